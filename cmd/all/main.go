@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Command all runs the API server and a worker in one process on a shared
+// connection pool, for development and small installs. It takes the worker's
+// flags; on SIGINT or SIGTERM, or when either half fails, both stop and the
+// pool closes after they have drained.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/adiadia/agent-runtime/internal/app"
+	"github.com/adiadia/agent-runtime/internal/config"
+	"github.com/adiadia/agent-runtime/internal/logging"
+)
+
+var (
+	Version   = "dev"
+	Commit    = "none"
+	BuildDate = "unknown"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := logging.NewLogger(cfg.Env)
+
+	// Flags override the loaded worker settings.
+	cfg.Worker.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if err := cfg.Worker.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(
+		context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
+	defer stop()
+
+	pool, err := app.OpenPool(ctx, cfg, logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	build := app.BuildInfo{Version: Version, Commit: Commit, BuildDate: BuildDate}
+	runCtx, cancel := context.WithCancel(ctx)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	run := func(name string, fn func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Whichever half returns first takes the other down with it.
+			defer cancel()
+			if err := fn(runCtx); err != nil {
+				logger.Error(name+" stopped", "error", err)
+				failed.Store(true)
+			}
+		}()
+	}
+
+	run("api", func(ctx context.Context) error {
+		return app.RunAPI(ctx, cfg, pool, logger, build)
+	})
+	// A fresh install has no API key to run a worker for yet: serve the API
+	// so one can be created, then restart with --api-key-id or --shared.
+	if strings.TrimSpace(cfg.Worker.APIKeyID) == "" && !cfg.Worker.Shared {
+		logger.Warn("no --api-key-id or --shared set: running the API without a worker")
+	} else {
+		run("worker", func(ctx context.Context) error {
+			return app.RunWorker(ctx, cfg, pool, logger, build)
+		})
+	}
+
+	wg.Wait()
+	pool.Close()
+	if failed.Load() {
+		os.Exit(1)
+	}
+}
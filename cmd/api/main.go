@@ -5,17 +5,13 @@ package main
 import (
 	"context"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
+	"github.com/adiadia/agent-runtime/internal/app"
 	"github.com/adiadia/agent-runtime/internal/config"
 	"github.com/adiadia/agent-runtime/internal/logging"
-	"github.com/adiadia/agent-runtime/internal/persistence/postgres"
-	"github.com/adiadia/agent-runtime/internal/repository"
-	httptransport "github.com/adiadia/agent-runtime/internal/transport/http"
 )
 
 var (
@@ -25,7 +21,10 @@ var (
 )
 
 func main() {
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	ctx, stop := signal.NotifyContext(
 		context.Background(),
@@ -36,70 +35,15 @@ func main() {
 
 	logger := logging.NewLogger(cfg.Env)
 
-	pool, err := postgres.NewPool(ctx, cfg.DatabaseURL)
+	pool, err := app.OpenPool(ctx, cfg, logger)
 	if err != nil {
-		log.Fatalf("db connect failed: %v", err)
+		log.Fatal(err)
 	}
 	defer pool.Close()
 
-	if cfg.AutoMigrate {
-		if err := postgres.EnsureSchema(ctx, pool, logger); err != nil {
-			log.Fatalf("schema bootstrap failed: %v", err)
-		}
-	} else {
-		logger.Info("auto schema bootstrap disabled", "env_var", "AUTO_MIGRATE")
-	}
-
-	runRepo := repository.NewRunRepository(pool, logger)
-	stepRepo := repository.NewStepRepository(pool, logger)
-	eventRepo := repository.NewEventRepository(pool, logger)
-	apiKeyRepo := repository.NewAPIKeyRepository(pool, logger)
-
-	handler := httptransport.NewRouter(httptransport.Deps{
-		RunRepo:        runRepo,
-		StepRepo:       stepRepo,
-		EventRepo:      eventRepo,
-		APIKeyAdmin:    apiKeyRepo,
-		Logger:         logger,
-		HealthChecker:  postgres.NewSchemaHealthChecker(pool),
-		APIKeyResolver: apiKeyRepo,
-		AdminToken:     cfg.AdminToken,
-		Version:        Version,
-		Commit:         Commit,
-		BuildDate:      BuildDate,
-	})
-
-	srv := &http.Server{
-		Addr:              cfg.HTTPAddr,
-		Handler:           handler,
-		ReadHeaderTimeout: 5 * time.Second,
-	}
-
-	go func() {
-		logger.Info("api listening",
-			"addr", cfg.HTTPAddr,
-			"version", Version,
-			"commit", Commit,
-			"build_date", BuildDate,
-		)
-
-		if err := srv.ListenAndServe(); err != nil &&
-			err != http.ErrServerClosed {
-			logger.Error("server failed", "error", err)
-			os.Exit(1)
-		}
-	}()
-
-	<-ctx.Done()
-	logger.Info("shutting down server")
-
-	shutdownCtx, cancel := context.WithTimeout(
-		context.Background(),
-		5*time.Second,
-	)
-	defer cancel()
-
-	if err := srv.Shutdown(shutdownCtx); err != nil {
-		logger.Error("server shutdown error", "error", err)
+	build := app.BuildInfo{Version: Version, Commit: Commit, BuildDate: BuildDate}
+	if err := app.RunAPI(ctx, cfg, pool, logger, build); err != nil {
+		logger.Error("api stopped", "error", err)
+		os.Exit(1)
 	}
 }
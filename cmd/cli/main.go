@@ -33,6 +33,26 @@ func main() {
 			os.Exit(1)
 		}
 		logger.Info("validation passed")
+	case "migrate":
+		if err := runMigrate(ctx, logger, os.Args[2:]); err != nil {
+			logger.Error("migrate failed", "error", err)
+			os.Exit(1)
+		}
+	case "replay":
+		if err := runReplay(ctx, logger, os.Args[2:]); err != nil {
+			logger.Error("replay failed", "error", err)
+			os.Exit(1)
+		}
+	case "rekey":
+		if err := runRekey(ctx, logger, os.Args[2:]); err != nil {
+			logger.Error("rekey failed", "error", err)
+			os.Exit(1)
+		}
+	case "rehash-api-keys":
+		if err := runRehashAPIKeys(ctx, logger, os.Args[2:]); err != nil {
+			logger.Error("rehash-api-keys failed", "error", err)
+			os.Exit(1)
+		}
 	default:
 		printUsage(os.Stderr)
 		os.Exit(2)
@@ -190,4 +210,8 @@ func parseLevel(raw string) slog.Level {
 
 func printUsage(w *os.File) {
 	_, _ = fmt.Fprintln(w, "usage: go run ./cmd/cli validate")
+	_, _ = fmt.Fprintln(w, "       "+migrateUsage[len("usage: "):])
+	_, _ = fmt.Fprintln(w, "       "+replayUsage[len("usage: "):])
+	_, _ = fmt.Fprintln(w, "       "+rekeyUsage[len("usage: "):])
+	_, _ = fmt.Fprintln(w, "       "+rehashAPIKeysUsage[len("usage: "):])
 }
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/config"
+	"github.com/adiadia/agent-runtime/internal/persistence/postgres"
+)
+
+const migrateUsage = "usage: go run ./cmd/cli migrate up | down [n] | status | to <version> | force <version>"
+
+// runMigrate applies, rolls back, or reports the embedded migrations against
+// the DATABASE_URL of the loaded config.
+func runMigrate(ctx context.Context, logger *slog.Logger, args []string) error {
+	cmd, n, err := parseMigrateArgs(args)
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, migrateUsage)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	pool, err := postgres.NewPool(ctx, cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("db connect failed: %w", err)
+	}
+	defer pool.Close()
+
+	switch cmd {
+	case "up":
+		return postgres.PrepareSchema(ctx, pool, logger, postgres.SchemaOptions{
+			Mode:        postgres.SchemaModeMigrate,
+			LockTimeout: cfg.MigrationLockTimeout,
+		})
+	case "down":
+		return postgres.MigrateDown(ctx, pool, logger, n)
+	case "to":
+		return postgres.MigrateTo(ctx, pool, logger, n)
+	case "force":
+		resolved, err := postgres.ForceMigration(ctx, pool, logger, n)
+		if err != nil {
+			return err
+		}
+		logger.Info("dirty migrations resolved", "count", resolved, "version", n)
+		return nil
+	default:
+		states, err := postgres.MigrationStates(ctx, pool)
+		if err != nil {
+			return err
+		}
+		return printMigrationStates(os.Stdout, states)
+	}
+}
+
+// parseMigrateArgs returns the migrate command and its number: the step
+// count for down (default 1), the version for to and force.
+func parseMigrateArgs(args []string) (string, int, error) {
+	if len(args) == 0 {
+		return "", 0, errors.New("missing migrate command")
+	}
+	cmd, rest := args[0], args[1:]
+	switch {
+	case (cmd == "up" || cmd == "status") && len(rest) == 0:
+		return cmd, 0, nil
+	case cmd == "down" && len(rest) == 0:
+		return cmd, 1, nil
+	case cmd == "down" && len(rest) == 1:
+		steps, err := strconv.Atoi(rest[0])
+		if err != nil || steps <= 0 {
+			return "", 0, fmt.Errorf("invalid step count %q", rest[0])
+		}
+		return cmd, steps, nil
+	case (cmd == "to" || cmd == "force") && len(rest) == 1:
+		version, err := strconv.Atoi(rest[0])
+		if err != nil || version < 0 {
+			return "", 0, fmt.Errorf("invalid version %q", rest[0])
+		}
+		return cmd, version, nil
+	default:
+		return "", 0, fmt.Errorf("unknown migrate command %q", strings.Join(args, " "))
+	}
+}
+
+func printMigrationStates(w io.Writer, states []postgres.MigrationState) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, s := range states {
+		appliedAt := "-"
+		if s.AppliedAt != nil {
+			appliedAt = s.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.Version, s.Name, s.Status, appliedAt)
+	}
+	return tw.Flush()
+}
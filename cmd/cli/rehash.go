@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/adiadia/agent-runtime/internal/config"
+	"github.com/adiadia/agent-runtime/internal/persistence/postgres"
+	"github.com/adiadia/agent-runtime/internal/repository"
+)
+
+const rehashAPIKeysUsage = "usage: go run ./cmd/cli rehash-api-keys [-batch-size N]"
+
+// runRehashAPIKeys keys the remaining plain SHA-256 token hashes of the
+// DATABASE_URL of the loaded config with API_KEY_PEPPER. Run it once every API
+// process has the pepper; the tokens themselves keep working unchanged.
+func runRehashAPIKeys(ctx context.Context, logger *slog.Logger, args []string) error {
+	fs := flag.NewFlagSet("rehash-api-keys", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	batchSize := fs.Int("batch-size", 500, "")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w\n%s", err, rehashAPIKeysUsage)
+	}
+	if fs.NArg() != 0 || *batchSize <= 0 {
+		return errors.New(rehashAPIKeysUsage)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if cfg.APIKeyPepper == "" {
+		return errors.New("API_KEY_PEPPER is not set")
+	}
+
+	pool, err := postgres.NewPool(ctx, cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("db connect failed: %w", err)
+	}
+	defer pool.Close()
+
+	n, err := repository.NewAPIKeyRepository(pool, logger).WithTokenPepper(cfg.APIKeyPepper).RehashLegacyTokens(ctx, *batchSize)
+	if err != nil {
+		return err
+	}
+	logger.Info("rehash complete", "rehashed", n)
+	return nil
+}
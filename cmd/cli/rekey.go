@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/adiadia/agent-runtime/internal/config"
+	"github.com/adiadia/agent-runtime/internal/persistence/postgres"
+	"github.com/adiadia/agent-runtime/internal/repository"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+)
+
+const rekeyUsage = "usage: go run ./cmd/cli rekey [-batch-size N]"
+
+// runRekey re-seals the encrypted columns of the DATABASE_URL of the loaded
+// config under the first key of ENCRYPTION_KEYS. Run it after putting a new
+// key first; once it succeeds the older keys can be dropped.
+func runRekey(ctx context.Context, logger *slog.Logger, args []string) error {
+	fs := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	batchSize := fs.Int("batch-size", 500, "")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w\n%s", err, rekeyUsage)
+	}
+	if fs.NArg() != 0 || *batchSize <= 0 {
+		return errors.New(rekeyUsage)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if cfg.EncryptionKeys == "" {
+		return errors.New("ENCRYPTION_KEYS is not set")
+	}
+	keyring, err := secrets.ParseKeyring(cfg.EncryptionKeys)
+	if err != nil {
+		return fmt.Errorf("invalid ENCRYPTION_KEYS: %w", err)
+	}
+
+	pool, err := postgres.NewPool(ctx, cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("db connect failed: %w", err)
+	}
+	defer pool.Close()
+
+	n, err := repository.NewEncryptionRepository(pool, logger).WithKeyring(keyring).Rekey(ctx, *batchSize)
+	if err != nil {
+		return err
+	}
+	logger.Info("rekey complete", "rekeyed", n, "key_id", keyring.ActiveKeyID())
+	return nil
+}
@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const replayUsage = "usage: go run ./cmd/cli replay [-api URL] [-input POSITION=JSON]... <run-id | export.json>"
+
+const defaultAPIURL = "http://localhost:8080"
+
+// stepInputFlags collects repeated -input POSITION=JSON flags.
+type stepInputFlags map[int]json.RawMessage
+
+func (f stepInputFlags) String() string {
+	return fmt.Sprint(map[int]json.RawMessage(f))
+}
+
+func (f stepInputFlags) Set(raw string) error {
+	pos, input, ok := strings.Cut(raw, "=")
+	if !ok {
+		return fmt.Errorf("want POSITION=JSON, got %q", raw)
+	}
+	position, err := strconv.Atoi(strings.TrimSpace(pos))
+	if err != nil || position < 0 {
+		return fmt.Errorf("invalid step position %q", pos)
+	}
+	if !json.Valid([]byte(input)) {
+		return fmt.Errorf("step %d input is not valid JSON", position)
+	}
+	f[position] = json.RawMessage(input)
+	return nil
+}
+
+// runReplay replays runs through POST /runs/{id}/replay of the API at -api
+// (default API_URL, then http://localhost:8080) with the API_TOKEN key. The
+// argument is a run ID or a file written by GET /runs/{id}/export or an
+// export job in JSON, whose runs are replayed in order.
+func runReplay(ctx context.Context, logger *slog.Logger, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	apiURL := fs.String("api", envOr("API_URL", defaultAPIURL), "")
+	inputs := stepInputFlags{}
+	fs.Var(inputs, "input", "")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w\n%s", err, replayUsage)
+	}
+	if fs.NArg() != 1 {
+		return errors.New(replayUsage)
+	}
+
+	token := strings.TrimSpace(os.Getenv("API_TOKEN"))
+	if token == "" {
+		return errors.New("API_TOKEN is not set")
+	}
+
+	runIDs, err := replaySources(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	for _, runID := range runIDs {
+		replayID, err := replayRun(ctx, client, strings.TrimRight(*apiURL, "/"), token, runID, inputs)
+		if err != nil {
+			return fmt.Errorf("replay run %s: %w", runID, err)
+		}
+		logger.Info("run replayed", "run_id", replayID, "replayed_from_run_id", runID)
+		_, _ = fmt.Fprintf(os.Stdout, "%s\t%s\n", runID, replayID)
+	}
+	return nil
+}
+
+// replaySources returns the run IDs to replay: arg itself when it is a run
+// ID, else the runs of the export file it names.
+func replaySources(arg string) ([]uuid.UUID, error) {
+	if id, err := uuid.Parse(arg); err == nil {
+		return []uuid.UUID{id}, nil
+	}
+
+	data, err := os.ReadFile(arg)
+	if err != nil {
+		return nil, err
+	}
+	type exportedRun struct {
+		Run struct {
+			ID uuid.UUID `json:"id"`
+		} `json:"run"`
+	}
+	var export struct {
+		exportedRun
+		Runs []exportedRun `json:"runs"`
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("read export %s: %w", arg, err)
+	}
+
+	var ids []uuid.UUID
+	if export.Run.ID != uuid.Nil {
+		ids = append(ids, export.Run.ID)
+	}
+	for _, run := range export.Runs {
+		ids = append(ids, run.Run.ID)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("export %s contains no runs", arg)
+	}
+	return ids, nil
+}
+
+func replayRun(ctx context.Context, client *http.Client, apiURL, token string, runID uuid.UUID, inputs stepInputFlags) (string, error) {
+	body, err := json.Marshal(map[string]any{"step_inputs": map[int]json.RawMessage(inputs)})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"/runs/"+runID.String()+"/replay", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var created struct {
+		RunID string `json:"run_id"`
+	}
+	if err := json.Unmarshal(data, &created); err != nil {
+		return "", err
+	}
+	return created.RunID, nil
+}
+
+func envOr(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
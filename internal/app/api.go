@@ -0,0 +1,405 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/backlog"
+	"github.com/adiadia/agent-runtime/internal/config"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/egress"
+	"github.com/adiadia/agent-runtime/internal/escalation"
+	"github.com/adiadia/agent-runtime/internal/export"
+	"github.com/adiadia/agent-runtime/internal/ingest"
+	"github.com/adiadia/agent-runtime/internal/janitor"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/adiadia/agent-runtime/internal/notify"
+	"github.com/adiadia/agent-runtime/internal/persistence/postgres"
+	"github.com/adiadia/agent-runtime/internal/reconcile"
+	"github.com/adiadia/agent-runtime/internal/relay"
+	"github.com/adiadia/agent-runtime/internal/repository"
+	httptransport "github.com/adiadia/agent-runtime/internal/transport/http"
+	"github.com/adiadia/agent-runtime/internal/transport/middleware"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RunAPI serves the HTTP API on cfg.HTTPAddr and runs the API's background
+// loops until ctx is done, then shuts the server down within
+// cfg.ShutdownTimeout. It returns early only if the server fails.
+func RunAPI(ctx context.Context, cfg config.Config, pool *pgxpool.Pool, logger *slog.Logger, build BuildInfo) error {
+	trustedProxies, err := middleware.ParseCIDRList(cfg.TrustedProxyCIDRs)
+	if err != nil {
+		return fmt.Errorf("invalid TRUSTED_PROXY_CIDRS: %w", err)
+	}
+
+	// Webhook URLs are vetted when runs and API key defaults are stored.
+	// Link-local and metadata addresses are refused even when private
+	// targets are allowed.
+	webhookTargets := egress.Policy{}
+	if !cfg.WebhookAllowPrivateTargets {
+		webhookTargets.Deny = egress.PrivateRanges
+	}
+
+	escalationThresholds, err := domain.ParseApprovalEscalationThresholds(cfg.ApprovalEscalationThresholds)
+	if err != nil {
+		return fmt.Errorf("invalid APPROVAL_ESCALATION_THRESHOLDS: %w", err)
+	}
+
+	runRetentionMode, err := domain.ParseRunRetentionMode(cfg.RunRetentionMode)
+	if err != nil {
+		return fmt.Errorf("invalid RUN_RETENTION_MODE: %w", err)
+	}
+
+	notifySenders, err := notificationSenders(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid notification config: %w", err)
+	}
+
+	publisher, err := outboxPublisher(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid outbox config: %w", err)
+	}
+
+	ingestSource, err := runIngestSource(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid ingest config: %w", err)
+	}
+
+	cipher, err := secretsCipher(cfg)
+	if err != nil {
+		return err
+	}
+	keyring, err := encryptionKeyring(cfg)
+	if err != nil {
+		return err
+	}
+	redactions, err := redactor(cfg)
+	if err != nil {
+		return err
+	}
+
+	var cors *httptransport.CORSConfig
+	if origins := splitList(cfg.CORSAllowedOrigins); len(origins) > 0 {
+		cors = &httptransport.CORSConfig{
+			AllowedOrigins: origins,
+			AllowedMethods: splitList(cfg.CORSAllowedMethods),
+			AllowedHeaders: splitList(cfg.CORSAllowedHeaders),
+			MaxAge:         cfg.CORSMaxAge,
+		}
+	}
+
+	replica, err := openReadReplica(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	if replica != nil {
+		defer replica.Close()
+	}
+	reads := repository.NewReadReplica(replica, logger)
+
+	// Postgres backs the run, step, event, and API key stores; everything
+	// below sees only their interfaces.
+	var (
+		runRepo    repository.RunStore    = repository.NewRunRepository(pool, logger).WithIdempotencyKeyTTL(cfg.IdempotencyKeyTTL).WithReadReplica(reads).WithKeyring(keyring).WithRedactor(redactions)
+		stepRepo   repository.StepStore   = repository.NewStepRepository(pool, logger).WithReadReplica(reads)
+		eventRepo  repository.EventStore  = repository.NewEventRepository(pool, logger).WithReadReplica(reads)
+		apiKeyRepo repository.APIKeyStore = repository.NewAPIKeyRepository(pool, logger).WithKeyring(keyring).WithTokenPepper(cfg.APIKeyPepper).WithUsageTracking(cfg.APIKeyUsageInterval)
+	)
+	runStatsRepo := repository.NewRunStatsRepository(pool, logger).WithReadReplica(reads)
+	usageRepo := repository.NewUsageRepository(pool, logger).WithReadReplica(reads)
+	tenantRepo := repository.NewTenantRepository(pool, logger)
+	auditRepo := repository.NewAuditRepository(pool, logger)
+	webhookRepo := repository.NewWebhookRepository(pool, logger)
+	workerRepo := repository.NewWorkerRepository(pool, logger)
+	outboxRepo := repository.NewOutboxRepository(pool, logger)
+	exportRepo := repository.NewExportRepository(pool, logger).WithKeyring(keyring)
+	templateRepo := repository.NewTemplateRepository(pool, logger)
+	secretRepo := repository.NewSecretRepository(pool, logger).WithCipher(cipher)
+
+	go janitor.New(janitor.Deps{
+		Events:             eventRepo,
+		Partitions:         eventRepo,
+		RunRequests:        runRepo,
+		Runs:               runRepo,
+		Outbox:             outboxRepo,
+		Logger:             logger,
+		Interval:           cfg.JanitorInterval,
+		EventRetentionDays: cfg.EventRetentionDays,
+		IdempotencyKeyTTL:  cfg.IdempotencyKeyTTL,
+		RunRetention: domain.RunRetentionPolicy{
+			DefaultDays: cfg.RunRetentionDays,
+			Mode:        runRetentionMode,
+			DryRun:      cfg.RunRetentionDryRun,
+		},
+		OutboxRetention: cfg.OutboxRetention,
+	}).Run(ctx)
+
+	go escalation.New(escalation.Deps{
+		Approvals:     runRepo,
+		Timeouts:      runRepo,
+		Logger:        logger,
+		Interval:      cfg.ApprovalEscalationInterval,
+		Thresholds:    escalationThresholds,
+		PriorityBoost: cfg.ApprovalEscalationPriorityBoost,
+	}).Run(ctx)
+
+	reconcileStaleAfter := cfg.RunReconcileStaleAfter
+	if !cfg.RunReconcileEnabled {
+		reconcileStaleAfter = 0
+	}
+	go reconcile.New(reconcile.Deps{
+		Runs:       runRepo,
+		Logger:     logger,
+		Interval:   cfg.RunReconcileInterval,
+		StaleAfter: reconcileStaleAfter,
+	}).Run(ctx)
+
+	go notify.New(notify.Deps{
+		Store:        repository.NewNotificationRepository(pool, logger),
+		Senders:      notifySenders,
+		Logger:       logger,
+		Interval:     cfg.NotifyInterval,
+		MaxAge:       cfg.NotifyMaxAge,
+		ApprovalLink: cfg.NotifyApprovalLink,
+	}).Run(ctx)
+
+	go relay.New(relay.Deps{
+		Store:     outboxRepo,
+		Publisher: publisher,
+		Logger:    logger,
+		Interval:  cfg.OutboxPollInterval,
+		BatchSize: cfg.OutboxBatchSize,
+	}).Run(ctx)
+
+	go ingest.New(ingest.Deps{
+		Runs:           runRepo,
+		Keys:           apiKeyRepo,
+		Source:         ingestSource,
+		Logger:         logger,
+		Interval:       cfg.IngestPollInterval,
+		WebhookTargets: &webhookTargets,
+	}).Run(ctx)
+
+	go export.New(export.Deps{
+		Store:    exportRepo,
+		Logger:   logger,
+		Interval: cfg.ExportInterval,
+	}).Run(ctx)
+
+	metrics.SetTenantLabels(cfg.MetricsTenantLabels)
+	go backlog.New(backlog.Deps{
+		Backlog:   runRepo,
+		Workers:   workerRepo,
+		Logger:    logger,
+		Interval:  cfg.MetricsCollectInterval,
+		PerTenant: cfg.MetricsTenantLabels,
+	}).Run(ctx)
+
+	streams := httptransport.NewStreams(cfg.SSEReconnectAfter)
+
+	handler := httptransport.NewRouter(httptransport.Deps{
+		RunRepo:             runRepo,
+		StepRepo:            stepRepo,
+		StepLogs:            stepRepo,
+		Timeline:            stepRepo,
+		RunVersions:         runRepo,
+		EventRepo:           eventRepo,
+		WebhookRepo:         webhookRepo,
+		APIKeyAdmin:         apiKeyRepo,
+		RunStats:            runStatsRepo,
+		TokenUsage:          usageRepo,
+		Workers:             workerRepo,
+		TenantPurger:        tenantRepo,
+		AuditLog:            auditRepo,
+		Exports:             exportRepo,
+		Templates:           templateRepo,
+		Secrets:             secretRepo,
+		Logger:              logger,
+		HealthChecker:       postgres.NewSchemaHealthChecker(pool),
+		APIKeyResolver:      apiKeyRepo,
+		AdminToken:          cfg.AdminToken,
+		EventRetentionDays:  cfg.EventRetentionDays,
+		RunRetentionDays:    cfg.RunRetentionDays,
+		APIKeyExpiryWarning: time.Duration(cfg.APIKeyExpiryWarningDays) * 24 * time.Hour,
+		TrustedProxies:      trustedProxies,
+		WebhookTargets:      &webhookTargets,
+		PurgeSigningKey:     cfg.PurgeSigningKey,
+		Streams:             streams,
+		SSEPollInterval:     cfg.SSEPollInterval,
+		MaxRequestBodyBytes: int64(cfg.MaxRequestBodyBytes),
+		HandlerTimeout:      cfg.HTTPHandlerTimeout,
+		Compression:         cfg.HTTPCompressionEnabled,
+		CORS:                cors,
+		AdminUI:             cfg.AdminUIEnabled && cfg.AdminToken != "",
+		Version:             build.Version,
+		Commit:              build.Commit,
+		BuildDate:           build.BuildDate,
+	})
+
+	// Event streams lift the read and write deadlines for themselves; every
+	// other response must finish within them.
+	srv := &http.Server{
+		Addr:              cfg.HTTPAddr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.HTTPReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTPReadTimeout,
+		WriteTimeout:      cfg.HTTPWriteTimeout,
+		IdleTimeout:       cfg.HTTPIdleTimeout,
+		MaxHeaderBytes:    cfg.HTTPMaxHeaderBytes,
+	}
+	// Shutdown only waits for idle connections; end open event streams with
+	// a reconnect hint so they drain instead of being cut at the timeout.
+	srv.RegisterOnShutdown(streams.Shutdown)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("api listening",
+			"addr", cfg.HTTPAddr,
+			"version", build.Version,
+			"commit", build.Commit,
+			"build_date", build.BuildDate,
+		)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+	}
+	logger.Info("shutting down server",
+		"timeout", cfg.ShutdownTimeout,
+		"active_streams", streams.Active(),
+	)
+
+	shutdownCtx, cancel := context.WithTimeout(
+		context.Background(),
+		cfg.ShutdownTimeout,
+	)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("server shutdown error", "error", err, "active_streams", streams.Active())
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server failed: %w", err)
+	}
+	return nil
+}
+
+// openReadReplica opens the READ_DATABASE_URL pool, or returns nil when none
+// is configured. An unreachable replica is logged, not fatal: reads use the
+// primary until it answers.
+func openReadReplica(ctx context.Context, cfg config.Config, logger *slog.Logger) (*pgxpool.Pool, error) {
+	if cfg.ReadDatabaseURL == "" {
+		return nil, nil
+	}
+	replica, err := postgres.NewReadPool(ctx, cfg.ReadDatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid READ_DATABASE_URL: %w", err)
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if err := replica.Ping(pingCtx); err != nil {
+		logger.Warn("read replica unreachable at startup; reads use the primary until it answers", "error", err)
+	} else {
+		logger.Info("read replica connected")
+	}
+	return replica, nil
+}
+
+// notificationSenders builds the Slack and email senders configured by the
+// NOTIFY_* variables; none means notifications are off.
+func notificationSenders(cfg config.Config) ([]notify.Sender, error) {
+	var senders []notify.Sender
+	if url := strings.TrimSpace(cfg.NotifySlackWebhookURL); url != "" {
+		senders = append(senders, notify.NewSlack(url, nil))
+	}
+
+	if addr := strings.TrimSpace(cfg.NotifySMTPAddr); addr != "" {
+		to := splitList(cfg.NotifySMTPTo)
+		if strings.TrimSpace(cfg.NotifySMTPFrom) == "" || len(to) == 0 {
+			return nil, errors.New("NOTIFY_SMTP_ADDR needs NOTIFY_SMTP_FROM and NOTIFY_SMTP_TO")
+		}
+		senders = append(senders, notify.NewSMTP(notify.SMTPConfig{
+			Addr:     addr,
+			Username: cfg.NotifySMTPUsername,
+			Password: cfg.NotifySMTPPassword,
+			From:     strings.TrimSpace(cfg.NotifySMTPFrom),
+			To:       to,
+		}))
+	}
+	return senders, nil
+}
+
+// outboxPublisher builds the broker publisher named by OUTBOX_PUBLISHER; none
+// means outbox messages are not relayed and only expire.
+func outboxPublisher(cfg config.Config) (relay.Publisher, error) {
+	switch cfg.OutboxPublisher {
+	case "":
+		return nil, nil
+	case relay.PublisherNATS:
+		return relay.NewNATS(relay.NATSConfig{
+			URL:           cfg.OutboxNATSURL,
+			SubjectPrefix: cfg.OutboxNATSSubjectPrefix,
+		})
+	case relay.PublisherKafka:
+		return relay.NewKafka(relay.KafkaConfig{
+			RESTURL: cfg.OutboxKafkaRESTURL,
+			Topic:   cfg.OutboxKafkaTopic,
+		}, nil)
+	case relay.PublisherSNS:
+		return relay.NewSNS(relay.SNSConfig{
+			TopicARN:        cfg.OutboxSNSTopicARN,
+			Endpoint:        cfg.OutboxSNSEndpoint,
+			AccessKeyID:     cfg.AWSAccessKeyID,
+			SecretAccessKey: cfg.AWSSecretAccessKey,
+			SessionToken:    cfg.AWSSessionToken,
+		}, nil)
+	default:
+		return nil, fmt.Errorf("unknown OUTBOX_PUBLISHER %q", cfg.OutboxPublisher)
+	}
+}
+
+// runIngestSource builds the queue named by INGEST_SOURCE that run-creation
+// commands are consumed from; none means runs are created over HTTP only.
+func runIngestSource(cfg config.Config) (ingest.Source, error) {
+	switch cfg.IngestSource {
+	case "":
+		return nil, nil
+	case ingest.SourceNATS:
+		return ingest.NewNATS(ingest.NATSConfig{
+			URL:     cfg.IngestNATSURL,
+			Subject: cfg.IngestNATSSubject,
+			Queue:   cfg.IngestNATSQueue,
+		})
+	case ingest.SourceKafka:
+		return ingest.NewKafka(ingest.KafkaConfig{
+			RESTURL:      cfg.IngestKafkaRESTURL,
+			Topic:        cfg.IngestKafkaTopic,
+			Group:        cfg.IngestKafkaGroup,
+			ReplyTopic:   cfg.IngestKafkaReplyTopic,
+			PollInterval: cfg.IngestPollInterval,
+		}, nil)
+	default:
+		return nil, fmt.Errorf("unknown INGEST_SOURCE %q", cfg.IngestSource)
+	}
+}
+
+// splitList splits a comma-separated setting, dropping blank entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package app runs the API server and the worker loop from a loaded config.
+// cmd/api and cmd/worker each run one of them; cmd/all runs both in one
+// process on a shared connection pool.
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/adiadia/agent-runtime/internal/config"
+	"github.com/adiadia/agent-runtime/internal/ids"
+	"github.com/adiadia/agent-runtime/internal/persistence/postgres"
+	"github.com/adiadia/agent-runtime/internal/redact"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// BuildInfo identifies the running binary, as set by -ldflags.
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	BuildDate string
+}
+
+// OpenPool applies UUID_VERSION, connects to DATABASE_URL, and prepares the
+// schema when AUTO_MIGRATE is on. The caller closes the pool.
+func OpenPool(ctx context.Context, cfg config.Config, logger *slog.Logger) (*pgxpool.Pool, error) {
+	uuidVersion, err := ids.ParseVersion(cfg.UUIDVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UUID_VERSION: %w", err)
+	}
+	if err := ids.SetVersion(uuidVersion); err != nil {
+		return nil, fmt.Errorf("invalid UUID_VERSION: %w", err)
+	}
+
+	pool, err := postgres.NewPool(ctx, cfg.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("db connect failed: %w", err)
+	}
+
+	if !cfg.AutoMigrate {
+		logger.Info("auto schema bootstrap disabled", "env_var", "AUTO_MIGRATE")
+		return pool, nil
+	}
+	schemaMode, err := postgres.ParseSchemaMode(cfg.MigrationMode)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("invalid MIGRATION_MODE: %w", err)
+	}
+	if err := postgres.PrepareSchema(ctx, pool, logger, postgres.SchemaOptions{
+		Mode:        schemaMode,
+		LockTimeout: cfg.MigrationLockTimeout,
+	}); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("schema bootstrap failed: %w", err)
+	}
+	return pool, nil
+}
+
+// secretsCipher returns the cipher for SECRETS_KEY, or nil when it is unset
+// and secrets are unavailable.
+func secretsCipher(cfg config.Config) (*secrets.Cipher, error) {
+	if cfg.SecretsKey == "" {
+		return nil, nil
+	}
+	key, err := secrets.ParseKey(cfg.SecretsKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SECRETS_KEY: %w", err)
+	}
+	return secrets.NewCipher(key)
+}
+
+// encryptionKeyring returns the keyring for ENCRYPTION_KEYS, or nil when it is
+// unset and sensitive columns are stored in plaintext.
+func encryptionKeyring(cfg config.Config) (*secrets.Keyring, error) {
+	if cfg.EncryptionKeys == "" {
+		return nil, nil
+	}
+	keyring, err := secrets.ParseKeyring(cfg.EncryptionKeys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ENCRYPTION_KEYS: %w", err)
+	}
+	return keyring, nil
+}
+
+// redactor returns the redactor for REDACTION_RULES, or nil when no rules are
+// set and payloads are stored as written.
+func redactor(cfg config.Config) (*redact.Redactor, error) {
+	r, err := redact.ParseRules(cfg.RedactionRules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDACTION_RULES: %w", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/config"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/egress"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/adiadia/agent-runtime/internal/persistence/postgres"
+	"github.com/adiadia/agent-runtime/internal/repository"
+	"github.com/adiadia/agent-runtime/internal/worker"
+	"github.com/adiadia/agent-runtime/internal/worker/executors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RunWorker registers a dedicated worker for cfg.Worker.APIKeyID, or with
+// cfg.Worker.Shared one worker per active API key, and polls for steps until
+// ctx is done. A step in flight at that point gets cfg.ShutdownTimeout to
+// finish before its context is canceled.
+func RunWorker(ctx context.Context, cfg config.Config, pool *pgxpool.Pool, logger *slog.Logger, build BuildInfo) error {
+	wc := cfg.Worker
+	var apiKeyID uuid.UUID
+	if !wc.Shared {
+		apiKeyRef := strings.TrimSpace(wc.APIKeyID)
+		if apiKeyRef == "" {
+			return errors.New("worker requires --api-key-id for dedicated mode, or --shared")
+		}
+		var err error
+		apiKeyID, err = uuid.Parse(apiKeyRef)
+		if err != nil {
+			if domain.ValidateAPIKeySlug(apiKeyRef) != nil {
+				return fmt.Errorf("invalid --api-key-id: %w", err)
+			}
+			apiKeyID, err = repository.NewAPIKeyRepository(pool, logger).GetAPIKeyIDBySlug(ctx, apiKeyRef)
+			if err != nil {
+				return fmt.Errorf("resolve --api-key-id slug %q: %w", apiKeyRef, err)
+			}
+			logger = logger.With("tenant", apiKeyRef)
+		}
+	}
+
+	var breaker *worker.BreakerConfig
+	if wc.BreakerFailureRate > 0 {
+		breaker = &worker.BreakerConfig{
+			FailureRate: wc.BreakerFailureRate,
+			MinRequests: wc.BreakerMinRequests,
+			Window:      wc.BreakerWindow,
+			Cooldown:    wc.BreakerCooldown,
+		}
+	}
+
+	var sandbox *executors.SandboxConfig
+	if binaries := splitList(wc.SandboxAllowedBinaries); len(binaries) > 0 {
+		sandbox = &executors.SandboxConfig{
+			AllowedBinaries: binaries,
+			CPUTime:         wc.SandboxCPUTime,
+			MemoryBytes:     int64(wc.SandboxMemoryMB) << 20,
+			MaxOutputBytes:  wc.SandboxMaxOutputBytes,
+		}
+	}
+
+	globalStepLimits, err := domain.ParseStepLimits(wc.GlobalStepLimits)
+	if err != nil {
+		return fmt.Errorf("invalid --global-step-limits: %w", err)
+	}
+
+	egressPolicy, err := egress.ParsePolicy(wc.EgressProxyURL, wc.EgressAllowCIDRs, wc.EgressDenyCIDRs)
+	if err != nil {
+		return fmt.Errorf("invalid egress settings: %w", err)
+	}
+
+	cipher, err := secretsCipher(cfg)
+	if err != nil {
+		return err
+	}
+	keyring, err := encryptionKeyring(cfg)
+	if err != nil {
+		return err
+	}
+	redactions, err := redactor(cfg)
+	if err != nil {
+		return err
+	}
+
+	var mock *worker.MockConfig
+	if cfg.MockProviders {
+		mock = &worker.MockConfig{
+			Latency:     cfg.MockProviderLatency,
+			FailureRate: cfg.MockProviderFailureRate,
+			Seed:        int64(cfg.MockProviderSeed),
+		}
+		logger.Warn("mock providers enabled: step executors and webhooks make no external calls",
+			"latency", mock.Latency,
+			"failure_rate", mock.FailureRate,
+			"seed", mock.Seed,
+		)
+	}
+
+	minSchemaVersion, err := postgres.EmbeddedSchemaVersion()
+	if err != nil {
+		return fmt.Errorf("read embedded schema version: %w", err)
+	}
+	if err := postgres.CheckSchemaVersion(ctx, pool, minSchemaVersion); err != nil {
+		return fmt.Errorf("schema check failed: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	registry := repository.NewWorkerRepository(pool, logger)
+	metrics.SetTenantLabels(cfg.MetricsTenantLabels)
+
+	deps := worker.Deps{
+		Pool:                  pool,
+		Logger:                logger,
+		ReclaimAfter:          wc.ReclaimAfter,
+		MaxAttempts:           wc.MaxAttempts,
+		RetryBaseDelay:        wc.RetryBaseDelay,
+		RetryPriority:         wc.RetryPriority,
+		DefaultStepTimeout:    wc.DefaultStepTimeout,
+		CancelCheckInterval:   wc.CancelCheckInterval,
+		WebhookMaxAttempts:    wc.WebhookMaxAttempts,
+		WebhookRetryBaseDelay: wc.WebhookRetryBaseDelay,
+		ApprovalLink:          cfg.NotifyApprovalLink,
+		Mock:                  mock,
+		Breaker:               breaker,
+		Sandbox:               sandbox,
+		MaxStepOutputBytes:    wc.MaxStepOutputBytes,
+		ClaimBatchSize:        wc.ClaimBatchSize,
+		GlobalStepLimits:      globalStepLimits,
+		Secrets:               cipher,
+		Keyring:               keyring,
+		Redactor:              redactions,
+		Egress:                &egressPolicy,
+		HTTPStepAllowPrivate:  wc.HTTPStepAllowPrivateTargets,
+	}
+	// newWorker registers a worker row for apiKeyID and builds its worker.
+	newWorker := func(ctx context.Context, apiKeyID uuid.UUID) (*worker.Worker, domain.WorkerRecord, error) {
+		d := deps
+		d.APIKeyID = apiKeyID
+		d.WorkerID = uuid.New()
+		w := worker.New(d)
+
+		registration := domain.WorkerRecord{
+			ID:               d.WorkerID,
+			APIKeyID:         apiKeyID,
+			Hostname:         hostname,
+			Version:          build.Version,
+			MinSchemaVersion: minSchemaVersion,
+			Features:         worker.Features,
+			Pricing:          w.Pricing(),
+		}
+		if err := registry.RegisterWorker(ctx, registration); err != nil {
+			return nil, registration, fmt.Errorf("register worker failed: %w", err)
+		}
+		return w, registration, nil
+	}
+
+	var (
+		processOnce func(context.Context) error
+		beats       func() []domain.WorkerRecord
+		workerID    string
+	)
+	if wc.Shared {
+		var (
+			mu            sync.Mutex
+			registrations = make(map[uuid.UUID]domain.WorkerRecord)
+		)
+		shared := worker.NewShared(worker.SharedDeps{
+			Pool:   pool,
+			Logger: logger,
+			NewWorker: func(ctx context.Context, apiKeyID uuid.UUID) (*worker.Worker, error) {
+				w, registration, err := newWorker(ctx, apiKeyID)
+				if err != nil {
+					return nil, err
+				}
+				mu.Lock()
+				registrations[apiKeyID] = registration
+				mu.Unlock()
+				return w, nil
+			},
+		})
+		processOnce = shared.ProcessOnce
+		beats = func() []domain.WorkerRecord {
+			var out []domain.WorkerRecord
+			shared.Each(func(apiKeyID uuid.UUID, w *worker.Worker) {
+				mu.Lock()
+				beat := registrations[apiKeyID]
+				mu.Unlock()
+				beat.InFlightSteps = w.InFlightSteps()
+				out = append(out, beat)
+			})
+			return out
+		}
+		workerID = "shared"
+		go shared.RunWebhookDispatcher(ctx, wc.WebhookPollInterval)
+	} else {
+		w, registration, err := newWorker(ctx, apiKeyID)
+		if err != nil {
+			return err
+		}
+		processOnce = w.ProcessOnce
+		beats = func() []domain.WorkerRecord {
+			beat := registration
+			beat.InFlightSteps = w.InFlightSteps()
+			return []domain.WorkerRecord{beat}
+		}
+		workerID = registration.ID.String()
+		go w.RunWebhookDispatcher(ctx, wc.WebhookPollInterval)
+	}
+	go heartbeat(ctx, registry, beats, wc.PollInterval, logger)
+
+	logger.Info("worker started",
+		"version", build.Version,
+		"commit", build.Commit,
+		"build_date", build.BuildDate,
+		"shared", wc.Shared,
+		"api_key_id", apiKeyID,
+		"worker_id", workerID,
+		"min_schema_version", minSchemaVersion,
+		"poll_interval", wc.PollInterval,
+		"max_attempts", wc.MaxAttempts,
+		"reclaim_after", wc.ReclaimAfter,
+		"retry_base_delay", wc.RetryBaseDelay,
+		"retry_priority", wc.RetryPriority,
+		"default_step_timeout", wc.DefaultStepTimeout,
+		"cancel_check_interval", wc.CancelCheckInterval,
+		"webhook_poll_interval", wc.WebhookPollInterval,
+		"webhook_max_attempts", wc.WebhookMaxAttempts,
+		"webhook_retry_base_delay", wc.WebhookRetryBaseDelay,
+		"breaker_failure_rate", wc.BreakerFailureRate,
+		"sandbox_allowed_binaries", wc.SandboxAllowedBinaries,
+		"claim_batch_size", wc.ClaimBatchSize,
+		"global_step_limits", wc.GlobalStepLimits,
+		"egress_proxy", egressPolicy.Proxy != nil,
+		"egress_allow_cidrs", wc.EgressAllowCIDRs,
+		"egress_deny_cidrs", wc.EgressDenyCIDRs,
+		"http_step_allow_private_targets", wc.HTTPStepAllowPrivateTargets,
+		"mock_providers", mock != nil,
+	)
+
+	// Steps run on a context that outlives ctx by the shutdown timeout, so a
+	// step in flight can record its result instead of waiting to be
+	// reclaimed.
+	stepCtx, cancelSteps := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelSteps()
+	stopDrain := context.AfterFunc(ctx, func() {
+		time.AfterFunc(cfg.ShutdownTimeout, cancelSteps)
+	})
+	defer stopDrain()
+
+	ticker := time.NewTicker(wc.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("worker stopped", "worker_id", workerID)
+			return nil
+		case <-ticker.C:
+		}
+		if err := processOnce(stepCtx); err != nil {
+			logger.Error("worker process failed", "error", err)
+		}
+	}
+}
+
+// heartbeat refreshes the registry rows beats returns, with their in-flight
+// steps, on every poll so the API counts the workers as live. It runs apart
+// from the poll loop so a long step does not make a worker look dead.
+func heartbeat(ctx context.Context, registry *repository.WorkerRepository, beats func() []domain.WorkerRecord, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, beat := range beats() {
+			if err := registry.Heartbeat(ctx, beat); err != nil {
+				logger.Warn("worker heartbeat failed", "worker_id", beat.ID, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package audit writes audit_log entries inside the caller's transaction, so
+// an entry exists exactly when the change it describes commits. The actor is
+// read from the context, where the HTTP layer stores it per request.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type actorContextKey struct{}
+
+var ctxActorKey actorContextKey
+
+// WithActor stores the actor of the current request on ctx.
+func WithActor(ctx context.Context, actor domain.AuditActor) context.Context {
+	return context.WithValue(ctx, ctxActorKey, actor)
+}
+
+// ActorFromContext returns the actor stored by WithActor.
+func ActorFromContext(ctx context.Context) (domain.AuditActor, bool) {
+	actor, ok := ctx.Value(ctxActorKey).(domain.AuditActor)
+	return actor, ok
+}
+
+// Change is one mutation to record. APIKeyID is the tenant it concerns, or
+// uuid.Nil. Before and After are stored as JSON; nil values are omitted.
+type Change struct {
+	Action     string
+	APIKeyID   uuid.UUID
+	TargetType string
+	TargetID   string
+	Before     any
+	After      any
+}
+
+// Record inserts change into audit_log at now, attributed to the actor on
+// ctx, or to the system when there is none.
+func Record(ctx context.Context, tx pgx.Tx, now time.Time, change Change) error {
+	actor, ok := ActorFromContext(ctx)
+	if !ok || actor.Type == "" {
+		actor = domain.AuditActor{Type: domain.AuditActorSystem}
+	}
+
+	before, err := marshalState(change.Before)
+	if err != nil {
+		return err
+	}
+	after, err := marshalState(change.After)
+	if err != nil {
+		return err
+	}
+
+	var apiKeyID *uuid.UUID
+	if change.APIKeyID != uuid.Nil {
+		apiKeyID = &change.APIKeyID
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO audit_log (id, created_at, actor_type, actor_id, api_key_id, action, target_type, target_id, ip, request_id, before, after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11::jsonb, $12::jsonb)
+	`,
+		uuid.New(),
+		now,
+		actor.Type,
+		actor.ID,
+		apiKeyID,
+		change.Action,
+		change.TargetType,
+		change.TargetID,
+		nullString(actor.IP),
+		nullString(actor.RequestID),
+		before,
+		after,
+	)
+	return err
+}
+
+func marshalState(v any) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
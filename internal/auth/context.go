@@ -4,6 +4,9 @@ package auth
 
 import (
 	"context"
+	"net/netip"
+	"slices"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -18,8 +21,32 @@ var ctxIdempotencyKey idempotencyKeyContextKey
 
 type APIKey struct {
 	ID                uuid.UUID
+	Slug              string
 	MaxConcurrentRuns int
 	MaxRequestsPerMin int
+	Scopes            []string
+	ExpiresAt         *time.Time
+	AllowedCIDRs      []netip.Prefix
+}
+
+// HasScope reports whether the key was granted scope.
+func (k APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// AllowsAddr reports whether a client at addr may use the key. Keys without
+// an allow-list accept any address.
+func (k APIKey) AllowsAddr(addr netip.Addr) bool {
+	if len(k.AllowedCIDRs) == 0 {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, prefix := range k.AllowedCIDRs {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
 }
 
 // WithAPIKeyID stores the authenticated tenant id on the request context.
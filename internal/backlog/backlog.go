@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package backlog periodically exports how much work is queued, runtime-wide
+// and optionally per tenant, and whether workers are alive to take it, for
+// autoscaling and backlog alerts.
+package backlog
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+)
+
+// Reader reads queued work.
+type Reader interface {
+	GetQueueStats(ctx context.Context) (domain.QueueStats, error)
+	ListTenantBacklog(ctx context.Context) ([]domain.TenantBacklog, error)
+}
+
+// LivenessReader reads worker heartbeats.
+type LivenessReader interface {
+	GetWorkerLiveness(ctx context.Context) (domain.WorkerLiveness, error)
+}
+
+type Deps struct {
+	Backlog  Reader
+	Workers  LivenessReader
+	Logger   *slog.Logger
+	Interval time.Duration
+	// PerTenant also refreshes the per-tenant gauges, which only make
+	// sense with tenant metric labels enabled.
+	PerTenant bool
+}
+
+// Collector refreshes the backlog gauges.
+type Collector struct {
+	backlog    Reader
+	workers    LivenessReader
+	logger     *slog.Logger
+	interval   time.Duration
+	perTenant  bool
+	setQueue   func(domain.QueueStats)
+	setTenants func([]domain.TenantBacklog)
+	setWorkers func(domain.WorkerLiveness)
+}
+
+func New(deps Deps) *Collector {
+	l := deps.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+
+	interval := deps.Interval
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+
+	return &Collector{
+		backlog:    deps.Backlog,
+		workers:    deps.Workers,
+		logger:     l,
+		interval:   interval,
+		perTenant:  deps.PerTenant,
+		setQueue:   metrics.SetQueueStats,
+		setTenants: metrics.SetTenantBacklog,
+		setWorkers: metrics.SetWorkerLiveness,
+	}
+}
+
+// Run executes RunOnce immediately and then on every interval until ctx is
+// done.
+func (c *Collector) Run(ctx context.Context) {
+	c.logger.Info("backlog metrics started", "interval", c.interval, "per_tenant", c.perTenant)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			c.logger.Error("backlog collection failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			c.logger.Info("backlog metrics stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce reads the backlog and updates the gauges. Gauges whose read fails
+// keep their previous values.
+func (c *Collector) RunOnce(ctx context.Context) error {
+	if c.backlog == nil {
+		return nil
+	}
+
+	stats, err := c.backlog.GetQueueStats(ctx)
+	if err != nil {
+		return err
+	}
+	c.setQueue(stats)
+
+	if c.workers != nil {
+		liveness, err := c.workers.GetWorkerLiveness(ctx)
+		if err != nil {
+			return err
+		}
+		c.setWorkers(liveness)
+	}
+
+	if !c.perTenant {
+		return nil
+	}
+	tenants, err := c.backlog.ListTenantBacklog(ctx)
+	if err != nil {
+		return err
+	}
+	c.setTenants(tenants)
+	return nil
+}
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package backlog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+)
+
+type fakeReader struct {
+	stats       domain.QueueStats
+	backlog     []domain.TenantBacklog
+	statsErr    error
+	backlogErr  error
+	backlogRead bool
+}
+
+func (f *fakeReader) GetQueueStats(ctx context.Context) (domain.QueueStats, error) {
+	return f.stats, f.statsErr
+}
+
+func (f *fakeReader) ListTenantBacklog(ctx context.Context) ([]domain.TenantBacklog, error) {
+	f.backlogRead = true
+	return f.backlog, f.backlogErr
+}
+
+func newTestCollector(reader *fakeReader, perTenant bool) (*Collector, *domain.QueueStats, *[]domain.TenantBacklog) {
+	c := New(Deps{
+		Backlog:   reader,
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		PerTenant: perTenant,
+	})
+	stats, tenants := new(domain.QueueStats), new([]domain.TenantBacklog)
+	c.setQueue = func(s domain.QueueStats) { *stats = s }
+	c.setTenants = func(b []domain.TenantBacklog) { *tenants = b }
+	return c, stats, tenants
+}
+
+func TestNewDefaults(t *testing.T) {
+	c := New(Deps{})
+
+	if c.logger == nil {
+		t.Fatal("expected default logger to be set")
+	}
+	if c.interval != 15*time.Second {
+		t.Fatalf("expected default interval=15s, got %s", c.interval)
+	}
+}
+
+func TestRunOncePublishesQueueStats(t *testing.T) {
+	want := domain.QueueStats{PendingSteps: 5, RunnableSteps: 2, OldestPendingAge: time.Minute, WaitingApprovalRuns: 1}
+	reader := &fakeReader{stats: want}
+	c, stats, _ := newTestCollector(reader, false)
+
+	if err := c.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *stats != want {
+		t.Fatalf("expected %+v got %+v", want, *stats)
+	}
+	if reader.backlogRead {
+		t.Fatal("expected per-tenant backlog to be skipped")
+	}
+}
+
+func TestRunOncePublishesTenantBacklog(t *testing.T) {
+	want := []domain.TenantBacklog{{APIKeyID: uuid.New(), Claimable: 3, Starvation: time.Minute}}
+	c, _, tenants := newTestCollector(&fakeReader{backlog: want}, true)
+
+	if err := c.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*tenants) != 1 || (*tenants)[0] != want[0] {
+		t.Fatalf("expected %v got %v", want, *tenants)
+	}
+}
+
+func TestRunOnceKeepsGaugesOnError(t *testing.T) {
+	wantErr := errors.New("db down")
+	reader := &fakeReader{statsErr: wantErr}
+	c, stats, _ := newTestCollector(reader, true)
+	*stats = domain.QueueStats{PendingSteps: 7}
+
+	if err := c.RunOnce(context.Background()); !errors.Is(err, wantErr) {
+		t.Fatalf("expected %v got %v", wantErr, err)
+	}
+	if stats.PendingSteps != 7 {
+		t.Fatal("expected gauges to be left alone on error")
+	}
+}
+
+type fakeLiveness struct {
+	liveness domain.WorkerLiveness
+}
+
+func (f *fakeLiveness) GetWorkerLiveness(ctx context.Context) (domain.WorkerLiveness, error) {
+	return f.liveness, nil
+}
+
+func TestRunOncePublishesWorkerLiveness(t *testing.T) {
+	want := domain.WorkerLiveness{StaleWorkers: 2, TenantsWithoutLiveWorker: []uuid.UUID{uuid.New()}}
+	c, _, _ := newTestCollector(&fakeReader{}, false)
+	c.workers = &fakeLiveness{liveness: want}
+	var got domain.WorkerLiveness
+	c.setWorkers = func(l domain.WorkerLiveness) { got = l }
+
+	if err := c.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.StaleWorkers != 2 || len(got.TenantsWithoutLiveWorker) != 1 {
+		t.Fatalf("expected %+v got %+v", want, got)
+	}
+}
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package budget reads a tenant's monthly spend cap and its month-to-date
+// spend. It runs on the caller's transaction so the API and worker check the
+// cap against the same snapshot they act on.
+package budget
+
+import (
+	"context"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Querier is the subset of pgx.Tx and pgxpool.Pool that Load needs.
+type Querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Load returns the tenant's monthly budget. Month-to-date spend is the total
+// cost of the tenant's runs created since the start of now's month (UTC), so
+// a run that started last month keeps counting towards last month. Spend is
+// only summed when the tenant has a cap.
+func Load(ctx context.Context, q Querier, apiKeyID uuid.UUID, now time.Time) (domain.MonthlyBudget, error) {
+	b := domain.MonthlyBudget{APIKeyID: apiKeyID}
+	if err := q.QueryRow(ctx,
+		`SELECT monthly_budget_usd::double precision FROM api_keys WHERE id=$1`,
+		apiKeyID,
+	).Scan(&b.LimitUSD); err != nil {
+		return domain.MonthlyBudget{}, err
+	}
+	if b.LimitUSD == nil {
+		return b, nil
+	}
+
+	if err := q.QueryRow(ctx, `
+		SELECT COALESCE(SUM(total_cost_usd), 0)::double precision
+		FROM runs
+		WHERE api_key_id=$1 AND created_at >= $2
+	`, apiKeyID, domain.MonthStart(now)).Scan(&b.SpentUSD); err != nil {
+		return domain.MonthlyBudget{}, err
+	}
+	return b, nil
+}
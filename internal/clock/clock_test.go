@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOrRealDefaultsToWallClock(t *testing.T) {
+	if _, ok := OrReal(nil).(Real); !ok {
+		t.Fatal("expected nil clock to fall back to Real")
+	}
+
+	fake := NewFake(time.Unix(0, 0))
+	if OrReal(fake) != Clock(fake) {
+		t.Fatal("expected non-nil clock to be returned unchanged")
+	}
+}
+
+func TestFakeSetAndAdvance(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+
+	if got := c.Now(); !got.Equal(start) {
+		t.Fatalf("expected %s got %s", start, got)
+	}
+
+	c.Advance(90 * time.Second)
+	if got := c.Now(); !got.Equal(start.Add(90 * time.Second)) {
+		t.Fatalf("expected advance to %s got %s", start.Add(90*time.Second), got)
+	}
+
+	later := start.Add(24 * time.Hour)
+	c.Set(later)
+	if got := c.Now(); !got.Equal(later) {
+		t.Fatalf("expected set to %s got %s", later, got)
+	}
+}
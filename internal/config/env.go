@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envLoader overrides config values with environment variables and collects
+// a problem for each variable that does not parse. Empty variables are
+// treated as unset.
+type envLoader struct {
+	problems []string
+}
+
+func (l *envLoader) invalid(key, kind, v string) {
+	l.problems = append(l.problems, fmt.Sprintf("%s: invalid %s %q", key, kind, v))
+}
+
+func (l *envLoader) str(key string, dst *string) {
+	if v := os.Getenv(key); v != "" {
+		*dst = v
+	}
+}
+
+func (l *envLoader) bool(key string, dst *bool) {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv(key)))
+	switch v {
+	case "":
+	case "1", "true", "yes", "on":
+		*dst = true
+	case "0", "false", "no", "off":
+		*dst = false
+	default:
+		l.invalid(key, "boolean", v)
+	}
+}
+
+func (l *envLoader) int(key string, dst *int) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		l.invalid(key, "integer", v)
+		return
+	}
+	*dst = n
+}
+
+func (l *envLoader) float(key string, dst *float64) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		l.invalid(key, "number", v)
+		return
+	}
+	*dst = f
+}
+
+func (l *envLoader) duration(key string, dst *time.Duration) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		l.invalid(key, "duration", v)
+		return
+	}
+	*dst = d
+}
@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import "flag"
+
+// RegisterFlags binds the worker command-line flags to w, so flags override
+// the loaded values when fs is parsed.
+func (w *WorkerConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&w.APIKeyID, "api-key-id", w.APIKeyID, "API key UUID or slug for dedicated worker (required unless --shared)")
+	fs.BoolVar(&w.Shared, "shared", w.Shared, "serve every active API key, sharing claims by scheduling weight")
+	fs.DurationVar(&w.PollInterval, "poll-interval", w.PollInterval, "worker poll interval")
+	fs.IntVar(&w.MaxAttempts, "max-attempts", w.MaxAttempts, "max execution attempts per step")
+	fs.DurationVar(&w.ReclaimAfter, "reclaim-after", w.ReclaimAfter, "step lease length; running steps whose lease was not renewed for this long are reclaimed")
+	fs.DurationVar(&w.RetryBaseDelay, "retry-base-delay", w.RetryBaseDelay, "base delay for exponential retry backoff")
+	fs.IntVar(&w.RetryPriority, "retry-priority", w.RetryPriority, "priority added to a step each time it is retried (-100..100); negative values let new work go first")
+	fs.DurationVar(&w.DefaultStepTimeout, "default-step-timeout", w.DefaultStepTimeout, "default timeout for steps with NULL timeout_seconds")
+	fs.DurationVar(&w.CancelCheckInterval, "cancel-check-interval", w.CancelCheckInterval, "how often an executing step checks whether its run was canceled")
+	fs.DurationVar(&w.WebhookPollInterval, "webhook-poll-interval", w.WebhookPollInterval, "webhook outbox poll interval")
+	fs.IntVar(&w.WebhookMaxAttempts, "webhook-max-attempts", w.WebhookMaxAttempts, "max delivery attempts per webhook")
+	fs.DurationVar(&w.WebhookRetryBaseDelay, "webhook-retry-base-delay", w.WebhookRetryBaseDelay, "base delay for exponential webhook retry backoff")
+	fs.Float64Var(&w.BreakerFailureRate, "breaker-failure-rate", w.BreakerFailureRate, "share of failed executions per step type that opens its circuit breaker; 0 disables breakers")
+	fs.IntVar(&w.BreakerMinRequests, "breaker-min-requests", w.BreakerMinRequests, "executions per step type within --breaker-window before its breaker can open")
+	fs.DurationVar(&w.BreakerWindow, "breaker-window", w.BreakerWindow, "window over which executor failures are counted")
+	fs.DurationVar(&w.BreakerCooldown, "breaker-cooldown", w.BreakerCooldown, "how long an open breaker stops claims before probing with one step")
+	fs.StringVar(&w.SandboxAllowedBinaries, "sandbox-allowed-binaries", w.SandboxAllowedBinaries, "comma-separated binaries TOOL step commands may run; empty disables command steps")
+	fs.DurationVar(&w.SandboxCPUTime, "sandbox-cpu-time", w.SandboxCPUTime, "CPU time limit for sandboxed commands")
+	fs.IntVar(&w.SandboxMemoryMB, "sandbox-memory-mb", w.SandboxMemoryMB, "address space limit for sandboxed commands in MiB")
+	fs.IntVar(&w.SandboxMaxOutputBytes, "sandbox-max-output-bytes", w.SandboxMaxOutputBytes, "bytes of stdout and of stderr kept from sandboxed commands")
+	fs.IntVar(&w.MaxStepOutputBytes, "max-step-output-bytes", w.MaxStepOutputBytes, "largest step output stored; larger output is replaced by a truncated preview")
+	fs.IntVar(&w.ClaimBatchSize, "claim-batch-size", w.ClaimBatchSize, "steps claimed per transaction and executed concurrently")
+	fs.StringVar(&w.EgressProxyURL, "egress-proxy-url", w.EgressProxyURL, "http(s) proxy for webhook deliveries and executor HTTP calls; empty uses HTTP_PROXY/HTTPS_PROXY")
+	fs.StringVar(&w.EgressAllowCIDRs, "egress-allow-cidrs", w.EgressAllowCIDRs, "comma-separated CIDRs outbound HTTP may connect to; empty allows any address not denied")
+	fs.StringVar(&w.EgressDenyCIDRs, "egress-deny-cidrs", w.EgressDenyCIDRs, "comma-separated CIDRs outbound HTTP may not connect to, on top of link-local and metadata addresses")
+	fs.BoolVar(&w.HTTPStepAllowPrivateTargets, "http-step-allow-private-targets", w.HTTPStepAllowPrivateTargets, "let HTTP steps connect to loopback and private addresses")
+	fs.StringVar(&w.GlobalStepLimits, "global-step-limits", w.GlobalStepLimits, "caps on RUNNING steps per step type across all tenants, e.g. LLM=20,TOOL=50; empty means none")
+}
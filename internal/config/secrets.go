@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// vaultScheme prefixes secret values that are references to a Vault KV v2
+// secret rather than the secret itself: vault://<mount>/<path>#<field>.
+const vaultScheme = "vault://"
+
+// secret reads a secret setting from key, or from the file named by
+// key_FILE, as mounted by Docker and Kubernetes secrets. A trailing newline in
+// the file is dropped. Setting both is a problem.
+func (l *envLoader) secret(key string, dst *string) {
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		l.str(key, dst)
+		return
+	}
+	if os.Getenv(key) != "" {
+		l.problems = append(l.problems, fmt.Sprintf("%s: set only one of %s and %s_FILE", key, key, key))
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		l.problems = append(l.problems, fmt.Sprintf("%s_FILE: %v", key, err))
+		return
+	}
+	*dst = strings.TrimRight(string(data), "\r\n")
+}
+
+// resolveSecrets replaces vault:// references among secrets with the values
+// they point to, read from the Vault at VAULT_ADDR with VAULT_TOKEN (or
+// VAULT_TOKEN_FILE).
+func (l *envLoader) resolveSecrets(secrets map[string]*string) {
+	var refs []string
+	for key, v := range secrets {
+		if strings.HasPrefix(*v, vaultScheme) {
+			refs = append(refs, key)
+		}
+	}
+	if len(refs) == 0 {
+		return
+	}
+	slices.Sort(refs)
+
+	vault := vaultClient{
+		addr:      strings.TrimRight(os.Getenv("VAULT_ADDR"), "/"),
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		http:      &http.Client{Timeout: 10 * time.Second},
+	}
+	l.secret("VAULT_TOKEN", &vault.token)
+	if vault.addr == "" || vault.token == "" {
+		l.problems = append(l.problems, fmt.Sprintf("%s: vault:// references need VAULT_ADDR and VAULT_TOKEN", strings.Join(refs, ", ")))
+		return
+	}
+
+	for _, key := range refs {
+		v, err := vault.read(context.Background(), *secrets[key])
+		if err != nil {
+			l.problems = append(l.problems, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		*secrets[key] = v
+	}
+}
+
+// vaultClient reads fields of Vault KV v2 secrets over the HTTP API.
+type vaultClient struct {
+	addr      string
+	token     string
+	namespace string
+	http      *http.Client
+}
+
+// read returns the field of the secret named by ref,
+// vault://<mount>/<path>#<field>.
+func (c vaultClient) read(ctx context.Context, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == "" || strings.Trim(u.Path, "/") == "" || u.Fragment == "" {
+		return "", fmt.Errorf("invalid vault reference %q (want vault://<mount>/<path>#<field>)", ref)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.addr+"/v1/"+u.Host+"/data/"+strings.Trim(u.Path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("read vault secret: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("read vault secret %s: status %d", u.Host+u.Path, resp.StatusCode)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decode vault secret: %w", err)
+	}
+	v, ok := body.Data.Data[u.Fragment].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string field %q", u.Host+u.Path, u.Fragment)
+	}
+	return v, nil
+}
@@ -0,0 +1,292 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/ids"
+	"github.com/adiadia/agent-runtime/internal/ingest"
+	"github.com/adiadia/agent-runtime/internal/natsclient"
+	"github.com/adiadia/agent-runtime/internal/persistence/postgres"
+	"github.com/adiadia/agent-runtime/internal/redact"
+	"github.com/adiadia/agent-runtime/internal/relay"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	"github.com/adiadia/agent-runtime/internal/transport/middleware"
+)
+
+// ValidationError lists every invalid setting, each naming its environment
+// variable.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate reports the worker settings that are out of range, e.g. after
+// command-line flags replaced the loaded values.
+func (w WorkerConfig) Validate() error {
+	if problems := w.problems(); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// checks collects validation problems.
+type checks []string
+
+func (c *checks) add(format string, args ...any) {
+	*c = append(*c, fmt.Sprintf(format, args...))
+}
+
+func (c *checks) err(key string, err error) {
+	if err != nil {
+		c.add("%s: %v", key, err)
+	}
+}
+
+func (c *checks) positive(key string, d time.Duration) {
+	if d <= 0 {
+		c.add("%s must be positive, got %s", key, d)
+	}
+}
+
+func (c *checks) positiveInt(key string, n int) {
+	if n <= 0 {
+		c.add("%s must be positive, got %d", key, n)
+	}
+}
+
+func (c *checks) nonNegative(key string, n int) {
+	if n < 0 {
+		c.add("%s must not be negative, got %d", key, n)
+	}
+}
+
+func (c *checks) rate(key string, f float64) {
+	if f < 0 || f > 1 {
+		c.add("%s must be between 0 and 1, got %g", key, f)
+	}
+}
+
+func (c *checks) hostPort(key, v string) {
+	_, port, err := net.SplitHostPort(v)
+	if err != nil {
+		c.add("%s: invalid address %q", key, v)
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		c.add("%s: invalid port %q", key, port)
+	}
+}
+
+func (c *checks) httpURL(key, v string) {
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.add("%s: invalid http(s) URL %q", key, v)
+	}
+}
+
+// postgresURL checks URL-style connection strings; key=value ones are left
+// to pgx.
+func (c *checks) postgresURL(key, v string) {
+	if !strings.Contains(v, "://") {
+		return
+	}
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		c.add("%s: invalid postgres URL", key)
+	}
+}
+
+func (c Config) problems() []string {
+	var p checks
+
+	p.hostPort("HTTP_ADDR", c.HTTPAddr)
+	if strings.TrimSpace(c.DatabaseURL) == "" {
+		p.add("DATABASE_URL is required")
+	}
+	p.postgresURL("DATABASE_URL", c.DatabaseURL)
+	p.postgresURL("READ_DATABASE_URL", c.ReadDatabaseURL)
+
+	_, err := postgres.ParseSchemaMode(c.MigrationMode)
+	p.err("MIGRATION_MODE", err)
+	if c.MigrationLockTimeout < 0 {
+		p.add("MIGRATION_LOCK_TIMEOUT must not be negative, got %s", c.MigrationLockTimeout)
+	}
+	p.nonNegative("EVENT_RETENTION_DAYS", c.EventRetentionDays)
+	p.nonNegative("RUN_RETENTION_DAYS", c.RunRetentionDays)
+	_, err = domain.ParseRunRetentionMode(c.RunRetentionMode)
+	p.err("RUN_RETENTION_MODE", err)
+	p.positive("JANITOR_INTERVAL", c.JanitorInterval)
+	p.positive("IDEMPOTENCY_KEY_TTL", c.IdempotencyKeyTTL)
+	_, err = ids.ParseVersion(c.UUIDVersion)
+	p.err("UUID_VERSION", err)
+	if c.SecretsKey != "" {
+		_, err = secrets.ParseKey(c.SecretsKey)
+		p.err("SECRETS_KEY", err)
+	}
+	if c.EncryptionKeys != "" {
+		_, err = secrets.ParseKeyring(c.EncryptionKeys)
+		p.err("ENCRYPTION_KEYS", err)
+	}
+	_, err = redact.ParseRules(c.RedactionRules)
+	p.err("REDACTION_RULES", err)
+	p.nonNegative("API_KEY_EXPIRY_WARNING_DAYS", c.APIKeyExpiryWarningDays)
+	p.positive("API_KEY_USAGE_INTERVAL", c.APIKeyUsageInterval)
+	if c.APIKeyPepper != "" && len(c.APIKeyPepper) < 32 {
+		p.add("API_KEY_PEPPER must be at least 32 bytes, got %d", len(c.APIKeyPepper))
+	}
+	_, err = middleware.ParseCIDRList(c.TrustedProxyCIDRs)
+	p.err("TRUSTED_PROXY_CIDRS", err)
+	_, err = domain.ParseApprovalEscalationThresholds(c.ApprovalEscalationThresholds)
+	p.err("APPROVAL_ESCALATION_THRESHOLDS", err)
+	p.positive("APPROVAL_ESCALATION_INTERVAL", c.ApprovalEscalationInterval)
+	p.nonNegative("APPROVAL_ESCALATION_PRIORITY_BOOST", c.ApprovalEscalationPriorityBoost)
+	p.positive("RUN_RECONCILE_INTERVAL", c.RunReconcileInterval)
+	p.positive("RUN_RECONCILE_STALE_AFTER", c.RunReconcileStaleAfter)
+
+	p.positive("SSE_POLL_INTERVAL", c.SSEPollInterval)
+	p.positive("SSE_RECONNECT_AFTER", c.SSEReconnectAfter)
+	if c.ShutdownTimeout <= c.SSEPollInterval {
+		p.add("SHUTDOWN_TIMEOUT (%s) must be longer than SSE_POLL_INTERVAL (%s)", c.ShutdownTimeout, c.SSEPollInterval)
+	}
+	p.positiveInt("MAX_REQUEST_BODY_BYTES", c.MaxRequestBodyBytes)
+	p.positive("HTTP_READ_HEADER_TIMEOUT", c.HTTPReadHeaderTimeout)
+	p.positive("HTTP_READ_TIMEOUT", c.HTTPReadTimeout)
+	p.positive("HTTP_IDLE_TIMEOUT", c.HTTPIdleTimeout)
+	p.positiveInt("HTTP_MAX_HEADER_BYTES", c.HTTPMaxHeaderBytes)
+	p.positive("HTTP_HANDLER_TIMEOUT", c.HTTPHandlerTimeout)
+	if c.HTTPWriteTimeout <= c.HTTPHandlerTimeout {
+		p.add("HTTP_WRITE_TIMEOUT (%s) must be longer than HTTP_HANDLER_TIMEOUT (%s)", c.HTTPWriteTimeout, c.HTTPHandlerTimeout)
+	}
+	for _, origin := range strings.Split(c.CORSAllowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" && origin != "*" {
+			p.httpURL("CORS_ALLOWED_ORIGINS", origin)
+		}
+	}
+	if c.CORSMaxAge < 0 {
+		p.add("CORS_MAX_AGE must not be negative, got %s", c.CORSMaxAge)
+	}
+
+	p.positive("METRICS_COLLECT_INTERVAL", c.MetricsCollectInterval)
+	if c.MockProviderLatency < 0 {
+		p.add("MOCK_PROVIDER_LATENCY must not be negative, got %s", c.MockProviderLatency)
+	}
+	p.rate("MOCK_PROVIDER_FAILURE_RATE", c.MockProviderFailureRate)
+
+	if c.NotifySlackWebhookURL != "" {
+		p.httpURL("NOTIFY_SLACK_WEBHOOK_URL", c.NotifySlackWebhookURL)
+	}
+	if c.NotifySMTPAddr != "" {
+		p.hostPort("NOTIFY_SMTP_ADDR", c.NotifySMTPAddr)
+	}
+	p.httpURL("NOTIFY_APPROVAL_LINK", c.NotifyApprovalLink)
+	p.positive("NOTIFY_INTERVAL", c.NotifyInterval)
+	p.positive("NOTIFY_MAX_AGE", c.NotifyMaxAge)
+
+	switch c.OutboxPublisher {
+	case "":
+	case relay.PublisherNATS:
+		if _, _, _, err := natsclient.Addr(c.OutboxNATSURL); err != nil {
+			p.add("OUTBOX_NATS_URL: invalid nats:// or tls:// URL")
+		}
+	case relay.PublisherKafka:
+		p.httpURL("OUTBOX_KAFKA_REST_URL", c.OutboxKafkaRESTURL)
+		if strings.TrimSpace(c.OutboxKafkaTopic) == "" {
+			p.add("OUTBOX_KAFKA_TOPIC is required when OUTBOX_PUBLISHER is kafka")
+		}
+	case relay.PublisherSNS:
+		_, err := relay.SNSRegion(c.OutboxSNSTopicARN)
+		p.err("OUTBOX_SNS_TOPIC_ARN", err)
+		if c.OutboxSNSEndpoint != "" {
+			p.httpURL("OUTBOX_SNS_ENDPOINT", c.OutboxSNSEndpoint)
+		}
+		if c.AWSAccessKeyID == "" || c.AWSSecretAccessKey == "" {
+			p.add("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required when OUTBOX_PUBLISHER is sns")
+		}
+	default:
+		p.add("OUTBOX_PUBLISHER must be one of nats, kafka, sns, or empty, got %q", c.OutboxPublisher)
+	}
+	p.positive("OUTBOX_POLL_INTERVAL", c.OutboxPollInterval)
+	p.positiveInt("OUTBOX_BATCH_SIZE", c.OutboxBatchSize)
+	if c.OutboxRetention < 0 {
+		p.add("OUTBOX_RETENTION must not be negative, got %s", c.OutboxRetention)
+	}
+
+	switch c.IngestSource {
+	case "":
+	case ingest.SourceNATS:
+		if _, _, _, err := natsclient.Addr(c.IngestNATSURL); err != nil {
+			p.add("INGEST_NATS_URL: invalid nats:// or tls:// URL")
+		}
+		if strings.TrimSpace(c.IngestNATSSubject) == "" {
+			p.add("INGEST_NATS_SUBJECT is required when INGEST_SOURCE is nats")
+		}
+	case ingest.SourceKafka:
+		p.httpURL("INGEST_KAFKA_REST_URL", c.IngestKafkaRESTURL)
+		if strings.TrimSpace(c.IngestKafkaTopic) == "" {
+			p.add("INGEST_KAFKA_TOPIC is required when INGEST_SOURCE is kafka")
+		}
+		if strings.TrimSpace(c.IngestKafkaGroup) == "" {
+			p.add("INGEST_KAFKA_GROUP is required when INGEST_SOURCE is kafka")
+		}
+	default:
+		p.add("INGEST_SOURCE must be one of nats, kafka, or empty, got %q", c.IngestSource)
+	}
+	p.positive("INGEST_POLL_INTERVAL", c.IngestPollInterval)
+	p.positive("EXPORT_INTERVAL", c.ExportInterval)
+
+	return append(p, c.Worker.problems()...)
+}
+
+func (w WorkerConfig) problems() []string {
+	var p checks
+
+	p.positive("WORKER_POLL_INTERVAL (--poll-interval)", w.PollInterval)
+	p.positiveInt("WORKER_MAX_ATTEMPTS (--max-attempts)", w.MaxAttempts)
+	p.positive("WORKER_RECLAIM_AFTER (--reclaim-after)", w.ReclaimAfter)
+	p.positive("WORKER_RETRY_BASE_DELAY (--retry-base-delay)", w.RetryBaseDelay)
+	if domain.ValidateRetryPriority(w.RetryPriority) != nil {
+		p.add("WORKER_RETRY_PRIORITY (--retry-priority) must be between -%d and %d, got %d",
+			domain.MaxRetryPriority, domain.MaxRetryPriority, w.RetryPriority)
+	}
+	p.positive("WORKER_DEFAULT_STEP_TIMEOUT (--default-step-timeout)", w.DefaultStepTimeout)
+	p.positive("WORKER_CANCEL_CHECK_INTERVAL (--cancel-check-interval)", w.CancelCheckInterval)
+	p.positive("WORKER_WEBHOOK_POLL_INTERVAL (--webhook-poll-interval)", w.WebhookPollInterval)
+	p.positiveInt("WORKER_WEBHOOK_MAX_ATTEMPTS (--webhook-max-attempts)", w.WebhookMaxAttempts)
+	p.positive("WORKER_WEBHOOK_RETRY_BASE_DELAY (--webhook-retry-base-delay)", w.WebhookRetryBaseDelay)
+	p.rate("WORKER_BREAKER_FAILURE_RATE (--breaker-failure-rate)", w.BreakerFailureRate)
+	p.positiveInt("WORKER_BREAKER_MIN_REQUESTS (--breaker-min-requests)", w.BreakerMinRequests)
+	p.positive("WORKER_BREAKER_WINDOW (--breaker-window)", w.BreakerWindow)
+	p.positive("WORKER_BREAKER_COOLDOWN (--breaker-cooldown)", w.BreakerCooldown)
+	p.positive("WORKER_SANDBOX_CPU_TIME (--sandbox-cpu-time)", w.SandboxCPUTime)
+	p.positiveInt("WORKER_SANDBOX_MEMORY_MB (--sandbox-memory-mb)", w.SandboxMemoryMB)
+	p.positiveInt("WORKER_SANDBOX_MAX_OUTPUT_BYTES (--sandbox-max-output-bytes)", w.SandboxMaxOutputBytes)
+	p.positiveInt("WORKER_MAX_STEP_OUTPUT_BYTES (--max-step-output-bytes)", w.MaxStepOutputBytes)
+	p.positiveInt("WORKER_CLAIM_BATCH_SIZE (--claim-batch-size)", w.ClaimBatchSize)
+	if _, err := domain.ParseStepLimits(w.GlobalStepLimits); err != nil {
+		p.add("WORKER_GLOBAL_STEP_LIMITS (--global-step-limits) must be comma-separated LLM=N or TOOL=N pairs with N >= 1, got %q", w.GlobalStepLimits)
+	}
+	// The proxy URL may carry credentials, so it is not echoed.
+	if u, err := url.Parse(w.EgressProxyURL); w.EgressProxyURL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		p.add("WORKER_EGRESS_PROXY_URL (--egress-proxy-url): invalid http(s) URL")
+	}
+	_, err := middleware.ParseCIDRList(w.EgressAllowCIDRs)
+	p.err("WORKER_EGRESS_ALLOW_CIDRS (--egress-allow-cidrs)", err)
+	_, err = middleware.ParseCIDRList(w.EgressDenyCIDRs)
+	p.err("WORKER_EGRESS_DENY_CIDRS (--egress-deny-cidrs)", err)
+	if w.Shared && strings.TrimSpace(w.APIKeyID) != "" {
+		p.add("WORKER_SHARED (--shared) and WORKER_API_KEY_ID (--api-key-id) are mutually exclusive")
+	}
+
+	return p
+}
@@ -3,6 +3,10 @@
 package domain
 
 import (
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,12 +15,37 @@ import (
 const (
 	DefaultMaxConcurrentRuns = 5
 	DefaultMaxRequestsPerMin = 60
+	MaxEventRetentionDays    = 3650
+	MaxRunRetentionDays      = 3650
+
+	DefaultAPIKeyPageSize = 100
+	MaxAPIKeyPageSize     = 500
 )
 
+// apiKeySlugPattern allows lowercase DNS-label style slugs such as "acme-prod".
+var apiKeySlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,61}[a-z0-9]$`)
+
+// API key scopes. A key may only call runtime endpoints covered by its scopes.
+const (
+	ScopeRunsRead       = "runs:read"
+	ScopeRunsWrite      = "runs:write"
+	ScopeApprovalsWrite = "approvals:write"
+)
+
+// AllScopes is granted to keys created without an explicit scope list.
+var AllScopes = []string{ScopeApprovalsWrite, ScopeRunsRead, ScopeRunsWrite}
+
 type CreateAPIKeyParams struct {
-	Name              string
-	MaxConcurrentRuns int
-	MaxRequestsPerMin int
+	Name               string
+	MaxConcurrentRuns  int
+	MaxRequestsPerMin  int
+	EventRetentionDays *int
+	RunRetentionDays   *int
+	MonthlyBudgetUSD   *float64
+	Scopes             []string
+	Slug               string
+	ExpiresAt          *time.Time
+	AllowedCIDRs       []string
 }
 
 type CreatedAPIKey struct {
@@ -25,9 +54,154 @@ type CreatedAPIKey struct {
 }
 
 type APIKeyRecord struct {
-	ID                uuid.UUID `json:"id"`
-	Name              string    `json:"name"`
-	MaxConcurrentRuns int       `json:"max_concurrent_runs"`
-	MaxRequestsPerMin int       `json:"max_requests_per_min"`
-	CreatedAt         time.Time `json:"created_at"`
+	ID                           uuid.UUID      `json:"id"`
+	Name                         string         `json:"name"`
+	Slug                         *string        `json:"slug"`
+	MaxConcurrentRuns            int            `json:"max_concurrent_runs"`
+	MaxRequestsPerMin            int            `json:"max_requests_per_min"`
+	EventRetentionDays           *int           `json:"event_retention_days"`
+	EffectiveEventRetentionDays  int            `json:"effective_event_retention_days"`
+	RunRetentionDays             *int           `json:"run_retention_days"`
+	EffectiveRunRetentionDays    int            `json:"effective_run_retention_days"`
+	MonthlyBudgetUSD             *float64       `json:"monthly_budget_usd"`
+	SchedulingWeight             int            `json:"scheduling_weight"`
+	MaxConcurrentRunsPerTemplate map[string]int `json:"max_concurrent_runs_per_template"`
+	DefaultWebhookURL            *string        `json:"default_webhook_url"`
+	HasDefaultWebhookSecret      bool           `json:"has_default_webhook_secret"`
+	Scopes                       []string       `json:"scopes"`
+	ExpiresAt                    *time.Time     `json:"expires_at"`
+	AllowedCIDRs                 []string       `json:"allowed_cidrs"`
+	EgressAllowHosts             []string       `json:"egress_allow_hosts"`
+	LastUsedAt                   *time.Time     `json:"last_used_at"`
+	RequestCount                 int64          `json:"request_count"`
+	CreatedAt                    time.Time      `json:"created_at"`
+	// RevokedAt is only set on revoked keys, which are listed on request.
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// APIKeyFilter narrows GET /api-keys, which lists keys newest first.
+// BeforeID pages past the key with that ID; revoked keys are only listed
+// with IncludeRevoked.
+type APIKeyFilter struct {
+	NamePrefix     string
+	IncludeRevoked bool
+	BeforeID       *uuid.UUID
+	Limit          int
+}
+
+// SetWebhookDefaultsParams replaces a key's default webhook settings. An empty
+// WebhookURL or WebhookSecret clears that setting; GenerateSecret asks the
+// runtime to create a new secret instead of supplying one.
+type SetWebhookDefaultsParams struct {
+	WebhookURL     string
+	WebhookSecret  string
+	GenerateSecret bool
+}
+
+// WebhookDefaults is a key's default webhook configuration. WebhookSecret is
+// only populated when the secret was just generated.
+type WebhookDefaults struct {
+	APIKeyID         uuid.UUID `json:"api_key_id"`
+	WebhookURL       *string   `json:"webhook_url"`
+	HasWebhookSecret bool      `json:"has_webhook_secret"`
+	WebhookSecret    string    `json:"webhook_secret,omitempty"`
+}
+
+// EffectiveEventRetentionDays resolves a tenant's event retention. A per-key
+// override wins over the deployment default; 0 means events are kept forever.
+func EffectiveEventRetentionDays(override *int, defaultDays int) int {
+	if override != nil && *override > 0 {
+		return *override
+	}
+	if defaultDays < 0 {
+		return 0
+	}
+	return defaultDays
+}
+
+// ValidateEventRetentionDays accepts nil (inherit the default) or 1..MaxEventRetentionDays.
+func ValidateEventRetentionDays(days *int) error {
+	if days == nil {
+		return nil
+	}
+	if *days <= 0 || *days > MaxEventRetentionDays {
+		return ErrInvalidEventRetention
+	}
+	return nil
+}
+
+// NormalizeScopes validates a requested scope list and returns it sorted and
+// de-duplicated. A nil list means "not specified" and grants AllScopes; an
+// empty or unknown scope is rejected with ErrInvalidScope.
+func NormalizeScopes(scopes []string) ([]string, error) {
+	if scopes == nil {
+		return slices.Clone(AllScopes), nil
+	}
+
+	out := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		s = strings.TrimSpace(s)
+		if !slices.Contains(AllScopes, s) {
+			return nil, ErrInvalidScope
+		}
+		out = append(out, s)
+	}
+	if len(out) == 0 {
+		return nil, ErrInvalidScope
+	}
+
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// ValidateAPIKeyExpiry accepts nil (never expires) or a time after now.
+func ValidateAPIKeyExpiry(expiresAt *time.Time, now time.Time) error {
+	if expiresAt == nil {
+		return nil
+	}
+	if !expiresAt.After(now) {
+		return ErrInvalidAPIKeyExpiry
+	}
+	return nil
+}
+
+// ValidateAPIKeySlug accepts "" (no slug) or a lowercase slug of letters,
+// digits, and inner hyphens, 2 to 63 characters long. Slugs that parse as a UUID
+// are rejected so an admin path segment is never ambiguous.
+func ValidateAPIKeySlug(slug string) error {
+	if slug == "" {
+		return nil
+	}
+	if !apiKeySlugPattern.MatchString(slug) {
+		return ErrInvalidAPIKeySlug
+	}
+	if _, err := uuid.Parse(slug); err == nil {
+		return ErrInvalidAPIKeySlug
+	}
+	return nil
+}
+
+// NormalizeAllowedCIDRs validates a client IP allow-list and returns it in
+// canonical prefix form, sorted and de-duplicated. A bare address is a
+// single-host prefix. An empty list returns nil, meaning any address.
+func NormalizeAllowedCIDRs(cidrs []string) ([]string, error) {
+	out := make([]string, 0, len(cidrs))
+	for _, raw := range cidrs {
+		raw = strings.TrimSpace(raw)
+		prefix, err := netip.ParsePrefix(raw)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(raw)
+			if addrErr != nil || addr.Zone() != "" {
+				return nil, ErrInvalidAllowedCIDR
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		out = append(out, prefix.Masked().String())
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+
+	slices.Sort(out)
+	return slices.Compact(out), nil
 }
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEffectiveEventRetentionDays(t *testing.T) {
+	override := 7
+	if got := EffectiveEventRetentionDays(&override, 90); got != 7 {
+		t.Fatalf("expected override to win, got %d", got)
+	}
+	if got := EffectiveEventRetentionDays(nil, 90); got != 90 {
+		t.Fatalf("expected default when no override, got %d", got)
+	}
+	if got := EffectiveEventRetentionDays(nil, 0); got != 0 {
+		t.Fatalf("expected 0 (keep forever) when neither is set, got %d", got)
+	}
+}
+
+func TestValidateEventRetentionDays(t *testing.T) {
+	if err := ValidateEventRetentionDays(nil); err != nil {
+		t.Fatalf("expected nil retention to be valid, got %v", err)
+	}
+	for _, days := range []int{0, -1, MaxEventRetentionDays + 1} {
+		d := days
+		if err := ValidateEventRetentionDays(&d); !errors.Is(err, ErrInvalidEventRetention) {
+			t.Fatalf("expected ErrInvalidEventRetention for %d, got %v", days, err)
+		}
+	}
+	valid := 365
+	if err := ValidateEventRetentionDays(&valid); err != nil {
+		t.Fatalf("expected 365 to be valid, got %v", err)
+	}
+}
+
+func TestNormalizeScopes(t *testing.T) {
+	all, err := NormalizeScopes(nil)
+	if err != nil || !slices.Equal(all, AllScopes) {
+		t.Fatalf("expected nil scopes to grant all, got %v (%v)", all, err)
+	}
+
+	got, err := NormalizeScopes([]string{" runs:read", "approvals:write", "runs:read"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"approvals:write", "runs:read"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+
+	for _, scopes := range [][]string{{}, {"runs:delete"}, {"runs:read", ""}} {
+		if _, err := NormalizeScopes(scopes); !errors.Is(err, ErrInvalidScope) {
+			t.Fatalf("expected ErrInvalidScope for %v, got %v", scopes, err)
+		}
+	}
+}
+
+func TestValidateAPIKeySlug(t *testing.T) {
+	for _, slug := range []string{"", "acme-prod", "a1", "0team"} {
+		if err := ValidateAPIKeySlug(slug); err != nil {
+			t.Fatalf("expected %q to be valid, got %v", slug, err)
+		}
+	}
+	invalid := []string{
+		"a",
+		"Acme",
+		"-acme",
+		"acme-",
+		"acme_prod",
+		"acme prod",
+		"0190a5b2-7c1e-7a3b-9f00-1234567890ab",
+		"0190a5b27c1e7a3b9f001234567890ab",
+		strings.Repeat("a", 64),
+	}
+	for _, slug := range invalid {
+		if err := ValidateAPIKeySlug(slug); !errors.Is(err, ErrInvalidAPIKeySlug) {
+			t.Fatalf("expected ErrInvalidAPIKeySlug for %q, got %v", slug, err)
+		}
+	}
+}
+
+func TestValidateAPIKeyExpiry(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := ValidateAPIKeyExpiry(nil, now); err != nil {
+		t.Fatalf("expected nil expiry to be valid, got %v", err)
+	}
+	future := now.Add(time.Hour)
+	if err := ValidateAPIKeyExpiry(&future, now); err != nil {
+		t.Fatalf("expected future expiry to be valid, got %v", err)
+	}
+	for _, at := range []time.Time{now, now.Add(-time.Minute)} {
+		at := at
+		if err := ValidateAPIKeyExpiry(&at, now); !errors.Is(err, ErrInvalidAPIKeyExpiry) {
+			t.Fatalf("expected ErrInvalidAPIKeyExpiry for %s, got %v", at, err)
+		}
+	}
+}
+
+func TestNormalizeAllowedCIDRs(t *testing.T) {
+	got, err := NormalizeAllowedCIDRs([]string{" 10.1.2.3/8 ", "192.0.2.7", "2001:db8::1/32", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	want := []string{"10.0.0.0/8", "192.0.2.7/32", "2001:db8::/32"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+
+	if got, err := NormalizeAllowedCIDRs([]string{}); err != nil || got != nil {
+		t.Fatalf("expected empty list to clear, got %v (err=%v)", got, err)
+	}
+	for _, cidr := range []string{"", "10.0.0.0/33", "example.com", "fe80::1%eth0"} {
+		if _, err := NormalizeAllowedCIDRs([]string{cidr}); !errors.Is(err, ErrInvalidAllowedCIDR) {
+			t.Fatalf("expected ErrInvalidAllowedCIDR for %q, got %v", cidr, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ParseApprovalEscalationThresholds parses a comma-separated list of Go
+// durations into ascending, de-duplicated thresholds; an APPROVAL step that
+// has waited past the i-th threshold is escalated to level i+1. "off" or "none"
+// disables escalation and returns nil.
+func ParseApprovalEscalationThresholds(raw string) ([]time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if strings.EqualFold(raw, "off") || strings.EqualFold(raw, "none") {
+		return nil, nil
+	}
+
+	var thresholds []time.Duration
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		d, err := time.ParseDuration(part)
+		if err != nil || d <= 0 {
+			return nil, ErrInvalidEscalationThreshold
+		}
+		thresholds = append(thresholds, d)
+	}
+	slices.Sort(thresholds)
+	return slices.Compact(thresholds), nil
+}
+
+// ApprovalEscalation is the payload of an APPROVAL_ESCALATED event.
+type ApprovalEscalation struct {
+	Level            int     `json:"level"`
+	ThresholdSeconds float64 `json:"threshold_seconds"`
+	WaitingSeconds   float64 `json:"waiting_seconds"`
+	Priority         int     `json:"priority"`
+}
+
+// ApprovalTimeoutAction is what happens to an APPROVAL step that waited past
+// its approval_timeout_seconds.
+type ApprovalTimeoutAction string
+
+const (
+	// ApprovalTimeoutFail fails the step and the run (the default).
+	ApprovalTimeoutFail ApprovalTimeoutAction = "fail"
+	// ApprovalTimeoutApprove approves the step as if a user had.
+	ApprovalTimeoutApprove ApprovalTimeoutAction = "approve"
+)
+
+// ParseApprovalTimeoutAction accepts fail or approve; empty means fail.
+func ParseApprovalTimeoutAction(v string) (ApprovalTimeoutAction, error) {
+	switch a := ApprovalTimeoutAction(strings.ToLower(strings.TrimSpace(v))); a {
+	case "":
+		return ApprovalTimeoutFail, nil
+	case ApprovalTimeoutFail, ApprovalTimeoutApprove:
+		return a, nil
+	default:
+		return "", ErrInvalidApprovalTimeout
+	}
+}
+
+// ApprovalTimeout is the payload of a STEP_APPROVAL_TIMED_OUT event.
+type ApprovalTimeout struct {
+	Action         ApprovalTimeoutAction `json:"action"`
+	TimeoutSeconds int                   `json:"timeout_seconds"`
+	WaitingSeconds float64               `json:"waiting_seconds"`
+	ApprovalName   string                `json:"approval_name,omitempty"`
+}
+
+// DefaultApprovalLink is the approval link used when none is configured: the
+// API route that approves the gate.
+const DefaultApprovalLink = "http://localhost:8080/runs/{run_id}/approvals/{step_id}"
+
+// ApprovalLink fills the {run_id} and {step_id} placeholders of link for the
+// gate stepID of runID. A nil stepID leaves {step_id} empty.
+func ApprovalLink(link string, runID uuid.UUID, stepID *uuid.UUID) string {
+	step := ""
+	if stepID != nil {
+		step = stepID.String()
+	}
+	return strings.NewReplacer(
+		"{run_id}", runID.String(),
+		"{step_id}", step,
+	).Replace(link)
+}
+
+// ApprovalGate is an APPROVAL step of a run that has not been approved yet:
+// PENDING until the steps before it settle, then WAITING_APPROVAL. Name is the
+// template's approval_name, empty for unnamed gates. TimesOutAt is set for a
+// waiting gate with an approval timeout.
+type ApprovalGate struct {
+	StepID       uuid.UUID  `json:"step_id"`
+	Name         string     `json:"name,omitempty"`
+	Status       StepStatus `json:"status"`
+	WaitingSince *time.Time `json:"waiting_since,omitempty"`
+	TimesOutAt   *time.Time `json:"times_out_at,omitempty"`
+}
@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseApprovalEscalationThresholds(t *testing.T) {
+	got, err := ParseApprovalEscalationThresholds(" 24h, 1h,4h,1h ")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := []time.Duration{time.Hour, 4 * time.Hour, 24 * time.Hour}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+
+	for _, raw := range []string{"off", "NONE"} {
+		if got, err := ParseApprovalEscalationThresholds(raw); err != nil || got != nil {
+			t.Fatalf("expected %q to disable escalation, got %v (err=%v)", raw, got, err)
+		}
+	}
+
+	for _, raw := range []string{"1h,soon", "0s", "-1h"} {
+		if _, err := ParseApprovalEscalationThresholds(raw); !errors.Is(err, ErrInvalidEscalationThreshold) {
+			t.Fatalf("expected ErrInvalidEscalationThreshold for %q, got %v", raw, err)
+		}
+	}
+}
+
+func TestParseApprovalTimeoutAction(t *testing.T) {
+	for raw, want := range map[string]ApprovalTimeoutAction{
+		"":          ApprovalTimeoutFail,
+		"fail":      ApprovalTimeoutFail,
+		" Approve ": ApprovalTimeoutApprove,
+	} {
+		got, err := ParseApprovalTimeoutAction(raw)
+		if err != nil || got != want {
+			t.Fatalf("parse %q: expected %s got %s (err=%v)", raw, want, got, err)
+		}
+	}
+
+	if _, err := ParseApprovalTimeoutAction("escalate"); !errors.Is(err, ErrInvalidApprovalTimeout) {
+		t.Fatalf("expected ErrInvalidApprovalTimeout, got %v", err)
+	}
+}
+
+func TestApprovalLink(t *testing.T) {
+	runID, stepID := uuid.New(), uuid.New()
+
+	got := ApprovalLink(DefaultApprovalLink, runID, &stepID)
+	want := "http://localhost:8080/runs/" + runID.String() + "/approvals/" + stepID.String()
+	if got != want {
+		t.Fatalf("expected %q got %q", want, got)
+	}
+	if got := ApprovalLink("https://ui/{run_id}?gate={step_id}", runID, nil); got != "https://ui/"+runID.String()+"?gate=" {
+		t.Fatalf("expected an empty step placeholder, got %q", got)
+	}
+}
@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audit log actions.
+const (
+	AuditAPIKeyCreate        = "api_key.create"
+	AuditAPIKeyUpdate        = "api_key.update"
+	AuditAPIKeyRevoke        = "api_key.revoke"
+	AuditWebhookSecretRotate = "api_key.webhook_secret.rotate"
+	AuditWebhookSecretExpire = "api_key.webhook_secret.expire"
+	AuditTenantPurge         = "tenant.purge"
+	AuditRunCreate           = "run.create"
+	AuditRunCancel           = "run.cancel"
+	AuditRunApprove          = "run.approve"
+	AuditRunReject           = "run.reject"
+	AuditTemplateVersion     = "workflow_template.version"
+	AuditSecretPut           = "secret.put"
+	AuditSecretDelete        = "secret.delete"
+	// Reads through the cross-tenant admin run endpoints are audited too.
+	AuditRunList = "run.list"
+	AuditRunView = "run.view"
+)
+
+// Audit actor types. Changes made outside an HTTP request, or by a caller
+// that set no actor, are attributed to the system.
+const (
+	AuditActorAdmin  = "admin"
+	AuditActorAPIKey = "api_key"
+	AuditActorSystem = "system"
+)
+
+// Audit target types.
+const (
+	AuditTargetAPIKey   = "api_key"
+	AuditTargetRun      = "run"
+	AuditTargetTemplate = "workflow_template"
+	AuditTargetSecret   = "secret"
+)
+
+const (
+	DefaultAuditPageSize = 100
+	MaxAuditPageSize     = 1000
+)
+
+// AuditActor is who made a change and from where. ID is set for API key
+// actors; IP and RequestID are empty when unknown.
+type AuditActor struct {
+	Type      string
+	ID        *uuid.UUID
+	IP        string
+	RequestID string
+}
+
+// AuditEntry is one recorded change. APIKeyID is the tenant the change
+// concerns; Before and After hold the changed fields, never secrets.
+type AuditEntry struct {
+	Seq        int64           `json:"seq"`
+	ID         uuid.UUID       `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorType  string          `json:"actor_type"`
+	ActorID    *uuid.UUID      `json:"actor_id,omitempty"`
+	APIKeyID   *uuid.UUID      `json:"api_key_id,omitempty"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	IP         *string         `json:"ip,omitempty"`
+	RequestID  *string         `json:"request_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+}
+
+// AuditFilter selects audit entries, newest first. Zero fields do not
+// filter; BeforeSeq pages backwards from a previous page's last Seq.
+type AuditFilter struct {
+	APIKeyID  *uuid.UUID
+	Action    string
+	ActorType string
+	TargetID  string
+	Since     *time.Time
+	Until     *time.Time
+	BeforeSeq int64
+	Limit     int
+}
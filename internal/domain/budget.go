@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxMonthlyBudgetUSD is the largest cap api_keys.monthly_budget_usd can hold.
+const MaxMonthlyBudgetUSD = 99_999_999
+
+// MonthlyBudget is a tenant's spend cap and what it has spent in the current
+// calendar month (UTC). A nil LimitUSD means the tenant has no cap.
+type MonthlyBudget struct {
+	APIKeyID uuid.UUID `json:"api_key_id"`
+	LimitUSD *float64  `json:"monthly_budget_usd"`
+	SpentUSD float64   `json:"month_to_date_cost_usd"`
+}
+
+// Exceeded reports whether the tenant has a cap and has spent all of it.
+func (b MonthlyBudget) Exceeded() bool {
+	return b.LimitUSD != nil && b.SpentUSD >= *b.LimitUSD
+}
+
+// MonthStart returns midnight UTC on the first day of t's month, where
+// month-to-date spend starts counting.
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// ValidateMonthlyBudget accepts nil (no cap) or a positive amount up to
+// MaxMonthlyBudgetUSD.
+func ValidateMonthlyBudget(usd *float64) error {
+	if usd == nil {
+		return nil
+	}
+	if !(*usd > 0) || *usd > MaxMonthlyBudgetUSD {
+		return ErrInvalidMonthlyBudget
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMonthlyBudgetExceeded(t *testing.T) {
+	limit := 10.0
+	tests := []struct {
+		name   string
+		budget MonthlyBudget
+		want   bool
+	}{
+		{name: "no cap", budget: MonthlyBudget{SpentUSD: 1e6}, want: false},
+		{name: "under cap", budget: MonthlyBudget{LimitUSD: &limit, SpentUSD: 9.99}, want: false},
+		{name: "at cap", budget: MonthlyBudget{LimitUSD: &limit, SpentUSD: 10}, want: true},
+		{name: "over cap", budget: MonthlyBudget{LimitUSD: &limit, SpentUSD: 12.5}, want: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.budget.Exceeded(); got != tc.want {
+				t.Fatalf("expected %v got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestMonthStart(t *testing.T) {
+	// 2026-03-01 01:30 in UTC+05:00 is still February in UTC.
+	local := time.Date(2026, time.March, 1, 1, 30, 0, 0, time.FixedZone("UTC+5", 5*60*60))
+	want := time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)
+	if got := MonthStart(local); !got.Equal(want) {
+		t.Fatalf("expected %s got %s", want, got)
+	}
+}
+
+func TestValidateMonthlyBudget(t *testing.T) {
+	if err := ValidateMonthlyBudget(nil); err != nil {
+		t.Fatalf("expected nil budget to be valid, got %v", err)
+	}
+	for _, usd := range []float64{0, -5, math.NaN(), math.Inf(1), MaxMonthlyBudgetUSD + 1} {
+		v := usd
+		if err := ValidateMonthlyBudget(&v); !errors.Is(err, ErrInvalidMonthlyBudget) {
+			t.Fatalf("expected ErrInvalidMonthlyBudget for %v, got %v", usd, err)
+		}
+	}
+	valid := 250.0
+	if err := ValidateMonthlyBudget(&valid); err != nil {
+		t.Fatalf("expected 250 to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,455 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxStepConditionLength bounds a template step condition.
+const MaxStepConditionLength = 1024
+
+// StepCondition is a parsed template step condition. The worker evaluates it
+// before running the step and skips the step when it is false.
+//
+// A condition compares paths into the run's context with JSON literals:
+//
+//	steps.LLM.output.type == "llm" && run.metadata.tier != "free"
+//	steps.TOOL.output.items.0.score >= 0.5 || !run.metadata.dry_run
+//
+// Paths start at steps.<NAME> (status and output of the most recently
+// finished step with that name) or run (metadata, tags, priority); numeric
+// segments index arrays. Operators are ==, !=, <, <=, >, >= (ordering only
+// between two numbers or two strings), !, &&, || and parentheses. A bare path
+// is true unless it is missing, null, false, 0, "", or empty.
+type StepCondition struct {
+	expr string
+	root conditionNode
+}
+
+// ParseStepCondition parses expr. Surrounding whitespace is ignored.
+func ParseStepCondition(expr string) (*StepCondition, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidStepCondition)
+	}
+	if len(expr) > MaxStepConditionLength {
+		return nil, fmt.Errorf("%w: longer than %d bytes", ErrInvalidStepCondition, MaxStepConditionLength)
+	}
+
+	tokens, err := tokenizeCondition(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidStepCondition, p.peek().text)
+	}
+	return &StepCondition{expr: expr, root: root}, nil
+}
+
+// String returns the condition as written.
+func (c *StepCondition) String() string {
+	return c.expr
+}
+
+// Evaluate reports whether the condition holds for ctx, a JSON-shaped
+// document such as the one built from the run and its earlier steps.
+func (c *StepCondition) Evaluate(ctx map[string]any) bool {
+	return c.root.eval(ctx)
+}
+
+// StepPath is a path into the condition context, such as
+// steps.LLM.output.items, used where a step reads a value rather than a
+// condition.
+type StepPath struct {
+	operand conditionOperand
+	raw     string
+}
+
+// ParseStepPath parses a single path using the condition path syntax.
+func ParseStepPath(path string) (StepPath, error) {
+	path = strings.TrimSpace(path)
+	tokens, err := tokenizeCondition(path)
+	if err != nil {
+		return StepPath{}, err
+	}
+	if len(tokens) != 1 || tokens[0].kind != tokenPath {
+		return StepPath{}, fmt.Errorf("%w: %q is not a path", ErrInvalidStepCondition, path)
+	}
+	p := &conditionParser{tokens: tokens}
+	operand, err := p.parseOperand()
+	if err != nil {
+		return StepPath{}, err
+	}
+	return StepPath{operand: operand, raw: path}, nil
+}
+
+// String returns the path as written.
+func (p StepPath) String() string {
+	return p.raw
+}
+
+// Lookup returns the value at the path in ctx, or nil when it is missing.
+func (p StepPath) Lookup(ctx map[string]any) any {
+	return p.operand.value(ctx)
+}
+
+type conditionNode interface {
+	eval(ctx map[string]any) bool
+}
+
+type conditionOr struct{ left, right conditionNode }
+
+func (n conditionOr) eval(ctx map[string]any) bool { return n.left.eval(ctx) || n.right.eval(ctx) }
+
+type conditionAnd struct{ left, right conditionNode }
+
+func (n conditionAnd) eval(ctx map[string]any) bool { return n.left.eval(ctx) && n.right.eval(ctx) }
+
+type conditionNot struct{ inner conditionNode }
+
+func (n conditionNot) eval(ctx map[string]any) bool { return !n.inner.eval(ctx) }
+
+type conditionTruthy struct{ operand conditionOperand }
+
+func (n conditionTruthy) eval(ctx map[string]any) bool { return truthy(n.operand.value(ctx)) }
+
+type conditionCompare struct {
+	op          string
+	left, right conditionOperand
+}
+
+func (n conditionCompare) eval(ctx map[string]any) bool {
+	left, right := n.left.value(ctx), n.right.value(ctx)
+	switch n.op {
+	case "==":
+		return jsonEqual(left, right)
+	case "!=":
+		return !jsonEqual(left, right)
+	}
+
+	cmp, ok := compareOrdered(left, right)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// conditionOperand is either a path (segments set) or a literal.
+type conditionOperand struct {
+	segments []string
+	literal  any
+}
+
+func (o conditionOperand) value(ctx map[string]any) any {
+	if o.segments == nil {
+		return o.literal
+	}
+	var cur any = ctx
+	for _, seg := range o.segments {
+		switch node := cur.(type) {
+		case map[string]any:
+			cur = node[seg]
+		case []any:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			cur = node[i]
+		default:
+			return nil
+		}
+	}
+	return cur
+}
+
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	default:
+		return true
+	}
+}
+
+func jsonEqual(a, b any) bool {
+	switch a := a.(type) {
+	case nil:
+		return b == nil
+	case bool:
+		bb, ok := b.(bool)
+		return ok && a == bb
+	case float64:
+		bf, ok := b.(float64)
+		return ok && a == bf
+	case string:
+		bs, ok := b.(string)
+		return ok && a == bs
+	default:
+		// Arrays and objects compare by their JSON encoding.
+		aj, errA := json.Marshal(a)
+		bj, errB := json.Marshal(b)
+		return errA == nil && errB == nil && string(aj) == string(bj)
+	}
+}
+
+func compareOrdered(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		bf, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a < bf:
+			return -1, true
+		case a > bf:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		bs, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, bs), true
+	}
+	return 0, false
+}
+
+type conditionTokenKind int
+
+const (
+	tokenPath conditionTokenKind = iota
+	tokenLiteral
+	tokenOp
+)
+
+type conditionToken struct {
+	kind    conditionTokenKind
+	text    string
+	literal any
+}
+
+func tokenizeCondition(expr string) ([]conditionToken, error) {
+	var tokens []conditionToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||") ||
+			strings.HasPrefix(expr[i:], "==") || strings.HasPrefix(expr[i:], "!=") ||
+			strings.HasPrefix(expr[i:], "<=") || strings.HasPrefix(expr[i:], ">="):
+			tokens = append(tokens, conditionToken{kind: tokenOp, text: expr[i : i+2]})
+			i += 2
+		case strings.ContainsRune("<>!()", rune(c)):
+			tokens = append(tokens, conditionToken{kind: tokenOp, text: expr[i : i+1]})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrInvalidStepCondition)
+			}
+			var s string
+			if err := json.Unmarshal([]byte(expr[i:end+1]), &s); err != nil {
+				return nil, fmt.Errorf("%w: invalid string %s", ErrInvalidStepCondition, expr[i:end+1])
+			}
+			tokens = append(tokens, conditionToken{kind: tokenLiteral, text: expr[i : end+1], literal: s})
+			i = end + 1
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for end < len(expr) && strings.ContainsRune("0123456789.eE+-", rune(expr[end])) {
+				end++
+			}
+			n, err := strconv.ParseFloat(expr[i:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid number %q", ErrInvalidStepCondition, expr[i:end])
+			}
+			tokens = append(tokens, conditionToken{kind: tokenLiteral, text: expr[i:end], literal: n})
+			i = end
+		case isPathChar(c):
+			end := i + 1
+			for end < len(expr) && (isPathChar(expr[end]) || expr[end] == '.') {
+				end++
+			}
+			word := expr[i:end]
+			switch word {
+			case "true":
+				tokens = append(tokens, conditionToken{kind: tokenLiteral, text: word, literal: true})
+			case "false":
+				tokens = append(tokens, conditionToken{kind: tokenLiteral, text: word, literal: false})
+			case "null":
+				tokens = append(tokens, conditionToken{kind: tokenLiteral, text: word, literal: nil})
+			default:
+				tokens = append(tokens, conditionToken{kind: tokenPath, text: word})
+			}
+			i = end
+		default:
+			return nil, fmt.Errorf("%w: unexpected character %q", ErrInvalidStepCondition, c)
+		}
+	}
+	return tokens, nil
+}
+
+func isPathChar(c byte) bool {
+	return c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+}
+
+func (p *conditionParser) done() bool { return p.pos >= len(p.tokens) }
+
+func (p *conditionParser) peek() conditionToken {
+	if p.done() {
+		return conditionToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *conditionParser) acceptOp(ops ...string) (string, bool) {
+	tok := p.peek()
+	if p.done() || tok.kind != tokenOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *conditionParser) parseOr() (conditionNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOp("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = conditionOr{left: left, right: right}
+	}
+}
+
+func (p *conditionParser) parseAnd() (conditionNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOp("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = conditionAnd{left: left, right: right}
+	}
+}
+
+func (p *conditionParser) parseUnary() (conditionNode, error) {
+	if _, ok := p.acceptOp("!"); ok {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return conditionNot{inner: inner}, nil
+	}
+	if _, ok := p.acceptOp("("); ok {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.acceptOp(")"); !ok {
+			return nil, fmt.Errorf("%w: missing )", ErrInvalidStepCondition)
+		}
+		return inner, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.acceptOp("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		if left.segments == nil {
+			return nil, fmt.Errorf("%w: a literal must be compared with a path", ErrInvalidStepCondition)
+		}
+		return conditionTruthy{operand: left}, nil
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return conditionCompare{op: op, left: left, right: right}, nil
+}
+
+func (p *conditionParser) parseOperand() (conditionOperand, error) {
+	if p.done() {
+		return conditionOperand{}, fmt.Errorf("%w: unexpected end", ErrInvalidStepCondition)
+	}
+	tok := p.tokens[p.pos]
+	switch tok.kind {
+	case tokenLiteral:
+		p.pos++
+		return conditionOperand{literal: tok.literal}, nil
+	case tokenPath:
+		p.pos++
+		segments := strings.Split(tok.text, ".")
+		for _, seg := range segments {
+			if seg == "" {
+				return conditionOperand{}, fmt.Errorf("%w: invalid path %q", ErrInvalidStepCondition, tok.text)
+			}
+		}
+		if segments[0] != "steps" && segments[0] != "run" {
+			return conditionOperand{}, fmt.Errorf("%w: path %q must start with steps or run", ErrInvalidStepCondition, tok.text)
+		}
+		return conditionOperand{segments: segments}, nil
+	default:
+		return conditionOperand{}, fmt.Errorf("%w: unexpected %q", ErrInvalidStepCondition, tok.text)
+	}
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStepConditionEvaluate(t *testing.T) {
+	ctx := map[string]any{
+		"steps": map[string]any{
+			"LLM": map[string]any{
+				"status": "SUCCEEDED",
+				"output": map[string]any{
+					"type":  "llm",
+					"score": 0.75,
+					"items": []any{map[string]any{"ok": true}},
+				},
+			},
+		},
+		"run": map[string]any{
+			"metadata": map[string]any{"tier": "pro"},
+			"tags":     []any{"nightly"},
+			"priority": float64(2),
+		},
+	}
+
+	for expr, want := range map[string]bool{
+		`steps.LLM.output.type == "llm"`:                                   true,
+		`steps.LLM.status != "SUCCEEDED"`:                                  false,
+		`steps.LLM.output.score >= 0.5`:                                    true,
+		`steps.LLM.output.score < 0.5`:                                     false,
+		`steps.LLM.output.items.0.ok`:                                      true,
+		`steps.LLM.output.items.1.ok`:                                      false,
+		`steps.TOOL.output.type == null`:                                   true,
+		`!run.metadata.dry_run`:                                            true,
+		`run.metadata.tier == "free" || run.priority > 1`:                  true,
+		`run.metadata.tier == "free" || run.priority > 1 && false == true`: false,
+		`(run.metadata.tier == "free" || run.priority > 1) && run.tags`:    true,
+		`steps.LLM.output.type > 1`:                                        false,
+		`run.metadata.tier > "free"`:                                       true,
+	} {
+		cond, err := ParseStepCondition(expr)
+		if err != nil {
+			t.Fatalf("%s: parse: %v", expr, err)
+		}
+		if got := cond.Evaluate(ctx); got != want {
+			t.Fatalf("%s: expected %v got %v", expr, want, got)
+		}
+	}
+}
+
+func TestParseStepConditionRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"   ",
+		`steps.LLM.status ==`,
+		`input.x == 1`,
+		`"llm"`,
+		`steps..status`,
+		`(steps.LLM.status == "SUCCEEDED"`,
+		`steps.LLM.status == "SUCCEEDED" steps.TOOL.status`,
+		`steps.LLM.status = "SUCCEEDED"`,
+		`steps.LLM.output.type == "llm`,
+		`steps.LLM.output.score > 1.2.3`,
+		strings.Repeat("a", MaxStepConditionLength+1),
+	} {
+		if _, err := ParseStepCondition(expr); !errors.Is(err, ErrInvalidStepCondition) {
+			t.Fatalf("%q: expected ErrInvalidStepCondition, got %v", expr, err)
+		}
+	}
+}
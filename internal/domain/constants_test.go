@@ -2,7 +2,10 @@
 
 package domain
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestRunStatusConstants(t *testing.T) {
 	if RunPending != "PENDING" {
@@ -44,6 +47,9 @@ func TestStepConstants(t *testing.T) {
 	if StepCanceled != "CANCELED" {
 		t.Fatalf("unexpected StepCanceled value: %s", StepCanceled)
 	}
+	if StepSkipped != "SKIPPED" {
+		t.Fatalf("unexpected StepSkipped value: %s", StepSkipped)
+	}
 
 	if StepLLM != "LLM" {
 		t.Fatalf("unexpected StepLLM value: %s", StepLLM)
@@ -55,3 +61,33 @@ func TestStepConstants(t *testing.T) {
 		t.Fatalf("unexpected StepApproval value: %s", StepApproval)
 	}
 }
+
+func TestWebhookDeliveryStatusConstants(t *testing.T) {
+	if WebhookPending != "PENDING" {
+		t.Fatalf("unexpected WebhookPending value: %s", WebhookPending)
+	}
+	if WebhookDelivered != "DELIVERED" {
+		t.Fatalf("unexpected WebhookDelivered value: %s", WebhookDelivered)
+	}
+	if WebhookFailed != "FAILED" {
+		t.Fatalf("unexpected WebhookFailed value: %s", WebhookFailed)
+	}
+}
+
+func TestParseOnFailurePolicy(t *testing.T) {
+	tests := map[string]OnFailurePolicy{
+		"":         OnFailureFailRun,
+		"fail_run": OnFailureFailRun,
+		" Skip ":   OnFailureSkip,
+		"continue": OnFailureContinue,
+	}
+	for in, want := range tests {
+		got, err := ParseOnFailurePolicy(in)
+		if err != nil || got != want {
+			t.Fatalf("parse %q: expected %s got %s (err=%v)", in, want, got, err)
+		}
+	}
+	if _, err := ParseOnFailurePolicy("retry"); !errors.Is(err, ErrInvalidOnFailurePolicy) {
+		t.Fatalf("expected ErrInvalidOnFailurePolicy, got %v", err)
+	}
+}
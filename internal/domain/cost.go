@@ -4,15 +4,69 @@ package domain
 
 import "github.com/google/uuid"
 
+// CostDetail itemizes what one step execution cost. LLM executors report the
+// provider, model, and token counts; tool executors report the tool name.
+// UnitPriceUSD is the price per token, or per call for tools.
+type CostDetail struct {
+	Provider         string  `json:"provider,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	Tool             string  `json:"tool,omitempty"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	UnitPriceUSD     float64 `json:"unit_price_usd"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
 type StepCostBreakdown struct {
-	ID      uuid.UUID `json:"id"`
-	Name    string    `json:"name"`
-	Status  string    `json:"status"`
-	CostUSD float64   `json:"cost_usd"`
+	ID         uuid.UUID   `json:"id"`
+	Name       string      `json:"name"`
+	Status     string      `json:"status"`
+	CostUSD    float64     `json:"cost_usd"`
+	CostDetail *CostDetail `json:"cost_detail"`
+}
+
+// ModelCost totals the steps of a run that used the same provider and model
+// or tool.
+type ModelCost struct {
+	Provider         string  `json:"provider,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	Tool             string  `json:"tool,omitempty"`
+	Steps            int     `json:"steps"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
 }
 
 type RunCostBreakdown struct {
 	RunID        uuid.UUID           `json:"run_id"`
 	TotalCostUSD float64             `json:"total_cost_usd"`
 	Steps        []StepCostBreakdown `json:"steps"`
+	ByModel      []ModelCost         `json:"by_model"`
+}
+
+// SummarizeCostByModel groups the steps' cost details by provider and model
+// or tool, in order of first appearance. Steps without a detail are skipped.
+func SummarizeCostByModel(steps []StepCostBreakdown) []ModelCost {
+	type key struct{ provider, model, tool string }
+
+	out := make([]ModelCost, 0, 2)
+	index := make(map[key]int, 2)
+	for _, step := range steps {
+		d := step.CostDetail
+		if d == nil {
+			continue
+		}
+		k := key{d.Provider, d.Model, d.Tool}
+		i, ok := index[k]
+		if !ok {
+			i = len(out)
+			index[k] = i
+			out = append(out, ModelCost{Provider: d.Provider, Model: d.Model, Tool: d.Tool})
+		}
+		out[i].Steps++
+		out[i].PromptTokens += d.PromptTokens
+		out[i].CompletionTokens += d.CompletionTokens
+		out[i].CostUSD += d.CostUSD
+	}
+	return out
 }
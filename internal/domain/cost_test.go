@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSummarizeCostByModel(t *testing.T) {
+	steps := []StepCostBreakdown{
+		{Name: "LLM", CostDetail: &CostDetail{Provider: "openai", Model: "gpt-4o", PromptTokens: 100, CompletionTokens: 20, CostUSD: 0.5}},
+		{Name: "TOOL", CostDetail: &CostDetail{Tool: "search", CostUSD: 0.01}},
+		{Name: "LLM", CostDetail: &CostDetail{Provider: "openai", Model: "gpt-4o", PromptTokens: 50, CompletionTokens: 10, CostUSD: 0.25}},
+		{Name: "APPROVAL"},
+	}
+
+	got := SummarizeCostByModel(steps)
+	want := []ModelCost{
+		{Provider: "openai", Model: "gpt-4o", Steps: 2, PromptTokens: 150, CompletionTokens: 30, CostUSD: 0.75},
+		{Tool: "search", Steps: 1, CostUSD: 0.01},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v got %+v", want, got)
+	}
+
+	if got := SummarizeCostByModel(nil); got == nil || len(got) != 0 {
+		t.Fatalf("expected empty non-nil summary, got %#v", got)
+	}
+}
@@ -2,9 +2,89 @@
 
 package domain
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var ErrMaxConcurrentRunsExceeded = errors.New("max concurrent runs exceeded")
-var ErrWorkflowTemplateNotFound = errors.New("workflow template not found")
+var ErrMonthlyBudgetExceeded = errors.New("monthly budget exceeded")
 var ErrInvalidAPIKeyName = errors.New("invalid api key name")
 var ErrRunNotWaitingApproval = errors.New("run is not waiting approval")
+var ErrApprovalStepNotFound = errors.New("approval step not found")
+var ErrInvalidEventRetention = errors.New("invalid event retention days")
+var ErrInvalidRunRetention = errors.New("invalid run retention days")
+var ErrInvalidMonthlyBudget = errors.New("invalid monthly budget")
+var ErrInvalidWebhookEvent = errors.New("invalid webhook event type")
+var ErrInvalidRunMetadata = errors.New("invalid run metadata")
+var ErrInvalidRunTag = errors.New("invalid run tag")
+var ErrPurgeSigningKeyMissing = errors.New("purge report signing key not configured")
+var ErrInvalidWebhookSecret = errors.New("invalid webhook secret")
+var ErrInvalidWebhookKeyOverlap = errors.New("invalid webhook signing key overlap")
+var ErrWebhookDeliveryPending = errors.New("webhook delivery is still pending")
+var ErrInvalidScope = errors.New("invalid api key scope")
+var ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+var ErrInvalidAPIKeySlug = errors.New("invalid api key slug")
+var ErrInvalidAPIKeyExpiry = errors.New("invalid api key expiry")
+var ErrInvalidEscalationThreshold = errors.New("invalid approval escalation threshold")
+var ErrInvalidApprovalTimeout = errors.New("invalid approval timeout")
+var ErrInvalidOnFailurePolicy = errors.New("invalid on_failure policy")
+var ErrInvalidStepCondition = errors.New("invalid step condition")
+var ErrInvalidMapStep = errors.New("invalid map step")
+var ErrInvalidRetryPolicy = errors.New("invalid retry policy")
+var ErrInvalidStepCommand = errors.New("invalid step command")
+var ErrInvalidStepHTTP = errors.New("invalid step http request")
+var ErrInvalidAllowedCIDR = errors.New("invalid allowed cidr")
+var ErrInvalidEgressAllowHost = errors.New("invalid egress allow host")
+var ErrAPIKeySlugTaken = errors.New("api key slug already in use")
+var ErrInvalidSchedulingWeight = errors.New("invalid scheduling weight")
+var ErrInvalidTemplateRunLimit = errors.New("invalid template run limit")
+var ErrInvalidStepLimit = errors.New("invalid step limit")
+var ErrInvalidCancelReason = errors.New("invalid cancel reason")
+var ErrInvalidExportFormat = errors.New("invalid export format")
+var ErrExportNotReady = errors.New("export is not ready")
+var ErrInvalidStepInput = errors.New("invalid step input")
+var ErrInvalidWorkflowTemplate = errors.New("invalid workflow template")
+var ErrInvalidTemplateVersion = errors.New("invalid template version")
+var ErrInvalidSecret = errors.New("invalid secret")
+var ErrSecretsKeyMissing = errors.New("secrets key not configured")
+
+// ErrNotFound is wrapped by the errors repositories return when the record a
+// call names does not exist or belongs to another tenant; handlers answer
+// 404 for it.
+var ErrNotFound = errors.New("not found")
+
+var ErrRunNotFound = fmt.Errorf("run %w", ErrNotFound)
+var ErrStepNotFound = fmt.Errorf("step %w", ErrNotFound)
+var ErrAPIKeyNotFound = fmt.Errorf("api key %w", ErrNotFound)
+var ErrWebhookSigningKeyNotFound = fmt.Errorf("webhook signing key %w", ErrNotFound)
+var ErrWebhookDeliveryNotFound = fmt.Errorf("webhook delivery %w", ErrNotFound)
+var ErrExportNotFound = fmt.Errorf("export %w", ErrNotFound)
+var ErrSecretNotFound = fmt.Errorf("secret %w", ErrNotFound)
+var ErrEventNotFound = fmt.Errorf("event %w", ErrNotFound)
+var ErrWorkflowTemplateNotFound = fmt.Errorf("workflow template %w", ErrNotFound)
+
+// ErrRunTerminal reports a change asked of a run that already finished.
+var ErrRunTerminal = errors.New("run is terminal")
+
+// ErrMaxConcurrentTemplateRunsExceeded is an ErrMaxConcurrentRunsExceeded
+// caused by a per-template cap rather than the key's overall limit.
+var ErrMaxConcurrentTemplateRunsExceeded = fmt.Errorf("%w for template", ErrMaxConcurrentRunsExceeded)
+
+// ErrNoStepToClaim reports that a claim found no runnable step the tenant's
+// limits allow.
+var ErrNoStepToClaim = errors.New("no step to claim")
+
+// ErrStepHandledAtClaim is wrapped by the errors a single-step claim returns
+// when it settled the step itself (skipped it by its condition, expanded a
+// MAP step, or opened an approval gate), leaving nothing to execute.
+var ErrStepHandledAtClaim = errors.New("step handled at claim")
+
+// ErrClaimSingly reports that the first runnable step needs handling at
+// claim, which only a single-step claim does.
+var ErrClaimSingly = errors.New("step must be claimed on its own")
+
+// ErrStaleClaim reports that a step was reclaimed, by another worker or the
+// same one, while the claim settling it still executed: the newer claim owns
+// the step's result.
+var ErrStaleClaim = errors.New("step was reclaimed by a newer claim")
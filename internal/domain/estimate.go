@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Units executors price their work in.
+const (
+	PricingUnitToken = "token"
+	PricingUnitCall  = "call"
+)
+
+const (
+	// MaxEstimateRuns bounds the runs one cost estimate covers.
+	MaxEstimateRuns = 1_000_000
+	// DefaultEstimateMapItems is how many items a MAP step is assumed to
+	// fan out to when the estimate does not say.
+	DefaultEstimateMapItems = 1
+)
+
+// ErrInvalidCostEstimate is returned for estimate options out of range.
+var ErrInvalidCostEstimate = errors.New("invalid cost estimate request")
+
+// StepPricing is what an executor declares one execution of its step type
+// costs: UnitPriceUSD per Unit, and the range of units one execution uses.
+// Workers record their executors' pricing in the workers registry.
+type StepPricing struct {
+	Provider     string  `json:"provider,omitempty"`
+	Model        string  `json:"model,omitempty"`
+	Tool         string  `json:"tool,omitempty"`
+	Unit         string  `json:"unit"`
+	UnitPriceUSD float64 `json:"unit_price_usd"`
+	MinUnits     int     `json:"min_units"`
+	MaxUnits     int     `json:"max_units"`
+}
+
+// StepPricingFromWorkers picks the declared pricing of each step type from
+// workers, most recently started first, so the newest build wins during a
+// rollout.
+func StepPricingFromWorkers(workers []WorkerRecord) map[StepName]StepPricing {
+	pricing := make(map[StepName]StepPricing)
+	for _, w := range workers {
+		for name, p := range w.Pricing {
+			if _, ok := pricing[name]; !ok {
+				pricing[name] = p
+			}
+		}
+	}
+	return pricing
+}
+
+// CostEstimateOptions scales a template's cost estimate.
+type CostEstimateOptions struct {
+	// Runs is how many runs of the template to estimate; 0 means 1.
+	Runs int
+	// MapItems is how many items each MAP step is expected to fan out to;
+	// 0 means DefaultEstimateMapItems.
+	MapItems int
+}
+
+// ParseCostEstimateOptions reads the runs and map_items query parameters of
+// a cost estimate.
+func ParseCostEstimateOptions(runs, mapItems string) (CostEstimateOptions, error) {
+	var (
+		opts CostEstimateOptions
+		err  error
+	)
+	if runs = strings.TrimSpace(runs); runs != "" {
+		opts.Runs, err = strconv.Atoi(runs)
+		if err != nil || opts.Runs <= 0 || opts.Runs > MaxEstimateRuns {
+			return CostEstimateOptions{}, fmt.Errorf("%w: runs must be between 1 and %d", ErrInvalidCostEstimate, MaxEstimateRuns)
+		}
+	}
+	if mapItems = strings.TrimSpace(mapItems); mapItems != "" {
+		opts.MapItems, err = strconv.Atoi(mapItems)
+		if err != nil || opts.MapItems <= 0 || opts.MapItems > MaxMapItems {
+			return CostEstimateOptions{}, fmt.Errorf("%w: map_items must be between 1 and %d", ErrInvalidCostEstimate, MaxMapItems)
+		}
+	}
+	return opts, nil
+}
+
+// StepCostEstimate is the expected cost of one template step in one run.
+type StepCostEstimate struct {
+	Position int      `json:"position"`
+	Name     StepName `json:"name"`
+	// Executions is how many times the step's executor runs: the expected
+	// item count for MAP steps, 0 for approval gates, else 1.
+	Executions int `json:"executions"`
+	// Conditional steps may be skipped, so they add nothing to the minimum.
+	Conditional bool         `json:"conditional,omitempty"`
+	Pricing     *StepPricing `json:"pricing,omitempty"`
+	// Unpriced is set when no active worker declares pricing for the step's
+	// executor; the step then adds nothing to either bound.
+	Unpriced   bool    `json:"unpriced,omitempty"`
+	MinCostUSD float64 `json:"min_cost_usd"`
+	MaxCostUSD float64 `json:"max_cost_usd"`
+}
+
+// CostEstimate is the expected cost range of running a template. The run
+// bounds cover one run; MinCostUSD and MaxCostUSD cover Runs of them. Retries
+// are not included: each retry of a step costs up to its MaxCostUSD again.
+type CostEstimate struct {
+	TemplateName    string             `json:"template_name,omitempty"`
+	TemplateVersion int                `json:"template_version,omitempty"`
+	Runs            int                `json:"runs"`
+	MapItems        int                `json:"map_items"`
+	Steps           []StepCostEstimate `json:"steps"`
+	RunMinCostUSD   float64            `json:"run_min_cost_usd"`
+	RunMaxCostUSD   float64            `json:"run_max_cost_usd"`
+	MinCostUSD      float64            `json:"min_cost_usd"`
+	MaxCostUSD      float64            `json:"max_cost_usd"`
+	// UnpricedSteps names the steps whose executors declared no pricing, so
+	// the bounds understate their cost.
+	UnpricedSteps []StepName `json:"unpriced_steps"`
+}
+
+// EstimateTemplateCost estimates the cost of running steps from the pricing
+// their executors declare. Approval gates, command steps, which run in the
+// worker's local sandbox, and HTTP steps, which the runtime does not bill,
+// cost nothing.
+func EstimateTemplateCost(steps []WorkflowTemplateStep, pricing map[StepName]StepPricing, opts CostEstimateOptions) CostEstimate {
+	runs := max(opts.Runs, 1)
+	mapItems := opts.MapItems
+	if mapItems <= 0 {
+		mapItems = DefaultEstimateMapItems
+	}
+
+	estimate := CostEstimate{
+		Runs:          runs,
+		MapItems:      mapItems,
+		Steps:         make([]StepCostEstimate, 0, len(steps)),
+		UnpricedSteps: []StepName{},
+	}
+	for i, st := range steps {
+		step := StepCostEstimate{
+			Position:    i,
+			Name:        st.Name,
+			Executions:  1,
+			Conditional: st.Condition != "",
+		}
+		executor := st.Name
+		switch {
+		case st.Name == StepApproval:
+			step.Executions = 0
+		case st.Name == StepMap:
+			step.Executions = mapItems
+			executor = st.MapStep
+		}
+
+		if step.Executions > 0 && len(st.Command) == 0 && st.HTTP == nil {
+			if p, ok := pricing[executor]; ok {
+				step.Pricing = &p
+				step.MaxCostUSD = float64(p.MaxUnits) * p.UnitPriceUSD * float64(step.Executions)
+				if !step.Conditional {
+					step.MinCostUSD = float64(p.MinUnits) * p.UnitPriceUSD * float64(step.Executions)
+				}
+			} else {
+				step.Unpriced = true
+				estimate.UnpricedSteps = append(estimate.UnpricedSteps, st.Name)
+			}
+		}
+
+		estimate.RunMinCostUSD += step.MinCostUSD
+		estimate.RunMaxCostUSD += step.MaxCostUSD
+		estimate.Steps = append(estimate.Steps, step)
+	}
+	estimate.MinCostUSD = estimate.RunMinCostUSD * float64(runs)
+	estimate.MaxCostUSD = estimate.RunMaxCostUSD * float64(runs)
+	return estimate
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestStepPricingFromWorkers(t *testing.T) {
+	newer := StepPricing{Model: "v2", Unit: PricingUnitToken, UnitPriceUSD: 0.2, MinUnits: 1, MaxUnits: 2}
+	older := StepPricing{Model: "v1", Unit: PricingUnitToken, UnitPriceUSD: 0.1, MinUnits: 1, MaxUnits: 2}
+	tool := StepPricing{Tool: "search", Unit: PricingUnitCall, UnitPriceUSD: 0.01, MinUnits: 1, MaxUnits: 1}
+
+	got := StepPricingFromWorkers([]WorkerRecord{
+		{Pricing: map[StepName]StepPricing{StepLLM: newer}},
+		{Pricing: map[StepName]StepPricing{StepLLM: older, StepTool: tool}},
+		{},
+	})
+	want := map[StepName]StepPricing{StepLLM: newer, StepTool: tool}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v got %+v", want, got)
+	}
+}
+
+func TestEstimateTemplateCost(t *testing.T) {
+	pricing := map[StepName]StepPricing{
+		StepLLM:  {Model: "m", Unit: PricingUnitToken, UnitPriceUSD: 0.001, MinUnits: 100, MaxUnits: 500},
+		StepTool: {Tool: "t", Unit: PricingUnitCall, UnitPriceUSD: 0.01, MinUnits: 1, MaxUnits: 1},
+	}
+	steps := []WorkflowTemplateStep{
+		{Name: StepLLM},
+		{Name: StepTool, Condition: "steps.LLM.output.ok == true"},
+		{Name: StepMap, MapItems: "steps.LLM.output.items", MapStep: StepLLM},
+		{Name: StepTool, Command: []string{"echo"}},
+		{Name: StepApproval},
+		{Name: "EMBED"},
+	}
+
+	got := EstimateTemplateCost(steps, pricing, CostEstimateOptions{Runs: 10, MapItems: 4})
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+	if got.Runs != 10 || got.MapItems != 4 || len(got.Steps) != len(steps) {
+		t.Fatalf("unexpected estimate shape: %+v", got)
+	}
+	if s := got.Steps[1]; !s.Conditional || s.MinCostUSD != 0 || !near(s.MaxCostUSD, 0.01) {
+		t.Fatalf("expected a conditional step to add only to the maximum, got %+v", s)
+	}
+	if s := got.Steps[2]; s.Executions != 4 || !near(s.MinCostUSD, 0.4) || !near(s.MaxCostUSD, 2) {
+		t.Fatalf("expected the MAP step priced per item, got %+v", s)
+	}
+	if s := got.Steps[3]; s.Pricing != nil || s.Unpriced || s.MaxCostUSD != 0 {
+		t.Fatalf("expected a command step to be free, got %+v", s)
+	}
+	if s := got.Steps[4]; s.Executions != 0 || s.Unpriced {
+		t.Fatalf("expected an approval gate to be free, got %+v", s)
+	}
+	if !reflect.DeepEqual(got.UnpricedSteps, []StepName{"EMBED"}) || !got.Steps[5].Unpriced {
+		t.Fatalf("expected EMBED unpriced, got %+v", got.UnpricedSteps)
+	}
+	if !near(got.RunMinCostUSD, 0.5) || !near(got.RunMaxCostUSD, 2.51) {
+		t.Fatalf("expected run bounds 0.5..2.51, got %v..%v", got.RunMinCostUSD, got.RunMaxCostUSD)
+	}
+	if !near(got.MinCostUSD, 5) || !near(got.MaxCostUSD, 25.1) {
+		t.Fatalf("expected totals 5..25.1, got %v..%v", got.MinCostUSD, got.MaxCostUSD)
+	}
+
+	if got := EstimateTemplateCost(steps[:1], nil, CostEstimateOptions{}); got.Runs != 1 || got.MapItems != DefaultEstimateMapItems {
+		t.Fatalf("expected defaults, got %+v", got)
+	}
+}
+
+func TestParseCostEstimateOptions(t *testing.T) {
+	opts, err := ParseCostEstimateOptions(" 25 ", "3")
+	if err != nil || opts != (CostEstimateOptions{Runs: 25, MapItems: 3}) {
+		t.Fatalf("expected runs 25 and 3 map items, got %+v, %v", opts, err)
+	}
+	if opts, err := ParseCostEstimateOptions("", ""); err != nil || opts != (CostEstimateOptions{}) {
+		t.Fatalf("expected zero options, got %+v, %v", opts, err)
+	}
+	for _, tc := range [][2]string{{"0", ""}, {"x", ""}, {"2000000", ""}, {"", "0"}, {"", "1001"}} {
+		if _, err := ParseCostEstimateOptions(tc[0], tc[1]); !errors.Is(err, ErrInvalidCostEstimate) {
+			t.Fatalf("%q: expected ErrInvalidCostEstimate, got %v", tc, err)
+		}
+	}
+}
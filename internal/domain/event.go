@@ -17,3 +17,51 @@ type EventRecord struct {
 	Payload   json.RawMessage `json:"payload,omitempty"`
 	CreatedAt time.Time       `json:"created_at"`
 }
+
+const (
+	EventStepClaimed         = "STEP_CLAIMED"
+	EventStepProgress        = "STEP_PROGRESS"
+	EventStepSucceeded       = "STEP_SUCCEEDED"
+	EventStepWaitingApproval = "STEP_WAITING_APPROVAL"
+	EventStepFailedRetry     = "STEP_FAILED_RETRY"
+	EventStepFailed          = "STEP_FAILED"
+	EventStepSkipped         = "STEP_SKIPPED"
+	EventStepCanceled        = "STEP_CANCELED"
+	EventStepApproved        = "STEP_APPROVED"
+	EventStepEgressBlocked   = "STEP_EGRESS_BLOCKED"
+	EventApprovalEscalated   = "APPROVAL_ESCALATED"
+	EventApprovalTimedOut    = "STEP_APPROVAL_TIMED_OUT"
+	EventRunApproved         = "RUN_APPROVED"
+	EventRunCanceled         = "RUN_CANCELED"
+	EventRunReconciled       = "RUN_RECONCILED"
+	EventRunSummary          = "RUN_SUMMARY"
+)
+
+// SubscribableEventTypes lists the event types a run can subscribe its
+// webhook to in addition to the terminal status callback.
+var SubscribableEventTypes = []string{
+	EventStepClaimed,
+	EventStepSucceeded,
+	EventStepWaitingApproval,
+	EventStepFailedRetry,
+	EventStepFailed,
+	EventStepSkipped,
+	EventStepCanceled,
+	EventStepApproved,
+	EventStepEgressBlocked,
+	EventApprovalEscalated,
+	EventApprovalTimedOut,
+	EventRunApproved,
+	EventRunCanceled,
+	EventRunReconciled,
+	EventRunSummary,
+}
+
+func IsSubscribableEventType(eventType string) bool {
+	for _, t := range SubscribableEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
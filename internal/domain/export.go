@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Formats of a run export.
+const (
+	ExportFormatJSON = "json"
+	ExportFormatCSV  = "csv"
+)
+
+// Statuses of an export job.
+const (
+	ExportPending   = "PENDING"
+	ExportRunning   = "RUNNING"
+	ExportSucceeded = "SUCCEEDED"
+	ExportFailed    = "FAILED"
+)
+
+// ExportJobStaleAfter is how long a RUNNING export job may go unfinished
+// before another API instance takes it over, as after a crash.
+const ExportJobStaleAfter = 30 * time.Minute
+
+// ParseExportFormat reads a format name, case-insensitively; an empty one is
+// ExportFormatJSON.
+func ParseExportFormat(raw string) (string, error) {
+	switch format := strings.ToLower(strings.TrimSpace(raw)); format {
+	case "":
+		return ExportFormatJSON, nil
+	case ExportFormatJSON, ExportFormatCSV:
+		return format, nil
+	}
+	return "", ErrInvalidExportFormat
+}
+
+// RunExport is everything recorded about one run: the run, its steps with
+// their inputs and outputs, and its events in seq order.
+type RunExport struct {
+	Run    RunDetail      `json:"run"`
+	Steps  []ExportedStep `json:"steps"`
+	Events []EventRecord  `json:"events"`
+}
+
+// ExportedStep is a step as it appears in a RunExport.
+type ExportedStep struct {
+	ID           uuid.UUID       `json:"id"`
+	ParentStepID *uuid.UUID      `json:"parent_step_id,omitempty"`
+	Name         string          `json:"name"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	Input        json.RawMessage `json:"input,omitempty"`
+	Output       json.RawMessage `json:"output,omitempty"`
+	CostUSD      float64         `json:"cost_usd"`
+	CreatedAt    time.Time       `json:"created_at"`
+	StartedAt    *time.Time      `json:"started_at,omitempty"`
+	FinishedAt   *time.Time      `json:"finished_at,omitempty"`
+}
+
+// ExportJob is a tenant-wide export, built in the background. Its artifact
+// can be downloaded once Status is ExportSucceeded.
+type ExportJob struct {
+	ID             uuid.UUID  `json:"id"`
+	APIKeyID       uuid.UUID  `json:"api_key_id"`
+	Format         string     `json:"format"`
+	Status         string     `json:"status"`
+	RunsExported   int        `json:"runs_exported"`
+	ArtifactBytes  *int64     `json:"artifact_bytes,omitempty"`
+	ArtifactSHA256 *string    `json:"artifact_sha256,omitempty"`
+	Error          *string    `json:"error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseExportFormat(t *testing.T) {
+	for raw, want := range map[string]string{
+		"":       ExportFormatJSON,
+		"json":   ExportFormatJSON,
+		" CSV ":  ExportFormatCSV,
+		"Json\t": ExportFormatJSON,
+	} {
+		got, err := ParseExportFormat(raw)
+		if err != nil || got != want {
+			t.Fatalf("ParseExportFormat(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+
+	for _, raw := range []string{"xml", "ndjson", "csv,json"} {
+		if _, err := ParseExportFormat(raw); !errors.Is(err, ErrInvalidExportFormat) {
+			t.Fatalf("expected %q invalid, got %v", raw, err)
+		}
+	}
+}
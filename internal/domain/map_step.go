@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultMapParallelism caps running child steps of a MAP step when its
+	// template sets no map_parallelism.
+	DefaultMapParallelism = 4
+	// MaxMapItems bounds how many child steps one MAP step may create.
+	MaxMapItems = 1000
+)
+
+// MapStepConfig configures a MAP step: Items is the path of the array to fan
+// out over (usually an earlier step's output), Step the step type run once
+// per item, and Parallelism how many of those may run at once.
+type MapStepConfig struct {
+	Items       StepPath
+	Step        StepName
+	Parallelism int
+}
+
+// ParseMapStepConfig validates a MAP step's template columns. A non-positive
+// parallelism means DefaultMapParallelism.
+func ParseMapStepConfig(items, step string, parallelism int) (MapStepConfig, error) {
+	path, err := ParseStepPath(items)
+	if err != nil {
+		return MapStepConfig{}, fmt.Errorf("%w: map_items: %w", ErrInvalidMapStep, err)
+	}
+
+	name := StepName(strings.ToUpper(strings.TrimSpace(step)))
+	switch name {
+	case StepLLM, StepTool:
+	default:
+		return MapStepConfig{}, fmt.Errorf("%w: map_step must be %s or %s, got %q", ErrInvalidMapStep, StepLLM, StepTool, step)
+	}
+
+	if parallelism <= 0 {
+		parallelism = DefaultMapParallelism
+	}
+	return MapStepConfig{Items: path, Step: name, Parallelism: parallelism}, nil
+}
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseMapStepConfig(t *testing.T) {
+	cfg, err := ParseMapStepConfig("steps.LLM.output.items", "tool", 0)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if cfg.Step != StepTool || cfg.Parallelism != DefaultMapParallelism || cfg.Items.String() != "steps.LLM.output.items" {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	for name, tc := range map[string]struct {
+		items string
+		step  string
+	}{
+		"missing items":  {items: "", step: "LLM"},
+		"items not path": {items: `steps.LLM.output.items == 1`, step: "LLM"},
+		"bad root":       {items: "items", step: "LLM"},
+		"nested map":     {items: "steps.LLM.output.items", step: "MAP"},
+		"approval child": {items: "steps.LLM.output.items", step: "APPROVAL"},
+	} {
+		if _, err := ParseMapStepConfig(tc.items, tc.step, 2); !errors.Is(err, ErrInvalidMapStep) {
+			t.Fatalf("%s: expected ErrInvalidMapStep, got %v", name, err)
+		}
+	}
+}
+
+func TestStepPathLookup(t *testing.T) {
+	path, err := ParseStepPath(" steps.LLM.output.items ")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	ctx := map[string]any{
+		"steps": map[string]any{
+			"LLM": map[string]any{"output": map[string]any{"items": []any{"a", "b"}}},
+		},
+	}
+	if got := path.Lookup(ctx); !reflect.DeepEqual(got, []any{"a", "b"}) {
+		t.Fatalf("expected items, got %v", got)
+	}
+	if got := path.Lookup(map[string]any{}); got != nil {
+		t.Fatalf("expected nil for missing path, got %v", got)
+	}
+}
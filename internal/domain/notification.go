@@ -0,0 +1,32 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NotificationKind says what a human is being told about.
+type NotificationKind string
+
+const (
+	// NotificationApprovalWaiting: an APPROVAL step started waiting.
+	NotificationApprovalWaiting NotificationKind = "approval_waiting"
+	// NotificationRunFailed: a run failed.
+	NotificationRunFailed NotificationKind = "run_failed"
+)
+
+// Notification is one approval or failure the notifier reports. StepID and
+// ApprovalName are set for approvals, Error for failures when the failed step
+// recorded one. At is when the approval started waiting or the run failed.
+type Notification struct {
+	Kind         NotificationKind
+	RunID        uuid.UUID
+	APIKeyID     uuid.UUID
+	StepID       *uuid.UUID
+	ApprovalName string
+	Error        string
+	At           time.Time
+}
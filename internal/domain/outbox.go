@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OutboxRunCreated is the outbox message type for a new run. Every other
+// message carries the type of the event it mirrors.
+const OutboxRunCreated = "RUN_CREATED"
+
+// OutboxMessage is a run or step lifecycle message waiting to be published to
+// an external broker. It is published as this JSON document.
+type OutboxMessage struct {
+	Seq       int64           `json:"seq"`
+	ID        uuid.UUID       `json:"id"`
+	Type      string          `json:"type"`
+	APIKeyID  uuid.UUID       `json:"api_key_id"`
+	RunID     uuid.UUID       `json:"run_id"`
+	StepID    *uuid.UUID      `json:"step_id,omitempty"`
+	EventID   *uuid.UUID      `json:"event_id,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+	// Attempts counts earlier failed publishes.
+	Attempts int `json:"-"`
+}
+
+// RunCreatedMessage is the payload of a RUN_CREATED message.
+type RunCreatedMessage struct {
+	Status       RunStatus         `json:"status"`
+	TemplateName string            `json:"template_name"`
+	Priority     int               `json:"priority"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	Tags         []string          `json:"tags"`
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const PurgeReportSignatureAlgorithm = "HMAC-SHA256"
+
+// PurgeCounts records how many rows of each kind a tenant purge deleted.
+type PurgeCounts struct {
+	Runs              int64 `json:"runs"`
+	Steps             int64 `json:"steps"`
+	Events            int64 `json:"events"`
+	StepLogs          int64 `json:"step_logs"`
+	RunRequests       int64 `json:"run_requests"`
+	WebhookDeliveries int64 `json:"webhook_deliveries"`
+	OutboxMessages    int64 `json:"outbox_messages"`
+	ArchivedRuns      int64 `json:"archived_runs"`
+	ExportJobs        int64 `json:"export_jobs"`
+	UsageRecords      int64 `json:"usage_records"`
+}
+
+// TenantPurgeReport is the deletion record produced by a tenant purge.
+type TenantPurgeReport struct {
+	ID          uuid.UUID   `json:"id"`
+	APIKeyID    uuid.UUID   `json:"api_key_id"`
+	StartedAt   time.Time   `json:"started_at"`
+	CompletedAt time.Time   `json:"completed_at"`
+	Deleted     PurgeCounts `json:"deleted"`
+}
+
+type SignedPurgeReport struct {
+	Report             TenantPurgeReport `json:"report"`
+	Signature          string            `json:"signature"`
+	SignatureAlgorithm string            `json:"signature_algorithm"`
+}
+
+// Sign returns the hex HMAC-SHA256 of the report's JSON encoding. The same
+// encoding is what gets persisted and returned, so a verifier can recompute
+// the signature over the "report" object as received.
+func (r TenantPurgeReport) Sign(key []byte) (string, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (s SignedPurgeReport) Verify(key []byte) bool {
+	want, err := s.Report.Sign(key)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(want), []byte(s.Signature))
+}
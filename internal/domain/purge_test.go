@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSignedPurgeReportVerify(t *testing.T) {
+	key := []byte("report-key")
+	report := TenantPurgeReport{
+		ID:          uuid.New(),
+		APIKeyID:    uuid.New(),
+		StartedAt:   time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		CompletedAt: time.Date(2026, 3, 1, 12, 0, 1, 0, time.UTC),
+		Deleted:     PurgeCounts{Runs: 2, Steps: 6, Events: 10},
+	}
+
+	sig, err := report.Sign(key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	signed := SignedPurgeReport{Report: report, Signature: sig, SignatureAlgorithm: PurgeReportSignatureAlgorithm}
+
+	if !signed.Verify(key) {
+		t.Fatal("expected signature to verify with signing key")
+	}
+	if signed.Verify([]byte("other-key")) {
+		t.Fatal("expected signature not to verify with another key")
+	}
+
+	signed.Report.Deleted.Runs = 1
+	if signed.Verify(key) {
+		t.Fatal("expected tampered report not to verify")
+	}
+}
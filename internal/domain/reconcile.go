@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import "time"
+
+// RunReconciliation is the RUN_RECONCILED event payload: the status a stale run
+// had, the status recomputed from its steps, and when it was last updated.
+type RunReconciliation struct {
+	From          RunStatus `json:"from"`
+	To            RunStatus `json:"to"`
+	LastUpdatedAt time.Time `json:"last_updated_at"`
+}
+
+// StepOutcome is the part of a step that decides its run's status.
+type StepOutcome struct {
+	Status    StepStatus
+	OnFailure OnFailurePolicy
+}
+
+// ReconcileRunStatus recomputes a run's status from its steps. It reports
+// false while any step is still PENDING, RUNNING, or WAITING_APPROVAL. Once
+// every step has stopped, a CANCELED step makes the run CANCELED, a FAILED
+// step outside the continue policy makes it FAILED, and otherwise it is
+// SUCCEEDED.
+func ReconcileRunStatus(steps []StepOutcome) (RunStatus, bool) {
+	status := RunSuccess
+	for _, step := range steps {
+		switch {
+		case !step.Status.IsTerminal():
+			return "", false
+		case step.Status == StepCanceled:
+			status = RunCanceled
+		case step.Status == StepFailed && step.OnFailure != OnFailureContinue && status != RunCanceled:
+			status = RunFailed
+		}
+	}
+	return status, true
+}
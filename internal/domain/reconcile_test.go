@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import "testing"
+
+func TestReconcileRunStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		steps  []StepOutcome
+		want   RunStatus
+		wantOK bool
+	}{
+		{
+			name:   "all succeeded",
+			steps:  []StepOutcome{{Status: StepSuccess}, {Status: StepSkipped}, {Status: StepSuccess}},
+			want:   RunSuccess,
+			wantOK: true,
+		},
+		{
+			name:   "all skipped",
+			steps:  []StepOutcome{{Status: StepSkipped}, {Status: StepSkipped}},
+			want:   RunSuccess,
+			wantOK: true,
+		},
+		{
+			name:   "failed under continue",
+			steps:  []StepOutcome{{Status: StepFailed, OnFailure: OnFailureContinue}, {Status: StepSuccess}},
+			want:   RunSuccess,
+			wantOK: true,
+		},
+		{
+			name:   "failed under fail_run",
+			steps:  []StepOutcome{{Status: StepSuccess}, {Status: StepFailed, OnFailure: OnFailureFailRun}},
+			want:   RunFailed,
+			wantOK: true,
+		},
+		{
+			name:   "canceled wins over failed",
+			steps:  []StepOutcome{{Status: StepFailed, OnFailure: OnFailureFailRun}, {Status: StepCanceled}},
+			want:   RunCanceled,
+			wantOK: true,
+		},
+		{
+			name:  "running step",
+			steps: []StepOutcome{{Status: StepSuccess}, {Status: StepRunning}},
+		},
+		{
+			name:  "waiting approval",
+			steps: []StepOutcome{{Status: StepSuccess}, {Status: StepWaiting}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := ReconcileRunStatus(tc.steps)
+			if ok != tc.wantOK || got != tc.want {
+				t.Fatalf("expected (%q, %v) got (%q, %v)", tc.want, tc.wantOK, got, ok)
+			}
+		})
+	}
+}
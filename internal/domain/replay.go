@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// MaxStepInputBytes bounds one step's input override.
+const MaxStepInputBytes = 64 << 10
+
+// reservedStepInputKeys are set by the worker on every claimed step and
+// cannot be overridden.
+var reservedStepInputKeys = []string{"step", "claimedAt", "reclaimed", "item"}
+
+// ReplayRunRequest is the body of POST /runs/{id}/replay. StepInputs
+// overrides the input of the template steps at the given positions, counted
+// from 0; each override is a JSON object merged into the step's input.
+type ReplayRunRequest struct {
+	StepInputs map[int]json.RawMessage `json:"step_inputs"`
+}
+
+// NormalizeStepInputs validates step input overrides and compacts them.
+// Positions past the template's last step are rejected when the run is
+// created, once the template is known.
+func NormalizeStepInputs(inputs map[int]json.RawMessage) (map[int]json.RawMessage, error) {
+	if len(inputs) == 0 {
+		return nil, nil
+	}
+
+	out := make(map[int]json.RawMessage, len(inputs))
+	for position, raw := range inputs {
+		if position < 0 {
+			return nil, fmt.Errorf("%w: position %d", ErrInvalidStepInput, position)
+		}
+		if len(raw) > MaxStepInputBytes {
+			return nil, fmt.Errorf("%w: position %d exceeds %d bytes", ErrInvalidStepInput, position, MaxStepInputBytes)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+			return nil, fmt.Errorf("%w: position %d must be a JSON object", ErrInvalidStepInput, position)
+		}
+		for _, key := range reservedStepInputKeys {
+			if _, ok := fields[key]; ok {
+				return nil, fmt.Errorf("%w: position %d sets reserved key %q", ErrInvalidStepInput, position, key)
+			}
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil {
+			return nil, fmt.Errorf("%w: position %d must be a JSON object", ErrInvalidStepInput, position)
+		}
+		out[position] = compact.Bytes()
+	}
+	return out, nil
+}
+
+// MergeStepInputs layers overrides over base, position by position; an
+// override replaces the keys it sets and keeps the others.
+func MergeStepInputs(base, overrides map[int]json.RawMessage) (map[int]json.RawMessage, error) {
+	if len(base) == 0 {
+		return overrides, nil
+	}
+
+	out := make(map[int]json.RawMessage, len(base)+len(overrides))
+	for position, raw := range base {
+		out[position] = raw
+	}
+	for position, raw := range overrides {
+		prev, ok := out[position]
+		if !ok {
+			out[position] = raw
+			continue
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(prev, &fields); err != nil {
+			return nil, err
+		}
+		var next map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &next); err != nil {
+			return nil, err
+		}
+		for k, v := range next {
+			fields[k] = v
+		}
+		merged, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		out[position] = merged
+	}
+	return out, nil
+}
@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestNormalizeStepInputs(t *testing.T) {
+	got, err := NormalizeStepInputs(map[int]json.RawMessage{1: json.RawMessage(`{ "prompt": "hi" }`)})
+	if err != nil {
+		t.Fatalf("NormalizeStepInputs: %v", err)
+	}
+	if string(got[1]) != `{"prompt":"hi"}` {
+		t.Fatalf("input = %s, want compacted object", got[1])
+	}
+
+	if got, err := NormalizeStepInputs(nil); err != nil || got != nil {
+		t.Fatalf("NormalizeStepInputs(nil) = %v, %v", got, err)
+	}
+
+	for name, inputs := range map[string]map[int]json.RawMessage{
+		"negative position": {-1: json.RawMessage(`{}`)},
+		"array":             {0: json.RawMessage(`[1]`)},
+		"null":              {0: json.RawMessage(`null`)},
+		"reserved key":      {0: json.RawMessage(`{"step":"TOOL"}`)},
+	} {
+		if _, err := NormalizeStepInputs(inputs); !errors.Is(err, ErrInvalidStepInput) {
+			t.Fatalf("%s: err = %v, want ErrInvalidStepInput", name, err)
+		}
+	}
+}
+
+func TestMergeStepInputs(t *testing.T) {
+	base := map[int]json.RawMessage{
+		0: json.RawMessage(`{"prompt":"a","model":"m"}`),
+		2: json.RawMessage(`{"limit":3}`),
+	}
+	got, err := MergeStepInputs(base, map[int]json.RawMessage{
+		0: json.RawMessage(`{"prompt":"b"}`),
+		1: json.RawMessage(`{"q":"x"}`),
+	})
+	if err != nil {
+		t.Fatalf("MergeStepInputs: %v", err)
+	}
+
+	want := map[int]string{
+		0: `{"model":"m","prompt":"b"}`,
+		1: `{"q":"x"}`,
+		2: `{"limit":3}`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("merged = %v, want %v", got, want)
+	}
+	for position, input := range want {
+		if string(got[position]) != input {
+			t.Fatalf("position %d = %s, want %s", position, got[position], input)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RunRetentionMode decides what happens to terminal runs past retention.
+type RunRetentionMode string
+
+const (
+	// RunRetentionArchive copies the run, its steps, and its events into
+	// run_archive before deleting them from the hot tables.
+	RunRetentionArchive RunRetentionMode = "archive"
+	// RunRetentionDelete deletes them outright.
+	RunRetentionDelete RunRetentionMode = "delete"
+)
+
+// ParseRunRetentionMode accepts "archive" or "delete"; empty means archive.
+func ParseRunRetentionMode(raw string) (RunRetentionMode, error) {
+	switch mode := RunRetentionMode(strings.ToLower(strings.TrimSpace(raw))); mode {
+	case "":
+		return RunRetentionArchive, nil
+	case RunRetentionArchive, RunRetentionDelete:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported run retention mode %q (want %q or %q)", raw, RunRetentionArchive, RunRetentionDelete)
+	}
+}
+
+// EffectiveRunRetentionDays resolves a tenant's run retention. A per-key
+// override wins over the deployment default; 0 means runs are kept forever.
+func EffectiveRunRetentionDays(override *int, defaultDays int) int {
+	if override != nil && *override > 0 {
+		return *override
+	}
+	if defaultDays < 0 {
+		return 0
+	}
+	return defaultDays
+}
+
+// ValidateRunRetentionDays accepts nil (inherit the default) or 1..MaxRunRetentionDays.
+func ValidateRunRetentionDays(days *int) error {
+	if days == nil {
+		return nil
+	}
+	if *days <= 0 || *days > MaxRunRetentionDays {
+		return ErrInvalidRunRetention
+	}
+	return nil
+}
+
+// RunRetentionPolicy is the deployment-wide run retention: DefaultDays
+// applies to keys without an override, Mode picks archive or delete, and
+// DryRun only counts the runs that would go.
+type RunRetentionPolicy struct {
+	DefaultDays int
+	Mode        RunRetentionMode
+	DryRun      bool
+}
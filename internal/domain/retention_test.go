@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRunRetentionMode(t *testing.T) {
+	tests := map[string]RunRetentionMode{
+		"":         RunRetentionArchive,
+		"archive":  RunRetentionArchive,
+		" DELETE ": RunRetentionDelete,
+		"delete":   RunRetentionDelete,
+	}
+	for raw, want := range tests {
+		got, err := ParseRunRetentionMode(raw)
+		if err != nil || got != want {
+			t.Fatalf("ParseRunRetentionMode(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+	if _, err := ParseRunRetentionMode("truncate"); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+}
+
+func TestEffectiveRunRetentionDays(t *testing.T) {
+	override := 7
+	if got := EffectiveRunRetentionDays(&override, 90); got != 7 {
+		t.Fatalf("expected override to win, got %d", got)
+	}
+	if got := EffectiveRunRetentionDays(nil, 90); got != 90 {
+		t.Fatalf("expected default when no override, got %d", got)
+	}
+	if got := EffectiveRunRetentionDays(nil, -1); got != 0 {
+		t.Fatalf("expected 0 (keep forever) for a negative default, got %d", got)
+	}
+}
+
+func TestValidateRunRetentionDays(t *testing.T) {
+	if err := ValidateRunRetentionDays(nil); err != nil {
+		t.Fatalf("expected nil retention to be valid, got %v", err)
+	}
+	for _, days := range []int{0, -1, MaxRunRetentionDays + 1} {
+		d := days
+		if err := ValidateRunRetentionDays(&d); !errors.Is(err, ErrInvalidRunRetention) {
+			t.Fatalf("expected ErrInvalidRunRetention for %d, got %v", days, err)
+		}
+	}
+}
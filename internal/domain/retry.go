@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+// RetryBackoff is how the delay before a step's next attempt grows.
+type RetryBackoff string
+
+const (
+	// RetryBackoffExponential doubles the delay per attempt (the default).
+	RetryBackoffExponential RetryBackoff = "exponential"
+	// RetryBackoffLinear adds the base delay per attempt.
+	RetryBackoffLinear RetryBackoff = "linear"
+	// RetryBackoffFixed waits the base delay every time.
+	RetryBackoffFixed RetryBackoff = "fixed"
+)
+
+// ParseRetryBackoff accepts exponential, linear, or fixed; empty means
+// exponential.
+func ParseRetryBackoff(v string) (RetryBackoff, error) {
+	switch b := RetryBackoff(strings.ToLower(strings.TrimSpace(v))); b {
+	case "":
+		return RetryBackoffExponential, nil
+	case RetryBackoffExponential, RetryBackoffLinear, RetryBackoffFixed:
+		return b, nil
+	default:
+		return "", ErrInvalidRetryPolicy
+	}
+}
+
+// RetryPolicy decides whether and when a failed step is retried. Template
+// steps may override any field of the worker-wide policy.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	Backoff     RetryBackoff
+	// Jitter randomizes each delay between half and all of it, so steps that
+	// failed together do not retry together.
+	Jitter bool
+	// Priority is added to the step's priority boost on every retry: positive
+	// values move retries ahead of new work, negative ones behind it.
+	Priority int
+}
+
+// MaxRetryPriority bounds the per-retry priority change, and
+// MaxPriorityBoost the boost it accumulates.
+const (
+	MaxRetryPriority = 100
+	MaxPriorityBoost = 1000
+)
+
+// ValidateRetryPriority checks a per-retry priority change.
+func ValidateRetryPriority(p int) error {
+	if p < -MaxRetryPriority || p > MaxRetryPriority {
+		return ErrInvalidRetryPolicy
+	}
+	return nil
+}
+
+// NextPriorityBoost returns a step's priority boost after one more retry
+// under p, kept within ±MaxPriorityBoost.
+func (p RetryPolicy) NextPriorityBoost(boost int) int {
+	return min(max(boost+p.Priority, -MaxPriorityBoost), MaxPriorityBoost)
+}
+
+// Delay returns the delay before the next attempt after attempts failed ones,
+// without jitter: BaseDelay·2^attempts for exponential, BaseDelay·attempts for
+// linear, and BaseDelay for fixed. It saturates instead of overflowing.
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	base := p.BaseDelay
+	if base <= 0 {
+		base = 2 * time.Second
+	}
+	if attempts <= 0 {
+		return base
+	}
+
+	const maxDuration = time.Duration(1<<63 - 1)
+	switch p.Backoff {
+	case RetryBackoffFixed:
+		return base
+	case RetryBackoffLinear:
+		if base > maxDuration/time.Duration(attempts) {
+			return maxDuration
+		}
+		return base * time.Duration(attempts)
+	default:
+		delay := base
+		for i := 0; i < attempts; i++ {
+			if delay > maxDuration/2 {
+				return maxDuration
+			}
+			delay *= 2
+		}
+		return delay
+	}
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseRetryBackoff(t *testing.T) {
+	for raw, want := range map[string]RetryBackoff{
+		"":            RetryBackoffExponential,
+		"linear":      RetryBackoffLinear,
+		" Fixed ":     RetryBackoffFixed,
+		"EXPONENTIAL": RetryBackoffExponential,
+	} {
+		got, err := ParseRetryBackoff(raw)
+		if err != nil || got != want {
+			t.Fatalf("parse %q: expected %s got %s (err=%v)", raw, want, got, err)
+		}
+	}
+
+	if _, err := ParseRetryBackoff("random"); !errors.Is(err, ErrInvalidRetryPolicy) {
+		t.Fatalf("expected ErrInvalidRetryPolicy, got %v", err)
+	}
+}
+
+func TestRetryPolicyNextPriorityBoost(t *testing.T) {
+	tests := []struct {
+		name  string
+		p     int
+		boost int
+		want  int
+	}{
+		{"boost", 10, 5, 15},
+		{"demote", -10, 5, -5},
+		{"unset", 0, 7, 7},
+		{"capped", 100, MaxPriorityBoost - 1, MaxPriorityBoost},
+		{"floored", -100, -MaxPriorityBoost + 1, -MaxPriorityBoost},
+	}
+	for _, tc := range tests {
+		if got := (RetryPolicy{Priority: tc.p}).NextPriorityBoost(tc.boost); got != tc.want {
+			t.Fatalf("%s: expected %d, got %d", tc.name, tc.want, got)
+		}
+	}
+
+	if err := ValidateRetryPriority(MaxRetryPriority + 1); !errors.Is(err, ErrInvalidRetryPolicy) {
+		t.Fatalf("expected ErrInvalidRetryPolicy, got %v", err)
+	}
+	if err := ValidateRetryPriority(-MaxRetryPriority); err != nil {
+		t.Fatalf("expected -%d to be valid, got %v", MaxRetryPriority, err)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		attempts int
+		want     time.Duration
+	}{
+		{"exponential", RetryPolicy{BaseDelay: time.Second, Backoff: RetryBackoffExponential}, 3, 8 * time.Second},
+		{"unset backoff is exponential", RetryPolicy{BaseDelay: time.Second}, 1, 2 * time.Second},
+		{"linear", RetryPolicy{BaseDelay: time.Second, Backoff: RetryBackoffLinear}, 3, 3 * time.Second},
+		{"fixed", RetryPolicy{BaseDelay: time.Second, Backoff: RetryBackoffFixed}, 3, time.Second},
+		{"default base", RetryPolicy{}, 0, 2 * time.Second},
+		{"exponential saturates", RetryPolicy{BaseDelay: time.Second}, 200, time.Duration(1<<63 - 1)},
+		{"linear saturates", RetryPolicy{BaseDelay: time.Duration(1 << 62), Backoff: RetryBackoffLinear}, 4, time.Duration(1<<63 - 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Delay(tt.attempts); got != tt.want {
+				t.Fatalf("expected %s got %s", tt.want, got)
+			}
+		})
+	}
+}
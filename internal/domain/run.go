@@ -2,6 +2,15 @@
 
 package domain
 
+import (
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
 type RunStatus string
 
 const (
@@ -13,8 +22,55 @@ const (
 	RunCanceled RunStatus = "CANCELED"
 )
 
+// Default cancel reasons, used when a cancel request gives none.
+const (
+	CancelReasonUserRequest  = "user_request"
+	CancelReasonAdminRequest = "admin_request"
+)
+
+// MaxCancelReasonLength bounds the reason stored on a canceled run.
+const MaxCancelReasonLength = 500
+
+// NormalizeCancelReason trims a cancel reason, returning fallback when it is
+// empty. Reasons longer than MaxCancelReasonLength characters are rejected.
+func NormalizeCancelReason(reason, fallback string) (string, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return fallback, nil
+	}
+	if utf8.RuneCountInString(reason) > MaxCancelReasonLength {
+		return "", ErrInvalidCancelReason
+	}
+	return reason, nil
+}
+
 type CreateRunParams struct {
-	WebhookURL   string
-	Priority     int
-	TemplateName string
+	WebhookURL    string
+	WebhookSecret string
+	WebhookEvents []string
+	Priority      int
+	TemplateName  string
+	// TemplateVersion pins a version of the template; nil means its latest.
+	TemplateVersion *int
+	Metadata        map[string]string
+	Tags            []string
+	// ReplayedFromRunID links a replay to the run it repeats, and StepInputs
+	// overrides the input of template steps by position; both are only set
+	// by replays.
+	ReplayedFromRunID *uuid.UUID
+	StepInputs        map[int]json.RawMessage
+}
+
+// CreatedRun is the result of submitting a run. WebhookSecret is only set when
+// the runtime generated the secret, so it can be shown to the caller once.
+type CreatedRun struct {
+	ID            uuid.UUID
+	WebhookSecret string
+}
+
+// RunVersion changes whenever a run or one of its steps does: run status
+// writes bump the run's updated_at, and step transitions record events.
+type RunVersion struct {
+	UpdatedAt    time.Time
+	LastEventSeq int64
 }
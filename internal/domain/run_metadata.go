@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	MaxRunMetadataKeys        = 50
+	MaxRunMetadataKeyLength   = 64
+	MaxRunMetadataValueLength = 512
+	MaxRunTags                = 20
+	MaxRunTagLength           = 64
+
+	DefaultRunPageSize = 50
+	MaxRunPageSize     = 500
+)
+
+// NormalizeRunMetadata trims keys and checks the size limits. Values are kept
+// as given so callers can round-trip them exactly; an empty map becomes nil.
+func NormalizeRunMetadata(metadata map[string]string) (map[string]string, error) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	if len(metadata) > MaxRunMetadataKeys {
+		return nil, fmt.Errorf("%w: at most %d keys", ErrInvalidRunMetadata, MaxRunMetadataKeys)
+	}
+
+	out := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		key = strings.TrimSpace(key)
+		if key == "" || utf8.RuneCountInString(key) > MaxRunMetadataKeyLength {
+			return nil, fmt.Errorf("%w: keys must be 1-%d characters", ErrInvalidRunMetadata, MaxRunMetadataKeyLength)
+		}
+		if utf8.RuneCountInString(value) > MaxRunMetadataValueLength {
+			return nil, fmt.Errorf("%w: value of %q exceeds %d characters", ErrInvalidRunMetadata, key, MaxRunMetadataValueLength)
+		}
+		if _, dup := out[key]; dup {
+			return nil, fmt.Errorf("%w: duplicate key %q", ErrInvalidRunMetadata, key)
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+// NormalizeRunTags trims, de-duplicates, and sorts tags. Tags are
+// case-sensitive and may not contain commas or whitespace.
+func NormalizeRunTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]struct{}, len(tags))
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || utf8.RuneCountInString(tag) > MaxRunTagLength || strings.ContainsAny(tag, ", \t\r\n") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRunTag, tag)
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		out = append(out, tag)
+	}
+	if len(out) > MaxRunTags {
+		return nil, fmt.Errorf("%w: at most %d tags", ErrInvalidRunTag, MaxRunTags)
+	}
+
+	sort.Strings(out)
+	return out, nil
+}
+
+// RunFilter narrows GET /runs. A run matches when it has every tag in Tags
+// and every key in Metadata with exactly that value. BeforeID pages past the
+// run with that ID in newest-first order.
+type RunFilter struct {
+	Status   RunStatus
+	Tags     []string
+	Metadata map[string]string
+	BeforeID *uuid.UUID
+	Limit    int
+}
+
+// RunListItem is a run as returned by GET /runs.
+type RunListItem struct {
+	ID           uuid.UUID         `json:"id"`
+	Status       RunStatus         `json:"status"`
+	Priority     int               `json:"priority"`
+	Metadata     map[string]string `json:"metadata"`
+	Tags         []string          `json:"tags"`
+	TotalCostUSD float64           `json:"total_cost_usd"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// AdminRunListItem is a run as returned by GET /admin/runs, with the tenant
+// it belongs to.
+type AdminRunListItem struct {
+	APIKeyID uuid.UUID `json:"api_key_id"`
+	RunListItem
+}
+
+// RunDetail is a run with its tenant, template and pinned template version,
+// cancel reason, and the run it replays, as returned by GET /admin/runs/{id}
+// and in run exports.
+type RunDetail struct {
+	AdminRunListItem
+	TemplateName      *string    `json:"template_name,omitempty"`
+	TemplateVersion   *int       `json:"template_version,omitempty"`
+	CancelReason      *string    `json:"cancel_reason,omitempty"`
+	ReplayedFromRunID *uuid.UUID `json:"replayed_from_run_id,omitempty"`
+}
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeRunMetadata(t *testing.T) {
+	got, err := NormalizeRunMetadata(map[string]string{" customer ": "42", "region": " eu "})
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	want := map[string]string{"customer": "42", "region": " eu "}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+
+	if got, err := NormalizeRunMetadata(map[string]string{}); err != nil || got != nil {
+		t.Fatalf("expected nil metadata for empty input, got %v (%v)", got, err)
+	}
+
+	tooMany := make(map[string]string, MaxRunMetadataKeys+1)
+	for i := range MaxRunMetadataKeys + 1 {
+		tooMany[fmt.Sprintf("k%d", i)] = "v"
+	}
+	for name, metadata := range map[string]map[string]string{
+		"empty key":      {" ": "v"},
+		"long key":       {strings.Repeat("k", MaxRunMetadataKeyLength+1): "v"},
+		"long value":     {"k": strings.Repeat("v", MaxRunMetadataValueLength+1)},
+		"colliding keys": {"k": "a", " k": "b"},
+		"too many keys":  tooMany,
+	} {
+		if _, err := NormalizeRunMetadata(metadata); !errors.Is(err, ErrInvalidRunMetadata) {
+			t.Fatalf("%s: expected ErrInvalidRunMetadata, got %v", name, err)
+		}
+	}
+}
+
+func TestNormalizeRunTags(t *testing.T) {
+	got, err := NormalizeRunTags([]string{"nightly", " billing", "nightly", "Nightly"})
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	want := []string{"Nightly", "billing", "nightly"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+
+	if got, err := NormalizeRunTags(nil); err != nil || got != nil {
+		t.Fatalf("expected nil tags for empty input, got %v (%v)", got, err)
+	}
+
+	tooMany := make([]string, MaxRunTags+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("t%d", i)
+	}
+	for name, tags := range map[string][]string{
+		"empty":         {" "},
+		"whitespace":    {"two words"},
+		"comma":         {"a,b"},
+		"long":          {strings.Repeat("t", MaxRunTagLength+1)},
+		"too many tags": tooMany,
+	} {
+		if _, err := NormalizeRunTags(tags); !errors.Is(err, ErrInvalidRunTag) {
+			t.Fatalf("%s: expected ErrInvalidRunTag, got %v", name, err)
+		}
+	}
+}
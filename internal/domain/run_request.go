@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// CreateRunRequest is the body of a run creation request, whether it arrives
+// as POST /runs or as an ingestion command.
+type CreateRunRequest struct {
+	WebhookURL    string            `json:"webhook_url"`
+	WebhookSecret string            `json:"webhook_secret"`
+	WebhookEvents []string          `json:"webhook_events"`
+	Priority      int               `json:"priority"`
+	TemplateName  string            `json:"template_name"`
+	Metadata      map[string]string `json:"metadata"`
+	Tags          []string          `json:"tags"`
+	// TemplateVersion pins an earlier version of the template; runs use the
+	// latest version by default.
+	TemplateVersion *int `json:"template_version"`
+}
+
+// Params validates and normalizes the request into the parameters of the run
+// it asks for.
+func (r CreateRunRequest) Params() (CreateRunParams, error) {
+	params := CreateRunParams{
+		WebhookURL:    strings.TrimSpace(r.WebhookURL),
+		WebhookSecret: r.WebhookSecret,
+		Priority:      r.Priority,
+		TemplateName:  strings.TrimSpace(r.TemplateName),
+	}
+
+	if r.TemplateVersion != nil {
+		if *r.TemplateVersion < 1 {
+			return CreateRunParams{}, ErrInvalidTemplateVersion
+		}
+		version := *r.TemplateVersion
+		params.TemplateVersion = &version
+	}
+
+	var err error
+	if params.WebhookEvents, err = NormalizeWebhookEvents(r.WebhookEvents); err != nil {
+		return CreateRunParams{}, err
+	}
+	if params.Metadata, err = NormalizeRunMetadata(r.Metadata); err != nil {
+		return CreateRunParams{}, err
+	}
+	if params.Tags, err = NormalizeRunTags(r.Tags); err != nil {
+		return CreateRunParams{}, err
+	}
+	if err := ValidateWebhookSecret(params.WebhookSecret); err != nil {
+		return CreateRunParams{}, err
+	}
+
+	if params.WebhookURL == "" {
+		if len(params.WebhookEvents) > 0 {
+			return CreateRunParams{}, errors.New("webhook_events requires webhook_url")
+		}
+		return params, nil
+	}
+	if err := ValidateWebhookURL(params.WebhookURL); err != nil {
+		return CreateRunParams{}, err
+	}
+	return params, nil
+}
+
+// ValidateWebhookURL accepts absolute http and https URLs.
+func ValidateWebhookURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return errors.New("invalid webhook_url")
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return errors.New("unsupported webhook_url scheme")
+	}
+	return nil
+}
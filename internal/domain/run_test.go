@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeCancelReason(t *testing.T) {
+	got, err := NormalizeCancelReason("  deploy rolled back ", CancelReasonUserRequest)
+	if err != nil || got != "deploy rolled back" {
+		t.Fatalf("expected trimmed reason, got %q, %v", got, err)
+	}
+
+	got, err = NormalizeCancelReason(" ", CancelReasonAdminRequest)
+	if err != nil || got != CancelReasonAdminRequest {
+		t.Fatalf("expected fallback reason, got %q, %v", got, err)
+	}
+
+	if _, err := NormalizeCancelReason(strings.Repeat("é", MaxCancelReasonLength), CancelReasonUserRequest); err != nil {
+		t.Fatalf("expected reason at the limit to be valid, got %v", err)
+	}
+	if _, err := NormalizeCancelReason(strings.Repeat("x", MaxCancelReasonLength+1), CancelReasonUserRequest); !errors.Is(err, ErrInvalidCancelReason) {
+		t.Fatalf("expected ErrInvalidCancelReason, got %v", err)
+	}
+}
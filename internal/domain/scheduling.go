@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Bounds of api_keys.scheduling_weight. Every key starts at
+// DefaultSchedulingWeight, so shared workers serve tenants evenly until an
+// admin says otherwise.
+const (
+	DefaultSchedulingWeight = 1
+	MaxSchedulingWeight     = 1000
+)
+
+// ValidateSchedulingWeight accepts a weight from 1 to MaxSchedulingWeight.
+func ValidateSchedulingWeight(weight int) error {
+	if weight < 1 || weight > MaxSchedulingWeight {
+		return ErrInvalidSchedulingWeight
+	}
+	return nil
+}
+
+// TenantScheduling is a tenant's scheduling weight next to the steps workers
+// have claimed for it, as returned by GET /admin/scheduling. WeightShare and
+// ClaimShare are fractions of the totals over the listed tenants, so a
+// tenant kept busy in shared mode has a ClaimShare near its WeightShare.
+type TenantScheduling struct {
+	APIKeyID         uuid.UUID  `json:"api_key_id"`
+	Name             string     `json:"name"`
+	Slug             *string    `json:"slug"`
+	SchedulingWeight int        `json:"scheduling_weight"`
+	WeightShare      float64    `json:"weight_share"`
+	Claims           int64      `json:"claims"`
+	ClaimShare       float64    `json:"claim_share"`
+	LastClaimedAt    *time.Time `json:"last_claimed_at"`
+}
+
+// FillSchedulingShares sets WeightShare and ClaimShare of each tenant.
+func FillSchedulingShares(tenants []TenantScheduling) {
+	var weights, claims int64
+	for _, t := range tenants {
+		weights += int64(t.SchedulingWeight)
+		claims += t.Claims
+	}
+	for i := range tenants {
+		if weights > 0 {
+			tenants[i].WeightShare = float64(tenants[i].SchedulingWeight) / float64(weights)
+		}
+		if claims > 0 {
+			tenants[i].ClaimShare = float64(tenants[i].Claims) / float64(claims)
+		}
+	}
+}
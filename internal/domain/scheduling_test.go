@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateSchedulingWeight(t *testing.T) {
+	for _, weight := range []int{1, 10, MaxSchedulingWeight} {
+		if err := ValidateSchedulingWeight(weight); err != nil {
+			t.Fatalf("expected weight %d valid, got %v", weight, err)
+		}
+	}
+	for _, weight := range []int{-1, 0, MaxSchedulingWeight + 1} {
+		if err := ValidateSchedulingWeight(weight); !errors.Is(err, ErrInvalidSchedulingWeight) {
+			t.Fatalf("expected weight %d invalid, got %v", weight, err)
+		}
+	}
+}
+
+func TestFillSchedulingShares(t *testing.T) {
+	tenants := []TenantScheduling{
+		{SchedulingWeight: 3, Claims: 30},
+		{SchedulingWeight: 1, Claims: 10},
+	}
+	FillSchedulingShares(tenants)
+
+	if tenants[0].WeightShare != 0.75 || tenants[1].WeightShare != 0.25 {
+		t.Fatalf("unexpected weight shares %v and %v", tenants[0].WeightShare, tenants[1].WeightShare)
+	}
+	if tenants[0].ClaimShare != 0.75 || tenants[1].ClaimShare != 0.25 {
+		t.Fatalf("unexpected claim shares %v and %v", tenants[0].ClaimShare, tenants[1].ClaimShare)
+	}
+
+	idle := []TenantScheduling{{SchedulingWeight: 2}}
+	FillSchedulingShares(idle)
+	if idle[0].WeightShare != 1 || idle[0].ClaimShare != 0 {
+		t.Fatalf("expected full weight share and no claims, got %+v", idle[0])
+	}
+}
@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// MaxSecretNameLength bounds secret names.
+	MaxSecretNameLength = 128
+	// MaxSecretValueBytes bounds a secret's value.
+	MaxSecretValueBytes = 16 << 10
+	// MaxStepSecrets bounds the secrets one template step references.
+	MaxStepSecrets = 32
+)
+
+// Secret is a named secret without its value, which is never returned once
+// stored.
+type Secret struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PutSecretRequest is the body of PUT /admin/secrets/{name}.
+type PutSecretRequest struct {
+	Value string `json:"value"`
+}
+
+// ValidateSecretName checks name can be used as an environment variable:
+// an ASCII letter or '_' followed by letters, digits, or '_', at most
+// MaxSecretNameLength long.
+func ValidateSecretName(name string) error {
+	if name == "" || len(name) > MaxSecretNameLength {
+		return fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidSecret, MaxSecretNameLength)
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return fmt.Errorf("%w: name %q must be letters, digits, and '_', not starting with a digit", ErrInvalidSecret, name)
+		}
+	}
+	return nil
+}
+
+// ValidateSecretValue accepts a non-empty value of at most
+// MaxSecretValueBytes.
+func ValidateSecretValue(value string) error {
+	if value == "" || len(value) > MaxSecretValueBytes {
+		return fmt.Errorf("%w: value must be 1 to %d bytes", ErrInvalidSecret, MaxSecretValueBytes)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateSecretName(t *testing.T) {
+	for _, name := range []string{"OPENAI_API_KEY", "_token", "a1"} {
+		if err := ValidateSecretName(name); err != nil {
+			t.Fatalf("%q: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "1TOKEN", "has-dash", "A=B", strings.Repeat("A", MaxSecretNameLength+1)} {
+		if err := ValidateSecretName(name); !errors.Is(err, ErrInvalidSecret) {
+			t.Fatalf("%q: err = %v, want ErrInvalidSecret", name, err)
+		}
+	}
+}
+
+func TestValidateSecretValue(t *testing.T) {
+	if err := ValidateSecretValue("v"); err != nil {
+		t.Fatalf("ValidateSecretValue: %v", err)
+	}
+	for _, value := range []string{"", strings.Repeat("v", MaxSecretValueBytes+1)} {
+		if err := ValidateSecretValue(value); !errors.Is(err, ErrInvalidSecret) {
+			t.Fatalf("%d bytes: err = %v, want ErrInvalidSecret", len(value), err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// QueueStats is the runtime-wide backlog. PendingSteps counts PENDING steps of
+// active runs other than approvals; RunnableSteps those a worker could claim
+// now (retry due and every earlier step settled). OldestPendingAge is how long
+// the oldest runnable step has been ready, and WaitingApprovalRuns counts
+// active runs with an approval waiting.
+type QueueStats struct {
+	PendingSteps        int64
+	RunnableSteps       int64
+	OldestPendingAge    time.Duration
+	WaitingApprovalRuns int64
+}
+
+// TenantBacklog is a tenant's claimable work: steps its worker could claim
+// now, and how long the oldest of them has been ready.
+type TenantBacklog struct {
+	APIKeyID   uuid.UUID
+	Claimable  int64
+	Starvation time.Duration
+}
+
+// DailyRunStats is one tenant-day row of the run_daily_stats summary table.
+// Runs are counted on their creation day; completions, steps, retries, cost,
+// and duration on the day the run reached a terminal status.
+type DailyRunStats struct {
+	Day                  string  `json:"day,omitempty"`
+	RunsCreated          int64   `json:"runs_created"`
+	RunsSucceeded        int64   `json:"runs_succeeded"`
+	RunsFailed           int64   `json:"runs_failed"`
+	RunsCanceled         int64   `json:"runs_canceled"`
+	StepsExecuted        int64   `json:"steps_executed"`
+	StepRetries          int64   `json:"step_retries"`
+	TotalCostUSD         float64 `json:"total_cost_usd"`
+	TotalDurationSeconds float64 `json:"total_duration_seconds"`
+	AvgDurationSeconds   float64 `json:"avg_duration_seconds"`
+}
+
+func (s DailyRunStats) RunsFinished() int64 {
+	return s.RunsSucceeded + s.RunsFailed + s.RunsCanceled
+}
+
+// SumDailyRunStats folds days into a single total with Day left empty.
+func SumDailyRunStats(days []DailyRunStats) DailyRunStats {
+	var total DailyRunStats
+	for _, d := range days {
+		total.RunsCreated += d.RunsCreated
+		total.RunsSucceeded += d.RunsSucceeded
+		total.RunsFailed += d.RunsFailed
+		total.RunsCanceled += d.RunsCanceled
+		total.StepsExecuted += d.StepsExecuted
+		total.StepRetries += d.StepRetries
+		total.TotalCostUSD += d.TotalCostUSD
+		total.TotalDurationSeconds += d.TotalDurationSeconds
+	}
+	total.AvgDurationSeconds = averageDuration(total.TotalDurationSeconds, total.RunsFinished())
+	return total
+}
+
+// Usage bucket granularities accepted by GET /usage.
+const (
+	UsageBucketDay   = "day"
+	UsageBucketMonth = "month"
+)
+
+// UsageBucket is the usage of one day (YYYY-MM-DD) or month (YYYY-MM).
+type UsageBucket struct {
+	Period string `json:"period"`
+	DailyRunStats
+}
+
+// BucketDailyRunStats groups days (oldest first) into day or month buckets.
+func BucketDailyRunStats(days []DailyRunStats, granularity string) []UsageBucket {
+	buckets := make([]UsageBucket, 0, len(days))
+	for i := 0; i < len(days); {
+		period := days[i].Day
+		if granularity == UsageBucketMonth && len(period) >= len("2006-01") {
+			period = period[:len("2006-01")]
+		}
+
+		j := i + 1
+		for j < len(days) && strings.HasPrefix(days[j].Day, period) {
+			j++
+		}
+		total := SumDailyRunStats(days[i:j])
+		buckets = append(buckets, UsageBucket{Period: period, DailyRunStats: total})
+		i = j
+	}
+	return buckets
+}
+
+func averageDuration(totalSeconds float64, finished int64) float64 {
+	if finished <= 0 {
+		return 0
+	}
+	return totalSeconds / float64(finished)
+}
@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import "testing"
+
+func TestSumDailyRunStats(t *testing.T) {
+	total := SumDailyRunStats([]DailyRunStats{
+		{Day: "2026-01-01", RunsCreated: 3, RunsSucceeded: 2, TotalCostUSD: 1.5, TotalDurationSeconds: 20},
+		{Day: "2026-01-02", RunsCreated: 1, RunsFailed: 1, RunsCanceled: 1, TotalCostUSD: 0.5, TotalDurationSeconds: 10},
+	})
+
+	if total.Day != "" {
+		t.Fatalf("expected empty day on totals, got %q", total.Day)
+	}
+	if total.RunsCreated != 4 || total.RunsFinished() != 4 {
+		t.Fatalf("unexpected run counts: %+v", total)
+	}
+	if total.TotalCostUSD != 2.0 {
+		t.Fatalf("expected total cost 2.0 got %f", total.TotalCostUSD)
+	}
+	if total.AvgDurationSeconds != 7.5 {
+		t.Fatalf("expected avg duration 7.5 got %f", total.AvgDurationSeconds)
+	}
+
+	if empty := SumDailyRunStats(nil); empty.AvgDurationSeconds != 0 {
+		t.Fatalf("expected zero avg for no finished runs, got %f", empty.AvgDurationSeconds)
+	}
+}
+
+func TestBucketDailyRunStats(t *testing.T) {
+	days := []DailyRunStats{
+		{Day: "2026-01-30", RunsCreated: 1, StepsExecuted: 3, StepRetries: 1, TotalCostUSD: 1},
+		{Day: "2026-01-31", RunsCreated: 2, StepsExecuted: 4, TotalCostUSD: 2},
+		{Day: "2026-02-01", RunsCreated: 1, StepsExecuted: 2, StepRetries: 2, TotalCostUSD: 0.5},
+	}
+
+	daily := BucketDailyRunStats(days, UsageBucketDay)
+	if len(daily) != 3 || daily[1].Period != "2026-01-31" || daily[1].Day != "" {
+		t.Fatalf("unexpected daily buckets: %+v", daily)
+	}
+
+	monthly := BucketDailyRunStats(days, UsageBucketMonth)
+	if len(monthly) != 2 {
+		t.Fatalf("expected 2 monthly buckets got %d", len(monthly))
+	}
+	jan := monthly[0]
+	if jan.Period != "2026-01" || jan.RunsCreated != 3 || jan.StepsExecuted != 7 || jan.StepRetries != 1 || jan.TotalCostUSD != 3 {
+		t.Fatalf("unexpected january bucket: %+v", jan)
+	}
+	if monthly[1].Period != "2026-02" || monthly[1].StepRetries != 2 {
+		t.Fatalf("unexpected february bucket: %+v", monthly[1])
+	}
+
+	if got := BucketDailyRunStats(nil, UsageBucketMonth); len(got) != 0 {
+		t.Fatalf("expected no buckets, got %+v", got)
+	}
+}
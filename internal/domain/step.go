@@ -2,7 +2,12 @@
 
 package domain
 
-import "github.com/google/uuid"
+import (
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
 
 type StepStatus string
 type StepName string
@@ -11,6 +16,8 @@ type StepRecord struct {
 	ID     uuid.UUID `json:"id"`
 	Name   string    `json:"name"`
 	Status string    `json:"status"`
+	// ParentStepID is set on the child steps a MAP step created.
+	ParentStepID *uuid.UUID `json:"parent_step_id,omitempty"`
 }
 
 const (
@@ -20,10 +27,48 @@ const (
 	StepSuccess  StepStatus = "SUCCEEDED"
 	StepFailed   StepStatus = "FAILED"
 	StepCanceled StepStatus = "CANCELED"
+	StepSkipped  StepStatus = "SKIPPED"
 )
 
 const (
 	StepLLM      StepName = "LLM"
 	StepTool     StepName = "TOOL"
 	StepApproval StepName = "APPROVAL"
+	// StepMap fans out over an array: the worker creates one child step per
+	// item and aggregates their outputs (see MapStepConfig).
+	StepMap StepName = "MAP"
+)
+
+// ExecutionKey identifies one execution of a step: its ID and execution
+// attempt, "<step_id>:<attempt>". A step reclaimed from a worker that died
+// keeps the key of the execution it interrupted, and a retry gets a new one,
+// so external systems can deduplicate the calls a reclaim repeats.
+func ExecutionKey(stepID uuid.UUID, executionAttempt int) string {
+	return stepID.String() + ":" + strconv.Itoa(executionAttempt)
+}
+
+// OnFailurePolicy decides what happens to a run when one of its steps fails
+// permanently.
+type OnFailurePolicy string
+
+const (
+	// OnFailureFailRun fails the whole run (the default).
+	OnFailureFailRun OnFailurePolicy = "fail_run"
+	// OnFailureSkip marks the step SKIPPED and moves on to the next step.
+	OnFailureSkip OnFailurePolicy = "skip"
+	// OnFailureContinue leaves the step FAILED and moves on to the next step.
+	OnFailureContinue OnFailurePolicy = "continue"
 )
+
+// ParseOnFailurePolicy accepts fail_run, skip, or continue; empty means
+// fail_run.
+func ParseOnFailurePolicy(v string) (OnFailurePolicy, error) {
+	switch p := OnFailurePolicy(strings.ToLower(strings.TrimSpace(v))); p {
+	case "":
+		return OnFailureFailRun, nil
+	case OnFailureFailRun, OnFailureSkip, OnFailureContinue:
+		return p, nil
+	default:
+		return "", ErrInvalidOnFailurePolicy
+	}
+}
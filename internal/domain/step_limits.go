@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseStepLimits parses cluster-wide caps on RUNNING steps per step type,
+// written as comma-separated NAME=N pairs such as "LLM=20,TOOL=50". Only
+// executed step types, LLM and TOOL, can be capped, each with N >= 1. An empty
+// string means no caps and returns nil.
+func ParseStepLimits(raw string) (map[StepName]int, error) {
+	var limits map[StepName]int
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, ErrInvalidStepLimit
+		}
+		step := StepName(strings.ToUpper(strings.TrimSpace(name)))
+		if step != StepLLM && step != StepTool {
+			return nil, ErrInvalidStepLimit
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 1 {
+			return nil, ErrInvalidStepLimit
+		}
+		if _, dup := limits[step]; dup {
+			return nil, ErrInvalidStepLimit
+		}
+		if limits == nil {
+			limits = make(map[StepName]int)
+		}
+		limits[step] = n
+	}
+	return limits, nil
+}
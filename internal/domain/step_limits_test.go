@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseStepLimits(t *testing.T) {
+	got, err := ParseStepLimits(" llm=20, TOOL = 5 ")
+	if err != nil {
+		t.Fatalf("expected valid limits, got %v", err)
+	}
+	if len(got) != 2 || got[StepLLM] != 20 || got[StepTool] != 5 {
+		t.Fatalf("unexpected limits %v", got)
+	}
+
+	if got, err := ParseStepLimits(""); err != nil || got != nil {
+		t.Fatalf("expected no limits, got %v, %v", got, err)
+	}
+
+	for _, raw := range []string{"LLM", "LLM=0", "LLM=-1", "LLM=x", "APPROVAL=1", "MAP=2", "LLM=1,LLM=2", "=3"} {
+		if _, err := ParseStepLimits(raw); !errors.Is(err, ErrInvalidStepLimit) {
+			t.Fatalf("expected %q invalid, got %v", raw, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultStepLogPageSize = 100
+	MaxStepLogPageSize     = 1000
+
+	// MaxStepLogLineBytes caps a single log line; longer lines are cut.
+	MaxStepLogLineBytes = 8 << 10
+)
+
+// StepLogLevel is the severity of a step log line.
+type StepLogLevel string
+
+const (
+	StepLogDebug StepLogLevel = "debug"
+	StepLogInfo  StepLogLevel = "info"
+	StepLogWarn  StepLogLevel = "warn"
+	StepLogError StepLogLevel = "error"
+)
+
+// Valid reports whether l is one of the known levels.
+func (l StepLogLevel) Valid() bool {
+	switch l {
+	case StepLogDebug, StepLogInfo, StepLogWarn, StepLogError:
+		return true
+	default:
+		return false
+	}
+}
+
+// StepLogLine is one line an executor logged while running a step. Seq
+// increases within a step and is the cursor for reading further lines.
+type StepLogLine struct {
+	Seq       int64        `json:"seq"`
+	StepID    uuid.UUID    `json:"step_id"`
+	Attempt   int          `json:"attempt"`
+	Level     StepLogLevel `json:"level"`
+	Line      string       `json:"line"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// StepLogPage is a page of a step's log lines after a cursor, with the
+// step's status so readers can tell whether more lines may come.
+type StepLogPage struct {
+	Lines      []StepLogLine
+	StepStatus StepStatus
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ClaimRequest is a worker's claim on its tenant's runnable steps.
+type ClaimRequest struct {
+	APIKeyID uuid.UUID
+	WorkerID uuid.UUID
+	// Lease is how long a claimed step stays with the worker before another
+	// may reclaim it, unless the lease is renewed.
+	Lease time.Duration
+	// DefaultTimeout applies to steps without a timeout of their own.
+	DefaultTimeout time.Duration
+	// Blocked lists step types not to claim, such as those whose executor's
+	// circuit breaker is open.
+	Blocked []StepName
+	// Probing lists step types a batch claims at most one step of, such as
+	// those whose circuit breaker is half-open.
+	Probing []StepName
+	// GlobalStepLimits caps the RUNNING steps of a step type across all
+	// tenants.
+	GlobalStepLimits map[StepName]int
+}
+
+// ClaimedStep is a step leased to a worker, with what its executor needs.
+type ClaimedStep struct {
+	StepID   uuid.UUID
+	RunID    uuid.UUID
+	APIKeyID uuid.UUID
+	Name     StepName
+	// Status is the step's status before the claim: PENDING, or RUNNING when
+	// an expired lease was reclaimed.
+	Status  StepStatus
+	Timeout time.Duration
+	// ParentStepID and Item are set on the children of a MAP step.
+	ParentStepID *uuid.UUID
+	Item         json.RawMessage
+	// Command is the argv of a TOOL step run in the sandbox.
+	Command []string
+	// HTTP is the request of a TOOL step run by the HTTP executor, and
+	// EgressHosts the tenant's egress allow-list it is limited to.
+	HTTP        *HTTPRequest
+	EgressHosts []string
+	// Secrets names the secrets resolved for the executor.
+	Secrets []string
+	// InputOverride is the JSON object a replay set for the step; its keys are
+	// merged into the step's input.
+	InputOverride json.RawMessage
+	// Attempt counts this claim, starting at 1.
+	Attempt int
+	// ExecutionAttempt counts the step's executions: it advances when a
+	// PENDING step is claimed, but not when an expired lease is reclaimed,
+	// so it keys the execution a dead worker may have left half done.
+	ExecutionAttempt int
+	// ClaimToken identifies this claim. The step is settled only while its
+	// claim_token still matches, so a reclaim fences off the earlier claim.
+	ClaimToken uuid.UUID
+	// ConfigErr is set when the step cannot run as configured (an unparsable
+	// condition, MAP items that are not an array, input the keyring cannot
+	// decrypt, or an invalid egress allow-list); the step then fails through
+	// the usual retry path instead of executing.
+	ConfigErr error
+}
+
+// StepCompletion is the result of a step that executed successfully.
+type StepCompletion struct {
+	Output json.RawMessage
+	// OutputTruncated is set when Output is the stand-in for output over the
+	// worker's size limit.
+	OutputTruncated bool
+	Cost            CostDetail
+	Latency         time.Duration
+}
+
+// StepFailure is the result of a step whose execution failed.
+type StepFailure struct {
+	Err error
+	// Permanent is set when retrying cannot help, so the step fails now
+	// whatever attempts it has left.
+	Permanent bool
+	Cost      CostDetail
+	Latency   time.Duration
+	// Retry is the worker-wide retry policy; the step's own retry settings
+	// override it.
+	Retry RetryPolicy
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StepSummary is one step's line in a RUN_SUMMARY event. DurationMS is nil for
+// steps that never started.
+type StepSummary struct {
+	ID               uuid.UUID `json:"id"`
+	Name             string    `json:"name"`
+	Status           string    `json:"status"`
+	Attempts         int       `json:"attempts"`
+	Retries          int       `json:"retries"`
+	DurationMS       *int64    `json:"duration_ms"`
+	CostUSD          float64   `json:"cost_usd"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+}
+
+// RunSummary is the RUN_SUMMARY event payload: one canonical record per
+// terminal run, so consumers do not have to reduce the whole event stream.
+type RunSummary struct {
+	Status           RunStatus     `json:"status"`
+	DurationMS       int64         `json:"duration_ms"`
+	TotalCostUSD     float64       `json:"total_cost_usd"`
+	Attempts         int           `json:"attempts"`
+	Retries          int           `json:"retries"`
+	PromptTokens     int64         `json:"prompt_tokens"`
+	CompletionTokens int64         `json:"completion_tokens"`
+	TotalTokens      int64         `json:"total_tokens"`
+	Steps            []StepSummary `json:"steps"`
+}
+
+// NewRunSummary totals steps into the summary of a run that was created at
+// createdAt and reached status at finishedAt. Every attempt after a step's
+// first counts as a retry.
+func NewRunSummary(status RunStatus, createdAt, finishedAt time.Time, steps []StepSummary) RunSummary {
+	summary := RunSummary{
+		Status:     status,
+		DurationMS: max(finishedAt.Sub(createdAt).Milliseconds(), 0),
+		Steps:      make([]StepSummary, 0, len(steps)),
+	}
+	for _, step := range steps {
+		step.Retries = max(step.Attempts-1, 0)
+		summary.TotalCostUSD += step.CostUSD
+		summary.Attempts += step.Attempts
+		summary.Retries += step.Retries
+		summary.PromptTokens += step.PromptTokens
+		summary.CompletionTokens += step.CompletionTokens
+		summary.Steps = append(summary.Steps, step)
+	}
+	summary.TotalTokens = summary.PromptTokens + summary.CompletionTokens
+	return summary
+}
+
+// StepDurationMS returns how long a step ran, or nil when it never started or
+// finished.
+func StepDurationMS(startedAt, finishedAt *time.Time) *int64 {
+	if startedAt == nil || finishedAt == nil {
+		return nil
+	}
+	ms := max(finishedAt.Sub(*startedAt).Milliseconds(), 0)
+	return &ms
+}
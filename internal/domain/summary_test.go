@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestNewRunSummary(t *testing.T) {
+	createdAt := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	started := createdAt.Add(time.Second)
+	finished := started.Add(1500 * time.Millisecond)
+
+	summary := NewRunSummary(RunFailed, createdAt, createdAt.Add(5*time.Second), []StepSummary{
+		{ID: uuid.New(), Name: "LLM", Status: "SUCCEEDED", Attempts: 1, DurationMS: StepDurationMS(&started, &finished), CostUSD: 0.5, PromptTokens: 180, CompletionTokens: 72},
+		{ID: uuid.New(), Name: "TOOL", Status: "FAILED", Attempts: 3, CostUSD: 0.25},
+		{ID: uuid.New(), Name: "APPROVAL", Status: "PENDING"},
+	})
+
+	if summary.Status != RunFailed || summary.DurationMS != 5000 {
+		t.Fatalf("unexpected run status/duration: %+v", summary)
+	}
+	if summary.Attempts != 4 || summary.Retries != 2 {
+		t.Fatalf("expected 4 attempts and 2 retries, got %d and %d", summary.Attempts, summary.Retries)
+	}
+	if summary.TotalCostUSD != 0.75 {
+		t.Fatalf("expected total cost 0.75 got %f", summary.TotalCostUSD)
+	}
+	if summary.PromptTokens != 180 || summary.CompletionTokens != 72 || summary.TotalTokens != 252 {
+		t.Fatalf("unexpected token totals: %+v", summary)
+	}
+	if d := summary.Steps[0].DurationMS; d == nil || *d != 1500 {
+		t.Fatalf("expected first step duration 1500ms, got %v", d)
+	}
+	if summary.Steps[1].Retries != 2 || summary.Steps[2].Retries != 0 {
+		t.Fatalf("unexpected per-step retries: %+v", summary.Steps)
+	}
+	if summary.Steps[2].DurationMS != nil {
+		t.Fatalf("expected nil duration for a step that never ran")
+	}
+}
@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxWorkflowTemplateSteps bounds the steps of one template version.
+	MaxWorkflowTemplateSteps = 100
+	// MaxWorkflowTemplateNameLength bounds template names.
+	MaxWorkflowTemplateNameLength = 100
+)
+
+// WorkflowTemplateStep is one step of a template version, with the
+// workflow_template_steps columns copied onto the steps of runs created from
+// it. Nil and empty fields are NULL columns, which keep the worker's
+// defaults.
+type WorkflowTemplateStep struct {
+	Name           StepName        `json:"name"`
+	TimeoutSeconds *int            `json:"timeout_seconds,omitempty"`
+	OnFailure      OnFailurePolicy `json:"on_failure,omitempty"`
+	Condition      string          `json:"condition,omitempty"`
+
+	MapItems       string   `json:"map_items,omitempty"`
+	MapStep        StepName `json:"map_step,omitempty"`
+	MapParallelism *int     `json:"map_parallelism,omitempty"`
+
+	ApprovalName           string                `json:"approval_name,omitempty"`
+	ApprovalTimeoutSeconds *int                  `json:"approval_timeout_seconds,omitempty"`
+	ApprovalTimeoutAction  ApprovalTimeoutAction `json:"approval_timeout_action,omitempty"`
+
+	MaxAttempts      *int         `json:"max_attempts,omitempty"`
+	RetryBaseDelayMS *int         `json:"retry_base_delay_ms,omitempty"`
+	RetryBackoff     RetryBackoff `json:"retry_backoff,omitempty"`
+	RetryJitter      *bool        `json:"retry_jitter,omitempty"`
+	RetryPriority    *int         `json:"retry_priority,omitempty"`
+
+	Command []string `json:"command,omitempty"`
+	// HTTP makes a TOOL step call an HTTP endpoint, limited by the tenant's
+	// egress allow-list, instead of running the default tool.
+	HTTP *HTTPRequest `json:"http,omitempty"`
+	// Secrets names the secrets the step's executor is given, e.g. as
+	// environment variables of its command.
+	Secrets []string `json:"secrets,omitempty"`
+}
+
+// WorkflowTemplateVersion is one version of a workflow template. Versions are
+// never changed once created; an edit adds the next version.
+type WorkflowTemplateVersion struct {
+	Name      string                 `json:"name"`
+	Version   int                    `json:"version"`
+	Steps     []WorkflowTemplateStep `json:"steps"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// PutWorkflowTemplateRequest is the body of PUT
+// /admin/workflow-templates/{name}.
+type PutWorkflowTemplateRequest struct {
+	Steps []WorkflowTemplateStep `json:"steps"`
+}
+
+// NormalizeWorkflowTemplateName trims a template name and checks it is 1 to
+// MaxWorkflowTemplateNameLength letters, digits, '.', '_', or '-'.
+func NormalizeWorkflowTemplateName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > MaxWorkflowTemplateNameLength {
+		return "", fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidWorkflowTemplate, MaxWorkflowTemplateNameLength)
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return "", fmt.Errorf("%w: name may only contain letters, digits, '.', '_', and '-'", ErrInvalidWorkflowTemplate)
+		}
+	}
+	return name, nil
+}
+
+// NormalizeWorkflowTemplateSteps validates the steps of a new template
+// version under the rules runs are created with, and returns them with
+// step names, policies, and conditions in canonical form.
+func NormalizeWorkflowTemplateSteps(steps []WorkflowTemplateStep) ([]WorkflowTemplateStep, error) {
+	if len(steps) == 0 || len(steps) > MaxWorkflowTemplateSteps {
+		return nil, fmt.Errorf("%w: a template has 1 to %d steps", ErrInvalidWorkflowTemplate, MaxWorkflowTemplateSteps)
+	}
+
+	out := make([]WorkflowTemplateStep, len(steps))
+	for i, st := range steps {
+		normalized, err := st.normalize()
+		if err != nil {
+			return nil, fmt.Errorf("step %d (%s): %w", i, st.Name, err)
+		}
+		out[i] = normalized
+	}
+	return out, nil
+}
+
+func (s WorkflowTemplateStep) normalize() (WorkflowTemplateStep, error) {
+	s.Name = StepName(strings.ToUpper(strings.TrimSpace(string(s.Name))))
+	switch s.Name {
+	case StepLLM, StepTool, StepApproval, StepMap:
+	default:
+		return s, fmt.Errorf("%w: unknown step %q", ErrInvalidWorkflowTemplate, s.Name)
+	}
+	if s.TimeoutSeconds != nil && *s.TimeoutSeconds <= 0 {
+		return s, fmt.Errorf("%w: timeout_seconds must be positive", ErrInvalidWorkflowTemplate)
+	}
+
+	var err error
+	if s.OnFailure, err = ParseOnFailurePolicy(string(s.OnFailure)); err != nil {
+		return s, err
+	}
+	if s.Condition = strings.TrimSpace(s.Condition); s.Condition != "" {
+		cond, err := ParseStepCondition(s.Condition)
+		if err != nil {
+			return s, err
+		}
+		s.Condition = cond.String()
+	}
+
+	if s.Name == StepMap {
+		parallelism := 0
+		if s.MapParallelism != nil {
+			if *s.MapParallelism <= 0 {
+				return s, fmt.Errorf("%w: map_parallelism must be positive", ErrInvalidMapStep)
+			}
+			parallelism = *s.MapParallelism
+		}
+		cfg, err := ParseMapStepConfig(s.MapItems, string(s.MapStep), parallelism)
+		if err != nil {
+			return s, err
+		}
+		s.MapItems, s.MapStep = cfg.Items.String(), cfg.Step
+	} else if s.MapItems != "" || s.MapStep != "" || s.MapParallelism != nil {
+		return s, fmt.Errorf("%w: only MAP steps set map_items, map_step, or map_parallelism", ErrInvalidMapStep)
+	}
+
+	s.ApprovalName = strings.TrimSpace(s.ApprovalName)
+	if s.Name != StepApproval && (s.ApprovalName != "" || s.ApprovalTimeoutSeconds != nil || s.ApprovalTimeoutAction != "") {
+		return s, fmt.Errorf("%w: only APPROVAL steps are named or time out", ErrInvalidApprovalTimeout)
+	}
+	if s.ApprovalTimeoutSeconds != nil && *s.ApprovalTimeoutSeconds <= 0 {
+		return s, fmt.Errorf("%w: approval_timeout_seconds must be positive", ErrInvalidApprovalTimeout)
+	}
+	if s.ApprovalTimeoutAction != "" {
+		if s.ApprovalTimeoutAction, err = ParseApprovalTimeoutAction(string(s.ApprovalTimeoutAction)); err != nil {
+			return s, err
+		}
+	}
+
+	if (s.MaxAttempts != nil && *s.MaxAttempts <= 0) || (s.RetryBaseDelayMS != nil && *s.RetryBaseDelayMS <= 0) {
+		return s, fmt.Errorf("%w: max_attempts and retry_base_delay_ms must be positive", ErrInvalidRetryPolicy)
+	}
+	if s.RetryBackoff != "" {
+		if s.RetryBackoff, err = ParseRetryBackoff(string(s.RetryBackoff)); err != nil {
+			return s, err
+		}
+	}
+	if s.RetryPriority != nil {
+		if err := ValidateRetryPriority(*s.RetryPriority); err != nil {
+			return s, err
+		}
+	}
+
+	if s.Command != nil {
+		if s.Name != StepTool && !(s.Name == StepMap && s.MapStep == StepTool) {
+			return s, fmt.Errorf("%w: only TOOL steps run commands", ErrInvalidStepCommand)
+		}
+		if len(s.Command) == 0 || strings.TrimSpace(s.Command[0]) == "" {
+			return s, fmt.Errorf("%w: command needs a binary", ErrInvalidStepCommand)
+		}
+	}
+
+	if s.HTTP != nil {
+		if s.Name != StepTool && !(s.Name == StepMap && s.MapStep == StepTool) {
+			return s, fmt.Errorf("%w: only TOOL steps make HTTP requests", ErrInvalidStepHTTP)
+		}
+		if s.Command != nil {
+			return s, fmt.Errorf("%w: a step runs a command or makes an HTTP request, not both", ErrInvalidStepHTTP)
+		}
+		req, err := s.HTTP.normalize()
+		if err != nil {
+			return s, err
+		}
+		s.HTTP = &req
+	}
+
+	if s.Secrets != nil {
+		if s.Name == StepApproval {
+			return s, fmt.Errorf("%w: APPROVAL steps run no executor to give secrets to", ErrInvalidSecret)
+		}
+		if len(s.Secrets) == 0 || len(s.Secrets) > MaxStepSecrets {
+			return s, fmt.Errorf("%w: a step references 1 to %d secrets", ErrInvalidSecret, MaxStepSecrets)
+		}
+		for i, name := range s.Secrets {
+			if err := ValidateSecretName(name); err != nil {
+				return s, err
+			}
+			if slices.Contains(s.Secrets[:i], name) {
+				return s, fmt.Errorf("%w: secret %q is listed twice", ErrInvalidSecret, name)
+			}
+		}
+	}
+	return s, nil
+}
+
+// HTTPRequest is the request an HTTP TOOL step sends. The body is the step's
+// input override, or a MAP child's item; steps without either send none.
+type HTTPRequest struct {
+	// Method defaults to POST.
+	Method  string            `json:"method,omitempty"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// httpMethods are the methods an HTTP step may use.
+var httpMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+func (r HTTPRequest) normalize() (HTTPRequest, error) {
+	r.Method = strings.ToUpper(strings.TrimSpace(r.Method))
+	if r.Method == "" {
+		r.Method = http.MethodPost
+	}
+	if !slices.Contains(httpMethods, r.Method) {
+		return r, fmt.Errorf("%w: unsupported method %q", ErrInvalidStepHTTP, r.Method)
+	}
+	r.URL = strings.TrimSpace(r.URL)
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return r, fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidStepHTTP)
+	}
+	if u.User != nil {
+		return r, fmt.Errorf("%w: url must not carry credentials; use a secret", ErrInvalidStepHTTP)
+	}
+	for name := range r.Headers {
+		if strings.TrimSpace(name) == "" || strings.ContainsAny(name, " :\r\n") {
+			return r, fmt.Errorf("%w: invalid header name %q", ErrInvalidStepHTTP, name)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import "strings"
+
+// MaxTemplateRunLimit bounds one entry of api_keys.max_concurrent_runs_per_template.
+const MaxTemplateRunLimit = 10000
+
+// NormalizeTemplateRunLimits validates a key's per-template run caps and
+// returns them with trimmed template names. Each cap is the most RUNNING or
+// WAITING runs of that template the key may have at once, from 1 to
+// MaxTemplateRunLimit. A nil or empty map removes every cap.
+func NormalizeTemplateRunLimits(limits map[string]int) (map[string]int, error) {
+	out := make(map[string]int, len(limits))
+	for name, limit := range limits {
+		name = strings.TrimSpace(name)
+		if name == "" || limit < 1 || limit > MaxTemplateRunLimit {
+			return nil, ErrInvalidTemplateRunLimit
+		}
+		if _, dup := out[name]; dup {
+			return nil, ErrInvalidTemplateRunLimit
+		}
+		out[name] = limit
+	}
+	return out, nil
+}
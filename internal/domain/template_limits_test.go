@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeTemplateRunLimits(t *testing.T) {
+	got, err := NormalizeTemplateRunLimits(map[string]int{" deploy ": 2, "default": MaxTemplateRunLimit})
+	if err != nil {
+		t.Fatalf("expected valid limits, got %v", err)
+	}
+	if len(got) != 2 || got["deploy"] != 2 || got["default"] != MaxTemplateRunLimit {
+		t.Fatalf("unexpected limits %v", got)
+	}
+
+	cleared, err := NormalizeTemplateRunLimits(nil)
+	if err != nil || cleared == nil || len(cleared) != 0 {
+		t.Fatalf("expected nil to clear the limits, got %v, %v", cleared, err)
+	}
+
+	for _, limits := range []map[string]int{
+		{"deploy": 0},
+		{"deploy": -1},
+		{"deploy": MaxTemplateRunLimit + 1},
+		{" ": 1},
+		{"deploy": 1, "deploy ": 2},
+	} {
+		if _, err := NormalizeTemplateRunLimits(limits); !errors.Is(err, ErrInvalidTemplateRunLimit) {
+			t.Fatalf("expected %v invalid, got %v", limits, err)
+		}
+	}
+}
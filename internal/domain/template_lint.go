@@ -0,0 +1,299 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DiagnosticSeverity says whether a template diagnostic blocks saving the
+// template (error) or only flags a likely mistake (warning).
+type DiagnosticSeverity string
+
+const (
+	DiagnosticError   DiagnosticSeverity = "error"
+	DiagnosticWarning DiagnosticSeverity = "warning"
+)
+
+// Template diagnostic codes.
+const (
+	// DiagnosticInvalidTemplate: the template as a whole is malformed, for
+	// example it has no steps.
+	DiagnosticInvalidTemplate = "invalid_template"
+	// DiagnosticInvalidStep: a step fails the checks the template would be
+	// saved with.
+	DiagnosticInvalidStep = "invalid_step"
+	// DiagnosticUnknownStep: a step name has no registered executor.
+	DiagnosticUnknownStep = "unknown_step"
+	// DiagnosticUnknownReference: a condition or map_items reads a step the
+	// template does not have.
+	DiagnosticUnknownReference = "unknown_reference"
+	// DiagnosticDependencyCycle: a step reads itself or a step that only runs
+	// after it. Steps run in position order, so such a step depends on one
+	// that depends on it.
+	DiagnosticDependencyCycle = "dependency_cycle"
+	// DiagnosticMissingTimeout: a step leaves its timeout to the worker
+	// default, or an approval waits forever.
+	DiagnosticMissingTimeout = "missing_timeout"
+	// DiagnosticUnreachableStep: a step's condition can never hold, so it is
+	// always skipped.
+	DiagnosticUnreachableStep = "unreachable_step"
+)
+
+// TemplateDiagnostic is one finding of ValidateWorkflowTemplate. Position is
+// the 0-based index of the step it is about, nil for the whole template.
+type TemplateDiagnostic struct {
+	Severity DiagnosticSeverity `json:"severity"`
+	Code     string             `json:"code"`
+	Position *int               `json:"position,omitempty"`
+	Step     StepName           `json:"step,omitempty"`
+	Field    string             `json:"field,omitempty"`
+	Message  string             `json:"message"`
+}
+
+// TemplateValidation is the response of POST /workflow-templates/validate.
+// Valid is false when any diagnostic is an error.
+type TemplateValidation struct {
+	Valid       bool                 `json:"valid"`
+	Diagnostics []TemplateDiagnostic `json:"diagnostics"`
+	// Estimate is the expected cost of the steps, set for valid templates
+	// when the pricing of active workers is known.
+	Estimate *CostEstimate `json:"estimate,omitempty"`
+}
+
+// ValidateWorkflowTemplate checks template steps without saving them. On top
+// of the rules of NormalizeWorkflowTemplateSteps it reports references to
+// missing or later steps, steps without timeouts, and steps whose condition
+// can never hold. Every problem is reported, not just the first.
+func ValidateWorkflowTemplate(steps []WorkflowTemplateStep) TemplateValidation {
+	l := &templateLinter{}
+	if len(steps) == 0 || len(steps) > MaxWorkflowTemplateSteps {
+		l.report(DiagnosticError, DiagnosticInvalidTemplate, -1, "", "steps",
+			fmt.Sprintf("a template has 1 to %d steps", MaxWorkflowTemplateSteps))
+	}
+
+	// Steps that fail normalization are left out of the later checks; their
+	// names still count so that references to them are not reported twice.
+	normalized := make([]*WorkflowTemplateStep, len(steps))
+	names := make([]StepName, len(steps))
+	for i, st := range steps {
+		names[i] = StepName(strings.ToUpper(strings.TrimSpace(string(st.Name))))
+		switch names[i] {
+		case StepLLM, StepTool, StepApproval, StepMap:
+		default:
+			l.report(DiagnosticError, DiagnosticUnknownStep, i, names[i], "name",
+				fmt.Sprintf("no executor is registered for step %q; use %s, %s, %s, or %s", st.Name, StepLLM, StepTool, StepApproval, StepMap))
+			continue
+		}
+		n, err := st.normalize()
+		if err != nil {
+			l.report(DiagnosticError, DiagnosticInvalidStep, i, names[i], stepErrorField(err), err.Error())
+			continue
+		}
+		normalized[i] = &n
+	}
+
+	for i, st := range normalized {
+		if st == nil {
+			continue
+		}
+		l.checkReferences(i, *st, names)
+		l.checkTimeouts(i, *st)
+		l.checkReachable(i, *st, names, normalized)
+	}
+
+	diagnostics := l.diagnostics
+	if diagnostics == nil {
+		diagnostics = []TemplateDiagnostic{}
+	}
+	return TemplateValidation{Valid: !l.failed, Diagnostics: diagnostics}
+}
+
+type templateLinter struct {
+	diagnostics []TemplateDiagnostic
+	failed      bool
+}
+
+func (l *templateLinter) report(severity DiagnosticSeverity, code string, position int, step StepName, field, message string) {
+	d := TemplateDiagnostic{Severity: severity, Code: code, Step: step, Field: field, Message: message}
+	if position >= 0 {
+		d.Position = &position
+	}
+	l.diagnostics = append(l.diagnostics, d)
+	if severity == DiagnosticError {
+		l.failed = true
+	}
+}
+
+// checkReferences reports steps.<NAME> paths that name no step, or only
+// steps at or after position i.
+func (l *templateLinter) checkReferences(i int, st WorkflowTemplateStep, names []StepName) {
+	check := func(field, expr string, refs []StepName) {
+		for _, ref := range refs {
+			switch {
+			case slices.Contains(names[:i], ref):
+			case slices.Contains(names[i:], ref):
+				l.report(DiagnosticError, DiagnosticDependencyCycle, i, st.Name, field,
+					fmt.Sprintf("%s reads steps.%s, which has not run before this step", expr, ref))
+			default:
+				l.report(DiagnosticError, DiagnosticUnknownReference, i, st.Name, field,
+					fmt.Sprintf("%s reads steps.%s, which is not a step of this template", expr, ref))
+			}
+		}
+	}
+
+	if st.Condition != "" {
+		cond, _ := ParseStepCondition(st.Condition)
+		check("condition", "condition", cond.stepRefs())
+	}
+	if st.MapItems != "" {
+		path, _ := ParseStepPath(st.MapItems)
+		if ref, ok := path.operand.stepRef(); ok {
+			check("map_items", "map_items", []StepName{ref})
+		}
+	}
+}
+
+func (l *templateLinter) checkTimeouts(i int, st WorkflowTemplateStep) {
+	if st.Name == StepApproval {
+		if st.ApprovalTimeoutSeconds == nil {
+			l.report(DiagnosticWarning, DiagnosticMissingTimeout, i, st.Name, "approval_timeout_seconds",
+				"approval has no approval_timeout_seconds and waits for a decision forever")
+		}
+		return
+	}
+	if st.TimeoutSeconds == nil {
+		l.report(DiagnosticWarning, DiagnosticMissingTimeout, i, st.Name, "timeout_seconds",
+			"step has no timeout_seconds and uses the worker default")
+	}
+}
+
+// checkReachable reports a condition requiring steps.<NAME>.status to be a
+// status the earlier steps with that name cannot finish with: a step whose
+// failure fails the run is never FAILED when a later step is evaluated.
+func (l *templateLinter) checkReachable(i int, st WorkflowTemplateStep, names []StepName, steps []*WorkflowTemplateStep) {
+	if st.Condition == "" {
+		return
+	}
+	cond, _ := ParseStepCondition(st.Condition)
+	for _, req := range cond.requiredStatuses() {
+		possible, known := map[StepStatus]bool{}, true
+		for j, earlier := range steps[:i] {
+			if names[j] != req.step {
+				continue
+			}
+			if earlier == nil {
+				known = false
+				break
+			}
+			possible[StepSuccess] = true
+			if earlier.Condition != "" || earlier.OnFailure == OnFailureSkip {
+				possible[StepSkipped] = true
+			}
+			if earlier.OnFailure == OnFailureContinue {
+				possible[StepFailed] = true
+			}
+		}
+		// Earlier steps that failed normalization or are missing are
+		// reported elsewhere.
+		if !known || len(possible) == 0 || possible[req.status] {
+			continue
+		}
+		l.report(DiagnosticWarning, DiagnosticUnreachableStep, i, st.Name, "condition",
+			fmt.Sprintf("condition needs steps.%s.status == %q, which earlier %s steps never finish with, so this step is always skipped", req.step, req.status, req.step))
+	}
+}
+
+// stepErrorField names the template field a step normalization error is
+// about, or "" when it is not about one field.
+func stepErrorField(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidStepCondition):
+		return "condition"
+	case errors.Is(err, ErrInvalidOnFailurePolicy):
+		return "on_failure"
+	case errors.Is(err, ErrInvalidStepCommand):
+		return "command"
+	case errors.Is(err, ErrInvalidStepHTTP):
+		return "http"
+	case errors.Is(err, ErrInvalidSecret):
+		return "secrets"
+	}
+	return ""
+}
+
+// stepRefs returns the step names the condition reads, in order of first
+// use.
+func (c *StepCondition) stepRefs() []StepName {
+	var refs []StepName
+	var walk func(conditionNode)
+	add := func(o conditionOperand) {
+		if ref, ok := o.stepRef(); ok && !slices.Contains(refs, ref) {
+			refs = append(refs, ref)
+		}
+	}
+	walk = func(n conditionNode) {
+		switch n := n.(type) {
+		case conditionOr:
+			walk(n.left)
+			walk(n.right)
+		case conditionAnd:
+			walk(n.left)
+			walk(n.right)
+		case conditionNot:
+			walk(n.inner)
+		case conditionTruthy:
+			add(n.operand)
+		case conditionCompare:
+			add(n.left)
+			add(n.right)
+		}
+	}
+	walk(c.root)
+	return refs
+}
+
+type requiredStatus struct {
+	step   StepName
+	status StepStatus
+}
+
+// requiredStatuses returns the steps.<NAME>.status == "<STATUS>" comparisons
+// joined by && at the top of the condition: each must hold for it to be true.
+func (c *StepCondition) requiredStatuses() []requiredStatus {
+	var out []requiredStatus
+	var walk func(conditionNode)
+	walk = func(n conditionNode) {
+		switch n := n.(type) {
+		case conditionAnd:
+			walk(n.left)
+			walk(n.right)
+		case conditionCompare:
+			if n.op != "==" {
+				return
+			}
+			path, lit := n.left, n.right
+			if path.segments == nil {
+				path, lit = lit, path
+			}
+			status, ok := lit.literal.(string)
+			ref, isRef := path.stepRef()
+			if ok && isRef && len(path.segments) == 3 && path.segments[2] == "status" {
+				out = append(out, requiredStatus{step: ref, status: StepStatus(status)})
+			}
+		}
+	}
+	walk(c.root)
+	return out
+}
+
+// stepRef returns NAME for a steps.<NAME>... path.
+func (o conditionOperand) stepRef() (StepName, bool) {
+	if len(o.segments) < 2 || o.segments[0] != "steps" {
+		return "", false
+	}
+	return StepName(o.segments[1]), true
+}
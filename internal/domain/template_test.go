@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestNormalizeWorkflowTemplateName(t *testing.T) {
+	if got, err := NormalizeWorkflowTemplateName(" ops-template.v2 "); err != nil || got != "ops-template.v2" {
+		t.Fatalf("NormalizeWorkflowTemplateName = %q, %v", got, err)
+	}
+	for _, name := range []string{"", "has space", "a/b"} {
+		if _, err := NormalizeWorkflowTemplateName(name); !errors.Is(err, ErrInvalidWorkflowTemplate) {
+			t.Fatalf("%q: err = %v, want ErrInvalidWorkflowTemplate", name, err)
+		}
+	}
+}
+
+func TestNormalizeWorkflowTemplateSteps(t *testing.T) {
+	three, zero := 3, 0
+	got, err := NormalizeWorkflowTemplateSteps([]WorkflowTemplateStep{
+		{Name: "llm", Condition: ` run.priority > 1 `},
+		{Name: StepMap, MapItems: "steps.LLM.output.items", MapStep: "tool", Command: []string{"jq", "."}},
+		{Name: StepApproval, ApprovalName: " legal ", ApprovalTimeoutSeconds: &three, ApprovalTimeoutAction: "APPROVE"},
+		{Name: StepTool, OnFailure: "skip", MaxAttempts: &three, RetryBackoff: "Linear", HTTP: &HTTPRequest{URL: " https://api.example/v1 "}},
+	})
+	if err != nil {
+		t.Fatalf("NormalizeWorkflowTemplateSteps: %v", err)
+	}
+	if got[0].Name != StepLLM || got[0].OnFailure != OnFailureFailRun || got[0].Condition != "run.priority > 1" {
+		t.Fatalf("step 0 = %+v", got[0])
+	}
+	if got[1].MapStep != StepTool || got[2].ApprovalName != "legal" || got[2].ApprovalTimeoutAction != ApprovalTimeoutApprove {
+		t.Fatalf("steps 1-2 = %+v, %+v", got[1], got[2])
+	}
+	if got[3].RetryBackoff != RetryBackoffLinear || got[3].HTTP.Method != "POST" || got[3].HTTP.URL != "https://api.example/v1" {
+		t.Fatalf("step 3 = %+v", got[3])
+	}
+
+	for name, tc := range map[string]struct {
+		steps []WorkflowTemplateStep
+		want  error
+	}{
+		"no steps":           {nil, ErrInvalidWorkflowTemplate},
+		"unknown step":       {[]WorkflowTemplateStep{{Name: "EMAIL"}}, ErrInvalidWorkflowTemplate},
+		"zero timeout":       {[]WorkflowTemplateStep{{Name: StepLLM, TimeoutSeconds: &zero}}, ErrInvalidWorkflowTemplate},
+		"bad condition":      {[]WorkflowTemplateStep{{Name: StepLLM, Condition: "run.priority >"}}, ErrInvalidStepCondition},
+		"bad policy":         {[]WorkflowTemplateStep{{Name: StepLLM, OnFailure: "retry"}}, ErrInvalidOnFailurePolicy},
+		"map fields on LLM":  {[]WorkflowTemplateStep{{Name: StepLLM, MapStep: StepTool}}, ErrInvalidMapStep},
+		"timeout on TOOL":    {[]WorkflowTemplateStep{{Name: StepTool, ApprovalTimeoutSeconds: &three}}, ErrInvalidApprovalTimeout},
+		"zero attempts":      {[]WorkflowTemplateStep{{Name: StepTool, MaxAttempts: &zero}}, ErrInvalidRetryPolicy},
+		"command on LLM":     {[]WorkflowTemplateStep{{Name: StepLLM, Command: []string{"jq"}}}, ErrInvalidStepCommand},
+		"empty command":      {[]WorkflowTemplateStep{{Name: StepTool, Command: []string{}}}, ErrInvalidStepCommand},
+		"http on LLM":        {[]WorkflowTemplateStep{{Name: StepLLM, HTTP: &HTTPRequest{URL: "https://api.example"}}}, ErrInvalidStepHTTP},
+		"http and command":   {[]WorkflowTemplateStep{{Name: StepTool, Command: []string{"jq"}, HTTP: &HTTPRequest{URL: "https://api.example"}}}, ErrInvalidStepHTTP},
+		"http without host":  {[]WorkflowTemplateStep{{Name: StepTool, HTTP: &HTTPRequest{URL: "file:///etc/passwd"}}}, ErrInvalidStepHTTP},
+		"http credentials":   {[]WorkflowTemplateStep{{Name: StepTool, HTTP: &HTTPRequest{URL: "https://u:p@api.example"}}}, ErrInvalidStepHTTP},
+		"http method":        {[]WorkflowTemplateStep{{Name: StepTool, HTTP: &HTTPRequest{Method: "TRACE", URL: "https://api.example"}}}, ErrInvalidStepHTTP},
+		"too many positions": {make([]WorkflowTemplateStep, MaxWorkflowTemplateSteps+1), ErrInvalidWorkflowTemplate},
+		"secret on APPROVAL": {[]WorkflowTemplateStep{{Name: StepApproval, Secrets: []string{"TOKEN"}}}, ErrInvalidSecret},
+		"bad secret name":    {[]WorkflowTemplateStep{{Name: StepTool, Secrets: []string{"1TOKEN"}}}, ErrInvalidSecret},
+		"repeated secret":    {[]WorkflowTemplateStep{{Name: StepLLM, Secrets: []string{"A", "A"}}}, ErrInvalidSecret},
+	} {
+		if _, err := NormalizeWorkflowTemplateSteps(tc.steps); !errors.Is(err, tc.want) {
+			t.Fatalf("%s: err = %v, want %v", name, err, tc.want)
+		}
+	}
+}
+
+func TestCreateRunRequestTemplateVersion(t *testing.T) {
+	version := 2
+	params, err := CreateRunRequest{TemplateName: "ops", TemplateVersion: &version}.Params()
+	if err != nil || params.TemplateVersion == nil || *params.TemplateVersion != 2 {
+		t.Fatalf("Params = %+v, %v", params, err)
+	}
+
+	version = 0
+	if _, err := (CreateRunRequest{TemplateVersion: &version}).Params(); !errors.Is(err, ErrInvalidTemplateVersion) {
+		t.Fatalf("err = %v, want ErrInvalidTemplateVersion", err)
+	}
+}
+
+func TestValidateWorkflowTemplate(t *testing.T) {
+	ten := 10
+	got := ValidateWorkflowTemplate([]WorkflowTemplateStep{
+		{Name: StepTool, TimeoutSeconds: &ten},
+		{Name: StepLLM, TimeoutSeconds: &ten, Condition: `steps.TOOL.status == "FAILED"`},
+		{Name: StepApproval, Condition: `steps.APPROVAL.status == "SUCCEEDED"`},
+		{Name: StepMap, TimeoutSeconds: &ten, MapItems: "steps.EMAIL.output.items", MapStep: StepTool},
+		{Name: "email"},
+		{Name: StepLLM, TimeoutSeconds: &ten, OnFailure: "retry"},
+	})
+	if got.Valid {
+		t.Fatal("expected template to be invalid")
+	}
+
+	type finding struct {
+		code     string
+		position int
+		field    string
+	}
+	var findings []finding
+	for _, d := range got.Diagnostics {
+		findings = append(findings, finding{d.Code, *d.Position, d.Field})
+	}
+	want := []finding{
+		{DiagnosticUnknownStep, 4, "name"},
+		{DiagnosticInvalidStep, 5, "on_failure"},
+		{DiagnosticUnreachableStep, 1, "condition"},
+		{DiagnosticDependencyCycle, 2, "condition"},
+		{DiagnosticMissingTimeout, 2, "approval_timeout_seconds"},
+		{DiagnosticDependencyCycle, 3, "map_items"},
+	}
+	if !slices.Equal(findings, want) {
+		t.Fatalf("diagnostics = %+v, want %+v", findings, want)
+	}
+
+	got = ValidateWorkflowTemplate([]WorkflowTemplateStep{
+		{Name: StepTool, OnFailure: "continue"},
+		{Name: StepLLM, TimeoutSeconds: &ten, Condition: `steps.TOOL.status == "FAILED" && steps.NOPE.output`},
+	})
+	if got.Valid || len(got.Diagnostics) != 2 {
+		t.Fatalf("diagnostics = %+v", got.Diagnostics)
+	}
+	if d := got.Diagnostics[0]; d.Code != DiagnosticMissingTimeout || d.Severity != DiagnosticWarning {
+		t.Fatalf("diagnostic 0 = %+v", d)
+	}
+	if d := got.Diagnostics[1]; d.Code != DiagnosticUnknownReference || d.Severity != DiagnosticError {
+		t.Fatalf("diagnostic 1 = %+v", d)
+	}
+
+	if got := ValidateWorkflowTemplate(nil); got.Valid || got.Diagnostics[0].Code != DiagnosticInvalidTemplate || got.Diagnostics[0].Position != nil {
+		t.Fatalf("empty template = %+v", got)
+	}
+}
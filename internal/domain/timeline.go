@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of a timeline segment.
+const (
+	SegmentExecution = "execution"
+	SegmentApproval  = "approval"
+)
+
+// Outcomes of a timeline segment, besides the step event that closed it.
+const (
+	// SegmentReclaimed closes an execution whose lease expired before another
+	// worker claimed the step again.
+	SegmentReclaimed = "RECLAIMED"
+)
+
+// RunTimeline is a run's steps with the time each spent executing or waiting,
+// as returned by GET /runs/{id}/timeline.
+type RunTimeline struct {
+	RunID  uuid.UUID      `json:"run_id"`
+	Status RunStatus      `json:"status"`
+	Steps  []StepTimeline `json:"steps"`
+}
+
+// StepTimeline is one step of a RunTimeline, in the order of GET
+// /runs/{id}/steps.
+type StepTimeline struct {
+	ID           uuid.UUID         `json:"id"`
+	Name         string            `json:"name"`
+	Status       string            `json:"status"`
+	ParentStepID *uuid.UUID        `json:"parent_step_id,omitempty"`
+	Attempts     int               `json:"attempts"`
+	StartedAt    *time.Time        `json:"started_at,omitempty"`
+	FinishedAt   *time.Time        `json:"finished_at,omitempty"`
+	Segments     []TimelineSegment `json:"segments"`
+}
+
+// TimelineSegment is a span of a step: one execution attempt, from its claim
+// to its result, or one wait for approval. A segment still in progress has no
+// FinishedAt or Outcome.
+type TimelineSegment struct {
+	Kind string `json:"kind"`
+	// Attempt numbers executions from 1; it is 0 for approval waits.
+	Attempt    int        `json:"attempt,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Outcome is the type of the event that closed the segment, such as
+	// STEP_SUCCEEDED or STEP_FAILED_RETRY, or SegmentReclaimed.
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// BuildSegments turns the events of one step, in seq order, into its
+// segments. Events that neither open nor close a segment are ignored.
+func BuildSegments(events []EventRecord) []TimelineSegment {
+	segments := make([]TimelineSegment, 0, 1)
+	open := -1
+	attempt := 0
+
+	closeOpen := func(at time.Time, outcome string) {
+		if open < 0 {
+			return
+		}
+		finished := at
+		segments[open].FinishedAt = &finished
+		segments[open].Outcome = outcome
+		open = -1
+	}
+
+	for _, ev := range events {
+		at := ev.CreatedAt.UTC()
+		switch ev.Type {
+		case EventStepClaimed:
+			closeOpen(at, SegmentReclaimed)
+			attempt++
+			segments = append(segments, TimelineSegment{Kind: SegmentExecution, Attempt: attempt, StartedAt: at})
+			open = len(segments) - 1
+		case EventStepWaitingApproval:
+			closeOpen(at, ev.Type)
+			segments = append(segments, TimelineSegment{Kind: SegmentApproval, StartedAt: at})
+			open = len(segments) - 1
+		case EventStepSucceeded, EventStepFailed, EventStepFailedRetry, EventStepApproved, EventStepCanceled:
+			if open >= 0 && segments[open].Kind == SegmentExecution {
+				if n := eventAttempt(ev.Payload); n > 0 {
+					segments[open].Attempt = n
+					attempt = n
+				}
+			}
+			closeOpen(at, ev.Type)
+		}
+	}
+
+	return segments
+}
+
+// eventAttempt reads the attempt number step failure events carry, or 0.
+func eventAttempt(payload json.RawMessage) int {
+	if len(payload) == 0 {
+		return 0
+	}
+	var p struct {
+		Attempt int `json:"attempt"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return 0
+	}
+	return p.Attempt
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBuildSegments(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+
+	segments := BuildSegments([]EventRecord{
+		{Type: EventStepClaimed, CreatedAt: at(0)},
+		{Type: EventStepClaimed, CreatedAt: at(30)},
+		{Type: EventStepFailedRetry, Payload: json.RawMessage(`{"attempt":2}`), CreatedAt: at(35)},
+		{Type: EventApprovalEscalated, CreatedAt: at(36)},
+		{Type: EventStepClaimed, CreatedAt: at(40)},
+		{Type: EventStepSucceeded, CreatedAt: at(42)},
+	})
+
+	want := []struct {
+		attempt  int
+		started  time.Time
+		finished time.Time
+		outcome  string
+	}{
+		{1, at(0), at(30), SegmentReclaimed},
+		{2, at(30), at(35), EventStepFailedRetry},
+		{3, at(40), at(42), EventStepSucceeded},
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("expected %d segments got %+v", len(want), segments)
+	}
+	for i, w := range want {
+		got := segments[i]
+		if got.Kind != SegmentExecution || got.Attempt != w.attempt || !got.StartedAt.Equal(w.started) ||
+			got.FinishedAt == nil || !got.FinishedAt.Equal(w.finished) || got.Outcome != w.outcome {
+			t.Fatalf("segment %d: unexpected %+v", i, got)
+		}
+	}
+}
+
+func TestBuildSegmentsApprovalAndOpenSegment(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	segments := BuildSegments([]EventRecord{
+		{Type: EventStepWaitingApproval, CreatedAt: start},
+		{Type: EventApprovalTimedOut, CreatedAt: start.Add(time.Hour)},
+		{Type: EventStepApproved, CreatedAt: start.Add(time.Hour)},
+	})
+	if len(segments) != 1 || segments[0].Kind != SegmentApproval || segments[0].Attempt != 0 ||
+		segments[0].Outcome != EventStepApproved || segments[0].FinishedAt == nil {
+		t.Fatalf("unexpected approval segments %+v", segments)
+	}
+
+	segments = BuildSegments([]EventRecord{{Type: EventStepClaimed, CreatedAt: start}})
+	if len(segments) != 1 || segments[0].FinishedAt != nil || segments[0].Outcome != "" {
+		t.Fatalf("expected one open segment, got %+v", segments)
+	}
+
+	if segments := BuildSegments(nil); segments == nil || len(segments) != 0 {
+		t.Fatalf("expected empty non-nil segments, got %#v", segments)
+	}
+}
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrInvalidTransition is wrapped by every *TransitionError.
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+const (
+	TransitionEntityRun  = "run"
+	TransitionEntityStep = "step"
+)
+
+// TransitionError reports a run or step status change the state machine does
+// not allow.
+type TransitionError struct {
+	Entity string
+	From   string
+	To     string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("invalid %s status transition %s -> %s", e.Entity, e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrInvalidTransition
+}
+
+// runTransitions lists the statuses each run status may move to. Terminal
+// statuses have no entry. RUNNING -> RUNNING is kept for runs whose approval
+// gate opened before the worker moved runs to WAITING_APPROVAL: approving
+// such a gate mid-run, through RunRepository.approve or
+// approveTimedOutStep, leaves the run RUNNING.
+var runTransitions = map[RunStatus][]RunStatus{
+	RunPending: {RunRunning, RunWaiting, RunFailed, RunCanceled},
+	RunRunning: {RunRunning, RunWaiting, RunSuccess, RunFailed, RunCanceled},
+	RunWaiting: {RunRunning, RunSuccess, RunFailed, RunCanceled},
+}
+
+// stepTransitions lists the statuses each step status may move to. Terminal
+// statuses have no entry. RUNNING -> RUNNING is a reclaim of a stuck step and
+// RUNNING -> PENDING a scheduled retry.
+var stepTransitions = map[StepStatus][]StepStatus{
+	StepPending: {StepRunning, StepWaiting, StepSkipped, StepCanceled},
+	StepRunning: {StepRunning, StepPending, StepSuccess, StepFailed, StepSkipped, StepCanceled},
+	StepWaiting: {StepSuccess, StepFailed, StepCanceled},
+}
+
+// IsTerminal reports whether a run in status s can no longer change.
+func (s RunStatus) IsTerminal() bool {
+	_, ok := runTransitions[s]
+	return !ok
+}
+
+// IsTerminal reports whether a step in status s can no longer change.
+func (s StepStatus) IsTerminal() bool {
+	_, ok := stepTransitions[s]
+	return !ok
+}
+
+// CanTransition reports whether a run or step may move from one status to the
+// other.
+func CanTransition[S RunStatus | StepStatus](from, to S) bool {
+	switch from := any(from).(type) {
+	case RunStatus:
+		return slices.Contains(runTransitions[from], RunStatus(to))
+	case StepStatus:
+		return slices.Contains(stepTransitions[from], StepStatus(to))
+	}
+	return false
+}
+
+// CheckRunTransition returns a *TransitionError unless a run may move from
+// one status to the other.
+func CheckRunTransition(from, to RunStatus) error {
+	if CanTransition(from, to) {
+		return nil
+	}
+	return &TransitionError{Entity: TransitionEntityRun, From: string(from), To: string(to)}
+}
+
+// CheckStepTransition returns a *TransitionError unless a step may move from
+// one status to the other.
+func CheckStepTransition(from, to StepStatus) error {
+	if CanTransition(from, to) {
+		return nil
+	}
+	return &TransitionError{Entity: TransitionEntityStep, From: string(from), To: string(to)}
+}
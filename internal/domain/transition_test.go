@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckRunTransition(t *testing.T) {
+	tests := []struct {
+		from, to RunStatus
+		ok       bool
+	}{
+		{RunPending, RunRunning, true},
+		{RunPending, RunCanceled, true},
+		{RunRunning, RunRunning, true},
+		{RunRunning, RunSuccess, true},
+		{RunWaiting, RunRunning, true},
+		{RunPending, RunSuccess, false},
+		{RunSuccess, RunRunning, false},
+		{RunCanceled, RunSuccess, false},
+		{RunFailed, RunFailed, false},
+	}
+
+	for _, tc := range tests {
+		err := CheckRunTransition(tc.from, tc.to)
+		if CanTransition(tc.from, tc.to) != tc.ok {
+			t.Fatalf("%s -> %s: expected CanTransition %v", tc.from, tc.to, tc.ok)
+		}
+		if tc.ok && err != nil {
+			t.Fatalf("%s -> %s: unexpected error %v", tc.from, tc.to, err)
+		}
+		if !tc.ok {
+			var transitionErr *TransitionError
+			if !errors.As(err, &transitionErr) || !errors.Is(err, ErrInvalidTransition) {
+				t.Fatalf("%s -> %s: expected TransitionError, got %v", tc.from, tc.to, err)
+			}
+			if transitionErr.Entity != TransitionEntityRun || transitionErr.From != string(tc.from) || transitionErr.To != string(tc.to) {
+				t.Fatalf("unexpected transition error %+v", transitionErr)
+			}
+		}
+	}
+}
+
+func TestCheckStepTransition(t *testing.T) {
+	tests := []struct {
+		from, to StepStatus
+		ok       bool
+	}{
+		{StepPending, StepRunning, true},
+		{StepPending, StepWaiting, true},
+		{StepRunning, StepRunning, true},
+		{StepRunning, StepPending, true},
+		{StepRunning, StepSkipped, true},
+		{StepWaiting, StepSuccess, true},
+		{StepWaiting, StepFailed, true},
+		{StepPending, StepSuccess, false},
+		{StepWaiting, StepRunning, false},
+		{StepCanceled, StepSuccess, false},
+		{StepSuccess, StepPending, false},
+	}
+
+	for _, tc := range tests {
+		err := CheckStepTransition(tc.from, tc.to)
+		if CanTransition(tc.from, tc.to) != tc.ok {
+			t.Fatalf("%s -> %s: expected CanTransition %v", tc.from, tc.to, tc.ok)
+		}
+		if tc.ok != (err == nil) {
+			t.Fatalf("%s -> %s: expected ok=%v, got %v", tc.from, tc.to, tc.ok, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidTransition) {
+			t.Fatalf("%s -> %s: expected ErrInvalidTransition, got %v", tc.from, tc.to, err)
+		}
+	}
+}
+
+func TestStatusIsTerminal(t *testing.T) {
+	for _, status := range []RunStatus{RunSuccess, RunFailed, RunCanceled} {
+		if !status.IsTerminal() {
+			t.Fatalf("expected run status %s to be terminal", status)
+		}
+	}
+	for _, status := range []RunStatus{RunPending, RunRunning, RunWaiting} {
+		if status.IsTerminal() {
+			t.Fatalf("expected run status %s not to be terminal", status)
+		}
+	}
+	for _, status := range []StepStatus{StepSuccess, StepFailed, StepCanceled, StepSkipped} {
+		if !status.IsTerminal() {
+			t.Fatalf("expected step status %s to be terminal", status)
+		}
+	}
+	if StepWaiting.IsTerminal() {
+		t.Fatal("expected WAITING_APPROVAL step not to be terminal")
+	}
+}
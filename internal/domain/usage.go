@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+// TokenUsage totals the usage records of one provider and model or tool:
+// how many executions reported usage, the tokens they used, and how long
+// the executors took. Period is the day (YYYY-MM-DD) or month (YYYY-MM) the
+// row covers, and empty for totals over a whole range.
+type TokenUsage struct {
+	Period           string  `json:"period,omitempty"`
+	Provider         string  `json:"provider,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	Tool             string  `json:"tool,omitempty"`
+	Calls            int64   `json:"calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	TotalLatencyMS   int64   `json:"total_latency_ms"`
+	AvgLatencyMS     float64 `json:"avg_latency_ms"`
+}
+
+// TokenUsageReport is GET /usage/tokens: the totals over the range, one row
+// per provider and model or tool, and those rows per day or month bucket.
+type TokenUsageReport struct {
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Bucket  string       `json:"bucket"`
+	Totals  TokenUsage   `json:"totals"`
+	ByModel []TokenUsage `json:"by_model"`
+	Buckets []TokenUsage `json:"buckets"`
+}
+
+// BuildTokenUsageReport folds daily rows (oldest first) into a report
+// bucketed by day or month. Rows keep the order they first appear in.
+func BuildTokenUsageReport(days []TokenUsage, granularity string) TokenUsageReport {
+	report := TokenUsageReport{
+		Bucket:  granularity,
+		ByModel: groupTokenUsage(days, func(TokenUsage) string { return "" }),
+		Buckets: groupTokenUsage(days, func(d TokenUsage) string {
+			if granularity == UsageBucketMonth && len(d.Period) >= len("2006-01") {
+				return d.Period[:len("2006-01")]
+			}
+			return d.Period
+		}),
+	}
+	for _, d := range days {
+		report.Totals.add(d)
+	}
+	report.Totals.finish()
+	return report
+}
+
+func groupTokenUsage(days []TokenUsage, period func(TokenUsage) string) []TokenUsage {
+	type key struct{ period, provider, model, tool string }
+
+	out := make([]TokenUsage, 0, len(days))
+	index := make(map[key]int, len(days))
+	for _, d := range days {
+		k := key{period(d), d.Provider, d.Model, d.Tool}
+		i, ok := index[k]
+		if !ok {
+			i = len(out)
+			index[k] = i
+			out = append(out, TokenUsage{Period: k.period, Provider: d.Provider, Model: d.Model, Tool: d.Tool})
+		}
+		out[i].add(d)
+	}
+	for i := range out {
+		out[i].finish()
+	}
+	return out
+}
+
+func (u *TokenUsage) add(d TokenUsage) {
+	u.Calls += d.Calls
+	u.PromptTokens += d.PromptTokens
+	u.CompletionTokens += d.CompletionTokens
+	u.TotalLatencyMS += d.TotalLatencyMS
+}
+
+func (u *TokenUsage) finish() {
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	u.AvgLatencyMS = 0
+	if u.Calls > 0 {
+		u.AvgLatencyMS = float64(u.TotalLatencyMS) / float64(u.Calls)
+	}
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildTokenUsageReport(t *testing.T) {
+	days := []TokenUsage{
+		{Period: "2026-01-31", Provider: "openai", Model: "gpt", Calls: 2, PromptTokens: 100, CompletionTokens: 40, TotalLatencyMS: 300},
+		{Period: "2026-01-31", Tool: "search", Calls: 1, TotalLatencyMS: 50},
+		{Period: "2026-02-01", Provider: "openai", Model: "gpt", Calls: 1, PromptTokens: 10, CompletionTokens: 5, TotalLatencyMS: 100},
+	}
+
+	report := BuildTokenUsageReport(days, UsageBucketMonth)
+	want := TokenUsage{Calls: 4, PromptTokens: 110, CompletionTokens: 45, TotalTokens: 155, TotalLatencyMS: 450, AvgLatencyMS: 112.5}
+	if report.Totals != want {
+		t.Fatalf("expected totals %+v got %+v", want, report.Totals)
+	}
+	if len(report.ByModel) != 2 {
+		t.Fatalf("expected 2 models got %+v", report.ByModel)
+	}
+	gpt := TokenUsage{Provider: "openai", Model: "gpt", Calls: 3, PromptTokens: 110, CompletionTokens: 45, TotalTokens: 155, TotalLatencyMS: 400, AvgLatencyMS: 400.0 / 3}
+	if report.ByModel[0] != gpt {
+		t.Fatalf("expected %+v got %+v", gpt, report.ByModel[0])
+	}
+
+	var periods []string
+	for _, b := range report.Buckets {
+		periods = append(periods, b.Period+"/"+b.Model+b.Tool)
+	}
+	if want := []string{"2026-01/gpt", "2026-01/search", "2026-02/gpt"}; !reflect.DeepEqual(periods, want) {
+		t.Fatalf("expected buckets %v got %v", want, periods)
+	}
+
+	if daily := BuildTokenUsageReport(days, UsageBucketDay); len(daily.Buckets) != 3 || daily.Buckets[2].Period != "2026-02-01" {
+		t.Fatalf("unexpected daily buckets: %+v", daily.Buckets)
+	}
+	if empty := BuildTokenUsageReport(nil, UsageBucketDay); empty.Totals.AvgLatencyMS != 0 || len(empty.ByModel) != 0 || len(empty.Buckets) != 0 {
+		t.Fatalf("expected an empty report, got %+v", empty)
+	}
+}
@@ -0,0 +1,175 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookPending   WebhookDeliveryStatus = "PENDING"
+	WebhookDelivered WebhookDeliveryStatus = "DELIVERED"
+	WebhookFailed    WebhookDeliveryStatus = "FAILED"
+)
+
+const DefaultWebhookMaxAttempts = 8
+
+const (
+	MinWebhookSecretLength = 16
+	MaxWebhookSecretLength = 256
+)
+
+// After a signing key rotation the previous versions keep signing for the
+// overlap window so receivers can switch secrets without rejecting deliveries.
+const (
+	DefaultWebhookSigningKeyOverlap = 24 * time.Hour
+	MaxWebhookSigningKeyOverlap     = 30 * 24 * time.Hour
+)
+
+// WebhookSigningKey is one version of a tenant's webhook signing secret.
+// Secret is only populated when the version was just created.
+type WebhookSigningKey struct {
+	KeyID     string     `json:"key_id"`
+	Version   int        `json:"version"`
+	Secret    string     `json:"secret,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// TerminalWebhookPayload is the body delivered when a run reaches a terminal
+// status.
+type TerminalWebhookPayload struct {
+	RunID      uuid.UUID `json:"run_id"`
+	Status     RunStatus `json:"status"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// ApprovalWebhookPayload is the body delivered when an approval gate of a run
+// starts waiting. ExpiresAt and OnExpiry are set when the gate has an approval
+// timeout.
+type ApprovalWebhookPayload struct {
+	RunID        uuid.UUID             `json:"run_id"`
+	StepID       uuid.UUID             `json:"step_id"`
+	Status       RunStatus             `json:"status"`
+	ApprovalName string                `json:"approval_name,omitempty"`
+	ApprovalURL  string                `json:"approval_url"`
+	WaitingSince time.Time             `json:"waiting_since"`
+	ExpiresAt    *time.Time            `json:"expires_at,omitempty"`
+	OnExpiry     ApprovalTimeoutAction `json:"on_expiry,omitempty"`
+}
+
+// EventWebhookPayload is the body delivered for subscribed run events.
+type EventWebhookPayload struct {
+	RunID     uuid.UUID       `json:"run_id"`
+	EventID   uuid.UUID       `json:"event_id"`
+	StepID    *uuid.UUID      `json:"step_id,omitempty"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// WebhookDeliveryRecord is one outbox delivery with its attempt history, as
+// shown to the tenant that owns the run.
+type WebhookDeliveryRecord struct {
+	ID               uuid.UUID              `json:"id"`
+	RunID            uuid.UUID              `json:"run_id"`
+	EventID          *uuid.UUID             `json:"event_id,omitempty"`
+	EventType        string                 `json:"event_type"`
+	URL              string                 `json:"url"`
+	Status           WebhookDeliveryStatus  `json:"status"`
+	Attempts         int                    `json:"attempts"`
+	MaxAttempts      int                    `json:"max_attempts"`
+	NextAttemptAt    time.Time              `json:"next_attempt_at"`
+	FirstAttemptedAt *time.Time             `json:"first_attempted_at"`
+	LastStatusCode   *int                   `json:"last_status_code"`
+	LastError        *string                `json:"last_error"`
+	DeliveredAt      *time.Time             `json:"delivered_at"`
+	CreatedAt        time.Time              `json:"created_at"`
+	AttemptLog       []WebhookAttemptRecord `json:"attempt_log"`
+}
+
+// WebhookAttemptRecord is a single POST made for a delivery. StatusCode is nil
+// when no response was received.
+type WebhookAttemptRecord struct {
+	Attempt    int       `json:"attempt"`
+	StatusCode *int      `json:"status_code"`
+	LatencyMS  int64     `json:"latency_ms"`
+	Error      *string   `json:"error"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// NormalizeWebhookEvents trims and de-duplicates event subscriptions and
+// rejects types that are not in SubscribableEventTypes.
+func NormalizeWebhookEvents(events []string) ([]string, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]struct{}, len(events))
+	out := make([]string, 0, len(events))
+	for _, e := range events {
+		e = strings.ToUpper(strings.TrimSpace(e))
+		if !IsSubscribableEventType(e) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidWebhookEvent, e)
+		}
+		if _, ok := seen[e]; ok {
+			continue
+		}
+		seen[e] = struct{}{}
+		out = append(out, e)
+	}
+
+	return out, nil
+}
+
+// ValidateWebhookSecret accepts an empty secret (none supplied) or one between
+// MinWebhookSecretLength and MaxWebhookSecretLength bytes without surrounding
+// whitespace.
+func ValidateWebhookSecret(secret string) error {
+	if secret == "" {
+		return nil
+	}
+	if strings.TrimSpace(secret) != secret {
+		return ErrInvalidWebhookSecret
+	}
+	if len(secret) < MinWebhookSecretLength || len(secret) > MaxWebhookSecretLength {
+		return ErrInvalidWebhookSecret
+	}
+	return nil
+}
+
+// WebhookSigningKeyID is the key id sent in X-Signature for a signing key
+// version, for example "k3".
+func WebhookSigningKeyID(version int) string {
+	return "k" + strconv.Itoa(version)
+}
+
+// ParseWebhookSigningKeyID returns the version named by a key id such as "k3".
+func ParseWebhookSigningKeyID(keyID string) (int, bool) {
+	digits, ok := strings.CutPrefix(keyID, "k")
+	if !ok {
+		return 0, false
+	}
+	version, err := strconv.Atoi(digits)
+	if err != nil || version <= 0 || WebhookSigningKeyID(version) != keyID {
+		return 0, false
+	}
+	return version, true
+}
+
+// ValidateWebhookSigningKeyOverlap accepts 0 (retire previous versions at
+// once) through MaxWebhookSigningKeyOverlap.
+func ValidateWebhookSigningKeyOverlap(overlap time.Duration) error {
+	if overlap < 0 || overlap > MaxWebhookSigningKeyOverlap {
+		return ErrInvalidWebhookKeyOverlap
+	}
+	return nil
+}
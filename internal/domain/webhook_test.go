@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeWebhookEvents(t *testing.T) {
+	got, err := NormalizeWebhookEvents([]string{" step_waiting_approval", EventStepFailedRetry, EventStepWaitingApproval})
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	want := []string{EventStepWaitingApproval, EventStepFailedRetry}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+
+	if got, err := NormalizeWebhookEvents(nil); err != nil || got != nil {
+		t.Fatalf("expected nil subscriptions for empty input, got %v (%v)", got, err)
+	}
+
+	if _, err := NormalizeWebhookEvents([]string{"RUN_SUCCEEDED"}); !errors.Is(err, ErrInvalidWebhookEvent) {
+		t.Fatalf("expected ErrInvalidWebhookEvent, got %v", err)
+	}
+}
+
+func TestValidateWebhookSecret(t *testing.T) {
+	valid := []string{"", strings.Repeat("s", MinWebhookSecretLength), strings.Repeat("s", MaxWebhookSecretLength)}
+	for _, secret := range valid {
+		if err := ValidateWebhookSecret(secret); err != nil {
+			t.Fatalf("expected %q to be valid, got %v", secret, err)
+		}
+	}
+
+	invalid := []string{"short", strings.Repeat("s", MaxWebhookSecretLength+1), " " + strings.Repeat("s", MinWebhookSecretLength)}
+	for _, secret := range invalid {
+		if err := ValidateWebhookSecret(secret); !errors.Is(err, ErrInvalidWebhookSecret) {
+			t.Fatalf("expected ErrInvalidWebhookSecret for %q, got %v", secret, err)
+		}
+	}
+}
+
+func TestWebhookSigningKeyID(t *testing.T) {
+	if got := WebhookSigningKeyID(3); got != "k3" {
+		t.Fatalf("expected k3 got %q", got)
+	}
+	if v, ok := ParseWebhookSigningKeyID("k12"); !ok || v != 12 {
+		t.Fatalf("expected version 12, got %d ok=%v", v, ok)
+	}
+	for _, keyID := range []string{"", "k", "k0", "k-1", "k01", "3", "v3"} {
+		if _, ok := ParseWebhookSigningKeyID(keyID); ok {
+			t.Fatalf("expected %q to be rejected", keyID)
+		}
+	}
+}
+
+func TestValidateWebhookSigningKeyOverlap(t *testing.T) {
+	for _, overlap := range []time.Duration{0, time.Hour, MaxWebhookSigningKeyOverlap} {
+		if err := ValidateWebhookSigningKeyOverlap(overlap); err != nil {
+			t.Fatalf("expected %s to be valid, got %v", overlap, err)
+		}
+	}
+	for _, overlap := range []time.Duration{-time.Second, MaxWebhookSigningKeyOverlap + time.Second} {
+		if err := ValidateWebhookSigningKeyOverlap(overlap); !errors.Is(err, ErrInvalidWebhookKeyOverlap) {
+			t.Fatalf("expected ErrInvalidWebhookKeyOverlap for %s, got %v", overlap, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// WorkerActiveWindow is how recently a worker must have been seen to count
+	// as active.
+	WorkerActiveWindow = 2 * time.Minute
+	// WorkerLivenessLookback is how far back the liveness report looks for
+	// workers; ones seen within it but not within WorkerActiveWindow are stale.
+	WorkerLivenessLookback = 24 * time.Hour
+)
+
+// WorkerRecord is one row of the workers registry.
+type WorkerRecord struct {
+	ID               uuid.UUID `json:"id"`
+	APIKeyID         uuid.UUID `json:"api_key_id"`
+	Hostname         string    `json:"hostname"`
+	Version          string    `json:"version"`
+	MinSchemaVersion int       `json:"min_schema_version"`
+	Features         []string  `json:"features"`
+	// Pricing is what the worker's executors declare their step types cost.
+	Pricing    map[StepName]StepPricing `json:"pricing,omitempty"`
+	StartedAt  time.Time                `json:"started_at"`
+	LastSeenAt time.Time                `json:"last_seen_at"`
+	// InFlightSteps is how many steps the worker reported executing in its
+	// last heartbeat.
+	InFlightSteps int `json:"in_flight_steps"`
+	// Stale is set when the worker was not seen within WorkerActiveWindow.
+	Stale bool `json:"stale"`
+	// ActiveLeases is how many RUNNING steps the worker holds an unexpired
+	// lease on.
+	ActiveLeases int `json:"active_leases"`
+}
+
+// WorkerLiveness reports the workers seen within WorkerLivenessLookback and the
+// tenants left without a live one: non-revoked keys with PENDING or RUNNING
+// runs, or with a worker in the lookback, but no worker seen within
+// WorkerActiveWindow.
+type WorkerLiveness struct {
+	Workers                  []WorkerRecord `json:"workers"`
+	StaleWorkers             int            `json:"stale_workers"`
+	TenantsWithoutLiveWorker []uuid.UUID    `json:"tenants_without_live_worker"`
+}
+
+// WorkerFleetWarnings describes version skew between active workers: more than
+// one binary version, required schema version, or feature set. It returns nil
+// for a uniform fleet.
+func WorkerFleetWarnings(workers []WorkerRecord) []string {
+	var (
+		versions       []string
+		schemaVersions []int
+		featureSets    []string
+	)
+	for _, w := range workers {
+		if !slices.Contains(versions, w.Version) {
+			versions = append(versions, w.Version)
+		}
+		if !slices.Contains(schemaVersions, w.MinSchemaVersion) {
+			schemaVersions = append(schemaVersions, w.MinSchemaVersion)
+		}
+		features := slices.Clone(w.Features)
+		slices.Sort(features)
+		if set := strings.Join(features, ","); !slices.Contains(featureSets, set) {
+			featureSets = append(featureSets, set)
+		}
+	}
+
+	var warnings []string
+	if len(versions) > 1 {
+		slices.Sort(versions)
+		warnings = append(warnings, "mixed worker versions active: "+strings.Join(versions, ", "))
+	}
+	if len(schemaVersions) > 1 {
+		slices.Sort(schemaVersions)
+		warnings = append(warnings, "active workers require different schema versions: "+joinInts(schemaVersions))
+	}
+	if len(featureSets) > 1 {
+		warnings = append(warnings, "active workers support different feature sets")
+	}
+	return warnings
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		parts = append(parts, strconv.Itoa(v))
+	}
+	return strings.Join(parts, ", ")
+}
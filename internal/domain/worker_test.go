@@ -0,0 +1,34 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package domain
+
+import "testing"
+
+func TestWorkerFleetWarnings(t *testing.T) {
+	uniform := []WorkerRecord{
+		{Version: "v1.4.0", MinSchemaVersion: 22, Features: []string{"run_summary", "webhook_signing_keys"}},
+		{Version: "v1.4.0", MinSchemaVersion: 22, Features: []string{"webhook_signing_keys", "run_summary"}},
+	}
+	if warnings := WorkerFleetWarnings(uniform); warnings != nil {
+		t.Fatalf("expected no warnings for a uniform fleet, got %v", warnings)
+	}
+	if warnings := WorkerFleetWarnings(nil); warnings != nil {
+		t.Fatalf("expected no warnings without workers, got %v", warnings)
+	}
+
+	mixed := append(uniform, WorkerRecord{Version: "v1.3.2", MinSchemaVersion: 20, Features: []string{"webhook_signing_keys"}})
+	warnings := WorkerFleetWarnings(mixed)
+	want := []string{
+		"mixed worker versions active: v1.3.2, v1.4.0",
+		"active workers require different schema versions: 20, 22",
+		"active workers support different feature sets",
+	}
+	if len(warnings) != len(want) {
+		t.Fatalf("expected %v got %v", want, warnings)
+	}
+	for i := range want {
+		if warnings[i] != want[i] {
+			t.Fatalf("expected %q got %q", want[i], warnings[i])
+		}
+	}
+}
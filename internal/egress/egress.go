@@ -0,0 +1,237 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package egress controls where the worker's outbound HTTP may go: webhook
+// deliveries and the HTTP clients executors get from executors.NewHTTPClient.
+// Every connection is checked on the address actually dialed, after DNS
+// resolution, so a name that resolved to an allowed address when the URL was
+// accepted and resolves to a blocked one when dialed (DNS rebinding) is still
+// refused, as are redirects to blocked addresses. A policy may also carry a
+// tenant's host allow-list, which limits where executors' requests may go.
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/transport/middleware"
+)
+
+// ErrBlocked is returned for connections the policy refuses.
+var ErrBlocked = errors.New("egress blocked")
+
+// alwaysBlocked is refused whatever the policy allows: the unspecified
+// addresses, the link-local ranges cloud metadata endpoints such as
+// 169.254.169.254 live in, and the metadata addresses outside them.
+var alwaysBlocked = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("100.100.100.200/32"), // Alibaba Cloud metadata
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("fd00:ec2::254/128"), // AWS metadata over IPv6
+}
+
+// Policy decides which addresses outbound requests may connect to. The zero
+// Policy allows everything but the always blocked ranges.
+type Policy struct {
+	// Proxy carries every request when set; otherwise the HTTP_PROXY,
+	// HTTPS_PROXY, and NO_PROXY environment variables apply. The proxy's
+	// own address is checked like any other, and it resolves the names of
+	// the requests it carries, so it must enforce its own egress rules.
+	Proxy *url.URL
+	// Allow limits connections to these ranges when not empty.
+	Allow []netip.Prefix
+	// Deny refuses these ranges; it wins over Allow.
+	Deny []netip.Prefix
+	// Hosts, when not empty, limits requests to the hosts and ports its
+	// rules allow. Name rules are matched against the request URL, every
+	// redirect included. Address rules are matched against the address
+	// actually dialed, after DNS resolution, so a name no name rule allows
+	// may still be reached at an allowed address. Requests through a proxy
+	// must match by name or address literal, since the proxy resolves them.
+	Hosts HostRules
+	// BlockPrivate also refuses PrivateRanges, whatever Allow and Hosts
+	// allow. A request through a proxy is checked on its URL only: the proxy
+	// resolves its name and may itself be on a private address.
+	BlockPrivate bool
+}
+
+// ParsePolicy builds a Policy from a proxy URL and comma-separated lists of
+// CIDRs or bare addresses, any of which may be empty.
+func ParsePolicy(proxyURL, allow, deny string) (Policy, error) {
+	var (
+		p   Policy
+		err error
+	)
+	if proxyURL = strings.TrimSpace(proxyURL); proxyURL != "" {
+		p.Proxy, err = url.Parse(proxyURL)
+		if err != nil || (p.Proxy.Scheme != "http" && p.Proxy.Scheme != "https") || p.Proxy.Host == "" {
+			return Policy{}, errors.New("proxy must be an http(s) URL")
+		}
+	}
+	if p.Allow, err = middleware.ParseCIDRList(allow); err != nil {
+		return Policy{}, fmt.Errorf("allow list: %w", err)
+	}
+	if p.Deny, err = middleware.ParseCIDRList(deny); err != nil {
+		return Policy{}, fmt.Errorf("deny list: %w", err)
+	}
+	return p, nil
+}
+
+// Check returns an error wrapping ErrBlocked when addr may not be connected to.
+func (p Policy) Check(addr netip.Addr) error {
+	// Zoned addresses never match a prefix, and IPv4-mapped ones only match
+	// IPv6 prefixes.
+	addr = addr.WithZone("").Unmap()
+	for _, prefix := range alwaysBlocked {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("%w: %s is a link-local or metadata address", ErrBlocked, addr)
+		}
+	}
+	for _, prefix := range p.Deny {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("%w: %s is denied", ErrBlocked, addr)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, prefix := range p.Allow {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not allowed", ErrBlocked, addr)
+}
+
+// Transport returns a transport that only connects to addresses the policy
+// allows, through Proxy when set. Requests whose URL names a blocked address
+// are refused before any proxy sees them.
+func (p Policy) Transport() http.RoundTripper {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:        30 * time.Second,
+		KeepAlive:      30 * time.Second,
+		ControlContext: p.control,
+	}
+	base.DialContext = dialer.DialContext
+	base.Proxy = http.ProxyFromEnvironment
+	if p.Proxy != nil {
+		base.Proxy = http.ProxyURL(p.Proxy)
+	}
+	return &transport{policy: p, base: base}
+}
+
+// hostAllowedKey marks the context of a request the host rules allowed by
+// its URL, so its connection is only checked against the address ranges.
+type hostAllowedKey struct{}
+
+// directKey marks the context of a request dialed without a proxy, whose
+// dialed address BlockPrivate applies to.
+type directKey struct{}
+
+// checkPrivate returns an error wrapping ErrBlocked when the policy blocks
+// private addresses and addr is one.
+func (p Policy) checkPrivate(addr netip.Addr) error {
+	if !p.BlockPrivate {
+		return nil
+	}
+	addr = addr.WithZone("").Unmap()
+	for _, prefix := range PrivateRanges {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("%w: %s is a loopback or private address", ErrBlocked, addr)
+		}
+	}
+	return nil
+}
+
+// control runs once the dialer resolved the address, right before connecting.
+func (p Policy) control(ctx context.Context, _, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: unexpected address %q", ErrBlocked, address)
+	}
+	if err := p.Check(addrPort.Addr()); err != nil {
+		return err
+	}
+	if ctx.Value(directKey{}) != nil {
+		if err := p.checkPrivate(addrPort.Addr()); err != nil {
+			return err
+		}
+	}
+	if len(p.Hosts) == 0 || ctx.Value(hostAllowedKey{}) != nil || p.Hosts.allowsAddr(addrPort.Addr(), addrPort.Port()) {
+		return nil
+	}
+	return blockedByHosts(addrPort.Addr().String(), addrPort.Port())
+}
+
+type transport struct {
+	policy Policy
+	base   *http.Transport
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	allowed, direct, err := t.allow(req)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	ctx := req.Context()
+	if allowed {
+		ctx = context.WithValue(ctx, hostAllowedKey{}, true)
+	}
+	if direct {
+		ctx = context.WithValue(ctx, directKey{}, true)
+	}
+	return t.base.RoundTrip(req.WithContext(ctx))
+}
+
+// allow checks the request URL against the policy. It reports whether the
+// host rules allow the request by its URL alone, in which case only the
+// address ranges apply to the address dialed, and whether the request is
+// dialed without a proxy.
+func (t *transport) allow(req *http.Request) (allowed, direct bool, err error) {
+	host, port := urlHostPort(req.URL)
+	addr, err := netip.ParseAddr(host)
+	literal := err == nil
+	if literal {
+		if err := t.policy.Check(addr); err != nil {
+			return false, false, err
+		}
+		if err := t.policy.checkPrivate(addr); err != nil {
+			return false, false, err
+		}
+	}
+	proxyURL, err := t.base.Proxy(req)
+	if err != nil {
+		return false, false, err
+	}
+	direct = proxyURL == nil
+
+	rules := t.policy.Hosts
+	if len(rules) == 0 {
+		return false, direct, nil
+	}
+	if (literal && rules.allowsAddr(addr, port)) || (!literal && rules.allowsName(host, port)) {
+		return true, direct, nil
+	}
+	if literal || !rules.hasAddrRules() || !direct {
+		return false, false, blockedByHosts(host, port)
+	}
+	return false, direct, nil
+}
+
+// CloseIdleConnections closes the connections the transport keeps open.
+func (t *transport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+}
@@ -0,0 +1,308 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package egress
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy(" http://proxy.internal:3128 ", "10.0.0.0/8, 203.0.113.7", "")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if p.Proxy == nil || p.Proxy.Host != "proxy.internal:3128" || len(p.Allow) != 2 || len(p.Deny) != 0 {
+		t.Fatalf("unexpected policy %+v", p)
+	}
+
+	if p, err := ParsePolicy("", "", ""); err != nil || p.Proxy != nil || p.Allow != nil || p.Deny != nil {
+		t.Fatalf("expected an empty policy, got %+v, %v", p, err)
+	}
+	for _, args := range [][3]string{
+		{"socks5://proxy:1080", "", ""},
+		{"http://", "", ""},
+		{"", "10.0.0.0/33", ""},
+		{"", "", "nope"},
+	} {
+		if _, err := ParsePolicy(args[0], args[1], args[2]); err == nil {
+			t.Fatalf("expected %q to be rejected", args)
+		}
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	open := Policy{}
+	for _, addr := range []string{
+		"169.254.169.254",
+		"::ffff:169.254.169.254",
+		"fe80::1%eth0",
+		"fd00:ec2::254",
+		"100.100.100.200",
+		"0.0.0.0",
+		"::",
+	} {
+		if err := open.Check(netip.MustParseAddr(addr)); !errors.Is(err, ErrBlocked) {
+			t.Fatalf("expected %s to be blocked, got %v", addr, err)
+		}
+	}
+	if err := open.Check(netip.MustParseAddr("10.1.2.3")); err != nil {
+		t.Fatalf("expected private addresses allowed by default, got %v", err)
+	}
+
+	p := Policy{
+		Allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		Deny:  []netip.Prefix{netip.MustParsePrefix("10.9.0.0/16")},
+	}
+	if err := p.Check(netip.MustParseAddr("10.1.2.3")); err != nil {
+		t.Fatalf("expected an allowed address, got %v", err)
+	}
+	if err := p.Check(netip.MustParseAddr("10.9.0.1")); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("expected the deny list to win over the allow list, got %v", err)
+	}
+	if err := p.Check(netip.MustParseAddr("203.0.113.7")); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("expected addresses outside the allow list blocked, got %v", err)
+	}
+	if err := (Policy{Allow: []netip.Prefix{netip.MustParsePrefix("169.254.0.0/16")}}).Check(netip.MustParseAddr("169.254.169.254")); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("expected metadata addresses blocked even when allowed, got %v", err)
+	}
+}
+
+type stubResolver map[string][]netip.Addr
+
+func (r stubResolver) LookupNetIP(_ context.Context, _, host string) ([]netip.Addr, error) {
+	return r[host], nil
+}
+
+func TestPolicyCheckURL(t *testing.T) {
+	resolver := stubResolver{
+		"public.example": {netip.MustParseAddr("203.0.113.7")},
+		"mixed.example":  {netip.MustParseAddr("203.0.113.7"), netip.MustParseAddr("::1")},
+	}
+	p := Policy{Deny: PrivateRanges}
+	ctx := context.Background()
+
+	if err := p.CheckURL(ctx, resolver, "https://public.example/hook"); err != nil {
+		t.Fatalf("expected a public host allowed, got %v", err)
+	}
+	for _, raw := range []string{
+		"https://mixed.example/hook",
+		"http://10.1.2.3:8080/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://[fd12::1]/hook",
+	} {
+		if err := p.CheckURL(ctx, resolver, raw); !errors.Is(err, ErrBlocked) {
+			t.Fatalf("expected %s blocked, got %v", raw, err)
+		}
+	}
+	if err := p.CheckURL(ctx, resolver, "https://missing.example/hook"); !errors.Is(err, ErrUnresolvable) {
+		t.Fatalf("expected an unresolvable host refused, got %v", err)
+	}
+	if err := (Policy{}).CheckURL(ctx, resolver, "http://192.168.1.1/hook"); err != nil {
+		t.Fatalf("expected private addresses allowed without a deny list, got %v", err)
+	}
+}
+
+func TestTransportChecksDialedAddress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	// A name is only checked once resolved, on the address dialed.
+	u, _ := url.Parse(srv.URL)
+	byName := "http://localhost:" + u.Port()
+
+	denied := &http.Client{Transport: Policy{Deny: []netip.Prefix{
+		netip.MustParsePrefix("127.0.0.0/8"),
+		netip.MustParsePrefix("::1/128"),
+	}}.Transport()}
+	if _, err := denied.Get(byName); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("expected the resolved address blocked, got %v", err)
+	}
+
+	allowed := &http.Client{Transport: Policy{}.Transport()}
+	resp, err := allowed.Get(byName)
+	if err != nil {
+		t.Fatalf("expected loopback allowed by default, got %v", err)
+	}
+	resp.Body.Close()
+
+	if _, err := allowed.Get(srv.URL + "/redirect"); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("expected a redirect to a metadata address blocked, got %v", err)
+	}
+}
+
+func TestTransportChecksLiteralAddressBeforeProxy(t *testing.T) {
+	var proxied int
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied++
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := &http.Client{Transport: Policy{Proxy: proxyURL}.Transport()}
+
+	_, err := client.Get("http://[fd00:ec2::254]/latest/meta-data/")
+	if !errors.Is(err, ErrBlocked) || !strings.Contains(err.Error(), "metadata") {
+		t.Fatalf("expected the metadata address blocked, got %v", err)
+	}
+
+	resp, err := client.Get("http://service.example/")
+	if err != nil {
+		t.Fatalf("expected the request to go through the proxy, got %v", err)
+	}
+	resp.Body.Close()
+	if proxied != 1 {
+		t.Fatalf("expected one proxied request, got %d", proxied)
+	}
+}
+
+func TestParseHostRule(t *testing.T) {
+	for raw, want := range map[string]string{
+		" API.Example.com. ":   "api.example.com",
+		"*.example.com:8443":   "*.example.com:8443",
+		"203.0.113.7:443":      "203.0.113.7:443",
+		"10.1.0.0/16":          "10.1.0.0/16",
+		"10.1.2.3/16:5432":     "10.1.0.0/16:5432",
+		"2001:DB8::1":          "2001:db8::1",
+		"[2001:db8::1/32]:443": "[2001:db8::/32]:443",
+		"[2001:db8::1]:443":    "[2001:db8::1]:443",
+	} {
+		rule, err := ParseHostRule(raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", raw, err)
+		}
+		if got := rule.String(); got != want {
+			t.Fatalf("expected %q to read as %q, got %q", raw, want, got)
+		}
+	}
+	for _, raw := range []string{"", "*", "*.", "exa mple.com", "-bad.example", "example.com:0", "example.com:70000", "[::1", "[::1]443", "fe80::1%eth0"} {
+		if _, err := ParseHostRule(raw); !errors.Is(err, ErrInvalidHostRule) {
+			t.Fatalf("expected %q rejected, got %v", raw, err)
+		}
+	}
+
+	got, err := NormalizeHostRules([]string{"B.example", "a.example", "b.example."})
+	if err != nil || strings.Join(got, ",") != "a.example,b.example" {
+		t.Fatalf("expected sorted unique rules, got %v, %v", got, err)
+	}
+	if got, err := NormalizeHostRules(nil); err != nil || got != nil {
+		t.Fatalf("expected no rules, got %v, %v", got, err)
+	}
+}
+
+func TestTransportEnforcesHostRules(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	byName := "http://localhost:" + u.Port()
+
+	get := func(rules []string, target string) error {
+		hosts, err := ParseHostRules(rules)
+		if err != nil {
+			t.Fatalf("parse %v: %v", rules, err)
+		}
+		resp, err := (&http.Client{Transport: Policy{Hosts: hosts}.Transport()}).Get(target)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	for _, rules := range [][]string{
+		{"localhost:" + u.Port()},
+		{"LOCALHOST"},
+		// Address rules hold for the address dialed, whatever the name.
+		{"127.0.0.0/8", "::1"},
+	} {
+		if err := get(rules, byName); err != nil {
+			t.Fatalf("expected %v to allow %s, got %v", rules, byName, err)
+		}
+	}
+	for _, rules := range [][]string{
+		{"localhost:1"},
+		{"*.localhost"},
+		{"127.0.0.0/8:1", "::1:1"},
+		{"203.0.113.0/24"},
+	} {
+		if err := get(rules, byName); !errors.Is(err, ErrBlocked) || !strings.Contains(err.Error(), "allow-list") {
+			t.Fatalf("expected %v to block %s, got %v", rules, byName, err)
+		}
+	}
+	if err := get([]string{"localhost"}, srv.URL); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("expected an address literal to need an address rule, got %v", err)
+	}
+	if err := get([]string{"127.0.0.1:" + u.Port()}, srv.URL); err != nil {
+		t.Fatalf("expected the literal allowed, got %v", err)
+	}
+
+	// Behind a proxy the dialed address is the proxy's, so only the URL counts.
+	var proxied int
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied++
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	hosts, _ := ParseHostRules([]string{"api.example", "127.0.0.0/8"})
+	client := &http.Client{Transport: Policy{Proxy: proxyURL, Hosts: hosts}.Transport()}
+	if _, err := client.Get("http://other.example/"); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("expected an unlisted name blocked behind the proxy, got %v", err)
+	}
+	resp, err := client.Get("http://api.example/")
+	if err != nil {
+		t.Fatalf("expected a listed name to go through the proxy, got %v", err)
+	}
+	resp.Body.Close()
+	if proxied != 1 {
+		t.Fatalf("expected one proxied request, got %d", proxied)
+	}
+}
+
+func TestTransportBlocksPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	byName := "http://localhost:" + u.Port()
+	hosts, _ := ParseHostRules([]string{"localhost", "127.0.0.1"})
+
+	// A listed name that resolves to loopback is refused once dialed, and a
+	// listed loopback literal before dialing.
+	client := &http.Client{Transport: Policy{Hosts: hosts, BlockPrivate: true}.Transport()}
+	for _, target := range []string{byName, srv.URL} {
+		if _, err := client.Get(target); !errors.Is(err, ErrBlocked) || !strings.Contains(err.Error(), "private") {
+			t.Fatalf("expected %s blocked as private, got %v", target, err)
+		}
+	}
+
+	// Through a proxy only the URL is checked, so the proxy's own private
+	// address stays reachable.
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxied := &http.Client{Transport: Policy{Proxy: proxyURL, BlockPrivate: true}.Transport()}
+	if _, err := proxied.Get("http://10.0.0.1/"); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("expected a private literal blocked before the proxy, got %v", err)
+	}
+	resp, err := proxied.Get("http://service.example/")
+	if err != nil {
+		t.Fatalf("expected the request to go through the proxy, got %v", err)
+	}
+	resp.Body.Close()
+
+	// Without BlockPrivate the allow-list alone decides.
+	client = &http.Client{Transport: Policy{Hosts: hosts}.Transport()}
+	resp, err = client.Get(byName)
+	if err != nil {
+		t.Fatalf("expected loopback allowed without BlockPrivate, got %v", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package egress
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidHostRule is returned by ParseHostRule for entries that are not
+// host[:port].
+var ErrInvalidHostRule = errors.New("invalid host rule")
+
+// HostRule allows one destination: a host name, every subdomain of a domain,
+// or an address range, on one port or on any.
+type HostRule struct {
+	// Name is a lower-case host name, or "*." followed by the domain whose
+	// subdomains match. It is empty for address rules.
+	Name string
+	// Prefix is the range an address rule matches.
+	Prefix netip.Prefix
+	// Port is the only port allowed, or 0 for any.
+	Port uint16
+}
+
+// ParseHostRule parses "host[:port]", where host is a name, "*." and a
+// domain, an IP address, or a CIDR. IPv6 addresses and ranges are bracketed
+// when a port follows.
+func ParseHostRule(raw string) (HostRule, error) {
+	entry := strings.ToLower(strings.TrimSpace(raw))
+	host, port := entry, ""
+	switch {
+	case strings.HasPrefix(entry, "["):
+		end := strings.IndexByte(entry, ']')
+		if end < 0 {
+			return HostRule{}, fmt.Errorf("%w: %q has an unclosed bracket", ErrInvalidHostRule, raw)
+		}
+		host = entry[1:end]
+		if rest := entry[end+1:]; rest != "" {
+			var ok bool
+			if port, ok = strings.CutPrefix(rest, ":"); !ok {
+				return HostRule{}, fmt.Errorf("%w: %q", ErrInvalidHostRule, raw)
+			}
+		}
+	case strings.Count(entry, ":") == 1:
+		host, port, _ = strings.Cut(entry, ":")
+	}
+
+	var rule HostRule
+	if port != "" {
+		n, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || n == 0 {
+			return HostRule{}, fmt.Errorf("%w: %q has an invalid port", ErrInvalidHostRule, raw)
+		}
+		rule.Port = uint16(n)
+	}
+
+	if prefix, err := netip.ParsePrefix(host); err == nil {
+		rule.Prefix = prefix.Masked()
+		return rule, nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if addr.Zone() != "" {
+			return HostRule{}, fmt.Errorf("%w: %q has a zone", ErrInvalidHostRule, raw)
+		}
+		addr = addr.Unmap()
+		rule.Prefix = netip.PrefixFrom(addr, addr.BitLen())
+		return rule, nil
+	}
+
+	name := strings.TrimSuffix(host, ".")
+	if !validHostName(strings.TrimPrefix(name, "*.")) {
+		return HostRule{}, fmt.Errorf("%w: %q is not a host name, address, or CIDR", ErrInvalidHostRule, raw)
+	}
+	rule.Name = name
+	return rule, nil
+}
+
+// validHostName reports whether name is a DNS name of letters, digits, and
+// hyphens.
+func validHostName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String returns the rule in the form ParseHostRule reads.
+func (r HostRule) String() string {
+	host := r.Name
+	if host == "" {
+		host = r.Prefix.String()
+		if r.Prefix.IsSingleIP() {
+			host = r.Prefix.Addr().String()
+		}
+	}
+	if r.Port == 0 {
+		return host
+	}
+	if r.Name == "" && r.Prefix.Addr().Is6() {
+		host = "[" + host + "]"
+	}
+	return host + ":" + strconv.Itoa(int(r.Port))
+}
+
+func (r HostRule) allowsPort(port uint16) bool {
+	return r.Port == 0 || r.Port == port
+}
+
+// HostRules is a host allow-list.
+type HostRules []HostRule
+
+// ParseHostRules parses entries with ParseHostRule.
+func ParseHostRules(entries []string) (HostRules, error) {
+	rules := make(HostRules, 0, len(entries))
+	for _, entry := range entries {
+		rule, err := ParseHostRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// NormalizeHostRules validates entries and returns them in the form String
+// writes, sorted and de-duplicated. An empty list returns nil, meaning no
+// host restriction.
+func NormalizeHostRules(entries []string) ([]string, error) {
+	rules, err := ParseHostRules(entries)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	out := rules.Strings()
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// Strings returns every rule in the form String writes.
+func (rs HostRules) Strings() []string {
+	out := make([]string, len(rs))
+	for i, r := range rs {
+		out[i] = r.String()
+	}
+	return out
+}
+
+// allowsName reports whether a name rule allows host, a lower-case name
+// without a trailing dot, on port.
+func (rs HostRules) allowsName(host string, port uint16) bool {
+	for _, r := range rs {
+		if r.Name == "" || !r.allowsPort(port) {
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(r.Name, "*"); ok {
+			if len(host) > len(suffix) && strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == r.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAddr reports whether an address rule allows addr on port.
+func (rs HostRules) allowsAddr(addr netip.Addr, port uint16) bool {
+	addr = addr.WithZone("").Unmap()
+	for _, r := range rs {
+		if r.Name == "" && r.allowsPort(port) && r.Prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rs HostRules) hasAddrRules() bool {
+	return slices.ContainsFunc(rs, func(r HostRule) bool { return r.Name == "" })
+}
+
+// urlHostPort returns the lower-case host of u, without a trailing dot, and
+// its port, defaulted from the scheme.
+func urlHostPort(u *url.URL) (string, uint16) {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if n, err := strconv.ParseUint(u.Port(), 10, 16); err == nil {
+		return host, uint16(n)
+	}
+	if u.Scheme == "https" {
+		return host, 443
+	}
+	return host, 80
+}
+
+func blockedByHosts(host string, port uint16) error {
+	return fmt.Errorf("%w: %s is not in the host allow-list", ErrBlocked, net.JoinHostPort(host, strconv.Itoa(int(port))))
+}
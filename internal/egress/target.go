@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+)
+
+// PrivateRanges are the loopback, private, shared address space, and unique
+// local ranges: addresses that reach the deployment's own network rather than
+// the internet.
+var PrivateRanges = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("fc00::/7"),
+}
+
+// ErrUnresolvable is returned by CheckURL when the host has no addresses.
+var ErrUnresolvable = errors.New("host does not resolve")
+
+// Resolver looks up the addresses of a host; *net.Resolver satisfies it.
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// CheckURL resolves the host of raw and returns an error wrapping ErrBlocked
+// when the policy refuses any of its addresses, or ErrUnresolvable when it
+// has none. It vets URLs when they are stored; the worker's Transport still
+// checks the address it dials, since the name may resolve differently then.
+// A nil resolver means net.DefaultResolver.
+func (p Policy) CheckURL(ctx context.Context, resolver Resolver, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return p.Check(addr)
+	}
+
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("%w: %s", ErrUnresolvable, host)
+	}
+	for _, addr := range addrs {
+		if err := p.Check(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package escalation enforces approval SLAs: it periodically escalates runs
+// whose APPROVAL step has been waiting past the configured thresholds, and
+// applies the timeout of APPROVAL steps that waited past their own
+// approval_timeout_seconds.
+package escalation
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const defaultBatchSize = 500
+
+// ApprovalEscalator escalates approvals that waited past thresholds.
+type ApprovalEscalator interface {
+	EscalateWaitingApprovals(ctx context.Context, thresholds []time.Duration, priorityBoost int, batchSize int) (int64, error)
+}
+
+// ApprovalTimer applies approval timeouts.
+type ApprovalTimer interface {
+	TimeOutWaitingApprovals(ctx context.Context, batchSize int) (int64, error)
+}
+
+type Deps struct {
+	Approvals     ApprovalEscalator
+	Timeouts      ApprovalTimer
+	Logger        *slog.Logger
+	Interval      time.Duration
+	Thresholds    []time.Duration
+	PriorityBoost int
+}
+
+// Escalator runs the approval escalation sweep.
+type Escalator struct {
+	approvals     ApprovalEscalator
+	timeouts      ApprovalTimer
+	logger        *slog.Logger
+	interval      time.Duration
+	thresholds    []time.Duration
+	priorityBoost int
+}
+
+func New(deps Deps) *Escalator {
+	l := deps.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+
+	interval := deps.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	boost := deps.PriorityBoost
+	if boost < 0 {
+		boost = 0
+	}
+
+	return &Escalator{
+		approvals:     deps.Approvals,
+		timeouts:      deps.Timeouts,
+		logger:        l,
+		interval:      interval,
+		thresholds:    deps.Thresholds,
+		priorityBoost: boost,
+	}
+}
+
+// Run executes RunOnce immediately and then on every interval until ctx is
+// done. It returns at once when neither thresholds nor timeouts are
+// configured.
+func (e *Escalator) Run(ctx context.Context) {
+	if len(e.thresholds) == 0 && e.timeouts == nil {
+		e.logger.Info("approval escalation disabled")
+		return
+	}
+
+	e.logger.Info("approval escalation started",
+		"interval", e.interval,
+		"thresholds", e.thresholds,
+		"priority_boost", e.priorityBoost,
+	)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			e.logger.Error("approval escalation pass failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			e.logger.Info("approval escalation stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce performs a single escalation sweep, then a timeout sweep.
+func (e *Escalator) RunOnce(ctx context.Context) error {
+	if e.approvals != nil && len(e.thresholds) > 0 {
+		if _, err := e.approvals.EscalateWaitingApprovals(ctx, e.thresholds, e.priorityBoost, defaultBatchSize); err != nil {
+			return err
+		}
+	}
+
+	if e.timeouts != nil {
+		if _, err := e.timeouts.TimeOutWaitingApprovals(ctx, defaultBatchSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
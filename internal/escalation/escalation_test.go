@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package escalation
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+type fakeApprovalEscalator struct {
+	calls         int
+	thresholds    []time.Duration
+	priorityBoost int
+	batchSize     int
+	err           error
+}
+
+func (f *fakeApprovalEscalator) EscalateWaitingApprovals(ctx context.Context, thresholds []time.Duration, priorityBoost int, batchSize int) (int64, error) {
+	f.calls++
+	f.thresholds = thresholds
+	f.priorityBoost = priorityBoost
+	f.batchSize = batchSize
+	return 0, f.err
+}
+
+type fakeApprovalTimer struct {
+	calls     int
+	batchSize int
+	err       error
+}
+
+func (f *fakeApprovalTimer) TimeOutWaitingApprovals(ctx context.Context, batchSize int) (int64, error) {
+	f.calls++
+	f.batchSize = batchSize
+	return 0, f.err
+}
+
+func TestNewDefaults(t *testing.T) {
+	e := New(Deps{PriorityBoost: -3})
+
+	if e.logger == nil {
+		t.Fatal("expected default logger to be set")
+	}
+	if e.interval != time.Minute {
+		t.Fatalf("expected default interval=1m, got %s", e.interval)
+	}
+	if e.priorityBoost != 0 {
+		t.Fatalf("expected negative boost to clamp to 0, got %d", e.priorityBoost)
+	}
+}
+
+func TestRunOnceForwardsPolicy(t *testing.T) {
+	approvals := &fakeApprovalEscalator{}
+	thresholds := []time.Duration{time.Hour, 4 * time.Hour}
+	e := New(Deps{
+		Approvals:     approvals,
+		Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Thresholds:    thresholds,
+		PriorityBoost: 10,
+	})
+
+	if err := e.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if approvals.calls != 1 {
+		t.Fatalf("expected one escalation call, got %d", approvals.calls)
+	}
+	if !slices.Equal(approvals.thresholds, thresholds) || approvals.priorityBoost != 10 {
+		t.Fatalf("unexpected policy forwarded: %v boost=%d", approvals.thresholds, approvals.priorityBoost)
+	}
+	if approvals.batchSize != defaultBatchSize {
+		t.Fatalf("expected batch size %d got %d", defaultBatchSize, approvals.batchSize)
+	}
+}
+
+func TestRunOnceSkipsWithoutThresholds(t *testing.T) {
+	approvals := &fakeApprovalEscalator{}
+	e := New(Deps{Approvals: approvals})
+
+	if err := e.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if approvals.calls != 0 {
+		t.Fatalf("expected no escalation call without thresholds, got %d", approvals.calls)
+	}
+}
+
+func TestRunOnceReturnsEscalationError(t *testing.T) {
+	wantErr := errors.New("db down")
+	e := New(Deps{
+		Approvals:  &fakeApprovalEscalator{err: wantErr},
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Thresholds: []time.Duration{time.Hour},
+	})
+
+	if err := e.RunOnce(context.Background()); !errors.Is(err, wantErr) {
+		t.Fatalf("expected %v got %v", wantErr, err)
+	}
+}
+
+func TestRunOnceTimesOutApprovalsWithoutThresholds(t *testing.T) {
+	approvals := &fakeApprovalEscalator{}
+	timeouts := &fakeApprovalTimer{}
+	e := New(Deps{
+		Approvals: approvals,
+		Timeouts:  timeouts,
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	if err := e.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if approvals.calls != 0 {
+		t.Fatalf("expected no escalation call without thresholds, got %d", approvals.calls)
+	}
+	if timeouts.calls != 1 || timeouts.batchSize != defaultBatchSize {
+		t.Fatalf("expected one timeout sweep with batch %d, got %d calls batch %d", defaultBatchSize, timeouts.calls, timeouts.batchSize)
+	}
+}
+
+func TestRunOnceReturnsTimeoutError(t *testing.T) {
+	wantErr := errors.New("db down")
+	e := New(Deps{
+		Approvals:  &fakeApprovalEscalator{},
+		Timeouts:   &fakeApprovalTimer{err: wantErr},
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Thresholds: []time.Duration{time.Hour},
+	})
+
+	if err := e.RunOnce(context.Background()); !errors.Is(err, wantErr) {
+		t.Fatalf("expected %v got %v", wantErr, err)
+	}
+}
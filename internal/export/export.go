@@ -0,0 +1,303 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package export writes run data as JSON or CSV bundles and builds the
+// tenant-wide export jobs requested through POST /exports in the background,
+// storing each finished bundle as the job's artifact.
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultInterval = 10 * time.Second
+	pageSize        = 50
+	// maxArtifactBytes bounds a tenant export held in memory and stored in
+	// export_jobs; a larger one fails instead.
+	maxArtifactBytes = 512 << 20
+)
+
+// csvHeader names the columns of a CSV export. Every run, step, and event is
+// one row; data holds the record's full JSON, so nothing is lost to the
+// flattening.
+var csvHeader = []string{"record", "run_id", "id", "seq", "name", "status", "attempts", "created_at", "finished_at", "data"}
+
+// ContentType returns the media type of an export in format.
+func ContentType(format string) string {
+	if format == domain.ExportFormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/json"
+}
+
+// WriteRun writes one run's export: the RunExport object in JSON, or its CSV
+// rows under the header.
+func WriteRun(w io.Writer, format string, run domain.RunExport) error {
+	if format == domain.ExportFormatJSON {
+		return json.NewEncoder(w).Encode(run)
+	}
+	enc, err := NewEncoder(w, format)
+	if err != nil {
+		return err
+	}
+	if err := enc.Encode(run); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// Encoder writes a bundle of runs: {"runs":[...]} in JSON, or rows under one
+// header in CSV. Close finishes the bundle.
+type Encoder struct {
+	w      io.Writer
+	format string
+	csv    *csv.Writer
+	n      int
+}
+
+func NewEncoder(w io.Writer, format string) (*Encoder, error) {
+	e := &Encoder{w: w, format: format}
+	switch format {
+	case domain.ExportFormatJSON:
+		if _, err := io.WriteString(w, `{"runs":[`); err != nil {
+			return nil, err
+		}
+	case domain.ExportFormatCSV:
+		e.csv = csv.NewWriter(w)
+		if err := e.csv.Write(csvHeader); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, domain.ErrInvalidExportFormat
+	}
+	return e, nil
+}
+
+// Encode appends one run to the bundle.
+func (e *Encoder) Encode(run domain.RunExport) error {
+	defer func() { e.n++ }()
+	if e.csv != nil {
+		return e.encodeCSV(run)
+	}
+
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	if e.n > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *Encoder) encodeCSV(run domain.RunExport) error {
+	runID := run.Run.ID.String()
+
+	data, err := json.Marshal(run.Run)
+	if err != nil {
+		return err
+	}
+	name := ""
+	if run.Run.TemplateName != nil {
+		name = *run.Run.TemplateName
+	}
+	if err := e.csv.Write([]string{"run", runID, runID, "", name, string(run.Run.Status), "", csvTime(&run.Run.CreatedAt), "", string(data)}); err != nil {
+		return err
+	}
+
+	for _, st := range run.Steps {
+		data, err := json.Marshal(st)
+		if err != nil {
+			return err
+		}
+		if err := e.csv.Write([]string{"step", runID, st.ID.String(), "", st.Name, st.Status, strconv.Itoa(st.Attempts), csvTime(&st.CreatedAt), csvTime(st.FinishedAt), string(data)}); err != nil {
+			return err
+		}
+	}
+
+	for _, ev := range run.Events {
+		if err := e.csv.Write([]string{"event", runID, ev.ID.String(), strconv.FormatInt(ev.Seq, 10), ev.Type, "", "", csvTime(&ev.CreatedAt), "", string(ev.Payload)}); err != nil {
+			return err
+		}
+	}
+
+	e.csv.Flush()
+	return e.csv.Error()
+}
+
+// Close finishes the bundle; it writes nothing more for CSV.
+func (e *Encoder) Close() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+	_, err := io.WriteString(e.w, "]}\n")
+	return err
+}
+
+// Count returns how many runs were encoded.
+func (e *Encoder) Count() int {
+	return e.n
+}
+
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Store claims export jobs, reads the runs they export, and keeps their
+// outcome.
+type Store interface {
+	ClaimExportJob(ctx context.Context) (domain.ExportJob, bool, error)
+	ExportRunPage(ctx context.Context, apiKeyID, after uuid.UUID, limit int) ([]domain.RunExport, error)
+	CompleteExportJob(ctx context.Context, id uuid.UUID, runs int, artifact []byte) error
+	FailExportJob(ctx context.Context, id uuid.UUID, reason string) error
+}
+
+type Deps struct {
+	Store    Store
+	Logger   *slog.Logger
+	Interval time.Duration
+}
+
+// Runner builds queued export jobs.
+type Runner struct {
+	store    Store
+	logger   *slog.Logger
+	interval time.Duration
+}
+
+func New(deps Deps) *Runner {
+	l := deps.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+
+	interval := deps.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	return &Runner{
+		store:    deps.Store,
+		logger:   l,
+		interval: interval,
+	}
+}
+
+// Run executes RunOnce immediately and then on every interval until ctx is
+// done.
+func (r *Runner) Run(ctx context.Context) {
+	if r.store == nil {
+		r.logger.Info("run exports disabled")
+		return
+	}
+
+	r.logger.Info("run exports started", "interval", r.interval)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Error("run export pass failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			r.logger.Info("run exports stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce builds every queued export job, one at a time. A job that cannot
+// be built is marked FAILED; only store errors are returned.
+func (r *Runner) RunOnce(ctx context.Context) error {
+	if r.store == nil {
+		return nil
+	}
+
+	for {
+		job, ok, err := r.store.ClaimExportJob(ctx)
+		if err != nil || !ok {
+			return err
+		}
+
+		artifact, runs, err := r.build(ctx, job)
+		if err != nil {
+			if ctx.Err() != nil {
+				// Left RUNNING; it is taken over once stale.
+				return ctx.Err()
+			}
+			r.logger.Error("export job failed", "export_id", job.ID, "api_key_id", job.APIKeyID, "error", err)
+			metrics.IncExportJob(metrics.ExportOutcomeFailed)
+			if err := r.store.FailExportJob(ctx, job.ID, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := r.store.CompleteExportJob(ctx, job.ID, runs, artifact); err != nil {
+			return err
+		}
+		metrics.IncExportJob(metrics.ExportOutcomeSucceeded)
+		r.logger.Info("export job succeeded",
+			"export_id", job.ID,
+			"api_key_id", job.APIKeyID,
+			"runs", runs,
+			"bytes", len(artifact),
+		)
+	}
+}
+
+func (r *Runner) build(ctx context.Context, job domain.ExportJob) ([]byte, int, error) {
+	var buf bytes.Buffer
+	enc, err := NewEncoder(&buf, job.Format)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	after := uuid.Nil
+	for {
+		page, err := r.store.ExportRunPage(ctx, job.APIKeyID, after, pageSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, run := range page {
+			if err := enc.Encode(run); err != nil {
+				return nil, 0, err
+			}
+		}
+		if buf.Len() > maxArtifactBytes {
+			return nil, 0, fmt.Errorf("export exceeds %d bytes", maxArtifactBytes)
+		}
+		if len(page) < pageSize {
+			break
+		}
+		after = page[len(page)-1].Run.ID
+	}
+
+	if err := enc.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), enc.Count(), nil
+}
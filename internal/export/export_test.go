@@ -0,0 +1,261 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+)
+
+func sampleRun(id uuid.UUID) domain.RunExport {
+	created := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	finished := created.Add(2 * time.Second)
+	template := "triage"
+	run := domain.RunExport{Steps: []domain.ExportedStep{{
+		ID:         uuid.New(),
+		Name:       "LLM",
+		Status:     "SUCCEEDED",
+		Attempts:   1,
+		Input:      json.RawMessage(`{"prompt":"hi"}`),
+		Output:     json.RawMessage(`{"text":"hello"}`),
+		CreatedAt:  created,
+		FinishedAt: &finished,
+	}}}
+	run.Run.ID = id
+	run.Run.Status = domain.RunSuccess
+	run.Run.TemplateName = &template
+	run.Run.CreatedAt = created
+	run.Events = []domain.EventRecord{{ID: uuid.New(), Seq: 7, RunID: id, Type: domain.EventStepSucceeded, Payload: json.RawMessage(`{"attempt":1}`), CreatedAt: finished}}
+	return run
+}
+
+func TestEncoderJSONBundle(t *testing.T) {
+	var buf bytes.Buffer
+	enc, err := NewEncoder(&buf, domain.ExportFormatJSON)
+	if err != nil {
+		t.Fatalf("new encoder: %v", err)
+	}
+	a, b := uuid.New(), uuid.New()
+	for _, id := range []uuid.UUID{a, b} {
+		if err := enc.Encode(sampleRun(id)); err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	var bundle struct {
+		Runs []domain.RunExport `json:"runs"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &bundle); err != nil {
+		t.Fatalf("decode bundle: %v\n%s", err, buf.String())
+	}
+	if enc.Count() != 2 || len(bundle.Runs) != 2 || bundle.Runs[0].Run.ID != a || bundle.Runs[1].Run.ID != b {
+		t.Fatalf("unexpected bundle: %s", buf.String())
+	}
+	if string(bundle.Runs[0].Steps[0].Output) != `{"text":"hello"}` {
+		t.Fatalf("step output not exported: %s", bundle.Runs[0].Steps[0].Output)
+	}
+}
+
+func TestEncoderEmptyJSONBundle(t *testing.T) {
+	var buf bytes.Buffer
+	enc, err := NewEncoder(&buf, domain.ExportFormatJSON)
+	if err != nil {
+		t.Fatalf("new encoder: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if got := buf.String(); got != "{\"runs\":[]}\n" {
+		t.Fatalf("unexpected empty bundle %q", got)
+	}
+}
+
+func TestWriteRunCSV(t *testing.T) {
+	id := uuid.New()
+	var buf bytes.Buffer
+	if err := WriteRun(&buf, domain.ExportFormatCSV, sampleRun(id)); err != nil {
+		t.Fatalf("write run: %v", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("read csv: %v", err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("expected header, run, step, and event rows, got %d", len(rows))
+	}
+	if rows[0][0] != "record" || rows[0][len(rows[0])-1] != "data" {
+		t.Fatalf("unexpected header %v", rows[0])
+	}
+	for i, want := range []string{"run", "step", "event"} {
+		row := rows[i+1]
+		if row[0] != want || row[1] != id.String() {
+			t.Fatalf("row %d = %v, want a %s row of run %s", i+1, row, want, id)
+		}
+	}
+	if rows[1][4] != "triage" || rows[2][6] != "1" || rows[3][3] != "7" {
+		t.Fatalf("unexpected columns: %v", rows[1:])
+	}
+
+	var step domain.ExportedStep
+	if err := json.Unmarshal([]byte(rows[2][9]), &step); err != nil {
+		t.Fatalf("decode step data: %v", err)
+	}
+	if string(step.Input) != `{"prompt":"hi"}` {
+		t.Fatalf("step input not in data column: %s", rows[2][9])
+	}
+}
+
+func TestWriteRunJSON(t *testing.T) {
+	id := uuid.New()
+	var buf bytes.Buffer
+	if err := WriteRun(&buf, domain.ExportFormatJSON, sampleRun(id)); err != nil {
+		t.Fatalf("write run: %v", err)
+	}
+	var run domain.RunExport
+	if err := json.Unmarshal(buf.Bytes(), &run); err != nil {
+		t.Fatalf("decode run: %v", err)
+	}
+	if run.Run.ID != id || len(run.Steps) != 1 || len(run.Events) != 1 {
+		t.Fatalf("unexpected run export: %s", buf.String())
+	}
+}
+
+func TestNewEncoderRejectsUnknownFormat(t *testing.T) {
+	if _, err := NewEncoder(io.Discard, "xml"); !errors.Is(err, domain.ErrInvalidExportFormat) {
+		t.Fatalf("expected ErrInvalidExportFormat, got %v", err)
+	}
+}
+
+type fakeStore struct {
+	jobs      []domain.ExportJob
+	runs      []uuid.UUID
+	pageErr   error
+	pageCalls int
+	completed map[uuid.UUID][]byte
+	counts    map[uuid.UUID]int
+	failed    map[uuid.UUID]string
+}
+
+func (f *fakeStore) ClaimExportJob(context.Context) (domain.ExportJob, bool, error) {
+	if len(f.jobs) == 0 {
+		return domain.ExportJob{}, false, nil
+	}
+	job := f.jobs[0]
+	f.jobs = f.jobs[1:]
+	return job, true, nil
+}
+
+func (f *fakeStore) ExportRunPage(_ context.Context, _ uuid.UUID, after uuid.UUID, limit int) ([]domain.RunExport, error) {
+	f.pageCalls++
+	if f.pageErr != nil {
+		return nil, f.pageErr
+	}
+	start := 0
+	if after != uuid.Nil {
+		for i, id := range f.runs {
+			if id == after {
+				start = i + 1
+			}
+		}
+	}
+	var page []domain.RunExport
+	for _, id := range f.runs[start:min(start+limit, len(f.runs))] {
+		page = append(page, sampleRun(id))
+	}
+	return page, nil
+}
+
+func (f *fakeStore) CompleteExportJob(_ context.Context, id uuid.UUID, runs int, artifact []byte) error {
+	if f.completed == nil {
+		f.completed = map[uuid.UUID][]byte{}
+		f.counts = map[uuid.UUID]int{}
+	}
+	f.completed[id] = artifact
+	f.counts[id] = runs
+	return nil
+}
+
+func (f *fakeStore) FailExportJob(_ context.Context, id uuid.UUID, reason string) error {
+	if f.failed == nil {
+		f.failed = map[uuid.UUID]string{}
+	}
+	f.failed[id] = reason
+	return nil
+}
+
+func TestRunOnceBuildsQueuedJobsAcrossPages(t *testing.T) {
+	store := &fakeStore{}
+	for range pageSize + 3 {
+		store.runs = append(store.runs, uuid.New())
+	}
+	jsonJob := domain.ExportJob{ID: uuid.New(), APIKeyID: uuid.New(), Format: domain.ExportFormatJSON}
+	csvJob := domain.ExportJob{ID: uuid.New(), APIKeyID: uuid.New(), Format: domain.ExportFormatCSV}
+	store.jobs = []domain.ExportJob{jsonJob, csvJob}
+
+	r := New(Deps{Store: store, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := r.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+
+	if len(store.completed) != 2 || len(store.failed) != 0 {
+		t.Fatalf("expected both jobs to complete, got completed=%d failed=%v", len(store.completed), store.failed)
+	}
+	if store.counts[jsonJob.ID] != len(store.runs) || store.counts[csvJob.ID] != len(store.runs) {
+		t.Fatalf("expected %d runs per job, got %v", len(store.runs), store.counts)
+	}
+
+	var bundle struct {
+		Runs []domain.RunExport `json:"runs"`
+	}
+	if err := json.Unmarshal(store.completed[jsonJob.ID], &bundle); err != nil {
+		t.Fatalf("decode json artifact: %v", err)
+	}
+	if len(bundle.Runs) != len(store.runs) || bundle.Runs[pageSize].Run.ID != store.runs[pageSize] {
+		t.Fatalf("json artifact has %d runs, want %d in order", len(bundle.Runs), len(store.runs))
+	}
+
+	rows, err := csv.NewReader(bytes.NewReader(store.completed[csvJob.ID])).ReadAll()
+	if err != nil {
+		t.Fatalf("read csv artifact: %v", err)
+	}
+	if want := 1 + 3*len(store.runs); len(rows) != want {
+		t.Fatalf("csv artifact has %d rows, want %d", len(rows), want)
+	}
+}
+
+func TestRunOnceFailsJobWhenRunsCannotBeRead(t *testing.T) {
+	job := domain.ExportJob{ID: uuid.New(), Format: domain.ExportFormatJSON}
+	store := &fakeStore{jobs: []domain.ExportJob{job}, pageErr: errors.New("db down")}
+
+	r := New(Deps{Store: store, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := r.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if store.failed[job.ID] != "db down" || len(store.completed) != 0 {
+		t.Fatalf("expected job to fail with the read error, got failed=%v", store.failed)
+	}
+}
+
+func TestNewDefaults(t *testing.T) {
+	r := New(Deps{})
+	if r.logger == nil || r.interval != defaultInterval {
+		t.Fatalf("expected default logger and interval, got %v %s", r.logger, r.interval)
+	}
+	if err := r.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce without a store: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ids generates primary keys for the append-heavy runs, steps, and
+// events tables. UUIDv7 keys are time-ordered, so new rows land at the end of
+// the primary-key index instead of on random pages. Existing v4 keys stay
+// valid: both versions share the UUID column type and nothing orders by id.
+package ids
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+const (
+	Version4 = 4
+	Version7 = 7
+)
+
+var useV7 atomic.Bool
+
+// ParseVersion accepts "4", "7", "v4", or "v7"; empty means Version4.
+func ParseVersion(raw string) (int, error) {
+	raw = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(raw)), "v")
+	if raw == "" {
+		return Version4, nil
+	}
+
+	v, err := strconv.Atoi(raw)
+	if err != nil || (v != Version4 && v != Version7) {
+		return 0, fmt.Errorf("unsupported uuid version %q (want 4 or 7)", raw)
+	}
+	return v, nil
+}
+
+// SetVersion selects the UUID version New returns for the whole process.
+func SetVersion(v int) error {
+	switch v {
+	case Version4:
+		useV7.Store(false)
+	case Version7:
+		useV7.Store(true)
+	default:
+		return fmt.Errorf("unsupported uuid version %d (want 4 or 7)", v)
+	}
+	return nil
+}
+
+// New returns a new identifier in the configured version.
+func New() uuid.UUID {
+	if useV7.Load() {
+		if id, err := uuid.NewV7(); err == nil {
+			return id
+		}
+	}
+	return uuid.New()
+}
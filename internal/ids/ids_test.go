@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package ids
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    int
+		wantErr bool
+	}{
+		{raw: "", want: Version4},
+		{raw: "4", want: Version4},
+		{raw: "v7", want: Version7},
+		{raw: " 7 ", want: Version7},
+		{raw: "6", wantErr: true},
+		{raw: "latest", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseVersion(tc.raw)
+		if tc.wantErr {
+			if err == nil {
+				t.Fatalf("expected error for %q", tc.raw)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Fatalf("ParseVersion(%q) = %d, %v; want %d", tc.raw, got, err, tc.want)
+		}
+	}
+}
+
+func TestNewHonorsVersion(t *testing.T) {
+	t.Cleanup(func() { _ = SetVersion(Version4) })
+
+	if err := SetVersion(5); err == nil {
+		t.Fatal("expected unsupported version to be rejected")
+	}
+
+	if err := SetVersion(Version4); err != nil {
+		t.Fatalf("set v4: %v", err)
+	}
+	if v := New().Version(); v != 4 {
+		t.Fatalf("expected v4 id, got version %d", v)
+	}
+
+	if err := SetVersion(Version7); err != nil {
+		t.Fatalf("set v7: %v", err)
+	}
+	prev := New()
+	if prev.Version() != 7 {
+		t.Fatalf("expected v7 id, got version %d", prev.Version())
+	}
+	for i := 0; i < 100; i++ {
+		next := New()
+		if bytes.Compare(prev[:], next[:]) >= 0 {
+			t.Fatalf("expected v7 ids to be increasing, got %s then %s", prev, next)
+		}
+		prev = next
+	}
+}
@@ -0,0 +1,315 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ingest creates runs from commands consumed from a message queue, for
+// backends that would rather publish than call POST /runs. A command goes
+// through the same validation, API key scope and rate limit, tenant limits,
+// and idempotency as the HTTP endpoint, and is answered with a reply carrying
+// the run ID or the reason it was rejected.
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/audit"
+	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/egress"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/adiadia/agent-runtime/internal/transport/middleware"
+)
+
+// Sources a consumer reads commands from.
+const (
+	SourceNATS  = "nats"
+	SourceKafka = "kafka"
+)
+
+// Error codes of a rejected command's reply.
+const (
+	ErrorInvalidRequest       = "invalid_request"
+	ErrorUnauthorized         = "unauthorized"
+	ErrorForbidden            = "forbidden"
+	ErrorRateLimited          = "rate_limited"
+	ErrorMaxConcurrentRuns    = "max_concurrent_runs_exceeded"
+	ErrorMonthlyBudget        = "monthly_budget_exceeded"
+	ErrorTemplateNotFound     = "template_not_found"
+	ErrorIdempotencyKeyReused = "idempotency_key_reused"
+	ErrorInternal             = "internal"
+)
+
+const (
+	maxCommandBytes   = 1 << 20
+	defaultInterval   = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// Command asks for one run. APIKey is the bearer token the HTTP endpoint would
+// take in the Authorization header, and IdempotencyKey its Idempotency-Key
+// header; it is required here because queues redeliver. CorrelationID is
+// echoed in the reply.
+type Command struct {
+	APIKey         string                  `json:"api_key"`
+	IdempotencyKey string                  `json:"idempotency_key"`
+	CorrelationID  string                  `json:"correlation_id,omitempty"`
+	Run            domain.CreateRunRequest `json:"run"`
+}
+
+// Reply answers a Command: the run on success, an error code and message
+// otherwise.
+type Reply struct {
+	CorrelationID     string `json:"correlation_id,omitempty"`
+	IdempotencyKey    string `json:"idempotency_key,omitempty"`
+	RunID             string `json:"run_id,omitempty"`
+	WebhookSecret     string `json:"webhook_secret,omitempty"`
+	Error             string `json:"error,omitempty"`
+	Message           string `json:"message,omitempty"`
+	RetryAfterSeconds int    `json:"retry_after_seconds,omitempty"`
+}
+
+// Retryable reports whether the command failed for a reason on this side, so
+// that handling it again may succeed.
+func (r Reply) Retryable() bool {
+	return r.Error == ErrorInternal
+}
+
+// Submitter creates runs; it is the repository behind POST /runs.
+type Submitter interface {
+	SubmitRun(ctx context.Context, params domain.CreateRunParams) (domain.CreatedRun, error)
+}
+
+// APIKeyResolver resolves a command's bearer token to its API key.
+type APIKeyResolver interface {
+	ResolveAPIKey(ctx context.Context, bearerToken string) (auth.APIKey, bool, error)
+}
+
+// HandleFunc handles one command message and returns its reply.
+type HandleFunc func(ctx context.Context, data []byte) Reply
+
+// Source delivers command messages from a queue.
+type Source interface {
+	// Consume passes each message to handle until ctx is done or the
+	// connection fails. A message is acknowledged once handle returns a
+	// reply that is not Retryable.
+	Consume(ctx context.Context, handle HandleFunc) error
+}
+
+type Deps struct {
+	Runs   Submitter
+	Keys   APIKeyResolver
+	Source Source
+	Logger *slog.Logger
+	// Interval is the first wait before reconnecting after the source fails;
+	// it doubles while the source keeps failing, up to a minute.
+	Interval time.Duration
+	// WebhookTargets, when set, vets webhook URLs as POST /runs does.
+	WebhookTargets *egress.Policy
+	// WebhookResolver resolves webhook hosts; nil means net.DefaultResolver.
+	WebhookResolver egress.Resolver
+}
+
+// Consumer runs the ingestion loop.
+type Consumer struct {
+	runs     Submitter
+	keys     APIKeyResolver
+	source   Source
+	limiter  *middleware.RateLimiter
+	logger   *slog.Logger
+	interval time.Duration
+	now      func() time.Time
+
+	webhookTargets  *egress.Policy
+	webhookResolver egress.Resolver
+}
+
+func New(deps Deps) *Consumer {
+	l := deps.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+
+	interval := deps.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	return &Consumer{
+		runs:     deps.Runs,
+		keys:     deps.Keys,
+		source:   deps.Source,
+		limiter:  middleware.NewRateLimiter(),
+		logger:   l,
+		interval: interval,
+		now:      time.Now,
+
+		webhookTargets:  deps.WebhookTargets,
+		webhookResolver: deps.WebhookResolver,
+	}
+}
+
+// Run consumes commands until ctx is done, reconnecting with backoff when the
+// source fails. It returns at once when no source is configured.
+func (c *Consumer) Run(ctx context.Context) {
+	if c.source == nil {
+		c.logger.Info("run ingestion disabled")
+		return
+	}
+
+	c.logger.Info("run ingestion started")
+	delay := c.interval
+	for {
+		started := c.now()
+		err := c.source.Consume(ctx, c.Handle)
+		if ctx.Err() != nil {
+			c.logger.Info("run ingestion stopped")
+			return
+		}
+		// A source that stayed up a while failed afresh, not in a loop.
+		if c.now().Sub(started) > maxReconnectDelay {
+			delay = c.interval
+		}
+		c.logger.Error("run ingestion source failed", "error", err, "retry_in", delay.String())
+
+		select {
+		case <-ctx.Done():
+			c.logger.Info("run ingestion stopped")
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// Handle decodes a command, checks it as POST /runs would, and submits the run.
+func (c *Consumer) Handle(ctx context.Context, data []byte) Reply {
+	reply := c.handle(ctx, data)
+	switch {
+	case reply.Error == "":
+		metrics.IncIngestCommand(metrics.IngestOutcomeCreated)
+	case reply.Retryable():
+		metrics.IncIngestCommand(metrics.IngestOutcomeFailed)
+	default:
+		metrics.IncIngestCommand(metrics.IngestOutcomeRejected)
+		c.logger.Warn("run command rejected",
+			"correlation_id", reply.CorrelationID,
+			"idempotency_key", reply.IdempotencyKey,
+			"error", reply.Error,
+			"message", reply.Message,
+		)
+	}
+	return reply
+}
+
+func (c *Consumer) handle(ctx context.Context, data []byte) Reply {
+	if len(data) > maxCommandBytes {
+		return reject(Reply{}, ErrorInvalidRequest, "command is too large")
+	}
+
+	var cmd Command
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cmd); err != nil {
+		return reject(Reply{}, ErrorInvalidRequest, "invalid command: "+err.Error())
+	}
+
+	reply := Reply{
+		CorrelationID:  cmd.CorrelationID,
+		IdempotencyKey: strings.TrimSpace(cmd.IdempotencyKey),
+	}
+	if cmd.APIKey == "" {
+		return reject(reply, ErrorUnauthorized, "api_key is required")
+	}
+	if reply.IdempotencyKey == "" {
+		return reject(reply, ErrorInvalidRequest, "idempotency_key is required")
+	}
+	params, err := cmd.Run.Params()
+	if err != nil {
+		return reject(reply, ErrorInvalidRequest, err.Error())
+	}
+
+	key, found, err := c.keys.ResolveAPIKey(ctx, cmd.APIKey)
+	if err != nil {
+		c.logger.Error("api key resolution failed", "correlation_id", reply.CorrelationID, "error", err)
+		return reject(reply, ErrorInternal, "auth lookup failed")
+	}
+	if !found {
+		return reject(reply, ErrorUnauthorized, "invalid api_key")
+	}
+	if !key.HasScope(domain.ScopeRunsWrite) {
+		return reject(reply, ErrorForbidden, "api key lacks required scope "+domain.ScopeRunsWrite)
+	}
+	if allowed, retryAfter := c.limiter.Allow(key, c.now()); !allowed {
+		reply.RetryAfterSeconds = int(retryAfter / time.Second)
+		return reject(reply, ErrorRateLimited, "rate limit exceeded")
+	}
+	if params.WebhookURL != "" && c.webhookTargets != nil {
+		if err := c.webhookTargets.CheckURL(ctx, c.webhookResolver, params.WebhookURL); err != nil {
+			if errors.Is(err, egress.ErrBlocked) {
+				return reject(reply, ErrorInvalidRequest, "webhook_url must not target a private, loopback, or link-local address")
+			}
+			return reject(reply, ErrorInvalidRequest, "webhook_url host does not resolve")
+		}
+	}
+
+	ctx = auth.WithAPIKey(ctx, key)
+	ctx = auth.WithIdempotencyKey(ctx, reply.IdempotencyKey)
+	ctx = audit.WithActor(ctx, domain.AuditActor{
+		Type:      domain.AuditActorAPIKey,
+		ID:        &key.ID,
+		RequestID: cmd.CorrelationID,
+	})
+
+	created, err := c.runs.SubmitRun(ctx, params)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrMaxConcurrentTemplateRunsExceeded):
+			reply.RetryAfterSeconds = 1
+			return reject(reply, ErrorMaxConcurrentRuns, "max concurrent runs for template exceeded")
+		case errors.Is(err, domain.ErrMaxConcurrentRunsExceeded):
+			reply.RetryAfterSeconds = 1
+			return reject(reply, ErrorMaxConcurrentRuns, "max concurrent runs exceeded")
+		case errors.Is(err, domain.ErrMonthlyBudgetExceeded):
+			return reject(reply, ErrorMonthlyBudget, "monthly budget exceeded")
+		case errors.Is(err, domain.ErrWorkflowTemplateNotFound):
+			return reject(reply, ErrorTemplateNotFound, "workflow template not found")
+		case errors.Is(err, domain.ErrIdempotencyKeyReused):
+			return reject(reply, ErrorIdempotencyKeyReused, "idempotency_key was already used with a different run")
+		}
+		c.logger.Error("create run from command failed",
+			"correlation_id", reply.CorrelationID,
+			"api_key_id", key.ID,
+			"error", err,
+		)
+		return reject(reply, ErrorInternal, "failed to create run")
+	}
+
+	c.logger.Info("run created via ingestion",
+		"run_id", created.ID,
+		"api_key_id", key.ID,
+		"correlation_id", reply.CorrelationID,
+	)
+	reply.RunID = created.ID.String()
+	reply.WebhookSecret = created.WebhookSecret
+	return reply
+}
+
+func reject(reply Reply, code, message string) Reply {
+	reply.Error = code
+	reply.Message = message
+	return reply
+}
+
+// encodeReply marshals a reply; Reply has no fields that can fail to encode.
+func encodeReply(reply Reply) []byte {
+	data, err := json.Marshal(reply)
+	if err != nil {
+		panic(fmt.Sprintf("encode ingest reply: %v", err))
+	}
+	return data
+}
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/adiadia/agent-runtime/internal/audit"
+	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+)
+
+type fakeSubmitter struct {
+	err    error
+	calls  int
+	params domain.CreateRunParams
+	ctx    context.Context
+	runID  uuid.UUID
+}
+
+func (f *fakeSubmitter) SubmitRun(ctx context.Context, params domain.CreateRunParams) (domain.CreatedRun, error) {
+	f.calls++
+	f.ctx, f.params = ctx, params
+	if f.err != nil {
+		return domain.CreatedRun{}, f.err
+	}
+	return domain.CreatedRun{ID: f.runID, WebhookSecret: params.WebhookSecret}, nil
+}
+
+type fakeResolver struct {
+	keys map[string]auth.APIKey
+	err  error
+}
+
+func (f fakeResolver) ResolveAPIKey(_ context.Context, token string) (auth.APIKey, bool, error) {
+	key, ok := f.keys[token]
+	return key, ok, f.err
+}
+
+func testConsumer(runs Submitter, keys APIKeyResolver) *Consumer {
+	return New(Deps{Runs: runs, Keys: keys, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+}
+
+func command(t *testing.T, mutate func(map[string]any)) []byte {
+	t.Helper()
+	cmd := map[string]any{
+		"api_key":         "tok",
+		"idempotency_key": "order-42",
+		"correlation_id":  "corr-1",
+		"run": map[string]any{
+			"webhook_url":   "https://example.com/hook",
+			"priority":      3,
+			"template_name": " summarize ",
+			"tags":          []string{"Batch"},
+		},
+	}
+	if mutate != nil {
+		mutate(cmd)
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatalf("marshal command: %v", err)
+	}
+	return data
+}
+
+func TestHandleSubmitsRunAsTheAPIKey(t *testing.T) {
+	key := auth.APIKey{ID: uuid.New(), Scopes: []string{domain.ScopeRunsWrite}, MaxRequestsPerMin: 60}
+	runs := &fakeSubmitter{runID: uuid.New()}
+	c := testConsumer(runs, fakeResolver{keys: map[string]auth.APIKey{"tok": key}})
+
+	reply := c.Handle(context.Background(), command(t, nil))
+	if reply.Error != "" || reply.RunID != runs.runID.String() {
+		t.Fatalf("expected the run, got %+v", reply)
+	}
+	if reply.CorrelationID != "corr-1" || reply.IdempotencyKey != "order-42" {
+		t.Fatalf("expected correlation and idempotency keys echoed, got %+v", reply)
+	}
+	if runs.params.TemplateName != "summarize" || runs.params.Priority != 3 || runs.params.WebhookURL != "https://example.com/hook" {
+		t.Fatalf("unexpected params %+v", runs.params)
+	}
+	if got, _ := auth.APIKeyIDFromContext(runs.ctx); got != key.ID {
+		t.Fatalf("expected api key %s on context, got %s", key.ID, got)
+	}
+	if got, _ := auth.IdempotencyKeyFromContext(runs.ctx); got != "order-42" {
+		t.Fatalf("expected idempotency key on context, got %q", got)
+	}
+	if actor, ok := audit.ActorFromContext(runs.ctx); !ok || actor.Type != domain.AuditActorAPIKey || actor.ID == nil || *actor.ID != key.ID {
+		t.Fatalf("expected api key audit actor, got %+v", actor)
+	}
+}
+
+func TestHandleRejectsCommands(t *testing.T) {
+	writer := auth.APIKey{ID: uuid.New(), Scopes: []string{domain.ScopeRunsWrite}, MaxRequestsPerMin: 60}
+	reader := auth.APIKey{ID: uuid.New(), Scopes: []string{domain.ScopeRunsRead}, MaxRequestsPerMin: 60}
+	keys := fakeResolver{keys: map[string]auth.APIKey{"tok": writer, "read": reader}}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		submitErr error
+		want      string
+	}{
+		{name: "malformed", data: []byte(`{"api_key":`), want: ErrorInvalidRequest},
+		{name: "unknown field", data: command(t, func(c map[string]any) { c["tenant"] = "x" }), want: ErrorInvalidRequest},
+		{name: "missing api key", data: command(t, func(c map[string]any) { delete(c, "api_key") }), want: ErrorUnauthorized},
+		{name: "missing idempotency key", data: command(t, func(c map[string]any) { c["idempotency_key"] = " " }), want: ErrorInvalidRequest},
+		{name: "invalid webhook", data: command(t, func(c map[string]any) {
+			c["run"] = map[string]any{"webhook_url": "ftp://example.com"}
+		}), want: ErrorInvalidRequest},
+		{name: "unknown key", data: command(t, func(c map[string]any) { c["api_key"] = "nope" }), want: ErrorUnauthorized},
+		{name: "missing scope", data: command(t, func(c map[string]any) { c["api_key"] = "read" }), want: ErrorForbidden},
+		{name: "concurrency", data: command(t, nil), submitErr: domain.ErrMaxConcurrentRunsExceeded, want: ErrorMaxConcurrentRuns},
+		{name: "budget", data: command(t, nil), submitErr: domain.ErrMonthlyBudgetExceeded, want: ErrorMonthlyBudget},
+		{name: "template", data: command(t, nil), submitErr: domain.ErrWorkflowTemplateNotFound, want: ErrorTemplateNotFound},
+		{name: "idempotency reused", data: command(t, nil), submitErr: domain.ErrIdempotencyKeyReused, want: ErrorIdempotencyKeyReused},
+		{name: "store failure", data: command(t, nil), submitErr: errors.New("connection reset"), want: ErrorInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConsumer(&fakeSubmitter{err: tt.submitErr}, keys)
+			reply := c.Handle(context.Background(), tt.data)
+			if reply.Error != tt.want {
+				t.Fatalf("expected %s, got %+v", tt.want, reply)
+			}
+			if reply.Retryable() != (tt.want == ErrorInternal) {
+				t.Fatalf("unexpected retryable %v for %s", reply.Retryable(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleAppliesTheKeyRateLimit(t *testing.T) {
+	key := auth.APIKey{ID: uuid.New(), Scopes: []string{domain.ScopeRunsWrite}, MaxRequestsPerMin: 1}
+	runs := &fakeSubmitter{runID: uuid.New()}
+	c := testConsumer(runs, fakeResolver{keys: map[string]auth.APIKey{"tok": key}})
+
+	if reply := c.Handle(context.Background(), command(t, nil)); reply.Error != "" {
+		t.Fatalf("expected the first command to pass, got %+v", reply)
+	}
+	reply := c.Handle(context.Background(), command(t, nil))
+	if reply.Error != ErrorRateLimited || reply.RetryAfterSeconds <= 0 {
+		t.Fatalf("expected rate_limited with a retry hint, got %+v", reply)
+	}
+	if runs.calls != 1 {
+		t.Fatalf("expected one submit, got %d", runs.calls)
+	}
+}
@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package ingest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/kafkarest"
+	"github.com/google/uuid"
+)
+
+// KafkaConfig configures the Kafka source.
+type KafkaConfig struct {
+	// RESTURL is the base URL of a Confluent REST Proxy (v2 API).
+	RESTURL string
+	Topic   string
+	// Group is the consumer group, shared by all replicas.
+	Group string
+	// ReplyTopic, when set, receives a reply per command, keyed by its
+	// correlation ID, or its idempotency key when it has none.
+	ReplyTopic string
+	// PollInterval is the wait between polls that return no records, and
+	// between attempts at a command that failed on this side.
+	PollInterval time.Duration
+}
+
+// Kafka consumes commands from a Kafka topic through a REST Proxy consumer
+// instance. Offsets are committed after each polled batch is handled, so
+// commands are processed at least once; the idempotency key makes a
+// redelivered command return the run it already created. A command that
+// fails on this side is retried in place, holding back the records behind it.
+type Kafka struct {
+	client *kafkarest.Client
+	cfg    KafkaConfig
+}
+
+// NewKafka returns a Kafka source for cfg. A nil client gets a 10s timeout.
+func NewKafka(cfg KafkaConfig, client *http.Client) (*Kafka, error) {
+	if strings.TrimSpace(cfg.Topic) == "" {
+		return nil, errors.New("kafka topic is required")
+	}
+	if strings.TrimSpace(cfg.Group) == "" {
+		return nil, errors.New("kafka consumer group is required")
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultInterval
+	}
+	return &Kafka{client: kafkarest.New(cfg.RESTURL, client), cfg: cfg}, nil
+}
+
+func (k *Kafka) Consume(ctx context.Context, handle HandleFunc) error {
+	consumer, err := k.client.NewConsumer(ctx, k.cfg.Group, "agent-runtime-"+uuid.NewString(), k.cfg.Topic)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close(context.WithoutCancel(ctx))
+
+	for {
+		records, err := consumer.Poll(ctx)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			if err := sleep(ctx, k.cfg.PollInterval); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for i, record := range records {
+			reply, err := k.handleRecord(ctx, handle, record)
+			if err != nil {
+				// Commit what was handled, so only the rest is redelivered.
+				_ = consumer.Commit(context.WithoutCancel(ctx), records[:i])
+				return err
+			}
+			if k.cfg.ReplyTopic == "" {
+				continue
+			}
+			if err := k.client.Produce(ctx, k.cfg.ReplyTopic, kafkarest.Record{Key: replyKey(reply), Value: encodeReply(reply)}); err != nil {
+				_ = consumer.Commit(context.WithoutCancel(ctx), records[:i])
+				return err
+			}
+		}
+		if err := consumer.Commit(ctx, records); err != nil {
+			return err
+		}
+	}
+}
+
+// handleRecord handles one record, retrying while it fails on this side. It
+// returns an error only when ctx is done first.
+func (k *Kafka) handleRecord(ctx context.Context, handle HandleFunc, record kafkarest.ConsumedRecord) (Reply, error) {
+	for {
+		reply := handle(ctx, record.Value)
+		if !reply.Retryable() {
+			return reply, nil
+		}
+		if err := sleep(ctx, k.cfg.PollInterval); err != nil {
+			return Reply{}, err
+		}
+	}
+}
+
+func replyKey(reply Reply) string {
+	if reply.CorrelationID != "" {
+		return reply.CorrelationID
+	}
+	return reply.IdempotencyKey
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
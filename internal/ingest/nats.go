@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package ingest
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/adiadia/agent-runtime/internal/natsclient"
+)
+
+// NATSConfig configures the NATS source. Credentials come from the URL, as
+// for the outbox relay.
+type NATSConfig struct {
+	URL     string
+	Subject string
+	// Queue is the queue group, so that each command goes to one replica.
+	Queue string
+}
+
+// NATS consumes commands from a NATS subject. Replies are published to the
+// message's reply subject, so a command sent as a NATS request gets its
+// reply as the response. Core NATS does not redeliver, so a command that
+// fails on this side is answered with an internal error for the sender to
+// retry under the same idempotency key.
+type NATS struct {
+	cfg NATSConfig
+}
+
+// NewNATS returns a NATS source for cfg; it does not connect yet.
+func NewNATS(cfg NATSConfig) (*NATS, error) {
+	if _, _, _, err := natsclient.Addr(cfg.URL); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(cfg.Subject) == "" {
+		return nil, errors.New("nats subject is required")
+	}
+	return &NATS{cfg: cfg}, nil
+}
+
+func (n *NATS) Consume(ctx context.Context, handle HandleFunc) error {
+	conn, err := natsclient.Dial(ctx, n.cfg.URL, "agent-runtime-ingest")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.Subscribe(n.cfg.Subject, n.cfg.Queue, "1"); err != nil {
+		return err
+	}
+	if err := conn.Flush(ctx); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := conn.Next(ctx)
+		if err != nil {
+			return err
+		}
+		reply := handle(ctx, msg.Data)
+		if msg.Reply == "" {
+			continue
+		}
+		if err := conn.Publish(msg.Reply, "", encodeReply(reply)); err != nil {
+			return err
+		}
+	}
+}
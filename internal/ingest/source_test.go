@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNATSRepliesToCommands(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	type received struct {
+		sub   string
+		pub   string
+		reply string
+	}
+	got := make(chan received, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		readLine := func() string {
+			line, _ := r.ReadString('\n')
+			return strings.TrimRight(line, "\r\n")
+		}
+
+		io.WriteString(conn, "INFO {}\r\n")
+		readLine() // CONNECT
+		readLine() // PING
+		io.WriteString(conn, "PONG\r\n")
+
+		var rec received
+		rec.sub = readLine()
+		readLine() // PING
+		io.WriteString(conn, "PONG\r\n")
+
+		body := `{"hello":"world"}`
+		fmt.Fprintf(conn, "MSG commands.create_run 1 _INBOX.abc %d\r\n%s\r\n", len(body), body)
+		rec.pub = readLine()
+		rec.reply = readLine()
+		got <- rec
+	}()
+
+	src, err := NewNATS(NATSConfig{URL: "nats://" + ln.Addr().String(), Subject: "commands.create_run", Queue: "agent-runtime"})
+	if err != nil {
+		t.Fatalf("new nats: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- src.Consume(ctx, func(_ context.Context, data []byte) Reply {
+			if string(data) != `{"hello":"world"}` {
+				return Reply{Error: ErrorInvalidRequest, Message: string(data)}
+			}
+			return Reply{RunID: "run-1"}
+		})
+	}()
+
+	var rec received
+	select {
+	case rec = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the reply")
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected Consume to stop with the context, got %v", err)
+	}
+
+	if rec.sub != "SUB commands.create_run agent-runtime 1" {
+		t.Fatalf("unexpected subscription %q", rec.sub)
+	}
+	if rec.pub != fmt.Sprintf("PUB _INBOX.abc %d", len(rec.reply)) {
+		t.Fatalf("unexpected reply publish %q", rec.pub)
+	}
+	if rec.reply != `{"run_id":"run-1"}` {
+		t.Fatalf("unexpected reply %q", rec.reply)
+	}
+}
+
+func TestKafkaHandlesRetriesRepliesAndCommits(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		polls    int
+		replies  []string
+		commits  []string
+		deleted  bool
+		consumer = "/consumers/runs/instances/inst-1"
+	)
+	committed := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/consumers/runs":
+			io.WriteString(w, `{"instance_id":"inst-1","base_uri":"unused"}`)
+		case r.Method == http.MethodPost && r.URL.Path == consumer+"/subscription":
+			if string(body) != `{"topics":["run-commands"]}` {
+				t.Errorf("unexpected subscription %s", body)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet && r.URL.Path == consumer+"/records":
+			polls++
+			if polls > 1 {
+				io.WriteString(w, `[]`)
+				return
+			}
+			io.WriteString(w, `[{"topic":"run-commands","key":null,"value":{"n":1},"partition":0,"offset":9},`+
+				`{"topic":"run-commands","key":null,"value":{"n":2},"partition":0,"offset":10}]`)
+		case r.Method == http.MethodPost && r.URL.Path == "/topics/run-replies":
+			replies = append(replies, string(body))
+			io.WriteString(w, `{"offsets":[{"partition":0,"offset":1}]}`)
+		case r.Method == http.MethodPost && r.URL.Path == consumer+"/offsets":
+			commits = append(commits, string(body))
+			w.WriteHeader(http.StatusNoContent)
+			close(committed)
+		case r.Method == http.MethodDelete && r.URL.Path == consumer:
+			deleted = true
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	src, err := NewKafka(KafkaConfig{
+		RESTURL:      srv.URL,
+		Topic:        "run-commands",
+		Group:        "runs",
+		ReplyTopic:   "run-replies",
+		PollInterval: time.Millisecond,
+	}, srv.Client())
+	if err != nil {
+		t.Fatalf("new kafka: %v", err)
+	}
+
+	attempts := map[string]int{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- src.Consume(ctx, func(_ context.Context, data []byte) Reply {
+			attempts[string(data)]++
+			if string(data) == `{"n":1}` && attempts[string(data)] == 1 {
+				return Reply{Error: ErrorInternal}
+			}
+			return Reply{CorrelationID: "c" + string(data[5]), RunID: "run"}
+		})
+	}()
+
+	select {
+	case <-committed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the commit")
+	}
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts[`{"n":1}`] != 2 || attempts[`{"n":2}`] != 1 {
+		t.Fatalf("expected the failed command retried once, got %v", attempts)
+	}
+	if len(replies) != 2 || !strings.Contains(replies[0], `"key":"c1"`) || !strings.Contains(replies[1], `"key":"c2"`) {
+		t.Fatalf("expected a keyed reply per command, got %v", replies)
+	}
+	var offsets struct {
+		Offsets []struct {
+			Partition int   `json:"partition"`
+			Offset    int64 `json:"offset"`
+		} `json:"offsets"`
+	}
+	if len(commits) != 1 || json.Unmarshal([]byte(commits[0]), &offsets) != nil ||
+		len(offsets.Offsets) != 2 || offsets.Offsets[1].Offset != 11 {
+		t.Fatalf("expected offsets committed past the batch, got %v", commits)
+	}
+	if !deleted {
+		t.Fatal("expected the consumer instance deleted")
+	}
+}
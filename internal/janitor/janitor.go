@@ -0,0 +1,206 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package janitor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+)
+
+const defaultPruneBatchSize = 1000
+
+// eventPartitionMonthsAhead is how many months of events partitions are kept
+// created beyond the current one.
+const eventPartitionMonthsAhead = 2
+
+// EventPruner deletes events that are past their tenant's retention window.
+type EventPruner interface {
+	PruneExpiredEvents(ctx context.Context, defaultRetentionDays int, batchSize int) (int64, error)
+}
+
+// EventPartitioner maintains the monthly partitions of the events table.
+type EventPartitioner interface {
+	EnsureEventPartitions(ctx context.Context, monthsAhead int) (int, error)
+	DropEmptyEventPartitions(ctx context.Context) (int, error)
+}
+
+// RunRequestPruner deletes idempotency records older than their TTL.
+type RunRequestPruner interface {
+	PruneExpiredRunRequests(ctx context.Context, ttl time.Duration, batchSize int) (int64, error)
+}
+
+// RunExpirer archives or deletes terminal runs past their tenant's retention.
+type RunExpirer interface {
+	ExpireRuns(ctx context.Context, policy domain.RunRetentionPolicy, batchSize int) (int64, error)
+}
+
+// OutboxPruner drops outbox messages that were never published.
+type OutboxPruner interface {
+	PruneOutboxMessages(ctx context.Context, maxAge time.Duration, batchSize int) (int64, error)
+}
+
+type Deps struct {
+	Events             EventPruner
+	Partitions         EventPartitioner
+	RunRequests        RunRequestPruner
+	Runs               RunExpirer
+	Outbox             OutboxPruner
+	Logger             *slog.Logger
+	Interval           time.Duration
+	EventRetentionDays int
+	IdempotencyKeyTTL  time.Duration
+	// RunRetention is the global run retention policy; tenants may override
+	// DefaultDays with their own run_retention_days.
+	RunRetention domain.RunRetentionPolicy
+	// OutboxRetention drops outbox messages still unpublished after this
+	// long; 0 keeps them.
+	OutboxRetention time.Duration
+}
+
+// Janitor runs periodic housekeeping against the durable store.
+type Janitor struct {
+	events             EventPruner
+	partitions         EventPartitioner
+	runRequests        RunRequestPruner
+	runs               RunExpirer
+	outbox             OutboxPruner
+	logger             *slog.Logger
+	interval           time.Duration
+	eventRetentionDays int
+	idempotencyKeyTTL  time.Duration
+	runRetention       domain.RunRetentionPolicy
+	outboxRetention    time.Duration
+}
+
+func New(deps Deps) *Janitor {
+	l := deps.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+
+	interval := deps.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	retention := deps.EventRetentionDays
+	if retention < 0 {
+		retention = 0
+	}
+
+	ttl := deps.IdempotencyKeyTTL
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	runRetention := deps.RunRetention
+	if runRetention.DefaultDays < 0 {
+		runRetention.DefaultDays = 0
+	}
+	if runRetention.Mode == "" {
+		runRetention.Mode = domain.RunRetentionArchive
+	}
+
+	return &Janitor{
+		events:             deps.Events,
+		partitions:         deps.Partitions,
+		runRequests:        deps.RunRequests,
+		runs:               deps.Runs,
+		outbox:             deps.Outbox,
+		logger:             l,
+		interval:           interval,
+		eventRetentionDays: retention,
+		idempotencyKeyTTL:  ttl,
+		runRetention:       runRetention,
+		outboxRetention:    deps.OutboxRetention,
+	}
+}
+
+// Run executes RunOnce immediately and then on every interval until ctx is done.
+func (j *Janitor) Run(ctx context.Context) {
+	j.logger.Info("janitor started",
+		"interval", j.interval,
+		"event_retention_days", j.eventRetentionDays,
+		"idempotency_key_ttl", j.idempotencyKeyTTL,
+		"run_retention_days", j.runRetention.DefaultDays,
+		"run_retention_mode", j.runRetention.Mode,
+		"run_retention_dry_run", j.runRetention.DryRun,
+		"outbox_retention", j.outboxRetention,
+	)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			j.logger.Error("janitor pass failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			j.logger.Info("janitor stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce performs a single housekeeping pass. Each task runs even if an
+// earlier one fails; their errors are joined. Upcoming events partitions are
+// created first, and events partitions emptied by pruning and run retention
+// are dropped last.
+func (j *Janitor) RunOnce(ctx context.Context) error {
+	var errs []error
+
+	if j.partitions != nil {
+		_, err := j.partitions.EnsureEventPartitions(ctx, eventPartitionMonthsAhead)
+		errs = append(errs, err)
+	}
+
+	if j.events != nil {
+		pruned, err := j.events.PruneExpiredEvents(ctx, j.eventRetentionDays, defaultPruneBatchSize)
+		metrics.AddEventsPruned(pruned)
+		errs = append(errs, err)
+	}
+
+	if j.runRequests != nil && j.idempotencyKeyTTL > 0 {
+		pruned, err := j.runRequests.PruneExpiredRunRequests(ctx, j.idempotencyKeyTTL, defaultPruneBatchSize)
+		metrics.AddRunRequestsPruned(pruned)
+		errs = append(errs, err)
+	}
+
+	if j.runs != nil {
+		expired, err := j.runs.ExpireRuns(ctx, j.runRetention, defaultPruneBatchSize)
+		if j.runRetention.DryRun {
+			metrics.SetRunsExpiryEligible(expired)
+			if err == nil {
+				j.logger.Info("run retention dry run", "eligible_runs", expired, "mode", j.runRetention.Mode)
+			}
+		} else {
+			metrics.AddRunsExpired(j.runRetention.Mode, expired)
+		}
+		errs = append(errs, err)
+	}
+
+	if j.outbox != nil && j.outboxRetention > 0 {
+		dropped, err := j.outbox.PruneOutboxMessages(ctx, j.outboxRetention, defaultPruneBatchSize)
+		metrics.AddOutboxMessages(metrics.OutboxOutcomeDropped, dropped)
+		if dropped > 0 {
+			j.logger.Warn("dropped unpublished outbox messages", "count", dropped, "older_than", j.outboxRetention)
+		}
+		errs = append(errs, err)
+	}
+
+	if j.partitions != nil {
+		dropped, err := j.partitions.DropEmptyEventPartitions(ctx)
+		metrics.AddEventPartitionsDropped(dropped)
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,308 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package janitor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+)
+
+type fakeEventPruner struct {
+	calls       int
+	defaultDays int
+	batchSize   int
+	pruned      int64
+	err         error
+}
+
+func (f *fakeEventPruner) PruneExpiredEvents(ctx context.Context, defaultRetentionDays int, batchSize int) (int64, error) {
+	f.calls++
+	f.defaultDays = defaultRetentionDays
+	f.batchSize = batchSize
+	return f.pruned, f.err
+}
+
+type fakeRunRequestPruner struct {
+	calls     int
+	ttl       time.Duration
+	batchSize int
+	pruned    int64
+	err       error
+}
+
+func (f *fakeRunRequestPruner) PruneExpiredRunRequests(ctx context.Context, ttl time.Duration, batchSize int) (int64, error) {
+	f.calls++
+	f.ttl = ttl
+	f.batchSize = batchSize
+	return f.pruned, f.err
+}
+
+type fakeEventPartitioner struct {
+	calls       []string
+	monthsAhead int
+	dropped     int
+	ensureErr   error
+}
+
+func (f *fakeEventPartitioner) EnsureEventPartitions(ctx context.Context, monthsAhead int) (int, error) {
+	f.calls = append(f.calls, "ensure")
+	f.monthsAhead = monthsAhead
+	return 0, f.ensureErr
+}
+
+func (f *fakeEventPartitioner) DropEmptyEventPartitions(ctx context.Context) (int, error) {
+	f.calls = append(f.calls, "drop")
+	return f.dropped, nil
+}
+
+type fakeRunExpirer struct {
+	calls     int
+	policy    domain.RunRetentionPolicy
+	batchSize int
+	expired   int64
+	err       error
+}
+
+func (f *fakeRunExpirer) ExpireRuns(ctx context.Context, policy domain.RunRetentionPolicy, batchSize int) (int64, error) {
+	f.calls++
+	f.policy = policy
+	f.batchSize = batchSize
+	return f.expired, f.err
+}
+
+func TestNewDefaults(t *testing.T) {
+	j := New(Deps{EventRetentionDays: -5})
+
+	if j.logger == nil {
+		t.Fatal("expected default logger to be set")
+	}
+	if j.interval != time.Hour {
+		t.Fatalf("expected default interval=1h, got %s", j.interval)
+	}
+	if j.eventRetentionDays != 0 {
+		t.Fatalf("expected negative retention to clamp to 0, got %d", j.eventRetentionDays)
+	}
+	if j.runRetention.Mode != domain.RunRetentionArchive {
+		t.Fatalf("expected default run retention mode archive, got %q", j.runRetention.Mode)
+	}
+}
+
+func TestRunOncePrunesEventsWithDefaultRetention(t *testing.T) {
+	pruner := &fakeEventPruner{pruned: 12}
+	j := New(Deps{
+		Events:             pruner,
+		Logger:             slog.New(slog.NewTextHandler(io.Discard, nil)),
+		EventRetentionDays: 30,
+	})
+
+	if err := j.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pruner.calls != 1 {
+		t.Fatalf("expected one prune call, got %d", pruner.calls)
+	}
+	if pruner.defaultDays != 30 {
+		t.Fatalf("expected default retention 30 forwarded, got %d", pruner.defaultDays)
+	}
+	if pruner.batchSize != defaultPruneBatchSize {
+		t.Fatalf("expected batch size %d got %d", defaultPruneBatchSize, pruner.batchSize)
+	}
+}
+
+func TestRunOnceReturnsPruneError(t *testing.T) {
+	wantErr := errors.New("db down")
+	j := New(Deps{
+		Events: &fakeEventPruner{err: wantErr},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	if err := j.RunOnce(context.Background()); !errors.Is(err, wantErr) {
+		t.Fatalf("expected %v got %v", wantErr, err)
+	}
+}
+
+func TestRunOncePrunesExpiredRunRequests(t *testing.T) {
+	requests := &fakeRunRequestPruner{pruned: 3}
+	j := New(Deps{
+		RunRequests:       requests,
+		Logger:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+		IdempotencyKeyTTL: 24 * time.Hour,
+	})
+
+	if err := j.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requests.calls != 1 {
+		t.Fatalf("expected one prune call, got %d", requests.calls)
+	}
+	if requests.ttl != 24*time.Hour {
+		t.Fatalf("expected ttl 24h forwarded, got %s", requests.ttl)
+	}
+	if requests.batchSize != defaultPruneBatchSize {
+		t.Fatalf("expected batch size %d got %d", defaultPruneBatchSize, requests.batchSize)
+	}
+}
+
+func TestRunOnceSkipsRunRequestsWithoutTTL(t *testing.T) {
+	requests := &fakeRunRequestPruner{}
+	j := New(Deps{
+		RunRequests: requests,
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	if err := j.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requests.calls != 0 {
+		t.Fatalf("expected no prune call without a ttl, got %d", requests.calls)
+	}
+}
+
+func TestRunOnceContinuesAfterEventPruneError(t *testing.T) {
+	wantErr := errors.New("db down")
+	requests := &fakeRunRequestPruner{}
+	j := New(Deps{
+		Events:            &fakeEventPruner{err: wantErr},
+		RunRequests:       requests,
+		Logger:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+		IdempotencyKeyTTL: time.Hour,
+	})
+
+	if err := j.RunOnce(context.Background()); !errors.Is(err, wantErr) {
+		t.Fatalf("expected %v got %v", wantErr, err)
+	}
+	if requests.calls != 1 {
+		t.Fatalf("expected run request pruning to still run, got %d calls", requests.calls)
+	}
+}
+
+func TestRunStopsWhenContextCanceled(t *testing.T) {
+	pruner := &fakeEventPruner{}
+	j := New(Deps{
+		Events:   pruner,
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Interval: time.Hour,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		j.Run(ctx)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Run to return after context cancellation")
+	}
+	if pruner.calls < 1 {
+		t.Fatal("expected an initial pass before waiting on the interval")
+	}
+}
+
+func TestRunOnceExpiresRunsWithPolicy(t *testing.T) {
+	runs := &fakeRunExpirer{expired: 4}
+	policy := domain.RunRetentionPolicy{DefaultDays: 90, Mode: domain.RunRetentionDelete, DryRun: true}
+	j := New(Deps{
+		Runs:         runs,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		RunRetention: policy,
+	})
+
+	if err := j.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if runs.calls != 1 {
+		t.Fatalf("expected one expire call, got %d", runs.calls)
+	}
+	if runs.policy != policy {
+		t.Fatalf("expected policy %+v forwarded, got %+v", policy, runs.policy)
+	}
+	if runs.batchSize != defaultPruneBatchSize {
+		t.Fatalf("expected batch size %d got %d", defaultPruneBatchSize, runs.batchSize)
+	}
+}
+
+func TestRunOnceReturnsRunExpiryError(t *testing.T) {
+	wantErr := errors.New("db down")
+	events := &fakeEventPruner{}
+	j := New(Deps{
+		Events: events,
+		Runs:   &fakeRunExpirer{err: wantErr},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	if err := j.RunOnce(context.Background()); !errors.Is(err, wantErr) {
+		t.Fatalf("expected %v got %v", wantErr, err)
+	}
+	if events.calls != 1 {
+		t.Fatalf("expected event pruning to still run, got %d calls", events.calls)
+	}
+}
+
+func TestRunOnceMaintainsEventPartitionsAroundPruning(t *testing.T) {
+	partitions := &fakeEventPartitioner{dropped: 1, ensureErr: errors.New("lock timeout")}
+	events := &fakeEventPruner{}
+	j := New(Deps{
+		Events:     events,
+		Partitions: partitions,
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	if err := j.RunOnce(context.Background()); !errors.Is(err, partitions.ensureErr) {
+		t.Fatalf("expected %v got %v", partitions.ensureErr, err)
+	}
+	if len(partitions.calls) != 2 || partitions.calls[0] != "ensure" || partitions.calls[1] != "drop" {
+		t.Fatalf("expected ensure before drop, got %v", partitions.calls)
+	}
+	if partitions.monthsAhead != eventPartitionMonthsAhead {
+		t.Fatalf("expected %d months ahead got %d", eventPartitionMonthsAhead, partitions.monthsAhead)
+	}
+	if events.calls != 1 {
+		t.Fatalf("expected event pruning to still run, got %d calls", events.calls)
+	}
+}
+
+type fakeOutboxPruner struct {
+	calls  int
+	maxAge time.Duration
+	pruned int64
+}
+
+func (f *fakeOutboxPruner) PruneOutboxMessages(ctx context.Context, maxAge time.Duration, batchSize int) (int64, error) {
+	f.calls++
+	f.maxAge = maxAge
+	return f.pruned, nil
+}
+
+func TestRunOncePrunesOutboxOnlyWithRetention(t *testing.T) {
+	outbox := &fakeOutboxPruner{pruned: 2}
+	j := New(Deps{
+		Outbox:          outbox,
+		Logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+		OutboxRetention: 72 * time.Hour,
+	})
+	if err := j.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outbox.calls != 1 || outbox.maxAge != 72*time.Hour {
+		t.Fatalf("expected one prune with 72h, got %d calls with %s", outbox.calls, outbox.maxAge)
+	}
+
+	outbox = &fakeOutboxPruner{}
+	j = New(Deps{Outbox: outbox, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := j.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outbox.calls != 0 {
+		t.Fatalf("expected no prune without a retention, got %d", outbox.calls)
+	}
+}
@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package kafkarest talks to Kafka through a Confluent REST Proxy (v2 API):
+// producing JSON records, and consuming them with a consumer group instance
+// that commits offsets explicitly.
+package kafkarest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Timeout is the HTTP client timeout when none is given.
+	Timeout = 10 * time.Second
+
+	contentTypeJSON = "application/vnd.kafka.json.v2+json"
+	contentTypeV2   = "application/vnd.kafka.v2+json"
+	maxResponse     = 8 << 20
+)
+
+// Client is a REST Proxy client.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// New returns a client for the REST Proxy at baseURL. A nil client gets a 10s
+// timeout.
+func New(baseURL string, client *http.Client) *Client {
+	if client == nil {
+		client = &http.Client{Timeout: Timeout}
+	}
+	return &Client{baseURL: strings.TrimRight(baseURL, "/"), http: client}
+}
+
+// Record is a JSON record to produce.
+type Record struct {
+	Key   string          `json:"key,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Produce writes records to topic and returns once the proxy reports each
+// written, or the first record error.
+func (c *Client) Produce(ctx context.Context, topic string, records ...Record) error {
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/topics/"+url.PathEscape(topic), contentTypeJSON,
+		map[string]any{"records": records}, &result); err != nil {
+		return fmt.Errorf("kafka produce: %w", err)
+	}
+	for _, o := range result.Offsets {
+		if o.Error != "" || (o.ErrorCode != nil && *o.ErrorCode != 0) {
+			return fmt.Errorf("kafka produce failed: %s", o.Error)
+		}
+	}
+	return nil
+}
+
+// ConsumedRecord is a record read by a Consumer.
+type ConsumedRecord struct {
+	Topic     string          `json:"topic"`
+	Key       json.RawMessage `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Partition int             `json:"partition"`
+	Offset    int64           `json:"offset"`
+}
+
+// Consumer is a consumer instance in a consumer group. Offsets are committed
+// only by Commit.
+type Consumer struct {
+	client *Client
+	path   string
+}
+
+// NewConsumer creates consumer instance name in group, starting from the
+// earliest offset when the group has none, and subscribes it to topics.
+func (c *Client) NewConsumer(ctx context.Context, group, name string, topics ...string) (*Consumer, error) {
+	var created struct {
+		InstanceID string `json:"instance_id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/consumers/"+url.PathEscape(group), contentTypeV2, map[string]string{
+		"name":               name,
+		"format":             "json",
+		"auto.offset.reset":  "earliest",
+		"auto.commit.enable": "false",
+	}, &created); err != nil {
+		return nil, fmt.Errorf("create kafka consumer: %w", err)
+	}
+	if created.InstanceID == "" {
+		created.InstanceID = name
+	}
+
+	consumer := &Consumer{
+		client: c,
+		path:   "/consumers/" + url.PathEscape(group) + "/instances/" + url.PathEscape(created.InstanceID),
+	}
+	if err := c.do(ctx, http.MethodPost, consumer.path+"/subscription", contentTypeV2,
+		map[string][]string{"topics": topics}, nil); err != nil {
+		consumer.Close(ctx)
+		return nil, fmt.Errorf("subscribe kafka consumer: %w", err)
+	}
+	return consumer, nil
+}
+
+// Poll fetches the records available now, possibly none.
+func (c *Consumer) Poll(ctx context.Context) ([]ConsumedRecord, error) {
+	var records []ConsumedRecord
+	if err := c.client.do(ctx, http.MethodGet, c.path+"/records", contentTypeJSON, nil, &records); err != nil {
+		return nil, fmt.Errorf("poll kafka consumer: %w", err)
+	}
+	return records, nil
+}
+
+// Commit commits the offsets past records.
+func (c *Consumer) Commit(ctx context.Context, records []ConsumedRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	type offset struct {
+		Topic     string `json:"topic"`
+		Partition int    `json:"partition"`
+		Offset    int64  `json:"offset"`
+	}
+	offsets := make([]offset, 0, len(records))
+	for _, r := range records {
+		// The REST Proxy commits the given offset as the next one to read.
+		offsets = append(offsets, offset{Topic: r.Topic, Partition: r.Partition, Offset: r.Offset + 1})
+	}
+	if err := c.client.do(ctx, http.MethodPost, c.path+"/offsets", contentTypeV2,
+		map[string]any{"offsets": offsets}, nil); err != nil {
+		return fmt.Errorf("commit kafka offsets: %w", err)
+	}
+	return nil
+}
+
+// Close deletes the consumer instance so the group rebalances right away.
+func (c *Consumer) Close(ctx context.Context) error {
+	return c.client.do(ctx, http.MethodDelete, c.path, contentTypeV2, nil, nil)
+}
+
+// do sends body as JSON with contentType, which is also the accepted response
+// type for reads, and decodes a 2xx response into out when out is not nil.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if method == http.MethodGet {
+		req.Header.Set("Accept", contentType)
+	} else {
+		req.Header.Set("Accept", contentTypeV2)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s returned %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponse)).Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
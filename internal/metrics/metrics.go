@@ -3,21 +3,91 @@
 package metrics
 
 import (
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Outcome labels for webhook_deliveries_total.
+const (
+	WebhookOutcomeDelivered = "delivered"
+	WebhookOutcomeRetry     = "retry"
+	WebhookOutcomeFailed    = "failed"
+)
+
+// Reason labels for replica_read_fallbacks_total.
+const (
+	ReplicaFallbackUnavailable = "unavailable"
+	ReplicaFallbackNotFound    = "not_found"
+)
+
+// Outcome labels for outbox_messages_total.
+const (
+	OutboxOutcomePublished = "published"
+	OutboxOutcomeRetry     = "retry"
+	OutboxOutcomeDropped   = "dropped"
+)
+
+// Outcome labels for ingest_commands_total.
+const (
+	IngestOutcomeCreated  = "created"
+	IngestOutcomeRejected = "rejected"
+	IngestOutcomeFailed   = "failed"
+)
+
+// Outcome labels for export_jobs_total.
+const (
+	ExportOutcomeSucceeded = "succeeded"
+	ExportOutcomeFailed    = "failed"
+)
+
 var (
-	initOnce sync.Once
+	initOnce     sync.Once
+	tenantLabels atomic.Bool
 
 	runsTotalCounter            *prometheus.CounterVec
 	stepsTotalCounter           *prometheus.CounterVec
 	stepExecutionDurationMetric prometheus.Histogram
 	stepRetriesCounter          prometheus.Counter
+	stepLeasesLostCounter       prometheus.Counter
+	executorCircuitStateGauge   *prometheus.GaugeVec
+	executorCircuitOpensCounter *prometheus.CounterVec
 	workerClaimLatencyMetric    prometheus.Histogram
+	stepClaimsCounter           *prometheus.CounterVec
+	workerClaimBatchSizeMetric  prometheus.Histogram
+	eventsPrunedCounter         prometheus.Counter
+	runRequestsPrunedCounter    prometheus.Counter
+	runsExpiredCounter          *prometheus.CounterVec
+	eventPartitionsDropped      prometheus.Counter
+	runsExpiryEligibleGauge     prometheus.Gauge
+	approvalEscalationsCounter  *prometheus.CounterVec
+	approvalTimeoutsCounter     *prometheus.CounterVec
+	webhookDeliveriesCounter    *prometheus.CounterVec
+	notificationsCounter        *prometheus.CounterVec
+	apiKeyExpiryWarnings        prometheus.Counter
+	replicaFallbacksCounter     *prometheus.CounterVec
+	outboxMessagesCounter       *prometheus.CounterVec
+	ingestCommandsCounter       *prometheus.CounterVec
+	exportJobsCounter           *prometheus.CounterVec
+	activeStreamsGauge          prometheus.Gauge
+	httpRequestsCounter         *prometheus.CounterVec
+	httpRequestDurationMetric   *prometheus.HistogramVec
+	httpInFlightGauge           prometheus.Gauge
+	transitionAnomaliesCounter  *prometheus.CounterVec
+	runsReconciledCounter       *prometheus.CounterVec
+	queuePendingStepsGauge      prometheus.Gauge
+	queueRunnableStepsGauge     prometheus.Gauge
+	queueOldestPendingAgeGauge  prometheus.Gauge
+	runsWaitingApprovalGauge    prometheus.Gauge
+	workersStaleGauge           prometheus.Gauge
+	tenantsWithoutWorkerGauge   prometheus.Gauge
+	tenantQueueDepthGauge       *prometheus.GaugeVec
+	tenantClaimStarvationGauge  *prometheus.GaugeVec
 )
 
 // Init registers metrics on the default Prometheus registry exactly once.
@@ -26,17 +96,17 @@ func Init() {
 		runsTotalCounter = prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "runs_total",
-				Help: "Total number of run status transitions by status.",
+				Help: "Total number of run status transitions by status and, with tenant labels enabled, api key.",
 			},
-			[]string{"status"},
+			[]string{"status", "tenant"},
 		)
 
 		stepsTotalCounter = prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "steps_total",
-				Help: "Total number of step terminal updates by status.",
+				Help: "Total number of step terminal updates by status and, with tenant labels enabled, api key.",
 			},
-			[]string{"status"},
+			[]string{"status", "tenant"},
 		)
 
 		stepExecutionDurationMetric = prometheus.NewHistogram(
@@ -54,6 +124,29 @@ func Init() {
 			},
 		)
 
+		stepLeasesLostCounter = prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "step_leases_lost_total",
+				Help: "Total number of step leases a worker failed to renew because the step was reclaimed or settled elsewhere.",
+			},
+		)
+
+		executorCircuitStateGauge = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "executor_circuit_state",
+				Help: "State of the worker's circuit breaker per step type: 0 closed, 1 half-open, 2 open.",
+			},
+			[]string{"step"},
+		)
+
+		executorCircuitOpensCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "executor_circuit_opens_total",
+				Help: "Total number of times a worker's circuit breaker opened and stopped claiming a step type.",
+			},
+			[]string{"step"},
+		)
+
 		workerClaimLatencyMetric = prometheus.NewHistogram(
 			prometheus.HistogramOpts{
 				Name:    "worker_claim_latency_seconds",
@@ -62,12 +155,273 @@ func Init() {
 			},
 		)
 
+		workerClaimBatchSizeMetric = prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "worker_claim_batch_size",
+				Help:    "Steps claimed per batch claim transaction.",
+				Buckets: []float64{1, 2, 4, 8, 16, 32, 64},
+			},
+		)
+
+		stepClaimsCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "step_claims_total",
+				Help: "Total number of steps claimed by workers and, with tenant labels enabled, by api key.",
+			},
+			[]string{"tenant"},
+		)
+
+		eventsPrunedCounter = prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "events_pruned_total",
+				Help: "Total number of events deleted by the retention janitor.",
+			},
+		)
+
+		runRequestsPrunedCounter = prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "run_requests_pruned_total",
+				Help: "Total number of expired idempotency records deleted by the janitor.",
+			},
+		)
+
+		eventPartitionsDropped = prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "event_partitions_dropped_total",
+				Help: "Total number of emptied monthly events partitions dropped by the janitor.",
+			},
+		)
+
+		runsExpiredCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "runs_expired_total",
+				Help: "Total number of terminal runs removed by the retention janitor, by mode (archive or delete).",
+			},
+			[]string{"mode"},
+		)
+
+		runsExpiryEligibleGauge = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "runs_retention_dry_run_eligible",
+				Help: "Number of terminal runs the last dry-run retention pass would have removed.",
+			},
+		)
+
+		approvalEscalationsCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "approval_escalations_total",
+				Help: "Total number of waiting approvals escalated, by escalation level.",
+			},
+			[]string{"level"},
+		)
+
+		approvalTimeoutsCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "approval_timeouts_total",
+				Help: "Total number of waiting approvals that timed out, by timeout action.",
+			},
+			[]string{"action"},
+		)
+
+		notificationsCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "notifications_total",
+				Help: "Total number of approval and failure notifications by kind and outcome.",
+			},
+			[]string{"kind", "outcome"},
+		)
+
+		webhookDeliveriesCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "webhook_deliveries_total",
+				Help: "Total number of webhook delivery attempts by outcome.",
+			},
+			[]string{"outcome"},
+		)
+
+		apiKeyExpiryWarnings = prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "api_key_expiry_warnings_total",
+				Help: "Total number of requests authenticated with an API key that is close to expiry.",
+			},
+		)
+
+		replicaFallbacksCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "replica_read_fallbacks_total",
+				Help: "Total number of reads sent to the primary instead of the read replica, by reason.",
+			},
+			[]string{"reason"},
+		)
+
+		outboxMessagesCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "outbox_messages_total",
+				Help: "Total number of outbox messages published, retried, or dropped unpublished by retention.",
+			},
+			[]string{"outcome"},
+		)
+
+		ingestCommandsCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ingest_commands_total",
+				Help: "Total number of run-creation commands consumed from the message queue, by outcome.",
+			},
+			[]string{"outcome"},
+		)
+
+		exportJobsCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "export_jobs_total",
+				Help: "Total number of tenant export jobs built, by outcome.",
+			},
+			[]string{"outcome"},
+		)
+
+		activeStreamsGauge = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "http_active_streams",
+				Help: "Number of open streaming requests (SSE event streams).",
+			},
+		)
+
+		httpRequestsCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_requests_total",
+				Help: "Total number of API requests by route pattern, method, and status code.",
+			},
+			[]string{"route", "method", "status"},
+		)
+
+		httpRequestDurationMetric = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_request_duration_seconds",
+				Help:    "Duration of API requests in seconds by route pattern and method.",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"route", "method"},
+		)
+
+		httpInFlightGauge = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "http_requests_in_flight",
+				Help: "Number of API requests currently being served.",
+			},
+		)
+
+		transitionAnomaliesCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "state_transition_anomalies_total",
+				Help: "Total number of run or step status changes rejected by the state machine.",
+			},
+			[]string{"entity", "from", "to"},
+		)
+
+		runsReconciledCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "runs_reconciled_total",
+				Help: "Total number of stale runs whose status was recomputed from their steps, by new status.",
+			},
+			[]string{"status"},
+		)
+
+		queuePendingStepsGauge = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "queue_pending_steps",
+				Help: "Number of PENDING steps in active runs, excluding approvals.",
+			},
+		)
+
+		queueRunnableStepsGauge = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "queue_runnable_steps",
+				Help: "Number of pending steps a worker could claim now: retry due and earlier steps settled.",
+			},
+		)
+
+		queueOldestPendingAgeGauge = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "queue_oldest_pending_age_seconds",
+				Help: "How long the oldest runnable step has been ready, in seconds; 0 when nothing is runnable.",
+			},
+		)
+
+		runsWaitingApprovalGauge = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "runs_waiting_approval",
+				Help: "Number of active runs with an approval step waiting.",
+			},
+		)
+
+		workersStaleGauge = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "workers_stale",
+				Help: "Number of workers seen in the last 24h whose heartbeat stopped more than 2 minutes ago.",
+			},
+		)
+
+		tenantsWithoutWorkerGauge = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "tenants_without_live_worker",
+				Help: "Number of tenants with queued runs or recent workers but no live worker.",
+			},
+		)
+
+		tenantQueueDepthGauge = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "tenant_queue_depth",
+				Help: "Number of steps a tenant's worker could claim now, by api key. Only set with tenant labels enabled.",
+			},
+			[]string{"tenant"},
+		)
+
+		tenantClaimStarvationGauge = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "tenant_claim_starvation_seconds",
+				Help: "How long a tenant's oldest claimable step has been ready without being claimed, by api key. Only set with tenant labels enabled.",
+			},
+			[]string{"tenant"},
+		)
+
 		prometheus.MustRegister(
 			runsTotalCounter,
 			stepsTotalCounter,
 			stepExecutionDurationMetric,
 			stepRetriesCounter,
+			stepLeasesLostCounter,
+			executorCircuitStateGauge,
+			executorCircuitOpensCounter,
 			workerClaimLatencyMetric,
+			stepClaimsCounter,
+			workerClaimBatchSizeMetric,
+			eventsPrunedCounter,
+			runRequestsPrunedCounter,
+			runsExpiredCounter,
+			runsExpiryEligibleGauge,
+			eventPartitionsDropped,
+			approvalEscalationsCounter,
+			approvalTimeoutsCounter,
+			webhookDeliveriesCounter,
+			notificationsCounter,
+			apiKeyExpiryWarnings,
+			replicaFallbacksCounter,
+			outboxMessagesCounter,
+			ingestCommandsCounter,
+			exportJobsCounter,
+			activeStreamsGauge,
+			httpRequestsCounter,
+			httpRequestDurationMetric,
+			httpInFlightGauge,
+			transitionAnomaliesCounter,
+			runsReconciledCounter,
+			queuePendingStepsGauge,
+			queueRunnableStepsGauge,
+			queueOldestPendingAgeGauge,
+			runsWaitingApprovalGauge,
+			workersStaleGauge,
+			tenantsWithoutWorkerGauge,
+			tenantQueueDepthGauge,
+			tenantClaimStarvationGauge,
 		)
 
 		// Ensure counter vectors are visible at /metrics before first increment.
@@ -79,7 +433,7 @@ func Init() {
 			domain.RunFailed,
 			domain.RunCanceled,
 		} {
-			runsTotalCounter.WithLabelValues(string(status))
+			runsTotalCounter.WithLabelValues(string(status), "")
 		}
 
 		for _, status := range []domain.StepStatus{
@@ -89,20 +443,80 @@ func Init() {
 			domain.StepSuccess,
 			domain.StepFailed,
 			domain.StepCanceled,
+			domain.StepSkipped,
+		} {
+			stepsTotalCounter.WithLabelValues(string(status), "")
+		}
+		stepClaimsCounter.WithLabelValues("")
+
+		for _, outcome := range []string{
+			WebhookOutcomeDelivered,
+			WebhookOutcomeRetry,
+			WebhookOutcomeFailed,
+		} {
+			webhookDeliveriesCounter.WithLabelValues(outcome)
+		}
+
+		for _, reason := range []string{
+			ReplicaFallbackUnavailable,
+			ReplicaFallbackNotFound,
+		} {
+			replicaFallbacksCounter.WithLabelValues(reason)
+		}
+
+		for _, outcome := range []string{
+			OutboxOutcomePublished,
+			OutboxOutcomeRetry,
+			OutboxOutcomeDropped,
+		} {
+			outboxMessagesCounter.WithLabelValues(outcome)
+		}
+
+		for _, outcome := range []string{
+			IngestOutcomeCreated,
+			IngestOutcomeRejected,
+			IngestOutcomeFailed,
+		} {
+			ingestCommandsCounter.WithLabelValues(outcome)
+		}
+
+		for _, outcome := range []string{
+			ExportOutcomeSucceeded,
+			ExportOutcomeFailed,
 		} {
-			stepsTotalCounter.WithLabelValues(string(status))
+			exportJobsCounter.WithLabelValues(outcome)
 		}
 	})
 }
 
-func IncRunStatus(status string) {
+// SetTenantLabels turns the tenant label of per-status counters on or off.
+// It is off by default because every api key adds a series per status.
+func SetTenantLabels(enabled bool) {
+	tenantLabels.Store(enabled)
+}
+
+// TenantLabels reports whether per-tenant labels are enabled.
+func TenantLabels() bool {
+	return tenantLabels.Load()
+}
+
+// tenantLabel is the tenant label value for apiKeyID: empty, which Prometheus
+// treats as no label, unless tenant labels are enabled.
+func tenantLabel(apiKeyID uuid.UUID) string {
+	if !tenantLabels.Load() || apiKeyID == uuid.Nil {
+		return ""
+	}
+	return apiKeyID.String()
+}
+
+func IncRunStatus(apiKeyID uuid.UUID, status string) {
 	Init()
-	runsTotalCounter.WithLabelValues(status).Inc()
+	runsTotalCounter.WithLabelValues(status, tenantLabel(apiKeyID)).Inc()
 }
 
-func IncStepStatus(status string) {
+func IncStepStatus(apiKeyID uuid.UUID, status string) {
 	Init()
-	stepsTotalCounter.WithLabelValues(status).Inc()
+	stepsTotalCounter.WithLabelValues(status, tenantLabel(apiKeyID)).Inc()
 }
 
 func ObserveStepExecutionDuration(d time.Duration) {
@@ -115,7 +529,194 @@ func IncStepRetries() {
 	stepRetriesCounter.Inc()
 }
 
+func IncStepLeaseLost() {
+	Init()
+	stepLeasesLostCounter.Inc()
+}
+
+// SetExecutorCircuitState records the circuit breaker state of a step type:
+// 0 closed, 1 half-open, 2 open.
+func SetExecutorCircuitState(step string, state int) {
+	Init()
+	executorCircuitStateGauge.WithLabelValues(step).Set(float64(state))
+}
+
+func IncExecutorCircuitOpen(step string) {
+	Init()
+	executorCircuitOpensCounter.WithLabelValues(step).Inc()
+}
+
 func ObserveWorkerClaimLatency(d time.Duration) {
 	Init()
 	workerClaimLatencyMetric.Observe(d.Seconds())
 }
+
+// ObserveClaimBatchSize records how many steps one batch claim took.
+func ObserveClaimBatchSize(n int) {
+	Init()
+	workerClaimBatchSizeMetric.Observe(float64(n))
+}
+
+// IncStepClaim counts a step claimed for apiKeyID.
+func IncStepClaim(apiKeyID uuid.UUID) {
+	Init()
+	stepClaimsCounter.WithLabelValues(tenantLabel(apiKeyID)).Inc()
+}
+
+func AddEventsPruned(n int64) {
+	Init()
+	if n <= 0 {
+		return
+	}
+	eventsPrunedCounter.Add(float64(n))
+}
+
+func AddRunRequestsPruned(n int64) {
+	Init()
+	if n <= 0 {
+		return
+	}
+	runRequestsPrunedCounter.Add(float64(n))
+}
+
+func AddEventPartitionsDropped(n int) {
+	Init()
+	if n <= 0 {
+		return
+	}
+	eventPartitionsDropped.Add(float64(n))
+}
+
+// AddRunsExpired counts terminal runs removed by retention in mode.
+func AddRunsExpired(mode domain.RunRetentionMode, n int64) {
+	Init()
+	if n <= 0 {
+		return
+	}
+	runsExpiredCounter.WithLabelValues(string(mode)).Add(float64(n))
+}
+
+// SetRunsExpiryEligible records how many runs a dry-run retention pass found.
+func SetRunsExpiryEligible(n int64) {
+	Init()
+	runsExpiryEligibleGauge.Set(float64(n))
+}
+
+func AddApprovalEscalations(level int, n int64) {
+	Init()
+	if n <= 0 {
+		return
+	}
+	approvalEscalationsCounter.WithLabelValues(strconv.Itoa(level)).Add(float64(n))
+}
+
+func IncApprovalTimeout(action string) {
+	Init()
+	approvalTimeoutsCounter.WithLabelValues(action).Inc()
+}
+
+func IncNotification(kind, outcome string) {
+	Init()
+	notificationsCounter.WithLabelValues(kind, outcome).Inc()
+}
+
+func IncWebhookDelivery(outcome string) {
+	Init()
+	webhookDeliveriesCounter.WithLabelValues(outcome).Inc()
+}
+
+func IncAPIKeyExpiryWarning() {
+	Init()
+	apiKeyExpiryWarnings.Inc()
+}
+
+// IncReplicaFallback counts a read retried on the primary.
+func IncReplicaFallback(reason string) {
+	Init()
+	replicaFallbacksCounter.WithLabelValues(reason).Inc()
+}
+
+// AddOutboxMessages counts n outbox messages with outcome.
+func AddOutboxMessages(outcome string, n int64) {
+	Init()
+	if n > 0 {
+		outboxMessagesCounter.WithLabelValues(outcome).Add(float64(n))
+	}
+}
+
+// IncIngestCommand counts a consumed run-creation command with outcome.
+func IncIngestCommand(outcome string) {
+	Init()
+	ingestCommandsCounter.WithLabelValues(outcome).Inc()
+}
+
+// IncExportJob counts a built export job with outcome.
+func IncExportJob(outcome string) {
+	Init()
+	exportJobsCounter.WithLabelValues(outcome).Inc()
+}
+
+// AddActiveStreams moves the open streaming request gauge by delta.
+func AddActiveStreams(delta int) {
+	Init()
+	activeStreamsGauge.Add(float64(delta))
+}
+
+// ObserveHTTPRequest records one served API request.
+func ObserveHTTPRequest(route, method string, status int, d time.Duration) {
+	Init()
+	httpRequestsCounter.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	httpRequestDurationMetric.WithLabelValues(route, method).Observe(d.Seconds())
+}
+
+// AddHTTPInFlight moves the in-flight API request gauge by delta.
+func AddHTTPInFlight(delta int) {
+	Init()
+	httpInFlightGauge.Add(float64(delta))
+}
+
+// IncTransitionAnomaly counts a status change rejected by the state machine.
+func IncTransitionAnomaly(entity, from, to string) {
+	Init()
+	transitionAnomaliesCounter.WithLabelValues(entity, from, to).Inc()
+}
+
+// IncRunReconciled counts a stale run moved to status by reconciliation.
+func IncRunReconciled(status string) {
+	Init()
+	runsReconciledCounter.WithLabelValues(status).Inc()
+}
+
+// SetQueueStats updates the runtime-wide backlog gauges.
+func SetQueueStats(stats domain.QueueStats) {
+	Init()
+	queuePendingStepsGauge.Set(float64(stats.PendingSteps))
+	queueRunnableStepsGauge.Set(float64(stats.RunnableSteps))
+	queueOldestPendingAgeGauge.Set(stats.OldestPendingAge.Seconds())
+	runsWaitingApprovalGauge.Set(float64(stats.WaitingApprovalRuns))
+}
+
+// SetWorkerLiveness updates the stale worker and tenants without a live worker
+// gauges.
+func SetWorkerLiveness(liveness domain.WorkerLiveness) {
+	Init()
+	workersStaleGauge.Set(float64(liveness.StaleWorkers))
+	tenantsWithoutWorkerGauge.Set(float64(len(liveness.TenantsWithoutLiveWorker)))
+}
+
+// SetTenantBacklog replaces the per-tenant queue depth and claim starvation
+// gauges. It does nothing unless tenant labels are enabled.
+func SetTenantBacklog(backlog []domain.TenantBacklog) {
+	Init()
+	if !tenantLabels.Load() {
+		return
+	}
+
+	tenantQueueDepthGauge.Reset()
+	tenantClaimStarvationGauge.Reset()
+	for _, b := range backlog {
+		tenant := b.APIKeyID.String()
+		tenantQueueDepthGauge.WithLabelValues(tenant).Set(float64(b.Claimable))
+		tenantClaimStarvationGauge.WithLabelValues(tenant).Set(b.Starvation.Seconds())
+	}
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestTenantLabels(t *testing.T) {
+	defer SetTenantLabels(false)
+	apiKeyID := uuid.New()
+
+	SetTenantLabels(false)
+	IncRunStatus(apiKeyID, string(domain.RunSuccess))
+	if m := findMetric(t, "runs_total", map[string]string{"tenant": apiKeyID.String()}); m != nil {
+		t.Fatal("expected no tenant series while tenant labels are disabled")
+	}
+	SetTenantBacklog([]domain.TenantBacklog{{APIKeyID: apiKeyID, Claimable: 1}})
+	if m := findMetric(t, "tenant_queue_depth", map[string]string{"tenant": apiKeyID.String()}); m != nil {
+		t.Fatal("expected no backlog series while tenant labels are disabled")
+	}
+
+	SetTenantLabels(true)
+	IncRunStatus(apiKeyID, string(domain.RunSuccess))
+	IncStepStatus(apiKeyID, string(domain.StepFailed))
+	run := findMetric(t, "runs_total", map[string]string{"status": string(domain.RunSuccess), "tenant": apiKeyID.String()})
+	if run == nil || run.GetCounter().GetValue() != 1 {
+		t.Fatalf("expected one tenant-labeled run, got %v", run)
+	}
+	if step := findMetric(t, "steps_total", map[string]string{"status": string(domain.StepFailed), "tenant": apiKeyID.String()}); step == nil {
+		t.Fatal("expected a tenant-labeled step series")
+	}
+
+	SetTenantBacklog([]domain.TenantBacklog{{APIKeyID: apiKeyID, Claimable: 4, Starvation: 90 * time.Second}})
+	depth := findMetric(t, "tenant_queue_depth", map[string]string{"tenant": apiKeyID.String()})
+	starvation := findMetric(t, "tenant_claim_starvation_seconds", map[string]string{"tenant": apiKeyID.String()})
+	if depth.GetGauge().GetValue() != 4 || starvation.GetGauge().GetValue() != 90 {
+		t.Fatalf("unexpected backlog gauges: depth=%v starvation=%v", depth, starvation)
+	}
+
+	SetTenantBacklog(nil)
+	if m := findMetric(t, "tenant_queue_depth", map[string]string{"tenant": apiKeyID.String()}); m != nil {
+		t.Fatal("expected tenants without backlog to be dropped")
+	}
+}
+
+func findMetric(t *testing.T, name string, labels map[string]string) *dto.Metric {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	next:
+		for _, m := range family.GetMetric() {
+			got := map[string]string{}
+			for _, pair := range m.GetLabel() {
+				got[pair.GetName()] = pair.GetValue()
+			}
+			for k, v := range labels {
+				if got[k] != v {
+					continue next
+				}
+			}
+			return m
+		}
+	}
+	return nil
+}
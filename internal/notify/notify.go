@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package notify tells humans about approvals that are waiting and runs that
+// failed, through a Slack incoming webhook and/or email, so nobody has to poll
+// for pending gates.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+)
+
+const (
+	defaultBatchSize = 100
+	// DefaultApprovalLink is the approval link used when none is configured.
+	DefaultApprovalLink = domain.DefaultApprovalLink
+)
+
+// Message is what a Sender delivers.
+type Message struct {
+	Subject string
+	Text    string
+}
+
+// Sender delivers a message to one channel.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NotificationStore claims unreported approvals and failures.
+type NotificationStore interface {
+	ClaimNotifications(ctx context.Context, maxAge time.Duration, batchSize int) ([]domain.Notification, error)
+	ReleaseNotification(ctx context.Context, n domain.Notification) error
+}
+
+type Deps struct {
+	Store   NotificationStore
+	Senders []Sender
+	Logger  *slog.Logger
+	// Interval is how often the notifier sweeps.
+	Interval time.Duration
+	// MaxAge drops approvals and failures older than this unsent.
+	MaxAge time.Duration
+	// ApprovalLink is the link put in approval messages; {run_id} and
+	// {step_id} are replaced.
+	ApprovalLink string
+}
+
+// Notifier runs the notification sweep.
+type Notifier struct {
+	store        NotificationStore
+	senders      []Sender
+	logger       *slog.Logger
+	interval     time.Duration
+	maxAge       time.Duration
+	approvalLink string
+}
+
+func New(deps Deps) *Notifier {
+	l := deps.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+
+	interval := deps.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	link := strings.TrimSpace(deps.ApprovalLink)
+	if link == "" {
+		link = DefaultApprovalLink
+	}
+
+	return &Notifier{
+		store:        deps.Store,
+		senders:      deps.Senders,
+		logger:       l,
+		interval:     interval,
+		maxAge:       deps.MaxAge,
+		approvalLink: link,
+	}
+}
+
+// Run executes RunOnce immediately and then on every interval until ctx is
+// done. It returns at once when no sender is configured.
+func (n *Notifier) Run(ctx context.Context) {
+	if len(n.senders) == 0 {
+		n.logger.Info("notifications disabled")
+		return
+	}
+
+	n.logger.Info("notifier started",
+		"interval", n.interval,
+		"max_age", n.maxAge,
+		"senders", len(n.senders),
+	)
+
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := n.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			n.logger.Error("notification pass failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			n.logger.Info("notifier stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce claims pending notifications and sends each to every sender. A
+// notification that any sender failed is released for the next pass, so
+// senders that succeeded may see it again.
+func (n *Notifier) RunOnce(ctx context.Context) error {
+	if n.store == nil || len(n.senders) == 0 {
+		return nil
+	}
+
+	claimed, err := n.store.ClaimNotifications(ctx, n.maxAge, defaultBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, notification := range claimed {
+		msg := n.message(notification)
+		var sendErr error
+		for _, s := range n.senders {
+			sendErr = errors.Join(sendErr, s.Send(ctx, msg))
+		}
+		if sendErr != nil {
+			metrics.IncNotification(string(notification.Kind), "failed")
+			n.logger.Warn("notification failed",
+				"kind", notification.Kind,
+				"run_id", notification.RunID,
+				"error", sendErr,
+			)
+			if err := n.store.ReleaseNotification(ctx, notification); err != nil {
+				return err
+			}
+			continue
+		}
+		metrics.IncNotification(string(notification.Kind), "sent")
+	}
+	return nil
+}
+
+func (n *Notifier) message(notification domain.Notification) Message {
+	switch notification.Kind {
+	case domain.NotificationApprovalWaiting:
+		gate := "Approval"
+		if notification.ApprovalName != "" {
+			gate = fmt.Sprintf("Approval %q", notification.ApprovalName)
+		}
+		link := domain.ApprovalLink(n.approvalLink, notification.RunID, notification.StepID)
+		return Message{
+			Subject: fmt.Sprintf("%s waiting on run %s", gate, notification.RunID),
+			Text: fmt.Sprintf("%s is waiting on run %s (api key %s) since %s.\nApprove: %s",
+				gate, notification.RunID, notification.APIKeyID, notification.At.UTC().Format(time.RFC3339), link),
+		}
+	default:
+		text := fmt.Sprintf("Run %s (api key %s) failed at %s.",
+			notification.RunID, notification.APIKeyID, notification.At.UTC().Format(time.RFC3339))
+		if notification.Error != "" {
+			text += "\nError: " + notification.Error
+		}
+		return Message{
+			Subject: fmt.Sprintf("Run %s failed", notification.RunID),
+			Text:    text,
+		}
+	}
+}
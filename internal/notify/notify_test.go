@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+)
+
+type fakeStore struct {
+	claimed  []domain.Notification
+	maxAge   time.Duration
+	released []domain.Notification
+	err      error
+}
+
+func (f *fakeStore) ClaimNotifications(ctx context.Context, maxAge time.Duration, batchSize int) ([]domain.Notification, error) {
+	f.maxAge = maxAge
+	return f.claimed, f.err
+}
+
+func (f *fakeStore) ReleaseNotification(ctx context.Context, n domain.Notification) error {
+	f.released = append(f.released, n)
+	return nil
+}
+
+type recordingSender struct {
+	sent []Message
+	err  error
+}
+
+func (s *recordingSender) Send(ctx context.Context, msg Message) error {
+	s.sent = append(s.sent, msg)
+	return s.err
+}
+
+func TestRunOnceSendsApprovalWithLink(t *testing.T) {
+	runID := uuid.New()
+	stepID := uuid.New()
+	store := &fakeStore{claimed: []domain.Notification{{
+		Kind:         domain.NotificationApprovalWaiting,
+		RunID:        runID,
+		StepID:       &stepID,
+		ApprovalName: "legal",
+		At:           time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+	}}}
+	sender := &recordingSender{}
+	n := New(Deps{
+		Store:        store,
+		Senders:      []Sender{sender},
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		MaxAge:       time.Hour,
+		ApprovalLink: "https://console.example.com/runs/{run_id}/gates/{step_id}",
+	})
+
+	if err := n.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.maxAge != time.Hour {
+		t.Fatalf("expected max age forwarded, got %s", store.maxAge)
+	}
+	if len(sender.sent) != 1 {
+		t.Fatalf("expected one message, got %d", len(sender.sent))
+	}
+	msg := sender.sent[0]
+	if !strings.Contains(msg.Subject, `Approval "legal"`) {
+		t.Fatalf("expected gate name in subject, got %q", msg.Subject)
+	}
+	wantLink := "https://console.example.com/runs/" + runID.String() + "/gates/" + stepID.String()
+	if !strings.Contains(msg.Text, wantLink) {
+		t.Fatalf("expected link %s in text, got %q", wantLink, msg.Text)
+	}
+	if len(store.released) != 0 {
+		t.Fatalf("expected nothing released, got %d", len(store.released))
+	}
+}
+
+func TestRunOnceReleasesFailedSends(t *testing.T) {
+	store := &fakeStore{claimed: []domain.Notification{{
+		Kind:  domain.NotificationRunFailed,
+		RunID: uuid.New(),
+		Error: "tool exploded",
+		At:    time.Now(),
+	}}}
+	ok := &recordingSender{}
+	failing := &recordingSender{err: errors.New("smtp down")}
+	n := New(Deps{
+		Store:   store,
+		Senders: []Sender{ok, failing},
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	if err := n.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ok.sent) != 1 || len(failing.sent) != 1 {
+		t.Fatalf("expected both senders tried, got %d and %d", len(ok.sent), len(failing.sent))
+	}
+	if !strings.Contains(ok.sent[0].Text, "Error: tool exploded") {
+		t.Fatalf("expected failure error in text, got %q", ok.sent[0].Text)
+	}
+	if len(store.released) != 1 {
+		t.Fatalf("expected the notification released for retry, got %d", len(store.released))
+	}
+}
+
+func TestRunOnceSkipsWithoutSenders(t *testing.T) {
+	store := &fakeStore{err: errors.New("must not be called")}
+	n := New(Deps{Store: store})
+
+	if err := n.RunOnce(context.Background()); err != nil {
+		t.Fatalf("expected no claim without senders, got %v", err)
+	}
+	if n.approvalLink != DefaultApprovalLink || n.interval != 30*time.Second {
+		t.Fatalf("unexpected defaults: link=%s interval=%s", n.approvalLink, n.interval)
+	}
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+const slackTimeout = 10 * time.Second
+
+// Slack posts messages to a Slack incoming webhook.
+type Slack struct {
+	url    string
+	client *http.Client
+}
+
+// NewSlack returns a Slack sender for webhookURL. A nil client gets a 10s
+// timeout.
+func NewSlack(webhookURL string, client *http.Client) *Slack {
+	if client == nil {
+		client = &http.Client{Timeout: slackTimeout}
+	}
+	return &Slack{url: webhookURL, client: client}
+}
+
+func (s *Slack) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(map[string]string{
+		"text": "*" + msg.Subject + "*\n" + msg.Text,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("slack webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SMTPConfig configures the email sender. Username and Password are optional;
+// when set, PLAIN auth is used.
+type SMTPConfig struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// SMTP emails messages through an SMTP server.
+type SMTP struct {
+	cfg SMTPConfig
+	// sendMail is smtp.SendMail, replaced in tests.
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func NewSMTP(cfg SMTPConfig) *SMTP {
+	return &SMTP{cfg: cfg, sendMail: smtp.SendMail}
+}
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(s.cfg.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(msg.Subject))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Text, "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	return s.sendMail(s.cfg.Addr, auth, s.cfg.From, s.cfg.To, []byte(b.String()))
+}
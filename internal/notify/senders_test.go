@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+func TestSlackPostsText(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	if err := NewSlack(srv.URL, srv.Client()).Send(context.Background(), Message{Subject: "Run failed", Text: "details"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if got["text"] != "*Run failed*\ndetails" {
+		t.Fatalf("unexpected slack text %q", got["text"])
+	}
+}
+
+func TestSlackReportsNon2xx(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	if err := NewSlack(srv.URL, srv.Client()).Send(context.Background(), Message{}); err == nil {
+		t.Fatal("expected an error for a 403")
+	}
+}
+
+func TestSMTPBuildsMessage(t *testing.T) {
+	s := NewSMTP(SMTPConfig{
+		Addr:     "mail.example.com:587",
+		Username: "bot",
+		Password: "secret",
+		From:     "runtime@example.com",
+		To:       []string{"ops@example.com", "oncall@example.com"},
+	})
+	var (
+		gotAddr string
+		gotAuth smtp.Auth
+		gotTo   []string
+		gotMsg  string
+	)
+	s.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		gotAddr, gotAuth, gotTo, gotMsg = addr, a, to, string(msg)
+		return nil
+	}
+
+	if err := s.Send(context.Background(), Message{Subject: "Approval\nwaiting", Text: "line one\nline two"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if gotAddr != "mail.example.com:587" || gotAuth == nil || len(gotTo) != 2 {
+		t.Fatalf("unexpected envelope addr=%s auth=%v to=%v", gotAddr, gotAuth, gotTo)
+	}
+	for _, want := range []string{
+		"To: ops@example.com, oncall@example.com\r\n",
+		"Subject: Approval waiting\r\n",
+		"\r\n\r\nline one\r\nline two\r\n",
+	} {
+		if !strings.Contains(gotMsg, want) {
+			t.Fatalf("expected %q in message, got %q", want, gotMsg)
+		}
+	}
+}
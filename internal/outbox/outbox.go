@@ -0,0 +1,239 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package outbox writes webhook deliveries into webhook_deliveries, and
+// broker messages into outbox_messages, inside the caller's transaction so
+// they commit atomically with the state change that produced them.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// EnqueueTerminalWebhook queues the terminal status callback for a run with a
+// webhook_url. It is a no-op when webhookURL is blank. It must be called in the
+// transaction that wrote the terminal status.
+func EnqueueTerminalWebhook(
+	ctx context.Context,
+	tx pgx.Tx,
+	runID uuid.UUID,
+	status domain.RunStatus,
+	finishedAt time.Time,
+	webhookURL string,
+	maxAttempts int,
+) error {
+	webhookURL = strings.TrimSpace(webhookURL)
+	if webhookURL == "" {
+		return nil
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = domain.DefaultWebhookMaxAttempts
+	}
+
+	body, err := json.Marshal(domain.TerminalWebhookPayload{
+		RunID:      runID,
+		Status:     status,
+		FinishedAt: finishedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO webhook_deliveries (id, run_id, api_key_id, event_type, url, payload, status, max_attempts)
+		SELECT $1, r.id, r.api_key_id, $3, $4, $5::jsonb, $6, $7
+		FROM runs r
+		WHERE r.id = $2
+	`,
+		uuid.New(),
+		runID,
+		"RUN_"+string(status),
+		webhookURL,
+		body,
+		domain.WebhookPending,
+		maxAttempts,
+	)
+	return err
+}
+
+// EnqueueApprovalWebhook queues the WAITING_APPROVAL callback for the gate
+// stepID of a run with a webhook_url, carrying approvalURL and, for a gate with
+// an approval timeout, when it expires. It is a no-op when the run has no
+// webhook_url. It must be called in the transaction that opened the gate.
+func EnqueueApprovalWebhook(
+	ctx context.Context,
+	tx pgx.Tx,
+	runID, stepID uuid.UUID,
+	waitingSince time.Time,
+	approvalURL string,
+	maxAttempts int,
+) error {
+	if maxAttempts <= 0 {
+		maxAttempts = domain.DefaultWebhookMaxAttempts
+	}
+
+	var (
+		webhookURL     string
+		timeoutSeconds *int
+		timeoutAction  string
+	)
+	payload := domain.ApprovalWebhookPayload{
+		RunID:        runID,
+		StepID:       stepID,
+		Status:       domain.RunWaiting,
+		ApprovalURL:  approvalURL,
+		WaitingSince: waitingSince.UTC(),
+	}
+	err := tx.QueryRow(ctx, `
+		SELECT r.webhook_url, COALESCE(s.approval_name, ''), s.approval_timeout_seconds, COALESCE(s.approval_timeout_action, '')
+		FROM steps s
+		JOIN runs r ON r.id = s.run_id
+		WHERE s.id = $1
+		  AND s.run_id = $2
+		  AND NULLIF(BTRIM(r.webhook_url), '') IS NOT NULL
+	`, stepID, runID).Scan(&webhookURL, &payload.ApprovalName, &timeoutSeconds, &timeoutAction)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if timeoutSeconds != nil {
+		expiresAt := payload.WaitingSince.Add(time.Duration(*timeoutSeconds) * time.Second)
+		payload.ExpiresAt = &expiresAt
+		if payload.OnExpiry, err = domain.ParseApprovalTimeoutAction(timeoutAction); err != nil {
+			return err
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO webhook_deliveries (id, run_id, api_key_id, event_type, url, payload, status, max_attempts)
+		SELECT $1, r.id, r.api_key_id, $3, $4, $5::jsonb, $6, $7
+		FROM runs r
+		WHERE r.id = $2
+	`,
+		uuid.New(),
+		runID,
+		"RUN_"+string(domain.RunWaiting),
+		strings.TrimSpace(webhookURL),
+		body,
+		domain.WebhookPending,
+		maxAttempts,
+	)
+	return err
+}
+
+// EnqueueEventWebhook queues a delivery for eventID when its run has a
+// webhook_url and subscribed to the event's type. It is a no-op otherwise.
+// It must be called in the transaction that inserted the event.
+func EnqueueEventWebhook(ctx context.Context, tx pgx.Tx, eventID uuid.UUID, maxAttempts int) error {
+	if maxAttempts <= 0 {
+		maxAttempts = domain.DefaultWebhookMaxAttempts
+	}
+
+	var (
+		payload    domain.EventWebhookPayload
+		createdAt  time.Time
+		apiKeyID   uuid.UUID
+		webhookURL string
+	)
+	err := tx.QueryRow(ctx, `
+		SELECT e.run_id, e.step_id, e.type, e.payload, e.created_at, r.api_key_id, r.webhook_url
+		FROM events e
+		JOIN runs r ON r.id = e.run_id
+		WHERE e.id = $1
+		  AND r.webhook_url IS NOT NULL
+		  AND e.type = ANY(r.webhook_events)
+	`, eventID).Scan(
+		&payload.RunID,
+		&payload.StepID,
+		&payload.Type,
+		&payload.Payload,
+		&createdAt,
+		&apiKeyID,
+		&webhookURL,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	payload.EventID = eventID
+	payload.CreatedAt = createdAt.UTC()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO webhook_deliveries (id, run_id, api_key_id, event_id, event_type, url, payload, status, max_attempts)
+		VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8, $9)
+	`,
+		uuid.New(),
+		payload.RunID,
+		apiKeyID,
+		eventID,
+		payload.Type,
+		webhookURL,
+		body,
+		domain.WebhookPending,
+		maxAttempts,
+	)
+	return err
+}
+
+// EnqueueEvent queues what an inserted event fans out to: an outbox message
+// for the relay and, when the run subscribed to the event's type, a webhook
+// delivery. It must be called in the transaction that inserted the event.
+func EnqueueEvent(ctx context.Context, tx pgx.Tx, eventID uuid.UUID, webhookMaxAttempts int) error {
+	if err := EnqueueEventMessage(ctx, tx, eventID); err != nil {
+		return err
+	}
+	return EnqueueEventWebhook(ctx, tx, eventID, webhookMaxAttempts)
+}
+
+// EnqueueEventMessage queues an outbox message mirroring eventID. It must be
+// called in the transaction that inserted the event.
+func EnqueueEventMessage(ctx context.Context, tx pgx.Tx, eventID uuid.UUID) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO outbox_messages (id, type, api_key_id, run_id, step_id, event_id, payload, created_at)
+		SELECT $1, e.type, r.api_key_id, e.run_id, e.step_id, e.id, COALESCE(e.payload, '{}'::jsonb), e.created_at
+		FROM events e
+		JOIN runs r ON r.id = e.run_id
+		WHERE e.id = $2
+	`, uuid.New(), eventID)
+	return err
+}
+
+// EnqueueRunCreated queues the RUN_CREATED outbox message for a run. It must
+// be called in the transaction that inserted the run.
+func EnqueueRunCreated(ctx context.Context, tx pgx.Tx, runID uuid.UUID, msg domain.RunCreatedMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO outbox_messages (id, type, api_key_id, run_id, payload, created_at)
+		SELECT $1, $2, r.api_key_id, r.id, $4::jsonb, r.created_at
+		FROM runs r
+		WHERE r.id = $3
+	`, uuid.New(), domain.OutboxRunCreated, runID, body)
+	return err
+}
@@ -10,17 +10,11 @@ import (
 )
 
 func NewPool(ctx context.Context, databaseURL string) (*pgxpool.Pool, error) {
-	cfg, err := pgxpool.ParseConfig(databaseURL)
+	cfg, err := poolConfig(databaseURL)
 	if err != nil {
 		return nil, err
 	}
 
-	// keep conservative defaults for now
-	cfg.MaxConns = 5
-	cfg.MinConns = 1
-	cfg.MaxConnIdleTime = 5 * time.Minute
-	cfg.MaxConnLifetime = 30 * time.Minute
-
 	pool, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
 		return nil, err
@@ -32,8 +26,36 @@ func NewPool(ctx context.Context, databaseURL string) (*pgxpool.Pool, error) {
 	defer cancel()
 
 	if err := pool.Ping(ctxPing); err != nil {
+		pool.Close()
 		return nil, err
 	}
 
 	return pool, nil
 }
+
+// NewReadPool opens a pool for a read replica. Unlike NewPool it does not
+// require the replica to be reachable: connections are made on demand, so a
+// replica that is down at startup is used once it comes up. Only an invalid
+// URL is an error.
+func NewReadPool(ctx context.Context, databaseURL string) (*pgxpool.Pool, error) {
+	cfg, err := poolConfig(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MinConns = 0
+	return pgxpool.NewWithConfig(ctx, cfg)
+}
+
+func poolConfig(databaseURL string) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep conservative defaults for now
+	cfg.MaxConns = 5
+	cfg.MinConns = 1
+	cfg.MaxConnIdleTime = 5 * time.Minute
+	cfg.MaxConnLifetime = 30 * time.Minute
+	return cfg, nil
+}
@@ -17,6 +17,45 @@ import (
 
 const schemaMigrationLockID int64 = 0x4152545f4d494752 // "ART_MIGR"
 
+const schemaPollInterval = 500 * time.Millisecond
+
+// SchemaMode selects how a process prepares the schema at startup.
+type SchemaMode string
+
+const (
+	// SchemaModeMigrate applies pending embedded migrations under the
+	// advisory lock.
+	SchemaModeMigrate SchemaMode = "migrate"
+	// SchemaModeWait never migrates; it waits until another process has
+	// applied every embedded migration.
+	SchemaModeWait SchemaMode = "wait"
+)
+
+var (
+	ErrSchemaLockTimeout = errors.New("timed out waiting for schema migration lock")
+	ErrSchemaWaitTimeout = errors.New("timed out waiting for schema to be migrated")
+)
+
+type SchemaOptions struct {
+	Mode SchemaMode
+	// LockTimeout bounds how long to wait for another process's migration:
+	// the advisory lock in migrate mode, schema readiness in wait mode.
+	// Zero waits indefinitely.
+	LockTimeout time.Duration
+}
+
+// ParseSchemaMode accepts "migrate" (or empty) and "wait".
+func ParseSchemaMode(v string) (SchemaMode, error) {
+	switch SchemaMode(strings.ToLower(strings.TrimSpace(v))) {
+	case "", SchemaModeMigrate:
+		return SchemaModeMigrate, nil
+	case SchemaModeWait:
+		return SchemaModeWait, nil
+	default:
+		return "", fmt.Errorf("unknown schema mode %q (want %q or %q)", v, SchemaModeMigrate, SchemaModeWait)
+	}
+}
+
 var requiredTables = []string{
 	"api_keys",
 	"runs",
@@ -25,17 +64,20 @@ var requiredTables = []string{
 	"run_requests",
 	"workflow_templates",
 	"workflow_template_steps",
-}
-
-type requiredColumn struct {
-	Table  string
-	Column string
-}
-
-var requiredColumns = []requiredColumn{
-	{Table: "api_keys", Column: "name"},
-	{Table: "api_keys", Column: "token_hash"},
-	{Table: "runs", Column: "priority"},
+	"webhook_deliveries",
+	"run_daily_stats",
+	"tenant_purge_reports",
+	"webhook_attempts",
+	"webhook_signing_keys",
+	"workers",
+	"audit_log",
+	"run_archive",
+	"step_logs",
+	"outbox_messages",
+	"tenant_claim_counters",
+	"export_jobs",
+	"secrets",
+	"usage_records",
 }
 
 type SchemaHealthChecker struct {
@@ -50,7 +92,25 @@ func (h *SchemaHealthChecker) Check(ctx context.Context) error {
 	return SchemaReady(ctx, h.pool)
 }
 
+// PrepareSchema migrates or waits for the schema according to opts.Mode.
+func PrepareSchema(ctx context.Context, pool *pgxpool.Pool, logger *slog.Logger, opts SchemaOptions) error {
+	switch opts.Mode {
+	case "", SchemaModeMigrate:
+		return ensureSchema(ctx, pool, logger, opts.LockTimeout)
+	case SchemaModeWait:
+		return WaitForSchema(ctx, pool, logger, opts.LockTimeout)
+	default:
+		return fmt.Errorf("unknown schema mode %q", opts.Mode)
+	}
+}
+
+// EnsureSchema applies pending migrations, waiting as long as needed for the
+// migration lock.
 func EnsureSchema(ctx context.Context, pool *pgxpool.Pool, logger *slog.Logger) error {
+	return ensureSchema(ctx, pool, logger, 0)
+}
+
+func ensureSchema(ctx context.Context, pool *pgxpool.Pool, logger *slog.Logger, lockTimeout time.Duration) error {
 	if pool == nil {
 		return errors.New("nil database pool")
 	}
@@ -61,14 +121,64 @@ func EnsureSchema(ctx context.Context, pool *pgxpool.Pool, logger *slog.Logger)
 	started := time.Now()
 	logger.Info("schema bootstrap starting")
 
+	migrations, err := embeddedmigrations.Ordered()
+	if err != nil {
+		return fmt.Errorf("load embedded migrations: %w", err)
+	}
+	if len(migrations) == 0 {
+		return errors.New("no embedded migrations found")
+	}
+
+	applied := 0
+	skipped := 0
+	err = withMigrationLock(ctx, pool, logger, lockTimeout, func(conn *pgxpool.Conn) error {
+		recorded, err := loadAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := verifyAppliedMigrations(ctx, conn, logger, migrations, recorded); err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			if _, ok := recorded[migration.Name]; ok {
+				skipped++
+				continue
+			}
+
+			logger.Info("applying migration", "file", migration.Name)
+			if err := applyMigration(ctx, conn, migration); err != nil {
+				return fmt.Errorf("apply migration %s: %w", migration.Name, err)
+			}
+			logger.Info("migration applied", "file", migration.Name)
+			applied++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("schema bootstrap complete",
+		"applied", applied,
+		"skipped", skipped,
+		"duration_ms", time.Since(started).Milliseconds(),
+	)
+
+	return SchemaReady(ctx, pool)
+}
+
+// withMigrationLock runs fn on a connection holding the schema advisory lock,
+// after making sure schema_migrations exists.
+func withMigrationLock(ctx context.Context, pool *pgxpool.Pool, logger *slog.Logger, lockTimeout time.Duration, fn func(conn *pgxpool.Conn) error) error {
 	conn, err := pool.Acquire(ctx)
 	if err != nil {
 		return fmt.Errorf("acquire db connection for schema bootstrap: %w", err)
 	}
 	defer conn.Release()
 
-	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, schemaMigrationLockID); err != nil {
-		return fmt.Errorf("acquire schema bootstrap lock: %w", err)
+	if err := acquireMigrationLock(ctx, conn, logger, lockTimeout); err != nil {
+		return err
 	}
 	defer func() {
 		unlockCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -78,59 +188,189 @@ func EnsureSchema(ctx context.Context, pool *pgxpool.Pool, logger *slog.Logger)
 		}
 	}()
 
+	// checksum and dirty were added after the table; older rows have no
+	// checksum until verifyAppliedMigrations records one.
 	if _, err := conn.Exec(ctx, `
 		CREATE TABLE IF NOT EXISTS schema_migrations (
 			filename TEXT PRIMARY KEY,
 			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-		)
+		);
+		ALTER TABLE schema_migrations
+			ADD COLUMN IF NOT EXISTS checksum TEXT,
+			ADD COLUMN IF NOT EXISTS dirty BOOLEAN NOT NULL DEFAULT FALSE;
 	`); err != nil {
 		return fmt.Errorf("create schema_migrations table: %w", err)
 	}
 
+	return fn(conn)
+}
+
+// acquireMigrationLock takes the schema advisory lock. With a positive timeout
+// it polls pg_try_advisory_lock so a replica stuck behind a long migration
+// fails with ErrSchemaLockTimeout instead of blocking forever.
+func acquireMigrationLock(ctx context.Context, conn *pgxpool.Conn, logger *slog.Logger, timeout time.Duration) error {
+	if timeout <= 0 {
+		if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, schemaMigrationLockID); err != nil {
+			return fmt.Errorf("acquire schema bootstrap lock: %w", err)
+		}
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	logged := false
+	for {
+		var acquired bool
+		if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, schemaMigrationLockID).Scan(&acquired); err != nil {
+			return fmt.Errorf("acquire schema bootstrap lock: %w", err)
+		}
+		if acquired {
+			return nil
+		}
+		if !logged {
+			logger.Info("waiting for schema migration lock held by another process", "timeout", timeout.String())
+			logged = true
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%w after %s", ErrSchemaLockTimeout, timeout)
+		}
+		if err := sleepContext(ctx, min(schemaPollInterval, time.Until(deadline))); err != nil {
+			return err
+		}
+	}
+}
+
+// WaitForSchema blocks until every embedded migration is recorded in
+// schema_migrations and SchemaReady passes. It never applies migrations
+// itself. A positive timeout fails with ErrSchemaWaitTimeout.
+func WaitForSchema(ctx context.Context, pool *pgxpool.Pool, logger *slog.Logger, timeout time.Duration) error {
+	if pool == nil {
+		return errors.New("nil database pool")
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+
 	migrations, err := embeddedmigrations.Ordered()
 	if err != nil {
 		return fmt.Errorf("load embedded migrations: %w", err)
 	}
-	if len(migrations) == 0 {
-		return errors.New("no embedded migrations found")
+
+	started := time.Now()
+	logged := false
+	for {
+		pending, err := pendingMigrations(ctx, pool, migrations)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			if err := SchemaReady(ctx, pool); err == nil {
+				logger.Info("schema ready", "waited_ms", time.Since(started).Milliseconds())
+				return nil
+			}
+		}
+		if !logged {
+			logger.Info("waiting for schema migration by another process",
+				"pending", len(pending),
+				"timeout", timeout.String(),
+			)
+			logged = true
+		}
+		if timeout > 0 && time.Since(started) >= timeout {
+			return fmt.Errorf("%w after %s (%d migrations pending)", ErrSchemaWaitTimeout, timeout, len(pending))
+		}
+		wait := schemaPollInterval
+		if timeout > 0 {
+			wait = min(wait, timeout-time.Since(started))
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
 	}
+}
 
-	applied := 0
-	skipped := 0
+func pendingMigrations(ctx context.Context, pool *pgxpool.Pool, migrations []embeddedmigrations.File) ([]string, error) {
+	var tracked *string
+	if err := pool.QueryRow(ctx, `SELECT to_regclass('public.schema_migrations')::text`).Scan(&tracked); err != nil {
+		return nil, fmt.Errorf("check schema_migrations table: %w", err)
+	}
 
-	for _, migration := range migrations {
-		var alreadyApplied bool
-		if err := conn.QueryRow(
-			ctx,
-			`SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE filename = $1)`,
-			migration.Name,
-		).Scan(&alreadyApplied); err != nil {
-			return fmt.Errorf("check migration %s: %w", migration.Name, err)
-		}
+	names := make([]string, 0, len(migrations))
+	for _, m := range migrations {
+		names = append(names, m.Name)
+	}
+	if tracked == nil {
+		return names, nil
+	}
 
-		if alreadyApplied {
-			skipped++
-			continue
-		}
+	rows, err := pool.Query(ctx, `SELECT filename FROM schema_migrations WHERE filename = ANY($1)`, names)
+	if err != nil {
+		return nil, fmt.Errorf("list applied migrations: %w", err)
+	}
+	defer rows.Close()
 
-		logger.Info("applying migration", "file", migration.Name)
-		if err := applyMigration(ctx, conn, migration); err != nil {
-			return fmt.Errorf("apply migration %s: %w", migration.Name, err)
+	applied := make(map[string]struct{}, len(names))
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
 		}
-		logger.Info("migration applied", "file", migration.Name)
-		applied++
+		applied[name] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	logger.Info("schema bootstrap complete",
-		"applied", applied,
-		"skipped", skipped,
-		"duration_ms", time.Since(started).Milliseconds(),
-	)
+	pending := make([]string, 0)
+	for _, name := range names {
+		if _, ok := applied[name]; !ok {
+			pending = append(pending, name)
+		}
+	}
+	return pending, nil
+}
 
-	return SchemaReady(ctx, pool)
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
 }
 
+// applyMigration runs migration in a transaction. Its row is recorded as
+// dirty first and cleared in that transaction, so a process that dies midway
+// leaves the row dirty for an operator to inspect.
 func applyMigration(ctx context.Context, conn *pgxpool.Conn, migration embeddedmigrations.File) error {
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO schema_migrations (filename, checksum, dirty)
+		VALUES ($1, $2, TRUE)
+	`, migration.Name, migration.Checksum()); err != nil {
+		return err
+	}
+
+	err := runInTx(ctx, conn, migration.SQL, `
+		UPDATE schema_migrations
+		SET dirty = FALSE, applied_at = NOW()
+		WHERE filename = $1
+	`, migration.Name)
+	if err != nil {
+		// The transaction rolled back, so the migration left no trace.
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		_, _ = conn.Exec(cleanupCtx, `DELETE FROM schema_migrations WHERE filename = $1`, migration.Name)
+	}
+	return err
+}
+
+// runInTx executes script and then the bookkeeping statement record with
+// args in one transaction.
+func runInTx(ctx context.Context, conn *pgxpool.Conn, script, record string, args ...any) error {
 	tx, err := conn.Begin(ctx)
 	if err != nil {
 		return err
@@ -139,59 +379,26 @@ func applyMigration(ctx context.Context, conn *pgxpool.Conn, migration embeddedm
 		_ = tx.Rollback(ctx)
 	}()
 
-	if _, err := tx.Exec(ctx, migration.SQL, pgx.QueryExecModeSimpleProtocol); err != nil {
+	if _, err := tx.Exec(ctx, script, pgx.QueryExecModeSimpleProtocol); err != nil {
 		return err
 	}
-	if _, err := tx.Exec(ctx, `
-		INSERT INTO schema_migrations (filename)
-		VALUES ($1)
-	`, migration.Name); err != nil {
+	if _, err := tx.Exec(ctx, record, args...); err != nil {
 		return err
 	}
 
 	return tx.Commit(ctx)
 }
 
+// SchemaReady checks that the database has the tables, columns, column
+// types, NOT NULL constraints, and indexes the code expects. Drift is
+// reported as a *SchemaDriftError.
 func SchemaReady(ctx context.Context, pool *pgxpool.Pool) error {
-	if pool == nil {
-		return errors.New("nil database pool")
-	}
-
-	missingTables := make([]string, 0, len(requiredTables))
-	for _, table := range requiredTables {
-		var relationName *string
-		if err := pool.QueryRow(ctx, `SELECT to_regclass($1)`, "public."+table).Scan(&relationName); err != nil {
-			return fmt.Errorf("check table %s: %w", table, err)
-		}
-		if relationName == nil || strings.TrimSpace(*relationName) == "" {
-			missingTables = append(missingTables, table)
-		}
-	}
-	if len(missingTables) > 0 {
-		return fmt.Errorf("required tables missing: %s", strings.Join(missingTables, ", "))
-	}
-
-	missingColumns := make([]string, 0, len(requiredColumns))
-	for _, column := range requiredColumns {
-		var exists bool
-		if err := pool.QueryRow(ctx, `
-			SELECT EXISTS (
-				SELECT 1
-				FROM information_schema.columns
-				WHERE table_schema = 'public'
-				  AND table_name = $1
-				  AND column_name = $2
-			)
-		`, column.Table, column.Column).Scan(&exists); err != nil {
-			return fmt.Errorf("check column %s.%s: %w", column.Table, column.Column, err)
-		}
-		if !exists {
-			missingColumns = append(missingColumns, column.Table+"."+column.Column)
-		}
+	drift, err := CheckSchemaDrift(ctx, pool)
+	if err != nil {
+		return err
 	}
-	if len(missingColumns) > 0 {
-		return fmt.Errorf("required columns missing: %s", strings.Join(missingColumns, ", "))
+	if !drift.Empty() {
+		return &SchemaDriftError{Drift: drift}
 	}
-
 	return nil
 }
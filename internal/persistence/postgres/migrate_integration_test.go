@@ -6,6 +6,7 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log/slog"
 	"os"
@@ -20,9 +21,10 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func TestEnsureSchemaBootstrapsEmptyDatabase(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
-	defer cancel()
+// newTempDatabase creates an empty database for the test and drops it
+// afterwards.
+func newTempDatabase(ctx context.Context, t *testing.T) *pgxpool.Pool {
+	t.Helper()
 
 	baseURL := strings.TrimSpace(os.Getenv("DATABASE_URL"))
 	if baseURL == "" {
@@ -33,7 +35,7 @@ func TestEnsureSchemaBootstrapsEmptyDatabase(t *testing.T) {
 	if err != nil {
 		t.Skipf("skip integration test: cannot create admin pool (%v)", err)
 	}
-	defer adminPool.Close()
+	t.Cleanup(adminPool.Close)
 
 	if err := adminPool.Ping(ctx); err != nil {
 		t.Skipf("skip integration test: cannot reach database (%v)", err)
@@ -44,7 +46,7 @@ func TestEnsureSchemaBootstrapsEmptyDatabase(t *testing.T) {
 		t.Skipf("skip integration test: cannot create database (%v)", err)
 	}
 
-	defer func() {
+	t.Cleanup(func() {
 		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cleanupCancel()
 
@@ -57,7 +59,7 @@ func TestEnsureSchemaBootstrapsEmptyDatabase(t *testing.T) {
 		if _, err := adminPool.Exec(cleanupCtx, "DROP DATABASE "+pgx.Identifier{testDBName}.Sanitize()); err != nil {
 			t.Logf("cleanup warning: drop temp database failed (%v)", err)
 		}
-	}()
+	})
 
 	poolCfg, err := pgxpool.ParseConfig(baseURL)
 	if err != nil {
@@ -69,12 +71,20 @@ func TestEnsureSchemaBootstrapsEmptyDatabase(t *testing.T) {
 	if err != nil {
 		t.Fatalf("create temp database pool: %v", err)
 	}
-	defer pool.Close()
+	// Registered after the drop, so it runs first.
+	t.Cleanup(pool.Close)
 
 	if err := pool.Ping(ctx); err != nil {
 		t.Fatalf("ping temp database: %v", err)
 	}
+	return pool
+}
+
+func TestEnsureSchemaBootstrapsEmptyDatabase(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
 
+	pool := newTempDatabase(ctx, t)
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 
 	if err := EnsureSchema(ctx, pool, logger); err != nil {
@@ -99,3 +109,156 @@ func TestEnsureSchemaBootstrapsEmptyDatabase(t *testing.T) {
 		t.Fatal("expected created api key token")
 	}
 }
+
+func TestPrepareSchemaLockTimeoutAndWaitMode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	databaseURL := strings.TrimSpace(os.Getenv("DATABASE_URL"))
+	if databaseURL == "" {
+		t.Skip("set DATABASE_URL to run integration tests")
+	}
+
+	pool, err := pgxpool.New(ctx, databaseURL)
+	if err != nil {
+		t.Skipf("skip integration test: cannot create pool (%v)", err)
+	}
+	defer pool.Close()
+
+	if err := pool.Ping(ctx); err != nil {
+		t.Skipf("skip integration test: cannot reach database (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := EnsureSchema(ctx, pool, logger); err != nil {
+		t.Fatalf("ensure schema: %v", err)
+	}
+
+	// Wait mode returns immediately once every migration is applied.
+	if err := PrepareSchema(ctx, pool, logger, SchemaOptions{Mode: SchemaModeWait, LockTimeout: time.Second}); err != nil {
+		t.Fatalf("wait for migrated schema: %v", err)
+	}
+
+	// Simulate another replica holding the migration lock.
+	holder, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire holder connection: %v", err)
+	}
+	defer holder.Release()
+	if _, err := holder.Exec(ctx, `SELECT pg_advisory_lock($1)`, schemaMigrationLockID); err != nil {
+		t.Fatalf("hold migration lock: %v", err)
+	}
+	defer func() {
+		_, _ = holder.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, schemaMigrationLockID)
+	}()
+
+	started := time.Now()
+	err = PrepareSchema(ctx, pool, logger, SchemaOptions{Mode: SchemaModeMigrate, LockTimeout: 300 * time.Millisecond})
+	if !errors.Is(err, ErrSchemaLockTimeout) {
+		t.Fatalf("expected ErrSchemaLockTimeout, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("expected lock wait to honor timeout, took %s", elapsed)
+	}
+}
+
+func TestMigrateDownUpAndDirtyState(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	pool := newTempDatabase(ctx, t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if err := EnsureSchema(ctx, pool, logger); err != nil {
+		t.Fatalf("ensure schema: %v", err)
+	}
+	latest, err := EmbeddedSchemaVersion()
+	if err != nil {
+		t.Fatalf("embedded schema version: %v", err)
+	}
+
+	// Roll back one step, then all the way, then apply everything again.
+	if err := MigrateDown(ctx, pool, logger, 1); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+	if v, err := SchemaVersion(ctx, pool); err != nil || v != latest-1 {
+		t.Fatalf("expected schema version %d after down, got %d (err=%v)", latest-1, v, err)
+	}
+	if err := MigrateTo(ctx, pool, logger, 0); err != nil {
+		t.Fatalf("migrate to 0: %v", err)
+	}
+	states, err := MigrationStates(ctx, pool)
+	if err != nil {
+		t.Fatalf("migration states: %v", err)
+	}
+	for _, s := range states {
+		if s.Status != MigrationPending {
+			t.Fatalf("expected %s pending after rolling back everything, got %s", s.Name, s.Status)
+		}
+	}
+	if err := SchemaReady(ctx, pool); err == nil {
+		t.Fatal("expected schema not ready after rolling back everything")
+	}
+	if err := EnsureSchema(ctx, pool, logger); err != nil {
+		t.Fatalf("ensure schema after full rollback: %v", err)
+	}
+
+	// An interrupted migration blocks startup until it is resolved.
+	if _, err := pool.Exec(ctx, `UPDATE schema_migrations SET dirty = TRUE WHERE filename = '041_step_logs.sql'`); err != nil {
+		t.Fatalf("mark dirty: %v", err)
+	}
+	if err := EnsureSchema(ctx, pool, logger); !errors.Is(err, ErrSchemaDirty) {
+		t.Fatalf("expected ErrSchemaDirty, got %v", err)
+	}
+	if resolved, err := ForceMigration(ctx, pool, logger, 41); err != nil || resolved != 1 {
+		t.Fatalf("expected one dirty migration resolved, got %d (err=%v)", resolved, err)
+	}
+
+	// An applied file that no longer matches the binary is refused.
+	if _, err := pool.Exec(ctx, `UPDATE schema_migrations SET checksum = 'edited' WHERE filename = '001_init.sql'`); err != nil {
+		t.Fatalf("change checksum: %v", err)
+	}
+	if err := EnsureSchema(ctx, pool, logger); !errors.Is(err, ErrMigrationModified) {
+		t.Fatalf("expected ErrMigrationModified, got %v", err)
+	}
+}
+
+func TestSchemaReadyReportsDrift(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	pool := newTempDatabase(ctx, t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if err := EnsureSchema(ctx, pool, logger); err != nil {
+		t.Fatalf("ensure schema: %v", err)
+	}
+	if drift, err := CheckSchemaDrift(ctx, pool); err != nil || !drift.Empty() {
+		t.Fatalf("expected no drift after migrating, got %+v (err=%v)", drift, err)
+	}
+
+	for _, stmt := range []string{
+		`DROP INDEX idx_steps_running_lease`,
+		`ALTER TABLE runs ALTER COLUMN priority DROP NOT NULL`,
+		`ALTER TABLE steps ALTER COLUMN cost_usd TYPE NUMERIC(12,4)`,
+	} {
+		if _, err := pool.Exec(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	var driftErr *SchemaDriftError
+	if err := SchemaReady(ctx, pool); !errors.As(err, &driftErr) {
+		t.Fatalf("expected SchemaDriftError, got %v", err)
+	}
+	drift := driftErr.Drift
+	if len(drift.MissingIndexes) != 1 || drift.MissingIndexes[0] != "idx_steps_running_lease" {
+		t.Fatalf("unexpected missing indexes %v", drift.MissingIndexes)
+	}
+	if len(drift.Nullability) != 1 || drift.Nullability[0] != (ColumnDrift{Column: "runs.priority", Expected: "NOT NULL", Actual: "NULL"}) {
+		t.Fatalf("unexpected nullability drift %+v", drift.Nullability)
+	}
+	if len(drift.ColumnTypes) != 1 || drift.ColumnTypes[0] != (ColumnDrift{Column: "steps.cost_usd", Expected: "numeric(10,6)", Actual: "numeric(12,4)"}) {
+		t.Fatalf("unexpected column type drift %+v", drift.ColumnTypes)
+	}
+}
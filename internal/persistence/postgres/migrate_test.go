@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package postgres
+
+import (
+	"strings"
+	"testing"
+
+	embeddedmigrations "github.com/adiadia/agent-runtime/migrations"
+)
+
+func TestParseSchemaMode(t *testing.T) {
+	tests := map[string]SchemaMode{
+		"":        SchemaModeMigrate,
+		"migrate": SchemaModeMigrate,
+		" WAIT ":  SchemaModeWait,
+		"wait":    SchemaModeWait,
+	}
+	for in, want := range tests {
+		got, err := ParseSchemaMode(in)
+		if err != nil {
+			t.Fatalf("parse %q: %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("parse %q: expected %s got %s", in, want, got)
+		}
+	}
+
+	if _, err := ParseSchemaMode("leader"); err == nil {
+		t.Fatal("expected unknown mode to be rejected")
+	}
+}
+
+func TestMigrationVersion(t *testing.T) {
+	v, err := MigrationVersion("021_api_key_allowed_cidrs.sql")
+	if err != nil || v != 21 {
+		t.Fatalf("expected version 21, got %d (err=%v)", v, err)
+	}
+	for _, name := range []string{"init.sql", "abc_init.sql", "000_init.sql"} {
+		if _, err := MigrationVersion(name); err == nil {
+			t.Fatalf("expected error for %q", name)
+		}
+	}
+
+	latest, err := EmbeddedSchemaVersion()
+	if err != nil {
+		t.Fatalf("embedded schema version: %v", err)
+	}
+	if latest < 21 {
+		t.Fatalf("expected embedded schema version >= 21, got %d", latest)
+	}
+}
+
+func TestEmbeddedMigrationsHaveDownMigrations(t *testing.T) {
+	migrations, err := embeddedmigrations.Ordered()
+	if err != nil {
+		t.Fatalf("load embedded migrations: %v", err)
+	}
+
+	checksums := make(map[string]string, len(migrations))
+	for _, m := range migrations {
+		if strings.HasSuffix(m.Name, ".down.sql") {
+			t.Fatalf("down migration %s listed as an up migration", m.Name)
+		}
+		if strings.TrimSpace(m.Down) == "" {
+			t.Fatalf("migration %s has no down migration", m.Name)
+		}
+		if other, ok := checksums[m.Checksum()]; ok {
+			t.Fatalf("migrations %s and %s share a checksum", other, m.Name)
+		}
+		checksums[m.Checksum()] = m.Name
+	}
+}
@@ -0,0 +1,364 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	embeddedmigrations "github.com/adiadia/agent-runtime/migrations"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	// ErrSchemaDirty means a migration was interrupted midway; an operator
+	// must check the schema and resolve it with ForceMigration.
+	ErrSchemaDirty = errors.New("schema is dirty: a migration was interrupted")
+	// ErrMigrationModified means an applied migration no longer matches the
+	// embedded file of the same name.
+	ErrMigrationModified = errors.New("applied migration differs from the embedded file")
+	// ErrNoDownMigration means a migration cannot be rolled back.
+	ErrNoDownMigration = errors.New("migration has no down migration")
+)
+
+// MigrationStatus is the state of one migration in the database.
+type MigrationStatus string
+
+const (
+	MigrationApplied  MigrationStatus = "applied"
+	MigrationPending  MigrationStatus = "pending"
+	MigrationDirty    MigrationStatus = "dirty"
+	MigrationModified MigrationStatus = "modified"
+	// MigrationUnknown is applied but not embedded in this binary, e.g. by a
+	// newer release.
+	MigrationUnknown MigrationStatus = "unknown"
+)
+
+// MigrationState describes one embedded or applied migration.
+type MigrationState struct {
+	Version   int
+	Name      string
+	Status    MigrationStatus
+	AppliedAt *time.Time
+}
+
+type appliedMigration struct {
+	AppliedAt time.Time
+	// Checksum is empty for rows recorded before checksums were kept.
+	Checksum string
+	Dirty    bool
+}
+
+type migrationQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// loadAppliedMigrations reads schema_migrations, which may predate the
+// checksum and dirty columns or not exist yet.
+func loadAppliedMigrations(ctx context.Context, q migrationQuerier) (map[string]appliedMigration, error) {
+	applied := make(map[string]appliedMigration)
+
+	var tracked bool
+	rows, err := q.Query(ctx, `SELECT to_regclass('public.schema_migrations') IS NOT NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("check schema_migrations table: %w", err)
+	}
+	for rows.Next() {
+		if err := rows.Scan(&tracked); err != nil {
+			rows.Close()
+			return nil, err
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("check schema_migrations table: %w", err)
+	}
+	if !tracked {
+		return applied, nil
+	}
+
+	rows, err = q.Query(ctx, `
+		SELECT filename,
+		       applied_at,
+		       COALESCE(to_jsonb(m)->>'checksum', ''),
+		       COALESCE((to_jsonb(m)->>'dirty')::boolean, FALSE)
+		FROM schema_migrations m
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			name string
+			m    appliedMigration
+		)
+		if err := rows.Scan(&name, &m.AppliedAt, &m.Checksum, &m.Dirty); err != nil {
+			return nil, err
+		}
+		applied[name] = m
+	}
+	return applied, rows.Err()
+}
+
+// verifyAppliedMigrations fails on dirty or edited migrations and records
+// checksums for rows that have none.
+func verifyAppliedMigrations(ctx context.Context, conn *pgxpool.Conn, logger *slog.Logger, migrations []embeddedmigrations.File, applied map[string]appliedMigration) error {
+	var dirty []string
+	for name, m := range applied {
+		if m.Dirty {
+			dirty = append(dirty, name)
+		}
+	}
+	if len(dirty) > 0 {
+		slices.Sort(dirty)
+		return fmt.Errorf("%w: %s", ErrSchemaDirty, strings.Join(dirty, ", "))
+	}
+
+	var modified []string
+	for _, migration := range migrations {
+		m, ok := applied[migration.Name]
+		switch {
+		case !ok:
+		case m.Checksum == "":
+			if _, err := conn.Exec(ctx, `
+				UPDATE schema_migrations SET checksum = $2 WHERE filename = $1 AND checksum IS NULL
+			`, migration.Name, migration.Checksum()); err != nil {
+				return fmt.Errorf("record checksum of %s: %w", migration.Name, err)
+			}
+			logger.Debug("recorded migration checksum", "file", migration.Name)
+		case m.Checksum != migration.Checksum():
+			modified = append(modified, migration.Name)
+		}
+	}
+	if len(modified) > 0 {
+		return fmt.Errorf("%w: %s", ErrMigrationModified, strings.Join(modified, ", "))
+	}
+	return nil
+}
+
+// MigrationStates lists every embedded migration and every applied one that
+// is not embedded, by version.
+func MigrationStates(ctx context.Context, pool *pgxpool.Pool) ([]MigrationState, error) {
+	if pool == nil {
+		return nil, errors.New("nil database pool")
+	}
+
+	migrations, err := embeddedmigrations.Ordered()
+	if err != nil {
+		return nil, fmt.Errorf("load embedded migrations: %w", err)
+	}
+	applied, err := loadAppliedMigrations(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	embedded := make(map[string]struct{}, len(migrations))
+	for _, migration := range migrations {
+		embedded[migration.Name] = struct{}{}
+		state := MigrationState{Name: migration.Name, Status: MigrationPending}
+		state.Version, _ = MigrationVersion(migration.Name)
+		if m, ok := applied[migration.Name]; ok {
+			state.AppliedAt = &m.AppliedAt
+			switch {
+			case m.Dirty:
+				state.Status = MigrationDirty
+			case m.Checksum != "" && m.Checksum != migration.Checksum():
+				state.Status = MigrationModified
+			default:
+				state.Status = MigrationApplied
+			}
+		}
+		states = append(states, state)
+	}
+	for name, m := range applied {
+		if _, ok := embedded[name]; ok {
+			continue
+		}
+		state := MigrationState{Name: name, Status: MigrationUnknown, AppliedAt: &m.AppliedAt}
+		if m.Dirty {
+			state.Status = MigrationDirty
+		}
+		state.Version, _ = MigrationVersion(name)
+		states = append(states, state)
+	}
+
+	slices.SortFunc(states, func(a, b MigrationState) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return states, nil
+}
+
+// MigrateTo applies or rolls back embedded migrations until the schema is at
+// version: pending migrations up to it are applied in order, and applied ones
+// above it are rolled back newest first.
+func MigrateTo(ctx context.Context, pool *pgxpool.Pool, logger *slog.Logger, version int) error {
+	return migrate(ctx, pool, logger, func(map[string]appliedMigration) int {
+		return version
+	})
+}
+
+// MigrateDown rolls back the newest steps applied migrations.
+func MigrateDown(ctx context.Context, pool *pgxpool.Pool, logger *slog.Logger, steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("steps must be positive, got %d", steps)
+	}
+	return migrate(ctx, pool, logger, func(applied map[string]appliedMigration) int {
+		versions := appliedVersions(applied)
+		if len(versions) <= steps {
+			return 0
+		}
+		return versions[len(versions)-steps-1]
+	})
+}
+
+func appliedVersions(applied map[string]appliedMigration) []int {
+	versions := make([]int, 0, len(applied))
+	for name := range applied {
+		if v, err := MigrationVersion(name); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	slices.Sort(versions)
+	return versions
+}
+
+// migrate moves the schema to the version chosen by target under the
+// migration lock.
+func migrate(ctx context.Context, pool *pgxpool.Pool, logger *slog.Logger, target func(applied map[string]appliedMigration) int) error {
+	if pool == nil {
+		return errors.New("nil database pool")
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	migrations, err := embeddedmigrations.Ordered()
+	if err != nil {
+		return fmt.Errorf("load embedded migrations: %w", err)
+	}
+
+	return withMigrationLock(ctx, pool, logger, 0, func(conn *pgxpool.Conn) error {
+		applied, err := loadAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := verifyAppliedMigrations(ctx, conn, logger, migrations, applied); err != nil {
+			return err
+		}
+		version := target(applied)
+
+		embedded := make(map[string]struct{}, len(migrations))
+		for _, migration := range migrations {
+			embedded[migration.Name] = struct{}{}
+		}
+		for name := range applied {
+			if v, err := MigrationVersion(name); err == nil && v > version {
+				if _, ok := embedded[name]; !ok {
+					return fmt.Errorf("applied migration %s is not embedded in this binary; roll it back with the release that added it", name)
+				}
+			}
+		}
+
+		for i := len(migrations) - 1; i >= 0; i-- {
+			migration := migrations[i]
+			v, err := MigrationVersion(migration.Name)
+			if err != nil {
+				return err
+			}
+			if _, ok := applied[migration.Name]; !ok || v <= version {
+				continue
+			}
+			logger.Info("rolling back migration", "file", migration.Name)
+			if err := rollbackMigration(ctx, conn, migration); err != nil {
+				return fmt.Errorf("roll back migration %s: %w", migration.Name, err)
+			}
+			logger.Info("migration rolled back", "file", migration.Name)
+		}
+
+		for _, migration := range migrations {
+			v, err := MigrationVersion(migration.Name)
+			if err != nil {
+				return err
+			}
+			if _, ok := applied[migration.Name]; ok || v > version {
+				continue
+			}
+			logger.Info("applying migration", "file", migration.Name)
+			if err := applyMigration(ctx, conn, migration); err != nil {
+				return fmt.Errorf("apply migration %s: %w", migration.Name, err)
+			}
+			logger.Info("migration applied", "file", migration.Name)
+		}
+		return nil
+	})
+}
+
+// rollbackMigration runs the down migration of migration in a transaction,
+// marking its row dirty until the transaction removes it.
+func rollbackMigration(ctx context.Context, conn *pgxpool.Conn, migration embeddedmigrations.File) error {
+	if migration.Down == "" {
+		return ErrNoDownMigration
+	}
+	if _, err := conn.Exec(ctx, `UPDATE schema_migrations SET dirty = TRUE WHERE filename = $1`, migration.Name); err != nil {
+		return err
+	}
+
+	err := runInTx(ctx, conn, migration.Down, `DELETE FROM schema_migrations WHERE filename = $1`, migration.Name)
+	if err != nil {
+		// The transaction rolled back, so the migration is still applied.
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		_, _ = conn.Exec(cleanupCtx, `UPDATE schema_migrations SET dirty = FALSE WHERE filename = $1`, migration.Name)
+	}
+	return err
+}
+
+// ForceMigration resolves dirty migrations once an operator has checked the
+// schema by hand: a dirty migration at or below version is recorded as
+// applied, one above it as not applied. It returns how many it resolved.
+func ForceMigration(ctx context.Context, pool *pgxpool.Pool, logger *slog.Logger, version int) (int, error) {
+	if pool == nil {
+		return 0, errors.New("nil database pool")
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	resolved := 0
+	err := withMigrationLock(ctx, pool, logger, 0, func(conn *pgxpool.Conn) error {
+		applied, err := loadAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for name, m := range applied {
+			if !m.Dirty {
+				continue
+			}
+			v, err := MigrationVersion(name)
+			if err != nil {
+				return err
+			}
+			if v <= version {
+				_, err = conn.Exec(ctx, `UPDATE schema_migrations SET dirty = FALSE WHERE filename = $1`, name)
+			} else {
+				_, err = conn.Exec(ctx, `DELETE FROM schema_migrations WHERE filename = $1`, name)
+			}
+			if err != nil {
+				return fmt.Errorf("resolve dirty migration %s: %w", name, err)
+			}
+			logger.Info("resolved dirty migration", "file", name, "applied", v <= version)
+			resolved++
+		}
+		return nil
+	})
+	return resolved, err
+}
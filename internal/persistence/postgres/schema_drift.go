@@ -0,0 +1,396 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// expectedColumn is a column the code reads or writes, with its type as
+// format_type prints it.
+type expectedColumn struct {
+	Table   string
+	Column  string
+	Type    string
+	NotNull bool
+}
+
+const (
+	uuidType      = "uuid"
+	textType      = "text"
+	textArrayType = "text[]"
+	intType       = "integer"
+	bigintType    = "bigint"
+	boolType      = "boolean"
+	jsonbType     = "jsonb"
+	byteaType     = "bytea"
+	costType      = "numeric(10,6)"
+	timestampType = "timestamp without time zone"
+	timestampTZ   = "timestamp with time zone"
+)
+
+var expectedColumns = []expectedColumn{
+	{"api_keys", "id", uuidType, true},
+	{"api_keys", "name", textType, true},
+	{"api_keys", "token_hash", textType, true},
+	{"api_keys", "max_concurrent_runs", intType, true},
+	{"api_keys", "max_requests_per_min", intType, true},
+	{"api_keys", "scopes", textArrayType, true},
+	{"api_keys", "slug", textType, false},
+	{"api_keys", "expires_at", timestampType, false},
+	{"api_keys", "allowed_cidrs", textArrayType, false},
+	{"api_keys", "egress_allow_hosts", textArrayType, false},
+	{"api_keys", "monthly_budget_usd", "numeric(12,4)", false},
+	{"api_keys", "scheduling_weight", intType, true},
+	{"api_keys", "max_concurrent_runs_per_template", jsonbType, true},
+	{"api_keys", "created_at", timestampType, true},
+	{"api_keys", "revoked_at", timestampType, false},
+	{"api_keys", "last_used_at", timestampType, false},
+	{"api_keys", "request_count", bigintType, true},
+
+	{"runs", "id", uuidType, true},
+	{"runs", "api_key_id", uuidType, true},
+	{"runs", "status", textType, true},
+	{"runs", "current_step", textType, false},
+	{"runs", "priority", intType, true},
+	{"runs", "total_cost_usd", costType, true},
+	{"runs", "webhook_events", textArrayType, true},
+	{"runs", "metadata", jsonbType, true},
+	{"runs", "tags", textArrayType, true},
+	{"runs", "template_name", textType, false},
+	{"runs", "template_version", intType, false},
+	{"runs", "cancel_reason", textType, false},
+	{"runs", "replayed_from_run_id", uuidType, false},
+	{"runs", "failure_notified_at", timestampTZ, false},
+	{"runs", "created_at", timestampType, true},
+	{"runs", "updated_at", timestampType, true},
+
+	{"steps", "id", uuidType, true},
+	{"steps", "run_id", uuidType, true},
+	{"steps", "name", textType, true},
+	{"steps", "status", textType, true},
+	{"steps", "position", intType, true},
+	{"steps", "attempts", intType, true},
+	{"steps", "on_failure", textType, true},
+	{"steps", "escalation_level", intType, true},
+	{"steps", "cost_usd", costType, true},
+	{"steps", "input", jsonbType, false},
+	{"steps", "input_override", jsonbType, false},
+	{"steps", "output", jsonbType, false},
+	{"steps", "cost_detail", jsonbType, false},
+	{"steps", "next_run_at", timestampType, false},
+	{"steps", "parent_step_id", uuidType, false},
+	{"steps", "item", jsonbType, false},
+	{"steps", "claimed_by", uuidType, false},
+	{"steps", "lease_expires_at", timestampType, false},
+	{"steps", "retry_jitter", boolType, false},
+	{"steps", "retry_priority", intType, false},
+	{"steps", "priority_boost", intType, true},
+	{"steps", "command", textArrayType, false},
+	{"steps", "http", jsonbType, false},
+	{"steps", "secrets", textArrayType, false},
+	{"steps", "execution_attempt", intType, true},
+	{"steps", "claim_token", uuidType, false},
+	{"steps", "approval_notified_at", timestampTZ, false},
+	{"steps", "created_at", timestampType, true},
+
+	{"events", "seq", bigintType, true},
+	{"events", "id", uuidType, true},
+	{"events", "run_id", uuidType, true},
+	{"events", "step_id", uuidType, false},
+	{"events", "type", textType, true},
+	{"events", "payload", jsonbType, false},
+	{"events", "created_at", timestampType, true},
+
+	{"run_requests", "api_key_id", uuidType, true},
+	{"run_requests", "idempotency_key", textType, true},
+	{"run_requests", "run_id", uuidType, true},
+	{"run_requests", "request_hash", textType, false},
+
+	{"webhook_deliveries", "id", uuidType, true},
+	{"webhook_deliveries", "run_id", uuidType, true},
+	{"webhook_deliveries", "event_type", textType, true},
+	{"webhook_deliveries", "payload", jsonbType, true},
+	{"webhook_deliveries", "status", textType, true},
+	{"webhook_deliveries", "attempts", intType, true},
+	{"webhook_deliveries", "max_attempts", intType, true},
+	{"webhook_deliveries", "next_attempt_at", timestampType, true},
+	{"webhook_deliveries", "event_id", uuidType, false},
+
+	{"workers", "id", uuidType, true},
+	{"workers", "api_key_id", uuidType, true},
+	{"workers", "min_schema_version", intType, true},
+	{"workers", "features", textArrayType, true},
+	{"workers", "pricing", jsonbType, true},
+	{"workers", "in_flight_steps", intType, true},
+	{"workers", "last_seen_at", timestampType, true},
+
+	{"step_logs", "seq", bigintType, true},
+	{"step_logs", "step_id", uuidType, true},
+	{"step_logs", "attempt", intType, true},
+	{"step_logs", "level", textType, true},
+	{"step_logs", "line", textType, true},
+
+	{"outbox_messages", "seq", bigintType, true},
+	{"outbox_messages", "id", uuidType, true},
+	{"outbox_messages", "type", textType, true},
+	{"outbox_messages", "api_key_id", uuidType, true},
+	{"outbox_messages", "run_id", uuidType, true},
+	{"outbox_messages", "step_id", uuidType, false},
+	{"outbox_messages", "event_id", uuidType, false},
+	{"outbox_messages", "payload", jsonbType, true},
+	{"outbox_messages", "created_at", timestampType, true},
+	{"outbox_messages", "attempts", intType, true},
+	{"outbox_messages", "next_attempt_at", timestampType, true},
+	{"outbox_messages", "last_error", textType, false},
+
+	{"tenant_claim_counters", "api_key_id", uuidType, true},
+	{"tenant_claim_counters", "claims", bigintType, true},
+	{"tenant_claim_counters", "last_claimed_at", timestampType, false},
+
+	{"export_jobs", "id", uuidType, true},
+	{"export_jobs", "api_key_id", uuidType, true},
+	{"export_jobs", "format", textType, true},
+	{"export_jobs", "status", textType, true},
+	{"export_jobs", "runs_exported", intType, true},
+	{"export_jobs", "artifact", byteaType, false},
+	{"export_jobs", "artifact_bytes", bigintType, false},
+	{"export_jobs", "artifact_sha256", textType, false},
+	{"export_jobs", "error", textType, false},
+	{"export_jobs", "created_at", timestampType, true},
+
+	{"workflow_templates", "name", textType, true},
+	{"workflow_templates", "version", intType, true},
+	{"workflow_template_steps", "secrets", textArrayType, false},
+
+	{"secrets", "id", uuidType, true},
+	{"secrets", "name", textType, true},
+	{"secrets", "ciphertext", byteaType, true},
+	{"secrets", "updated_at", timestampType, true},
+
+	{"usage_records", "api_key_id", uuidType, true},
+	{"usage_records", "run_id", uuidType, true},
+	{"usage_records", "step_id", uuidType, true},
+	{"usage_records", "provider", textType, true},
+	{"usage_records", "model", textType, true},
+	{"usage_records", "tool", textType, true},
+	{"usage_records", "prompt_tokens", bigintType, true},
+	{"usage_records", "completion_tokens", bigintType, true},
+	{"usage_records", "latency_ms", bigintType, true},
+	{"usage_records", "created_at", timestampType, true},
+}
+
+// requiredIndexes are the indexes hot queries depend on. Without them the
+// schema works but claims, event streams, and webhook polling degrade to
+// sequential scans.
+var requiredIndexes = []string{
+	"idx_api_keys_slug",
+	"idx_api_keys_token_hash_unique",
+	"idx_events_run_id_seq",
+	"idx_export_jobs_status_created",
+	"idx_outbox_messages_due",
+	"idx_run_requests_api_key_id",
+	"idx_runs_api_key_created",
+	"idx_runs_api_key_id",
+	"idx_runs_api_key_template_status",
+	"idx_runs_replayed_from",
+	"idx_step_logs_step_seq",
+	"idx_steps_claimed_by_running",
+	"idx_steps_parent_step_id",
+	"idx_steps_run_id",
+	"idx_steps_running_lease",
+	"idx_steps_waiting_approval",
+	"idx_usage_records_api_key_created",
+	"idx_webhook_attempts_delivery_id",
+	"idx_webhook_deliveries_due",
+	"idx_workers_last_seen",
+	"idx_workflow_templates_name_version",
+}
+
+// ColumnDrift is a column whose type or nullability differs from what the
+// code expects.
+type ColumnDrift struct {
+	Column   string `json:"column"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// SchemaDrift lists how the database differs from the schema the code
+// expects. Extra tables, columns, and indexes are not drift.
+type SchemaDrift struct {
+	MissingTables  []string      `json:"missing_tables,omitempty"`
+	MissingColumns []string      `json:"missing_columns,omitempty"`
+	ColumnTypes    []ColumnDrift `json:"column_types,omitempty"`
+	Nullability    []ColumnDrift `json:"nullability,omitempty"`
+	MissingIndexes []string      `json:"missing_indexes,omitempty"`
+}
+
+// Empty reports whether no drift was found.
+func (d SchemaDrift) Empty() bool {
+	return len(d.MissingTables) == 0 && len(d.MissingColumns) == 0 &&
+		len(d.ColumnTypes) == 0 && len(d.Nullability) == 0 && len(d.MissingIndexes) == 0
+}
+
+func (d SchemaDrift) String() string {
+	var parts []string
+	if len(d.MissingTables) > 0 {
+		parts = append(parts, "missing tables: "+strings.Join(d.MissingTables, ", "))
+	}
+	if len(d.MissingColumns) > 0 {
+		parts = append(parts, "missing columns: "+strings.Join(d.MissingColumns, ", "))
+	}
+	for _, c := range d.ColumnTypes {
+		parts = append(parts, fmt.Sprintf("%s is %s, want %s", c.Column, c.Actual, c.Expected))
+	}
+	for _, c := range d.Nullability {
+		parts = append(parts, fmt.Sprintf("%s is %s, want %s", c.Column, c.Actual, c.Expected))
+	}
+	if len(d.MissingIndexes) > 0 {
+		parts = append(parts, "missing indexes: "+strings.Join(d.MissingIndexes, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// SchemaDriftError is returned by SchemaReady when the database does not
+// match the expected schema.
+type SchemaDriftError struct {
+	Drift SchemaDrift
+}
+
+func (e *SchemaDriftError) Error() string {
+	return "schema drift: " + e.Drift.String()
+}
+
+// ReadinessDetail returns the drift for readiness endpoints to report.
+func (e *SchemaDriftError) ReadinessDetail() any {
+	return e.Drift
+}
+
+// actualColumn is a column as found in pg_attribute.
+type actualColumn struct {
+	Type    string
+	NotNull bool
+}
+
+// CheckSchemaDrift compares the public schema with requiredTables,
+// expectedColumns, and requiredIndexes.
+func CheckSchemaDrift(ctx context.Context, pool *pgxpool.Pool) (SchemaDrift, error) {
+	if pool == nil {
+		return SchemaDrift{}, errors.New("nil database pool")
+	}
+
+	tables := make(map[string]bool, len(requiredTables))
+	rows, err := pool.Query(ctx, `
+		SELECT c.relname
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = 'public' AND c.relkind IN ('r', 'p') AND c.relname = ANY($1)
+	`, requiredTables)
+	if err != nil {
+		return SchemaDrift{}, fmt.Errorf("check tables: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return SchemaDrift{}, fmt.Errorf("scan table: %w", err)
+		}
+		tables[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return SchemaDrift{}, fmt.Errorf("check tables: %w", err)
+	}
+
+	columns := make(map[string]actualColumn)
+	rows, err = pool.Query(ctx, `
+		SELECT c.relname, a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = 'public' AND c.relname = ANY($1)
+		  AND a.attnum > 0 AND NOT a.attisdropped
+	`, requiredTables)
+	if err != nil {
+		return SchemaDrift{}, fmt.Errorf("check columns: %w", err)
+	}
+	for rows.Next() {
+		var table, column string
+		var col actualColumn
+		if err := rows.Scan(&table, &column, &col.Type, &col.NotNull); err != nil {
+			rows.Close()
+			return SchemaDrift{}, fmt.Errorf("scan column: %w", err)
+		}
+		columns[table+"."+column] = col
+	}
+	if err := rows.Err(); err != nil {
+		return SchemaDrift{}, fmt.Errorf("check columns: %w", err)
+	}
+
+	indexes := make(map[string]bool, len(requiredIndexes))
+	rows, err = pool.Query(ctx, `
+		SELECT indexname FROM pg_indexes
+		WHERE schemaname = 'public' AND indexname = ANY($1)
+	`, requiredIndexes)
+	if err != nil {
+		return SchemaDrift{}, fmt.Errorf("check indexes: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return SchemaDrift{}, fmt.Errorf("scan index: %w", err)
+		}
+		indexes[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return SchemaDrift{}, fmt.Errorf("check indexes: %w", err)
+	}
+
+	return diffSchema(tables, columns, indexes), nil
+}
+
+// diffSchema compares what was found against the expectations. Columns of
+// missing tables are reported only as the missing table.
+func diffSchema(tables map[string]bool, columns map[string]actualColumn, indexes map[string]bool) SchemaDrift {
+	var drift SchemaDrift
+	for _, table := range requiredTables {
+		if !tables[table] {
+			drift.MissingTables = append(drift.MissingTables, table)
+		}
+	}
+	for _, want := range expectedColumns {
+		if !tables[want.Table] {
+			continue
+		}
+		name := want.Table + "." + want.Column
+		got, ok := columns[name]
+		if !ok {
+			drift.MissingColumns = append(drift.MissingColumns, name)
+			continue
+		}
+		if got.Type != want.Type {
+			drift.ColumnTypes = append(drift.ColumnTypes, ColumnDrift{Column: name, Expected: want.Type, Actual: got.Type})
+		}
+		if got.NotNull != want.NotNull {
+			drift.Nullability = append(drift.Nullability, ColumnDrift{Column: name, Expected: nullability(want.NotNull), Actual: nullability(got.NotNull)})
+		}
+	}
+	for _, index := range requiredIndexes {
+		if !indexes[index] {
+			drift.MissingIndexes = append(drift.MissingIndexes, index)
+		}
+	}
+	return drift
+}
+
+func nullability(notNull bool) string {
+	if notNull {
+		return "NOT NULL"
+	}
+	return "NULL"
+}
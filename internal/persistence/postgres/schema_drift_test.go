@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package postgres
+
+import (
+	"strings"
+	"testing"
+)
+
+// expectedSchema returns catalog contents that match every expectation.
+func expectedSchema() (map[string]bool, map[string]actualColumn, map[string]bool) {
+	tables := make(map[string]bool, len(requiredTables))
+	for _, table := range requiredTables {
+		tables[table] = true
+	}
+	columns := make(map[string]actualColumn, len(expectedColumns))
+	for _, c := range expectedColumns {
+		columns[c.Table+"."+c.Column] = actualColumn{Type: c.Type, NotNull: c.NotNull}
+	}
+	indexes := make(map[string]bool, len(requiredIndexes))
+	for _, index := range requiredIndexes {
+		indexes[index] = true
+	}
+	return tables, columns, indexes
+}
+
+func TestDiffSchema(t *testing.T) {
+	tables, columns, indexes := expectedSchema()
+	if drift := diffSchema(tables, columns, indexes); !drift.Empty() {
+		t.Fatalf("expected no drift, got %s", drift)
+	}
+
+	delete(tables, "step_logs")
+	delete(columns, "runs.tags")
+	columns["steps.cost_usd"] = actualColumn{Type: "numeric(12,4)", NotNull: true}
+	columns["runs.priority"] = actualColumn{Type: "integer", NotNull: false}
+	delete(indexes, "idx_steps_running_lease")
+
+	drift := diffSchema(tables, columns, indexes)
+	if strings.Join(drift.MissingTables, ",") != "step_logs" {
+		t.Fatalf("unexpected missing tables %v", drift.MissingTables)
+	}
+	// Columns of a missing table are not reported separately.
+	if strings.Join(drift.MissingColumns, ",") != "runs.tags" {
+		t.Fatalf("unexpected missing columns %v", drift.MissingColumns)
+	}
+	if len(drift.ColumnTypes) != 1 || drift.ColumnTypes[0] != (ColumnDrift{Column: "steps.cost_usd", Expected: "numeric(10,6)", Actual: "numeric(12,4)"}) {
+		t.Fatalf("unexpected column type drift %+v", drift.ColumnTypes)
+	}
+	if len(drift.Nullability) != 1 || drift.Nullability[0] != (ColumnDrift{Column: "runs.priority", Expected: "NOT NULL", Actual: "NULL"}) {
+		t.Fatalf("unexpected nullability drift %+v", drift.Nullability)
+	}
+	if strings.Join(drift.MissingIndexes, ",") != "idx_steps_running_lease" {
+		t.Fatalf("unexpected missing indexes %v", drift.MissingIndexes)
+	}
+
+	err := (&SchemaDriftError{Drift: drift}).Error()
+	for _, want := range []string{"missing tables: step_logs", "steps.cost_usd is numeric(12,4), want numeric(10,6)", "runs.priority is NULL, want NOT NULL"} {
+		if !strings.Contains(err, want) {
+			t.Fatalf("expected %q in %q", want, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	embeddedmigrations "github.com/adiadia/agent-runtime/migrations"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrSchemaTooOld is returned by CheckSchemaVersion when the database has not
+// been migrated as far as the running binary requires.
+var ErrSchemaTooOld = errors.New("database schema older than this binary requires")
+
+// MigrationVersion returns the numeric prefix of a migration file name, so
+// "021_api_key_allowed_cidrs.sql" is version 21.
+func MigrationVersion(name string) (int, error) {
+	prefix, _, ok := strings.Cut(name, "_")
+	if !ok {
+		return 0, fmt.Errorf("migration %q has no version prefix", name)
+	}
+	v, err := strconv.Atoi(prefix)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("migration %q has invalid version prefix", name)
+	}
+	return v, nil
+}
+
+// EmbeddedSchemaVersion returns the highest migration version compiled into
+// this binary, which is the schema version it requires.
+func EmbeddedSchemaVersion() (int, error) {
+	migrations, err := embeddedmigrations.Ordered()
+	if err != nil {
+		return 0, fmt.Errorf("load embedded migrations: %w", err)
+	}
+
+	latest := 0
+	for _, m := range migrations {
+		v, err := MigrationVersion(m.Name)
+		if err != nil {
+			return 0, err
+		}
+		latest = max(latest, v)
+	}
+	return latest, nil
+}
+
+// SchemaVersion returns the highest migration version recorded in
+// schema_migrations, or 0 when nothing has been applied.
+func SchemaVersion(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	if pool == nil {
+		return 0, errors.New("nil database pool")
+	}
+
+	var tracked *string
+	if err := pool.QueryRow(ctx, `SELECT to_regclass('public.schema_migrations')::text`).Scan(&tracked); err != nil {
+		return 0, fmt.Errorf("check schema_migrations table: %w", err)
+	}
+	if tracked == nil {
+		return 0, nil
+	}
+
+	rows, err := pool.Query(ctx, `SELECT filename FROM schema_migrations`)
+	if err != nil {
+		return 0, fmt.Errorf("list applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	latest := 0
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return 0, err
+		}
+		if v, err := MigrationVersion(name); err == nil {
+			latest = max(latest, v)
+		}
+	}
+	return latest, rows.Err()
+}
+
+// CheckSchemaVersion fails with ErrSchemaTooOld when the database schema is
+// older than minVersion.
+func CheckSchemaVersion(ctx context.Context, pool *pgxpool.Pool, minVersion int) error {
+	current, err := SchemaVersion(ctx, pool)
+	if err != nil {
+		return err
+	}
+	if current < minVersion {
+		return fmt.Errorf("%w: schema version %d, need %d", ErrSchemaTooOld, current, minVersion)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package reconcile repairs status drift: it periodically recomputes the
+// status of stale RUNNING runs whose steps have all stopped.
+package reconcile
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const defaultBatchSize = 500
+
+// RunReconciler recomputes the status of runs untouched for staleAfter.
+type RunReconciler interface {
+	ReconcileStaleRuns(ctx context.Context, staleAfter time.Duration, batchSize int) (int64, error)
+}
+
+type Deps struct {
+	Runs       RunReconciler
+	Logger     *slog.Logger
+	Interval   time.Duration
+	StaleAfter time.Duration
+}
+
+// Reconciler runs the stale run reconciliation sweep.
+type Reconciler struct {
+	runs       RunReconciler
+	logger     *slog.Logger
+	interval   time.Duration
+	staleAfter time.Duration
+}
+
+func New(deps Deps) *Reconciler {
+	l := deps.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+
+	interval := deps.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	return &Reconciler{
+		runs:       deps.Runs,
+		logger:     l,
+		interval:   interval,
+		staleAfter: deps.StaleAfter,
+	}
+}
+
+// Run executes RunOnce immediately and then on every interval until ctx is
+// done. It returns at once when StaleAfter is not positive.
+func (r *Reconciler) Run(ctx context.Context) {
+	if r.staleAfter <= 0 {
+		r.logger.Info("run reconciliation disabled")
+		return
+	}
+
+	r.logger.Info("run reconciliation started",
+		"interval", r.interval,
+		"stale_after", r.staleAfter,
+	)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Error("run reconciliation pass failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			r.logger.Info("run reconciliation stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce performs a single reconciliation sweep.
+func (r *Reconciler) RunOnce(ctx context.Context) error {
+	if r.runs == nil || r.staleAfter <= 0 {
+		return nil
+	}
+
+	_, err := r.runs.ReconcileStaleRuns(ctx, r.staleAfter, defaultBatchSize)
+	return err
+}
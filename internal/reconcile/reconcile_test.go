@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+type fakeRunReconciler struct {
+	calls      int
+	staleAfter time.Duration
+	batchSize  int
+	err        error
+}
+
+func (f *fakeRunReconciler) ReconcileStaleRuns(ctx context.Context, staleAfter time.Duration, batchSize int) (int64, error) {
+	f.calls++
+	f.staleAfter = staleAfter
+	f.batchSize = batchSize
+	return 0, f.err
+}
+
+func TestNewDefaults(t *testing.T) {
+	r := New(Deps{})
+
+	if r.logger == nil {
+		t.Fatal("expected default logger to be set")
+	}
+	if r.interval != time.Minute {
+		t.Fatalf("expected default interval=1m, got %s", r.interval)
+	}
+}
+
+func TestRunOnceForwardsStaleAfter(t *testing.T) {
+	runs := &fakeRunReconciler{}
+	r := New(Deps{
+		Runs:       runs,
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		StaleAfter: 10 * time.Minute,
+	})
+
+	if err := r.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if runs.calls != 1 || runs.staleAfter != 10*time.Minute {
+		t.Fatalf("expected one call with stale_after=10m, got %d calls with %s", runs.calls, runs.staleAfter)
+	}
+	if runs.batchSize != defaultBatchSize {
+		t.Fatalf("expected batch size %d got %d", defaultBatchSize, runs.batchSize)
+	}
+}
+
+func TestRunOnceSkipsWhenDisabled(t *testing.T) {
+	runs := &fakeRunReconciler{}
+	r := New(Deps{Runs: runs})
+
+	if err := r.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if runs.calls != 0 {
+		t.Fatalf("expected no reconciliation call when disabled, got %d", runs.calls)
+	}
+}
+
+func TestRunOnceReturnsReconcileError(t *testing.T) {
+	wantErr := errors.New("db down")
+	r := New(Deps{
+		Runs:       &fakeRunReconciler{err: wantErr},
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		StaleAfter: time.Minute,
+	})
+
+	if err := r.RunOnce(context.Background()); !errors.Is(err, wantErr) {
+		t.Fatalf("expected %v got %v", wantErr, err)
+	}
+}
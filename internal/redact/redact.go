@@ -0,0 +1,299 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package redact removes personal data from what the runtime persists and
+// streams: event payloads, step output and errors, and step log lines. The
+// rules are set per deployment in REDACTION_RULES, a JSON array in which
+// each rule has a regular expression, a JSONPath, or both:
+//
+//	[{"name": "email", "pattern": "[\\w.+-]+@[\\w-]+\\.[\\w.]+"},
+//	 {"path": "$.prompt"},
+//	 {"path": "$..messages[*].content", "pattern": "\\d{3}-\\d{2}-\\d{4}", "replacement": "[SSN]"}]
+//
+// A pattern alone replaces its matches in every string; a path alone
+// replaces the values it selects whole; both replace the pattern's matches in
+// the strings under the path. Log lines are plain text, so only pattern-only
+// rules apply to them.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultReplacement stands in for redacted values when a rule sets none.
+const DefaultReplacement = "[REDACTED]"
+
+// Rule is one REDACTION_RULES entry.
+type Rule struct {
+	// Name identifies the rule in configuration errors.
+	Name        string `json:"name,omitempty"`
+	Pattern     string `json:"pattern,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+type rule struct {
+	pattern     *regexp.Regexp
+	path        []segment
+	replacement string
+}
+
+// Redactor applies a deployment's rules. A nil *Redactor leaves everything
+// unchanged, and it is safe for concurrent use.
+type Redactor struct {
+	rules []rule
+}
+
+// ParseRules decodes REDACTION_RULES. An empty value returns a nil Redactor.
+func ParseRules(raw string) (*Redactor, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var specs []Rule
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&specs); err != nil {
+		return nil, fmt.Errorf("must be a JSON array of rules: %w", err)
+	}
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	r := &Redactor{rules: make([]rule, 0, len(specs))}
+	for i, spec := range specs {
+		name := spec.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		if spec.Pattern == "" && spec.Path == "" {
+			return nil, fmt.Errorf("rule %s needs a pattern, a path, or both", name)
+		}
+
+		compiled := rule{replacement: spec.Replacement}
+		if compiled.replacement == "" {
+			compiled.replacement = DefaultReplacement
+		}
+		if spec.Pattern != "" {
+			re, err := regexp.Compile(spec.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s pattern: %w", name, err)
+			}
+			compiled.pattern = re
+		}
+		if spec.Path != "" {
+			path, err := parsePath(spec.Path)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s path %q: %w", name, spec.Path, err)
+			}
+			compiled.path = path
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+// String applies the pattern-only rules to s.
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, rl := range r.rules {
+		if rl.path == nil {
+			s = rl.pattern.ReplaceAllLiteralString(s, rl.replacement)
+		}
+	}
+	return s
+}
+
+// JSON applies every rule to doc. Numbers and the document's shape are kept
+// apart from the values replaced; a document that does not parse is returned
+// as a redacted JSON string.
+func (r *Redactor) JSON(doc json.RawMessage) json.RawMessage {
+	if r == nil || len(doc) == 0 {
+		return doc
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		out, _ := json.Marshal(r.String(string(doc)))
+		return out
+	}
+	for _, rl := range r.rules {
+		if rl.path == nil {
+			v = rl.apply(v)
+			continue
+		}
+		v = visit(v, rl.path, rl.apply)
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		out, _ = json.Marshal(r.String(string(doc)))
+	}
+	return out
+}
+
+// apply redacts a value the rule selected: whole without a pattern, else
+// the pattern's matches in each string within it.
+func (rl rule) apply(v any) any {
+	if rl.pattern == nil {
+		return rl.replacement
+	}
+	switch v := v.(type) {
+	case string:
+		return rl.pattern.ReplaceAllLiteralString(v, rl.replacement)
+	case []any:
+		for i := range v {
+			v[i] = rl.apply(v[i])
+		}
+		return v
+	case map[string]any:
+		for k, item := range v {
+			v[k] = rl.apply(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+type segmentKind int
+
+const (
+	segmentChild segmentKind = iota
+	segmentIndex
+	segmentWildcard
+	segmentDescendant
+)
+
+// segment is one step of a parsed JSONPath. A descendant segment matches
+// name, or anything when name is "*", at any depth below the current value.
+type segment struct {
+	kind  segmentKind
+	name  string
+	index int
+}
+
+// errPathSyntax reports a path outside the supported JSONPath subset: "$"
+// followed by .name, ['name'], [N], [*], .*, and ..name steps.
+var errPathSyntax = errors.New(`must start with "$" and use only .name, ['name'], [N], [*], .*, and ..name`)
+
+func parsePath(path string) ([]segment, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(path), "$")
+	if !ok {
+		return nil, errPathSyntax
+	}
+
+	segments := []segment{}
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			name, tail := cutName(rest[2:])
+			if name == "" {
+				return nil, errPathSyntax
+			}
+			segments = append(segments, segment{kind: segmentDescendant, name: name})
+			rest = tail
+		case strings.HasPrefix(rest, "."):
+			name, tail := cutName(rest[1:])
+			switch name {
+			case "":
+				return nil, errPathSyntax
+			case "*":
+				segments = append(segments, segment{kind: segmentWildcard})
+			default:
+				segments = append(segments, segment{kind: segmentChild, name: name})
+			}
+			rest = tail
+		case strings.HasPrefix(rest, "["):
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, errPathSyntax
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				segments = append(segments, segment{kind: segmentWildcard})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				segments = append(segments, segment{kind: segmentChild, name: inner[1 : len(inner)-1]})
+			default:
+				n, err := strconv.Atoi(inner)
+				if err != nil || n < 0 {
+					return nil, errPathSyntax
+				}
+				segments = append(segments, segment{kind: segmentIndex, index: n})
+			}
+		default:
+			return nil, errPathSyntax
+		}
+	}
+	return segments, nil
+}
+
+// cutName splits a dotted name off the front of s, up to the next '.' or '['.
+func cutName(s string) (string, string) {
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+// visit replaces each value path selects in v with fn's result.
+func visit(v any, path []segment, fn func(any) any) any {
+	if len(path) == 0 {
+		return fn(v)
+	}
+	seg, rest := path[0], path[1:]
+
+	switch seg.kind {
+	case segmentChild:
+		if m, ok := v.(map[string]any); ok {
+			if child, ok := m[seg.name]; ok {
+				m[seg.name] = visit(child, rest, fn)
+			}
+		}
+	case segmentIndex:
+		if a, ok := v.([]any); ok && seg.index < len(a) {
+			a[seg.index] = visit(a[seg.index], rest, fn)
+		}
+	case segmentWildcard:
+		switch c := v.(type) {
+		case map[string]any:
+			for k, child := range c {
+				c[k] = visit(child, rest, fn)
+			}
+		case []any:
+			for i := range c {
+				c[i] = visit(c[i], rest, fn)
+			}
+		}
+	case segmentDescendant:
+		switch c := v.(type) {
+		case map[string]any:
+			for k, child := range c {
+				child = visit(child, path, fn)
+				if seg.name == "*" || k == seg.name {
+					child = visit(child, rest, fn)
+				}
+				c[k] = child
+			}
+		case []any:
+			for i := range c {
+				c[i] = visit(c[i], path, fn)
+				if seg.name == "*" {
+					c[i] = visit(c[i], rest, fn)
+				}
+			}
+		}
+	}
+	return v
+}
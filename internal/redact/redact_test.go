@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package redact
+
+import (
+	"testing"
+)
+
+func testRedactor(t *testing.T, raw string) *Redactor {
+	t.Helper()
+	r, err := ParseRules(raw)
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+	return r
+}
+
+func TestParseRules(t *testing.T) {
+	if r := testRedactor(t, " "); r != nil {
+		t.Fatalf("empty rules = %+v, want nil", r)
+	}
+	for _, raw := range []string{
+		`{"pattern":"x"}`,
+		`[{}]`,
+		`[{"pattern":"("}]`,
+		`[{"path":"prompt"}]`,
+		`[{"path":"$.a[x]"}]`,
+		`[{"path":"$."}]`,
+		`[{"pattern":"x","unknown":1}]`,
+	} {
+		if _, err := ParseRules(raw); err == nil {
+			t.Fatalf("%s: expected an error", raw)
+		}
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	r := testRedactor(t, `[
+		{"name": "email", "pattern": "[a-z]+@example\\.com", "replacement": "[EMAIL]"},
+		{"path": "$.prompt"},
+		{"path": "$..messages[*].content", "pattern": "\\d{3}-\\d{4}"},
+		{"path": "$.items[1]"}
+	]`)
+
+	got := r.JSON([]byte(`{
+		"prompt": {"text": "hi"},
+		"step": "LLM",
+		"cost": 0.25,
+		"contact": "ann@example.com and bob@example.com",
+		"nested": {"messages": [{"role": "user", "content": "call 555-1234"}]},
+		"items": [1, 2, 3]
+	}`))
+	want := `{"contact":"[EMAIL] and [EMAIL]","cost":0.25,"items":[1,"[REDACTED]",3],` +
+		`"nested":{"messages":[{"content":"call [REDACTED]","role":"user"}]},"prompt":"[REDACTED]","step":"LLM"}`
+	if string(got) != want {
+		t.Fatalf("JSON =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := r.JSON([]byte(`not json ann@example.com`)); string(got) != `"not json [EMAIL]"` {
+		t.Fatalf("JSON of invalid document = %s", got)
+	}
+}
+
+func TestRedactString(t *testing.T) {
+	r := testRedactor(t, `[{"pattern": "sk-[a-z0-9]+"}, {"path": "$.prompt", "pattern": "secret"}]`)
+	if got := r.String("key sk-abc123, a secret"); got != "key [REDACTED], a secret" {
+		t.Fatalf("String = %q", got)
+	}
+
+	var nilRedactor *Redactor
+	if got := nilRedactor.String("sk-abc"); got != "sk-abc" {
+		t.Fatalf("nil String = %q", got)
+	}
+	if got := nilRedactor.JSON([]byte(`{"a":1}`)); string(got) != `{"a":1}` {
+		t.Fatalf("nil JSON = %s", got)
+	}
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package relay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/kafkarest"
+)
+
+// KafkaConfig configures the Kafka publisher.
+type KafkaConfig struct {
+	// RESTURL is the base URL of a Confluent REST Proxy (v2 API).
+	RESTURL string
+	Topic   string
+}
+
+// Kafka produces messages through a Kafka REST Proxy. Records are keyed by run
+// ID, so a run's messages land on one partition in order.
+type Kafka struct {
+	client *kafkarest.Client
+	topic  string
+}
+
+// NewKafka returns a Kafka publisher for cfg. A nil client gets a 10s
+// timeout.
+func NewKafka(cfg KafkaConfig, client *http.Client) (*Kafka, error) {
+	if strings.TrimSpace(cfg.Topic) == "" {
+		return nil, errors.New("kafka topic is required")
+	}
+	return &Kafka{client: kafkarest.New(cfg.RESTURL, client), topic: cfg.Topic}, nil
+}
+
+func (k *Kafka) Publish(ctx context.Context, msg domain.OutboxMessage) error {
+	value, err := encode(msg)
+	if err != nil {
+		return err
+	}
+	return k.client.Produce(ctx, k.topic, kafkarest.Record{Key: msg.RunID.String(), Value: value})
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package relay
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+)
+
+// Publisher kinds accepted by OUTBOX_PUBLISHER.
+const (
+	PublisherNATS  = "nats"
+	PublisherKafka = "kafka"
+	PublisherSNS   = "sns"
+)
+
+// encode returns the document published for msg.
+func encode(msg domain.OutboxMessage) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+// subject returns the lower-cased message type, e.g. step_completed, joined to
+// prefix with a dot.
+func subject(prefix string, msg domain.OutboxMessage) string {
+	name := strings.ToLower(msg.Type)
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func closePublisher(p Publisher, logger *slog.Logger) {
+	c, ok := p.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := c.Close(); err != nil {
+		logger.Warn("close outbox publisher failed", "error", err)
+	}
+}
@@ -0,0 +1,259 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package relay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+)
+
+func testMessage() domain.OutboxMessage {
+	return domain.OutboxMessage{
+		Seq:       7,
+		ID:        uuid.New(),
+		Type:      domain.EventStepSucceeded,
+		APIKeyID:  uuid.New(),
+		RunID:     uuid.New(),
+		Payload:   json.RawMessage(`{"step":"draft"}`),
+		CreatedAt: time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestNATSPublishesWithAuthAndWaitsForPong(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	type received struct {
+		connect string
+		pub     string
+		body    string
+	}
+	got := make(chan received, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		readLine := func() string {
+			line, _ := r.ReadString('\n')
+			return strings.TrimRight(line, "\r\n")
+		}
+
+		io.WriteString(conn, "INFO {\"server_id\":\"test\"}\r\n")
+		var rec received
+		rec.connect = readLine()
+		readLine() // PING
+		io.WriteString(conn, "PONG\r\n")
+
+		rec.pub = readLine()
+		rec.body = readLine()
+		readLine() // PING
+		// A server PING before our PONG must be answered.
+		io.WriteString(conn, "PING\r\n")
+		if readLine() != "PONG" {
+			return
+		}
+		io.WriteString(conn, "PONG\r\n")
+		got <- rec
+	}()
+
+	pub, err := NewNATS(NATSConfig{URL: "nats://relay:s3cret@" + ln.Addr().String(), SubjectPrefix: "agent_runtime"})
+	if err != nil {
+		t.Fatalf("new nats: %v", err)
+	}
+	defer pub.Close()
+
+	msg := testMessage()
+	if err := pub.Publish(context.Background(), msg); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	rec := <-got
+	var connect map[string]any
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(rec.connect, "CONNECT ")), &connect); err != nil {
+		t.Fatalf("decode CONNECT %q: %v", rec.connect, err)
+	}
+	if connect["user"] != "relay" || connect["pass"] != "s3cret" {
+		t.Fatalf("expected credentials from the URL, got %v", connect)
+	}
+	wantPub := "PUB agent_runtime.step_succeeded " + strconv.Itoa(len(rec.body))
+	if rec.pub != wantPub {
+		t.Fatalf("expected %q, got %q", wantPub, rec.pub)
+	}
+	var envelope domain.OutboxMessage
+	if err := json.Unmarshal([]byte(rec.body), &envelope); err != nil || envelope.ID != msg.ID || envelope.RunID != msg.RunID {
+		t.Fatalf("unexpected message body %q (%v)", rec.body, err)
+	}
+}
+
+func TestNATSReportsServerError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "INFO {}\r\n")
+		bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+	}()
+
+	pub, err := NewNATS(NATSConfig{URL: "nats://" + ln.Addr().String()})
+	if err != nil {
+		t.Fatalf("new nats: %v", err)
+	}
+	err = pub.Publish(context.Background(), testMessage())
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Fatalf("expected the server error, got %v", err)
+	}
+}
+
+func TestKafkaProducesKeyedRecord(t *testing.T) {
+	msg := testMessage()
+	var body struct {
+		Records []struct {
+			Key   string               `json:"key"`
+			Value domain.OutboxMessage `json:"value"`
+		} `json:"records"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/run-events" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/vnd.kafka.json.v2+json" {
+			t.Errorf("unexpected content type %q", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		io.WriteString(w, `{"offsets":[{"partition":0,"offset":12}]}`)
+	}))
+	defer srv.Close()
+
+	pub, err := NewKafka(KafkaConfig{RESTURL: srv.URL + "/", Topic: "run-events"}, srv.Client())
+	if err != nil {
+		t.Fatalf("new kafka: %v", err)
+	}
+	if err := pub.Publish(context.Background(), msg); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if len(body.Records) != 1 || body.Records[0].Key != msg.RunID.String() || body.Records[0].Value.ID != msg.ID {
+		t.Fatalf("unexpected records %+v", body.Records)
+	}
+}
+
+func TestKafkaReportsRecordError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"offsets":[{"partition":null,"offset":null,"error_code":50003,"error":"leader not available"}]}`)
+	}))
+	defer srv.Close()
+
+	pub, err := NewKafka(KafkaConfig{RESTURL: srv.URL, Topic: "run-events"}, srv.Client())
+	if err != nil {
+		t.Fatalf("new kafka: %v", err)
+	}
+	err = pub.Publish(context.Background(), testMessage())
+	if err == nil || !strings.Contains(err.Error(), "leader not available") {
+		t.Fatalf("expected the record error, got %v", err)
+	}
+}
+
+func TestSNSPublishesSignedFIFOMessage(t *testing.T) {
+	msg := testMessage()
+	var form url.Values
+	var auth, token string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		token = r.Header.Get("X-Amz-Security-Token")
+		r.ParseForm()
+		form = r.PostForm
+		io.WriteString(w, `<PublishResponse><PublishResult><MessageId>1</MessageId></PublishResult></PublishResponse>`)
+	}))
+	defer srv.Close()
+
+	pub, err := NewSNS(SNSConfig{
+		TopicARN:        "arn:aws:sns:eu-west-1:123456789012:runs.fifo",
+		Endpoint:        srv.URL,
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "secret",
+		SessionToken:    "session",
+	}, srv.Client())
+	if err != nil {
+		t.Fatalf("new sns: %v", err)
+	}
+	if err := pub.Publish(context.Background(), msg); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(auth, "/eu-west-1/sns/aws4_request") {
+		t.Fatalf("unexpected authorization %q", auth)
+	}
+	if token != "session" {
+		t.Fatalf("expected the session token header, got %q", token)
+	}
+	if form.Get("Action") != "Publish" || form.Get("TopicArn") != "arn:aws:sns:eu-west-1:123456789012:runs.fifo" {
+		t.Fatalf("unexpected form %v", form)
+	}
+	if form.Get("MessageGroupId") != msg.RunID.String() || form.Get("MessageDeduplicationId") != msg.ID.String() {
+		t.Fatalf("expected FIFO group and dedup IDs, got %v", form)
+	}
+	if form.Get("MessageAttributes.entry.1.Value.StringValue") != msg.Type {
+		t.Fatalf("expected the type attribute, got %v", form)
+	}
+}
+
+func TestSNSRegionRejectsBadARN(t *testing.T) {
+	if region, err := SNSRegion("arn:aws:sns:us-east-2:123456789012:runs"); err != nil || region != "us-east-2" {
+		t.Fatalf("expected us-east-2, got %q (%v)", region, err)
+	}
+	for _, arn := range []string{"", "runs", "arn:aws:sqs:us-east-1:123456789012:runs", "arn:aws:sns::123456789012:runs"} {
+		if _, err := SNSRegion(arn); err == nil {
+			t.Fatalf("expected an error for %q", arn)
+		}
+	}
+}
+
+// TestSignV4MatchesAWSExample checks the signer against the GET ListUsers
+// example from the AWS Signature Version 4 documentation.
+func TestSignV4MatchesAWSExample(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Version=2010-05-08&Action=ListUsers", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	signV4(req, nil, awsCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("unexpected authorization\n got: %s\nwant: %s", got, want)
+	}
+}
@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package relay publishes outbox_messages to an external broker. Messages are
+// written by the transactions that create runs and record events, so a
+// message exists if and only if its state change committed; the relay
+// delivers each at least once, in order per run.
+package relay
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultBatchSize = 100
+	// claimLease is how long a claimed batch is held before another relay may
+	// claim it again, e.g. after this one crashed mid-batch.
+	claimLease     = time.Minute
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 5 * time.Minute
+	maxErrorLength = 1024
+)
+
+// Publisher sends one message to a broker. Publish returns once the broker has
+// accepted the message. A Publisher that also implements io.Closer is closed
+// when the relay stops.
+type Publisher interface {
+	Publish(ctx context.Context, msg domain.OutboxMessage) error
+}
+
+// MessageStore claims outbox messages and records what became of them.
+type MessageStore interface {
+	ClaimOutboxMessages(ctx context.Context, batchSize int, lease time.Duration) ([]domain.OutboxMessage, error)
+	DeleteOutboxMessages(ctx context.Context, seqs []int64) error
+	RetryOutboxMessage(ctx context.Context, seq int64, at time.Time, lastError string) error
+	ReleaseOutboxMessages(ctx context.Context, seqs []int64) error
+}
+
+type Deps struct {
+	Store     MessageStore
+	Publisher Publisher
+	Logger    *slog.Logger
+	// Interval is how often the relay polls when the outbox is drained.
+	Interval  time.Duration
+	BatchSize int
+}
+
+// Relay runs the outbox publishing loop.
+type Relay struct {
+	store     MessageStore
+	publisher Publisher
+	logger    *slog.Logger
+	interval  time.Duration
+	batchSize int
+	now       func() time.Time
+}
+
+func New(deps Deps) *Relay {
+	l := deps.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+
+	interval := deps.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	batchSize := deps.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	return &Relay{
+		store:     deps.Store,
+		publisher: deps.Publisher,
+		logger:    l,
+		interval:  interval,
+		batchSize: batchSize,
+		now:       time.Now,
+	}
+}
+
+// Run executes RunOnce until ctx is done, immediately again after a full batch
+// and otherwise on every interval. It returns at once when no publisher is
+// configured.
+func (r *Relay) Run(ctx context.Context) {
+	if r.publisher == nil {
+		r.logger.Info("outbox relay disabled")
+		return
+	}
+	defer closePublisher(r.publisher, r.logger)
+
+	r.logger.Info("outbox relay started",
+		"interval", r.interval,
+		"batch_size", r.batchSize,
+	)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Info("outbox relay stopped")
+			return
+		case <-timer.C:
+		}
+
+		claimed, err := r.runOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.Error("outbox relay pass failed", "error", err)
+		}
+		if err == nil && claimed == r.batchSize {
+			timer.Reset(0)
+		} else {
+			timer.Reset(r.interval)
+		}
+	}
+}
+
+// RunOnce claims a batch and publishes it in order. Once a message of a run
+// fails, that run's later messages in the batch are released unpublished so
+// they stay behind it.
+func (r *Relay) RunOnce(ctx context.Context) error {
+	_, err := r.runOnce(ctx)
+	return err
+}
+
+func (r *Relay) runOnce(ctx context.Context) (int, error) {
+	if r.store == nil || r.publisher == nil {
+		return 0, nil
+	}
+
+	claimed, err := r.store.ClaimOutboxMessages(ctx, r.batchSize, claimLease)
+	if err != nil {
+		return 0, err
+	}
+
+	var published, held []int64
+	blocked := make(map[uuid.UUID]bool)
+	for _, msg := range claimed {
+		if blocked[msg.RunID] || ctx.Err() != nil {
+			held = append(held, msg.Seq)
+			continue
+		}
+
+		if err := r.publisher.Publish(ctx, msg); err != nil {
+			blocked[msg.RunID] = true
+			if ctx.Err() != nil {
+				held = append(held, msg.Seq)
+				continue
+			}
+			delay := retryDelay(msg.Attempts)
+			r.logger.Warn("outbox publish failed",
+				"message_id", msg.ID,
+				"type", msg.Type,
+				"run_id", msg.RunID,
+				"attempts", msg.Attempts+1,
+				"retry_in", delay.String(),
+				"error", err,
+			)
+			metrics.AddOutboxMessages(metrics.OutboxOutcomeRetry, 1)
+			if err := r.store.RetryOutboxMessage(ctx, msg.Seq, r.now().Add(delay), truncate(err.Error())); err != nil {
+				return len(claimed), err
+			}
+			continue
+		}
+		published = append(published, msg.Seq)
+	}
+
+	// Messages are deleted only after the whole batch, so a crash in between
+	// republishes them; consumers deduplicate on id.
+	if err := r.store.DeleteOutboxMessages(context.WithoutCancel(ctx), published); err != nil {
+		return len(claimed), err
+	}
+	metrics.AddOutboxMessages(metrics.OutboxOutcomePublished, int64(len(published)))
+
+	if len(held) > 0 {
+		// Release even when ctx is canceled, so shutdown does not leave the
+		// held messages leased.
+		if err := r.store.ReleaseOutboxMessages(context.WithoutCancel(ctx), held); err != nil {
+			return len(claimed), err
+		}
+	}
+	return len(claimed), nil
+}
+
+// retryDelay doubles from retryBaseDelay with each earlier attempt, up to
+// retryMaxDelay.
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 0; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMaxDelay)
+}
+
+func truncate(s string) string {
+	if len(s) > maxErrorLength {
+		return s[:maxErrorLength]
+	}
+	return s
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package relay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+)
+
+type fakeStore struct {
+	claimed  []domain.OutboxMessage
+	deleted  []int64
+	released []int64
+	retried  map[int64]time.Time
+	errors   map[int64]string
+}
+
+func (f *fakeStore) ClaimOutboxMessages(ctx context.Context, batchSize int, lease time.Duration) ([]domain.OutboxMessage, error) {
+	return f.claimed, nil
+}
+
+func (f *fakeStore) DeleteOutboxMessages(ctx context.Context, seqs []int64) error {
+	f.deleted = append(f.deleted, seqs...)
+	return nil
+}
+
+func (f *fakeStore) RetryOutboxMessage(ctx context.Context, seq int64, at time.Time, lastError string) error {
+	if f.retried == nil {
+		f.retried = make(map[int64]time.Time)
+		f.errors = make(map[int64]string)
+	}
+	f.retried[seq] = at
+	f.errors[seq] = lastError
+	return nil
+}
+
+func (f *fakeStore) ReleaseOutboxMessages(ctx context.Context, seqs []int64) error {
+	f.released = append(f.released, seqs...)
+	return nil
+}
+
+type recordingPublisher struct {
+	published []int64
+	failSeq   map[int64]bool
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, msg domain.OutboxMessage) error {
+	if p.failSeq[msg.Seq] {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, msg.Seq)
+	return nil
+}
+
+func TestRunOncePublishesInOrderAndDeletes(t *testing.T) {
+	run := uuid.New()
+	store := &fakeStore{claimed: []domain.OutboxMessage{
+		{Seq: 1, RunID: run, Type: domain.OutboxRunCreated},
+		{Seq: 2, RunID: run, Type: domain.EventStepClaimed},
+	}}
+	pub := &recordingPublisher{}
+	r := New(Deps{Store: store, Publisher: pub, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+
+	if err := r.RunOnce(context.Background()); err != nil {
+		t.Fatalf("run once: %v", err)
+	}
+	if !slices.Equal(pub.published, []int64{1, 2}) {
+		t.Fatalf("expected messages published in order, got %v", pub.published)
+	}
+	if !slices.Equal(store.deleted, []int64{1, 2}) {
+		t.Fatalf("expected published messages deleted, got %v", store.deleted)
+	}
+}
+
+func TestRunOnceHoldsRunBehindFailedMessage(t *testing.T) {
+	failing, other := uuid.New(), uuid.New()
+	store := &fakeStore{claimed: []domain.OutboxMessage{
+		{Seq: 1, RunID: failing, Attempts: 2},
+		{Seq: 2, RunID: other},
+		{Seq: 3, RunID: failing},
+	}}
+	pub := &recordingPublisher{failSeq: map[int64]bool{1: true}}
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	r := New(Deps{Store: store, Publisher: pub, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	r.now = func() time.Time { return now }
+
+	if err := r.RunOnce(context.Background()); err != nil {
+		t.Fatalf("run once: %v", err)
+	}
+	if !slices.Equal(pub.published, []int64{2}) {
+		t.Fatalf("expected only the other run published, got %v", pub.published)
+	}
+	if got := store.retried[1]; !got.Equal(now.Add(4 * time.Second)) {
+		t.Fatalf("expected retry after 4s on the third attempt, got %s", got)
+	}
+	if store.errors[1] != "broker unavailable" {
+		t.Fatalf("unexpected last error %q", store.errors[1])
+	}
+	if !slices.Equal(store.released, []int64{3}) {
+		t.Fatalf("expected the failed run's later message released, got %v", store.released)
+	}
+	if !slices.Equal(store.deleted, []int64{2}) {
+		t.Fatalf("expected only the published message deleted, got %v", store.deleted)
+	}
+}
+
+func TestRetryDelayIsCapped(t *testing.T) {
+	if got := retryDelay(0); got != time.Second {
+		t.Fatalf("expected 1s for the first retry, got %s", got)
+	}
+	if got := retryDelay(50); got != retryMaxDelay {
+		t.Fatalf("expected the delay capped at %s, got %s", retryMaxDelay, got)
+	}
+}
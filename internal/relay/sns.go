@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+)
+
+const snsTimeout = 10 * time.Second
+
+// SNSConfig configures the SNS publisher.
+type SNSConfig struct {
+	TopicARN string
+	// Endpoint overrides https://sns.<region>.amazonaws.com, e.g. for
+	// LocalStack or a VPC endpoint.
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// SNS publishes messages to an SNS topic with the Publish action, signed with
+// Signature Version 4. Each message carries its type in a "type" message
+// attribute for subscription filter policies. On FIFO topics a run's messages
+// share a message group and are deduplicated on message ID.
+type SNS struct {
+	cfg      SNSConfig
+	region   string
+	endpoint string
+	fifo     bool
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewSNS returns an SNS publisher for cfg. A nil client gets a 10s timeout.
+func NewSNS(cfg SNSConfig, client *http.Client) (*SNS, error) {
+	region, err := SNSRegion(cfg.TopicARN)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("sns needs an access key ID and secret access key")
+	}
+	endpoint := strings.TrimRight(cfg.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://sns." + region + ".amazonaws.com"
+	}
+	if client == nil {
+		client = &http.Client{Timeout: snsTimeout}
+	}
+	return &SNS{
+		cfg:      cfg,
+		region:   region,
+		endpoint: endpoint + "/",
+		fifo:     strings.HasSuffix(cfg.TopicARN, ".fifo"),
+		client:   client,
+		now:      time.Now,
+	}, nil
+}
+
+// SNSRegion returns the region of an SNS topic ARN,
+// arn:<partition>:sns:<region>:<account>:<topic>.
+func SNSRegion(arn string) (string, error) {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "sns" || parts[3] == "" || parts[5] == "" {
+		return "", fmt.Errorf("invalid SNS topic ARN %q", arn)
+	}
+	return parts[3], nil
+}
+
+func (s *SNS) Publish(ctx context.Context, msg domain.OutboxMessage) error {
+	body, err := encode(msg)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{
+		"Action":                         {"Publish"},
+		"Version":                        {"2010-03-31"},
+		"TopicArn":                       {s.cfg.TopicARN},
+		"Message":                        {string(body)},
+		"MessageAttributes.entry.1.Name": {"type"},
+		"MessageAttributes.entry.1.Value.DataType":    {"String"},
+		"MessageAttributes.entry.1.Value.StringValue": {msg.Type},
+	}
+	if s.fifo {
+		form.Set("MessageGroupId", msg.RunID.String())
+		form.Set("MessageDeduplicationId", msg.ID.String())
+	}
+	payload := []byte(form.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signV4(req, payload, awsCredentials{
+		AccessKeyID:     s.cfg.AccessKeyID,
+		SecretAccessKey: s.cfg.SecretAccessKey,
+		SessionToken:    s.cfg.SessionToken,
+	}, s.region, "sns", s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sns publish: %w", err)
+	}
+	defer resp.Body.Close()
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var e struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		if xml.Unmarshal(detail, &e) == nil && e.Code != "" {
+			return fmt.Errorf("sns publish returned %d: %s: %s", resp.StatusCode, e.Code, e.Message)
+		}
+		return fmt.Errorf("sns publish returned %d", resp.StatusCode)
+	}
+	return nil
+}
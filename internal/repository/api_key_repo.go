@@ -4,23 +4,49 @@ package repository
 
 import (
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net/netip"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/adiadia/agent-runtime/internal/audit"
 	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/adiadia/agent-runtime/internal/budget"
+	"github.com/adiadia/agent-runtime/internal/clock"
 	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/egress"
+	"github.com/adiadia/agent-runtime/internal/secrets"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type APIKeyRepository struct {
-	pool   *pgxpool.Pool
-	logger *slog.Logger
+	pool    *pgxpool.Pool
+	logger  *slog.Logger
+	clock   clock.Clock
+	keyring *secrets.Keyring
+	pepper  []byte
+
+	usageInterval time.Duration
+	usageMu       sync.Mutex
+	usage         map[uuid.UUID]*keyUsage
+}
+
+// keyUsage is a key's use since its usage was last written.
+type keyUsage struct {
+	requests  int64
+	lastUsed  time.Time
+	flushedAt time.Time
 }
 
 func NewAPIKeyRepository(pool *pgxpool.Pool, logger *slog.Logger) *APIKeyRepository {
@@ -34,19 +60,63 @@ func NewAPIKeyRepository(pool *pgxpool.Pool, logger *slog.Logger) *APIKeyReposit
 	}
 }
 
+// WithClock sets the clock used to decide whether a key has expired and to
+// stamp revocations and audit records.
+func (r *APIKeyRepository) WithClock(c clock.Clock) *APIKeyRepository {
+	r.clock = c
+	return r
+}
+
+// WithKeyring encrypts default webhook secrets and webhook signing keys under
+// keyring before they are stored.
+func (r *APIKeyRepository) WithKeyring(keyring *secrets.Keyring) *APIKeyRepository {
+	r.keyring = keyring
+	return r
+}
+
+// WithTokenPepper stores the hashes of new tokens keyed with pepper, so the
+// table alone is not enough to brute-force a token. Existing unkeyed hashes
+// keep resolving until RehashLegacyTokens converts them.
+func (r *APIKeyRepository) WithTokenPepper(pepper string) *APIKeyRepository {
+	if pepper != "" {
+		r.pepper = []byte(pepper)
+	}
+	return r
+}
+
+// WithUsageTracking makes ResolveAPIKey record each key's last_used_at and
+// request_count. Requests are counted in memory and written at most once per
+// interval per key, so both lag by up to interval; a key's first request is
+// written at once.
+func (r *APIKeyRepository) WithUsageTracking(interval time.Duration) *APIKeyRepository {
+	r.usageInterval = interval
+	r.usage = make(map[uuid.UUID]*keyUsage)
+	return r
+}
+
 func (r *APIKeyRepository) ResolveAPIKey(ctx context.Context, bearerToken string) (auth.APIKey, bool, error) {
 	if bearerToken == "" {
 		return auth.APIKey{}, false, nil
 	}
-	tokenHash := sha256Hex(bearerToken)
+	legacyHash := sha256Hex(bearerToken)
+	tokenHashes := []string{legacyHash}
+	if r.pepper != nil {
+		tokenHashes = append(tokenHashes, r.keyedHash(legacyHash))
+	}
 
-	var key auth.APIKey
+	var (
+		key          auth.APIKey
+		allowedCIDRs []string
+	)
 	err := r.pool.QueryRow(ctx,
-		`SELECT id, max_concurrent_runs, max_requests_per_min
+		`SELECT id, COALESCE(slug, ''), max_concurrent_runs, max_requests_per_min, scopes, expires_at, allowed_cidrs
 		 FROM api_keys
-		 WHERE token_hash=$1 AND revoked_at IS NULL`,
-		tokenHash,
-	).Scan(&key.ID, &key.MaxConcurrentRuns, &key.MaxRequestsPerMin)
+		 WHERE token_hash = ANY($1)
+		   AND revoked_at IS NULL
+		   AND (expires_at IS NULL OR expires_at > $2)`,
+		tokenHashes,
+		nowUTC(r.clock),
+	).Scan(&key.ID, &key.Slug, &key.MaxConcurrentRuns, &key.MaxRequestsPerMin, &key.Scopes, &key.ExpiresAt, &allowedCIDRs)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return auth.APIKey{}, false, nil
@@ -61,10 +131,59 @@ func (r *APIKeyRepository) ResolveAPIKey(ctx context.Context, bearerToken string
 	if key.MaxRequestsPerMin <= 0 {
 		key.MaxRequestsPerMin = domain.DefaultMaxRequestsPerMin
 	}
+	for _, cidr := range allowedCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			r.logger.Error("invalid stored allowed cidr", "api_key_id", key.ID, "cidr", cidr, "error", err)
+			return auth.APIKey{}, false, err
+		}
+		key.AllowedCIDRs = append(key.AllowedCIDRs, prefix)
+	}
 
+	if r.usage != nil {
+		r.recordUsage(ctx, key.ID)
+	}
 	return key, true, nil
 }
 
+// recordUsage counts one request by the key and writes what the key's
+// requests added once its usage was last written an interval ago. A failed
+// write keeps the count for the next one; it never fails the request.
+func (r *APIKeyRepository) recordUsage(ctx context.Context, id uuid.UUID) {
+	now := nowUTC(r.clock)
+
+	r.usageMu.Lock()
+	u := r.usage[id]
+	if u == nil {
+		u = &keyUsage{}
+		r.usage[id] = u
+	}
+	u.requests++
+	u.lastUsed = now
+	if !u.flushedAt.IsZero() && now.Sub(u.flushedAt) < r.usageInterval {
+		r.usageMu.Unlock()
+		return
+	}
+	requests, lastUsed := u.requests, u.lastUsed
+	u.requests = 0
+	u.flushedAt = now
+	r.usageMu.Unlock()
+
+	// GREATEST skips NULL and keeps the latest use across API processes.
+	if _, err := r.pool.Exec(ctx, `
+		UPDATE api_keys
+		SET last_used_at = GREATEST(last_used_at, $2),
+		    request_count = request_count + $3
+		WHERE id = $1`,
+		id, lastUsed, requests,
+	); err != nil {
+		r.logger.Warn("record api key usage failed", "api_key_id", id, "error", err)
+		r.usageMu.Lock()
+		u.requests += requests
+		r.usageMu.Unlock()
+	}
+}
+
 func (r *APIKeyRepository) CreateAPIKey(ctx context.Context, params domain.CreateAPIKeyParams) (domain.CreatedAPIKey, error) {
 	name := strings.TrimSpace(params.Name)
 	if name == "" {
@@ -79,56 +198,188 @@ func (r *APIKeyRepository) CreateAPIKey(ctx context.Context, params domain.Creat
 	if maxRequestsPerMin <= 0 {
 		maxRequestsPerMin = domain.DefaultMaxRequestsPerMin
 	}
+	if err := domain.ValidateEventRetentionDays(params.EventRetentionDays); err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+	if err := domain.ValidateRunRetentionDays(params.RunRetentionDays); err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+	if err := domain.ValidateMonthlyBudget(params.MonthlyBudgetUSD); err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+	scopes, err := domain.NormalizeScopes(params.Scopes)
+	if err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+	slug := strings.TrimSpace(params.Slug)
+	if err := domain.ValidateAPIKeySlug(slug); err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+	if err := domain.ValidateAPIKeyExpiry(params.ExpiresAt, nowUTC(r.clock)); err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+	allowedCIDRs, err := domain.NormalizeAllowedCIDRs(params.AllowedCIDRs)
+	if err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+	var expiresAt *time.Time
+	if params.ExpiresAt != nil {
+		utc := params.ExpiresAt.UTC()
+		expiresAt = &utc
+	}
 
-	token, tokenHash, err := generateAPIKeyToken()
+	token, err := generateAPIKeyToken()
 	if err != nil {
 		r.logger.Error("generate api key token failed", "error", err)
 		return domain.CreatedAPIKey{}, err
 	}
 
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		r.logger.Error("begin tx failed", "error", err)
+		return domain.CreatedAPIKey{}, err
+	}
+	defer tx.Rollback(ctx)
+
 	apiKeyID := uuid.New()
-	if _, err := r.pool.Exec(ctx, `
-		INSERT INTO api_keys (id, name, token_hash, max_concurrent_runs, max_requests_per_min)
-		VALUES ($1, $2, $3, $4, $5)
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO api_keys (id, name, token_hash, max_concurrent_runs, max_requests_per_min, event_retention_days, scopes, slug, expires_at, allowed_cidrs, monthly_budget_usd, run_retention_days)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 	`,
 		apiKeyID,
 		name,
-		tokenHash,
+		r.tokenHash(token),
 		maxConcurrentRuns,
 		maxRequestsPerMin,
+		params.EventRetentionDays,
+		scopes,
+		nullString(slug),
+		expiresAt,
+		allowedCIDRs,
+		params.MonthlyBudgetUSD,
+		params.RunRetentionDays,
 	); err != nil {
+		if isUniqueViolation(err) {
+			return domain.CreatedAPIKey{}, domain.ErrAPIKeySlugTaken
+		}
 		r.logger.Error("create api key failed", "name", name, "error", err)
 		return domain.CreatedAPIKey{}, err
 	}
 
+	if err := audit.Record(ctx, tx, nowUTC(r.clock), audit.Change{
+		Action:     domain.AuditAPIKeyCreate,
+		APIKeyID:   apiKeyID,
+		TargetType: domain.AuditTargetAPIKey,
+		TargetID:   apiKeyID.String(),
+		After: map[string]any{
+			"name":                 name,
+			"slug":                 nullString(slug),
+			"max_concurrent_runs":  maxConcurrentRuns,
+			"max_requests_per_min": maxRequestsPerMin,
+			"event_retention_days": params.EventRetentionDays,
+			"run_retention_days":   params.RunRetentionDays,
+			"monthly_budget_usd":   params.MonthlyBudgetUSD,
+			"scopes":               scopes,
+			"expires_at":           expiresAt,
+			"allowed_cidrs":        allowedCIDRs,
+		},
+	}); err != nil {
+		r.logger.Error("record api key creation failed", "api_key_id", apiKeyID, "error", err)
+		return domain.CreatedAPIKey{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("commit failed", "api_key_id", apiKeyID, "error", err)
+		return domain.CreatedAPIKey{}, err
+	}
+
 	return domain.CreatedAPIKey{
 		ID:    apiKeyID,
 		Token: token,
 	}, nil
 }
 
-func (r *APIKeyRepository) ListAPIKeys(ctx context.Context) ([]domain.APIKeyRecord, error) {
+// ListAPIKeys returns the keys matching filter, newest first, at most
+// filter.Limit of them (DefaultAPIKeyPageSize when unset, capped at
+// MaxAPIKeyPageSize). A filter.BeforeID naming no key, revoked or not, fails
+// with domain.ErrAPIKeyNotFound.
+func (r *APIKeyRepository) ListAPIKeys(ctx context.Context, filter domain.APIKeyFilter) ([]domain.APIKeyRecord, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = domain.DefaultAPIKeyPageSize
+	}
+	limit = min(limit, domain.MaxAPIKeyPageSize)
+
+	var (
+		conds []string
+		args  []any
+	)
+	where := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if !filter.IncludeRevoked {
+		conds = append(conds, "revoked_at IS NULL")
+	}
+	if filter.NamePrefix != "" {
+		where("starts_with(name, ?)", filter.NamePrefix)
+	}
+	if filter.BeforeID != nil {
+		// Resolved up front: a cursor compared against a subquery that
+		// finds nothing matches no row and reads as an empty last page.
+		var createdAt time.Time
+		if err := r.pool.QueryRow(ctx, `SELECT created_at FROM api_keys WHERE id=$1`, *filter.BeforeID).Scan(&createdAt); err != nil {
+			r.logger.Warn("list api keys cursor lookup failed", "before", *filter.BeforeID, "error", err)
+			return nil, notFound(err, domain.ErrAPIKeyNotFound)
+		}
+		args = append(args, createdAt, *filter.BeforeID)
+		conds = append(conds, "(created_at, id) < ($"+strconv.Itoa(len(args)-1)+", $"+strconv.Itoa(len(args))+")")
+	}
+
+	whereClause := ""
+	if len(conds) > 0 {
+		whereClause = "WHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, limit)
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, name, max_concurrent_runs, max_requests_per_min, created_at
+		SELECT id, name, slug, max_concurrent_runs, max_requests_per_min, event_retention_days, run_retention_days,
+		       monthly_budget_usd::double precision, scheduling_weight, max_concurrent_runs_per_template, default_webhook_url, default_webhook_secret IS NOT NULL, scopes, expires_at, allowed_cidrs, egress_allow_hosts, last_used_at, request_count, created_at, revoked_at
 		FROM api_keys
-		WHERE revoked_at IS NULL
-		ORDER BY created_at DESC
-	`)
+		`+whereClause+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT $`+strconv.Itoa(len(args)),
+		args...,
+	)
 	if err != nil {
 		r.logger.Error("list api keys query failed", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	keys := make([]domain.APIKeyRecord, 0, 32)
+	keys := make([]domain.APIKeyRecord, 0, limit)
 	for rows.Next() {
 		var record domain.APIKeyRecord
 		if err := rows.Scan(
 			&record.ID,
 			&record.Name,
+			&record.Slug,
 			&record.MaxConcurrentRuns,
 			&record.MaxRequestsPerMin,
+			&record.EventRetentionDays,
+			&record.RunRetentionDays,
+			&record.MonthlyBudgetUSD,
+			&record.SchedulingWeight,
+			&record.MaxConcurrentRunsPerTemplate,
+			&record.DefaultWebhookURL,
+			&record.HasDefaultWebhookSecret,
+			&record.Scopes,
+			&record.ExpiresAt,
+			&record.AllowedCIDRs,
+			&record.EgressAllowHosts,
+			&record.LastUsedAt,
+			&record.RequestCount,
 			&record.CreatedAt,
+			&record.RevokedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -141,29 +392,726 @@ func (r *APIKeyRepository) ListAPIKeys(ctx context.Context) ([]domain.APIKeyReco
 	return keys, nil
 }
 
+func (r *APIKeyRepository) GetAPIKey(ctx context.Context, id uuid.UUID) (domain.APIKeyRecord, error) {
+	var record domain.APIKeyRecord
+	err := r.pool.QueryRow(ctx, `
+		SELECT id, name, slug, max_concurrent_runs, max_requests_per_min, event_retention_days, run_retention_days,
+		       monthly_budget_usd::double precision, scheduling_weight, max_concurrent_runs_per_template, default_webhook_url, default_webhook_secret IS NOT NULL, scopes, expires_at, allowed_cidrs, egress_allow_hosts, last_used_at, request_count, created_at
+		FROM api_keys
+		WHERE id=$1 AND revoked_at IS NULL
+	`, id).Scan(
+		&record.ID,
+		&record.Name,
+		&record.Slug,
+		&record.MaxConcurrentRuns,
+		&record.MaxRequestsPerMin,
+		&record.EventRetentionDays,
+		&record.RunRetentionDays,
+		&record.MonthlyBudgetUSD,
+		&record.SchedulingWeight,
+		&record.MaxConcurrentRunsPerTemplate,
+		&record.DefaultWebhookURL,
+		&record.HasDefaultWebhookSecret,
+		&record.Scopes,
+		&record.ExpiresAt,
+		&record.AllowedCIDRs,
+		&record.EgressAllowHosts,
+		&record.LastUsedAt,
+		&record.RequestCount,
+		&record.CreatedAt,
+	)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("get api key failed", "api_key_id", id, "error", err)
+		}
+		return domain.APIKeyRecord{}, notFound(err, domain.ErrAPIKeyNotFound)
+	}
+
+	return record, nil
+}
+
+// GetAPIKeyIDBySlug returns the ID of the key that owns slug. Revoked keys are
+// included so their slugs still resolve for tenant purges.
+func (r *APIKeyRepository) GetAPIKeyIDBySlug(ctx context.Context, slug string) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := r.pool.QueryRow(ctx, `SELECT id FROM api_keys WHERE slug=$1`, slug).Scan(&id)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("get api key by slug failed", "slug", slug, "error", err)
+		}
+		return uuid.Nil, notFound(err, domain.ErrAPIKeyNotFound)
+	}
+	return id, nil
+}
+
+// SetSlug replaces the key's slug; "" clears it. It returns
+// domain.ErrAPIKeySlugTaken when another key already uses slug.
+func (r *APIKeyRepository) SetSlug(ctx context.Context, id uuid.UUID, slug string) error {
+	slug = strings.TrimSpace(slug)
+	if err := domain.ValidateAPIKeySlug(slug); err != nil {
+		return err
+	}
+
+	if err := r.updateAPIKeyField(ctx, id, "slug", nullString(slug)); err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrAPIKeySlugTaken
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("set api key slug failed", "api_key_id", id, "error", err)
+		}
+		return err
+	}
+
+	r.logger.Info("api key slug updated", "api_key_id", id, "slug", slug)
+	return nil
+}
+
+// SetEventRetentionDays overrides the event retention for one key. A nil
+// value clears the override so the key falls back to the deployment default.
+func (r *APIKeyRepository) SetEventRetentionDays(ctx context.Context, id uuid.UUID, days *int) error {
+	if err := domain.ValidateEventRetentionDays(days); err != nil {
+		return err
+	}
+
+	if err := r.updateAPIKeyField(ctx, id, "event_retention_days", days); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("set event retention failed", "api_key_id", id, "error", err)
+		}
+		return err
+	}
+
+	r.logger.Info("event retention updated", "api_key_id", id, "event_retention_days", days)
+	return nil
+}
+
+// SetRunRetentionDays overrides how long one key's terminal runs stay in the
+// hot tables. A nil value clears the override so the key falls back to the
+// deployment default.
+func (r *APIKeyRepository) SetRunRetentionDays(ctx context.Context, id uuid.UUID, days *int) error {
+	if err := domain.ValidateRunRetentionDays(days); err != nil {
+		return err
+	}
+
+	if err := r.updateAPIKeyField(ctx, id, "run_retention_days", days); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("set run retention failed", "api_key_id", id, "error", err)
+		}
+		return err
+	}
+
+	r.logger.Info("run retention updated", "api_key_id", id, "run_retention_days", days)
+	return nil
+}
+
+// SetMonthlyBudget caps the key's spend per calendar month (UTC) and returns
+// the new budget with the month-to-date spend. A nil value removes the cap.
+func (r *APIKeyRepository) SetMonthlyBudget(ctx context.Context, id uuid.UUID, usd *float64) (domain.MonthlyBudget, error) {
+	if err := domain.ValidateMonthlyBudget(usd); err != nil {
+		return domain.MonthlyBudget{}, err
+	}
+
+	if err := r.updateAPIKeyField(ctx, id, "monthly_budget_usd", usd); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("set monthly budget failed", "api_key_id", id, "error", err)
+		}
+		return domain.MonthlyBudget{}, err
+	}
+
+	monthly, err := budget.Load(ctx, r.pool, id, nowUTC(r.clock))
+	if err != nil {
+		r.logger.Error("read monthly budget failed", "api_key_id", id, "error", err)
+		return domain.MonthlyBudget{}, err
+	}
+
+	r.logger.Info("monthly budget updated", "api_key_id", id, "monthly_budget_usd", usd)
+	return monthly, nil
+}
+
+// SetSchedulingWeight sets the share of shared-worker claims one key gets
+// relative to the other keys' weights.
+func (r *APIKeyRepository) SetSchedulingWeight(ctx context.Context, id uuid.UUID, weight int) error {
+	if err := domain.ValidateSchedulingWeight(weight); err != nil {
+		return err
+	}
+
+	if err := r.updateAPIKeyField(ctx, id, "scheduling_weight", weight); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("set scheduling weight failed", "api_key_id", id, "error", err)
+		}
+		return err
+	}
+
+	r.logger.Info("scheduling weight updated", "api_key_id", id, "scheduling_weight", weight)
+	return nil
+}
+
+// SetTemplateRunLimits replaces one key's per-template run caps and returns
+// the stored map. An empty map removes every cap.
+func (r *APIKeyRepository) SetTemplateRunLimits(ctx context.Context, id uuid.UUID, limits map[string]int) (map[string]int, error) {
+	normalized, err := domain.NormalizeTemplateRunLimits(limits)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.updateAPIKeyField(ctx, id, "max_concurrent_runs_per_template", normalized); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("set template run limits failed", "api_key_id", id, "error", err)
+		}
+		return nil, err
+	}
+
+	r.logger.Info("template run limits updated", "api_key_id", id, "max_concurrent_runs_per_template", normalized)
+	return normalized, nil
+}
+
+// ListTenantScheduling returns every active key's scheduling weight and claim
+// counter, oldest key first.
+func (r *APIKeyRepository) ListTenantScheduling(ctx context.Context) ([]domain.TenantScheduling, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT k.id, k.name, k.slug, k.scheduling_weight, COALESCE(c.claims, 0), c.last_claimed_at
+		FROM api_keys k
+		LEFT JOIN tenant_claim_counters c ON c.api_key_id = k.id
+		WHERE k.revoked_at IS NULL
+		  AND (k.expires_at IS NULL OR k.expires_at > $1)
+		ORDER BY k.created_at ASC, k.id ASC
+	`, nowUTC(r.clock))
+	if err != nil {
+		r.logger.Error("list tenant scheduling query failed", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := make([]domain.TenantScheduling, 0, 32)
+	for rows.Next() {
+		var t domain.TenantScheduling
+		if err := rows.Scan(&t.APIKeyID, &t.Name, &t.Slug, &t.SchedulingWeight, &t.Claims, &t.LastClaimedAt); err != nil {
+			return nil, err
+		}
+		if t.LastClaimedAt != nil {
+			utc := t.LastClaimedAt.UTC()
+			t.LastClaimedAt = &utc
+		}
+		tenants = append(tenants, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	domain.FillSchedulingShares(tenants)
+	return tenants, nil
+}
+
+// SetScopes replaces the scopes granted to one key and returns the stored,
+// normalized list. A nil list restores full access.
+func (r *APIKeyRepository) SetScopes(ctx context.Context, id uuid.UUID, scopes []string) ([]string, error) {
+	normalized, err := domain.NormalizeScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.updateAPIKeyField(ctx, id, "scopes", normalized); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("set api key scopes failed", "api_key_id", id, "error", err)
+		}
+		return nil, err
+	}
+
+	r.logger.Info("api key scopes updated", "api_key_id", id, "scopes", normalized)
+	return normalized, nil
+}
+
+// SetAllowedCIDRs replaces the client IP allow-list of one key and returns the
+// stored, normalized list. A nil or empty list allows any address.
+func (r *APIKeyRepository) SetAllowedCIDRs(ctx context.Context, id uuid.UUID, cidrs []string) ([]string, error) {
+	normalized, err := domain.NormalizeAllowedCIDRs(cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.updateAPIKeyField(ctx, id, "allowed_cidrs", normalized); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("set api key allowed cidrs failed", "api_key_id", id, "error", err)
+		}
+		return nil, err
+	}
+
+	r.logger.Info("api key allowed cidrs updated", "api_key_id", id, "allowed_cidrs", normalized)
+	return normalized, nil
+}
+
+// SetEgressAllowHosts replaces the host allow-list of the HTTP steps of one
+// key's runs and returns the stored, normalized list. A nil or empty list
+// leaves them limited only by the worker's egress policy.
+func (r *APIKeyRepository) SetEgressAllowHosts(ctx context.Context, id uuid.UUID, hosts []string) ([]string, error) {
+	normalized, err := egress.NormalizeHostRules(hosts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidEgressAllowHost, err)
+	}
+
+	if err := r.updateAPIKeyField(ctx, id, "egress_allow_hosts", normalized); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("set api key egress allow hosts failed", "api_key_id", id, "error", err)
+		}
+		return nil, err
+	}
+
+	r.logger.Info("api key egress allow hosts updated", "api_key_id", id, "egress_allow_hosts", normalized)
+	return normalized, nil
+}
+
+// SetWebhookDefaults replaces the webhook URL and secret that runs created by
+// this key inherit when the request does not supply its own. A generated
+// secret is returned once in the result and never readable afterwards.
+func (r *APIKeyRepository) SetWebhookDefaults(ctx context.Context, id uuid.UUID, params domain.SetWebhookDefaultsParams) (domain.WebhookDefaults, error) {
+	webhookURL := strings.TrimSpace(params.WebhookURL)
+	webhookSecret := params.WebhookSecret
+	if params.GenerateSecret && webhookSecret != "" {
+		return domain.WebhookDefaults{}, domain.ErrInvalidWebhookSecret
+	}
+	if err := domain.ValidateWebhookSecret(webhookSecret); err != nil {
+		return domain.WebhookDefaults{}, err
+	}
+
+	result := domain.WebhookDefaults{APIKeyID: id}
+	if params.GenerateSecret {
+		generated, err := generateWebhookSecret()
+		if err != nil {
+			r.logger.Error("generate webhook secret failed", "api_key_id", id, "error", err)
+			return domain.WebhookDefaults{}, err
+		}
+		webhookSecret = generated
+		result.WebhookSecret = generated
+	}
+	storedSecret, err := r.keyring.SealString(secrets.FieldWebhookSecret, webhookSecret)
+	if err != nil {
+		r.logger.Error("seal webhook secret failed", "api_key_id", id, "error", err)
+		return domain.WebhookDefaults{}, err
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		r.logger.Error("begin tx failed", "error", err)
+		return domain.WebhookDefaults{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		previousURL       *string
+		previousHasSecret bool
+	)
+	if err := tx.QueryRow(ctx, `
+		SELECT default_webhook_url, default_webhook_secret IS NOT NULL
+		FROM api_keys
+		WHERE id = $1 AND revoked_at IS NULL
+		FOR UPDATE
+	`, id).Scan(&previousURL, &previousHasSecret); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("read webhook defaults failed", "api_key_id", id, "error", err)
+		}
+		return domain.WebhookDefaults{}, notFound(err, domain.ErrAPIKeyNotFound)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE api_keys
+		SET default_webhook_url = $2,
+		    default_webhook_secret = $3
+		WHERE id = $1
+	`, id, nullString(webhookURL), nullString(storedSecret)); err != nil {
+		r.logger.Error("set webhook defaults failed", "api_key_id", id, "error", err)
+		return domain.WebhookDefaults{}, err
+	}
+
+	if webhookURL != "" {
+		result.WebhookURL = &webhookURL
+	}
+	result.HasWebhookSecret = webhookSecret != ""
+
+	if err := audit.Record(ctx, tx, nowUTC(r.clock), audit.Change{
+		Action:     domain.AuditAPIKeyUpdate,
+		APIKeyID:   id,
+		TargetType: domain.AuditTargetAPIKey,
+		TargetID:   id.String(),
+		Before: map[string]any{
+			"default_webhook_url":        previousURL,
+			"has_default_webhook_secret": previousHasSecret,
+		},
+		After: map[string]any{
+			"default_webhook_url":        result.WebhookURL,
+			"has_default_webhook_secret": result.HasWebhookSecret,
+		},
+	}); err != nil {
+		r.logger.Error("record webhook defaults change failed", "api_key_id", id, "error", err)
+		return domain.WebhookDefaults{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("commit failed", "api_key_id", id, "error", err)
+		return domain.WebhookDefaults{}, err
+	}
+
+	r.logger.Info("webhook defaults updated",
+		"api_key_id", id,
+		"has_webhook_url", webhookURL != "",
+		"has_webhook_secret", result.HasWebhookSecret,
+	)
+	return result, nil
+}
+
+// RotateWebhookSigningKey adds a new signing key version for the tenant and
+// makes every unexpired older version expire after overlap (or sooner, if it
+// already expires earlier). The new secret is returned once.
+func (r *APIKeyRepository) RotateWebhookSigningKey(ctx context.Context, id uuid.UUID, overlap time.Duration) (domain.WebhookSigningKey, error) {
+	if err := domain.ValidateWebhookSigningKeyOverlap(overlap); err != nil {
+		return domain.WebhookSigningKey{}, err
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		r.logger.Error("generate webhook signing key failed", "api_key_id", id, "error", err)
+		return domain.WebhookSigningKey{}, err
+	}
+	storedSecret, err := r.keyring.SealString(secrets.FieldWebhookSecret, secret)
+	if err != nil {
+		r.logger.Error("seal webhook signing key failed", "api_key_id", id, "error", err)
+		return domain.WebhookSigningKey{}, err
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		r.logger.Error("begin tx failed", "error", err)
+		return domain.WebhookSigningKey{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the key row so concurrent rotations pick distinct versions.
+	var exists int
+	if err := tx.QueryRow(ctx,
+		`SELECT 1 FROM api_keys WHERE id=$1 AND revoked_at IS NULL FOR UPDATE`,
+		id,
+	).Scan(&exists); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("lock api key failed", "api_key_id", id, "error", err)
+		}
+		return domain.WebhookSigningKey{}, notFound(err, domain.ErrAPIKeyNotFound)
+	}
+
+	now := nowUTC(r.clock)
+	retireAt := now.Add(overlap)
+	if _, err := tx.Exec(ctx, `
+		UPDATE webhook_signing_keys
+		SET expires_at = LEAST(COALESCE(expires_at, $2), $2)
+		WHERE api_key_id = $1
+		  AND (expires_at IS NULL OR expires_at > $3)
+	`, id, retireAt, now); err != nil {
+		r.logger.Error("expire previous webhook signing keys failed", "api_key_id", id, "error", err)
+		return domain.WebhookSigningKey{}, err
+	}
+
+	key := domain.WebhookSigningKey{Secret: secret, CreatedAt: now}
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO webhook_signing_keys (api_key_id, version, secret, created_at)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3
+		FROM webhook_signing_keys
+		WHERE api_key_id = $1
+		RETURNING version
+	`, id, storedSecret, now).Scan(&key.Version); err != nil {
+		r.logger.Error("insert webhook signing key failed", "api_key_id", id, "error", err)
+		return domain.WebhookSigningKey{}, err
+	}
+	key.KeyID = domain.WebhookSigningKeyID(key.Version)
+
+	if err := audit.Record(ctx, tx, now, audit.Change{
+		Action:     domain.AuditWebhookSecretRotate,
+		APIKeyID:   id,
+		TargetType: domain.AuditTargetAPIKey,
+		TargetID:   id.String(),
+		After: map[string]any{
+			"key_id":             key.KeyID,
+			"previous_expire_at": retireAt,
+		},
+	}); err != nil {
+		r.logger.Error("record webhook signing key rotation failed", "api_key_id", id, "error", err)
+		return domain.WebhookSigningKey{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("commit failed", "api_key_id", id, "error", err)
+		return domain.WebhookSigningKey{}, err
+	}
+
+	r.logger.Info("webhook signing key rotated",
+		"api_key_id", id,
+		"key_id", key.KeyID,
+		"previous_expire_at", retireAt,
+	)
+	return key, nil
+}
+
+// ListWebhookSigningKeys returns every signing key version of the tenant,
+// newest first, without secrets.
+func (r *APIKeyRepository) ListWebhookSigningKeys(ctx context.Context, id uuid.UUID) ([]domain.WebhookSigningKey, error) {
+	var exists int
+	if err := r.pool.QueryRow(ctx,
+		`SELECT 1 FROM api_keys WHERE id=$1 AND revoked_at IS NULL`,
+		id,
+	).Scan(&exists); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("api key lookup failed", "api_key_id", id, "error", err)
+		}
+		return nil, notFound(err, domain.ErrAPIKeyNotFound)
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT version, created_at, expires_at
+		FROM webhook_signing_keys
+		WHERE api_key_id = $1
+		ORDER BY version DESC
+	`, id)
+	if err != nil {
+		r.logger.Error("list webhook signing keys failed", "api_key_id", id, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]domain.WebhookSigningKey, 0, 4)
+	for rows.Next() {
+		var key domain.WebhookSigningKey
+		if err := rows.Scan(&key.Version, &key.CreatedAt, &key.ExpiresAt); err != nil {
+			return nil, err
+		}
+		key.KeyID = domain.WebhookSigningKeyID(key.Version)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// ExpireWebhookSigningKey stops signing with one version immediately, for
+// example after its secret leaked. It returns
+// domain.ErrWebhookSigningKeyNotFound when the version does not exist or has
+// already expired.
+func (r *APIKeyRepository) ExpireWebhookSigningKey(ctx context.Context, id uuid.UUID, version int) error {
+	now := nowUTC(r.clock)
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		r.logger.Error("begin tx failed", "error", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var previousExpiresAt *time.Time
+	if err := tx.QueryRow(ctx, `
+		SELECT expires_at
+		FROM webhook_signing_keys
+		WHERE api_key_id = $1
+		  AND version = $2
+		  AND (expires_at IS NULL OR expires_at > $3)
+		FOR UPDATE
+	`, id, version, now).Scan(&previousExpiresAt); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("read webhook signing key failed", "api_key_id", id, "version", version, "error", err)
+		}
+		return notFound(err, domain.ErrWebhookSigningKeyNotFound)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE webhook_signing_keys
+		SET expires_at = $3
+		WHERE api_key_id = $1 AND version = $2
+	`, id, version, now); err != nil {
+		r.logger.Error("expire webhook signing key failed", "api_key_id", id, "version", version, "error", err)
+		return err
+	}
+
+	if err := audit.Record(ctx, tx, now, audit.Change{
+		Action:     domain.AuditWebhookSecretExpire,
+		APIKeyID:   id,
+		TargetType: domain.AuditTargetAPIKey,
+		TargetID:   id.String(),
+		Before:     map[string]any{"key_id": domain.WebhookSigningKeyID(version), "expires_at": previousExpiresAt},
+		After:      map[string]any{"key_id": domain.WebhookSigningKeyID(version), "expires_at": now},
+	}); err != nil {
+		r.logger.Error("record webhook signing key expiry failed", "api_key_id", id, "error", err)
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("commit failed", "api_key_id", id, "error", err)
+		return err
+	}
+
+	r.logger.Info("webhook signing key expired", "api_key_id", id, "key_id", domain.WebhookSigningKeyID(version))
+	return nil
+}
+
 func (r *APIKeyRepository) RevokeAPIKey(ctx context.Context, id uuid.UUID) error {
-	tag, err := r.pool.Exec(ctx, `
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		r.logger.Error("begin tx failed", "error", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	now := nowUTC(r.clock)
+	tag, err := tx.Exec(ctx, `
 		UPDATE api_keys
-		SET revoked_at = NOW()
+		SET revoked_at = $2
 		WHERE id = $1 AND revoked_at IS NULL
-	`, id)
+	`, id, now)
 	if err != nil {
 		r.logger.Error("revoke api key failed", "api_key_id", id, "error", err)
 		return err
 	}
 	if tag.RowsAffected() == 0 {
-		return pgx.ErrNoRows
+		return notFound(pgx.ErrNoRows, domain.ErrAPIKeyNotFound)
+	}
+
+	if err := audit.Record(ctx, tx, now, audit.Change{
+		Action:     domain.AuditAPIKeyRevoke,
+		APIKeyID:   id,
+		TargetType: domain.AuditTargetAPIKey,
+		TargetID:   id.String(),
+	}); err != nil {
+		r.logger.Error("record api key revocation failed", "api_key_id", id, "error", err)
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("commit failed", "api_key_id", id, "error", err)
+		return err
 	}
 	return nil
 }
 
-func generateAPIKeyToken() (string, string, error) {
+// updateAPIKeyField sets one column of a live key and records the previous
+// and new values in the audit log, in one transaction. column must be a
+// trusted identifier, never request input. It returns
+// domain.ErrAPIKeyNotFound when the key does not exist or is revoked.
+func (r *APIKeyRepository) updateAPIKeyField(ctx context.Context, id uuid.UUID, column string, value any) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var before json.RawMessage
+	if err := tx.QueryRow(ctx,
+		`SELECT to_jsonb(`+column+`) FROM api_keys WHERE id = $1 AND revoked_at IS NULL FOR UPDATE`,
+		id,
+	).Scan(&before); err != nil {
+		return notFound(err, domain.ErrAPIKeyNotFound)
+	}
+	if before == nil {
+		before = json.RawMessage("null")
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE api_keys SET `+column+` = $2 WHERE id = $1`, id, value); err != nil {
+		return err
+	}
+
+	if err := audit.Record(ctx, tx, nowUTC(r.clock), audit.Change{
+		Action:     domain.AuditAPIKeyUpdate,
+		APIKeyID:   id,
+		TargetType: domain.AuditTargetAPIKey,
+		TargetID:   id.String(),
+		Before:     map[string]json.RawMessage{column: before},
+		After:      map[string]any{column: value},
+	}); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func generateAPIKeyToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return "sk_live_" + hex.EncodeToString(raw), nil
+}
+
+// keyedHashPrefix marks token hashes keyed with the pepper; the others are
+// the legacy hex SHA-256 of the token.
+const keyedHashPrefix = "hmac-sha256:"
+
+// tokenHash is the hash a new token is stored under.
+func (r *APIKeyRepository) tokenHash(token string) string {
+	if r.pepper == nil {
+		return sha256Hex(token)
+	}
+	return r.keyedHash(sha256Hex(token))
+}
+
+// keyedHash keys a legacy hash with the pepper. Keying the legacy hash rather
+// than the token lets RehashLegacyTokens convert stored hashes without the
+// tokens.
+func (r *APIKeyRepository) keyedHash(legacyHash string) string {
+	mac := hmac.New(sha256.New, r.pepper)
+	mac.Write([]byte(legacyHash))
+	return keyedHashPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// RehashLegacyTokens keys every unkeyed token hash, revoked keys included,
+// with the pepper, batchSize rows at a time, and returns how many it
+// converted. Run it once every API process has the pepper: processes without
+// it no longer resolve converted keys.
+func (r *APIKeyRepository) RehashLegacyTokens(ctx context.Context, batchSize int) (int64, error) {
+	if r.pepper == nil {
+		return 0, errors.New("no token pepper configured")
+	}
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+
+	var total int64
+	for {
+		rows, err := r.pool.Query(ctx,
+			`SELECT id, token_hash FROM api_keys WHERE NOT starts_with(token_hash, $1) LIMIT $2`,
+			keyedHashPrefix, batchSize,
+		)
+		if err != nil {
+			return total, err
+		}
+		type legacy struct {
+			id   uuid.UUID
+			hash string
+		}
+		batch, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (legacy, error) {
+			var l legacy
+			return l, row.Scan(&l.id, &l.hash)
+		})
+		if err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+
+		for _, l := range batch {
+			// A hash changed meanwhile is left to the next pass.
+			tag, err := r.pool.Exec(ctx,
+				`UPDATE api_keys SET token_hash = $3 WHERE id = $1 AND token_hash = $2`,
+				l.id, l.hash, r.keyedHash(l.hash),
+			)
+			if err != nil {
+				r.logger.Error("rehash api key token failed", "api_key_id", l.id, "error", err)
+				return total, err
+			}
+			total += tag.RowsAffected()
+		}
+	}
+}
+
+func generateWebhookSecret() (string, error) {
 	raw := make([]byte, 32)
 	if _, err := rand.Read(raw); err != nil {
-		return "", "", err
+		return "", err
 	}
-	token := "sk_live_" + hex.EncodeToString(raw)
-	return token, sha256Hex(token), nil
+	return "whsec_" + hex.EncodeToString(raw), nil
 }
 
 func sha256Hex(input string) string {
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AuditRepository reads the audit log for admins. Entries are written by the
+// repositories that make the changes, through package audit.
+type AuditRepository struct {
+	pool   *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func NewAuditRepository(pool *pgxpool.Pool, logger *slog.Logger) *AuditRepository {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &AuditRepository{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// ListAuditEntries returns the entries matching filter, newest first, at most
+// filter.Limit of them (DefaultAuditPageSize when unset, capped at
+// MaxAuditPageSize).
+func (r *AuditRepository) ListAuditEntries(ctx context.Context, filter domain.AuditFilter) ([]domain.AuditEntry, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = domain.DefaultAuditPageSize
+	}
+	limit = min(limit, domain.MaxAuditPageSize)
+
+	var (
+		conds []string
+		args  []any
+	)
+	where := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if filter.APIKeyID != nil {
+		where("api_key_id = ?", *filter.APIKeyID)
+	}
+	if filter.Action != "" {
+		where("action = ?", filter.Action)
+	}
+	if filter.ActorType != "" {
+		where("actor_type = ?", filter.ActorType)
+	}
+	if filter.TargetID != "" {
+		where("target_id = ?", filter.TargetID)
+	}
+	if filter.Since != nil {
+		where("created_at >= ?", filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		where("created_at < ?", filter.Until.UTC())
+	}
+	if filter.BeforeSeq > 0 {
+		where("seq < ?", filter.BeforeSeq)
+	}
+
+	query := `
+		SELECT seq, id, created_at, actor_type, actor_id, api_key_id, action, target_type, target_id, ip, request_id, before, after
+		FROM audit_log`
+	if len(conds) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, limit)
+	query += "\n\t\tORDER BY seq DESC\n\t\tLIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		r.logger.Error("list audit entries failed", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]domain.AuditEntry, 0, 32)
+	for rows.Next() {
+		var entry domain.AuditEntry
+		if err := rows.Scan(
+			&entry.Seq,
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.ActorType,
+			&entry.ActorID,
+			&entry.APIKeyID,
+			&entry.Action,
+			&entry.TargetType,
+			&entry.TargetID,
+			&entry.IP,
+			&entry.RequestID,
+			&entry.Before,
+			&entry.After,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,15 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/clock"
+)
+
+// nowUTC reads c (the wall clock when nil) in UTC, the zone the TIMESTAMP
+// columns are written in.
+func nowUTC(c clock.Clock) time.Time {
+	return clock.OrReal(c).Now().UTC()
+}
@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// encryptedColumn is a column the keyring seals. Its rows are addressed by
+// key for re-sealing.
+type encryptedColumn struct {
+	table  string
+	column string
+	field  string
+	key    []string
+	json   bool
+}
+
+// encryptedColumns lists every column sealed at rest.
+var encryptedColumns = []encryptedColumn{
+	{table: "steps", column: "input", field: secrets.FieldStepInput, key: []string{"id"}, json: true},
+	{table: "steps", column: "input_override", field: secrets.FieldStepInputOverride, key: []string{"id"}, json: true},
+	{table: "steps", column: "item", field: secrets.FieldStepItem, key: []string{"id"}, json: true},
+	{table: "steps", column: "output", field: secrets.FieldStepOutput, key: []string{"id"}, json: true},
+	{table: "runs", column: "webhook_secret", field: secrets.FieldWebhookSecret, key: []string{"id"}},
+	{table: "api_keys", column: "default_webhook_secret", field: secrets.FieldWebhookSecret, key: []string{"id"}},
+	{table: "webhook_signing_keys", column: "secret", field: secrets.FieldWebhookSecret, key: []string{"api_key_id", "version"}},
+}
+
+// EncryptionRepository re-seals encrypted columns after a key rotation.
+type EncryptionRepository struct {
+	pool    *pgxpool.Pool
+	logger  *slog.Logger
+	keyring *secrets.Keyring
+}
+
+func NewEncryptionRepository(pool *pgxpool.Pool, logger *slog.Logger) *EncryptionRepository {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &EncryptionRepository{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// WithKeyring sets the keyring Rekey opens and seals with.
+func (r *EncryptionRepository) WithKeyring(keyring *secrets.Keyring) *EncryptionRepository {
+	r.keyring = keyring
+	return r
+}
+
+// Rekey seals every value of the encrypted columns that is not yet sealed
+// under the keyring's active key, batchSize rows at a time: values sealed
+// under older keys and plaintext written before encryption was turned on.
+// Once it returns, keys other than the active one can be retired. It returns
+// the number of values re-sealed, and fails on the first value none of the
+// keyring's keys opens.
+func (r *EncryptionRepository) Rekey(ctx context.Context, batchSize int) (int64, error) {
+	if r.keyring == nil {
+		return 0, errors.New("no encryption keys configured")
+	}
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+
+	var total int64
+	for _, col := range encryptedColumns {
+		n, err := r.rekeyColumn(ctx, col, batchSize)
+		total += n
+		if err != nil {
+			r.logger.Error("rekey failed", "column", col.table+"."+col.column, "rekeyed", n, "error", err)
+			return total, err
+		}
+		r.logger.Info("column rekeyed", "column", col.table+"."+col.column, "rekeyed", n, "key_id", r.keyring.ActiveKeyID())
+	}
+	return total, nil
+}
+
+// rekeyColumn re-seals col in batches until no row is left outside the
+// active key. Each update only applies if the value is unchanged, so a row
+// rewritten meanwhile is simply picked up again.
+func (r *EncryptionRepository) rekeyColumn(ctx context.Context, col encryptedColumn, batchSize int) (int64, error) {
+	keys := strings.Join(col.key, ", ")
+	stale := fmt.Sprintf("COALESCE(%s->'%s'->>'key_id', '') <> $1", col.column, secrets.EnvelopeKey)
+	marker := r.keyring.ActiveKeyID()
+	cast := "::jsonb"
+	if !col.json {
+		stale = fmt.Sprintf("NOT starts_with(%s, $1)", col.column)
+		marker = secrets.SealedStringPrefix(marker)
+		cast = ""
+	}
+	selectQuery := fmt.Sprintf(`SELECT %s, %s::text FROM %s WHERE %s IS NOT NULL AND %s LIMIT $2`,
+		keys, col.column, col.table, col.column, stale)
+
+	where := make([]string, 0, len(col.key)+1)
+	for i, k := range col.key {
+		where = append(where, fmt.Sprintf("%s = $%d", k, i+1))
+	}
+	where = append(where, fmt.Sprintf("%s = $%d%s", col.column, len(col.key)+1, cast))
+	updateQuery := fmt.Sprintf(`UPDATE %s SET %s = $%d%s WHERE %s`,
+		col.table, col.column, len(col.key)+2, cast, strings.Join(where, " AND "))
+
+	var total int64
+	for {
+		rows, err := r.pool.Query(ctx, selectQuery, marker, batchSize)
+		if err != nil {
+			return total, err
+		}
+		batch, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) ([]any, error) {
+			return row.Values()
+		})
+		if err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+
+		for _, values := range batch {
+			current := values[len(values)-1].(string)
+			resealed, err := r.reseal(col, current)
+			if err != nil {
+				return total, fmt.Errorf("%s.%s: %w", col.table, col.column, err)
+			}
+			args := append(values[:len(values)-1:len(values)-1], current, resealed)
+			tag, err := r.pool.Exec(ctx, updateQuery, args...)
+			if err != nil {
+				return total, err
+			}
+			total += tag.RowsAffected()
+		}
+	}
+}
+
+// reseal opens value under whichever key sealed it and seals it under the
+// active key.
+func (r *EncryptionRepository) reseal(col encryptedColumn, value string) (string, error) {
+	if !col.json {
+		plain, err := r.keyring.OpenString(col.field, value)
+		if err != nil {
+			return "", err
+		}
+		return r.keyring.SealString(col.field, plain)
+	}
+	plain, err := r.keyring.OpenJSON(col.field, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	sealed, err := r.keyring.SealJSON(col.field, plain)
+	return string(sealed), err
+}
@@ -4,16 +4,34 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"strings"
+	"time"
 
+	"github.com/adiadia/agent-runtime/internal/clock"
 	"github.com/adiadia/agent-runtime/internal/domain"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+const (
+	eventPartitionPrefix = "events_p"
+	eventPartitionLayout = "200601"
+
+	// eventPartitionLockTimeout bounds how long partition DDL waits for the
+	// events table lock, so it never queues event reads and writes behind it.
+	eventPartitionLockTimeout = "1s"
+)
+
 type EventRepository struct {
-	pool   *pgxpool.Pool
-	logger *slog.Logger
+	pool    *pgxpool.Pool
+	logger  *slog.Logger
+	clock   clock.Clock
+	replica *ReadReplica
 }
 
 func NewEventRepository(pool *pgxpool.Pool, logger *slog.Logger) *EventRepository {
@@ -27,6 +45,20 @@ func NewEventRepository(pool *pgxpool.Pool, logger *slog.Logger) *EventRepositor
 	}
 }
 
+// WithClock sets the clock event retention cutoffs and the months of event
+// partitions are measured from.
+func (r *EventRepository) WithClock(c clock.Clock) *EventRepository {
+	r.clock = c
+	return r
+}
+
+// WithReadReplica serves event reads (ListEventsAfter and
+// ResolveCursorByEventID) from replica.
+func (r *EventRepository) WithReadReplica(replica *ReadReplica) *EventRepository {
+	r.replica = replica
+	return r
+}
+
 func (r *EventRepository) ListEventsAfter(ctx context.Context, runID uuid.UUID, afterSeq int64) ([]domain.EventRecord, error) {
 	apiKeyID, err := apiKeyIDFromContext(ctx)
 	if err != nil {
@@ -34,13 +66,23 @@ func (r *EventRepository) ListEventsAfter(ctx context.Context, runID uuid.UUID,
 		return nil, err
 	}
 
-	rows, err := r.pool.Query(ctx, `
+	var out []domain.EventRecord
+	err = r.replica.read(ctx, r.pool, func(q querier) error {
+		out, err = r.listEventsAfter(ctx, q, runID, apiKeyID, afterSeq)
+		return err
+	})
+	return out, err
+}
+
+func (r *EventRepository) listEventsAfter(ctx context.Context, q querier, runID, apiKeyID uuid.UUID, afterSeq int64) ([]domain.EventRecord, error) {
+	rows, err := q.Query(ctx, `
 		SELECT e.id, e.seq, e.run_id, e.type, e.payload, e.created_at
 		FROM events e
 		JOIN runs r ON e.run_id = r.id
 		WHERE e.run_id=$1
 		  AND r.api_key_id=$2
 		  AND e.seq > $3
+		  AND e.created_at >= r.created_at
 		ORDER BY e.seq ASC
 	`,
 		runID,
@@ -98,26 +140,262 @@ func (r *EventRepository) ResolveCursorByEventID(ctx context.Context, runID uuid
 	}
 
 	var seq int64
-	if err := r.pool.QueryRow(ctx, `
-		SELECT e.seq
-		FROM events e
-		JOIN runs r ON e.run_id = r.id
-		WHERE e.id=$1
-		  AND e.run_id=$2
-		  AND r.api_key_id=$3
-	`,
-		eventID,
-		runID,
-		apiKeyID,
-	).Scan(&seq); err != nil {
+	if err := r.replica.read(ctx, r.pool, func(q querier) error {
+		return q.QueryRow(ctx, `
+			SELECT e.seq
+			FROM events e
+			JOIN runs r ON e.run_id = r.id
+			WHERE e.id=$1
+			  AND e.run_id=$2
+			  AND r.api_key_id=$3
+			  AND e.created_at >= r.created_at
+		`,
+			eventID,
+			runID,
+			apiKeyID,
+		).Scan(&seq)
+	}); err != nil {
 		r.logger.Error("resolve event cursor failed",
 			"run_id", runID,
 			"event_id", eventID,
 			"api_key_id", apiKeyID,
 			"error", err,
 		)
-		return 0, err
+		return 0, notFound(err, domain.ErrEventNotFound)
 	}
 
 	return seq, nil
 }
+
+// PruneExpiredEvents deletes events of terminal runs that are older than the
+// owning API key's effective retention. defaultRetentionDays applies to keys
+// without an override (0 keeps their events forever). Rows are deleted in
+// batches of batchSize so a large backlog does not hold long locks.
+func (r *EventRepository) PruneExpiredEvents(ctx context.Context, defaultRetentionDays int, batchSize int) (int64, error) {
+	if defaultRetentionDays < 0 {
+		defaultRetentionDays = 0
+	}
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	now := nowUTC(r.clock)
+	var total int64
+	for {
+		tag, err := r.pool.Exec(ctx, `
+			DELETE FROM events
+			WHERE (seq, created_at) IN (
+				SELECT e.seq, e.created_at
+				FROM events e
+				JOIN runs r ON r.id = e.run_id
+				JOIN api_keys k ON k.id = r.api_key_id
+				WHERE r.status IN ($2, $3, $4)
+				  AND COALESCE(k.event_retention_days, $1) > 0
+				  AND e.created_at < $6::timestamp - make_interval(days => COALESCE(k.event_retention_days, $1))
+				LIMIT $5
+			)
+		`,
+			defaultRetentionDays,
+			domain.RunSuccess,
+			domain.RunFailed,
+			domain.RunCanceled,
+			batchSize,
+			now,
+		)
+		if err != nil {
+			r.logger.Error("prune expired events failed",
+				"default_retention_days", defaultRetentionDays,
+				"pruned_so_far", total,
+				"error", err,
+			)
+			return total, err
+		}
+
+		total += tag.RowsAffected()
+		if tag.RowsAffected() < int64(batchSize) {
+			break
+		}
+	}
+
+	if total > 0 {
+		r.logger.Info("expired events pruned",
+			"count", total,
+			"default_retention_days", defaultRetentionDays,
+		)
+	}
+	return total, nil
+}
+
+// EnsureEventPartitions creates the monthly events partitions for the current
+// month and the next monthsAhead months if they are missing, and returns how
+// many it created. Events for months without a partition land in
+// events_default, so a late pass loses nothing. A month whose rows already
+// sit in events_default cannot get its own partition; that error is returned
+// and the remaining months are still tried.
+func (r *EventRepository) EnsureEventPartitions(ctx context.Context, monthsAhead int) (int, error) {
+	if monthsAhead < 0 {
+		monthsAhead = 0
+	}
+
+	current := monthStart(nowUTC(r.clock))
+	created := 0
+	var errs []error
+	for i := 0; i <= monthsAhead; i++ {
+		month := current.AddDate(0, i, 0)
+		name := eventPartitionName(month)
+
+		var existing *string
+		if err := r.pool.QueryRow(ctx, `SELECT to_regclass($1)::text`, "public."+name).Scan(&existing); err != nil {
+			r.logger.Error("look up event partition failed", "partition", name, "error", err)
+			return created, err
+		}
+		if existing != nil {
+			continue
+		}
+
+		err := r.withEventsLock(ctx, func(tx pgx.Tx) error {
+			_, err := tx.Exec(ctx, fmt.Sprintf(
+				`CREATE TABLE IF NOT EXISTS %s PARTITION OF events FOR VALUES FROM ('%s') TO ('%s')`,
+				pgx.Identifier{name}.Sanitize(),
+				month.Format(time.DateOnly),
+				month.AddDate(0, 1, 0).Format(time.DateOnly),
+			))
+			return err
+		})
+		if err != nil {
+			r.logger.Error("create event partition failed", "partition", name, "error", err)
+			errs = append(errs, fmt.Errorf("create %s: %w", name, err))
+			continue
+		}
+		created++
+		r.logger.Info("event partition created", "partition", name)
+	}
+
+	return created, errors.Join(errs...)
+}
+
+// DropEmptyEventPartitions drops monthly events partitions for months before
+// the current one that retention pruning has emptied, and returns how many
+// it dropped. New events never land in past months, so an empty one stays
+// empty. Partitions whose lock is busy are left for the next pass.
+func (r *EventRepository) DropEmptyEventPartitions(ctx context.Context) (int, error) {
+	partitions, err := r.listEventPartitions(ctx)
+	if err != nil {
+		r.logger.Error("list event partitions failed", "error", err)
+		return 0, err
+	}
+
+	current := monthStart(nowUTC(r.clock))
+	dropped := 0
+	for _, name := range partitions {
+		month, ok := parseEventPartitionName(name)
+		if !ok || !month.Before(current) {
+			continue
+		}
+
+		var removed bool
+		err := r.withEventsLock(ctx, func(tx pgx.Tx) error {
+			table := pgx.Identifier{name}.Sanitize()
+			if _, err := tx.Exec(ctx, `LOCK TABLE events, `+table+` IN ACCESS EXCLUSIVE MODE`); err != nil {
+				return err
+			}
+			var hasRows bool
+			if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM `+table+`)`).Scan(&hasRows); err != nil {
+				return err
+			}
+			if hasRows {
+				return nil
+			}
+			if _, err := tx.Exec(ctx, `DROP TABLE `+table); err != nil {
+				return err
+			}
+			removed = true
+			return nil
+		})
+		if isLockNotAvailable(err) {
+			r.logger.Warn("event partition busy; retrying next pass", "partition", name)
+			continue
+		}
+		if err != nil {
+			r.logger.Error("drop event partition failed", "partition", name, "dropped_so_far", dropped, "error", err)
+			return dropped, err
+		}
+		if removed {
+			dropped++
+			r.logger.Info("empty event partition dropped", "partition", name)
+		}
+	}
+
+	return dropped, nil
+}
+
+func (r *EventRepository) listEventPartitions(ctx context.Context) ([]string, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'public.events'::regclass
+		ORDER BY c.relname
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0, 8)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// withEventsLock runs fn in a transaction whose lock waits give up after
+// eventPartitionLockTimeout.
+func (r *EventRepository) withEventsLock(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SET LOCAL lock_timeout = '`+eventPartitionLockTimeout+`'`); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// eventPartitionName is the partition holding events created in month.
+func eventPartitionName(month time.Time) string {
+	return eventPartitionPrefix + month.UTC().Format(eventPartitionLayout)
+}
+
+// parseEventPartitionName returns the month of a monthly events partition;
+// ok is false for any other table, such as events_default.
+func parseEventPartitionName(name string) (time.Time, bool) {
+	suffix, found := strings.CutPrefix(name, eventPartitionPrefix)
+	if !found || len(suffix) != len(eventPartitionLayout) {
+		return time.Time{}, false
+	}
+	month, err := time.Parse(eventPartitionLayout, suffix)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return month, true
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func isLockNotAvailable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "55P03"
+}
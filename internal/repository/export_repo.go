@@ -0,0 +1,361 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/ids"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ExportRepository reads runs for export and keeps the tenant-wide export
+// jobs and their artifacts.
+type ExportRepository struct {
+	pool    *pgxpool.Pool
+	logger  *slog.Logger
+	clock   clock.Clock
+	keyring *secrets.Keyring
+}
+
+func NewExportRepository(pool *pgxpool.Pool, logger *slog.Logger) *ExportRepository {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &ExportRepository{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// WithClock sets the clock used to stamp export jobs.
+func (r *ExportRepository) WithClock(c clock.Clock) *ExportRepository {
+	r.clock = c
+	return r
+}
+
+// WithKeyring decrypts encrypted step input and output into exports.
+func (r *ExportRepository) WithKeyring(keyring *secrets.Keyring) *ExportRepository {
+	r.keyring = keyring
+	return r
+}
+
+// snapshot runs fn in a read-only transaction that sees one snapshot, so a
+// run, its steps, and its events are exported consistently.
+func (r *ExportRepository) snapshot(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		r.logger.Error("begin export tx failed", "error", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ExportRun returns the tenant's run with its steps and events, or
+// domain.ErrRunNotFound.
+func (r *ExportRepository) ExportRun(ctx context.Context, runID uuid.UUID) (domain.RunExport, error) {
+	apiKeyID, err := apiKeyIDFromContext(ctx)
+	if err != nil {
+		r.logger.Warn("export run denied: missing api key id", "run_id", runID, "error", err)
+		return domain.RunExport{}, err
+	}
+
+	var export domain.RunExport
+	err = r.snapshot(ctx, func(tx pgx.Tx) error {
+		export, err = r.exportRun(ctx, tx, apiKeyID, runID)
+		return err
+	})
+	return export, notFound(err, domain.ErrRunNotFound)
+}
+
+// ExportRunPage returns up to limit of the tenant's runs with IDs above
+// after, in ID order, with their steps and events. Pass the last ID of a
+// page as after to read the next one.
+func (r *ExportRepository) ExportRunPage(ctx context.Context, apiKeyID, after uuid.UUID, limit int) ([]domain.RunExport, error) {
+	var page []domain.RunExport
+	err := r.snapshot(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT id FROM runs
+			WHERE api_key_id = $1 AND id > $2
+			ORDER BY id
+			LIMIT $3
+		`, apiKeyID, after, limit)
+		if err != nil {
+			r.logger.Error("list runs for export failed", "api_key_id", apiKeyID, "error", err)
+			return err
+		}
+		runIDs, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+		if err != nil {
+			r.logger.Error("scan runs for export failed", "api_key_id", apiKeyID, "error", err)
+			return err
+		}
+
+		page = make([]domain.RunExport, 0, len(runIDs))
+		for _, runID := range runIDs {
+			export, err := r.exportRun(ctx, tx, apiKeyID, runID)
+			if err != nil {
+				return err
+			}
+			page = append(page, export)
+		}
+		return nil
+	})
+	return page, err
+}
+
+func (r *ExportRepository) exportRun(ctx context.Context, tx pgx.Tx, apiKeyID, runID uuid.UUID) (domain.RunExport, error) {
+	run, err := scanRunDetail(tx.QueryRow(ctx,
+		`SELECT `+runDetailColumns+` FROM runs WHERE id = $1 AND api_key_id = $2`,
+		runID,
+		apiKeyID,
+	))
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("read run for export failed", "run_id", runID, "api_key_id", apiKeyID, "error", err)
+		}
+		return domain.RunExport{}, err
+	}
+	export := domain.RunExport{Run: run}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, parent_step_id, name, status, attempts, input, output,
+		       cost_usd::double precision, created_at, started_at, finished_at
+		FROM steps
+		WHERE run_id = $1
+		ORDER BY position ASC, map_index ASC NULLS FIRST, created_at ASC
+	`, runID)
+	if err != nil {
+		r.logger.Error("read steps for export failed", "run_id", runID, "error", err)
+		return domain.RunExport{}, err
+	}
+	export.Steps, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.ExportedStep, error) {
+		var st domain.ExportedStep
+		err := row.Scan(
+			&st.ID,
+			&st.ParentStepID,
+			&st.Name,
+			&st.Status,
+			&st.Attempts,
+			&st.Input,
+			&st.Output,
+			&st.CostUSD,
+			&st.CreatedAt,
+			&st.StartedAt,
+			&st.FinishedAt,
+		)
+		if err != nil {
+			return st, err
+		}
+		if st.Input, err = r.keyring.OpenJSON(secrets.FieldStepInput, st.Input); err != nil {
+			return st, err
+		}
+		st.Output, err = r.keyring.OpenJSON(secrets.FieldStepOutput, st.Output)
+		return st, err
+	})
+	if err != nil {
+		r.logger.Error("scan steps for export failed", "run_id", runID, "error", err)
+		return domain.RunExport{}, err
+	}
+
+	// Events are partitioned by created_at; none predates its run.
+	rows, err = tx.Query(ctx, `
+		SELECT id, seq, run_id, type, payload, created_at
+		FROM events
+		WHERE run_id = $1 AND created_at >= $2
+		ORDER BY seq ASC
+	`, runID, run.CreatedAt)
+	if err != nil {
+		r.logger.Error("read events for export failed", "run_id", runID, "error", err)
+		return domain.RunExport{}, err
+	}
+	export.Events, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.EventRecord, error) {
+		var ev domain.EventRecord
+		err := row.Scan(&ev.ID, &ev.Seq, &ev.RunID, &ev.Type, &ev.Payload, &ev.CreatedAt)
+		return ev, err
+	})
+	if err != nil {
+		r.logger.Error("scan events for export failed", "run_id", runID, "error", err)
+		return domain.RunExport{}, err
+	}
+
+	return export, nil
+}
+
+// exportJobColumns are the export_jobs columns scanExportJob reads, in order.
+const exportJobColumns = `id, api_key_id, format, status, runs_exported, artifact_bytes, artifact_sha256,
+	error, created_at, started_at, finished_at`
+
+func scanExportJob(row pgx.Row) (domain.ExportJob, error) {
+	var job domain.ExportJob
+	err := row.Scan(
+		&job.ID,
+		&job.APIKeyID,
+		&job.Format,
+		&job.Status,
+		&job.RunsExported,
+		&job.ArtifactBytes,
+		&job.ArtifactSHA256,
+		&job.Error,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+	)
+	return job, err
+}
+
+// CreateExportJob queues an export of all the tenant's runs in format.
+func (r *ExportRepository) CreateExportJob(ctx context.Context, format string) (domain.ExportJob, error) {
+	apiKeyID, err := apiKeyIDFromContext(ctx)
+	if err != nil {
+		r.logger.Warn("create export denied: missing api key id", "error", err)
+		return domain.ExportJob{}, err
+	}
+
+	job, err := scanExportJob(r.pool.QueryRow(ctx, `
+		INSERT INTO export_jobs (id, api_key_id, format, status, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+exportJobColumns,
+		ids.New(),
+		apiKeyID,
+		format,
+		domain.ExportPending,
+		nowUTC(r.clock),
+	))
+	if err != nil {
+		r.logger.Error("create export job failed", "api_key_id", apiKeyID, "error", err)
+		return domain.ExportJob{}, err
+	}
+
+	r.logger.Info("export job created", "export_id", job.ID, "api_key_id", apiKeyID, "format", format)
+	return job, nil
+}
+
+// GetExportJob returns one of the tenant's export jobs.
+func (r *ExportRepository) GetExportJob(ctx context.Context, id uuid.UUID) (domain.ExportJob, error) {
+	apiKeyID, err := apiKeyIDFromContext(ctx)
+	if err != nil {
+		r.logger.Warn("get export denied: missing api key id", "export_id", id, "error", err)
+		return domain.ExportJob{}, err
+	}
+
+	job, err := scanExportJob(r.pool.QueryRow(ctx,
+		`SELECT `+exportJobColumns+` FROM export_jobs WHERE id = $1 AND api_key_id = $2`,
+		id,
+		apiKeyID,
+	))
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		r.logger.Error("get export job failed", "export_id", id, "api_key_id", apiKeyID, "error", err)
+	}
+	return job, notFound(err, domain.ErrExportNotFound)
+}
+
+// GetExportArtifact returns one of the tenant's export jobs with its
+// artifact, or domain.ErrExportNotReady while the job has not succeeded.
+func (r *ExportRepository) GetExportArtifact(ctx context.Context, id uuid.UUID) (domain.ExportJob, []byte, error) {
+	job, err := r.GetExportJob(ctx, id)
+	if err != nil {
+		return domain.ExportJob{}, nil, err
+	}
+	if job.Status != domain.ExportSucceeded {
+		return job, nil, domain.ErrExportNotReady
+	}
+
+	var artifact []byte
+	if err := r.pool.QueryRow(ctx,
+		`SELECT artifact FROM export_jobs WHERE id = $1 AND api_key_id = $2`,
+		id,
+		job.APIKeyID,
+	).Scan(&artifact); err != nil {
+		r.logger.Error("read export artifact failed", "export_id", id, "error", err)
+		return domain.ExportJob{}, nil, err
+	}
+	return job, artifact, nil
+}
+
+// ClaimExportJob marks the oldest pending export job RUNNING and returns it,
+// taking over a RUNNING one left for longer than domain.ExportJobStaleAfter.
+// It reports false when there is none.
+func (r *ExportRepository) ClaimExportJob(ctx context.Context) (domain.ExportJob, bool, error) {
+	now := nowUTC(r.clock)
+	job, err := scanExportJob(r.pool.QueryRow(ctx, `
+		UPDATE export_jobs
+		SET status = $1, started_at = $2
+		WHERE id = (
+			SELECT id FROM export_jobs
+			WHERE status = $3 OR (status = $1 AND started_at < $4)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+exportJobColumns,
+		domain.ExportRunning,
+		now,
+		domain.ExportPending,
+		now.Add(-domain.ExportJobStaleAfter),
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.ExportJob{}, false, nil
+	}
+	if err != nil {
+		r.logger.Error("claim export job failed", "error", err)
+		return domain.ExportJob{}, false, err
+	}
+	return job, true, nil
+}
+
+// CompleteExportJob stores a RUNNING job's artifact and marks it SUCCEEDED.
+func (r *ExportRepository) CompleteExportJob(ctx context.Context, id uuid.UUID, runs int, artifact []byte) error {
+	sum := sha256.Sum256(artifact)
+	_, err := r.pool.Exec(ctx, `
+		UPDATE export_jobs
+		SET status = $2, runs_exported = $3, artifact = $4, artifact_bytes = $5, artifact_sha256 = $6, finished_at = $7
+		WHERE id = $1 AND status = $8
+	`,
+		id,
+		domain.ExportSucceeded,
+		runs,
+		artifact,
+		len(artifact),
+		hex.EncodeToString(sum[:]),
+		nowUTC(r.clock),
+		domain.ExportRunning,
+	)
+	if err != nil {
+		r.logger.Error("complete export job failed", "export_id", id, "error", err)
+	}
+	return err
+}
+
+// FailExportJob marks a RUNNING job FAILED with reason.
+func (r *ExportRepository) FailExportJob(ctx context.Context, id uuid.UUID, reason string) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE export_jobs
+		SET status = $2, error = $3, finished_at = $4
+		WHERE id = $1 AND status = $5
+	`,
+		id,
+		domain.ExportFailed,
+		reason,
+		nowUTC(r.clock),
+		domain.ExportRunning,
+	)
+	if err != nil {
+		r.logger.Error("fail export job failed", "export_id", id, "error", err)
+	}
+	return err
+}
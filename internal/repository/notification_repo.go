@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// NotificationRepository finds the waiting approvals and failed runs the
+// notifier has not reported yet, across all tenants.
+type NotificationRepository struct {
+	pool   *pgxpool.Pool
+	logger *slog.Logger
+	clock  clock.Clock
+}
+
+func NewNotificationRepository(pool *pgxpool.Pool, logger *slog.Logger) *NotificationRepository {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &NotificationRepository{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// WithClock sets the clock used to stamp and age notifications.
+func (r *NotificationRepository) WithClock(c clock.Clock) *NotificationRepository {
+	r.clock = c
+	return r
+}
+
+// ClaimNotifications marks up to batchSize unreported waiting approvals and up
+// to batchSize unreported failed runs as notified and returns them. Ones older
+// than maxAge, e.g. left over while no notifier ran, are marked without being
+// returned. A caller that fails to send one hands it back with
+// ReleaseNotification so the next sweep retries it.
+func (r *NotificationRepository) ClaimNotifications(ctx context.Context, maxAge time.Duration, batchSize int) ([]domain.Notification, error) {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	now := nowUTC(r.clock)
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	approvals, err := claimNotifications(ctx, tx, `
+		UPDATE steps s
+		SET approval_notified_at=$1
+		FROM runs r
+		WHERE r.id = s.run_id
+		  AND s.id IN (
+			SELECT s2.id
+			FROM steps s2
+			JOIN runs r2 ON r2.id = s2.run_id
+			WHERE s2.status = $2
+			  AND s2.name = $3
+			  AND s2.approval_notified_at IS NULL
+			  AND r2.status NOT IN ($4, $5, $6)
+			ORDER BY s2.waiting_since ASC
+			LIMIT $7
+			FOR UPDATE OF s2 SKIP LOCKED
+		  )
+		RETURNING s.run_id, r.api_key_id, s.id, COALESCE(s.approval_name, ''), '', s.waiting_since
+	`,
+		domain.NotificationApprovalWaiting,
+		now,
+		domain.StepWaiting,
+		domain.StepApproval,
+		domain.RunSuccess,
+		domain.RunFailed,
+		domain.RunCanceled,
+		batchSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	failures, err := claimNotifications(ctx, tx, `
+		UPDATE runs r
+		SET failure_notified_at=$1
+		WHERE r.id IN (
+			SELECT r2.id
+			FROM runs r2
+			WHERE r2.status = $2
+			  AND r2.failure_notified_at IS NULL
+			ORDER BY r2.updated_at ASC
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING r.id, r.api_key_id, NULL::uuid, '', COALESCE((
+			SELECT e.payload->>'error'
+			FROM events e
+			WHERE e.run_id = r.id
+			  AND e.type = $4
+			ORDER BY e.seq DESC
+			LIMIT 1
+		), ''), r.updated_at
+	`,
+		domain.NotificationRunFailed,
+		now,
+		domain.RunFailed,
+		batchSize,
+		domain.EventStepFailed,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	cutoff := now.Add(-maxAge)
+	claimed := make([]domain.Notification, 0, len(approvals)+len(failures))
+	var stale int
+	for _, n := range append(approvals, failures...) {
+		if maxAge > 0 && n.At.Before(cutoff) {
+			stale++
+			continue
+		}
+		claimed = append(claimed, n)
+	}
+	if stale > 0 {
+		r.logger.Info("stale notifications skipped", "count", stale, "max_age", maxAge)
+	}
+	return claimed, nil
+}
+
+func claimNotifications(ctx context.Context, tx pgx.Tx, query string, kind domain.NotificationKind, args ...any) ([]domain.Notification, error) {
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claimed []domain.Notification
+	for rows.Next() {
+		n := domain.Notification{Kind: kind}
+		var stepID *uuid.UUID
+		if err := rows.Scan(&n.RunID, &n.APIKeyID, &stepID, &n.ApprovalName, &n.Error, &n.At); err != nil {
+			return nil, err
+		}
+		n.StepID = stepID
+		claimed = append(claimed, n)
+	}
+	return claimed, rows.Err()
+}
+
+// ReleaseNotification clears the notified marker of a claimed notification so
+// the next sweep claims it again.
+func (r *NotificationRepository) ReleaseNotification(ctx context.Context, n domain.Notification) error {
+	var err error
+	switch n.Kind {
+	case domain.NotificationApprovalWaiting:
+		_, err = r.pool.Exec(ctx, `UPDATE steps SET approval_notified_at=NULL WHERE id=$1`, n.StepID)
+	default:
+		_, err = r.pool.Exec(ctx, `UPDATE runs SET failure_notified_at=NULL WHERE id=$1`, n.RunID)
+	}
+	if err != nil {
+		r.logger.Error("release notification failed", "kind", n.Kind, "run_id", n.RunID, "error", err)
+	}
+	return err
+}
@@ -0,0 +1,194 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// outboxClaimLockID serializes outbox claims across relays, so a run's
+// messages are never split between two relays and published out of order.
+const outboxClaimLockID int64 = 0x6f7574626f78 // "outbox"
+
+// OutboxRepository hands outbox_messages to the relay.
+type OutboxRepository struct {
+	pool   *pgxpool.Pool
+	logger *slog.Logger
+	clock  clock.Clock
+}
+
+func NewOutboxRepository(pool *pgxpool.Pool, logger *slog.Logger) *OutboxRepository {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &OutboxRepository{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// WithClock sets the clock used for leases and retry times.
+func (r *OutboxRepository) WithClock(c clock.Clock) *OutboxRepository {
+	r.clock = c
+	return r
+}
+
+// ClaimOutboxMessages leases up to batchSize due messages for lease, oldest
+// first. A message is not due while an earlier message of the same run is
+// leased or waiting to be retried, so each run's messages are published in
+// order. The caller deletes, retries, or releases every claimed message; one
+// it drops is claimed again once its lease runs out.
+func (r *OutboxRepository) ClaimOutboxMessages(ctx context.Context, batchSize int, lease time.Duration) ([]domain.OutboxMessage, error) {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	now := nowUTC(r.clock)
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, outboxClaimLockID); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, `
+		UPDATE outbox_messages o
+		SET next_attempt_at=$2
+		WHERE o.seq IN (
+			SELECT m.seq
+			FROM outbox_messages m
+			WHERE m.next_attempt_at <= $1
+			  AND NOT EXISTS (
+				SELECT 1
+				FROM outbox_messages p
+				WHERE p.run_id = m.run_id
+				  AND p.seq < m.seq
+				  AND p.next_attempt_at > $1
+			  )
+			ORDER BY m.seq ASC
+			LIMIT $3
+		)
+		RETURNING o.seq, o.id, o.type, o.api_key_id, o.run_id, o.step_id, o.event_id, o.payload, o.created_at, o.attempts
+	`, now, now.Add(lease), batchSize)
+	if err != nil {
+		r.logger.Error("claim outbox messages failed", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claimed []domain.OutboxMessage
+	for rows.Next() {
+		var m domain.OutboxMessage
+		if err := rows.Scan(&m.Seq, &m.ID, &m.Type, &m.APIKeyID, &m.RunID, &m.StepID, &m.EventID, &m.Payload, &m.CreatedAt, &m.Attempts); err != nil {
+			return nil, err
+		}
+		m.CreatedAt = m.CreatedAt.UTC()
+		claimed = append(claimed, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	// UPDATE ... RETURNING does not follow the subquery's order.
+	sortOutboxMessages(claimed)
+	return claimed, nil
+}
+
+// DeleteOutboxMessages removes published messages.
+func (r *OutboxRepository) DeleteOutboxMessages(ctx context.Context, seqs []int64) error {
+	if len(seqs) == 0 {
+		return nil
+	}
+	if _, err := r.pool.Exec(ctx, `DELETE FROM outbox_messages WHERE seq = ANY($1)`, seqs); err != nil {
+		r.logger.Error("delete outbox messages failed", "count", len(seqs), "error", err)
+		return err
+	}
+	return nil
+}
+
+// RetryOutboxMessage records a failed publish and schedules the next attempt.
+func (r *OutboxRepository) RetryOutboxMessage(ctx context.Context, seq int64, at time.Time, lastError string) error {
+	if _, err := r.pool.Exec(ctx, `
+		UPDATE outbox_messages
+		SET attempts=attempts + 1,
+		    next_attempt_at=$2,
+		    last_error=$3
+		WHERE seq=$1
+	`, seq, at.UTC(), lastError); err != nil {
+		r.logger.Error("retry outbox message failed", "seq", seq, "error", err)
+		return err
+	}
+	return nil
+}
+
+// ReleaseOutboxMessages ends the lease on claimed messages that were not
+// attempted, e.g. because an earlier message of their run failed. They stay
+// behind that message until it is published.
+func (r *OutboxRepository) ReleaseOutboxMessages(ctx context.Context, seqs []int64) error {
+	if len(seqs) == 0 {
+		return nil
+	}
+	if _, err := r.pool.Exec(ctx,
+		`UPDATE outbox_messages SET next_attempt_at=$2 WHERE seq = ANY($1)`,
+		seqs, nowUTC(r.clock),
+	); err != nil {
+		r.logger.Error("release outbox messages failed", "count", len(seqs), "error", err)
+		return err
+	}
+	return nil
+}
+
+// PruneOutboxMessages deletes messages created more than maxAge ago that were
+// never published, e.g. because no relay is configured, in batches of
+// batchSize. A maxAge <= 0 keeps them forever.
+func (r *OutboxRepository) PruneOutboxMessages(ctx context.Context, maxAge time.Duration, batchSize int) (int64, error) {
+	if maxAge <= 0 {
+		return 0, nil
+	}
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	cutoff := nowUTC(r.clock).Add(-maxAge)
+	var total int64
+	for {
+		tag, err := r.pool.Exec(ctx, `
+			DELETE FROM outbox_messages
+			WHERE seq IN (
+				SELECT seq FROM outbox_messages
+				WHERE created_at < $1
+				ORDER BY seq ASC
+				LIMIT $2
+			)
+		`, cutoff, batchSize)
+		if err != nil {
+			r.logger.Error("prune outbox messages failed", "error", err)
+			return total, err
+		}
+		total += tag.RowsAffected()
+		if tag.RowsAffected() < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+func sortOutboxMessages(messages []domain.OutboxMessage) {
+	slices.SortFunc(messages, func(a, b domain.OutboxMessage) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+}
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// replicaBackoff is how long reads stay on the primary after the replica
+// fails, so an unreachable replica costs one failed query per period rather
+// than one per request.
+const replicaBackoff = 30 * time.Second
+
+// querier is the read subset shared by *pgxpool.Pool and pgx.Tx.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// ReadReplica sends read-only queries to a replica pool. A read that fails
+// because the replica is unreachable, shutting down, or in recovery conflict
+// is retried on the primary, and reads skip the replica for replicaBackoff
+// afterwards. A read that finds nothing is retried on the primary too, since
+// the row may not have replicated yet. One ReadReplica is shared by every
+// repository so they back off together.
+type ReadReplica struct {
+	pool      querier
+	logger    *slog.Logger
+	clock     clock.Clock
+	downUntil atomic.Int64
+}
+
+func NewReadReplica(pool *pgxpool.Pool, logger *slog.Logger) *ReadReplica {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	r := &ReadReplica{logger: logger}
+	if pool != nil {
+		r.pool = pool
+	}
+	return r
+}
+
+// WithClock sets the clock used for the backoff after a replica failure.
+func (r *ReadReplica) WithClock(c clock.Clock) *ReadReplica {
+	r.clock = c
+	return r
+}
+
+// read runs fn against the replica, or against primary when there is no
+// replica, it is backing off, or fn's result calls for a retry there. fn must
+// only read.
+func (r *ReadReplica) read(ctx context.Context, primary querier, fn func(q querier) error) error {
+	if r == nil || r.pool == nil {
+		return fn(primary)
+	}
+	now := clock.OrReal(r.clock).Now()
+	if now.UnixNano() < r.downUntil.Load() {
+		return fn(primary)
+	}
+
+	err := fn(r.pool)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, pgx.ErrNoRows):
+		metrics.IncReplicaFallback(metrics.ReplicaFallbackNotFound)
+	case replicaUnavailable(ctx, err):
+		if r.downUntil.Swap(now.Add(replicaBackoff).UnixNano()) < now.UnixNano() {
+			r.logger.Warn("read replica unavailable; reading from the primary",
+				"backoff", replicaBackoff.String(),
+				"error", err,
+			)
+		}
+		metrics.IncReplicaFallback(metrics.ReplicaFallbackUnavailable)
+	default:
+		return err
+	}
+	return fn(primary)
+}
+
+// replicaUnavailable reports whether err means the replica could not serve
+// the query, as opposed to the query itself failing.
+func replicaUnavailable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Connection exceptions, operator intervention (shutdown, not yet
+		// accepting connections), and queries canceled by WAL replay.
+		return strings.HasPrefix(pgErr.Code, "08") ||
+			strings.HasPrefix(pgErr.Code, "57P") ||
+			pgErr.Code == "40001"
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		pgconn.SafeToRetry(err)
+}
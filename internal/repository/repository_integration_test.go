@@ -5,17 +5,29 @@
 package repository
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"io"
 	"log/slog"
+	"net/netip"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/adiadia/agent-runtime/internal/audit"
 	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/adiadia/agent-runtime/internal/clock"
 	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/ids"
+	"github.com/adiadia/agent-runtime/internal/secrets"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -75,7 +87,12 @@ func TestRunAndStepRepositoriesIntegration(t *testing.T) {
 		}
 	}
 
-	if err := runRepo.CancelRun(tenantCtx, runID); err != nil {
+	before, err := runRepo.GetRunVersion(tenantCtx, runID)
+	if err != nil {
+		t.Fatalf("get run version: %v", err)
+	}
+
+	if err := runRepo.CancelRun(tenantCtx, runID, ""); err != nil {
 		t.Fatalf("cancel run: %v", err)
 	}
 
@@ -86,6 +103,32 @@ func TestRunAndStepRepositoriesIntegration(t *testing.T) {
 	if status != domain.RunCanceled {
 		t.Fatalf("expected run status %s got %s", domain.RunCanceled, status)
 	}
+
+	after, err := runRepo.GetRunVersion(tenantCtx, runID)
+	if err != nil {
+		t.Fatalf("get run version after cancel: %v", err)
+	}
+	if after.LastEventSeq <= before.LastEventSeq {
+		t.Fatalf("expected cancel to advance the run version, got %+v then %+v", before, after)
+	}
+	if _, err := runRepo.GetRunVersion(auth.WithAPIKeyID(ctx, uuid.New()), runID); !errors.Is(err, domain.ErrRunNotFound) {
+		t.Fatalf("expected another tenant's run version to be not found, got %v", err)
+	}
+
+	var summaryPayload []byte
+	if err := pool.QueryRow(ctx,
+		`SELECT payload FROM events WHERE run_id=$1 AND type=$2`,
+		runID, domain.EventRunSummary,
+	).Scan(&summaryPayload); err != nil {
+		t.Fatalf("query run summary event: %v", err)
+	}
+	var summary domain.RunSummary
+	if err := json.Unmarshal(summaryPayload, &summary); err != nil {
+		t.Fatalf("decode run summary: %v", err)
+	}
+	if summary.Status != domain.RunCanceled || len(summary.Steps) != 3 {
+		t.Fatalf("unexpected cancel summary: %+v", summary)
+	}
 }
 
 func TestApproveRunIntegration(t *testing.T) {
@@ -159,7 +202,7 @@ func TestApproveRunIntegration(t *testing.T) {
 	}
 }
 
-func TestApproveRunRejectsNonWaitingApprovalStep(t *testing.T) {
+func TestApproveLastGateQueuesTerminalWebhook(t *testing.T) {
 	ctx := context.Background()
 	pool := integrationPool(t, ctx)
 	defer pool.Close()
@@ -175,32 +218,55 @@ func TestApproveRunRejectsNonWaitingApprovalStep(t *testing.T) {
 	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	runRepo := NewRunRepository(pool, logger)
+	fake := clock.NewFake(time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC))
+	runRepo := NewRunRepository(pool, logger).WithClock(fake)
 
-	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	// The default LLM -> TOOL -> APPROVAL template ends at its gate.
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{WebhookURL: "https://example.com/hook"})
 	if err != nil {
 		t.Fatalf("create run: %v", err)
 	}
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps
+		SET status = CASE WHEN name=$2 THEN $3 ELSE $4 END
+		WHERE run_id=$1
+	`, runID, domain.StepApproval, domain.StepWaiting, domain.StepSuccess); err != nil {
+		t.Fatalf("settle steps before the gate: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `UPDATE runs SET status=$2 WHERE id=$1`, runID, domain.RunWaiting); err != nil {
+		t.Fatalf("set run waiting: %v", err)
+	}
 
-	err = runRepo.ApproveRun(tenantCtx, runID)
-	if !errors.Is(err, domain.ErrRunNotWaitingApproval) {
-		t.Fatalf("expected ErrRunNotWaitingApproval got %v", err)
+	if err := runRepo.ApproveRun(tenantCtx, runID); err != nil {
+		t.Fatalf("approve run: %v", err)
 	}
 
-	var approvalStatus domain.StepStatus
-	if err := pool.QueryRow(ctx, `
-		SELECT status
-		FROM steps
-		WHERE run_id=$1 AND name=$2
-	`, runID, domain.StepApproval).Scan(&approvalStatus); err != nil {
-		t.Fatalf("query approval step status: %v", err)
+	var (
+		status  domain.RunStatus
+		payload []byte
+	)
+	if err := pool.QueryRow(ctx, `SELECT status FROM runs WHERE id=$1`, runID).Scan(&status); err != nil {
+		t.Fatalf("read run status: %v", err)
 	}
-	if approvalStatus != domain.StepPending {
-		t.Fatalf("expected approval step to remain %s got %s", domain.StepPending, approvalStatus)
+	if status != domain.RunSuccess {
+		t.Fatalf("expected the run %s, got %s", domain.RunSuccess, status)
+	}
+	if err := pool.QueryRow(ctx,
+		`SELECT payload FROM webhook_deliveries WHERE run_id=$1 AND event_type=$2`,
+		runID, "RUN_"+string(domain.RunSuccess),
+	).Scan(&payload); err != nil {
+		t.Fatalf("read terminal webhook delivery: %v", err)
+	}
+	var terminal domain.TerminalWebhookPayload
+	if err := json.Unmarshal(payload, &terminal); err != nil {
+		t.Fatalf("decode terminal webhook payload: %v", err)
+	}
+	if terminal.Status != domain.RunSuccess || !terminal.FinishedAt.Equal(fake.Now()) {
+		t.Fatalf("unexpected terminal webhook payload %s", payload)
 	}
 }
 
-func TestApproveRunIsIdempotentWhenAlreadyApproved(t *testing.T) {
+func TestGetRunTimelineBuildsAttemptSegments(t *testing.T) {
 	ctx := context.Background()
 	pool := integrationPool(t, ctx)
 	defer pool.Close()
@@ -217,26 +283,72 @@ func TestApproveRunIsIdempotentWhenAlreadyApproved(t *testing.T) {
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	runRepo := NewRunRepository(pool, logger)
+	stepRepo := NewStepRepository(pool, logger)
 
 	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
 	if err != nil {
 		t.Fatalf("create run: %v", err)
 	}
 
-	if _, err := pool.Exec(ctx, `
-		UPDATE steps
-		SET status=$2
-		WHERE run_id=$1 AND name=$3
-	`, runID, domain.StepSuccess, domain.StepApproval); err != nil {
-		t.Fatalf("set approval step succeeded: %v", err)
+	var stepID uuid.UUID
+	if err := pool.QueryRow(ctx, `SELECT id FROM steps WHERE run_id=$1 AND name=$2`, runID, domain.StepLLM).Scan(&stepID); err != nil {
+		t.Fatalf("query LLM step: %v", err)
+	}
+
+	for i, ev := range []struct {
+		typ     string
+		payload string
+	}{
+		{domain.EventStepClaimed, `{}`},
+		{domain.EventStepFailedRetry, `{"attempt":1}`},
+		{domain.EventStepClaimed, `{}`},
+		{domain.EventStepSucceeded, `{}`},
+	} {
+		if _, err := pool.Exec(ctx, `
+			INSERT INTO events (id, run_id, step_id, type, payload, created_at)
+			VALUES ($1, $2, $3, $4, $5::jsonb, NOW() + make_interval(secs => $6))
+		`, ids.New(), runID, stepID, ev.typ, ev.payload, i); err != nil {
+			t.Fatalf("insert %s event: %v", ev.typ, err)
+		}
 	}
 
-	if err := runRepo.ApproveRun(tenantCtx, runID); err != nil {
-		t.Fatalf("approve run should be idempotent when already approved, got %v", err)
+	timeline, err := stepRepo.GetRunTimeline(tenantCtx, runID)
+	if err != nil {
+		t.Fatalf("get run timeline: %v", err)
+	}
+	if timeline.RunID != runID || timeline.Status != domain.RunPending {
+		t.Fatalf("unexpected run %s/%s", timeline.RunID, timeline.Status)
+	}
+
+	var llm *domain.StepTimeline
+	for i := range timeline.Steps {
+		if timeline.Steps[i].ID == stepID {
+			llm = &timeline.Steps[i]
+		}
+	}
+	if llm == nil {
+		t.Fatalf("expected step %s in timeline %+v", stepID, timeline.Steps)
+	}
+	if len(llm.Segments) != 2 {
+		t.Fatalf("expected 2 segments got %+v", llm.Segments)
+	}
+	if llm.Segments[0].Attempt != 1 || llm.Segments[0].Outcome != domain.EventStepFailedRetry {
+		t.Fatalf("unexpected first segment %+v", llm.Segments[0])
+	}
+	if llm.Segments[1].Attempt != 2 || llm.Segments[1].Outcome != domain.EventStepSucceeded || llm.Segments[1].FinishedAt == nil {
+		t.Fatalf("unexpected second segment %+v", llm.Segments[1])
+	}
+	if llm.StartedAt == nil || !llm.StartedAt.Equal(llm.Segments[0].StartedAt) {
+		t.Fatalf("expected step start from its first segment, got %v", llm.StartedAt)
+	}
+
+	otherCtx := auth.WithAPIKeyID(ctx, uuid.New())
+	if _, err := stepRepo.GetRunTimeline(otherCtx, runID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows for another tenant, got %v", err)
 	}
 }
 
-func TestRepositoryEnforcesRunOwnership(t *testing.T) {
+func TestEscalateWaitingApprovalsRaisesLevelAndPriority(t *testing.T) {
 	ctx := context.Background()
 	pool := integrationPool(t, ctx)
 	defer pool.Close()
@@ -245,45 +357,88 @@ func TestRepositoryEnforcesRunOwnership(t *testing.T) {
 		t.Skipf("skip integration test: database not reachable (%v)", err)
 	}
 
-	apiKeyA, err := createIntegrationAPIKey(ctx, pool)
-	if err != nil {
-		t.Fatalf("create api key A: %v", err)
-	}
-	apiKeyB, err := createIntegrationAPIKey(ctx, pool)
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
 	if err != nil {
-		t.Fatalf("create api key B: %v", err)
+		t.Fatalf("create api key: %v", err)
 	}
-
-	ctxA := auth.WithAPIKeyID(ctx, apiKeyA)
-	ctxB := auth.WithAPIKeyID(ctx, apiKeyB)
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	runRepo := NewRunRepository(pool, logger)
-	stepRepo := NewStepRepository(pool, logger)
+	fake := clock.NewFake(time.Now().UTC().Truncate(time.Second))
+	runRepo := NewRunRepository(pool, logger).WithClock(fake)
 
-	runID, err := runRepo.CreateRun(ctxA, domain.CreateRunParams{})
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
+		WebhookURL:    "https://example.com/hook",
+		WebhookEvents: []string{domain.EventApprovalEscalated},
+	})
 	if err != nil {
 		t.Fatalf("create run: %v", err)
 	}
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps
+		SET status=$2, waiting_since=$4
+		WHERE run_id=$1 AND name=$3
+	`, runID, domain.StepWaiting, domain.StepApproval, fake.Now().Add(-5*time.Hour)); err != nil {
+		t.Fatalf("set approval step waiting: %v", err)
+	}
 
-	if _, err := runRepo.GetRun(ctxB, runID); !errors.Is(err, pgx.ErrNoRows) {
-		t.Fatalf("expected pgx.ErrNoRows for GetRun with wrong tenant, got %v", err)
+	thresholds := []time.Duration{time.Hour, 4 * time.Hour, 24 * time.Hour}
+
+	// Five hours in, the step jumps straight to level 2 with one event.
+	escalated, err := runRepo.EscalateWaitingApprovals(ctx, thresholds, 10, 100)
+	if err != nil {
+		t.Fatalf("escalate: %v", err)
+	}
+	if escalated != 1 {
+		t.Fatalf("expected 1 escalation got %d", escalated)
+	}
+	if again, err := runRepo.EscalateWaitingApprovals(ctx, thresholds, 10, 100); err != nil || again != 0 {
+		t.Fatalf("expected no repeat escalation, got %d (err=%v)", again, err)
 	}
 
-	if _, err := stepRepo.ListSteps(ctxB, runID); !errors.Is(err, pgx.ErrNoRows) {
-		t.Fatalf("expected pgx.ErrNoRows for ListSteps with wrong tenant, got %v", err)
+	fake.Advance(20 * time.Hour)
+	if escalated, err := runRepo.EscalateWaitingApprovals(ctx, thresholds, 10, 100); err != nil || escalated != 1 {
+		t.Fatalf("expected level 3 escalation, got %d (err=%v)", escalated, err)
 	}
 
-	if err := runRepo.CancelRun(ctxB, runID); !errors.Is(err, pgx.ErrNoRows) {
-		t.Fatalf("expected pgx.ErrNoRows for CancelRun with wrong tenant, got %v", err)
+	var (
+		level    int
+		priority int
+	)
+	if err := pool.QueryRow(ctx, `
+		SELECT s.escalation_level, r.priority
+		FROM steps s
+		JOIN runs r ON r.id = s.run_id
+		WHERE s.run_id=$1 AND s.name=$2
+	`, runID, domain.StepApproval).Scan(&level, &priority); err != nil {
+		t.Fatalf("read escalation state: %v", err)
+	}
+	if level != 3 || priority != 20 {
+		t.Fatalf("expected level 3 and priority 20, got level %d priority %d", level, priority)
 	}
 
-	if err := runRepo.ApproveRun(ctxB, runID); !errors.Is(err, pgx.ErrNoRows) {
-		t.Fatalf("expected pgx.ErrNoRows for ApproveRun with wrong tenant, got %v", err)
+	var (
+		events     int
+		deliveries int
+	)
+	if err := pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM events WHERE run_id=$1 AND type=$2`,
+		runID, domain.EventApprovalEscalated,
+	).Scan(&events); err != nil {
+		t.Fatalf("count escalation events: %v", err)
+	}
+	if err := pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM webhook_deliveries WHERE run_id=$1 AND event_type=$2`,
+		runID, domain.EventApprovalEscalated,
+	).Scan(&deliveries); err != nil {
+		t.Fatalf("count escalation deliveries: %v", err)
+	}
+	if events != 2 || deliveries != 2 {
+		t.Fatalf("expected 2 escalation events and deliveries, got %d and %d", events, deliveries)
 	}
 }
 
-func TestCreateRunRespectsMaxConcurrentRuns(t *testing.T) {
+func TestTimeOutWaitingApprovalsAppliesAction(t *testing.T) {
 	ctx := context.Background()
 	pool := integrationPool(t, ctx)
 	defer pool.Close()
@@ -296,38 +451,92 @@ func TestCreateRunRespectsMaxConcurrentRuns(t *testing.T) {
 	if err != nil {
 		t.Fatalf("create api key: %v", err)
 	}
-
-	if _, err := pool.Exec(ctx, `
-		UPDATE api_keys
-		SET max_concurrent_runs=1
-		WHERE id=$1
-	`, apiKeyID); err != nil {
-		t.Fatalf("set api key max_concurrent_runs: %v", err)
-	}
-
 	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
-	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	runRepo := NewRunRepository(pool, logger)
 
-	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
-	if err != nil {
-		t.Fatalf("create first run: %v", err)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fake := clock.NewFake(time.Now().UTC().Truncate(time.Second))
+	runRepo := NewRunRepository(pool, logger).WithClock(fake)
+
+	want := map[domain.ApprovalTimeoutAction]struct {
+		step domain.StepStatus
+		run  domain.RunStatus
+	}{
+		domain.ApprovalTimeoutFail:    {step: domain.StepFailed, run: domain.RunFailed},
+		domain.ApprovalTimeoutApprove: {step: domain.StepSuccess, run: domain.RunSuccess},
+	}
+	runs := make(map[domain.ApprovalTimeoutAction]uuid.UUID, len(want))
+	for action := range want {
+		runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
+			WebhookURL:    "https://example.com/hook",
+			WebhookEvents: []string{domain.EventApprovalTimedOut},
+		})
+		if err != nil {
+			t.Fatalf("create run: %v", err)
+		}
+		if _, err := pool.Exec(ctx, `UPDATE runs SET status=$2 WHERE id=$1`, runID, domain.RunRunning); err != nil {
+			t.Fatalf("set run running: %v", err)
+		}
+		if _, err := pool.Exec(ctx, `
+			UPDATE steps
+			SET status = CASE WHEN name=$3 THEN $4 ELSE $5 END,
+			    waiting_since = CASE WHEN name=$3 THEN $6::timestamptz END,
+			    approval_timeout_seconds = CASE WHEN name=$3 THEN 3600 END,
+			    approval_timeout_action = CASE WHEN name=$3 THEN $2 END
+			WHERE run_id=$1
+		`, runID, action, domain.StepApproval, domain.StepWaiting, domain.StepSuccess, fake.Now().Add(-30*time.Minute)); err != nil {
+			t.Fatalf("set approval step waiting: %v", err)
+		}
+		runs[action] = runID
 	}
 
-	if _, err := pool.Exec(ctx, `
-		UPDATE runs
-		SET status=$2
-		WHERE id=$1
-	`, runID, domain.RunRunning); err != nil {
-		t.Fatalf("mark first run running: %v", err)
+	if timedOut, err := runRepo.TimeOutWaitingApprovals(ctx, 100); err != nil || timedOut != 0 {
+		t.Fatalf("expected no timeout before the deadline, got %d (err=%v)", timedOut, err)
 	}
 
-	if _, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{}); !errors.Is(err, domain.ErrMaxConcurrentRunsExceeded) {
-		t.Fatalf("expected ErrMaxConcurrentRunsExceeded, got %v", err)
+	fake.Advance(time.Hour)
+	timedOut, err := runRepo.TimeOutWaitingApprovals(ctx, 100)
+	if err != nil {
+		t.Fatalf("time out approvals: %v", err)
+	}
+	if timedOut != 2 {
+		t.Fatalf("expected 2 timeouts got %d", timedOut)
+	}
+
+	for action, runID := range runs {
+		var (
+			stepStatus domain.StepStatus
+			runStatus  domain.RunStatus
+		)
+		if err := pool.QueryRow(ctx, `
+			SELECT s.status, r.status
+			FROM steps s
+			JOIN runs r ON r.id = s.run_id
+			WHERE s.run_id=$1 AND s.name=$2
+		`, runID, domain.StepApproval).Scan(&stepStatus, &runStatus); err != nil {
+			t.Fatalf("read %s state: %v", action, err)
+		}
+		if stepStatus != want[action].step || runStatus != want[action].run {
+			t.Fatalf("%s: expected step %s run %s, got step %s run %s", action, want[action].step, want[action].run, stepStatus, runStatus)
+		}
+
+		var (
+			timeoutDeliveries  int
+			terminalDeliveries int
+		)
+		if err := pool.QueryRow(ctx,
+			`SELECT COUNT(*) FILTER (WHERE event_type=$2), COUNT(*) FILTER (WHERE event_type=$3)
+			 FROM webhook_deliveries WHERE run_id=$1`,
+			runID, domain.EventApprovalTimedOut, "RUN_"+string(want[action].run),
+		).Scan(&timeoutDeliveries, &terminalDeliveries); err != nil {
+			t.Fatalf("count %s deliveries: %v", action, err)
+		}
+		if timeoutDeliveries != 1 || terminalDeliveries != 1 {
+			t.Fatalf("%s: expected one timeout and one terminal delivery, got %d and %d", action, timeoutDeliveries, terminalDeliveries)
+		}
 	}
 }
 
-func TestCreateRunWithSameIdempotencyKeyReturnsSameRunID(t *testing.T) {
+func TestApproveRunRejectsNonWaitingApprovalStep(t *testing.T) {
 	ctx := context.Background()
 	pool := integrationPool(t, ctx)
 	defer pool.Close()
@@ -340,53 +549,35 @@ func TestCreateRunWithSameIdempotencyKeyReturnsSameRunID(t *testing.T) {
 	if err != nil {
 		t.Fatalf("create api key: %v", err)
 	}
-
 	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
-	idempotentCtx := auth.WithIdempotencyKey(tenantCtx, "idem-same-key")
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	runRepo := NewRunRepository(pool, logger)
 
-	firstRunID, err := runRepo.CreateRun(idempotentCtx, domain.CreateRunParams{})
-	if err != nil {
-		t.Fatalf("create first run: %v", err)
-	}
-
-	secondRunID, err := runRepo.CreateRun(idempotentCtx, domain.CreateRunParams{})
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
 	if err != nil {
-		t.Fatalf("create second run: %v", err)
-	}
-
-	if firstRunID != secondRunID {
-		t.Fatalf("expected same run id for repeated idempotency key, got %s and %s", firstRunID, secondRunID)
+		t.Fatalf("create run: %v", err)
 	}
 
-	var runsCount int
-	if err := pool.QueryRow(ctx, `
-		SELECT COUNT(*)
-		FROM runs
-		WHERE api_key_id=$1
-	`, apiKeyID).Scan(&runsCount); err != nil {
-		t.Fatalf("count runs: %v", err)
-	}
-	if runsCount != 1 {
-		t.Fatalf("expected exactly 1 run row, got %d", runsCount)
+	err = runRepo.ApproveRun(tenantCtx, runID)
+	if !errors.Is(err, domain.ErrRunNotWaitingApproval) {
+		t.Fatalf("expected ErrRunNotWaitingApproval got %v", err)
 	}
 
-	var reqCount int
+	var approvalStatus domain.StepStatus
 	if err := pool.QueryRow(ctx, `
-		SELECT COUNT(*)
-		FROM run_requests
-		WHERE api_key_id=$1 AND idempotency_key=$2
-	`, apiKeyID, "idem-same-key").Scan(&reqCount); err != nil {
-		t.Fatalf("count run_requests: %v", err)
+		SELECT status
+		FROM steps
+		WHERE run_id=$1 AND name=$2
+	`, runID, domain.StepApproval).Scan(&approvalStatus); err != nil {
+		t.Fatalf("query approval step status: %v", err)
 	}
-	if reqCount != 1 {
-		t.Fatalf("expected exactly 1 run_requests row, got %d", reqCount)
+	if approvalStatus != domain.StepPending {
+		t.Fatalf("expected approval step to remain %s got %s", domain.StepPending, approvalStatus)
 	}
 }
 
-func TestCreateRunPersistsWebhookURLAndRunCostBreakdown(t *testing.T) {
+func TestApproveRunIsIdempotentWhenAlreadyApproved(t *testing.T) {
 	ctx := context.Background()
 	pool := integrationPool(t, ctx)
 	defer pool.Close()
@@ -399,69 +590,73 @@ func TestCreateRunPersistsWebhookURLAndRunCostBreakdown(t *testing.T) {
 	if err != nil {
 		t.Fatalf("create api key: %v", err)
 	}
-
 	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	runRepo := NewRunRepository(pool, logger)
 
-	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
-		WebhookURL: "https://example.com/hook",
-	})
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
 	if err != nil {
 		t.Fatalf("create run: %v", err)
 	}
 
-	var webhookURL string
-	if err := pool.QueryRow(ctx, `
-		SELECT webhook_url
-		FROM runs
-		WHERE id=$1
-	`, runID).Scan(&webhookURL); err != nil {
-		t.Fatalf("query webhook url: %v", err)
-	}
-	if webhookURL != "https://example.com/hook" {
-		t.Fatalf("expected webhook_url to persist, got %q", webhookURL)
-	}
-
-	// simulate billed costs
 	if _, err := pool.Exec(ctx, `
 		UPDATE steps
-		SET cost_usd = 1.250000
-		WHERE run_id=$1 AND name=$2
-	`, runID, domain.StepLLM); err != nil {
-		t.Fatalf("update step cost llm: %v", err)
+		SET status=$2
+		WHERE run_id=$1 AND name=$3
+	`, runID, domain.StepSuccess, domain.StepApproval); err != nil {
+		t.Fatalf("set approval step succeeded: %v", err)
 	}
-	if _, err := pool.Exec(ctx, `
-		UPDATE steps
-		SET cost_usd = 0.750000
-		WHERE run_id=$1 AND name=$2
-	`, runID, domain.StepTool); err != nil {
-		t.Fatalf("update step cost tool: %v", err)
+
+	if err := runRepo.ApproveRun(tenantCtx, runID); err != nil {
+		t.Fatalf("approve run should be idempotent when already approved, got %v", err)
 	}
-	if _, err := pool.Exec(ctx, `
-		UPDATE runs
-		SET total_cost_usd = 2.000000
-		WHERE id=$1
-	`, runID); err != nil {
-		t.Fatalf("update run total cost: %v", err)
+}
+
+func TestApproveRunRejectsTerminalRun(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
 	}
 
-	breakdown, err := runRepo.GetRunCost(tenantCtx, runID)
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
 	if err != nil {
-		t.Fatalf("get run cost: %v", err)
+		t.Fatalf("create api key: %v", err)
 	}
-	if breakdown.RunID != runID {
-		t.Fatalf("expected run id %s got %s", runID, breakdown.RunID)
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
 	}
-	if breakdown.TotalCostUSD != 2.0 {
-		t.Fatalf("expected total cost 2.0 got %f", breakdown.TotalCostUSD)
+
+	if _, err := pool.Exec(ctx, `UPDATE runs SET status=$2 WHERE id=$1`, runID, domain.RunSuccess); err != nil {
+		t.Fatalf("set run succeeded: %v", err)
 	}
-	if len(breakdown.Steps) != 3 {
-		t.Fatalf("expected 3 step costs got %d", len(breakdown.Steps))
+
+	if err := runRepo.ApproveRun(tenantCtx, runID); !errors.Is(err, domain.ErrRunNotWaitingApproval) {
+		t.Fatalf("expected ErrRunNotWaitingApproval for a terminal run, got %v", err)
+	}
+
+	var approvedEvents int
+	if err := pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM events WHERE run_id=$1 AND type=$2`,
+		runID, domain.EventRunApproved,
+	).Scan(&approvedEvents); err != nil {
+		t.Fatalf("count approve events: %v", err)
+	}
+	if approvedEvents != 0 {
+		t.Fatalf("expected no %s event for a terminal run, got %d", domain.EventRunApproved, approvedEvents)
 	}
 }
 
-func TestCreateRunUsesWorkflowTemplateAndPriority(t *testing.T) {
+func TestReconcileStaleRunsRecomputesStatusFromSteps(t *testing.T) {
 	ctx := context.Background()
 	pool := integrationPool(t, ctx)
 	defer pool.Close()
@@ -470,74 +665,2842 @@ func TestCreateRunUsesWorkflowTemplateAndPriority(t *testing.T) {
 		t.Skipf("skip integration test: database not reachable (%v)", err)
 	}
 
-	templateID := uuid.New()
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+
+	driftedRunID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create drifted run: %v", err)
+	}
+	liveRunID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create live run: %v", err)
+	}
+
+	if _, err := pool.Exec(ctx, `UPDATE steps SET status=$2 WHERE run_id=$1`, driftedRunID, domain.StepSuccess); err != nil {
+		t.Fatalf("settle drifted steps: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		UPDATE runs
+		SET status=$2, updated_at=NOW() - INTERVAL '1 hour'
+		WHERE id = ANY($1)
+	`, []uuid.UUID{driftedRunID, liveRunID}, domain.RunRunning); err != nil {
+		t.Fatalf("age runs: %v", err)
+	}
+
+	reconciled, err := runRepo.ReconcileStaleRuns(ctx, 10*time.Minute, 0)
+	if err != nil {
+		t.Fatalf("reconcile stale runs: %v", err)
+	}
+	if reconciled != 1 {
+		t.Fatalf("expected 1 reconciled run, got %d", reconciled)
+	}
+
+	runStatus := func(runID uuid.UUID) domain.RunStatus {
+		t.Helper()
+		var status domain.RunStatus
+		if err := pool.QueryRow(ctx, `SELECT status FROM runs WHERE id=$1`, runID).Scan(&status); err != nil {
+			t.Fatalf("query run status: %v", err)
+		}
+		return status
+	}
+	if got := runStatus(driftedRunID); got != domain.RunSuccess {
+		t.Fatalf("expected drifted run %s got %s", domain.RunSuccess, got)
+	}
+	if got := runStatus(liveRunID); got != domain.RunRunning {
+		t.Fatalf("expected live run to stay %s got %s", domain.RunRunning, got)
+	}
+
+	var reconciliation domain.RunReconciliation
+	if err := pool.QueryRow(ctx,
+		`SELECT payload FROM events WHERE run_id=$1 AND type=$2`,
+		driftedRunID, domain.EventRunReconciled,
+	).Scan(&reconciliation); err != nil {
+		t.Fatalf("query reconciled event: %v", err)
+	}
+	if reconciliation.From != domain.RunRunning || reconciliation.To != domain.RunSuccess {
+		t.Fatalf("unexpected reconciliation payload %+v", reconciliation)
+	}
+
+	var summaries int
+	if err := pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM events WHERE run_id=$1 AND type=$2`,
+		driftedRunID, domain.EventRunSummary,
+	).Scan(&summaries); err != nil {
+		t.Fatalf("count run summaries: %v", err)
+	}
+	if summaries != 1 {
+		t.Fatalf("expected one %s event, got %d", domain.EventRunSummary, summaries)
+	}
+
+	if again, err := runRepo.ReconcileStaleRuns(ctx, 10*time.Minute, 0); err != nil || again != 0 {
+		t.Fatalf("expected second sweep to be a no-op, got %d (err=%v)", again, err)
+	}
+}
+
+func TestListTenantBacklogCountsClaimableSteps(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+
+	for range 2 {
+		runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+		if err != nil {
+			t.Fatalf("create run: %v", err)
+		}
+		if _, err := pool.Exec(ctx,
+			`UPDATE steps SET created_at = created_at - INTERVAL '1 hour' WHERE run_id=$1`,
+			runID,
+		); err != nil {
+			t.Fatalf("age steps: %v", err)
+		}
+	}
+
+	backlog, err := runRepo.ListTenantBacklog(ctx)
+	if err != nil {
+		t.Fatalf("list tenant backlog: %v", err)
+	}
+	if len(backlog) != 1 || backlog[0].APIKeyID != apiKeyID {
+		t.Fatalf("expected backlog for one tenant, got %+v", backlog)
+	}
+	// Only the first step of each run is claimable; later steps wait for it.
+	if backlog[0].Claimable != 2 {
+		t.Fatalf("expected 2 claimable steps, got %d", backlog[0].Claimable)
+	}
+	if backlog[0].Starvation < 59*time.Minute {
+		t.Fatalf("expected starvation of about an hour, got %s", backlog[0].Starvation)
+	}
+}
+
+func TestGetQueueStatsCountsPendingRunnableAndWaiting(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+
+	runIDs := make([]uuid.UUID, 0, 2)
+	for range 2 {
+		runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+		if err != nil {
+			t.Fatalf("create run: %v", err)
+		}
+		if _, err := pool.Exec(ctx,
+			`UPDATE steps SET created_at = created_at - INTERVAL '1 hour' WHERE run_id=$1`,
+			runID,
+		); err != nil {
+			t.Fatalf("age steps: %v", err)
+		}
+		runIDs = append(runIDs, runID)
+	}
+	if _, err := pool.Exec(ctx,
+		`UPDATE steps SET status=$1 WHERE run_id=$2 AND name=$3`,
+		domain.StepWaiting, runIDs[0], domain.StepApproval,
+	); err != nil {
+		t.Fatalf("mark approval waiting: %v", err)
+	}
+
+	stats, err := runRepo.GetQueueStats(ctx)
+	if err != nil {
+		t.Fatalf("get queue stats: %v", err)
+	}
+	// Each default run has two pending non-approval steps, of which only the
+	// first is runnable.
+	if stats.PendingSteps != 4 || stats.RunnableSteps != 2 {
+		t.Fatalf("expected 4 pending and 2 runnable steps, got %+v", stats)
+	}
+	if stats.OldestPendingAge < 59*time.Minute {
+		t.Fatalf("expected oldest pending age of about an hour, got %s", stats.OldestPendingAge)
+	}
+	if stats.WaitingApprovalRuns != 1 {
+		t.Fatalf("expected 1 run waiting approval, got %d", stats.WaitingApprovalRuns)
+	}
+}
+
+func TestAuditLogRecordsChangesWithActor(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	apiKeyRepo := NewAPIKeyRepository(pool, logger)
+	runRepo := NewRunRepository(pool, logger)
+	auditRepo := NewAuditRepository(pool, logger)
+
+	adminCtx := audit.WithActor(ctx, domain.AuditActor{Type: domain.AuditActorAdmin, IP: "10.0.0.1", RequestID: "req-admin"})
+	created, err := apiKeyRepo.CreateAPIKey(adminCtx, domain.CreateAPIKeyParams{Name: "audited"})
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	if _, err := apiKeyRepo.SetScopes(adminCtx, created.ID, []string{domain.ScopeRunsRead, domain.ScopeRunsWrite}); err != nil {
+		t.Fatalf("set scopes: %v", err)
+	}
+
+	tenantCtx := audit.WithActor(auth.WithAPIKeyID(ctx, created.ID), domain.AuditActor{
+		Type:      domain.AuditActorAPIKey,
+		ID:        &created.ID,
+		RequestID: "req-tenant",
+	})
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if err := runRepo.CancelRun(tenantCtx, runID, ""); err != nil {
+		t.Fatalf("cancel run: %v", err)
+	}
+
+	entries, err := auditRepo.ListAuditEntries(ctx, domain.AuditFilter{APIKeyID: &created.ID})
+	if err != nil {
+		t.Fatalf("list audit entries: %v", err)
+	}
+	actions := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+	want := []string{domain.AuditRunCancel, domain.AuditRunCreate, domain.AuditAPIKeyUpdate, domain.AuditAPIKeyCreate}
+	if !slices.Equal(actions, want) {
+		t.Fatalf("expected actions %v newest first, got %v", want, actions)
+	}
+
+	cancel := entries[0]
+	if cancel.ActorType != domain.AuditActorAPIKey || cancel.ActorID == nil || *cancel.ActorID != created.ID {
+		t.Fatalf("expected tenant actor on cancel, got %+v", cancel)
+	}
+	if cancel.TargetID != runID.String() || cancel.RequestID == nil || *cancel.RequestID != "req-tenant" {
+		t.Fatalf("unexpected cancel entry: %+v", cancel)
+	}
+	if !strings.Contains(string(cancel.Before), string(domain.RunPending)) || !strings.Contains(string(cancel.After), string(domain.RunCanceled)) {
+		t.Fatalf("expected PENDING -> CANCELED, got %s -> %s", cancel.Before, cancel.After)
+	}
+
+	scopes := entries[2]
+	if scopes.ActorType != domain.AuditActorAdmin || scopes.IP == nil || *scopes.IP != "10.0.0.1" {
+		t.Fatalf("expected admin actor with ip on scopes change, got %+v", scopes)
+	}
+	var after map[string][]string
+	if err := json.Unmarshal(scopes.After, &after); err != nil {
+		t.Fatalf("decode scopes after: %v", err)
+	}
+	if !slices.Equal(after["scopes"], []string{domain.ScopeRunsRead, domain.ScopeRunsWrite}) {
+		t.Fatalf("unexpected scopes after: %s", scopes.After)
+	}
+
+	page, err := auditRepo.ListAuditEntries(ctx, domain.AuditFilter{
+		ActorType: domain.AuditActorAdmin,
+		BeforeSeq: scopes.Seq,
+		Limit:     10,
+	})
+	if err != nil {
+		t.Fatalf("list admin audit entries: %v", err)
+	}
+	if len(page) != 1 || page[0].Action != domain.AuditAPIKeyCreate {
+		t.Fatalf("expected only the api key creation before the scopes change, got %+v", page)
+	}
+}
+
+func TestRepositoryEnforcesRunOwnership(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyA, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key A: %v", err)
+	}
+	apiKeyB, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key B: %v", err)
+	}
+
+	ctxA := auth.WithAPIKeyID(ctx, apiKeyA)
+	ctxB := auth.WithAPIKeyID(ctx, apiKeyB)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+	stepRepo := NewStepRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(ctxA, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	if _, err := runRepo.GetRun(ctxB, runID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows for GetRun with wrong tenant, got %v", err)
+	}
+
+	if _, err := stepRepo.ListSteps(ctxB, runID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows for ListSteps with wrong tenant, got %v", err)
+	}
+
+	if err := runRepo.CancelRun(ctxB, runID, ""); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows for CancelRun with wrong tenant, got %v", err)
+	}
+
+	if err := runRepo.ApproveRun(ctxB, runID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows for ApproveRun with wrong tenant, got %v", err)
+	}
+}
+
+func TestReplayRunCopiesRunAndLayersStepInputs(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	otherKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+
+	sourceID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
+		Priority: 4,
+		Metadata: map[string]string{"customer": "42"},
+		Tags:     []string{"nightly"},
+	})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	first, err := runRepo.ReplayRun(tenantCtx, sourceID, map[int]json.RawMessage{1: json.RawMessage(`{"prompt":"a","model":"m"}`)})
+	if err != nil {
+		t.Fatalf("replay run: %v", err)
+	}
+	second, err := runRepo.ReplayRun(tenantCtx, first.ID, map[int]json.RawMessage{1: json.RawMessage(`{"prompt":"b"}`)})
+	if err != nil {
+		t.Fatalf("replay replay: %v", err)
+	}
+
+	var (
+		replayedFrom *uuid.UUID
+		priority     int
+		metadata     map[string]string
+		tags         []string
+	)
+	if err := pool.QueryRow(ctx, `SELECT replayed_from_run_id, priority, metadata, tags FROM runs WHERE id = $1`, second.ID).
+		Scan(&replayedFrom, &priority, &metadata, &tags); err != nil {
+		t.Fatalf("read replayed run: %v", err)
+	}
+	if replayedFrom == nil || *replayedFrom != first.ID {
+		t.Fatalf("replayed_from_run_id = %v, want %s", replayedFrom, first.ID)
+	}
+	if priority != 4 || metadata["customer"] != "42" || len(tags) != 1 || tags[0] != "nightly" {
+		t.Fatalf("replay lost the run's inputs: priority=%d metadata=%v tags=%v", priority, metadata, tags)
+	}
+
+	var override map[string]string
+	if err := pool.QueryRow(ctx, `SELECT input_override FROM steps WHERE run_id = $1 AND position = 1`, second.ID).Scan(&override); err != nil {
+		t.Fatalf("read input_override: %v", err)
+	}
+	if override["prompt"] != "b" || override["model"] != "m" {
+		t.Fatalf("input_override = %v, want the first replay's override with prompt replaced", override)
+	}
+
+	if _, err := runRepo.ReplayRun(tenantCtx, sourceID, map[int]json.RawMessage{9: json.RawMessage(`{}`)}); !errors.Is(err, domain.ErrInvalidStepInput) {
+		t.Fatalf("expected ErrInvalidStepInput for a missing position, got %v", err)
+	}
+	if _, err := runRepo.ReplayRun(auth.WithAPIKeyID(ctx, otherKeyID), sourceID, nil); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected ErrNoRows for another tenant's run, got %v", err)
+	}
+}
+
+func TestCancelRunRecordsReason(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+
+	tenantRun, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	adminRun, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	if err := runRepo.CancelRun(tenantCtx, tenantRun, strings.Repeat("x", domain.MaxCancelReasonLength+1)); !errors.Is(err, domain.ErrInvalidCancelReason) {
+		t.Fatalf("expected ErrInvalidCancelReason, got %v", err)
+	}
+	if err := runRepo.CancelRun(tenantCtx, tenantRun, "  superseded  "); err != nil {
+		t.Fatalf("cancel run: %v", err)
+	}
+	// The admin variant needs no tenant in the context.
+	if err := runRepo.CancelRunAsAdmin(ctx, adminRun, ""); err != nil {
+		t.Fatalf("cancel run as admin: %v", err)
+	}
+
+	for _, tc := range []struct {
+		runID      uuid.UUID
+		reason     string
+		canceledBy string
+	}{
+		{tenantRun, "superseded", "api_key"},
+		{adminRun, domain.CancelReasonAdminRequest, "admin"},
+	} {
+		var reason string
+		if err := pool.QueryRow(ctx, `SELECT cancel_reason FROM runs WHERE id = $1`, tc.runID).Scan(&reason); err != nil {
+			t.Fatalf("read cancel_reason: %v", err)
+		}
+		if reason != tc.reason {
+			t.Fatalf("cancel_reason = %q, want %q", reason, tc.reason)
+		}
+
+		var payload struct {
+			Reason     string `json:"reason"`
+			CanceledBy string `json:"canceled_by"`
+		}
+		var raw []byte
+		if err := pool.QueryRow(ctx, `
+			SELECT payload FROM events WHERE run_id = $1 AND type = $2
+		`, tc.runID, domain.EventRunCanceled).Scan(&raw); err != nil {
+			t.Fatalf("read cancel event: %v", err)
+		}
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			t.Fatalf("decode cancel event: %v", err)
+		}
+		if payload.Reason != tc.reason || payload.CanceledBy != tc.canceledBy {
+			t.Fatalf("unexpected cancel event payload: %s", raw)
+		}
+	}
+}
+
+func TestAdminRunReadsCrossTenantsAndAreAudited(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyA, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key A: %v", err)
+	}
+	apiKeyB, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key B: %v", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+
+	runA, err := runRepo.CreateRun(auth.WithAPIKeyID(ctx, apiKeyA), domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run A: %v", err)
+	}
+	runB, err := runRepo.CreateRun(auth.WithAPIKeyID(ctx, apiKeyB), domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run B: %v", err)
+	}
+
+	adminCtx := audit.WithActor(ctx, domain.AuditActor{Type: domain.AuditActorAdmin, RequestID: "req-support"})
+
+	all, err := runRepo.AdminListRuns(adminCtx, nil, domain.RunFilter{})
+	if err != nil {
+		t.Fatalf("admin list runs: %v", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("expected runs of both tenants, got %d", len(all))
+	}
+
+	onlyB, err := runRepo.AdminListRuns(adminCtx, &apiKeyB, domain.RunFilter{})
+	if err != nil {
+		t.Fatalf("admin list runs of B: %v", err)
+	}
+	if len(onlyB) != 1 || onlyB[0].ID != runB || onlyB[0].APIKeyID != apiKeyB {
+		t.Fatalf("unexpected runs for tenant B: %+v", onlyB)
+	}
+
+	run, err := runRepo.AdminGetRun(adminCtx, runA)
+	if err != nil {
+		t.Fatalf("admin get run: %v", err)
+	}
+	if run.ID != runA || run.APIKeyID != apiKeyA || run.Status != domain.RunPending {
+		t.Fatalf("unexpected admin run: %+v", run)
+	}
+
+	missing := uuid.New()
+	if _, err := runRepo.AdminGetRun(adminCtx, missing); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows for unknown run, got %v", err)
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT action, actor_type, api_key_id, target_id, request_id
+		FROM audit_log
+		WHERE action IN ($1, $2)
+		ORDER BY seq
+	`, domain.AuditRunList, domain.AuditRunView)
+	if err != nil {
+		t.Fatalf("query audit log: %v", err)
+	}
+	defer rows.Close()
+
+	type entry struct {
+		action, actorType, targetID string
+		apiKeyID                    *uuid.UUID
+		requestID                   *string
+	}
+	var got []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.action, &e.actorType, &e.apiKeyID, &e.targetID, &e.requestID); err != nil {
+			t.Fatalf("scan audit entry: %v", err)
+		}
+		got = append(got, e)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("audit rows: %v", err)
+	}
+
+	want := []struct {
+		action   string
+		apiKeyID *uuid.UUID
+		targetID string
+	}{
+		{domain.AuditRunList, nil, "*"},
+		{domain.AuditRunList, &apiKeyB, apiKeyB.String()},
+		{domain.AuditRunView, &apiKeyA, runA.String()},
+		{domain.AuditRunView, nil, missing.String()},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d audit entries, got %d", len(want), len(got))
+	}
+	for i, w := range want {
+		e := got[i]
+		if e.action != w.action || e.targetID != w.targetID || e.actorType != domain.AuditActorAdmin {
+			t.Fatalf("audit entry %d = %+v, want %+v", i, e, w)
+		}
+		if (e.apiKeyID == nil) != (w.apiKeyID == nil) || (w.apiKeyID != nil && *e.apiKeyID != *w.apiKeyID) {
+			t.Fatalf("audit entry %d api_key_id = %v, want %v", i, e.apiKeyID, w.apiKeyID)
+		}
+		if e.requestID == nil || *e.requestID != "req-support" {
+			t.Fatalf("audit entry %d request_id = %v", i, e.requestID)
+		}
+	}
+}
+
+func TestExportRunAndExportJobLifecycle(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	otherKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create other api key: %v", err)
+	}
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+	exportRepo := NewExportRepository(pool, logger)
+
+	runIDs := make([]uuid.UUID, 0, 3)
+	for range 3 {
+		runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+		if err != nil {
+			t.Fatalf("create run: %v", err)
+		}
+		runIDs = append(runIDs, runID)
+	}
+	if err := runRepo.CancelRun(tenantCtx, runIDs[0], "exported"); err != nil {
+		t.Fatalf("cancel run: %v", err)
+	}
+	if _, err := runRepo.CreateRun(auth.WithAPIKeyID(ctx, otherKeyID), domain.CreateRunParams{}); err != nil {
+		t.Fatalf("create other run: %v", err)
+	}
+
+	export, err := exportRepo.ExportRun(tenantCtx, runIDs[0])
+	if err != nil {
+		t.Fatalf("export run: %v", err)
+	}
+	if export.Run.ID != runIDs[0] || export.Run.APIKeyID != apiKeyID || export.Run.CancelReason == nil || *export.Run.CancelReason != "exported" {
+		t.Fatalf("unexpected exported run: %+v", export.Run)
+	}
+	if len(export.Steps) == 0 || len(export.Events) == 0 || export.Events[len(export.Events)-1].Type != domain.EventRunCanceled {
+		t.Fatalf("expected steps and events in export, got %d steps and %+v", len(export.Steps), export.Events)
+	}
+	if _, err := exportRepo.ExportRun(auth.WithAPIKeyID(ctx, otherKeyID), runIDs[0]); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows exporting another tenant's run, got %v", err)
+	}
+
+	var seen []uuid.UUID
+	after := uuid.Nil
+	for {
+		page, err := exportRepo.ExportRunPage(ctx, apiKeyID, after, 2)
+		if err != nil {
+			t.Fatalf("export run page: %v", err)
+		}
+		for _, run := range page {
+			seen = append(seen, run.Run.ID)
+		}
+		if len(page) < 2 {
+			break
+		}
+		after = page[len(page)-1].Run.ID
+	}
+	slices.SortFunc(runIDs, func(a, b uuid.UUID) int { return strings.Compare(a.String(), b.String()) })
+	if !slices.Equal(seen, runIDs) {
+		t.Fatalf("paged export = %v, want the tenant's runs %v in ID order", seen, runIDs)
+	}
+
+	job, err := exportRepo.CreateExportJob(tenantCtx, domain.ExportFormatCSV)
+	if err != nil {
+		t.Fatalf("create export job: %v", err)
+	}
+	if job.Status != domain.ExportPending || job.APIKeyID != apiKeyID {
+		t.Fatalf("unexpected new job: %+v", job)
+	}
+	if _, _, err := exportRepo.GetExportArtifact(tenantCtx, job.ID); !errors.Is(err, domain.ErrExportNotReady) {
+		t.Fatalf("expected ErrExportNotReady, got %v", err)
+	}
+
+	claimed, ok, err := exportRepo.ClaimExportJob(ctx)
+	if err != nil || !ok || claimed.ID != job.ID || claimed.Status != domain.ExportRunning {
+		t.Fatalf("claim export job = %+v, %v, %v", claimed, ok, err)
+	}
+	if _, ok, err := exportRepo.ClaimExportJob(ctx); err != nil || ok {
+		t.Fatalf("expected no second claim, got %v, %v", ok, err)
+	}
+
+	artifact := []byte("record,run_id\n")
+	if err := exportRepo.CompleteExportJob(ctx, job.ID, 3, artifact); err != nil {
+		t.Fatalf("complete export job: %v", err)
+	}
+	done, got, err := exportRepo.GetExportArtifact(tenantCtx, job.ID)
+	if err != nil {
+		t.Fatalf("get export artifact: %v", err)
+	}
+	if string(got) != string(artifact) || done.RunsExported != 3 || done.ArtifactBytes == nil || *done.ArtifactBytes != int64(len(artifact)) || done.ArtifactSHA256 == nil {
+		t.Fatalf("unexpected finished job %+v with artifact %q", done, got)
+	}
+	if _, err := exportRepo.GetExportJob(auth.WithAPIKeyID(ctx, otherKeyID), job.ID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows reading another tenant's export, got %v", err)
+	}
+}
+
+func TestCreateRunUsesConfiguredUUIDVersion(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	runRepo := NewRunRepository(pool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// A v4 run created before the switch keeps working next to v7 runs.
+	legacyRunID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create v4 run: %v", err)
+	}
+	if legacyRunID.Version() != 4 {
+		t.Fatalf("expected v4 run id by default, got version %d", legacyRunID.Version())
+	}
+
+	if err := ids.SetVersion(ids.Version7); err != nil {
+		t.Fatalf("set uuid version: %v", err)
+	}
+	t.Cleanup(func() { _ = ids.SetVersion(ids.Version4) })
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create v7 run: %v", err)
+	}
+	if runID.Version() != 7 {
+		t.Fatalf("expected v7 run id, got version %d", runID.Version())
+	}
+
+	steps, err := NewStepRepository(pool, slog.New(slog.NewTextHandler(io.Discard, nil))).ListSteps(tenantCtx, runID)
+	if err != nil {
+		t.Fatalf("list steps: %v", err)
+	}
+	for _, step := range steps {
+		if step.ID.Version() != 7 {
+			t.Fatalf("expected v7 step id, got %s", step.ID)
+		}
+	}
+
+	if _, err := runRepo.GetRun(tenantCtx, legacyRunID); err != nil {
+		t.Fatalf("get v4 run after switch: %v", err)
+	}
+}
+
+func TestCreateRunRespectsMaxConcurrentRuns(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	if _, err := pool.Exec(ctx, `
+		UPDATE api_keys
+		SET max_concurrent_runs=1
+		WHERE id=$1
+	`, apiKeyID); err != nil {
+		t.Fatalf("set api key max_concurrent_runs: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create first run: %v", err)
+	}
+
+	if _, err := pool.Exec(ctx, `
+		UPDATE runs
+		SET status=$2
+		WHERE id=$1
+	`, runID, domain.RunRunning); err != nil {
+		t.Fatalf("mark first run running: %v", err)
+	}
+
+	if _, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{}); !errors.Is(err, domain.ErrMaxConcurrentRunsExceeded) {
+		t.Fatalf("expected ErrMaxConcurrentRunsExceeded, got %v", err)
+	}
+}
+
+func TestCreateRunRespectsTemplateRunLimit(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	apiKeyRepo := NewAPIKeyRepository(pool, logger)
+	if _, err := apiKeyRepo.SetTemplateRunLimits(ctx, apiKeyID, map[string]int{"default": 1}); err != nil {
+		t.Fatalf("set template run limits: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	runRepo := NewRunRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create first run: %v", err)
+	}
+	// A pending run has not started, so it does not count against the cap.
+	if _, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{}); err != nil {
+		t.Fatalf("create second run while the first is pending: %v", err)
+	}
+
+	if _, err := pool.Exec(ctx, `UPDATE runs SET status=$2 WHERE id=$1`, runID, domain.RunRunning); err != nil {
+		t.Fatalf("mark first run running: %v", err)
+	}
+
+	_, err = runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if !errors.Is(err, domain.ErrMaxConcurrentTemplateRunsExceeded) || !errors.Is(err, domain.ErrMaxConcurrentRunsExceeded) {
+		t.Fatalf("expected ErrMaxConcurrentTemplateRunsExceeded, got %v", err)
+	}
+
+	key, err := apiKeyRepo.GetAPIKey(ctx, apiKeyID)
+	if err != nil {
+		t.Fatalf("get api key: %v", err)
+	}
+	if key.MaxConcurrentRunsPerTemplate["default"] != 1 {
+		t.Fatalf("expected template run limits on the key, got %v", key.MaxConcurrentRunsPerTemplate)
+	}
+
+	if _, err := apiKeyRepo.SetTemplateRunLimits(ctx, apiKeyID, nil); err != nil {
+		t.Fatalf("clear template run limits: %v", err)
+	}
+	if _, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{}); err != nil {
+		t.Fatalf("create run after clearing the cap: %v", err)
+	}
+}
+
+func TestCreateRunRejectedOverMonthlyBudget(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	clk := clock.NewFake(time.Date(2026, time.May, 20, 12, 0, 0, 0, time.UTC))
+	limit := 1.0
+	monthly, err := NewAPIKeyRepository(pool, logger).WithClock(clk).SetMonthlyBudget(ctx, apiKeyID, &limit)
+	if err != nil {
+		t.Fatalf("set monthly budget: %v", err)
+	}
+	if monthly.LimitUSD == nil || *monthly.LimitUSD != limit || monthly.SpentUSD != 0 {
+		t.Fatalf("unexpected budget after set: %+v", monthly)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	runRepo := NewRunRepository(pool, logger).WithClock(clk)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create first run: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		UPDATE runs
+		SET total_cost_usd=1.5, created_at=$2
+		WHERE id=$1
+	`, runID, time.Date(2026, time.May, 3, 9, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("record run cost: %v", err)
+	}
+
+	if _, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{}); !errors.Is(err, domain.ErrMonthlyBudgetExceeded) {
+		t.Fatalf("expected ErrMonthlyBudgetExceeded, got %v", err)
+	}
+
+	// May's spend no longer counts once June starts.
+	clk.Set(time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC))
+	if _, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{}); err != nil {
+		t.Fatalf("create run in new month: %v", err)
+	}
+}
+
+func TestCreateRunWithSameIdempotencyKeyReturnsSameRunID(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	idempotentCtx := auth.WithIdempotencyKey(tenantCtx, "idem-same-key")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+
+	firstRunID, err := runRepo.CreateRun(idempotentCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create first run: %v", err)
+	}
+
+	secondRunID, err := runRepo.CreateRun(idempotentCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create second run: %v", err)
+	}
+
+	if firstRunID != secondRunID {
+		t.Fatalf("expected same run id for repeated idempotency key, got %s and %s", firstRunID, secondRunID)
+	}
+
+	var runsCount int
+	if err := pool.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM runs
+		WHERE api_key_id=$1
+	`, apiKeyID).Scan(&runsCount); err != nil {
+		t.Fatalf("count runs: %v", err)
+	}
+	if runsCount != 1 {
+		t.Fatalf("expected exactly 1 run row, got %d", runsCount)
+	}
+
+	var reqCount int
+	if err := pool.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM run_requests
+		WHERE api_key_id=$1 AND idempotency_key=$2
+	`, apiKeyID, "idem-same-key").Scan(&reqCount); err != nil {
+		t.Fatalf("count run_requests: %v", err)
+	}
+	if reqCount != 1 {
+		t.Fatalf("expected exactly 1 run_requests row, got %d", reqCount)
+	}
+}
+
+func TestCreateRunRejectsIdempotencyKeyReuseWithDifferentRequest(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	idempotentCtx := auth.WithIdempotencyKey(tenantCtx, "idem-payload-check")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+
+	firstRunID, err := runRepo.CreateRun(idempotentCtx, domain.CreateRunParams{Priority: 5})
+	if err != nil {
+		t.Fatalf("create first run: %v", err)
+	}
+
+	// An explicit default template is the same request as an omitted one.
+	sameRunID, err := runRepo.CreateRun(idempotentCtx, domain.CreateRunParams{Priority: 5, TemplateName: "default"})
+	if err != nil {
+		t.Fatalf("replay same request: %v", err)
+	}
+	if sameRunID != firstRunID {
+		t.Fatalf("expected replay to return %s got %s", firstRunID, sameRunID)
+	}
+
+	if _, err := runRepo.CreateRun(idempotentCtx, domain.CreateRunParams{Priority: 9}); !errors.Is(err, domain.ErrIdempotencyKeyReused) {
+		t.Fatalf("expected ErrIdempotencyKeyReused, got %v", err)
+	}
+
+	// Rows written before request hashes existed still replay.
+	if _, err := pool.Exec(ctx, `UPDATE run_requests SET request_hash=NULL WHERE api_key_id=$1`, apiKeyID); err != nil {
+		t.Fatalf("clear request hash: %v", err)
+	}
+	legacyRunID, err := runRepo.CreateRun(idempotentCtx, domain.CreateRunParams{Priority: 9})
+	if err != nil {
+		t.Fatalf("replay legacy request: %v", err)
+	}
+	if legacyRunID != firstRunID {
+		t.Fatalf("expected legacy replay to return %s got %s", firstRunID, legacyRunID)
+	}
+}
+
+func TestIdempotencyKeyExpiresAfterTTL(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger).WithIdempotencyKeyTTL(time.Hour)
+
+	expiredCtx := auth.WithIdempotencyKey(tenantCtx, "idem-expired")
+	firstRunID, err := runRepo.CreateRun(expiredCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create first run: %v", err)
+	}
+	if _, err := runRepo.CreateRun(auth.WithIdempotencyKey(tenantCtx, "idem-stale"), domain.CreateRunParams{}); err != nil {
+		t.Fatalf("create stale run: %v", err)
+	}
+	if _, err := runRepo.CreateRun(auth.WithIdempotencyKey(tenantCtx, "idem-fresh"), domain.CreateRunParams{}); err != nil {
+		t.Fatalf("create fresh run: %v", err)
+	}
+	if _, err := pool.Exec(ctx,
+		`UPDATE run_requests SET created_at = NOW() - INTERVAL '2 hours' WHERE idempotency_key IN ('idem-expired', 'idem-stale')`,
+	); err != nil {
+		t.Fatalf("age run requests: %v", err)
+	}
+
+	// Outside the window the key is free again, even with a different body.
+	secondRunID, err := runRepo.CreateRun(expiredCtx, domain.CreateRunParams{Priority: 3})
+	if err != nil {
+		t.Fatalf("reuse expired key: %v", err)
+	}
+	if secondRunID == firstRunID {
+		t.Fatalf("expected expired key to create a new run, got %s again", firstRunID)
+	}
+
+	pruned, err := runRepo.PruneExpiredRunRequests(ctx, time.Hour, 1)
+	if err != nil {
+		t.Fatalf("prune run requests: %v", err)
+	}
+	if pruned != 1 {
+		t.Fatalf("expected 1 expired run request pruned, got %d", pruned)
+	}
+
+	var keys []string
+	rows, err := pool.Query(ctx, `SELECT idempotency_key FROM run_requests ORDER BY idempotency_key`)
+	if err != nil {
+		t.Fatalf("list run requests: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			t.Fatalf("scan run request: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) != 2 || keys[0] != "idem-expired" || keys[1] != "idem-fresh" {
+		t.Fatalf("expected idem-expired and idem-fresh to remain, got %v", keys)
+	}
+
+	var runs int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM runs WHERE api_key_id=$1`, apiKeyID).Scan(&runs); err != nil {
+		t.Fatalf("count runs: %v", err)
+	}
+	if runs != 4 {
+		t.Fatalf("expected pruning to keep all 4 runs, got %d", runs)
+	}
+}
+
+func TestCreateRunConcurrentSameIdempotencyKeyCreatesSingleRun(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	idempotentCtx := auth.WithIdempotencyKey(auth.WithAPIKeyID(ctx, apiKeyID), "idem-race-key")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+
+	const callers = 8
+	var wg sync.WaitGroup
+	runIDs := make([]uuid.UUID, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			runIDs[i], errs[i] = runRepo.CreateRun(idempotentCtx, domain.CreateRunParams{})
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < callers; i++ {
+		if errs[i] != nil {
+			t.Fatalf("create run #%d: %v", i, errs[i])
+		}
+		if runIDs[i] != runIDs[0] {
+			t.Fatalf("expected all callers to get run %s, caller #%d got %s", runIDs[0], i, runIDs[i])
+		}
+	}
+
+	var runsCount, stepsCount int
+	if err := pool.QueryRow(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM runs WHERE api_key_id=$1),
+			(SELECT COUNT(*) FROM steps st JOIN runs r ON r.id = st.run_id WHERE r.api_key_id=$1)
+	`, apiKeyID).Scan(&runsCount, &stepsCount); err != nil {
+		t.Fatalf("count runs and steps: %v", err)
+	}
+	if runsCount != 1 {
+		t.Fatalf("expected exactly 1 run row, got %d", runsCount)
+	}
+	if stepsCount != 3 {
+		t.Fatalf("expected exactly 3 step rows, got %d", stepsCount)
+	}
+}
+
+func TestCancelRunEnqueuesSubscribedEventWebhook(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+
+	subscribedRunID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
+		WebhookURL:    "https://example.com/hook",
+		WebhookEvents: []string{domain.EventRunCanceled},
+	})
+	if err != nil {
+		t.Fatalf("create subscribed run: %v", err)
+	}
+	unsubscribedRunID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
+		WebhookURL: "https://example.com/hook",
+	})
+	if err != nil {
+		t.Fatalf("create unsubscribed run: %v", err)
+	}
+
+	for _, runID := range []uuid.UUID{subscribedRunID, unsubscribedRunID} {
+		if err := runRepo.CancelRun(tenantCtx, runID, ""); err != nil {
+			t.Fatalf("cancel run %s: %v", runID, err)
+		}
+	}
+
+	var (
+		eventType string
+		payload   []byte
+	)
+	if err := pool.QueryRow(ctx, `
+		SELECT event_type, payload
+		FROM webhook_deliveries
+		WHERE run_id=$1
+	`, subscribedRunID).Scan(&eventType, &payload); err != nil {
+		t.Fatalf("expected cancel webhook delivery: %v", err)
+	}
+	if eventType != domain.EventRunCanceled {
+		t.Fatalf("expected event_type %s got %s", domain.EventRunCanceled, eventType)
+	}
+
+	var body domain.EventWebhookPayload
+	if err := json.Unmarshal(payload, &body); err != nil {
+		t.Fatalf("unmarshal delivery payload: %v", err)
+	}
+	if body.RunID != subscribedRunID || body.Type != domain.EventRunCanceled || body.EventID == uuid.Nil {
+		t.Fatalf("unexpected delivery payload: %+v", body)
+	}
+
+	var unsubscribed int
+	if err := pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM webhook_deliveries WHERE run_id=$1
+	`, unsubscribedRunID).Scan(&unsubscribed); err != nil {
+		t.Fatalf("count unsubscribed deliveries: %v", err)
+	}
+	if unsubscribed != 0 {
+		t.Fatalf("expected no deliveries for unsubscribed run, got %d", unsubscribed)
+	}
+}
+
+func TestOutboxMessagesCommitWithRunAndClaimInOrder(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+	outboxRepo := NewOutboxRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{Tags: []string{"nightly"}})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if err := runRepo.CancelRun(tenantCtx, runID, ""); err != nil {
+		t.Fatalf("cancel run: %v", err)
+	}
+
+	claimed, err := outboxRepo.ClaimOutboxMessages(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if len(claimed) != 2 || claimed[0].Type != domain.OutboxRunCreated || claimed[1].Type != domain.EventRunCanceled {
+		t.Fatalf("expected RUN_CREATED then RUN_CANCELED, got %+v", claimed)
+	}
+	if claimed[0].RunID != runID || claimed[0].APIKeyID != apiKeyID || claimed[1].EventID == nil {
+		t.Fatalf("unexpected messages %+v", claimed)
+	}
+	var created domain.RunCreatedMessage
+	if err := json.Unmarshal(claimed[0].Payload, &created); err != nil || created.Status != domain.RunPending || len(created.Tags) != 1 {
+		t.Fatalf("unexpected RUN_CREATED payload %s (%v)", claimed[0].Payload, err)
+	}
+
+	// Leased messages are not claimed twice.
+	again, err := outboxRepo.ClaimOutboxMessages(ctx, 10, time.Minute)
+	if err != nil || len(again) != 0 {
+		t.Fatalf("expected nothing left to claim, got %d (%v)", len(again), err)
+	}
+
+	// A failed first message holds back the rest of its run.
+	if err := outboxRepo.RetryOutboxMessage(ctx, claimed[0].Seq, time.Now().Add(time.Hour), "broker down"); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if err := outboxRepo.ReleaseOutboxMessages(ctx, []int64{claimed[1].Seq}); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	again, err = outboxRepo.ClaimOutboxMessages(ctx, 10, time.Minute)
+	if err != nil || len(again) != 0 {
+		t.Fatalf("expected the run held behind its failed message, got %d (%v)", len(again), err)
+	}
+
+	if err := outboxRepo.DeleteOutboxMessages(ctx, []int64{claimed[0].Seq, claimed[1].Seq}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	var left int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM outbox_messages`).Scan(&left); err != nil || left != 0 {
+		t.Fatalf("expected the outbox empty, got %d (%v)", left, err)
+	}
+}
+
+func TestRunDailyStatsMaintainedByTriggers(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+	statsRepo := NewRunStatsRepository(pool, logger)
+
+	canceledRunID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if _, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{}); err != nil {
+		t.Fatalf("create second run: %v", err)
+	}
+
+	if _, err := pool.Exec(ctx, `
+		UPDATE runs SET total_cost_usd = 1.500000 WHERE id=$1
+	`, canceledRunID); err != nil {
+		t.Fatalf("set run cost: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps SET attempts = 3 WHERE run_id=$1 AND name=$2
+	`, canceledRunID, domain.StepLLM); err != nil {
+		t.Fatalf("set step attempts: %v", err)
+	}
+	if err := runRepo.CancelRun(tenantCtx, canceledRunID, ""); err != nil {
+		t.Fatalf("cancel run: %v", err)
+	}
+
+	// Cost billed after the run finished still lands in the summary.
+	if _, err := pool.Exec(ctx, `
+		UPDATE runs SET total_cost_usd = total_cost_usd + 0.500000 WHERE id=$1
+	`, canceledRunID); err != nil {
+		t.Fatalf("add late run cost: %v", err)
+	}
+
+	today := time.Now().UTC()
+	days, err := statsRepo.ListDailyRunStats(ctx, apiKeyID, today.AddDate(0, 0, -1), today.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("list daily run stats: %v", err)
+	}
+
+	total := domain.SumDailyRunStats(days)
+	if total.RunsCreated != 2 {
+		t.Fatalf("expected 2 runs created got %d", total.RunsCreated)
+	}
+	if total.RunsCanceled != 1 || total.RunsSucceeded != 0 || total.RunsFailed != 0 {
+		t.Fatalf("unexpected terminal counts: %+v", total)
+	}
+	if total.TotalCostUSD != 2.0 {
+		t.Fatalf("expected total cost 2.0 got %f", total.TotalCostUSD)
+	}
+	if total.StepsExecuted != 1 || total.StepRetries != 2 {
+		t.Fatalf("expected 1 executed step with 2 retries, got %+v", total)
+	}
+
+	if _, err := statsRepo.ListDailyRunStats(ctx, uuid.New(), today, today); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows for unknown api key, got %v", err)
+	}
+}
+
+func TestPurgeTenantDeletesOnlyThatTenantAndStoresSignedReport(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	purgedKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create purged api key: %v", err)
+	}
+	keptKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create kept api key: %v", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+	tenantRepo := NewTenantRepository(pool, logger)
+
+	purgedCtx := auth.WithIdempotencyKey(auth.WithAPIKeyID(ctx, purgedKeyID), "purge-me")
+	purgedRunID, err := runRepo.CreateRun(purgedCtx, domain.CreateRunParams{
+		WebhookURL:    "https://example.com/hook",
+		WebhookEvents: []string{domain.EventRunCanceled},
+	})
+	if err != nil {
+		t.Fatalf("create purged run: %v", err)
+	}
+	if err := runRepo.CancelRun(purgedCtx, purgedRunID, ""); err != nil {
+		t.Fatalf("cancel purged run: %v", err)
+	}
+
+	keptRunID, err := runRepo.CreateRun(auth.WithAPIKeyID(ctx, keptKeyID), domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create kept run: %v", err)
+	}
+
+	if _, err := NewExportRepository(pool, logger).CreateExportJob(purgedCtx, domain.ExportFormatJSON); err != nil {
+		t.Fatalf("create purged export job: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		INSERT INTO usage_records (api_key_id, run_id, step_id, step_name, attempt, model, prompt_tokens)
+		VALUES ($1, $2, $3, $4, 1, 'gpt-4o', 100)
+	`, purgedKeyID, purgedRunID, uuid.New(), domain.StepLLM); err != nil {
+		t.Fatalf("insert purged usage record: %v", err)
+	}
+
+	signingKey := []byte("report-key")
+	signed, err := tenantRepo.PurgeTenant(ctx, purgedKeyID, signingKey)
+	if err != nil {
+		t.Fatalf("purge tenant: %v", err)
+	}
+
+	want := domain.PurgeCounts{Runs: 1, Steps: 3, Events: 1, RunRequests: 1, WebhookDeliveries: 1, ExportJobs: 1, UsageRecords: 1}
+	if signed.Report.Deleted != want {
+		t.Fatalf("expected deleted counts %+v got %+v", want, signed.Report.Deleted)
+	}
+	if !signed.Verify(signingKey) {
+		t.Fatal("expected purge report signature to verify")
+	}
+
+	var remaining int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM runs WHERE api_key_id=$1`, purgedKeyID).Scan(&remaining); err != nil {
+		t.Fatalf("count purged runs: %v", err)
+	}
+	if remaining != 0 {
+		t.Fatalf("expected no runs left for purged tenant, got %d", remaining)
+	}
+
+	var keptSteps int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM steps WHERE run_id=$1`, keptRunID).Scan(&keptSteps); err != nil {
+		t.Fatalf("count kept steps: %v", err)
+	}
+	if keptSteps != 3 {
+		t.Fatalf("expected other tenant's steps untouched, got %d", keptSteps)
+	}
+
+	var storedSignature string
+	if err := pool.QueryRow(ctx, `
+		SELECT signature FROM tenant_purge_reports WHERE id=$1 AND api_key_id=$2
+	`, signed.Report.ID, purgedKeyID).Scan(&storedSignature); err != nil {
+		t.Fatalf("read stored purge report: %v", err)
+	}
+	if storedSignature != signed.Signature {
+		t.Fatalf("expected stored signature %q got %q", signed.Signature, storedSignature)
+	}
+
+	if _, err := tenantRepo.PurgeTenant(ctx, uuid.New(), signingKey); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows for unknown tenant, got %v", err)
+	}
+}
+
+func TestCreateRunPersistsWebhookURLAndRunCostBreakdown(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
+		WebhookURL: "https://example.com/hook",
+	})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	var webhookURL string
+	if err := pool.QueryRow(ctx, `
+		SELECT webhook_url
+		FROM runs
+		WHERE id=$1
+	`, runID).Scan(&webhookURL); err != nil {
+		t.Fatalf("query webhook url: %v", err)
+	}
+	if webhookURL != "https://example.com/hook" {
+		t.Fatalf("expected webhook_url to persist, got %q", webhookURL)
+	}
+
+	// simulate billed costs
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps
+		SET cost_usd = 1.250000
+		WHERE run_id=$1 AND name=$2
+	`, runID, domain.StepLLM); err != nil {
+		t.Fatalf("update step cost llm: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps
+		SET cost_usd = 0.750000
+		WHERE run_id=$1 AND name=$2
+	`, runID, domain.StepTool); err != nil {
+		t.Fatalf("update step cost tool: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		UPDATE runs
+		SET total_cost_usd = 2.000000
+		WHERE id=$1
+	`, runID); err != nil {
+		t.Fatalf("update run total cost: %v", err)
+	}
+
+	breakdown, err := runRepo.GetRunCost(tenantCtx, runID)
+	if err != nil {
+		t.Fatalf("get run cost: %v", err)
+	}
+	if breakdown.RunID != runID {
+		t.Fatalf("expected run id %s got %s", runID, breakdown.RunID)
+	}
+	if breakdown.TotalCostUSD != 2.0 {
+		t.Fatalf("expected total cost 2.0 got %f", breakdown.TotalCostUSD)
+	}
+	if len(breakdown.Steps) != 3 {
+		t.Fatalf("expected 3 step costs got %d", len(breakdown.Steps))
+	}
+}
+
+func TestSubmitRunProvisionsWebhookSecrets(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+	apiKeyRepo := NewAPIKeyRepository(pool, logger)
+
+	storedSecret := func(runID uuid.UUID) (*string, *string) {
+		t.Helper()
+		var webhookURL, webhookSecret *string
+		if err := pool.QueryRow(ctx, `SELECT webhook_url, webhook_secret FROM runs WHERE id=$1`, runID).Scan(&webhookURL, &webhookSecret); err != nil {
+			t.Fatalf("query run webhook settings: %v", err)
+		}
+		return webhookURL, webhookSecret
+	}
+
+	// Caller-supplied secret is stored and not echoed back.
+	supplied, err := runRepo.SubmitRun(tenantCtx, domain.CreateRunParams{
+		WebhookURL:    "https://example.com/hook",
+		WebhookSecret: "caller-secret-0123456789",
+	})
+	if err != nil {
+		t.Fatalf("submit run with secret: %v", err)
+	}
+	if supplied.WebhookSecret != "" {
+		t.Fatalf("expected no generated secret, got %q", supplied.WebhookSecret)
+	}
+	if _, secret := storedSecret(supplied.ID); secret == nil || *secret != "caller-secret-0123456789" {
+		t.Fatalf("expected caller secret to persist, got %v", secret)
+	}
+
+	// A URL without a secret gets a generated one, returned only on first submit.
+	replayCtx := auth.WithIdempotencyKey(tenantCtx, "webhook-secret-replay")
+	generated, err := runRepo.SubmitRun(replayCtx, domain.CreateRunParams{WebhookURL: "https://example.com/hook"})
+	if err != nil {
+		t.Fatalf("submit run without secret: %v", err)
+	}
+	if !strings.HasPrefix(generated.WebhookSecret, "whsec_") {
+		t.Fatalf("expected generated whsec_ secret, got %q", generated.WebhookSecret)
+	}
+	if _, secret := storedSecret(generated.ID); secret == nil || *secret != generated.WebhookSecret {
+		t.Fatalf("expected generated secret to persist, got %v", secret)
+	}
+	replayed, err := runRepo.SubmitRun(replayCtx, domain.CreateRunParams{WebhookURL: "https://example.com/hook"})
+	if err != nil {
+		t.Fatalf("replay submit run: %v", err)
+	}
+	if replayed.ID != generated.ID || replayed.WebhookSecret != "" {
+		t.Fatalf("expected replay to return run %s without secret, got %+v", generated.ID, replayed)
+	}
+
+	// Key defaults fill in both URL and secret.
+	defaults, err := apiKeyRepo.SetWebhookDefaults(ctx, apiKeyID, domain.SetWebhookDefaultsParams{
+		WebhookURL:     "https://example.com/default",
+		GenerateSecret: true,
+	})
+	if err != nil {
+		t.Fatalf("set webhook defaults: %v", err)
+	}
+	if defaults.WebhookSecret == "" || !defaults.HasWebhookSecret {
+		t.Fatalf("expected generated default secret, got %+v", defaults)
+	}
+	record, err := apiKeyRepo.GetAPIKey(ctx, apiKeyID)
+	if err != nil {
+		t.Fatalf("get api key: %v", err)
+	}
+	if record.DefaultWebhookURL == nil || *record.DefaultWebhookURL != "https://example.com/default" || !record.HasDefaultWebhookSecret {
+		t.Fatalf("expected api key record to expose webhook defaults, got %+v", record)
+	}
+
+	inherited, err := runRepo.SubmitRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("submit run with defaults: %v", err)
+	}
+	if inherited.WebhookSecret != "" {
+		t.Fatalf("expected inherited secret not to be returned, got %q", inherited.WebhookSecret)
+	}
+	webhookURL, secret := storedSecret(inherited.ID)
+	if webhookURL == nil || *webhookURL != "https://example.com/default" {
+		t.Fatalf("expected default webhook url, got %v", webhookURL)
+	}
+	if secret == nil || *secret != defaults.WebhookSecret {
+		t.Fatalf("expected default webhook secret, got %v", secret)
+	}
+
+	// Clearing the defaults stops inheritance.
+	if _, err := apiKeyRepo.SetWebhookDefaults(ctx, apiKeyID, domain.SetWebhookDefaultsParams{}); err != nil {
+		t.Fatalf("clear webhook defaults: %v", err)
+	}
+	plain, err := runRepo.SubmitRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("submit run after clearing defaults: %v", err)
+	}
+	if webhookURL, secret := storedSecret(plain.ID); webhookURL != nil || secret != nil {
+		t.Fatalf("expected no webhook settings, got url=%v secret=%v", webhookURL, secret)
+	}
+
+	if _, err := apiKeyRepo.SetWebhookDefaults(ctx, uuid.New(), domain.SetWebhookDefaultsParams{}); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected ErrNoRows for unknown key, got %v", err)
+	}
+}
+
+func TestWorkflowTemplateVersionsArePinnedByRuns(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+	stepRepo := NewStepRepository(pool, logger)
+	templateRepo := NewTemplateRepository(pool, logger)
+	templateName := "versioned-" + uuid.NewString()[:8]
+
+	v1, err := templateRepo.PutWorkflowTemplate(ctx, templateName, []domain.WorkflowTemplateStep{{Name: domain.StepLLM}})
+	if err != nil || v1.Version != 1 {
+		t.Fatalf("expected version 1, got %d (%v)", v1.Version, err)
+	}
+	firstRun, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{TemplateName: templateName})
+	if err != nil {
+		t.Fatalf("create run on version 1: %v", err)
+	}
+
+	v2, err := templateRepo.PutWorkflowTemplate(ctx, templateName, []domain.WorkflowTemplateStep{{Name: domain.StepLLM}, {Name: domain.StepTool}})
+	if err != nil || v2.Version != 2 {
+		t.Fatalf("expected version 2, got %d (%v)", v2.Version, err)
+	}
+
+	steps, err := stepRepo.ListSteps(tenantCtx, firstRun)
+	if err != nil || len(steps) != 1 {
+		t.Fatalf("expected the first run to keep its one step, got %d (%v)", len(steps), err)
+	}
+
+	latestRun, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{TemplateName: templateName})
+	if err != nil {
+		t.Fatalf("create run on latest version: %v", err)
+	}
+	one := 1
+	pinnedRun, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{TemplateName: templateName, TemplateVersion: &one})
+	if err != nil {
+		t.Fatalf("create run pinned to version 1: %v", err)
+	}
+	for runID, want := range map[uuid.UUID]int{firstRun: 1, latestRun: 2, pinnedRun: 1} {
+		var version int
+		if err := pool.QueryRow(ctx, `SELECT template_version FROM runs WHERE id = $1`, runID).Scan(&version); err != nil || version != want {
+			t.Fatalf("expected run %s on version %d, got %d (%v)", runID, want, version, err)
+		}
+	}
+
+	replay, err := runRepo.ReplayRun(tenantCtx, firstRun, nil)
+	if err != nil {
+		t.Fatalf("replay run: %v", err)
+	}
+	if steps, err := stepRepo.ListSteps(tenantCtx, replay.ID); err != nil || len(steps) != 1 {
+		t.Fatalf("expected the replay to use the source's version, got %d steps (%v)", len(steps), err)
+	}
+
+	three := 3
+	if _, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{TemplateName: templateName, TemplateVersion: &three}); !errors.Is(err, domain.ErrWorkflowTemplateNotFound) {
+		t.Fatalf("expected ErrWorkflowTemplateNotFound for a missing version, got %v", err)
+	}
+
+	versions, err := templateRepo.ListWorkflowTemplateVersions(ctx, templateName)
+	if err != nil {
+		t.Fatalf("list versions: %v", err)
+	}
+	if len(versions) != 2 || versions[0].Version != 2 || len(versions[0].Steps) != 2 || len(versions[1].Steps) != 1 {
+		t.Fatalf("unexpected versions %+v", versions)
+	}
+	if _, err := templateRepo.ListWorkflowTemplateVersions(ctx, "missing-"+templateName); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected ErrNoRows for an unknown template, got %v", err)
+	}
+}
+
+func TestCreateRunUsesWorkflowTemplateAndPriority(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	templateID := uuid.New()
 	templateName := "custom-template-" + uuid.NewString()
 	if _, err := pool.Exec(ctx, `
-		INSERT INTO workflow_templates (id, name)
-		VALUES ($1, $2)
-	`, templateID, templateName); err != nil {
-		t.Fatalf("insert workflow template: %v", err)
+		INSERT INTO workflow_templates (id, name)
+		VALUES ($1, $2)
+	`, templateID, templateName); err != nil {
+		t.Fatalf("insert workflow template: %v", err)
+	}
+
+	if _, err := pool.Exec(ctx, `
+		INSERT INTO workflow_template_steps (id, template_id, position, name)
+		VALUES
+			($1, $2, 1, $3),
+			($4, $2, 2, $5)
+	`,
+		uuid.New(),
+		templateID,
+		domain.StepTool,
+		uuid.New(),
+		domain.StepLLM,
+	); err != nil {
+		t.Fatalf("insert workflow template steps: %v", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+	stepRepo := NewStepRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
+		Priority:     9,
+		TemplateName: templateName,
+	})
+	if err != nil {
+		t.Fatalf("create run with custom template: %v", err)
+	}
+
+	var priority int
+	if err := pool.QueryRow(ctx, `
+		SELECT priority FROM runs WHERE id=$1
+	`, runID).Scan(&priority); err != nil {
+		t.Fatalf("query run priority: %v", err)
+	}
+	if priority != 9 {
+		t.Fatalf("expected run priority 9 got %d", priority)
+	}
+
+	steps, err := stepRepo.ListSteps(tenantCtx, runID)
+	if err != nil {
+		t.Fatalf("list steps: %v", err)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("expected 2 steps from custom template got %d", len(steps))
+	}
+	if steps[0].Name != string(domain.StepTool) {
+		t.Fatalf("expected first step %s got %s", domain.StepTool, steps[0].Name)
+	}
+	if steps[1].Name != string(domain.StepLLM) {
+		t.Fatalf("expected second step %s got %s", domain.StepLLM, steps[1].Name)
+	}
+}
+
+func TestAPIKeyScopesRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	apiKeyRepo := NewAPIKeyRepository(pool, logger)
+
+	full, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{Name: "full-access"})
+	if err != nil {
+		t.Fatalf("create full key: %v", err)
+	}
+	key, found, err := apiKeyRepo.ResolveAPIKey(ctx, full.Token)
+	if err != nil || !found {
+		t.Fatalf("resolve full key: found=%v err=%v", found, err)
+	}
+	if !slices.Equal(key.Scopes, domain.AllScopes) {
+		t.Fatalf("expected default scopes %v got %v", domain.AllScopes, key.Scopes)
+	}
+
+	reader, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{
+		Name:   "dashboard",
+		Scopes: []string{domain.ScopeRunsRead},
+	})
+	if err != nil {
+		t.Fatalf("create read-only key: %v", err)
+	}
+	key, _, err = apiKeyRepo.ResolveAPIKey(ctx, reader.Token)
+	if err != nil {
+		t.Fatalf("resolve read-only key: %v", err)
+	}
+	if !key.HasScope(domain.ScopeRunsRead) || key.HasScope(domain.ScopeRunsWrite) {
+		t.Fatalf("expected read-only scopes, got %v", key.Scopes)
+	}
+
+	if _, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{Name: "bad", Scopes: []string{"admin"}}); !errors.Is(err, domain.ErrInvalidScope) {
+		t.Fatalf("expected ErrInvalidScope, got %v", err)
+	}
+
+	scopes, err := apiKeyRepo.SetScopes(ctx, reader.ID, []string{domain.ScopeApprovalsWrite, domain.ScopeRunsRead})
+	if err != nil {
+		t.Fatalf("set scopes: %v", err)
+	}
+	record, err := apiKeyRepo.GetAPIKey(ctx, reader.ID)
+	if err != nil {
+		t.Fatalf("get api key: %v", err)
+	}
+	if !slices.Equal(record.Scopes, scopes) || len(scopes) != 2 {
+		t.Fatalf("expected stored scopes %v got %v", scopes, record.Scopes)
+	}
+
+	if _, err := apiKeyRepo.SetScopes(ctx, uuid.New(), nil); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows for unknown key, got %v", err)
+	}
+}
+
+func TestAPIKeySlugRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	apiKeyRepo := NewAPIKeyRepository(pool, logger)
+
+	created, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{Name: "acme", Slug: "acme-prod"})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	key, _, err := apiKeyRepo.ResolveAPIKey(ctx, created.Token)
+	if err != nil || key.Slug != "acme-prod" {
+		t.Fatalf("expected resolved slug acme-prod, got %q (err=%v)", key.Slug, err)
+	}
+	id, err := apiKeyRepo.GetAPIKeyIDBySlug(ctx, "acme-prod")
+	if err != nil || id != created.ID {
+		t.Fatalf("expected slug to resolve to %s, got %s (err=%v)", created.ID, id, err)
+	}
+
+	if _, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{Name: "copy", Slug: "acme-prod"}); !errors.Is(err, domain.ErrAPIKeySlugTaken) {
+		t.Fatalf("expected ErrAPIKeySlugTaken, got %v", err)
+	}
+	other, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{Name: "other"})
+	if err != nil {
+		t.Fatalf("create second key: %v", err)
+	}
+	if err := apiKeyRepo.SetSlug(ctx, other.ID, "acme-prod"); !errors.Is(err, domain.ErrAPIKeySlugTaken) {
+		t.Fatalf("expected ErrAPIKeySlugTaken on update, got %v", err)
+	}
+
+	if err := apiKeyRepo.SetSlug(ctx, created.ID, ""); err != nil {
+		t.Fatalf("clear slug: %v", err)
+	}
+	record, err := apiKeyRepo.GetAPIKey(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("get api key: %v", err)
+	}
+	if record.Slug != nil {
+		t.Fatalf("expected cleared slug, got %q", *record.Slug)
+	}
+	if _, err := apiKeyRepo.GetAPIKeyIDBySlug(ctx, "acme-prod"); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows for cleared slug, got %v", err)
+	}
+}
+
+func TestTenantSchedulingReportsWeightsAndClaims(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	apiKeyRepo := NewAPIKeyRepository(pool, logger)
+
+	heavy, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{Name: "heavy"})
+	if err != nil {
+		t.Fatalf("create heavy key: %v", err)
+	}
+	light, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{Name: "light"})
+	if err != nil {
+		t.Fatalf("create light key: %v", err)
+	}
+
+	if err := apiKeyRepo.SetSchedulingWeight(ctx, heavy.ID, 3); err != nil {
+		t.Fatalf("set scheduling weight: %v", err)
+	}
+	if err := apiKeyRepo.SetSchedulingWeight(ctx, light.ID, 0); !errors.Is(err, domain.ErrInvalidSchedulingWeight) {
+		t.Fatalf("expected ErrInvalidSchedulingWeight, got %v", err)
+	}
+	if err := apiKeyRepo.SetSchedulingWeight(ctx, uuid.New(), 2); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows for unknown key, got %v", err)
+	}
+
+	if _, err := pool.Exec(ctx, `
+		INSERT INTO tenant_claim_counters (api_key_id, claims, last_claimed_at)
+		VALUES ($1, 30, NOW()), ($2, 10, NOW())
+	`, heavy.ID, light.ID); err != nil {
+		t.Fatalf("seed claim counters: %v", err)
+	}
+
+	tenants, err := apiKeyRepo.ListTenantScheduling(ctx)
+	if err != nil {
+		t.Fatalf("list tenant scheduling: %v", err)
+	}
+	if len(tenants) != 2 {
+		t.Fatalf("expected 2 tenants, got %d", len(tenants))
+	}
+	got := map[uuid.UUID]domain.TenantScheduling{}
+	for _, tenant := range tenants {
+		got[tenant.APIKeyID] = tenant
+	}
+	if h := got[heavy.ID]; h.SchedulingWeight != 3 || h.Claims != 30 || h.WeightShare != 0.75 || h.ClaimShare != 0.75 {
+		t.Fatalf("unexpected heavy tenant %+v", h)
+	}
+	if l := got[light.ID]; l.SchedulingWeight != domain.DefaultSchedulingWeight || l.Claims != 10 || l.LastClaimedAt == nil {
+		t.Fatalf("unexpected light tenant %+v", l)
+	}
+
+	record, err := apiKeyRepo.GetAPIKey(ctx, heavy.ID)
+	if err != nil || record.SchedulingWeight != 3 {
+		t.Fatalf("expected stored weight 3, got %d (err=%v)", record.SchedulingWeight, err)
+	}
+}
+
+func TestAPIKeyExpiryEnforcedOnResolve(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fake := clock.NewFake(time.Now().UTC().Truncate(time.Second))
+	apiKeyRepo := NewAPIKeyRepository(pool, logger).WithClock(fake)
+
+	expiresAt := fake.Now().Add(24 * time.Hour)
+	created, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{Name: "contractor", ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	key, found, err := apiKeyRepo.ResolveAPIKey(ctx, created.Token)
+	if err != nil || !found {
+		t.Fatalf("resolve before expiry: found=%v err=%v", found, err)
+	}
+	if key.ExpiresAt == nil || !key.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected resolved expires_at %s got %v", expiresAt, key.ExpiresAt)
+	}
+
+	keys, err := apiKeyRepo.ListAPIKeys(ctx, domain.APIKeyFilter{})
+	if err != nil {
+		t.Fatalf("list api keys: %v", err)
+	}
+	if len(keys) != 1 || keys[0].ExpiresAt == nil || !keys[0].ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected listed key with expires_at %s, got %+v", expiresAt, keys)
+	}
+
+	fake.Advance(24 * time.Hour)
+	if _, found, err := apiKeyRepo.ResolveAPIKey(ctx, created.Token); err != nil || found {
+		t.Fatalf("expected expired key to be rejected: found=%v err=%v", found, err)
+	}
+
+	past := fake.Now().Add(-time.Minute)
+	if _, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{Name: "late", ExpiresAt: &past}); !errors.Is(err, domain.ErrInvalidAPIKeyExpiry) {
+		t.Fatalf("expected ErrInvalidAPIKeyExpiry, got %v", err)
+	}
+}
+
+func TestResolveAPIKeyTracksUsage(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fake := clock.NewFake(time.Now().UTC().Truncate(time.Second))
+	apiKeyRepo := NewAPIKeyRepository(pool, logger).WithClock(fake).WithUsageTracking(time.Minute)
+
+	created, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{Name: "dashboard"})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	resolve := func() {
+		t.Helper()
+		if _, found, err := apiKeyRepo.ResolveAPIKey(ctx, created.Token); err != nil || !found {
+			t.Fatalf("resolve: found=%v err=%v", found, err)
+		}
+	}
+	usage := func() domain.APIKeyRecord {
+		t.Helper()
+		record, err := apiKeyRepo.GetAPIKey(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("get key: %v", err)
+		}
+		return record
+	}
+
+	if record := usage(); record.LastUsedAt != nil || record.RequestCount != 0 {
+		t.Fatalf("expected an unused key, got %v and %d", record.LastUsedAt, record.RequestCount)
+	}
+
+	first := fake.Now()
+	resolve()
+	fake.Advance(10 * time.Second)
+	resolve()
+	if record := usage(); record.LastUsedAt == nil || !record.LastUsedAt.Equal(first) || record.RequestCount != 1 {
+		t.Fatalf("expected the first use written at once and the second held back, got %v and %d", record.LastUsedAt, record.RequestCount)
+	}
+
+	fake.Advance(time.Minute)
+	resolve()
+	if record := usage(); record.LastUsedAt == nil || !record.LastUsedAt.Equal(fake.Now()) || record.RequestCount != 3 {
+		t.Fatalf("expected both held requests written after the interval, got %v and %d", record.LastUsedAt, record.RequestCount)
+	}
+
+	keys, err := apiKeyRepo.ListAPIKeys(ctx, domain.APIKeyFilter{})
+	if err != nil || len(keys) != 1 || keys[0].RequestCount != 3 || keys[0].LastUsedAt == nil {
+		t.Fatalf("expected usage in the key list, got %+v (err=%v)", keys, err)
+	}
+}
+
+func TestAPIKeyTokenPepperAndRehash(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	plain := NewAPIKeyRepository(pool, logger)
+	peppered := NewAPIKeyRepository(pool, logger).WithTokenPepper(strings.Repeat("p", 32))
+
+	legacy, err := plain.CreateAPIKey(ctx, domain.CreateAPIKeyParams{Name: "legacy"})
+	if err != nil {
+		t.Fatalf("create legacy key: %v", err)
+	}
+	keyed, err := peppered.CreateAPIKey(ctx, domain.CreateAPIKeyParams{Name: "keyed"})
+	if err != nil {
+		t.Fatalf("create keyed key: %v", err)
+	}
+	storedHash := func(id uuid.UUID) string {
+		t.Helper()
+		var hash string
+		if err := pool.QueryRow(ctx, `SELECT token_hash FROM api_keys WHERE id=$1`, id).Scan(&hash); err != nil {
+			t.Fatalf("read token hash: %v", err)
+		}
+		return hash
+	}
+	if hash := storedHash(keyed.ID); !strings.HasPrefix(hash, keyedHashPrefix) {
+		t.Fatalf("expected a keyed hash for a new key, got %q", hash)
+	}
+	if _, found, err := plain.ResolveAPIKey(ctx, keyed.Token); err != nil || found {
+		t.Fatalf("expected a keyed hash not to resolve without the pepper, found=%v err=%v", found, err)
+	}
+	for _, token := range []string{legacy.Token, keyed.Token} {
+		if _, found, err := peppered.ResolveAPIKey(ctx, token); err != nil || !found {
+			t.Fatalf("expected both hash forms to resolve with the pepper, found=%v err=%v", found, err)
+		}
+	}
+
+	if _, err := plain.RehashLegacyTokens(ctx, 10); err == nil {
+		t.Fatal("expected rehash without a pepper to fail")
+	}
+	n, err := peppered.RehashLegacyTokens(ctx, 1)
+	if err != nil || n != 1 {
+		t.Fatalf("expected one legacy hash rehashed, got %d (err=%v)", n, err)
+	}
+	if hash := storedHash(legacy.ID); !strings.HasPrefix(hash, keyedHashPrefix) {
+		t.Fatalf("expected the legacy hash keyed, got %q", hash)
+	}
+	if _, found, err := peppered.ResolveAPIKey(ctx, legacy.Token); err != nil || !found {
+		t.Fatalf("expected the rehashed key to resolve, found=%v err=%v", found, err)
+	}
+	if n, err := peppered.RehashLegacyTokens(ctx, 1); err != nil || n != 0 {
+		t.Fatalf("expected nothing left to rehash, got %d (err=%v)", n, err)
+	}
+}
+
+func TestAPIKeyAllowedCIDRsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	apiKeyRepo := NewAPIKeyRepository(pool, logger)
+
+	created, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{Name: "office", AllowedCIDRs: []string{"192.0.2.7", "10.0.0.0/8"}})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	key, found, err := apiKeyRepo.ResolveAPIKey(ctx, created.Token)
+	if err != nil || !found {
+		t.Fatalf("resolve: found=%v err=%v", found, err)
+	}
+	if !key.AllowsAddr(netip.MustParseAddr("10.4.5.6")) || key.AllowsAddr(netip.MustParseAddr("192.0.2.8")) {
+		t.Fatalf("unexpected resolved allow-list %v", key.AllowedCIDRs)
+	}
+
+	record, err := apiKeyRepo.GetAPIKey(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("get key: %v", err)
+	}
+	if want := []string{"10.0.0.0/8", "192.0.2.7/32"}; !slices.Equal(record.AllowedCIDRs, want) {
+		t.Fatalf("expected allowed_cidrs %v got %v", want, record.AllowedCIDRs)
+	}
+
+	if _, err := apiKeyRepo.SetAllowedCIDRs(ctx, created.ID, nil); err != nil {
+		t.Fatalf("clear allowed cidrs: %v", err)
+	}
+	key, _, err = apiKeyRepo.ResolveAPIKey(ctx, created.Token)
+	if err != nil || len(key.AllowedCIDRs) != 0 {
+		t.Fatalf("expected cleared allow-list, got %v (err=%v)", key.AllowedCIDRs, err)
+	}
+
+	if _, err := apiKeyRepo.SetAllowedCIDRs(ctx, created.ID, []string{"bogus"}); !errors.Is(err, domain.ErrInvalidAllowedCIDR) {
+		t.Fatalf("expected ErrInvalidAllowedCIDR, got %v", err)
+	}
+
+	hosts, err := apiKeyRepo.SetEgressAllowHosts(ctx, created.ID, []string{"API.example.com:443", "10.0.0.0/8", "api.example.com:443"})
+	if err != nil {
+		t.Fatalf("set egress allow hosts: %v", err)
+	}
+	if want := []string{"10.0.0.0/8", "api.example.com:443"}; !slices.Equal(hosts, want) {
+		t.Fatalf("expected egress_allow_hosts %v got %v", want, hosts)
+	}
+	if record, err = apiKeyRepo.GetAPIKey(ctx, created.ID); err != nil || !slices.Equal(record.EgressAllowHosts, hosts) {
+		t.Fatalf("expected stored egress_allow_hosts %v, got %v (err=%v)", hosts, record.EgressAllowHosts, err)
+	}
+	if _, err := apiKeyRepo.SetEgressAllowHosts(ctx, created.ID, []string{"api.example.com:0"}); !errors.Is(err, domain.ErrInvalidEgressAllowHost) {
+		t.Fatalf("expected ErrInvalidEgressAllowHost, got %v", err)
+	}
+}
+
+func TestWebhookSigningKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fake := clock.NewFake(time.Now().UTC().Truncate(time.Second))
+	apiKeyRepo := NewAPIKeyRepository(pool, logger).WithClock(fake)
+	runRepo := NewRunRepository(pool, logger).WithClock(fake)
+
+	first, err := apiKeyRepo.RotateWebhookSigningKey(ctx, apiKeyID, time.Hour)
+	if err != nil {
+		t.Fatalf("first rotation: %v", err)
+	}
+	if first.KeyID != "k1" || !strings.HasPrefix(first.Secret, "whsec_") {
+		t.Fatalf("expected k1 with a generated secret, got %+v", first)
+	}
+
+	// Runs without their own secret are signed with the tenant keys instead of
+	// getting a per-run secret.
+	created, err := runRepo.SubmitRun(auth.WithAPIKeyID(ctx, apiKeyID), domain.CreateRunParams{WebhookURL: "https://example.com/hook"})
+	if err != nil {
+		t.Fatalf("submit run: %v", err)
+	}
+	if created.WebhookSecret != "" {
+		t.Fatalf("expected no per-run secret, got %q", created.WebhookSecret)
+	}
+	var runSecret *string
+	if err := pool.QueryRow(ctx, `SELECT webhook_secret FROM runs WHERE id=$1`, created.ID).Scan(&runSecret); err != nil {
+		t.Fatalf("query run secret: %v", err)
+	}
+	if runSecret != nil {
+		t.Fatalf("expected NULL run secret, got %q", *runSecret)
+	}
+
+	second, err := apiKeyRepo.RotateWebhookSigningKey(ctx, apiKeyID, time.Hour)
+	if err != nil {
+		t.Fatalf("second rotation: %v", err)
+	}
+	if second.KeyID != "k2" {
+		t.Fatalf("expected k2, got %s", second.KeyID)
+	}
+
+	keys, err := apiKeyRepo.ListWebhookSigningKeys(ctx, apiKeyID)
+	if err != nil {
+		t.Fatalf("list signing keys: %v", err)
+	}
+	if len(keys) != 2 || keys[0].KeyID != "k2" || keys[1].KeyID != "k1" {
+		t.Fatalf("expected k2 then k1, got %+v", keys)
+	}
+	if keys[0].ExpiresAt != nil || keys[0].Secret != "" {
+		t.Fatalf("expected current key without expiry or secret, got %+v", keys[0])
+	}
+	if keys[1].ExpiresAt == nil || !keys[1].ExpiresAt.Equal(fake.Now().Add(time.Hour)) {
+		t.Fatalf("expected k1 to expire after the overlap, got %v", keys[1].ExpiresAt)
+	}
+
+	if err := apiKeyRepo.ExpireWebhookSigningKey(ctx, apiKeyID, 1); err != nil {
+		t.Fatalf("expire k1: %v", err)
+	}
+	if err := apiKeyRepo.ExpireWebhookSigningKey(ctx, apiKeyID, 1); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows for already expired key, got %v", err)
+	}
+	if _, err := apiKeyRepo.RotateWebhookSigningKey(ctx, uuid.New(), time.Hour); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows for unknown api key, got %v", err)
+	}
+}
+
+func TestAPIKeyLifecycleRepositoryIntegration(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	apiKeyRepo := NewAPIKeyRepository(pool, logger)
+
+	created, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{
+		Name:              "integration-key",
+		MaxConcurrentRuns: 7,
+		MaxRequestsPerMin: 70,
+	})
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	if created.ID == uuid.Nil {
+		t.Fatalf("expected created api key id")
+	}
+	if len(created.Token) <= len("sk_live_") || created.Token[:8] != "sk_live_" {
+		t.Fatalf("expected token prefix sk_live_, got %q", created.Token)
+	}
+
+	var storedHash string
+	if err := pool.QueryRow(ctx, `
+		SELECT token_hash
+		FROM api_keys
+		WHERE id=$1
+	`, created.ID).Scan(&storedHash); err != nil {
+		t.Fatalf("query token hash: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte(created.Token))
+	expectedHash := hex.EncodeToString(sum[:])
+	if storedHash != expectedHash {
+		t.Fatalf("expected token hash %s got %s", expectedHash, storedHash)
+	}
+	if storedHash == created.Token {
+		t.Fatalf("raw token must not be stored")
+	}
+
+	resolved, found, err := apiKeyRepo.ResolveAPIKey(ctx, created.Token)
+	if err != nil {
+		t.Fatalf("resolve api key: %v", err)
+	}
+	if !found {
+		t.Fatalf("expected api key to resolve by raw token")
+	}
+	if resolved.ID != created.ID {
+		t.Fatalf("expected resolved id %s got %s", created.ID, resolved.ID)
+	}
+
+	keys, err := apiKeyRepo.ListAPIKeys(ctx, domain.APIKeyFilter{})
+	if err != nil {
+		t.Fatalf("list api keys: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 api key got %d", len(keys))
+	}
+	if keys[0].ID != created.ID {
+		t.Fatalf("expected listed key %s got %s", created.ID, keys[0].ID)
+	}
+
+	if err := apiKeyRepo.RevokeAPIKey(ctx, created.ID); err != nil {
+		t.Fatalf("revoke api key: %v", err)
+	}
+
+	_, found, err = apiKeyRepo.ResolveAPIKey(ctx, created.Token)
+	if err != nil {
+		t.Fatalf("resolve revoked api key: %v", err)
+	}
+	if found {
+		t.Fatalf("expected revoked api key to be unresolved")
+	}
+}
+
+func TestListAPIKeysPagesAndFilters(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	apiKeyRepo := NewAPIKeyRepository(pool, logger)
+
+	var ids []uuid.UUID
+	for _, name := range []string{"ci-a", "ci-b", "prod", "ci-c"} {
+		created, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{Name: name})
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		ids = append(ids, created.ID)
+	}
+	if err := apiKeyRepo.RevokeAPIKey(ctx, ids[1]); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+
+	names := func(keys []domain.APIKeyRecord) []string {
+		out := make([]string, 0, len(keys))
+		for _, key := range keys {
+			out = append(out, key.Name)
+		}
+		return out
+	}
+
+	first, err := apiKeyRepo.ListAPIKeys(ctx, domain.APIKeyFilter{NamePrefix: "ci-", Limit: 1})
+	if err != nil {
+		t.Fatalf("list first page: %v", err)
+	}
+	next, err := apiKeyRepo.ListAPIKeys(ctx, domain.APIKeyFilter{NamePrefix: "ci-", BeforeID: &first[0].ID, Limit: 5})
+	if err != nil {
+		t.Fatalf("list next page: %v", err)
+	}
+	if got := append(names(first), names(next)...); !slices.Equal(got, []string{"ci-c", "ci-a"}) {
+		t.Fatalf("expected active ci- keys newest first, got %v", got)
+	}
+
+	unknown := uuid.New()
+	if _, err := apiKeyRepo.ListAPIKeys(ctx, domain.APIKeyFilter{BeforeID: &unknown}); !errors.Is(err, domain.ErrAPIKeyNotFound) {
+		t.Fatalf("expected unknown cursor to fail with ErrAPIKeyNotFound, got %v", err)
+	}
+
+	all, err := apiKeyRepo.ListAPIKeys(ctx, domain.APIKeyFilter{IncludeRevoked: true})
+	if err != nil {
+		t.Fatalf("list with revoked: %v", err)
+	}
+	if got := names(all); !slices.Equal(got, []string{"ci-c", "prod", "ci-b", "ci-a"}) {
+		t.Fatalf("expected every key, got %v", got)
+	}
+	for _, key := range all {
+		if (key.RevokedAt != nil) != (key.ID == ids[1]) || key.LastUsedAt != nil {
+			t.Fatalf("unexpected revoked_at/last_used_at on %s: %v %v", key.Name, key.RevokedAt, key.LastUsedAt)
+		}
+	}
+}
+
+func TestPruneExpiredEventsHonorsPerKeyRetention(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+	eventRepo := NewEventRepository(pool, logger)
+	apiKeyRepo := NewAPIKeyRepository(pool, logger)
+
+	shortKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create short retention api key: %v", err)
+	}
+	defaultKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create default retention api key: %v", err)
+	}
+
+	sevenDays := 7
+	if err := apiKeyRepo.SetEventRetentionDays(ctx, shortKeyID, &sevenDays); err != nil {
+		t.Fatalf("set event retention: %v", err)
+	}
+
+	runIDs := make(map[uuid.UUID]uuid.UUID, 2)
+	for _, keyID := range []uuid.UUID{shortKeyID, defaultKeyID} {
+		tenantCtx := auth.WithAPIKeyID(ctx, keyID)
+		runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+		if err != nil {
+			t.Fatalf("create run: %v", err)
+		}
+		if err := runRepo.CancelRun(tenantCtx, runID, ""); err != nil {
+			t.Fatalf("cancel run: %v", err)
+		}
+		// Age the terminal run's events past the 7 day override but inside the 30 day default.
+		if _, err := pool.Exec(ctx, `
+			UPDATE events SET created_at = NOW() - INTERVAL '10 days' WHERE run_id=$1
+		`, runID); err != nil {
+			t.Fatalf("age events: %v", err)
+		}
+		runIDs[keyID] = runID
+	}
+
+	pruned, err := eventRepo.PruneExpiredEvents(ctx, 30, 1)
+	if err != nil {
+		t.Fatalf("prune expired events: %v", err)
+	}
+	if pruned == 0 {
+		t.Fatal("expected events of the 7 day tenant to be pruned")
+	}
+
+	for keyID, runID := range runIDs {
+		var remaining int
+		if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM events WHERE run_id=$1`, runID).Scan(&remaining); err != nil {
+			t.Fatalf("count events: %v", err)
+		}
+		if keyID == shortKeyID && remaining != 0 {
+			t.Fatalf("expected short retention tenant events to be pruned, %d remain", remaining)
+		}
+		if keyID == defaultKeyID && remaining == 0 {
+			t.Fatal("expected default retention tenant events to be kept")
+		}
+	}
+}
+
+func TestExpireRunsArchivesOrDeletesPerKeyRetention(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+	apiKeyRepo := NewAPIKeyRepository(pool, logger)
+
+	shortKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create short retention api key: %v", err)
+	}
+	defaultKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create default retention api key: %v", err)
+	}
+
+	sevenDays := 7
+	if err := apiKeyRepo.SetRunRetentionDays(ctx, shortKeyID, &sevenDays); err != nil {
+		t.Fatalf("set run retention: %v", err)
+	}
+
+	runIDs := make(map[uuid.UUID]uuid.UUID, 2)
+	for _, keyID := range []uuid.UUID{shortKeyID, defaultKeyID} {
+		tenantCtx := auth.WithAPIKeyID(ctx, keyID)
+		runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+		if err != nil {
+			t.Fatalf("create run: %v", err)
+		}
+		if err := runRepo.CancelRun(tenantCtx, runID, ""); err != nil {
+			t.Fatalf("cancel run: %v", err)
+		}
+		// Age the terminal run past the 7 day override but inside the 30 day default.
+		if _, err := pool.Exec(ctx, `
+			UPDATE runs SET updated_at = NOW() - INTERVAL '10 days' WHERE id=$1
+		`, runID); err != nil {
+			t.Fatalf("age run: %v", err)
+		}
+		runIDs[keyID] = runID
+	}
+
+	// A pending run is never expired, however old.
+	pendingID, err := runRepo.CreateRun(auth.WithAPIKeyID(ctx, shortKeyID), domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create pending run: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		UPDATE runs SET updated_at = NOW() - INTERVAL '100 days' WHERE id=$1
+	`, pendingID); err != nil {
+		t.Fatalf("age pending run: %v", err)
+	}
+
+	policy := domain.RunRetentionPolicy{DefaultDays: 30, Mode: domain.RunRetentionArchive, DryRun: true}
+	eligible, err := runRepo.ExpireRuns(ctx, policy, 1)
+	if err != nil {
+		t.Fatalf("dry run expire: %v", err)
+	}
+	if eligible != 1 {
+		t.Fatalf("expected one eligible run in dry run, got %d", eligible)
+	}
+	var runCount int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM runs`).Scan(&runCount); err != nil {
+		t.Fatalf("count runs: %v", err)
+	}
+	if runCount != 3 {
+		t.Fatalf("expected dry run to keep every run, got %d", runCount)
+	}
+
+	policy.DryRun = false
+	expired, err := runRepo.ExpireRuns(ctx, policy, 1)
+	if err != nil {
+		t.Fatalf("expire runs: %v", err)
+	}
+	if expired != 1 {
+		t.Fatalf("expected one expired run, got %d", expired)
+	}
+
+	shortRunID := runIDs[shortKeyID]
+	var exists bool
+	if err := pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM runs WHERE id=$1)`, shortRunID).Scan(&exists); err != nil {
+		t.Fatalf("check expired run: %v", err)
+	}
+	if exists {
+		t.Fatal("expected short retention tenant run to be removed")
+	}
+	var archivedStatus string
+	var archivedSteps, archivedEvents int
+	if err := pool.QueryRow(ctx, `
+		SELECT status, jsonb_array_length(steps), jsonb_array_length(events)
+		FROM run_archive WHERE id=$1
+	`, shortRunID).Scan(&archivedStatus, &archivedSteps, &archivedEvents); err != nil {
+		t.Fatalf("load archived run: %v", err)
+	}
+	if archivedStatus != string(domain.RunCanceled) {
+		t.Fatalf("expected archived status CANCELED, got %s", archivedStatus)
+	}
+	if archivedSteps == 0 || archivedEvents == 0 {
+		t.Fatalf("expected steps and events archived with the run, got %d steps %d events", archivedSteps, archivedEvents)
+	}
+
+	// Delete mode with a 5 day default also takes the other tenant's run.
+	expired, err = runRepo.ExpireRuns(ctx, domain.RunRetentionPolicy{DefaultDays: 5, Mode: domain.RunRetentionDelete}, 10)
+	if err != nil {
+		t.Fatalf("expire runs in delete mode: %v", err)
+	}
+	if expired != 1 {
+		t.Fatalf("expected one deleted run, got %d", expired)
+	}
+	var archived int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM run_archive WHERE id=$1`, runIDs[defaultKeyID]).Scan(&archived); err != nil {
+		t.Fatalf("count archive: %v", err)
+	}
+	if archived != 0 {
+		t.Fatal("expected delete mode not to archive")
+	}
+	if err := pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM runs WHERE id=$1)`, pendingID).Scan(&exists); err != nil {
+		t.Fatalf("check pending run: %v", err)
+	}
+	if !exists {
+		t.Fatal("expected pending run to be kept")
+	}
+}
+
+func TestEventPartitionsAreCreatedAheadAndDroppedWhenEmpty(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	// Restore partitions for the real current month once the test has
+	// dropped everything before its fake clock.
+	defer func() {
+		if _, err := NewEventRepository(pool, logger).EnsureEventPartitions(ctx, 1); err != nil {
+			t.Errorf("restore event partitions: %v", err)
+		}
+	}()
+
+	future := monthStart(time.Now().UTC()).AddDate(2, 0, 0)
+	clk := clock.NewFake(future.Add(36 * time.Hour))
+	eventRepo := NewEventRepository(pool, logger).WithClock(clk)
+
+	created, err := eventRepo.EnsureEventPartitions(ctx, 1)
+	if err != nil {
+		t.Fatalf("ensure event partitions: %v", err)
+	}
+	if created != 2 {
+		t.Fatalf("expected two partitions created, got %d", created)
+	}
+	if created, err := eventRepo.EnsureEventPartitions(ctx, 1); err != nil || created != 0 {
+		t.Fatalf("expected second pass to create nothing, got %d (err=%v)", created, err)
 	}
 
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	runID, err := NewRunRepository(pool, logger).CreateRun(auth.WithAPIKeyID(ctx, apiKeyID), domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
 	if _, err := pool.Exec(ctx, `
-		INSERT INTO workflow_template_steps (id, template_id, position, name)
-		VALUES
-			($1, $2, 1, $3),
-			($4, $2, 2, $5)
-	`,
-		uuid.New(),
-		templateID,
-		domain.StepTool,
-		uuid.New(),
-		domain.StepLLM,
-	); err != nil {
-		t.Fatalf("insert workflow template steps: %v", err)
+		INSERT INTO events (id, run_id, type, created_at) VALUES ($1, $2, 'TEST', $3)
+	`, uuid.New(), runID, future.AddDate(0, 1, 0)); err != nil {
+		t.Fatalf("insert event in next month: %v", err)
+	}
+
+	clk.Advance(24 * 31 * 3 * time.Hour)
+	dropped, err := eventRepo.DropEmptyEventPartitions(ctx)
+	if err != nil {
+		t.Fatalf("drop empty event partitions: %v", err)
+	}
+	if dropped == 0 {
+		t.Fatal("expected the empty partition to be dropped")
+	}
+
+	if eventPartitionExists(t, ctx, pool, eventPartitionName(future)) {
+		t.Fatal("expected the empty partition to be dropped")
+	}
+	if !eventPartitionExists(t, ctx, pool, eventPartitionName(future.AddDate(0, 1, 0))) {
+		t.Fatal("expected the partition holding an event to be kept")
+	}
+
+	if _, err := pool.Exec(ctx, `DELETE FROM events WHERE run_id=$1`, runID); err != nil {
+		t.Fatalf("delete test event: %v", err)
+	}
+	if _, err := eventRepo.DropEmptyEventPartitions(ctx); err != nil {
+		t.Fatalf("drop emptied partition: %v", err)
+	}
+	if eventPartitionExists(t, ctx, pool, eventPartitionName(future.AddDate(0, 1, 0))) {
+		t.Fatal("expected the emptied partition to be dropped")
+	}
+}
+
+func eventPartitionExists(t *testing.T, ctx context.Context, pool *pgxpool.Pool, name string) bool {
+	t.Helper()
+	var existing *string
+	if err := pool.QueryRow(ctx, `SELECT to_regclass($1)::text`, "public."+name).Scan(&existing); err != nil {
+		t.Fatalf("look up %s: %v", name, err)
+	}
+	return existing != nil
+}
+
+func TestListRunsFiltersByTagsAndMetadata(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := NewRunRepository(pool, logger)
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	otherKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create other api key: %v", err)
+	}
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+
+	customer42, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
+		Metadata: map[string]string{"customer": "42", "region": "eu"},
+		Tags:     []string{"nightly", "billing"},
+	})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if _, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
+		Metadata: map[string]string{"customer": "7"},
+		Tags:     []string{"nightly"},
+	}); err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if _, err := runRepo.CreateRun(auth.WithAPIKeyID(ctx, otherKeyID), domain.CreateRunParams{
+		Metadata: map[string]string{"customer": "42"},
+	}); err != nil {
+		t.Fatalf("create other tenant run: %v", err)
+	}
+
+	runs, err := runRepo.ListRuns(tenantCtx, domain.RunFilter{Metadata: map[string]string{"customer": "42"}})
+	if err != nil {
+		t.Fatalf("list runs by metadata: %v", err)
+	}
+	if len(runs) != 1 || runs[0].ID != customer42 {
+		t.Fatalf("expected only run %s for customer 42, got %+v", customer42, runs)
+	}
+	if runs[0].Metadata["region"] != "eu" || !slices.Equal(runs[0].Tags, []string{"billing", "nightly"}) {
+		t.Fatalf("expected metadata and sorted tags to round-trip, got %+v", runs[0])
+	}
+
+	runs, err = runRepo.ListRuns(tenantCtx, domain.RunFilter{Tags: []string{"nightly"}, Limit: 1})
+	if err != nil {
+		t.Fatalf("list runs by tag: %v", err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("expected a page of one run, got %d", len(runs))
+	}
+	next, err := runRepo.ListRuns(tenantCtx, domain.RunFilter{Tags: []string{"nightly"}, BeforeID: &runs[0].ID})
+	if err != nil {
+		t.Fatalf("list next page: %v", err)
+	}
+	if len(next) != 1 || next[0].ID == runs[0].ID {
+		t.Fatalf("expected the other nightly run on the next page, got %+v", next)
+	}
+
+	runs, err = runRepo.ListRuns(tenantCtx, domain.RunFilter{Tags: []string{"nightly", "missing"}})
+	if err != nil {
+		t.Fatalf("list runs by tags: %v", err)
+	}
+	if len(runs) != 0 {
+		t.Fatalf("expected runs to need every tag, got %d", len(runs))
+	}
+}
+
+func TestSecretsAreSealedAndCopiedOntoSteps(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+	if _, err := pool.Exec(ctx, `DELETE FROM secrets`); err != nil {
+		t.Fatalf("clear secrets: %v", err)
 	}
 
 	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
 	if err != nil {
 		t.Fatalf("create api key: %v", err)
 	}
-
 	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
-	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	runRepo := NewRunRepository(pool, logger)
-	stepRepo := NewStepRepository(pool, logger)
 
-	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
-		Priority:     9,
-		TemplateName: templateName,
-	})
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cipher, err := secrets.NewCipher(make([]byte, secrets.KeySize))
 	if err != nil {
-		t.Fatalf("create run with custom template: %v", err)
+		t.Fatalf("new cipher: %v", err)
+	}
+	secretRepo := NewSecretRepository(pool, logger)
+	if _, err := secretRepo.PutSecret(ctx, "API_TOKEN", "plain-value"); !errors.Is(err, domain.ErrSecretsKeyMissing) {
+		t.Fatalf("expected ErrSecretsKeyMissing without a cipher, got %v", err)
 	}
+	secretRepo.WithCipher(cipher)
 
-	var priority int
-	if err := pool.QueryRow(ctx, `
-		SELECT priority FROM runs WHERE id=$1
-	`, runID).Scan(&priority); err != nil {
-		t.Fatalf("query run priority: %v", err)
+	if _, err := secretRepo.PutSecret(ctx, "API_TOKEN", "old-value"); err != nil {
+		t.Fatalf("put secret: %v", err)
 	}
-	if priority != 9 {
-		t.Fatalf("expected run priority 9 got %d", priority)
+	if _, err := secretRepo.PutSecret(ctx, "API_TOKEN", "plain-value"); err != nil {
+		t.Fatalf("replace secret: %v", err)
+	}
+	var sealed []byte
+	if err := pool.QueryRow(ctx, `SELECT ciphertext FROM secrets WHERE name = 'API_TOKEN'`).Scan(&sealed); err != nil {
+		t.Fatalf("read ciphertext: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("plain-value")) {
+		t.Fatal("expected the stored value to be encrypted")
+	}
+	if value, err := cipher.Open("API_TOKEN", sealed); err != nil || string(value) != "plain-value" {
+		t.Fatalf("expected the replaced value, got %q (%v)", value, err)
 	}
 
-	steps, err := stepRepo.ListSteps(tenantCtx, runID)
+	list, err := secretRepo.ListSecrets(ctx)
+	if err != nil || len(list) != 1 || list[0].Name != "API_TOKEN" {
+		t.Fatalf("unexpected secrets %+v (%v)", list, err)
+	}
+	var auditRows int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM audit_log WHERE action = $1 AND after::text NOT LIKE '%value%'`, domain.AuditSecretPut).Scan(&auditRows); err != nil || auditRows != 2 {
+		t.Fatalf("expected 2 value-free audit entries, got %d (%v)", auditRows, err)
+	}
+
+	templateName := "secrets-" + uuid.NewString()[:8]
+	if _, err := NewTemplateRepository(pool, logger).PutWorkflowTemplate(ctx, templateName, []domain.WorkflowTemplateStep{
+		{Name: domain.StepTool, Secrets: []string{"API_TOKEN"}},
+	}); err != nil {
+		t.Fatalf("put template: %v", err)
+	}
+	runID, err := NewRunRepository(pool, logger).CreateRun(tenantCtx, domain.CreateRunParams{TemplateName: templateName})
 	if err != nil {
-		t.Fatalf("list steps: %v", err)
+		t.Fatalf("create run: %v", err)
 	}
-	if len(steps) != 2 {
-		t.Fatalf("expected 2 steps from custom template got %d", len(steps))
+	var stepSecrets []string
+	if err := pool.QueryRow(ctx, `SELECT secrets FROM steps WHERE run_id = $1`, runID).Scan(&stepSecrets); err != nil || len(stepSecrets) != 1 || stepSecrets[0] != "API_TOKEN" {
+		t.Fatalf("expected the step to reference API_TOKEN, got %v (%v)", stepSecrets, err)
 	}
-	if steps[0].Name != string(domain.StepTool) {
-		t.Fatalf("expected first step %s got %s", domain.StepTool, steps[0].Name)
+
+	if err := secretRepo.DeleteSecret(ctx, "API_TOKEN"); err != nil {
+		t.Fatalf("delete secret: %v", err)
 	}
-	if steps[1].Name != string(domain.StepLLM) {
-		t.Fatalf("expected second step %s got %s", domain.StepLLM, steps[1].Name)
+	if err := secretRepo.DeleteSecret(ctx, "API_TOKEN"); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows deleting twice, got %v", err)
 	}
 }
 
-func TestAPIKeyLifecycleRepositoryIntegration(t *testing.T) {
+func TestEncryptedColumnsOpenAndRekey(t *testing.T) {
 	ctx := context.Background()
 	pool := integrationPool(t, ctx)
 	defer pool.Close()
@@ -546,79 +3509,104 @@ func TestAPIKeyLifecycleRepositoryIntegration(t *testing.T) {
 		t.Skipf("skip integration test: database not reachable (%v)", err)
 	}
 
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	apiKeyRepo := NewAPIKeyRepository(pool, logger)
+	oldKey := base64.StdEncoding.EncodeToString(make([]byte, secrets.KeySize))
+	newKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, secrets.KeySize))
+	before, err := secrets.ParseKeyring("k1:" + oldKey)
+	if err != nil {
+		t.Fatalf("parse keyring: %v", err)
+	}
+	runRepo := NewRunRepository(pool, logger).WithKeyring(before)
 
-	created, err := apiKeyRepo.CreateAPIKey(ctx, domain.CreateAPIKeyParams{
-		Name:              "integration-key",
-		MaxConcurrentRuns: 7,
-		MaxRequestsPerMin: 70,
-	})
+	if _, err := NewAPIKeyRepository(pool, logger).WithKeyring(before).SetWebhookDefaults(ctx, apiKeyID, domain.SetWebhookDefaultsParams{
+		WebhookURL:    "https://example.com/hook",
+		WebhookSecret: "default-secret-value",
+	}); err != nil {
+		t.Fatalf("set webhook defaults: %v", err)
+	}
+	sourceID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
 	if err != nil {
-		t.Fatalf("create api key: %v", err)
+		t.Fatalf("create run: %v", err)
 	}
-	if created.ID == uuid.Nil {
-		t.Fatalf("expected created api key id")
+	first, err := runRepo.ReplayRun(tenantCtx, sourceID, map[int]json.RawMessage{1: json.RawMessage(`{"prompt":"a"}`)})
+	if err != nil {
+		t.Fatalf("replay run: %v", err)
 	}
-	if len(created.Token) <= len("sk_live_") || created.Token[:8] != "sk_live_" {
-		t.Fatalf("expected token prefix sk_live_, got %q", created.Token)
+	second, err := runRepo.ReplayRun(tenantCtx, first.ID, map[int]json.RawMessage{1: json.RawMessage(`{"model":"m"}`)})
+	if err != nil {
+		t.Fatalf("replay an encrypted run: %v", err)
 	}
 
-	var storedHash string
+	var (
+		runSecret string
+		override  json.RawMessage
+	)
 	if err := pool.QueryRow(ctx, `
-		SELECT token_hash
-		FROM api_keys
-		WHERE id=$1
-	`, created.ID).Scan(&storedHash); err != nil {
-		t.Fatalf("query token hash: %v", err)
+		SELECT r.webhook_secret, s.input_override
+		FROM runs r JOIN steps s ON s.run_id = r.id AND s.position = 1
+		WHERE r.id = $1
+	`, second.ID).Scan(&runSecret, &override); err != nil {
+		t.Fatalf("read replayed run: %v", err)
 	}
-
-	sum := sha256.Sum256([]byte(created.Token))
-	expectedHash := hex.EncodeToString(sum[:])
-	if storedHash != expectedHash {
-		t.Fatalf("expected token hash %s got %s", expectedHash, storedHash)
+	if !strings.HasPrefix(runSecret, secrets.SealedStringPrefix("k1")) || bytes.Contains(override, []byte("prompt")) {
+		t.Fatalf("expected sealed values, got %q and %s", runSecret, override)
 	}
-	if storedHash == created.Token {
-		t.Fatalf("raw token must not be stored")
+	if secret, err := before.OpenString(secrets.FieldWebhookSecret, runSecret); err != nil || secret != "default-secret-value" {
+		t.Fatalf("run webhook secret = %q (%v)", secret, err)
+	}
+	if input, err := before.OpenJSON(secrets.FieldStepInputOverride, override); err != nil || string(input) != `{"model":"m","prompt":"a"}` {
+		t.Fatalf("replay input = %s (%v)", input, err)
 	}
 
-	resolved, found, err := apiKeyRepo.ResolveAPIKey(ctx, created.Token)
+	// Plaintext from before encryption was turned on is sealed by Rekey too.
+	if _, err := pool.Exec(ctx, `UPDATE steps SET status = $2, output = '{"answer":42}' WHERE run_id = $1 AND position = 0`, sourceID, domain.StepSuccess); err != nil {
+		t.Fatalf("write plaintext output: %v", err)
+	}
+
+	after, err := secrets.ParseKeyring("k2:" + newKey + ",k1:" + oldKey)
 	if err != nil {
-		t.Fatalf("resolve api key: %v", err)
+		t.Fatalf("parse rotated keyring: %v", err)
 	}
-	if !found {
-		t.Fatalf("expected api key to resolve by raw token")
+	rekeyed, err := NewEncryptionRepository(pool, logger).WithKeyring(after).Rekey(ctx, 2)
+	if err != nil {
+		t.Fatalf("rekey: %v", err)
 	}
-	if resolved.ID != created.ID {
-		t.Fatalf("expected resolved id %s got %s", created.ID, resolved.ID)
+	if rekeyed < 5 {
+		t.Fatalf("expected at least 5 values re-sealed, got %d", rekeyed)
+	}
+	if again, err := NewEncryptionRepository(pool, logger).WithKeyring(after).Rekey(ctx, 2); err != nil || again != 0 {
+		t.Fatalf("second rekey re-sealed %d values (%v)", again, err)
 	}
 
-	keys, err := apiKeyRepo.ListAPIKeys(ctx)
+	onlyNew, err := secrets.ParseKeyring("k2:" + newKey)
 	if err != nil {
-		t.Fatalf("list api keys: %v", err)
+		t.Fatalf("parse new keyring: %v", err)
 	}
-	if len(keys) != 1 {
-		t.Fatalf("expected 1 api key got %d", len(keys))
-	}
-	if keys[0].ID != created.ID {
-		t.Fatalf("expected listed key %s got %s", created.ID, keys[0].ID)
+	var defaultSecret string
+	if err := pool.QueryRow(ctx, `SELECT default_webhook_secret FROM api_keys WHERE id = $1`, apiKeyID).Scan(&defaultSecret); err != nil {
+		t.Fatalf("read default webhook secret: %v", err)
 	}
-
-	if err := apiKeyRepo.RevokeAPIKey(ctx, created.ID); err != nil {
-		t.Fatalf("revoke api key: %v", err)
+	if secret, err := onlyNew.OpenString(secrets.FieldWebhookSecret, defaultSecret); err != nil || secret != "default-secret-value" {
+		t.Fatalf("default webhook secret after rekey = %q (%v)", secret, err)
 	}
 
-	_, found, err = apiKeyRepo.ResolveAPIKey(ctx, created.Token)
+	export, err := NewExportRepository(pool, logger).WithKeyring(onlyNew).ExportRun(tenantCtx, sourceID)
 	if err != nil {
-		t.Fatalf("resolve revoked api key: %v", err)
+		t.Fatalf("export run: %v", err)
 	}
-	if found {
-		t.Fatalf("expected revoked api key to be unresolved")
+	if string(export.Steps[0].Output) != `{"answer": 42}` {
+		t.Fatalf("exported output = %s", export.Steps[0].Output)
 	}
 }
 
 func truncateAll(ctx context.Context, pool *pgxpool.Pool) error {
-	_, err := pool.Exec(ctx, `TRUNCATE TABLE events, steps, run_requests, runs, api_keys RESTART IDENTITY CASCADE`)
+	_, err := pool.Exec(ctx, `TRUNCATE TABLE outbox_messages, run_archive, audit_log, tenant_purge_reports, events, steps, run_requests, runs, api_keys RESTART IDENTITY CASCADE`)
 	return err
 }
 
@@ -654,3 +3642,309 @@ func integrationPool(t *testing.T, ctx context.Context) *pgxpool.Pool {
 
 	return pool
 }
+
+func TestWorkerRegistryListsActiveWorkers(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fake := clock.NewFake(time.Now().UTC().Truncate(time.Second))
+	registry := NewWorkerRepository(pool, logger).WithClock(fake)
+
+	stale := domain.WorkerRecord{ID: uuid.New(), APIKeyID: apiKeyID, Hostname: "old", Version: "v1.3.0", MinSchemaVersion: 20}
+	if err := registry.RegisterWorker(ctx, stale); err != nil {
+		t.Fatalf("register stale worker: %v", err)
+	}
+	fake.Advance(domain.WorkerActiveWindow + time.Second)
+
+	llmPricing := domain.StepPricing{Model: "m", Unit: domain.PricingUnitToken, UnitPriceUSD: 0.001, MinUnits: 10, MaxUnits: 20}
+	current := domain.WorkerRecord{
+		ID: uuid.New(), APIKeyID: apiKeyID, Hostname: "new", Version: "v1.4.0", MinSchemaVersion: 22, Features: []string{"run_summary"},
+		Pricing: map[domain.StepName]domain.StepPricing{domain.StepLLM: llmPricing},
+	}
+	if err := registry.RegisterWorker(ctx, current); err != nil {
+		t.Fatalf("register current worker: %v", err)
+	}
+
+	workers, err := registry.ListActiveWorkers(ctx, apiKeyID)
+	if err != nil {
+		t.Fatalf("list active workers: %v", err)
+	}
+	if len(workers) != 1 || workers[0].ID != current.ID || !slices.Equal(workers[0].Features, []string{"run_summary"}) {
+		t.Fatalf("expected only the current worker, got %+v", workers)
+	}
+	if got := workers[0].Pricing[domain.StepLLM]; got != llmPricing {
+		t.Fatalf("expected declared LLM pricing %+v, got %+v", llmPricing, workers[0].Pricing)
+	}
+
+	if err := registry.TouchWorker(ctx, stale.ID); err != nil {
+		t.Fatalf("touch stale worker: %v", err)
+	}
+	workers, err = registry.ListActiveWorkers(ctx, apiKeyID)
+	if err != nil {
+		t.Fatalf("list active workers after touch: %v", err)
+	}
+	if len(workers) != 2 || len(domain.WorkerFleetWarnings(workers)) == 0 {
+		t.Fatalf("expected two mixed-version workers, got %+v", workers)
+	}
+
+	if err := registry.TouchWorker(ctx, uuid.New()); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected pgx.ErrNoRows for unknown worker, got %v", err)
+	}
+
+	// A RUNNING step leased to the current worker counts until the lease
+	// expires.
+	runID, err := NewRunRepository(pool, logger).CreateRun(auth.WithAPIKeyID(ctx, apiKeyID), domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps SET status=$2, claimed_by=$3, lease_expires_at=$4
+		WHERE run_id=$1 AND position=1
+	`, runID, domain.StepRunning, current.ID, fake.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("lease step: %v", err)
+	}
+	activeLeases := func() int {
+		t.Helper()
+		all, err := registry.ListAllActiveWorkers(ctx)
+		if err != nil {
+			t.Fatalf("list all active workers: %v", err)
+		}
+		for _, w := range all {
+			if w.ID == current.ID {
+				return w.ActiveLeases
+			}
+		}
+		t.Fatalf("expected current worker in %+v", all)
+		return 0
+	}
+	if got := activeLeases(); got != 1 {
+		t.Fatalf("expected one active lease, got %d", got)
+	}
+	fake.Advance(2 * time.Minute)
+	if err := registry.TouchWorker(ctx, current.ID); err != nil {
+		t.Fatalf("touch current worker: %v", err)
+	}
+	if got := activeLeases(); got != 0 {
+		t.Fatalf("expected the expired lease not to count, got %d", got)
+	}
+}
+
+func TestWorkerHeartbeatReportsLiveness(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	liveKey, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create live api key: %v", err)
+	}
+	orphanedKey, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create orphaned api key: %v", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fake := clock.NewFake(time.Now().UTC().Truncate(time.Second))
+	registry := NewWorkerRepository(pool, logger).WithClock(fake)
+
+	// The orphaned tenant has queued work and only a worker that stopped.
+	if _, err := NewRunRepository(pool, logger).CreateRun(auth.WithAPIKeyID(ctx, orphanedKey), domain.CreateRunParams{}); err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	stopped := domain.WorkerRecord{ID: uuid.New(), APIKeyID: orphanedKey, Hostname: "gone", Version: "v1.4.0"}
+	if err := registry.Heartbeat(ctx, stopped); err != nil {
+		t.Fatalf("heartbeat stopped worker: %v", err)
+	}
+	fake.Advance(domain.WorkerActiveWindow + time.Second)
+
+	// A heartbeat registers a worker whose row is missing.
+	live := domain.WorkerRecord{ID: uuid.New(), APIKeyID: liveKey, Hostname: "up", Version: "v1.4.0", InFlightSteps: 1}
+	if err := registry.Heartbeat(ctx, live); err != nil {
+		t.Fatalf("heartbeat live worker: %v", err)
+	}
+
+	liveness, err := registry.GetWorkerLiveness(ctx)
+	if err != nil {
+		t.Fatalf("get worker liveness: %v", err)
+	}
+	if len(liveness.Workers) != 2 || liveness.StaleWorkers != 1 {
+		t.Fatalf("expected one live and one stale worker, got %+v", liveness)
+	}
+	for _, w := range liveness.Workers {
+		if w.ID == live.ID && (w.Stale || w.InFlightSteps != 1) {
+			t.Fatalf("expected live worker with one in-flight step, got %+v", w)
+		}
+		if w.ID == stopped.ID && !w.Stale {
+			t.Fatalf("expected stopped worker to be stale, got %+v", w)
+		}
+	}
+	if len(liveness.TenantsWithoutLiveWorker) != 1 || liveness.TenantsWithoutLiveWorker[0] != orphanedKey {
+		t.Fatalf("expected only %s without a live worker, got %v", orphanedKey, liveness.TenantsWithoutLiveWorker)
+	}
+}
+
+func TestClaimNotificationsReportsOnceAndSkipsStale(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fake := clock.NewFake(time.Now().UTC().Truncate(time.Second))
+	runRepo := NewRunRepository(pool, logger)
+	notifications := NewNotificationRepository(pool, logger).WithClock(fake)
+
+	waitingRun, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create waiting run: %v", err)
+	}
+	staleRun, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create stale run: %v", err)
+	}
+	failedRun, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create failed run: %v", err)
+	}
+
+	for runID, since := range map[uuid.UUID]time.Time{
+		waitingRun: fake.Now().Add(-time.Minute),
+		staleRun:   fake.Now().Add(-2 * time.Hour),
+	} {
+		if _, err := pool.Exec(ctx,
+			`UPDATE steps SET status=$2, waiting_since=$4 WHERE run_id=$1 AND name=$3`,
+			runID, domain.StepWaiting, domain.StepApproval, since,
+		); err != nil {
+			t.Fatalf("set approval waiting: %v", err)
+		}
+	}
+	if _, err := pool.Exec(ctx,
+		`UPDATE steps SET status=$2, finished_at=NOW() WHERE run_id=$1 AND name=$3`,
+		failedRun, domain.StepFailed, domain.StepTool,
+	); err != nil {
+		t.Fatalf("fail tool step: %v", err)
+	}
+	// The error is read from the failure event: step output may be sealed.
+	if _, err := pool.Exec(ctx,
+		`INSERT INTO events (id, run_id, type, payload) VALUES ($1, $2, $3, '{"error":"tool exploded"}'::jsonb)`,
+		uuid.New(), failedRun, domain.EventStepFailed,
+	); err != nil {
+		t.Fatalf("insert failure event: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `UPDATE runs SET status=$2, updated_at=$3 WHERE id=$1`, failedRun, domain.RunFailed, fake.Now()); err != nil {
+		t.Fatalf("fail run: %v", err)
+	}
+
+	claimed, err := notifications.ClaimNotifications(ctx, time.Hour, 10)
+	if err != nil {
+		t.Fatalf("claim notifications: %v", err)
+	}
+	if len(claimed) != 2 {
+		t.Fatalf("expected 2 notifications (stale approval skipped), got %d", len(claimed))
+	}
+	var failure domain.Notification
+	for _, n := range claimed {
+		switch n.Kind {
+		case domain.NotificationApprovalWaiting:
+			if n.RunID != waitingRun || n.StepID == nil {
+				t.Fatalf("unexpected approval notification %+v", n)
+			}
+		case domain.NotificationRunFailed:
+			if n.RunID != failedRun || n.Error != "tool exploded" {
+				t.Fatalf("unexpected failure notification %+v", n)
+			}
+			failure = n
+		}
+	}
+
+	if again, err := notifications.ClaimNotifications(ctx, time.Hour, 10); err != nil || len(again) != 0 {
+		t.Fatalf("expected nothing left to claim, got %d (err=%v)", len(again), err)
+	}
+
+	if err := notifications.ReleaseNotification(ctx, failure); err != nil {
+		t.Fatalf("release notification: %v", err)
+	}
+	retried, err := notifications.ClaimNotifications(ctx, time.Hour, 10)
+	if err != nil {
+		t.Fatalf("reclaim notifications: %v", err)
+	}
+	if len(retried) != 1 || retried[0].RunID != failedRun {
+		t.Fatalf("expected the released failure to be claimed again, got %+v", retried)
+	}
+}
+
+func TestRepositoriesStampWritesWithTheirClock(t *testing.T) {
+	ctx := context.Background()
+	pool := integrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := truncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := createIntegrationAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fake := clock.NewFake(time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC))
+	runRepo := NewRunRepository(pool, logger).WithClock(fake)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if err := runRepo.CancelRun(tenantCtx, runID, "stop"); err != nil {
+		t.Fatalf("cancel run: %v", err)
+	}
+	var runUpdated, stepFinished time.Time
+	if err := pool.QueryRow(ctx, `
+		SELECT r.updated_at, MAX(s.finished_at)
+		FROM runs r
+		JOIN steps s ON s.run_id = r.id
+		WHERE r.id=$1
+		GROUP BY r.updated_at
+	`, runID).Scan(&runUpdated, &stepFinished); err != nil {
+		t.Fatalf("read cancel timestamps: %v", err)
+	}
+	if !runUpdated.Equal(fake.Now()) || !stepFinished.Equal(fake.Now()) {
+		t.Fatalf("expected cancel stamped %s, got run %s and steps %s", fake.Now(), runUpdated, stepFinished)
+	}
+
+	if err := NewAPIKeyRepository(pool, logger).WithClock(fake).RevokeAPIKey(ctx, apiKeyID); err != nil {
+		t.Fatalf("revoke api key: %v", err)
+	}
+	var revokedAt time.Time
+	if err := pool.QueryRow(ctx, `SELECT revoked_at FROM api_keys WHERE id=$1`, apiKeyID).Scan(&revokedAt); err != nil {
+		t.Fatalf("read revoked_at: %v", err)
+	}
+	if !revokedAt.Equal(fake.Now()) {
+		t.Fatalf("expected revoked_at %s, got %s", fake.Now(), revokedAt)
+	}
+}
@@ -3,10 +3,21 @@
 package repository
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
+	"net"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -41,3 +52,244 @@ func TestNewStepRepository(t *testing.T) {
 		t.Fatal("expected logger reference to be preserved")
 	}
 }
+
+func TestCreateRunRequestHash(t *testing.T) {
+	base := createRunRequestHash("https://example.com/hook", "", []string{"STEP_FAILED", "STEP_CLAIMED"}, 5, "default", nil, nil, nil, nil, nil)
+
+	if got := createRunRequestHash("https://example.com/hook", "", []string{"STEP_CLAIMED", "STEP_FAILED"}, 5, "default", nil, nil, nil, nil, nil); got != base {
+		t.Fatal("expected event order not to change the request hash")
+	}
+	if got := createRunRequestHash("https://example.com/hook", "", []string{"STEP_FAILED", "STEP_CLAIMED"}, 6, "default", nil, nil, nil, nil, nil); got == base {
+		t.Fatal("expected a different priority to change the request hash")
+	}
+	if got := createRunRequestHash("https://example.com/other", "", []string{"STEP_FAILED", "STEP_CLAIMED"}, 5, "default", nil, nil, nil, nil, nil); got == base {
+		t.Fatal("expected a different webhook url to change the request hash")
+	}
+	if got := createRunRequestHash("https://example.com/hook", "", []string{"STEP_FAILED", "STEP_CLAIMED"}, 5, "default", nil, map[string]string{"customer": "42"}, nil, nil, nil); got == base {
+		t.Fatal("expected metadata to change the request hash")
+	}
+	tagged := createRunRequestHash("https://example.com/hook", "", nil, 5, "default", nil, nil, []string{"b", "a"}, nil, nil)
+	if got := createRunRequestHash("https://example.com/hook", "", nil, 5, "default", nil, nil, []string{"a", "b"}, nil, nil); got != tagged {
+		t.Fatal("expected tag order not to change the request hash")
+	}
+	source := uuid.New()
+	if got := createRunRequestHash("https://example.com/hook", "", []string{"STEP_FAILED", "STEP_CLAIMED"}, 5, "default", nil, nil, nil, &source, nil); got == base {
+		t.Fatal("expected a replay to change the request hash")
+	}
+	replay := createRunRequestHash("https://example.com/hook", "", nil, 5, "default", nil, nil, nil, &source, nil)
+	if got := createRunRequestHash("https://example.com/hook", "", nil, 5, "default", nil, nil, nil, &source, map[int]json.RawMessage{0: json.RawMessage(`{"prompt":"hi"}`)}); got == replay {
+		t.Fatal("expected step inputs to change the request hash")
+	}
+	version := 2
+	if got := createRunRequestHash("https://example.com/hook", "", []string{"STEP_FAILED", "STEP_CLAIMED"}, 5, "default", &version, nil, nil, nil, nil); got == base {
+		t.Fatal("expected a pinned template version to change the request hash")
+	}
+
+	stored := base
+	if err := checkRequestHash(&stored, base); err != nil {
+		t.Fatalf("expected matching hash to pass, got %v", err)
+	}
+	if err := checkRequestHash(nil, base); err != nil {
+		t.Fatalf("expected legacy rows without a hash to pass, got %v", err)
+	}
+	other := "other"
+	if err := checkRequestHash(&other, base); !errors.Is(err, domain.ErrIdempotencyKeyReused) {
+		t.Fatalf("expected ErrIdempotencyKeyReused, got %v", err)
+	}
+}
+
+func TestEventPartitionName(t *testing.T) {
+	month := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	name := eventPartitionName(month)
+	if name != "events_p202603" {
+		t.Fatalf("expected events_p202603 got %s", name)
+	}
+
+	got, ok := parseEventPartitionName(name)
+	if !ok || !got.Equal(month) {
+		t.Fatalf("expected %s to parse back to %s, got %s (ok=%v)", name, month, got, ok)
+	}
+	for _, other := range []string{"events_default", "events_p2026", "events_p2026ab", "runs"} {
+		if _, ok := parseEventPartitionName(other); ok {
+			t.Fatalf("expected %s not to parse as a monthly partition", other)
+		}
+	}
+
+	if got := monthStart(time.Date(2026, time.December, 31, 23, 59, 0, 0, time.UTC)); !got.Equal(time.Date(2026, time.December, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected month start %s", got)
+	}
+}
+
+// stubQuerier stands in for a pool; read only compares identities.
+type stubQuerier struct {
+	querier
+	name string
+}
+
+func TestReadReplicaFallback(t *testing.T) {
+	ctx := context.Background()
+	primary := &stubQuerier{name: "primary"}
+	replicaPool := &stubQuerier{name: "replica"}
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	replica := NewReadReplica(nil, slog.New(slog.NewTextHandler(io.Discard, nil))).WithClock(fake)
+	replica.pool = replicaPool
+
+	// read returns the pools fn ran against; fn fails on the replica with
+	// replicaErr.
+	read := func(replicaErr error) []string {
+		var used []string
+		err := replica.read(ctx, primary, func(q querier) error {
+			used = append(used, q.(*stubQuerier).name)
+			if q == replicaPool {
+				return replicaErr
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return used
+	}
+	expect := func(got []string, want ...string) {
+		t.Helper()
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("expected reads on %v, got %v", want, got)
+		}
+	}
+
+	expect(read(nil), "replica")
+	// Not yet replicated: retry on the primary, keep using the replica.
+	expect(read(pgx.ErrNoRows), "replica", "primary")
+	expect(read(nil), "replica")
+
+	// Unreachable: retry on the primary and skip the replica while backing off.
+	expect(read(&net.OpError{Op: "dial", Err: errors.New("connection refused")}), "replica", "primary")
+	expect(read(nil), "primary")
+	fake.Advance(replicaBackoff)
+	expect(read(nil), "replica")
+
+	expect(read(&pgconn.PgError{Code: "57P01"}), "replica", "primary")
+
+	// Query errors are not the replica's fault and are returned as is.
+	fake.Advance(replicaBackoff)
+	queryErr := &pgconn.PgError{Code: "42P01"}
+	if err := replica.read(ctx, primary, func(q querier) error { return queryErr }); !errors.Is(err, queryErr) {
+		t.Fatalf("expected query error, got %v", err)
+	}
+
+	// Without a replica everything reads from the primary.
+	var none *ReadReplica
+	if err := none.read(ctx, primary, func(q querier) error {
+		if q != primary {
+			t.Fatal("expected the primary")
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	err := notFound(pgx.ErrNoRows, domain.ErrRunNotFound)
+	if !errors.Is(err, domain.ErrRunNotFound) || !errors.Is(err, domain.ErrNotFound) || !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected run not found wrapping pgx.ErrNoRows, got %v", err)
+	}
+	if again := notFound(err, domain.ErrStepNotFound); again != err {
+		t.Fatalf("expected an already mapped error unchanged, got %v", again)
+	}
+
+	other := errors.New("connection reset")
+	if got := notFound(other, domain.ErrRunNotFound); got != other {
+		t.Fatalf("expected other errors unchanged, got %v", got)
+	}
+	if got := notFound(nil, domain.ErrRunNotFound); got != nil {
+		t.Fatalf("expected nil, got %v", got)
+	}
+}
+
+func TestNewStepQueueRepository(t *testing.T) {
+	repo := NewStepQueueRepository(nil, nil).WithWebhookMaxAttempts(0).WithApprovalLink(" ")
+	if repo.logger == nil {
+		t.Fatal("expected default logger to be set")
+	}
+	if repo.webhookMaxAttempts != domain.DefaultWebhookMaxAttempts || repo.approvalLink != domain.DefaultApprovalLink {
+		t.Fatalf("expected webhook defaults kept, got %d, %q", repo.webhookMaxAttempts, repo.approvalLink)
+	}
+
+	repo.WithWebhookMaxAttempts(4).WithApprovalLink("https://ops.example/runs/{run_id}")
+	if repo.webhookMaxAttempts != 4 || repo.approvalLink != "https://ops.example/runs/{run_id}" {
+		t.Fatalf("expected webhook settings applied, got %d, %q", repo.webhookMaxAttempts, repo.approvalLink)
+	}
+}
+
+func TestTakeSlot(t *testing.T) {
+	slots := map[domain.StepName]int{domain.StepLLM: 1, domain.StepTool: 0}
+
+	if !takeSlot(slots, domain.StepLLM) {
+		t.Fatal("expected the last LLM slot to be taken")
+	}
+	if takeSlot(slots, domain.StepLLM) || takeSlot(slots, domain.StepTool) {
+		t.Fatal("expected no slot left for a type at its cap")
+	}
+	if !takeSlot(slots, domain.StepMap) {
+		t.Fatal("expected an uncapped type to always get a slot")
+	}
+	if !takeSlot(nil, domain.StepLLM) {
+		t.Fatal("expected no caps to allow every claim")
+	}
+}
+
+func TestRetryPolicyOverrides(t *testing.T) {
+	defaults := domain.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   2 * time.Second,
+		Backoff:     domain.RetryBackoffExponential,
+		Priority:    -5,
+	}
+
+	if got := retryPolicy(defaults, retryOverride{}); got != (domain.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   2 * time.Second,
+		Backoff:     domain.RetryBackoffExponential,
+		Priority:    -5,
+	}) {
+		t.Fatalf("expected worker-wide defaults without overrides, got %+v", got)
+	}
+
+	maxAttempts, baseMS, backoff, jitter, priority := 6, 500, "linear", true, 20
+	got := retryPolicy(defaults, retryOverride{
+		MaxAttempts: &maxAttempts,
+		BaseDelayMS: &baseMS,
+		Backoff:     &backoff,
+		Jitter:      &jitter,
+		Priority:    &priority,
+	})
+	if got != (domain.RetryPolicy{
+		MaxAttempts: 6,
+		BaseDelay:   500 * time.Millisecond,
+		Backoff:     domain.RetryBackoffLinear,
+		Jitter:      true,
+		Priority:    20,
+	}) {
+		t.Fatalf("expected overrides applied, got %+v", got)
+	}
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	repo := NewStepQueueRepository(nil, nil)
+	policy := domain.RetryPolicy{BaseDelay: 10 * time.Second, Backoff: domain.RetryBackoffFixed}
+
+	repo.jitter = func(n int64) int64 { return n - 1 }
+	if got := repo.retryDelay(policy, 1); got != 10*time.Second {
+		t.Fatalf("expected no jitter when disabled, got %s", got)
+	}
+
+	policy.Jitter = true
+	if got := repo.retryDelay(policy, 1); got != 10*time.Second {
+		t.Fatalf("expected max jitter to keep the full delay, got %s", got)
+	}
+	repo.jitter = func(n int64) int64 { return 0 }
+	if got := repo.retryDelay(policy, 1); got != 5*time.Second {
+		t.Fatalf("expected min jitter to halve the delay, got %s", got)
+	}
+}
@@ -9,11 +9,23 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/adiadia/agent-runtime/internal/audit"
 	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/adiadia/agent-runtime/internal/budget"
+	"github.com/adiadia/agent-runtime/internal/clock"
 	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/ids"
 	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/adiadia/agent-runtime/internal/outbox"
+	"github.com/adiadia/agent-runtime/internal/redact"
+	"github.com/adiadia/agent-runtime/internal/runsummary"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	"github.com/adiadia/agent-runtime/internal/transition"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -21,8 +33,13 @@ import (
 )
 
 type RunRepository struct {
-	pool   *pgxpool.Pool
-	logger *slog.Logger
+	pool           *pgxpool.Pool
+	logger         *slog.Logger
+	clock          clock.Clock
+	idempotencyTTL time.Duration
+	replica        *ReadReplica
+	keyring        *secrets.Keyring
+	redactor       *redact.Redactor
 }
 
 const defaultWorkflowTemplateName = "default"
@@ -38,36 +55,158 @@ func NewRunRepository(pool *pgxpool.Pool, logger *slog.Logger) *RunRepository {
 	}
 }
 
+// WithClock sets the clock used for idempotency-key, retention, and
+// reconciliation cutoffs, approval escalations and timeouts, monthly budgets,
+// queue statistics, and the run, step, and audit timestamps it writes.
+func (r *RunRepository) WithClock(c clock.Clock) *RunRepository {
+	r.clock = c
+	return r
+}
+
+// WithIdempotencyKeyTTL sets how long an Idempotency-Key maps to its run. A
+// key older than ttl is treated as unused and can create a new run; ttl <= 0
+// keeps keys forever.
+func (r *RunRepository) WithIdempotencyKeyTTL(ttl time.Duration) *RunRepository {
+	r.idempotencyTTL = ttl
+	return r
+}
+
+// WithReadReplica serves GetRun and GetRunCost from replica.
+func (r *RunRepository) WithReadReplica(replica *ReadReplica) *RunRepository {
+	r.replica = replica
+	return r
+}
+
+// WithKeyring encrypts run webhook secrets and step input overrides under
+// keyring before they are stored.
+func (r *RunRepository) WithKeyring(keyring *secrets.Keyring) *RunRepository {
+	r.keyring = keyring
+	return r
+}
+
+// WithRedactor applies redactor to the event payloads and cancel reasons the
+// repository writes.
+func (r *RunRepository) WithRedactor(redactor *redact.Redactor) *RunRepository {
+	r.redactor = redactor
+	return r
+}
+
 func (r *RunRepository) CreateRun(ctx context.Context, params domain.CreateRunParams) (uuid.UUID, error) {
-	runID := uuid.New()
+	created, err := r.SubmitRun(ctx, params)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return created.ID, nil
+}
+
+// SubmitRun creates a run and reports the webhook secret when one had to be
+// generated. The webhook URL and secret fall back to the API key's defaults.
+// A run without its own secret is signed with the tenant's versioned signing
+// keys when it has any, else with the key's default secret; a secret is
+// generated only for a new run that ends up with a webhook URL but neither,
+// so idempotent replays never mint a second secret.
+func (r *RunRepository) SubmitRun(ctx context.Context, params domain.CreateRunParams) (domain.CreatedRun, error) {
+	runID := ids.New()
 	apiKeyID, err := apiKeyIDFromContext(ctx)
 	if err != nil {
 		r.logger.Warn("create run denied: missing api key id", "error", err)
-		return uuid.Nil, err
+		return domain.CreatedRun{}, err
 	}
 	idempotencyKey, hasIdempotencyKey := auth.IdempotencyKeyFromContext(ctx)
 	webhookURL := strings.TrimSpace(params.WebhookURL)
+	webhookSecret := params.WebhookSecret
+	webhookEvents := params.WebhookEvents
+	if webhookEvents == nil {
+		webhookEvents = []string{}
+	}
 	templateName := strings.TrimSpace(params.TemplateName)
 	if templateName == "" {
 		templateName = defaultWorkflowTemplateName
 	}
+	metadata, err := domain.NormalizeRunMetadata(params.Metadata)
+	if err != nil {
+		return domain.CreatedRun{}, err
+	}
+	tags, err := domain.NormalizeRunTags(params.Tags)
+	if err != nil {
+		return domain.CreatedRun{}, err
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return domain.CreatedRun{}, err
+	}
+	if metadata == nil {
+		metadataJSON = []byte("{}")
+	}
+	stepInputs, err := domain.NormalizeStepInputs(params.StepInputs)
+	if err != nil {
+		return domain.CreatedRun{}, err
+	}
+	requestHash := createRunRequestHash(webhookURL, webhookSecret, webhookEvents, params.Priority, templateName, params.TemplateVersion, metadata, tags, params.ReplayedFromRunID, stepInputs)
 
 	tx, err := r.pool.Begin(ctx)
 	if err != nil {
 		r.logger.Error("begin tx failed", "error", err)
-		return uuid.Nil, err
+		return domain.CreatedRun{}, err
 	}
 	defer tx.Rollback(ctx)
 
 	if hasIdempotencyKey {
-		var existingRunID uuid.UUID
+		// Claim the idempotency key before inserting anything. Concurrent
+		// requests for the same key block here until the winner commits, then
+		// observe its run_requests row instead of inserting an orphan run.
+		if _, err := tx.Exec(ctx,
+			`SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`,
+			idempotencyLockKey(apiKeyID, idempotencyKey),
+		); err != nil {
+			r.logger.Error("claim idempotency key failed",
+				"api_key_id", apiKeyID,
+				"idempotency_key", idempotencyKey,
+				"error", err,
+			)
+			return domain.CreatedRun{}, err
+		}
+
+		if r.idempotencyTTL > 0 {
+			// An expired record no longer binds the key; drop it so the
+			// request below creates a fresh run under the same key.
+			if _, err := tx.Exec(ctx, `
+				DELETE FROM run_requests
+				WHERE api_key_id=$1
+				  AND idempotency_key=$2
+				  AND created_at < $3
+			`, apiKeyID, idempotencyKey, nowUTC(r.clock).Add(-r.idempotencyTTL)); err != nil {
+				r.logger.Error("expire idempotency key failed",
+					"api_key_id", apiKeyID,
+					"idempotency_key", idempotencyKey,
+					"error", err,
+				)
+				return domain.CreatedRun{}, err
+			}
+		}
+
+		var (
+			existingRunID uuid.UUID
+			existingHash  *string
+		)
 		err := tx.QueryRow(ctx, `
-			SELECT run_id
+			SELECT run_id, request_hash
 			FROM run_requests
 			WHERE api_key_id=$1 AND idempotency_key=$2
-		`, apiKeyID, idempotencyKey).Scan(&existingRunID)
+		`, apiKeyID, idempotencyKey).Scan(&existingRunID, &existingHash)
 		if err == nil {
-			return existingRunID, nil
+			if err := checkRequestHash(existingHash, requestHash); err != nil {
+				r.logger.Warn("idempotency key reused with different request",
+					"api_key_id", apiKeyID,
+					"idempotency_key", idempotencyKey,
+					"run_id", existingRunID,
+				)
+				return domain.CreatedRun{}, err
+			}
+			return domain.CreatedRun{ID: existingRunID}, nil
 		}
 		if !errors.Is(err, pgx.ErrNoRows) {
 			r.logger.Error("find idempotent run failed",
@@ -75,17 +214,22 @@ func (r *RunRepository) CreateRun(ctx context.Context, params domain.CreateRunPa
 				"idempotency_key", idempotencyKey,
 				"error", err,
 			)
-			return uuid.Nil, err
+			return domain.CreatedRun{}, err
 		}
 	}
 
-	var maxConcurrentRuns int
+	var (
+		maxConcurrentRuns    int
+		templateRunLimits    map[string]int
+		defaultWebhookURL    *string
+		defaultWebhookSecret *string
+	)
 	if err := tx.QueryRow(ctx,
-		`SELECT max_concurrent_runs FROM api_keys WHERE id=$1 FOR UPDATE`,
+		`SELECT max_concurrent_runs, max_concurrent_runs_per_template, default_webhook_url, default_webhook_secret FROM api_keys WHERE id=$1 FOR UPDATE`,
 		apiKeyID,
-	).Scan(&maxConcurrentRuns); err != nil {
+	).Scan(&maxConcurrentRuns, &templateRunLimits, &defaultWebhookURL, &defaultWebhookSecret); err != nil {
 		r.logger.Error("read api key limits failed", "api_key_id", apiKeyID, "error", err)
-		return uuid.Nil, err
+		return domain.CreatedRun{}, err
 	}
 
 	if maxConcurrentRuns <= 0 {
@@ -104,7 +248,7 @@ func (r *RunRepository) CreateRun(ctx context.Context, params domain.CreateRunPa
 		domain.RunWaiting,
 	).Scan(&activeRuns); err != nil {
 		r.logger.Error("count active runs failed", "api_key_id", apiKeyID, "error", err)
-		return uuid.Nil, err
+		return domain.CreatedRun{}, err
 	}
 
 	if activeRuns >= maxConcurrentRuns {
@@ -113,72 +257,197 @@ func (r *RunRepository) CreateRun(ctx context.Context, params domain.CreateRunPa
 			"active_runs", activeRuns,
 			"max_concurrent_runs", maxConcurrentRuns,
 		)
-		return uuid.Nil, fmt.Errorf("%w: active=%d limit=%d", domain.ErrMaxConcurrentRunsExceeded, activeRuns, maxConcurrentRuns)
+		return domain.CreatedRun{}, fmt.Errorf("%w: active=%d limit=%d", domain.ErrMaxConcurrentRunsExceeded, activeRuns, maxConcurrentRuns)
 	}
 
-	_, err = tx.Exec(ctx,
-		`INSERT INTO runs (id, api_key_id, status, webhook_url, priority) VALUES ($1, $2, $3, $4, $5)`,
-		runID, apiKeyID, domain.RunPending, nullString(webhookURL), params.Priority,
-	)
+	if templateLimit, ok := templateRunLimits[templateName]; ok {
+		var activeTemplateRuns int
+		if err := tx.QueryRow(ctx, `
+			SELECT COUNT(*)
+			FROM runs
+			WHERE api_key_id=$1
+			  AND template_name=$2
+			  AND status IN ($3, $4)
+		`,
+			apiKeyID,
+			templateName,
+			domain.RunRunning,
+			domain.RunWaiting,
+		).Scan(&activeTemplateRuns); err != nil {
+			r.logger.Error("count active template runs failed", "api_key_id", apiKeyID, "template_name", templateName, "error", err)
+			return domain.CreatedRun{}, err
+		}
+
+		if activeTemplateRuns >= templateLimit {
+			r.logger.Warn("create run blocked by template run limit",
+				"api_key_id", apiKeyID,
+				"template_name", templateName,
+				"active_runs", activeTemplateRuns,
+				"max_concurrent_runs", templateLimit,
+			)
+			return domain.CreatedRun{}, fmt.Errorf("%w: template=%s active=%d limit=%d", domain.ErrMaxConcurrentTemplateRunsExceeded, templateName, activeTemplateRuns, templateLimit)
+		}
+	}
+
+	monthly, err := budget.Load(ctx, tx, apiKeyID, nowUTC(r.clock))
 	if err != nil {
-		r.logger.Error("insert run failed", "run_id", runID, "api_key_id", apiKeyID, "error", err)
-		return uuid.Nil, err
+		r.logger.Error("read monthly budget failed", "api_key_id", apiKeyID, "error", err)
+		return domain.CreatedRun{}, err
+	}
+	if monthly.Exceeded() {
+		r.logger.Warn("create run blocked by monthly budget",
+			"api_key_id", apiKeyID,
+			"month_to_date_cost_usd", monthly.SpentUSD,
+			"monthly_budget_usd", *monthly.LimitUSD,
+		)
+		return domain.CreatedRun{}, fmt.Errorf("%w: spent=%.4f budget=%.4f", domain.ErrMonthlyBudgetExceeded, monthly.SpentUSD, *monthly.LimitUSD)
+	}
+
+	if webhookURL == "" && defaultWebhookURL != nil {
+		webhookURL = *defaultWebhookURL
+	}
+	usesSigningKeys := false
+	if webhookURL != "" && webhookSecret == "" {
+		if err := tx.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM webhook_signing_keys
+				WHERE api_key_id=$1 AND (expires_at IS NULL OR expires_at > $2)
+			)
+		`, apiKeyID, nowUTC(r.clock)).Scan(&usesSigningKeys); err != nil {
+			r.logger.Error("check webhook signing keys failed", "api_key_id", apiKeyID, "error", err)
+			return domain.CreatedRun{}, err
+		}
+	}
+	if webhookSecret == "" && defaultWebhookSecret != nil && !usesSigningKeys {
+		if webhookSecret, err = r.keyring.OpenString(secrets.FieldWebhookSecret, *defaultWebhookSecret); err != nil {
+			r.logger.Error("open default webhook secret failed", "api_key_id", apiKeyID, "error", err)
+			return domain.CreatedRun{}, err
+		}
+	}
+	var generatedSecret string
+	if webhookURL == "" {
+		webhookSecret = ""
+	} else if webhookSecret == "" && !usesSigningKeys {
+		generatedSecret, err = generateWebhookSecret()
+		if err != nil {
+			r.logger.Error("generate webhook secret failed", "run_id", runID, "error", err)
+			return domain.CreatedRun{}, err
+		}
+		webhookSecret = generatedSecret
 	}
 
-	templateSteps, err := r.loadWorkflowTemplateSteps(ctx, tx, templateName)
+	// The run pins the template version its steps are copied from, so
+	// later edits of the template never reach it.
+	templateVersion, templateSteps, err := r.loadWorkflowTemplateSteps(ctx, tx, templateName, params.TemplateVersion)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return uuid.Nil, fmt.Errorf("%w: %s", domain.ErrWorkflowTemplateNotFound, templateName)
+			if params.TemplateVersion != nil {
+				return domain.CreatedRun{}, fmt.Errorf("%w: %s version %d", domain.ErrWorkflowTemplateNotFound, templateName, *params.TemplateVersion)
+			}
+			return domain.CreatedRun{}, fmt.Errorf("%w: %s", domain.ErrWorkflowTemplateNotFound, templateName)
 		}
 		r.logger.Error("load workflow template failed",
 			"template_name", templateName,
 			"error", err,
 		)
-		return uuid.Nil, err
+		return domain.CreatedRun{}, err
+	}
+	for position := range stepInputs {
+		if position >= len(templateSteps) {
+			return domain.CreatedRun{}, fmt.Errorf("%w: template %s has no step at position %d", domain.ErrInvalidStepInput, templateName, position)
+		}
+	}
+
+	storedSecret, err := r.keyring.SealString(secrets.FieldWebhookSecret, webhookSecret)
+	if err != nil {
+		r.logger.Error("seal webhook secret failed", "run_id", runID, "error", err)
+		return domain.CreatedRun{}, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO runs (id, api_key_id, status, webhook_url, webhook_secret, webhook_events, priority, metadata, tags, template_name, template_version, replayed_from_run_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		runID, apiKeyID, domain.RunPending, nullString(webhookURL), nullString(storedSecret), webhookEvents, params.Priority, metadataJSON, tags, templateName, templateVersion, params.ReplayedFromRunID,
+	)
+	if err != nil {
+		r.logger.Error("insert run failed", "run_id", runID, "api_key_id", apiKeyID, "error", err)
+		return domain.CreatedRun{}, err
 	}
 
-	for _, step := range templateSteps {
+	for position, step := range templateSteps {
+		input, err := r.keyring.SealJSON(secrets.FieldStepInputOverride, stepInputs[position])
+		if err != nil {
+			r.logger.Error("seal step input failed", "run_id", runID, "position", position, "error", err)
+			return domain.CreatedRun{}, err
+		}
 		if _, err := tx.Exec(ctx,
-			`INSERT INTO steps (id, run_id, name, status, timeout_seconds)
-			 VALUES ($1, $2, $3, $4, $5)`,
-			uuid.New(),
+			`INSERT INTO steps (id, run_id, name, status, timeout_seconds, on_failure, condition, position, map_items, map_step, map_parallelism, approval_name,
+			                    approval_timeout_seconds, approval_timeout_action, max_attempts, retry_base_delay_ms, retry_backoff, retry_jitter, retry_priority, command,
+			                    http, secrets, input_override)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23)`,
+			ids.New(),
 			runID,
 			step.Name,
 			domain.StepPending,
 			nullInt64(step.TimeoutSeconds),
+			step.OnFailure,
+			nullString(step.Condition),
+			position,
+			step.mapItems(),
+			step.mapStep(),
+			step.mapParallelism(),
+			nullString(step.ApprovalName),
+			nullInt64(step.ApprovalTimeoutSeconds),
+			step.approvalTimeoutAction(),
+			nullInt64(step.MaxAttempts),
+			nullInt64(step.RetryBaseDelayMS),
+			step.retryBackoff(),
+			step.retryJitter(),
+			nullInt64(step.RetryPriority),
+			step.Command,
+			step.HTTP,
+			step.Secrets,
+			input,
 		); err != nil {
 			r.logger.Error("insert step failed",
 				"run_id", runID,
 				"step", step.Name,
 				"error", err,
 			)
-			return uuid.Nil, err
+			return domain.CreatedRun{}, err
 		}
 	}
 
 	if hasIdempotencyKey {
 		_, err := tx.Exec(ctx, `
-			INSERT INTO run_requests (id, api_key_id, idempotency_key, run_id)
-			VALUES ($1, $2, $3, $4)
+			INSERT INTO run_requests (id, api_key_id, idempotency_key, run_id, request_hash)
+			VALUES ($1, $2, $3, $4, $5)
 		`,
 			uuid.New(),
 			apiKeyID,
 			idempotencyKey,
 			runID,
+			requestHash,
 		)
 		if err != nil {
-			// If another request won the same idempotency key race, return its run_id.
+			// The advisory lock above should make this unreachable, but if another
+			// request still won the key (e.g. a writer that bypassed the lock), the
+			// deferred rollback discards this run and its steps and we return the
+			// winner's run_id.
 			if isUniqueViolation(err) {
-				existingRunID, getErr := r.getRunIDByRequest(ctx, apiKeyID, idempotencyKey)
+				existingRunID, existingHash, getErr := r.getRunRequest(ctx, apiKeyID, idempotencyKey)
 				if getErr != nil {
 					r.logger.Error("fetch winner idempotent run failed",
 						"api_key_id", apiKeyID,
 						"idempotency_key", idempotencyKey,
 						"error", getErr,
 					)
-					return uuid.Nil, getErr
+					return domain.CreatedRun{}, getErr
+				}
+				if err := checkRequestHash(existingHash, requestHash); err != nil {
+					return domain.CreatedRun{}, err
 				}
-				return existingRunID, nil
+				return domain.CreatedRun{ID: existingRunID}, nil
 			}
 
 			r.logger.Error("insert run request failed",
@@ -187,121 +456,1668 @@ func (r *RunRepository) CreateRun(ctx context.Context, params domain.CreateRunPa
 				"run_id", runID,
 				"error", err,
 			)
-			return uuid.Nil, err
+			return domain.CreatedRun{}, err
+		}
+	}
+
+	if err := outbox.EnqueueRunCreated(ctx, tx, runID, domain.RunCreatedMessage{
+		Status:       domain.RunPending,
+		TemplateName: templateName,
+		Priority:     params.Priority,
+		Metadata:     metadata,
+		Tags:         tags,
+	}); err != nil {
+		r.logger.Error("enqueue run created message failed", "run_id", runID, "error", err)
+		return domain.CreatedRun{}, err
+	}
+
+	after := map[string]any{
+		"status":           domain.RunPending,
+		"template_name":    templateName,
+		"template_version": templateVersion,
+		"priority":         params.Priority,
+		"webhook_url":      nullString(webhookURL),
+		"metadata":         metadata,
+		"tags":             tags,
+	}
+	if params.ReplayedFromRunID != nil {
+		after["replayed_from_run_id"] = params.ReplayedFromRunID
+		after["step_inputs"] = stepInputs
+	}
+	if err := audit.Record(ctx, tx, nowUTC(r.clock), audit.Change{
+		Action:     domain.AuditRunCreate,
+		APIKeyID:   apiKeyID,
+		TargetType: domain.AuditTargetRun,
+		TargetID:   runID.String(),
+		After:      after,
+	}); err != nil {
+		r.logger.Error("record run creation failed", "run_id", runID, "error", err)
+		return domain.CreatedRun{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("commit failed", "run_id", runID, "error", err)
+		return domain.CreatedRun{}, err
+	}
+
+	metrics.IncRunStatus(apiKeyID, string(domain.RunPending))
+	r.logger.Info("run created", "run_id", runID, "api_key_id", apiKeyID)
+	return domain.CreatedRun{ID: runID, WebhookSecret: generatedSecret}, nil
+}
+
+// ReplayRun creates a run from one of the tenant's runs: same template
+// version, priority, metadata, tags, and webhook, and the same step input
+// overrides, with stepInputs layered over them. The new run records the run it replays.
+// It returns domain.ErrRunNotFound when the source run is not the tenant's.
+func (r *RunRepository) ReplayRun(ctx context.Context, sourceID uuid.UUID, stepInputs map[int]json.RawMessage) (domain.CreatedRun, error) {
+	apiKeyID, err := apiKeyIDFromContext(ctx)
+	if err != nil {
+		r.logger.Warn("replay run denied: missing api key id", "run_id", sourceID, "error", err)
+		return domain.CreatedRun{}, err
+	}
+	stepInputs, err = domain.NormalizeStepInputs(stepInputs)
+	if err != nil {
+		return domain.CreatedRun{}, err
+	}
+
+	var (
+		params        = domain.CreateRunParams{ReplayedFromRunID: &sourceID}
+		templateName  *string
+		webhookURL    *string
+		webhookSecret *string
+	)
+	if err := r.pool.QueryRow(ctx, `
+		SELECT template_name, template_version, priority, metadata, tags, webhook_url, webhook_secret, webhook_events
+		FROM runs
+		WHERE id = $1 AND api_key_id = $2
+	`, sourceID, apiKeyID).Scan(
+		&templateName,
+		&params.TemplateVersion,
+		&params.Priority,
+		&params.Metadata,
+		&params.Tags,
+		&webhookURL,
+		&webhookSecret,
+		&params.WebhookEvents,
+	); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("read run to replay failed", "run_id", sourceID, "api_key_id", apiKeyID, "error", err)
+		}
+		return domain.CreatedRun{}, notFound(err, domain.ErrRunNotFound)
+	}
+	if templateName != nil {
+		params.TemplateName = *templateName
+	}
+	if webhookURL != nil {
+		params.WebhookURL = *webhookURL
+	}
+	if webhookSecret != nil {
+		if params.WebhookSecret, err = r.keyring.OpenString(secrets.FieldWebhookSecret, *webhookSecret); err != nil {
+			r.logger.Error("open webhook secret to replay failed", "run_id", sourceID, "error", err)
+			return domain.CreatedRun{}, err
+		}
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT position, input_override
+		FROM steps
+		WHERE run_id = $1 AND parent_step_id IS NULL AND input_override IS NOT NULL
+	`, sourceID)
+	if err != nil {
+		r.logger.Error("read step inputs to replay failed", "run_id", sourceID, "error", err)
+		return domain.CreatedRun{}, err
+	}
+	inherited := map[int]json.RawMessage{}
+	for rows.Next() {
+		var (
+			position int
+			input    json.RawMessage
+		)
+		if err := rows.Scan(&position, &input); err != nil {
+			rows.Close()
+			return domain.CreatedRun{}, err
+		}
+		if input, err = r.keyring.OpenJSON(secrets.FieldStepInputOverride, input); err != nil {
+			rows.Close()
+			r.logger.Error("open step input to replay failed", "run_id", sourceID, "position", position, "error", err)
+			return domain.CreatedRun{}, err
+		}
+		inherited[position] = input
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("scan step inputs to replay failed", "run_id", sourceID, "error", err)
+		return domain.CreatedRun{}, err
+	}
+	if params.StepInputs, err = domain.MergeStepInputs(inherited, stepInputs); err != nil {
+		return domain.CreatedRun{}, err
+	}
+
+	created, err := r.SubmitRun(ctx, params)
+	if err != nil {
+		return domain.CreatedRun{}, err
+	}
+	r.logger.Info("run replayed", "run_id", created.ID, "replayed_from_run_id", sourceID, "api_key_id", apiKeyID)
+	return created, nil
+}
+
+// PruneExpiredRunRequests deletes idempotency records older than ttl in
+// batches of batchSize. A ttl <= 0 keeps them forever. The runs themselves
+// are untouched.
+func (r *RunRepository) PruneExpiredRunRequests(ctx context.Context, ttl time.Duration, batchSize int) (int64, error) {
+	if ttl <= 0 {
+		return 0, nil
+	}
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	cutoff := nowUTC(r.clock).Add(-ttl)
+	var total int64
+	for {
+		tag, err := r.pool.Exec(ctx, `
+			DELETE FROM run_requests
+			WHERE id IN (
+				SELECT id
+				FROM run_requests
+				WHERE created_at < $1
+				LIMIT $2
+			)
+		`,
+			cutoff,
+			batchSize,
+		)
+		if err != nil {
+			r.logger.Error("prune expired run requests failed",
+				"ttl", ttl,
+				"pruned_so_far", total,
+				"error", err,
+			)
+			return total, err
+		}
+
+		total += tag.RowsAffected()
+		if tag.RowsAffected() < int64(batchSize) {
+			break
+		}
+	}
+
+	if total > 0 {
+		r.logger.Info("expired run requests pruned", "count", total, "ttl", ttl)
+	}
+	return total, nil
+}
+
+// expiredRunsFrom selects terminal runs whose last update is older than their
+// tenant's run retention: the key's run_retention_days, else $1 (0 keeps runs
+// forever). $2-$4 are the terminal statuses and $5 is now.
+const expiredRunsFrom = `
+	FROM runs r
+	JOIN api_keys k ON k.id = r.api_key_id
+	WHERE r.status IN ($2, $3, $4)
+	  AND COALESCE(k.run_retention_days, $1) > 0
+	  AND r.updated_at < $5::timestamp - make_interval(days => COALESCE(k.run_retention_days, $1))
+`
+
+// ExpireRuns removes terminal runs past their tenant's retention, batchSize
+// at a time, and returns how many went. In archive mode each run is first
+// copied with its steps and events into run_archive. Steps, events, webhook
+// deliveries, and idempotency records are deleted with the run; the
+// run_daily_stats aggregates are kept. A dry run changes nothing and returns
+// how many runs would go.
+func (r *RunRepository) ExpireRuns(ctx context.Context, policy domain.RunRetentionPolicy, batchSize int) (int64, error) {
+	if policy.DefaultDays < 0 {
+		policy.DefaultDays = 0
+	}
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	now := nowUTC(r.clock)
+	if policy.DryRun {
+		var count int64
+		if err := r.pool.QueryRow(ctx, `SELECT COUNT(*)`+expiredRunsFrom,
+			policy.DefaultDays,
+			domain.RunSuccess,
+			domain.RunFailed,
+			domain.RunCanceled,
+			now,
+		).Scan(&count); err != nil {
+			r.logger.Error("count expired runs failed", "default_retention_days", policy.DefaultDays, "error", err)
+			return 0, err
+		}
+		return count, nil
+	}
+
+	var total int64
+	for {
+		n, err := r.expireRunBatch(ctx, policy, now, batchSize)
+		total += n
+		if err != nil {
+			r.logger.Error("expire runs failed",
+				"default_retention_days", policy.DefaultDays,
+				"mode", policy.Mode,
+				"expired_so_far", total,
+				"error", err,
+			)
+			return total, err
+		}
+		if n < int64(batchSize) {
+			break
+		}
+	}
+
+	if total > 0 {
+		r.logger.Info("expired runs removed",
+			"count", total,
+			"mode", policy.Mode,
+			"default_retention_days", policy.DefaultDays,
+		)
+	}
+	return total, nil
+}
+
+func (r *RunRepository) expireRunBatch(ctx context.Context, policy domain.RunRetentionPolicy, now time.Time, batchSize int) (int64, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `SELECT r.id`+expiredRunsFrom+`
+		ORDER BY r.updated_at
+		LIMIT $6
+		FOR UPDATE OF r SKIP LOCKED
+	`,
+		policy.DefaultDays,
+		domain.RunSuccess,
+		domain.RunFailed,
+		domain.RunCanceled,
+		now,
+		batchSize,
+	)
+	if err != nil {
+		return 0, err
+	}
+	runIDs := make([]uuid.UUID, 0, batchSize)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		runIDs = append(runIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(runIDs) == 0 {
+		return 0, nil
+	}
+
+	if policy.Mode != domain.RunRetentionDelete {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO run_archive (id, api_key_id, status, created_at, finished_at, archived_at, run, steps, events)
+			SELECT r.id, r.api_key_id, r.status, r.created_at, r.updated_at, $2,
+			       to_jsonb(r) - 'webhook_secret',
+			       COALESCE((SELECT jsonb_agg(to_jsonb(s) ORDER BY s.position, s.map_index NULLS FIRST, s.created_at, s.id) FROM steps s WHERE s.run_id = r.id), '[]'::jsonb),
+			       COALESCE((SELECT jsonb_agg(to_jsonb(e) ORDER BY e.seq) FROM events e WHERE e.run_id = r.id), '[]'::jsonb)
+			FROM runs r
+			WHERE r.id = ANY($1)
+			ON CONFLICT (id) DO NOTHING
+		`, runIDs, now); err != nil {
+			return 0, err
+		}
+	}
+
+	tag, err := tx.Exec(ctx, `DELETE FROM runs WHERE id = ANY($1)`, runIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// EscalateWaitingApprovals escalates APPROVAL steps that have been waiting
+// longer than thresholds (ascending; the i-th threshold is level i+1). Each
+// escalation raises the step's escalation_level, adds priorityBoost to the
+// run's priority, and appends an APPROVAL_ESCALATED event. A step that passed
+// several thresholds since the last sweep jumps to the highest level with one
+// event. It returns the number of escalations.
+func (r *RunRepository) EscalateWaitingApprovals(ctx context.Context, thresholds []time.Duration, priorityBoost int, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	now := nowUTC(r.clock)
+	var total int64
+	for i := len(thresholds) - 1; i >= 0; i-- {
+		level := i + 1
+		for {
+			escalated, err := r.escalateApprovalBatch(ctx, now, level, thresholds[i], priorityBoost, batchSize)
+			total += escalated
+			if err != nil {
+				r.logger.Error("escalate waiting approvals failed",
+					"level", level,
+					"threshold", thresholds[i],
+					"escalated_so_far", total,
+					"error", err,
+				)
+				return total, err
+			}
+			metrics.AddApprovalEscalations(level, escalated)
+			if escalated < int64(batchSize) {
+				break
+			}
+		}
+	}
+
+	if total > 0 {
+		r.logger.Info("waiting approvals escalated", "count", total)
+	}
+	return total, nil
+}
+
+func (r *RunRepository) escalateApprovalBatch(ctx context.Context, now time.Time, level int, threshold time.Duration, priorityBoost int, batchSize int) (int64, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		UPDATE steps s
+		SET escalation_level=$1
+		WHERE s.id IN (
+			SELECT s2.id
+			FROM steps s2
+			JOIN runs r ON r.id = s2.run_id
+			WHERE s2.status = $2
+			  AND s2.escalation_level < $1
+			  AND s2.waiting_since <= $3
+			  AND r.status NOT IN ($4, $5, $6)
+			ORDER BY s2.waiting_since ASC
+			LIMIT $7
+			FOR UPDATE OF s2 SKIP LOCKED
+		)
+		RETURNING s.id, s.run_id, s.waiting_since
+	`,
+		level,
+		domain.StepWaiting,
+		now.Add(-threshold),
+		domain.RunSuccess,
+		domain.RunFailed,
+		domain.RunCanceled,
+		batchSize,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	type waitingApproval struct {
+		stepID       uuid.UUID
+		runID        uuid.UUID
+		waitingSince time.Time
+	}
+	due := make([]waitingApproval, 0, batchSize)
+	for rows.Next() {
+		var a waitingApproval
+		if err := rows.Scan(&a.stepID, &a.runID, &a.waitingSince); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, a := range due {
+		escalation := domain.ApprovalEscalation{
+			Level:            level,
+			ThresholdSeconds: threshold.Seconds(),
+			WaitingSeconds:   now.Sub(a.waitingSince).Seconds(),
+		}
+		if err := tx.QueryRow(ctx,
+			`UPDATE runs SET priority = priority + $2 WHERE id=$1 RETURNING priority`,
+			a.runID,
+			priorityBoost,
+		).Scan(&escalation.Priority); err != nil {
+			return 0, err
+		}
+
+		payload, err := json.Marshal(escalation)
+		if err != nil {
+			return 0, err
+		}
+		payload = r.redactor.JSON(payload)
+
+		eventID := ids.New()
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO events (id, run_id, step_id, type, payload)
+			 VALUES ($1, $2, $3, $4, $5::jsonb)`,
+			eventID,
+			a.runID,
+			a.stepID,
+			domain.EventApprovalEscalated,
+			payload,
+		); err != nil {
+			return 0, err
+		}
+		if err := outbox.EnqueueEvent(ctx, tx, eventID, domain.DefaultWebhookMaxAttempts); err != nil {
+			return 0, err
+		}
+
+		r.logger.Warn("approval escalated",
+			"run_id", a.runID,
+			"step_id", a.stepID,
+			"level", level,
+			"waiting_since", a.waitingSince,
+			"priority", escalation.Priority,
+		)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return int64(len(due)), nil
+}
+
+// TimeOutWaitingApprovals applies the approval timeout of APPROVAL steps that
+// have waited longer than their approval_timeout_seconds. Each gets a
+// STEP_APPROVAL_TIMED_OUT event and, per its approval_timeout_action, either
+// fails the step and the run or is approved like POST /runs/{id}/approve
+// would. Runs finished by a timeout get the run summary and the terminal
+// webhook. It returns the number of approvals timed out.
+func (r *RunRepository) TimeOutWaitingApprovals(ctx context.Context, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	var total int64
+	for {
+		timedOut, err := r.timeOutApprovalBatch(ctx, nowUTC(r.clock), batchSize)
+		total += timedOut
+		if err != nil {
+			r.logger.Error("time out waiting approvals failed",
+				"timed_out_so_far", total,
+				"error", err,
+			)
+			return total, err
 		}
+		if timedOut < int64(batchSize) {
+			break
+		}
+	}
+
+	if total > 0 {
+		r.logger.Warn("waiting approvals timed out", "count", total)
+	}
+	return total, nil
+}
+
+func (r *RunRepository) timeOutApprovalBatch(ctx context.Context, now time.Time, batchSize int) (int64, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Run rows are locked too, as approve and cancel do; SKIP LOCKED leaves
+	// approvals that are being decided right now for the next sweep.
+	rows, err := tx.Query(ctx, `
+		SELECT s.id, s.status, s.run_id, r.api_key_id, r.status, r.webhook_url, s.waiting_since,
+		       s.approval_timeout_seconds, COALESCE(s.approval_timeout_action, ''), COALESCE(s.approval_name, '')
+		FROM steps s
+		JOIN runs r ON r.id = s.run_id
+		WHERE s.status = $1
+		  AND s.name = $2
+		  AND s.approval_timeout_seconds IS NOT NULL
+		  AND s.waiting_since + make_interval(secs => s.approval_timeout_seconds) <= $3
+		  AND r.status NOT IN ($4, $5, $6)
+		ORDER BY s.waiting_since ASC
+		LIMIT $7
+		FOR UPDATE OF s, r SKIP LOCKED
+	`,
+		domain.StepWaiting,
+		domain.StepApproval,
+		now,
+		domain.RunSuccess,
+		domain.RunFailed,
+		domain.RunCanceled,
+		batchSize,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	type expiredApproval struct {
+		stepID         uuid.UUID
+		stepStatus     domain.StepStatus
+		runID          uuid.UUID
+		apiKeyID       uuid.UUID
+		runStatus      domain.RunStatus
+		webhookURL     sql.NullString
+		waitingSince   time.Time
+		timeoutSeconds int
+		action         string
+		name           string
+	}
+	due := make([]expiredApproval, 0, batchSize)
+	for rows.Next() {
+		var a expiredApproval
+		if err := rows.Scan(&a.stepID, &a.stepStatus, &a.runID, &a.apiKeyID, &a.runStatus, &a.webhookURL, &a.waitingSince,
+			&a.timeoutSeconds, &a.action, &a.name); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	type timedOutApproval struct {
+		apiKeyID   uuid.UUID
+		action     domain.ApprovalTimeoutAction
+		stepStatus domain.StepStatus
+		runStatus  domain.RunStatus
+	}
+	timedOut := make([]timedOutApproval, 0, len(due))
+	for _, a := range due {
+		action, err := domain.ParseApprovalTimeoutAction(a.action)
+		if err != nil {
+			return 0, err
+		}
+
+		payload, err := json.Marshal(domain.ApprovalTimeout{
+			Action:         action,
+			TimeoutSeconds: a.timeoutSeconds,
+			WaitingSeconds: now.Sub(a.waitingSince).Seconds(),
+			ApprovalName:   a.name,
+		})
+		if err != nil {
+			return 0, err
+		}
+		if err := r.insertApprovalEvent(ctx, tx, a.runID, &a.stepID, domain.EventApprovalTimedOut, payload); err != nil {
+			return 0, err
+		}
+
+		var (
+			stepStatus domain.StepStatus
+			runStatus  domain.RunStatus
+		)
+		switch action {
+		case domain.ApprovalTimeoutApprove:
+			stepStatus = domain.StepSuccess
+			runStatus, err = r.approveTimedOutStep(ctx, tx, a.runID, a.stepID, a.stepStatus, a.runStatus, a.name)
+		default:
+			stepStatus = domain.StepFailed
+			runStatus, err = r.failTimedOutStep(ctx, tx, a.runID, a.stepID, a.stepStatus, a.runStatus, a.timeoutSeconds)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if runStatus.IsTerminal() {
+			var finishedAt time.Time
+			if err := tx.QueryRow(ctx, `SELECT updated_at FROM runs WHERE id=$1`, a.runID).Scan(&finishedAt); err != nil {
+				return 0, err
+			}
+			if err := runsummary.Emit(ctx, tx, a.runID, domain.DefaultWebhookMaxAttempts); err != nil {
+				return 0, err
+			}
+			if err := outbox.EnqueueTerminalWebhook(ctx, tx, a.runID, runStatus, finishedAt.UTC(), a.webhookURL.String, domain.DefaultWebhookMaxAttempts); err != nil {
+				return 0, err
+			}
+		}
+
+		timedOut = append(timedOut, timedOutApproval{
+			apiKeyID:   a.apiKeyID,
+			action:     action,
+			stepStatus: stepStatus,
+			runStatus:  runStatus,
+		})
+		r.logger.Warn("approval timed out",
+			"run_id", a.runID,
+			"step_id", a.stepID,
+			"action", action,
+			"waiting_since", a.waitingSince,
+			"timeout_seconds", a.timeoutSeconds,
+			"run_status", runStatus,
+		)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	for _, a := range timedOut {
+		metrics.IncApprovalTimeout(string(a.action))
+		metrics.IncStepStatus(a.apiKeyID, string(a.stepStatus))
+		metrics.IncRunStatus(a.apiKeyID, string(a.runStatus))
+	}
+	return int64(len(timedOut)), nil
+}
+
+// approveTimedOutStep approves a waiting APPROVAL step on behalf of its
+// timeout and moves the run on. stepStatus and current are the statuses the
+// caller locked the step and run rows with. It returns the run's new status.
+func (r *RunRepository) approveTimedOutStep(ctx context.Context, tx pgx.Tx, runID, stepID uuid.UUID, stepStatus domain.StepStatus, current domain.RunStatus, approvalName string) (domain.RunStatus, error) {
+	if err := transition.Step(r.logger, stepID, stepStatus, domain.StepSuccess); err != nil {
+		return "", err
+	}
+	now := nowUTC(r.clock)
+	if _, err := tx.Exec(ctx, `
+		UPDATE steps
+		SET status=$2,
+		    started_at=COALESCE(started_at, $3),
+		    finished_at=COALESCE(finished_at, $3)
+		WHERE id=$1
+	`, stepID, domain.StepSuccess, now); err != nil {
+		return "", err
+	}
+
+	approval := map[string]any{
+		"status":      domain.StepSuccess,
+		"approved_by": "timeout",
+	}
+	if approvalName != "" {
+		approval["approval_name"] = approvalName
+	}
+	stepPayload, err := json.Marshal(approval)
+	if err != nil {
+		return "", err
+	}
+	if err := r.insertApprovalEvent(ctx, tx, runID, &stepID, domain.EventStepApproved, stepPayload); err != nil {
+		return "", err
+	}
+	if err := r.insertApprovalEvent(ctx, tx, runID, nil, domain.EventRunApproved, []byte(`{"approved_by":"timeout"}`)); err != nil {
+		return "", err
+	}
+
+	var remaining int
+	if err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM steps
+		WHERE run_id=$1
+		  AND status NOT IN ($2, $3)
+		  AND NOT (status=$4 AND on_failure=$5)
+	`, runID, domain.StepSuccess, domain.StepSkipped, domain.StepFailed, domain.OnFailureContinue).Scan(&remaining); err != nil {
+		return "", err
+	}
+
+	next := domain.RunRunning
+	if remaining == 0 {
+		next = domain.RunSuccess
+	}
+	if err := transition.Run(r.logger, runID, current, next); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE runs SET status=$2, updated_at=$3 WHERE id=$1`, runID, next, now); err != nil {
+		return "", err
+	}
+	return next, nil
+}
+
+// failTimedOutStep fails a waiting APPROVAL step whose timeout ran out, and
+// its run. It returns the run's new status.
+func (r *RunRepository) failTimedOutStep(ctx context.Context, tx pgx.Tx, runID, stepID uuid.UUID, stepStatus domain.StepStatus, current domain.RunStatus, timeoutSeconds int) (domain.RunStatus, error) {
+	return r.failApprovalStep(ctx, tx, runID, stepID, stepStatus, current, fmt.Sprintf("approval timed out after %ds", timeoutSeconds))
+}
+
+// failApprovalStep fails a waiting APPROVAL step with message as its error,
+// and the run with it. stepStatus and current are the statuses the caller
+// locked the step and run rows with. It returns the run's new status.
+func (r *RunRepository) failApprovalStep(ctx context.Context, tx pgx.Tx, runID, stepID uuid.UUID, stepStatus domain.StepStatus, current domain.RunStatus, message string) (domain.RunStatus, error) {
+	if err := transition.Step(r.logger, stepID, stepStatus, domain.StepFailed); err != nil {
+		return "", err
+	}
+	if err := transition.Run(r.logger, runID, current, domain.RunFailed); err != nil {
+		return "", err
+	}
+
+	output, err := json.Marshal(map[string]string{"error": message})
+	if err != nil {
+		return "", err
+	}
+	if output, err = r.keyring.SealJSON(secrets.FieldStepOutput, output); err != nil {
+		return "", err
+	}
+	now := nowUTC(r.clock)
+	if _, err := tx.Exec(ctx, `
+		UPDATE steps
+		SET status=$2,
+		    output=$3::jsonb,
+		    finished_at=$4
+		WHERE id=$1
+	`, stepID, domain.StepFailed, output, now); err != nil {
+		return "", err
+	}
+
+	stepPayload, err := json.Marshal(map[string]any{
+		"status": domain.StepFailed,
+		"step":   domain.StepApproval,
+		"error":  message,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := r.insertApprovalEvent(ctx, tx, runID, &stepID, domain.EventStepFailed, stepPayload); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE runs SET status=$2, updated_at=$3 WHERE id=$1`, runID, domain.RunFailed, now); err != nil {
+		return "", err
+	}
+	return domain.RunFailed, nil
+}
+
+// insertApprovalEvent appends an event written by the approval sweeps and
+// queues its outbox message and, for runs subscribed to the type, its webhook.
+func (r *RunRepository) insertApprovalEvent(ctx context.Context, tx pgx.Tx, runID uuid.UUID, stepID *uuid.UUID, eventType string, payload []byte) error {
+	payload = r.redactor.JSON(payload)
+	eventID := ids.New()
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO events (id, run_id, step_id, type, payload)
+		 VALUES ($1, $2, $3, $4, $5::jsonb)`,
+		eventID,
+		runID,
+		stepID,
+		eventType,
+		payload,
+	); err != nil {
+		return err
+	}
+	return outbox.EnqueueEvent(ctx, tx, eventID, domain.DefaultWebhookMaxAttempts)
+}
+
+// ReconcileStaleRuns fixes runs left RUNNING or WAITING_APPROVAL although none of their steps can make progress, e.g. because the update
+// that should have finished the run raced. Runs untouched for staleAfter whose
+// steps have all stopped get the status recomputed from those steps, a
+// RUN_RECONCILED event, the run summary, and the terminal webhook. It returns
+// the number of runs reconciled.
+func (r *RunRepository) ReconcileStaleRuns(ctx context.Context, staleAfter time.Duration, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	cutoff := nowUTC(r.clock).Add(-staleAfter)
+	var total int64
+	for {
+		reconciled, err := r.reconcileRunBatch(ctx, cutoff, batchSize)
+		total += reconciled
+		if err != nil {
+			r.logger.Error("reconcile stale runs failed",
+				"stale_after", staleAfter,
+				"reconciled_so_far", total,
+				"error", err,
+			)
+			return total, err
+		}
+		if reconciled < int64(batchSize) {
+			break
+		}
+	}
+
+	if total > 0 {
+		r.logger.Warn("stale runs reconciled", "count", total)
+	}
+	return total, nil
+}
+
+func (r *RunRepository) reconcileRunBatch(ctx context.Context, cutoff time.Time, batchSize int) (int64, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT r.id, r.api_key_id, r.status, r.updated_at, r.webhook_url
+		FROM runs r
+		WHERE r.status IN ($1, $2)
+		  AND r.updated_at <= $3
+		  AND NOT EXISTS (
+			SELECT 1 FROM steps s
+			WHERE s.run_id = r.id
+			  AND s.status IN ($4, $5, $6)
+		  )
+		ORDER BY r.updated_at ASC
+		LIMIT $7
+		FOR UPDATE OF r SKIP LOCKED
+	`,
+		domain.RunRunning,
+		domain.RunWaiting,
+		cutoff,
+		domain.StepPending,
+		domain.StepRunning,
+		domain.StepWaiting,
+		batchSize,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	type staleRun struct {
+		id         uuid.UUID
+		apiKeyID   uuid.UUID
+		status     domain.RunStatus
+		updatedAt  time.Time
+		webhookURL sql.NullString
+	}
+	stale := make([]staleRun, 0, batchSize)
+	for rows.Next() {
+		var run staleRun
+		if err := rows.Scan(&run.id, &run.apiKeyID, &run.status, &run.updatedAt, &run.webhookURL); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stale = append(stale, run)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	type reconciledRun struct {
+		apiKeyID uuid.UUID
+		status   domain.RunStatus
+	}
+	reconciled := make([]reconciledRun, 0, len(stale))
+	for _, run := range stale {
+		outcomes, err := loadStepOutcomes(ctx, tx, run.id)
+		if err != nil {
+			return 0, err
+		}
+		status, ok := domain.ReconcileRunStatus(outcomes)
+		if !ok {
+			continue
+		}
+		if transition.Run(r.logger, run.id, run.status, status) != nil {
+			// Reported as an anomaly; leave the run for an operator.
+			continue
+		}
+
+		var finishedAt time.Time
+		if err := tx.QueryRow(ctx,
+			`UPDATE runs SET status=$2, updated_at=$3 WHERE id=$1 RETURNING updated_at`,
+			run.id,
+			status,
+			nowUTC(r.clock),
+		).Scan(&finishedAt); err != nil {
+			return 0, err
+		}
+
+		payload, err := json.Marshal(domain.RunReconciliation{
+			From:          run.status,
+			To:            status,
+			LastUpdatedAt: run.updatedAt.UTC(),
+		})
+		if err != nil {
+			return 0, err
+		}
+		payload = r.redactor.JSON(payload)
+
+		eventID := ids.New()
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO events (id, run_id, type, payload)
+			 VALUES ($1, $2, $3, $4::jsonb)`,
+			eventID,
+			run.id,
+			domain.EventRunReconciled,
+			payload,
+		); err != nil {
+			return 0, err
+		}
+		if err := outbox.EnqueueEvent(ctx, tx, eventID, domain.DefaultWebhookMaxAttempts); err != nil {
+			return 0, err
+		}
+		if err := runsummary.Emit(ctx, tx, run.id, domain.DefaultWebhookMaxAttempts); err != nil {
+			return 0, err
+		}
+		if status != domain.RunCanceled {
+			if err := outbox.EnqueueTerminalWebhook(ctx, tx, run.id, status, finishedAt.UTC(), run.webhookURL.String, domain.DefaultWebhookMaxAttempts); err != nil {
+				return 0, err
+			}
+		}
+
+		reconciled = append(reconciled, reconciledRun{apiKeyID: run.apiKeyID, status: status})
+		r.logger.Warn("run reconciled",
+			"run_id", run.id,
+			"from", run.status,
+			"to", status,
+			"last_updated_at", run.updatedAt,
+		)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	for _, run := range reconciled {
+		metrics.IncRunReconciled(string(run.status))
+		metrics.IncRunStatus(run.apiKeyID, string(run.status))
+	}
+	return int64(len(reconciled)), nil
+}
+
+// pendingStepsQuery selects the PENDING non-approval steps of active runs with
+// the tenant, whether a worker could claim the step now (the worker's claim
+// condition minus concurrency and budget limits), and when it became ready:
+// since it was created, its retry came due, or the step before it settled,
+// whichever is latest. Its arguments come from pendingStepsArgs.
+const pendingStepsQuery = `
+	SELECT r.api_key_id,
+	       (st.next_run_at IS NULL OR st.next_run_at <= $2)
+	       AND NOT EXISTS (
+			SELECT 1 FROM steps s2
+			WHERE s2.run_id = st.run_id
+			  AND s2.position < st.position
+			  AND s2.status NOT IN ($7, $8)
+			  AND NOT (s2.status = $9 AND s2.on_failure = $10)
+	       ) AS runnable,
+	       GREATEST(COALESCE(st.next_run_at, st.created_at), COALESCE(prev.finished_at, st.created_at)) AS ready_since
+	FROM steps st
+	JOIN runs r ON r.id = st.run_id
+	LEFT JOIN LATERAL (
+		SELECT MAX(s2.finished_at) AS finished_at
+		FROM steps s2
+		WHERE s2.run_id = st.run_id
+		  AND s2.position < st.position
+	) prev ON TRUE
+	WHERE st.status = $1
+	  AND st.name <> $3
+	  AND r.status NOT IN ($4, $5, $6)
+`
+
+func pendingStepsArgs(now time.Time) []any {
+	return []any{
+		domain.StepPending,
+		now,
+		domain.StepApproval,
+		domain.RunCanceled,
+		domain.RunFailed,
+		domain.RunSuccess,
+		domain.StepSuccess,
+		domain.StepSkipped,
+		domain.StepFailed,
+		domain.OnFailureContinue,
+	}
+}
+
+// GetQueueStats returns the runtime-wide backlog across all tenants.
+func (r *RunRepository) GetQueueStats(ctx context.Context) (domain.QueueStats, error) {
+	now := nowUTC(r.clock)
+
+	var (
+		stats       domain.QueueStats
+		oldestReady *time.Time
+	)
+	if err := r.pool.QueryRow(ctx, `
+		SELECT COUNT(*),
+		       COUNT(*) FILTER (WHERE runnable),
+		       MIN(ready_since) FILTER (WHERE runnable)
+		FROM (`+pendingStepsQuery+`) pending
+	`, pendingStepsArgs(now)...).Scan(&stats.PendingSteps, &stats.RunnableSteps, &oldestReady); err != nil {
+		r.logger.Error("get queue stats failed", "error", err)
+		return domain.QueueStats{}, err
+	}
+	if oldestReady != nil && now.After(*oldestReady) {
+		stats.OldestPendingAge = now.Sub(*oldestReady)
+	}
+
+	if err := r.pool.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT st.run_id)
+		FROM steps st
+		JOIN runs r ON r.id = st.run_id
+		WHERE st.status = $1
+		  AND r.status NOT IN ($2, $3, $4)
+	`,
+		domain.StepWaiting,
+		domain.RunCanceled,
+		domain.RunFailed,
+		domain.RunSuccess,
+	).Scan(&stats.WaitingApprovalRuns); err != nil {
+		r.logger.Error("count runs waiting approval failed", "error", err)
+		return domain.QueueStats{}, err
+	}
+
+	return stats, nil
+}
+
+// ListTenantBacklog returns, per tenant with runnable steps, how many there
+// are and how long the oldest of them has been ready.
+func (r *RunRepository) ListTenantBacklog(ctx context.Context) ([]domain.TenantBacklog, error) {
+	now := nowUTC(r.clock)
+	rows, err := r.pool.Query(ctx, `
+		SELECT api_key_id, COUNT(*), MIN(ready_since)
+		FROM (`+pendingStepsQuery+`) pending
+		WHERE runnable
+		GROUP BY api_key_id
+	`, pendingStepsArgs(now)...)
+	if err != nil {
+		r.logger.Error("list tenant backlog failed", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var backlog []domain.TenantBacklog
+	for rows.Next() {
+		var (
+			b          domain.TenantBacklog
+			readySince time.Time
+		)
+		if err := rows.Scan(&b.APIKeyID, &b.Claimable, &readySince); err != nil {
+			r.logger.Error("scan tenant backlog failed", "error", err)
+			return nil, err
+		}
+		if age := now.Sub(readySince); age > 0 {
+			b.Starvation = age
+		}
+		backlog = append(backlog, b)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("iterate tenant backlog failed", "error", err)
+		return nil, err
+	}
+	return backlog, nil
+}
+
+func loadStepOutcomes(ctx context.Context, tx pgx.Tx, runID uuid.UUID) ([]domain.StepOutcome, error) {
+	rows, err := tx.Query(ctx, `SELECT status, on_failure FROM steps WHERE run_id=$1`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var outcomes []domain.StepOutcome
+	for rows.Next() {
+		var outcome domain.StepOutcome
+		if err := rows.Scan(&outcome.Status, &outcome.OnFailure); err != nil {
+			return nil, err
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes, rows.Err()
+}
+
+func (r *RunRepository) getRunRequest(ctx context.Context, apiKeyID uuid.UUID, idempotencyKey string) (uuid.UUID, *string, error) {
+	var (
+		runID       uuid.UUID
+		requestHash *string
+	)
+	err := r.pool.QueryRow(ctx, `
+		SELECT run_id, request_hash
+		FROM run_requests
+		WHERE api_key_id=$1 AND idempotency_key=$2
+	`,
+		apiKeyID,
+		idempotencyKey,
+	).Scan(&runID, &requestHash)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	return runID, requestHash, nil
+}
+
+// createRunRequestHash fingerprints the normalized run request so a replayed
+// Idempotency-Key can be checked against the body it was first used with.
+// Event subscriptions are order-insensitive. Metadata and tags only join the
+// fingerprint when set, so hashes stored before they existed still match.
+func createRunRequestHash(webhookURL, webhookSecret string, webhookEvents []string, priority int, templateName string, templateVersion *int,
+	metadata map[string]string, tags []string, replayedFrom *uuid.UUID, stepInputs map[int]json.RawMessage) string {
+	events := append([]string(nil), webhookEvents...)
+	sort.Strings(events)
+	sortedTags := append([]string(nil), tags...)
+	sort.Strings(sortedTags)
+
+	body, _ := json.Marshal(struct {
+		WebhookURL    string   `json:"webhook_url"`
+		WebhookSecret string   `json:"webhook_secret"`
+		WebhookEvents []string `json:"webhook_events"`
+		Priority      int      `json:"priority"`
+		TemplateName  string   `json:"template_name"`
+		// Omitted unless the request pins a version.
+		TemplateVersion *int              `json:"template_version,omitempty"`
+		Metadata        map[string]string `json:"metadata,omitempty"`
+		Tags            []string          `json:"tags,omitempty"`
+		// Omitted for plain runs, so their hashes match those stored before
+		// replays existed.
+		ReplayedFrom *uuid.UUID              `json:"replayed_from_run_id,omitempty"`
+		StepInputs   map[int]json.RawMessage `json:"step_inputs,omitempty"`
+	}{
+		WebhookURL:      webhookURL,
+		WebhookSecret:   webhookSecret,
+		WebhookEvents:   events,
+		Priority:        priority,
+		TemplateName:    templateName,
+		TemplateVersion: templateVersion,
+		Metadata:        metadata,
+		Tags:            sortedTags,
+		ReplayedFrom:    replayedFrom,
+		StepInputs:      stepInputs,
+	})
+	return sha256Hex(string(body))
+}
+
+// checkRequestHash accepts rows written before request hashes were stored.
+func checkRequestHash(stored *string, requestHash string) error {
+	if stored == nil || *stored == requestHash {
+		return nil
+	}
+	return domain.ErrIdempotencyKeyReused
+}
+
+// idempotencyLockKey scopes the advisory lock to one tenant's idempotency key.
+func idempotencyLockKey(apiKeyID uuid.UUID, idempotencyKey string) string {
+	return "run_requests:" + apiKeyID.String() + ":" + idempotencyKey
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func nullString(v string) any {
+	if strings.TrimSpace(v) == "" {
+		return nil
+	}
+	return v
+}
+
+func nullInt64(v sql.NullInt64) any {
+	if !v.Valid {
+		return nil
+	}
+	return v.Int64
+}
+
+type templateStep struct {
+	Name           domain.StepName
+	TimeoutSeconds sql.NullInt64
+	OnFailure      domain.OnFailurePolicy
+	Condition      string
+	// Map is set for MAP steps.
+	Map *domain.MapStepConfig
+	// ApprovalName labels an APPROVAL gate.
+	ApprovalName string
+	// ApprovalTimeoutSeconds and ApprovalTimeoutAction are set for APPROVAL
+	// steps that time out.
+	ApprovalTimeoutSeconds sql.NullInt64
+	ApprovalTimeoutAction  domain.ApprovalTimeoutAction
+	// MaxAttempts, RetryBaseDelayMS, RetryBackoff, RetryJitter, and
+	// RetryPriority override the worker's retry policy when set.
+	MaxAttempts      sql.NullInt64
+	RetryBaseDelayMS sql.NullInt64
+	RetryBackoff     domain.RetryBackoff
+	RetryJitter      sql.NullBool
+	RetryPriority    sql.NullInt64
+	// Command is the argv a TOOL step (or a MAP step's TOOL children) runs in
+	// the worker's sandbox.
+	Command []string
+	// HTTP is the request a TOOL step (or a MAP step's TOOL children) sends
+	// with the worker's HTTP executor.
+	HTTP *domain.HTTPRequest
+	// Secrets names the secrets the worker resolves for the step's executor.
+	Secrets []string
+}
+
+func (s templateStep) retryBackoff() any {
+	if s.RetryBackoff == "" {
+		return nil
+	}
+	return s.RetryBackoff
+}
+
+func (s templateStep) retryJitter() any {
+	if !s.RetryJitter.Valid {
+		return nil
+	}
+	return s.RetryJitter.Bool
+}
+
+func (s templateStep) approvalTimeoutAction() any {
+	if !s.ApprovalTimeoutSeconds.Valid {
+		return nil
+	}
+	return s.ApprovalTimeoutAction
+}
+
+func (s templateStep) mapItems() any {
+	if s.Map == nil {
+		return nil
+	}
+	return s.Map.Items.String()
+}
+
+func (s templateStep) mapStep() any {
+	if s.Map == nil {
+		return nil
+	}
+	return s.Map.Step
+}
+
+func (s templateStep) mapParallelism() any {
+	if s.Map == nil {
+		return nil
+	}
+	return s.Map.Parallelism
+}
+
+// loadWorkflowTemplateSteps returns a version of a template and its steps:
+// the given one, or the latest when version is nil.
+func (r *RunRepository) loadWorkflowTemplateSteps(ctx context.Context, tx pgx.Tx, templateName string, version *int) (int, []templateStep, error) {
+	var (
+		templateID      uuid.UUID
+		templateVersion int
+	)
+	if err := tx.QueryRow(ctx, `
+		SELECT id, version
+		FROM workflow_templates
+		WHERE name = $1 AND ($2::int IS NULL OR version = $2)
+		ORDER BY version DESC
+		LIMIT 1
+	`, templateName, version).Scan(&templateID, &templateVersion); err != nil {
+		return 0, nil, err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT wts.name, wts.timeout_seconds, wts.on_failure, COALESCE(wts.condition, ''),
+		       COALESCE(wts.map_items, ''), COALESCE(wts.map_step, ''), COALESCE(wts.map_parallelism, 0),
+		       COALESCE(wts.approval_name, ''), wts.approval_timeout_seconds, COALESCE(wts.approval_timeout_action, ''),
+		       wts.max_attempts, wts.retry_base_delay_ms, COALESCE(wts.retry_backoff, ''), wts.retry_jitter,
+		       wts.retry_priority, wts.command, wts.http, wts.secrets
+		FROM workflow_template_steps wts
+		WHERE wts.template_id = $1
+		ORDER BY wts.position ASC
+	`, templateID)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	steps := make([]templateStep, 0, 8)
+	for rows.Next() {
+		var (
+			stepName              string
+			timeout               sql.NullInt64
+			onFailure             string
+			condition             string
+			mapItems              string
+			mapStep               string
+			mapParallelism        int
+			approvalName          string
+			approvalTimeout       sql.NullInt64
+			approvalTimeoutAction string
+			maxAttempts           sql.NullInt64
+			retryBaseDelayMS      sql.NullInt64
+			retryBackoff          string
+			retryJitter           sql.NullBool
+			retryPriority         sql.NullInt64
+			command               []string
+			httpRequest           *domain.HTTPRequest
+			secrets               []string
+		)
+		if err := rows.Scan(&stepName, &timeout, &onFailure, &condition, &mapItems, &mapStep, &mapParallelism, &approvalName, &approvalTimeout, &approvalTimeoutAction,
+			&maxAttempts, &retryBaseDelayMS, &retryBackoff, &retryJitter, &retryPriority, &command, &httpRequest, &secrets); err != nil {
+			return 0, nil, err
+		}
+		if strings.TrimSpace(stepName) == "" {
+			return 0, nil, errors.New("workflow template contains empty step name")
+		}
+		policy, err := domain.ParseOnFailurePolicy(onFailure)
+		if err != nil {
+			return 0, nil, fmt.Errorf("workflow template step %s: %w", stepName, err)
+		}
+		if strings.TrimSpace(condition) != "" {
+			parsed, err := domain.ParseStepCondition(condition)
+			if err != nil {
+				return 0, nil, fmt.Errorf("workflow template step %s: %w", stepName, err)
+			}
+			condition = parsed.String()
+		}
+		var mapConfig *domain.MapStepConfig
+		if domain.StepName(stepName) == domain.StepMap {
+			cfg, err := domain.ParseMapStepConfig(mapItems, mapStep, mapParallelism)
+			if err != nil {
+				return 0, nil, fmt.Errorf("workflow template step %s: %w", stepName, err)
+			}
+			mapConfig = &cfg
+		}
+		timeoutAction, err := domain.ParseApprovalTimeoutAction(approvalTimeoutAction)
+		if err != nil {
+			return 0, nil, fmt.Errorf("workflow template step %s: %w", stepName, err)
+		}
+		if approvalTimeout.Valid && domain.StepName(stepName) != domain.StepApproval {
+			return 0, nil, fmt.Errorf("workflow template step %s: %w: only APPROVAL steps time out", stepName, domain.ErrInvalidApprovalTimeout)
+		}
+		var backoff domain.RetryBackoff
+		if strings.TrimSpace(retryBackoff) != "" {
+			if backoff, err = domain.ParseRetryBackoff(retryBackoff); err != nil {
+				return 0, nil, fmt.Errorf("workflow template step %s: %w", stepName, err)
+			}
+		}
+		if command != nil {
+			runsTool := domain.StepName(stepName) == domain.StepTool ||
+				(mapConfig != nil && mapConfig.Step == domain.StepTool)
+			if !runsTool {
+				return 0, nil, fmt.Errorf("workflow template step %s: %w: only TOOL steps run commands", stepName, domain.ErrInvalidStepCommand)
+			}
+		}
+		if httpRequest != nil {
+			runsTool := domain.StepName(stepName) == domain.StepTool ||
+				(mapConfig != nil && mapConfig.Step == domain.StepTool)
+			if !runsTool {
+				return 0, nil, fmt.Errorf("workflow template step %s: %w: only TOOL steps make HTTP requests", stepName, domain.ErrInvalidStepHTTP)
+			}
+		}
+		steps = append(steps, templateStep{
+			Name:           domain.StepName(stepName),
+			TimeoutSeconds: timeout,
+			OnFailure:      policy,
+			Condition:      condition,
+			Map:            mapConfig,
+			ApprovalName:   strings.TrimSpace(approvalName),
+
+			ApprovalTimeoutSeconds: approvalTimeout,
+			ApprovalTimeoutAction:  timeoutAction,
+
+			MaxAttempts:      maxAttempts,
+			RetryBaseDelayMS: retryBaseDelayMS,
+			RetryBackoff:     backoff,
+			RetryJitter:      retryJitter,
+			RetryPriority:    retryPriority,
+
+			Command: command,
+			HTTP:    httpRequest,
+			Secrets: secrets,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	if len(steps) == 0 {
+		return 0, nil, pgx.ErrNoRows
+	}
+
+	return templateVersion, steps, nil
+}
+
+func (r *RunRepository) GetRun(ctx context.Context, id uuid.UUID) (domain.RunStatus, error) {
+	var status domain.RunStatus
+	apiKeyID, err := apiKeyIDFromContext(ctx)
+	if err != nil {
+		r.logger.Warn("get run denied: missing api key id", "run_id", id, "error", err)
+		return "", err
+	}
+
+	err = r.replica.read(ctx, r.pool, func(q querier) error {
+		return q.QueryRow(ctx,
+			`SELECT status FROM runs WHERE id=$1 AND api_key_id=$2`,
+			id,
+			apiKeyID,
+		).Scan(&status)
+	})
+
+	if err != nil {
+		r.logger.Error("get run failed", "run_id", id, "api_key_id", apiKeyID, "error", err)
+		return "", notFound(err, domain.ErrRunNotFound)
+	}
+
+	return status, nil
+}
+
+// GetRunVersion returns the tenant's run's updated_at and the sequence number
+// of its latest event, a cheap stand-in for reading the run and its steps.
+func (r *RunRepository) GetRunVersion(ctx context.Context, id uuid.UUID) (domain.RunVersion, error) {
+	var version domain.RunVersion
+	apiKeyID, err := apiKeyIDFromContext(ctx)
+	if err != nil {
+		return domain.RunVersion{}, err
+	}
+
+	err = r.replica.read(ctx, r.pool, func(q querier) error {
+		return q.QueryRow(ctx, `
+			SELECT r.updated_at,
+			       COALESCE((SELECT max(e.seq) FROM events e WHERE e.run_id = r.id), 0)
+			FROM runs r
+			WHERE r.id=$1 AND r.api_key_id=$2`,
+			id,
+			apiKeyID,
+		).Scan(&version.UpdatedAt, &version.LastEventSeq)
+	})
+	if err != nil {
+		return domain.RunVersion{}, notFound(err, domain.ErrRunNotFound)
+	}
+	return version, nil
+}
+
+// ListRuns returns the tenant's runs matching filter, newest first, at most
+// filter.Limit of them (DefaultRunPageSize when unset, capped at
+// MaxRunPageSize). Tag and metadata filters use containment so the GIN
+// indexes on runs serve them.
+func (r *RunRepository) ListRuns(ctx context.Context, filter domain.RunFilter) ([]domain.RunListItem, error) {
+	apiKeyID, err := apiKeyIDFromContext(ctx)
+	if err != nil {
+		r.logger.Warn("list runs denied: missing api key id", "error", err)
+		return nil, err
+	}
+
+	items, err := r.listRuns(ctx, r.pool, &apiKeyID, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]domain.RunListItem, 0, len(items))
+	for _, item := range items {
+		runs = append(runs, item.RunListItem)
+	}
+	return runs, nil
+}
+
+// AdminListRuns lists runs as ListRuns does, but across tenants: apiKeyID
+// narrows the list to one tenant when set. Each call is recorded in the
+// audit log with the IDs of the runs it returned.
+func (r *RunRepository) AdminListRuns(ctx context.Context, apiKeyID *uuid.UUID, filter domain.RunFilter) ([]domain.AdminRunListItem, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		r.logger.Error("begin tx failed", "error", err)
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	runs, err := r.listRuns(ctx, tx, apiKeyID, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	change := audit.Change{
+		Action:     domain.AuditRunList,
+		TargetType: domain.AuditTargetRun,
+		TargetID:   "*",
+	}
+	if apiKeyID != nil {
+		change.APIKeyID = *apiKeyID
+		change.TargetID = apiKeyID.String()
+	}
+	runIDs := make([]uuid.UUID, 0, len(runs))
+	for _, run := range runs {
+		runIDs = append(runIDs, run.ID)
+	}
+	change.After = map[string]any{
+		"status":   filter.Status,
+		"tags":     filter.Tags,
+		"metadata": filter.Metadata,
+		"run_ids":  runIDs,
+	}
+	if err := audit.Record(ctx, tx, nowUTC(r.clock), change); err != nil {
+		r.logger.Error("record admin run list failed", "error", err)
+		return nil, err
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		r.logger.Error("commit failed", "run_id", runID, "error", err)
-		return uuid.Nil, err
+		r.logger.Error("commit admin run list failed", "error", err)
+		return nil, err
 	}
-
-	metrics.IncRunStatus(string(domain.RunPending))
-	r.logger.Info("run created", "run_id", runID, "api_key_id", apiKeyID)
-	return runID, nil
+	return runs, nil
 }
 
-func (r *RunRepository) getRunIDByRequest(ctx context.Context, apiKeyID uuid.UUID, idempotencyKey string) (uuid.UUID, error) {
-	var runID uuid.UUID
-	err := r.pool.QueryRow(ctx, `
-		SELECT run_id
-		FROM run_requests
-		WHERE api_key_id=$1 AND idempotency_key=$2
-	`,
-		apiKeyID,
-		idempotencyKey,
-	).Scan(&runID)
-	if err != nil {
-		return uuid.Nil, err
+// listRuns reads the runs matching filter, only tenant's when tenant is set.
+func (r *RunRepository) listRuns(ctx context.Context, q querier, tenant *uuid.UUID, filter domain.RunFilter) ([]domain.AdminRunListItem, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = domain.DefaultRunPageSize
 	}
-	return runID, nil
-}
-
-func isUniqueViolation(err error) bool {
-	var pgErr *pgconn.PgError
-	return errors.As(err, &pgErr) && pgErr.Code == "23505"
-}
+	limit = min(limit, domain.MaxRunPageSize)
 
-func nullString(v string) any {
-	if strings.TrimSpace(v) == "" {
-		return nil
+	var (
+		conds []string
+		args  []any
+	)
+	where := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
 	}
-	return v
-}
-
-func nullInt64(v sql.NullInt64) any {
-	if !v.Valid {
-		return nil
+	// The cursor run must be visible to whoever pages from it.
+	cursor := "(created_at, id) < (SELECT created_at, id FROM runs WHERE id = ?)"
+	if tenant != nil {
+		where("api_key_id = ?", *tenant)
+		cursor = "(created_at, id) < (SELECT created_at, id FROM runs WHERE id = ? AND api_key_id = $1)"
+	}
+	if filter.Status != "" {
+		where("status = ?", filter.Status)
+	}
+	if len(filter.Tags) > 0 {
+		where("tags @> ?", filter.Tags)
+	}
+	if len(filter.Metadata) > 0 {
+		metadataJSON, err := json.Marshal(filter.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		where("metadata @> ?::jsonb", string(metadataJSON))
+	}
+	if filter.BeforeID != nil {
+		where(cursor, *filter.BeforeID)
 	}
-	return v.Int64
-}
 
-type templateStep struct {
-	Name           domain.StepName
-	TimeoutSeconds sql.NullInt64
-}
+	whereClause := ""
+	if len(conds) > 0 {
+		whereClause = "WHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, limit)
+	query := `
+		SELECT id, api_key_id, status, priority, metadata, tags, total_cost_usd::double precision, created_at, updated_at
+		FROM runs
+		` + whereClause + `
+		ORDER BY created_at DESC, id DESC
+		LIMIT $` + strconv.Itoa(len(args))
 
-func (r *RunRepository) loadWorkflowTemplateSteps(ctx context.Context, tx pgx.Tx, templateName string) ([]templateStep, error) {
-	rows, err := tx.Query(ctx, `
-		SELECT wts.name, wts.timeout_seconds
-		FROM workflow_templates wt
-		JOIN workflow_template_steps wts ON wts.template_id = wt.id
-		WHERE wt.name = $1
-		ORDER BY wts.position ASC
-	`, templateName)
+	rows, err := q.Query(ctx, query, args...)
 	if err != nil {
+		r.logger.Error("list runs failed", "api_key_id", tenant, "error", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	steps := make([]templateStep, 0, 8)
+	runs := make([]domain.AdminRunListItem, 0, limit)
 	for rows.Next() {
-		var (
-			stepName string
-			timeout  sql.NullInt64
-		)
-		if err := rows.Scan(&stepName, &timeout); err != nil {
+		var run domain.AdminRunListItem
+		if err := rows.Scan(
+			&run.ID,
+			&run.APIKeyID,
+			&run.Status,
+			&run.Priority,
+			&run.Metadata,
+			&run.Tags,
+			&run.TotalCostUSD,
+			&run.CreatedAt,
+			&run.UpdatedAt,
+		); err != nil {
+			r.logger.Error("scan run row failed", "api_key_id", tenant, "error", err)
 			return nil, err
 		}
-		if strings.TrimSpace(stepName) == "" {
-			return nil, errors.New("workflow template contains empty step name")
-		}
-		steps = append(steps, templateStep{
-			Name:           domain.StepName(stepName),
-			TimeoutSeconds: timeout,
-		})
+		runs = append(runs, run)
 	}
-
 	if err := rows.Err(); err != nil {
+		r.logger.Error("runs rows iteration failed", "api_key_id", tenant, "error", err)
 		return nil, err
 	}
-	if len(steps) == 0 {
-		return nil, pgx.ErrNoRows
-	}
 
-	return steps, nil
+	return runs, nil
 }
 
-func (r *RunRepository) GetRun(ctx context.Context, id uuid.UUID) (domain.RunStatus, error) {
-	var status domain.RunStatus
-	apiKeyID, err := apiKeyIDFromContext(ctx)
+// runDetailColumns are the runs columns scanRunDetail reads, in order.
+const runDetailColumns = `id, api_key_id, status, priority, metadata, tags, total_cost_usd::double precision,
+	template_name, template_version, cancel_reason, replayed_from_run_id, created_at, updated_at`
+
+func scanRunDetail(row pgx.Row) (domain.RunDetail, error) {
+	var run domain.RunDetail
+	err := row.Scan(
+		&run.ID,
+		&run.APIKeyID,
+		&run.Status,
+		&run.Priority,
+		&run.Metadata,
+		&run.Tags,
+		&run.TotalCostUSD,
+		&run.TemplateName,
+		&run.TemplateVersion,
+		&run.CancelReason,
+		&run.ReplayedFromRunID,
+		&run.CreatedAt,
+		&run.UpdatedAt,
+	)
+	return run, err
+}
+
+// AdminGetRun returns any tenant's run. Every lookup is recorded in the audit
+// log, including one for a run that does not exist, which returns
+// domain.ErrRunNotFound.
+func (r *RunRepository) AdminGetRun(ctx context.Context, id uuid.UUID) (domain.RunDetail, error) {
+	tx, err := r.pool.Begin(ctx)
 	if err != nil {
-		r.logger.Warn("get run denied: missing api key id", "run_id", id, "error", err)
-		return "", err
+		r.logger.Error("begin tx failed", "error", err)
+		return domain.RunDetail{}, err
 	}
+	defer tx.Rollback(ctx)
 
-	err = r.pool.QueryRow(ctx,
-		`SELECT status FROM runs WHERE id=$1 AND api_key_id=$2`,
-		id,
-		apiKeyID,
-	).Scan(&status)
+	run, err := scanRunDetail(tx.QueryRow(ctx, `SELECT `+runDetailColumns+` FROM runs WHERE id = $1`, id))
+	found := err == nil
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		r.logger.Error("admin get run failed", "run_id", id, "error", err)
+		return domain.RunDetail{}, err
+	}
 
-	if err != nil {
-		r.logger.Error("get run failed", "run_id", id, "api_key_id", apiKeyID, "error", err)
-		return "", err
+	change := audit.Change{
+		Action:     domain.AuditRunView,
+		TargetType: domain.AuditTargetRun,
+		TargetID:   id.String(),
+		After:      map[string]any{"found": found},
+	}
+	if found {
+		change.APIKeyID = run.APIKeyID
+		change.After = map[string]any{"found": found, "status": run.Status}
+	}
+	if err := audit.Record(ctx, tx, nowUTC(r.clock), change); err != nil {
+		r.logger.Error("record admin run view failed", "run_id", id, "error", err)
+		return domain.RunDetail{}, err
 	}
 
-	return status, nil
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("commit admin run view failed", "run_id", id, "error", err)
+		return domain.RunDetail{}, err
+	}
+	if !found {
+		return domain.RunDetail{}, notFound(pgx.ErrNoRows, domain.ErrRunNotFound)
+	}
+	return run, nil
 }
 
 func (r *RunRepository) GetRunCost(ctx context.Context, id uuid.UUID) (domain.RunCostBreakdown, error) {
@@ -311,8 +2127,17 @@ func (r *RunRepository) GetRunCost(ctx context.Context, id uuid.UUID) (domain.Ru
 		return domain.RunCostBreakdown{}, err
 	}
 
+	var breakdown domain.RunCostBreakdown
+	err = r.replica.read(ctx, r.pool, func(q querier) error {
+		breakdown, err = r.getRunCost(ctx, q, id, apiKeyID)
+		return err
+	})
+	return breakdown, notFound(err, domain.ErrRunNotFound)
+}
+
+func (r *RunRepository) getRunCost(ctx context.Context, q querier, id, apiKeyID uuid.UUID) (domain.RunCostBreakdown, error) {
 	var totalCostUSD float64
-	if err := r.pool.QueryRow(ctx, `
+	if err := q.QueryRow(ctx, `
 		SELECT total_cost_usd::double precision
 		FROM runs
 		WHERE id=$1 AND api_key_id=$2
@@ -328,11 +2153,11 @@ func (r *RunRepository) GetRunCost(ctx context.Context, id uuid.UUID) (domain.Ru
 		return domain.RunCostBreakdown{}, err
 	}
 
-	rows, err := r.pool.Query(ctx, `
-		SELECT id, name, status, cost_usd::double precision
+	rows, err := q.Query(ctx, `
+		SELECT id, name, status, cost_usd::double precision, cost_detail
 		FROM steps
 		WHERE run_id=$1
-		ORDER BY created_at ASC
+		ORDER BY position ASC, map_index ASC NULLS FIRST, created_at ASC
 	`, id)
 	if err != nil {
 		r.logger.Error("get run step costs query failed",
@@ -347,7 +2172,7 @@ func (r *RunRepository) GetRunCost(ctx context.Context, id uuid.UUID) (domain.Ru
 	steps := make([]domain.StepCostBreakdown, 0, 4)
 	for rows.Next() {
 		var step domain.StepCostBreakdown
-		if err := rows.Scan(&step.ID, &step.Name, &step.Status, &step.CostUSD); err != nil {
+		if err := rows.Scan(&step.ID, &step.Name, &step.Status, &step.CostUSD, &step.CostDetail); err != nil {
 			r.logger.Error("scan run step costs failed",
 				"run_id", id,
 				"api_key_id", apiKeyID,
@@ -371,15 +2196,41 @@ func (r *RunRepository) GetRunCost(ctx context.Context, id uuid.UUID) (domain.Ru
 		RunID:        id,
 		TotalCostUSD: totalCostUSD,
 		Steps:        steps,
+		ByModel:      domain.SummarizeCostByModel(steps),
 	}, nil
 }
 
-func (r *RunRepository) CancelRun(ctx context.Context, runID uuid.UUID) error {
+// CancelRun cancels one of the tenant's runs, recording reason on the run and
+// its RUN_CANCELED event; an empty reason records "user_request".
+func (r *RunRepository) CancelRun(ctx context.Context, runID uuid.UUID, reason string) error {
 	apiKeyID, err := apiKeyIDFromContext(ctx)
 	if err != nil {
 		r.logger.Warn("cancel run denied: missing api key id", "run_id", runID, "error", err)
 		return err
 	}
+	reason, err = domain.NormalizeCancelReason(reason, domain.CancelReasonUserRequest)
+	if err != nil {
+		return err
+	}
+
+	return r.cancelRun(ctx, runID, &apiKeyID, reason, domain.AuditActorAPIKey)
+}
+
+// CancelRunAsAdmin cancels any tenant's run, for incident response. An empty
+// reason records "admin_request".
+func (r *RunRepository) CancelRunAsAdmin(ctx context.Context, runID uuid.UUID, reason string) error {
+	reason, err := domain.NormalizeCancelReason(reason, domain.CancelReasonAdminRequest)
+	if err != nil {
+		return err
+	}
+
+	return r.cancelRun(ctx, runID, nil, reason, domain.AuditActorAdmin)
+}
+
+// cancelRun cancels a run of tenant, or of any tenant when tenant is nil.
+// canceledBy is the audit actor type recorded on the event.
+func (r *RunRepository) cancelRun(ctx context.Context, runID uuid.UUID, tenant *uuid.UUID, reason, canceledBy string) error {
+	reason = r.redactor.String(reason)
 
 	tx, err := r.pool.Begin(ctx)
 	if err != nil {
@@ -388,29 +2239,36 @@ func (r *RunRepository) CancelRun(ctx context.Context, runID uuid.UUID) error {
 	}
 	defer tx.Rollback(ctx)
 
-	var status domain.RunStatus
+	var (
+		status   domain.RunStatus
+		apiKeyID uuid.UUID
+	)
 	if err := tx.QueryRow(ctx,
-		`SELECT status FROM runs WHERE id=$1 AND api_key_id=$2`,
+		`SELECT status, api_key_id FROM runs WHERE id=$1 AND ($2::uuid IS NULL OR api_key_id=$2) FOR UPDATE`,
 		runID,
-		apiKeyID,
-	).Scan(&status); err != nil {
-		r.logger.Error("read run status failed", "run_id", runID, "api_key_id", apiKeyID, "error", err)
-		return err
+		tenant,
+	).Scan(&status, &apiKeyID); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("read run status failed", "run_id", runID, "api_key_id", tenant, "error", err)
+		}
+		return notFound(err, domain.ErrRunNotFound)
 	}
 
-	if status == domain.RunCanceled ||
-		status == domain.RunSuccess ||
-		status == domain.RunFailed {
+	if status.IsTerminal() {
 		r.logger.Info("cancel skipped (terminal)",
 			"run_id", runID,
 			"status", status,
 		)
 		return tx.Commit(ctx)
 	}
+	if err := transition.Run(r.logger, runID, status, domain.RunCanceled); err != nil {
+		return err
+	}
 
+	now := nowUTC(r.clock)
 	_, err = tx.Exec(ctx,
-		`UPDATE runs SET status=$2, updated_at=NOW() WHERE id=$1`,
-		runID, domain.RunCanceled,
+		`UPDATE runs SET status=$2, cancel_reason=$3, updated_at=$4 WHERE id=$1`,
+		runID, domain.RunCanceled, reason, now,
 	)
 	if err != nil {
 		r.logger.Error("update run cancel failed", "run_id", runID, "error", err)
@@ -420,7 +2278,8 @@ func (r *RunRepository) CancelRun(ctx context.Context, runID uuid.UUID) error {
 	_, err = tx.Exec(ctx, `
 		UPDATE steps
 		SET status=$2,
-		    finished_at=COALESCE(finished_at, NOW())
+		    lease_expires_at=NULL,
+		    finished_at=COALESCE(finished_at, $6)
 		WHERE run_id=$1
 		  AND status IN ($3,$4,$5)
 	`,
@@ -429,36 +2288,82 @@ func (r *RunRepository) CancelRun(ctx context.Context, runID uuid.UUID) error {
 		domain.StepPending,
 		domain.StepRunning,
 		domain.StepWaiting,
+		now,
 	)
 	if err != nil {
 		r.logger.Error("update steps cancel failed", "run_id", runID, "error", err)
 		return err
 	}
 
+	cancelPayload, err := json.Marshal(map[string]string{
+		"reason":      reason,
+		"canceled_by": canceledBy,
+	})
+	if err != nil {
+		return err
+	}
+
+	cancelEventID := ids.New()
 	_, err = tx.Exec(ctx,
 		`INSERT INTO events (id, run_id, type, payload)
 		 VALUES ($1, $2, $3, $4)`,
-		uuid.New(), runID, "RUN_CANCELED", `{"reason":"user_request"}`,
+		cancelEventID, runID, domain.EventRunCanceled, cancelPayload,
 	)
 	if err != nil {
 		r.logger.Error("insert cancel event failed", "run_id", runID, "error", err)
 		return err
 	}
 
+	if err := outbox.EnqueueEvent(ctx, tx, cancelEventID, domain.DefaultWebhookMaxAttempts); err != nil {
+		r.logger.Error("enqueue cancel webhook failed", "run_id", runID, "error", err)
+		return err
+	}
+
+	if err := runsummary.Emit(ctx, tx, runID, domain.DefaultWebhookMaxAttempts); err != nil {
+		r.logger.Error("emit run summary failed", "run_id", runID, "error", err)
+		return err
+	}
+
+	if err := audit.Record(ctx, tx, nowUTC(r.clock), audit.Change{
+		Action:     domain.AuditRunCancel,
+		APIKeyID:   apiKeyID,
+		TargetType: domain.AuditTargetRun,
+		TargetID:   runID.String(),
+		Before:     map[string]any{"status": status},
+		After:      map[string]any{"status": domain.RunCanceled, "cancel_reason": reason},
+	}); err != nil {
+		r.logger.Error("record run cancel failed", "run_id", runID, "error", err)
+		return err
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		r.logger.Error("commit cancel failed", "run_id", runID, "error", err)
 		return err
 	}
 
-	metrics.IncRunStatus(string(domain.RunCanceled))
-	r.logger.Info("run canceled", "run_id", runID)
+	metrics.IncRunStatus(apiKeyID, string(domain.RunCanceled))
+	r.logger.Info("run canceled", "run_id", runID, "api_key_id", apiKeyID, "reason", reason, "canceled_by", canceledBy)
 	return nil
 }
 
+// ApproveRun approves the run's first approval gate that is waiting.
 func (r *RunRepository) ApproveRun(ctx context.Context, runID uuid.UUID) error {
+	return r.approve(ctx, runID, nil)
+}
+
+// ApproveStep approves one approval gate of the run. It returns
+// domain.ErrApprovalStepNotFound when stepID is not an APPROVAL step of the
+// run, and is a no-op for a gate that is already approved.
+func (r *RunRepository) ApproveStep(ctx context.Context, runID, stepID uuid.UUID) error {
+	return r.approve(ctx, runID, &stepID)
+}
+
+// RejectStep rejects the waiting approval gate stepID: the step and the run
+// fail, as when an approval times out with action fail.
+func (r *RunRepository) RejectStep(ctx context.Context, runID, stepID uuid.UUID) error {
 	apiKeyID, err := apiKeyIDFromContext(ctx)
 	if err != nil {
-		r.logger.Warn("approve run denied: missing api key id", "run_id", runID, "error", err)
+		r.logger.Warn("reject step denied: missing api key id", "run_id", runID, "error", err)
 		return err
 	}
 
@@ -469,97 +2374,251 @@ func (r *RunRepository) ApproveRun(ctx context.Context, runID uuid.UUID) error {
 	}
 	defer tx.Rollback(ctx)
 
-	var runStatus domain.RunStatus
+	var (
+		runStatus  domain.RunStatus
+		webhookURL sql.NullString
+	)
 	if err := tx.QueryRow(ctx,
-		`SELECT status FROM runs WHERE id=$1 AND api_key_id=$2`,
+		`SELECT status, webhook_url FROM runs WHERE id=$1 AND api_key_id=$2 FOR UPDATE`,
 		runID,
 		apiKeyID,
-	).Scan(&runStatus); err != nil {
+	).Scan(&runStatus, &webhookURL); err != nil {
 		r.logger.Error("read run status failed", "run_id", runID, "api_key_id", apiKeyID, "error", err)
-		return err
+		return notFound(err, domain.ErrRunNotFound)
+	}
+	if runStatus.IsTerminal() {
+		r.logger.Warn("reject refused (terminal)", "run_id", runID, "status", runStatus)
+		return fmt.Errorf("%w: %w: run status is %s", domain.ErrRunNotWaitingApproval, domain.ErrRunTerminal, runStatus)
 	}
 
-	if runStatus == domain.RunCanceled ||
-		runStatus == domain.RunFailed {
-		r.logger.Warn("approve rejected (terminal)",
+	var stepStatus domain.StepStatus
+	if err := tx.QueryRow(ctx,
+		`SELECT status FROM steps WHERE id=$1 AND run_id=$2 AND name=$3 FOR UPDATE`,
+		stepID,
+		runID,
+		domain.StepApproval,
+	).Scan(&stepStatus); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Warn("reject refused: approval step not found", "run_id", runID, "step_id", stepID)
+			return domain.ErrApprovalStepNotFound
+		}
+		r.logger.Error("read approval step status failed", "run_id", runID, "error", err)
+		return err
+	}
+	if stepStatus != domain.StepWaiting {
+		r.logger.Warn("reject refused: approval step not waiting",
 			"run_id", runID,
-			"status", runStatus,
+			"step_id", stepID,
+			"approval_status", stepStatus,
 		)
-		return fmt.Errorf("%w: run status is %s", domain.ErrRunNotWaitingApproval, runStatus)
+		return fmt.Errorf("%w: approval step status is %s", domain.ErrRunNotWaitingApproval, stepStatus)
 	}
 
-	if runStatus == domain.RunSuccess {
-		r.logger.Info("approve idempotent (already succeeded)",
-			"run_id", runID,
-			"status", runStatus,
-		)
-		return tx.Commit(ctx)
+	newStatus, err := r.failApprovalStep(ctx, tx, runID, stepID, stepStatus, runStatus, "approval rejected")
+	if err != nil {
+		r.logger.Error("fail rejected step failed", "run_id", runID, "step_id", stepID, "error", err)
+		return err
 	}
 
-	var approvalStepID uuid.UUID
-	err = tx.QueryRow(ctx, `
-		UPDATE steps
-		SET status=$2,
-		    started_at=COALESCE(started_at, NOW()),
-		    finished_at=COALESCE(finished_at, NOW())
-		WHERE run_id=$1
-		  AND name=$4
-		  AND status=$3
-		RETURNING id
+	var finishedAt time.Time
+	if err := tx.QueryRow(ctx, `SELECT updated_at FROM runs WHERE id=$1`, runID).Scan(&finishedAt); err != nil {
+		return err
+	}
+	if err := runsummary.Emit(ctx, tx, runID, domain.DefaultWebhookMaxAttempts); err != nil {
+		r.logger.Error("emit run summary failed", "run_id", runID, "error", err)
+		return err
+	}
+	if err := outbox.EnqueueTerminalWebhook(ctx, tx, runID, newStatus, finishedAt.UTC(), webhookURL.String, domain.DefaultWebhookMaxAttempts); err != nil {
+		r.logger.Error("enqueue terminal webhook failed", "run_id", runID, "error", err)
+		return err
+	}
+
+	if err := audit.Record(ctx, tx, nowUTC(r.clock), audit.Change{
+		Action:     domain.AuditRunReject,
+		APIKeyID:   apiKeyID,
+		TargetType: domain.AuditTargetRun,
+		TargetID:   runID.String(),
+		Before:     map[string]domain.RunStatus{"status": runStatus},
+		After:      map[string]any{"status": newStatus, "step_id": stepID},
+	}); err != nil {
+		r.logger.Error("record run rejection failed", "run_id", runID, "error", err)
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("commit reject failed", "run_id", runID, "error", err)
+		return err
+	}
+
+	metrics.IncStepStatus(apiKeyID, string(domain.StepFailed))
+	metrics.IncRunStatus(apiKeyID, string(newStatus))
+	r.logger.Info("approval rejected", "run_id", runID, "step_id", stepID)
+	return nil
+}
+
+// ListPendingApprovals returns the run's approval gates that are not approved
+// yet, in template order.
+func (r *RunRepository) ListPendingApprovals(ctx context.Context, runID uuid.UUID) ([]domain.ApprovalGate, error) {
+	apiKeyID, err := apiKeyIDFromContext(ctx)
+	if err != nil {
+		r.logger.Warn("list pending approvals denied: missing api key id", "run_id", runID, "error", err)
+		return nil, err
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT s.id, COALESCE(s.approval_name, ''), s.status, s.waiting_since,
+		       s.waiting_since + make_interval(secs => s.approval_timeout_seconds)
+		FROM steps s
+		JOIN runs r ON r.id = s.run_id
+		WHERE s.run_id=$1
+		  AND r.api_key_id=$2
+		  AND s.name=$3
+		  AND s.status IN ($4, $5)
+		ORDER BY s.position ASC
 	`,
 		runID,
-		domain.StepSuccess,
-		domain.StepWaiting,
+		apiKeyID,
 		domain.StepApproval,
-	).Scan(&approvalStepID)
-	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		r.logger.Error("approve step update failed", "run_id", runID, "error", err)
-		return err
+		domain.StepPending,
+		domain.StepWaiting,
+	)
+	if err != nil {
+		r.logger.Error("list pending approvals failed", "run_id", runID, "error", err)
+		return nil, err
 	}
+	defer rows.Close()
 
-	if errors.Is(err, pgx.ErrNoRows) {
-		var approvalStatus domain.StepStatus
-		statusErr := tx.QueryRow(ctx, `
-			SELECT status
-			FROM steps
-			WHERE run_id=$1 AND name=$2
-		`, runID, domain.StepApproval).Scan(&approvalStatus)
-		if statusErr != nil {
-			if errors.Is(statusErr, pgx.ErrNoRows) {
-				r.logger.Warn("approve rejected: approval step not found", "run_id", runID)
-				return fmt.Errorf("%w: approval step not found", domain.ErrRunNotWaitingApproval)
-			}
-			r.logger.Error("read approval step status failed", "run_id", runID, "error", statusErr)
-			return statusErr
+	gates := make([]domain.ApprovalGate, 0, 2)
+	for rows.Next() {
+		var gate domain.ApprovalGate
+		if err := rows.Scan(&gate.StepID, &gate.Name, &gate.Status, &gate.WaitingSince, &gate.TimesOutAt); err != nil {
+			r.logger.Error("scan pending approval failed", "run_id", runID, "error", err)
+			return nil, err
 		}
+		gates = append(gates, gate)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("iterate pending approvals failed", "run_id", runID, "error", err)
+		return nil, err
+	}
+	return gates, nil
+}
+
+// approve approves the approval gate stepID, or the first waiting gate when
+// stepID is nil, and completes the run if nothing else is left.
+func (r *RunRepository) approve(ctx context.Context, runID uuid.UUID, stepID *uuid.UUID) error {
+	apiKeyID, err := apiKeyIDFromContext(ctx)
+	if err != nil {
+		r.logger.Warn("approve run denied: missing api key id", "run_id", runID, "error", err)
+		return err
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		r.logger.Error("begin tx failed", "error", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		runStatus  domain.RunStatus
+		webhookURL sql.NullString
+	)
+	if err := tx.QueryRow(ctx,
+		`SELECT status, webhook_url FROM runs WHERE id=$1 AND api_key_id=$2 FOR UPDATE`,
+		runID,
+		apiKeyID,
+	).Scan(&runStatus, &webhookURL); err != nil {
+		r.logger.Error("read run status failed", "run_id", runID, "api_key_id", apiKeyID, "error", err)
+		return notFound(err, domain.ErrRunNotFound)
+	}
 
-		if approvalStatus == domain.StepSuccess {
-			r.logger.Info("approve idempotent (already approved)", "run_id", runID)
-			return tx.Commit(ctx)
+	if runStatus.IsTerminal() {
+		r.logger.Warn("approve rejected (terminal)",
+			"run_id", runID,
+			"status", runStatus,
+		)
+		return fmt.Errorf("%w: %w: run status is %s", domain.ErrRunNotWaitingApproval, domain.ErrRunTerminal, runStatus)
+	}
+
+	// Lock the first gate that is not approved yet; only when every gate is
+	// approved is the call an idempotent repeat.
+	var (
+		approvalStepID uuid.UUID
+		approvalStatus domain.StepStatus
+		approvalName   string
+	)
+	err = tx.QueryRow(ctx, `
+		SELECT id, status, COALESCE(approval_name, '')
+		FROM steps
+		WHERE run_id=$1
+		  AND name=$2
+		  AND ($4::uuid IS NULL OR id=$4)
+		ORDER BY status = $3, position ASC
+		LIMIT 1
+		FOR UPDATE
+	`, runID, domain.StepApproval, domain.StepSuccess, stepID).Scan(&approvalStepID, &approvalStatus, &approvalName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		r.logger.Warn("approve rejected: approval step not found", "run_id", runID, "step_id", stepID)
+		if stepID != nil {
+			return domain.ErrApprovalStepNotFound
 		}
+		return fmt.Errorf("%w: approval step not found", domain.ErrRunNotWaitingApproval)
+	}
+	if err != nil {
+		r.logger.Error("read approval step status failed", "run_id", runID, "error", err)
+		return err
+	}
 
+	if approvalStatus == domain.StepSuccess {
+		r.logger.Info("approve idempotent (already approved)", "run_id", runID)
+		return tx.Commit(ctx)
+	}
+	// A gate that is not waiting is the caller's mistake, not an illegal
+	// transition, so it is rejected before the state machine check.
+	if approvalStatus != domain.StepWaiting {
 		r.logger.Warn("approve rejected: approval step not waiting",
 			"run_id", runID,
 			"approval_status", approvalStatus,
 		)
 		return fmt.Errorf("%w: approval step status is %s", domain.ErrRunNotWaitingApproval, approvalStatus)
 	}
+	if err := transition.Step(r.logger, approvalStepID, approvalStatus, domain.StepSuccess); err != nil {
+		return err
+	}
+	now := nowUTC(r.clock)
+	if _, err := tx.Exec(ctx, `
+		UPDATE steps
+		SET status=$2,
+		    started_at=COALESCE(started_at, $3),
+		    finished_at=COALESCE(finished_at, $3)
+		WHERE id=$1
+	`, approvalStepID, domain.StepSuccess, now); err != nil {
+		r.logger.Error("approve step update failed", "run_id", runID, "error", err)
+		return err
+	}
 
-	approvalPayload, err := json.Marshal(map[string]domain.StepStatus{
+	approval := map[string]any{
 		"status": domain.StepSuccess,
-	})
+	}
+	if approvalName != "" {
+		approval["approval_name"] = approvalName
+	}
+	approvalPayload, err := json.Marshal(approval)
 	if err != nil {
 		r.logger.Error("marshal approve payload failed", "run_id", runID, "error", err)
 		return err
 	}
+	approvalPayload = r.redactor.JSON(approvalPayload)
 
+	stepApprovedEventID := ids.New()
 	_, err = tx.Exec(ctx,
 		`INSERT INTO events (id, run_id, step_id, type, payload)
 		 VALUES ($1, $2, $3, $4, $5::jsonb)`,
-		uuid.New(),
+		stepApprovedEventID,
 		runID,
 		approvalStepID,
-		"STEP_APPROVED",
+		domain.EventStepApproved,
 		approvalPayload,
 	)
 	if err != nil {
@@ -567,21 +2626,31 @@ func (r *RunRepository) ApproveRun(ctx context.Context, runID uuid.UUID) error {
 		return err
 	}
 
+	runApprovedEventID := ids.New()
 	_, err = tx.Exec(ctx,
 		`INSERT INTO events (id, run_id, type, payload)
 		 VALUES ($1, $2, $3, $4)`,
-		uuid.New(), runID, "RUN_APPROVED", `{"approved_by":"user"}`,
+		runApprovedEventID, runID, domain.EventRunApproved, `{"approved_by":"user"}`,
 	)
 	if err != nil {
 		r.logger.Error("insert approve event failed", "run_id", runID, "error", err)
 		return err
 	}
 
+	for _, eventID := range []uuid.UUID{stepApprovedEventID, runApprovedEventID} {
+		if err := outbox.EnqueueEvent(ctx, tx, eventID, domain.DefaultWebhookMaxAttempts); err != nil {
+			r.logger.Error("enqueue approve webhook failed", "run_id", runID, "error", err)
+			return err
+		}
+	}
+
 	var remaining int
 	if err := tx.QueryRow(ctx, `
 		SELECT COUNT(*) FROM steps
-		WHERE run_id=$1 AND status <> $2
-	`, runID, domain.StepSuccess).Scan(&remaining); err != nil {
+		WHERE run_id=$1
+		  AND status NOT IN ($2, $3)
+		  AND NOT (status=$4 AND on_failure=$5)
+	`, runID, domain.StepSuccess, domain.StepSkipped, domain.StepFailed, domain.OnFailureContinue).Scan(&remaining); err != nil {
 		r.logger.Error("count remaining steps failed", "run_id", runID, "error", err)
 		return err
 	}
@@ -590,25 +2659,54 @@ func (r *RunRepository) ApproveRun(ctx context.Context, runID uuid.UUID) error {
 	if remaining == 0 {
 		newStatus = domain.RunSuccess
 	}
+	if err := transition.Run(r.logger, runID, runStatus, newStatus); err != nil {
+		return err
+	}
 
 	_, err = tx.Exec(ctx,
-		`UPDATE runs SET status=$2, updated_at=NOW() WHERE id=$1`,
-		runID, newStatus,
+		`UPDATE runs SET status=$2, updated_at=$3 WHERE id=$1`,
+		runID, newStatus, now,
 	)
 	if err != nil {
 		r.logger.Error("update run status failed", "run_id", runID, "error", err)
 		return err
 	}
 
+	if newStatus == domain.RunSuccess {
+		if err := runsummary.Emit(ctx, tx, runID, domain.DefaultWebhookMaxAttempts); err != nil {
+			r.logger.Error("emit run summary failed", "run_id", runID, "error", err)
+			return err
+		}
+		// The run finished with this write, so updated_at is its finish time,
+		// as on every other terminal path.
+		if err := outbox.EnqueueTerminalWebhook(ctx, tx, runID, domain.RunSuccess, now, webhookURL.String, domain.DefaultWebhookMaxAttempts); err != nil {
+			r.logger.Error("enqueue terminal webhook failed", "run_id", runID, "error", err)
+			return err
+		}
+	}
+
+	if err := audit.Record(ctx, tx, now, audit.Change{
+		Action:     domain.AuditRunApprove,
+		APIKeyID:   apiKeyID,
+		TargetType: domain.AuditTargetRun,
+		TargetID:   runID.String(),
+		Before:     map[string]domain.RunStatus{"status": runStatus},
+		After:      map[string]any{"status": newStatus, "step_id": approvalStepID},
+	}); err != nil {
+		r.logger.Error("record run approval failed", "run_id", runID, "error", err)
+		return err
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		r.logger.Error("commit approve failed", "run_id", runID, "error", err)
 		return err
 	}
 
-	metrics.IncStepStatus(string(domain.StepSuccess))
-	metrics.IncRunStatus(string(newStatus))
+	metrics.IncStepStatus(apiKeyID, string(domain.StepSuccess))
+	metrics.IncRunStatus(apiKeyID, string(newStatus))
 	r.logger.Info("run approved",
 		"run_id", runID,
+		"step_id", approvalStepID,
 		"new_status", newStatus,
 	)
 
@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/adiadia/agent-runtime/internal/audit"
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/ids"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SecretRepository stores the named secrets template steps reference. Like
+// templates they are shared by every tenant. Values are sealed before they
+// reach the database and are never read back through this repository; only
+// workers open them.
+type SecretRepository struct {
+	pool   *pgxpool.Pool
+	logger *slog.Logger
+	clock  clock.Clock
+	cipher *secrets.Cipher
+}
+
+func NewSecretRepository(pool *pgxpool.Pool, logger *slog.Logger) *SecretRepository {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &SecretRepository{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// WithClock sets the clock used to stamp secrets.
+func (r *SecretRepository) WithClock(c clock.Clock) *SecretRepository {
+	r.clock = c
+	return r
+}
+
+// WithCipher sets the cipher values are sealed with. Without one PutSecret
+// returns domain.ErrSecretsKeyMissing.
+func (r *SecretRepository) WithCipher(c *secrets.Cipher) *SecretRepository {
+	r.cipher = c
+	return r
+}
+
+// PutSecret creates the named secret or replaces its value. Steps already
+// running keep the value they were given.
+func (r *SecretRepository) PutSecret(ctx context.Context, name, value string) (domain.Secret, error) {
+	if r.cipher == nil {
+		return domain.Secret{}, domain.ErrSecretsKeyMissing
+	}
+	sealed, err := r.cipher.Seal(name, []byte(value))
+	if err != nil {
+		r.logger.Error("seal secret failed", "secret", name, "error", err)
+		return domain.Secret{}, err
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		r.logger.Error("begin tx failed", "error", err)
+		return domain.Secret{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	now := nowUTC(r.clock)
+	var (
+		secret  = domain.Secret{Name: name}
+		created bool
+	)
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO secrets (id, name, ciphertext, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $4)
+		ON CONFLICT (name) DO UPDATE
+		SET ciphertext = EXCLUDED.ciphertext, updated_at = EXCLUDED.updated_at
+		RETURNING created_at, updated_at, xmax = 0
+	`, ids.New(), name, sealed, now).Scan(&secret.CreatedAt, &secret.UpdatedAt, &created); err != nil {
+		r.logger.Error("put secret failed", "secret", name, "error", err)
+		return domain.Secret{}, err
+	}
+
+	// The value is left out of the audit log on purpose.
+	if err := audit.Record(ctx, tx, now, audit.Change{
+		Action:     domain.AuditSecretPut,
+		TargetType: domain.AuditTargetSecret,
+		TargetID:   name,
+		After:      map[string]any{"created": created},
+	}); err != nil {
+		r.logger.Error("record secret put failed", "secret", name, "error", err)
+		return domain.Secret{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("commit failed", "secret", name, "error", err)
+		return domain.Secret{}, err
+	}
+
+	r.logger.Info("secret saved", "secret", name, "created", created)
+	return secret, nil
+}
+
+// ListSecrets returns every secret's name and timestamps, ordered by name.
+func (r *SecretRepository) ListSecrets(ctx context.Context) ([]domain.Secret, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT name, created_at, updated_at
+		FROM secrets
+		ORDER BY name ASC
+	`)
+	if err != nil {
+		r.logger.Error("list secrets failed", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []domain.Secret{}
+	for rows.Next() {
+		var s domain.Secret
+		if err := rows.Scan(&s.Name, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("scan secrets failed", "error", err)
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeleteSecret removes the named secret. Steps that reference it fail
+// permanently when claimed. It returns domain.ErrSecretNotFound for an
+// unknown name.
+func (r *SecretRepository) DeleteSecret(ctx context.Context, name string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		r.logger.Error("begin tx failed", "error", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `DELETE FROM secrets WHERE name = $1`, name)
+	if err != nil {
+		r.logger.Error("delete secret failed", "secret", name, "error", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return notFound(pgx.ErrNoRows, domain.ErrSecretNotFound)
+	}
+
+	if err := audit.Record(ctx, tx, nowUTC(r.clock), audit.Change{
+		Action:     domain.AuditSecretDelete,
+		TargetType: domain.AuditTargetSecret,
+		TargetID:   name,
+	}); err != nil {
+		r.logger.Error("record secret delete failed", "secret", name, "error", err)
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("commit failed", "secret", name, "error", err)
+		return err
+	}
+
+	r.logger.Info("secret deleted", "secret", name)
+	return nil
+}
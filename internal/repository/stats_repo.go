@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RunStatsRepository reads the trigger-maintained run_daily_stats summary so
+// usage and admin views never aggregate over runs directly.
+type RunStatsRepository struct {
+	pool    *pgxpool.Pool
+	logger  *slog.Logger
+	replica *ReadReplica
+}
+
+func NewRunStatsRepository(pool *pgxpool.Pool, logger *slog.Logger) *RunStatsRepository {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &RunStatsRepository{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// WithReadReplica serves ListDailyRunStats from replica.
+func (r *RunStatsRepository) WithReadReplica(replica *ReadReplica) *RunStatsRepository {
+	r.replica = replica
+	return r
+}
+
+// ListDailyRunStats returns the days in [from, to] that have activity for
+// apiKeyID, oldest first. It returns domain.ErrAPIKeyNotFound when the key
+// does not exist.
+func (r *RunStatsRepository) ListDailyRunStats(ctx context.Context, apiKeyID uuid.UUID, from, to time.Time) ([]domain.DailyRunStats, error) {
+	var days []domain.DailyRunStats
+	err := r.replica.read(ctx, r.pool, func(q querier) error {
+		var err error
+		days, err = r.listDailyRunStats(ctx, q, apiKeyID, from, to)
+		return err
+	})
+	return days, notFound(err, domain.ErrAPIKeyNotFound)
+}
+
+func (r *RunStatsRepository) listDailyRunStats(ctx context.Context, q querier, apiKeyID uuid.UUID, from, to time.Time) ([]domain.DailyRunStats, error) {
+	var exists bool
+	if err := q.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM api_keys WHERE id=$1)`,
+		apiKeyID,
+	).Scan(&exists); err != nil {
+		r.logger.Error("check api key failed", "api_key_id", apiKeyID, "error", err)
+		return nil, err
+	}
+	if !exists {
+		return nil, pgx.ErrNoRows
+	}
+
+	rows, err := q.Query(ctx, `
+		SELECT day,
+		       runs_created,
+		       runs_succeeded,
+		       runs_failed,
+		       runs_canceled,
+		       steps_executed,
+		       step_retries,
+		       total_cost_usd::double precision,
+		       total_duration_seconds
+		FROM run_daily_stats
+		WHERE api_key_id=$1
+		  AND day BETWEEN $2::date AND $3::date
+		ORDER BY day ASC
+	`, apiKeyID, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	if err != nil {
+		r.logger.Error("list daily run stats failed", "api_key_id", apiKeyID, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := make([]domain.DailyRunStats, 0)
+	for rows.Next() {
+		var (
+			s   domain.DailyRunStats
+			day time.Time
+		)
+		if err := rows.Scan(
+			&day,
+			&s.RunsCreated,
+			&s.RunsSucceeded,
+			&s.RunsFailed,
+			&s.RunsCanceled,
+			&s.StepsExecuted,
+			&s.StepRetries,
+			&s.TotalCostUSD,
+			&s.TotalDurationSeconds,
+		); err != nil {
+			return nil, err
+		}
+		s.Day = day.Format(time.DateOnly)
+		if finished := s.RunsFinished(); finished > 0 {
+			s.AvgDurationSeconds = s.TotalDurationSeconds / float64(finished)
+		}
+		days = append(days, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return days, nil
+}
@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	"github.com/adiadia/agent-runtime/internal/transition"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// errStepSkipped reports that the claimed step was skipped by its condition
+// instead of being handed to an executor.
+var errStepSkipped = fmt.Errorf("%w: skipped by condition", domain.ErrStepHandledAtClaim)
+
+// evaluateStepCondition evaluates a step condition against the run's metadata
+// and the steps that have finished so far. An unparsable condition returns an
+// error wrapping domain.ErrInvalidStepCondition.
+func (r *StepQueueRepository) evaluateStepCondition(ctx context.Context, tx pgx.Tx, runID, stepID uuid.UUID, expr string) (bool, error) {
+	condition, err := domain.ParseStepCondition(expr)
+	if err != nil {
+		return false, err
+	}
+	doc, err := loadConditionContext(ctx, tx, r.keyring, runID, stepID)
+	if err != nil {
+		return false, err
+	}
+	return condition.Evaluate(doc), nil
+}
+
+// loadConditionContext builds the document step conditions are evaluated
+// against: {"run": {...}, "steps": {"<NAME>": {"status", "output"}}}. When a
+// name repeats, the most recently finished step wins; MAP children are left
+// out, their outputs are read through the MAP step. Encrypted outputs are
+// opened with keyring.
+func loadConditionContext(ctx context.Context, tx pgx.Tx, keyring *secrets.Keyring, runID, stepID uuid.UUID) (map[string]any, error) {
+	var (
+		metadataJSON []byte
+		tags         []string
+		priority     int
+	)
+	if err := tx.QueryRow(ctx,
+		`SELECT metadata, tags, priority FROM runs WHERE id=$1`,
+		runID,
+	).Scan(&metadataJSON, &tags, &priority); err != nil {
+		return nil, err
+	}
+
+	var metadata map[string]any
+	if err := json.Unmarshal(metadataJSON, &metadata); err != nil {
+		return nil, err
+	}
+	tagValues := make([]any, 0, len(tags))
+	for _, tag := range tags {
+		tagValues = append(tagValues, tag)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT name, status, output
+		FROM steps
+		WHERE run_id=$1
+		  AND id <> $2
+		  AND parent_step_id IS NULL
+		  AND finished_at IS NOT NULL
+		ORDER BY finished_at ASC
+	`, runID, stepID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steps := make(map[string]any)
+	for rows.Next() {
+		var (
+			name       string
+			status     string
+			outputJSON json.RawMessage
+		)
+		if err := rows.Scan(&name, &status, &outputJSON); err != nil {
+			return nil, err
+		}
+		outputJSON, err := keyring.OpenJSON(secrets.FieldStepOutput, outputJSON)
+		if err != nil {
+			return nil, fmt.Errorf("output of step %s: %w", name, err)
+		}
+		var output any
+		if len(outputJSON) > 0 {
+			if err := json.Unmarshal(outputJSON, &output); err != nil {
+				return nil, err
+			}
+		}
+		steps[name] = map[string]any{
+			"status": status,
+			"output": output,
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"run": map[string]any{
+			"metadata": metadata,
+			"tags":     tagValues,
+			"priority": float64(priority),
+		},
+		"steps": steps,
+	}, nil
+}
+
+// skipStepByCondition marks a pending step SKIPPED because its condition is
+// false and commits tx. The run moves on exactly as if the step had succeeded.
+// It returns errStepSkipped once the skip is committed.
+func (r *StepQueueRepository) skipStepByCondition(ctx context.Context, tx pgx.Tx, s domain.ClaimedStep, condition string) error {
+	if err := r.markConditionSkipped(ctx, tx, s.APIKeyID, s.RunID, s.StepID, s.Name, s.Status, condition); err != nil {
+		return err
+	}
+
+	// The run may not have started yet; a run only completes from RUNNING.
+	runStatusUpdated, err := tx.Exec(ctx, `
+		UPDATE runs
+		SET status=$2, updated_at=$4
+		WHERE id=$1 AND status=$3
+	`,
+		s.RunID,
+		domain.RunRunning,
+		domain.RunPending,
+		nowUTC(r.clock),
+	)
+	if err != nil {
+		return err
+	}
+
+	var runWaiting bool
+	if s.Name == domain.StepTool || s.Name == domain.StepMap {
+		if runWaiting, err = r.promoteApproval(ctx, tx, s.APIKeyID, s.RunID); err != nil {
+			return err
+		}
+	}
+
+	runTerminal, err := r.completeRunIfDone(ctx, tx, s.RunID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	if runStatusUpdated.RowsAffected() > 0 {
+		metrics.IncRunStatus(s.APIKeyID, string(domain.RunRunning))
+	}
+	if runWaiting {
+		metrics.IncRunStatus(s.APIKeyID, string(domain.RunWaiting))
+	}
+	if runTerminal {
+		metrics.IncRunStatus(s.APIKeyID, string(domain.RunSuccess))
+	}
+	return errStepSkipped
+}
+
+// markConditionSkipped records a step as SKIPPED by its condition.
+func (r *StepQueueRepository) markConditionSkipped(
+	ctx context.Context,
+	tx pgx.Tx,
+	apiKeyID uuid.UUID,
+	runID uuid.UUID,
+	stepID uuid.UUID,
+	stepName domain.StepName,
+	current domain.StepStatus,
+	condition string,
+) error {
+	if err := transition.Step(r.logger, stepID, current, domain.StepSkipped); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE steps
+		SET status=$2,
+		    next_run_at=NULL,
+		    finished_at=$3
+		WHERE id=$1
+	`,
+		stepID,
+		domain.StepSkipped,
+		nowUTC(r.clock),
+	); err != nil {
+		return err
+	}
+
+	if err := r.insertStepEvent(ctx, tx, runID, stepID, domain.EventStepSkipped, map[string]any{
+		"status":    domain.StepSkipped,
+		"step":      stepName,
+		"reason":    "condition",
+		"condition": condition,
+	}); err != nil {
+		return err
+	}
+
+	metrics.IncStepStatus(apiKeyID, string(domain.StepSkipped))
+	r.logger.Info("step skipped by condition",
+		"api_key_id", apiKeyID,
+		"run_id", runID,
+		"step_id", stepID,
+		"step", stepName,
+		"condition", condition,
+	)
+	return nil
+}
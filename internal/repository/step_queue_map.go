@@ -0,0 +1,396 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/ids"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	"github.com/adiadia/agent-runtime/internal/transition"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// errMapExpanded reports that the claimed step was a MAP step that was
+// expanded into child steps instead of being handed to an executor.
+var errMapExpanded = fmt.Errorf("%w: map step expanded", domain.ErrStepHandledAtClaim)
+
+// loadMapItems reads a MAP step's configuration and resolves the array it fans
+// out over. Configuration and item problems wrap domain.ErrInvalidMapStep.
+func loadMapItems(ctx context.Context, tx pgx.Tx, keyring *secrets.Keyring, runID, stepID uuid.UUID) (domain.MapStepConfig, []any, error) {
+	var (
+		itemsPath   string
+		stepName    string
+		parallelism int
+	)
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(map_items, ''), COALESCE(map_step, ''), COALESCE(map_parallelism, 0)
+		FROM steps
+		WHERE id=$1
+	`, stepID).Scan(&itemsPath, &stepName, &parallelism); err != nil {
+		return domain.MapStepConfig{}, nil, err
+	}
+
+	cfg, err := domain.ParseMapStepConfig(itemsPath, stepName, parallelism)
+	if err != nil {
+		return domain.MapStepConfig{}, nil, err
+	}
+
+	doc, err := loadConditionContext(ctx, tx, keyring, runID, stepID)
+	if err != nil {
+		return domain.MapStepConfig{}, nil, err
+	}
+	items, ok := cfg.Items.Lookup(doc).([]any)
+	if !ok {
+		return domain.MapStepConfig{}, nil, fmt.Errorf("%w: %s is not an array", domain.ErrInvalidMapStep, cfg.Items)
+	}
+	if len(items) > domain.MaxMapItems {
+		return domain.MapStepConfig{}, nil, fmt.Errorf("%w: %s has %d items, more than %d", domain.ErrInvalidMapStep, cfg.Items, len(items), domain.MaxMapItems)
+	}
+	return cfg, items, nil
+}
+
+// expandMapStep marks a pending MAP step RUNNING, creates one pending child
+// step per item, and commits tx. Children share the MAP step's position, so
+// they become claimable right away and the steps after the MAP step wait for
+// all of them. It returns errMapExpanded once the expansion is committed.
+func (r *StepQueueRepository) expandMapStep(ctx context.Context, tx pgx.Tx, s domain.ClaimedStep, cfg domain.MapStepConfig, items []any, now time.Time) error {
+	if err := transition.Step(r.logger, s.StepID, s.Status, domain.StepRunning); err != nil {
+		return err
+	}
+
+	inputPayload, _ := json.Marshal(map[string]any{
+		"step":      s.Name,
+		"claimedAt": now,
+		"items":     len(items),
+	})
+	inputPayload, err := r.keyring.SealJSON(secrets.FieldStepInput, inputPayload)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE steps
+		SET status=$2,
+		    started_at=COALESCE(started_at, $4),
+		    input=$3::jsonb,
+		    next_run_at=NULL,
+		    attempts = attempts + 1
+		WHERE id=$1
+	`,
+		s.StepID,
+		domain.StepRunning,
+		inputPayload,
+		now,
+	); err != nil {
+		return err
+	}
+
+	for i, item := range items {
+		itemJSON, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if itemJSON, err = r.keyring.SealJSON(secrets.FieldStepItem, itemJSON); err != nil {
+			return err
+		}
+		// Children inherit the MAP step's timeout, failure policy, and position.
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO steps (id, run_id, name, status, timeout_seconds, on_failure, position, parent_step_id, map_index, item,
+			                   max_attempts, retry_base_delay_ms, retry_backoff, retry_jitter, retry_priority, command, http, secrets, input_override)
+			SELECT $1, run_id, $2, $3, timeout_seconds, on_failure, position, id, $4, $5::jsonb,
+			       max_attempts, retry_base_delay_ms, retry_backoff, retry_jitter, retry_priority, command, http, secrets, input_override
+			FROM steps
+			WHERE id=$6
+		`,
+			ids.New(),
+			cfg.Step,
+			domain.StepPending,
+			i,
+			itemJSON,
+			s.StepID,
+		); err != nil {
+			return err
+		}
+	}
+
+	runStatusUpdated, err := tx.Exec(ctx, `
+		UPDATE runs
+		SET status=$2, updated_at=$4
+		WHERE id=$1 AND status=$3
+	`,
+		s.RunID,
+		domain.RunRunning,
+		domain.RunPending,
+		now,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := r.insertStepEvent(ctx, tx, s.RunID, s.StepID, domain.EventStepClaimed, map[string]any{
+		"status":     domain.StepRunning,
+		"step":       s.Name,
+		"reclaimed":  false,
+		"previous":   s.Status,
+		"api_key_id": s.APIKeyID,
+		"claimed_at": now,
+		"map_items":  len(items),
+		"map_step":   cfg.Step,
+	}); err != nil {
+		return err
+	}
+	if err := countClaim(ctx, tx, s.APIKeyID, now); err != nil {
+		return err
+	}
+
+	// An empty array leaves nothing to wait for.
+	var (
+		mapDone     bool
+		runWaiting  bool
+		runTerminal bool
+	)
+	if len(items) == 0 {
+		if mapDone, runWaiting, err = r.settleMapStep(ctx, tx, s.APIKeyID, s.RunID, s.StepID); err != nil {
+			return err
+		}
+		if runTerminal, err = r.completeRunIfDone(ctx, tx, s.RunID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	metrics.IncStepClaim(s.APIKeyID)
+	if runStatusUpdated.RowsAffected() > 0 {
+		metrics.IncRunStatus(s.APIKeyID, string(domain.RunRunning))
+	}
+	if mapDone {
+		metrics.IncStepStatus(s.APIKeyID, string(domain.StepSuccess))
+	}
+	if runWaiting {
+		metrics.IncRunStatus(s.APIKeyID, string(domain.RunWaiting))
+	}
+	if runTerminal {
+		metrics.IncRunStatus(s.APIKeyID, string(domain.RunSuccess))
+	}
+
+	r.logger.Info("map step expanded",
+		"api_key_id", s.APIKeyID,
+		"run_id", s.RunID,
+		"step_id", s.StepID,
+		"map_step", cfg.Step,
+		"items", len(items),
+		"parallelism", cfg.Parallelism,
+	)
+
+	return errMapExpanded
+}
+
+// mapChildClaimable locks a child's MAP step and reports whether another
+// child may start without exceeding map_parallelism. Locking the MAP step
+// serializes concurrent child claims so the limit holds across workers.
+func mapChildClaimable(ctx context.Context, tx pgx.Tx, parentID, childID uuid.UUID) (bool, error) {
+	var parallelism int
+	if err := tx.QueryRow(ctx,
+		`SELECT COALESCE(map_parallelism, $2) FROM steps WHERE id=$1 FOR UPDATE`,
+		parentID,
+		domain.DefaultMapParallelism,
+	).Scan(&parallelism); err != nil {
+		return false, err
+	}
+
+	var running int
+	if err := tx.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM steps
+		WHERE parent_step_id=$1
+		  AND id <> $2
+		  AND status=$3
+	`,
+		parentID,
+		childID,
+		domain.StepRunning,
+	).Scan(&running); err != nil {
+		return false, err
+	}
+	return running < parallelism, nil
+}
+
+// settleMapStep marks a RUNNING MAP step SUCCEEDED once all of its children
+// have settled, with output {"items": n, "results": [...]} holding each
+// child's output in item order (null for skipped or failed children). It then
+// promotes a pending approval like a finished TOOL step would. It reports
+// whether the MAP step was completed by this call, and whether the run moved
+// to WAITING_APPROVAL with it.
+func (r *StepQueueRepository) settleMapStep(ctx context.Context, tx pgx.Tx, apiKeyID, runID, mapStepID uuid.UUID) (bool, bool, error) {
+	current, err := transition.LockStep(ctx, tx, mapStepID)
+	if err != nil {
+		return false, false, err
+	}
+	if current != domain.StepRunning {
+		return false, false, nil
+	}
+
+	var settled bool
+	if err := tx.QueryRow(ctx, `
+		SELECT NOT EXISTS (
+			SELECT 1 FROM steps c
+			WHERE c.parent_step_id=$1
+			  AND c.status NOT IN ($2, $3)
+			  AND NOT (c.status = $4 AND c.on_failure = $5)
+		)
+	`,
+		mapStepID,
+		domain.StepSuccess,
+		domain.StepSkipped,
+		domain.StepFailed,
+		domain.OnFailureContinue,
+	).Scan(&settled); err != nil {
+		return false, false, err
+	}
+	if !settled {
+		return false, false, nil
+	}
+	if err := transition.Step(r.logger, mapStepID, current, domain.StepSuccess); err != nil {
+		return false, false, err
+	}
+
+	output, items, err := r.mapStepOutput(ctx, tx, mapStepID)
+	if err != nil {
+		return false, false, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE steps
+		SET status=$2,
+		    output=$3::jsonb,
+		    next_run_at=NULL,
+		    finished_at=$4
+		WHERE id=$1
+	`,
+		mapStepID,
+		domain.StepSuccess,
+		output,
+		nowUTC(r.clock),
+	); err != nil {
+		return false, false, err
+	}
+
+	if err := r.insertStepEvent(ctx, tx, runID, mapStepID, domain.EventStepSucceeded, map[string]any{
+		"status": domain.StepSuccess,
+		"step":   domain.StepMap,
+		"items":  items,
+	}); err != nil {
+		return false, false, err
+	}
+
+	runWaiting, err := r.promoteApproval(ctx, tx, apiKeyID, runID)
+	if err != nil {
+		return false, false, err
+	}
+	return true, runWaiting, nil
+}
+
+// mapStepOutput builds a settled MAP step's output from its children. It is
+// assembled here rather than in SQL because child outputs may be encrypted:
+// each is opened, and the result sealed again as a whole.
+func (r *StepQueueRepository) mapStepOutput(ctx context.Context, tx pgx.Tx, mapStepID uuid.UUID) (json.RawMessage, int, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT status, output
+		FROM steps
+		WHERE parent_step_id=$1
+		ORDER BY map_index ASC
+	`, mapStepID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []json.RawMessage{}
+	for rows.Next() {
+		var (
+			status domain.StepStatus
+			output json.RawMessage
+		)
+		if err := rows.Scan(&status, &output); err != nil {
+			return nil, 0, err
+		}
+		if status != domain.StepSuccess || len(output) == 0 {
+			results = append(results, json.RawMessage("null"))
+			continue
+		}
+		if output, err = r.keyring.OpenJSON(secrets.FieldStepOutput, output); err != nil {
+			return nil, 0, err
+		}
+		results = append(results, output)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	doc, err := json.Marshal(map[string]any{"items": len(results), "results": results})
+	if err != nil {
+		return nil, 0, err
+	}
+	sealed, err := r.keyring.SealJSON(secrets.FieldStepOutput, doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	return sealed, len(results), nil
+}
+
+// failMapStep marks a RUNNING MAP step FAILED after one of its children failed
+// the run.
+func (r *StepQueueRepository) failMapStep(ctx context.Context, tx pgx.Tx, runID, mapStepID, childID uuid.UUID, execErr error) (bool, error) {
+	current, err := transition.LockStep(ctx, tx, mapStepID)
+	if err != nil {
+		return false, err
+	}
+	if current != domain.StepRunning {
+		return false, nil
+	}
+	if err := transition.Step(r.logger, mapStepID, current, domain.StepFailed); err != nil {
+		return false, err
+	}
+
+	payload, _ := json.Marshal(map[string]any{
+		"error":       execErr.Error(),
+		"failed_step": childID,
+	})
+	payload, err = r.keyring.SealJSON(secrets.FieldStepOutput, payload)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE steps
+		SET status=$2,
+		    output=$3::jsonb,
+		    next_run_at=NULL,
+		    finished_at=$4
+		WHERE id=$1
+	`,
+		mapStepID,
+		domain.StepFailed,
+		payload,
+		nowUTC(r.clock),
+	); err != nil {
+		return false, err
+	}
+
+	if err := r.insertStepEvent(ctx, tx, runID, mapStepID, domain.EventStepFailed, map[string]any{
+		"status":      domain.StepFailed,
+		"step":        domain.StepMap,
+		"error":       execErr.Error(),
+		"failed_step": childID,
+	}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,877 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/budget"
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/egress"
+	"github.com/adiadia/agent-runtime/internal/ids"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/adiadia/agent-runtime/internal/outbox"
+	"github.com/adiadia/agent-runtime/internal/redact"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	"github.com/adiadia/agent-runtime/internal/transition"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// StepQueueRepository is the Postgres StepQueue. Claims lock runnable steps
+// with FOR UPDATE SKIP LOCKED, so concurrent workers never claim the same
+// step; each claim and each settlement is one transaction that also moves
+// the step's run along and enqueues the events and webhooks it produced.
+type StepQueueRepository struct {
+	pool               *pgxpool.Pool
+	logger             *slog.Logger
+	clock              clock.Clock
+	keyring            *secrets.Keyring
+	redactor           *redact.Redactor
+	webhookMaxAttempts int
+	approvalLink       string
+	// jitter returns a random value in [0, n); rand.Int64N outside tests.
+	jitter func(n int64) int64
+}
+
+func NewStepQueueRepository(pool *pgxpool.Pool, logger *slog.Logger) *StepQueueRepository {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &StepQueueRepository{
+		pool:               pool,
+		logger:             logger,
+		webhookMaxAttempts: domain.DefaultWebhookMaxAttempts,
+		approvalLink:       domain.DefaultApprovalLink,
+		jitter:             rand.Int64N,
+	}
+}
+
+// WithClock sets the clock used for claims, leases, retries, and the
+// timestamps of settled steps and runs.
+func (r *StepQueueRepository) WithClock(c clock.Clock) *StepQueueRepository {
+	r.clock = c
+	return r
+}
+
+// WithKeyring encrypts step input and output at rest and opens them, and MAP
+// items and input overrides, when read. Nil stores them in plaintext.
+func (r *StepQueueRepository) WithKeyring(keyring *secrets.Keyring) *StepQueueRepository {
+	r.keyring = keyring
+	return r
+}
+
+// WithRedactor applies redactor to the event payloads the repository writes.
+func (r *StepQueueRepository) WithRedactor(redactor *redact.Redactor) *StepQueueRepository {
+	r.redactor = redactor
+	return r
+}
+
+// WithWebhookMaxAttempts sets how many times the webhooks and event
+// deliveries enqueued by settled steps are attempted; n <= 0 keeps
+// domain.DefaultWebhookMaxAttempts.
+func (r *StepQueueRepository) WithWebhookMaxAttempts(n int) *StepQueueRepository {
+	if n > 0 {
+		r.webhookMaxAttempts = n
+	}
+	return r
+}
+
+// WithApprovalLink sets the link put in WAITING_APPROVAL webhooks; {run_id}
+// and {step_id} are replaced. Empty keeps domain.DefaultApprovalLink.
+func (r *StepQueueRepository) WithApprovalLink(link string) *StepQueueRepository {
+	if link = strings.TrimSpace(link); link != "" {
+		r.approvalLink = link
+	}
+	return r
+}
+
+// errApprovalOpened reports that the claimed step was an approval gate that
+// now waits for approval.
+var errApprovalOpened = fmt.Errorf("%w: approval gate opened", domain.ErrStepHandledAtClaim)
+
+// ClaimStep claims one runnable step and leases it to req.WorkerID for
+// req.Lease. It also reclaims RUNNING steps whose lease expired because the
+// worker holding it stopped renewing. Approval gates, MAP steps, and steps
+// whose condition is false are handled here and committed; the error then
+// wraps domain.ErrStepHandledAtClaim. It returns domain.ErrNoStepToClaim when
+// nothing is runnable within the tenant's limits.
+func (r *StepQueueRepository) ClaimStep(ctx context.Context, req domain.ClaimRequest) (domain.ClaimedStep, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return domain.ClaimedStep{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	now := nowUTC(r.clock)
+
+	guard, err := r.claimGuards(ctx, tx, req, now)
+	if err != nil {
+		return domain.ClaimedStep{}, err
+	}
+
+	candidates, err := r.selectClaimCandidates(ctx, tx, req, now, guard, 1)
+	if err != nil {
+		return domain.ClaimedStep{}, err
+	}
+	if len(candidates) == 0 {
+		return domain.ClaimedStep{}, domain.ErrNoStepToClaim
+	}
+	s, condition := candidates[0].step, candidates[0].condition
+
+	// Validate step name to avoid corrupted DB values
+	if !validClaimName(s.Name) {
+		return domain.ClaimedStep{}, errors.New("invalid step name in DB: " + string(s.Name))
+	}
+
+	// Gates right after another gate or an LLM step are opened here; gates
+	// after TOOL and MAP steps are usually opened as those settle.
+	if s.Name == domain.StepApproval {
+		return domain.ClaimedStep{}, r.openApprovalGate(ctx, tx, s)
+	}
+
+	// The query filters on map_parallelism without locks; recheck it under
+	// the MAP step's lock.
+	if s.ParentStepID != nil {
+		ok, err := mapChildClaimable(ctx, tx, *s.ParentStepID, s.StepID)
+		if err != nil {
+			return domain.ClaimedStep{}, err
+		}
+		if !ok {
+			return domain.ClaimedStep{}, domain.ErrNoStepToClaim
+		}
+	}
+
+	// A pending step with a false condition is skipped rather than claimed.
+	if s.Status == domain.StepPending && condition != "" {
+		matched, err := r.evaluateStepCondition(ctx, tx, s.RunID, s.StepID, condition)
+		switch {
+		case errors.Is(err, domain.ErrInvalidStepCondition), undecryptable(err):
+			s.ConfigErr = err
+		case err != nil:
+			return domain.ClaimedStep{}, err
+		case !matched:
+			return domain.ClaimedStep{}, r.skipStepByCondition(ctx, tx, s, condition)
+		}
+	}
+
+	// A MAP step is expanded into child steps rather than executed.
+	if s.Name == domain.StepMap && s.ConfigErr == nil {
+		cfg, items, err := loadMapItems(ctx, tx, r.keyring, s.RunID, s.StepID)
+		switch {
+		case errors.Is(err, domain.ErrInvalidMapStep), undecryptable(err):
+			s.ConfigErr = err
+		case err != nil:
+			return domain.ClaimedStep{}, err
+		default:
+			return domain.ClaimedStep{}, r.expandMapStep(ctx, tx, s, cfg, items, now)
+		}
+	}
+
+	runStarted, err := r.markClaimed(ctx, tx, req, s, now)
+	if err != nil {
+		return domain.ClaimedStep{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return domain.ClaimedStep{}, err
+	}
+
+	r.claimCommitted(req, s, runStarted)
+	return s, nil
+}
+
+// ClaimSteps claims up to n runnable steps in one transaction: one guard
+// check, one locking query, and one commit for the lot. Candidates are taken
+// in claim order and the batch stops before the first one that needs
+// handling at claim, so that step is never overtaken; when it comes first,
+// ClaimSteps returns domain.ErrClaimSingly.
+func (r *StepQueueRepository) ClaimSteps(ctx context.Context, req domain.ClaimRequest, n int) ([]domain.ClaimedStep, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	now := nowUTC(r.clock)
+
+	guard, err := r.claimGuards(ctx, tx, req, now)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := r.selectClaimCandidates(ctx, tx, req, now, guard, min(n, guard.headroom))
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, domain.ErrNoStepToClaim
+	}
+
+	var (
+		stopped     bool
+		steps       = make([]domain.ClaimedStep, 0, len(candidates))
+		runsStarted = make([]bool, 0, len(candidates))
+		// probed holds probing step types that already have their probe.
+		probed = map[domain.StepName]bool{}
+	)
+	for _, c := range candidates {
+		s := c.step
+		if !validClaimName(s.Name) || s.Name == domain.StepApproval ||
+			(s.Status == domain.StepPending && (s.Name == domain.StepMap || c.condition != "")) {
+			stopped = true
+			break
+		}
+		if probed[s.Name] {
+			continue
+		}
+		// Steps that start runs share their template's remaining slots, and
+		// capped step types their remaining global slots.
+		if c.runPending && !takeSlot(guard.runSlots, c.template) {
+			continue
+		}
+		if !takeSlot(guard.stepSlots, s.Name) {
+			continue
+		}
+		if s.ParentStepID != nil {
+			ok, err := mapChildClaimable(ctx, tx, *s.ParentStepID, s.StepID)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		runStarted, err := r.markClaimed(ctx, tx, req, s, now)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, s)
+		runsStarted = append(runsStarted, runStarted)
+		if slices.Contains(req.Probing, s.Name) {
+			probed[s.Name] = true
+		}
+	}
+	if len(steps) == 0 {
+		if stopped {
+			return nil, domain.ErrClaimSingly
+		}
+		return nil, domain.ErrNoStepToClaim
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	for i, s := range steps {
+		r.claimCommitted(req, s, runsStarted[i])
+	}
+	return steps, nil
+}
+
+// claimCandidate is a row of the claim query: a step not yet checked for
+// its condition, MAP expansion, or MAP parallelism.
+type claimCandidate struct {
+	step      domain.ClaimedStep
+	condition string
+	// runPending and template tell whether claiming the step starts a run,
+	// and of which template.
+	runPending bool
+	template   string
+}
+
+// claimGuard is what the tenant's limits leave open for one claim
+// transaction.
+type claimGuard struct {
+	// headroom is how many more steps the tenant may run now.
+	headroom int
+	// blocked holds the step types not to claim: the request's and those at
+	// their global cap.
+	blocked []string
+	// runSlots holds, for each template with a run cap, how many more of its
+	// runs may start.
+	runSlots map[string]int
+	// stepSlots holds, for each step type with a global cap, how many more of
+	// its steps may run across all tenants.
+	stepSlots map[domain.StepName]int
+	// egressHosts is the tenant's egress allow-list, or egressErr why it is
+	// invalid; HTTP steps fail with it.
+	egressHosts []string
+	egressErr   error
+}
+
+// fullTemplates returns the templates whose run cap leaves no run to start.
+func (g claimGuard) fullTemplates() []string {
+	full := []string{}
+	for name, slots := range g.runSlots {
+		if slots <= 0 {
+			full = append(full, name)
+		}
+	}
+	return full
+}
+
+// takeSlot reports whether key has a slot left, using it up if so. Keys
+// without an entry are not capped.
+func takeSlot[K comparable](slots map[K]int, key K) bool {
+	n, ok := slots[key]
+	if !ok {
+		return true
+	}
+	if n <= 0 {
+		return false
+	}
+	slots[key] = n - 1
+	return true
+}
+
+// claimGuards returns what the tenant's limits leave open for this claim. It
+// returns domain.ErrNoStepToClaim when the tenant's concurrency limit or
+// monthly budget leaves nothing to claim.
+func (r *StepQueueRepository) claimGuards(ctx context.Context, tx pgx.Tx, req domain.ClaimRequest, now time.Time) (claimGuard, error) {
+	var (
+		maxConcurrency int
+		runLimits      map[string]int
+		egressHosts    []string
+	)
+	err := tx.QueryRow(ctx,
+		`SELECT max_concurrent_runs, max_concurrent_runs_per_template, egress_allow_hosts FROM api_keys WHERE id=$1`,
+		req.APIKeyID,
+	).Scan(&maxConcurrency, &runLimits, &egressHosts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return claimGuard{}, domain.ErrNoStepToClaim
+	}
+	if err != nil {
+		return claimGuard{}, err
+	}
+	if maxConcurrency <= 0 {
+		maxConcurrency = domain.DefaultMaxConcurrentRuns
+	}
+
+	var runningSteps int
+	if err := tx.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM steps st
+		JOIN runs r ON st.run_id = r.id
+		WHERE r.api_key_id = $1
+		  AND st.status = $2
+	`,
+		req.APIKeyID,
+		domain.StepRunning,
+	).Scan(&runningSteps); err != nil {
+		return claimGuard{}, err
+	}
+	if runningSteps >= maxConcurrency {
+		r.logger.Debug("claim skipped by concurrency limit",
+			"api_key_id", req.APIKeyID,
+			"running_steps", runningSteps,
+			"max_concurrency", maxConcurrency,
+		)
+		return claimGuard{}, domain.ErrNoStepToClaim
+	}
+
+	monthly, err := budget.Load(ctx, tx, req.APIKeyID, now)
+	if err != nil {
+		return claimGuard{}, err
+	}
+	if monthly.Exceeded() {
+		r.logger.Debug("claim skipped by monthly budget",
+			"api_key_id", req.APIKeyID,
+			"month_to_date_cost_usd", monthly.SpentUSD,
+			"monthly_budget_usd", *monthly.LimitUSD,
+		)
+		return claimGuard{}, domain.ErrNoStepToClaim
+	}
+
+	runSlots, err := templateRunSlots(ctx, tx, req.APIKeyID, runLimits)
+	if err != nil {
+		return claimGuard{}, err
+	}
+
+	blocked := make([]string, 0, len(req.Blocked))
+	for _, name := range req.Blocked {
+		blocked = append(blocked, string(name))
+	}
+
+	// So are step types at their global cap.
+	stepSlots, err := globalStepSlots(ctx, tx, req.GlobalStepLimits, now)
+	if err != nil {
+		return claimGuard{}, err
+	}
+	var capped []string
+	for name, slots := range stepSlots {
+		if slots <= 0 && !slices.Contains(blocked, string(name)) {
+			capped = append(capped, string(name))
+		}
+	}
+	if len(capped) > 0 {
+		r.logger.Debug("claim skipping step types at global limit",
+			"api_key_id", req.APIKeyID,
+			"steps", capped,
+		)
+		blocked = append(blocked, capped...)
+	}
+
+	if _, err = egress.ParseHostRules(egressHosts); err != nil {
+		err = fmt.Errorf("egress allow hosts: %w", err)
+	}
+
+	return claimGuard{
+		headroom:    maxConcurrency - runningSteps,
+		blocked:     blocked,
+		runSlots:    runSlots,
+		stepSlots:   stepSlots,
+		egressHosts: egressHosts,
+		egressErr:   err,
+	}, nil
+}
+
+// globalStepSlots returns how many more steps of each globally capped step
+// type may run, counting RUNNING steps of every tenant whose lease is live.
+// It locks each capped type for the rest of tx first, so claim transactions
+// of all workers count and claim capped types one at a time.
+func globalStepSlots(ctx context.Context, tx pgx.Tx, limits map[domain.StepName]int, now time.Time) (map[domain.StepName]int, error) {
+	if len(limits) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(limits))
+	slots := make(map[domain.StepName]int, len(limits))
+	for name, limit := range limits {
+		names = append(names, string(name))
+		slots[name] = limit
+	}
+	// A fixed lock order keeps two workers from deadlocking.
+	slices.Sort(names)
+	for _, name := range names {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, "step_limit:"+name); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT name, COUNT(*)
+		FROM steps
+		WHERE status = $1
+		  AND name = ANY($2::text[])
+		  AND (lease_expires_at IS NULL OR lease_expires_at >= $3)
+		GROUP BY name
+	`,
+		domain.StepRunning,
+		names,
+		now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			name    string
+			running int
+		)
+		if err := rows.Scan(&name, &running); err != nil {
+			return nil, err
+		}
+		slots[domain.StepName(name)] -= running
+	}
+	return slots, rows.Err()
+}
+
+// templateRunSlots returns how many more runs of each capped template may
+// start, counting the tenant's RUNNING and WAITING runs as CreateRun does.
+func templateRunSlots(ctx context.Context, tx pgx.Tx, apiKeyID uuid.UUID, limits map[string]int) (map[string]int, error) {
+	slots := make(map[string]int, len(limits))
+	if len(limits) == 0 {
+		return slots, nil
+	}
+
+	names := make([]string, 0, len(limits))
+	for name, limit := range limits {
+		names = append(names, name)
+		slots[name] = limit
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT template_name, COUNT(*)
+		FROM runs
+		WHERE api_key_id = $1
+		  AND template_name = ANY($2::text[])
+		  AND status IN ($3, $4)
+		GROUP BY template_name
+	`,
+		apiKeyID,
+		names,
+		domain.RunRunning,
+		domain.RunWaiting,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			name   string
+			active int
+		)
+		if err := rows.Scan(&name, &active); err != nil {
+			return nil, err
+		}
+		slots[name] -= active
+	}
+	return slots, rows.Err()
+}
+
+// selectClaimCandidates locks up to limit runnable steps of the tenant, in
+// claim order, skipping rows other workers hold. Steps that would start a run
+// of a template at its run cap are left alone.
+func (r *StepQueueRepository) selectClaimCandidates(ctx context.Context, tx pgx.Tx, req domain.ClaimRequest, now time.Time, guard claimGuard, limit int) ([]claimCandidate, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT st.id, st.run_id, st.name, st.status, st.timeout_seconds, COALESCE(st.condition, ''),
+		       st.parent_step_id, st.item, st.command, st.http, st.secrets, st.input_override, st.attempts, st.execution_attempt, r.status = $17, COALESCE(r.template_name, '')
+		FROM steps st
+		JOIN runs r ON st.run_id = r.id
+		WHERE (
+			st.status = $1 OR
+			(st.status = $2 AND st.lease_expires_at IS NOT NULL AND st.lease_expires_at < $3)
+		)
+		  AND (st.next_run_at IS NULL OR st.next_run_at <= $9)
+		  AND NOT (st.name = $13 AND st.status = $2)
+		  AND NOT (st.name = ANY($15::text[]))
+		  AND r.status NOT IN ($4,$5,$6)
+		  AND r.api_key_id = $8
+		  AND NOT (r.status = $17 AND r.template_name = ANY($18::text[]))
+		  AND NOT EXISTS (
+			SELECT 1 FROM steps s2
+			WHERE s2.run_id = st.run_id
+			  AND s2.position < st.position
+			  AND s2.status NOT IN ($7, $10)
+			  AND NOT (s2.status = $11 AND s2.on_failure = $12)
+		  )
+		  AND (st.parent_step_id IS NULL OR (
+			SELECT COUNT(*) FROM steps c
+			WHERE c.parent_step_id = st.parent_step_id
+			  AND c.id <> st.id
+			  AND c.status = $2
+		  ) < (
+			SELECT COALESCE(p.map_parallelism, $14) FROM steps p WHERE p.id = st.parent_step_id
+		  ))
+		ORDER BY r.priority + st.priority_boost DESC, st.created_at ASC, st.position ASC, st.map_index ASC
+		FOR UPDATE SKIP LOCKED
+		LIMIT $16
+	`,
+		domain.StepPending,
+		domain.StepRunning,
+		now,
+		domain.RunCanceled,
+		domain.RunFailed,
+		domain.RunSuccess,
+		domain.StepSuccess,
+		req.APIKeyID,
+		now,
+		domain.StepSkipped,
+		domain.StepFailed,
+		domain.OnFailureContinue,
+		domain.StepMap,
+		domain.DefaultMapParallelism,
+		guard.blocked,
+		limit,
+		domain.RunPending,
+		guard.fullTemplates(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := make([]claimCandidate, 0, limit)
+	for rows.Next() {
+		var (
+			c              claimCandidate
+			nameStr        string
+			timeoutSeconds sql.NullInt64
+		)
+		if err := rows.Scan(&c.step.StepID, &c.step.RunID, &nameStr, &c.step.Status, &timeoutSeconds, &c.condition,
+			&c.step.ParentStepID, &c.step.Item, &c.step.Command, &c.step.HTTP, &c.step.Secrets, &c.step.InputOverride, &c.step.Attempt, &c.step.ExecutionAttempt, &c.runPending, &c.template); err != nil {
+			return nil, err
+		}
+		c.step.APIKeyID = req.APIKeyID
+		c.step.Name = domain.StepName(nameStr)
+		c.step.Attempt++
+		if c.step.Status != domain.StepRunning || c.step.ExecutionAttempt == 0 {
+			c.step.ExecutionAttempt++
+		}
+		c.step.ClaimToken = uuid.New()
+		if c.step.InputOverride, err = r.keyring.OpenJSON(secrets.FieldStepInputOverride, c.step.InputOverride); err != nil {
+			c.step.ConfigErr = fmt.Errorf("step input: %w", err)
+		}
+		if c.step.Item, err = r.keyring.OpenJSON(secrets.FieldStepItem, c.step.Item); err != nil {
+			c.step.ConfigErr = fmt.Errorf("map item: %w", err)
+		}
+		if c.step.HTTP != nil {
+			c.step.EgressHosts = guard.egressHosts
+			if guard.egressErr != nil {
+				c.step.ConfigErr = guard.egressErr
+			}
+		}
+		c.step.Timeout = resolveStepTimeout(timeoutSeconds, req.DefaultTimeout)
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// validClaimName reports whether a claimed step's name is one workers
+// handle.
+func validClaimName(name domain.StepName) bool {
+	switch name {
+	case domain.StepLLM, domain.StepTool, domain.StepApproval, domain.StepMap:
+		return true
+	}
+	return false
+}
+
+func resolveStepTimeout(timeoutSeconds sql.NullInt64, defaultTimeout time.Duration) time.Duration {
+	if timeoutSeconds.Valid && timeoutSeconds.Int64 > 0 {
+		return time.Duration(timeoutSeconds.Int64) * time.Second
+	}
+
+	if defaultTimeout <= 0 {
+		return 30 * time.Second
+	}
+
+	return defaultTimeout
+}
+
+// undecryptable reports whether err is the keyring failing to open a value,
+// which retrying with the same keys cannot fix.
+func undecryptable(err error) bool {
+	return errors.Is(err, secrets.ErrUnknownKey) || errors.Is(err, secrets.ErrUndecryptable)
+}
+
+// markClaimed marks s RUNNING under the requesting worker's lease in tx,
+// moves its run to RUNNING if it was PENDING, and records the claim. It
+// reports whether the run started.
+func (r *StepQueueRepository) markClaimed(ctx context.Context, tx pgx.Tx, req domain.ClaimRequest, s domain.ClaimedStep, now time.Time) (bool, error) {
+	// Build input JSON for this step
+	input := map[string]any{
+		"step":      s.Name,
+		"claimedAt": now,
+		"reclaimed": s.Status == domain.StepRunning,
+	}
+	if s.Item != nil {
+		input["item"] = s.Item
+	}
+	if s.InputOverride != nil {
+		var override map[string]json.RawMessage
+		if err := json.Unmarshal(s.InputOverride, &override); err == nil {
+			for k, v := range override {
+				input[k] = v
+			}
+		}
+	}
+	inputPayload, _ := json.Marshal(input)
+	inputPayload, err := r.keyring.SealJSON(secrets.FieldStepInput, inputPayload)
+	if err != nil {
+		return false, err
+	}
+
+	if err := transition.Step(r.logger, s.StepID, s.Status, domain.StepRunning); err != nil {
+		return false, err
+	}
+
+	// Mark RUNNING, take the lease under a new claim token and increment
+	// attempts (every claim counts as an attempt)
+	_, err = tx.Exec(ctx, `
+		UPDATE steps
+		SET status=$2,
+		    started_at=COALESCE(started_at, $4),
+		    input=$3::jsonb,
+		    next_run_at=NULL,
+		    claimed_by=$5,
+		    lease_expires_at=$6,
+		    attempts = attempts + 1,
+		    execution_attempt=$7,
+		    claim_token=$8
+		WHERE id=$1
+	`,
+		s.StepID,
+		domain.StepRunning,
+		inputPayload,
+		now,
+		req.WorkerID,
+		now.Add(req.Lease),
+		s.ExecutionAttempt,
+		s.ClaimToken,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	// Mark run RUNNING if it was PENDING
+	runStatusUpdated, _ := tx.Exec(ctx, `
+		UPDATE runs
+		SET status=$2, updated_at=$4
+		WHERE id=$1 AND status=$3
+	`,
+		s.RunID,
+		domain.RunRunning,
+		domain.RunPending,
+		now,
+	)
+
+	if err := r.insertStepEvent(ctx, tx, s.RunID, s.StepID, domain.EventStepClaimed, map[string]any{
+		"status":        domain.StepRunning,
+		"step":          s.Name,
+		"reclaimed":     s.Status == domain.StepRunning,
+		"previous":      s.Status,
+		"api_key_id":    req.APIKeyID,
+		"worker_id":     req.WorkerID,
+		"claimed_at":    now,
+		"execution_key": domain.ExecutionKey(s.StepID, s.ExecutionAttempt),
+	}); err != nil {
+		return false, err
+	}
+	if err := countClaim(ctx, tx, req.APIKeyID, now); err != nil {
+		return false, err
+	}
+
+	return runStatusUpdated.RowsAffected() > 0, nil
+}
+
+// claimCommitted records the metrics and log of a committed claim.
+func (r *StepQueueRepository) claimCommitted(req domain.ClaimRequest, s domain.ClaimedStep, runStarted bool) {
+	metrics.IncStepClaim(req.APIKeyID)
+	if runStarted {
+		metrics.IncRunStatus(req.APIKeyID, string(domain.RunRunning))
+	}
+
+	r.logger.Info("step marked running",
+		"api_key_id", req.APIKeyID,
+		"worker_id", req.WorkerID,
+		"run_id", s.RunID,
+		"step_id", s.StepID,
+		"step", s.Name,
+		"reclaimed", s.Status == domain.StepRunning,
+	)
+}
+
+// countClaim bumps the tenant's claim counter in the claim transaction, so
+// GET /admin/scheduling can compare claim shares with scheduling weights.
+func countClaim(ctx context.Context, tx pgx.Tx, apiKeyID uuid.UUID, now time.Time) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO tenant_claim_counters (api_key_id, claims, last_claimed_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (api_key_id) DO UPDATE
+		SET claims = tenant_claim_counters.claims + 1,
+		    last_claimed_at = EXCLUDED.last_claimed_at
+	`, apiKeyID, now)
+	return err
+}
+
+// RenewLease extends the lease on step to lease from now. It reports false
+// once the step is no longer RUNNING under this claim, e.g. because another
+// worker reclaimed it.
+func (r *StepQueueRepository) RenewLease(ctx context.Context, step domain.ClaimedStep, lease time.Duration) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE steps
+		SET lease_expires_at=$3
+		WHERE id=$1
+		  AND claim_token=$2
+		  AND status=$4
+	`,
+		step.StepID,
+		step.ClaimToken,
+		nowUTC(r.clock).Add(lease),
+		domain.StepRunning,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// StepCanceled reports whether step's run was canceled or step itself is
+// CANCELED.
+func (r *StepQueueRepository) StepCanceled(ctx context.Context, step domain.ClaimedStep) (bool, error) {
+	var (
+		runStatus  domain.RunStatus
+		stepStatus domain.StepStatus
+	)
+	if err := r.pool.QueryRow(ctx, `
+		SELECT r.status, st.status
+		FROM steps st
+		JOIN runs r ON r.id = st.run_id
+		WHERE st.id = $1
+	`, step.StepID).Scan(&runStatus, &stepStatus); err != nil {
+		return false, err
+	}
+	return runStatus == domain.RunCanceled || stepStatus == domain.StepCanceled, nil
+}
+
+// AppendStepEvent records an event of step, such as STEP_PROGRESS, outside
+// its claim and settlement.
+func (r *StepQueueRepository) AppendStepEvent(ctx context.Context, step domain.ClaimedStep, eventType string, payload any) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := r.insertStepEvent(ctx, tx, step.RunID, step.StepID, eventType, payload); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// insertStepEvent appends an event and enqueues its outbox message and, when
+// the run subscribed its webhook to this event type, the delivery in the same
+// transaction.
+func (r *StepQueueRepository) insertStepEvent(
+	ctx context.Context,
+	tx pgx.Tx,
+	runID uuid.UUID,
+	stepID uuid.UUID,
+	eventType string,
+	payload any,
+) error {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	payloadJSON = r.redactor.JSON(payloadJSON)
+
+	// created_at is left to the database: events are partitioned on it, and
+	// exports read a run's events from its created_at on, which the API
+	// stamps with the database clock.
+	eventID := ids.New()
+	_, err = tx.Exec(ctx, `
+		INSERT INTO events (id, run_id, step_id, type, payload)
+		VALUES ($1, $2, $3, $4, $5::jsonb)
+	`,
+		eventID,
+		runID,
+		stepID,
+		eventType,
+		payloadJSON,
+	)
+	if err != nil {
+		return err
+	}
+
+	return outbox.EnqueueEvent(ctx, tx, eventID, r.webhookMaxAttempts)
+}
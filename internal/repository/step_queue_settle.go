@@ -0,0 +1,880 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/egress"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/adiadia/agent-runtime/internal/outbox"
+	"github.com/adiadia/agent-runtime/internal/runsummary"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	"github.com/adiadia/agent-runtime/internal/transition"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// CompleteStep marks step SUCCEEDED with its output and cost, records its
+// usage, and moves its run along: a MAP child may complete its MAP step, a
+// TOOL step opens the approval gate after it, and the run succeeds once
+// every step has settled. It returns domain.ErrStaleClaim when the step was
+// reclaimed since step's claim.
+func (r *StepQueueRepository) CompleteStep(ctx context.Context, step domain.ClaimedStep, result domain.StepCompletion) error {
+	output, err := r.keyring.SealJSON(secrets.FieldStepOutput, result.Output)
+	if err != nil {
+		return err
+	}
+
+	cost := result.Cost
+	costDetail, err := json.Marshal(cost)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	current, err := transition.LockStep(ctx, tx, step.StepID)
+	if err != nil {
+		return err
+	}
+	if err := transition.Step(r.logger, step.StepID, current, domain.StepSuccess); err != nil {
+		return err
+	}
+
+	now := nowUTC(r.clock)
+	tag, err := tx.Exec(ctx, `
+		UPDATE steps
+		SET status=$2,
+		    output=$3::jsonb,
+		    cost_usd=$4,
+		    cost_detail=$5::jsonb,
+		    next_run_at=NULL,
+		    lease_expires_at=NULL,
+		    finished_at=$7
+		WHERE id=$1
+		  AND claim_token=$6
+	`,
+		step.StepID,
+		domain.StepSuccess,
+		output,
+		cost.CostUSD,
+		costDetail,
+		step.ClaimToken,
+		now,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrStaleClaim
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE runs
+		SET total_cost_usd = total_cost_usd + $2
+		WHERE id=$1
+	`,
+		step.RunID,
+		cost.CostUSD,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := insertUsageRecord(ctx, tx, step, cost, result.Latency, now); err != nil {
+		return err
+	}
+
+	payload := map[string]any{
+		"status": domain.StepSuccess,
+		"step":   step.Name,
+		"cost":   cost.CostUSD,
+	}
+	if result.OutputTruncated {
+		payload["output_truncated"] = true
+	}
+	if err := r.insertStepEvent(ctx, tx, step.RunID, step.StepID, domain.EventStepSucceeded, payload); err != nil {
+		return err
+	}
+
+	// If TOOL finished -> move APPROVAL to WAITING_APPROVAL; a MAP child
+	// finishing may complete its MAP step instead.
+	var mapDone, runWaiting bool
+	switch {
+	case step.ParentStepID != nil:
+		if mapDone, runWaiting, err = r.settleMapStep(ctx, tx, step.APIKeyID, step.RunID, *step.ParentStepID); err != nil {
+			return err
+		}
+	case step.Name == domain.StepTool:
+		if runWaiting, err = r.promoteApproval(ctx, tx, step.APIKeyID, step.RunID); err != nil {
+			return err
+		}
+	}
+
+	runTerminal, err := r.completeRunIfDone(ctx, tx, step.RunID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	metrics.IncStepStatus(step.APIKeyID, string(domain.StepSuccess))
+	if mapDone {
+		metrics.IncStepStatus(step.APIKeyID, string(domain.StepSuccess))
+	}
+	if runWaiting {
+		metrics.IncRunStatus(step.APIKeyID, string(domain.RunWaiting))
+	}
+	if runTerminal {
+		metrics.IncRunStatus(step.APIKeyID, string(domain.RunSuccess))
+	}
+
+	r.logger.Info("step marked succeeded",
+		"api_key_id", step.APIKeyID,
+		"run_id", step.RunID,
+		"step_id", step.StepID,
+		"step", step.Name,
+		"cost_usd", cost.CostUSD,
+	)
+
+	return nil
+}
+
+// promoteApproval moves the run's first pending APPROVAL step to
+// WAITING_APPROVAL once every step before it has settled; callers invoke it
+// when a TOOL or MAP step settles. An APPROVAL step whose condition is
+// false is skipped instead; one whose condition cannot be parsed still waits,
+// so a broken condition never bypasses the approval. The run waits with its
+// gate, in WAITING_APPROVAL, until the gate is decided; promoteApproval
+// reports whether it moved the run there.
+func (r *StepQueueRepository) promoteApproval(ctx context.Context, tx pgx.Tx, apiKeyID, runID uuid.UUID) (bool, error) {
+	var (
+		approvalStepID uuid.UUID
+		approvalStatus domain.StepStatus
+		condition      string
+	)
+	err := tx.QueryRow(ctx, `
+		SELECT st.id, st.status, COALESCE(st.condition, '')
+		FROM steps st
+		WHERE st.run_id=$1
+		  AND st.name=$2
+		  AND st.status=$3
+		  AND NOT EXISTS (
+			SELECT 1 FROM steps s2
+			WHERE s2.run_id = st.run_id
+			  AND s2.position < st.position
+			  AND s2.status NOT IN ($4, $5)
+			  AND NOT (s2.status = $6 AND s2.on_failure = $7)
+		  )
+		ORDER BY st.position ASC
+		LIMIT 1
+		FOR UPDATE
+	`,
+		runID,
+		domain.StepApproval,
+		domain.StepPending,
+		domain.StepSuccess,
+		domain.StepSkipped,
+		domain.StepFailed,
+		domain.OnFailureContinue,
+	).Scan(&approvalStepID, &approvalStatus, &condition)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if condition != "" {
+		matched, err := r.evaluateStepCondition(ctx, tx, runID, approvalStepID, condition)
+		if err != nil && !errors.Is(err, domain.ErrInvalidStepCondition) {
+			return false, err
+		}
+		if err == nil && !matched {
+			return false, r.markConditionSkipped(ctx, tx, apiKeyID, runID, approvalStepID, domain.StepApproval, approvalStatus, condition)
+		}
+	}
+
+	if err := transition.Step(r.logger, approvalStepID, approvalStatus, domain.StepWaiting); err != nil {
+		return false, err
+	}
+	waitingSince := nowUTC(r.clock)
+	if _, err := tx.Exec(ctx, `
+		UPDATE steps
+		SET status=$2,
+		    waiting_since=$3
+		WHERE id=$1
+	`,
+		approvalStepID,
+		domain.StepWaiting,
+		waitingSince,
+	); err != nil {
+		return false, err
+	}
+
+	if err := r.insertStepEvent(ctx, tx, runID, approvalStepID, domain.EventStepWaitingApproval, map[string]any{
+		"status": domain.StepWaiting,
+		"step":   domain.StepApproval,
+	}); err != nil {
+		return false, err
+	}
+
+	runStatus, err := transition.LockRun(ctx, tx, runID)
+	if err != nil {
+		return false, err
+	}
+	runWaiting := runStatus != domain.RunWaiting
+	if runWaiting {
+		if err := transition.Run(r.logger, runID, runStatus, domain.RunWaiting); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE runs
+			SET status=$2, updated_at=$3
+			WHERE id=$1
+		`,
+			runID,
+			domain.RunWaiting,
+			waitingSince,
+		); err != nil {
+			return false, err
+		}
+	}
+
+	// The WAITING_APPROVAL callback links to the gate through the configured
+	// approval link.
+	approvalURL := domain.ApprovalLink(r.approvalLink, runID, &approvalStepID)
+	if err := outbox.EnqueueApprovalWebhook(ctx, tx, runID, approvalStepID, waitingSince, approvalURL, r.webhookMaxAttempts); err != nil {
+		return false, err
+	}
+	return runWaiting, nil
+}
+
+// insertUsageRecord records the tokens and latency of an execution that
+// reported a provider and model or a tool, for GET /usage/tokens, whether the
+// attempt succeeded or failed. Executions that report no usage, such as
+// sandboxed commands, record nothing.
+func insertUsageRecord(ctx context.Context, tx pgx.Tx, step domain.ClaimedStep, cost domain.CostDetail, latency time.Duration, at time.Time) error {
+	if cost.Provider == "" && cost.Model == "" && cost.Tool == "" && cost.PromptTokens == 0 && cost.CompletionTokens == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO usage_records (
+			api_key_id, run_id, step_id, step_name, attempt,
+			provider, model, tool, prompt_tokens, completion_tokens, latency_ms, created_at
+		)
+		SELECT api_key_id, id, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+		FROM runs
+		WHERE id=$1
+	`,
+		step.RunID,
+		step.StepID,
+		step.Name,
+		step.Attempt,
+		cost.Provider,
+		cost.Model,
+		cost.Tool,
+		cost.PromptTokens,
+		cost.CompletionTokens,
+		latency.Milliseconds(),
+		at,
+	)
+	return err
+}
+
+// openApprovalGate promotes a claimed pending APPROVAL step, or skips it by
+// its condition, and commits tx. It returns errApprovalOpened once committed.
+func (r *StepQueueRepository) openApprovalGate(ctx context.Context, tx pgx.Tx, s domain.ClaimedStep) error {
+	// A template may start with an approval; the run starts before it waits
+	// on the gate, and only completes from RUNNING.
+	runStatusUpdated, err := tx.Exec(ctx, `
+		UPDATE runs
+		SET status=$2, updated_at=$4
+		WHERE id=$1 AND status=$3
+	`,
+		s.RunID,
+		domain.RunRunning,
+		domain.RunPending,
+		nowUTC(r.clock),
+	)
+	if err != nil {
+		return err
+	}
+
+	runWaiting, err := r.promoteApproval(ctx, tx, s.APIKeyID, s.RunID)
+	if err != nil {
+		return err
+	}
+
+	runTerminal, err := r.completeRunIfDone(ctx, tx, s.RunID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	if runStatusUpdated.RowsAffected() > 0 {
+		metrics.IncRunStatus(s.APIKeyID, string(domain.RunRunning))
+	}
+	if runWaiting {
+		metrics.IncRunStatus(s.APIKeyID, string(domain.RunWaiting))
+	}
+	if runTerminal {
+		metrics.IncRunStatus(s.APIKeyID, string(domain.RunSuccess))
+	}
+	return errApprovalOpened
+}
+
+// completeRunIfDone marks the run SUCCEEDED when every step has settled:
+// SUCCEEDED, SKIPPED, or FAILED under the continue policy. It reports whether
+// the run was completed by this call.
+func (r *StepQueueRepository) completeRunIfDone(ctx context.Context, tx pgx.Tx, runID uuid.UUID) (bool, error) {
+	var (
+		webhookURL    sql.NullString
+		runFinishedAt time.Time
+	)
+
+	current, err := transition.LockRun(ctx, tx, runID)
+	if err != nil {
+		return false, err
+	}
+
+	var settled bool
+	if err := tx.QueryRow(ctx, `
+		SELECT NOT EXISTS (
+			SELECT 1 FROM steps s
+			WHERE s.run_id=$1
+			  AND s.status NOT IN ($2, $3)
+			  AND NOT (s.status = $4 AND s.on_failure = $5)
+		)
+	`,
+		runID,
+		domain.StepSuccess,
+		domain.StepSkipped,
+		domain.StepFailed,
+		domain.OnFailureContinue,
+	).Scan(&settled); err != nil {
+		return false, err
+	}
+	if !settled {
+		return false, nil
+	}
+	if err := transition.Run(r.logger, runID, current, domain.RunSuccess); err != nil {
+		return false, err
+	}
+
+	if err := tx.QueryRow(ctx, `
+		UPDATE runs
+		SET status=$2, updated_at=$3
+		WHERE id=$1
+		RETURNING webhook_url, updated_at
+	`,
+		runID,
+		domain.RunSuccess,
+		nowUTC(r.clock),
+	).Scan(&webhookURL, &runFinishedAt); err != nil {
+		return false, err
+	}
+
+	if err := runsummary.Emit(ctx, tx, runID, r.webhookMaxAttempts); err != nil {
+		return false, err
+	}
+	// The terminal callback goes to the outbox inside the transaction that
+	// finishes the run, so a crash between commit and delivery cannot lose it.
+	if err := outbox.EnqueueTerminalWebhook(ctx, tx, runID, domain.RunSuccess, runFinishedAt.UTC(), webhookURL.String, r.webhookMaxAttempts); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// stepFailedPayload adds "permanent": true to a terminal failure event when
+// the failure was permanent, so consumers can tell a fail-fast from exhausted
+// retries.
+func stepFailedPayload(payload map[string]any, failure domain.StepFailure) map[string]any {
+	if failure.Permanent {
+		payload["permanent"] = true
+	}
+	return payload
+}
+
+// FailStep retries step up to its max attempts.
+// - if attempts < max attempts and the failure is not permanent: set step back to PENDING
+// - else, with on_failure=fail_run: set step FAILED and mark run FAILED
+// - else: set step SKIPPED (skip) or FAILED (continue) and let the run go on
+//
+// Whatever the outcome, the tokens the attempt spent are recorded. It returns
+// domain.ErrStaleClaim when the step was reclaimed since step's claim.
+func (r *StepQueueRepository) FailStep(ctx context.Context, step domain.ClaimedStep, failure domain.StepFailure) error {
+	stepID, execErr := step.StepID, failure.Err
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Read status + attempts + run_id + failure policy
+	var (
+		current      domain.StepStatus
+		attempts     int
+		runID        uuid.UUID
+		stepName     domain.StepName
+		onFailure    domain.OnFailurePolicy
+		parentStepID *uuid.UUID
+		override     retryOverride
+		boost        int
+		runPriority  int
+		owned        bool
+	)
+
+	// The row stays locked until commit, so a claim token that matches here
+	// still matches when the step is updated below.
+	if err := tx.QueryRow(ctx, `
+		SELECT st.status, st.attempts, st.run_id, st.name, st.on_failure, st.parent_step_id,
+		       st.max_attempts, st.retry_base_delay_ms, st.retry_backoff, st.retry_jitter, st.retry_priority,
+		       st.priority_boost, r.priority, st.claim_token IS NOT DISTINCT FROM $2
+		FROM steps st
+		JOIN runs r ON r.id = st.run_id
+		WHERE st.id=$1
+		FOR UPDATE OF st
+	`, stepID, step.ClaimToken).Scan(&current, &attempts, &runID, &stepName, &onFailure, &parentStepID,
+		&override.MaxAttempts, &override.BaseDelayMS, &override.Backoff, &override.Jitter, &override.Priority,
+		&boost, &runPriority, &owned); err != nil {
+		return err
+	}
+	if !owned {
+		return domain.ErrStaleClaim
+	}
+	now := nowUTC(r.clock)
+	if err := insertUsageRecord(ctx, tx, step, failure.Cost, failure.Latency, now); err != nil {
+		return err
+	}
+	policy := retryPolicy(failure.Retry, override)
+
+	// A destination outside the egress policy or the tenant's allow-list is
+	// a security event of its own; the step then fails permanently below.
+	if errors.Is(execErr, egress.ErrBlocked) {
+		r.logger.Warn("step egress blocked",
+			"api_key_id", step.APIKeyID,
+			"step_id", stepID,
+			"run_id", runID,
+			"error", execErr,
+		)
+		if err := r.insertStepEvent(ctx, tx, runID, stepID, domain.EventStepEgressBlocked, map[string]any{
+			"error":   execErr.Error(),
+			"attempt": attempts,
+		}); err != nil {
+			return err
+		}
+	}
+
+	payload, _ := json.Marshal(map[string]string{
+		"error": execErr.Error(),
+	})
+	payload, err = r.keyring.SealJSON(secrets.FieldStepOutput, payload)
+	if err != nil {
+		return err
+	}
+
+	// Retry if attempts < the step's max attempts, unless the executor said
+	// retrying cannot help.
+	if attempts < policy.MaxAttempts && !failure.Permanent {
+		if err := transition.Step(r.logger, stepID, current, domain.StepPending); err != nil {
+			return err
+		}
+
+		nextRunAt := now.Add(r.retryDelay(policy, attempts))
+		boost = policy.NextPriorityBoost(boost)
+
+		r.logger.Warn("step failed - retrying",
+			"step_id", stepID,
+			"run_id", runID,
+			"attempt", attempts,
+			"max_attempts", policy.MaxAttempts,
+			"backoff", policy.Backoff,
+			"next_run_at", nextRunAt,
+			"priority", runPriority+boost,
+		)
+
+		_, err = tx.Exec(ctx, `
+			UPDATE steps
+			SET status=$2,
+			    output=$3::jsonb,
+			    next_run_at=$4,
+			    priority_boost=$5,
+			    lease_expires_at=NULL,
+			    finished_at=$6
+			WHERE id=$1
+		`,
+			stepID,
+			domain.StepPending,
+			payload,
+			nextRunAt,
+			boost,
+			now,
+		)
+		if err != nil {
+			return err
+		}
+
+		if err := r.insertStepEvent(ctx, tx, runID, stepID, domain.EventStepFailedRetry, map[string]any{
+			"status":         domain.StepPending,
+			"error":          execErr.Error(),
+			"attempt":        attempts,
+			"max_attempts":   policy.MaxAttempts,
+			"next_run_at":    nextRunAt,
+			"priority_boost": boost,
+			"priority":       runPriority + boost,
+		}); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+
+		metrics.IncStepRetries()
+		r.logger.Info("retry scheduled",
+			"api_key_id", step.APIKeyID,
+			"step_id", stepID,
+			"run_id", runID,
+			"attempt", attempts,
+			"next_run_at", nextRunAt,
+		)
+		return nil
+	}
+
+	if onFailure == domain.OnFailureSkip || onFailure == domain.OnFailureContinue {
+		return r.settleFailedStep(ctx, tx, step.APIKeyID, stepID, current, runID, stepName, parentStepID, onFailure, attempts, policy.MaxAttempts, payload, failure)
+	}
+
+	if err := transition.Step(r.logger, stepID, current, domain.StepFailed); err != nil {
+		return err
+	}
+
+	// Permanently fail
+	r.logger.Error("step permanently failed",
+		"step_id", stepID,
+		"run_id", runID,
+		"attempts", attempts,
+		"max_attempts", policy.MaxAttempts,
+		"permanent_error", failure.Permanent,
+	)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE steps
+		SET status=$2,
+		    output=$3::jsonb,
+		    next_run_at=NULL,
+		    lease_expires_at=NULL,
+		    finished_at=$4
+		WHERE id=$1
+	`,
+		stepID,
+		domain.StepFailed,
+		payload,
+		now,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := r.insertStepEvent(ctx, tx, runID, stepID, domain.EventStepFailed, stepFailedPayload(map[string]any{
+		"status":       domain.StepFailed,
+		"error":        execErr.Error(),
+		"attempt":      attempts,
+		"max_attempts": policy.MaxAttempts,
+	}, failure)); err != nil {
+		return err
+	}
+
+	var mapFailed bool
+	if parentStepID != nil {
+		if mapFailed, err = r.failMapStep(ctx, tx, runID, *parentStepID, stepID, execErr); err != nil {
+			return err
+		}
+	}
+
+	var (
+		runTerminal   bool
+		webhookURL    sql.NullString
+		runFinishedAt time.Time
+	)
+
+	runStatus, err := transition.LockRun(ctx, tx, runID)
+	if err != nil {
+		return err
+	}
+	if runStatus != domain.RunFailed {
+		if err := transition.Run(r.logger, runID, runStatus, domain.RunFailed); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(ctx, `
+			UPDATE runs
+			SET status=$2, updated_at=$3
+			WHERE id=$1
+			RETURNING webhook_url, updated_at
+		`,
+			runID,
+			domain.RunFailed,
+			now,
+		).Scan(&webhookURL, &runFinishedAt); err != nil {
+			return err
+		}
+
+		runTerminal = true
+		if err := runsummary.Emit(ctx, tx, runID, r.webhookMaxAttempts); err != nil {
+			return err
+		}
+		if err := outbox.EnqueueTerminalWebhook(ctx, tx, runID, domain.RunFailed, runFinishedAt.UTC(), webhookURL.String, r.webhookMaxAttempts); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	metrics.IncStepStatus(step.APIKeyID, string(domain.StepFailed))
+	if mapFailed {
+		metrics.IncStepStatus(step.APIKeyID, string(domain.StepFailed))
+	}
+	if runTerminal {
+		metrics.IncRunStatus(step.APIKeyID, string(domain.RunFailed))
+	}
+
+	r.logger.Error("step marked failed",
+		"api_key_id", step.APIKeyID,
+		"step_id", stepID,
+		"run_id", runID,
+		"attempts", attempts,
+	)
+
+	return nil
+}
+
+// settleFailedStep finishes a step that exhausted its attempts under the skip
+// or continue policy without failing the run: the step is recorded as SKIPPED
+// or FAILED, and the run moves on as if it had succeeded.
+func (r *StepQueueRepository) settleFailedStep(
+	ctx context.Context,
+	tx pgx.Tx,
+	apiKeyID uuid.UUID,
+	stepID uuid.UUID,
+	current domain.StepStatus,
+	runID uuid.UUID,
+	stepName domain.StepName,
+	parentStepID *uuid.UUID,
+	onFailure domain.OnFailurePolicy,
+	attempts int,
+	maxAttempts int,
+	payload []byte,
+	failure domain.StepFailure,
+) error {
+	status, eventType := domain.StepFailed, domain.EventStepFailed
+	if onFailure == domain.OnFailureSkip {
+		status, eventType = domain.StepSkipped, domain.EventStepSkipped
+	}
+	if err := transition.Step(r.logger, stepID, current, status); err != nil {
+		return err
+	}
+
+	r.logger.Warn("step failed - continuing run",
+		"step_id", stepID,
+		"run_id", runID,
+		"attempts", attempts,
+		"on_failure", onFailure,
+		"status", status,
+	)
+
+	_, err := tx.Exec(ctx, `
+		UPDATE steps
+		SET status=$2,
+		    output=$3::jsonb,
+		    next_run_at=NULL,
+		    lease_expires_at=NULL,
+		    finished_at=$4
+		WHERE id=$1
+	`,
+		stepID,
+		status,
+		payload,
+		nowUTC(r.clock),
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := r.insertStepEvent(ctx, tx, runID, stepID, eventType, stepFailedPayload(map[string]any{
+		"status":       status,
+		"error":        failure.Err.Error(),
+		"attempt":      attempts,
+		"max_attempts": maxAttempts,
+		"on_failure":   onFailure,
+	}, failure)); err != nil {
+		return err
+	}
+
+	var mapDone, runWaiting bool
+	switch {
+	case parentStepID != nil:
+		if mapDone, runWaiting, err = r.settleMapStep(ctx, tx, apiKeyID, runID, *parentStepID); err != nil {
+			return err
+		}
+	case stepName == domain.StepTool:
+		if runWaiting, err = r.promoteApproval(ctx, tx, apiKeyID, runID); err != nil {
+			return err
+		}
+	}
+
+	runTerminal, err := r.completeRunIfDone(ctx, tx, runID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	metrics.IncStepStatus(apiKeyID, string(status))
+	if mapDone {
+		metrics.IncStepStatus(apiKeyID, string(domain.StepSuccess))
+	}
+	if runWaiting {
+		metrics.IncRunStatus(apiKeyID, string(domain.RunWaiting))
+	}
+	if runTerminal {
+		metrics.IncRunStatus(apiKeyID, string(domain.RunSuccess))
+	}
+
+	r.logger.Info("step settled after failure",
+		"api_key_id", apiKeyID,
+		"step_id", stepID,
+		"run_id", runID,
+		"status", status,
+	)
+
+	return nil
+}
+
+// CancelStep settles a step whose executor was interrupted because its run
+// was canceled. CancelRun has usually marked the step CANCELED already;
+// otherwise it is marked here. Either way a STEP_CANCELED event records the
+// interruption.
+func (r *StepQueueRepository) CancelStep(ctx context.Context, step domain.ClaimedStep) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	current, err := transition.LockStep(ctx, tx, step.StepID)
+	if err != nil {
+		return err
+	}
+	if current != domain.StepCanceled {
+		if err := transition.Step(r.logger, step.StepID, current, domain.StepCanceled); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE steps
+		SET status=$2,
+		    lease_expires_at=NULL,
+		    finished_at=COALESCE(finished_at, $3)
+		WHERE id=$1
+	`,
+		step.StepID,
+		domain.StepCanceled,
+		nowUTC(r.clock),
+	); err != nil {
+		return err
+	}
+
+	if err := r.insertStepEvent(ctx, tx, step.RunID, step.StepID, domain.EventStepCanceled, map[string]any{
+		"status":      domain.StepCanceled,
+		"step":        step.Name,
+		"reason":      "run_canceled",
+		"interrupted": true,
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	if current != domain.StepCanceled {
+		metrics.IncStepStatus(step.APIKeyID, string(domain.StepCanceled))
+	}
+	r.logger.Info("step canceled",
+		"api_key_id", step.APIKeyID,
+		"run_id", step.RunID,
+		"step_id", step.StepID,
+		"step", step.Name,
+	)
+	return nil
+}
+
+// retryOverride holds a step's retry policy columns; NULL columns keep the
+// worker-wide setting.
+type retryOverride struct {
+	MaxAttempts *int
+	BaseDelayMS *int
+	Backoff     *string
+	Jitter      *bool
+	Priority    *int
+}
+
+// retryPolicy resolves a step's retry policy from its overrides and the
+// worker-wide defaults.
+func retryPolicy(defaults domain.RetryPolicy, o retryOverride) domain.RetryPolicy {
+	policy := defaults
+	if o.MaxAttempts != nil && *o.MaxAttempts > 0 {
+		policy.MaxAttempts = *o.MaxAttempts
+	}
+	if o.BaseDelayMS != nil && *o.BaseDelayMS > 0 {
+		policy.BaseDelay = time.Duration(*o.BaseDelayMS) * time.Millisecond
+	}
+	if o.Backoff != nil {
+		// The column is constrained to valid values; anything else keeps the
+		// default.
+		if backoff, err := domain.ParseRetryBackoff(*o.Backoff); err == nil {
+			policy.Backoff = backoff
+		}
+	}
+	if o.Jitter != nil {
+		policy.Jitter = *o.Jitter
+	}
+	if o.Priority != nil {
+		policy.Priority = *o.Priority
+	}
+	return policy
+}
+
+// retryDelay returns the delay before the next attempt after attempts failed
+// ones, randomized between half and all of it when the policy asks for
+// jitter.
+func (r *StepQueueRepository) retryDelay(policy domain.RetryPolicy, attempts int) time.Duration {
+	delay := policy.Delay(attempts)
+	if !policy.Jitter || delay < 2 {
+		return delay
+	}
+	half := delay / 2
+	return half + time.Duration(r.jitter(int64(delay-half)+1))
+}
@@ -4,16 +4,20 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"time"
 
 	"github.com/adiadia/agent-runtime/internal/domain"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type StepRepository struct {
-	pool   *pgxpool.Pool
-	logger *slog.Logger
+	pool    *pgxpool.Pool
+	logger  *slog.Logger
+	replica *ReadReplica
 }
 
 func NewStepRepository(pool *pgxpool.Pool, logger *slog.Logger) *StepRepository {
@@ -27,6 +31,12 @@ func NewStepRepository(pool *pgxpool.Pool, logger *slog.Logger) *StepRepository
 	}
 }
 
+// WithReadReplica serves ListSteps and GetRunTimeline from replica.
+func (s *StepRepository) WithReadReplica(replica *ReadReplica) *StepRepository {
+	s.replica = replica
+	return s
+}
+
 func (s *StepRepository) ListSteps(ctx context.Context, runID uuid.UUID) ([]domain.StepRecord, error) {
 	apiKeyID, err := apiKeyIDFromContext(ctx)
 	if err != nil {
@@ -34,8 +44,26 @@ func (s *StepRepository) ListSteps(ctx context.Context, runID uuid.UUID) ([]doma
 		return nil, err
 	}
 
+	var out []domain.StepRecord
+	err = s.replica.read(ctx, s.pool, func(q querier) error {
+		out, err = s.listSteps(ctx, q, runID, apiKeyID)
+		return err
+	})
+	if err != nil {
+		return nil, notFound(err, domain.ErrRunNotFound)
+	}
+
+	s.logger.Info("steps fetched",
+		"run_id", runID,
+		"count", len(out),
+	)
+
+	return out, nil
+}
+
+func (s *StepRepository) listSteps(ctx context.Context, q querier, runID, apiKeyID uuid.UUID) ([]domain.StepRecord, error) {
 	var exists int
-	if err := s.pool.QueryRow(ctx,
+	if err := q.QueryRow(ctx,
 		`SELECT 1 FROM runs WHERE id=$1 AND api_key_id=$2`,
 		runID,
 		apiKeyID,
@@ -48,11 +76,11 @@ func (s *StepRepository) ListSteps(ctx context.Context, runID uuid.UUID) ([]doma
 		return nil, err
 	}
 
-	rows, err := s.pool.Query(ctx, `
-		SELECT id, name, status
+	rows, err := q.Query(ctx, `
+		SELECT id, name, status, parent_step_id
 		FROM steps
 		WHERE run_id=$1
-		ORDER BY created_at ASC
+		ORDER BY position ASC, map_index ASC NULLS FIRST, created_at ASC
 	`, runID)
 	if err != nil {
 		s.logger.Error("list steps query failed",
@@ -67,7 +95,7 @@ func (s *StepRepository) ListSteps(ctx context.Context, runID uuid.UUID) ([]doma
 
 	for rows.Next() {
 		var st domain.StepRecord
-		if err := rows.Scan(&st.ID, &st.Name, &st.Status); err != nil {
+		if err := rows.Scan(&st.ID, &st.Name, &st.Status, &st.ParentStepID); err != nil {
 			s.logger.Error("scan step row failed",
 				"run_id", runID,
 				"error", err,
@@ -85,10 +113,187 @@ func (s *StepRepository) ListSteps(ctx context.Context, runID uuid.UUID) ([]doma
 		return nil, err
 	}
 
-	s.logger.Info("steps fetched",
-		"run_id", runID,
-		"count", len(out),
-	)
-
 	return out, nil
 }
+
+// GetRunTimeline returns the run's steps with their execution and approval
+// segments, built from the step events still retained. It returns
+// domain.ErrRunNotFound when the run is not one of the tenant's.
+func (s *StepRepository) GetRunTimeline(ctx context.Context, runID uuid.UUID) (domain.RunTimeline, error) {
+	apiKeyID, err := apiKeyIDFromContext(ctx)
+	if err != nil {
+		s.logger.Warn("run timeline denied: missing api key id", "run_id", runID, "error", err)
+		return domain.RunTimeline{}, err
+	}
+
+	var out domain.RunTimeline
+	err = s.replica.read(ctx, s.pool, func(q querier) error {
+		out, err = s.getRunTimeline(ctx, q, runID, apiKeyID)
+		return err
+	})
+	return out, notFound(err, domain.ErrRunNotFound)
+}
+
+func (s *StepRepository) getRunTimeline(ctx context.Context, q querier, runID, apiKeyID uuid.UUID) (domain.RunTimeline, error) {
+	timeline := domain.RunTimeline{RunID: runID}
+	var runCreatedAt time.Time
+	if err := q.QueryRow(ctx,
+		`SELECT status, created_at FROM runs WHERE id=$1 AND api_key_id=$2`,
+		runID,
+		apiKeyID,
+	).Scan(&timeline.Status, &runCreatedAt); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			s.logger.Error("run ownership check failed",
+				"run_id", runID,
+				"api_key_id", apiKeyID,
+				"error", err,
+			)
+		}
+		return domain.RunTimeline{}, err
+	}
+
+	rows, err := q.Query(ctx, `
+		SELECT id, name, status, parent_step_id, attempts, started_at, finished_at
+		FROM steps
+		WHERE run_id=$1
+		ORDER BY position ASC, map_index ASC NULLS FIRST, created_at ASC
+	`, runID)
+	if err != nil {
+		s.logger.Error("timeline steps query failed", "run_id", runID, "error", err)
+		return domain.RunTimeline{}, err
+	}
+	timeline.Steps = make([]domain.StepTimeline, 0, 4)
+	for rows.Next() {
+		var st domain.StepTimeline
+		if err := rows.Scan(&st.ID, &st.Name, &st.Status, &st.ParentStepID, &st.Attempts, &st.StartedAt, &st.FinishedAt); err != nil {
+			rows.Close()
+			s.logger.Error("scan timeline step row failed", "run_id", runID, "error", err)
+			return domain.RunTimeline{}, err
+		}
+		st.StartedAt = utcPtr(st.StartedAt)
+		st.FinishedAt = utcPtr(st.FinishedAt)
+		timeline.Steps = append(timeline.Steps, st)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		s.logger.Error("timeline steps iteration failed", "run_id", runID, "error", err)
+		return domain.RunTimeline{}, err
+	}
+
+	// created_at bounds the scan to the partitions that can hold the run's
+	// events.
+	rows, err = q.Query(ctx, `
+		SELECT step_id, type, payload, created_at
+		FROM events
+		WHERE run_id=$1
+		  AND step_id IS NOT NULL
+		  AND created_at >= $2
+		ORDER BY seq ASC
+	`, runID, runCreatedAt)
+	if err != nil {
+		s.logger.Error("timeline events query failed", "run_id", runID, "error", err)
+		return domain.RunTimeline{}, err
+	}
+	defer rows.Close()
+
+	events := make(map[uuid.UUID][]domain.EventRecord, len(timeline.Steps))
+	for rows.Next() {
+		var stepID uuid.UUID
+		ev := domain.EventRecord{RunID: runID}
+		if err := rows.Scan(&stepID, &ev.Type, &ev.Payload, &ev.CreatedAt); err != nil {
+			s.logger.Error("scan timeline event row failed", "run_id", runID, "error", err)
+			return domain.RunTimeline{}, err
+		}
+		events[stepID] = append(events[stepID], ev)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("timeline events iteration failed", "run_id", runID, "error", err)
+		return domain.RunTimeline{}, err
+	}
+
+	for i := range timeline.Steps {
+		st := &timeline.Steps[i]
+		st.Segments = domain.BuildSegments(events[st.ID])
+		if st.StartedAt == nil && len(st.Segments) > 0 {
+			started := st.Segments[0].StartedAt
+			st.StartedAt = &started
+		}
+	}
+
+	return timeline, nil
+}
+
+// ListStepLogs returns up to limit log lines of a step of runID with seq above
+// afterSeq. It returns domain.ErrStepNotFound when the step is not part of
+// one of the tenant's runs.
+func (s *StepRepository) ListStepLogs(ctx context.Context, runID, stepID uuid.UUID, afterSeq int64, limit int) (domain.StepLogPage, error) {
+	apiKeyID, err := apiKeyIDFromContext(ctx)
+	if err != nil {
+		s.logger.Warn("list step logs denied: missing api key id", "run_id", runID, "step_id", stepID, "error", err)
+		return domain.StepLogPage{}, err
+	}
+	if limit <= 0 || limit > domain.MaxStepLogPageSize {
+		limit = domain.DefaultStepLogPageSize
+	}
+
+	var page domain.StepLogPage
+	if err := s.pool.QueryRow(ctx, `
+		SELECT st.status
+		FROM steps st
+		JOIN runs r ON r.id = st.run_id
+		WHERE st.id=$1 AND st.run_id=$2 AND r.api_key_id=$3
+	`, stepID, runID, apiKeyID).Scan(&page.StepStatus); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			s.logger.Error("step ownership check failed",
+				"run_id", runID,
+				"step_id", stepID,
+				"error", err,
+			)
+		}
+		return domain.StepLogPage{}, notFound(err, domain.ErrStepNotFound)
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT seq, step_id, attempt, level, line, created_at
+		FROM step_logs
+		WHERE step_id=$1 AND seq > $2
+		ORDER BY seq ASC
+		LIMIT $3
+	`, stepID, afterSeq, limit)
+	if err != nil {
+		s.logger.Error("list step logs query failed",
+			"run_id", runID,
+			"step_id", stepID,
+			"error", err,
+		)
+		return domain.StepLogPage{}, err
+	}
+	defer rows.Close()
+
+	page.Lines = make([]domain.StepLogLine, 0, 16)
+	for rows.Next() {
+		var line domain.StepLogLine
+		if err := rows.Scan(&line.Seq, &line.StepID, &line.Attempt, &line.Level, &line.Line, &line.CreatedAt); err != nil {
+			s.logger.Error("scan step log row failed",
+				"step_id", stepID,
+				"error", err,
+			)
+			return domain.StepLogPage{}, err
+		}
+		line.CreatedAt = line.CreatedAt.UTC()
+		page.Lines = append(page.Lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return domain.StepLogPage{}, err
+	}
+
+	return page, nil
+}
+
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// The store interfaces describe the run, step, event, and API key storage the
+// API, its background loops, and the worker depend on. The pgx repositories in this
+// package are the Postgres backend; another backend implements the same
+// interfaces, and tests can substitute fakes. Not-found results wrap
+// domain.ErrNotFound, through the error of the record that was missing (for
+// example domain.ErrRunNotFound), so callers never depend on the driver.
+
+// RunStore creates runs and drives them through approvals, cancellation,
+// retention, and reconciliation.
+type RunStore interface {
+	CreateRun(ctx context.Context, params domain.CreateRunParams) (uuid.UUID, error)
+	SubmitRun(ctx context.Context, params domain.CreateRunParams) (domain.CreatedRun, error)
+	ReplayRun(ctx context.Context, sourceID uuid.UUID, stepInputs map[int]json.RawMessage) (domain.CreatedRun, error)
+	GetRun(ctx context.Context, id uuid.UUID) (domain.RunStatus, error)
+	GetRunVersion(ctx context.Context, id uuid.UUID) (domain.RunVersion, error)
+	ListRuns(ctx context.Context, filter domain.RunFilter) ([]domain.RunListItem, error)
+	GetRunCost(ctx context.Context, id uuid.UUID) (domain.RunCostBreakdown, error)
+	CancelRun(ctx context.Context, runID uuid.UUID, reason string) error
+	CancelRunAsAdmin(ctx context.Context, runID uuid.UUID, reason string) error
+	AdminListRuns(ctx context.Context, apiKeyID *uuid.UUID, filter domain.RunFilter) ([]domain.AdminRunListItem, error)
+	AdminGetRun(ctx context.Context, id uuid.UUID) (domain.RunDetail, error)
+	ApproveRun(ctx context.Context, runID uuid.UUID) error
+	ApproveStep(ctx context.Context, runID, stepID uuid.UUID) error
+	RejectStep(ctx context.Context, runID, stepID uuid.UUID) error
+	ListPendingApprovals(ctx context.Context, runID uuid.UUID) ([]domain.ApprovalGate, error)
+
+	PruneExpiredRunRequests(ctx context.Context, ttl time.Duration, batchSize int) (int64, error)
+	ExpireRuns(ctx context.Context, policy domain.RunRetentionPolicy, batchSize int) (int64, error)
+	EscalateWaitingApprovals(ctx context.Context, thresholds []time.Duration, priorityBoost int, batchSize int) (int64, error)
+	TimeOutWaitingApprovals(ctx context.Context, batchSize int) (int64, error)
+	ReconcileStaleRuns(ctx context.Context, staleAfter time.Duration, batchSize int) (int64, error)
+	GetQueueStats(ctx context.Context) (domain.QueueStats, error)
+	ListTenantBacklog(ctx context.Context) ([]domain.TenantBacklog, error)
+}
+
+// StepStore reads the steps of a run, their timeline, and their logs.
+type StepStore interface {
+	ListSteps(ctx context.Context, runID uuid.UUID) ([]domain.StepRecord, error)
+	GetRunTimeline(ctx context.Context, runID uuid.UUID) (domain.RunTimeline, error)
+	ListStepLogs(ctx context.Context, runID, stepID uuid.UUID, afterSeq int64, limit int) (domain.StepLogPage, error)
+}
+
+// EventStore reads run events and maintains their retention and partitions.
+type EventStore interface {
+	ListEventsAfter(ctx context.Context, runID uuid.UUID, afterSeq int64) ([]domain.EventRecord, error)
+	ResolveCursorByEventID(ctx context.Context, runID uuid.UUID, eventID uuid.UUID) (int64, error)
+	PruneExpiredEvents(ctx context.Context, defaultRetentionDays int, batchSize int) (int64, error)
+	EnsureEventPartitions(ctx context.Context, monthsAhead int) (int, error)
+	DropEmptyEventPartitions(ctx context.Context) (int, error)
+}
+
+// TemplateStore keeps the versions of workflow templates.
+type TemplateStore interface {
+	PutWorkflowTemplate(ctx context.Context, name string, steps []domain.WorkflowTemplateStep) (domain.WorkflowTemplateVersion, error)
+	ListWorkflowTemplateVersions(ctx context.Context, name string) ([]domain.WorkflowTemplateVersion, error)
+}
+
+// SecretStore keeps the named secrets template steps reference. Values go in
+// and never come back out.
+type SecretStore interface {
+	PutSecret(ctx context.Context, name, value string) (domain.Secret, error)
+	ListSecrets(ctx context.Context) ([]domain.Secret, error)
+	DeleteSecret(ctx context.Context, name string) error
+}
+
+// APIKeyStore resolves bearer tokens and manages API keys and their
+// per-tenant settings.
+type APIKeyStore interface {
+	ResolveAPIKey(ctx context.Context, bearerToken string) (auth.APIKey, bool, error)
+	CreateAPIKey(ctx context.Context, params domain.CreateAPIKeyParams) (domain.CreatedAPIKey, error)
+	ListAPIKeys(ctx context.Context, filter domain.APIKeyFilter) ([]domain.APIKeyRecord, error)
+	GetAPIKey(ctx context.Context, id uuid.UUID) (domain.APIKeyRecord, error)
+	GetAPIKeyIDBySlug(ctx context.Context, slug string) (uuid.UUID, error)
+	SetSlug(ctx context.Context, id uuid.UUID, slug string) error
+	SetEventRetentionDays(ctx context.Context, id uuid.UUID, days *int) error
+	SetRunRetentionDays(ctx context.Context, id uuid.UUID, days *int) error
+	SetMonthlyBudget(ctx context.Context, id uuid.UUID, usd *float64) (domain.MonthlyBudget, error)
+	SetSchedulingWeight(ctx context.Context, id uuid.UUID, weight int) error
+	SetTemplateRunLimits(ctx context.Context, id uuid.UUID, limits map[string]int) (map[string]int, error)
+	ListTenantScheduling(ctx context.Context) ([]domain.TenantScheduling, error)
+	SetScopes(ctx context.Context, id uuid.UUID, scopes []string) ([]string, error)
+	SetAllowedCIDRs(ctx context.Context, id uuid.UUID, cidrs []string) ([]string, error)
+	SetEgressAllowHosts(ctx context.Context, id uuid.UUID, hosts []string) ([]string, error)
+	SetWebhookDefaults(ctx context.Context, id uuid.UUID, params domain.SetWebhookDefaultsParams) (domain.WebhookDefaults, error)
+	RotateWebhookSigningKey(ctx context.Context, id uuid.UUID, overlap time.Duration) (domain.WebhookSigningKey, error)
+	ListWebhookSigningKeys(ctx context.Context, id uuid.UUID) ([]domain.WebhookSigningKey, error)
+	ExpireWebhookSigningKey(ctx context.Context, id uuid.UUID, version int) error
+	RevokeAPIKey(ctx context.Context, id uuid.UUID) error
+}
+
+// StepQueue leases a tenant's runnable steps to workers and settles what
+// they executed, moving the steps' runs along. Settling a step whose claim a
+// reclaim superseded fails with domain.ErrStaleClaim, and a transition the
+// state machine rejects with domain.ErrInvalidTransition. While a step
+// executes, the worker renews its lease and polls whether it was canceled.
+type StepQueue interface {
+	ClaimStep(ctx context.Context, req domain.ClaimRequest) (domain.ClaimedStep, error)
+	ClaimSteps(ctx context.Context, req domain.ClaimRequest, n int) ([]domain.ClaimedStep, error)
+	CompleteStep(ctx context.Context, step domain.ClaimedStep, result domain.StepCompletion) error
+	FailStep(ctx context.Context, step domain.ClaimedStep, failure domain.StepFailure) error
+	CancelStep(ctx context.Context, step domain.ClaimedStep) error
+	RenewLease(ctx context.Context, step domain.ClaimedStep, lease time.Duration) (bool, error)
+	StepCanceled(ctx context.Context, step domain.ClaimedStep) (bool, error)
+	AppendStepEvent(ctx context.Context, step domain.ClaimedStep, eventType string, payload any) error
+}
+
+var (
+	_ RunStore    = (*RunRepository)(nil)
+	_ StepStore   = (*StepRepository)(nil)
+	_ EventStore  = (*EventRepository)(nil)
+	_ APIKeyStore = (*APIKeyRepository)(nil)
+	_ StepQueue   = (*StepQueueRepository)(nil)
+)
+
+// notFound returns err as target, one of the domain errors wrapping
+// domain.ErrNotFound, when it is pgx.ErrNoRows. pgx.ErrNoRows stays in the
+// chain for this package's own checks, such as the replica fallback.
+func notFound(err, target error) error {
+	if !errors.Is(err, pgx.ErrNoRows) || errors.Is(err, domain.ErrNotFound) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", target, err)
+}
@@ -0,0 +1,211 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/adiadia/agent-runtime/internal/audit"
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/ids"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TemplateRepository keeps the versions of workflow templates. Templates are
+// shared by every tenant; a version is never changed once written.
+type TemplateRepository struct {
+	pool   *pgxpool.Pool
+	logger *slog.Logger
+	clock  clock.Clock
+}
+
+func NewTemplateRepository(pool *pgxpool.Pool, logger *slog.Logger) *TemplateRepository {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &TemplateRepository{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// WithClock sets the clock used to stamp template versions.
+func (r *TemplateRepository) WithClock(c clock.Clock) *TemplateRepository {
+	r.clock = c
+	return r
+}
+
+// PutWorkflowTemplate saves steps as the next version of the named template,
+// creating the template at version 1. Runs already created keep the version
+// they pinned. Steps must be normalized with
+// domain.NormalizeWorkflowTemplateSteps.
+func (r *TemplateRepository) PutWorkflowTemplate(ctx context.Context, name string, steps []domain.WorkflowTemplateStep) (domain.WorkflowTemplateVersion, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		r.logger.Error("begin tx failed", "error", err)
+		return domain.WorkflowTemplateVersion{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Concurrent edits of one template would otherwise pick the same
+	// version; the unique index would reject the second, but waiting lets it
+	// become the next version instead.
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, "workflow_templates:"+name); err != nil {
+		r.logger.Error("lock workflow template failed", "template_name", name, "error", err)
+		return domain.WorkflowTemplateVersion{}, err
+	}
+
+	var previous int
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(MAX(version), 0) FROM workflow_templates WHERE name = $1
+	`, name).Scan(&previous); err != nil {
+		r.logger.Error("read workflow template version failed", "template_name", name, "error", err)
+		return domain.WorkflowTemplateVersion{}, err
+	}
+
+	now := nowUTC(r.clock)
+	version := domain.WorkflowTemplateVersion{
+		Name:      name,
+		Version:   previous + 1,
+		Steps:     steps,
+		CreatedAt: now,
+	}
+	templateID := ids.New()
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO workflow_templates (id, name, version, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, templateID, name, version.Version, now); err != nil {
+		r.logger.Error("insert workflow template failed", "template_name", name, "error", err)
+		return domain.WorkflowTemplateVersion{}, err
+	}
+
+	for i, st := range steps {
+		var mapStep, backoff, timeoutAction any
+		if st.MapStep != "" {
+			mapStep = st.MapStep
+		}
+		if st.RetryBackoff != "" {
+			backoff = st.RetryBackoff
+		}
+		if st.ApprovalTimeoutAction != "" {
+			timeoutAction = st.ApprovalTimeoutAction
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO workflow_template_steps (id, template_id, position, name, timeout_seconds, on_failure, condition,
+				map_items, map_step, map_parallelism, approval_name, approval_timeout_seconds, approval_timeout_action,
+				max_attempts, retry_base_delay_ms, retry_backoff, retry_jitter, retry_priority, command, http, secrets, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
+		`,
+			ids.New(), templateID, i+1, st.Name, st.TimeoutSeconds, st.OnFailure, nullString(st.Condition),
+			nullString(st.MapItems), mapStep, st.MapParallelism, nullString(st.ApprovalName), st.ApprovalTimeoutSeconds, timeoutAction,
+			st.MaxAttempts, st.RetryBaseDelayMS, backoff, st.RetryJitter, st.RetryPriority, st.Command, st.HTTP, st.Secrets, now,
+		); err != nil {
+			r.logger.Error("insert workflow template step failed", "template_name", name, "position", i+1, "error", err)
+			return domain.WorkflowTemplateVersion{}, err
+		}
+	}
+
+	change := audit.Change{
+		Action:     domain.AuditTemplateVersion,
+		TargetType: domain.AuditTargetTemplate,
+		TargetID:   name,
+		After:      map[string]any{"version": version.Version, "steps": steps},
+	}
+	if previous > 0 {
+		change.Before = map[string]any{"version": previous}
+	}
+	if err := audit.Record(ctx, tx, now, change); err != nil {
+		r.logger.Error("record workflow template version failed", "template_name", name, "error", err)
+		return domain.WorkflowTemplateVersion{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("commit failed", "template_name", name, "error", err)
+		return domain.WorkflowTemplateVersion{}, err
+	}
+
+	r.logger.Info("workflow template version created", "template_name", name, "version", version.Version)
+	return version, nil
+}
+
+// ListWorkflowTemplateVersions returns every version of the named template
+// with its steps, newest first. It returns domain.ErrWorkflowTemplateNotFound
+// for an unknown template.
+func (r *TemplateRepository) ListWorkflowTemplateVersions(ctx context.Context, name string) ([]domain.WorkflowTemplateVersion, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, version, created_at
+		FROM workflow_templates
+		WHERE name = $1
+		ORDER BY version DESC
+	`, name)
+	if err != nil {
+		r.logger.Error("list workflow template versions failed", "template_name", name, "error", err)
+		return nil, err
+	}
+
+	var (
+		versions  []domain.WorkflowTemplateVersion
+		byID      = map[uuid.UUID]int{}
+		templates []uuid.UUID
+	)
+	for rows.Next() {
+		var (
+			id      uuid.UUID
+			version = domain.WorkflowTemplateVersion{Name: name, Steps: []domain.WorkflowTemplateStep{}}
+		)
+		if err := rows.Scan(&id, &version.Version, &version.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		byID[id] = len(versions)
+		templates = append(templates, id)
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("scan workflow template versions failed", "template_name", name, "error", err)
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, notFound(pgx.ErrNoRows, domain.ErrWorkflowTemplateNotFound)
+	}
+
+	rows, err = r.pool.Query(ctx, `
+		SELECT template_id, name, timeout_seconds, on_failure, COALESCE(condition, ''),
+		       COALESCE(map_items, ''), COALESCE(map_step, ''), map_parallelism,
+		       COALESCE(approval_name, ''), approval_timeout_seconds, COALESCE(approval_timeout_action, ''),
+		       max_attempts, retry_base_delay_ms, COALESCE(retry_backoff, ''), retry_jitter, retry_priority, command, http, secrets
+		FROM workflow_template_steps
+		WHERE template_id = ANY($1)
+		ORDER BY template_id, position ASC
+	`, templates)
+	if err != nil {
+		r.logger.Error("list workflow template steps failed", "template_name", name, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			templateID uuid.UUID
+			st         domain.WorkflowTemplateStep
+		)
+		if err := rows.Scan(&templateID, &st.Name, &st.TimeoutSeconds, &st.OnFailure, &st.Condition,
+			&st.MapItems, &st.MapStep, &st.MapParallelism,
+			&st.ApprovalName, &st.ApprovalTimeoutSeconds, &st.ApprovalTimeoutAction,
+			&st.MaxAttempts, &st.RetryBaseDelayMS, &st.RetryBackoff, &st.RetryJitter, &st.RetryPriority, &st.Command, &st.HTTP, &st.Secrets); err != nil {
+			return nil, err
+		}
+		i := byID[templateID]
+		versions[i].Steps = append(versions[i].Steps, st)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("scan workflow template steps failed", "template_name", name, "error", err)
+		return nil, err
+	}
+	return versions, nil
+}
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/adiadia/agent-runtime/internal/audit"
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type TenantRepository struct {
+	pool   *pgxpool.Pool
+	logger *slog.Logger
+	clock  clock.Clock
+}
+
+func NewTenantRepository(pool *pgxpool.Pool, logger *slog.Logger) *TenantRepository {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &TenantRepository{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// WithClock sets the clock used to stamp purge reports.
+func (r *TenantRepository) WithClock(c clock.Clock) *TenantRepository {
+	r.clock = c
+	return r
+}
+
+// PurgeTenant deletes every run, step, event, idempotency record, webhook
+// delivery, and archived run owned by apiKeyID in one transaction, and stores a report signed
+// with signingKey alongside. The API key row and the aggregate
+// run_daily_stats rows are kept. It returns domain.ErrAPIKeyNotFound when
+// the key does not exist.
+func (r *TenantRepository) PurgeTenant(ctx context.Context, apiKeyID uuid.UUID, signingKey []byte) (domain.SignedPurgeReport, error) {
+	if len(signingKey) == 0 {
+		return domain.SignedPurgeReport{}, domain.ErrPurgeSigningKeyMissing
+	}
+
+	report := domain.TenantPurgeReport{
+		ID:        uuid.New(),
+		APIKeyID:  apiKeyID,
+		StartedAt: nowUTC(r.clock),
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		r.logger.Error("begin tx failed", "error", err)
+		return domain.SignedPurgeReport{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the key row serializes the purge with CreateRun, which takes the
+	// same lock, so no run can slip in mid-purge.
+	var lockedID uuid.UUID
+	if err := tx.QueryRow(ctx,
+		`SELECT id FROM api_keys WHERE id=$1 FOR UPDATE`,
+		apiKeyID,
+	).Scan(&lockedID); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("lock api key for purge failed", "api_key_id", apiKeyID, "error", err)
+		}
+		return domain.SignedPurgeReport{}, notFound(err, domain.ErrAPIKeyNotFound)
+	}
+
+	deletes := []struct {
+		name  string
+		sql   string
+		count *int64
+	}{
+		{"webhook_deliveries", `DELETE FROM webhook_deliveries WHERE api_key_id=$1`, &report.Deleted.WebhookDeliveries},
+		{"outbox_messages", `DELETE FROM outbox_messages WHERE api_key_id=$1`, &report.Deleted.OutboxMessages},
+		{"events", `DELETE FROM events WHERE run_id IN (SELECT id FROM runs WHERE api_key_id=$1)`, &report.Deleted.Events},
+		{"step_logs", `DELETE FROM step_logs WHERE run_id IN (SELECT id FROM runs WHERE api_key_id=$1)`, &report.Deleted.StepLogs},
+		{"steps", `DELETE FROM steps WHERE run_id IN (SELECT id FROM runs WHERE api_key_id=$1)`, &report.Deleted.Steps},
+		{"run_requests", `DELETE FROM run_requests WHERE api_key_id=$1`, &report.Deleted.RunRequests},
+		{"runs", `DELETE FROM runs WHERE api_key_id=$1`, &report.Deleted.Runs},
+		{"run_archive", `DELETE FROM run_archive WHERE api_key_id=$1`, &report.Deleted.ArchivedRuns},
+		{"export_jobs", `DELETE FROM export_jobs WHERE api_key_id=$1`, &report.Deleted.ExportJobs},
+		{"usage_records", `DELETE FROM usage_records WHERE api_key_id=$1`, &report.Deleted.UsageRecords},
+	}
+	for _, d := range deletes {
+		tag, err := tx.Exec(ctx, d.sql, apiKeyID)
+		if err != nil {
+			r.logger.Error("purge tenant delete failed",
+				"api_key_id", apiKeyID,
+				"table", d.name,
+				"error", err,
+			)
+			return domain.SignedPurgeReport{}, err
+		}
+		*d.count = tag.RowsAffected()
+	}
+
+	report.CompletedAt = nowUTC(r.clock)
+
+	signature, err := report.Sign(signingKey)
+	if err != nil {
+		return domain.SignedPurgeReport{}, err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO tenant_purge_reports (id, api_key_id, report, signature)
+		VALUES ($1, $2, $3, $4)
+	`,
+		report.ID,
+		apiKeyID,
+		report,
+		signature,
+	); err != nil {
+		r.logger.Error("insert purge report failed", "api_key_id", apiKeyID, "error", err)
+		return domain.SignedPurgeReport{}, err
+	}
+
+	if err := audit.Record(ctx, tx, report.CompletedAt, audit.Change{
+		Action:     domain.AuditTenantPurge,
+		APIKeyID:   apiKeyID,
+		TargetType: domain.AuditTargetAPIKey,
+		TargetID:   apiKeyID.String(),
+		After: map[string]any{
+			"report_id": report.ID,
+			"deleted":   report.Deleted,
+		},
+	}); err != nil {
+		r.logger.Error("record tenant purge failed", "api_key_id", apiKeyID, "error", err)
+		return domain.SignedPurgeReport{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("commit tenant purge failed", "api_key_id", apiKeyID, "error", err)
+		return domain.SignedPurgeReport{}, err
+	}
+
+	r.logger.Info("tenant purged",
+		"api_key_id", apiKeyID,
+		"report_id", report.ID,
+		"runs", report.Deleted.Runs,
+		"steps", report.Deleted.Steps,
+		"events", report.Deleted.Events,
+		"step_logs", report.Deleted.StepLogs,
+		"run_requests", report.Deleted.RunRequests,
+		"webhook_deliveries", report.Deleted.WebhookDeliveries,
+		"archived_runs", report.Deleted.ArchivedRuns,
+	)
+
+	return domain.SignedPurgeReport{
+		Report:             report,
+		Signature:          signature,
+		SignatureAlgorithm: domain.PurgeReportSignatureAlgorithm,
+	}, nil
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// UsageRepository reads the usage_records workers write for each step
+// execution that reports tokens, a model, or a tool.
+type UsageRepository struct {
+	pool    *pgxpool.Pool
+	logger  *slog.Logger
+	replica *ReadReplica
+}
+
+func NewUsageRepository(pool *pgxpool.Pool, logger *slog.Logger) *UsageRepository {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &UsageRepository{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// WithReadReplica serves ListDailyTokenUsage from replica.
+func (r *UsageRepository) WithReadReplica(replica *ReadReplica) *UsageRepository {
+	r.replica = replica
+	return r
+}
+
+// ListDailyTokenUsage totals apiKeyID's usage records per day and provider
+// and model or tool for the days in [from, to], oldest first. It returns
+// domain.ErrAPIKeyNotFound when the key does not exist.
+func (r *UsageRepository) ListDailyTokenUsage(ctx context.Context, apiKeyID uuid.UUID, from, to time.Time) ([]domain.TokenUsage, error) {
+	var days []domain.TokenUsage
+	err := r.replica.read(ctx, r.pool, func(q querier) error {
+		var err error
+		days, err = r.listDailyTokenUsage(ctx, q, apiKeyID, from, to)
+		return err
+	})
+	return days, notFound(err, domain.ErrAPIKeyNotFound)
+}
+
+func (r *UsageRepository) listDailyTokenUsage(ctx context.Context, q querier, apiKeyID uuid.UUID, from, to time.Time) ([]domain.TokenUsage, error) {
+	var exists bool
+	if err := q.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM api_keys WHERE id=$1)`,
+		apiKeyID,
+	).Scan(&exists); err != nil {
+		r.logger.Error("check api key failed", "api_key_id", apiKeyID, "error", err)
+		return nil, err
+	}
+	if !exists {
+		return nil, pgx.ErrNoRows
+	}
+
+	rows, err := q.Query(ctx, `
+		SELECT created_at::date AS day,
+		       provider,
+		       model,
+		       tool,
+		       COUNT(*),
+		       COALESCE(SUM(prompt_tokens), 0)::bigint,
+		       COALESCE(SUM(completion_tokens), 0)::bigint,
+		       COALESCE(SUM(latency_ms), 0)::bigint
+		FROM usage_records
+		WHERE api_key_id=$1
+		  AND created_at >= $2::date
+		  AND created_at < $3::date + 1
+		GROUP BY day, provider, model, tool
+		ORDER BY day ASC, provider ASC, model ASC, tool ASC
+	`, apiKeyID, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	if err != nil {
+		r.logger.Error("list token usage failed", "api_key_id", apiKeyID, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := make([]domain.TokenUsage, 0)
+	for rows.Next() {
+		var (
+			u   domain.TokenUsage
+			day time.Time
+		)
+		if err := rows.Scan(
+			&day,
+			&u.Provider,
+			&u.Model,
+			&u.Tool,
+			&u.Calls,
+			&u.PromptTokens,
+			&u.CompletionTokens,
+			&u.TotalLatencyMS,
+		); err != nil {
+			return nil, err
+		}
+		u.Period = day.Format(time.DateOnly)
+		days = append(days, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return days, nil
+}
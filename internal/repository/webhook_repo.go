@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// WebhookRepository exposes the webhook outbox and its attempt log to the
+// tenant that owns the runs.
+type WebhookRepository struct {
+	pool   *pgxpool.Pool
+	logger *slog.Logger
+	clock  clock.Clock
+}
+
+func NewWebhookRepository(pool *pgxpool.Pool, logger *slog.Logger) *WebhookRepository {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &WebhookRepository{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// WithClock sets the clock used to schedule and stamp webhook redeliveries.
+func (r *WebhookRepository) WithClock(c clock.Clock) *WebhookRepository {
+	r.clock = c
+	return r
+}
+
+const webhookDeliveryColumns = `id, run_id, event_id, event_type, url, status, attempts, max_attempts,
+		       next_attempt_at, first_attempted_at, last_status_code, last_error, delivered_at, created_at`
+
+// ListWebhookDeliveries returns every delivery for the run, oldest first, each
+// with its attempts. It returns domain.ErrRunNotFound when the run does not
+// belong to the caller's API key.
+func (r *WebhookRepository) ListWebhookDeliveries(ctx context.Context, runID uuid.UUID) ([]domain.WebhookDeliveryRecord, error) {
+	apiKeyID, err := apiKeyIDFromContext(ctx)
+	if err != nil {
+		r.logger.Warn("list webhook deliveries denied: missing api key id", "run_id", runID, "error", err)
+		return nil, err
+	}
+
+	var exists int
+	if err := r.pool.QueryRow(ctx,
+		`SELECT 1 FROM runs WHERE id=$1 AND api_key_id=$2`,
+		runID,
+		apiKeyID,
+	).Scan(&exists); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("run ownership check failed", "run_id", runID, "api_key_id", apiKeyID, "error", err)
+		}
+		return nil, notFound(err, domain.ErrRunNotFound)
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries
+		WHERE run_id=$1
+		ORDER BY created_at ASC, id ASC
+	`, runID)
+	if err != nil {
+		r.logger.Error("list webhook deliveries failed", "run_id", runID, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]domain.WebhookDeliveryRecord, 0, 4)
+	byID := make(map[uuid.UUID]int)
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID[d.ID] = len(deliveries)
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	attemptRows, err := r.pool.Query(ctx, `
+		SELECT a.delivery_id, a.attempt, a.status_code, a.latency_ms, a.error, a.created_at
+		FROM webhook_attempts a
+		JOIN webhook_deliveries d ON d.id = a.delivery_id
+		WHERE d.run_id=$1
+		ORDER BY a.delivery_id, a.attempt ASC, a.created_at ASC
+	`, runID)
+	if err != nil {
+		r.logger.Error("list webhook attempts failed", "run_id", runID, "error", err)
+		return nil, err
+	}
+	defer attemptRows.Close()
+
+	for attemptRows.Next() {
+		var (
+			deliveryID uuid.UUID
+			a          domain.WebhookAttemptRecord
+		)
+		if err := attemptRows.Scan(&deliveryID, &a.Attempt, &a.StatusCode, &a.LatencyMS, &a.Error, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		if i, ok := byID[deliveryID]; ok {
+			deliveries[i].AttemptLog = append(deliveries[i].AttemptLog, a)
+		}
+	}
+	if err := attemptRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RedeliverWebhook schedules one more immediate attempt for a delivered or
+// failed delivery. It returns domain.ErrWebhookDeliveryNotFound when the
+// delivery does not belong to the caller and domain.ErrWebhookDeliveryPending
+// when it is still queued.
+func (r *WebhookRepository) RedeliverWebhook(ctx context.Context, deliveryID uuid.UUID) (domain.WebhookDeliveryRecord, error) {
+	apiKeyID, err := apiKeyIDFromContext(ctx)
+	if err != nil {
+		r.logger.Warn("redeliver webhook denied: missing api key id", "delivery_id", deliveryID, "error", err)
+		return domain.WebhookDeliveryRecord{}, err
+	}
+
+	row := r.pool.QueryRow(ctx, `
+		UPDATE webhook_deliveries
+		SET status=$3,
+		    max_attempts=attempts + 1,
+		    next_attempt_at=$4,
+		    delivered_at=NULL,
+		    updated_at=$4
+		WHERE id=$1
+		  AND api_key_id=$2
+		  AND status <> $3
+		RETURNING `+webhookDeliveryColumns,
+		deliveryID,
+		apiKeyID,
+		domain.WebhookPending,
+		nowUTC(r.clock),
+	)
+	d, err := scanWebhookDelivery(row)
+	if err == nil {
+		r.logger.Info("webhook redelivery scheduled", "delivery_id", deliveryID, "run_id", d.RunID, "api_key_id", apiKeyID)
+		return d, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		r.logger.Error("redeliver webhook failed", "delivery_id", deliveryID, "error", err)
+		return domain.WebhookDeliveryRecord{}, err
+	}
+
+	var pending bool
+	if err := r.pool.QueryRow(ctx,
+		`SELECT status = $3 FROM webhook_deliveries WHERE id=$1 AND api_key_id=$2`,
+		deliveryID,
+		apiKeyID,
+		domain.WebhookPending,
+	).Scan(&pending); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("check webhook delivery failed", "delivery_id", deliveryID, "error", err)
+		}
+		return domain.WebhookDeliveryRecord{}, notFound(err, domain.ErrWebhookDeliveryNotFound)
+	}
+	if pending {
+		return domain.WebhookDeliveryRecord{}, domain.ErrWebhookDeliveryPending
+	}
+	return domain.WebhookDeliveryRecord{}, notFound(pgx.ErrNoRows, domain.ErrWebhookDeliveryNotFound)
+}
+
+func scanWebhookDelivery(row pgx.Row) (domain.WebhookDeliveryRecord, error) {
+	var d domain.WebhookDeliveryRecord
+	err := row.Scan(
+		&d.ID,
+		&d.RunID,
+		&d.EventID,
+		&d.EventType,
+		&d.URL,
+		&d.Status,
+		&d.Attempts,
+		&d.MaxAttempts,
+		&d.NextAttemptAt,
+		&d.FirstAttemptedAt,
+		&d.LastStatusCode,
+		&d.LastError,
+		&d.DeliveredAt,
+		&d.CreatedAt,
+	)
+	return d, err
+}
@@ -0,0 +1,298 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// WorkerRepository maintains the workers registry: each worker process
+// registers its version, required schema version, and features at startup and
+// heartbeats last_seen_at and its in-flight steps on every poll.
+type WorkerRepository struct {
+	pool   *pgxpool.Pool
+	logger *slog.Logger
+	clock  clock.Clock
+}
+
+func NewWorkerRepository(pool *pgxpool.Pool, logger *slog.Logger) *WorkerRepository {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &WorkerRepository{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// WithClock sets the clock used for registry timestamps and activity checks.
+func (r *WorkerRepository) WithClock(c clock.Clock) *WorkerRepository {
+	r.clock = c
+	return r
+}
+
+// RegisterWorker inserts or replaces the registry row for worker.ID, stamping
+// started_at and last_seen_at with the current time.
+func (r *WorkerRepository) RegisterWorker(ctx context.Context, worker domain.WorkerRecord) error {
+	features, pricing, err := workerRegistration(worker)
+	if err != nil {
+		return err
+	}
+
+	now := nowUTC(r.clock)
+	if _, err := r.pool.Exec(ctx, `
+		INSERT INTO workers (id, api_key_id, hostname, version, min_schema_version, features, pricing, started_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8, $8)
+		ON CONFLICT (id) DO UPDATE
+		SET hostname = EXCLUDED.hostname,
+		    version = EXCLUDED.version,
+		    min_schema_version = EXCLUDED.min_schema_version,
+		    features = EXCLUDED.features,
+		    pricing = EXCLUDED.pricing,
+		    last_seen_at = EXCLUDED.last_seen_at
+	`,
+		worker.ID,
+		worker.APIKeyID,
+		worker.Hostname,
+		worker.Version,
+		worker.MinSchemaVersion,
+		features,
+		pricing,
+		now,
+	); err != nil {
+		r.logger.Error("register worker failed", "worker_id", worker.ID, "api_key_id", worker.APIKeyID, "error", err)
+		return err
+	}
+
+	r.logger.Info("worker registered",
+		"worker_id", worker.ID,
+		"api_key_id", worker.APIKeyID,
+		"version", worker.Version,
+		"min_schema_version", worker.MinSchemaVersion,
+		"features", features,
+	)
+	return nil
+}
+
+// Heartbeat refreshes last_seen_at and in_flight_steps for worker.ID,
+// re-registering the worker when its row was removed.
+func (r *WorkerRepository) Heartbeat(ctx context.Context, worker domain.WorkerRecord) error {
+	features, pricing, err := workerRegistration(worker)
+	if err != nil {
+		return err
+	}
+
+	now := nowUTC(r.clock)
+	if _, err := r.pool.Exec(ctx, `
+		INSERT INTO workers (id, api_key_id, hostname, version, min_schema_version, features, pricing, started_at, last_seen_at, in_flight_steps)
+		VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8, $8, $9)
+		ON CONFLICT (id) DO UPDATE
+		SET last_seen_at = EXCLUDED.last_seen_at,
+		    in_flight_steps = EXCLUDED.in_flight_steps
+	`,
+		worker.ID,
+		worker.APIKeyID,
+		worker.Hostname,
+		worker.Version,
+		worker.MinSchemaVersion,
+		features,
+		pricing,
+		now,
+		worker.InFlightSteps,
+	); err != nil {
+		r.logger.Error("worker heartbeat failed", "worker_id", worker.ID, "api_key_id", worker.APIKeyID, "error", err)
+		return err
+	}
+	return nil
+}
+
+// workerRegistration returns the features and encoded pricing stored for
+// worker, empty rather than NULL when it has none.
+func workerRegistration(worker domain.WorkerRecord) ([]string, []byte, error) {
+	features := worker.Features
+	if features == nil {
+		features = []string{}
+	}
+	pricing := worker.Pricing
+	if pricing == nil {
+		pricing = map[domain.StepName]domain.StepPricing{}
+	}
+	encoded, err := json.Marshal(pricing)
+	return features, encoded, err
+}
+
+// TouchWorker refreshes last_seen_at. It returns pgx.ErrNoRows when the worker
+// is not registered.
+func (r *WorkerRepository) TouchWorker(ctx context.Context, id uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx,
+		`UPDATE workers SET last_seen_at=$2 WHERE id=$1`,
+		id,
+		nowUTC(r.clock),
+	)
+	if err != nil {
+		r.logger.Error("touch worker failed", "worker_id", id, "error", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ListActiveWorkers returns apiKeyID's workers seen within
+// domain.WorkerActiveWindow, most recently started first.
+func (r *WorkerRepository) ListActiveWorkers(ctx context.Context, apiKeyID uuid.UUID) ([]domain.WorkerRecord, error) {
+	workers, err := r.listWorkers(ctx, &apiKeyID, domain.WorkerActiveWindow)
+	if err != nil {
+		r.logger.Error("list active workers failed", "api_key_id", apiKeyID, "error", err)
+	}
+	return workers, err
+}
+
+// ListAllActiveWorkers returns the active workers of every tenant, most
+// recently started first.
+func (r *WorkerRepository) ListAllActiveWorkers(ctx context.Context) ([]domain.WorkerRecord, error) {
+	workers, err := r.listWorkers(ctx, nil, domain.WorkerActiveWindow)
+	if err != nil {
+		r.logger.Error("list all active workers failed", "error", err)
+	}
+	return workers, err
+}
+
+// GetWorkerLiveness lists the workers seen within
+// domain.WorkerLivenessLookback, flagging stale ones, and the tenants without
+// a live worker.
+func (r *WorkerRepository) GetWorkerLiveness(ctx context.Context) (domain.WorkerLiveness, error) {
+	workers, err := r.listWorkers(ctx, nil, domain.WorkerLivenessLookback)
+	if err != nil {
+		r.logger.Error("list workers failed", "error", err)
+		return domain.WorkerLiveness{}, err
+	}
+
+	liveness := domain.WorkerLiveness{
+		Workers:                  workers,
+		TenantsWithoutLiveWorker: []uuid.UUID{},
+	}
+	for _, w := range workers {
+		if w.Stale {
+			liveness.StaleWorkers++
+		}
+	}
+
+	now := nowUTC(r.clock)
+	rows, err := r.pool.Query(ctx, `
+		SELECT k.id
+		FROM api_keys k
+		WHERE k.revoked_at IS NULL
+		  AND (
+			EXISTS (
+				SELECT 1 FROM workers w
+				WHERE w.api_key_id = k.id
+				  AND w.last_seen_at > $1
+			)
+			OR EXISTS (
+				SELECT 1 FROM runs r
+				WHERE r.api_key_id = k.id
+				  AND r.status IN ($3, $4)
+			)
+		  )
+		  AND NOT EXISTS (
+			SELECT 1 FROM workers w
+			WHERE w.api_key_id = k.id
+			  AND w.last_seen_at > $2
+		  )
+		ORDER BY k.id
+	`,
+		now.Add(-domain.WorkerLivenessLookback),
+		now.Add(-domain.WorkerActiveWindow),
+		domain.RunPending,
+		domain.RunRunning,
+	)
+	if err != nil {
+		r.logger.Error("list tenants without live worker failed", "error", err)
+		return domain.WorkerLiveness{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return domain.WorkerLiveness{}, err
+		}
+		liveness.TenantsWithoutLiveWorker = append(liveness.TenantsWithoutLiveWorker, id)
+	}
+	if err := rows.Err(); err != nil {
+		return domain.WorkerLiveness{}, err
+	}
+
+	return liveness, nil
+}
+
+// listWorkers lists the workers seen within seenWithin, of apiKeyID only when
+// it is set, with the unexpired step leases each holds. Workers not seen
+// within domain.WorkerActiveWindow are marked stale.
+func (r *WorkerRepository) listWorkers(ctx context.Context, apiKeyID *uuid.UUID, seenWithin time.Duration) ([]domain.WorkerRecord, error) {
+	now := nowUTC(r.clock)
+	rows, err := r.pool.Query(ctx, `
+		SELECT w.id, w.api_key_id, w.hostname, w.version, w.min_schema_version, w.features, w.pricing,
+		       w.started_at, w.last_seen_at, w.in_flight_steps, w.last_seen_at <= $5,
+		       (
+				SELECT COUNT(*)
+				FROM steps s
+				WHERE s.claimed_by = w.id
+				  AND s.status = $4
+				  AND s.lease_expires_at > $3
+		       )
+		FROM workers w
+		WHERE ($1::uuid IS NULL OR w.api_key_id = $1)
+		  AND w.last_seen_at > $2
+		ORDER BY w.started_at DESC, w.id ASC
+	`,
+		apiKeyID,
+		now.Add(-seenWithin),
+		now,
+		domain.StepRunning,
+		now.Add(-domain.WorkerActiveWindow),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workers := make([]domain.WorkerRecord, 0, 4)
+	for rows.Next() {
+		var w domain.WorkerRecord
+		if err := rows.Scan(
+			&w.ID,
+			&w.APIKeyID,
+			&w.Hostname,
+			&w.Version,
+			&w.MinSchemaVersion,
+			&w.Features,
+			&w.Pricing,
+			&w.StartedAt,
+			&w.LastSeenAt,
+			&w.InFlightSteps,
+			&w.Stale,
+			&w.ActiveLeases,
+		); err != nil {
+			return nil, err
+		}
+		workers = append(workers, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return workers, nil
+}
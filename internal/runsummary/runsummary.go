@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package runsummary appends the RUN_SUMMARY event for a run that has just
+// reached a terminal status. It runs inside the caller's transaction so the
+// summary sees the final step states and commits with them.
+package runsummary
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/ids"
+	"github.com/adiadia/agent-runtime/internal/outbox"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Emit builds the run's summary from its steps, inserts it as a RUN_SUMMARY
+// event, and enqueues its outbox message and, when the run subscribed to it,
+// the event webhook. It must be called after the run's terminal status has
+// been written.
+func Emit(ctx context.Context, tx pgx.Tx, runID uuid.UUID, webhookMaxAttempts int) error {
+	var (
+		status     domain.RunStatus
+		createdAt  time.Time
+		finishedAt time.Time
+	)
+	if err := tx.QueryRow(ctx,
+		`SELECT status, created_at, updated_at FROM runs WHERE id=$1`,
+		runID,
+	).Scan(&status, &createdAt, &finishedAt); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, name, status, attempts, started_at, finished_at, cost_usd::double precision,
+		       `+usageTokens("prompt_tokens")+`,
+		       `+usageTokens("completion_tokens")+`
+		FROM steps
+		WHERE run_id=$1
+		ORDER BY position ASC, map_index ASC NULLS FIRST, created_at ASC, id ASC
+	`, runID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	steps := make([]domain.StepSummary, 0, 4)
+	for rows.Next() {
+		var (
+			step                  domain.StepSummary
+			startedAt, finishedAt *time.Time
+		)
+		if err := rows.Scan(
+			&step.ID,
+			&step.Name,
+			&step.Status,
+			&step.Attempts,
+			&startedAt,
+			&finishedAt,
+			&step.CostUSD,
+			&step.PromptTokens,
+			&step.CompletionTokens,
+		); err != nil {
+			return err
+		}
+		step.DurationMS = domain.StepDurationMS(startedAt, finishedAt)
+		steps = append(steps, step)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(domain.NewRunSummary(status, createdAt, finishedAt, steps))
+	if err != nil {
+		return err
+	}
+
+	eventID := ids.New()
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO events (id, run_id, type, payload)
+		VALUES ($1, $2, $3, $4::jsonb)
+	`,
+		eventID,
+		runID,
+		domain.EventRunSummary,
+		payload,
+	); err != nil {
+		return err
+	}
+
+	return outbox.EnqueueEvent(ctx, tx, eventID, webhookMaxAttempts)
+}
+
+// usageTokens reads a token count from the "usage" object executors add to
+// step output, treating a missing or non-numeric value as zero. Encrypted
+// output cannot be read here, so its step's cost detail is used instead.
+func usageTokens(field string) string {
+	return `CASE WHEN jsonb_typeof(output->'usage'->'` + field + `') = 'number'
+		            THEN (output->'usage'->>'` + field + `')::numeric::bigint
+		            WHEN output ? '` + secrets.EnvelopeKey + `' AND jsonb_typeof(cost_detail->'` + field + `') = 'number'
+		            THEN (cost_detail->>'` + field + `')::numeric::bigint
+		            ELSE 0 END`
+}
@@ -0,0 +1,223 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Fields name the encrypted columns. Each is authenticated with the values
+// sealed for it, so a ciphertext moved into another column does not open.
+// Webhook secrets share one field because they are copied between tables.
+const (
+	FieldStepInput         = "steps.input"
+	FieldStepInputOverride = "steps.input_override"
+	FieldStepItem          = "steps.item"
+	FieldStepOutput        = "steps.output"
+	FieldWebhookSecret     = "webhook_secret"
+)
+
+// EnvelopeKey is the single key of a sealed JSON column value:
+//
+//	{"$encrypted": {"key_id": "2024-01", "ciphertext": "<base64>"}}
+const EnvelopeKey = "$encrypted"
+
+// sealedStringPrefix starts a sealed text column value, followed by the key
+// ID, ':', and the base64 ciphertext.
+const sealedStringPrefix = "enc:v1:"
+
+// maxKeyIDLength bounds ENCRYPTION_KEYS key IDs.
+const maxKeyIDLength = 32
+
+// ErrUnknownKey is returned when opening a value sealed under a key ID the
+// keyring does not hold, including by a nil keyring.
+var ErrUnknownKey = errors.New("value is encrypted under a key that is not configured")
+
+// Keyring encrypts sensitive column values at rest. It seals under its active
+// key and opens under any of its keys, so a new key can become active while
+// rows sealed under the old ones stay readable until they are re-sealed.
+//
+// A nil *Keyring leaves values as they are: encryption is off. Values that
+// were never sealed open as themselves with or without a keyring, so
+// encryption can be turned on for an existing database.
+type Keyring struct {
+	active  string
+	ciphers map[string]*Cipher
+}
+
+// ParseKeyring decodes ENCRYPTION_KEYS: comma-separated KEY_ID:BASE64_KEY
+// entries, each key KeySize bytes. The first entry is the active key; the
+// rest only open values sealed before a rotation.
+func ParseKeyring(raw string) (*Keyring, error) {
+	k := &Keyring{ciphers: map[string]*Cipher{}}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("entry %q must be KEY_ID:BASE64_KEY", truncateEntry(entry))
+		}
+		if err := validateKeyID(id); err != nil {
+			return nil, err
+		}
+		if _, dup := k.ciphers[id]; dup {
+			return nil, fmt.Errorf("key ID %q is listed twice", id)
+		}
+		key, err := ParseKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q %w", id, err)
+		}
+		c, err := NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		k.ciphers[id] = c
+		if k.active == "" {
+			k.active = id
+		}
+	}
+	if k.active == "" {
+		return nil, errors.New("must list at least one KEY_ID:BASE64_KEY")
+	}
+	return k, nil
+}
+
+// validateKeyID accepts 1 to maxKeyIDLength ASCII letters, digits, '.', and
+// '-'.
+func validateKeyID(id string) error {
+	if id == "" || len(id) > maxKeyIDLength {
+		return fmt.Errorf("key ID %q must be 1 to %d characters", id, maxKeyIDLength)
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.', c == '-':
+		default:
+			return fmt.Errorf("key ID %q must be letters, digits, '.', and '-'", id)
+		}
+	}
+	return nil
+}
+
+// truncateEntry keeps a malformed entry out of error messages beyond its
+// first characters, since it may be key material.
+func truncateEntry(entry string) string {
+	if len(entry) > 8 {
+		return entry[:8] + "..."
+	}
+	return entry
+}
+
+// ActiveKeyID returns the ID of the key new values are sealed under, or ""
+// for a nil keyring.
+func (k *Keyring) ActiveKeyID() string {
+	if k == nil {
+		return ""
+	}
+	return k.active
+}
+
+// SealedStringPrefix returns the prefix of text values sealed under keyID.
+func SealedStringPrefix(keyID string) string {
+	return sealedStringPrefix + keyID + ":"
+}
+
+type envelope struct {
+	KeyID      string `json:"key_id"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// SealJSON encrypts doc for field under the active key and returns its
+// envelope. Empty documents and a nil keyring return doc unchanged.
+func (k *Keyring) SealJSON(field string, doc json.RawMessage) (json.RawMessage, error) {
+	if k == nil || len(doc) == 0 {
+		return doc, nil
+	}
+	sealed, err := k.ciphers[k.active].Seal(field, doc)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]envelope{EnvelopeKey: {KeyID: k.active, Ciphertext: sealed}})
+}
+
+// OpenJSON returns the document SealJSON sealed for field, or doc itself when
+// it is not an envelope.
+func (k *Keyring) OpenJSON(field string, doc json.RawMessage) (json.RawMessage, error) {
+	env, ok := parseEnvelope(doc)
+	if !ok {
+		return doc, nil
+	}
+	return k.open(field, env.KeyID, env.Ciphertext)
+}
+
+// parseEnvelope reports whether doc is exactly a sealed JSON envelope.
+func parseEnvelope(doc json.RawMessage) (envelope, bool) {
+	if !bytes.Contains(doc, []byte(EnvelopeKey)) {
+		return envelope{}, false
+	}
+	var wrapper map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &wrapper); err != nil || len(wrapper) != 1 {
+		return envelope{}, false
+	}
+	raw, ok := wrapper[EnvelopeKey]
+	if !ok {
+		return envelope{}, false
+	}
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil || env.KeyID == "" {
+		return envelope{}, false
+	}
+	return env, true
+}
+
+// SealString encrypts s for field under the active key. An empty s and a nil
+// keyring return s unchanged.
+func (k *Keyring) SealString(field, s string) (string, error) {
+	if k == nil || s == "" {
+		return s, nil
+	}
+	sealed, err := k.ciphers[k.active].Seal(field, []byte(s))
+	if err != nil {
+		return "", err
+	}
+	return SealedStringPrefix(k.active) + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenString returns the value SealString sealed for field, or s itself when
+// it was not sealed.
+func (k *Keyring) OpenString(field, s string) (string, error) {
+	rest, ok := strings.CutPrefix(s, sealedStringPrefix)
+	if !ok {
+		return s, nil
+	}
+	keyID, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", ErrUndecryptable
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrUndecryptable
+	}
+	value, err := k.open(field, keyID, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func (k *Keyring) open(field, keyID string, sealed []byte) ([]byte, error) {
+	if k == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	c, ok := k.ciphers[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	return c.Open(field, sealed)
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package secrets
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, raw string) *Keyring {
+	t.Helper()
+	k, err := ParseKeyring(raw)
+	if err != nil {
+		t.Fatalf("ParseKeyring: %v", err)
+	}
+	return k
+}
+
+func TestParseKeyring(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, KeySize))
+	k := testKeyring(t, " new:"+key+", old:"+key)
+	if k.ActiveKeyID() != "new" {
+		t.Fatalf("ActiveKeyID = %q, want the first key", k.ActiveKeyID())
+	}
+	for _, raw := range []string{"", key, "a:" + key + ",a:" + key, "bad id:" + key, "k:short"} {
+		if _, err := ParseKeyring(raw); err == nil {
+			t.Fatalf("%q: expected an error", raw)
+		}
+	}
+	if _, err := ParseKeyring(key[:20] + "secret-material"); err == nil || strings.Contains(err.Error(), "secret-material") {
+		t.Fatalf("expected an error without the key material, got %v", err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey := base64.StdEncoding.EncodeToString(make([]byte, KeySize))
+	newKey := base64.StdEncoding.EncodeToString(append(make([]byte, KeySize-1), 1))
+	before := testKeyring(t, "k1:"+oldKey)
+	after := testKeyring(t, "k2:"+newKey+",k1:"+oldKey)
+
+	doc, err := before.SealJSON(FieldStepOutput, []byte(`{"answer":42}`))
+	if err != nil {
+		t.Fatalf("SealJSON: %v", err)
+	}
+	if strings.Contains(string(doc), "answer") || !strings.Contains(string(doc), `"key_id":"k1"`) {
+		t.Fatalf("sealed document = %s", doc)
+	}
+	if got, err := after.OpenJSON(FieldStepOutput, doc); err != nil || string(got) != `{"answer":42}` {
+		t.Fatalf("OpenJSON after rotation = %s, %v", got, err)
+	}
+	if _, err := after.OpenJSON(FieldStepInput, doc); !errors.Is(err, ErrUndecryptable) {
+		t.Fatalf("OpenJSON for another field: err = %v", err)
+	}
+	if _, err := testKeyring(t, "k2:"+newKey).OpenJSON(FieldStepOutput, doc); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("OpenJSON without the old key: err = %v", err)
+	}
+
+	secret, err := after.SealString(FieldWebhookSecret, "whsec")
+	if err != nil || !strings.HasPrefix(secret, SealedStringPrefix("k2")) {
+		t.Fatalf("SealString = %q, %v", secret, err)
+	}
+	if got, err := after.OpenString(FieldWebhookSecret, secret); err != nil || got != "whsec" {
+		t.Fatalf("OpenString = %q, %v", got, err)
+	}
+}
+
+func TestNilKeyringPassesPlaintextThrough(t *testing.T) {
+	var k *Keyring
+	if got, err := k.SealJSON(FieldStepInput, []byte(`{"a":1}`)); err != nil || string(got) != `{"a":1}` {
+		t.Fatalf("SealJSON = %s, %v", got, err)
+	}
+	if got, err := k.OpenString(FieldWebhookSecret, "plain"); err != nil || got != "plain" {
+		t.Fatalf("OpenString = %q, %v", got, err)
+	}
+	sealed, _ := testKeyring(t, "k1:"+base64.StdEncoding.EncodeToString(make([]byte, KeySize))).SealString(FieldWebhookSecret, "x")
+	if _, err := k.OpenString(FieldWebhookSecret, sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("OpenString of a sealed value: err = %v", err)
+	}
+}
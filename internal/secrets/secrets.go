@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package secrets seals the values of named secrets for the secrets table and
+// masks them in what a step leaves behind. Values are encrypted with AES-256
+// GCM under SECRETS_KEY; the secret's name is authenticated with each value,
+// so a ciphertext copied onto another row does not open.
+//
+// Keyring applies the same encryption to sensitive columns, such as step
+// input and output and webhook secrets, under the keys of ENCRYPTION_KEYS.
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the length of SECRETS_KEY once base64-decoded.
+const KeySize = 32
+
+// Masked replaces secret values in step output, errors, and logs.
+const Masked = "***"
+
+// ErrUndecryptable is returned by Open for a ciphertext that was not sealed
+// under this key for this name.
+var ErrUndecryptable = errors.New("secret cannot be decrypted with the configured key")
+
+// ParseKey decodes a base64 SECRETS_KEY, which must be KeySize bytes.
+func ParseKey(raw string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("must be base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("must decode to %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// Cipher seals and opens secret values. It is safe for concurrent use.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher returns a Cipher for a KeySize key.
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("secrets key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal encrypts value for the secret called name. The result is the random
+// nonce followed by the ciphertext.
+func (c *Cipher) Seal(name string, value []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, value, []byte(name)), nil
+}
+
+// Open decrypts what Seal returned for the secret called name.
+func (c *Cipher) Open(name string, sealed []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, ErrUndecryptable
+	}
+	value, err := c.aead.Open(nil, sealed[:n], sealed[n:], []byte(name))
+	if err != nil {
+		return nil, ErrUndecryptable
+	}
+	return value, nil
+}
+
+// Mask replaces every occurrence of the values in s with Masked.
+func Mask(s string, values []string) string {
+	for _, v := range values {
+		if v != "" {
+			s = strings.ReplaceAll(s, v, Masked)
+		}
+	}
+	return s
+}
+
+// MaskJSON masks the values in every string of a JSON document, keys
+// included. Numbers and the document's shape are kept; a document that does
+// not parse is returned as a masked JSON string.
+func MaskJSON(doc json.RawMessage, values []string) json.RawMessage {
+	if len(doc) == 0 || len(values) == 0 {
+		return doc
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		out, _ := json.Marshal(Mask(string(doc), values))
+		return out
+	}
+	out, err := json.Marshal(maskValue(v, values))
+	if err != nil {
+		out, _ = json.Marshal(Mask(string(doc), values))
+	}
+	return out
+}
+
+func maskValue(v any, values []string) any {
+	switch v := v.(type) {
+	case string:
+		return Mask(v, values)
+	case []any:
+		for i := range v {
+			v[i] = maskValue(v[i], values)
+		}
+		return v
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[Mask(k, values)] = maskValue(item, values)
+		}
+		return out
+	}
+	return v
+}
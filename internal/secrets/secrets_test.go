@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package secrets
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	key := make([]byte, KeySize)
+	if got, err := ParseKey(base64.StdEncoding.EncodeToString(key)); err != nil || len(got) != KeySize {
+		t.Fatalf("ParseKey = %d bytes, %v", len(got), err)
+	}
+	for _, raw := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(key[:16])} {
+		if _, err := ParseKey(raw); err == nil {
+			t.Fatalf("%q: expected an error", raw)
+		}
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	c, err := NewCipher(make([]byte, KeySize))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	sealed, err := c.Seal("API_TOKEN", []byte("value"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if got, err := c.Open("API_TOKEN", sealed); err != nil || string(got) != "value" {
+		t.Fatalf("Open = %q, %v", got, err)
+	}
+	if _, err := c.Open("OTHER", sealed); !errors.Is(err, ErrUndecryptable) {
+		t.Fatalf("Open under another name: err = %v", err)
+	}
+
+	other, _ := NewCipher(append(make([]byte, KeySize-1), 1))
+	if _, err := other.Open("API_TOKEN", sealed); !errors.Is(err, ErrUndecryptable) {
+		t.Fatalf("Open under another key: err = %v", err)
+	}
+	if again, _ := c.Seal("API_TOKEN", []byte("value")); string(again) == string(sealed) {
+		t.Fatal("expected a fresh nonce for every Seal")
+	}
+}
+
+func TestMaskJSON(t *testing.T) {
+	values := []string{`p"w`, "tok"}
+	got := MaskJSON([]byte(`{"a":"x p\"w y","tok":[1,"tok"],"n":12345678901234567890}`), values)
+	if string(got) != `{"***":[1,"***"],"a":"x *** y","n":12345678901234567890}` {
+		t.Fatalf("MaskJSON = %s", got)
+	}
+	if got := MaskJSON([]byte(`not json tok`), values); string(got) != `"not json ***"` {
+		t.Fatalf("MaskJSON of invalid JSON = %s", got)
+	}
+	if got := MaskJSON(nil, values); got != nil {
+		t.Fatalf("MaskJSON(nil) = %s", got)
+	}
+}
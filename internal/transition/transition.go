@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transition guards run and step status updates with the domain state
+// machine. Callers lock the row, check the change, and only then write it; a
+// rejected change is logged and counted as an anomaly so drift between the
+// SQL paths and the state machine shows up on dashboards instead of in data.
+package transition
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// LockRun reads the run's status and locks the row until tx ends.
+func LockRun(ctx context.Context, tx pgx.Tx, runID uuid.UUID) (domain.RunStatus, error) {
+	var status domain.RunStatus
+	err := tx.QueryRow(ctx, `SELECT status FROM runs WHERE id=$1 FOR UPDATE`, runID).Scan(&status)
+	return status, err
+}
+
+// LockStep reads the step's status and locks the row until tx ends.
+func LockStep(ctx context.Context, tx pgx.Tx, stepID uuid.UUID) (domain.StepStatus, error) {
+	var status domain.StepStatus
+	err := tx.QueryRow(ctx, `SELECT status FROM steps WHERE id=$1 FOR UPDATE`, stepID).Scan(&status)
+	return status, err
+}
+
+// Run checks a run status change and reports it when it is not allowed.
+func Run(logger *slog.Logger, runID uuid.UUID, from, to domain.RunStatus) error {
+	err := domain.CheckRunTransition(from, to)
+	report(logger, "run_id", runID, err)
+	return err
+}
+
+// Step checks a step status change and reports it when it is not allowed.
+func Step(logger *slog.Logger, stepID uuid.UUID, from, to domain.StepStatus) error {
+	err := domain.CheckStepTransition(from, to)
+	report(logger, "step_id", stepID, err)
+	return err
+}
+
+func report(logger *slog.Logger, idKey string, id uuid.UUID, err error) {
+	var transitionErr *domain.TransitionError
+	if !errors.As(err, &transitionErr) {
+		return
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	metrics.IncTransitionAnomaly(transitionErr.Entity, transitionErr.From, transitionErr.To)
+	logger.Warn("state transition anomaly",
+		idKey, id,
+		"entity", transitionErr.Entity,
+		"from", transitionErr.From,
+		"to", transitionErr.To,
+	)
+}
@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package httptransport
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// encoder is what gzip.Writer and zlib.Writer have in common.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	"gzip":    {New: func() any { return gzip.NewWriter(io.Discard) }},
+	"deflate": {New: func() any { return zlib.NewWriter(io.Discard) }},
+}
+
+// compressMiddleware gzips or deflates JSON responses for clients that accept
+// it, which shrinks run, step, event, and usage listings several times over.
+// Event streams and every other content type pass through untouched, so SSE
+// still reaches the client event by event.
+func compressMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cw := &compressWriter{ResponseWriter: w}
+			if r.Method != http.MethodHead {
+				cw.encoding = negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip on a tie, or returns "" when the client accepts neither.
+func negotiateEncoding(header string) string {
+	gzipQ, deflateQ, anyQ := -1.0, -1.0, -1.0
+	for part := range strings.SplitSeq(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "deflate":
+			deflateQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ < 0 {
+		gzipQ = anyQ
+	}
+	if deflateQ < 0 {
+		deflateQ = anyQ
+	}
+	switch {
+	case gzipQ > 0 && gzipQ >= deflateQ:
+		return "gzip"
+	case deflateQ > 0:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressWriter decides when the header is written: JSON responses with a
+// body are encoded, anything else is written as is.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	wroteHeader bool
+	enc         encoder
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if !c.wroteHeader && code >= http.StatusOK {
+		c.wroteHeader = true
+		c.start(code)
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *compressWriter) start(code int) {
+	h := c.Header()
+	mediaType, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	if !strings.EqualFold(strings.TrimSpace(mediaType), "application/json") {
+		return
+	}
+	h.Add("Vary", "Accept-Encoding")
+	if c.encoding == "" || code == http.StatusNoContent || code == http.StatusNotModified || h.Get("Content-Encoding") != "" {
+		return
+	}
+	h.Set("Content-Encoding", c.encoding)
+	h.Del("Content-Length")
+	c.enc = encoderPools[c.encoding].Get().(encoder)
+	c.enc.Reset(c.ResponseWriter)
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.enc == nil {
+		return c.ResponseWriter.Write(p)
+	}
+	return c.enc.Write(p)
+}
+
+func (c *compressWriter) Flush() {
+	if c.enc != nil {
+		_ = c.enc.Flush()
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// close finishes the encoded body and returns the encoder to its pool.
+func (c *compressWriter) close() {
+	if c.enc == nil {
+		return
+	}
+	_ = c.enc.Close()
+	c.enc.Reset(io.Discard)
+	encoderPools[c.encoding].Put(c.enc)
+	c.enc = nil
+}
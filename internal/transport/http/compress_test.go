@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package httptransport
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                         "",
+		"gzip, deflate, br":        "gzip",
+		"deflate":                  "deflate",
+		"gzip;q=0.5, deflate":      "deflate",
+		"gzip;q=0, deflate;q=0":    "",
+		"*":                        "gzip",
+		"*;q=0.2, gzip;q=0":        "deflate",
+		"identity, br":             "",
+		"GZIP ; q=0.8, deflate;q=": "gzip",
+	}
+	for header, want := range tests {
+		if got := negotiateEncoding(header); got != want {
+			t.Fatalf("%q: expected %q got %q", header, want, got)
+		}
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	body := `{"runs":[` + strings.Repeat(`{"status":"SUCCEEDED"},`, 200) + `{}]}`
+	h := compressMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/events":
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "data: {}\n\n")
+			w.(http.Flusher).Flush()
+		case "/missing":
+			http.Error(w, "run not found", http.StatusNotFound)
+		default:
+			writeJSON(w, http.StatusOK, map[string]any{"raw": body})
+		}
+	}))
+
+	serve := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	plain := serve("/runs", "")
+	if plain.Header().Get("Content-Encoding") != "" || plain.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected an uncompressed JSON response varying on Accept-Encoding, got %v", plain.Header())
+	}
+
+	for encoding, open := range map[string]func(io.Reader) (io.ReadCloser, error){
+		"gzip":    func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		"deflate": zlib.NewReader,
+	} {
+		rec := serve("/runs", encoding)
+		if got := rec.Header().Get("Content-Encoding"); got != encoding {
+			t.Fatalf("expected %s, got %q", encoding, got)
+		}
+		if rec.Body.Len() >= plain.Body.Len() {
+			t.Fatalf("%s: expected a smaller body, got %d bytes for %d", encoding, rec.Body.Len(), plain.Body.Len())
+		}
+		r, err := open(rec.Body)
+		if err != nil {
+			t.Fatalf("%s: open: %v", encoding, err)
+		}
+		decoded, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: read: %v", encoding, err)
+		}
+		if string(decoded) != plain.Body.String() {
+			t.Fatalf("%s: decoded body differs from the plain one", encoding)
+		}
+	}
+
+	for _, path := range []string{"/events", "/missing"} {
+		rec := serve(path, "gzip")
+		if rec.Header().Get("Content-Encoding") != "" || rec.Header().Get("Vary") != "" {
+			t.Fatalf("%s: expected the response passed through, got %v", path, rec.Header())
+		}
+	}
+	if rec := serve("/events", "gzip"); rec.Body.String() != "data: {}\n\n" || !rec.Flushed {
+		t.Fatalf("expected the event stream flushed as is, got %q", rec.Body.String())
+	}
+}
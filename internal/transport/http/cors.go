@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package httptransport
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig lets browser apps on AllowedOrigins call the API. An origin of
+// "*" allows any origin. Requests authenticate with a Bearer token, not
+// cookies, so credentials are never allowed.
+type CORSConfig struct {
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	MaxAge         time.Duration
+}
+
+// corsExposedHeaders are the response headers browser code may read.
+var corsExposedHeaders = strings.Join([]string{
+	headerRequestID,
+	"Retry-After",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+	"X-API-Key-Expires-At",
+	"Warning",
+	"ETag",
+}, ", ")
+
+// corsMiddleware answers preflight requests and adds CORS headers to
+// responses for allowed origins. A preflight from another origin gets 403;
+// other cross-origin requests are served without CORS headers, so the
+// browser keeps their responses from the page.
+func corsMiddleware(cfg CORSConfig) func(http.Handler) http.Handler {
+	allowAny := slices.Contains(cfg.AllowedOrigins, "*")
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge / time.Second))
+
+	allowed := func(origin string) bool {
+		if allowAny {
+			return true
+		}
+		return slices.ContainsFunc(cfg.AllowedOrigins, func(o string) bool {
+			return strings.EqualFold(o, origin)
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if preflight {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			if !allowed(origin) {
+				if preflight {
+					http.Error(w, "origin not allowed", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if allowAny {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if !preflight {
+				h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", headers)
+			if cfg.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
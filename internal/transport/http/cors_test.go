@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package httptransport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func corsTestRouter(origins ...string) http.Handler {
+	return NewRouter(Deps{
+		RunRepo:  &mockRunRepo{createRunID: uuid.New()},
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+		CORS: &CORSConfig{
+			AllowedOrigins: origins,
+			AllowedMethods: []string{"GET", "POST"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "Last-Event-ID"},
+			MaxAge:         10 * time.Minute,
+		},
+	})
+}
+
+func TestCORSPreflight(t *testing.T) {
+	router := corsTestRouter("https://dash.example.com")
+
+	for _, path := range []string{"/runs", "/runs/" + uuid.NewString() + "/events"} {
+		req := httptest.NewRequest(http.MethodOptions, path, nil)
+		req.Header.Set("Origin", "https://dash.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		req.Header.Set("Access-Control-Request-Headers", "authorization")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("%s: expected status 204 got %d", path, rec.Code)
+		}
+		h := rec.Header()
+		if got := h.Get("Access-Control-Allow-Origin"); got != "https://dash.example.com" {
+			t.Fatalf("%s: expected origin echoed, got %q", path, got)
+		}
+		if got := h.Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+			t.Fatalf("%s: unexpected allowed methods %q", path, got)
+		}
+		if got := h.Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Authorization") {
+			t.Fatalf("%s: unexpected allowed headers %q", path, got)
+		}
+		if got := h.Get("Access-Control-Max-Age"); got != "600" {
+			t.Fatalf("%s: expected max age 600, got %q", path, got)
+		}
+	}
+}
+
+func TestCORSRejectsPreflightFromUnknownOrigin(t *testing.T) {
+	router := corsTestRouter("https://dash.example.com")
+
+	req := httptest.NewRequest(http.MethodOptions, "/runs", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403 got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("expected no allowed origin, got %q", got)
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	router := corsTestRouter("*")
+
+	req := httptest.NewRequest(http.MethodPost, "/runs", nil)
+	req.Header.Set("Origin", "https://anywhere.example.com")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("expected wildcard origin, got %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(got, headerRequestID) {
+		t.Fatalf("expected %s to be exposed, got %q", headerRequestID, got)
+	}
+
+	// Same-origin and non-browser requests carry no Origin and are untouched.
+	req = httptest.NewRequest(http.MethodPost, "/runs", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("expected no CORS headers without Origin, got %q", got)
+	}
+}
@@ -4,6 +4,8 @@ package httptransport
 
 import (
 	"context"
+	"encoding/json"
+	"time"
 
 	"github.com/adiadia/agent-runtime/internal/auth"
 	"github.com/adiadia/agent-runtime/internal/domain"
@@ -11,27 +13,109 @@ import (
 )
 
 type RunCreator interface {
-	CreateRun(ctx context.Context, params domain.CreateRunParams) (uuid.UUID, error)
+	SubmitRun(ctx context.Context, params domain.CreateRunParams) (domain.CreatedRun, error)
+	ReplayRun(ctx context.Context, sourceID uuid.UUID, stepInputs map[int]json.RawMessage) (domain.CreatedRun, error)
 	GetRun(ctx context.Context, id uuid.UUID) (domain.RunStatus, error)
+	ListRuns(ctx context.Context, filter domain.RunFilter) ([]domain.RunListItem, error)
 	GetRunCost(ctx context.Context, id uuid.UUID) (domain.RunCostBreakdown, error)
-	CancelRun(ctx context.Context, id uuid.UUID) error
+	CancelRun(ctx context.Context, id uuid.UUID, reason string) error
+	CancelRunAsAdmin(ctx context.Context, id uuid.UUID, reason string) error
+	AdminListRuns(ctx context.Context, apiKeyID *uuid.UUID, filter domain.RunFilter) ([]domain.AdminRunListItem, error)
+	AdminGetRun(ctx context.Context, id uuid.UUID) (domain.RunDetail, error)
 	ApproveRun(ctx context.Context, id uuid.UUID) error
+	ApproveStep(ctx context.Context, runID, stepID uuid.UUID) error
+	RejectStep(ctx context.Context, runID, stepID uuid.UUID) error
+	ListPendingApprovals(ctx context.Context, runID uuid.UUID) ([]domain.ApprovalGate, error)
 }
 
 type StepLister interface {
 	ListSteps(ctx context.Context, runID uuid.UUID) ([]domain.StepRecord, error)
 }
 
+// RunVersionReader reports what changes with a run or its steps, for ETags.
+type RunVersionReader interface {
+	GetRunVersion(ctx context.Context, runID uuid.UUID) (domain.RunVersion, error)
+}
+
+type TimelineReader interface {
+	GetRunTimeline(ctx context.Context, runID uuid.UUID) (domain.RunTimeline, error)
+}
+
+type RunExporter interface {
+	ExportRun(ctx context.Context, runID uuid.UUID) (domain.RunExport, error)
+	CreateExportJob(ctx context.Context, format string) (domain.ExportJob, error)
+	GetExportJob(ctx context.Context, id uuid.UUID) (domain.ExportJob, error)
+	GetExportArtifact(ctx context.Context, id uuid.UUID) (domain.ExportJob, []byte, error)
+}
+
+type TemplateManager interface {
+	PutWorkflowTemplate(ctx context.Context, name string, steps []domain.WorkflowTemplateStep) (domain.WorkflowTemplateVersion, error)
+	ListWorkflowTemplateVersions(ctx context.Context, name string) ([]domain.WorkflowTemplateVersion, error)
+}
+
+type SecretManager interface {
+	PutSecret(ctx context.Context, name, value string) (domain.Secret, error)
+	ListSecrets(ctx context.Context) ([]domain.Secret, error)
+	DeleteSecret(ctx context.Context, name string) error
+}
+
+type StepLogReader interface {
+	ListStepLogs(ctx context.Context, runID, stepID uuid.UUID, afterSeq int64, limit int) (domain.StepLogPage, error)
+}
+
 type APIKeyResolver interface {
 	ResolveAPIKey(ctx context.Context, bearerToken string) (auth.APIKey, bool, error)
 }
 
 type APIKeyManager interface {
 	CreateAPIKey(ctx context.Context, params domain.CreateAPIKeyParams) (domain.CreatedAPIKey, error)
-	ListAPIKeys(ctx context.Context) ([]domain.APIKeyRecord, error)
+	ListAPIKeys(ctx context.Context, filter domain.APIKeyFilter) ([]domain.APIKeyRecord, error)
+	GetAPIKey(ctx context.Context, id uuid.UUID) (domain.APIKeyRecord, error)
+	GetAPIKeyIDBySlug(ctx context.Context, slug string) (uuid.UUID, error)
+	SetEventRetentionDays(ctx context.Context, id uuid.UUID, days *int) error
+	SetRunRetentionDays(ctx context.Context, id uuid.UUID, days *int) error
+	SetMonthlyBudget(ctx context.Context, id uuid.UUID, usd *float64) (domain.MonthlyBudget, error)
+	SetSchedulingWeight(ctx context.Context, id uuid.UUID, weight int) error
+	SetTemplateRunLimits(ctx context.Context, id uuid.UUID, limits map[string]int) (map[string]int, error)
+	ListTenantScheduling(ctx context.Context) ([]domain.TenantScheduling, error)
+	SetScopes(ctx context.Context, id uuid.UUID, scopes []string) ([]string, error)
+	SetAllowedCIDRs(ctx context.Context, id uuid.UUID, cidrs []string) ([]string, error)
+	SetEgressAllowHosts(ctx context.Context, id uuid.UUID, hosts []string) ([]string, error)
+	SetSlug(ctx context.Context, id uuid.UUID, slug string) error
+	SetWebhookDefaults(ctx context.Context, id uuid.UUID, params domain.SetWebhookDefaultsParams) (domain.WebhookDefaults, error)
+	RotateWebhookSigningKey(ctx context.Context, id uuid.UUID, overlap time.Duration) (domain.WebhookSigningKey, error)
+	ListWebhookSigningKeys(ctx context.Context, id uuid.UUID) ([]domain.WebhookSigningKey, error)
+	ExpireWebhookSigningKey(ctx context.Context, id uuid.UUID, version int) error
 	RevokeAPIKey(ctx context.Context, id uuid.UUID) error
 }
 
+type RunStatsReader interface {
+	ListDailyRunStats(ctx context.Context, apiKeyID uuid.UUID, from, to time.Time) ([]domain.DailyRunStats, error)
+}
+
+type TokenUsageReader interface {
+	ListDailyTokenUsage(ctx context.Context, apiKeyID uuid.UUID, from, to time.Time) ([]domain.TokenUsage, error)
+}
+
+type WorkerRegistry interface {
+	ListActiveWorkers(ctx context.Context, apiKeyID uuid.UUID) ([]domain.WorkerRecord, error)
+	ListAllActiveWorkers(ctx context.Context) ([]domain.WorkerRecord, error)
+	GetWorkerLiveness(ctx context.Context) (domain.WorkerLiveness, error)
+}
+
+type TenantPurger interface {
+	PurgeTenant(ctx context.Context, apiKeyID uuid.UUID, signingKey []byte) (domain.SignedPurgeReport, error)
+}
+
+type AuditLogReader interface {
+	ListAuditEntries(ctx context.Context, filter domain.AuditFilter) ([]domain.AuditEntry, error)
+}
+
+type WebhookDeliveryManager interface {
+	ListWebhookDeliveries(ctx context.Context, runID uuid.UUID) ([]domain.WebhookDeliveryRecord, error)
+	RedeliverWebhook(ctx context.Context, deliveryID uuid.UUID) (domain.WebhookDeliveryRecord, error)
+}
+
 type EventStreamer interface {
 	ListEventsAfter(ctx context.Context, runID uuid.UUID, afterSeq int64) ([]domain.EventRecord, error)
 	ResolveCursorByEventID(ctx context.Context, runID uuid.UUID, eventID uuid.UUID) (int64, error)
@@ -40,3 +124,9 @@ type EventStreamer interface {
 type HealthChecker interface {
 	Check(ctx context.Context) error
 }
+
+// readinessDetail is implemented by health check errors that carry a
+// structured explanation, such as a schema drift diff, for /readyz.
+type readinessDetail interface {
+	ReadinessDetail() any
+}
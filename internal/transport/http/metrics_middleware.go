@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package httptransport
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/go-chi/chi/v5"
+)
+
+// unmatchedRoute labels requests that matched no route, so unknown paths do
+// not create a label value each.
+const unmatchedRoute = "unmatched"
+
+// httpMetricsMiddleware records request counts by status, latency, and the
+// in-flight gauge. Requests are labeled by chi route pattern (for example
+// /runs/{id}) rather than raw path to keep label cardinality bounded.
+func httpMetricsMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			metrics.AddHTTPInFlight(1)
+			defer metrics.AddHTTPInFlight(-1)
+
+			rec := &statusRecorder{
+				ResponseWriter: w,
+				status:         http.StatusOK,
+			}
+			next.ServeHTTP(rec, r)
+
+			metrics.ObserveHTTPRequest(routePattern(r), r.Method, rec.status, time.Since(start))
+		})
+	}
+}
+
+// routePattern returns the chi pattern the request was routed to. It must be
+// called after the router has served the request.
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return unmatchedRoute
+	}
+	if pattern := rctx.RoutePattern(); pattern != "" {
+		return pattern
+	}
+	return unmatchedRoute
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package httptransport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestHTTPMetricsMiddlewareLabelsByRoutePattern(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	before := httpRequestsCount(t, "/runs/{id}", http.MethodGet, "400")
+	unmatchedBefore := httpRequestsCount(t, unmatchedRoute, http.MethodGet, "404")
+
+	for _, path := range []string{"/runs/not-a-uuid", "/runs/also-bad"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s got %d", path, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/no-such-route/"+uuid.NewString(), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 got %d", rec.Code)
+	}
+
+	if got := httpRequestsCount(t, "/runs/{id}", http.MethodGet, "400") - before; got != 2 {
+		t.Fatalf("expected 2 requests counted under /runs/{id}, got %v", got)
+	}
+	if got := httpRequestsCount(t, unmatchedRoute, http.MethodGet, "404") - unmatchedBefore; got != 1 {
+		t.Fatalf("expected 1 unmatched request counted, got %v", got)
+	}
+	if !hasMetric(t, "http_request_duration_seconds") || !hasMetric(t, "http_requests_in_flight") {
+		t.Fatal("expected duration histogram and in-flight gauge to be registered")
+	}
+}
+
+func httpRequestsCount(t *testing.T, route, method, status string) float64 {
+	t.Helper()
+	for _, family := range gatherMetrics(t) {
+		if family.GetName() != "http_requests_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, pair := range m.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			if labels["route"] == route && labels["method"] == method && labels["status"] == status {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func hasMetric(t *testing.T, name string) bool {
+	t.Helper()
+	for _, family := range gatherMetrics(t) {
+		if family.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func gatherMetrics(t *testing.T) []*dto.MetricFamily {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("gather metrics: %v", err)
+	}
+	return families
+}
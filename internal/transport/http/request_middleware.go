@@ -6,10 +6,14 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"strings"
 	"time"
 
+	"github.com/adiadia/agent-runtime/internal/audit"
 	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/transport/middleware"
 	"github.com/google/uuid"
 )
 
@@ -49,6 +53,11 @@ func (s *statusRecorder) Flush() {
 	flusher.Flush()
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
 func withRequestID(ctx context.Context, requestID string) context.Context {
 	return context.WithValue(ctx, ctxRequestIDKey, requestID)
 }
@@ -101,8 +110,62 @@ func requestLoggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handl
 			if apiKeyID, ok := auth.APIKeyIDFromContext(r.Context()); ok {
 				attrs = append(attrs, "api_key_id", apiKeyID)
 			}
+			if key, ok := auth.APIKeyFromContext(r.Context()); ok && key.Slug != "" {
+				attrs = append(attrs, "tenant", key.Slug)
+			}
 
 			logger.Info("request completed", attrs...)
 		})
 	}
 }
+
+// auditActorMiddleware stores the audit actor of the request: actorType, the
+// authenticated API key for tenant routes, the client address, and the
+// request ID. It must run after authentication.
+func auditActorMiddleware(actorType string, trustedProxies []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actor := domain.AuditActor{Type: actorType}
+			if actorType == domain.AuditActorAPIKey {
+				if apiKeyID, ok := auth.APIKeyIDFromContext(r.Context()); ok {
+					actor.ID = &apiKeyID
+				}
+			}
+			if addr, ok := middleware.ClientAddr(r, trustedProxies); ok {
+				actor.IP = addr.String()
+			}
+			actor.RequestID, _ = requestIDFromContext(r.Context())
+
+			next.ServeHTTP(w, r.WithContext(audit.WithActor(r.Context(), actor)))
+		})
+	}
+}
+
+// DefaultMaxRequestBodyBytes bounds request bodies when Deps does not set a
+// limit.
+const DefaultMaxRequestBodyBytes int64 = 1 << 20
+
+// maxBodyMiddleware rejects POST, PUT, and PATCH requests whose body is larger
+// than limit bytes with 413. A declared Content-Length over the limit is
+// rejected up front; otherwise the body is capped and decoding fails with an
+// *http.MaxBytesError once the limit is crossed.
+func maxBodyMiddleware(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch:
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > limit {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -3,11 +3,16 @@
 package httptransport
 
 import (
+	"bytes"
+	"encoding/json"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/google/uuid"
 )
 
 func TestRequestIDMiddlewareGeneratesAndPropagatesRequestID(t *testing.T) {
@@ -63,3 +68,32 @@ func TestRequestIDMiddlewarePreservesIncomingRequestID(t *testing.T) {
 		t.Fatalf("expected X-Request-Id req-fixed-id got %q", got)
 	}
 }
+
+func TestRequestLoggingMiddlewareLogsTenantSlug(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	key := auth.APIKey{ID: uuid.New(), Slug: "acme-prod"}
+
+	authenticate := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*r = *r.WithContext(auth.WithAPIKey(r.Context(), key))
+			next.ServeHTTP(w, r)
+		})
+	}
+	h := requestLoggingMiddleware(logger)(authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/runs/x", nil))
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decode log entry: %v", err)
+	}
+	if entry["tenant"] != "acme-prod" {
+		t.Fatalf("expected tenant acme-prod got %v", entry["tenant"])
+	}
+	if entry["api_key_id"] != key.ID.String() {
+		t.Fatalf("expected api_key_id %s got %v", key.ID, entry["api_key_id"])
+	}
+}
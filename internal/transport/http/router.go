@@ -9,48 +9,202 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
-	"net/url"
+	"net/netip"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/adiadia/agent-runtime/internal/clock"
 	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/egress"
+	"github.com/adiadia/agent-runtime/internal/export"
 	"github.com/adiadia/agent-runtime/internal/metrics"
 	"github.com/adiadia/agent-runtime/internal/transport/middleware"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const headerIdempotencyKey = "Idempotency-Key"
 
-type createRunRequest struct {
-	WebhookURL   string `json:"webhook_url"`
-	Priority     int    `json:"priority"`
-	TemplateName string `json:"template_name"`
+type createAPIKeyRequest struct {
+	Name               string     `json:"name"`
+	MaxConcurrentRuns  int        `json:"max_concurrent_runs"`
+	MaxRequestsPerMin  int        `json:"max_requests_per_min"`
+	EventRetentionDays *int       `json:"event_retention_days"`
+	RunRetentionDays   *int       `json:"run_retention_days"`
+	MonthlyBudgetUSD   *float64   `json:"monthly_budget_usd"`
+	Scopes             []string   `json:"scopes"`
+	Slug               string     `json:"slug"`
+	ExpiresAt          *time.Time `json:"expires_at"`
+	AllowedCIDRs       []string   `json:"allowed_cidrs"`
 }
 
-type createAPIKeyRequest struct {
-	Name              string `json:"name"`
-	MaxConcurrentRuns int    `json:"max_concurrent_runs"`
-	MaxRequestsPerMin int    `json:"max_requests_per_min"`
+type setScopesRequest struct {
+	Scopes []string `json:"scopes"`
+}
+
+type setAllowedCIDRsRequest struct {
+	AllowedCIDRs []string `json:"allowed_cidrs"`
+}
+
+type setEgressAllowHostsRequest struct {
+	EgressAllowHosts []string `json:"egress_allow_hosts"`
+}
+
+type rotateWebhookSigningKeyRequest struct {
+	OverlapSeconds *int `json:"overlap_seconds"`
+}
+
+type setSlugRequest struct {
+	Slug string `json:"slug"`
+}
+
+type setEventRetentionRequest struct {
+	EventRetentionDays *int `json:"event_retention_days"`
+}
+
+type setRunRetentionRequest struct {
+	RunRetentionDays *int `json:"run_retention_days"`
+}
+
+type setMonthlyBudgetRequest struct {
+	MonthlyBudgetUSD *float64 `json:"monthly_budget_usd"`
+}
+
+type setSchedulingWeightRequest struct {
+	SchedulingWeight *int `json:"scheduling_weight"`
+}
+
+type setTemplateRunLimitsRequest struct {
+	MaxConcurrentRunsPerTemplate map[string]int `json:"max_concurrent_runs_per_template"`
+}
+
+type cancelRunRequest struct {
+	Reason string `json:"reason"`
+}
+
+type setWebhookDefaultsRequest struct {
+	WebhookURL     string `json:"webhook_url"`
+	WebhookSecret  string `json:"webhook_secret"`
+	GenerateSecret bool   `json:"generate_secret"`
+}
+
+type eventRetentionPolicy struct {
+	APIKeyID                    uuid.UUID `json:"api_key_id"`
+	EventRetentionDays          *int      `json:"event_retention_days"`
+	DefaultEventRetentionDays   int       `json:"default_event_retention_days"`
+	EffectiveEventRetentionDays int       `json:"effective_event_retention_days"`
+}
+
+type runRetentionPolicy struct {
+	APIKeyID                  uuid.UUID `json:"api_key_id"`
+	RunRetentionDays          *int      `json:"run_retention_days"`
+	DefaultRunRetentionDays   int       `json:"default_run_retention_days"`
+	EffectiveRunRetentionDays int       `json:"effective_run_retention_days"`
+}
+
+type runStatsResponse struct {
+	APIKeyID      uuid.UUID              `json:"api_key_id"`
+	From          string                 `json:"from"`
+	To            string                 `json:"to"`
+	Totals        domain.DailyRunStats   `json:"totals"`
+	Days          []domain.DailyRunStats `json:"days"`
+	ActiveWorkers []domain.WorkerRecord  `json:"active_workers,omitempty"`
+	Warnings      []string               `json:"warnings,omitempty"`
+}
+
+type usageResponse struct {
+	From    string               `json:"from"`
+	To      string               `json:"to"`
+	Bucket  string               `json:"bucket"`
+	Totals  domain.DailyRunStats `json:"totals"`
+	Buckets []domain.UsageBucket `json:"buckets"`
+}
+
+const (
+	defaultStatsRangeDays = 30
+	maxStatsRangeDays     = 366
+)
+
+// DefaultSSEPollInterval is how often an event stream polls for new events.
+const DefaultSSEPollInterval = 500 * time.Millisecond
+
+// GET /runs/{id}/wait blocks for the requested timeout, defaultRunWaitTimeout
+// when none is given, and at most maxRunWaitTimeout.
+const (
+	defaultRunWaitTimeout = 30 * time.Second
+	maxRunWaitTimeout     = 60 * time.Second
+)
+
+// runWaitResponse is the result of a run wait: the run's status when it
+// became terminal, or when the wait timed out (Terminal false).
+type runWaitResponse struct {
+	ID       uuid.UUID        `json:"id"`
+	Status   domain.RunStatus `json:"status"`
+	Terminal bool             `json:"terminal"`
+}
+
+// sseShutdownEvent is the terminal SSE event sent when the server shuts down.
+// Clients should reconnect after ReconnectAfterMS with since_id=LastSeq.
+type sseShutdownEvent struct {
+	Reason           string `json:"reason"`
+	ReconnectAfterMS int64  `json:"reconnect_after_ms"`
+	LastSeq          int64  `json:"last_seq"`
 }
 
 type Deps struct {
-	RunRepo        RunCreator
-	StepRepo       StepLister
-	EventRepo      EventStreamer
-	APIKeyAdmin    APIKeyManager
-	Logger         *slog.Logger
-	HealthChecker  HealthChecker
-	APIKeyResolver APIKeyResolver
-	AdminToken     string
-	Version        string
-	Commit         string
-	BuildDate      string
+	RunRepo             RunCreator
+	StepRepo            StepLister
+	StepLogs            StepLogReader
+	Timeline            TimelineReader
+	RunVersions         RunVersionReader
+	EventRepo           EventStreamer
+	WebhookRepo         WebhookDeliveryManager
+	APIKeyAdmin         APIKeyManager
+	RunStats            RunStatsReader
+	TokenUsage          TokenUsageReader
+	Workers             WorkerRegistry
+	TenantPurger        TenantPurger
+	AuditLog            AuditLogReader
+	Exports             RunExporter
+	Templates           TemplateManager
+	Secrets             SecretManager
+	Logger              *slog.Logger
+	Clock               clock.Clock
+	HealthChecker       HealthChecker
+	APIKeyResolver      APIKeyResolver
+	AdminToken          string
+	EventRetentionDays  int
+	RunRetentionDays    int
+	APIKeyExpiryWarning time.Duration
+	TrustedProxies      []netip.Prefix
+	PurgeSigningKey     string
+	Streams             *Streams
+	SSEPollInterval     time.Duration
+	// MaxRequestBodyBytes bounds POST, PUT, and PATCH bodies; 0 means
+	// DefaultMaxRequestBodyBytes.
+	MaxRequestBodyBytes int64
+	// HandlerTimeout bounds non-streaming handlers; 0 means no limit.
+	HandlerTimeout time.Duration
+	// Compression gzips or deflates JSON responses for clients that accept it.
+	Compression bool
+	// CORS enables cross-origin requests from browsers; nil disables it.
+	CORS *CORSConfig
+	// WebhookTargets, when set, vets the webhook URLs of new runs and API
+	// key defaults: their host must only resolve to addresses it allows.
+	WebhookTargets *egress.Policy
+	// WebhookResolver resolves webhook hosts; nil means net.DefaultResolver.
+	WebhookResolver egress.Resolver
+	// AdminUI serves the admin dashboard at /ui.
+	AdminUI   bool
+	Version   string
+	Commit    string
+	BuildDate string
 }
 
 func NewRouter(deps Deps) http.Handler {
@@ -58,14 +212,38 @@ func NewRouter(deps Deps) http.Handler {
 	if logger == nil {
 		logger = slog.Default()
 	}
+	clk := clock.OrReal(deps.Clock)
 	metrics.Init()
 	version := valueOrDefault(deps.Version, "dev")
 	commit := valueOrDefault(deps.Commit, "none")
 	buildDate := valueOrDefault(deps.BuildDate, "unknown")
+	streams := deps.Streams
+	if streams == nil {
+		streams = NewStreams(0)
+	}
+	ssePollInterval := deps.SSEPollInterval
+	if ssePollInterval <= 0 {
+		ssePollInterval = DefaultSSEPollInterval
+	}
+	maxBodyBytes := deps.MaxRequestBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = DefaultMaxRequestBodyBytes
+	}
 
 	r := chi.NewRouter()
 	r.Use(requestIDMiddleware())
 	r.Use(requestLoggingMiddleware(logger))
+	r.Use(httpMetricsMiddleware())
+	if deps.CORS != nil {
+		r.Use(corsMiddleware(*deps.CORS))
+	}
+	r.Use(maxBodyMiddleware(maxBodyBytes))
+	if deps.Compression {
+		r.Use(compressMiddleware())
+	}
+	if deps.HandlerTimeout > 0 {
+		r.Use(handlerTimeoutMiddleware(deps.HandlerTimeout))
+	}
 
 	// ---------------- HEALTH ----------------
 
@@ -84,6 +262,24 @@ func NewRouter(deps Deps) http.Handler {
 		_, _ = w.Write([]byte("ok"))
 	})
 
+	r.Get("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if deps.HealthChecker != nil {
+			checkCtx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+			defer cancel()
+			if err := deps.HealthChecker.Check(checkCtx); err != nil {
+				logger.Warn("readiness check failed", "error", err)
+				resp := map[string]any{"status": "not_ready", "error": err.Error()}
+				var detail readinessDetail
+				if errors.As(err, &detail) {
+					resp["detail"] = detail.ReadinessDetail()
+				}
+				writeJSON(w, http.StatusServiceUnavailable, resp)
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+
 	// ---------------- METRICS ----------------
 
 	r.Get("/metrics", func(w http.ResponseWriter, r *http.Request) {
@@ -100,29 +296,101 @@ func NewRouter(deps Deps) http.Handler {
 		})
 	})
 
+	// ---------------- ADMIN UI ----------------
+
+	if deps.AdminUI {
+		r.Get("/ui", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/ui/", http.StatusMovedPermanently)
+		})
+		r.Handle("/ui/*", uiHandler())
+	}
+
 	// ---------------- API KEY LIFECYCLE (ADMIN) ----------------
 
+	// apiKeyRef resolves an admin path segment holding either a key ID or its
+	// slug, writing the error response when it cannot.
+	apiKeyRef := func(w http.ResponseWriter, r *http.Request, raw string) (uuid.UUID, bool) {
+		if id, err := uuid.Parse(raw); err == nil {
+			return id, true
+		}
+		if deps.APIKeyAdmin == nil || domain.ValidateAPIKeySlug(raw) != nil {
+			http.Error(w, "invalid api key ID", http.StatusBadRequest)
+			return uuid.Nil, false
+		}
+		id, err := deps.APIKeyAdmin.GetAPIKeyIDBySlug(r.Context(), raw)
+		if err != nil {
+			if errors.Is(err, domain.ErrAPIKeyNotFound) {
+				http.Error(w, "api key not found", http.StatusNotFound)
+				return uuid.Nil, false
+			}
+			logger.Error("resolve api key slug failed", "slug", raw, "error", err)
+			http.Error(w, "failed to resolve api key", http.StatusInternalServerError)
+			return uuid.Nil, false
+		}
+		return id, true
+	}
+
 	if deps.APIKeyAdmin != nil {
 		r.Route("/api-keys", func(admin chi.Router) {
 			admin.Use(middleware.AdminTokenAuth(deps.AdminToken, logger))
+			admin.Use(auditActorMiddleware(domain.AuditActorAdmin, deps.TrustedProxies))
 
 			admin.Post("/", func(w http.ResponseWriter, r *http.Request) {
 				reqBody, err := decodeCreateAPIKeyRequest(r)
 				if err != nil {
-					http.Error(w, "invalid request body", http.StatusBadRequest)
+					writeBodyError(w, err)
 					return
 				}
 
 				created, err := deps.APIKeyAdmin.CreateAPIKey(r.Context(), domain.CreateAPIKeyParams{
-					Name:              reqBody.Name,
-					MaxConcurrentRuns: reqBody.MaxConcurrentRuns,
-					MaxRequestsPerMin: reqBody.MaxRequestsPerMin,
+					Name:               reqBody.Name,
+					MaxConcurrentRuns:  reqBody.MaxConcurrentRuns,
+					MaxRequestsPerMin:  reqBody.MaxRequestsPerMin,
+					EventRetentionDays: reqBody.EventRetentionDays,
+					RunRetentionDays:   reqBody.RunRetentionDays,
+					MonthlyBudgetUSD:   reqBody.MonthlyBudgetUSD,
+					Scopes:             reqBody.Scopes,
+					Slug:               reqBody.Slug,
+					ExpiresAt:          reqBody.ExpiresAt,
+					AllowedCIDRs:       reqBody.AllowedCIDRs,
 				})
 				if err != nil {
 					if errors.Is(err, domain.ErrInvalidAPIKeyName) {
 						http.Error(w, "invalid api key name", http.StatusBadRequest)
 						return
 					}
+					if errors.Is(err, domain.ErrInvalidScope) {
+						http.Error(w, "invalid scopes", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrInvalidAPIKeySlug) {
+						http.Error(w, "invalid slug", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrAPIKeySlugTaken) {
+						http.Error(w, "slug already in use", http.StatusConflict)
+						return
+					}
+					if errors.Is(err, domain.ErrInvalidAPIKeyExpiry) {
+						http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrInvalidAllowedCIDR) {
+						http.Error(w, "invalid allowed_cidrs", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrInvalidEventRetention) {
+						http.Error(w, "invalid event_retention_days", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrInvalidRunRetention) {
+						http.Error(w, "invalid run_retention_days", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrInvalidMonthlyBudget) {
+						http.Error(w, "invalid monthly_budget_usd", http.StatusBadRequest)
+						return
+					}
 					logger.Error("create api key failed", "error", err)
 					http.Error(w, "failed to create api key", http.StatusInternalServerError)
 					return
@@ -135,181 +403,1522 @@ func NewRouter(deps Deps) http.Handler {
 			})
 
 			admin.Get("/", func(w http.ResponseWriter, r *http.Request) {
-				keys, err := deps.APIKeyAdmin.ListAPIKeys(r.Context())
+				filter, err := parseAPIKeyFilter(r)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				keys, err := deps.APIKeyAdmin.ListAPIKeys(r.Context(), filter)
+				if errors.Is(err, domain.ErrAPIKeyNotFound) {
+					http.Error(w, "invalid before", http.StatusBadRequest)
+					return
+				}
 				if err != nil {
 					logger.Error("list api keys failed", "error", err)
 					http.Error(w, "failed to list api keys", http.StatusInternalServerError)
 					return
 				}
-				writeJSON(w, http.StatusOK, map[string]any{
+				for i := range keys {
+					keys[i].EffectiveEventRetentionDays = domain.EffectiveEventRetentionDays(
+						keys[i].EventRetentionDays,
+						deps.EventRetentionDays,
+					)
+					keys[i].EffectiveRunRetentionDays = domain.EffectiveRunRetentionDays(
+						keys[i].RunRetentionDays,
+						deps.RunRetentionDays,
+					)
+				}
+				resp := map[string]any{
 					"api_keys": keys,
-				})
+				}
+				if len(keys) > 0 && len(keys) == filter.Limit {
+					resp["next_before"] = keys[len(keys)-1].ID
+				}
+				writeJSON(w, http.StatusOK, resp)
 			})
 
-			admin.Delete("/{id}", func(w http.ResponseWriter, r *http.Request) {
-				id, err := uuid.Parse(chi.URLParam(r, "id"))
-				if err != nil {
-					http.Error(w, "invalid api key ID", http.StatusBadRequest)
+			admin.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
 					return
 				}
 
-				if err := deps.APIKeyAdmin.RevokeAPIKey(r.Context(), id); err != nil {
-					if errors.Is(err, pgx.ErrNoRows) {
+				key, err := deps.APIKeyAdmin.GetAPIKey(r.Context(), id)
+				if err != nil {
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
 						http.Error(w, "api key not found", http.StatusNotFound)
 						return
 					}
-					logger.Error("delete api key failed", "api_key_id", id, "error", err)
-					http.Error(w, "failed to delete api key", http.StatusInternalServerError)
+					logger.Error("get api key failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to get api key", http.StatusInternalServerError)
 					return
 				}
 
-				w.WriteHeader(http.StatusNoContent)
+				key.EffectiveEventRetentionDays = domain.EffectiveEventRetentionDays(key.EventRetentionDays, deps.EventRetentionDays)
+				key.EffectiveRunRetentionDays = domain.EffectiveRunRetentionDays(key.RunRetentionDays, deps.RunRetentionDays)
+				writeJSON(w, http.StatusOK, key)
 			})
-		})
-	}
-
-	// ---------------- RUNS (API KEY AUTH) ----------------
-
-	r.Group(func(r chi.Router) {
-		if deps.APIKeyResolver != nil {
-			r.Use(middleware.APITokenAuth(deps.APIKeyResolver, logger))
-		}
-
-		// ---------------- CREATE RUN ----------------
 
-		r.Post("/runs", func(w http.ResponseWriter, r *http.Request) {
-			ctx := r.Context()
-			if key := strings.TrimSpace(r.Header.Get(headerIdempotencyKey)); key != "" {
-				ctx = auth.WithIdempotencyKey(ctx, key)
-			}
+			admin.Put("/{id}/retention", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
+					return
+				}
 
-			reqBody, err := decodeCreateRunRequest(r)
-			if err != nil {
-				http.Error(w, "invalid request body", http.StatusBadRequest)
-				return
-			}
+				var reqBody setEventRetentionRequest
+				if err := decodeJSONBody(r, &reqBody); err != nil {
+					writeBodyError(w, err)
+					return
+				}
 
-			runID, err := deps.RunRepo.CreateRun(ctx, domain.CreateRunParams{
-				WebhookURL:   reqBody.WebhookURL,
-				Priority:     reqBody.Priority,
-				TemplateName: reqBody.TemplateName,
-			})
-			if err != nil {
-				if errors.Is(err, domain.ErrMaxConcurrentRunsExceeded) {
-					if w.Header().Get("Retry-After") == "" {
-						w.Header().Set("Retry-After", "1")
+				if err := deps.APIKeyAdmin.SetEventRetentionDays(r.Context(), id, reqBody.EventRetentionDays); err != nil {
+					if errors.Is(err, domain.ErrInvalidEventRetention) {
+						http.Error(w, "invalid event_retention_days", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
+						http.Error(w, "api key not found", http.StatusNotFound)
+						return
 					}
-					http.Error(w, "max concurrent runs exceeded", http.StatusTooManyRequests)
+					logger.Error("set event retention failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to set event retention", http.StatusInternalServerError)
 					return
 				}
-				if errors.Is(err, domain.ErrWorkflowTemplateNotFound) {
-					http.Error(w, "workflow template not found", http.StatusBadRequest)
+
+				writeJSON(w, http.StatusOK, eventRetentionPolicy{
+					APIKeyID:                    id,
+					EventRetentionDays:          reqBody.EventRetentionDays,
+					DefaultEventRetentionDays:   deps.EventRetentionDays,
+					EffectiveEventRetentionDays: domain.EffectiveEventRetentionDays(reqBody.EventRetentionDays, deps.EventRetentionDays),
+				})
+			})
+
+			admin.Put("/{id}/run-retention", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
 					return
 				}
 
-				logger.Error("create run failed", "error", err)
-				http.Error(w, "failed to create run", http.StatusInternalServerError)
-				return
-			}
+				var reqBody setRunRetentionRequest
+				if err := decodeJSONBody(r, &reqBody); err != nil {
+					writeBodyError(w, err)
+					return
+				}
 
-			logger.Info("run created via API", "run_id", runID)
+				if err := deps.APIKeyAdmin.SetRunRetentionDays(r.Context(), id, reqBody.RunRetentionDays); err != nil {
+					if errors.Is(err, domain.ErrInvalidRunRetention) {
+						http.Error(w, "invalid run_retention_days", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
+						http.Error(w, "api key not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("set run retention failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to set run retention", http.StatusInternalServerError)
+					return
+				}
 
-			writeJSON(w, http.StatusOK, map[string]string{
-				"run_id": runID.String(),
+				writeJSON(w, http.StatusOK, runRetentionPolicy{
+					APIKeyID:                  id,
+					RunRetentionDays:          reqBody.RunRetentionDays,
+					DefaultRunRetentionDays:   deps.RunRetentionDays,
+					EffectiveRunRetentionDays: domain.EffectiveRunRetentionDays(reqBody.RunRetentionDays, deps.RunRetentionDays),
+				})
 			})
-		})
-
-		// ---------------- GET RUN COST ----------------
 
-		r.Get("/runs/{id}/cost", func(w http.ResponseWriter, r *http.Request) {
-			idStr := chi.URLParam(r, "id")
+			admin.Put("/{id}/budget", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
+					return
+				}
 
-			runID, err := uuid.Parse(idStr)
-			if err != nil {
-				http.Error(w, "invalid run ID", http.StatusBadRequest)
-				return
-			}
+				var reqBody setMonthlyBudgetRequest
+				if err := decodeJSONBody(r, &reqBody); err != nil {
+					writeBodyError(w, err)
+					return
+				}
 
-			breakdown, err := deps.RunRepo.GetRunCost(r.Context(), runID)
-			if err != nil {
-				if errors.Is(err, pgx.ErrNoRows) {
-					logger.Warn("run not found", "run_id", runID)
-					http.Error(w, "run not found", http.StatusNotFound)
+				monthly, err := deps.APIKeyAdmin.SetMonthlyBudget(r.Context(), id, reqBody.MonthlyBudgetUSD)
+				if err != nil {
+					if errors.Is(err, domain.ErrInvalidMonthlyBudget) {
+						http.Error(w, "invalid monthly_budget_usd", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
+						http.Error(w, "api key not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("set monthly budget failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to set monthly budget", http.StatusInternalServerError)
 					return
 				}
 
-				logger.Error("get run cost failed", "run_id", runID, "error", err)
-				http.Error(w, "failed to get run cost", http.StatusInternalServerError)
-				return
-			}
+				writeJSON(w, http.StatusOK, monthly)
+			})
 
-			writeJSON(w, http.StatusOK, breakdown)
-		})
+			admin.Put("/{id}/scheduling-weight", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
+					return
+				}
 
-		// ---------------- GET RUN ----------------
+				var reqBody setSchedulingWeightRequest
+				if err := decodeJSONBody(r, &reqBody); err != nil {
+					writeBodyError(w, err)
+					return
+				}
+				if reqBody.SchedulingWeight == nil {
+					http.Error(w, "scheduling_weight is required", http.StatusBadRequest)
+					return
+				}
 
-		r.Get("/runs/{id}", func(w http.ResponseWriter, r *http.Request) {
-			idStr := chi.URLParam(r, "id")
+				if err := deps.APIKeyAdmin.SetSchedulingWeight(r.Context(), id, *reqBody.SchedulingWeight); err != nil {
+					if errors.Is(err, domain.ErrInvalidSchedulingWeight) {
+						http.Error(w, "invalid scheduling_weight", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
+						http.Error(w, "api key not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("set scheduling weight failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to set scheduling weight", http.StatusInternalServerError)
+					return
+				}
 
-			runID, err := uuid.Parse(idStr)
-			if err != nil {
-				http.Error(w, "invalid run ID", http.StatusBadRequest)
-				return
-			}
+				writeJSON(w, http.StatusOK, map[string]any{
+					"api_key_id":        id,
+					"scheduling_weight": *reqBody.SchedulingWeight,
+				})
+			})
 
-			status, err := deps.RunRepo.GetRun(r.Context(), runID)
-			if err != nil {
-				if errors.Is(err, pgx.ErrNoRows) {
-					logger.Warn("run not found", "run_id", runID)
-					http.Error(w, "run not found", http.StatusNotFound)
+			admin.Put("/{id}/template-run-limits", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
 					return
 				}
 
-				logger.Error("get run failed", "run_id", runID, "error", err)
-				http.Error(w, "failed to get run", http.StatusInternalServerError)
-				return
-			}
+				var reqBody setTemplateRunLimitsRequest
+				if err := decodeJSONBody(r, &reqBody); err != nil {
+					writeBodyError(w, err)
+					return
+				}
 
-			writeJSON(w, http.StatusOK, map[string]string{
-				"id":     runID.String(),
-				"status": string(status), // convert domain type to string
-			})
-		})
+				limits, err := deps.APIKeyAdmin.SetTemplateRunLimits(r.Context(), id, reqBody.MaxConcurrentRunsPerTemplate)
+				if err != nil {
+					if errors.Is(err, domain.ErrInvalidTemplateRunLimit) {
+						http.Error(w, "invalid max_concurrent_runs_per_template", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
+						http.Error(w, "api key not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("set template run limits failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to set template run limits", http.StatusInternalServerError)
+					return
+				}
 
-		// ---------------- CANCEL RUN ----------------
+				writeJSON(w, http.StatusOK, map[string]any{
+					"api_key_id":                       id,
+					"max_concurrent_runs_per_template": limits,
+				})
+			})
 
-		r.Post("/runs/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
-			idStr := chi.URLParam(r, "id")
+			admin.Put("/{id}/scopes", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
+					return
+				}
 
-			runID, err := uuid.Parse(idStr)
-			if err != nil {
-				http.Error(w, "invalid run ID", http.StatusBadRequest)
-				return
-			}
+				var reqBody setScopesRequest
+				if err := decodeJSONBody(r, &reqBody); err != nil {
+					writeBodyError(w, err)
+					return
+				}
 
-			if err := deps.RunRepo.CancelRun(r.Context(), runID); err != nil {
-				if errors.Is(err, pgx.ErrNoRows) {
-					logger.Warn("run not found", "run_id", runID)
-					http.Error(w, "run not found", http.StatusNotFound)
+				scopes, err := deps.APIKeyAdmin.SetScopes(r.Context(), id, reqBody.Scopes)
+				if err != nil {
+					if errors.Is(err, domain.ErrInvalidScope) {
+						http.Error(w, "invalid scopes", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
+						http.Error(w, "api key not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("set api key scopes failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to set scopes", http.StatusInternalServerError)
 					return
 				}
 
-				logger.Error("cancel run failed", "run_id", runID, "error", err)
-				http.Error(w, "failed to cancel run", http.StatusInternalServerError)
-				return
-			}
+				writeJSON(w, http.StatusOK, map[string]any{
+					"api_key_id": id,
+					"scopes":     scopes,
+				})
+			})
 
-			logger.Info("run canceled via API", "run_id", runID)
+			admin.Put("/{id}/allowed-cidrs", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
+					return
+				}
 
-			writeJSON(w, http.StatusOK, map[string]string{
+				var reqBody setAllowedCIDRsRequest
+				if err := decodeJSONBody(r, &reqBody); err != nil {
+					writeBodyError(w, err)
+					return
+				}
+
+				cidrs, err := deps.APIKeyAdmin.SetAllowedCIDRs(r.Context(), id, reqBody.AllowedCIDRs)
+				if err != nil {
+					if errors.Is(err, domain.ErrInvalidAllowedCIDR) {
+						http.Error(w, "invalid allowed_cidrs", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
+						http.Error(w, "api key not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("set api key allowed cidrs failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to set allowed cidrs", http.StatusInternalServerError)
+					return
+				}
+
+				writeJSON(w, http.StatusOK, map[string]any{
+					"api_key_id":    id,
+					"allowed_cidrs": cidrs,
+				})
+			})
+
+			admin.Put("/{id}/egress-allow-hosts", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
+					return
+				}
+
+				var reqBody setEgressAllowHostsRequest
+				if err := decodeJSONBody(r, &reqBody); err != nil {
+					writeBodyError(w, err)
+					return
+				}
+
+				hosts, err := deps.APIKeyAdmin.SetEgressAllowHosts(r.Context(), id, reqBody.EgressAllowHosts)
+				if err != nil {
+					if errors.Is(err, domain.ErrInvalidEgressAllowHost) {
+						http.Error(w, "invalid egress_allow_hosts", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
+						http.Error(w, "api key not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("set api key egress allow hosts failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to set egress allow hosts", http.StatusInternalServerError)
+					return
+				}
+
+				writeJSON(w, http.StatusOK, map[string]any{
+					"api_key_id":         id,
+					"egress_allow_hosts": hosts,
+				})
+			})
+
+			admin.Put("/{id}/slug", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
+					return
+				}
+
+				var reqBody setSlugRequest
+				if err := decodeJSONBody(r, &reqBody); err != nil {
+					writeBodyError(w, err)
+					return
+				}
+
+				if err := deps.APIKeyAdmin.SetSlug(r.Context(), id, reqBody.Slug); err != nil {
+					if errors.Is(err, domain.ErrInvalidAPIKeySlug) {
+						http.Error(w, "invalid slug", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrAPIKeySlugTaken) {
+						http.Error(w, "slug already in use", http.StatusConflict)
+						return
+					}
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
+						http.Error(w, "api key not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("set api key slug failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to set slug", http.StatusInternalServerError)
+					return
+				}
+
+				var slug *string
+				if trimmed := strings.TrimSpace(reqBody.Slug); trimmed != "" {
+					slug = &trimmed
+				}
+				writeJSON(w, http.StatusOK, map[string]any{
+					"api_key_id": id,
+					"slug":       slug,
+				})
+			})
+
+			admin.Post("/{id}/webhook-secrets", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
+					return
+				}
+
+				var reqBody rotateWebhookSigningKeyRequest
+				if r.Body != nil && r.Body != http.NoBody {
+					if err := decodeJSONBody(r, &reqBody); err != nil {
+						writeBodyError(w, err)
+						return
+					}
+				}
+				overlap := domain.DefaultWebhookSigningKeyOverlap
+				if reqBody.OverlapSeconds != nil {
+					overlap = time.Duration(*reqBody.OverlapSeconds) * time.Second
+				}
+
+				key, err := deps.APIKeyAdmin.RotateWebhookSigningKey(r.Context(), id, overlap)
+				if err != nil {
+					if errors.Is(err, domain.ErrInvalidWebhookKeyOverlap) {
+						http.Error(w, "invalid overlap_seconds", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
+						http.Error(w, "api key not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("rotate webhook signing key failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to rotate webhook secret", http.StatusInternalServerError)
+					return
+				}
+
+				writeJSON(w, http.StatusOK, key)
+			})
+
+			admin.Get("/{id}/webhook-secrets", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
+					return
+				}
+
+				keys, err := deps.APIKeyAdmin.ListWebhookSigningKeys(r.Context(), id)
+				if err != nil {
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
+						http.Error(w, "api key not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("list webhook signing keys failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to list webhook secrets", http.StatusInternalServerError)
+					return
+				}
+
+				writeJSON(w, http.StatusOK, map[string]any{
+					"webhook_secrets": keys,
+				})
+			})
+
+			admin.Delete("/{id}/webhook-secrets/{key_id}", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
+					return
+				}
+				version, ok := domain.ParseWebhookSigningKeyID(chi.URLParam(r, "key_id"))
+				if !ok {
+					http.Error(w, "invalid key ID", http.StatusBadRequest)
+					return
+				}
+
+				if err := deps.APIKeyAdmin.ExpireWebhookSigningKey(r.Context(), id, version); err != nil {
+					if errors.Is(err, domain.ErrWebhookSigningKeyNotFound) {
+						http.Error(w, "webhook secret not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("expire webhook signing key failed", "api_key_id", id, "version", version, "error", err)
+					http.Error(w, "failed to expire webhook secret", http.StatusInternalServerError)
+					return
+				}
+
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			admin.Put("/{id}/webhook", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
+					return
+				}
+
+				var reqBody setWebhookDefaultsRequest
+				if err := decodeJSONBody(r, &reqBody); err != nil {
+					writeBodyError(w, err)
+					return
+				}
+				reqBody.WebhookURL = strings.TrimSpace(reqBody.WebhookURL)
+				if reqBody.WebhookURL != "" {
+					if err := domain.ValidateWebhookURL(reqBody.WebhookURL); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					if !checkWebhookTarget(w, r, deps, reqBody.WebhookURL) {
+						return
+					}
+				}
+
+				defaults, err := deps.APIKeyAdmin.SetWebhookDefaults(r.Context(), id, domain.SetWebhookDefaultsParams{
+					WebhookURL:     reqBody.WebhookURL,
+					WebhookSecret:  reqBody.WebhookSecret,
+					GenerateSecret: reqBody.GenerateSecret,
+				})
+				if err != nil {
+					if errors.Is(err, domain.ErrInvalidWebhookSecret) {
+						http.Error(w, "invalid webhook_secret", http.StatusBadRequest)
+						return
+					}
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
+						http.Error(w, "api key not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("set webhook defaults failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to set webhook defaults", http.StatusInternalServerError)
+					return
+				}
+
+				writeJSON(w, http.StatusOK, defaults)
+			})
+
+			if deps.RunStats != nil {
+				admin.Get("/{id}/stats", func(w http.ResponseWriter, r *http.Request) {
+					id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+					if !ok {
+						return
+					}
+
+					from, to, err := parseStatsRange(r, clk.Now().UTC())
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+
+					days, err := deps.RunStats.ListDailyRunStats(r.Context(), id, from, to)
+					if err != nil {
+						if errors.Is(err, domain.ErrAPIKeyNotFound) {
+							http.Error(w, "api key not found", http.StatusNotFound)
+							return
+						}
+						logger.Error("list run stats failed", "api_key_id", id, "error", err)
+						http.Error(w, "failed to list run stats", http.StatusInternalServerError)
+						return
+					}
+
+					resp := runStatsResponse{
+						APIKeyID: id,
+						From:     from.Format(time.DateOnly),
+						To:       to.Format(time.DateOnly),
+						Totals:   domain.SumDailyRunStats(days),
+						Days:     days,
+					}
+					if deps.Workers != nil {
+						workers, err := deps.Workers.ListActiveWorkers(r.Context(), id)
+						if err != nil {
+							logger.Error("list active workers failed", "api_key_id", id, "error", err)
+							http.Error(w, "failed to list run stats", http.StatusInternalServerError)
+							return
+						}
+						resp.ActiveWorkers = workers
+						resp.Warnings = domain.WorkerFleetWarnings(workers)
+						if len(resp.Warnings) > 0 {
+							logger.Warn("mixed-version workers active", "api_key_id", id, "warnings", resp.Warnings)
+						}
+					}
+
+					writeJSON(w, http.StatusOK, resp)
+				})
+			}
+
+			admin.Delete("/{id}", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "id"))
+				if !ok {
+					return
+				}
+
+				if err := deps.APIKeyAdmin.RevokeAPIKey(r.Context(), id); err != nil {
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
+						http.Error(w, "api key not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("delete api key failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to delete api key", http.StatusInternalServerError)
+					return
+				}
+
+				w.WriteHeader(http.StatusNoContent)
+			})
+		})
+	}
+
+	// ---------------- TENANT ADMINISTRATION (ADMIN) ----------------
+
+	r.Route("/admin/tenants", func(admin chi.Router) {
+		admin.Use(middleware.AdminTokenAuth(deps.AdminToken, logger))
+		admin.Use(auditActorMiddleware(domain.AuditActorAdmin, deps.TrustedProxies))
+
+		if deps.TenantPurger != nil {
+			admin.Post("/{api_key_id}/purge", func(w http.ResponseWriter, r *http.Request) {
+				id, ok := apiKeyRef(w, r, chi.URLParam(r, "api_key_id"))
+				if !ok {
+					return
+				}
+
+				report, err := deps.TenantPurger.PurgeTenant(r.Context(), id, []byte(deps.PurgeSigningKey))
+				if err != nil {
+					if errors.Is(err, domain.ErrPurgeSigningKeyMissing) {
+						http.Error(w, "purge report signing key not configured", http.StatusServiceUnavailable)
+						return
+					}
+					if errors.Is(err, domain.ErrAPIKeyNotFound) {
+						http.Error(w, "api key not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("purge tenant failed", "api_key_id", id, "error", err)
+					http.Error(w, "failed to purge tenant", http.StatusInternalServerError)
+					return
+				}
+
+				writeJSON(w, http.StatusOK, report)
+			})
+		}
+
+		// A tenant's runs, read and decided on its behalf (the admin UI uses
+		// these). The tenant's key ID goes on the context, so the
+		// repositories scope every query to it as for the tenant's own key.
+		admin.Route("/{api_key_id}/runs", func(runs chi.Router) {
+			runs.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					id, ok := apiKeyRef(w, r, chi.URLParam(r, "api_key_id"))
+					if !ok {
+						return
+					}
+					next.ServeHTTP(w, r.WithContext(auth.WithAPIKeyID(r.Context(), id)))
+				})
+			})
+
+			runs.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				filter, err := parseRunFilter(r)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				list, err := deps.RunRepo.ListRuns(r.Context(), filter)
+				if err != nil {
+					logger.Error("admin list runs failed", "error", err)
+					http.Error(w, "failed to list runs", http.StatusInternalServerError)
+					return
+				}
+				writeJSON(w, http.StatusOK, map[string]any{"runs": list})
+			})
+
+			runs.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+				runID, err := uuid.Parse(chi.URLParam(r, "id"))
+				if err != nil {
+					http.Error(w, "invalid run ID", http.StatusBadRequest)
+					return
+				}
+
+				status, err := deps.RunRepo.GetRun(r.Context(), runID)
+				if err != nil {
+					if errors.Is(err, domain.ErrRunNotFound) {
+						http.Error(w, "run not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("admin get run failed", "run_id", runID, "error", err)
+					http.Error(w, "failed to get run", http.StatusInternalServerError)
+					return
+				}
+
+				pending, err := deps.RunRepo.ListPendingApprovals(r.Context(), runID)
+				if err != nil {
+					logger.Error("admin list pending approvals failed", "run_id", runID, "error", err)
+					http.Error(w, "failed to get run", http.StatusInternalServerError)
+					return
+				}
+				if pending == nil {
+					pending = []domain.ApprovalGate{}
+				}
+
+				writeJSON(w, http.StatusOK, map[string]any{
+					"id":                runID.String(),
+					"status":            string(status),
+					"pending_approvals": pending,
+				})
+			})
+
+			runs.Get("/{id}/steps", func(w http.ResponseWriter, r *http.Request) {
+				runID, err := uuid.Parse(chi.URLParam(r, "id"))
+				if err != nil {
+					http.Error(w, "invalid run ID", http.StatusBadRequest)
+					return
+				}
+
+				steps, err := deps.StepRepo.ListSteps(r.Context(), runID)
+				if err != nil {
+					if errors.Is(err, domain.ErrRunNotFound) {
+						http.Error(w, "run not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("admin list steps failed", "run_id", runID, "error", err)
+					http.Error(w, "failed to list steps", http.StatusInternalServerError)
+					return
+				}
+				writeJSON(w, http.StatusOK, map[string]any{"run_id": runID.String(), "steps": steps})
+			})
+
+			if deps.Timeline != nil {
+				runs.Get("/{id}/timeline", func(w http.ResponseWriter, r *http.Request) {
+					runID, err := uuid.Parse(chi.URLParam(r, "id"))
+					if err != nil {
+						http.Error(w, "invalid run ID", http.StatusBadRequest)
+						return
+					}
+
+					timeline, err := deps.Timeline.GetRunTimeline(r.Context(), runID)
+					if err != nil {
+						if errors.Is(err, domain.ErrRunNotFound) {
+							http.Error(w, "run not found", http.StatusNotFound)
+							return
+						}
+						logger.Error("admin run timeline failed", "run_id", runID, "error", err)
+						http.Error(w, "failed to build run timeline", http.StatusInternalServerError)
+						return
+					}
+					writeJSON(w, http.StatusOK, timeline)
+				})
+			}
+
+			// Events after the ?after= sequence number, polled by the UI in
+			// place of the SSE stream, which browsers cannot authenticate.
+			runs.Get("/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+				runID, err := uuid.Parse(chi.URLParam(r, "id"))
+				if err != nil {
+					http.Error(w, "invalid run ID", http.StatusBadRequest)
+					return
+				}
+				var after int64
+				if raw := strings.TrimSpace(r.URL.Query().Get("after")); raw != "" {
+					after, err = strconv.ParseInt(raw, 10, 64)
+					if err != nil || after < 0 {
+						http.Error(w, "invalid after", http.StatusBadRequest)
+						return
+					}
+				}
+				if deps.EventRepo == nil {
+					http.Error(w, "failed to list events", http.StatusInternalServerError)
+					return
+				}
+
+				if _, err := deps.RunRepo.GetRun(r.Context(), runID); err != nil {
+					if errors.Is(err, domain.ErrRunNotFound) {
+						http.Error(w, "run not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("admin get run failed", "run_id", runID, "error", err)
+					http.Error(w, "failed to list events", http.StatusInternalServerError)
+					return
+				}
+
+				events, err := deps.EventRepo.ListEventsAfter(r.Context(), runID, after)
+				if err != nil {
+					logger.Error("admin list events failed", "run_id", runID, "error", err)
+					http.Error(w, "failed to list events", http.StatusInternalServerError)
+					return
+				}
+				if events == nil {
+					events = []domain.EventRecord{}
+				}
+				writeJSON(w, http.StatusOK, map[string]any{"run_id": runID.String(), "events": events})
+			})
+
+			decide := func(verb string) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					runID, err := uuid.Parse(chi.URLParam(r, "id"))
+					if err != nil {
+						http.Error(w, "invalid run ID", http.StatusBadRequest)
+						return
+					}
+					stepID, err := uuid.Parse(chi.URLParam(r, "step_id"))
+					if err != nil {
+						http.Error(w, "invalid step ID", http.StatusBadRequest)
+						return
+					}
+
+					decision, status := deps.RunRepo.ApproveStep, "APPROVED"
+					if verb == "reject" {
+						decision, status = deps.RunRepo.RejectStep, "REJECTED"
+					}
+					if err := decision(r.Context(), runID, stepID); err != nil {
+						writeStepDecisionError(w, logger, err, runID, stepID, verb)
+						return
+					}
+
+					logger.Info("step "+strings.ToLower(status)+" by admin", "run_id", runID, "step_id", stepID)
+					writeJSON(w, http.StatusOK, map[string]string{
+						"id":      runID.String(),
+						"step_id": stepID.String(),
+						"status":  status,
+					})
+				}
+			}
+			runs.Post("/{id}/approvals/{step_id}", decide("approve"))
+			runs.Post("/{id}/approvals/{step_id}/reject", decide("reject"))
+		})
+	})
+
+	// ---------------- RUNS (ADMIN) ----------------
+
+	// Any tenant's runs, for support and incident response.
+	// Runs of every tenant, for support and incident response. Reads here are
+	// audited as well as writes.
+	r.Route("/admin/runs", func(admin chi.Router) {
+		admin.Use(middleware.AdminTokenAuth(deps.AdminToken, logger))
+		admin.Use(auditActorMiddleware(domain.AuditActorAdmin, deps.TrustedProxies))
+
+		admin.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			filter, err := parseRunFilter(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			var tenant *uuid.UUID
+			if raw := strings.TrimSpace(r.URL.Query().Get("api_key_id")); raw != "" {
+				id, ok := apiKeyRef(w, r, raw)
+				if !ok {
+					return
+				}
+				tenant = &id
+			}
+
+			list, err := deps.RunRepo.AdminListRuns(r.Context(), tenant, filter)
+			if err != nil {
+				logger.Error("admin list runs failed", "api_key_id", tenant, "error", err)
+				http.Error(w, "failed to list runs", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"runs": list})
+		})
+
+		admin.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			runID, err := uuid.Parse(chi.URLParam(r, "id"))
+			if err != nil {
+				http.Error(w, "invalid run ID", http.StatusBadRequest)
+				return
+			}
+
+			run, err := deps.RunRepo.AdminGetRun(r.Context(), runID)
+			if err != nil {
+				if errors.Is(err, domain.ErrRunNotFound) {
+					http.Error(w, "run not found", http.StatusNotFound)
+					return
+				}
+				logger.Error("admin get run failed", "run_id", runID, "error", err)
+				http.Error(w, "failed to get run", http.StatusInternalServerError)
+				return
+			}
+
+			// The steps are read as the run's tenant, so they come from the
+			// tenant-scoped repository like the tenant's own.
+			steps, err := deps.StepRepo.ListSteps(auth.WithAPIKeyID(r.Context(), run.APIKeyID), runID)
+			if err != nil && !errors.Is(err, domain.ErrRunNotFound) {
+				logger.Error("admin list steps failed", "run_id", runID, "error", err)
+				http.Error(w, "failed to get run", http.StatusInternalServerError)
+				return
+			}
+			if steps == nil {
+				steps = []domain.StepRecord{}
+			}
+
+			writeJSON(w, http.StatusOK, map[string]any{"run": run, "steps": steps})
+		})
+
+		admin.Post("/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+			runID, err := uuid.Parse(chi.URLParam(r, "id"))
+			if err != nil {
+				http.Error(w, "invalid run ID", http.StatusBadRequest)
+				return
+			}
+
+			var reqBody cancelRunRequest
+			if err := decodeOptionalJSONBody(r, &reqBody); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+
+			if err := deps.RunRepo.CancelRunAsAdmin(r.Context(), runID, reqBody.Reason); err != nil {
+				if errors.Is(err, domain.ErrInvalidCancelReason) {
+					http.Error(w, "invalid reason", http.StatusBadRequest)
+					return
+				}
+				if errors.Is(err, domain.ErrRunNotFound) {
+					http.Error(w, "run not found", http.StatusNotFound)
+					return
+				}
+				if errors.Is(err, domain.ErrInvalidTransition) {
+					http.Error(w, "invalid run status transition", http.StatusConflict)
+					return
+				}
+				logger.Error("admin cancel run failed", "run_id", runID, "error", err)
+				http.Error(w, "failed to cancel run", http.StatusInternalServerError)
+				return
+			}
+
+			logger.Info("run canceled by admin", "run_id", runID)
+			writeJSON(w, http.StatusOK, map[string]string{
+				"id":     runID.String(),
+				"status": string(domain.RunCanceled),
+			})
+		})
+	})
+
+	// ---------------- WORKFLOW TEMPLATES (ADMIN) ----------------
+
+	// Templates are shared by every tenant, so only the admin token edits
+	// them. An edit adds a version; runs keep the version they pinned.
+	if deps.Templates != nil {
+		r.Route("/admin/workflow-templates", func(admin chi.Router) {
+			admin.Use(middleware.AdminTokenAuth(deps.AdminToken, logger))
+			admin.Use(auditActorMiddleware(domain.AuditActorAdmin, deps.TrustedProxies))
+
+			admin.Put("/{name}", func(w http.ResponseWriter, r *http.Request) {
+				name, err := domain.NormalizeWorkflowTemplateName(chi.URLParam(r, "name"))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				var reqBody domain.PutWorkflowTemplateRequest
+				if err := decodeJSONBody(r, &reqBody); err != nil {
+					writeBodyError(w, err)
+					return
+				}
+				steps, err := domain.NormalizeWorkflowTemplateSteps(reqBody.Steps)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				version, err := deps.Templates.PutWorkflowTemplate(r.Context(), name, steps)
+				if err != nil {
+					logger.Error("put workflow template failed", "template_name", name, "error", err)
+					http.Error(w, "failed to save workflow template", http.StatusInternalServerError)
+					return
+				}
+
+				logger.Info("workflow template saved", "template_name", name, "version", version.Version)
+				writeJSON(w, http.StatusOK, version)
+			})
+		})
+	}
+
+	// ---------------- SECRETS (ADMIN) ----------------
+
+	// Secrets are write-only: values are sealed on the way in and only
+	// workers open them, for the steps whose template names them.
+	if deps.Secrets != nil {
+		r.Route("/admin/secrets", func(admin chi.Router) {
+			admin.Use(middleware.AdminTokenAuth(deps.AdminToken, logger))
+			admin.Use(auditActorMiddleware(domain.AuditActorAdmin, deps.TrustedProxies))
+
+			admin.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				list, err := deps.Secrets.ListSecrets(r.Context())
+				if err != nil {
+					logger.Error("list secrets failed", "error", err)
+					http.Error(w, "failed to list secrets", http.StatusInternalServerError)
+					return
+				}
+				writeJSON(w, http.StatusOK, map[string]any{"secrets": list})
+			})
+
+			admin.Put("/{name}", func(w http.ResponseWriter, r *http.Request) {
+				name := chi.URLParam(r, "name")
+				if err := domain.ValidateSecretName(name); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				var reqBody domain.PutSecretRequest
+				if err := decodeJSONBody(r, &reqBody); err != nil {
+					writeBodyError(w, err)
+					return
+				}
+				if err := domain.ValidateSecretValue(reqBody.Value); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				secret, err := deps.Secrets.PutSecret(r.Context(), name, reqBody.Value)
+				if err != nil {
+					if errors.Is(err, domain.ErrSecretsKeyMissing) {
+						http.Error(w, "secrets are unavailable: SECRETS_KEY is not configured", http.StatusServiceUnavailable)
+						return
+					}
+					logger.Error("put secret failed", "secret", name, "error", err)
+					http.Error(w, "failed to save secret", http.StatusInternalServerError)
+					return
+				}
+				writeJSON(w, http.StatusOK, secret)
+			})
+
+			admin.Delete("/{name}", func(w http.ResponseWriter, r *http.Request) {
+				name := chi.URLParam(r, "name")
+				if err := deps.Secrets.DeleteSecret(r.Context(), name); err != nil {
+					if errors.Is(err, domain.ErrSecretNotFound) {
+						http.Error(w, "secret not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("delete secret failed", "secret", name, "error", err)
+					http.Error(w, "failed to delete secret", http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			})
+		})
+	}
+
+	// ---------------- AUDIT LOG (ADMIN) ----------------
+
+	if deps.AuditLog != nil {
+		r.Route("/audit", func(admin chi.Router) {
+			admin.Use(middleware.AdminTokenAuth(deps.AdminToken, logger))
+
+			admin.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				filter, err := parseAuditFilter(r)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				if raw := strings.TrimSpace(r.URL.Query().Get("api_key_id")); raw != "" {
+					id, ok := apiKeyRef(w, r, raw)
+					if !ok {
+						return
+					}
+					filter.APIKeyID = &id
+				}
+
+				entries, err := deps.AuditLog.ListAuditEntries(r.Context(), filter)
+				if err != nil {
+					logger.Error("list audit entries failed", "error", err)
+					http.Error(w, "failed to list audit entries", http.StatusInternalServerError)
+					return
+				}
+
+				resp := map[string]any{
+					"entries": entries,
+				}
+				if len(entries) > 0 && len(entries) == filter.Limit {
+					resp["next_before"] = entries[len(entries)-1].Seq
+				}
+				writeJSON(w, http.StatusOK, resp)
+			})
+		})
+	}
+
+	// ---------------- WORKERS (ADMIN) ----------------
+
+	if deps.Workers != nil {
+		r.Route("/workers", func(admin chi.Router) {
+			admin.Use(middleware.AdminTokenAuth(deps.AdminToken, logger))
+
+			admin.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				var (
+					workers []domain.WorkerRecord
+					err     error
+				)
+				if raw := strings.TrimSpace(r.URL.Query().Get("api_key_id")); raw != "" {
+					id, ok := apiKeyRef(w, r, raw)
+					if !ok {
+						return
+					}
+					workers, err = deps.Workers.ListActiveWorkers(r.Context(), id)
+				} else {
+					workers, err = deps.Workers.ListAllActiveWorkers(r.Context())
+				}
+				if err != nil {
+					logger.Error("list active workers failed", "error", err)
+					http.Error(w, "failed to list workers", http.StatusInternalServerError)
+					return
+				}
+
+				writeJSON(w, http.StatusOK, map[string]any{
+					"workers": workers,
+				})
+			})
+		})
+
+		r.Route("/admin/workers", func(admin chi.Router) {
+			admin.Use(middleware.AdminTokenAuth(deps.AdminToken, logger))
+
+			admin.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				liveness, err := deps.Workers.GetWorkerLiveness(r.Context())
+				if err != nil {
+					logger.Error("get worker liveness failed", "error", err)
+					http.Error(w, "failed to list workers", http.StatusInternalServerError)
+					return
+				}
+				if len(liveness.TenantsWithoutLiveWorker) > 0 {
+					logger.Warn("tenants without live worker", "api_key_ids", liveness.TenantsWithoutLiveWorker)
+				}
+
+				writeJSON(w, http.StatusOK, liveness)
+			})
+		})
+	}
+
+	// ---------------- SCHEDULING (ADMIN) ----------------
+
+	if deps.APIKeyAdmin != nil {
+		r.With(middleware.AdminTokenAuth(deps.AdminToken, logger)).Get("/admin/scheduling", func(w http.ResponseWriter, r *http.Request) {
+			tenants, err := deps.APIKeyAdmin.ListTenantScheduling(r.Context())
+			if err != nil {
+				logger.Error("list tenant scheduling failed", "error", err)
+				http.Error(w, "failed to list tenant scheduling", http.StatusInternalServerError)
+				return
+			}
+
+			writeJSON(w, http.StatusOK, map[string]any{
+				"tenants": tenants,
+			})
+		})
+	}
+
+	// ---------------- RUNS (API KEY AUTH) ----------------
+
+	// Scopes are only enforced when API key auth is configured.
+	requireScope := func(scope string) func(http.Handler) http.Handler {
+		if deps.APIKeyResolver == nil {
+			return func(next http.Handler) http.Handler { return next }
+		}
+		return middleware.RequireScope(scope, logger)
+	}
+
+	r.Group(func(r chi.Router) {
+		if deps.APIKeyResolver != nil {
+			r.Use(middleware.APITokenAuthWithClock(deps.APIKeyResolver, clk, logger))
+			r.Use(middleware.RequireAllowedIP(deps.TrustedProxies, logger))
+			r.Use(auditActorMiddleware(domain.AuditActorAPIKey, deps.TrustedProxies))
+			if deps.APIKeyExpiryWarning > 0 {
+				r.Use(middleware.APIKeyExpiryWarning(deps.APIKeyExpiryWarning, clk, logger))
+			}
+		}
+
+		// ---------------- CREATE RUN ----------------
+
+		r.With(requireScope(domain.ScopeRunsWrite)).Post("/runs", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if key := strings.TrimSpace(r.Header.Get(headerIdempotencyKey)); key != "" {
+				ctx = auth.WithIdempotencyKey(ctx, key)
+			}
+
+			params, err := decodeCreateRunRequest(r)
+			if err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			if params.WebhookURL != "" && !checkWebhookTarget(w, r, deps, params.WebhookURL) {
+				return
+			}
+
+			created, err := deps.RunRepo.SubmitRun(ctx, params)
+			if err != nil {
+				if writeSubmitRunError(w, err) {
+					return
+				}
+
+				logger.Error("create run failed", "error", err)
+				http.Error(w, "failed to create run", http.StatusInternalServerError)
+				return
+			}
+
+			logger.Info("run created via API", "run_id", created.ID)
+
+			resp := map[string]string{
+				"run_id": created.ID.String(),
+			}
+			if created.WebhookSecret != "" {
+				resp["webhook_secret"] = created.WebhookSecret
+			}
+			writeJSON(w, http.StatusOK, resp)
+		})
+
+		// ---------------- LIST RUNS ----------------
+
+		r.With(requireScope(domain.ScopeRunsRead)).Get("/runs", func(w http.ResponseWriter, r *http.Request) {
+			filter, err := parseRunFilter(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			runs, err := deps.RunRepo.ListRuns(r.Context(), filter)
+			if err != nil {
+				logger.Error("list runs failed", "error", err)
+				http.Error(w, "failed to list runs", http.StatusInternalServerError)
+				return
+			}
+
+			resp := map[string]any{
+				"runs": runs,
+			}
+			if len(runs) > 0 && len(runs) == filter.Limit {
+				resp["next_before"] = runs[len(runs)-1].ID
+			}
+			writeJSON(w, http.StatusOK, resp)
+		})
+
+		// ---------------- USAGE ----------------
+
+		if deps.RunStats != nil {
+			r.With(requireScope(domain.ScopeRunsRead)).Get("/usage", func(w http.ResponseWriter, r *http.Request) {
+				apiKeyID, ok := auth.APIKeyIDFromContext(r.Context())
+				if !ok {
+					http.Error(w, "missing or invalid API token", http.StatusUnauthorized)
+					return
+				}
+
+				bucket := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("bucket")))
+				switch bucket {
+				case "":
+					bucket = domain.UsageBucketDay
+				case domain.UsageBucketDay, domain.UsageBucketMonth:
+				default:
+					http.Error(w, "invalid bucket", http.StatusBadRequest)
+					return
+				}
+
+				from, to, err := parseStatsRange(r, clk.Now().UTC())
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				days, err := deps.RunStats.ListDailyRunStats(r.Context(), apiKeyID, from, to)
+				if err != nil {
+					logger.Error("list usage failed", "api_key_id", apiKeyID, "error", err)
+					http.Error(w, "failed to get usage", http.StatusInternalServerError)
+					return
+				}
+
+				writeJSON(w, http.StatusOK, usageResponse{
+					From:    from.Format(time.DateOnly),
+					To:      to.Format(time.DateOnly),
+					Bucket:  bucket,
+					Totals:  domain.SumDailyRunStats(days),
+					Buckets: domain.BucketDailyRunStats(days, bucket),
+				})
+			})
+		}
+
+		if deps.TokenUsage != nil {
+			r.With(requireScope(domain.ScopeRunsRead)).Get("/usage/tokens", func(w http.ResponseWriter, r *http.Request) {
+				apiKeyID, ok := auth.APIKeyIDFromContext(r.Context())
+				if !ok {
+					http.Error(w, "missing or invalid API token", http.StatusUnauthorized)
+					return
+				}
+
+				bucket := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("bucket")))
+				switch bucket {
+				case "":
+					bucket = domain.UsageBucketDay
+				case domain.UsageBucketDay, domain.UsageBucketMonth:
+				default:
+					http.Error(w, "invalid bucket", http.StatusBadRequest)
+					return
+				}
+
+				from, to, err := parseStatsRange(r, clk.Now().UTC())
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				days, err := deps.TokenUsage.ListDailyTokenUsage(r.Context(), apiKeyID, from, to)
+				if err != nil {
+					logger.Error("list token usage failed", "api_key_id", apiKeyID, "error", err)
+					http.Error(w, "failed to get token usage", http.StatusInternalServerError)
+					return
+				}
+
+				report := domain.BuildTokenUsageReport(days, bucket)
+				report.From = from.Format(time.DateOnly)
+				report.To = to.Format(time.DateOnly)
+				writeJSON(w, http.StatusOK, report)
+			})
+		}
+
+		// ---------------- GET RUN COST ----------------
+
+		r.With(requireScope(domain.ScopeRunsRead)).Get("/runs/{id}/cost", func(w http.ResponseWriter, r *http.Request) {
+			idStr := chi.URLParam(r, "id")
+
+			runID, err := uuid.Parse(idStr)
+			if err != nil {
+				http.Error(w, "invalid run ID", http.StatusBadRequest)
+				return
+			}
+
+			breakdown, err := deps.RunRepo.GetRunCost(r.Context(), runID)
+			if err != nil {
+				if errors.Is(err, domain.ErrRunNotFound) {
+					logger.Warn("run not found", "run_id", runID)
+					http.Error(w, "run not found", http.StatusNotFound)
+					return
+				}
+
+				logger.Error("get run cost failed", "run_id", runID, "error", err)
+				http.Error(w, "failed to get run cost", http.StatusInternalServerError)
+				return
+			}
+
+			writeJSON(w, http.StatusOK, breakdown)
+		})
+
+		// ---------------- GET RUN ----------------
+
+		r.With(requireScope(domain.ScopeRunsRead)).Get("/runs/{id}", func(w http.ResponseWriter, r *http.Request) {
+			idStr := chi.URLParam(r, "id")
+
+			runID, err := uuid.Parse(idStr)
+			if err != nil {
+				http.Error(w, "invalid run ID", http.StatusBadRequest)
+				return
+			}
+
+			if notModified(w, r, deps.RunVersions, runID) {
+				return
+			}
+
+			status, err := deps.RunRepo.GetRun(r.Context(), runID)
+			if err != nil {
+				if errors.Is(err, domain.ErrRunNotFound) {
+					logger.Warn("run not found", "run_id", runID)
+					http.Error(w, "run not found", http.StatusNotFound)
+					return
+				}
+
+				logger.Error("get run failed", "run_id", runID, "error", err)
+				http.Error(w, "failed to get run", http.StatusInternalServerError)
+				return
+			}
+
+			pending, err := deps.RunRepo.ListPendingApprovals(r.Context(), runID)
+			if err != nil {
+				logger.Error("list pending approvals failed", "run_id", runID, "error", err)
+				http.Error(w, "failed to get run", http.StatusInternalServerError)
+				return
+			}
+			if pending == nil {
+				pending = []domain.ApprovalGate{}
+			}
+
+			writeJSON(w, http.StatusOK, map[string]any{
+				"id":                runID.String(),
+				"status":            string(status), // convert domain type to string
+				"pending_approvals": pending,
+			})
+		})
+
+		// ---------------- WAIT FOR RUN ----------------
+
+		r.With(requireScope(domain.ScopeRunsRead)).Get("/runs/{id}/wait", func(w http.ResponseWriter, r *http.Request) {
+			runID, err := uuid.Parse(chi.URLParam(r, "id"))
+			if err != nil {
+				http.Error(w, "invalid run ID", http.StatusBadRequest)
+				return
+			}
+
+			timeout, err := parseRunWaitTimeout(r.URL.Query().Get("timeout"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if deps.EventRepo == nil {
+				logger.Error("wait for run: events repository is not configured")
+				http.Error(w, "failed to wait for run", http.StatusInternalServerError)
+				return
+			}
+
+			status, err := deps.RunRepo.GetRun(r.Context(), runID)
+			if err != nil {
+				if errors.Is(err, domain.ErrRunNotFound) {
+					http.Error(w, "run not found", http.StatusNotFound)
+					return
+				}
+				logger.Error("wait for run: get run failed", "run_id", runID, "error", err)
+				http.Error(w, "failed to wait for run", http.StatusInternalServerError)
+				return
+			}
+			if status.IsTerminal() || timeout == 0 {
+				writeJSON(w, http.StatusOK, runWaitResponse{ID: runID, Status: status, Terminal: status.IsTerminal()})
+				return
+			}
+
+			closing, done, ok := streams.open()
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(streams.ReconnectAfter().Seconds()))))
+				http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+				return
+			}
+			defer done()
+			holdOpenForStream(w, r)
+
+			// Every status change appends an event, so the status is only
+			// read again once the run has new events. The first poll reads
+			// the events written so far and checks the status once more,
+			// closing the gap since GetRun.
+			var cursor int64
+			deadline := time.NewTimer(timeout)
+			defer deadline.Stop()
+			ticker := time.NewTicker(ssePollInterval)
+			defer ticker.Stop()
+
+		wait:
+			for {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-closing:
+					break wait
+				case <-deadline.C:
+					break wait
+				case <-ticker.C:
+				}
+
+				events, err := deps.EventRepo.ListEventsAfter(r.Context(), runID, cursor)
+				if err != nil {
+					logger.Error("wait for run: list events failed", "run_id", runID, "error", err)
+					http.Error(w, "failed to wait for run", http.StatusInternalServerError)
+					return
+				}
+				if len(events) == 0 {
+					continue
+				}
+				cursor = events[len(events)-1].Seq
+
+				if status, err = deps.RunRepo.GetRun(r.Context(), runID); err != nil {
+					logger.Error("wait for run: get run failed", "run_id", runID, "error", err)
+					http.Error(w, "failed to wait for run", http.StatusInternalServerError)
+					return
+				}
+				if status.IsTerminal() {
+					break wait
+				}
+			}
+
+			writeJSON(w, http.StatusOK, runWaitResponse{ID: runID, Status: status, Terminal: status.IsTerminal()})
+		})
+
+		// ---------------- CANCEL RUN ----------------
+
+		r.With(requireScope(domain.ScopeRunsWrite)).Post("/runs/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+			idStr := chi.URLParam(r, "id")
+
+			runID, err := uuid.Parse(idStr)
+			if err != nil {
+				http.Error(w, "invalid run ID", http.StatusBadRequest)
+				return
+			}
+
+			var reqBody cancelRunRequest
+			if err := decodeOptionalJSONBody(r, &reqBody); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+
+			if err := deps.RunRepo.CancelRun(r.Context(), runID, reqBody.Reason); err != nil {
+				if errors.Is(err, domain.ErrInvalidCancelReason) {
+					http.Error(w, "invalid reason", http.StatusBadRequest)
+					return
+				}
+				if errors.Is(err, domain.ErrRunNotFound) {
+					logger.Warn("run not found", "run_id", runID)
+					http.Error(w, "run not found", http.StatusNotFound)
+					return
+				}
+				if errors.Is(err, domain.ErrInvalidTransition) {
+					http.Error(w, "invalid run status transition", http.StatusConflict)
+					return
+				}
+
+				logger.Error("cancel run failed", "run_id", runID, "error", err)
+				http.Error(w, "failed to cancel run", http.StatusInternalServerError)
+				return
+			}
+
+			logger.Info("run canceled via API", "run_id", runID)
+
+			writeJSON(w, http.StatusOK, map[string]string{
 				"id":     runID.String(),
 				"status": string(domain.RunCanceled),
 			})
 		})
 
+		// ---------------- REPLAY RUN ----------------
+
+		r.With(requireScope(domain.ScopeRunsWrite)).Post("/runs/{id}/replay", func(w http.ResponseWriter, r *http.Request) {
+			runID, err := uuid.Parse(chi.URLParam(r, "id"))
+			if err != nil {
+				http.Error(w, "invalid run ID", http.StatusBadRequest)
+				return
+			}
+
+			ctx := r.Context()
+			if key := strings.TrimSpace(r.Header.Get(headerIdempotencyKey)); key != "" {
+				ctx = auth.WithIdempotencyKey(ctx, key)
+			}
+
+			var reqBody domain.ReplayRunRequest
+			if err := decodeOptionalJSONBody(r, &reqBody); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+
+			created, err := deps.RunRepo.ReplayRun(ctx, runID, reqBody.StepInputs)
+			if err != nil {
+				if errors.Is(err, domain.ErrRunNotFound) {
+					http.Error(w, "run not found", http.StatusNotFound)
+					return
+				}
+				if writeSubmitRunError(w, err) {
+					return
+				}
+
+				logger.Error("replay run failed", "run_id", runID, "error", err)
+				http.Error(w, "failed to replay run", http.StatusInternalServerError)
+				return
+			}
+
+			logger.Info("run replayed via API", "run_id", created.ID, "replayed_from_run_id", runID)
+
+			resp := map[string]string{
+				"run_id":               created.ID.String(),
+				"replayed_from_run_id": runID.String(),
+			}
+			if created.WebhookSecret != "" {
+				resp["webhook_secret"] = created.WebhookSecret
+			}
+			writeJSON(w, http.StatusOK, resp)
+		})
+
 		// ---------------- LIST STEPS ----------------
 
-		r.Get("/runs/{id}/steps", func(w http.ResponseWriter, r *http.Request) {
+		r.With(requireScope(domain.ScopeRunsRead)).Get("/runs/{id}/steps", func(w http.ResponseWriter, r *http.Request) {
 			idStr := chi.URLParam(r, "id")
 
 			runID, err := uuid.Parse(idStr)
@@ -318,9 +1927,13 @@ func NewRouter(deps Deps) http.Handler {
 				return
 			}
 
+			if notModified(w, r, deps.RunVersions, runID) {
+				return
+			}
+
 			steps, err := deps.StepRepo.ListSteps(r.Context(), runID)
 			if err != nil {
-				if errors.Is(err, pgx.ErrNoRows) {
+				if errors.Is(err, domain.ErrRunNotFound) {
 					logger.Warn("run not found", "run_id", runID)
 					http.Error(w, "run not found", http.StatusNotFound)
 					return
@@ -340,9 +1953,284 @@ func NewRouter(deps Deps) http.Handler {
 			})
 		})
 
+		// ---------------- RUN TIMELINE ----------------
+
+		if deps.Timeline != nil {
+			r.With(requireScope(domain.ScopeRunsRead)).Get("/runs/{id}/timeline", func(w http.ResponseWriter, r *http.Request) {
+				runID, err := uuid.Parse(chi.URLParam(r, "id"))
+				if err != nil {
+					http.Error(w, "invalid run ID", http.StatusBadRequest)
+					return
+				}
+
+				timeline, err := deps.Timeline.GetRunTimeline(r.Context(), runID)
+				if err != nil {
+					if errors.Is(err, domain.ErrRunNotFound) {
+						http.Error(w, "run not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("run timeline failed", "run_id", runID, "error", err)
+					http.Error(w, "failed to build run timeline", http.StatusInternalServerError)
+					return
+				}
+
+				writeJSON(w, http.StatusOK, timeline)
+			})
+		}
+
+		// ---------------- EXPORTS ----------------
+
+		if deps.Exports != nil {
+			r.With(requireScope(domain.ScopeRunsRead)).Get("/runs/{id}/export", func(w http.ResponseWriter, r *http.Request) {
+				runID, err := uuid.Parse(chi.URLParam(r, "id"))
+				if err != nil {
+					http.Error(w, "invalid run ID", http.StatusBadRequest)
+					return
+				}
+				format, err := domain.ParseExportFormat(r.URL.Query().Get("format"))
+				if err != nil {
+					http.Error(w, "invalid format", http.StatusBadRequest)
+					return
+				}
+
+				run, err := deps.Exports.ExportRun(r.Context(), runID)
+				if err != nil {
+					if errors.Is(err, domain.ErrRunNotFound) {
+						http.Error(w, "run not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("export run failed", "run_id", runID, "error", err)
+					http.Error(w, "failed to export run", http.StatusInternalServerError)
+					return
+				}
+
+				w.Header().Set("Content-Type", export.ContentType(format))
+				w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="run-%s.%s"`, runID, format))
+				if err := export.WriteRun(w, format, run); err != nil {
+					logger.Error("write run export failed", "run_id", runID, "error", err)
+				}
+			})
+
+			r.With(requireScope(domain.ScopeRunsRead)).Post("/exports", func(w http.ResponseWriter, r *http.Request) {
+				var reqBody struct {
+					Format string `json:"format"`
+				}
+				if err := decodeOptionalJSONBody(r, &reqBody); err != nil {
+					writeBodyError(w, err)
+					return
+				}
+				format, err := domain.ParseExportFormat(reqBody.Format)
+				if err != nil {
+					http.Error(w, "invalid format", http.StatusBadRequest)
+					return
+				}
+
+				job, err := deps.Exports.CreateExportJob(r.Context(), format)
+				if err != nil {
+					logger.Error("create export job failed", "error", err)
+					http.Error(w, "failed to create export", http.StatusInternalServerError)
+					return
+				}
+				writeJSON(w, http.StatusAccepted, job)
+			})
+
+			r.With(requireScope(domain.ScopeRunsRead)).Get("/exports/{id}", func(w http.ResponseWriter, r *http.Request) {
+				id, err := uuid.Parse(chi.URLParam(r, "id"))
+				if err != nil {
+					http.Error(w, "invalid export ID", http.StatusBadRequest)
+					return
+				}
+
+				job, err := deps.Exports.GetExportJob(r.Context(), id)
+				if err != nil {
+					if errors.Is(err, domain.ErrExportNotFound) {
+						http.Error(w, "export not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("get export job failed", "export_id", id, "error", err)
+					http.Error(w, "failed to get export", http.StatusInternalServerError)
+					return
+				}
+				writeJSON(w, http.StatusOK, job)
+			})
+
+			r.With(requireScope(domain.ScopeRunsRead)).Get("/exports/{id}/artifact", func(w http.ResponseWriter, r *http.Request) {
+				id, err := uuid.Parse(chi.URLParam(r, "id"))
+				if err != nil {
+					http.Error(w, "invalid export ID", http.StatusBadRequest)
+					return
+				}
+
+				job, artifact, err := deps.Exports.GetExportArtifact(r.Context(), id)
+				if err != nil {
+					if errors.Is(err, domain.ErrExportNotFound) {
+						http.Error(w, "export not found", http.StatusNotFound)
+						return
+					}
+					if errors.Is(err, domain.ErrExportNotReady) {
+						http.Error(w, "export is "+strings.ToLower(job.Status), http.StatusConflict)
+						return
+					}
+					logger.Error("get export artifact failed", "export_id", id, "error", err)
+					http.Error(w, "failed to get export", http.StatusInternalServerError)
+					return
+				}
+
+				w.Header().Set("Content-Type", export.ContentType(job.Format))
+				w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%s.%s"`, id, job.Format))
+				w.Header().Set("Content-Length", strconv.Itoa(len(artifact)))
+				if job.ArtifactSHA256 != nil {
+					w.Header().Set("X-Content-SHA256", *job.ArtifactSHA256)
+				}
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write(artifact)
+			})
+		}
+
+		// ---------------- STEP LOGS ----------------
+
+		if deps.StepLogs != nil {
+			r.With(requireScope(domain.ScopeRunsRead)).Get("/runs/{id}/steps/{step_id}/logs", func(w http.ResponseWriter, r *http.Request) {
+				runID, err := uuid.Parse(chi.URLParam(r, "id"))
+				if err != nil {
+					http.Error(w, "invalid run ID", http.StatusBadRequest)
+					return
+				}
+				stepID, err := uuid.Parse(chi.URLParam(r, "step_id"))
+				if err != nil {
+					http.Error(w, "invalid step ID", http.StatusBadRequest)
+					return
+				}
+				after, limit, err := parseStepLogQuery(r)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				page, err := deps.StepLogs.ListStepLogs(r.Context(), runID, stepID, after, limit)
+				if err != nil {
+					if errors.Is(err, domain.ErrStepNotFound) {
+						http.Error(w, "step not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("list step logs failed", "run_id", runID, "step_id", stepID, "error", err)
+					http.Error(w, "failed to list step logs", http.StatusInternalServerError)
+					return
+				}
+
+				if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+					resp := map[string]any{
+						"run_id":      runID,
+						"step_id":     stepID,
+						"step_status": page.StepStatus,
+						"lines":       page.Lines,
+					}
+					if len(page.Lines) > 0 {
+						resp["next_after"] = page.Lines[len(page.Lines)-1].Seq
+					}
+					writeJSON(w, http.StatusOK, resp)
+					return
+				}
+
+				// Tail: send lines as they are written until the step
+				// settles and every line has been sent.
+				flusher, ok := w.(http.Flusher)
+				if !ok {
+					http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+					return
+				}
+
+				closing, done, ok := streams.open()
+				if !ok {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(streams.ReconnectAfter().Seconds()))))
+					http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+					return
+				}
+				defer done()
+				holdOpenForStream(w, r)
+
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Header().Set("Cache-Control", "no-cache")
+				w.Header().Set("Connection", "keep-alive")
+				w.Header().Set("X-Accel-Buffering", "no")
+				w.WriteHeader(http.StatusOK)
+				flusher.Flush()
+
+				// writeLines sends page and reports whether the stream is done.
+				writeLines := func(page domain.StepLogPage) (bool, error) {
+					for _, line := range page.Lines {
+						payload, err := json.Marshal(line)
+						if err != nil {
+							return false, err
+						}
+						if _, err := fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", line.Seq, payload); err != nil {
+							return false, err
+						}
+						after = line.Seq
+					}
+					if len(page.Lines) == limit || !page.StepStatus.IsTerminal() {
+						flusher.Flush()
+						return false, nil
+					}
+					payload, err := json.Marshal(map[string]any{"step_status": page.StepStatus, "last_seq": after})
+					if err != nil {
+						return false, err
+					}
+					if _, err := fmt.Fprintf(w, "event: end\ndata: %s\n\n", payload); err != nil {
+						return false, err
+					}
+					flusher.Flush()
+					return true, nil
+				}
+
+				if finished, err := writeLines(page); err != nil || finished {
+					if err != nil {
+						logger.Error("step log stream write failed", "run_id", runID, "step_id", stepID, "error", err)
+					}
+					return
+				}
+
+				ticker := time.NewTicker(ssePollInterval)
+				defer ticker.Stop()
+
+				for {
+					select {
+					case <-r.Context().Done():
+						return
+					case <-closing:
+						reconnectAfter := streams.ReconnectAfter()
+						payload, err := json.Marshal(sseShutdownEvent{
+							Reason:           "server_shutdown",
+							ReconnectAfterMS: reconnectAfter.Milliseconds(),
+							LastSeq:          after,
+						})
+						if err != nil {
+							return
+						}
+						if _, err := fmt.Fprintf(w, "event: shutdown\nretry: %d\ndata: %s\n\n", reconnectAfter.Milliseconds(), payload); err != nil {
+							return
+						}
+						flusher.Flush()
+						return
+					case <-ticker.C:
+						page, err := deps.StepLogs.ListStepLogs(r.Context(), runID, stepID, after, limit)
+						if err != nil {
+							if r.Context().Err() == nil {
+								logger.Error("step log stream read failed", "run_id", runID, "step_id", stepID, "error", err)
+							}
+							return
+						}
+						if finished, err := writeLines(page); err != nil || finished {
+							return
+						}
+					}
+				}
+			})
+		}
+
 		// ---------------- STREAM EVENTS (SSE) ----------------
 
-		r.Get("/runs/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		r.With(requireScope(domain.ScopeRunsRead)).Get("/runs/{id}/events", func(w http.ResponseWriter, r *http.Request) {
 			idStr := chi.URLParam(r, "id")
 
 			runID, err := uuid.Parse(idStr)
@@ -353,7 +2241,7 @@ func NewRouter(deps Deps) http.Handler {
 
 			// Enforce tenant ownership and hide cross-tenant existence.
 			if _, err := deps.RunRepo.GetRun(r.Context(), runID); err != nil {
-				if errors.Is(err, pgx.ErrNoRows) {
+				if errors.Is(err, domain.ErrRunNotFound) {
 					http.Error(w, "run not found", http.StatusNotFound)
 					return
 				}
@@ -390,6 +2278,15 @@ func NewRouter(deps Deps) http.Handler {
 				return
 			}
 
+			closing, done, ok := streams.open()
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(streams.ReconnectAfter().Seconds()))))
+				http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+				return
+			}
+			defer done()
+			holdOpenForStream(w, r)
+
 			w.Header().Set("Content-Type", "text/event-stream")
 			w.Header().Set("Cache-Control", "no-cache")
 			w.Header().Set("Connection", "keep-alive")
@@ -418,30 +2315,108 @@ func NewRouter(deps Deps) http.Handler {
 				return nil
 			}
 
-			if err := writeEvents(); err != nil {
-				logger.Error("sse initial write failed", "run_id", runID, "error", err)
-				return
-			}
+			if err := writeEvents(); err != nil {
+				logger.Error("sse initial write failed", "run_id", runID, "error", err)
+				return
+			}
+
+			ticker := time.NewTicker(ssePollInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-closing:
+					reconnectAfter := streams.ReconnectAfter()
+					payload, err := json.Marshal(sseShutdownEvent{
+						Reason:           "server_shutdown",
+						ReconnectAfterMS: reconnectAfter.Milliseconds(),
+						LastSeq:          cursor,
+					})
+					if err != nil {
+						logger.Error("sse shutdown event encode failed", "run_id", runID, "error", err)
+						return
+					}
+					if _, err := fmt.Fprintf(w, "event: shutdown\nretry: %d\ndata: %s\n\n", reconnectAfter.Milliseconds(), payload); err != nil {
+						return
+					}
+					flusher.Flush()
+					logger.Debug("sse stream closed for shutdown", "run_id", runID, "last_seq", cursor)
+					return
+				case <-ticker.C:
+					if err := writeEvents(); err != nil {
+						logger.Error("sse write failed", "run_id", runID, "error", err)
+						return
+					}
+				}
+			}
+		})
+
+		// ---------------- WEBHOOK DELIVERIES ----------------
+
+		if deps.WebhookRepo != nil {
+			r.With(requireScope(domain.ScopeRunsRead)).Get("/runs/{id}/webhook-deliveries", func(w http.ResponseWriter, r *http.Request) {
+				runID, err := uuid.Parse(chi.URLParam(r, "id"))
+				if err != nil {
+					http.Error(w, "invalid run ID", http.StatusBadRequest)
+					return
+				}
+
+				deliveries, err := deps.WebhookRepo.ListWebhookDeliveries(r.Context(), runID)
+				if err != nil {
+					if errors.Is(err, domain.ErrRunNotFound) {
+						logger.Warn("run not found", "run_id", runID)
+						http.Error(w, "run not found", http.StatusNotFound)
+						return
+					}
+
+					logger.Error("list webhook deliveries failed", "run_id", runID, "error", err)
+					http.Error(w, "failed to list webhook deliveries", http.StatusInternalServerError)
+					return
+				}
 
-			ticker := time.NewTicker(500 * time.Millisecond)
-			defer ticker.Stop()
+				writeJSON(w, http.StatusOK, struct {
+					RunID      string                         `json:"run_id"`
+					Deliveries []domain.WebhookDeliveryRecord `json:"deliveries"`
+				}{
+					RunID:      runID.String(),
+					Deliveries: deliveries,
+				})
+			})
 
-			for {
-				select {
-				case <-r.Context().Done():
+			r.With(requireScope(domain.ScopeRunsWrite)).Post("/webhook-deliveries/{id}/redeliver", func(w http.ResponseWriter, r *http.Request) {
+				deliveryID, err := uuid.Parse(chi.URLParam(r, "id"))
+				if err != nil {
+					http.Error(w, "invalid webhook delivery ID", http.StatusBadRequest)
 					return
-				case <-ticker.C:
-					if err := writeEvents(); err != nil {
-						logger.Error("sse write failed", "run_id", runID, "error", err)
+				}
+
+				delivery, err := deps.WebhookRepo.RedeliverWebhook(r.Context(), deliveryID)
+				if err != nil {
+					if errors.Is(err, domain.ErrWebhookDeliveryNotFound) {
+						http.Error(w, "webhook delivery not found", http.StatusNotFound)
+						return
+					}
+					if errors.Is(err, domain.ErrWebhookDeliveryPending) {
+						http.Error(w, "webhook delivery is still pending", http.StatusConflict)
 						return
 					}
+
+					logger.Error("redeliver webhook failed", "delivery_id", deliveryID, "error", err)
+					http.Error(w, "failed to redeliver webhook", http.StatusInternalServerError)
+					return
 				}
-			}
-		})
+
+				logger.Info("webhook redelivery requested via API", "delivery_id", deliveryID, "run_id", delivery.RunID)
+
+				writeJSON(w, http.StatusAccepted, delivery)
+			})
+		}
 
 		// ---------------- APPROVE RUN ----------------
 
-		r.Post("/runs/{id}/approve", func(w http.ResponseWriter, r *http.Request) {
+		r.With(requireScope(domain.ScopeApprovalsWrite)).Post("/runs/{id}/approve", func(w http.ResponseWriter, r *http.Request) {
 			idStr := chi.URLParam(r, "id")
 
 			runID, err := uuid.Parse(idStr)
@@ -451,7 +2426,7 @@ func NewRouter(deps Deps) http.Handler {
 			}
 
 			if err := deps.RunRepo.ApproveRun(r.Context(), runID); err != nil {
-				if errors.Is(err, pgx.ErrNoRows) {
+				if errors.Is(err, domain.ErrRunNotFound) {
 					logger.Warn("run not found", "run_id", runID)
 					http.Error(w, "run not found", http.StatusNotFound)
 					return
@@ -460,6 +2435,10 @@ func NewRouter(deps Deps) http.Handler {
 					http.Error(w, "only WAITING_APPROVAL runs can be approved", http.StatusConflict)
 					return
 				}
+				if errors.Is(err, domain.ErrInvalidTransition) {
+					http.Error(w, "invalid run status transition", http.StatusConflict)
+					return
+				}
 
 				logger.Error("approve run failed", "run_id", runID, "error", err)
 				http.Error(w, "failed to approve run", http.StatusInternalServerError)
@@ -473,52 +2452,244 @@ func NewRouter(deps Deps) http.Handler {
 				"status": "APPROVED",
 			})
 		})
+
+		// ---------------- APPROVE STEP ----------------
+
+		r.With(requireScope(domain.ScopeApprovalsWrite)).Post("/runs/{id}/approvals/{step_id}", func(w http.ResponseWriter, r *http.Request) {
+			runID, err := uuid.Parse(chi.URLParam(r, "id"))
+			if err != nil {
+				http.Error(w, "invalid run ID", http.StatusBadRequest)
+				return
+			}
+			stepID, err := uuid.Parse(chi.URLParam(r, "step_id"))
+			if err != nil {
+				http.Error(w, "invalid step ID", http.StatusBadRequest)
+				return
+			}
+
+			if err := deps.RunRepo.ApproveStep(r.Context(), runID, stepID); err != nil {
+				writeStepDecisionError(w, logger, err, runID, stepID, "approve")
+				return
+			}
+
+			logger.Info("step approved via API", "run_id", runID, "step_id", stepID)
+
+			writeJSON(w, http.StatusOK, map[string]string{
+				"id":      runID.String(),
+				"step_id": stepID.String(),
+				"status":  "APPROVED",
+			})
+		})
+
+		// ---------------- REJECT STEP ----------------
+
+		r.With(requireScope(domain.ScopeApprovalsWrite)).Post("/runs/{id}/approvals/{step_id}/reject", func(w http.ResponseWriter, r *http.Request) {
+			runID, err := uuid.Parse(chi.URLParam(r, "id"))
+			if err != nil {
+				http.Error(w, "invalid run ID", http.StatusBadRequest)
+				return
+			}
+			stepID, err := uuid.Parse(chi.URLParam(r, "step_id"))
+			if err != nil {
+				http.Error(w, "invalid step ID", http.StatusBadRequest)
+				return
+			}
+
+			if err := deps.RunRepo.RejectStep(r.Context(), runID, stepID); err != nil {
+				writeStepDecisionError(w, logger, err, runID, stepID, "reject")
+				return
+			}
+
+			logger.Info("step rejected via API", "run_id", runID, "step_id", stepID)
+
+			writeJSON(w, http.StatusOK, map[string]string{
+				"id":      runID.String(),
+				"step_id": stepID.String(),
+				"status":  "REJECTED",
+			})
+		})
+
+		// ---------------- VALIDATE WORKFLOW TEMPLATE ----------------
+
+		// Lints steps in the PUT /admin/workflow-templates/{name} body without
+		// saving them. A template with problems is still a 200; the
+		// diagnostics say what is wrong.
+		r.With(requireScope(domain.ScopeRunsRead)).Post("/workflow-templates/validate", func(w http.ResponseWriter, r *http.Request) {
+			var reqBody domain.PutWorkflowTemplateRequest
+			if err := decodeJSONBody(r, &reqBody); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+
+			validation := domain.ValidateWorkflowTemplate(reqBody.Steps)
+			if validation.Valid && deps.Workers != nil {
+				opts, err := domain.ParseCostEstimateOptions(r.URL.Query().Get("runs"), r.URL.Query().Get("map_items"))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				steps, _ := domain.NormalizeWorkflowTemplateSteps(reqBody.Steps)
+				if pricing, err := workerPricing(r.Context(), deps.Workers); err != nil {
+					// The diagnostics still stand; only the estimate is left out.
+					logger.Warn("read worker pricing failed", "error", err)
+				} else {
+					estimate := domain.EstimateTemplateCost(steps, pricing, opts)
+					validation.Estimate = &estimate
+				}
+			}
+
+			writeJSON(w, http.StatusOK, validation)
+		})
+
+		// ---------------- WORKFLOW TEMPLATE COST ESTIMATE ----------------
+
+		if deps.Templates != nil && deps.Workers != nil {
+			r.With(requireScope(domain.ScopeRunsRead)).Get("/workflow-templates/{name}/estimate", func(w http.ResponseWriter, r *http.Request) {
+				name := strings.TrimSpace(chi.URLParam(r, "name"))
+				q := r.URL.Query()
+
+				opts, err := domain.ParseCostEstimateOptions(q.Get("runs"), q.Get("map_items"))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				version := 0
+				if raw := strings.TrimSpace(q.Get("version")); raw != "" {
+					if version, err = strconv.Atoi(raw); err != nil || version <= 0 {
+						http.Error(w, "version must be a positive integer", http.StatusBadRequest)
+						return
+					}
+				}
+
+				versions, err := deps.Templates.ListWorkflowTemplateVersions(r.Context(), name)
+				if err != nil {
+					if errors.Is(err, domain.ErrWorkflowTemplateNotFound) {
+						http.Error(w, "workflow template not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("list workflow template versions failed", "template_name", name, "error", err)
+					http.Error(w, "failed to estimate workflow template cost", http.StatusInternalServerError)
+					return
+				}
+				// Versions are newest first; without a version the latest is
+				// estimated.
+				template := versions[0]
+				if version != 0 {
+					i := slices.IndexFunc(versions, func(v domain.WorkflowTemplateVersion) bool { return v.Version == version })
+					if i < 0 {
+						http.Error(w, "workflow template version not found", http.StatusNotFound)
+						return
+					}
+					template = versions[i]
+				}
+
+				pricing, err := workerPricing(r.Context(), deps.Workers)
+				if err != nil {
+					logger.Error("read worker pricing failed", "template_name", name, "error", err)
+					http.Error(w, "failed to estimate workflow template cost", http.StatusInternalServerError)
+					return
+				}
+
+				estimate := domain.EstimateTemplateCost(template.Steps, pricing, opts)
+				estimate.TemplateName = template.Name
+				estimate.TemplateVersion = template.Version
+				writeJSON(w, http.StatusOK, estimate)
+			})
+		}
+
+		// ---------------- WORKFLOW TEMPLATE VERSIONS ----------------
+
+		if deps.Templates != nil {
+			r.With(requireScope(domain.ScopeRunsRead)).Get("/workflow-templates/{name}/versions", func(w http.ResponseWriter, r *http.Request) {
+				name := strings.TrimSpace(chi.URLParam(r, "name"))
+
+				versions, err := deps.Templates.ListWorkflowTemplateVersions(r.Context(), name)
+				if err != nil {
+					if errors.Is(err, domain.ErrWorkflowTemplateNotFound) {
+						http.Error(w, "workflow template not found", http.StatusNotFound)
+						return
+					}
+					logger.Error("list workflow template versions failed", "template_name", name, "error", err)
+					http.Error(w, "failed to list workflow template versions", http.StatusInternalServerError)
+					return
+				}
+
+				writeJSON(w, http.StatusOK, map[string]any{
+					"name":     name,
+					"versions": versions,
+				})
+			})
+		}
 	})
 
 	return r
 }
 
+// workerPricing returns the step pricing declared by the caller's active
+// workers or, when it has none yet, by any active worker.
+func workerPricing(ctx context.Context, workers WorkerRegistry) (map[domain.StepName]domain.StepPricing, error) {
+	var active []domain.WorkerRecord
+	if apiKeyID, ok := auth.APIKeyIDFromContext(ctx); ok {
+		var err error
+		if active, err = workers.ListActiveWorkers(ctx, apiKeyID); err != nil {
+			return nil, err
+		}
+	}
+	if len(active) == 0 {
+		var err error
+		if active, err = workers.ListAllActiveWorkers(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return domain.StepPricingFromWorkers(active), nil
+}
+
+// writeStepDecisionError answers an approve or reject of an approval step
+// that failed; verb is "approve" or "reject".
+func writeStepDecisionError(w http.ResponseWriter, logger *slog.Logger, err error, runID, stepID uuid.UUID, verb string) {
+	switch {
+	case errors.Is(err, domain.ErrRunNotFound):
+		logger.Warn("run not found", "run_id", runID)
+		http.Error(w, "run not found", http.StatusNotFound)
+	case errors.Is(err, domain.ErrApprovalStepNotFound):
+		http.Error(w, "approval step not found", http.StatusNotFound)
+	case errors.Is(err, domain.ErrRunNotWaitingApproval):
+		http.Error(w, "only WAITING_APPROVAL steps can be "+verb+"d", http.StatusConflict)
+	case errors.Is(err, domain.ErrInvalidTransition):
+		http.Error(w, "invalid run status transition", http.StatusConflict)
+	default:
+		logger.Error(verb+" step failed", "run_id", runID, "step_id", stepID, "error", err)
+		http.Error(w, "failed to "+verb+" step", http.StatusInternalServerError)
+	}
+}
+
 func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(v)
 }
 
-func decodeCreateRunRequest(r *http.Request) (createRunRequest, error) {
+func decodeCreateRunRequest(r *http.Request) (domain.CreateRunParams, error) {
 	if r == nil || r.Body == nil || r.Body == http.NoBody {
-		return createRunRequest{}, nil
+		return domain.CreateRunParams{}, nil
 	}
 
-	var req createRunRequest
+	var req domain.CreateRunRequest
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 	if err := dec.Decode(&req); err != nil {
 		if errors.Is(err, io.EOF) {
-			return createRunRequest{}, nil
+			return domain.CreateRunParams{}, nil
 		}
-		return createRunRequest{}, err
+		return domain.CreateRunParams{}, err
 	}
 
 	// Ensure there is only one JSON object.
 	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
-		return createRunRequest{}, errors.New("request body must contain exactly one JSON object")
-	}
-
-	req.WebhookURL = strings.TrimSpace(req.WebhookURL)
-	req.TemplateName = strings.TrimSpace(req.TemplateName)
-	if req.WebhookURL == "" {
-		return req, nil
+		return domain.CreateRunParams{}, errors.New("request body must contain exactly one JSON object")
 	}
 
-	parsed, err := url.Parse(req.WebhookURL)
-	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
-		return createRunRequest{}, errors.New("invalid webhook_url")
-	}
-	if parsed.Scheme != "http" && parsed.Scheme != "https" {
-		return createRunRequest{}, errors.New("unsupported webhook_url scheme")
-	}
-
-	return req, nil
+	return req.Params()
 }
 
 func decodeCreateAPIKeyRequest(r *http.Request) (createAPIKeyRequest, error) {
@@ -544,8 +2715,192 @@ func decodeCreateAPIKeyRequest(r *http.Request) (createAPIKeyRequest, error) {
 	return req, nil
 }
 
+// writeBodyError answers a request whose body could not be decoded: 413 when
+// it crossed the body size limit, 400 otherwise.
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "invalid request body", http.StatusBadRequest)
+}
+
+// notModified sets a weak ETag from the run's version and answers 304 when
+// If-None-Match already lists it. The version is read before the response
+// body, so a change in between costs the client one more 200, never a stale
+// 304. Without versions, or when the version cannot be read, the handler
+// answers as usual.
+func notModified(w http.ResponseWriter, r *http.Request, versions RunVersionReader, runID uuid.UUID) bool {
+	if versions == nil {
+		return false
+	}
+	version, err := versions.GetRunVersion(r.Context(), runID)
+	if err != nil {
+		return false
+	}
+
+	etag := fmt.Sprintf(`W/"%x-%x"`, version.UpdatedAt.UnixMicro(), version.LastEventSeq)
+	w.Header().Set("ETag", etag)
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using the
+// weak comparison If-None-Match calls for.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for candidate := range strings.SplitSeq(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWebhookTarget answers 400 and returns false when deps.WebhookTargets
+// refuses an address webhookURL's host resolves to.
+func checkWebhookTarget(w http.ResponseWriter, r *http.Request, deps Deps, webhookURL string) bool {
+	if deps.WebhookTargets == nil {
+		return true
+	}
+	err := deps.WebhookTargets.CheckURL(r.Context(), deps.WebhookResolver, webhookURL)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, egress.ErrBlocked):
+		http.Error(w, "webhook_url must not target a private, loopback, or link-local address", http.StatusBadRequest)
+	case errors.Is(err, egress.ErrUnresolvable):
+		http.Error(w, "webhook_url host does not resolve", http.StatusBadRequest)
+	default:
+		http.Error(w, "invalid webhook_url", http.StatusBadRequest)
+	}
+	return false
+}
+
+// decodeJSONBody strictly decodes a single JSON object into dst.
+func decodeJSONBody(r *http.Request, dst any) error {
+	if r == nil || r.Body == nil || r.Body == http.NoBody {
+		return errors.New("request body is required")
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return err
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return errors.New("request body must contain exactly one JSON object")
+	}
+	return nil
+}
+
+// decodeOptionalJSONBody is decodeJSONBody for endpoints whose body may be
+// left out; a missing or empty body leaves dst as it is.
+// writeSubmitRunError answers the errors a run submission is refused with,
+// reporting false for any other error.
+func writeSubmitRunError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, domain.ErrMaxConcurrentRunsExceeded):
+		if w.Header().Get("Retry-After") == "" {
+			w.Header().Set("Retry-After", "1")
+		}
+		if errors.Is(err, domain.ErrMaxConcurrentTemplateRunsExceeded) {
+			http.Error(w, "max concurrent runs for template exceeded", http.StatusTooManyRequests)
+			return true
+		}
+		http.Error(w, "max concurrent runs exceeded", http.StatusTooManyRequests)
+	case errors.Is(err, domain.ErrMonthlyBudgetExceeded):
+		http.Error(w, "monthly budget exceeded", http.StatusPaymentRequired)
+	case errors.Is(err, domain.ErrWorkflowTemplateNotFound):
+		http.Error(w, "workflow template not found", http.StatusBadRequest)
+	case errors.Is(err, domain.ErrInvalidStepInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, domain.ErrIdempotencyKeyReused):
+		http.Error(w, "Idempotency-Key was already used with a different request body", http.StatusUnprocessableEntity)
+	default:
+		return false
+	}
+	return true
+}
+
+func decodeOptionalJSONBody(r *http.Request, dst any) error {
+	if r == nil || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return errors.New("request body must contain exactly one JSON object")
+	}
+	return nil
+}
+
 var errInvalidSinceID = errors.New("invalid since_id")
 
+// parseRunWaitTimeout reads the timeout of a run wait: a Go duration such as
+// 30s, or a number of seconds, up to maxRunWaitTimeout.
+func parseRunWaitTimeout(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return defaultRunWaitTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(raw)
+	if err != nil {
+		seconds, convErr := strconv.ParseInt(raw, 10, 64)
+		if convErr != nil {
+			return 0, errors.New("invalid timeout")
+		}
+		timeout = maxRunWaitTimeout + 1
+		if seconds <= int64(maxRunWaitTimeout/time.Second) {
+			timeout = time.Duration(seconds) * time.Second
+		}
+	}
+	if timeout < 0 || timeout > maxRunWaitTimeout {
+		return 0, fmt.Errorf("timeout must be between 0s and %s", maxRunWaitTimeout)
+	}
+	return timeout, nil
+}
+
+// parseStepLogQuery reads the after cursor and page size of a step log read.
+// Last-Event-ID, sent by reconnecting SSE clients, stands in for after.
+func parseStepLogQuery(r *http.Request) (after int64, limit int, err error) {
+	q := r.URL.Query()
+
+	raw := strings.TrimSpace(q.Get("after"))
+	if raw == "" {
+		raw = strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	}
+	if raw != "" {
+		after, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil || after < 0 {
+			return 0, 0, errors.New("invalid after")
+		}
+	}
+
+	limit = domain.DefaultStepLogPageSize
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > domain.MaxStepLogPageSize {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", domain.MaxStepLogPageSize)
+		}
+	}
+
+	return after, limit, nil
+}
+
 func resolveEventsCursor(
 	ctx context.Context,
 	eventRepo EventStreamer,
@@ -570,7 +2925,7 @@ func resolveEventsCursor(
 
 	seq, err := eventRepo.ResolveCursorByEventID(ctx, runID, eventID)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
+		if errors.Is(err, domain.ErrEventNotFound) {
 			return 0, errInvalidSinceID
 		}
 		return 0, err
@@ -586,3 +2941,188 @@ func valueOrDefault(value, defaultValue string) string {
 	}
 	return trimmed
 }
+
+// parseStatsRange reads the inclusive from/to query dates (YYYY-MM-DD). to
+// defaults to today and from to the defaultStatsRangeDays days ending at to.
+func parseStatsRange(r *http.Request, now time.Time) (time.Time, time.Time, error) {
+	to := now.Truncate(24 * time.Hour)
+	if raw := strings.TrimSpace(r.URL.Query().Get("to")); raw != "" {
+		parsed, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid to date")
+		}
+		to = parsed
+	}
+
+	from := to.AddDate(0, 0, -(defaultStatsRangeDays - 1))
+	if raw := strings.TrimSpace(r.URL.Query().Get("from")); raw != "" {
+		parsed, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid from date")
+		}
+		from = parsed
+	}
+
+	if from.After(to) {
+		return time.Time{}, time.Time{}, errors.New("from must not be after to")
+	}
+	if to.Sub(from) >= maxStatsRangeDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("date range must not exceed %d days", maxStatsRangeDays)
+	}
+
+	return from, to, nil
+}
+
+// parseAuditFilter reads the GET /audit query: action, actor_type, target_id,
+// since and until (RFC 3339), before (a seq cursor), and limit. api_key_id is
+// resolved by the caller.
+func parseAuditFilter(r *http.Request) (domain.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := domain.AuditFilter{
+		Action:    strings.TrimSpace(q.Get("action")),
+		ActorType: strings.TrimSpace(q.Get("actor_type")),
+		TargetID:  strings.TrimSpace(q.Get("target_id")),
+		Limit:     domain.DefaultAuditPageSize,
+	}
+
+	for _, bound := range []struct {
+		name string
+		dst  **time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		raw := strings.TrimSpace(q.Get(bound.name))
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return domain.AuditFilter{}, fmt.Errorf("invalid %s", bound.name)
+		}
+		*bound.dst = &parsed
+	}
+
+	if raw := strings.TrimSpace(q.Get("before")); raw != "" {
+		seq, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || seq <= 0 {
+			return domain.AuditFilter{}, errors.New("invalid before")
+		}
+		filter.BeforeSeq = seq
+	}
+
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > domain.MaxAuditPageSize {
+			return domain.AuditFilter{}, fmt.Errorf("limit must be between 1 and %d", domain.MaxAuditPageSize)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+// parseAPIKeyFilter reads the GET /api-keys query: name_prefix,
+// include_revoked, before (an API key ID cursor), and limit.
+func parseAPIKeyFilter(r *http.Request) (domain.APIKeyFilter, error) {
+	q := r.URL.Query()
+	filter := domain.APIKeyFilter{
+		NamePrefix: strings.TrimSpace(q.Get("name_prefix")),
+		Limit:      domain.DefaultAPIKeyPageSize,
+	}
+
+	if raw := strings.TrimSpace(q.Get("include_revoked")); raw != "" {
+		include, err := strconv.ParseBool(raw)
+		if err != nil {
+			return domain.APIKeyFilter{}, errors.New("invalid include_revoked")
+		}
+		filter.IncludeRevoked = include
+	}
+
+	if raw := strings.TrimSpace(q.Get("before")); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return domain.APIKeyFilter{}, errors.New("invalid before")
+		}
+		filter.BeforeID = &id
+	}
+
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > domain.MaxAPIKeyPageSize {
+			return domain.APIKeyFilter{}, fmt.Errorf("limit must be between 1 and %d", domain.MaxAPIKeyPageSize)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+// parseRunFilter reads the GET /runs query: status, tag (repeatable or
+// comma-separated; a run must carry all of them), metadata.<key>=<value>
+// (repeatable; all must match), before (a run ID cursor), and limit.
+func parseRunFilter(r *http.Request) (domain.RunFilter, error) {
+	q := r.URL.Query()
+	filter := domain.RunFilter{Limit: domain.DefaultRunPageSize}
+
+	if raw := strings.TrimSpace(q.Get("status")); raw != "" {
+		status := domain.RunStatus(strings.ToUpper(raw))
+		switch status {
+		case domain.RunPending, domain.RunRunning, domain.RunWaiting, domain.RunSuccess, domain.RunFailed, domain.RunCanceled:
+			filter.Status = status
+		default:
+			return domain.RunFilter{}, errors.New("invalid status")
+		}
+	}
+
+	var tags []string
+	for _, raw := range q["tag"] {
+		tags = append(tags, strings.Split(raw, ",")...)
+	}
+	if len(tags) > 0 {
+		normalized, err := domain.NormalizeRunTags(tags)
+		if err != nil {
+			return domain.RunFilter{}, errors.New("invalid tag")
+		}
+		filter.Tags = normalized
+	}
+
+	for param, values := range q {
+		key, ok := strings.CutPrefix(param, "metadata.")
+		if !ok {
+			continue
+		}
+		if len(values) != 1 {
+			return domain.RunFilter{}, fmt.Errorf("metadata key %q given more than once", key)
+		}
+		if filter.Metadata == nil {
+			filter.Metadata = make(map[string]string, 2)
+		}
+		filter.Metadata[key] = values[0]
+	}
+	if filter.Metadata != nil {
+		normalized, err := domain.NormalizeRunMetadata(filter.Metadata)
+		if err != nil {
+			return domain.RunFilter{}, errors.New("invalid metadata filter")
+		}
+		filter.Metadata = normalized
+	}
+
+	if raw := strings.TrimSpace(q.Get("before")); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return domain.RunFilter{}, errors.New("invalid before")
+		}
+		filter.BeforeID = &id
+	}
+
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > domain.MaxRunPageSize {
+			return domain.RunFilter{}, fmt.Errorf("limit must be between 1 and %d", domain.MaxRunPageSize)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
@@ -7,18 +7,25 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/adiadia/agent-runtime/internal/audit"
 	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/adiadia/agent-runtime/internal/clock"
 	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/egress"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
 )
 
 func TestRouter_CreateRun(t *testing.T) {
@@ -137,6 +144,49 @@ func TestRouter_CreateRunConcurrentLimitExceeded(t *testing.T) {
 	}
 }
 
+func TestRouter_CreateRunTemplateLimitExceeded(t *testing.T) {
+	runRepo := &mockRunRepo{createErr: fmt.Errorf("%w: template=deploy active=2 limit=2", domain.ErrMaxConcurrentTemplateRunsExceeded)}
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/runs", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429 got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got == "" {
+		t.Fatal("expected Retry-After header to be set")
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "for template") {
+		t.Fatalf("expected template limit message got %q", body)
+	}
+}
+
+func TestRouter_CreateRunMonthlyBudgetExceeded(t *testing.T) {
+	runRepo := &mockRunRepo{createErr: fmt.Errorf("%w: spent=10.0000 budget=10.0000", domain.ErrMonthlyBudgetExceeded)}
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/runs", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected status 402 got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "monthly budget exceeded") {
+		t.Fatalf("unexpected body %q", rec.Body.String())
+	}
+}
+
 func TestRouter_CreateRunWithWebhookURL(t *testing.T) {
 	runID := uuid.New()
 	runRepo := &mockRunRepo{createRunID: runID}
@@ -158,6 +208,247 @@ func TestRouter_CreateRunWithWebhookURL(t *testing.T) {
 	}
 }
 
+func TestRouter_CreateRunWithWebhookEvents(t *testing.T) {
+	runRepo := &mockRunRepo{createRunID: uuid.New()}
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/runs",
+		bytes.NewBufferString(`{"webhook_url":"https://example.com/webhook","webhook_events":["STEP_WAITING_APPROVAL","step_failed_retry","STEP_WAITING_APPROVAL"]}`),
+	)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	want := []string{domain.EventStepWaitingApproval, domain.EventStepFailedRetry}
+	if !reflect.DeepEqual(runRepo.createParams.WebhookEvents, want) {
+		t.Fatalf("expected webhook_events %v to be forwarded, got %v", want, runRepo.createParams.WebhookEvents)
+	}
+}
+
+func TestRouter_CreateRunRejectsInvalidWebhookEvents(t *testing.T) {
+	for name, body := range map[string]string{
+		"unknown type":   `{"webhook_url":"https://example.com/webhook","webhook_events":["NOT_AN_EVENT"]}`,
+		"missing url":    `{"webhook_events":["STEP_WAITING_APPROVAL"]}`,
+		"terminal event": `{"webhook_url":"https://example.com/webhook","webhook_events":["RUN_SUCCEEDED"]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			runRepo := &mockRunRepo{createRunID: uuid.New()}
+			router := NewRouter(Deps{
+				RunRepo:  runRepo,
+				StepRepo: &mockStepLister{},
+				Logger:   discardLogger(),
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/runs", bytes.NewBufferString(body))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status 400 got %d", rec.Code)
+			}
+			if runRepo.createCalled {
+				t.Fatal("expected CreateRun not to be called for invalid webhook_events")
+			}
+		})
+	}
+}
+
+func TestRouter_CreateRunWithMetadataAndTags(t *testing.T) {
+	runRepo := &mockRunRepo{createRunID: uuid.New()}
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/runs",
+		bytes.NewBufferString(`{"metadata":{"customer":"42"},"tags":["nightly"," billing","nightly"]}`),
+	)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if !reflect.DeepEqual(runRepo.createParams.Metadata, map[string]string{"customer": "42"}) {
+		t.Fatalf("expected metadata to be forwarded, got %v", runRepo.createParams.Metadata)
+	}
+	if want := []string{"billing", "nightly"}; !reflect.DeepEqual(runRepo.createParams.Tags, want) {
+		t.Fatalf("expected tags %v got %v", want, runRepo.createParams.Tags)
+	}
+
+	for name, body := range map[string]string{
+		"non-string value": `{"metadata":{"customer":42}}`,
+		"empty key":        `{"metadata":{"":"x"}}`,
+		"tag with space":   `{"tags":["two words"]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			runRepo.createCalled = false
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/runs", bytes.NewBufferString(body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status 400 got %d", rec.Code)
+			}
+			if runRepo.createCalled {
+				t.Fatal("expected CreateRun not to be called")
+			}
+		})
+	}
+}
+
+func TestRouter_ListRuns(t *testing.T) {
+	runs := []domain.RunListItem{
+		{ID: uuid.New(), Status: domain.RunSuccess, Tags: []string{"nightly"}, Metadata: map[string]string{"customer": "42"}},
+		{ID: uuid.New(), Status: domain.RunSuccess, Tags: []string{"nightly"}, Metadata: map[string]string{"customer": "42"}},
+	}
+	runRepo := &mockRunRepo{listRuns: runs}
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	before := uuid.New()
+	req := httptest.NewRequest(http.MethodGet, "/runs?status=succeeded&tag=nightly&tag=billing,nightly&metadata.customer=42&before="+before.String()+"&limit=2", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	want := domain.RunFilter{
+		Status:   domain.RunSuccess,
+		Tags:     []string{"billing", "nightly"},
+		Metadata: map[string]string{"customer": "42"},
+		BeforeID: &before,
+		Limit:    2,
+	}
+	if !reflect.DeepEqual(runRepo.listFilter, want) {
+		t.Fatalf("expected filter %+v got %+v", want, runRepo.listFilter)
+	}
+
+	var resp struct {
+		Runs       []domain.RunListItem `json:"runs"`
+		NextBefore *uuid.UUID           `json:"next_before"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Runs) != 2 {
+		t.Fatalf("expected 2 runs got %d", len(resp.Runs))
+	}
+	if resp.NextBefore == nil || *resp.NextBefore != runs[1].ID {
+		t.Fatalf("expected next_before %s got %v", runs[1].ID, resp.NextBefore)
+	}
+
+	for _, query := range []string{"status=DONE", "tag=a%20b", "before=nope", "limit=0", "metadata.a=1&metadata.a=2"} {
+		runRepo.listCalled = false
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/runs?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected status 400 got %d", query, rec.Code)
+		}
+		if runRepo.listCalled {
+			t.Fatalf("%s: expected ListRuns not to be called", query)
+		}
+	}
+}
+
+func TestRouter_CreateRunReturnsGeneratedWebhookSecret(t *testing.T) {
+	runID := uuid.New()
+	runRepo := &mockRunRepo{createRunID: runID, createSecret: "whsec_generated"}
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/runs", bytes.NewBufferString(`{"webhook_url":"https://example.com/webhook"}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+
+	var resp map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp["run_id"] != runID.String() {
+		t.Fatalf("expected run_id %s got %q", runID, resp["run_id"])
+	}
+	if resp["webhook_secret"] != "whsec_generated" {
+		t.Fatalf("expected generated webhook_secret in response, got %q", resp["webhook_secret"])
+	}
+}
+
+func TestRouter_CreateRunWithWebhookSecret(t *testing.T) {
+	runRepo := &mockRunRepo{createRunID: uuid.New()}
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/runs",
+		bytes.NewBufferString(`{"webhook_url":"https://example.com/webhook","webhook_secret":"my-shared-secret-value"}`),
+	)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if runRepo.createParams.WebhookSecret != "my-shared-secret-value" {
+		t.Fatalf("expected webhook_secret to be forwarded, got %q", runRepo.createParams.WebhookSecret)
+	}
+
+	var resp map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if _, ok := resp["webhook_secret"]; ok {
+		t.Fatalf("expected caller-supplied secret not to be echoed, got %v", resp)
+	}
+}
+
+func TestRouter_CreateRunRejectsShortWebhookSecret(t *testing.T) {
+	runRepo := &mockRunRepo{}
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/runs",
+		bytes.NewBufferString(`{"webhook_url":"https://example.com/webhook","webhook_secret":"short"}`),
+	)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 got %d", rec.Code)
+	}
+	if runRepo.createCalled {
+		t.Fatal("expected run creation to be skipped")
+	}
+}
+
 func TestRouter_CreateRunWithPriorityAndTemplateName(t *testing.T) {
 	runID := uuid.New()
 	runRepo := &mockRunRepo{createRunID: runID}
@@ -243,6 +534,83 @@ func TestRouter_CreateRunRejectsInvalidWebhookURL(t *testing.T) {
 	}
 }
 
+// stubResolver answers every lookup with the same addresses.
+type stubResolver []netip.Addr
+
+func (r stubResolver) LookupNetIP(context.Context, string, string) ([]netip.Addr, error) {
+	return r, nil
+}
+
+func TestRouter_CreateRunChecksWebhookTarget(t *testing.T) {
+	policy := &egress.Policy{Deny: egress.PrivateRanges}
+	tests := []struct {
+		name       string
+		url        string
+		resolver   stubResolver
+		wantStatus int
+	}{
+		{name: "public", url: "https://hooks.example.com/cb", resolver: stubResolver{netip.MustParseAddr("203.0.113.10")}, wantStatus: http.StatusOK},
+		{name: "resolves private", url: "https://hooks.example.com/cb", resolver: stubResolver{netip.MustParseAddr("203.0.113.10"), netip.MustParseAddr("10.0.0.5")}, wantStatus: http.StatusBadRequest},
+		{name: "loopback literal", url: "http://127.0.0.1:9000/cb", wantStatus: http.StatusBadRequest},
+		{name: "metadata literal", url: "http://169.254.169.254/latest", wantStatus: http.StatusBadRequest},
+		{name: "unresolvable", url: "https://nowhere.example/cb", resolver: stubResolver{}, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			runRepo := &mockRunRepo{createRunID: uuid.New()}
+			router := NewRouter(Deps{
+				RunRepo:         runRepo,
+				StepRepo:        &mockStepLister{},
+				Logger:          discardLogger(),
+				WebhookTargets:  policy,
+				WebhookResolver: tc.resolver,
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/runs", bytes.NewBufferString(`{"webhook_url":"`+tc.url+`"}`))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if runRepo.createCalled != (tc.wantStatus == http.StatusOK) {
+				t.Fatalf("unexpected CreateRun call state %v", runRepo.createCalled)
+			}
+		})
+	}
+}
+
+func TestRouter_RejectsOversizedRequestBody(t *testing.T) {
+	runRepo := &mockRunRepo{createRunID: uuid.New()}
+	router := NewRouter(Deps{
+		RunRepo:             runRepo,
+		StepRepo:            &mockStepLister{},
+		Logger:              discardLogger(),
+		MaxRequestBodyBytes: 64,
+	})
+	body := `{"template_name":"` + strings.Repeat("x", 100) + `"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/runs", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status 413 for declared length got %d", rec.Code)
+	}
+
+	// Without a Content-Length the limit is hit while decoding.
+	req = httptest.NewRequest(http.MethodPost, "/runs", io.MultiReader(strings.NewReader(body)))
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status 413 for streamed body got %d", rec.Code)
+	}
+	if runRepo.createCalled {
+		t.Fatal("expected CreateRun not to be called")
+	}
+}
+
 func TestRouter_CreateRunTemplateNotFound(t *testing.T) {
 	runRepo := &mockRunRepo{createErr: domain.ErrWorkflowTemplateNotFound}
 	router := NewRouter(Deps{
@@ -260,6 +628,24 @@ func TestRouter_CreateRunTemplateNotFound(t *testing.T) {
 	}
 }
 
+func TestRouter_CreateRunIdempotencyKeyReusedWithDifferentBody(t *testing.T) {
+	runRepo := &mockRunRepo{createErr: domain.ErrIdempotencyKeyReused}
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/runs", bytes.NewBufferString(`{"priority":9}`))
+	req.Header.Set(headerIdempotencyKey, "idem-1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 got %d", rec.Code)
+	}
+}
+
 func TestRouter_CreateAPIKeyRequiresAdminToken(t *testing.T) {
 	apiKeyAdmin := &mockAPIKeyManager{}
 	router := NewRouter(Deps{
@@ -338,18 +724,8 @@ func TestRouter_CreateAPIKey(t *testing.T) {
 	}
 }
 
-func TestRouter_ListAPIKeys(t *testing.T) {
-	apiKeyAdmin := &mockAPIKeyManager{
-		listResp: []domain.APIKeyRecord{
-			{
-				ID:                uuid.New(),
-				Name:              "key-a",
-				MaxConcurrentRuns: 5,
-				MaxRequestsPerMin: 60,
-				CreatedAt:         time.Now().UTC(),
-			},
-		},
-	}
+func TestRouter_CreateAPIKeyWithScopes(t *testing.T) {
+	apiKeyAdmin := &mockAPIKeyManager{}
 	router := NewRouter(Deps{
 		RunRepo:     &mockRunRepo{},
 		StepRepo:    &mockStepLister{},
@@ -358,7 +734,7 @@ func TestRouter_ListAPIKeys(t *testing.T) {
 		Logger:      discardLogger(),
 	})
 
-	req := httptest.NewRequest(http.MethodGet, "/api-keys", nil)
+	req := httptest.NewRequest(http.MethodPost, "/api-keys", bytes.NewBufferString(`{"name":"dashboard","scopes":["runs:read"]}`))
 	req.Header.Set("Authorization", "Bearer master-token")
 	rec := httptest.NewRecorder()
 	router.ServeHTTP(rec, req)
@@ -366,22 +742,22 @@ func TestRouter_ListAPIKeys(t *testing.T) {
 	if rec.Code != http.StatusOK {
 		t.Fatalf("expected status 200 got %d", rec.Code)
 	}
-	if !apiKeyAdmin.listCalled {
-		t.Fatalf("expected ListAPIKeys to be called")
+	if len(apiKeyAdmin.createParams.Scopes) != 1 || apiKeyAdmin.createParams.Scopes[0] != domain.ScopeRunsRead {
+		t.Fatalf("expected scopes to be forwarded, got %v", apiKeyAdmin.createParams.Scopes)
 	}
 
-	var resp struct {
-		APIKeys []domain.APIKeyRecord `json:"api_keys"`
-	}
-	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
-		t.Fatalf("decode response: %v", err)
-	}
-	if len(resp.APIKeys) != 1 {
-		t.Fatalf("expected 1 api key got %d", len(resp.APIKeys))
+	apiKeyAdmin.createErr = domain.ErrInvalidScope
+	req = httptest.NewRequest(http.MethodPost, "/api-keys", bytes.NewBufferString(`{"name":"bad","scopes":["runs:delete"]}`))
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for invalid scope got %d", rec.Code)
 	}
 }
 
-func TestRouter_DeleteAPIKey(t *testing.T) {
+func TestRouter_CreateAPIKeyWithSlug(t *testing.T) {
 	apiKeyAdmin := &mockAPIKeyManager{}
 	router := NewRouter(Deps{
 		RunRepo:     &mockRunRepo{},
@@ -391,576 +767,3446 @@ func TestRouter_DeleteAPIKey(t *testing.T) {
 		Logger:      discardLogger(),
 	})
 
-	apiKeyID := uuid.New()
-	req := httptest.NewRequest(http.MethodDelete, "/api-keys/"+apiKeyID.String(), nil)
+	req := httptest.NewRequest(http.MethodPost, "/api-keys", bytes.NewBufferString(`{"name":"acme","slug":"acme-prod"}`))
 	req.Header.Set("Authorization", "Bearer master-token")
 	rec := httptest.NewRecorder()
 	router.ServeHTTP(rec, req)
 
-	if rec.Code != http.StatusNoContent {
-		t.Fatalf("expected status 204 got %d", rec.Code)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
 	}
-	if apiKeyAdmin.revokeID != apiKeyID {
-		t.Fatalf("expected revoke id %s got %s", apiKeyID, apiKeyAdmin.revokeID)
+	if apiKeyAdmin.createParams.Slug != "acme-prod" {
+		t.Fatalf("expected slug to be forwarded, got %q", apiKeyAdmin.createParams.Slug)
 	}
-}
 
-func TestRouter_HealthzUnauthenticated(t *testing.T) {
-	router := NewRouter(Deps{
-		RunRepo:        &mockRunRepo{},
-		StepRepo:       &mockStepLister{},
+	apiKeyAdmin.createErr = domain.ErrAPIKeySlugTaken
+	req = httptest.NewRequest(http.MethodPost, "/api-keys", bytes.NewBufferString(`{"name":"acme-2","slug":"acme-prod"}`))
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected status 409 for taken slug got %d", rec.Code)
+	}
+}
+
+func TestRouter_RotateWebhookSigningKey(t *testing.T) {
+	apiKeyID := uuid.New()
+	tests := []struct {
+		name        string
+		body        string
+		err         error
+		wantStatus  int
+		wantOverlap time.Duration
+	}{
+		{name: "default overlap", wantStatus: http.StatusOK, wantOverlap: domain.DefaultWebhookSigningKeyOverlap},
+		{name: "explicit overlap", body: `{"overlap_seconds":3600}`, wantStatus: http.StatusOK, wantOverlap: time.Hour},
+		{name: "negative overlap", body: `{"overlap_seconds":-1}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown field", body: `{"overlap":1}`, wantStatus: http.StatusBadRequest},
+		{name: "not found", err: domain.ErrAPIKeyNotFound, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			apiKeyAdmin := &mockAPIKeyManager{rotateErr: tc.err}
+			router := NewRouter(Deps{
+				RunRepo:     &mockRunRepo{},
+				StepRepo:    &mockStepLister{},
+				APIKeyAdmin: apiKeyAdmin,
+				AdminToken:  "master-token",
+				Logger:      discardLogger(),
+			})
+
+			var body io.Reader
+			if tc.body != "" {
+				body = bytes.NewBufferString(tc.body)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api-keys/"+apiKeyID.String()+"/webhook-secrets", body)
+			req.Header.Set("Authorization", "Bearer master-token")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d got %d", tc.wantStatus, rec.Code)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			if apiKeyAdmin.rotateOverlap != tc.wantOverlap {
+				t.Fatalf("expected overlap %s got %s", tc.wantOverlap, apiKeyAdmin.rotateOverlap)
+			}
+			var resp domain.WebhookSigningKey
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if resp.KeyID != "k2" || resp.Secret == "" {
+				t.Fatalf("expected new key id and secret in response, got %+v", resp)
+			}
+		})
+	}
+}
+
+func TestRouter_ExpireWebhookSigningKey(t *testing.T) {
+	apiKeyID := uuid.New()
+	apiKeyAdmin := &mockAPIKeyManager{}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: apiKeyAdmin,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	tests := []struct {
+		keyID      string
+		err        error
+		wantStatus int
+	}{
+		{keyID: "k1", wantStatus: http.StatusNoContent},
+		{keyID: "k1", err: domain.ErrWebhookSigningKeyNotFound, wantStatus: http.StatusNotFound},
+		{keyID: "v1", wantStatus: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		apiKeyAdmin.expireErr = tc.err
+		req := httptest.NewRequest(http.MethodDelete, "/api-keys/"+apiKeyID.String()+"/webhook-secrets/"+tc.keyID, nil)
+		req.Header.Set("Authorization", "Bearer master-token")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tc.wantStatus {
+			t.Fatalf("%s: expected status %d got %d", tc.keyID, tc.wantStatus, rec.Code)
+		}
+	}
+	if apiKeyAdmin.expireVersion != 1 {
+		t.Fatalf("expected version 1 to be expired, got %d", apiKeyAdmin.expireVersion)
+	}
+}
+
+func TestRouter_SetScopes(t *testing.T) {
+	apiKeyID := uuid.New()
+	tests := []struct {
+		name       string
+		body       string
+		err        error
+		wantStatus int
+	}{
+		{name: "ok", body: `{"scopes":["approvals:write"]}`, wantStatus: http.StatusOK},
+		{name: "invalid scope", body: `{"scopes":["admin"]}`, wantStatus: http.StatusBadRequest},
+		{name: "not found", body: `{"scopes":["runs:read"]}`, err: domain.ErrAPIKeyNotFound, wantStatus: http.StatusNotFound},
+		{name: "store failure", body: `{"scopes":["runs:read"]}`, err: errors.New("db down"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			apiKeyAdmin := &mockAPIKeyManager{scopesErr: tc.err}
+			router := NewRouter(Deps{
+				RunRepo:     &mockRunRepo{},
+				StepRepo:    &mockStepLister{},
+				APIKeyAdmin: apiKeyAdmin,
+				AdminToken:  "master-token",
+				Logger:      discardLogger(),
+			})
+
+			req := httptest.NewRequest(http.MethodPut, "/api-keys/"+apiKeyID.String()+"/scopes", bytes.NewBufferString(tc.body))
+			req.Header.Set("Authorization", "Bearer master-token")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d got %d", tc.wantStatus, rec.Code)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			if apiKeyAdmin.scopesID != apiKeyID {
+				t.Fatalf("expected api key id %s got %s", apiKeyID, apiKeyAdmin.scopesID)
+			}
+			var resp struct {
+				Scopes []string `json:"scopes"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if len(resp.Scopes) != 1 || resp.Scopes[0] != domain.ScopeApprovalsWrite {
+				t.Fatalf("expected scopes [approvals:write] got %v", resp.Scopes)
+			}
+		})
+	}
+}
+
+func TestRouter_SetAllowedCIDRs(t *testing.T) {
+	apiKeyID := uuid.New()
+	tests := []struct {
+		name       string
+		body       string
+		err        error
+		wantStatus int
+		want       []string
+	}{
+		{name: "ok", body: `{"allowed_cidrs":["192.0.2.7","10.0.0.0/8"]}`, wantStatus: http.StatusOK, want: []string{"10.0.0.0/8", "192.0.2.7/32"}},
+		{name: "clear", body: `{"allowed_cidrs":null}`, wantStatus: http.StatusOK},
+		{name: "invalid cidr", body: `{"allowed_cidrs":["10.0.0.0/40"]}`, wantStatus: http.StatusBadRequest},
+		{name: "not found", body: `{"allowed_cidrs":[]}`, err: domain.ErrAPIKeyNotFound, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			apiKeyAdmin := &mockAPIKeyManager{cidrsErr: tc.err}
+			router := NewRouter(Deps{
+				RunRepo:     &mockRunRepo{},
+				StepRepo:    &mockStepLister{},
+				APIKeyAdmin: apiKeyAdmin,
+				AdminToken:  "master-token",
+				Logger:      discardLogger(),
+			})
+
+			req := httptest.NewRequest(http.MethodPut, "/api-keys/"+apiKeyID.String()+"/allowed-cidrs", bytes.NewBufferString(tc.body))
+			req.Header.Set("Authorization", "Bearer master-token")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d got %d", tc.wantStatus, rec.Code)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			if apiKeyAdmin.cidrsID != apiKeyID {
+				t.Fatalf("expected api key id %s got %s", apiKeyID, apiKeyAdmin.cidrsID)
+			}
+			var resp struct {
+				AllowedCIDRs []string `json:"allowed_cidrs"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if !slices.Equal(resp.AllowedCIDRs, tc.want) {
+				t.Fatalf("expected allowed_cidrs %v got %v", tc.want, resp.AllowedCIDRs)
+			}
+		})
+	}
+}
+
+func TestRouter_SetEgressAllowHosts(t *testing.T) {
+	apiKeyID := uuid.New()
+	tests := []struct {
+		name       string
+		body       string
+		err        error
+		wantStatus int
+		want       []string
+	}{
+		{name: "ok", body: `{"egress_allow_hosts":["API.example.com:443","*.svc.example","10.0.0.0/8"]}`, wantStatus: http.StatusOK, want: []string{"*.svc.example", "10.0.0.0/8", "api.example.com:443"}},
+		{name: "clear", body: `{"egress_allow_hosts":null}`, wantStatus: http.StatusOK},
+		{name: "invalid host", body: `{"egress_allow_hosts":["api.example.com:99999"]}`, wantStatus: http.StatusBadRequest},
+		{name: "not found", body: `{"egress_allow_hosts":[]}`, err: domain.ErrAPIKeyNotFound, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			apiKeyAdmin := &mockAPIKeyManager{egressHostsErr: tc.err}
+			router := NewRouter(Deps{
+				RunRepo:     &mockRunRepo{},
+				StepRepo:    &mockStepLister{},
+				APIKeyAdmin: apiKeyAdmin,
+				AdminToken:  "master-token",
+				Logger:      discardLogger(),
+			})
+
+			req := httptest.NewRequest(http.MethodPut, "/api-keys/"+apiKeyID.String()+"/egress-allow-hosts", bytes.NewBufferString(tc.body))
+			req.Header.Set("Authorization", "Bearer master-token")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d got %d", tc.wantStatus, rec.Code)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			if apiKeyAdmin.egressHostsID != apiKeyID {
+				t.Fatalf("expected api key id %s got %s", apiKeyID, apiKeyAdmin.egressHostsID)
+			}
+			var resp struct {
+				EgressAllowHosts []string `json:"egress_allow_hosts"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if !slices.Equal(resp.EgressAllowHosts, tc.want) {
+				t.Fatalf("expected egress_allow_hosts %v got %v", tc.want, resp.EgressAllowHosts)
+			}
+		})
+	}
+}
+
+func TestRouter_SetAPIKeySlug(t *testing.T) {
+	apiKeyID := uuid.New()
+
+	tests := []struct {
+		name       string
+		body       string
+		err        error
+		wantStatus int
+	}{
+		{name: "ok", body: `{"slug":"acme-prod"}`, wantStatus: http.StatusOK},
+		{name: "clear", body: `{"slug":""}`, wantStatus: http.StatusOK},
+		{name: "invalid slug", body: `{"slug":"Acme Prod"}`, wantStatus: http.StatusBadRequest},
+		{name: "taken", body: `{"slug":"acme-prod"}`, err: domain.ErrAPIKeySlugTaken, wantStatus: http.StatusConflict},
+		{name: "not found", body: `{"slug":"acme-prod"}`, err: domain.ErrAPIKeyNotFound, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			apiKeyAdmin := &mockAPIKeyManager{slugErr: tc.err}
+			router := NewRouter(Deps{
+				RunRepo:     &mockRunRepo{},
+				StepRepo:    &mockStepLister{},
+				APIKeyAdmin: apiKeyAdmin,
+				AdminToken:  "master-token",
+				Logger:      discardLogger(),
+			})
+
+			req := httptest.NewRequest(http.MethodPut, "/api-keys/"+apiKeyID.String()+"/slug", bytes.NewBufferString(tc.body))
+			req.Header.Set("Authorization", "Bearer master-token")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d got %d", tc.wantStatus, rec.Code)
+			}
+			if tc.wantStatus == http.StatusOK && apiKeyAdmin.slugID != apiKeyID {
+				t.Fatalf("expected api key id %s got %s", apiKeyID, apiKeyAdmin.slugID)
+			}
+		})
+	}
+}
+
+func TestRouter_AdminRoutesAcceptAPIKeySlug(t *testing.T) {
+	apiKeyID := uuid.New()
+	apiKeyAdmin := &mockAPIKeyManager{
+		slugIDs: map[string]uuid.UUID{"acme-prod": apiKeyID},
+		getResp: domain.APIKeyRecord{ID: apiKeyID, Name: "acme"},
+	}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: apiKeyAdmin,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{path: "/api-keys/acme-prod", wantStatus: http.StatusOK},
+		{path: "/api-keys/acme-staging", wantStatus: http.StatusNotFound},
+		{path: "/api-keys/Acme_Prod", wantStatus: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Header.Set("Authorization", "Bearer master-token")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tc.wantStatus {
+			t.Fatalf("%s: expected status %d got %d", tc.path, tc.wantStatus, rec.Code)
+		}
+	}
+	if apiKeyAdmin.getID != apiKeyID {
+		t.Fatalf("expected slug to resolve to %s got %s", apiKeyID, apiKeyAdmin.getID)
+	}
+}
+
+func TestRouter_ListAPIKeys(t *testing.T) {
+	apiKeyAdmin := &mockAPIKeyManager{
+		listResp: []domain.APIKeyRecord{
+			{
+				ID:                uuid.New(),
+				Name:              "key-a",
+				MaxConcurrentRuns: 5,
+				MaxRequestsPerMin: 60,
+				CreatedAt:         time.Now().UTC(),
+			},
+		},
+	}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: apiKeyAdmin,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api-keys", nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if !apiKeyAdmin.listCalled {
+		t.Fatalf("expected ListAPIKeys to be called")
+	}
+
+	var resp struct {
+		APIKeys []domain.APIKeyRecord `json:"api_keys"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.APIKeys) != 1 {
+		t.Fatalf("expected 1 api key got %d", len(resp.APIKeys))
+	}
+	if want := (domain.APIKeyFilter{Limit: domain.DefaultAPIKeyPageSize}); !reflect.DeepEqual(apiKeyAdmin.listFilter, want) {
+		t.Fatalf("expected default filter %+v got %+v", want, apiKeyAdmin.listFilter)
+	}
+}
+
+func TestRouter_ListAPIKeysPagination(t *testing.T) {
+	lastID := uuid.New()
+	apiKeyAdmin := &mockAPIKeyManager{
+		listResp: []domain.APIKeyRecord{{ID: uuid.New(), Name: "ci-a"}, {ID: lastID, Name: "ci-b"}},
+	}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: apiKeyAdmin,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	before := uuid.New()
+	req := httptest.NewRequest(http.MethodGet, "/api-keys?name_prefix=ci-&include_revoked=true&limit=2&before="+before.String(), nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	want := domain.APIKeyFilter{NamePrefix: "ci-", IncludeRevoked: true, BeforeID: &before, Limit: 2}
+	if !reflect.DeepEqual(apiKeyAdmin.listFilter, want) {
+		t.Fatalf("expected filter %+v got %+v", want, apiKeyAdmin.listFilter)
+	}
+	var resp struct {
+		NextBefore uuid.UUID `json:"next_before"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.NextBefore != lastID {
+		t.Fatalf("expected next_before %s got %s", lastID, resp.NextBefore)
+	}
+
+	for _, query := range []string{"limit=0", "limit=501", "before=nope", "include_revoked=maybe"} {
+		req := httptest.NewRequest(http.MethodGet, "/api-keys?"+query, nil)
+		req.Header.Set("Authorization", "Bearer master-token")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected status 400 got %d", query, rec.Code)
+		}
+	}
+
+	// A cursor naming no key is rejected rather than read as the last page.
+	apiKeyAdmin.listErr = domain.ErrAPIKeyNotFound
+	req = httptest.NewRequest(http.MethodGet, "/api-keys?before="+uuid.NewString(), nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown before: expected status 400 got %d", rec.Code)
+	}
+}
+
+func TestRouter_DeleteAPIKey(t *testing.T) {
+	apiKeyAdmin := &mockAPIKeyManager{}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: apiKeyAdmin,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	apiKeyID := uuid.New()
+	req := httptest.NewRequest(http.MethodDelete, "/api-keys/"+apiKeyID.String(), nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204 got %d", rec.Code)
+	}
+	if apiKeyAdmin.revokeID != apiKeyID {
+		t.Fatalf("expected revoke id %s got %s", apiKeyID, apiKeyAdmin.revokeID)
+	}
+}
+
+func TestRouter_GetAPIKeyIncludesEffectiveRetention(t *testing.T) {
+	apiKeyID := uuid.New()
+	override := 7
+	apiKeyAdmin := &mockAPIKeyManager{
+		getResp: domain.APIKeyRecord{ID: apiKeyID, Name: "free-tier", EventRetentionDays: &override},
+	}
+	router := NewRouter(Deps{
+		RunRepo:            &mockRunRepo{},
+		StepRepo:           &mockStepLister{},
+		APIKeyAdmin:        apiKeyAdmin,
+		AdminToken:         "master-token",
+		EventRetentionDays: 90,
+		Logger:             discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api-keys/"+apiKeyID.String(), nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if apiKeyAdmin.getID != apiKeyID {
+		t.Fatalf("expected get id %s got %s", apiKeyID, apiKeyAdmin.getID)
+	}
+
+	var resp domain.APIKeyRecord
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.EffectiveEventRetentionDays != 7 {
+		t.Fatalf("expected effective retention 7 got %d", resp.EffectiveEventRetentionDays)
+	}
+}
+
+func TestRouter_GetAPIKeyNotFound(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: &mockAPIKeyManager{getErr: domain.ErrAPIKeyNotFound},
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api-keys/"+uuid.NewString(), nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 got %d", rec.Code)
+	}
+}
+
+func TestRouter_SetEventRetention(t *testing.T) {
+	apiKeyID := uuid.New()
+	apiKeyAdmin := &mockAPIKeyManager{}
+	router := NewRouter(Deps{
+		RunRepo:            &mockRunRepo{},
+		StepRepo:           &mockStepLister{},
+		APIKeyAdmin:        apiKeyAdmin,
+		AdminToken:         "master-token",
+		EventRetentionDays: 30,
+		Logger:             discardLogger(),
+	})
+
+	req := httptest.NewRequest(
+		http.MethodPut,
+		"/api-keys/"+apiKeyID.String()+"/retention",
+		bytes.NewBufferString(`{"event_retention_days":365}`),
+	)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if apiKeyAdmin.retentionID != apiKeyID {
+		t.Fatalf("expected retention id %s got %s", apiKeyID, apiKeyAdmin.retentionID)
+	}
+	if apiKeyAdmin.retentionDays == nil || *apiKeyAdmin.retentionDays != 365 {
+		t.Fatalf("expected retention days 365 to be forwarded, got %v", apiKeyAdmin.retentionDays)
+	}
+
+	var resp eventRetentionPolicy
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.DefaultEventRetentionDays != 30 || resp.EffectiveEventRetentionDays != 365 {
+		t.Fatalf("unexpected retention policy: %+v", resp)
+	}
+
+	// A null override falls back to the deployment default.
+	req = httptest.NewRequest(
+		http.MethodPut,
+		"/api-keys/"+apiKeyID.String()+"/retention",
+		bytes.NewBufferString(`{"event_retention_days":null}`),
+	)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if apiKeyAdmin.retentionDays != nil {
+		t.Fatalf("expected cleared override, got %v", *apiKeyAdmin.retentionDays)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.EffectiveEventRetentionDays != 30 {
+		t.Fatalf("expected effective retention to fall back to 30, got %d", resp.EffectiveEventRetentionDays)
+	}
+}
+
+func TestRouter_SetRunRetention(t *testing.T) {
+	apiKeyID := uuid.New()
+	apiKeyAdmin := &mockAPIKeyManager{}
+	router := NewRouter(Deps{
+		RunRepo:          &mockRunRepo{},
+		StepRepo:         &mockStepLister{},
+		APIKeyAdmin:      apiKeyAdmin,
+		AdminToken:       "master-token",
+		RunRetentionDays: 90,
+		Logger:           discardLogger(),
+	})
+
+	req := httptest.NewRequest(
+		http.MethodPut,
+		"/api-keys/"+apiKeyID.String()+"/run-retention",
+		bytes.NewBufferString(`{"run_retention_days":7}`),
+	)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if apiKeyAdmin.retentionID != apiKeyID || apiKeyAdmin.runRetention == nil || *apiKeyAdmin.runRetention != 7 {
+		t.Fatalf("expected run retention 7 for %s, got %v for %s", apiKeyID, apiKeyAdmin.runRetention, apiKeyAdmin.retentionID)
+	}
+
+	var resp runRetentionPolicy
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.DefaultRunRetentionDays != 90 || resp.EffectiveRunRetentionDays != 7 {
+		t.Fatalf("unexpected run retention policy: %+v", resp)
+	}
+
+	apiKeyAdmin.runRetErr = domain.ErrInvalidRunRetention
+	req = httptest.NewRequest(
+		http.MethodPut,
+		"/api-keys/"+apiKeyID.String()+"/run-retention",
+		bytes.NewBufferString(`{"run_retention_days":0}`),
+	)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 got %d", rec.Code)
+	}
+}
+
+func TestRouter_SetMonthlyBudget(t *testing.T) {
+	apiKeyID := uuid.New()
+	apiKeyAdmin := &mockAPIKeyManager{}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: apiKeyAdmin,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api-keys/"+apiKeyID.String()+"/budget", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer master-token")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := put(`{"monthly_budget_usd":25.5}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if apiKeyAdmin.budgetID != apiKeyID || apiKeyAdmin.budgetUSD == nil || *apiKeyAdmin.budgetUSD != 25.5 {
+		t.Fatalf("expected budget 25.5 for %s, got %v for %s", apiKeyID, apiKeyAdmin.budgetUSD, apiKeyAdmin.budgetID)
+	}
+	var resp domain.MonthlyBudget
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.LimitUSD == nil || *resp.LimitUSD != 25.5 || resp.SpentUSD != 4.5 {
+		t.Fatalf("unexpected budget response: %+v", resp)
+	}
+
+	if rec := put(`{"monthly_budget_usd":null}`); rec.Code != http.StatusOK || apiKeyAdmin.budgetUSD != nil {
+		t.Fatalf("expected cleared budget, got status %d budget %v", rec.Code, apiKeyAdmin.budgetUSD)
+	}
+	if rec := put(`{"monthly_budget_usd":0}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for zero budget got %d", rec.Code)
+	}
+}
+
+func TestRouter_SetSchedulingWeight(t *testing.T) {
+	apiKeyID := uuid.New()
+	apiKeyAdmin := &mockAPIKeyManager{}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: apiKeyAdmin,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api-keys/"+apiKeyID.String()+"/scheduling-weight", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer master-token")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := put(`{"scheduling_weight":4}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if apiKeyAdmin.weightID != apiKeyID || apiKeyAdmin.weight != 4 {
+		t.Fatalf("expected weight 4 for %s, got %d for %s", apiKeyID, apiKeyAdmin.weight, apiKeyAdmin.weightID)
+	}
+
+	for _, body := range []string{`{}`, `{"scheduling_weight":0}`, `{"scheduling_weight":1001}`} {
+		if rec := put(body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected status 400 got %d", body, rec.Code)
+		}
+	}
+}
+
+func TestRouter_SetTemplateRunLimits(t *testing.T) {
+	apiKeyID := uuid.New()
+	apiKeyAdmin := &mockAPIKeyManager{}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: apiKeyAdmin,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api-keys/"+apiKeyID.String()+"/template-run-limits", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer master-token")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := put(`{"max_concurrent_runs_per_template":{" deploy ":2}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if apiKeyAdmin.templateLimitsID != apiKeyID {
+		t.Fatalf("expected limits set for %s, got %s", apiKeyID, apiKeyAdmin.templateLimitsID)
+	}
+	var resp struct {
+		Limits map[string]int `json:"max_concurrent_runs_per_template"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Limits) != 1 || resp.Limits["deploy"] != 2 {
+		t.Fatalf("unexpected limits %v", resp.Limits)
+	}
+
+	if rec := put(`{}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 clearing limits got %d", rec.Code)
+	}
+	for _, body := range []string{`{"max_concurrent_runs_per_template":{"deploy":0}}`, `{"max_concurrent_runs_per_template":{"":1}}`} {
+		if rec := put(body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected status 400 got %d", body, rec.Code)
+		}
+	}
+}
+
+func TestRouter_ListTenantScheduling(t *testing.T) {
+	apiKeyID := uuid.New()
+	apiKeyAdmin := &mockAPIKeyManager{scheduling: []domain.TenantScheduling{
+		{APIKeyID: apiKeyID, Name: "acme", SchedulingWeight: 3, WeightShare: 0.75, Claims: 12, ClaimShare: 0.8},
+	}}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: apiKeyAdmin,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/scheduling", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without admin token got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/scheduling", nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	var resp struct {
+		Tenants []domain.TenantScheduling `json:"tenants"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Tenants) != 1 || resp.Tenants[0].APIKeyID != apiKeyID || resp.Tenants[0].Claims != 12 {
+		t.Fatalf("unexpected tenants %+v", resp.Tenants)
+	}
+}
+
+func TestRouter_SetEventRetentionRejectsInvalidDays(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: &mockAPIKeyManager{retentionErr: domain.ErrInvalidEventRetention},
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	req := httptest.NewRequest(
+		http.MethodPut,
+		"/api-keys/"+uuid.NewString()+"/retention",
+		bytes.NewBufferString(`{"event_retention_days":0}`),
+	)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 got %d", rec.Code)
+	}
+}
+
+func TestRouter_SetWebhookDefaults(t *testing.T) {
+	apiKeyID := uuid.New()
+	webhookURL := "https://example.com/hooks"
+	apiKeyAdmin := &mockAPIKeyManager{
+		webhookResp: domain.WebhookDefaults{
+			APIKeyID:         apiKeyID,
+			WebhookURL:       &webhookURL,
+			HasWebhookSecret: true,
+			WebhookSecret:    "whsec_generated",
+		},
+	}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: apiKeyAdmin,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	req := httptest.NewRequest(
+		http.MethodPut,
+		"/api-keys/"+apiKeyID.String()+"/webhook",
+		bytes.NewBufferString(`{"webhook_url":" https://example.com/hooks ","generate_secret":true}`),
+	)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if apiKeyAdmin.webhookID != apiKeyID {
+		t.Fatalf("expected api key id %s got %s", apiKeyID, apiKeyAdmin.webhookID)
+	}
+	want := domain.SetWebhookDefaultsParams{WebhookURL: webhookURL, GenerateSecret: true}
+	if apiKeyAdmin.webhookParams != want {
+		t.Fatalf("expected params %+v got %+v", want, apiKeyAdmin.webhookParams)
+	}
+
+	var resp domain.WebhookDefaults
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.WebhookSecret != "whsec_generated" || !resp.HasWebhookSecret {
+		t.Fatalf("expected generated secret in response, got %+v", resp)
+	}
+}
+
+func TestRouter_SetWebhookDefaultsErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		err        error
+		wantStatus int
+	}{
+		{name: "invalid url", body: `{"webhook_url":"ftp://example.com"}`, wantStatus: http.StatusBadRequest},
+		{name: "private target", body: `{"webhook_url":"http://192.168.1.20/hooks"}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid secret", body: `{"webhook_secret":"short"}`, err: domain.ErrInvalidWebhookSecret, wantStatus: http.StatusBadRequest},
+		{name: "unknown field", body: `{"secret":"x"}`, wantStatus: http.StatusBadRequest},
+		{name: "not found", body: `{}`, err: domain.ErrAPIKeyNotFound, wantStatus: http.StatusNotFound},
+		{name: "store failure", body: `{}`, err: errors.New("db down"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			router := NewRouter(Deps{
+				RunRepo:        &mockRunRepo{},
+				StepRepo:       &mockStepLister{},
+				APIKeyAdmin:    &mockAPIKeyManager{webhookErr: tc.err},
+				AdminToken:     "master-token",
+				Logger:         discardLogger(),
+				WebhookTargets: &egress.Policy{Deny: egress.PrivateRanges},
+			})
+
+			req := httptest.NewRequest(http.MethodPut, "/api-keys/"+uuid.NewString()+"/webhook", bytes.NewBufferString(tc.body))
+			req.Header.Set("Authorization", "Bearer master-token")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d got %d", tc.wantStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRouter_GetAPIKeyStats(t *testing.T) {
+	apiKeyID := uuid.New()
+	stats := &mockRunStats{resp: []domain.DailyRunStats{
+		{Day: "2026-03-01", RunsCreated: 2, RunsSucceeded: 1, TotalCostUSD: 1.5, TotalDurationSeconds: 30, AvgDurationSeconds: 30},
+		{Day: "2026-03-02", RunsCreated: 1, RunsFailed: 1, TotalCostUSD: 0.5, TotalDurationSeconds: 10, AvgDurationSeconds: 10},
+	}}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: &mockAPIKeyManager{},
+		RunStats:    stats,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api-keys/"+apiKeyID.String()+"/stats?from=2026-03-01&to=2026-03-07", nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if stats.apiKeyID != apiKeyID {
+		t.Fatalf("expected api key id %s got %s", apiKeyID, stats.apiKeyID)
+	}
+	if got := stats.from.Format(time.DateOnly); got != "2026-03-01" {
+		t.Fatalf("expected from 2026-03-01 got %s", got)
+	}
+	if got := stats.to.Format(time.DateOnly); got != "2026-03-07" {
+		t.Fatalf("expected to 2026-03-07 got %s", got)
+	}
+
+	var body runStatsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(body.Days) != 2 {
+		t.Fatalf("expected 2 days got %d", len(body.Days))
+	}
+	if body.Totals.RunsCreated != 3 || body.Totals.TotalCostUSD != 2.0 || body.Totals.AvgDurationSeconds != 20 {
+		t.Fatalf("unexpected totals: %+v", body.Totals)
+	}
+}
+
+func TestRouter_GetAPIKeyStatsWarnsOnMixedWorkerVersions(t *testing.T) {
+	apiKeyID := uuid.New()
+	workers := &mockWorkerRegistry{resp: []domain.WorkerRecord{
+		{ID: uuid.New(), APIKeyID: apiKeyID, Version: "v1.4.0", MinSchemaVersion: 22, Features: []string{"run_summary"}},
+		{ID: uuid.New(), APIKeyID: apiKeyID, Version: "v1.3.0", MinSchemaVersion: 22, Features: []string{"run_summary"}},
+	}}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: &mockAPIKeyManager{},
+		RunStats:    &mockRunStats{},
+		Workers:     workers,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api-keys/"+apiKeyID.String()+"/stats", nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if workers.apiKeyID != apiKeyID {
+		t.Fatalf("expected workers listed for %s got %s", apiKeyID, workers.apiKeyID)
+	}
+
+	var body runStatsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(body.ActiveWorkers) != 2 {
+		t.Fatalf("expected 2 active workers got %d", len(body.ActiveWorkers))
+	}
+	if len(body.Warnings) != 1 || body.Warnings[0] != "mixed worker versions active: v1.3.0, v1.4.0" {
+		t.Fatalf("unexpected warnings: %v", body.Warnings)
+	}
+}
+
+func TestRouter_ListWorkers(t *testing.T) {
+	apiKeyID := uuid.New()
+	workers := &mockWorkerRegistry{resp: []domain.WorkerRecord{
+		{ID: uuid.New(), APIKeyID: apiKeyID, Version: "v1.4.0", ActiveLeases: 2},
+	}}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: &mockAPIKeyManager{},
+		Workers:     workers,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/workers", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without admin token got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/workers", nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if !workers.listedAll {
+		t.Fatal("expected workers of all tenants listed")
+	}
+
+	var body struct {
+		Workers []domain.WorkerRecord `json:"workers"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(body.Workers) != 1 || body.Workers[0].ActiveLeases != 2 {
+		t.Fatalf("unexpected workers: %+v", body.Workers)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/workers?api_key_id="+apiKeyID.String(), nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if workers.apiKeyID != apiKeyID {
+		t.Fatalf("expected workers listed for %s got %s", apiKeyID, workers.apiKeyID)
+	}
+}
+
+func TestRouter_GetWorkerLiveness(t *testing.T) {
+	orphaned := uuid.New()
+	workers := &mockWorkerRegistry{liveness: domain.WorkerLiveness{
+		Workers: []domain.WorkerRecord{
+			{ID: uuid.New(), APIKeyID: uuid.New(), InFlightSteps: 1},
+			{ID: uuid.New(), APIKeyID: orphaned, Stale: true},
+		},
+		StaleWorkers:             1,
+		TenantsWithoutLiveWorker: []uuid.UUID{orphaned},
+	}}
+	router := NewRouter(Deps{
+		RunRepo:    &mockRunRepo{},
+		StepRepo:   &mockStepLister{},
+		Workers:    workers,
+		AdminToken: "master-token",
+		Logger:     discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/workers", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without admin token got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/workers", nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+
+	var body domain.WorkerLiveness
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(body.Workers) != 2 || body.Workers[0].InFlightSteps != 1 || !body.Workers[1].Stale {
+		t.Fatalf("unexpected workers: %+v", body.Workers)
+	}
+	if body.StaleWorkers != 1 || len(body.TenantsWithoutLiveWorker) != 1 || body.TenantsWithoutLiveWorker[0] != orphaned {
+		t.Fatalf("unexpected liveness: %+v", body)
+	}
+}
+
+func TestRouter_GetAPIKeyStatsDefaultRangeUsesClock(t *testing.T) {
+	stats := &mockRunStats{}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: &mockAPIKeyManager{},
+		RunStats:    stats,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+		Clock:       clock.NewFake(time.Date(2026, 3, 31, 18, 30, 0, 0, time.UTC)),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api-keys/"+uuid.NewString()+"/stats", nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if got := stats.from.Format(time.DateOnly); got != "2026-03-02" {
+		t.Fatalf("expected from 2026-03-02 got %s", got)
+	}
+	if got := stats.to.Format(time.DateOnly); got != "2026-03-31" {
+		t.Fatalf("expected to 2026-03-31 got %s", got)
+	}
+}
+
+func TestRouter_GetAPIKeyStatsRejectsInvalidRange(t *testing.T) {
+	for name, query := range map[string]string{
+		"bad date":       "?from=03-01-2026",
+		"inverted range": "?from=2026-03-07&to=2026-03-01",
+		"too long":       "?from=2025-01-01&to=2026-03-01",
+	} {
+		t.Run(name, func(t *testing.T) {
+			stats := &mockRunStats{}
+			router := NewRouter(Deps{
+				RunRepo:     &mockRunRepo{},
+				StepRepo:    &mockStepLister{},
+				APIKeyAdmin: &mockAPIKeyManager{},
+				RunStats:    stats,
+				AdminToken:  "master-token",
+				Logger:      discardLogger(),
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api-keys/"+uuid.NewString()+"/stats"+query, nil)
+			req.Header.Set("Authorization", "Bearer master-token")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status 400 got %d", rec.Code)
+			}
+			if stats.called {
+				t.Fatal("expected stats not to be queried for invalid range")
+			}
+		})
+	}
+}
+
+func TestRouter_GetAPIKeyStatsNotFound(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: &mockAPIKeyManager{},
+		RunStats:    &mockRunStats{err: domain.ErrAPIKeyNotFound},
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api-keys/"+uuid.NewString()+"/stats", nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 got %d", rec.Code)
+	}
+}
+
+func TestParseStatsRangeDefaultsToLast30Days(t *testing.T) {
+	now := time.Date(2026, 3, 15, 17, 30, 0, 0, time.UTC)
+	req := httptest.NewRequest(http.MethodGet, "/api-keys/x/stats", nil)
+
+	from, to, err := parseStatsRange(req, now)
+	if err != nil {
+		t.Fatalf("parse range: %v", err)
+	}
+	if got := to.Format(time.DateOnly); got != "2026-03-15" {
+		t.Fatalf("expected to=2026-03-15 got %s", got)
+	}
+	if got := from.Format(time.DateOnly); got != "2026-02-14" {
+		t.Fatalf("expected from=2026-02-14 got %s", got)
+	}
+}
+
+func TestRouter_PurgeTenantReturnsSignedReport(t *testing.T) {
+	apiKeyID := uuid.New()
+	purger := &mockTenantPurger{resp: domain.SignedPurgeReport{
+		Report: domain.TenantPurgeReport{
+			ID:       uuid.New(),
+			APIKeyID: apiKeyID,
+			Deleted:  domain.PurgeCounts{Runs: 2, Steps: 6, Events: 9},
+		},
+		Signature:          "abc123",
+		SignatureAlgorithm: domain.PurgeReportSignatureAlgorithm,
+	}}
+	router := NewRouter(Deps{
+		RunRepo:         &mockRunRepo{},
+		StepRepo:        &mockStepLister{},
+		TenantPurger:    purger,
+		AdminToken:      "master-token",
+		PurgeSigningKey: "report-key",
+		Logger:          discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/tenants/"+apiKeyID.String()+"/purge", nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if purger.apiKeyID != apiKeyID {
+		t.Fatalf("expected purge of %s got %s", apiKeyID, purger.apiKeyID)
+	}
+	if string(purger.signingKey) != "report-key" {
+		t.Fatalf("expected configured signing key to be forwarded, got %q", purger.signingKey)
+	}
+
+	var body domain.SignedPurgeReport
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if body.Signature != "abc123" || body.Report.Deleted.Runs != 2 {
+		t.Fatalf("unexpected purge report: %+v", body)
+	}
+}
+
+func TestRouter_PurgeTenantRequiresAdminToken(t *testing.T) {
+	purger := &mockTenantPurger{}
+	router := NewRouter(Deps{
+		RunRepo:         &mockRunRepo{},
+		StepRepo:        &mockStepLister{},
+		TenantPurger:    purger,
+		AdminToken:      "master-token",
+		PurgeSigningKey: "report-key",
+		Logger:          discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/tenants/"+uuid.NewString()+"/purge", nil)
+	req.Header.Set("Authorization", "Bearer wrong-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 got %d", rec.Code)
+	}
+	if purger.called {
+		t.Fatal("expected purge not to run without admin token")
+	}
+}
+
+func TestRouter_PurgeTenantErrors(t *testing.T) {
+	tests := map[string]struct {
+		err  error
+		want int
+	}{
+		"not found":       {err: domain.ErrAPIKeyNotFound, want: http.StatusNotFound},
+		"no signing key":  {err: domain.ErrPurgeSigningKeyMissing, want: http.StatusServiceUnavailable},
+		"repository fail": {err: errors.New("boom"), want: http.StatusInternalServerError},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := NewRouter(Deps{
+				RunRepo:      &mockRunRepo{},
+				StepRepo:     &mockStepLister{},
+				TenantPurger: &mockTenantPurger{err: tt.err},
+				AdminToken:   "master-token",
+				Logger:       discardLogger(),
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/tenants/"+uuid.NewString()+"/purge", nil)
+			req.Header.Set("Authorization", "Bearer master-token")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("expected status %d got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRouter_AttachesAuditActor(t *testing.T) {
+	apiKeyID := uuid.New()
+	runRepo := &mockRunRepo{createRunID: uuid.New()}
+	purger := &mockTenantPurger{}
+	router := NewRouter(Deps{
+		RunRepo:      runRepo,
+		StepRepo:     &mockStepLister{},
+		TenantPurger: purger,
+		AdminToken:   "master-token",
+		Logger:       discardLogger(),
+		APIKeyResolver: &mockAPIKeyResolver{
+			keyByToken: map[string]auth.APIKey{
+				"secret": {ID: apiKeyID, Scopes: domain.AllScopes},
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/runs", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set(headerRequestID, "req-1")
+	req.RemoteAddr = "198.51.100.7:4000"
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+
+	actor, ok := audit.ActorFromContext(runRepo.createCtx)
+	if !ok {
+		t.Fatal("expected audit actor on run create context")
+	}
+	if actor.Type != domain.AuditActorAPIKey || actor.ID == nil || *actor.ID != apiKeyID {
+		t.Fatalf("expected api key actor %s, got %+v", apiKeyID, actor)
+	}
+	if actor.IP != "198.51.100.7" || actor.RequestID != "req-1" {
+		t.Fatalf("expected ip and request id on actor, got %+v", actor)
+	}
+
+	purgeReq := httptest.NewRequest(http.MethodPost, "/admin/tenants/"+apiKeyID.String()+"/purge", nil)
+	purgeReq.Header.Set("Authorization", "Bearer master-token")
+	router.ServeHTTP(httptest.NewRecorder(), purgeReq)
+
+	actor, ok = audit.ActorFromContext(purger.ctx)
+	if !ok || actor.Type != domain.AuditActorAdmin || actor.ID != nil {
+		t.Fatalf("expected admin actor on purge context, got %+v", actor)
+	}
+}
+
+func TestRouter_ListAuditEntries(t *testing.T) {
+	apiKeyID := uuid.New()
+	auditLog := &mockAuditLog{resp: []domain.AuditEntry{
+		{Seq: 42, ID: uuid.New(), Action: domain.AuditRunCancel, ActorType: domain.AuditActorAPIKey},
+	}}
+	router := NewRouter(Deps{
+		RunRepo:    &mockRunRepo{},
+		StepRepo:   &mockStepLister{},
+		AuditLog:   auditLog,
+		AdminToken: "master-token",
+		Logger:     discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet,
+		"/audit?api_key_id="+apiKeyID.String()+"&action=run.cancel&since=2026-03-01T00:00:00Z&before=100&limit=1", nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	filter := auditLog.filter
+	if filter.APIKeyID == nil || *filter.APIKeyID != apiKeyID {
+		t.Fatalf("expected api key filter %s, got %+v", apiKeyID, filter.APIKeyID)
+	}
+	if filter.Action != domain.AuditRunCancel || filter.BeforeSeq != 100 || filter.Limit != 1 {
+		t.Fatalf("unexpected filter: %+v", filter)
+	}
+	if filter.Since == nil || !filter.Since.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected since filter, got %v", filter.Since)
+	}
+
+	var body struct {
+		Entries    []domain.AuditEntry `json:"entries"`
+		NextBefore int64               `json:"next_before"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(body.Entries) != 1 || body.NextBefore != 42 {
+		t.Fatalf("expected one entry and next_before=42, got %+v", body)
+	}
+}
+
+func TestRouter_ListAuditEntriesRejectsBadRequests(t *testing.T) {
+	tests := map[string]struct {
+		query string
+		token string
+		want  int
+	}{
+		"missing admin token": {query: "", token: "wrong", want: http.StatusUnauthorized},
+		"invalid since":       {query: "?since=yesterday", token: "master-token", want: http.StatusBadRequest},
+		"invalid before":      {query: "?before=abc", token: "master-token", want: http.StatusBadRequest},
+		"limit too large":     {query: "?limit=5000", token: "master-token", want: http.StatusBadRequest},
+		"invalid api key":     {query: "?api_key_id=Not_A_Key", token: "master-token", want: http.StatusBadRequest},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			auditLog := &mockAuditLog{}
+			router := NewRouter(Deps{
+				RunRepo:     &mockRunRepo{},
+				StepRepo:    &mockStepLister{},
+				AuditLog:    auditLog,
+				APIKeyAdmin: &mockAPIKeyManager{},
+				AdminToken:  "master-token",
+				Logger:      discardLogger(),
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/audit"+tt.query, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("expected status %d got %d", tt.want, rec.Code)
+			}
+			if auditLog.called {
+				t.Fatal("expected audit log not to be queried")
+			}
+		})
+	}
+}
+
+func TestRouter_HealthzUnauthenticated(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:        &mockRunRepo{},
+		StepRepo:       &mockStepLister{},
+		Logger:         discardLogger(),
+		APIKeyResolver: &mockAPIKeyResolver{},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if got := rec.Header().Get(headerRequestID); got == "" {
+		t.Fatalf("expected %s response header to be set", headerRequestID)
+	}
+}
+
+func TestRouter_HealthzPreservesRequestID(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set(headerRequestID, "req-from-client")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if got := rec.Header().Get(headerRequestID); got != "req-from-client" {
+		t.Fatalf("expected %s req-from-client got %q", headerRequestID, got)
+	}
+}
+
+func TestRouter_HealthzNotReadyWhenSchemaCheckFails(t *testing.T) {
+	healthChecker := &mockHealthChecker{err: errors.New("schema missing")}
+	router := NewRouter(Deps{
+		RunRepo:       &mockRunRepo{},
+		StepRepo:      &mockStepLister{},
+		Logger:        discardLogger(),
+		HealthChecker: healthChecker,
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503 got %d", rec.Code)
+	}
+	if healthChecker.calls != 1 {
+		t.Fatalf("expected health checker call count 1 got %d", healthChecker.calls)
+	}
+}
+
+func TestRouter_ReadyzUnauthenticated(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:        &mockRunRepo{},
+		StepRepo:       &mockStepLister{},
+		Logger:         discardLogger(),
+		APIKeyResolver: &mockAPIKeyResolver{},
+		HealthChecker:  &mockHealthChecker{},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body["status"] != "ready" {
+		t.Fatalf("expected status ready, got %v", body)
+	}
+}
+
+type detailedHealthError struct{}
+
+func (detailedHealthError) Error() string { return "schema drift: missing indexes: idx_steps_run_id" }
+
+func (detailedHealthError) ReadinessDetail() any {
+	return map[string][]string{"missing_indexes": {"idx_steps_run_id"}}
+}
+
+func TestRouter_ReadyzReportsDetail(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:       &mockRunRepo{},
+		StepRepo:      &mockStepLister{},
+		Logger:        discardLogger(),
+		HealthChecker: &mockHealthChecker{err: fmt.Errorf("check schema: %w", detailedHealthError{})},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503 got %d", rec.Code)
+	}
+	var body struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Detail struct {
+			MissingIndexes []string `json:"missing_indexes"`
+		} `json:"detail"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body.Status != "not_ready" || !strings.Contains(body.Error, "idx_steps_run_id") {
+		t.Fatalf("unexpected body %s", rec.Body.String())
+	}
+	if len(body.Detail.MissingIndexes) != 1 || body.Detail.MissingIndexes[0] != "idx_steps_run_id" {
+		t.Fatalf("expected drift detail, got %s", rec.Body.String())
+	}
+}
+
+func TestRouter_MetricsUnauthenticated(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:        &mockRunRepo{},
+		StepRepo:       &mockStepLister{},
+		Logger:         discardLogger(),
+		APIKeyResolver: &mockAPIKeyResolver{},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "runs_total") {
+		t.Fatalf("expected prometheus output to include runs_total metric, got %q", rec.Body.String())
+	}
+}
+
+func TestRouter_VersionUnauthenticated(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:        &mockRunRepo{},
+		StepRepo:       &mockStepLister{},
 		Logger:         discardLogger(),
 		APIKeyResolver: &mockAPIKeyResolver{},
+		Version:        "1.2.3",
+		Commit:         "abc123",
+		BuildDate:      "2026-02-23T00:00:00Z",
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+
+	var resp map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	if resp["version"] != "1.2.3" {
+		t.Fatalf("expected version 1.2.3 got %q", resp["version"])
+	}
+	if resp["commit"] != "abc123" {
+		t.Fatalf("expected commit abc123 got %q", resp["commit"])
+	}
+	if resp["build_date"] != "2026-02-23T00:00:00Z" {
+		t.Fatalf("expected build_date 2026-02-23T00:00:00Z got %q", resp["build_date"])
+	}
+}
+
+func TestRouter_GetRunNotFound(t *testing.T) {
+	runRepo := &mockRunRepo{getRunErr: domain.ErrRunNotFound}
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+uuid.New().String(), nil)
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 got %d", rec.Code)
+	}
+
+	if runRepo.getRunID == uuid.Nil {
+		t.Fatalf("expected GetRun to be called")
+	}
+}
+
+func TestRouter_GetRunError(t *testing.T) {
+	runRepo := &mockRunRepo{getRunErr: errors.New("db failed")}
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+uuid.New().String(), nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500 got %d", rec.Code)
+	}
+}
+
+func TestRouter_GetRunSuccess(t *testing.T) {
+	runID := uuid.New()
+	runRepo := &mockRunRepo{getRunStatus: domain.RunRunning}
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String(), nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+
+	var resp struct {
+		ID               string                `json:"id"`
+		Status           string                `json:"status"`
+		PendingApprovals []domain.ApprovalGate `json:"pending_approvals"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	if resp.ID != runID.String() {
+		t.Fatalf("expected id %s got %s", runID, resp.ID)
+	}
+
+	if resp.Status != string(domain.RunRunning) {
+		t.Fatalf("expected status %s got %s", domain.RunRunning, resp.Status)
+	}
+
+	if resp.PendingApprovals == nil || len(resp.PendingApprovals) != 0 {
+		t.Fatalf("expected empty pending_approvals got %+v", resp.PendingApprovals)
+	}
+}
+
+func TestRouter_GetRunAndStepsETag(t *testing.T) {
+	runID := uuid.New()
+	runRepo := &mockRunRepo{getRunStatus: domain.RunRunning}
+	versions := &mockRunVersionReader{version: domain.RunVersion{
+		UpdatedAt:    time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		LastEventSeq: 42,
+	}}
+	router := NewRouter(Deps{
+		RunRepo:     runRepo,
+		StepRepo:    &mockStepLister{},
+		RunVersions: versions,
+		Logger:      discardLogger(),
+	})
+
+	for _, path := range []string{"/runs/" + runID.String(), "/runs/" + runID.String() + "/steps"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		etag := rec.Header().Get("ETag")
+		if rec.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+			t.Fatalf("%s: expected 200 with a weak ETag, got %d %q", path, rec.Code, etag)
+		}
+		if versions.runID != runID {
+			t.Fatalf("%s: expected the version of run %s, got %s", path, runID, versions.runID)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("If-None-Match", `"stale", `+strings.TrimPrefix(etag, "W/"))
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+			t.Fatalf("%s: expected 304 with the same ETag, got %d %q", path, rec.Code, rec.Header().Get("ETag"))
+		}
+	}
+
+	versions.version.LastEventSeq++
+	runRepo.getRunID = uuid.Nil
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String(), nil)
+	req.Header.Set("If-None-Match", `W/"`+fmt.Sprintf("%x-%x", versions.version.UpdatedAt.UnixMicro(), 42)+`"`)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || runRepo.getRunID != runID {
+		t.Fatalf("expected a new event to invalidate the ETag, got %d", rec.Code)
+	}
+}
+
+func TestRouter_GetRunReportsPendingApprovals(t *testing.T) {
+	runID := uuid.New()
+	waitingSince := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	gates := []domain.ApprovalGate{
+		{StepID: uuid.New(), Name: "legal", Status: domain.StepWaiting, WaitingSince: &waitingSince},
+		{StepID: uuid.New(), Name: "finance", Status: domain.StepPending},
+	}
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{getRunStatus: domain.RunRunning, pendingApprovals: gates},
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String(), nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+
+	var resp struct {
+		PendingApprovals []domain.ApprovalGate `json:"pending_approvals"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.PendingApprovals) != 2 {
+		t.Fatalf("expected 2 pending approvals got %d", len(resp.PendingApprovals))
+	}
+	if resp.PendingApprovals[0].StepID != gates[0].StepID || resp.PendingApprovals[0].Name != "legal" ||
+		resp.PendingApprovals[0].Status != domain.StepWaiting || resp.PendingApprovals[0].WaitingSince == nil {
+		t.Fatalf("unexpected first gate %+v", resp.PendingApprovals[0])
+	}
+	if resp.PendingApprovals[1].Name != "finance" || resp.PendingApprovals[1].WaitingSince != nil {
+		t.Fatalf("unexpected second gate %+v", resp.PendingApprovals[1])
+	}
+}
+
+func TestRouter_GetRunInvalidID(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/not-a-uuid", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 got %d", rec.Code)
+	}
+}
+
+func TestRouter_GetRunCost(t *testing.T) {
+	runID := uuid.New()
+	runRepo := &mockRunRepo{
+		getRunCost: domain.RunCostBreakdown{
+			RunID:        runID,
+			TotalCostUSD: 1.2345,
+			Steps: []domain.StepCostBreakdown{
+				{ID: uuid.New(), Name: string(domain.StepLLM), Status: string(domain.StepSuccess), CostUSD: 1.2345, CostDetail: &domain.CostDetail{
+					Provider:         "openai",
+					Model:            "gpt-4o",
+					PromptTokens:     1000,
+					CompletionTokens: 200,
+					UnitPriceUSD:     0.00102875,
+					CostUSD:          1.2345,
+				}},
+			},
+			ByModel: []domain.ModelCost{
+				{Provider: "openai", Model: "gpt-4o", Steps: 1, PromptTokens: 1000, CompletionTokens: 200, CostUSD: 1.2345},
+			},
+		},
+	}
+
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/cost", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+
+	var resp domain.RunCostBreakdown
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.RunID != runID {
+		t.Fatalf("expected run_id %s got %s", runID, resp.RunID)
+	}
+	if resp.TotalCostUSD != 1.2345 {
+		t.Fatalf("expected total_cost_usd 1.2345 got %f", resp.TotalCostUSD)
+	}
+	if len(resp.Steps) != 1 {
+		t.Fatalf("expected 1 step cost entry got %d", len(resp.Steps))
+	}
+	if detail := resp.Steps[0].CostDetail; detail == nil || detail.Model != "gpt-4o" || detail.PromptTokens != 1000 {
+		t.Fatalf("expected step cost_detail, got %+v", detail)
+	}
+	if len(resp.ByModel) != 1 || resp.ByModel[0].Provider != "openai" || resp.ByModel[0].CompletionTokens != 200 {
+		t.Fatalf("expected by_model breakdown, got %+v", resp.ByModel)
+	}
+}
+
+func TestRouter_GetRunCostNotFound(t *testing.T) {
+	runID := uuid.New()
+	runRepo := &mockRunRepo{getRunCostErr: domain.ErrRunNotFound}
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/cost", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 got %d", rec.Code)
+	}
+}
+
+func TestRouter_GetUsageBucketsByMonth(t *testing.T) {
+	apiKeyID := uuid.New()
+	stats := &mockRunStats{resp: []domain.DailyRunStats{
+		{Day: "2026-02-27", RunsCreated: 2, RunsSucceeded: 2, StepsExecuted: 6, StepRetries: 1, TotalCostUSD: 1.5},
+		{Day: "2026-03-01", RunsCreated: 1, RunsFailed: 1, StepsExecuted: 2, StepRetries: 2, TotalCostUSD: 0.5},
+	}}
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{},
+		RunStats: stats,
+		Logger:   discardLogger(),
+		APIKeyResolver: &mockAPIKeyResolver{
+			keyByToken: map[string]auth.APIKey{
+				"secret": {ID: apiKeyID, MaxRequestsPerMin: 60, Scopes: domain.AllScopes},
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/usage?bucket=month&from=2026-02-01&to=2026-03-31", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if stats.apiKeyID != apiKeyID {
+		t.Fatalf("expected usage for authenticated key %s got %s", apiKeyID, stats.apiKeyID)
+	}
+
+	var body usageResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if body.Bucket != domain.UsageBucketMonth || len(body.Buckets) != 2 {
+		t.Fatalf("expected 2 monthly buckets got %+v", body)
+	}
+	if body.Buckets[0].Period != "2026-02" || body.Buckets[1].Period != "2026-03" {
+		t.Fatalf("unexpected periods: %+v", body.Buckets)
+	}
+	if body.Totals.RunsCreated != 3 || body.Totals.StepsExecuted != 8 || body.Totals.StepRetries != 3 || body.Totals.TotalCostUSD != 2.0 {
+		t.Fatalf("unexpected totals: %+v", body.Totals)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/usage?bucket=week", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for invalid bucket got %d", rec.Code)
+	}
+}
+
+func TestRouter_GetTokenUsage(t *testing.T) {
+	apiKeyID := uuid.New()
+	usage := &mockTokenUsage{resp: []domain.TokenUsage{
+		{Period: "2026-02-27", Provider: "openai", Model: "gpt-4o", Calls: 2, PromptTokens: 300, CompletionTokens: 100, TotalLatencyMS: 800},
+		{Period: "2026-03-01", Provider: "openai", Model: "gpt-4o", Calls: 1, PromptTokens: 50, CompletionTokens: 25, TotalLatencyMS: 400},
+		{Period: "2026-03-01", Provider: "anthropic", Model: "claude", Calls: 1, PromptTokens: 10, CompletionTokens: 5, TotalLatencyMS: 200},
+	}}
+	router := NewRouter(Deps{
+		RunRepo:    &mockRunRepo{},
+		StepRepo:   &mockStepLister{},
+		TokenUsage: usage,
+		Logger:     discardLogger(),
+		APIKeyResolver: &mockAPIKeyResolver{
+			keyByToken: map[string]auth.APIKey{
+				"secret": {ID: apiKeyID, MaxRequestsPerMin: 60, Scopes: domain.AllScopes},
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/usage/tokens?bucket=month&from=2026-02-01&to=2026-03-31", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if usage.apiKeyID != apiKeyID || usage.from.Format(time.DateOnly) != "2026-02-01" || usage.to.Format(time.DateOnly) != "2026-03-31" {
+		t.Fatalf("unexpected query: key %s from %s to %s", usage.apiKeyID, usage.from, usage.to)
+	}
+
+	var body domain.TokenUsageReport
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if body.From != "2026-02-01" || body.To != "2026-03-31" || body.Bucket != domain.UsageBucketMonth {
+		t.Fatalf("unexpected range: %+v", body)
+	}
+	if body.Totals.Calls != 4 || body.Totals.TotalTokens != 490 || body.Totals.AvgLatencyMS != 350 {
+		t.Fatalf("unexpected totals: %+v", body.Totals)
+	}
+	if len(body.ByModel) != 2 || body.ByModel[0].Model != "gpt-4o" || body.ByModel[0].PromptTokens != 350 {
+		t.Fatalf("unexpected models: %+v", body.ByModel)
+	}
+	if len(body.Buckets) != 3 || body.Buckets[0].Period != "2026-02" || body.Buckets[1].Period != "2026-03" {
+		t.Fatalf("unexpected buckets: %+v", body.Buckets)
+	}
+
+	for _, query := range []string{"?bucket=week", "?from=2026-03-07&to=2026-03-01"} {
+		usage.called = false
+		req = httptest.NewRequest(http.MethodGet, "/usage/tokens"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest || usage.called {
+			t.Fatalf("%s: expected status 400 without a query, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestRouter_ListSteps(t *testing.T) {
+	runID := uuid.New()
+	steps := []domain.StepRecord{
+		{ID: uuid.New(), Name: "demo", Status: string(domain.StepPending)},
+	}
+
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{steps: steps},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/steps", nil)
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+
+	var resp struct {
+		RunID string              `json:"run_id"`
+		Steps []domain.StepRecord `json:"steps"`
+	}
+
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	if resp.RunID != runID.String() {
+		t.Fatalf("expected run id %s got %s", runID, resp.RunID)
+	}
+
+	if len(resp.Steps) != len(steps) {
+		t.Fatalf("expected %d steps got %d", len(steps), len(resp.Steps))
+	}
+}
+
+func TestRouter_ListStepsError(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{err: errors.New("query failed")},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+uuid.New().String()+"/steps", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500 got %d", rec.Code)
+	}
+}
+
+func TestRouter_ListWebhookDeliveries(t *testing.T) {
+	runID := uuid.New()
+	statusCode := http.StatusInternalServerError
+	lastError := "non-2xx response: 500"
+	webhookRepo := &mockWebhookRepo{
+		deliveries: []domain.WebhookDeliveryRecord{{
+			ID:             uuid.New(),
+			RunID:          runID,
+			EventType:      "RUN_SUCCEEDED",
+			URL:            "https://example.com/hook",
+			Status:         domain.WebhookPending,
+			Attempts:       1,
+			MaxAttempts:    8,
+			LastStatusCode: &statusCode,
+			LastError:      &lastError,
+			AttemptLog: []domain.WebhookAttemptRecord{
+				{Attempt: 1, StatusCode: &statusCode, LatencyMS: 42, Error: &lastError},
+			},
+		}},
+	}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		WebhookRepo: webhookRepo,
+		Logger:      discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/webhook-deliveries", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if webhookRepo.listRunID != runID {
+		t.Fatalf("expected run id %s got %s", runID, webhookRepo.listRunID)
+	}
+
+	var resp struct {
+		RunID      string                         `json:"run_id"`
+		Deliveries []domain.WebhookDeliveryRecord `json:"deliveries"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Deliveries) != 1 || len(resp.Deliveries[0].AttemptLog) != 1 {
+		t.Fatalf("expected one delivery with one attempt, got %+v", resp.Deliveries)
+	}
+	if got := resp.Deliveries[0].AttemptLog[0]; got.LatencyMS != 42 || got.StatusCode == nil || *got.StatusCode != statusCode {
+		t.Fatalf("unexpected attempt record %+v", got)
+	}
+}
+
+func TestRouter_ListWebhookDeliveriesNotFound(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		WebhookRepo: &mockWebhookRepo{listErr: domain.ErrRunNotFound},
+		Logger:      discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+uuid.NewString()+"/webhook-deliveries", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 got %d", rec.Code)
+	}
+}
+
+func TestRouter_RedeliverWebhook(t *testing.T) {
+	deliveryID := uuid.New()
+	webhookRepo := &mockWebhookRepo{
+		redeliverResp: domain.WebhookDeliveryRecord{ID: deliveryID, Status: domain.WebhookPending, Attempts: 8, MaxAttempts: 9},
+	}
+	router := NewRouter(Deps{
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		WebhookRepo: webhookRepo,
+		Logger:      discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook-deliveries/"+deliveryID.String()+"/redeliver", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202 got %d", rec.Code)
+	}
+	if webhookRepo.redeliverID != deliveryID {
+		t.Fatalf("expected delivery id %s got %s", deliveryID, webhookRepo.redeliverID)
+	}
+
+	var resp domain.WebhookDeliveryRecord
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Status != domain.WebhookPending || resp.MaxAttempts != 9 {
+		t.Fatalf("unexpected redelivery response %+v", resp)
+	}
+}
+
+func TestRouter_RedeliverWebhookErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		err        error
+		wantStatus int
+	}{
+		{name: "invalid id", path: "/webhook-deliveries/not-a-uuid/redeliver", wantStatus: http.StatusBadRequest},
+		{name: "not found", path: "/webhook-deliveries/" + uuid.NewString() + "/redeliver", err: domain.ErrWebhookDeliveryNotFound, wantStatus: http.StatusNotFound},
+		{name: "still pending", path: "/webhook-deliveries/" + uuid.NewString() + "/redeliver", err: domain.ErrWebhookDeliveryPending, wantStatus: http.StatusConflict},
+		{name: "store failure", path: "/webhook-deliveries/" + uuid.NewString() + "/redeliver", err: errors.New("db down"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			router := NewRouter(Deps{
+				RunRepo:     &mockRunRepo{},
+				StepRepo:    &mockStepLister{},
+				WebhookRepo: &mockWebhookRepo{redeliverErr: tc.err},
+				Logger:      discardLogger(),
+			})
+
+			req := httptest.NewRequest(http.MethodPost, tc.path, nil)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d got %d", tc.wantStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRouter_ListStepsNotFound(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{err: domain.ErrRunNotFound},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+uuid.New().String()+"/steps", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 got %d", rec.Code)
+	}
+}
+
+func TestRouter_ListStepsInvalidID(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/not-a-uuid/steps", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 got %d", rec.Code)
+	}
+}
+
+func TestRouter_StreamEvents(t *testing.T) {
+	runID := uuid.New()
+	ev := domain.EventRecord{
+		ID:        uuid.New(),
+		Seq:       1,
+		RunID:     runID,
+		Type:      "STEP_CLAIMED",
+		Payload:   mustStatusPayload(t, domain.StepRunning),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{getRunStatus: domain.RunRunning},
+		StepRepo: &mockStepLister{},
+		EventRepo: &mockEventRepo{
+			eventsByAfter: map[int64][]domain.EventRecord{
+				0: []domain.EventRecord{ev},
+			},
+		},
+		Logger: discardLogger(),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/events", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(rec, req)
+		close(done)
+	}()
+
+	time.Sleep(30 * time.Millisecond)
+	cancel()
+	<-done
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "event: step_update") {
+		t.Fatalf("expected SSE event line, got body %q", body)
+	}
+	if !strings.Contains(body, ev.ID.String()) {
+		t.Fatalf("expected SSE payload to include event id %s, got body %q", ev.ID, body)
+	}
+}
+
+func TestRouter_ListStepLogs(t *testing.T) {
+	runID, stepID := uuid.New(), uuid.New()
+	logs := &mockStepLogReader{pages: map[int64]domain.StepLogPage{
+		5: {
+			StepStatus: domain.StepRunning,
+			Lines: []domain.StepLogLine{
+				{Seq: 6, StepID: stepID, Attempt: 1, Level: domain.StepLogInfo, Line: "fetching"},
+				{Seq: 9, StepID: stepID, Attempt: 1, Level: domain.StepLogWarn, Line: "slow upstream"},
+			},
+		},
+	}}
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{},
+		StepLogs: logs,
+		Logger:   discardLogger(),
 	})
 
-	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/steps/"+stepID.String()+"/logs?after=5&limit=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		StepStatus domain.StepStatus    `json:"step_status"`
+		Lines      []domain.StepLogLine `json:"lines"`
+		NextAfter  int64                `json:"next_after"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Lines) != 2 || body.NextAfter != 9 || body.StepStatus != domain.StepRunning {
+		t.Fatalf("unexpected page: %+v", body)
+	}
+	if logs.runID != runID || logs.stepID != stepID || logs.limit != 2 {
+		t.Fatalf("unexpected repository call: run=%s step=%s limit=%d", logs.runID, logs.stepID, logs.limit)
+	}
+
+	for query, want := range map[string]int{
+		"?after=-1":   http.StatusBadRequest,
+		"?limit=5000": http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/steps/"+stepID.String()+"/logs"+query, nil))
+		if rec.Code != want {
+			t.Fatalf("%s: expected status %d got %d", query, want, rec.Code)
+		}
+	}
+
+	logs.err = domain.ErrStepNotFound
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/steps/"+stepID.String()+"/logs", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 got %d", rec.Code)
+	}
+}
+
+func TestRouter_GetRunTimeline(t *testing.T) {
+	runID, stepID := uuid.New(), uuid.New()
+	started := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	finished := started.Add(2 * time.Second)
+	timelines := &mockTimelineReader{timeline: domain.RunTimeline{
+		RunID:  runID,
+		Status: domain.RunSuccess,
+		Steps: []domain.StepTimeline{{
+			ID:         stepID,
+			Name:       "LLM",
+			Status:     string(domain.StepSuccess),
+			Attempts:   1,
+			StartedAt:  &started,
+			FinishedAt: &finished,
+			Segments: []domain.TimelineSegment{
+				{Kind: domain.SegmentExecution, Attempt: 1, StartedAt: started, FinishedAt: &finished, Outcome: domain.EventStepSucceeded},
+			},
+		}},
+	}}
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{},
+		Timeline: timelines,
+		Logger:   discardLogger(),
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/timeline", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body domain.RunTimeline
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.RunID != runID || len(body.Steps) != 1 || len(body.Steps[0].Segments) != 1 {
+		t.Fatalf("unexpected timeline: %+v", body)
+	}
+	if seg := body.Steps[0].Segments[0]; seg.Outcome != domain.EventStepSucceeded || seg.FinishedAt == nil || !seg.FinishedAt.Equal(finished) {
+		t.Fatalf("unexpected segment: %+v", seg)
+	}
+	if timelines.runID != runID {
+		t.Fatalf("expected timeline of %s got %s", runID, timelines.runID)
+	}
+
+	for _, tt := range []struct {
+		path string
+		err  error
+		want int
+	}{
+		{path: "/runs/not-a-uuid/timeline", want: http.StatusBadRequest},
+		{path: "/runs/" + runID.String() + "/timeline", err: domain.ErrRunNotFound, want: http.StatusNotFound},
+		{path: "/runs/" + runID.String() + "/timeline", err: errors.New("db down"), want: http.StatusInternalServerError},
+	} {
+		timelines.err = tt.err
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Fatalf("%s (%v): expected status %d got %d", tt.path, tt.err, tt.want, rec.Code)
+		}
+	}
+}
+
+func TestRouter_TailStepLogsUntilStepSettles(t *testing.T) {
+	runID, stepID := uuid.New(), uuid.New()
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{},
+		StepLogs: &mockStepLogReader{pages: map[int64]domain.StepLogPage{
+			0: {StepStatus: domain.StepRunning, Lines: []domain.StepLogLine{{Seq: 1, Line: "one"}}},
+			1: {StepStatus: domain.StepSuccess, Lines: []domain.StepLogLine{{Seq: 2, Line: "two"}}},
+		}},
+		SSEPollInterval: time.Millisecond,
+		Logger:          discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/steps/"+stepID.String()+"/logs", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(rec, req)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("log stream did not end after the step settled")
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"id: 1\nevent: log\n",
+		"id: 2\nevent: log\n",
+		"event: end\ndata: {\"last_seq\":2,\"step_status\":\"SUCCEEDED\"}",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in stream, got %q", want, body)
+		}
+	}
+}
+
+func TestRouter_StreamEventsEndsCleanlyOnShutdown(t *testing.T) {
+	runID := uuid.New()
+	ev := domain.EventRecord{
+		ID:        uuid.New(),
+		Seq:       1,
+		RunID:     runID,
+		Type:      "STEP_CLAIMED",
+		Payload:   mustStatusPayload(t, domain.StepRunning),
+		CreatedAt: time.Now().UTC(),
+	}
+	streams := NewStreams(1500 * time.Millisecond)
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{getRunStatus: domain.RunRunning},
+		StepRepo: &mockStepLister{},
+		EventRepo: &mockEventRepo{
+			eventsByAfter: map[int64][]domain.EventRecord{
+				0: []domain.EventRecord{ev},
+			},
+		},
+		Streams:         streams,
+		SSEPollInterval: time.Hour,
+		Logger:          discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/events", nil)
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(rec, req)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for streams.Active() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("stream was never registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	streams.Shutdown()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream did not end after shutdown")
+	}
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "event: shutdown\nretry: 1500\n") {
+		t.Fatalf("expected terminal shutdown event with retry hint, got body %q", body)
+	}
+	if !strings.Contains(body, `"reconnect_after_ms":1500`) || !strings.Contains(body, `"last_seq":1`) {
+		t.Fatalf("expected reconnect hint and cursor in shutdown payload, got body %q", body)
+	}
+	if got := streams.Active(); got != 0 {
+		t.Fatalf("expected no active streams after shutdown, got %d", got)
+	}
+
+	// New streams are refused while the server drains.
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/events", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503 got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("expected Retry-After 2 got %q", got)
+	}
+}
+
+func TestRouter_StreamEventsInvalidSinceID(t *testing.T) {
+	runID := uuid.New()
+	router := NewRouter(Deps{
+		RunRepo:   &mockRunRepo{getRunStatus: domain.RunRunning},
+		StepRepo:  &mockStepLister{},
+		EventRepo: &mockEventRepo{},
+		Logger:    discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/events?since_id=not-valid", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 got %d", rec.Code)
+	}
+}
+
+func TestRouter_StreamEventsSinceEventID(t *testing.T) {
+	runID := uuid.New()
+	sinceEventID := uuid.New()
+	ev := domain.EventRecord{
+		ID:        uuid.New(),
+		Seq:       6,
+		RunID:     runID,
+		Type:      "STEP_SUCCEEDED",
+		Payload:   mustStatusPayload(t, domain.StepSuccess),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	eventRepo := &mockEventRepo{
+		resolveCursorByEventID: map[uuid.UUID]int64{
+			sinceEventID: 5,
+		},
+		eventsByAfter: map[int64][]domain.EventRecord{
+			5: []domain.EventRecord{ev},
+		},
+	}
+
+	router := NewRouter(Deps{
+		RunRepo:   &mockRunRepo{getRunStatus: domain.RunRunning},
+		StepRepo:  &mockStepLister{},
+		EventRepo: eventRepo,
+		Logger:    discardLogger(),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(
+		http.MethodGet,
+		"/runs/"+runID.String()+"/events?since_id="+sinceEventID.String(),
+		nil,
+	).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(rec, req)
+		close(done)
+	}()
+
+	time.Sleep(30 * time.Millisecond)
+	cancel()
+	<-done
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if eventRepo.resolveEventID != sinceEventID {
+		t.Fatalf("expected resolve cursor lookup for event id %s got %s", sinceEventID, eventRepo.resolveEventID)
+	}
+}
+
+func TestRouter_StreamEventsRunNotFound(t *testing.T) {
+	runID := uuid.New()
+	router := NewRouter(Deps{
+		RunRepo:   &mockRunRepo{getRunErr: domain.ErrRunNotFound},
+		StepRepo:  &mockStepLister{},
+		EventRepo: &mockEventRepo{},
+		Logger:    discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/events", nil)
 	rec := httptest.NewRecorder()
 	router.ServeHTTP(rec, req)
 
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 got %d", rec.Code)
+	}
+}
+
+// statusSequenceRunRepo answers GetRun with statuses in turn, repeating the
+// last one.
+type statusSequenceRunRepo struct {
+	*mockRunRepo
+	statuses []domain.RunStatus
+	calls    int
+}
+
+func (m *statusSequenceRunRepo) GetRun(ctx context.Context, id uuid.UUID) (domain.RunStatus, error) {
+	status := m.statuses[min(m.calls, len(m.statuses)-1)]
+	m.calls++
+	return status, nil
+}
+
+func TestRouter_WaitRun(t *testing.T) {
+	runID := uuid.New()
+	runRepo := &statusSequenceRunRepo{
+		mockRunRepo: &mockRunRepo{},
+		statuses:    []domain.RunStatus{domain.RunRunning, domain.RunSuccess},
+	}
+	events := &mockEventRepo{
+		eventsByAfter: map[int64][]domain.EventRecord{
+			0: {{ID: uuid.New(), Seq: 3, RunID: runID, Type: "STEP_SUCCEEDED"}},
+		},
+	}
+	router := NewRouter(Deps{
+		RunRepo:         runRepo,
+		StepRepo:        &mockStepLister{},
+		EventRepo:       events,
+		SSEPollInterval: time.Millisecond,
+		Logger:          discardLogger(),
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/wait?timeout=5s", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	var got runWaitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if want := (runWaitResponse{ID: runID, Status: domain.RunSuccess, Terminal: true}); got != want {
+		t.Fatalf("expected %+v got %+v", want, got)
+	}
+	if runRepo.calls != 2 {
+		t.Fatalf("expected the status to be read again after new events, got %d reads", runRepo.calls)
+	}
+}
+
+func TestRouter_WaitRunTimesOut(t *testing.T) {
+	runID := uuid.New()
+	events := &mockEventRepo{}
+	router := NewRouter(Deps{
+		RunRepo:         &mockRunRepo{getRunStatus: domain.RunRunning},
+		StepRepo:        &mockStepLister{},
+		EventRepo:       events,
+		SSEPollInterval: time.Millisecond,
+		Logger:          discardLogger(),
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/wait?timeout=20ms", nil))
 	if rec.Code != http.StatusOK {
 		t.Fatalf("expected status 200 got %d", rec.Code)
 	}
-	if got := rec.Header().Get(headerRequestID); got == "" {
-		t.Fatalf("expected %s response header to be set", headerRequestID)
+	var got runWaitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if got.Status != domain.RunRunning || got.Terminal {
+		t.Fatalf("expected a non-terminal RUNNING result, got %+v", got)
+	}
+	if events.listCalls == 0 {
+		t.Fatal("expected the wait to poll events")
+	}
+
+	for _, timeout := range []string{"soon", "-1s", "2m", "61", "99999999999999"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/wait?timeout="+timeout, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("timeout %q: expected status 400 got %d", timeout, rec.Code)
+		}
 	}
 }
 
-func TestRouter_HealthzPreservesRequestID(t *testing.T) {
+func TestRouter_WaitRunTerminalReturnsAtOnce(t *testing.T) {
+	runID := uuid.New()
+	events := &mockEventRepo{}
 	router := NewRouter(Deps{
-		RunRepo:  &mockRunRepo{},
+		RunRepo:   &mockRunRepo{getRunStatus: domain.RunCanceled},
+		StepRepo:  &mockStepLister{},
+		EventRepo: events,
+		Logger:    discardLogger(),
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/wait", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"terminal":true`) {
+		t.Fatalf("expected a terminal result, got %d %s", rec.Code, rec.Body.String())
+	}
+	if events.listCalls != 0 {
+		t.Fatalf("expected no event polling for a terminal run, got %d", events.listCalls)
+	}
+
+	router = NewRouter(Deps{
+		RunRepo:   &mockRunRepo{getRunErr: domain.ErrRunNotFound},
+		StepRepo:  &mockStepLister{},
+		EventRepo: events,
+		Logger:    discardLogger(),
+	})
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/wait", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 got %d", rec.Code)
+	}
+}
+
+func TestRouter_AuthEnforcedWhenResolverPresent(t *testing.T) {
+	apiKeyID := uuid.New()
+	runRepo := &mockRunRepo{createRunID: uuid.New()}
+	router := NewRouter(Deps{
+		RunRepo:  runRepo,
 		StepRepo: &mockStepLister{},
 		Logger:   discardLogger(),
+		APIKeyResolver: &mockAPIKeyResolver{
+			keyByToken: map[string]auth.APIKey{
+				"secret": {
+					ID:                apiKeyID,
+					MaxConcurrentRuns: 5,
+					MaxRequestsPerMin: 60,
+					Scopes:            domain.AllScopes,
+				},
+			},
+		},
 	})
 
-	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
-	req.Header.Set(headerRequestID, "req-from-client")
+	req := httptest.NewRequest(http.MethodPost, "/runs", nil)
 	rec := httptest.NewRecorder()
+
 	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 got %d", rec.Code)
+	}
 
-	if rec.Code != http.StatusOK {
-		t.Fatalf("expected status 200 got %d", rec.Code)
+	authReq := httptest.NewRequest(http.MethodPost, "/runs", nil)
+	authReq.Header.Set("Authorization", "Bearer secret")
+	authRec := httptest.NewRecorder()
+
+	router.ServeHTTP(authRec, authReq)
+	if authRec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", authRec.Code)
 	}
-	if got := rec.Header().Get(headerRequestID); got != "req-from-client" {
-		t.Fatalf("expected %s req-from-client got %q", headerRequestID, got)
+	gotAPIKeyID, ok := auth.APIKeyIDFromContext(runRepo.createCtx)
+	if !ok {
+		t.Fatal("expected api_key_id to be attached to context")
+	}
+	if gotAPIKeyID != apiKeyID {
+		t.Fatalf("expected api_key_id %s got %s", apiKeyID, gotAPIKeyID)
 	}
 }
 
-func TestRouter_HealthzNotReadyWhenSchemaCheckFails(t *testing.T) {
-	healthChecker := &mockHealthChecker{err: errors.New("schema missing")}
+func TestRouter_WarnsWhenAPIKeyNearsExpiry(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(2 * 24 * time.Hour)
 	router := NewRouter(Deps{
-		RunRepo:       &mockRunRepo{},
-		StepRepo:      &mockStepLister{},
-		Logger:        discardLogger(),
-		HealthChecker: healthChecker,
+		RunRepo:             &mockRunRepo{createRunID: uuid.New()},
+		StepRepo:            &mockStepLister{},
+		Logger:              discardLogger(),
+		Clock:               clock.NewFake(now),
+		APIKeyExpiryWarning: 7 * 24 * time.Hour,
+		APIKeyResolver: &mockAPIKeyResolver{
+			keyByToken: map[string]auth.APIKey{
+				"secret": {
+					ID:                uuid.New(),
+					MaxRequestsPerMin: 60,
+					Scopes:            domain.AllScopes,
+					ExpiresAt:         &expiresAt,
+				},
+			},
+		},
 	})
 
-	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req := httptest.NewRequest(http.MethodPost, "/runs", nil)
+	req.Header.Set("Authorization", "Bearer secret")
 	rec := httptest.NewRecorder()
 	router.ServeHTTP(rec, req)
 
-	if rec.Code != http.StatusServiceUnavailable {
-		t.Fatalf("expected status 503 got %d", rec.Code)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
 	}
-	if healthChecker.calls != 1 {
-		t.Fatalf("expected health checker call count 1 got %d", healthChecker.calls)
+	if got := rec.Header().Get("X-API-Key-Expires-At"); got != "2026-03-03T12:00:00Z" {
+		t.Fatalf("expected X-API-Key-Expires-At 2026-03-03T12:00:00Z got %q", got)
+	}
+	if rec.Header().Get("Warning") == "" {
+		t.Fatal("expected Warning header for a key close to expiry")
 	}
 }
 
-func TestRouter_MetricsUnauthenticated(t *testing.T) {
+func TestRouter_RejectsRequestsOutsideAllowedCIDRs(t *testing.T) {
 	router := NewRouter(Deps{
-		RunRepo:        &mockRunRepo{},
+		RunRepo:        &mockRunRepo{createRunID: uuid.New()},
 		StepRepo:       &mockStepLister{},
 		Logger:         discardLogger(),
-		APIKeyResolver: &mockAPIKeyResolver{},
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		APIKeyResolver: &mockAPIKeyResolver{
+			keyByToken: map[string]auth.APIKey{
+				"secret": {
+					ID:                uuid.New(),
+					MaxRequestsPerMin: 60,
+					Scopes:            domain.AllScopes,
+					AllowedCIDRs:      []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")},
+				},
+			},
+		},
 	})
 
-	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
-	rec := httptest.NewRecorder()
-	router.ServeHTTP(rec, req)
-
-	if rec.Code != http.StatusOK {
-		t.Fatalf("expected status 200 got %d", rec.Code)
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		wantStatus   int
+	}{
+		{name: "allowed", remoteAddr: "203.0.113.4:40000", wantStatus: http.StatusOK},
+		{name: "outside allow-list", remoteAddr: "198.51.100.4:40000", wantStatus: http.StatusForbidden},
+		{name: "via trusted proxy", remoteAddr: "10.1.2.3:40000", forwardedFor: "203.0.113.4", wantStatus: http.StatusOK},
+		{name: "forged header", remoteAddr: "198.51.100.4:40000", forwardedFor: "203.0.113.4", wantStatus: http.StatusForbidden},
 	}
-	if !strings.Contains(rec.Body.String(), "runs_total") {
-		t.Fatalf("expected prometheus output to include runs_total metric, got %q", rec.Body.String())
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/runs", nil)
+			req.RemoteAddr = tc.remoteAddr
+			req.Header.Set("Authorization", "Bearer secret")
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d got %d", tc.wantStatus, rec.Code)
+			}
+		})
 	}
 }
 
-func TestRouter_VersionUnauthenticated(t *testing.T) {
+func TestRouter_CreateAPIKeyWithExpiry(t *testing.T) {
+	apiKeyAdmin := &mockAPIKeyManager{}
 	router := NewRouter(Deps{
-		RunRepo:        &mockRunRepo{},
-		StepRepo:       &mockStepLister{},
-		Logger:         discardLogger(),
-		APIKeyResolver: &mockAPIKeyResolver{},
-		Version:        "1.2.3",
-		Commit:         "abc123",
-		BuildDate:      "2026-02-23T00:00:00Z",
+		RunRepo:     &mockRunRepo{},
+		StepRepo:    &mockStepLister{},
+		APIKeyAdmin: apiKeyAdmin,
+		AdminToken:  "master-token",
+		Logger:      discardLogger(),
 	})
 
-	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	req := httptest.NewRequest(http.MethodPost, "/api-keys", bytes.NewBufferString(`{"name":"contractor","expires_at":"2026-12-31T00:00:00Z"}`))
+	req.Header.Set("Authorization", "Bearer master-token")
 	rec := httptest.NewRecorder()
 	router.ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Fatalf("expected status 200 got %d", rec.Code)
 	}
-
-	var resp map[string]string
-	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
-		t.Fatalf("decode response: %v", err)
+	want := time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)
+	if got := apiKeyAdmin.createParams.ExpiresAt; got == nil || !got.Equal(want) {
+		t.Fatalf("expected expires_at %s to be forwarded, got %v", want, got)
 	}
 
-	if resp["version"] != "1.2.3" {
-		t.Fatalf("expected version 1.2.3 got %q", resp["version"])
-	}
-	if resp["commit"] != "abc123" {
-		t.Fatalf("expected commit abc123 got %q", resp["commit"])
-	}
-	if resp["build_date"] != "2026-02-23T00:00:00Z" {
-		t.Fatalf("expected build_date 2026-02-23T00:00:00Z got %q", resp["build_date"])
+	apiKeyAdmin.createErr = domain.ErrInvalidAPIKeyExpiry
+	req = httptest.NewRequest(http.MethodPost, "/api-keys", bytes.NewBufferString(`{"name":"late","expires_at":"2020-01-01T00:00:00Z"}`))
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for past expiry got %d", rec.Code)
 	}
 }
 
-func TestRouter_GetRunNotFound(t *testing.T) {
-	runRepo := &mockRunRepo{getRunErr: pgx.ErrNoRows}
+func TestRouter_EnforcesAPIKeyScopes(t *testing.T) {
+	runID := uuid.New()
 	router := NewRouter(Deps{
-		RunRepo:  runRepo,
+		RunRepo:  &mockRunRepo{createRunID: uuid.New(), getRunStatus: domain.RunWaiting},
 		StepRepo: &mockStepLister{},
 		Logger:   discardLogger(),
+		APIKeyResolver: &mockAPIKeyResolver{
+			keyByToken: map[string]auth.APIKey{
+				"reader":   {ID: uuid.New(), MaxRequestsPerMin: 60, Scopes: []string{domain.ScopeRunsRead}},
+				"approver": {ID: uuid.New(), MaxRequestsPerMin: 60, Scopes: []string{domain.ScopeApprovalsWrite}},
+			},
+		},
 	})
 
-	req := httptest.NewRequest(http.MethodGet, "/runs/"+uuid.New().String(), nil)
-	rec := httptest.NewRecorder()
-
-	router.ServeHTTP(rec, req)
-
-	if rec.Code != http.StatusNotFound {
-		t.Fatalf("expected status 404 got %d", rec.Code)
+	tests := []struct {
+		token      string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{token: "reader", method: http.MethodGet, path: "/runs/" + runID.String(), wantStatus: http.StatusOK},
+		{token: "reader", method: http.MethodGet, path: "/runs/" + runID.String() + "/steps", wantStatus: http.StatusOK},
+		{token: "reader", method: http.MethodPost, path: "/runs", wantStatus: http.StatusForbidden},
+		{token: "reader", method: http.MethodPost, path: "/runs/" + runID.String() + "/cancel", wantStatus: http.StatusForbidden},
+		{token: "reader", method: http.MethodPost, path: "/runs/" + runID.String() + "/approve", wantStatus: http.StatusForbidden},
+		{token: "approver", method: http.MethodPost, path: "/runs/" + runID.String() + "/approve", wantStatus: http.StatusOK},
+		{token: "approver", method: http.MethodGet, path: "/runs/" + runID.String(), wantStatus: http.StatusForbidden},
+		{token: "approver", method: http.MethodPost, path: "/runs", wantStatus: http.StatusForbidden},
 	}
 
-	if runRepo.getRunID == uuid.Nil {
-		t.Fatalf("expected GetRun to be called")
+	for _, tc := range tests {
+		t.Run(tc.token+" "+tc.method+" "+tc.path, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d got %d", tc.wantStatus, rec.Code)
+			}
+		})
 	}
 }
 
-func TestRouter_GetRunError(t *testing.T) {
-	runRepo := &mockRunRepo{getRunErr: errors.New("db failed")}
+func TestRouter_CancelAndApprove(t *testing.T) {
+	runID := uuid.New()
+	runRepo := &mockRunRepo{}
 	router := NewRouter(Deps{
 		RunRepo:  runRepo,
 		StepRepo: &mockStepLister{},
 		Logger:   discardLogger(),
 	})
 
-	req := httptest.NewRequest(http.MethodGet, "/runs/"+uuid.New().String(), nil)
-	rec := httptest.NewRecorder()
-	router.ServeHTTP(rec, req)
+	cancelReq := httptest.NewRequest(http.MethodPost, "/runs/"+runID.String()+"/cancel", nil)
+	cancelRec := httptest.NewRecorder()
+	router.ServeHTTP(cancelRec, cancelReq)
+	if cancelRec.Code != http.StatusOK {
+		t.Fatalf("cancel expected 200 got %d", cancelRec.Code)
+	}
+	if runRepo.cancelRunID != runID {
+		t.Fatalf("expected cancel run id %s got %s", runID, runRepo.cancelRunID)
+	}
 
-	if rec.Code != http.StatusInternalServerError {
-		t.Fatalf("expected status 500 got %d", rec.Code)
+	approveReq := httptest.NewRequest(http.MethodPost, "/runs/"+runID.String()+"/approve", bytes.NewBufferString("{}"))
+	approveRec := httptest.NewRecorder()
+	router.ServeHTTP(approveRec, approveReq)
+	if approveRec.Code != http.StatusOK {
+		t.Fatalf("approve expected 200 got %d", approveRec.Code)
+	}
+	if runRepo.approveRunID != runID {
+		t.Fatalf("expected approve run id %s got %s", runID, runRepo.approveRunID)
 	}
 }
 
-func TestRouter_GetRunSuccess(t *testing.T) {
+func TestRouter_ApproveStep(t *testing.T) {
 	runID := uuid.New()
-	runRepo := &mockRunRepo{getRunStatus: domain.RunRunning}
+	stepID := uuid.New()
+	runRepo := &mockRunRepo{}
 	router := NewRouter(Deps{
 		RunRepo:  runRepo,
 		StepRepo: &mockStepLister{},
 		Logger:   discardLogger(),
 	})
 
-	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String(), nil)
+	req := httptest.NewRequest(http.MethodPost, "/runs/"+runID.String()+"/approvals/"+stepID.String(), nil)
 	rec := httptest.NewRecorder()
 	router.ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Fatalf("expected status 200 got %d", rec.Code)
 	}
+	if runRepo.approveRunID != runID || runRepo.approveStepID != stepID {
+		t.Fatalf("expected approve %s/%s got %s/%s", runID, stepID, runRepo.approveRunID, runRepo.approveStepID)
+	}
 
 	var resp map[string]string
 	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
 		t.Fatalf("decode response: %v", err)
 	}
-
-	if resp["id"] != runID.String() {
-		t.Fatalf("expected id %s got %s", runID, resp["id"])
-	}
-
-	if resp["status"] != string(domain.RunRunning) {
-		t.Fatalf("expected status %s got %s", domain.RunRunning, resp["status"])
+	if resp["step_id"] != stepID.String() || resp["status"] != "APPROVED" {
+		t.Fatalf("unexpected response %+v", resp)
 	}
 }
 
-func TestRouter_GetRunInvalidID(t *testing.T) {
-	router := NewRouter(Deps{
-		RunRepo:  &mockRunRepo{},
-		StepRepo: &mockStepLister{},
-		Logger:   discardLogger(),
-	})
-
-	req := httptest.NewRequest(http.MethodGet, "/runs/not-a-uuid", nil)
-	rec := httptest.NewRecorder()
-	router.ServeHTTP(rec, req)
+func TestRouter_ApproveStepErrors(t *testing.T) {
+	runID := uuid.New()
+	stepID := uuid.New()
+	tests := []struct {
+		name       string
+		err        error
+		path       string
+		wantStatus int
+	}{
+		{name: "invalid step id", path: "/runs/" + runID.String() + "/approvals/not-a-uuid", wantStatus: http.StatusBadRequest},
+		{name: "run not found", err: domain.ErrRunNotFound, wantStatus: http.StatusNotFound},
+		{name: "step not found", err: domain.ErrApprovalStepNotFound, wantStatus: http.StatusNotFound},
+		{name: "not waiting", err: fmt.Errorf("%w: approval step status is PENDING", domain.ErrRunNotWaitingApproval), wantStatus: http.StatusConflict},
+		{name: "internal", err: errors.New("update failed"), wantStatus: http.StatusInternalServerError},
+	}
 
-	if rec.Code != http.StatusBadRequest {
-		t.Fatalf("expected status 400 got %d", rec.Code)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter(Deps{
+				RunRepo:  &mockRunRepo{approveErr: tt.err},
+				StepRepo: &mockStepLister{},
+				Logger:   discardLogger(),
+			})
+
+			path := tt.path
+			if path == "" {
+				path = "/runs/" + runID.String() + "/approvals/" + stepID.String()
+			}
+			req := httptest.NewRequest(http.MethodPost, path, nil)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d got %d", tt.wantStatus, rec.Code)
+			}
+		})
 	}
 }
 
-func TestRouter_GetRunCost(t *testing.T) {
+func TestRouter_RejectStep(t *testing.T) {
 	runID := uuid.New()
-	runRepo := &mockRunRepo{
-		getRunCost: domain.RunCostBreakdown{
-			RunID:        runID,
-			TotalCostUSD: 1.2345,
-			Steps: []domain.StepCostBreakdown{
-				{ID: uuid.New(), Name: string(domain.StepLLM), Status: string(domain.StepSuccess), CostUSD: 1.2345},
-			},
-		},
-	}
-
+	stepID := uuid.New()
+	runRepo := &mockRunRepo{}
 	router := NewRouter(Deps{
 		RunRepo:  runRepo,
 		StepRepo: &mockStepLister{},
 		Logger:   discardLogger(),
 	})
 
-	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/cost", nil)
+	req := httptest.NewRequest(http.MethodPost, "/runs/"+runID.String()+"/approvals/"+stepID.String()+"/reject", nil)
 	rec := httptest.NewRecorder()
 	router.ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Fatalf("expected status 200 got %d", rec.Code)
 	}
+	if runRepo.rejectRunID != runID || runRepo.rejectStepID != stepID {
+		t.Fatalf("expected reject %s/%s got %s/%s", runID, stepID, runRepo.rejectRunID, runRepo.rejectStepID)
+	}
 
-	var resp domain.RunCostBreakdown
+	var resp map[string]string
 	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
 		t.Fatalf("decode response: %v", err)
 	}
-	if resp.RunID != runID {
-		t.Fatalf("expected run_id %s got %s", runID, resp.RunID)
-	}
-	if resp.TotalCostUSD != 1.2345 {
-		t.Fatalf("expected total_cost_usd 1.2345 got %f", resp.TotalCostUSD)
-	}
-	if len(resp.Steps) != 1 {
-		t.Fatalf("expected 1 step cost entry got %d", len(resp.Steps))
+	if resp["step_id"] != stepID.String() || resp["status"] != "REJECTED" {
+		t.Fatalf("unexpected response %+v", resp)
 	}
 }
 
-func TestRouter_GetRunCostNotFound(t *testing.T) {
-	runID := uuid.New()
-	runRepo := &mockRunRepo{getRunCostErr: pgx.ErrNoRows}
-	router := NewRouter(Deps{
-		RunRepo:  runRepo,
-		StepRepo: &mockStepLister{},
-		Logger:   discardLogger(),
-	})
-
-	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/cost", nil)
-	rec := httptest.NewRecorder()
-	router.ServeHTTP(rec, req)
+func TestRouter_RejectStepErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "run not found", err: domain.ErrRunNotFound, wantStatus: http.StatusNotFound},
+		{name: "step not found", err: domain.ErrApprovalStepNotFound, wantStatus: http.StatusNotFound},
+		{name: "not waiting", err: domain.ErrRunNotWaitingApproval, wantStatus: http.StatusConflict},
+		{name: "internal", err: errors.New("update failed"), wantStatus: http.StatusInternalServerError},
+	}
 
-	if rec.Code != http.StatusNotFound {
-		t.Fatalf("expected status 404 got %d", rec.Code)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter(Deps{
+				RunRepo:  &mockRunRepo{rejectErr: tt.err},
+				StepRepo: &mockStepLister{},
+				Logger:   discardLogger(),
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/runs/"+uuid.NewString()+"/approvals/"+uuid.NewString()+"/reject", nil)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d got %d", tt.wantStatus, rec.Code)
+			}
+		})
 	}
 }
 
-func TestRouter_ListSteps(t *testing.T) {
+func TestRouter_AdminTenantRunsActAsTenant(t *testing.T) {
+	apiKeyID := uuid.New()
 	runID := uuid.New()
-	steps := []domain.StepRecord{
-		{ID: uuid.New(), Name: "demo", Status: string(domain.StepPending)},
-	}
-
+	stepID := uuid.New()
+	runRepo := &mockRunRepo{listRuns: []domain.RunListItem{{ID: runID, Status: domain.RunRunning}}}
 	router := NewRouter(Deps{
-		RunRepo:  &mockRunRepo{},
-		StepRepo: &mockStepLister{steps: steps},
-		Logger:   discardLogger(),
+		RunRepo:    runRepo,
+		StepRepo:   &mockStepLister{},
+		AdminToken: "master-token",
+		Logger:     discardLogger(),
 	})
 
-	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/steps", nil)
+	req := httptest.NewRequest(http.MethodGet, "/admin/tenants/"+apiKeyID.String()+"/runs?status=RUNNING", nil)
+	req.Header.Set("Authorization", "Bearer master-token")
 	rec := httptest.NewRecorder()
-
 	router.ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
-		t.Fatalf("expected status 200 got %d", rec.Code)
+		t.Fatalf("expected list status 200 got %d body=%s", rec.Code, rec.Body.String())
 	}
-
-	var resp struct {
-		RunID string              `json:"run_id"`
-		Steps []domain.StepRecord `json:"steps"`
+	if got, ok := auth.APIKeyIDFromContext(runRepo.listCtx); !ok || got != apiKeyID {
+		t.Fatalf("expected list scoped to %s got %s (%v)", apiKeyID, got, ok)
 	}
-
-	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
-		t.Fatalf("decode response: %v", err)
+	if runRepo.listFilter.Status != domain.RunRunning {
+		t.Fatalf("expected status filter RUNNING got %q", runRepo.listFilter.Status)
 	}
 
-	if resp.RunID != runID.String() {
-		t.Fatalf("expected run id %s got %s", runID, resp.RunID)
-	}
+	req = httptest.NewRequest(http.MethodPost,
+		"/admin/tenants/"+apiKeyID.String()+"/runs/"+runID.String()+"/approvals/"+stepID.String()+"/reject", nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
 
-	if len(resp.Steps) != len(steps) {
-		t.Fatalf("expected %d steps got %d", len(steps), len(resp.Steps))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected reject status 200 got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if runRepo.rejectRunID != runID || runRepo.rejectStepID != stepID {
+		t.Fatalf("expected reject %s/%s got %s/%s", runID, stepID, runRepo.rejectRunID, runRepo.rejectStepID)
+	}
+	if got, ok := auth.APIKeyIDFromContext(runRepo.rejectCtx); !ok || got != apiKeyID {
+		t.Fatalf("expected reject scoped to %s got %s (%v)", apiKeyID, got, ok)
 	}
 }
 
-func TestRouter_ListStepsError(t *testing.T) {
+func TestRouter_AdminTenantRunsRequireAdminToken(t *testing.T) {
+	runRepo := &mockRunRepo{}
 	router := NewRouter(Deps{
-		RunRepo:  &mockRunRepo{},
-		StepRepo: &mockStepLister{err: errors.New("query failed")},
-		Logger:   discardLogger(),
+		RunRepo:    runRepo,
+		StepRepo:   &mockStepLister{},
+		AdminToken: "master-token",
+		Logger:     discardLogger(),
 	})
 
-	req := httptest.NewRequest(http.MethodGet, "/runs/"+uuid.New().String()+"/steps", nil)
+	req := httptest.NewRequest(http.MethodGet, "/admin/tenants/"+uuid.NewString()+"/runs", nil)
+	req.Header.Set("Authorization", "Bearer wrong-token")
 	rec := httptest.NewRecorder()
 	router.ServeHTTP(rec, req)
 
-	if rec.Code != http.StatusInternalServerError {
-		t.Fatalf("expected status 500 got %d", rec.Code)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 got %d", rec.Code)
+	}
+	if runRepo.listCalled {
+		t.Fatal("expected runs not to be listed")
 	}
-}
-
-func TestRouter_ListStepsNotFound(t *testing.T) {
-	router := NewRouter(Deps{
-		RunRepo:  &mockRunRepo{},
-		StepRepo: &mockStepLister{err: pgx.ErrNoRows},
-		Logger:   discardLogger(),
-	})
 
-	req := httptest.NewRequest(http.MethodGet, "/runs/"+uuid.New().String()+"/steps", nil)
-	rec := httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/admin/tenants/not-a-uuid/runs", nil)
+	req.Header.Set("Authorization", "Bearer master-token")
+	rec = httptest.NewRecorder()
 	router.ServeHTTP(rec, req)
 
-	if rec.Code != http.StatusNotFound {
-		t.Fatalf("expected status 404 got %d", rec.Code)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for invalid api key id got %d", rec.Code)
 	}
 }
 
-func TestRouter_ListStepsInvalidID(t *testing.T) {
+func TestRouter_CancelWithReason(t *testing.T) {
+	runID := uuid.New()
+	runRepo := &mockRunRepo{}
 	router := NewRouter(Deps{
-		RunRepo:  &mockRunRepo{},
+		RunRepo:  runRepo,
 		StepRepo: &mockStepLister{},
 		Logger:   discardLogger(),
 	})
 
-	req := httptest.NewRequest(http.MethodGet, "/runs/not-a-uuid/steps", nil)
+	req := httptest.NewRequest(http.MethodPost, "/runs/"+runID.String()+"/cancel", bytes.NewBufferString(`{"reason":"superseded by a newer deploy"}`))
 	rec := httptest.NewRecorder()
 	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if runRepo.cancelReason != "superseded by a newer deploy" || runRepo.cancelAsAdmin {
+		t.Fatalf("expected tenant cancel with reason, got %q (admin=%v)", runRepo.cancelReason, runRepo.cancelAsAdmin)
+	}
 
+	req = httptest.NewRequest(http.MethodPost, "/runs/"+runID.String()+"/cancel", bytes.NewBufferString(`{"why":"x"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
 	if rec.Code != http.StatusBadRequest {
-		t.Fatalf("expected status 400 got %d", rec.Code)
+		t.Fatalf("expected status 400 for unknown field got %d", rec.Code)
 	}
-}
 
-func TestRouter_StreamEvents(t *testing.T) {
-	runID := uuid.New()
-	ev := domain.EventRecord{
-		ID:        uuid.New(),
-		Seq:       1,
-		RunID:     runID,
-		Type:      "STEP_CLAIMED",
-		Payload:   mustStatusPayload(t, domain.StepRunning),
-		CreatedAt: time.Now().UTC(),
+	runRepo.cancelErr = domain.ErrInvalidCancelReason
+	req = httptest.NewRequest(http.MethodPost, "/runs/"+runID.String()+"/cancel", bytes.NewBufferString(`{"reason":"too long"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for invalid reason got %d", rec.Code)
 	}
+}
 
+func TestRouter_ReplayRun(t *testing.T) {
+	runID := uuid.New()
+	newID := uuid.New()
+	runRepo := &mockRunRepo{createRunID: newID}
 	router := NewRouter(Deps{
-		RunRepo:  &mockRunRepo{getRunStatus: domain.RunRunning},
+		RunRepo:  runRepo,
 		StepRepo: &mockStepLister{},
-		EventRepo: &mockEventRepo{
-			eventsByAfter: map[int64][]domain.EventRecord{
-				0: []domain.EventRecord{ev},
-			},
-		},
-		Logger: discardLogger(),
+		Logger:   discardLogger(),
 	})
 
-	ctx, cancel := context.WithCancel(context.Background())
-	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/events", nil).WithContext(ctx)
-	rec := httptest.NewRecorder()
-
-	done := make(chan struct{})
-	go func() {
+	replay := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/runs/"+runID.String()+"/replay", bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
 		router.ServeHTTP(rec, req)
-		close(done)
-	}()
-
-	time.Sleep(30 * time.Millisecond)
-	cancel()
-	<-done
+		return rec
+	}
 
+	rec := replay(`{"step_inputs":{"1":{"prompt":"try again"}}}`)
 	if rec.Code != http.StatusOK {
-		t.Fatalf("expected status 200 got %d", rec.Code)
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
 	}
-	body := rec.Body.String()
-	if !strings.Contains(body, "event: step_update") {
-		t.Fatalf("expected SSE event line, got body %q", body)
+	var resp map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
 	}
-	if !strings.Contains(body, ev.ID.String()) {
-		t.Fatalf("expected SSE payload to include event id %s, got body %q", ev.ID, body)
+	if resp["run_id"] != newID.String() || resp["replayed_from_run_id"] != runID.String() {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	params := runRepo.createParams
+	if params.ReplayedFromRunID == nil || *params.ReplayedFromRunID != runID || string(params.StepInputs[1]) != `{"prompt":"try again"}` {
+		t.Fatalf("unexpected replay params %+v", params)
+	}
+
+	if rec := replay(""); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 without a body got %d", rec.Code)
+	}
+	if rec := replay(`{"step_inputs":{"x":{}}}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for a non-numeric position got %d", rec.Code)
+	}
+
+	runRepo.createErr = fmt.Errorf("%w: template default has no step at position 9", domain.ErrInvalidStepInput)
+	if rec := replay(`{"step_inputs":{"9":{}}}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an invalid step input got %d", rec.Code)
+	}
+	runRepo.createErr = nil
+
+	runRepo.replayErr = domain.ErrRunNotFound
+	if rec := replay(""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for an unknown run got %d", rec.Code)
 	}
 }
 
-func TestRouter_StreamEventsInvalidSinceID(t *testing.T) {
+func TestRouter_ExportRun(t *testing.T) {
 	runID := uuid.New()
+	exporter := &mockExporter{}
+	exporter.run.Run.ID = runID
+	exporter.run.Run.Status = domain.RunSuccess
+	exporter.run.Steps = []domain.ExportedStep{{ID: uuid.New(), Name: "LLM", Output: json.RawMessage(`{"text":"ok"}`)}}
 	router := NewRouter(Deps{
-		RunRepo:   &mockRunRepo{getRunStatus: domain.RunRunning},
-		StepRepo:  &mockStepLister{},
-		EventRepo: &mockEventRepo{},
-		Logger:    discardLogger(),
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{},
+		Exports:  exporter,
+		Logger:   discardLogger(),
 	})
 
-	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/events?since_id=not-valid", nil)
-	rec := httptest.NewRecorder()
-	router.ServeHTTP(rec, req)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
 
-	if rec.Code != http.StatusBadRequest {
-		t.Fatalf("expected status 400 got %d", rec.Code)
+	rec := get("/runs/" + runID.String() + "/export")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "run-"+runID.String()+".json") {
+		t.Fatalf("unexpected content disposition %q", cd)
+	}
+	var got domain.RunExport
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode export: %v", err)
+	}
+	if got.Run.ID != runID || len(got.Steps) != 1 || string(got.Steps[0].Output) != `{"text":"ok"}` {
+		t.Fatalf("unexpected export: %s", rec.Body.String())
 	}
-}
 
-func TestRouter_StreamEventsSinceEventID(t *testing.T) {
-	runID := uuid.New()
-	sinceEventID := uuid.New()
-	ev := domain.EventRecord{
-		ID:        uuid.New(),
-		Seq:       6,
-		RunID:     runID,
-		Type:      "STEP_SUCCEEDED",
-		Payload:   mustStatusPayload(t, domain.StepSuccess),
-		CreatedAt: time.Now().UTC(),
+	rec = get("/runs/" + runID.String() + "/export?format=csv")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("expected csv export, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.HasPrefix(rec.Body.String(), "record,run_id,") || !strings.Contains(rec.Body.String(), "\nstep,"+runID.String()) {
+		t.Fatalf("unexpected csv export: %s", rec.Body.String())
 	}
 
-	eventRepo := &mockEventRepo{
-		resolveCursorByEventID: map[uuid.UUID]int64{
-			sinceEventID: 5,
-		},
-		eventsByAfter: map[int64][]domain.EventRecord{
-			5: []domain.EventRecord{ev},
-		},
+	if rec := get("/runs/" + runID.String() + "/export?format=xml"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for unknown format got %d", rec.Code)
+	}
+
+	exporter.runErr = domain.ErrRunNotFound
+	if rec := get("/runs/" + runID.String() + "/export"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 got %d", rec.Code)
 	}
+}
 
+func TestRouter_ExportJobs(t *testing.T) {
+	jobID := uuid.New()
+	exporter := &mockExporter{job: domain.ExportJob{ID: jobID, Format: domain.ExportFormatCSV, Status: domain.ExportPending}}
 	router := NewRouter(Deps{
-		RunRepo:   &mockRunRepo{getRunStatus: domain.RunRunning},
-		StepRepo:  &mockStepLister{},
-		EventRepo: eventRepo,
-		Logger:    discardLogger(),
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{},
+		Exports:  exporter,
+		Logger:   discardLogger(),
 	})
 
-	ctx, cancel := context.WithCancel(context.Background())
-	req := httptest.NewRequest(
-		http.MethodGet,
-		"/runs/"+runID.String()+"/events?since_id="+sinceEventID.String(),
-		nil,
-	).WithContext(ctx)
+	req := httptest.NewRequest(http.MethodPost, "/exports", bytes.NewBufferString(`{"format":"CSV"}`))
 	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202 got %d", rec.Code)
+	}
+	if exporter.createdWith != domain.ExportFormatCSV {
+		t.Fatalf("expected csv export job, got %q", exporter.createdWith)
+	}
 
-	done := make(chan struct{})
-	go func() {
-		router.ServeHTTP(rec, req)
-		close(done)
-	}()
+	req = httptest.NewRequest(http.MethodPost, "/exports", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted || exporter.createdWith != domain.ExportFormatJSON {
+		t.Fatalf("expected json export job by default, got %d %q", rec.Code, exporter.createdWith)
+	}
 
-	time.Sleep(30 * time.Millisecond)
-	cancel()
-	<-done
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
 
-	if rec.Code != http.StatusOK {
-		t.Fatalf("expected status 200 got %d", rec.Code)
+	if rec := get("/exports/" + jobID.String()); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"PENDING"`) {
+		t.Fatalf("expected pending job, got %d %s", rec.Code, rec.Body.String())
 	}
-	if eventRepo.resolveEventID != sinceEventID {
-		t.Fatalf("expected resolve cursor lookup for event id %s got %s", sinceEventID, eventRepo.resolveEventID)
+	if rec := get("/exports/" + jobID.String() + "/artifact"); rec.Code != http.StatusConflict {
+		t.Fatalf("expected status 409 before the export is built got %d", rec.Code)
 	}
-}
-
-func TestRouter_StreamEventsRunNotFound(t *testing.T) {
-	runID := uuid.New()
-	router := NewRouter(Deps{
-		RunRepo:   &mockRunRepo{getRunErr: pgx.ErrNoRows},
-		StepRepo:  &mockStepLister{},
-		EventRepo: &mockEventRepo{},
-		Logger:    discardLogger(),
-	})
 
-	req := httptest.NewRequest(http.MethodGet, "/runs/"+runID.String()+"/events", nil)
-	rec := httptest.NewRecorder()
-	router.ServeHTTP(rec, req)
+	sum := "abc123"
+	exporter.job.Status = domain.ExportSucceeded
+	exporter.job.ArtifactSHA256 = &sum
+	exporter.artifact = []byte("record,run_id\n")
+	rec = get("/exports/" + jobID.String() + "/artifact")
+	if rec.Code != http.StatusOK || rec.Body.String() != "record,run_id\n" {
+		t.Fatalf("expected artifact, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("X-Content-SHA256") != sum || !strings.Contains(rec.Header().Get("Content-Disposition"), ".csv") {
+		t.Fatalf("unexpected artifact headers %v", rec.Header())
+	}
 
-	if rec.Code != http.StatusNotFound {
+	exporter.jobErr = domain.ErrExportNotFound
+	if rec := get("/exports/" + jobID.String()); rec.Code != http.StatusNotFound {
 		t.Fatalf("expected status 404 got %d", rec.Code)
 	}
 }
 
-func TestRouter_AuthEnforcedWhenResolverPresent(t *testing.T) {
-	apiKeyID := uuid.New()
-	runRepo := &mockRunRepo{createRunID: uuid.New()}
+func TestRouter_AdminInspectRuns(t *testing.T) {
+	tenantID := uuid.New()
+	runID := uuid.New()
+	runRepo := &mockRunRepo{
+		adminRuns: []domain.AdminRunListItem{{APIKeyID: tenantID, RunListItem: domain.RunListItem{ID: runID, Status: domain.RunRunning}}},
+		adminRun:  domain.RunDetail{AdminRunListItem: domain.AdminRunListItem{APIKeyID: tenantID, RunListItem: domain.RunListItem{ID: runID, Status: domain.RunRunning}}},
+	}
+	stepRepo := &mockStepLister{steps: []domain.StepRecord{{ID: uuid.New(), Name: "LLM"}}}
 	router := NewRouter(Deps{
-		RunRepo:  runRepo,
-		StepRepo: &mockStepLister{},
-		Logger:   discardLogger(),
-		APIKeyResolver: &mockAPIKeyResolver{
-			keyByToken: map[string]auth.APIKey{
-				"secret": {
-					ID:                apiKeyID,
-					MaxConcurrentRuns: 5,
-					MaxRequestsPerMin: 60,
-				},
-			},
-		},
+		RunRepo:    runRepo,
+		StepRepo:   stepRepo,
+		AdminToken: "master-token",
+		Logger:     discardLogger(),
 	})
 
-	req := httptest.NewRequest(http.MethodPost, "/runs", nil)
-	rec := httptest.NewRecorder()
+	get := func(token, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
 
-	router.ServeHTTP(rec, req)
-	if rec.Code != http.StatusUnauthorized {
-		t.Fatalf("expected status 401 got %d", rec.Code)
+	if rec := get("", "/admin/runs"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without admin token got %d", rec.Code)
+	}
+	if rec := get("tenant-token", "/admin/runs/"+runID.String()); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 with a non-admin token got %d", rec.Code)
+	}
+	if runRepo.listCalled || runRepo.getRunID != uuid.Nil {
+		t.Fatal("expected no repository access without admin token")
 	}
 
-	authReq := httptest.NewRequest(http.MethodPost, "/runs", nil)
-	authReq.Header.Set("Authorization", "Bearer secret")
-	authRec := httptest.NewRecorder()
+	rec := get("master-token", "/admin/runs?status=running&api_key_id="+tenantID.String())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	if runRepo.adminTenant == nil || *runRepo.adminTenant != tenantID || runRepo.listFilter.Status != domain.RunRunning {
+		t.Fatalf("unexpected admin list arguments: tenant=%v filter=%+v", runRepo.adminTenant, runRepo.listFilter)
+	}
+	var list struct {
+		Runs []domain.AdminRunListItem `json:"runs"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	if len(list.Runs) != 1 || list.Runs[0].APIKeyID != tenantID || list.Runs[0].ID != runID {
+		t.Fatalf("unexpected admin run list: %s", rec.Body.String())
+	}
 
-	router.ServeHTTP(authRec, authReq)
-	if authRec.Code != http.StatusOK {
-		t.Fatalf("expected status 200 got %d", authRec.Code)
+	if rec := get("master-token", "/admin/runs?api_key_id=not_a_key!"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for invalid api_key_id got %d", rec.Code)
 	}
-	gotAPIKeyID, ok := auth.APIKeyIDFromContext(runRepo.createCtx)
-	if !ok {
-		t.Fatal("expected api_key_id to be attached to context")
+
+	rec = get("master-token", "/admin/runs/"+runID.String())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
 	}
-	if gotAPIKeyID != apiKeyID {
-		t.Fatalf("expected api_key_id %s got %s", apiKeyID, gotAPIKeyID)
+	var detail struct {
+		Run   domain.RunDetail    `json:"run"`
+		Steps []domain.StepRecord `json:"steps"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("decode run: %v", err)
+	}
+	if detail.Run.ID != runID || detail.Run.APIKeyID != tenantID || len(detail.Steps) != 1 {
+		t.Fatalf("unexpected admin run: %s", rec.Body.String())
+	}
+
+	runRepo.adminErr = domain.ErrRunNotFound
+	if rec := get("master-token", "/admin/runs/"+uuid.NewString()); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for unknown run got %d", rec.Code)
 	}
 }
 
-func TestRouter_CancelAndApprove(t *testing.T) {
+func TestRouter_AdminCancelRun(t *testing.T) {
 	runID := uuid.New()
 	runRepo := &mockRunRepo{}
 	router := NewRouter(Deps{
-		RunRepo:  runRepo,
-		StepRepo: &mockStepLister{},
-		Logger:   discardLogger(),
+		RunRepo:    runRepo,
+		StepRepo:   &mockStepLister{},
+		AdminToken: "master-token",
+		Logger:     discardLogger(),
 	})
 
-	cancelReq := httptest.NewRequest(http.MethodPost, "/runs/"+runID.String()+"/cancel", nil)
-	cancelRec := httptest.NewRecorder()
-	router.ServeHTTP(cancelRec, cancelReq)
-	if cancelRec.Code != http.StatusOK {
-		t.Fatalf("cancel expected 200 got %d", cancelRec.Code)
+	cancel := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/runs/"+runID.String()+"/cancel", bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
 	}
-	if runRepo.cancelRunID != runID {
-		t.Fatalf("expected cancel run id %s got %s", runID, runRepo.cancelRunID)
+
+	if rec := cancel("", `{"reason":"incident"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without admin token got %d", rec.Code)
+	}
+	if runRepo.cancelRunID != uuid.Nil {
+		t.Fatal("expected no cancel without admin token")
 	}
 
-	approveReq := httptest.NewRequest(http.MethodPost, "/runs/"+runID.String()+"/approve", bytes.NewBufferString("{}"))
-	approveRec := httptest.NewRecorder()
-	router.ServeHTTP(approveRec, approveReq)
-	if approveRec.Code != http.StatusOK {
-		t.Fatalf("approve expected 200 got %d", approveRec.Code)
+	if rec := cancel("master-token", `{"reason":"incident 42"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
 	}
-	if runRepo.approveRunID != runID {
-		t.Fatalf("expected approve run id %s got %s", runID, runRepo.approveRunID)
+	if runRepo.cancelRunID != runID || runRepo.cancelReason != "incident 42" || !runRepo.cancelAsAdmin {
+		t.Fatalf("expected admin cancel of %s with reason, got %s %q (admin=%v)", runID, runRepo.cancelRunID, runRepo.cancelReason, runRepo.cancelAsAdmin)
+	}
+
+	runRepo.cancelErr = domain.ErrRunNotFound
+	if rec := cancel("master-token", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for unknown run got %d", rec.Code)
 	}
 }
 
@@ -984,7 +4230,7 @@ func TestRouter_CancelError(t *testing.T) {
 
 func TestRouter_CancelNotFound(t *testing.T) {
 	runID := uuid.New()
-	runRepo := &mockRunRepo{cancelErr: pgx.ErrNoRows}
+	runRepo := &mockRunRepo{cancelErr: domain.ErrRunNotFound}
 	router := NewRouter(Deps{
 		RunRepo:  runRepo,
 		StepRepo: &mockStepLister{},
@@ -1020,7 +4266,7 @@ func TestRouter_ApproveError(t *testing.T) {
 
 func TestRouter_ApproveNotFound(t *testing.T) {
 	runID := uuid.New()
-	runRepo := &mockRunRepo{approveErr: pgx.ErrNoRows}
+	runRepo := &mockRunRepo{approveErr: domain.ErrRunNotFound}
 	router := NewRouter(Deps{
 		RunRepo:  runRepo,
 		StepRepo: &mockStepLister{},
@@ -1040,17 +4286,41 @@ func TestRouter_ApproveRequiresWaitingApproval(t *testing.T) {
 	runID := uuid.New()
 	runRepo := &mockRunRepo{approveErr: domain.ErrRunNotWaitingApproval}
 	router := NewRouter(Deps{
-		RunRepo:  runRepo,
+		RunRepo:  runRepo,
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/runs/"+runID.String()+"/approve", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected status 409 got %d", rec.Code)
+	}
+}
+
+func TestRouter_CancelAndApproveRejectInvalidTransition(t *testing.T) {
+	runID := uuid.New()
+	transitionErr := &domain.TransitionError{
+		Entity: domain.TransitionEntityRun,
+		From:   string(domain.RunPending),
+		To:     string(domain.RunSuccess),
+	}
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{cancelErr: transitionErr, approveErr: transitionErr},
 		StepRepo: &mockStepLister{},
 		Logger:   discardLogger(),
 	})
 
-	req := httptest.NewRequest(http.MethodPost, "/runs/"+runID.String()+"/approve", nil)
-	rec := httptest.NewRecorder()
-	router.ServeHTTP(rec, req)
+	for _, action := range []string{"cancel", "approve"} {
+		req := httptest.NewRequest(http.MethodPost, "/runs/"+runID.String()+"/"+action, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
 
-	if rec.Code != http.StatusConflict {
-		t.Fatalf("expected status 409 got %d", rec.Code)
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("%s: expected status 409 got %d", action, rec.Code)
+		}
 	}
 }
 
@@ -1075,25 +4345,56 @@ func TestWriteJSONSetsHeadersAndBody(t *testing.T) {
 }
 
 type mockRunRepo struct {
-	createRunID   uuid.UUID
-	createErr     error
-	createCalled  bool
-	createCalls   int
-	createCtx     context.Context
-	createParams  domain.CreateRunParams
-	runByKey      map[string]uuid.UUID
-	getRunStatus  domain.RunStatus
-	getRunErr     error
-	getRunID      uuid.UUID
-	getRunCost    domain.RunCostBreakdown
-	getRunCostErr error
-	cancelErr     error
-	cancelRunID   uuid.UUID
-	approveErr    error
-	approveRunID  uuid.UUID
-}
-
-func (m *mockRunRepo) CreateRun(ctx context.Context, params domain.CreateRunParams) (uuid.UUID, error) {
+	createRunID      uuid.UUID
+	createSecret     string
+	createErr        error
+	createCalled     bool
+	createCalls      int
+	createCtx        context.Context
+	createParams     domain.CreateRunParams
+	runByKey         map[string]uuid.UUID
+	getRunStatus     domain.RunStatus
+	getRunErr        error
+	getRunID         uuid.UUID
+	getRunCost       domain.RunCostBreakdown
+	getRunCostErr    error
+	cancelErr        error
+	cancelRunID      uuid.UUID
+	cancelReason     string
+	cancelAsAdmin    bool
+	approveErr       error
+	approveRunID     uuid.UUID
+	approveStepID    uuid.UUID
+	rejectErr        error
+	rejectCtx        context.Context
+	rejectRunID      uuid.UUID
+	rejectStepID     uuid.UUID
+	pendingApprovals []domain.ApprovalGate
+	listRuns         []domain.RunListItem
+	listErr          error
+	listFilter       domain.RunFilter
+	listCalled       bool
+	listCtx          context.Context
+	adminRuns        []domain.AdminRunListItem
+	adminTenant      *uuid.UUID
+	adminRun         domain.RunDetail
+	adminErr         error
+	replayErr        error
+}
+
+func (m *mockRunRepo) SubmitRun(ctx context.Context, params domain.CreateRunParams) (domain.CreatedRun, error) {
+	id, err := m.createRun(ctx, params)
+	return domain.CreatedRun{ID: id, WebhookSecret: m.createSecret}, err
+}
+
+func (m *mockRunRepo) ReplayRun(ctx context.Context, sourceID uuid.UUID, stepInputs map[int]json.RawMessage) (domain.CreatedRun, error) {
+	if m.replayErr != nil {
+		return domain.CreatedRun{}, m.replayErr
+	}
+	return m.SubmitRun(ctx, domain.CreateRunParams{ReplayedFromRunID: &sourceID, StepInputs: stepInputs})
+}
+
+func (m *mockRunRepo) createRun(ctx context.Context, params domain.CreateRunParams) (uuid.UUID, error) {
 	m.createCalled = true
 	m.createCalls++
 	m.createCtx = ctx
@@ -1125,21 +4426,97 @@ func (m *mockRunRepo) GetRun(ctx context.Context, id uuid.UUID) (domain.RunStatu
 	return m.getRunStatus, m.getRunErr
 }
 
+func (m *mockRunRepo) ListRuns(ctx context.Context, filter domain.RunFilter) ([]domain.RunListItem, error) {
+	m.listCalled = true
+	m.listCtx = ctx
+	m.listFilter = filter
+	return m.listRuns, m.listErr
+}
+
 func (m *mockRunRepo) GetRunCost(ctx context.Context, id uuid.UUID) (domain.RunCostBreakdown, error) {
 	m.getRunID = id
 	return m.getRunCost, m.getRunCostErr
 }
 
-func (m *mockRunRepo) CancelRun(ctx context.Context, id uuid.UUID) error {
+func (m *mockRunRepo) CancelRun(ctx context.Context, id uuid.UUID, reason string) error {
+	m.cancelRunID = id
+	m.cancelReason = reason
+	return m.cancelErr
+}
+
+func (m *mockRunRepo) CancelRunAsAdmin(ctx context.Context, id uuid.UUID, reason string) error {
 	m.cancelRunID = id
+	m.cancelReason = reason
+	m.cancelAsAdmin = true
 	return m.cancelErr
 }
 
+func (m *mockRunRepo) AdminListRuns(ctx context.Context, apiKeyID *uuid.UUID, filter domain.RunFilter) ([]domain.AdminRunListItem, error) {
+	m.listCalled = true
+	m.listFilter = filter
+	m.adminTenant = apiKeyID
+	return m.adminRuns, m.adminErr
+}
+
+func (m *mockRunRepo) AdminGetRun(ctx context.Context, id uuid.UUID) (domain.RunDetail, error) {
+	m.getRunID = id
+	return m.adminRun, m.adminErr
+}
+
 func (m *mockRunRepo) ApproveRun(ctx context.Context, id uuid.UUID) error {
 	m.approveRunID = id
 	return m.approveErr
 }
 
+func (m *mockRunRepo) ApproveStep(ctx context.Context, runID, stepID uuid.UUID) error {
+	m.approveRunID = runID
+	m.approveStepID = stepID
+	return m.approveErr
+}
+
+func (m *mockRunRepo) RejectStep(ctx context.Context, runID, stepID uuid.UUID) error {
+	m.rejectCtx = ctx
+	m.rejectRunID = runID
+	m.rejectStepID = stepID
+	return m.rejectErr
+}
+
+func (m *mockRunRepo) ListPendingApprovals(ctx context.Context, runID uuid.UUID) ([]domain.ApprovalGate, error) {
+	return m.pendingApprovals, nil
+}
+
+type mockExporter struct {
+	run         domain.RunExport
+	runErr      error
+	job         domain.ExportJob
+	jobErr      error
+	artifact    []byte
+	createdWith string
+}
+
+func (m *mockExporter) ExportRun(ctx context.Context, runID uuid.UUID) (domain.RunExport, error) {
+	return m.run, m.runErr
+}
+
+func (m *mockExporter) CreateExportJob(ctx context.Context, format string) (domain.ExportJob, error) {
+	m.createdWith = format
+	return m.job, m.jobErr
+}
+
+func (m *mockExporter) GetExportJob(ctx context.Context, id uuid.UUID) (domain.ExportJob, error) {
+	return m.job, m.jobErr
+}
+
+func (m *mockExporter) GetExportArtifact(ctx context.Context, id uuid.UUID) (domain.ExportJob, []byte, error) {
+	if m.jobErr != nil {
+		return domain.ExportJob{}, nil, m.jobErr
+	}
+	if m.job.Status != domain.ExportSucceeded {
+		return m.job, nil, domain.ErrExportNotReady
+	}
+	return m.job, m.artifact, nil
+}
+
 type mockStepLister struct {
 	steps []domain.StepRecord
 	err   error
@@ -1149,6 +4526,63 @@ func (m *mockStepLister) ListSteps(ctx context.Context, runID uuid.UUID) ([]doma
 	return m.steps, m.err
 }
 
+type mockRunVersionReader struct {
+	version domain.RunVersion
+	err     error
+	runID   uuid.UUID
+}
+
+func (m *mockRunVersionReader) GetRunVersion(ctx context.Context, runID uuid.UUID) (domain.RunVersion, error) {
+	m.runID = runID
+	return m.version, m.err
+}
+
+type mockTimelineReader struct {
+	timeline domain.RunTimeline
+	err      error
+	runID    uuid.UUID
+}
+
+func (m *mockTimelineReader) GetRunTimeline(ctx context.Context, runID uuid.UUID) (domain.RunTimeline, error) {
+	m.runID = runID
+	return m.timeline, m.err
+}
+
+type mockStepLogReader struct {
+	pages  map[int64]domain.StepLogPage
+	err    error
+	runID  uuid.UUID
+	stepID uuid.UUID
+	limit  int
+}
+
+func (m *mockStepLogReader) ListStepLogs(ctx context.Context, runID, stepID uuid.UUID, afterSeq int64, limit int) (domain.StepLogPage, error) {
+	m.runID, m.stepID, m.limit = runID, stepID, limit
+	if m.err != nil {
+		return domain.StepLogPage{}, m.err
+	}
+	return m.pages[afterSeq], nil
+}
+
+type mockWebhookRepo struct {
+	deliveries    []domain.WebhookDeliveryRecord
+	listErr       error
+	listRunID     uuid.UUID
+	redeliverResp domain.WebhookDeliveryRecord
+	redeliverErr  error
+	redeliverID   uuid.UUID
+}
+
+func (m *mockWebhookRepo) ListWebhookDeliveries(ctx context.Context, runID uuid.UUID) ([]domain.WebhookDeliveryRecord, error) {
+	m.listRunID = runID
+	return m.deliveries, m.listErr
+}
+
+func (m *mockWebhookRepo) RedeliverWebhook(ctx context.Context, deliveryID uuid.UUID) (domain.WebhookDeliveryRecord, error) {
+	m.redeliverID = deliveryID
+	return m.redeliverResp, m.redeliverErr
+}
+
 func discardLogger() *slog.Logger {
 	return slog.New(slog.NewTextHandler(io.Discard, nil))
 }
@@ -1176,15 +4610,138 @@ func (m *mockAPIKeyResolver) ResolveAPIKey(ctx context.Context, bearerToken stri
 	return key, ok, nil
 }
 
+type mockWorkerRegistry struct {
+	resp      []domain.WorkerRecord
+	err       error
+	apiKeyID  uuid.UUID
+	listedAll bool
+	liveness  domain.WorkerLiveness
+}
+
+func (m *mockWorkerRegistry) ListActiveWorkers(ctx context.Context, apiKeyID uuid.UUID) ([]domain.WorkerRecord, error) {
+	m.apiKeyID = apiKeyID
+	return m.resp, m.err
+}
+
+func (m *mockWorkerRegistry) ListAllActiveWorkers(ctx context.Context) ([]domain.WorkerRecord, error) {
+	m.listedAll = true
+	return m.resp, m.err
+}
+
+func (m *mockWorkerRegistry) GetWorkerLiveness(ctx context.Context) (domain.WorkerLiveness, error) {
+	return m.liveness, m.err
+}
+
+type mockRunStats struct {
+	resp     []domain.DailyRunStats
+	err      error
+	called   bool
+	apiKeyID uuid.UUID
+	from     time.Time
+	to       time.Time
+}
+
+func (m *mockRunStats) ListDailyRunStats(ctx context.Context, apiKeyID uuid.UUID, from, to time.Time) ([]domain.DailyRunStats, error) {
+	m.called = true
+	m.apiKeyID = apiKeyID
+	m.from = from
+	m.to = to
+	return m.resp, m.err
+}
+
+type mockTokenUsage struct {
+	resp     []domain.TokenUsage
+	err      error
+	called   bool
+	apiKeyID uuid.UUID
+	from     time.Time
+	to       time.Time
+}
+
+func (m *mockTokenUsage) ListDailyTokenUsage(ctx context.Context, apiKeyID uuid.UUID, from, to time.Time) ([]domain.TokenUsage, error) {
+	m.called = true
+	m.apiKeyID = apiKeyID
+	m.from = from
+	m.to = to
+	return m.resp, m.err
+}
+
+type mockTenantPurger struct {
+	resp       domain.SignedPurgeReport
+	err        error
+	called     bool
+	ctx        context.Context
+	apiKeyID   uuid.UUID
+	signingKey []byte
+}
+
+func (m *mockTenantPurger) PurgeTenant(ctx context.Context, apiKeyID uuid.UUID, signingKey []byte) (domain.SignedPurgeReport, error) {
+	m.called = true
+	m.ctx = ctx
+	m.apiKeyID = apiKeyID
+	m.signingKey = signingKey
+	return m.resp, m.err
+}
+
+type mockAuditLog struct {
+	resp   []domain.AuditEntry
+	err    error
+	called bool
+	filter domain.AuditFilter
+}
+
+func (m *mockAuditLog) ListAuditEntries(ctx context.Context, filter domain.AuditFilter) ([]domain.AuditEntry, error) {
+	m.called = true
+	m.filter = filter
+	return m.resp, m.err
+}
+
 type mockAPIKeyManager struct {
-	createResp   domain.CreatedAPIKey
-	createErr    error
-	createParams domain.CreateAPIKeyParams
-	listResp     []domain.APIKeyRecord
-	listErr      error
-	listCalled   bool
-	revokeID     uuid.UUID
-	revokeErr    error
+	createResp       domain.CreatedAPIKey
+	createErr        error
+	createParams     domain.CreateAPIKeyParams
+	listResp         []domain.APIKeyRecord
+	listErr          error
+	listCalled       bool
+	listFilter       domain.APIKeyFilter
+	getResp          domain.APIKeyRecord
+	getErr           error
+	getID            uuid.UUID
+	retentionID      uuid.UUID
+	retentionDays    *int
+	retentionErr     error
+	runRetention     *int
+	runRetErr        error
+	budgetID         uuid.UUID
+	budgetUSD        *float64
+	scopesID         uuid.UUID
+	scopes           []string
+	scopesErr        error
+	cidrsID          uuid.UUID
+	cidrsErr         error
+	egressHostsID    uuid.UUID
+	egressHostsErr   error
+	slugIDs          map[string]uuid.UUID
+	slugID           uuid.UUID
+	slug             string
+	slugErr          error
+	webhookID        uuid.UUID
+	webhookParams    domain.SetWebhookDefaultsParams
+	webhookResp      domain.WebhookDefaults
+	webhookErr       error
+	rotateID         uuid.UUID
+	rotateOverlap    time.Duration
+	rotateErr        error
+	signingKeys      []domain.WebhookSigningKey
+	expireVersion    int
+	expireErr        error
+	revokeID         uuid.UUID
+	revokeErr        error
+	weightID         uuid.UUID
+	weight           int
+	scheduling       []domain.TenantScheduling
+	schedulingErr    error
+	templateLimitsID uuid.UUID
 }
 
 func (m *mockAPIKeyManager) CreateAPIKey(ctx context.Context, params domain.CreateAPIKeyParams) (domain.CreatedAPIKey, error) {
@@ -1196,11 +4753,126 @@ func (m *mockAPIKeyManager) CreateAPIKey(ctx context.Context, params domain.Crea
 	return m.createResp, m.createErr
 }
 
-func (m *mockAPIKeyManager) ListAPIKeys(ctx context.Context) ([]domain.APIKeyRecord, error) {
+func (m *mockAPIKeyManager) ListAPIKeys(ctx context.Context, filter domain.APIKeyFilter) ([]domain.APIKeyRecord, error) {
 	m.listCalled = true
+	m.listFilter = filter
 	return m.listResp, m.listErr
 }
 
+func (m *mockAPIKeyManager) GetAPIKey(ctx context.Context, id uuid.UUID) (domain.APIKeyRecord, error) {
+	m.getID = id
+	return m.getResp, m.getErr
+}
+
+func (m *mockAPIKeyManager) GetAPIKeyIDBySlug(ctx context.Context, slug string) (uuid.UUID, error) {
+	id, ok := m.slugIDs[slug]
+	if !ok {
+		return uuid.Nil, domain.ErrAPIKeyNotFound
+	}
+	return id, nil
+}
+
+func (m *mockAPIKeyManager) SetEventRetentionDays(ctx context.Context, id uuid.UUID, days *int) error {
+	m.retentionID = id
+	m.retentionDays = days
+	return m.retentionErr
+}
+
+func (m *mockAPIKeyManager) SetRunRetentionDays(ctx context.Context, id uuid.UUID, days *int) error {
+	m.retentionID = id
+	m.runRetention = days
+	return m.runRetErr
+}
+
+func (m *mockAPIKeyManager) SetMonthlyBudget(ctx context.Context, id uuid.UUID, usd *float64) (domain.MonthlyBudget, error) {
+	m.budgetID = id
+	m.budgetUSD = usd
+	if err := domain.ValidateMonthlyBudget(usd); err != nil {
+		return domain.MonthlyBudget{}, err
+	}
+	return domain.MonthlyBudget{APIKeyID: id, LimitUSD: usd, SpentUSD: 4.5}, nil
+}
+
+func (m *mockAPIKeyManager) SetSchedulingWeight(ctx context.Context, id uuid.UUID, weight int) error {
+	m.weightID = id
+	m.weight = weight
+	return domain.ValidateSchedulingWeight(weight)
+}
+
+func (m *mockAPIKeyManager) SetTemplateRunLimits(ctx context.Context, id uuid.UUID, limits map[string]int) (map[string]int, error) {
+	m.templateLimitsID = id
+	return domain.NormalizeTemplateRunLimits(limits)
+}
+
+func (m *mockAPIKeyManager) ListTenantScheduling(ctx context.Context) ([]domain.TenantScheduling, error) {
+	return m.scheduling, m.schedulingErr
+}
+
+func (m *mockAPIKeyManager) SetScopes(ctx context.Context, id uuid.UUID, scopes []string) ([]string, error) {
+	m.scopesID = id
+	m.scopes = scopes
+	if m.scopesErr != nil {
+		return nil, m.scopesErr
+	}
+	return domain.NormalizeScopes(scopes)
+}
+
+func (m *mockAPIKeyManager) SetAllowedCIDRs(ctx context.Context, id uuid.UUID, cidrs []string) ([]string, error) {
+	m.cidrsID = id
+	if m.cidrsErr != nil {
+		return nil, m.cidrsErr
+	}
+	return domain.NormalizeAllowedCIDRs(cidrs)
+}
+
+func (m *mockAPIKeyManager) SetEgressAllowHosts(ctx context.Context, id uuid.UUID, hosts []string) ([]string, error) {
+	m.egressHostsID = id
+	if m.egressHostsErr != nil {
+		return nil, m.egressHostsErr
+	}
+	normalized, err := egress.NormalizeHostRules(hosts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidEgressAllowHost, err)
+	}
+	return normalized, nil
+}
+
+func (m *mockAPIKeyManager) SetSlug(ctx context.Context, id uuid.UUID, slug string) error {
+	m.slugID = id
+	m.slug = slug
+	if m.slugErr != nil {
+		return m.slugErr
+	}
+	return domain.ValidateAPIKeySlug(slug)
+}
+
+func (m *mockAPIKeyManager) SetWebhookDefaults(ctx context.Context, id uuid.UUID, params domain.SetWebhookDefaultsParams) (domain.WebhookDefaults, error) {
+	m.webhookID = id
+	m.webhookParams = params
+	return m.webhookResp, m.webhookErr
+}
+
+func (m *mockAPIKeyManager) RotateWebhookSigningKey(ctx context.Context, id uuid.UUID, overlap time.Duration) (domain.WebhookSigningKey, error) {
+	m.rotateID = id
+	m.rotateOverlap = overlap
+	if m.rotateErr != nil {
+		return domain.WebhookSigningKey{}, m.rotateErr
+	}
+	if err := domain.ValidateWebhookSigningKeyOverlap(overlap); err != nil {
+		return domain.WebhookSigningKey{}, err
+	}
+	return domain.WebhookSigningKey{KeyID: "k2", Version: 2, Secret: "whsec_generated"}, nil
+}
+
+func (m *mockAPIKeyManager) ListWebhookSigningKeys(ctx context.Context, id uuid.UUID) ([]domain.WebhookSigningKey, error) {
+	return m.signingKeys, nil
+}
+
+func (m *mockAPIKeyManager) ExpireWebhookSigningKey(ctx context.Context, id uuid.UUID, version int) error {
+	m.expireVersion = version
+	return m.expireErr
+}
+
 func (m *mockAPIKeyManager) RevokeAPIKey(ctx context.Context, id uuid.UUID) error {
 	m.revokeID = id
 	return m.revokeErr
@@ -1232,11 +4904,11 @@ func (m *mockEventRepo) ResolveCursorByEventID(ctx context.Context, runID uuid.U
 		return 0, m.resolveErr
 	}
 	if m.resolveCursorByEventID == nil {
-		return 0, pgx.ErrNoRows
+		return 0, domain.ErrEventNotFound
 	}
 	seq, ok := m.resolveCursorByEventID[eventID]
 	if !ok {
-		return 0, pgx.ErrNoRows
+		return 0, domain.ErrEventNotFound
 	}
 	return seq, nil
 }
@@ -1250,3 +4922,282 @@ func (m *mockHealthChecker) Check(ctx context.Context) error {
 	m.calls++
 	return m.err
 }
+
+type mockTemplates struct {
+	putName  string
+	putSteps []domain.WorkflowTemplateStep
+	versions []domain.WorkflowTemplateVersion
+	listErr  error
+}
+
+func (m *mockTemplates) PutWorkflowTemplate(_ context.Context, name string, steps []domain.WorkflowTemplateStep) (domain.WorkflowTemplateVersion, error) {
+	m.putName, m.putSteps = name, steps
+	return domain.WorkflowTemplateVersion{Name: name, Version: 2, Steps: steps}, nil
+}
+
+func (m *mockTemplates) ListWorkflowTemplateVersions(context.Context, string) ([]domain.WorkflowTemplateVersion, error) {
+	return m.versions, m.listErr
+}
+
+func TestRouter_WorkflowTemplateVersions(t *testing.T) {
+	templates := &mockTemplates{versions: []domain.WorkflowTemplateVersion{
+		{Name: "ops", Version: 2, Steps: []domain.WorkflowTemplateStep{{Name: domain.StepLLM}, {Name: domain.StepTool}}},
+		{Name: "ops", Version: 1, Steps: []domain.WorkflowTemplateStep{{Name: domain.StepLLM}}},
+	}}
+	router := NewRouter(Deps{
+		RunRepo:    &mockRunRepo{},
+		StepRepo:   &mockStepLister{},
+		Templates:  templates,
+		AdminToken: "master-token",
+		Logger:     discardLogger(),
+	})
+
+	put := func(token, name, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/admin/workflow-templates/"+name, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := put("", "ops", `{"steps":[{"name":"LLM"}]}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without admin token got %d", rec.Code)
+	}
+	rec := put("master-token", "ops", `{"steps":[{"name":"llm"},{"name":"TOOL","on_failure":"skip"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	if templates.putName != "ops" || len(templates.putSteps) != 2 || templates.putSteps[0].Name != domain.StepLLM {
+		t.Fatalf("unexpected saved template %q %+v", templates.putName, templates.putSteps)
+	}
+	if rec := put("master-token", "ops", `{"steps":[{"name":"LLM","condition":"run.priority >"}]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an invalid condition got %d", rec.Code)
+	}
+	if rec := put("master-token", "not_a_name!", `{"steps":[{"name":"LLM"}]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an invalid name got %d", rec.Code)
+	}
+
+	list := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/workflow-templates/ops/versions", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	rec = list()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+	var resp struct {
+		Name     string                           `json:"name"`
+		Versions []domain.WorkflowTemplateVersion `json:"versions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Name != "ops" || len(resp.Versions) != 2 || resp.Versions[0].Version != 2 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	templates.listErr = domain.ErrWorkflowTemplateNotFound
+	if rec := list(); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for an unknown template got %d", rec.Code)
+	}
+}
+
+func TestRouter_ValidateWorkflowTemplate(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	validate := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/workflow-templates/validate", bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := validate(`{"steps":[{"name":"LLM","timeout_seconds":30,"condition":"steps.TOOL.output.ok"},{"name":"TOOL","timeout_seconds":30}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp domain.TemplateValidation
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Valid || len(resp.Diagnostics) != 1 || resp.Diagnostics[0].Code != domain.DiagnosticDependencyCycle {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	rec = validate(`{"steps":[{"name":"TOOL","timeout_seconds":30}]}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"valid":true,"diagnostics":[]`) {
+		t.Fatalf("expected a clean template got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := validate(`{"steps":`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for a malformed body got %d", rec.Code)
+	}
+}
+
+type mockSecrets struct {
+	values    map[string]string
+	putErr    error
+	deleteErr error
+}
+
+func (m *mockSecrets) PutSecret(_ context.Context, name, value string) (domain.Secret, error) {
+	if m.putErr != nil {
+		return domain.Secret{}, m.putErr
+	}
+	m.values[name] = value
+	return domain.Secret{Name: name}, nil
+}
+
+func (m *mockSecrets) ListSecrets(context.Context) ([]domain.Secret, error) {
+	out := []domain.Secret{}
+	for name := range m.values {
+		out = append(out, domain.Secret{Name: name})
+	}
+	return out, nil
+}
+
+func (m *mockSecrets) DeleteSecret(_ context.Context, name string) error {
+	if _, ok := m.values[name]; !ok {
+		return domain.ErrSecretNotFound
+	}
+	delete(m.values, name)
+	return m.deleteErr
+}
+
+func TestRouter_AdminSecrets(t *testing.T) {
+	store := &mockSecrets{values: map[string]string{}}
+	router := NewRouter(Deps{
+		RunRepo:    &mockRunRepo{},
+		StepRepo:   &mockStepLister{},
+		Secrets:    store,
+		AdminToken: "master-token",
+		Logger:     discardLogger(),
+	})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer master-token")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPut, "/admin/secrets/OPENAI_API_KEY", `{"value":"sk-123"}`)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "sk-123") {
+		t.Fatalf("expected status 200 without the value got %d: %s", rec.Code, rec.Body.String())
+	}
+	if store.values["OPENAI_API_KEY"] != "sk-123" {
+		t.Fatalf("expected secret stored, got %v", store.values)
+	}
+	if rec := do(http.MethodPut, "/admin/secrets/bad-name", `{"value":"x"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an invalid name got %d", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/admin/secrets/EMPTY", `{"value":""}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an empty value got %d", rec.Code)
+	}
+
+	rec = do(http.MethodGet, "/admin/secrets", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"name":"OPENAI_API_KEY"`) || strings.Contains(rec.Body.String(), "sk-123") {
+		t.Fatalf("unexpected list %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := do(http.MethodDelete, "/admin/secrets/OPENAI_API_KEY", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204 got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/admin/secrets/OPENAI_API_KEY", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 got %d", rec.Code)
+	}
+
+	store.putErr = domain.ErrSecretsKeyMissing
+	if rec := do(http.MethodPut, "/admin/secrets/OPENAI_API_KEY", `{"value":"sk-123"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503 without SECRETS_KEY got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/secrets", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without admin token got %d", rec.Code)
+	}
+}
+
+func TestRouter_EstimateWorkflowTemplateCost(t *testing.T) {
+	templates := &mockTemplates{versions: []domain.WorkflowTemplateVersion{
+		{Name: "ops", Version: 2, Steps: []domain.WorkflowTemplateStep{{Name: domain.StepLLM}, {Name: domain.StepTool}}},
+		{Name: "ops", Version: 1, Steps: []domain.WorkflowTemplateStep{{Name: domain.StepLLM}}},
+	}}
+	workers := &mockWorkerRegistry{resp: []domain.WorkerRecord{{
+		ID: uuid.New(),
+		Pricing: map[domain.StepName]domain.StepPricing{
+			domain.StepLLM: {Model: "m", Unit: domain.PricingUnitToken, UnitPriceUSD: 0.001, MinUnits: 100, MaxUnits: 300},
+		},
+	}}}
+	router := NewRouter(Deps{
+		RunRepo:   &mockRunRepo{},
+		StepRepo:  &mockStepLister{},
+		Templates: templates,
+		Workers:   workers,
+		Logger:    discardLogger(),
+	})
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/workflow-templates/ops/estimate?runs=10", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	var estimate domain.CostEstimate
+	if err := json.Unmarshal(rec.Body.Bytes(), &estimate); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if estimate.TemplateVersion != 2 || estimate.Runs != 10 || len(estimate.Steps) != 2 {
+		t.Fatalf("expected the latest version estimated for 10 runs, got %+v", estimate)
+	}
+	if math.Abs(estimate.MinCostUSD-1) > 1e-9 || math.Abs(estimate.MaxCostUSD-3) > 1e-9 {
+		t.Fatalf("expected 1..3 USD, got %v..%v", estimate.MinCostUSD, estimate.MaxCostUSD)
+	}
+	if len(estimate.UnpricedSteps) != 1 || estimate.UnpricedSteps[0] != domain.StepTool {
+		t.Fatalf("expected TOOL unpriced, got %v", estimate.UnpricedSteps)
+	}
+	if !workers.listedAll {
+		t.Fatal("expected pricing read from all active workers without a tenant")
+	}
+
+	rec = do(http.MethodGet, "/workflow-templates/ops/estimate?version=1", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &estimate); err != nil || estimate.TemplateVersion != 1 || len(estimate.Steps) != 1 {
+		t.Fatalf("expected version 1 estimated, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodGet, "/workflow-templates/ops/estimate?version=3", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for an unknown version got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/workflow-templates/ops/estimate?runs=0", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for invalid runs got %d", rec.Code)
+	}
+
+	rec = do(http.MethodPost, "/workflow-templates/validate?map_items=5", `{"steps":[{"name":"MAP","map_items":"run.metadata.items","map_step":"LLM","timeout_seconds":30}]}`)
+	var validation domain.TemplateValidation
+	if err := json.Unmarshal(rec.Body.Bytes(), &validation); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if validation.Estimate == nil || validation.Estimate.Steps[0].Executions != 5 || math.Abs(validation.Estimate.MaxCostUSD-1.5) > 1e-9 {
+		t.Fatalf("expected an estimate with the validation, got %s", rec.Body.String())
+	}
+
+	templates.listErr = domain.ErrWorkflowTemplateNotFound
+	if rec := do(http.MethodGet, "/workflow-templates/ops/estimate", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for an unknown template got %d", rec.Code)
+	}
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package httptransport
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/metrics"
+)
+
+// DefaultStreamReconnectAfter is the reconnect hint sent to streaming clients
+// when the server shuts down and no other value is configured.
+const DefaultStreamReconnectAfter = 2 * time.Second
+
+// Streams tracks long-lived streaming requests so a shutting-down server can
+// end them cleanly. After Shutdown, open streams send a terminal event telling
+// clients when to reconnect and return, and new streams are refused with 503,
+// so http.Server.Shutdown finds idle connections instead of timing out on
+// streams that never end.
+type Streams struct {
+	reconnectAfter time.Duration
+
+	mu       sync.Mutex
+	active   int
+	closing  chan struct{}
+	shutdown bool
+}
+
+// NewStreams returns a tracker whose clients are told to reconnect after
+// reconnectAfter; non-positive values use DefaultStreamReconnectAfter.
+func NewStreams(reconnectAfter time.Duration) *Streams {
+	if reconnectAfter <= 0 {
+		reconnectAfter = DefaultStreamReconnectAfter
+	}
+	return &Streams{
+		reconnectAfter: reconnectAfter,
+		closing:        make(chan struct{}),
+	}
+}
+
+// Shutdown tells every open stream to finish. It is safe to call more than
+// once and is meant for http.Server.RegisterOnShutdown.
+func (s *Streams) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return
+	}
+	s.shutdown = true
+	close(s.closing)
+}
+
+// Active returns the number of open streams.
+func (s *Streams) Active() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+// ReconnectAfter is the delay clients are asked to wait before reconnecting.
+func (s *Streams) ReconnectAfter() time.Duration {
+	return s.reconnectAfter
+}
+
+// open registers a new stream. It returns a channel closed on Shutdown and a
+// func that must be called when the stream ends; ok is false once the server
+// is shutting down.
+func (s *Streams) open() (closing <-chan struct{}, done func(), ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return nil, nil, false
+	}
+	s.active++
+	metrics.AddActiveStreams(1)
+
+	var once sync.Once
+	return s.closing, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.active--
+			s.mu.Unlock()
+			metrics.AddActiveStreams(-1)
+		})
+	}, true
+}
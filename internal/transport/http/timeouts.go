@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package httptransport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// errHandlerTimeout is the cause set on a request context whose handler ran
+// longer than the handler timeout.
+var errHandlerTimeout = errors.New("handler timeout")
+
+type handlerTimerKey struct{}
+
+// handlerTimeoutMiddleware cancels the request context timeout after the
+// handler starts, so slow queries are abandoned instead of holding a
+// connection. A 5xx written after the timeout becomes 503. Streaming handlers
+// call holdOpenForStream to opt out.
+func handlerTimeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithCancelCause(r.Context())
+			defer cancel(nil)
+			timer := time.AfterFunc(timeout, func() { cancel(errHandlerTimeout) })
+			defer timer.Stop()
+
+			ctx = context.WithValue(ctx, handlerTimerKey{}, timer)
+			next.ServeHTTP(&timeoutWriter{ResponseWriter: w, ctx: ctx}, r.WithContext(ctx))
+		})
+	}
+}
+
+// holdOpenForStream lifts the handler timeout and the server's read and
+// write deadlines for a response that streams until the client leaves.
+func holdOpenForStream(w http.ResponseWriter, r *http.Request) {
+	if timer, ok := r.Context().Value(handlerTimerKey{}).(*time.Timer); ok {
+		timer.Stop()
+	}
+	rc := http.NewResponseController(w)
+	// Writers that cannot change deadlines (tests) have none to lift.
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+}
+
+// timeoutWriter reports server errors caused by the handler timeout as 503.
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+}
+
+func (t *timeoutWriter) WriteHeader(code int) {
+	if code >= http.StatusInternalServerError && errors.Is(context.Cause(t.ctx), errHandlerTimeout) {
+		code = http.StatusServiceUnavailable
+	}
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *timeoutWriter) Flush() {
+	if flusher, ok := t.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (t *timeoutWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
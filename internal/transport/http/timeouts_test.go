@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package httptransport
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlerTimeoutMiddlewareReturns503(t *testing.T) {
+	h := handlerTimeoutMiddleware(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		http.Error(w, "failed to list runs", http.StatusInternalServerError)
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/runs", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503 got %d", rec.Code)
+	}
+}
+
+func TestHoldOpenForStreamLiftsTimeouts(t *testing.T) {
+	inner := handlerTimeoutMiddleware(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		holdOpenForStream(w, r)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(150 * time.Millisecond):
+		}
+		_, _ = io.WriteString(w, "data: late\n\n")
+	}))
+
+	srv := httptest.NewUnstartedServer(requestLoggingMiddleware(discardLogger())(inner))
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Config.ReadTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read stream: %v", err)
+	}
+	if string(body) != "data: late\n\n" {
+		t.Fatalf("expected the stream to outlive the timeouts, got %q", body)
+	}
+}
@@ -0,0 +1,32 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package httptransport
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed ui
+var uiAssets embed.FS
+
+// uiHandler serves the admin UI's static assets under /ui/. The assets carry
+// no data: the page asks for the admin token and sends it with each call to
+// the admin API, so they are served without authentication.
+func uiHandler() http.Handler {
+	assets, err := fs.Sub(uiAssets, "ui")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix("/ui/", http.FileServerFS(assets))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Scripts and styles come from /ui only, and the page cannot be
+		// framed, so injected markup can neither run nor be clickjacked.
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'; base-uri 'none'; form-action 'self'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		files.ServeHTTP(w, r)
+	})
+}
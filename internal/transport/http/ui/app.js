@@ -0,0 +1,306 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Admin UI: every call goes to the admin API with the admin token as a bearer
+// token. Run data is read per tenant through /admin/tenants/{id}/runs.
+(() => {
+  "use strict";
+
+  const tokenKey = "agent-runtime-admin-token";
+  const eventPollMs = 2000;
+  const $ = (id) => document.getElementById(id);
+
+  let selectedRun = null;
+  let eventCursor = 0;
+  let eventTimer = null;
+
+  function token() {
+    return sessionStorage.getItem(tokenKey) || "";
+  }
+
+  async function api(method, path, body) {
+    const opts = { method, headers: { Authorization: "Bearer " + token() } };
+    if (body !== undefined) {
+      opts.headers["Content-Type"] = "application/json";
+      opts.body = JSON.stringify(body);
+    }
+    const resp = await fetch(path, opts);
+    if (resp.status === 401) {
+      signOut();
+      throw new Error("The admin token was rejected.");
+    }
+    if (!resp.ok) {
+      throw new Error((await resp.text()).trim() || resp.statusText);
+    }
+    return resp.status === 204 ? null : resp.json();
+  }
+
+  function showError(err) {
+    $("error").textContent = err ? err.message || String(err) : "";
+    $("error").hidden = !err;
+  }
+
+  // run wraps an async UI action so its failure is shown instead of lost.
+  function run(action) {
+    return (...args) => {
+      showError(null);
+      return Promise.resolve(action(...args)).catch(showError);
+    };
+  }
+
+  function el(tag, text, className) {
+    const node = document.createElement(tag);
+    if (text !== undefined && text !== null) node.textContent = String(text);
+    if (className) node.className = className;
+    return node;
+  }
+
+  function row(cells) {
+    const tr = document.createElement("tr");
+    for (const cell of cells) {
+      const td = document.createElement("td");
+      if (cell instanceof Node) td.appendChild(cell);
+      else td.textContent = cell === null || cell === undefined ? "" : String(cell);
+      tr.appendChild(td);
+    }
+    return tr;
+  }
+
+  function time(value) {
+    return value ? new Date(value).toLocaleString() : "";
+  }
+
+  // duration formats the span from start to finish, or to now while open.
+  function duration(start, finish) {
+    const ms = (finish ? new Date(finish) : new Date()) - new Date(start);
+    return ms >= 0 ? " · " + (ms < 1000 ? ms + "ms" : (ms / 1000).toFixed(1) + "s") : "";
+  }
+
+  function show(view) {
+    for (const id of ["login", "runs", "keys"]) $(id).hidden = id !== view;
+    $("nav").hidden = view === "login";
+    if (view !== "runs") stopEvents();
+  }
+
+  function signOut() {
+    sessionStorage.removeItem(tokenKey);
+    stopEvents();
+    show("login");
+  }
+
+  // ---------------- API KEYS ----------------
+
+  // listKeys pages through every active key.
+  async function listKeys() {
+    const keys = [];
+    let before = "";
+    do {
+      const page = await api("GET", "/api-keys?limit=500" + (before ? "&before=" + before : ""));
+      keys.push(...page.api_keys);
+      before = page.next_before || "";
+    } while (before);
+    return keys;
+  }
+
+  async function loadKeys() {
+    const keys = await listKeys();
+    const rows = $("key-rows");
+    rows.replaceChildren();
+    for (const key of keys) {
+      const revoke = el("button", "Revoke", "danger");
+      revoke.type = "button";
+      revoke.addEventListener("click", run(async () => {
+        if (!confirm("Revoke API key " + key.name + "?")) return;
+        await api("DELETE", "/api-keys/" + key.id);
+        await loadKeys();
+      }));
+      rows.appendChild(row([
+        key.name,
+        key.slug,
+        el("code", key.id),
+        (key.scopes || []).join(", "),
+        key.max_concurrent_runs,
+        key.max_requests_per_min,
+        time(key.expires_at),
+        time(key.last_used_at),
+        key.request_count,
+        revoke,
+      ]));
+    }
+    return keys;
+  }
+
+  async function createKey(event) {
+    event.preventDefault();
+    const body = { name: $("key-name").value.trim() };
+    const scopes = $("key-scopes").value.split(",").map((s) => s.trim()).filter(Boolean);
+    if (scopes.length) body.scopes = scopes;
+    const created = await api("POST", "/api-keys", body);
+    $("key-token").textContent = created.token;
+    $("key-created").hidden = false;
+    $("key-form").reset();
+    await loadKeys();
+  }
+
+  // ---------------- RUNS ----------------
+
+  function tenantPath() {
+    return "/admin/tenants/" + encodeURIComponent($("tenant").value) + "/runs";
+  }
+
+  async function loadTenants() {
+    const keys = await listKeys();
+    const select = $("tenant");
+    const current = select.value;
+    select.replaceChildren();
+    for (const key of keys) {
+      const option = el("option", key.slug ? key.name + " (" + key.slug + ")" : key.name);
+      option.value = key.id;
+      select.appendChild(option);
+    }
+    if (current) select.value = current;
+  }
+
+  async function loadRuns(event) {
+    if (event) event.preventDefault();
+    if (!$("tenant").value) {
+      $("run-rows").replaceChildren();
+      return;
+    }
+    const status = $("status").value;
+    const { runs } = await api("GET", tenantPath() + (status ? "?status=" + status : ""));
+    const rows = $("run-rows");
+    rows.replaceChildren();
+    for (const r of runs) {
+      const tr = row([
+        el("code", r.id),
+        el("span", r.status, "badge " + r.status),
+        r.priority,
+        (r.tags || []).join(", "),
+        r.total_cost_usd.toFixed(4),
+        time(r.created_at),
+      ]);
+      tr.className = "clickable" + (r.id === selectedRun ? " selected" : "");
+      tr.addEventListener("click", run(() => openRun(r.id)));
+      rows.appendChild(tr);
+    }
+  }
+
+  async function openRun(id) {
+    stopEvents();
+    selectedRun = id;
+    eventCursor = 0;
+    $("events").replaceChildren();
+    $("run-detail").hidden = false;
+    for (const tr of $("run-rows").children) {
+      tr.classList.toggle("selected", tr.firstChild.textContent === id);
+    }
+    await refreshRun();
+    await pollEvents();
+    eventTimer = setInterval(run(pollEvents), eventPollMs);
+    $("events-live").textContent = "(live)";
+  }
+
+  async function refreshRun() {
+    const base = tenantPath() + "/" + selectedRun;
+    const [detail, { steps }] = await Promise.all([api("GET", base), api("GET", base + "/timeline")]);
+
+    $("run-id").textContent = detail.id;
+    $("run-status").textContent = detail.status;
+    $("run-status").className = "badge " + detail.status;
+
+    const approvals = $("approvals");
+    approvals.replaceChildren();
+    if (!detail.pending_approvals.length) approvals.appendChild(el("li", "none"));
+    for (const gate of detail.pending_approvals) {
+      const li = el("li");
+      li.appendChild(el("span", gate.name || gate.step_id));
+      li.appendChild(document.createTextNode(" "));
+      li.appendChild(el("span", gate.status, "badge " + gate.status));
+      if (gate.times_out_at) li.appendChild(document.createTextNode(" times out " + time(gate.times_out_at)));
+      if (gate.status === "WAITING_APPROVAL") {
+        for (const [label, suffix, cls] of [["Approve", "", "approve"], ["Reject", "/reject", "reject"]]) {
+          const button = el("button", label, cls);
+          button.type = "button";
+          button.addEventListener("click", run(async () => {
+            if (cls === "reject" && !confirm("Reject this approval? The run will fail.")) return;
+            await api("POST", base + "/approvals/" + gate.step_id + suffix);
+            await refreshRun();
+            await loadRuns();
+          }));
+          li.appendChild(button);
+        }
+      }
+      approvals.appendChild(li);
+    }
+
+    const list = $("steps");
+    list.replaceChildren();
+    for (const step of steps) {
+      const li = el("li", null, step.parent_step_id ? "child" : "");
+      li.appendChild(el("span", step.name + " "));
+      li.appendChild(el("span", step.status, "badge " + step.status));
+      if (step.started_at) li.appendChild(el("span", " " + time(step.started_at) + duration(step.started_at, step.finished_at), "hint"));
+      for (const seg of step.segments) {
+        const label = (seg.kind === "approval" ? "waiting" : "attempt " + seg.attempt) +
+          duration(seg.started_at, seg.finished_at) + (seg.outcome ? " " + seg.outcome : " (in progress)");
+        li.appendChild(el("div", label, "segment"));
+      }
+      list.appendChild(li);
+    }
+  }
+
+  async function pollEvents() {
+    if (!selectedRun) return;
+    const runID = selectedRun;
+    const { events } = await api("GET", tenantPath() + "/" + runID + "/events?after=" + eventCursor);
+    if (runID !== selectedRun) return;
+    const rows = $("events");
+    for (const ev of events) {
+      const payload = el("pre", ev.payload ? JSON.stringify(ev.payload) : "");
+      rows.appendChild(row([ev.seq, time(ev.created_at), ev.type, payload]));
+      eventCursor = ev.seq;
+    }
+    if (events.length) await refreshRun();
+  }
+
+  function stopEvents() {
+    if (eventTimer) clearInterval(eventTimer);
+    eventTimer = null;
+    $("events-live").textContent = "";
+  }
+
+  // ---------------- WIRING ----------------
+
+  async function openView(view) {
+    show(view);
+    if (view === "keys") await loadKeys();
+    if (view === "runs") {
+      await loadTenants();
+      await loadRuns();
+    }
+  }
+
+  document.addEventListener("DOMContentLoaded", () => {
+    $("login-form").addEventListener("submit", run(async (event) => {
+      event.preventDefault();
+      sessionStorage.setItem(tokenKey, $("token").value);
+      $("token").value = "";
+      await openView("runs");
+    }));
+    $("logout").addEventListener("click", signOut);
+    for (const button of document.querySelectorAll("nav [data-view]")) {
+      button.addEventListener("click", run(() => openView(button.dataset.view)));
+    }
+    $("runs-filter").addEventListener("submit", run(loadRuns));
+    $("tenant").addEventListener("change", run(() => {
+      stopEvents();
+      selectedRun = null;
+      $("run-detail").hidden = true;
+      return loadRuns();
+    }));
+    $("key-form").addEventListener("submit", run(createKey));
+
+    if (token()) run(() => openView("runs"))();
+    else show("login");
+  });
+})();
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package httptransport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouter_ServesAdminUI(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:    &mockRunRepo{},
+		StepRepo:   &mockStepLister{},
+		AdminToken: "master-token",
+		AdminUI:    true,
+		Logger:     discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/ui", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/ui/" {
+		t.Fatalf("expected redirect to /ui/ got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	for _, path := range []string{"/ui/", "/ui/app.js", "/ui/style.css"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200 got %d", path, rec.Code)
+		}
+		if csp := rec.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "default-src 'self'") {
+			t.Fatalf("%s: expected restrictive CSP got %q", path, csp)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/ui/", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), "app.js") {
+		t.Fatal("expected index.html to load app.js")
+	}
+}
+
+func TestRouter_AdminUIDisabled(t *testing.T) {
+	router := NewRouter(Deps{
+		RunRepo:  &mockRunRepo{},
+		StepRepo: &mockStepLister{},
+		Logger:   discardLogger(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/ui/", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 got %d", rec.Code)
+	}
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/adiadia/agent-runtime/internal/auth"
+)
+
+const headerForwardedFor = "X-Forwarded-For"
+
+// RequireAllowedIP rejects requests from addresses outside the resolved API
+// key's allowed CIDRs with 403. The client address is the connection peer,
+// unless the peer is one of trustedProxies, in which case X-Forwarded-For is
+// walked right to left past further trusted hops. It must run after
+// APITokenAuth; keys without an allow-list are not restricted.
+func RequireAllowedIP(trustedProxies []netip.Prefix, logger *slog.Logger) func(http.Handler) http.Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := auth.APIKeyFromContext(r.Context())
+			if !ok || len(key.AllowedCIDRs) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			addr, found := ClientAddr(r, trustedProxies)
+			if !found || !key.AllowsAddr(addr) {
+				logger.Warn("request blocked by api key ip allow-list",
+					"path", r.URL.Path,
+					"api_key_id", key.ID,
+					"remote_addr", r.RemoteAddr,
+					"client_ip", addr,
+				)
+				http.Error(w, "client address not allowed for api key", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientAddr returns the address of the client that sent r. X-Forwarded-For
+// is only consulted when the connection peer is a trusted proxy, and the
+// first untrusted hop from the right is the client.
+func ClientAddr(r *http.Request, trustedProxies []netip.Prefix) (netip.Addr, bool) {
+	addr, ok := parseRemoteAddr(r.RemoteAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	if !containsAddr(trustedProxies, addr) {
+		return addr, true
+	}
+
+	hops := make([]string, 0, 4)
+	for _, value := range r.Header.Values(headerForwardedFor) {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = hop.Unmap()
+		if !containsAddr(trustedProxies, addr) {
+			return addr, true
+		}
+	}
+
+	// Every hop is a trusted proxy; the leftmost one is the best we know.
+	return addr, true
+}
+
+// ParseCIDRList parses a comma-separated list of CIDRs or bare addresses.
+func ParseCIDRList(raw string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(part)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(part)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid cidr %q", part)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func parseRemoteAddr(remoteAddr string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.WithZone("").Unmap(), true
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
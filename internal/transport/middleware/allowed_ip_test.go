@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/google/uuid"
+)
+
+func TestRequireAllowedIP(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	handler := RequireAllowedIP(trusted, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	restricted := &auth.APIKey{ID: uuid.New(), AllowedCIDRs: []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}}
+
+	tests := []struct {
+		name         string
+		key          *auth.APIKey
+		remoteAddr   string
+		forwardedFor string
+		wantStatus   int
+	}{
+		{name: "no allow-list", key: &auth.APIKey{ID: uuid.New()}, remoteAddr: "198.51.100.1:5000", wantStatus: http.StatusOK},
+		{name: "direct allowed", key: restricted, remoteAddr: "203.0.113.9:5000", wantStatus: http.StatusOK},
+		{name: "direct denied", key: restricted, remoteAddr: "198.51.100.1:5000", wantStatus: http.StatusForbidden},
+		{name: "ipv4-mapped peer", key: restricted, remoteAddr: "[::ffff:203.0.113.9]:5000", wantStatus: http.StatusOK},
+		{name: "spoofed header from untrusted peer", key: restricted, remoteAddr: "198.51.100.1:5000", forwardedFor: "203.0.113.9", wantStatus: http.StatusForbidden},
+		{name: "forwarded by trusted proxy", key: restricted, remoteAddr: "10.0.0.5:5000", forwardedFor: "203.0.113.9", wantStatus: http.StatusOK},
+		{name: "client spoofs leftmost hop", key: restricted, remoteAddr: "10.0.0.5:5000", forwardedFor: "203.0.113.9, 198.51.100.1, 10.0.0.7", wantStatus: http.StatusForbidden},
+		{name: "garbage hop", key: restricted, remoteAddr: "10.0.0.5:5000", forwardedFor: "not-an-ip", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/runs", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			req = req.WithContext(auth.WithAPIKey(req.Context(), *tc.key))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d got %d", tc.wantStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestParseCIDRList(t *testing.T) {
+	got, err := ParseCIDRList(" 10.1.0.0/16, 192.0.2.1 ,,::1")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.1.0.0/16"),
+		netip.MustParsePrefix("192.0.2.1/32"),
+		netip.MustParsePrefix("::1/128"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v got %v", want, got)
+		}
+	}
+
+	if got, err := ParseCIDRList(""); err != nil || got != nil {
+		t.Fatalf("expected empty list, got %v (err=%v)", got, err)
+	}
+	if _, err := ParseCIDRList("10.0.0.0/8,nope"); err == nil {
+		t.Fatal("expected error for invalid entry")
+	}
+}
@@ -8,12 +8,13 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/adiadia/agent-runtime/internal/clock"
 )
 
 const healthzPath = "/healthz"
+const readyzPath = "/readyz"
 const metricsPath = "/metrics"
 const versionPath = "/version"
 const headerRateLimitLimit = "X-RateLimit-Limit"
@@ -25,15 +26,22 @@ type APIKeyResolver interface {
 }
 
 // APITokenAuth enforces bearer-token authentication for all routes except
-// /healthz, /metrics, and /version; resolves api_key_id from token, and stores
-// it on request context.
+// /healthz, /readyz, /metrics, and /version; resolves api_key_id from token,
+// and stores it on request context.
 func APITokenAuth(resolver APIKeyResolver, logger *slog.Logger) func(http.Handler) http.Handler {
-	return apiTokenAuthWithLimiter(resolver, newInMemoryRateLimiter(), logger)
+	return APITokenAuthWithClock(resolver, nil, logger)
+}
+
+// APITokenAuthWithClock is APITokenAuth with the rate limiter driven by clk
+// (the wall clock when nil).
+func APITokenAuthWithClock(resolver APIKeyResolver, clk clock.Clock, logger *slog.Logger) func(http.Handler) http.Handler {
+	return apiTokenAuthWithLimiter(resolver, newInMemoryRateLimiter(), clock.OrReal(clk), logger)
 }
 
 func apiTokenAuthWithLimiter(
 	resolver APIKeyResolver,
 	limiter *inMemoryRateLimiter,
+	clk clock.Clock,
 	logger *slog.Logger,
 ) func(http.Handler) http.Handler {
 	if resolver == nil {
@@ -42,6 +50,7 @@ func apiTokenAuthWithLimiter(
 	if limiter == nil {
 		panic("middleware.APITokenAuth requires a limiter")
 	}
+	clk = clock.OrReal(clk)
 
 	if logger == nil {
 		logger = slog.Default()
@@ -49,7 +58,7 @@ func apiTokenAuthWithLimiter(
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path == healthzPath || r.URL.Path == metricsPath || r.URL.Path == versionPath {
+			if r.URL.Path == healthzPath || r.URL.Path == readyzPath || r.URL.Path == metricsPath || r.URL.Path == versionPath {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -87,7 +96,7 @@ func apiTokenAuthWithLimiter(
 				return
 			}
 
-			decision := limiter.Allow(key.ID, key.MaxRequestsPerMin, time.Now())
+			decision := limiter.Allow(key.ID, key.MaxRequestsPerMin, clk.Now())
 			w.Header().Set(headerRateLimitLimit, strconv.Itoa(decision.LimitPerMinute))
 			w.Header().Set(headerRateLimitRemaining, strconv.Itoa(decision.Remaining))
 			if !decision.Allowed {
@@ -11,8 +11,10 @@ import (
 	"net/http/httptest"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/adiadia/agent-runtime/internal/clock"
 	"github.com/google/uuid"
 )
 
@@ -33,6 +35,19 @@ func TestAPITokenAuth(t *testing.T) {
 		}
 	})
 
+	t.Run("allows readyz path without auth", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+		rec := httptest.NewRecorder()
+
+		APITokenAuth(&mockAPIKeyResolver{}, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d got %d", http.StatusOK, rec.Code)
+		}
+	})
+
 	t.Run("allows metrics path without auth", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
 		rec := httptest.NewRecorder()
@@ -194,6 +209,51 @@ func TestAPITokenAuth(t *testing.T) {
 	})
 }
 
+func TestAPITokenAuthWithClockRefillsFromInjectedClock(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	resolver := &mockAPIKeyResolver{
+		keyByToken: map[string]auth.APIKey{
+			"two-per-min": {ID: uuid.New(), MaxRequestsPerMin: 2},
+		},
+	}
+	clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+
+	handler := APITokenAuthWithClock(resolver, clk, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/runs", nil)
+		req.Header.Set("Authorization", "Bearer two-per-min")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := send(); rec.Code != http.StatusOK {
+			t.Fatalf("expected request %d to pass, got %d", i+1, rec.Code)
+		}
+	}
+
+	rec := send()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected bucket to be empty, got %d", rec.Code)
+	}
+	if got := rec.Header().Get(headerRetryAfter); got != "30" {
+		t.Fatalf("expected %s=30 got %q", headerRetryAfter, got)
+	}
+
+	// One token refills every 30s at 2/min; no sleeping required.
+	clk.Advance(29 * time.Second)
+	if rec := send(); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected no refill after 29s, got %d", rec.Code)
+	}
+	clk.Advance(time.Second)
+	if rec := send(); rec.Code != http.StatusOK {
+		t.Fatalf("expected refill after 30s, got %d", rec.Code)
+	}
+}
+
 func TestAPITokenAuthPanicsWithoutToken(t *testing.T) {
 	defer func() {
 		if r := recover(); r == nil {
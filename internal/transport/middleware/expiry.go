@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+)
+
+const headerAPIKeyExpiresAt = "X-API-Key-Expires-At"
+const headerWarning = "Warning"
+
+// APIKeyExpiryWarning marks responses to keys that expire within window with
+// X-API-Key-Expires-At and a Warning header, and counts them in
+// api_key_expiry_warnings_total. It must run after APITokenAuth.
+func APIKeyExpiryWarning(window time.Duration, clk clock.Clock, logger *slog.Logger) func(http.Handler) http.Handler {
+	clk = clock.OrReal(clk)
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := auth.APIKeyFromContext(r.Context())
+			if ok && key.ExpiresAt != nil && key.ExpiresAt.Sub(clk.Now()) <= window {
+				expiresAt := key.ExpiresAt.UTC().Format(time.RFC3339)
+				w.Header().Set(headerAPIKeyExpiresAt, expiresAt)
+				w.Header().Set(headerWarning, `299 - "API key expires at `+expiresAt+`"`)
+				metrics.IncAPIKeyExpiryWarning()
+				logger.Debug("api key close to expiry",
+					"api_key_id", key.ID,
+					"expires_at", expiresAt,
+				)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
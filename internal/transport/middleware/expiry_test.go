@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/google/uuid"
+)
+
+func TestAPIKeyExpiryWarning(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	handler := APIKeyExpiryWarning(14*24*time.Hour, clock.NewFake(now), logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	soon := now.Add(3 * 24 * time.Hour)
+	later := now.Add(30 * 24 * time.Hour)
+	tests := []struct {
+		name      string
+		expiresAt *time.Time
+		want      string
+	}{
+		{name: "within window", expiresAt: &soon, want: "2026-03-04T12:00:00Z"},
+		{name: "outside window", expiresAt: &later},
+		{name: "never expires"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/runs/x", nil)
+			req = req.WithContext(auth.WithAPIKey(req.Context(), auth.APIKey{ID: uuid.New(), ExpiresAt: tc.expiresAt}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200 got %d", rec.Code)
+			}
+			if got := rec.Header().Get(headerAPIKeyExpiresAt); got != tc.want {
+				t.Fatalf("expected %s %q got %q", headerAPIKeyExpiresAt, tc.want, got)
+			}
+			if gotWarning := rec.Header().Get(headerWarning) != ""; gotWarning != (tc.want != "") {
+				t.Fatalf("expected Warning header present=%v, got %q", tc.want != "", rec.Header().Get(headerWarning))
+			}
+		})
+	}
+}
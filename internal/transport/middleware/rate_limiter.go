@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/adiadia/agent-runtime/internal/auth"
 	"github.com/google/uuid"
 )
 
@@ -87,3 +88,22 @@ func (l *inMemoryRateLimiter) Allow(apiKeyID uuid.UUID, limitPerMinute int, now
 	decision.RetryAfterSeconds = waitSeconds
 	return decision
 }
+
+// RateLimiter applies API keys' per-minute request limits to requests that do
+// not arrive over HTTP, such as run commands consumed from a message queue. It
+// keeps its own buckets, separate from APITokenAuth's.
+type RateLimiter struct {
+	limiter *inMemoryRateLimiter
+}
+
+// NewRateLimiter returns a RateLimiter with full buckets.
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{limiter: newInMemoryRateLimiter()}
+}
+
+// Allow takes one request from key's bucket. When the bucket is empty it
+// reports false and how long until a request is allowed again.
+func (l *RateLimiter) Allow(key auth.APIKey, now time.Time) (bool, time.Duration) {
+	decision := l.limiter.Allow(key.ID, key.MaxRequestsPerMin, now)
+	return decision.Allowed, time.Duration(decision.RetryAfterSeconds) * time.Second
+}
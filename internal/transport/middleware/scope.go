@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/adiadia/agent-runtime/internal/auth"
+)
+
+// RequireScope rejects requests whose resolved API key lacks scope with 403.
+// It must run after APITokenAuth; a request without a resolved key is
+// rejected as well.
+func RequireScope(scope string, logger *slog.Logger) func(http.Handler) http.Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := auth.APIKeyFromContext(r.Context())
+			if !ok || !key.HasScope(scope) {
+				logger.Warn("request blocked by api key scope",
+					"path", r.URL.Path,
+					"api_key_id", key.ID,
+					"required_scope", scope,
+				)
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+				http.Error(w, "api key lacks required scope "+scope, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
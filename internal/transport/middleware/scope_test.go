@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/google/uuid"
+)
+
+func TestRequireScope(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := RequireScope("runs:write", logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		key        *auth.APIKey
+		wantStatus int
+	}{
+		{name: "granted", key: &auth.APIKey{ID: uuid.New(), Scopes: []string{"runs:read", "runs:write"}}, wantStatus: http.StatusOK},
+		{name: "missing scope", key: &auth.APIKey{ID: uuid.New(), Scopes: []string{"runs:read"}}, wantStatus: http.StatusForbidden},
+		{name: "no scopes", key: &auth.APIKey{ID: uuid.New()}, wantStatus: http.StatusForbidden},
+		{name: "unauthenticated", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/runs", nil)
+			if tc.key != nil {
+				req = req.WithContext(auth.WithAPIKey(req.Context(), *tc.key))
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d got %d", tc.wantStatus, rec.Code)
+			}
+			if tc.wantStatus == http.StatusForbidden {
+				want := `Bearer error="insufficient_scope", scope="runs:write"`
+				if got := rec.Header().Get("WWW-Authenticate"); got != want {
+					t.Fatalf("expected WWW-Authenticate %q got %q", want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+)
+
+// BreakerConfig configures the worker's per-step-type circuit breakers. A
+// breaker opens when at least MinRequests executions finished within Window
+// and FailureRate of them failed. While open, the worker does not claim
+// steps of that type; after Cooldown it half-opens and claims one step to
+// probe recovery, closing on success and reopening on failure.
+type BreakerConfig struct {
+	FailureRate float64
+	MinRequests int
+	Window      time.Duration
+	Cooldown    time.Duration
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitHalfOpen:
+		return "half_open"
+	case circuitOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+type circuit struct {
+	state       circuitState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	// probing is set while the half-open probe step executes.
+	probing bool
+}
+
+// circuitBreakers tracks executor outcomes per step type. A nil
+// *circuitBreakers never blocks anything.
+type circuitBreakers struct {
+	mu       sync.Mutex
+	cfg      BreakerConfig
+	logger   *slog.Logger
+	circuits map[domain.StepName]*circuit
+}
+
+func newCircuitBreakers(cfg BreakerConfig, logger *slog.Logger) *circuitBreakers {
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+	return &circuitBreakers{
+		cfg:      cfg,
+		logger:   logger,
+		circuits: make(map[domain.StepName]*circuit),
+	}
+}
+
+// blocked returns the step types not to claim at now: open breakers, and
+// half-open ones whose probe is still executing. Open breakers whose cooldown
+// passed half-open here.
+func (b *circuitBreakers) blocked(now time.Time) []domain.StepName {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var names []domain.StepName
+	for name, c := range b.circuits {
+		if c.state == circuitOpen && now.Sub(c.openedAt) >= b.cfg.Cooldown {
+			b.setState(name, c, circuitHalfOpen)
+		}
+		if c.state == circuitOpen || (c.state == circuitHalfOpen && c.probing) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// claimed notes that a step of type name was claimed; in the half-open state
+// it is the probe.
+func (b *circuitBreakers) claimed(name domain.StepName) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c, ok := b.circuits[name]; ok && c.state == circuitHalfOpen {
+		c.probing = true
+	}
+}
+
+// halfOpen returns the step types whose breaker is half-open, so a claim of
+// one of them is its probe.
+func (b *circuitBreakers) halfOpen() []domain.StepName {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var names []domain.StepName
+	for name, c := range b.circuits {
+		if c.state == circuitHalfOpen {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// release ends a probe that settled without an executor outcome, e.g.
+// because its run was canceled, so another step can probe.
+func (b *circuitBreakers) release(name domain.StepName) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c, ok := b.circuits[name]; ok {
+		c.probing = false
+	}
+}
+
+// record counts an execution of a step of type name that finished at now.
+func (b *circuitBreakers) record(name domain.StepName, failed bool, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[name]
+	if !ok {
+		c = &circuit{windowStart: now}
+		b.circuits[name] = c
+	}
+
+	switch c.state {
+	case circuitHalfOpen:
+		c.probing = false
+		if failed {
+			c.openedAt = now
+			b.setState(name, c, circuitOpen)
+			return
+		}
+		c.windowStart, c.requests, c.failures = now, 0, 0
+		b.setState(name, c, circuitClosed)
+	case circuitOpen:
+		// A step claimed before the breaker opened; its outcome is stale.
+	default:
+		if now.Sub(c.windowStart) >= b.cfg.Window {
+			c.windowStart, c.requests, c.failures = now, 0, 0
+		}
+		c.requests++
+		if failed {
+			c.failures++
+		}
+		if c.requests >= b.cfg.MinRequests && float64(c.failures)/float64(c.requests) >= b.cfg.FailureRate {
+			c.openedAt = now
+			b.setState(name, c, circuitOpen)
+		}
+	}
+}
+
+func (b *circuitBreakers) setState(name domain.StepName, c *circuit, state circuitState) {
+	b.logger.Warn("executor circuit "+state.String(),
+		"step", name,
+		"requests", c.requests,
+		"failures", c.failures,
+	)
+	if state == circuitOpen {
+		metrics.IncExecutorCircuitOpen(string(name))
+	}
+	metrics.SetExecutorCircuitState(string(name), int(state))
+	c.state = state
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+)
+
+func TestCircuitBreakerOpensHalfOpensAndCloses(t *testing.T) {
+	b := newCircuitBreakers(BreakerConfig{
+		FailureRate: 0.5,
+		MinRequests: 4,
+		Window:      time.Minute,
+		Cooldown:    30 * time.Second,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	b.record(domain.StepLLM, true, now)
+	b.record(domain.StepLLM, true, now)
+	b.record(domain.StepLLM, false, now)
+	if got := b.blocked(now); len(got) != 0 {
+		t.Fatalf("expected breaker closed below min requests, got %v", got)
+	}
+	b.record(domain.StepLLM, false, now)
+	if got := b.blocked(now); !slices.Equal(got, []domain.StepName{domain.StepLLM}) {
+		t.Fatalf("expected LLM blocked at 50%% failures, got %v", got)
+	}
+	b.record(domain.StepTool, false, now)
+	if got := b.blocked(now.Add(29 * time.Second)); !slices.Equal(got, []domain.StepName{domain.StepLLM}) {
+		t.Fatalf("expected only LLM blocked during cooldown, got %v", got)
+	}
+
+	// After the cooldown one probe is let through.
+	now = now.Add(30 * time.Second)
+	if got := b.blocked(now); len(got) != 0 {
+		t.Fatalf("expected half-open breaker to allow a probe, got %v", got)
+	}
+	if got := b.halfOpen(); !slices.Equal(got, []domain.StepName{domain.StepLLM}) {
+		t.Fatalf("expected LLM claimed as a probe, got %v", got)
+	}
+	b.claimed(domain.StepLLM)
+	if got := b.blocked(now); !slices.Equal(got, []domain.StepName{domain.StepLLM}) {
+		t.Fatalf("expected LLM blocked while the probe runs, got %v", got)
+	}
+	b.record(domain.StepLLM, true, now)
+	if got := b.blocked(now.Add(time.Second)); !slices.Equal(got, []domain.StepName{domain.StepLLM}) {
+		t.Fatalf("expected failed probe to reopen the breaker, got %v", got)
+	}
+
+	now = now.Add(30 * time.Second)
+	b.blocked(now)
+	b.claimed(domain.StepLLM)
+	b.record(domain.StepLLM, false, now)
+	if got := b.blocked(now); len(got) != 0 {
+		t.Fatalf("expected successful probe to close the breaker, got %v", got)
+	}
+}
+
+func TestCircuitBreakerWindowResets(t *testing.T) {
+	b := newCircuitBreakers(BreakerConfig{FailureRate: 1, MinRequests: 2, Window: time.Minute}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	b.record(domain.StepTool, true, now)
+	b.record(domain.StepTool, true, now.Add(time.Minute))
+	if got := b.blocked(now.Add(time.Minute)); len(got) != 0 {
+		t.Fatalf("expected failures in separate windows not to open the breaker, got %v", got)
+	}
+}
+
+func TestNilCircuitBreakersNeverBlock(t *testing.T) {
+	var b *circuitBreakers
+	b.record(domain.StepLLM, true, time.Now())
+	b.claimed(domain.StepLLM)
+	b.release(domain.StepLLM)
+	if got := b.blocked(time.Now()); got != nil {
+		t.Fatalf("expected disabled breakers to block nothing, got %v", got)
+	}
+}
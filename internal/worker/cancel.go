@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+)
+
+// errStepCanceled is the cause set on an executing step's context when its
+// run was canceled.
+var errStepCanceled = errors.New("run canceled while step was executing")
+
+// watchCancellation checks every cancelCheckInterval whether s's run was
+// canceled and, if so, cancels the executor's context with errStepCanceled.
+// It stops when the returned func is called.
+func (w *Worker) watchCancellation(ctx context.Context, s domain.ClaimedStep, cancel context.CancelCauseFunc) (stop func()) {
+	ctx, stopWatching := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(w.cancelCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			canceled, err := w.queue.StepCanceled(ctx, s)
+			if err != nil {
+				if ctx.Err() == nil {
+					w.logger.Warn("cancellation check failed",
+						"run_id", s.RunID,
+						"step_id", s.StepID,
+						"error", err,
+					)
+				}
+				continue
+			}
+			if canceled {
+				w.logger.Info("interrupting canceled step",
+					"api_key_id", w.apiKeyID,
+					"run_id", s.RunID,
+					"step_id", s.StepID,
+					"step", s.Name,
+				)
+				cancel(errStepCanceled)
+				return
+			}
+		}
+	}()
+
+	return func() {
+		stopWatching()
+		wg.Wait()
+	}
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+)
+
+// processBatch claims up to claimBatchSize steps in one transaction and runs
+// them at once, one executor goroutine each, returning when all have
+// settled. When the first runnable step needs handling at claim, it claims
+// that step on its own instead.
+func (w *Worker) processBatch(ctx context.Context) (int, error) {
+	claimStart := time.Now()
+	steps, err := w.queue.ClaimSteps(ctx, w.claimRequest(), w.claimBatchSize)
+	metrics.ObserveWorkerClaimLatency(time.Since(claimStart))
+	if errors.Is(err, domain.ErrClaimSingly) {
+		return w.processOne(ctx)
+	}
+	if err != nil {
+		if errors.Is(err, domain.ErrNoStepToClaim) {
+			return 0, nil
+		}
+		w.logger.Error("claim step batch failed", "error", err)
+		return 0, err
+	}
+	metrics.ObserveClaimBatchSize(len(steps))
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(steps))
+	for i, s := range steps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = w.runClaimed(ctx, s)
+		}()
+	}
+	wg.Wait()
+
+	return len(steps), errors.Join(errs...)
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/adiadia/agent-runtime/internal/egress"
+)
+
+// hostTransports builds the transport of HTTP steps: the worker's egress
+// policy narrowed to the tenant's egress allow-list and, unless allowed,
+// kept off loopback and private addresses. A worker serves one
+// tenant, so it keeps the transport for the last list it saw and replaces it
+// when the list changes, keeping connections pooled across steps.
+type hostTransports struct {
+	policy egress.Policy
+
+	mu    sync.Mutex
+	hosts egress.HostRules
+	rt    http.RoundTripper
+}
+
+func newHostTransports(policy *egress.Policy, allowPrivate bool) *hostTransports {
+	t := &hostTransports{}
+	if policy != nil {
+		t.policy = *policy
+	}
+	t.policy.BlockPrivate = !allowPrivate
+	return t
+}
+
+// forHosts returns the transport that allows the destinations of hosts, or
+// any the policy allows when hosts is empty.
+func (t *hostTransports) forHosts(hosts egress.HostRules) http.RoundTripper {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.rt != nil && slices.Equal(t.hosts, hosts) {
+		return t.rt
+	}
+	if closer, ok := t.rt.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+	policy := t.policy
+	policy.Hosts = hosts
+	t.hosts, t.rt = slices.Clone(hosts), policy.Transport()
+	return t.rt
+}
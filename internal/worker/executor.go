@@ -6,9 +6,20 @@ import (
 	"context"
 	"encoding/json"
 
+	"github.com/adiadia/agent-runtime/internal/domain"
 	"github.com/google/uuid"
 )
 
+// StepExecutor runs one step and reports its output and what it cost. The
+// worker stores the detail in steps.cost_detail and adds CostUSD to the run.
 type StepExecutor interface {
-	Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, float64, error)
+	Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, domain.CostDetail, error)
+}
+
+// PricedExecutor is a StepExecutor that declares what one execution costs.
+// The worker records the pricing in the workers registry, where the API reads
+// it to estimate template costs before runs start.
+type PricedExecutor interface {
+	StepExecutor
+	Pricing() domain.StepPricing
 }
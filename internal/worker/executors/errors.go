@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package executors
+
+import (
+	"errors"
+	"net/http"
+)
+
+// PermanentError marks an executor failure that retrying cannot fix, such as
+// invalid input or a 4xx from a tool. The worker fails the step on the first
+// such error instead of scheduling the remaining attempts.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	if e.Err == nil {
+		return "permanent executor error"
+	}
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps err as a PermanentError. It returns nil for a nil err.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err or any error it wraps is a PermanentError.
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// HTTPStatusError classifies err from a call that returned statusCode: 4xx
+// responses are permanent, except 408 and 429, which are worth retrying like
+// 5xx ones.
+func HTTPStatusError(statusCode int, err error) error {
+	if statusCode >= 400 && statusCode < 500 &&
+		statusCode != http.StatusRequestTimeout && statusCode != http.StatusTooManyRequests {
+		return Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package executors
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/adiadia/agent-runtime/internal/egress"
+)
+
+const (
+	// ExecutionKeyHeader carries the execution key on the HTTP requests an
+	// executor makes to external systems.
+	ExecutionKeyHeader = "Idempotency-Key"
+	// ExecutionKeyEnv is the environment variable sandboxed commands find
+	// the execution key in.
+	ExecutionKeyEnv = "EXECUTION_KEY"
+)
+
+// ErrNoExecutionKey is returned by ExecutionKeyTransport for requests whose
+// context carries no execution key, i.e. requests not made by an executing
+// step.
+var ErrNoExecutionKey = errors.New("request context has no execution key")
+
+type executionKeyKey struct{}
+
+type transportKey struct{}
+
+// WithExecutionKey returns a context carrying the executing step's
+// execution key (see domain.ExecutionKey).
+func WithExecutionKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, executionKeyKey{}, key)
+}
+
+// ExecutionKey returns the executing step's execution key. It is the same
+// when a step is reclaimed after its worker died mid-call and changes on
+// retry, so an executor that passes it to external systems makes its side
+// effects happen once per execution however often a reclaim repeats them.
+func ExecutionKey(ctx context.Context) (key string, ok bool) {
+	key, ok = ctx.Value(executionKeyKey{}).(string)
+	return key, ok && key != ""
+}
+
+// WithTransport returns a context carrying the transport the worker's egress
+// policy (see package egress) applies to.
+func WithTransport(ctx context.Context, rt http.RoundTripper) context.Context {
+	return context.WithValue(ctx, transportKey{}, rt)
+}
+
+// StepTransport returns the executing step's egress-controlled transport, or
+// http.DefaultTransport when there is none (e.g. in tests).
+func StepTransport(ctx context.Context) http.RoundTripper {
+	if rt, ok := ctx.Value(transportKey{}).(http.RoundTripper); ok {
+		return rt
+	}
+	return http.DefaultTransport
+}
+
+// ExecutionKeyTransport sets ExecutionKeyHeader on every request from the
+// execution key in the request's context, and refuses requests without one,
+// so an executor's HTTP client cannot call out without it. Base defaults to
+// the request context's StepTransport. Requests the egress policy blocks fail
+// permanently.
+type ExecutionKeyTransport struct {
+	Base http.RoundTripper
+}
+
+func (t *ExecutionKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, ok := ExecutionKey(req.Context())
+	if !ok {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, Permanent(ErrNoExecutionKey)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set(ExecutionKeyHeader, key)
+
+	base := t.Base
+	if base == nil {
+		base = StepTransport(req.Context())
+	}
+	resp, err := base.RoundTrip(req)
+	if errors.Is(err, egress.ErrBlocked) {
+		return nil, Permanent(err)
+	}
+	return resp, err
+}
+
+// NewHTTPClient returns a client for executors calling external systems: its
+// requests carry the execution key of the step whose context they are made
+// with (see http.NewRequestWithContext) and, unless base sets a transport,
+// follow the worker's egress policy.
+func NewHTTPClient(base *http.Client) *http.Client {
+	client := &http.Client{}
+	if base != nil {
+		*client = *base
+	}
+	client.Transport = &ExecutionKeyTransport{Base: client.Transport}
+	return client
+}
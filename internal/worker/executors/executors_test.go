@@ -5,8 +5,19 @@ package executors
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/egress"
 	"github.com/google/uuid"
 )
 
@@ -18,16 +29,176 @@ func TestLLMExecutorExecute(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if cost <= 0 {
-		t.Fatalf("expected llm execution to return positive cost, got %f", cost)
+	if cost.CostUSD <= 0 {
+		t.Fatalf("expected llm execution to return positive cost, got %f", cost.CostUSD)
+	}
+	if cost.Provider != llmProvider || cost.Model != llmModel ||
+		cost.PromptTokens != llmPromptTokens || cost.CompletionTokens != llmCompletionTokens ||
+		cost.UnitPriceUSD != llmModelPricePerToken {
+		t.Fatalf("expected itemized llm cost detail, got %+v", cost)
 	}
 
-	var payload map[string]string
+	var payload struct {
+		Type  string `json:"type"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
 	if err := json.Unmarshal(out, &payload); err != nil {
 		t.Fatalf("expected valid json output, got %v", err)
 	}
-	if payload["type"] != "llm" {
-		t.Fatalf("expected type=llm got %s", payload["type"])
+	if payload.Type != "llm" {
+		t.Fatalf("expected type=llm got %s", payload.Type)
+	}
+	if payload.Usage.PromptTokens != llmPromptTokens || payload.Usage.CompletionTokens != llmCompletionTokens {
+		t.Fatalf("expected token usage in output, got %+v", payload.Usage)
+	}
+}
+
+type recordingProgress struct {
+	mu       sync.Mutex
+	chunks   []string
+	percents []float64
+	messages []string
+}
+
+func (p *recordingProgress) EmitProgress(percent float64, message string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.percents = append(p.percents, percent)
+	p.messages = append(p.messages, message)
+}
+
+func (p *recordingProgress) Stream(chunk string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.chunks = append(p.chunks, chunk)
+}
+
+func TestLLMExecutorStreamsReply(t *testing.T) {
+	t.Parallel()
+
+	progress := &recordingProgress{}
+	if _, _, err := (&LLMExecutor{}).Execute(WithProgress(context.Background(), progress), uuid.New()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(progress.chunks) < 2 || strings.Join(progress.chunks, "") != llmReply {
+		t.Fatalf("expected the reply streamed in chunks, got %q", progress.chunks)
+	}
+}
+
+func TestToolExecutorReportsProgress(t *testing.T) {
+	t.Parallel()
+
+	progress := &recordingProgress{}
+	if _, _, err := (&ToolExecutor{}).Execute(WithEventEmitter(context.Background(), progress), uuid.New()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []float64{0, 25, 50, 75, 100}
+	if !slices.Equal(progress.percents, want) || progress.messages[0] != "calling "+toolName {
+		t.Fatalf("expected percents %v, got %v %q", want, progress.percents, progress.messages)
+	}
+}
+
+func TestExecutionKeyTransport(t *testing.T) {
+	t.Parallel()
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(ExecutionKeyHeader)
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(srv.Client())
+	req, _ := http.NewRequestWithContext(WithExecutionKey(context.Background(), "step:1"), http.MethodPost, srv.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if got != "step:1" {
+		t.Fatalf("expected the execution key header, got %q", got)
+	}
+
+	req, _ = http.NewRequestWithContext(context.Background(), http.MethodPost, srv.URL, nil)
+	if _, err := client.Do(req); !errors.Is(err, ErrNoExecutionKey) || !IsPermanent(err) {
+		t.Fatalf("expected a permanent ErrNoExecutionKey outside a step, got %v", err)
+	}
+}
+
+func TestExecutionKeyTransportFollowsStepEgress(t *testing.T) {
+	t.Parallel()
+
+	policy := egress.Policy{Deny: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}}
+	ctx := WithTransport(WithExecutionKey(context.Background(), "step:1"), policy.Transport())
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://192.0.2.10/", nil)
+	_, err := NewHTTPClient(nil).Do(req)
+	if !errors.Is(err, egress.ErrBlocked) || !IsPermanent(err) {
+		t.Fatalf("expected a permanent egress error, got %v", err)
+	}
+}
+
+func TestHTTPToolExecutor(t *testing.T) {
+	t.Parallel()
+
+	var got struct {
+		method, contentType, auth, body, key string
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got.method, got.contentType, got.auth, got.body = r.Method, r.Header.Get("Content-Type"), r.Header.Get("Authorization"), string(body)
+		got.key = r.Header.Get(ExecutionKeyHeader)
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/busy":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			fmt.Fprint(w, `{"ok":true}`)
+		}
+	}))
+	defer srv.Close()
+
+	hosts, _ := egress.ParseHostRules([]string{"127.0.0.1"})
+	ctx := WithTransport(WithExecutionKey(context.Background(), "step:1"), egress.Policy{Hosts: hosts}.Transport())
+	run := func(ctx context.Context, req domain.HTTPRequest) (json.RawMessage, error) {
+		out, cost, err := (&HTTPToolExecutor{}).Execute(WithHTTPRequest(ctx, req), uuid.New())
+		if cost.Tool != "http" {
+			t.Fatalf("expected the http tool cost, got %+v", cost)
+		}
+		return out, err
+	}
+
+	out, err := run(WithStepInput(ctx, json.RawMessage(`{"q":1}`)), domain.HTTPRequest{
+		Method:  http.MethodPost,
+		URL:     srv.URL + "/search",
+		Headers: map[string]string{"Authorization": "Bearer t"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(out) != `{"body":{"ok":true},"status":200}` {
+		t.Fatalf("unexpected output %s", out)
+	}
+	if got.method != http.MethodPost || got.body != `{"q":1}` || got.contentType != "application/json" || got.auth != "Bearer t" || got.key != "step:1" {
+		t.Fatalf("unexpected request %+v", got)
+	}
+
+	if _, err := run(ctx, domain.HTTPRequest{Method: http.MethodGet, URL: srv.URL + "/missing"}); !IsPermanent(err) {
+		t.Fatalf("expected a 404 to fail permanently, got %v", err)
+	}
+	if _, err := run(ctx, domain.HTTPRequest{Method: http.MethodGet, URL: srv.URL + "/busy"}); err == nil || IsPermanent(err) {
+		t.Fatalf("expected a 503 to be retryable, got %v", err)
+	}
+	_, err = run(ctx, domain.HTTPRequest{Method: http.MethodGet, URL: strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)})
+	if err != nil {
+		t.Fatalf("expected localhost to resolve to an allowed address, got %v", err)
+	}
+	_, err = run(ctx, domain.HTTPRequest{Method: http.MethodGet, URL: "http://192.0.2.10/"})
+	if !errors.Is(err, egress.ErrBlocked) || !IsPermanent(err) {
+		t.Fatalf("expected a permanent egress error for an unlisted host, got %v", err)
 	}
 }
 
@@ -39,8 +210,11 @@ func TestToolExecutorExecute(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if cost < 0 {
-		t.Fatalf("expected non-negative tool cost, got %f", cost)
+	if cost.CostUSD < 0 {
+		t.Fatalf("expected non-negative tool cost, got %f", cost.CostUSD)
+	}
+	if cost.Tool != toolName {
+		t.Fatalf("expected tool %q in cost detail, got %+v", toolName, cost)
 	}
 
 	var payload map[string]string
@@ -51,3 +225,124 @@ func TestToolExecutorExecute(t *testing.T) {
 		t.Fatalf("expected type=tool got %s", payload["type"])
 	}
 }
+
+func TestToolExecutorEchoesMapItem(t *testing.T) {
+	t.Parallel()
+
+	exec := &ToolExecutor{}
+	ctx := WithMapItem(context.Background(), json.RawMessage(`{"id":7}`))
+	out, _, err := exec.Execute(ctx, uuid.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var payload struct {
+		Type string         `json:"type"`
+		Item map[string]int `json:"item"`
+	}
+	if err := json.Unmarshal(out, &payload); err != nil {
+		t.Fatalf("expected valid json output, got %v", err)
+	}
+	if payload.Type != "tool" || payload.Item["id"] != 7 {
+		t.Fatalf("expected tool output echoing the item, got %s", out)
+	}
+}
+
+func TestExecutorsEchoStepInput(t *testing.T) {
+	t.Parallel()
+
+	ctx := WithStepInput(context.Background(), json.RawMessage(`{"prompt":"again"}`))
+	for _, exec := range []interface {
+		Execute(context.Context, uuid.UUID) (json.RawMessage, domain.CostDetail, error)
+	}{&LLMExecutor{}, &ToolExecutor{}} {
+		out, _, err := exec.Execute(ctx, uuid.New())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var payload struct {
+			Input map[string]string `json:"input"`
+		}
+		if err := json.Unmarshal(out, &payload); err != nil {
+			t.Fatalf("expected valid json output, got %v", err)
+		}
+		if payload.Input["prompt"] != "again" {
+			t.Fatalf("expected output echoing the step input, got %s", out)
+		}
+	}
+}
+
+func TestMockExecutorIsDeterministic(t *testing.T) {
+	t.Parallel()
+
+	outcomes := func() []bool {
+		exec := &MockExecutor{Step: "LLM", Dice: NewMockDice(42, 0.5)}
+		got := make([]bool, 0, 20)
+		for range 20 {
+			_, cost, err := exec.Execute(context.Background(), uuid.New())
+			if err != nil && !errors.Is(err, ErrMockFailure) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cost.CostUSD != 0 {
+				t.Fatalf("expected mock cost 0, got %f", cost.CostUSD)
+			}
+			got = append(got, err != nil)
+		}
+		return got
+	}
+
+	first, second := outcomes(), outcomes()
+	failures := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected the same outcomes for the same seed, differ at call %d", i)
+		}
+		if first[i] {
+			failures++
+		}
+	}
+	if failures == 0 || failures == len(first) {
+		t.Fatalf("expected a mix of failures at rate 0.5, got %d/%d", failures, len(first))
+	}
+
+	never := &MockExecutor{Step: "TOOL", Dice: NewMockDice(42, 0)}
+	out, _, err := never.Execute(context.Background(), uuid.New())
+	if err != nil {
+		t.Fatalf("expected no failures at rate 0, got %v", err)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(out, &payload); err != nil || payload["type"] != "mock" {
+		t.Fatalf("expected mock output, got %s (err=%v)", out, err)
+	}
+}
+
+func TestPermanentError(t *testing.T) {
+	t.Parallel()
+
+	cause := errors.New("invalid input")
+	err := fmt.Errorf("tool call: %w", Permanent(cause))
+	if !IsPermanent(err) {
+		t.Fatal("expected wrapped permanent error to be detected")
+	}
+	if !errors.Is(err, cause) {
+		t.Fatal("expected permanent error to unwrap to its cause")
+	}
+	if IsPermanent(cause) || Permanent(nil) != nil {
+		t.Fatal("expected plain errors and nil not to be permanent")
+	}
+
+	for _, tt := range []struct {
+		status    int
+		permanent bool
+	}{
+		{status: 400, permanent: true},
+		{status: 404, permanent: true},
+		{status: 408, permanent: false},
+		{status: 429, permanent: false},
+		{status: 503, permanent: false},
+	} {
+		if got := IsPermanent(HTTPStatusError(tt.status, cause)); got != tt.permanent {
+			t.Fatalf("status %d: expected permanent=%v got %v", tt.status, tt.permanent, got)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package executors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+)
+
+// httpToolName is the tool name HTTP steps are priced and costed under.
+const httpToolName = "http"
+
+type httpRequestKey struct{}
+
+// WithHTTPRequest returns a context carrying the request an HTTP TOOL step
+// sends.
+func WithHTTPRequest(ctx context.Context, req domain.HTTPRequest) context.Context {
+	return context.WithValue(ctx, httpRequestKey{}, req)
+}
+
+// HTTPRequest returns the request passed to an HTTP TOOL step; ok is false
+// for other steps.
+func HTTPRequest(ctx context.Context) (req domain.HTTPRequest, ok bool) {
+	req, ok = ctx.Value(httpRequestKey{}).(domain.HTTPRequest)
+	return req, ok
+}
+
+// HTTPToolExecutor sends a TOOL step's HTTP request through the context's
+// StepTransport, which the worker limits to the tenant's egress allow-list,
+// and returns the response status and body. The body is the step's input
+// override, or a MAP child's item. Blocked destinations and 4xx responses
+// other than 408 and 429 fail permanently; response bodies are cut to
+// MaxResponseBytes.
+type HTTPToolExecutor struct {
+	// MaxResponseBytes bounds the response body kept; 0 means 64 KiB.
+	MaxResponseBytes int64
+}
+
+func (e *HTTPToolExecutor) Execute(
+	ctx context.Context,
+	runID uuid.UUID,
+) (json.RawMessage, domain.CostDetail, error) {
+
+	spec, ok := HTTPRequest(ctx)
+	if !ok {
+		return nil, domain.CostDetail{}, Permanent(errors.New("step has no http request"))
+	}
+	cost := domain.CostDetail{Tool: httpToolName}
+
+	var body io.Reader
+	payload, hasInput := StepInput(ctx)
+	if !hasInput {
+		payload, hasInput = MapItem(ctx)
+	}
+	if hasInput && spec.Method != http.MethodGet && spec.Method != http.MethodHead {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, spec.Method, spec.URL, body)
+	if err != nil {
+		return nil, cost, Permanent(fmt.Errorf("build http request: %w", err))
+	}
+	for name, value := range spec.Headers {
+		req.Header.Set(name, value)
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	ReportProgress(ctx, 0, "calling %s %s", spec.Method, req.URL.Host)
+	resp, err := NewHTTPClient(nil).Do(req)
+	if err != nil {
+		return nil, cost, err
+	}
+	defer resp.Body.Close()
+
+	limit := e.MaxResponseBytes
+	if limit <= 0 {
+		limit = 64 << 10
+	}
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	if err != nil {
+		return nil, cost, fmt.Errorf("read http response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, cost, HTTPStatusError(resp.StatusCode, fmt.Errorf("%s %s: %s", spec.Method, req.URL.Redacted(), resp.Status))
+	}
+
+	// JSON bodies are kept as JSON, anything else as a string.
+	result := map[string]any{"status": resp.StatusCode}
+	if json.Valid(respBody) {
+		result["body"] = json.RawMessage(respBody)
+	} else {
+		result["body"] = string(respBody)
+	}
+	out, err := json.Marshal(result)
+	if err != nil {
+		return nil, cost, err
+	}
+	return out, cost, nil
+}
+
+// Pricing declares HTTP calls free: the runtime does not bill for them.
+func (e *HTTPToolExecutor) Pricing() domain.StepPricing {
+	return domain.StepPricing{Tool: httpToolName, Unit: domain.PricingUnitCall, MinUnits: 1, MaxUnits: 1}
+}
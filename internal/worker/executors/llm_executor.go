@@ -5,38 +5,84 @@ package executors
 import (
 	"context"
 	"encoding/json"
+	"strings"
 	"time"
 
+	"github.com/adiadia/agent-runtime/internal/domain"
 	"github.com/google/uuid"
 )
 
 type LLMExecutor struct{}
 
 const (
+	llmProvider           = "local"
+	llmModel              = "local-echo"
 	llmModelPricePerToken = 0.000002
 	llmPromptTokens       = 180
 	llmCompletionTokens   = 72
+	llmLatency            = 2 * time.Second
+	llmReply              = "hello from llm step"
 )
 
 func (e *LLMExecutor) Execute(
 	ctx context.Context,
 	runID uuid.UUID,
-) (json.RawMessage, float64, error) {
+) (json.RawMessage, domain.CostDetail, error) {
 
-	timer := time.NewTimer(2 * time.Second)
-	defer timer.Stop()
+	// The reply is streamed a word at a time, as a provider streams tokens,
+	// so the step reports progress while it generates.
+	words := strings.SplitAfter(llmReply, " ")
+	ticker := time.NewTicker(llmLatency / time.Duration(len(words)))
+	defer ticker.Stop()
 
-	select {
-	case <-ctx.Done():
-		return nil, 0, ctx.Err()
-	case <-timer.C:
+	progress := StepProgress(ctx)
+	for _, word := range words {
+		select {
+		case <-ctx.Done():
+			return nil, domain.CostDetail{}, ctx.Err()
+		case <-ticker.C:
+		}
+		progress.Stream(word)
 	}
 
-	totalTokens := llmPromptTokens + llmCompletionTokens
-	costUSD := float64(totalTokens) * llmModelPricePerToken
+	cost := domain.CostDetail{
+		Provider:         llmProvider,
+		Model:            llmModel,
+		PromptTokens:     llmPromptTokens,
+		CompletionTokens: llmCompletionTokens,
+		UnitPriceUSD:     llmModelPricePerToken,
+		CostUSD:          float64(llmPromptTokens+llmCompletionTokens) * llmModelPricePerToken,
+	}
+
+	// usage is read back into the run's RUN_SUMMARY event.
+	payload := map[string]any{
+		"type": "llm",
+		"text": llmReply,
+		"usage": map[string]int{
+			"prompt_tokens":     llmPromptTokens,
+			"completion_tokens": llmCompletionTokens,
+		},
+	}
+	// A replayed step echoes the input it was given.
+	if input, ok := StepInput(ctx); ok {
+		payload["input"] = input
+	}
+	out, err := json.Marshal(payload)
+	if err != nil {
+		return nil, domain.CostDetail{}, err
+	}
+
+	return out, cost, nil
+}
 
-	return json.RawMessage(`{
-		"type":"llm",
-		"text":"hello from llm step"
-	}`), costUSD, nil
+// Pricing declares the fixed token usage of a call.
+func (e *LLMExecutor) Pricing() domain.StepPricing {
+	return domain.StepPricing{
+		Provider:     llmProvider,
+		Model:        llmModel,
+		Unit:         domain.PricingUnitToken,
+		UnitPriceUSD: llmModelPricePerToken,
+		MinUnits:     llmPromptTokens + llmCompletionTokens,
+		MaxUnits:     llmPromptTokens + llmCompletionTokens,
+	}
 }
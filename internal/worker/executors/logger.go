@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package executors
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+)
+
+// Logger appends lines to the log of the step being executed. Lines are
+// stored apart from run events and can be read, or tailed, at
+// GET /runs/{id}/steps/{step_id}/logs while the step runs. Log does not
+// block on the database and is safe for concurrent use.
+type Logger interface {
+	Log(level domain.StepLogLevel, line string)
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context carrying the executing step's Logger.
+func WithLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// StepLogger returns the executing step's Logger, or one that discards lines
+// when there is none (e.g. in tests).
+func StepLogger(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return l
+	}
+	return discardLogger{}
+}
+
+// Logf formats a line and appends it to the executing step's log.
+func Logf(ctx context.Context, level domain.StepLogLevel, format string, args ...any) {
+	StepLogger(ctx).Log(level, fmt.Sprintf(format, args...))
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Log(domain.StepLogLevel, string) {}
+
+// lineWriter is an io.Writer that logs every complete line written to it at
+// level; Flush logs a trailing partial line.
+type lineWriter struct {
+	logger Logger
+	level  domain.StepLogLevel
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.logger.Log(w.level, string(bytes.TrimSuffix(w.buf[:i], []byte("\r"))))
+		w.buf = w.buf[i+1:]
+	}
+	// Do not let a single endless line grow without bound.
+	if len(w.buf) >= domain.MaxStepLogLineBytes {
+		w.Flush()
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.logger.Log(w.level, string(w.buf))
+		w.buf = w.buf[:0]
+	}
+}
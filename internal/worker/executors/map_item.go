@@ -0,0 +1,23 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package executors
+
+import (
+	"context"
+	"encoding/json"
+)
+
+type mapItemKey struct{}
+
+// WithMapItem returns a context carrying the array item a MAP step's child
+// runs on.
+func WithMapItem(ctx context.Context, item json.RawMessage) context.Context {
+	return context.WithValue(ctx, mapItemKey{}, item)
+}
+
+// MapItem returns the item passed to a MAP step's child; ok is false for
+// steps that are not MAP children.
+func MapItem(ctx context.Context) (item json.RawMessage, ok bool) {
+	item, ok = ctx.Value(mapItemKey{}).(json.RawMessage)
+	return item, ok
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package executors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+)
+
+const mockProvider = "mock"
+
+// ErrMockFailure is returned by MockExecutor for calls picked to fail.
+var ErrMockFailure = errors.New("mock provider failure")
+
+// MockDice decides which mock calls fail. It draws from a generator seeded
+// with a fixed seed, so replaying the same workload fails the same calls.
+// It is safe for concurrent use.
+type MockDice struct {
+	mu          sync.Mutex
+	rng         *rand.Rand
+	failureRate float64
+}
+
+func NewMockDice(seed int64, failureRate float64) *MockDice {
+	return &MockDice{
+		rng:         rand.New(rand.NewSource(seed)),
+		failureRate: failureRate,
+	}
+}
+
+// Fail reports whether the next call should fail.
+func (d *MockDice) Fail() bool {
+	if d.failureRate <= 0 {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rng.Float64() < d.failureRate
+}
+
+// MockExecutor stands in for an executor that would call an external
+// provider. It waits Latency and then succeeds with a fixed output at no
+// cost, or fails when Dice says so.
+type MockExecutor struct {
+	Step    string
+	Latency time.Duration
+	Dice    *MockDice
+}
+
+func (e *MockExecutor) Execute(
+	ctx context.Context,
+	runID uuid.UUID,
+) (json.RawMessage, domain.CostDetail, error) {
+
+	if e.Latency > 0 {
+		timer := time.NewTimer(e.Latency)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return nil, domain.CostDetail{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if e.Dice != nil && e.Dice.Fail() {
+		return nil, domain.CostDetail{}, ErrMockFailure
+	}
+
+	out, err := json.Marshal(map[string]any{
+		"type":   "mock",
+		"step":   e.Step,
+		"run_id": runID,
+		"text":   "mock " + e.Step + " ok",
+		"usage": map[string]int{
+			"prompt_tokens":     0,
+			"completion_tokens": 0,
+		},
+	})
+	if err != nil {
+		return nil, domain.CostDetail{}, err
+	}
+
+	return out, e.costDetail(), nil
+}
+
+// costDetail reports a zero-cost call to the "mock" provider, as a model for
+// LLM steps and as a tool otherwise.
+func (e *MockExecutor) costDetail() domain.CostDetail {
+	if e.Step == string(domain.StepLLM) {
+		return domain.CostDetail{Provider: mockProvider, Model: mockProvider}
+	}
+	return domain.CostDetail{Provider: mockProvider, Tool: mockProvider}
+}
+
+// Pricing declares mock calls free, like their cost details.
+func (e *MockExecutor) Pricing() domain.StepPricing {
+	d := e.costDetail()
+	unit := domain.PricingUnitCall
+	if d.Model != "" {
+		unit = domain.PricingUnitToken
+	}
+	return domain.StepPricing{Provider: d.Provider, Model: d.Model, Tool: d.Tool, Unit: unit, MinUnits: 1, MaxUnits: 1}
+}
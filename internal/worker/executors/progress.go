@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package executors
+
+import (
+	"context"
+	"fmt"
+)
+
+// Progress receives the output of an executor that streams it, such as an
+// LLM provider returning tokens as they are generated. The worker turns it
+// into throttled STEP_PROGRESS events so SSE consumers can follow the
+// generation before the step finishes. Stream does not block on the database
+// and is safe for concurrent use.
+type Progress interface {
+	// Stream appends chunk to the output generated so far.
+	Stream(chunk string)
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context carrying the executing step's Progress.
+func WithProgress(ctx context.Context, p Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+// StepProgress returns the executing step's Progress, or one that discards
+// chunks when there is none (e.g. in tests).
+func StepProgress(ctx context.Context) Progress {
+	if p, ok := ctx.Value(progressKey{}).(Progress); ok {
+		return p
+	}
+	return discardProgress{}
+}
+
+type discardProgress struct{}
+
+func (discardProgress) Stream(string) {}
+
+// EventEmitter reports how far a long-running step has come. The worker
+// persists the latest report as a STEP_PROGRESS event, throttled together
+// with streamed output, and the event is streamed over SSE like any other.
+// EmitProgress does not block on the database and is safe for concurrent use.
+type EventEmitter interface {
+	// EmitProgress records percent, from 0 to 100 or negative when unknown,
+	// and an optional message describing the current stage.
+	EmitProgress(percent float64, message string)
+}
+
+type emitterKey struct{}
+
+// WithEventEmitter returns a context carrying the executing step's
+// EventEmitter.
+func WithEventEmitter(ctx context.Context, e EventEmitter) context.Context {
+	return context.WithValue(ctx, emitterKey{}, e)
+}
+
+// StepEventEmitter returns the executing step's EventEmitter, or one that
+// discards reports when there is none (e.g. in tests).
+func StepEventEmitter(ctx context.Context) EventEmitter {
+	if e, ok := ctx.Value(emitterKey{}).(EventEmitter); ok {
+		return e
+	}
+	return discardProgress{}
+}
+
+// ReportProgress reports percent and a formatted message for the executing
+// step.
+func ReportProgress(ctx context.Context, percent float64, format string, args ...any) {
+	StepEventEmitter(ctx).EmitProgress(percent, fmt.Sprintf(format, args...))
+}
+
+func (discardProgress) EmitProgress(float64, string) {}
@@ -0,0 +1,175 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package executors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+)
+
+// sandboxPath is the only environment a sandboxed command sees besides HOME,
+// the step's secrets, and its execution key.
+const sandboxPath = "PATH=/usr/local/bin:/usr/bin:/bin"
+
+// ErrCommandNotAllowed is returned, as a PermanentError, for a command whose
+// binary is not in the sandbox allow-list.
+var ErrCommandNotAllowed = errors.New("command not allowed")
+
+type commandKey struct{}
+
+// WithCommand returns a context carrying the argv a TOOL step runs.
+func WithCommand(ctx context.Context, argv []string) context.Context {
+	return context.WithValue(ctx, commandKey{}, argv)
+}
+
+// Command returns the argv passed to a command TOOL step; ok is false for
+// steps without a command.
+func Command(ctx context.Context) (argv []string, ok bool) {
+	argv, ok = ctx.Value(commandKey{}).([]string)
+	return argv, ok && len(argv) > 0
+}
+
+// SandboxConfig limits the commands SandboxExecutor runs. AllowedBinaries
+// lists the names (or absolute paths) argv[0] may be; CPUTime and
+// MemoryBytes become the command's RLIMIT_CPU and RLIMIT_AS, and stdout and
+// stderr are each cut to MaxOutputBytes. Wall time is bounded by the step
+// timeout.
+type SandboxConfig struct {
+	AllowedBinaries []string
+	CPUTime         time.Duration
+	MemoryBytes     int64
+	MaxOutputBytes  int
+}
+
+// SandboxExecutor runs a TOOL step's command as a subprocess with resource
+// limits, an environment of only PATH, HOME, the step's secrets, and
+// EXECUTION_KEY, and a scratch working directory, and returns its exit code
+// and captured output.
+type SandboxExecutor struct {
+	cfg SandboxConfig
+}
+
+func NewSandboxExecutor(cfg SandboxConfig) *SandboxExecutor {
+	if cfg.MaxOutputBytes <= 0 {
+		cfg.MaxOutputBytes = 64 << 10
+	}
+	return &SandboxExecutor{cfg: cfg}
+}
+
+func (e *SandboxExecutor) Execute(
+	ctx context.Context,
+	runID uuid.UUID,
+) (json.RawMessage, domain.CostDetail, error) {
+
+	argv, ok := Command(ctx)
+	if !ok {
+		return nil, domain.CostDetail{}, Permanent(errors.New("step has no command"))
+	}
+	if !slices.Contains(e.cfg.AllowedBinaries, argv[0]) {
+		return nil, domain.CostDetail{}, Permanent(fmt.Errorf("%w: %s", ErrCommandNotAllowed, argv[0]))
+	}
+
+	dir, err := os.MkdirTemp("", "agent-runtime-sandbox-")
+	if err != nil {
+		return nil, domain.CostDetail{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	// The limits are applied by the shell, which then execs the command; argv
+	// is passed as positional parameters, never interpolated.
+	cmd := exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", e.script(), "sandbox"}, argv...)...)
+	cmd.Dir = dir
+	cmd.Env = append([]string{sandboxPath, "HOME=" + dir}, secretEnv(ctx)...)
+	if key, ok := ExecutionKey(ctx); ok {
+		cmd.Env = append(cmd.Env, ExecutionKeyEnv+"="+key)
+	}
+	cmd.WaitDelay = time.Second
+	// Output is captured for the step result and streamed line by line to
+	// the step log while the command runs.
+	stdout := &cappedBuffer{max: e.cfg.MaxOutputBytes}
+	stderr := &cappedBuffer{max: e.cfg.MaxOutputBytes}
+	stdoutLog := &lineWriter{logger: StepLogger(ctx), level: domain.StepLogInfo}
+	stderrLog := &lineWriter{logger: StepLogger(ctx), level: domain.StepLogWarn}
+	cmd.Stdout = io.MultiWriter(stdout, stdoutLog)
+	cmd.Stderr = io.MultiWriter(stderr, stderrLog)
+
+	runErr := cmd.Run()
+	stdoutLog.Flush()
+	stderrLog.Flush()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, domain.CostDetail{}, ctxErr
+	}
+	var exitErr *exec.ExitError
+	if runErr != nil && !errors.As(runErr, &exitErr) {
+		return nil, domain.CostDetail{}, runErr
+	}
+	exitCode := cmd.ProcessState.ExitCode()
+
+	cost := domain.CostDetail{Tool: argv[0]}
+	if exitCode != 0 {
+		return nil, cost, fmt.Errorf("command %s exited with status %d: %s", argv[0], exitCode, strings.TrimSpace(stderr.String()))
+	}
+
+	out, err := json.Marshal(map[string]any{
+		"type":             "tool",
+		"command":          argv,
+		"exit_code":        exitCode,
+		"stdout":           stdout.String(),
+		"stderr":           stderr.String(),
+		"stdout_truncated": stdout.truncated,
+		"stderr_truncated": stderr.truncated,
+	})
+	if err != nil {
+		return nil, cost, err
+	}
+	return out, cost, nil
+}
+
+// script returns the shell script that applies the limits and execs "$@".
+func (e *SandboxExecutor) script() string {
+	var b strings.Builder
+	if e.cfg.CPUTime > 0 {
+		seconds := max(int64(e.cfg.CPUTime/time.Second), 1)
+		b.WriteString("ulimit -t " + strconv.FormatInt(seconds, 10) + " || exit 126; ")
+	}
+	if e.cfg.MemoryBytes > 0 {
+		kib := max(e.cfg.MemoryBytes>>10, 1)
+		b.WriteString("ulimit -v " + strconv.FormatInt(kib, 10) + " || exit 126; ")
+	}
+	b.WriteString(`exec "$@"`)
+	return b.String()
+}
+
+// cappedBuffer keeps the first max bytes written to it and notes whether
+// more were dropped.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if room := c.max - c.buf.Len(); room < len(p) {
+		c.truncated = true
+		c.buf.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return c.buf.Write(p)
+}
+
+func (c *cappedBuffer) String() string {
+	return c.buf.String()
+}
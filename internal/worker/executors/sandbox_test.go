@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package executors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func requireShell(t *testing.T) {
+	t.Helper()
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("sandbox needs /bin/sh")
+	}
+}
+
+func TestSandboxExecutorCapturesOutput(t *testing.T) {
+	requireShell(t)
+	t.Parallel()
+
+	exec := NewSandboxExecutor(SandboxConfig{
+		AllowedBinaries: []string{"echo"},
+		CPUTime:         time.Second,
+		MemoryBytes:     256 << 20,
+		MaxOutputBytes:  5,
+	})
+	ctx := WithCommand(context.Background(), []string{"echo", "hello world"})
+	out, cost, err := exec.Execute(ctx, uuid.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cost.Tool != "echo" {
+		t.Fatalf("expected tool echo in cost detail, got %+v", cost)
+	}
+
+	var payload struct {
+		ExitCode        int    `json:"exit_code"`
+		Stdout          string `json:"stdout"`
+		StdoutTruncated bool   `json:"stdout_truncated"`
+	}
+	if err := json.Unmarshal(out, &payload); err != nil {
+		t.Fatalf("expected valid json output, got %v", err)
+	}
+	if payload.ExitCode != 0 || payload.Stdout != "hello" || !payload.StdoutTruncated {
+		t.Fatalf("expected truncated stdout, got %+v", payload)
+	}
+}
+
+func TestSandboxExecutorPassesExecutionKey(t *testing.T) {
+	requireShell(t)
+	t.Parallel()
+
+	exec := NewSandboxExecutor(SandboxConfig{AllowedBinaries: []string{"printenv"}})
+	ctx := WithExecutionKey(WithCommand(context.Background(), []string{"printenv", ExecutionKeyEnv}), "step:2")
+	out, _, err := exec.Execute(ctx, uuid.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var payload struct {
+		Stdout string `json:"stdout"`
+	}
+	if err := json.Unmarshal(out, &payload); err != nil || payload.Stdout != "step:2\n" {
+		t.Fatalf("expected the execution key in the environment, got %s (%v)", out, err)
+	}
+}
+
+func TestSandboxExecutorRejectsUnlistedBinary(t *testing.T) {
+	t.Parallel()
+
+	exec := NewSandboxExecutor(SandboxConfig{AllowedBinaries: []string{"echo"}})
+	_, _, err := exec.Execute(WithCommand(context.Background(), []string{"rm", "-rf", "/"}), uuid.New())
+	if !errors.Is(err, ErrCommandNotAllowed) || !IsPermanent(err) {
+		t.Fatalf("expected permanent ErrCommandNotAllowed, got %v", err)
+	}
+
+	if _, _, err := exec.Execute(context.Background(), uuid.New()); !IsPermanent(err) {
+		t.Fatalf("expected permanent error without a command, got %v", err)
+	}
+}
+
+func TestSandboxExecutorReportsExitStatusAndTimeout(t *testing.T) {
+	requireShell(t)
+	t.Parallel()
+
+	exec := NewSandboxExecutor(SandboxConfig{AllowedBinaries: []string{"false", "sleep"}})
+	if _, _, err := exec.Execute(WithCommand(context.Background(), []string{"false"}), uuid.New()); err == nil || IsPermanent(err) {
+		t.Fatalf("expected a retryable error for a non-zero exit, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(WithCommand(context.Background(), []string{"sleep", "10"}), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := exec.Execute(ctx, uuid.New()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the command to be killed at the deadline, took %s", elapsed)
+	}
+}
@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package executors
+
+import (
+	"context"
+	"maps"
+	"slices"
+)
+
+type secretsKey struct{}
+
+// WithSecrets returns a context carrying the secrets the step's template
+// names, by name. They are never part of the step's input, output, or
+// events; the worker masks their values in what the executor returns.
+func WithSecrets(ctx context.Context, values map[string]string) context.Context {
+	return context.WithValue(ctx, secretsKey{}, values)
+}
+
+// Secrets returns the executing step's secrets by name; ok is false for steps
+// without any.
+func Secrets(ctx context.Context) (values map[string]string, ok bool) {
+	values, ok = ctx.Value(secretsKey{}).(map[string]string)
+	return values, ok && len(values) > 0
+}
+
+// secretEnv returns the step's secrets as NAME=value environment entries,
+// sorted by name.
+func secretEnv(ctx context.Context) []string {
+	values, _ := Secrets(ctx)
+	env := make([]string, 0, len(values))
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		env = append(env, name+"="+values[name])
+	}
+	return env
+}
@@ -0,0 +1,23 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package executors
+
+import (
+	"context"
+	"encoding/json"
+)
+
+type stepInputKey struct{}
+
+// WithStepInput returns a context carrying the input override a replay set
+// for the step, a JSON object.
+func WithStepInput(ctx context.Context, input json.RawMessage) context.Context {
+	return context.WithValue(ctx, stepInputKey{}, input)
+}
+
+// StepInput returns the input override of a replayed step; ok is false for
+// steps without one.
+func StepInput(ctx context.Context) (input json.RawMessage, ok bool) {
+	input, ok = ctx.Value(stepInputKey{}).(json.RawMessage)
+	return input, ok
+}
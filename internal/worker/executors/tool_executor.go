@@ -7,27 +7,66 @@ import (
 	"encoding/json"
 	"time"
 
+	"github.com/adiadia/agent-runtime/internal/domain"
 	"github.com/google/uuid"
 )
 
 type ToolExecutor struct{}
 
+const (
+	toolName          = "echo"
+	toolLatency       = 2 * time.Second
+	toolProgressSteps = 4
+)
+
 func (e *ToolExecutor) Execute(
 	ctx context.Context,
 	runID uuid.UUID,
-) (json.RawMessage, float64, error) {
+) (json.RawMessage, domain.CostDetail, error) {
+
+	// The call reports how far it has come, as a long-running tool would.
+	ticker := time.NewTicker(toolLatency / toolProgressSteps)
+	defer ticker.Stop()
 
-	timer := time.NewTimer(2 * time.Second)
-	defer timer.Stop()
+	ReportProgress(ctx, 0, "calling %s", toolName)
+	for i := 1; i <= toolProgressSteps; i++ {
+		select {
+		case <-ctx.Done():
+			return nil, domain.CostDetail{}, ctx.Err()
+		case <-ticker.C:
+		}
+		ReportProgress(ctx, float64(100*i/toolProgressSteps), "calling %s", toolName)
+	}
 
-	select {
-	case <-ctx.Done():
-		return nil, 0, ctx.Err()
-	case <-timer.C:
+	// A MAP child echoes the item it ran on, and a replayed step the input
+	// it was given.
+	item, isChild := MapItem(ctx)
+	input, replayed := StepInput(ctx)
+	if isChild || replayed {
+		payload := map[string]any{
+			"type": "tool",
+			"text": "mock tool ok",
+		}
+		if isChild {
+			payload["item"] = item
+		}
+		if replayed {
+			payload["input"] = input
+		}
+		out, err := json.Marshal(payload)
+		if err != nil {
+			return nil, domain.CostDetail{}, err
+		}
+		return out, domain.CostDetail{Tool: toolName}, nil
 	}
 
 	return json.RawMessage(`{
 		"type":"tool",
 		"text":"mock tool ok"
-	}`), 0, nil
+	}`), domain.CostDetail{Tool: toolName}, nil
+}
+
+// Pricing declares the tool free: it runs locally.
+func (e *ToolExecutor) Pricing() domain.StepPricing {
+	return domain.StepPricing{Tool: toolName, Unit: domain.PricingUnitCall, MinUnits: 1, MaxUnits: 1}
 }
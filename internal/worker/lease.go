@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+)
+
+// leaseRenewalsPerLease is how many times a lease is renewed within its
+// length, so a single slow renewal does not let it lapse.
+const leaseRenewalsPerLease = 3
+
+// keepLease renews the lease on s every reclaimAfter/3 until the returned stop
+// func is called. Renewal ends early when the lease is lost, i.e. the step is
+// no longer RUNNING under this claim; the result is still settled as usual.
+func (w *Worker) keepLease(ctx context.Context, s domain.ClaimedStep) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(w.reclaimAfter / leaseRenewalsPerLease)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			held, err := w.renewLease(ctx, s)
+			if err != nil {
+				if ctx.Err() == nil {
+					w.logger.Warn("step lease renewal failed",
+						"run_id", s.RunID,
+						"step_id", s.StepID,
+						"worker_id", w.workerID,
+						"error", err,
+					)
+				}
+				continue
+			}
+			if !held {
+				metrics.IncStepLeaseLost()
+				w.logger.Warn("step lease lost",
+					"api_key_id", w.apiKeyID,
+					"run_id", s.RunID,
+					"step_id", s.StepID,
+					"step", s.Name,
+					"worker_id", w.workerID,
+				)
+				return
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// renewLease extends the lease on s by reclaimAfter. It reports false when
+// the step is no longer RUNNING under this claim.
+func (w *Worker) renewLease(ctx context.Context, s domain.ClaimedStep) (bool, error) {
+	return w.queue.RenewLease(ctx, s, w.reclaimAfter)
+}
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	execs "github.com/adiadia/agent-runtime/internal/worker/executors"
+)
+
+// MockConfig switches the worker to local mock providers: step executors and
+// webhook deliveries make no external calls, take Latency, and fail a
+// FailureRate share of calls picked deterministically from Seed.
+type MockConfig struct {
+	Latency     time.Duration
+	FailureRate float64
+	Seed        int64
+}
+
+func mockExecutors(cfg MockConfig) map[domain.StepName]StepExecutor {
+	// Executors and webhooks draw from separate sequences so changing the
+	// webhook load does not change which steps fail.
+	dice := execs.NewMockDice(cfg.Seed, cfg.FailureRate)
+	return map[domain.StepName]StepExecutor{
+		domain.StepLLM:  &execs.MockExecutor{Step: string(domain.StepLLM), Latency: cfg.Latency, Dice: dice},
+		domain.StepTool: &execs.MockExecutor{Step: string(domain.StepTool), Latency: cfg.Latency, Dice: dice},
+	}
+}
+
+// mockWebhookTransport answers webhook POSTs locally: 204 on success, 503 for
+// calls picked to fail.
+type mockWebhookTransport struct {
+	latency time.Duration
+	dice    *execs.MockDice
+}
+
+func newMockWebhookClient(cfg MockConfig) *http.Client {
+	return &http.Client{Transport: &mockWebhookTransport{
+		latency: cfg.Latency,
+		dice:    execs.NewMockDice(cfg.Seed+1, cfg.FailureRate),
+	}}
+}
+
+func (t *mockWebhookTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		_ = req.Body.Close()
+	}
+
+	if t.latency > 0 {
+		timer := time.NewTimer(t.latency)
+		defer timer.Stop()
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+
+	status := http.StatusNoContent
+	if t.dice.Fail() {
+		status = http.StatusServiceUnavailable
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// defaultMaxStepOutputBytes bounds step output when Deps does not set a limit.
+const defaultMaxStepOutputBytes = 1 << 20
+
+// boundStepOutput returns output unchanged when it fits in max bytes.
+// Otherwise it returns a stand-in object flagged "truncated" that records the
+// original size and, when it fits, a prefix of the raw output as a string, so
+// one oversized result never lands in the steps table.
+func boundStepOutput(output json.RawMessage, max int) (json.RawMessage, bool) {
+	if max <= 0 || len(output) <= max {
+		return output, false
+	}
+
+	truncated := map[string]any{
+		"truncated":      true,
+		"original_bytes": len(output),
+	}
+	// Escaping can grow the preview; half the limit leaves room for most
+	// output, and the preview is left out when it still does not fit.
+	truncated["preview"] = strings.ToValidUTF8(string(output[:max/2]), "")
+	if bounded, err := json.Marshal(truncated); err == nil && len(bounded) <= max {
+		return bounded, true
+	}
+	delete(truncated, "preview")
+	bounded, _ := json.Marshal(truncated)
+	return bounded, true
+}
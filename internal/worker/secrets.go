@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	execs "github.com/adiadia/agent-runtime/internal/worker/executors"
+)
+
+// errSecretsDisabled fails steps that reference secrets on workers started
+// without SECRETS_KEY.
+var errSecretsDisabled = errors.New("step references secrets but the worker has no SECRETS_KEY configured")
+
+// openStepSecrets reads and decrypts the named secrets. A missing or
+// undecryptable secret fails the step permanently: retrying cannot fix it.
+func (w *Worker) openStepSecrets(ctx context.Context, names []string) (map[string]string, error) {
+	if w.secrets == nil {
+		return nil, execs.Permanent(errSecretsDisabled)
+	}
+
+	rows, err := w.pool.Query(ctx, `SELECT name, ciphertext FROM secrets WHERE name = ANY($1::text[])`, names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string]string, len(names))
+	for rows.Next() {
+		var (
+			name   string
+			sealed []byte
+		)
+		if err := rows.Scan(&name, &sealed); err != nil {
+			return nil, err
+		}
+		value, err := w.secrets.Open(name, sealed)
+		if err != nil {
+			return nil, execs.Permanent(fmt.Errorf("secret %s: %w", name, err))
+		}
+		values[name] = string(value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if _, ok := values[name]; !ok {
+			return nil, execs.Permanent(fmt.Errorf("secret %s does not exist", name))
+		}
+	}
+	return values, nil
+}
+
+// secretValues returns the values to mask, longest first so a value that
+// contains another is masked whole.
+func secretValues(values map[string]string) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		out = append(out, v)
+	}
+	slices.SortFunc(out, func(a, b string) int { return cmp.Compare(len(b), len(a)) })
+	return out
+}
+
+// maskingLogger masks secret values in step log lines.
+type maskingLogger struct {
+	next   execs.Logger
+	values []string
+}
+
+func (l maskingLogger) Log(level domain.StepLogLevel, line string) {
+	l.next.Log(level, secrets.Mask(line, l.values))
+}
+
+// maskedError keeps an executor error's identity, e.g. whether it is
+// permanent, while its message has secret values masked or personal data
+// redacted.
+type maskedError struct {
+	err error
+	msg string
+}
+
+func (e *maskedError) Error() string { return e.msg }
+
+func (e *maskedError) Unwrap() error { return e.err }
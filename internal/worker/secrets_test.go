@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	execs "github.com/adiadia/agent-runtime/internal/worker/executors"
+	"github.com/google/uuid"
+)
+
+// secretEchoExecutor leaks the API_TOKEN secret everywhere it can.
+type secretEchoExecutor struct {
+	fail bool
+}
+
+func (e secretEchoExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, domain.CostDetail, error) {
+	values, _ := execs.Secrets(ctx)
+	token := values["API_TOKEN"]
+	execs.Logf(ctx, domain.StepLogInfo, "calling with %s", token)
+	if e.fail {
+		return nil, domain.CostDetail{}, execs.Permanent(errors.New("401 for token " + token))
+	}
+	out, _ := json.Marshal(map[string]any{"echo": "Bearer " + token, "count": 1})
+	return out, domain.CostDetail{}, nil
+}
+
+type recordingLogger struct{ lines []string }
+
+func (l *recordingLogger) Log(_ domain.StepLogLevel, line string) { l.lines = append(l.lines, line) }
+
+func TestExecuteStepSecrets(t *testing.T) {
+	cipher, err := secrets.NewCipher(make([]byte, secrets.KeySize))
+	if err != nil {
+		t.Fatalf("new cipher: %v", err)
+	}
+	sealed, _ := cipher.Seal("API_TOKEN", []byte("s3cr3t-value"))
+	db := &fakeDB{rows: []fakeRow{{"API_TOKEN", sealed}}}
+
+	w := New(Deps{Queue: &fakeQueue{}, Pool: db, Secrets: cipher})
+	w.executors = map[domain.StepName]StepExecutor{domain.StepLLM: secretEchoExecutor{}, domain.StepTool: secretEchoExecutor{fail: true}}
+	log := &recordingLogger{}
+	ctx := execs.WithLogger(context.Background(), log)
+
+	out, _, err := w.executeStep(ctx, domain.ClaimedStep{RunID: uuid.New(), Name: domain.StepLLM, Secrets: []string{"API_TOKEN"}})
+	if err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if string(out) != `{"count":1,"echo":"Bearer ***"}` {
+		t.Fatalf("expected masked output, got %s", out)
+	}
+	if len(log.lines) != 1 || log.lines[0] != "calling with ***" {
+		t.Fatalf("expected masked log line, got %q", log.lines)
+	}
+
+	_, _, err = w.executeStep(ctx, domain.ClaimedStep{RunID: uuid.New(), Name: domain.StepTool, Secrets: []string{"API_TOKEN"}})
+	if err == nil || strings.Contains(err.Error(), "s3cr3t") || !execs.IsPermanent(err) {
+		t.Fatalf("expected masked permanent error, got %v", err)
+	}
+
+	_, _, err = w.executeStep(ctx, domain.ClaimedStep{RunID: uuid.New(), Name: domain.StepLLM, Secrets: []string{"API_TOKEN", "MISSING"}})
+	if err == nil || !execs.IsPermanent(err) || !strings.Contains(err.Error(), "MISSING") {
+		t.Fatalf("expected permanent error for a missing secret, got %v", err)
+	}
+
+	w.secrets = nil
+	if _, _, err := w.executeStep(ctx, domain.ClaimedStep{RunID: uuid.New(), Name: domain.StepLLM, Secrets: []string{"API_TOKEN"}}); !errors.Is(err, errSecretsDisabled) {
+		t.Fatalf("expected errSecretsDisabled, got %v", err)
+	}
+}
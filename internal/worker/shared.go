@@ -0,0 +1,264 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/google/uuid"
+)
+
+// SharedDeps configures a Shared scheduler.
+type SharedDeps struct {
+	Pool   DB
+	Logger *slog.Logger
+	Clock  clock.Clock
+	// NewWorker builds the worker for a tenant the first time the scheduler
+	// sees it; a tenant whose worker fails to build is retried next tick.
+	NewWorker func(ctx context.Context, apiKeyID uuid.UUID) (*Worker, error)
+}
+
+// tenantWork is one active api key as the scheduler sees it on a tick.
+type tenantWork struct {
+	APIKeyID uuid.UUID
+	Weight   int
+	// Runnable reports a pending or reclaimable step in a live run. It is a
+	// cheap prefilter; the claim itself applies the full claim condition.
+	Runnable bool
+}
+
+// processor claims and runs steps, reporting how many it claimed.
+type processor interface {
+	processOnce(ctx context.Context) (int, error)
+}
+
+type sharedTenant struct {
+	proc   processor
+	weight int
+	// pass is the tenant's virtual time: each claimed step advances it by
+	// 1/weight, and the tenant with the lowest pass claims next.
+	pass float64
+}
+
+// Shared serves every active tenant from one process. Each tenant gets its own
+// Worker, so claims stay tenant-scoped and keep their concurrency and budget
+// guards; Shared decides whose turn it is. Claim slots are handed out by
+// stride scheduling on api_keys.scheduling_weight, so over a busy period a
+// tenant with weight 3 gets three claims for every one of a tenant with
+// weight 1. A tenant that was idle rejoins at the current virtual time, so it
+// gets no burst of catch-up claims.
+type Shared struct {
+	logger      *slog.Logger
+	listTenants func(ctx context.Context) ([]tenantWork, error)
+	newProc     func(ctx context.Context, apiKeyID uuid.UUID) (processor, error)
+
+	mu      sync.Mutex
+	tenants map[uuid.UUID]*sharedTenant
+	// virtual is the pass of the last claim.
+	virtual float64
+}
+
+func NewShared(deps SharedDeps) *Shared {
+	l := deps.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+
+	s := &Shared{
+		logger:  l,
+		tenants: make(map[uuid.UUID]*sharedTenant),
+	}
+	s.listTenants = func(ctx context.Context) ([]tenantWork, error) {
+		return listTenantWork(ctx, deps.Pool, clock.OrReal(deps.Clock).Now().UTC())
+	}
+	s.newProc = func(ctx context.Context, apiKeyID uuid.UUID) (processor, error) {
+		w, err := deps.NewWorker(ctx, apiKeyID)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+	return s
+}
+
+// ProcessOnce refreshes the active tenants and runs the next claim, one step or
+// a batch, for the runnable tenant with the lowest pass, trying the next one
+// when a tenant turns out to have nothing it may claim.
+func (s *Shared) ProcessOnce(ctx context.Context) error {
+	work, err := s.listTenants(ctx)
+	if err != nil {
+		s.logger.Error("list tenants failed", "error", err)
+		return err
+	}
+
+	candidates := s.sync(ctx, work)
+	for _, c := range candidates {
+		claimed, err := c.t.proc.processOnce(ctx)
+		s.mu.Lock()
+		if claimed > 0 {
+			s.virtual = c.t.pass
+			c.t.pass += float64(claimed) / float64(c.t.weight)
+		} else {
+			c.t.pass = max(c.t.pass, s.virtual)
+		}
+		s.mu.Unlock()
+		if claimed > 0 || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sharedCandidate struct {
+	id uuid.UUID
+	t  *sharedTenant
+}
+
+// sync adds new tenants, drops revoked and expired ones, and returns the
+// runnable tenants in the order they should try to claim.
+func (s *Shared) sync(ctx context.Context, work []tenantWork) []sharedCandidate {
+	s.mu.Lock()
+	known := make(map[uuid.UUID]*sharedTenant, len(s.tenants))
+	for id, t := range s.tenants {
+		known[id] = t
+	}
+	virtual := s.virtual
+	s.mu.Unlock()
+
+	// Workers are built outside the lock: building one registers it.
+	active := make(map[uuid.UUID]*sharedTenant, len(work))
+	candidates := make([]sharedCandidate, 0, len(work))
+	for _, tw := range work {
+		t, ok := known[tw.APIKeyID]
+		if !ok {
+			proc, err := s.newProc(ctx, tw.APIKeyID)
+			if err != nil {
+				s.logger.Error("start tenant worker failed", "api_key_id", tw.APIKeyID, "error", err)
+				continue
+			}
+			t = &sharedTenant{proc: proc, pass: virtual}
+			s.logger.Info("tenant worker started", "api_key_id", tw.APIKeyID, "scheduling_weight", tw.Weight)
+		}
+		t.weight = max(tw.Weight, domain.DefaultSchedulingWeight)
+		active[tw.APIKeyID] = t
+		if tw.Runnable {
+			candidates = append(candidates, sharedCandidate{id: tw.APIKeyID, t: t})
+		}
+	}
+
+	s.mu.Lock()
+	for id := range s.tenants {
+		if _, ok := active[id]; !ok {
+			s.logger.Info("tenant worker stopped", "api_key_id", id)
+		}
+	}
+	s.tenants = active
+	for _, c := range candidates {
+		// A tenant that sat idle must not bank claims for later.
+		c.t.pass = max(c.t.pass, s.virtual)
+	}
+	slices.SortFunc(candidates, func(a, b sharedCandidate) int {
+		switch {
+		case a.t.pass < b.t.pass:
+			return -1
+		case a.t.pass > b.t.pass:
+			return 1
+		}
+		return bytes.Compare(a.id[:], b.id[:])
+	})
+	s.mu.Unlock()
+
+	return candidates
+}
+
+// Each calls fn with the worker of every tenant the scheduler serves.
+func (s *Shared) Each(fn func(apiKeyID uuid.UUID, w *Worker)) {
+	s.mu.Lock()
+	workers := make(map[uuid.UUID]*Worker, len(s.tenants))
+	for id, t := range s.tenants {
+		if w, ok := t.proc.(*Worker); ok {
+			workers[id] = w
+		}
+	}
+	s.mu.Unlock()
+
+	for id, w := range workers {
+		fn(id, w)
+	}
+}
+
+// RunWebhookDispatcher delivers every tenant's due outbox webhooks each
+// interval until ctx is canceled.
+func (s *Shared) RunWebhookDispatcher(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.Each(func(apiKeyID uuid.UUID, w *Worker) {
+			if _, err := w.DispatchWebhooksOnce(ctx); err != nil && ctx.Err() == nil {
+				w.logger.Error("webhook dispatch failed", "api_key_id", apiKeyID, "error", err)
+			}
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// listTenantWork reads the active api keys with their weights and whether
+// each has a step to claim.
+func listTenantWork(ctx context.Context, pool DB, now time.Time) ([]tenantWork, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT k.id, k.scheduling_weight, EXISTS (
+			SELECT 1
+			FROM steps st
+			JOIN runs r ON st.run_id = r.id
+			WHERE r.api_key_id = k.id
+			  AND r.status NOT IN ($3,$4,$5)
+			  AND (
+				st.status = $1 OR
+				(st.status = $2 AND st.lease_expires_at IS NOT NULL AND st.lease_expires_at < $6)
+			  )
+			  AND (st.next_run_at IS NULL OR st.next_run_at <= $6)
+		)
+		FROM api_keys k
+		WHERE k.revoked_at IS NULL
+		  AND (k.expires_at IS NULL OR k.expires_at > $6)
+		ORDER BY k.id
+	`,
+		domain.StepPending,
+		domain.StepRunning,
+		domain.RunCanceled,
+		domain.RunFailed,
+		domain.RunSuccess,
+		now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []tenantWork
+	for rows.Next() {
+		var tw tenantWork
+		if err := rows.Scan(&tw.APIKeyID, &tw.Weight, &tw.Runnable); err != nil {
+			return nil, err
+		}
+		out = append(out, tw)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// fakeTenant claims a step on every call while it has work.
+type fakeTenant struct {
+	hasWork bool
+	claims  int
+}
+
+func (f *fakeTenant) processOnce(context.Context) (int, error) {
+	if !f.hasWork {
+		return 0, nil
+	}
+	f.claims++
+	return 1, nil
+}
+
+type fakeTenants struct {
+	weights map[uuid.UUID]int
+	procs   map[uuid.UUID]*fakeTenant
+	order   []uuid.UUID
+}
+
+func newFakeShared(ft *fakeTenants) *Shared {
+	s := NewShared(SharedDeps{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	s.listTenants = func(context.Context) ([]tenantWork, error) {
+		out := make([]tenantWork, 0, len(ft.order))
+		for _, id := range ft.order {
+			out = append(out, tenantWork{APIKeyID: id, Weight: ft.weights[id], Runnable: ft.procs[id].hasWork})
+		}
+		return out, nil
+	}
+	s.newProc = func(_ context.Context, id uuid.UUID) (processor, error) {
+		return ft.procs[id], nil
+	}
+	return s
+}
+
+func (ft *fakeTenants) add(weight int, hasWork bool) *fakeTenant {
+	if ft.weights == nil {
+		ft.weights = map[uuid.UUID]int{}
+		ft.procs = map[uuid.UUID]*fakeTenant{}
+	}
+	id := uuid.New()
+	p := &fakeTenant{hasWork: hasWork}
+	ft.weights[id] = weight
+	ft.procs[id] = p
+	ft.order = append(ft.order, id)
+	return p
+}
+
+func TestSharedSplitsClaimsByWeight(t *testing.T) {
+	ft := &fakeTenants{}
+	heavy := ft.add(3, true)
+	light := ft.add(1, true)
+	s := newFakeShared(ft)
+
+	for range 400 {
+		if err := s.ProcessOnce(context.Background()); err != nil {
+			t.Fatalf("ProcessOnce: %v", err)
+		}
+	}
+
+	if heavy.claims != 300 || light.claims != 100 {
+		t.Fatalf("claims heavy=%d light=%d, want 300 and 100", heavy.claims, light.claims)
+	}
+}
+
+func TestSharedSkipsTenantsWithoutWork(t *testing.T) {
+	ft := &fakeTenants{}
+	idle := ft.add(10, false)
+	busy := ft.add(1, true)
+	s := newFakeShared(ft)
+
+	for range 5 {
+		if err := s.ProcessOnce(context.Background()); err != nil {
+			t.Fatalf("ProcessOnce: %v", err)
+		}
+	}
+
+	if busy.claims != 5 || idle.claims != 0 {
+		t.Fatalf("claims busy=%d idle=%d, want 5 and 0", busy.claims, idle.claims)
+	}
+}
+
+func TestSharedIdleTenantGetsNoCatchUpBurst(t *testing.T) {
+	ft := &fakeTenants{}
+	a := ft.add(1, true)
+	b := ft.add(1, false)
+	s := newFakeShared(ft)
+
+	for range 100 {
+		_ = s.ProcessOnce(context.Background())
+	}
+	b.hasWork = true
+	for range 40 {
+		_ = s.ProcessOnce(context.Background())
+	}
+
+	if got := a.claims - 100; got < 19 || got > 21 {
+		t.Fatalf("a claimed %d of 40 after b woke up, want an even split", got)
+	}
+	if b.claims < 19 || b.claims > 21 {
+		t.Fatalf("b claimed %d of 40, want an even split", b.claims)
+	}
+}
+
+func TestSharedDropsInactiveTenants(t *testing.T) {
+	ft := &fakeTenants{}
+	ft.add(1, true)
+	gone := ft.order[0]
+	s := newFakeShared(ft)
+
+	_ = s.ProcessOnce(context.Background())
+	if len(s.tenants) != 1 {
+		t.Fatalf("tenants = %d, want 1", len(s.tenants))
+	}
+
+	ft.order = nil
+	_ = s.ProcessOnce(context.Background())
+	if _, ok := s.tenants[gone]; ok {
+		t.Fatal("revoked tenant still scheduled")
+	}
+}
+
+func TestSharedRetriesTenantWhoseWorkerFailedToStart(t *testing.T) {
+	ft := &fakeTenants{}
+	p := ft.add(1, true)
+	s := newFakeShared(ft)
+	build := s.newProc
+	fail := true
+	s.newProc = func(ctx context.Context, id uuid.UUID) (processor, error) {
+		if fail {
+			return nil, errors.New("register failed")
+		}
+		return build(ctx, id)
+	}
+
+	_ = s.ProcessOnce(context.Background())
+	if p.claims != 0 || len(s.tenants) != 0 {
+		t.Fatalf("claims=%d tenants=%d, want none", p.claims, len(s.tenants))
+	}
+
+	fail = false
+	_ = s.ProcessOnce(context.Background())
+	if p.claims != 1 {
+		t.Fatalf("claims = %d, want 1", p.claims)
+	}
+}
@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+)
+
+const (
+	// stepLogFlushInterval is how often buffered log lines are written, so
+	// tailing readers see them while the step runs.
+	stepLogFlushInterval = 500 * time.Millisecond
+	// stepLogBatchSize lines trigger a write before the interval.
+	stepLogBatchSize = 100
+	// stepLogMaxPending bounds the lines buffered between writes; further
+	// lines are dropped until the buffer drains.
+	stepLogMaxPending = 10 * stepLogBatchSize
+)
+
+type stepLogLine struct {
+	level domain.StepLogLevel
+	line  string
+	at    time.Time
+}
+
+// stepLog is the executors.Logger handed to an executing step. Log only
+// buffers; a goroutine writes the lines to step_logs in batches.
+type stepLog struct {
+	w       *Worker
+	step    domain.ClaimedStep
+	mu      sync.Mutex
+	pending []stepLogLine
+	dropped int
+	full    chan struct{}
+}
+
+func (l *stepLog) Log(level domain.StepLogLevel, line string) {
+	if !level.Valid() {
+		level = domain.StepLogInfo
+	}
+	line = l.w.redactor.String(line)
+	if len(line) > domain.MaxStepLogLineBytes {
+		line = line[:domain.MaxStepLogLineBytes]
+	}
+	// Postgres TEXT rejects NUL bytes and invalid UTF-8.
+	line = strings.ToValidUTF8(strings.ReplaceAll(line, "\x00", ""), "�")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.pending) >= stepLogMaxPending {
+		l.dropped++
+		return
+	}
+	l.pending = append(l.pending, stepLogLine{level: level, line: line, at: l.w.now()})
+	if len(l.pending) == stepLogBatchSize {
+		select {
+		case l.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// keepStepLog returns the Logger for s and starts writing its lines every
+// stepLogFlushInterval. The returned stop func writes what is left.
+func (w *Worker) keepStepLog(ctx context.Context, s domain.ClaimedStep) (*stepLog, func()) {
+	l := &stepLog{w: w, step: s, full: make(chan struct{}, 1)}
+
+	flushCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(stepLogFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-flushCtx.Done():
+				return
+			case <-ticker.C:
+			case <-l.full:
+			}
+			l.flush(flushCtx)
+		}
+	}()
+
+	return l, func() {
+		cancel()
+		wg.Wait()
+		// The step context may be gone; the last lines still matter most.
+		finalCtx, cancelFinal := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancelFinal()
+		l.flush(finalCtx)
+	}
+}
+
+// flush writes the buffered lines. Lines that fail to write are dropped
+// rather than retried, so a slow database never backs up the executor.
+func (l *stepLog) flush(ctx context.Context) {
+	l.mu.Lock()
+	lines, dropped := l.pending, l.dropped
+	l.pending, l.dropped = nil, 0
+	l.mu.Unlock()
+
+	if dropped > 0 {
+		lines = append(lines, stepLogLine{
+			level: domain.StepLogWarn,
+			line:  fmt.Sprintf("step log buffer full: %d lines dropped", dropped),
+			at:    l.w.now(),
+		})
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	levels := make([]string, len(lines))
+	texts := make([]string, len(lines))
+	ats := make([]time.Time, len(lines))
+	for i, line := range lines {
+		levels[i], texts[i], ats[i] = string(line.level), line.line, line.at
+	}
+
+	if _, err := l.w.pool.Exec(ctx, `
+		INSERT INTO step_logs (run_id, step_id, attempt, level, line, created_at)
+		SELECT $1, $2, $3, l.level, l.line, l.created_at
+		FROM unnest($4::text[], $5::text[], $6::timestamp[]) WITH ORDINALITY AS l(level, line, created_at, n)
+		ORDER BY l.n
+	`,
+		l.step.RunID,
+		l.step.StepID,
+		l.step.Attempt,
+		levels,
+		texts,
+		ats,
+	); err != nil {
+		l.w.logger.Warn("write step logs failed",
+			"run_id", l.step.RunID,
+			"step_id", l.step.StepID,
+			"lines", len(lines),
+			"dropped", dropped,
+			"error", err,
+		)
+	}
+}
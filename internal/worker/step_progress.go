@@ -0,0 +1,191 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+)
+
+const (
+	// stepProgressInterval throttles STEP_PROGRESS events to one per step
+	// per interval, however fast the executor streams.
+	stepProgressInterval = time.Second
+	// stepProgressPreviewBytes bounds the tail of the streamed output a
+	// STEP_PROGRESS event carries.
+	stepProgressPreviewBytes = 256
+	// stepProgressMessageBytes bounds a reported progress message.
+	stepProgressMessageBytes = 512
+)
+
+// stepProgress is the executors.Progress and executors.EventEmitter handed
+// to an executing step. Stream only counts and keeps the output's tail and
+// EmitProgress only keeps the latest report; a goroutine writes a
+// STEP_PROGRESS event when something changed since the last one.
+type stepProgress struct {
+	w       *Worker
+	step    domain.ClaimedStep
+	mu      sync.Mutex
+	bytes   int
+	chunks  int
+	tail    string
+	percent float64
+	message string
+	// reported is set once the executor called EmitProgress.
+	reported bool
+	updates  int
+	emitted  int
+	masked   []string
+}
+
+func (p *stepProgress) Stream(chunk string) {
+	if chunk == "" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes += len(chunk)
+	p.chunks++
+	p.updates++
+	// Keep twice the preview so secrets cut by a chunk boundary are still
+	// whole when the preview is masked.
+	p.tail += chunk
+	if keep := 2 * stepProgressPreviewBytes; len(p.tail) > keep {
+		p.tail = p.tail[len(p.tail)-keep:]
+	}
+}
+
+func (p *stepProgress) EmitProgress(percent float64, message string) {
+	if percent > 100 {
+		percent = 100
+	}
+	if percent < 0 || math.IsNaN(percent) {
+		percent = -1
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.percent = percent
+	p.message = message
+	p.reported = true
+	p.updates++
+}
+
+// maskSecrets masks values in the previews of a step that was handed secrets.
+func (p *stepProgress) maskSecrets(values []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.masked = values
+}
+
+// keepStepProgress returns the Progress for s and starts writing its events
+// every stepProgressInterval. The step's own terminal event follows the
+// last of them, so the returned stop func writes nothing more.
+func (w *Worker) keepStepProgress(ctx context.Context, s domain.ClaimedStep) (*stepProgress, func()) {
+	p := &stepProgress{w: w, step: s}
+
+	emitCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(stepProgressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-emitCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			p.emit(emitCtx)
+		}
+	}()
+
+	return p, func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// emit writes a STEP_PROGRESS event if output arrived since the last one.
+// Events that fail to write are dropped; the next tick reports the newer
+// totals anyway.
+func (p *stepProgress) emit(ctx context.Context) {
+	payload, ok := p.snapshot()
+	if !ok {
+		return
+	}
+
+	if err := p.w.queue.AppendStepEvent(ctx, p.step, domain.EventStepProgress, payload); err != nil {
+		p.logFailure(ctx, err)
+	}
+}
+
+func (p *stepProgress) snapshot() (map[string]any, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.updates == p.emitted {
+		return nil, false
+	}
+	p.emitted = p.updates
+
+	payload := map[string]any{
+		"step":    p.step.Name,
+		"attempt": p.step.Attempt,
+	}
+	if p.reported {
+		if p.percent >= 0 {
+			payload["percent"] = p.percent
+		}
+		if message := p.sanitize(p.message); message != "" {
+			if len(message) > stepProgressMessageBytes {
+				message = strings.ToValidUTF8(message[:stepProgressMessageBytes], "")
+			}
+			payload["message"] = message
+		}
+	}
+	if p.chunks == 0 {
+		return payload, true
+	}
+
+	preview := secrets.Mask(p.tail, p.masked)
+	if len(preview) > stepProgressPreviewBytes {
+		start := len(preview) - stepProgressPreviewBytes
+		for start < len(preview) && !utf8.RuneStart(preview[start]) {
+			start++
+		}
+		preview = preview[start:]
+	}
+	payload["chunks"] = p.chunks
+	payload["output_bytes"] = p.bytes
+	payload["preview"] = p.sanitize(preview)
+	return payload, true
+}
+
+// sanitize masks the step's secrets in s and drops what Postgres JSONB
+// rejects: NUL characters and invalid UTF-8.
+func (p *stepProgress) sanitize(s string) string {
+	s = secrets.Mask(s, p.masked)
+	return strings.ToValidUTF8(strings.ReplaceAll(s, "\x00", ""), "�")
+}
+
+func (p *stepProgress) logFailure(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	p.w.logger.Warn("write step progress failed",
+		"run_id", p.step.RunID,
+		"step_id", p.step.StepID,
+		"error", err,
+	)
+}
@@ -5,144 +5,375 @@ package worker
 import (
 	"bytes"
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	"github.com/adiadia/agent-runtime/pkg/webhook"
 	"github.com/google/uuid"
 )
 
 const (
-	webhookRetryAttempts = 3
-	webhookRetryBase     = 300 * time.Millisecond
-	webhookHeaderSig     = "X-Signature"
+	webhookHeaderDeliveryID     = "X-Webhook-Delivery-Id"
+	webhookHeaderAttempt        = "X-Webhook-Attempt"
+	webhookHeaderFirstAttemptAt = "X-Webhook-First-Attempt-At"
+	webhookClaimLease           = time.Minute
+	webhookDispatchBatch        = 10
+	webhookMaxRetryDelay        = time.Hour
+	webhookMaxErrorLength       = 1024
 )
 
-type terminalWebhookPayload struct {
-	RunID      uuid.UUID        `json:"run_id"`
-	Status     domain.RunStatus `json:"status"`
-	FinishedAt time.Time        `json:"finished_at"`
+type webhookDelivery struct {
+	ID      uuid.UUID
+	RunID   uuid.UUID
+	URL     string
+	Payload []byte
+	Secret  string
+	// SecretErr is set when the run's secret could not be decrypted; the
+	// attempt then fails without sending an unsigned delivery.
+	SecretErr        error
+	SigningKeys      []webhook.Key
+	Attempts         int
+	MaxAttempts      int
+	FirstAttemptedAt time.Time
 }
 
-func (w *Worker) deliverTerminalWebhook(
-	ctx context.Context,
-	runID uuid.UUID,
-	status domain.RunStatus,
-	finishedAt time.Time,
-	webhookURL string,
-	webhookSecret string,
-) {
-	webhookURL = strings.TrimSpace(webhookURL)
-	if webhookURL == "" || w.httpClient == nil {
-		return
-	}
-
-	body, err := json.Marshal(terminalWebhookPayload{
-		RunID:      runID,
-		Status:     status,
-		FinishedAt: finishedAt,
-	})
-	if err != nil {
-		w.logger.Error("webhook payload marshal failed",
-			"run_id", runID,
-			"status", status,
-			"error", err,
-		)
-		return
-	}
-
-	signature := signWebhookPayload(webhookSecret, body)
-
-	var lastErr error
-	for attempt := 1; attempt <= webhookRetryAttempts; attempt++ {
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
-		if err != nil {
-			lastErr = err
-			w.logger.Error("webhook request build failed",
-				"run_id", runID,
-				"status", status,
-				"attempt", attempt,
-				"error", err,
-			)
-			break
+// RunWebhookDispatcher delivers due outbox webhooks every interval until ctx
+// is canceled.
+func (w *Worker) RunWebhookDispatcher(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.DispatchWebhooksOnce(ctx); err != nil && ctx.Err() == nil {
+			w.logger.Error("webhook dispatch failed", "error", err)
 		}
-		req.Header.Set("Content-Type", "application/json")
-		if signature != "" {
-			req.Header.Set(webhookHeaderSig, signature)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
+	}
+}
+
+// DispatchWebhooksOnce claims a batch of due deliveries for this worker's
+// tenant, attempts each once and records the outcome. It returns the number of
+// deliveries attempted.
+func (w *Worker) DispatchWebhooksOnce(ctx context.Context) (int, error) {
+	if w.httpClient == nil {
+		return 0, nil
+	}
 
-		resp, err := w.httpClient.Do(req)
-		if err != nil {
-			lastErr = err
-			w.logger.Warn("webhook failure",
-				"run_id", runID,
-				"status", status,
-				"attempt", attempt,
-				"error", err,
-			)
-		} else {
-			_, _ = io.Copy(io.Discard, resp.Body)
-			resp.Body.Close()
-
-			if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
-				w.logger.Info("webhook success",
-					"run_id", runID,
-					"status", status,
-					"attempt", attempt,
-					"response_status", resp.StatusCode,
-				)
-				return
+	deliveries, err := w.claimDueWebhooks(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var signingKeys []webhook.Key
+	for i := range deliveries {
+		if deliveries[i].Secret != "" || deliveries[i].SecretErr != nil {
+			continue
+		}
+		if signingKeys == nil {
+			if signingKeys, err = w.loadWebhookSigningKeys(ctx); err != nil {
+				return 0, err
 			}
+		}
+		deliveries[i].SigningKeys = signingKeys
+	}
 
-			lastErr = fmt.Errorf("non-2xx response: %d", resp.StatusCode)
-			w.logger.Warn("webhook failure",
-				"run_id", runID,
-				"status", status,
-				"attempt", attempt,
-				"response_status", resp.StatusCode,
-			)
+	for i, d := range deliveries {
+		started := time.Now()
+		statusCode, sendErr := w.sendWebhook(ctx, d)
+		if err := w.recordWebhookAttempt(ctx, d, statusCode, time.Since(started), sendErr); err != nil {
+			return i, err
 		}
+	}
 
-		if attempt < webhookRetryAttempts {
-			wait := webhookRetryBase * time.Duration(1<<(attempt-1))
-			timer := time.NewTimer(wait)
-			select {
-			case <-ctx.Done():
-				timer.Stop()
-				w.logger.Warn("webhook canceled before retry",
-					"run_id", runID,
-					"status", status,
-					"attempt", attempt,
-					"error", ctx.Err(),
-				)
-				return
-			case <-timer.C:
-			}
+	return len(deliveries), nil
+}
+
+// claimDueWebhooks leases due deliveries by pushing next_attempt_at forward by
+// webhookClaimLease. A dispatcher that dies mid-send leaves the row to be
+// picked up again once the lease expires.
+func (w *Worker) claimDueWebhooks(ctx context.Context) ([]webhookDelivery, error) {
+	now := w.now()
+	rows, err := w.pool.Query(ctx, `
+		WITH due AS (
+			SELECT id
+			FROM webhook_deliveries
+			WHERE api_key_id = $1
+			  AND status = $2
+			  AND next_attempt_at <= $5
+			ORDER BY next_attempt_at ASC
+			FOR UPDATE SKIP LOCKED
+			LIMIT $3
+		)
+		UPDATE webhook_deliveries d
+		SET attempts = d.attempts + 1,
+		    first_attempted_at = COALESCE(d.first_attempted_at, $5),
+		    next_attempt_at = $4,
+		    updated_at = $5
+		FROM due, runs r
+		WHERE d.id = due.id
+		  AND r.id = d.run_id
+		RETURNING d.id, d.run_id, d.url, d.payload, COALESCE(r.webhook_secret, ''), d.attempts, d.max_attempts, d.first_attempted_at
+	`,
+		w.apiKeyID,
+		domain.WebhookPending,
+		webhookDispatchBatch,
+		now.Add(webhookClaimLease),
+		now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []webhookDelivery
+	for rows.Next() {
+		var d webhookDelivery
+		if err := rows.Scan(&d.ID, &d.RunID, &d.URL, &d.Payload, &d.Secret, &d.Attempts, &d.MaxAttempts, &d.FirstAttemptedAt); err != nil {
+			return nil, err
+		}
+		d.Secret, d.SecretErr = w.keyring.OpenString(secrets.FieldWebhookSecret, d.Secret)
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// loadWebhookSigningKeys returns the tenant's unexpired signing key versions,
+// newest first. Deliveries for runs without their own secret are signed with
+// all of them so receivers mid-rotation can verify with either secret.
+func (w *Worker) loadWebhookSigningKeys(ctx context.Context) ([]webhook.Key, error) {
+	rows, err := w.pool.Query(ctx, `
+		SELECT version, secret
+		FROM webhook_signing_keys
+		WHERE api_key_id = $1
+		  AND (expires_at IS NULL OR expires_at > $2)
+		ORDER BY version DESC
+	`, w.apiKeyID, w.now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []webhook.Key{}
+	for rows.Next() {
+		var (
+			version int
+			secret  string
+		)
+		if err := rows.Scan(&version, &secret); err != nil {
+			return nil, err
+		}
+		if secret, err = w.keyring.OpenString(secrets.FieldWebhookSecret, secret); err != nil {
+			return nil, fmt.Errorf("webhook signing key %s: %w", domain.WebhookSigningKeyID(version), err)
 		}
+		keys = append(keys, webhook.Key{ID: domain.WebhookSigningKeyID(version), Secret: secret})
+	}
+
+	return keys, rows.Err()
+}
+
+// recordWebhookAttempt appends the attempt to webhook_attempts and moves the
+// delivery to its next state in one transaction, so the log always matches
+// the delivery's attempts counter.
+func (w *Worker) recordWebhookAttempt(ctx context.Context, d webhookDelivery, statusCode int, latency time.Duration, sendErr error) error {
+	var lastStatusCode *int
+	if statusCode > 0 {
+		lastStatusCode = &statusCode
+	}
+	var lastError *string
+	if sendErr != nil {
+		msg := truncateWebhookError(sendErr.Error())
+		lastError = &msg
+	}
+
+	tx, err := w.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO webhook_attempts (id, delivery_id, attempt, status_code, latency_ms, error)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`,
+		uuid.New(),
+		d.ID,
+		d.Attempts,
+		lastStatusCode,
+		latency.Milliseconds(),
+		lastError,
+	); err != nil {
+		return err
+	}
+
+	var (
+		outcome       string
+		nextAttemptAt time.Time
+	)
+	switch {
+	case sendErr == nil:
+		outcome = metrics.WebhookOutcomeDelivered
+		_, err = tx.Exec(ctx, `
+			UPDATE webhook_deliveries
+			SET status=$2,
+			    last_status_code=$3,
+			    last_error=NULL,
+			    delivered_at=$4,
+			    updated_at=$4
+			WHERE id=$1
+		`,
+			d.ID,
+			domain.WebhookDelivered,
+			lastStatusCode,
+			w.now(),
+		)
+	case d.Attempts >= d.MaxAttempts:
+		outcome = metrics.WebhookOutcomeFailed
+		_, err = tx.Exec(ctx, `
+			UPDATE webhook_deliveries
+			SET status=$2,
+			    last_status_code=$3,
+			    last_error=$4,
+			    updated_at=$5
+			WHERE id=$1
+		`,
+			d.ID,
+			domain.WebhookFailed,
+			lastStatusCode,
+			lastError,
+			w.now(),
+		)
+	default:
+		outcome = metrics.WebhookOutcomeRetry
+		nextAttemptAt = w.now().Add(webhookRetryDelay(w.webhookRetryBase, d.Attempts))
+		_, err = tx.Exec(ctx, `
+			UPDATE webhook_deliveries
+			SET next_attempt_at=$2,
+			    last_status_code=$3,
+			    last_error=$4,
+			    updated_at=$5
+			WHERE id=$1
+		`,
+			d.ID,
+			nextAttemptAt,
+			lastStatusCode,
+			lastError,
+			w.now(),
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
 	}
 
-	if lastErr != nil {
+	metrics.IncWebhookDelivery(outcome)
+	switch outcome {
+	case metrics.WebhookOutcomeDelivered:
+		w.logger.Info("webhook delivered",
+			"api_key_id", w.apiKeyID,
+			"run_id", d.RunID,
+			"delivery_id", d.ID,
+			"attempt", d.Attempts,
+			"response_status", statusCode,
+			"latency_ms", latency.Milliseconds(),
+		)
+	case metrics.WebhookOutcomeFailed:
 		w.logger.Error("webhook retries exhausted",
-			"run_id", runID,
-			"status", status,
-			"error", lastErr,
+			"api_key_id", w.apiKeyID,
+			"run_id", d.RunID,
+			"delivery_id", d.ID,
+			"attempts", d.Attempts,
+			"response_status", statusCode,
+			"error", sendErr,
 		)
+	default:
+		w.logger.Warn("webhook failure - retrying",
+			"api_key_id", w.apiKeyID,
+			"run_id", d.RunID,
+			"delivery_id", d.ID,
+			"attempt", d.Attempts,
+			"max_attempts", d.MaxAttempts,
+			"response_status", statusCode,
+			"next_attempt_at", nextAttemptAt,
+			"error", sendErr,
+		)
+	}
+	return nil
+}
+
+// sendWebhook performs a single signed POST. Attempt headers let receivers
+// spot retried or delayed notifications. It returns the response status code
+// when one was received.
+func (w *Worker) sendWebhook(ctx context.Context, d webhookDelivery) (int, error) {
+	if d.SecretErr != nil {
+		return 0, fmt.Errorf("webhook secret: %w", d.SecretErr)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if strings.TrimSpace(d.Secret) != "" {
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(d.Secret, w.now(), d.Payload))
+	} else if len(d.SigningKeys) > 0 {
+		req.Header.Set(webhook.SignatureHeader, webhook.SignWithKeys(d.SigningKeys, w.now(), d.Payload))
+	}
+	if d.ID != uuid.Nil {
+		req.Header.Set(webhookHeaderDeliveryID, d.ID.String())
+	}
+	if d.Attempts > 0 {
+		req.Header.Set(webhookHeaderAttempt, strconv.Itoa(d.Attempts))
 	}
+	if !d.FirstAttemptedAt.IsZero() {
+		req.Header.Set(webhookHeaderFirstAttemptAt, d.FirstAttemptedAt.UTC().Format(time.RFC3339))
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return resp.StatusCode, fmt.Errorf("non-2xx response: %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
 }
 
-func signWebhookPayload(secret string, payload []byte) string {
-	if strings.TrimSpace(secret) == "" {
-		return ""
+// webhookRetryDelay doubles base for every attempt already made, capped at
+// webhookMaxRetryDelay.
+func webhookRetryDelay(base time.Duration, attempts int) time.Duration {
+	delay := domain.RetryPolicy{BaseDelay: base, Backoff: domain.RetryBackoffExponential}.Delay(attempts - 1)
+	if delay > webhookMaxRetryDelay {
+		return webhookMaxRetryDelay
 	}
+	return delay
+}
 
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write(payload)
-	return hex.EncodeToString(mac.Sum(nil))
+func truncateWebhookError(msg string) string {
+	if len(msg) <= webhookMaxErrorLength {
+		return msg
+	}
+	return msg[:webhookMaxErrorLength]
 }
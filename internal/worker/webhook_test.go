@@ -4,7 +4,9 @@ package worker
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
@@ -14,31 +16,43 @@ import (
 	"time"
 
 	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	"github.com/adiadia/agent-runtime/pkg/webhook"
 	"github.com/google/uuid"
 )
 
-func TestDeliverTerminalWebhookRetriesAndSigns(t *testing.T) {
+func TestSendWebhookSignsPayload(t *testing.T) {
 	var attempts int32
 	runID := uuid.New()
 	finishedAt := time.Now().UTC().Truncate(time.Second)
 	secret := "super-secret"
 
+	body, err := json.Marshal(domain.TerminalWebhookPayload{
+		RunID:      runID,
+		Status:     domain.RunFailed,
+		FinishedAt: finishedAt,
+	})
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+
 	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
-		current := atomic.AddInt32(&attempts, 1)
+		atomic.AddInt32(&attempts, 1)
 
-		body, err := io.ReadAll(r.Body)
+		got, err := io.ReadAll(r.Body)
 		if err != nil {
 			t.Fatalf("read body: %v", err)
 		}
 
-		gotSig := r.Header.Get(webhookHeaderSig)
-		wantSig := signWebhookPayload(secret, body)
-		if gotSig != wantSig {
-			t.Fatalf("expected signature %q got %q", wantSig, gotSig)
+		if err := webhook.Verify(got, r.Header.Get(webhook.SignatureHeader), secret, webhook.DefaultTolerance); err != nil {
+			t.Fatalf("expected verifiable signature, got %v (header %q)", err, r.Header.Get(webhook.SignatureHeader))
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("expected json content type got %q", ct)
 		}
 
-		var payload terminalWebhookPayload
-		if err := json.Unmarshal(body, &payload); err != nil {
+		var payload domain.TerminalWebhookPayload
+		if err := json.Unmarshal(got, &payload); err != nil {
 			t.Fatalf("unmarshal payload: %v", err)
 		}
 		if payload.RunID != runID {
@@ -51,13 +65,6 @@ func TestDeliverTerminalWebhookRetriesAndSigns(t *testing.T) {
 			t.Fatalf("expected finished_at %s got %s", finishedAt, payload.FinishedAt)
 		}
 
-		if current < 3 {
-			return &http.Response{
-				StatusCode: http.StatusInternalServerError,
-				Body:       io.NopCloser(strings.NewReader("fail")),
-				Header:     make(http.Header),
-			}, nil
-		}
 		return &http.Response{
 			StatusCode: http.StatusOK,
 			Body:       io.NopCloser(strings.NewReader("ok")),
@@ -70,19 +77,106 @@ func TestDeliverTerminalWebhookRetriesAndSigns(t *testing.T) {
 		httpClient: client,
 	}
 
-	w.deliverTerminalWebhook(context.Background(), runID, domain.RunFailed, finishedAt, "http://webhook.local/callback", secret)
+	statusCode, err := w.sendWebhook(context.Background(), webhookDelivery{
+		URL:     "http://webhook.local/callback",
+		Secret:  secret,
+		Payload: body,
+	})
+	if err != nil {
+		t.Fatalf("send webhook: %v", err)
+	}
+	if statusCode != http.StatusOK {
+		t.Fatalf("expected status %d got %d", http.StatusOK, statusCode)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Fatalf("expected a single webhook attempt got %d", got)
+	}
+}
+
+func TestSendWebhookSetsAttemptHeaders(t *testing.T) {
+	deliveryID := uuid.New()
+	firstAttemptedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	var got http.Header
+	w := &Worker{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		httpClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			got = r.Header.Clone()
+			return &http.Response{
+				StatusCode: http.StatusNoContent,
+				Body:       io.NopCloser(strings.NewReader("")),
+				Header:     make(http.Header),
+			}, nil
+		})},
+	}
+
+	if _, err := w.sendWebhook(context.Background(), webhookDelivery{
+		ID:               deliveryID,
+		URL:              "http://webhook.local/callback",
+		Payload:          []byte(`{}`),
+		Attempts:         3,
+		FirstAttemptedAt: firstAttemptedAt,
+	}); err != nil {
+		t.Fatalf("send webhook: %v", err)
+	}
+
+	if v := got.Get(webhookHeaderDeliveryID); v != deliveryID.String() {
+		t.Fatalf("expected delivery id header %s got %q", deliveryID, v)
+	}
+	if v := got.Get(webhookHeaderAttempt); v != "3" {
+		t.Fatalf("expected attempt header 3 got %q", v)
+	}
+	if v := got.Get(webhookHeaderFirstAttemptAt); v != "2026-03-01T12:00:00Z" {
+		t.Fatalf("expected first attempt header got %q", v)
+	}
+}
+
+func TestSendWebhookSignsWithTenantSigningKeys(t *testing.T) {
+	var header string
+	w := &Worker{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		httpClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			header = r.Header.Get(webhook.SignatureHeader)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader("")),
+				Header:     make(http.Header),
+			}, nil
+		})},
+	}
+
+	payload := []byte(`{"run_id":"r1"}`)
+	if _, err := w.sendWebhook(context.Background(), webhookDelivery{
+		URL:     "http://webhook.local/callback",
+		Payload: payload,
+		SigningKeys: []webhook.Key{
+			{ID: "k2", Secret: "whsec_new_secret_value"},
+			{ID: "k1", Secret: "whsec_old_secret_value"},
+		},
+	}); err != nil {
+		t.Fatalf("send webhook: %v", err)
+	}
 
-	if got := atomic.LoadInt32(&attempts); got != 3 {
-		t.Fatalf("expected 3 webhook attempts got %d", got)
+	_, signatures, err := webhook.ParseSignatures(header)
+	if err != nil {
+		t.Fatalf("parse signature header %q: %v", header, err)
+	}
+	if len(signatures) != 2 || signatures[0].KeyID != "k2" || signatures[1].KeyID != "k1" {
+		t.Fatalf("expected signatures for k2 and k1, got %+v", signatures)
+	}
+	if err := webhook.VerifyWithKeys(payload, header, map[string]string{"k1": "whsec_old_secret_value"}, webhook.DefaultTolerance); err != nil {
+		t.Fatalf("expected receiver holding only the old secret to verify, got %v", err)
 	}
 }
 
-func TestDeliverTerminalWebhookStopsAfterRetryLimit(t *testing.T) {
+func TestSendWebhookReturnsErrorOnNon2xx(t *testing.T) {
 	var attempts int32
-	runID := uuid.New()
 
 	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
 		atomic.AddInt32(&attempts, 1)
+		if sig := r.Header.Get(webhook.SignatureHeader); sig != "" {
+			t.Fatalf("expected no signature without secret, got %q", sig)
+		}
 		return &http.Response{
 			StatusCode: http.StatusInternalServerError,
 			Body:       io.NopCloser(strings.NewReader("fail")),
@@ -95,10 +189,48 @@ func TestDeliverTerminalWebhookStopsAfterRetryLimit(t *testing.T) {
 		httpClient: client,
 	}
 
-	w.deliverTerminalWebhook(context.Background(), runID, domain.RunSuccess, time.Now().UTC(), "http://webhook.local/callback", "")
+	statusCode, err := w.sendWebhook(context.Background(), webhookDelivery{
+		URL:     "http://webhook.local/callback",
+		Payload: []byte(`{}`),
+	})
+	if err == nil {
+		t.Fatal("expected error for non-2xx response")
+	}
+	if statusCode != http.StatusInternalServerError {
+		t.Fatalf("expected status %d got %d", http.StatusInternalServerError, statusCode)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Fatalf("expected send to make one attempt and leave retries to the outbox, got %d", got)
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	base := 10 * time.Second
 
-	if got := atomic.LoadInt32(&attempts); got != webhookRetryAttempts {
-		t.Fatalf("expected %d attempts got %d", webhookRetryAttempts, got)
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: 10 * time.Second},
+		{attempts: 2, want: 20 * time.Second},
+		{attempts: 3, want: 40 * time.Second},
+		{attempts: 20, want: webhookMaxRetryDelay},
+	}
+
+	for _, tt := range tests {
+		if got := webhookRetryDelay(base, tt.attempts); got != tt.want {
+			t.Fatalf("attempts=%d: expected %s got %s", tt.attempts, tt.want, got)
+		}
+	}
+}
+
+func TestTruncateWebhookError(t *testing.T) {
+	long := strings.Repeat("x", webhookMaxErrorLength+10)
+	if got := truncateWebhookError(long); len(got) != webhookMaxErrorLength {
+		t.Fatalf("expected truncated length %d got %d", webhookMaxErrorLength, len(got))
+	}
+	if got := truncateWebhookError("short"); got != "short" {
+		t.Fatalf("expected short message unchanged, got %q", got)
 	}
 }
 
@@ -107,3 +239,41 @@ type roundTripFunc func(*http.Request) (*http.Response, error)
 func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
 	return f(req)
 }
+
+func TestWebhookSecretsAreDecrypted(t *testing.T) {
+	keyring, err := secrets.ParseKeyring("k1:" + base64.StdEncoding.EncodeToString(make([]byte, secrets.KeySize)))
+	if err != nil {
+		t.Fatalf("parse keyring: %v", err)
+	}
+	sealed, err := keyring.SealString(secrets.FieldWebhookSecret, "signing-secret")
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+
+	w := New(Deps{Queue: &fakeQueue{}, Pool: &fakeDB{rows: []fakeRow{{2, sealed}}}, Keyring: keyring})
+	keys, err := w.loadWebhookSigningKeys(context.Background())
+	if err != nil {
+		t.Fatalf("load signing keys: %v", err)
+	}
+	if len(keys) != 1 || keys[0].Secret != "signing-secret" {
+		t.Fatalf("expected the decrypted signing key, got %+v", keys)
+	}
+
+	w.keyring = nil
+	if _, err := w.loadWebhookSigningKeys(context.Background()); !errors.Is(err, secrets.ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey without the keyring, got %v", err)
+	}
+
+	var sent int32
+	w.httpClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&sent, 1)
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+	})}
+	if _, err := w.sendWebhook(context.Background(), webhookDelivery{
+		URL:       "http://webhook.local/callback",
+		Payload:   []byte(`{}`),
+		SecretErr: secrets.ErrUnknownKey,
+	}); !errors.Is(err, secrets.ErrUnknownKey) || atomic.LoadInt32(&sent) != 0 {
+		t.Fatalf("expected the delivery to fail unsent, got %v after %d requests", err, sent)
+	}
+}
@@ -4,41 +4,161 @@ package worker
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
+	"sync/atomic"
 	"time"
 
+	"github.com/adiadia/agent-runtime/internal/clock"
 	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/egress"
 	"github.com/adiadia/agent-runtime/internal/metrics"
+	"github.com/adiadia/agent-runtime/internal/redact"
+	"github.com/adiadia/agent-runtime/internal/repository"
+	"github.com/adiadia/agent-runtime/internal/secrets"
 	execs "github.com/adiadia/agent-runtime/internal/worker/executors"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Features lists the capabilities of this worker build. They are recorded in
+// the workers registry so operators can spot feature skew during rollouts.
+var Features = []string{
+	"approval_escalation",
+	"cancel_propagation",
+	"executor_circuit_breakers",
+	"http_tools",
+	"map_steps",
+	"monthly_budget",
+	"named_approvals",
+	"run_summary",
+	"sandboxed_commands",
+	"step_conditions",
+	"step_leases",
+	"step_logs",
+	"step_on_failure",
+	"step_retry_policy",
+	"step_secrets",
+	"tenant_claim_counters",
+	"webhook_event_subscriptions",
+	"webhook_signing_keys",
+	"worker_heartbeats",
+}
+
+// DB is the part of *pgxpool.Pool the worker uses outside its StepQueue, for
+// step logs, secrets, and webhook deliveries, so tests can run it against a
+// fake instead of a live database.
+type DB interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+var _ DB = (*pgxpool.Pool)(nil)
+
 type Deps struct {
-	Pool               *pgxpool.Pool
-	Logger             *slog.Logger
-	ReclaimAfter       time.Duration
-	MaxAttempts        int
-	RetryBaseDelay     time.Duration
-	DefaultStepTimeout time.Duration
-	APIKeyID           uuid.UUID
+	Pool DB
+	// Queue claims steps and settles them. Nil uses a
+	// repository.StepQueueRepository on Pool, configured from these Deps;
+	// it is required when Pool is not a *pgxpool.Pool.
+	Queue          repository.StepQueue
+	Logger         *slog.Logger
+	Clock          clock.Clock
+	WorkerID       uuid.UUID
+	ReclaimAfter   time.Duration
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
+	// RetryPriority is added to a step's priority boost each time it is
+	// rescheduled, unless its template step sets retry_priority.
+	RetryPriority         int
+	DefaultStepTimeout    time.Duration
+	CancelCheckInterval   time.Duration
+	APIKeyID              uuid.UUID
+	WebhookMaxAttempts    int
+	WebhookRetryBaseDelay time.Duration
+	Mock                  *MockConfig
+	// Executors replaces the executors of the step types it lists, e.g. with
+	// scripted ones in tests. TOOL steps with a command or an HTTP request
+	// still run in the sandbox or through the HTTP executor.
+	Executors map[domain.StepName]StepExecutor
+	Breaker   *BreakerConfig
+	Sandbox   *execs.SandboxConfig
+	// MaxStepOutputBytes bounds the output stored for a step; larger output
+	// is replaced by a truncated stand-in. 0 means 1 MiB.
+	MaxStepOutputBytes int
+	// ClaimBatchSize is how many steps ProcessOnce claims in one transaction
+	// and executes concurrently. 0 or 1 claims one step at a time.
+	ClaimBatchSize int
+	// GlobalStepLimits caps the RUNNING steps of a step type across all
+	// tenants. Every worker must be given the same caps for them to hold.
+	GlobalStepLimits map[domain.StepName]int
+	// Secrets opens the secrets steps reference. Steps that reference
+	// secrets fail permanently when it is nil.
+	Secrets *secrets.Cipher
+	// Keyring encrypts step input and output at rest and opens them, and
+	// webhook secrets, when read. Nil stores them in plaintext.
+	Keyring *secrets.Keyring
+	// Redactor removes personal data from step output, errors, and logs and
+	// from event payloads before they are written. Nil writes them as is.
+	Redactor *redact.Redactor
+	// Egress limits where webhook deliveries and executors' HTTP clients
+	// may connect. Nil leaves them unrestricted.
+	Egress *egress.Policy
+	// HTTPStepAllowPrivate lets HTTP steps connect to loopback and private
+	// addresses, which they are refused by default, as webhook targets are.
+	HTTPStepAllowPrivate bool
+	// ApprovalLink is the link put in WAITING_APPROVAL webhooks; {run_id}
+	// and {step_id} are replaced. Empty means domain.DefaultApprovalLink.
+	ApprovalLink string
 }
 
 type Worker struct {
-	pool               *pgxpool.Pool
-	logger             *slog.Logger
-	httpClient         *http.Client
-	reclaimAfter       time.Duration
-	executors          map[domain.StepName]StepExecutor
-	maxAttempts        int
-	retryBaseDelay     time.Duration
-	defaultStepTimeout time.Duration
-	apiKeyID           uuid.UUID
+	pool                DB
+	queue               repository.StepQueue
+	logger              *slog.Logger
+	clock               clock.Clock
+	httpClient          *http.Client
+	workerID            uuid.UUID
+	reclaimAfter        time.Duration
+	executors           map[domain.StepName]StepExecutor
+	maxAttempts         int
+	retryBaseDelay      time.Duration
+	retryPriority       int
+	defaultStepTimeout  time.Duration
+	cancelCheckInterval time.Duration
+	apiKeyID            uuid.UUID
+	webhookRetryBase    time.Duration
+	// inFlight counts steps claimed and not yet settled.
+	inFlight atomic.Int64
+	// breakers is nil unless Deps.Breaker is set.
+	breakers *circuitBreakers
+	// sandbox runs TOOL steps with a command; nil unless Deps.Sandbox is set.
+	sandbox StepExecutor
+	// httpTool runs TOOL steps with an HTTP request through hostTransports.
+	httpTool       StepExecutor
+	hostTransports *hostTransports
+	// maxStepOutputBytes bounds the output markStepSucceeded stores.
+	maxStepOutputBytes int
+	// claimBatchSize bounds the steps one ProcessOnce claims and runs.
+	claimBatchSize int
+	// globalStepLimits caps RUNNING steps per step type across tenants.
+	globalStepLimits map[domain.StepName]int
+	// secrets opens step secrets; nil unless Deps.Secrets is set.
+	secrets *secrets.Cipher
+	// keyring seals and opens encrypted columns; nil leaves them plaintext.
+	keyring *secrets.Keyring
+	// egress is the transport executors' HTTP clients use; nil unless
+	// Deps.Egress is set.
+	egress http.RoundTripper
+	// redactor applies REDACTION_RULES; nil leaves payloads unchanged.
+	redactor *redact.Redactor
 }
 
 func New(deps Deps) *Worker {
@@ -47,6 +167,11 @@ func New(deps Deps) *Worker {
 		l = slog.Default()
 	}
 
+	workerID := deps.WorkerID
+	if workerID == uuid.Nil {
+		workerID = uuid.New()
+	}
+
 	reclaim := deps.ReclaimAfter
 	if reclaim <= 0 {
 		reclaim = 5 * time.Minute
@@ -67,43 +192,182 @@ func New(deps Deps) *Worker {
 		defaultStepTimeout = 30 * time.Second
 	}
 
+	cancelCheckInterval := deps.CancelCheckInterval
+	if cancelCheckInterval <= 0 {
+		cancelCheckInterval = 2 * time.Second
+	}
+
+	webhookRetryBase := deps.WebhookRetryBaseDelay
+	if webhookRetryBase <= 0 {
+		webhookRetryBase = 10 * time.Second
+	}
+
+	maxStepOutputBytes := deps.MaxStepOutputBytes
+	if maxStepOutputBytes <= 0 {
+		maxStepOutputBytes = defaultMaxStepOutputBytes
+	}
+
 	registry := map[domain.StepName]StepExecutor{
 		domain.StepLLM:  &execs.LLMExecutor{},
 		domain.StepTool: &execs.ToolExecutor{},
 	}
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	var egressTransport http.RoundTripper
+	if deps.Egress != nil {
+		egressTransport = deps.Egress.Transport()
+		httpClient.Transport = egressTransport
+	}
+	var httpTool StepExecutor = &execs.HTTPToolExecutor{}
+	if deps.Mock != nil {
+		registry = mockExecutors(*deps.Mock)
+		httpClient = newMockWebhookClient(*deps.Mock)
+		httpTool = registry[domain.StepTool]
+	}
+	maps.Copy(registry, deps.Executors)
+
+	var breakers *circuitBreakers
+	if deps.Breaker != nil {
+		breakers = newCircuitBreakers(*deps.Breaker, l)
+	}
+
+	var sandbox StepExecutor
+	if deps.Sandbox != nil {
+		sandbox = execs.NewSandboxExecutor(*deps.Sandbox)
+	}
+
+	queue := deps.Queue
+	if queue == nil {
+		pool, ok := deps.Pool.(*pgxpool.Pool)
+		if !ok {
+			panic("worker.New requires Deps.Queue when Deps.Pool is not a *pgxpool.Pool")
+		}
+		queue = repository.NewStepQueueRepository(pool, l).
+			WithClock(deps.Clock).
+			WithKeyring(deps.Keyring).
+			WithRedactor(deps.Redactor).
+			WithWebhookMaxAttempts(deps.WebhookMaxAttempts).
+			WithApprovalLink(deps.ApprovalLink)
+	}
 
 	return &Worker{
-		pool:               deps.Pool,
-		logger:             l,
-		httpClient:         &http.Client{Timeout: 5 * time.Second},
-		reclaimAfter:       reclaim,
-		maxAttempts:        maxAtt,
-		retryBaseDelay:     retryBase,
-		defaultStepTimeout: defaultStepTimeout,
-		executors:          registry,
-		apiKeyID:           deps.APIKeyID,
+		pool:                deps.Pool,
+		queue:               queue,
+		logger:              l,
+		clock:               clock.OrReal(deps.Clock),
+		httpClient:          httpClient,
+		egress:              egressTransport,
+		workerID:            workerID,
+		reclaimAfter:        reclaim,
+		maxAttempts:         maxAtt,
+		retryBaseDelay:      retryBase,
+		retryPriority:       deps.RetryPriority,
+		defaultStepTimeout:  defaultStepTimeout,
+		cancelCheckInterval: cancelCheckInterval,
+		executors:           registry,
+		apiKeyID:            deps.APIKeyID,
+		webhookRetryBase:    webhookRetryBase,
+		breakers:            breakers,
+		sandbox:             sandbox,
+		httpTool:            httpTool,
+		hostTransports:      newHostTransports(deps.Egress, deps.HTTPStepAllowPrivate),
+		maxStepOutputBytes:  maxStepOutputBytes,
+		claimBatchSize:      max(deps.ClaimBatchSize, 1),
+		globalStepLimits:    deps.GlobalStepLimits,
+		secrets:             deps.Secrets,
+		keyring:             deps.Keyring,
+		redactor:            deps.Redactor,
+	}
+}
+
+// now returns the worker's current time in UTC, the zone the TIMESTAMP
+// columns it compares against are written in.
+func (w *Worker) now() time.Time {
+	return clock.OrReal(w.clock).Now().UTC()
+}
+
+// errSandboxDisabled fails steps with a command on workers started without
+// --sandbox-allowed-binaries.
+var errSandboxDisabled = errors.New("step has a command but the worker has no sandbox configured")
+
+// claimRequest is the claim this worker makes on its tenant's steps. Step
+// types whose executor's circuit is open are left alone, and those whose
+// circuit is half-open are claimed one at a time as probes.
+func (w *Worker) claimRequest() domain.ClaimRequest {
+	blocked := w.breakers.blocked(w.now())
+	if len(blocked) > 0 {
+		w.logger.Debug("claim skipping step types with open circuits",
+			"api_key_id", w.apiKeyID,
+			"steps", blocked,
+		)
+	}
+	return domain.ClaimRequest{
+		APIKeyID:         w.apiKeyID,
+		WorkerID:         w.workerID,
+		Lease:            w.reclaimAfter,
+		DefaultTimeout:   w.defaultStepTimeout,
+		Blocked:          blocked,
+		Probing:          w.breakers.halfOpen(),
+		GlobalStepLimits: w.globalStepLimits,
 	}
 }
 
-type claimedStep struct {
-	StepID  uuid.UUID
-	RunID   uuid.UUID
-	Name    domain.StepName
-	Status  domain.StepStatus
-	Timeout time.Duration
+// InFlightSteps reports how many claimed steps the worker has not settled
+// yet, for its heartbeat.
+func (w *Worker) InFlightSteps() int {
+	return int(w.inFlight.Load())
 }
 
+// Pricing returns the pricing the worker's executors declare, by step type,
+// for its registry row.
+func (w *Worker) Pricing() map[domain.StepName]domain.StepPricing {
+	pricing := make(map[domain.StepName]domain.StepPricing, len(w.executors))
+	for name, executor := range w.executors {
+		if priced, ok := executor.(PricedExecutor); ok {
+			pricing[name] = priced.Pricing()
+		}
+	}
+	return pricing
+}
+
+// ProcessOnce claims a runnable step, or with Deps.ClaimBatchSize above 1 a
+// batch of them, and executes what it claimed.
 func (w *Worker) ProcessOnce(ctx context.Context) error {
+	_, err := w.processOnce(ctx)
+	return err
+}
+
+// processOnce is ProcessOnce, also reporting how many steps it claimed, which
+// the shared scheduler charges against the tenant's weight.
+func (w *Worker) processOnce(ctx context.Context) (int, error) {
+	if w.claimBatchSize > 1 {
+		return w.processBatch(ctx)
+	}
+	return w.processOne(ctx)
+}
+
+// processOne claims and executes a single step.
+func (w *Worker) processOne(ctx context.Context) (int, error) {
 	claimStart := time.Now()
-	step, err := w.claimOneStep(ctx)
+	step, err := w.queue.ClaimStep(ctx, w.claimRequest())
 	metrics.ObserveWorkerClaimLatency(time.Since(claimStart))
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil
+		if errors.Is(err, domain.ErrNoStepToClaim) {
+			return 0, nil
+		}
+		if errors.Is(err, domain.ErrStepHandledAtClaim) {
+			return 1, nil
 		}
 		w.logger.Error("claim step failed", "error", err)
-		return err
+		return 0, err
 	}
+	return 1, w.runClaimed(ctx, step)
+}
+
+// runClaimed executes a claimed step and records its result.
+func (w *Worker) runClaimed(ctx context.Context, step domain.ClaimedStep) error {
+	w.inFlight.Add(1)
+	defer w.inFlight.Add(-1)
+	w.breakers.claimed(step.Name)
 
 	w.logger.Info("step claimed",
 		"api_key_id", w.apiKeyID,
@@ -114,6 +378,22 @@ func (w *Worker) ProcessOnce(ctx context.Context) error {
 		"timeout", step.Timeout,
 	)
 
+	if step.ConfigErr != nil {
+		w.logger.Error("step configuration invalid",
+			"run_id", step.RunID,
+			"step_id", step.StepID,
+			"step", step.Name,
+			"error", step.ConfigErr,
+		)
+		w.breakers.release(step.Name)
+		configErr := step.ConfigErr
+		if errors.Is(configErr, egress.ErrInvalidHostRule) {
+			// Retrying cannot fix the tenant's allow-list.
+			configErr = execs.Permanent(configErr)
+		}
+		return w.discardRejectedResult(step, w.markStepFailed(ctx, step, configErr, domain.CostDetail{}, 0))
+	}
+
 	w.logger.Info("executing step",
 		"api_key_id", w.apiKeyID,
 		"run_id", step.RunID,
@@ -122,7 +402,31 @@ func (w *Worker) ProcessOnce(ctx context.Context) error {
 		"timeout", step.Timeout,
 	)
 
-	out, costUSD, execErr := w.executeStep(ctx, step)
+	execCtx, cancelExec := context.WithCancelCause(ctx)
+	defer cancelExec(nil)
+	stopWatching := w.watchCancellation(execCtx, step, cancelExec)
+	stopLease := w.keepLease(ctx, step)
+	stepLog, stopLog := w.keepStepLog(ctx, step)
+	progress, stopProgress := w.keepStepProgress(ctx, step)
+	execStart := time.Now()
+	stepCtx := execs.WithLogger(execCtx, stepLog)
+	stepCtx = execs.WithEventEmitter(execs.WithProgress(stepCtx, progress), progress)
+	out, cost, execErr := w.executeStep(stepCtx, step)
+	latency := time.Since(execStart)
+	stopProgress()
+	stopLog()
+	stopLease()
+	stopWatching()
+
+	// The run was canceled mid-flight: whatever the executor returned is
+	// dropped.
+	if errors.Is(context.Cause(execCtx), errStepCanceled) {
+		w.breakers.release(step.Name)
+		return w.discardRejectedResult(step, w.queue.CancelStep(ctx, step))
+	}
+	// Permanent errors blame the step's input, not the provider, so they do
+	// not count against the breaker.
+	w.breakers.record(step.Name, execErr != nil && !execs.IsPermanent(execErr), w.now())
 	if execErr != nil {
 		timeoutTriggered := errors.Is(execErr, context.DeadlineExceeded)
 		w.logger.Error("step execution failed",
@@ -133,15 +437,15 @@ func (w *Worker) ProcessOnce(ctx context.Context) error {
 			"timeout_triggered", timeoutTriggered,
 			"error", execErr,
 		)
-		return w.markStepFailed(ctx, step.StepID, execErr)
+		return w.discardRejectedResult(step, w.markStepFailed(ctx, step, execErr, cost, latency))
 	}
 
-	if err := w.markStepSucceeded(ctx, step, out, costUSD); err != nil {
+	if err := w.discardRejectedResult(step, w.markStepSucceeded(ctx, step, out, cost, latency)); err != nil {
 		w.logger.Error("mark step succeeded failed",
 			"run_id", step.RunID,
 			"step_id", step.StepID,
 			"step", step.Name,
-			"cost_usd", costUSD,
+			"cost_usd", cost.CostUSD,
 			"error", err,
 		)
 		return err
@@ -151,7 +455,7 @@ func (w *Worker) ProcessOnce(ctx context.Context) error {
 		"run_id", step.RunID,
 		"step_id", step.StepID,
 		"step", step.Name,
-		"cost_usd", costUSD,
+		"cost_usd", cost.CostUSD,
 		"timeout", step.Timeout,
 		"timeout_triggered", false,
 	)
@@ -159,170 +463,25 @@ func (w *Worker) ProcessOnce(ctx context.Context) error {
 	return nil
 }
 
-// claimOneStep claims one runnable step.
-// It also supports "reclaiming" stuck RUNNING steps older than reclaimAfter.
-func (w *Worker) claimOneStep(ctx context.Context) (claimedStep, error) {
-	tx, err := w.pool.Begin(ctx)
-	if err != nil {
-		return claimedStep{}, err
-	}
-	defer tx.Rollback(ctx)
-
-	reclaimBefore := time.Now().Add(-w.reclaimAfter)
-
-	var maxConcurrency int
-	if err := tx.QueryRow(ctx,
-		`SELECT max_concurrent_runs FROM api_keys WHERE id=$1`,
-		w.apiKeyID,
-	).Scan(&maxConcurrency); err != nil {
-		return claimedStep{}, err
-	}
-	if maxConcurrency <= 0 {
-		maxConcurrency = domain.DefaultMaxConcurrentRuns
-	}
-
-	var runningSteps int
-	if err := tx.QueryRow(ctx, `
-		SELECT COUNT(*)
-		FROM steps st
-		JOIN runs r ON st.run_id = r.id
-		WHERE r.api_key_id = $1
-		  AND st.status = $2
-	`,
-		w.apiKeyID,
-		domain.StepRunning,
-	).Scan(&runningSteps); err != nil {
-		return claimedStep{}, err
-	}
-	if runningSteps >= maxConcurrency {
-		w.logger.Debug("claim skipped by concurrency limit",
-			"api_key_id", w.apiKeyID,
-			"running_steps", runningSteps,
-			"max_concurrency", maxConcurrency,
-		)
-		return claimedStep{}, pgx.ErrNoRows
-	}
-
-	var (
-		s              claimedStep
-		nameStr        string
-		timeoutSeconds sql.NullInt64
-	)
-
-	err = tx.QueryRow(ctx, `
-		SELECT st.id, st.run_id, st.name, st.status, st.timeout_seconds
-		FROM steps st
-		JOIN runs r ON st.run_id = r.id
-		WHERE (
-			st.status = $1 OR
-			(st.status = $2 AND st.started_at IS NOT NULL AND st.started_at < $3)
-		)
-		  AND (st.next_run_at IS NULL OR st.next_run_at <= NOW())
-		  AND st.name <> $4
-		  AND r.status NOT IN ($5,$6,$7)
-		  AND r.api_key_id = $9
-		  AND NOT EXISTS (
-			SELECT 1 FROM steps s2
-			WHERE s2.run_id = st.run_id
-			  AND s2.created_at < st.created_at
-			  AND s2.status <> $8
-		  )
-		ORDER BY r.priority DESC, st.created_at ASC
-		FOR UPDATE SKIP LOCKED
-		LIMIT 1
-	`,
-		domain.StepPending,
-		domain.StepRunning,
-		reclaimBefore,
-		domain.StepApproval,
-		domain.RunCanceled,
-		domain.RunFailed,
-		domain.RunSuccess,
-		domain.StepSuccess,
-		w.apiKeyID,
-	).Scan(&s.StepID, &s.RunID, &nameStr, &s.Status, &timeoutSeconds)
-
-	if err != nil {
-		return claimedStep{}, err
-	}
-
-	s.Name = domain.StepName(nameStr)
-	s.Timeout = resolveStepTimeout(timeoutSeconds, w.defaultStepTimeout)
-
-	// Validate step name to avoid corrupted DB values
-	switch s.Name {
-	case domain.StepLLM, domain.StepTool, domain.StepApproval:
-	default:
-		return claimedStep{}, errors.New("invalid step name in DB: " + nameStr)
-	}
-
-	// Build input JSON for this step
-	inputPayload, _ := json.Marshal(map[string]any{
-		"step":      s.Name,
-		"claimedAt": time.Now(),
-		"reclaimed": s.Status == domain.StepRunning,
-	})
-
-	// Mark RUNNING and increment attempts (every claim counts as an attempt)
-	_, err = tx.Exec(ctx, `
-		UPDATE steps
-		SET status=$2,
-		    started_at=COALESCE(started_at, NOW()),
-		    input=$3::jsonb,
-		    next_run_at=NULL,
-		    attempts = attempts + 1
-		WHERE id=$1
-	`,
-		s.StepID,
-		domain.StepRunning,
-		inputPayload,
-	)
-	if err != nil {
-		return claimedStep{}, err
-	}
-
-	// Mark run RUNNING if it was PENDING
-	runStatusUpdated, _ := tx.Exec(ctx, `
-		UPDATE runs
-		SET status=$2, updated_at=NOW()
-		WHERE id=$1 AND status=$3
-	`,
-		s.RunID,
-		domain.RunRunning,
-		domain.RunPending,
-	)
-
-	if err := insertStepEvent(ctx, tx, s.RunID, s.StepID, "STEP_CLAIMED", map[string]any{
-		"status":     domain.StepRunning,
-		"step":       s.Name,
-		"reclaimed":  s.Status == domain.StepRunning,
-		"previous":   s.Status,
-		"api_key_id": w.apiKeyID,
-		"claimed_at": time.Now().UTC(),
-	}); err != nil {
-		return claimedStep{}, err
-	}
-
-	if err := tx.Commit(ctx); err != nil {
-		return claimedStep{}, err
-	}
-
-	if runStatusUpdated.RowsAffected() > 0 {
-		metrics.IncRunStatus(string(domain.RunRunning))
+// discardRejectedResult drops a step result whose status change the state
+// machine rejected, e.g. because the run was canceled while the step ran, or
+// whose claim a reclaim superseded. The rejection has already been reported
+// as an anomaly or belongs to the newer claim, so it is not retried.
+func (w *Worker) discardRejectedResult(step domain.ClaimedStep, err error) error {
+	if !errors.Is(err, domain.ErrInvalidTransition) && !errors.Is(err, domain.ErrStaleClaim) {
+		return err
 	}
-
-	w.logger.Info("step marked running",
+	w.logger.Warn("step result discarded",
 		"api_key_id", w.apiKeyID,
-		"run_id", s.RunID,
-		"step_id", s.StepID,
-		"step", s.Name,
-		"reclaimed", s.Status == domain.StepRunning,
+		"run_id", step.RunID,
+		"step_id", step.StepID,
+		"step", step.Name,
+		"error", err,
 	)
-
-	return s, nil
+	return nil
 }
 
-func (w *Worker) executeStep(ctx context.Context, s claimedStep) (json.RawMessage, float64, error) {
+func (w *Worker) executeStep(ctx context.Context, s domain.ClaimedStep) (json.RawMessage, domain.CostDetail, error) {
 	start := time.Now()
 	defer func() {
 		metrics.ObserveStepExecutionDuration(time.Since(start))
@@ -330,370 +489,106 @@ func (w *Worker) executeStep(ctx context.Context, s claimedStep) (json.RawMessag
 
 	executor, ok := w.executors[s.Name]
 	if !ok {
-		return nil, 0, errors.New("no executor registered for step: " + string(s.Name))
+		return nil, domain.CostDetail{}, errors.New("no executor registered for step: " + string(s.Name))
 	}
 
-	execCtx := ctx
-	cancel := func() {}
-	if s.Timeout > 0 {
-		execCtx, cancel = context.WithTimeout(ctx, s.Timeout)
+	execCtx := execs.WithExecutionKey(ctx, domain.ExecutionKey(s.StepID, s.ExecutionAttempt))
+	if w.egress != nil {
+		execCtx = execs.WithTransport(execCtx, w.egress)
 	}
-	defer cancel()
-
-	return executor.Execute(execCtx, s.RunID)
-}
-
-func (w *Worker) markStepSucceeded(ctx context.Context, step claimedStep, output json.RawMessage, costUSD float64) error {
-	tx, err := w.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
-
-	_, err = tx.Exec(ctx, `
-		UPDATE steps
-		SET status=$2,
-		    output=$3::jsonb,
-		    cost_usd=$4,
-		    next_run_at=NULL,
-		    finished_at=NOW()
-		WHERE id=$1
-	`,
-		step.StepID,
-		domain.StepSuccess,
-		output,
-		costUSD,
-	)
-	if err != nil {
-		return err
+	if s.Item != nil {
+		execCtx = execs.WithMapItem(execCtx, s.Item)
 	}
-
-	_, err = tx.Exec(ctx, `
-		UPDATE runs
-		SET total_cost_usd = total_cost_usd + $2
-		WHERE id=$1
-	`,
-		step.RunID,
-		costUSD,
-	)
-	if err != nil {
-		return err
+	if s.InputOverride != nil {
+		execCtx = execs.WithStepInput(execCtx, s.InputOverride)
 	}
-
-	if err := insertStepEvent(ctx, tx, step.RunID, step.StepID, "STEP_SUCCEEDED", map[string]any{
-		"status": domain.StepSuccess,
-		"step":   step.Name,
-		"cost":   costUSD,
-	}); err != nil {
-		return err
-	}
-
-	// If TOOL finished -> move APPROVAL to WAITING_APPROVAL
-	if step.Name == domain.StepTool {
-		var approvalStepID uuid.UUID
-		err = tx.QueryRow(ctx, `
-			UPDATE steps
-			SET status=$2
-			WHERE run_id=$1
-			  AND name=$3
-			  AND status=$4
-			RETURNING id
-		`,
-			step.RunID,
-			domain.StepWaiting,
-			domain.StepApproval,
-			domain.StepPending,
-		).Scan(&approvalStepID)
-		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
-			return err
+	if len(s.Command) > 0 {
+		if w.sandbox == nil {
+			return nil, domain.CostDetail{}, execs.Permanent(errSandboxDisabled)
 		}
-
-		if err == nil {
-			if err := insertStepEvent(ctx, tx, step.RunID, approvalStepID, "STEP_WAITING_APPROVAL", map[string]any{
-				"status": domain.StepWaiting,
-				"step":   domain.StepApproval,
-			}); err != nil {
-				return err
-			}
-		}
-	}
-
-	// If all steps are SUCCEEDED -> mark run SUCCEEDED
-	var (
-		runTerminal   bool
-		webhookURL    sql.NullString
-		webhookSecret sql.NullString
-		runFinishedAt time.Time
-	)
-
-	err = tx.QueryRow(ctx, `
-		UPDATE runs r
-		SET status=$2, updated_at=NOW()
-		WHERE r.id=$1
-		  AND NOT EXISTS (
-			SELECT 1 FROM steps s
-			WHERE s.run_id=r.id AND s.status <> $3
-		  )
-		RETURNING r.webhook_url, r.webhook_secret, r.updated_at
-	`,
-		step.RunID,
-		domain.RunSuccess,
-		domain.StepSuccess,
-	).Scan(&webhookURL, &webhookSecret, &runFinishedAt)
-	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		return err
-	}
-	if err == nil {
-		runTerminal = true
-	}
-
-	if err := tx.Commit(ctx); err != nil {
-		return err
-	}
-
-	metrics.IncStepStatus(string(domain.StepSuccess))
-	if runTerminal {
-		metrics.IncRunStatus(string(domain.RunSuccess))
-		w.deliverTerminalWebhook(
-			ctx,
-			step.RunID,
-			domain.RunSuccess,
-			runFinishedAt.UTC(),
-			webhookURL.String,
-			webhookSecret.String,
-		)
-	}
-
-	w.logger.Info("step marked succeeded",
-		"api_key_id", w.apiKeyID,
-		"run_id", step.RunID,
-		"step_id", step.StepID,
-		"step", step.Name,
-		"cost_usd", costUSD,
-	)
-
-	return nil
-}
-
-// markStepFailed retries up to maxAttempts.
-// - if attempts < maxAttempts: set step back to PENDING (retry)
-// - else: set step FAILED and mark run FAILED
-func (w *Worker) markStepFailed(ctx context.Context, stepID uuid.UUID, execErr error) error {
-	tx, err := w.pool.Begin(ctx)
-	if err != nil {
-		return err
+		executor = w.sandbox
+		execCtx = execs.WithCommand(execCtx, s.Command)
 	}
-	defer tx.Rollback(ctx)
-
-	// Read attempts + run_id
-	var attempts int
-	var runID uuid.UUID
-
-	if err := tx.QueryRow(ctx, `
-		SELECT attempts, run_id
-		FROM steps
-		WHERE id=$1
-	`, stepID).Scan(&attempts, &runID); err != nil {
-		return err
-	}
-
-	payload, _ := json.Marshal(map[string]string{
-		"error": execErr.Error(),
-	})
-
-	// Retry if attempts < maxAttempts
-	if attempts < w.maxAttempts {
-		nextRunAt := time.Now().UTC().Add(backoffDelay(w.retryBaseDelay, attempts))
-
-		w.logger.Warn("step failed - retrying",
-			"step_id", stepID,
-			"run_id", runID,
-			"attempt", attempts,
-			"max_attempts", w.maxAttempts,
-			"next_run_at", nextRunAt,
-		)
-
-		_, err = tx.Exec(ctx, `
-			UPDATE steps
-			SET status=$2,
-			    output=$3::jsonb,
-			    next_run_at=$4,
-			    finished_at=NOW()
-			WHERE id=$1
-		`,
-			stepID,
-			domain.StepPending,
-			payload,
-			nextRunAt,
-		)
+	if s.HTTP != nil {
+		hosts, err := egress.ParseHostRules(s.EgressHosts)
 		if err != nil {
-			return err
+			return nil, domain.CostDetail{}, execs.Permanent(fmt.Errorf("egress allow hosts: %w", err))
 		}
-
-		if err := insertStepEvent(ctx, tx, runID, stepID, "STEP_FAILED_RETRY", map[string]any{
-			"status":       domain.StepPending,
-			"error":        execErr.Error(),
-			"attempt":      attempts,
-			"max_attempts": w.maxAttempts,
-			"next_run_at":  nextRunAt,
-		}); err != nil {
-			return err
+		executor = w.httpTool
+		execCtx = execs.WithHTTPRequest(execCtx, *s.HTTP)
+		execCtx = execs.WithTransport(execCtx, w.hostTransports.forHosts(hosts))
+	}
+	var masked []string
+	if len(s.Secrets) > 0 {
+		values, err := w.openStepSecrets(ctx, s.Secrets)
+		if err != nil {
+			return nil, domain.CostDetail{}, err
 		}
-
-		if err := tx.Commit(ctx); err != nil {
-			return err
+		masked = secretValues(values)
+		execCtx = execs.WithSecrets(execCtx, values)
+		execCtx = execs.WithLogger(execCtx, maskingLogger{next: execs.StepLogger(execCtx), values: masked})
+		if p, ok := execs.StepProgress(execCtx).(*stepProgress); ok {
+			p.maskSecrets(masked)
 		}
-
-		metrics.IncStepRetries()
-		w.logger.Info("retry scheduled",
-			"api_key_id", w.apiKeyID,
-			"step_id", stepID,
-			"run_id", runID,
-			"attempt", attempts,
-			"next_run_at", nextRunAt,
-		)
-		return nil
 	}
-
-	// Permanently fail
-	w.logger.Error("step permanently failed",
-		"step_id", stepID,
-		"run_id", runID,
-		"attempts", attempts,
-		"max_attempts", w.maxAttempts,
-	)
-
-	_, err = tx.Exec(ctx, `
-		UPDATE steps
-		SET status=$2,
-		    output=$3::jsonb,
-		    next_run_at=NULL,
-		    finished_at=NOW()
-		WHERE id=$1
-	`,
-		stepID,
-		domain.StepFailed,
-		payload,
-	)
-	if err != nil {
-		return err
-	}
-
-	if err := insertStepEvent(ctx, tx, runID, stepID, "STEP_FAILED", map[string]any{
-		"status":       domain.StepFailed,
-		"error":        execErr.Error(),
-		"attempt":      attempts,
-		"max_attempts": w.maxAttempts,
-	}); err != nil {
-		return err
-	}
-
-	var (
-		runTerminal   bool
-		webhookURL    sql.NullString
-		webhookSecret sql.NullString
-		runFinishedAt time.Time
-	)
-
-	err = tx.QueryRow(ctx, `
-		UPDATE runs
-		SET status=$2, updated_at=NOW()
-		WHERE id=$1
-		  AND status <> $2
-		RETURNING webhook_url, webhook_secret, updated_at
-	`,
-		runID,
-		domain.RunFailed,
-	).Scan(&webhookURL, &webhookSecret, &runFinishedAt)
-	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		return err
-	}
-	if err == nil {
-		runTerminal = true
-	}
-
-	if err := tx.Commit(ctx); err != nil {
-		return err
-	}
-
-	metrics.IncStepStatus(string(domain.StepFailed))
-	if runTerminal {
-		metrics.IncRunStatus(string(domain.RunFailed))
-		w.deliverTerminalWebhook(
-			ctx,
-			runID,
-			domain.RunFailed,
-			runFinishedAt.UTC(),
-			webhookURL.String,
-			webhookSecret.String,
-		)
+	cancel := func() {}
+	if s.Timeout > 0 {
+		execCtx, cancel = context.WithTimeout(execCtx, s.Timeout)
 	}
+	defer cancel()
 
-	w.logger.Error("step marked failed",
-		"api_key_id", w.apiKeyID,
-		"step_id", stepID,
-		"run_id", runID,
-		"attempts", attempts,
-	)
-
-	return nil
-}
-
-func backoffDelay(base time.Duration, attempts int) time.Duration {
-	if base <= 0 {
-		base = 2 * time.Second
-	}
-	if attempts <= 0 {
-		return base
+	out, cost, err := executor.Execute(execCtx, s.RunID)
+	if len(masked) > 0 {
+		out = secrets.MaskJSON(out, masked)
+		if err != nil {
+			err = &maskedError{err: err, msg: secrets.Mask(err.Error(), masked)}
+		}
 	}
-
-	delay := base
-	const maxDuration = time.Duration(1<<63 - 1)
-
-	for i := 0; i < attempts; i++ {
-		if delay > maxDuration/2 {
-			return maxDuration
+	if w.redactor != nil {
+		out = w.redactor.JSON(out)
+		if err != nil {
+			err = &maskedError{err: err, msg: w.redactor.String(err.Error())}
 		}
-		delay *= 2
 	}
-
-	return delay
+	return out, cost, err
 }
 
-func resolveStepTimeout(timeoutSeconds sql.NullInt64, defaultTimeout time.Duration) time.Duration {
-	if timeoutSeconds.Valid && timeoutSeconds.Int64 > 0 {
-		return time.Duration(timeoutSeconds.Int64) * time.Second
-	}
-
-	if defaultTimeout <= 0 {
-		return 30 * time.Second
+// markStepSucceeded stores step's output, cut to maxStepOutputBytes, and
+// settles it through the queue.
+func (w *Worker) markStepSucceeded(ctx context.Context, step domain.ClaimedStep, output json.RawMessage, cost domain.CostDetail, latency time.Duration) error {
+	originalBytes := len(output)
+	output, truncated := boundStepOutput(output, w.maxStepOutputBytes)
+	if truncated {
+		w.logger.Warn("step output truncated",
+			"run_id", step.RunID,
+			"step_id", step.StepID,
+			"output_bytes", originalBytes,
+			"max_bytes", w.maxStepOutputBytes,
+		)
 	}
-
-	return defaultTimeout
+	return w.queue.CompleteStep(ctx, step, domain.StepCompletion{
+		Output:          output,
+		OutputTruncated: truncated,
+		Cost:            cost,
+		Latency:         latency,
+	})
 }
 
-func insertStepEvent(
-	ctx context.Context,
-	tx pgx.Tx,
-	runID uuid.UUID,
-	stepID uuid.UUID,
-	eventType string,
-	payload any,
-) error {
-	payloadJSON, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	_, err = tx.Exec(ctx, `
-		INSERT INTO events (id, run_id, step_id, type, payload)
-		VALUES ($1, $2, $3, $4, $5::jsonb)
-	`,
-		uuid.New(),
-		runID,
-		stepID,
-		eventType,
-		payloadJSON,
-	)
-	return err
+// markStepFailed settles a failed step through the queue, which retries it
+// under the worker's --max-attempts, --retry-base-delay, and
+// --retry-priority unless the step overrides them or execErr is an
+// executors.PermanentError.
+func (w *Worker) markStepFailed(ctx context.Context, step domain.ClaimedStep, execErr error, cost domain.CostDetail, latency time.Duration) error {
+	return w.queue.FailStep(ctx, step, domain.StepFailure{
+		Err:       execErr,
+		Permanent: execs.IsPermanent(execErr),
+		Cost:      cost,
+		Latency:   latency,
+		Retry: domain.RetryPolicy{
+			MaxAttempts: w.maxAttempts,
+			BaseDelay:   w.retryBaseDelay,
+			Backoff:     domain.RetryBackoffExponential,
+			Priority:    w.retryPriority,
+		},
+	})
 }
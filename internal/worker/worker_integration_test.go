@@ -7,19 +7,30 @@ package worker
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"io"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/adiadia/agent-runtime/internal/auth"
+	"github.com/adiadia/agent-runtime/internal/clock"
 	"github.com/adiadia/agent-runtime/internal/domain"
 	"github.com/adiadia/agent-runtime/internal/repository"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	execs "github.com/adiadia/agent-runtime/internal/worker/executors"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -140,6 +151,865 @@ func TestWorkerSchedulesExponentialBackoffRetry(t *testing.T) {
 	}
 }
 
+func TestWorkerRetryAndReclaimFollowInjectedClock(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runID, err := repository.NewRunRepository(pool, logger).CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	// Run the fake clock a day ahead so nothing becomes due by wall time.
+	start := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Second)
+	clk := clock.NewFake(start)
+	w := New(Deps{
+		Pool:           pool,
+		Logger:         logger,
+		Clock:          clk,
+		APIKeyID:       apiKeyID,
+		ReclaimAfter:   5 * time.Minute,
+		MaxAttempts:    4,
+		RetryBaseDelay: time.Second,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM: failingExecutor{err: errors.New("boom")},
+	}
+
+	readStep := func() (int, time.Time) {
+		t.Helper()
+		var (
+			attempts  int
+			nextRunAt *time.Time
+		)
+		if err := pool.QueryRow(ctx,
+			`SELECT attempts, next_run_at FROM steps WHERE run_id=$1 AND name=$2`,
+			runID, domain.StepLLM,
+		).Scan(&attempts, &nextRunAt); err != nil {
+			t.Fatalf("read step: %v", err)
+		}
+		if nextRunAt == nil {
+			return attempts, time.Time{}
+		}
+		return attempts, *nextRunAt
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once #1: %v", err)
+	}
+	attempts, nextRunAt := readStep()
+	if attempts != 1 || !nextRunAt.Equal(start.Add(2*time.Second)) {
+		t.Fatalf("expected attempt 1 due at %s, got attempt %d due at %s", start.Add(2*time.Second), attempts, nextRunAt)
+	}
+	// The failed attempt is stamped from the worker's clock, not the
+	// database's.
+	var finishedAt time.Time
+	if err := pool.QueryRow(ctx,
+		`SELECT finished_at FROM steps WHERE run_id=$1 AND name=$2`,
+		runID, domain.StepLLM,
+	).Scan(&finishedAt); err != nil {
+		t.Fatalf("read finished_at: %v", err)
+	}
+	if !finishedAt.Equal(start) {
+		t.Fatalf("expected finished_at %s, got %s", start, finishedAt)
+	}
+
+	clk.Advance(time.Second)
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once before due: %v", err)
+	}
+	if attempts, _ := readStep(); attempts != 1 {
+		t.Fatalf("expected retry to wait for the clock, got attempts=%d", attempts)
+	}
+
+	clk.Advance(time.Second)
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once #2: %v", err)
+	}
+	attempts, nextRunAt = readStep()
+	if attempts != 2 || !nextRunAt.Equal(start.Add(6*time.Second)) {
+		t.Fatalf("expected attempt 2 due at %s, got attempt %d due at %s", start.Add(6*time.Second), attempts, nextRunAt)
+	}
+
+	// A step left RUNNING by a dead worker is reclaimed once its lease,
+	// reclaimAfter long, expires.
+	if _, err := pool.Exec(ctx,
+		`UPDATE steps SET status=$3, next_run_at=NULL, claimed_by=$5, lease_expires_at=$4 WHERE run_id=$1 AND name=$2`,
+		runID, domain.StepLLM, domain.StepRunning, clk.Now().Add(5*time.Minute), uuid.New(),
+	); err != nil {
+		t.Fatalf("simulate stuck step: %v", err)
+	}
+	clk.Advance(4 * time.Minute)
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once before reclaim: %v", err)
+	}
+	if attempts, _ := readStep(); attempts != 2 {
+		t.Fatalf("expected stuck step not to be reclaimed yet, got attempts=%d", attempts)
+	}
+
+	clk.Advance(2 * time.Minute)
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once after reclaim window: %v", err)
+	}
+	if attempts, _ := readStep(); attempts != 3 {
+		t.Fatalf("expected stuck step to be reclaimed, got attempts=%d", attempts)
+	}
+
+	// The reclaim continues the second execution, so its execution key is
+	// unchanged.
+	var executionAttempt int
+	if err := pool.QueryRow(ctx,
+		`SELECT execution_attempt FROM steps WHERE run_id=$1 AND name=$2`,
+		runID, domain.StepLLM,
+	).Scan(&executionAttempt); err != nil {
+		t.Fatalf("read execution attempt: %v", err)
+	}
+	if executionAttempt != 2 {
+		t.Fatalf("expected the reclaim to keep execution attempt 2, got %d", executionAttempt)
+	}
+}
+
+func TestWorkerAppliesTemplateStepRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	templateID := uuid.New()
+	templateName := "retry-template-" + uuid.NewString()
+	if _, err := pool.Exec(ctx, `INSERT INTO workflow_templates (id, name) VALUES ($1, $2)`, templateID, templateName); err != nil {
+		t.Fatalf("insert workflow template: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		INSERT INTO workflow_template_steps (id, template_id, position, name, max_attempts, retry_base_delay_ms, retry_backoff)
+		VALUES ($1, $2, 1, $3, 2, 500, 'fixed')
+	`, uuid.New(), templateID, domain.StepLLM); err != nil {
+		t.Fatalf("insert workflow template step: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runID, err := repository.NewRunRepository(pool, logger).CreateRun(tenantCtx, domain.CreateRunParams{TemplateName: templateName})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	start := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Second)
+	clk := clock.NewFake(start)
+	w := New(Deps{
+		Pool:           pool,
+		Logger:         logger,
+		Clock:          clk,
+		APIKeyID:       apiKeyID,
+		MaxAttempts:    5,
+		RetryBaseDelay: 10 * time.Second,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM: failingExecutor{err: errors.New("boom")},
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once #1: %v", err)
+	}
+	var nextRunAt time.Time
+	if err := pool.QueryRow(ctx,
+		`SELECT next_run_at FROM steps WHERE run_id=$1 AND name=$2`,
+		runID, domain.StepLLM,
+	).Scan(&nextRunAt); err != nil {
+		t.Fatalf("read step: %v", err)
+	}
+	if want := start.Add(500 * time.Millisecond); !nextRunAt.Equal(want) {
+		t.Fatalf("expected fixed 500ms retry due at %s, got %s", want, nextRunAt)
+	}
+
+	clk.Advance(time.Second)
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once #2: %v", err)
+	}
+	var (
+		status   domain.StepStatus
+		attempts int
+	)
+	if err := pool.QueryRow(ctx,
+		`SELECT status, attempts FROM steps WHERE run_id=$1 AND name=$2`,
+		runID, domain.StepLLM,
+	).Scan(&status, &attempts); err != nil {
+		t.Fatalf("read step: %v", err)
+	}
+	if status != domain.StepFailed || attempts != 2 {
+		t.Fatalf("expected step FAILED after the template's 2 attempts, got %s after %d", status, attempts)
+	}
+}
+
+func TestWorkerRetryPriorityMovesRetriesAheadOfNewWork(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	templateID := uuid.New()
+	templateName := "critical-template-" + uuid.NewString()
+	if _, err := pool.Exec(ctx, `INSERT INTO workflow_templates (id, name) VALUES ($1, $2)`, templateID, templateName); err != nil {
+		t.Fatalf("insert workflow template: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		INSERT INTO workflow_template_steps (id, template_id, position, name, retry_base_delay_ms, retry_backoff, retry_priority)
+		VALUES ($1, $2, 1, $3, 500, 'fixed', 20)
+	`, uuid.New(), templateID, domain.StepLLM); err != nil {
+		t.Fatalf("insert workflow template step: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+	criticalRun, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{TemplateName: templateName})
+	if err != nil {
+		t.Fatalf("create critical run: %v", err)
+	}
+
+	start := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Second)
+	clk := clock.NewFake(start)
+	w := New(Deps{
+		Pool:        pool,
+		Logger:      logger,
+		Clock:       clk,
+		APIKeyID:    apiKeyID,
+		MaxAttempts: 5,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM: failingExecutor{err: errors.New("boom")},
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once #1: %v", err)
+	}
+
+	var payload []byte
+	if err := pool.QueryRow(ctx, `
+		SELECT payload FROM events WHERE run_id=$1 AND type=$2
+	`, criticalRun, domain.EventStepFailedRetry).Scan(&payload); err != nil {
+		t.Fatalf("read retry event: %v", err)
+	}
+	var retry struct {
+		PriorityBoost int `json:"priority_boost"`
+		Priority      int `json:"priority"`
+	}
+	if err := json.Unmarshal(payload, &retry); err != nil {
+		t.Fatalf("decode retry event: %v", err)
+	}
+	if retry.PriorityBoost != 20 || retry.Priority != 20 {
+		t.Fatalf("expected the retry recorded at priority 20, got %+v", retry)
+	}
+
+	// New work at priority 10 arrives while the retry waits.
+	newRun, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{Priority: 10})
+	if err != nil {
+		t.Fatalf("create new run: %v", err)
+	}
+
+	clk.Advance(time.Second)
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once #2: %v", err)
+	}
+
+	var attempts, boost int
+	if err := pool.QueryRow(ctx,
+		`SELECT attempts, priority_boost FROM steps WHERE run_id=$1 AND name=$2`,
+		criticalRun, domain.StepLLM,
+	).Scan(&attempts, &boost); err != nil {
+		t.Fatalf("read critical step: %v", err)
+	}
+	if attempts != 2 || boost != 40 {
+		t.Fatalf("expected the boosted retry claimed first, got attempts=%d boost=%d", attempts, boost)
+	}
+	var newStatus domain.StepStatus
+	if err := pool.QueryRow(ctx,
+		`SELECT status FROM steps WHERE run_id=$1 AND name=$2`,
+		newRun, domain.StepLLM,
+	).Scan(&newStatus); err != nil {
+		t.Fatalf("read new step: %v", err)
+	}
+	if newStatus != domain.StepPending {
+		t.Fatalf("expected the new run to wait behind the retry, got %s", newStatus)
+	}
+}
+
+func TestWorkerFailsFastOnPermanentError(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:        pool,
+		Logger:      logger,
+		APIKeyID:    apiKeyID,
+		MaxAttempts: 4,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM: failingExecutor{err: execs.HTTPStatusError(http.StatusBadRequest, errors.New("tool rejected input"))},
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once: %v", err)
+	}
+
+	var (
+		status   domain.StepStatus
+		attempts int
+	)
+	if err := pool.QueryRow(ctx,
+		`SELECT status, attempts FROM steps WHERE run_id=$1 AND name=$2`,
+		runID, domain.StepLLM,
+	).Scan(&status, &attempts); err != nil {
+		t.Fatalf("read step: %v", err)
+	}
+	if status != domain.StepFailed || attempts != 1 {
+		t.Fatalf("expected step FAILED after one attempt, got %s after %d", status, attempts)
+	}
+
+	runStatus, err := runRepo.GetRun(tenantCtx, runID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	if runStatus != domain.RunFailed {
+		t.Fatalf("expected run FAILED, got %s", runStatus)
+	}
+
+	var permanent bool
+	if err := pool.QueryRow(ctx,
+		`SELECT (payload->>'permanent')::boolean FROM events WHERE run_id=$1 AND type=$2`,
+		runID, domain.EventStepFailed,
+	).Scan(&permanent); err != nil {
+		t.Fatalf("read STEP_FAILED event: %v", err)
+	}
+	if !permanent {
+		t.Fatal("expected STEP_FAILED event to be marked permanent")
+	}
+}
+
+func TestWorkerTruncatesOversizedStepOutput(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runID, err := repository.NewRunRepository(pool, logger).CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:               pool,
+		Logger:             logger,
+		APIKeyID:           apiKeyID,
+		MaxStepOutputBytes: 256,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM: &fakeExecutor{output: json.RawMessage(`{"text":"` + strings.Repeat("a", 4096) + `"}`)},
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once: %v", err)
+	}
+
+	var (
+		status        domain.StepStatus
+		truncated     bool
+		originalBytes int
+		outputBytes   int
+	)
+	if err := pool.QueryRow(ctx, `
+		SELECT status, (output->>'truncated')::boolean, (output->>'original_bytes')::int, length(output::text)
+		FROM steps WHERE run_id=$1 AND name=$2
+	`, runID, domain.StepLLM).Scan(&status, &truncated, &originalBytes, &outputBytes); err != nil {
+		t.Fatalf("read step: %v", err)
+	}
+	if status != domain.StepSuccess || !truncated || originalBytes != 4107 || outputBytes > 300 {
+		t.Fatalf("expected SUCCESS with a truncated stand-in, got %s truncated=%v original_bytes=%d output_bytes=%d", status, truncated, originalBytes, outputBytes)
+	}
+
+	var flagged bool
+	if err := pool.QueryRow(ctx,
+		`SELECT (payload->>'output_truncated')::boolean FROM events WHERE run_id=$1 AND type=$2`,
+		runID, domain.EventStepSucceeded,
+	).Scan(&flagged); err != nil {
+		t.Fatalf("read STEP_SUCCEEDED event: %v", err)
+	}
+	if !flagged {
+		t.Fatal("expected STEP_SUCCEEDED event to flag the truncated output")
+	}
+}
+
+func TestWorkerSkipsStepTypeWithOpenCircuit(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runID, err := repository.NewRunRepository(pool, logger).CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	start := time.Now().UTC().Truncate(time.Second)
+	clk := clock.NewFake(start)
+	w := New(Deps{
+		Pool:           pool,
+		Logger:         logger,
+		Clock:          clk,
+		APIKeyID:       apiKeyID,
+		MaxAttempts:    5,
+		RetryBaseDelay: time.Millisecond,
+		Breaker:        &BreakerConfig{FailureRate: 1, MinRequests: 1, Cooldown: time.Minute},
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM: failingExecutor{err: errors.New("provider down")},
+	}
+
+	readAttempts := func() int {
+		t.Helper()
+		var attempts int
+		if err := pool.QueryRow(ctx,
+			`SELECT attempts FROM steps WHERE run_id=$1 AND name=$2`,
+			runID, domain.StepLLM,
+		).Scan(&attempts); err != nil {
+			t.Fatalf("read step: %v", err)
+		}
+		return attempts
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once #1: %v", err)
+	}
+	clk.Advance(time.Second)
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once while open: %v", err)
+	}
+	if got := readAttempts(); got != 1 {
+		t.Fatalf("expected the open breaker to stop claims, got attempts=%d", got)
+	}
+
+	clk.Advance(time.Minute)
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once after cooldown: %v", err)
+	}
+	if got := readAttempts(); got != 2 {
+		t.Fatalf("expected one probe claim after the cooldown, got attempts=%d", got)
+	}
+}
+
+func TestWorkerRunsCommandStepInSandbox(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	templateID := uuid.New()
+	templateName := "command-template-" + uuid.NewString()
+	if _, err := pool.Exec(ctx, `INSERT INTO workflow_templates (id, name) VALUES ($1, $2)`, templateID, templateName); err != nil {
+		t.Fatalf("insert workflow template: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		INSERT INTO workflow_template_steps (id, template_id, position, name, command)
+		VALUES ($1, $2, 1, $3, $4)
+	`, uuid.New(), templateID, domain.StepTool, []string{"echo", "sandboxed"}); err != nil {
+		t.Fatalf("insert workflow template step: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+
+	readStep := func(runID uuid.UUID) (domain.StepStatus, int, []byte) {
+		t.Helper()
+		var (
+			status   domain.StepStatus
+			attempts int
+			output   []byte
+		)
+		if err := pool.QueryRow(ctx,
+			`SELECT status, attempts, output FROM steps WHERE run_id=$1 AND name=$2`,
+			runID, domain.StepTool,
+		).Scan(&status, &attempts, &output); err != nil {
+			t.Fatalf("read step: %v", err)
+		}
+		return status, attempts, output
+	}
+
+	// Without a sandbox the command step fails on its first attempt.
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{TemplateName: templateName})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	w := New(Deps{Pool: pool, Logger: logger, APIKeyID: apiKeyID})
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once without sandbox: %v", err)
+	}
+	if status, attempts, _ := readStep(runID); status != domain.StepFailed || attempts != 1 {
+		t.Fatalf("expected command step FAILED without a sandbox, got %s after %d", status, attempts)
+	}
+
+	runID, err = runRepo.CreateRun(tenantCtx, domain.CreateRunParams{TemplateName: templateName})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	w = New(Deps{
+		Pool:     pool,
+		Logger:   logger,
+		APIKeyID: apiKeyID,
+		Sandbox:  &execs.SandboxConfig{AllowedBinaries: []string{"echo"}},
+	})
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once with sandbox: %v", err)
+	}
+	status, _, output := readStep(runID)
+	if status != domain.StepSuccess {
+		t.Fatalf("expected command step SUCCEEDED, got %s", status)
+	}
+	var payload struct {
+		Stdout string `json:"stdout"`
+	}
+	if err := json.Unmarshal(output, &payload); err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if payload.Stdout != "sandboxed\n" {
+		t.Fatalf("expected captured stdout, got %q", payload.Stdout)
+	}
+}
+
+func TestWorkerRunsHTTPStepWithinEgressAllowList(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"pong":true}`)
+	}))
+	defer srv.Close()
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	templateID := uuid.New()
+	templateName := "http-template-" + uuid.NewString()
+	if _, err := pool.Exec(ctx, `INSERT INTO workflow_templates (id, name) VALUES ($1, $2)`, templateID, templateName); err != nil {
+		t.Fatalf("insert workflow template: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		INSERT INTO workflow_template_steps (id, template_id, position, name, http)
+		VALUES ($1, $2, 1, $3, $4)
+	`, uuid.New(), templateID, domain.StepTool, &domain.HTTPRequest{Method: http.MethodGet, URL: srv.URL + "/ping"}); err != nil {
+		t.Fatalf("insert workflow template step: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+	// The test server listens on loopback, which HTTP steps are refused by
+	// default.
+	w := New(Deps{Pool: pool, Logger: logger, APIKeyID: apiKeyID, HTTPStepAllowPrivate: true})
+
+	run := func(w *Worker, allowHosts []string) (uuid.UUID, domain.StepStatus, int, []byte) {
+		t.Helper()
+		if _, err := pool.Exec(ctx, `UPDATE api_keys SET egress_allow_hosts=$2 WHERE id=$1`, apiKeyID, allowHosts); err != nil {
+			t.Fatalf("set egress allow hosts: %v", err)
+		}
+		runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{TemplateName: templateName})
+		if err != nil {
+			t.Fatalf("create run: %v", err)
+		}
+		if err := w.ProcessOnce(ctx); err != nil {
+			t.Fatalf("process once: %v", err)
+		}
+		var (
+			status   domain.StepStatus
+			attempts int
+			output   []byte
+		)
+		if err := pool.QueryRow(ctx,
+			`SELECT status, attempts, output FROM steps WHERE run_id=$1 AND name=$2`,
+			runID, domain.StepTool,
+		).Scan(&status, &attempts, &output); err != nil {
+			t.Fatalf("read step: %v", err)
+		}
+		return runID, status, attempts, output
+	}
+
+	_, status, _, output := run(w, []string{"127.0.0.1"})
+	if status != domain.StepSuccess {
+		t.Fatalf("expected the allowed HTTP step SUCCEEDED, got %s: %s", status, output)
+	}
+	var payload struct {
+		Status int             `json:"status"`
+		Body   json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal(output, &payload); err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if payload.Status != http.StatusOK || string(payload.Body) != `{"pong":true}` {
+		t.Fatalf("unexpected output %s", output)
+	}
+
+	// A destination outside the list fails on its first attempt and is
+	// recorded as a security event.
+	runID, status, attempts, _ := run(w, []string{"api.example.com:443"})
+	if status != domain.StepFailed || attempts != 1 {
+		t.Fatalf("expected the blocked HTTP step FAILED after one attempt, got %s after %d", status, attempts)
+	}
+	var blocked int
+	if err := pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM events WHERE run_id=$1 AND type=$2`,
+		runID, domain.EventStepEgressBlocked,
+	).Scan(&blocked); err != nil {
+		t.Fatalf("count egress events: %v", err)
+	}
+	if blocked != 1 {
+		t.Fatalf("expected one %s event, got %d", domain.EventStepEgressBlocked, blocked)
+	}
+
+	// Without the opt-out a listed loopback address is still refused.
+	private := New(Deps{Pool: pool, Logger: logger, APIKeyID: apiKeyID})
+	if _, status, attempts, _ := run(private, []string{"127.0.0.1"}); status != domain.StepFailed || attempts != 1 {
+		t.Fatalf("expected the loopback HTTP step FAILED after one attempt, got %s after %d", status, attempts)
+	}
+}
+
+func TestWorkerStoresExecutorLogLines(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runID, err := repository.NewRunRepository(pool, logger).CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	w := New(Deps{Pool: pool, Logger: logger, APIKeyID: apiKeyID})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM: loggingExecutor{lines: []string{"calling model", "got 42 tokens"}},
+	}
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once: %v", err)
+	}
+
+	var stepID uuid.UUID
+	if err := pool.QueryRow(ctx,
+		`SELECT id FROM steps WHERE run_id=$1 AND name=$2`,
+		runID, domain.StepLLM,
+	).Scan(&stepID); err != nil {
+		t.Fatalf("read step: %v", err)
+	}
+
+	page, err := repository.NewStepRepository(pool, logger).ListStepLogs(tenantCtx, runID, stepID, 0, 10)
+	if err != nil {
+		t.Fatalf("list step logs: %v", err)
+	}
+	if page.StepStatus != domain.StepSuccess || len(page.Lines) != 2 {
+		t.Fatalf("expected two lines of a SUCCEEDED step, got %+v", page)
+	}
+	if page.Lines[0].Line != "calling model" || page.Lines[1].Line != "got 42 tokens" || page.Lines[0].Attempt != 1 {
+		t.Fatalf("unexpected log lines: %+v", page.Lines)
+	}
+
+	rest, err := repository.NewStepRepository(pool, logger).ListStepLogs(tenantCtx, runID, stepID, page.Lines[1].Seq, 10)
+	if err != nil || len(rest.Lines) != 0 {
+		t.Fatalf("expected no lines after the cursor, got %+v (%v)", rest.Lines, err)
+	}
+}
+
+func TestWorkerLeasesClaimedStep(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if _, err := repository.NewRunRepository(pool, logger).CreateRun(tenantCtx, domain.CreateRunParams{}); err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	start := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Second)
+	clk := clock.NewFake(start)
+	workerID := uuid.New()
+	w := New(Deps{
+		Pool:         pool,
+		Logger:       logger,
+		Clock:        clk,
+		APIKeyID:     apiKeyID,
+		WorkerID:     workerID,
+		ReclaimAfter: time.Minute,
+	})
+
+	step, err := w.queue.ClaimStep(ctx, w.claimRequest())
+	if err != nil {
+		t.Fatalf("claim step: %v", err)
+	}
+
+	readLease := func() (*uuid.UUID, *time.Time) {
+		t.Helper()
+		var (
+			claimedBy      *uuid.UUID
+			leaseExpiresAt *time.Time
+		)
+		if err := pool.QueryRow(ctx,
+			`SELECT claimed_by, lease_expires_at FROM steps WHERE id=$1`,
+			step.StepID,
+		).Scan(&claimedBy, &leaseExpiresAt); err != nil {
+			t.Fatalf("read lease: %v", err)
+		}
+		return claimedBy, leaseExpiresAt
+	}
+
+	claimedBy, expires := readLease()
+	if claimedBy == nil || *claimedBy != workerID || expires == nil || !expires.Equal(start.Add(time.Minute)) {
+		t.Fatalf("expected lease for %s until %s, got %v until %v", workerID, start.Add(time.Minute), claimedBy, expires)
+	}
+
+	clk.Advance(30 * time.Second)
+	held, err := w.renewLease(ctx, step)
+	if err != nil || !held {
+		t.Fatalf("expected lease renewed, got held=%v err=%v", held, err)
+	}
+	if _, expires := readLease(); expires == nil || !expires.Equal(start.Add(90*time.Second)) {
+		t.Fatalf("expected lease extended to %s, got %v", start.Add(90*time.Second), expires)
+	}
+
+	// Once another worker reclaims the step, the lease is lost and the
+	// newer claim owns the result.
+	if _, err := pool.Exec(ctx, `UPDATE steps SET claimed_by=$2, claim_token=$3 WHERE id=$1`, step.StepID, uuid.New(), uuid.New()); err != nil {
+		t.Fatalf("simulate reclaim: %v", err)
+	}
+	if held, err := w.renewLease(ctx, step); err != nil || held {
+		t.Fatalf("expected lease lost, got held=%v err=%v", held, err)
+	}
+	if err := w.markStepSucceeded(ctx, step, json.RawMessage(`{}`), domain.CostDetail{}, 0); !errors.Is(err, domain.ErrStaleClaim) {
+		t.Fatalf("expected stale success rejected, got %v", err)
+	}
+	if err := w.markStepFailed(ctx, step, errors.New("boom"), domain.CostDetail{}, 0); !errors.Is(err, domain.ErrStaleClaim) {
+		t.Fatalf("expected stale failure rejected, got %v", err)
+	}
+	var status domain.StepStatus
+	if err := pool.QueryRow(ctx, `SELECT status FROM steps WHERE id=$1`, step.StepID).Scan(&status); err != nil {
+		t.Fatalf("read step status: %v", err)
+	}
+	if status != domain.StepRunning {
+		t.Fatalf("expected stale results discarded, got step %s", status)
+	}
+	if _, expires := readLease(); expires == nil {
+		t.Fatal("expected the newer claim's lease kept")
+	}
+
+	// The claim holding the token settles the step.
+	if _, err := pool.Exec(ctx, `UPDATE steps SET claim_token=$2 WHERE id=$1`, step.StepID, step.ClaimToken); err != nil {
+		t.Fatalf("restore claim: %v", err)
+	}
+	if err := w.markStepSucceeded(ctx, step, json.RawMessage(`{}`), domain.CostDetail{}, 0); err != nil {
+		t.Fatalf("mark step succeeded: %v", err)
+	}
+	if _, expires := readLease(); expires != nil {
+		t.Fatalf("expected lease cleared on settle, got %v", expires)
+	}
+}
+
 func TestWorkerUsesDefaultStepTimeoutWhenDBTimeoutIsNull(t *testing.T) {
 	ctx := context.Background()
 	pool := workerIntegrationPool(t, ctx)
@@ -157,58 +1027,1401 @@ func TestWorkerUsesDefaultStepTimeoutWhenDBTimeoutIsNull(t *testing.T) {
 	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	runRepo := repository.NewRunRepository(pool, logger)
-
-	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
-	if err != nil {
-		t.Fatalf("create run: %v", err)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:               pool,
+		Logger:             logger,
+		APIKeyID:           apiKeyID,
+		ReclaimAfter:       5 * time.Minute,
+		MaxAttempts:        3,
+		RetryBaseDelay:     1 * time.Second,
+		DefaultStepTimeout: 100 * time.Millisecond,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM: timeoutExecutor{},
+	}
+
+	start := time.Now()
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected default timeout to stop step quickly, elapsed=%s", elapsed)
+	}
+
+	var (
+		status    domain.StepStatus
+		attempts  int
+		nextRunAt time.Time
+	)
+	if err := pool.QueryRow(ctx, `
+		SELECT status, attempts, next_run_at
+		FROM steps
+		WHERE run_id=$1 AND name=$2
+	`, runID, domain.StepLLM).Scan(&status, &attempts, &nextRunAt); err != nil {
+		t.Fatalf("query step after timeout: %v", err)
+	}
+
+	if status != domain.StepPending {
+		t.Fatalf("expected timed-out step to be retried as %s, got %s", domain.StepPending, status)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected attempts=1 after timeout, got %d", attempts)
+	}
+	if !nextRunAt.After(time.Now().UTC()) {
+		t.Fatalf("expected next_run_at to be scheduled in future, got %s", nextRunAt)
+	}
+}
+
+func TestWorkerDiscardsResultForRunCanceledMidStep(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:           pool,
+		Logger:         logger,
+		APIKeyID:       apiKeyID,
+		ReclaimAfter:   5 * time.Minute,
+		MaxAttempts:    3,
+		RetryBaseDelay: time.Second,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM: cancelingExecutor{cancel: func() error { return runRepo.CancelRun(tenantCtx, runID, "") }},
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once: %v", err)
+	}
+
+	var runStatus domain.RunStatus
+	if err := pool.QueryRow(ctx, `SELECT status FROM runs WHERE id=$1`, runID).Scan(&runStatus); err != nil {
+		t.Fatalf("query run status: %v", err)
+	}
+	if runStatus != domain.RunCanceled {
+		t.Fatalf("expected run to stay %s, got %s", domain.RunCanceled, runStatus)
+	}
+
+	var stepStatus domain.StepStatus
+	if err := pool.QueryRow(ctx,
+		`SELECT status FROM steps WHERE run_id=$1 AND name=$2`,
+		runID, domain.StepLLM,
+	).Scan(&stepStatus); err != nil {
+		t.Fatalf("query step status: %v", err)
+	}
+	if stepStatus != domain.StepCanceled {
+		t.Fatalf("expected step to stay %s, got %s", domain.StepCanceled, stepStatus)
+	}
+
+	var succeededEvents int
+	if err := pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM events WHERE run_id=$1 AND type=$2`,
+		runID, domain.EventStepSucceeded,
+	).Scan(&succeededEvents); err != nil {
+		t.Fatalf("count succeeded events: %v", err)
+	}
+	if succeededEvents != 0 {
+		t.Fatalf("expected no %s event for a canceled step, got %d", domain.EventStepSucceeded, succeededEvents)
+	}
+}
+
+func TestWorkerInterruptsStepOfCanceledRun(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:                pool,
+		Logger:              logger,
+		APIKeyID:            apiKeyID,
+		CancelCheckInterval: 10 * time.Millisecond,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM: cancelAndBlockExecutor{cancel: func() error { return runRepo.CancelRun(tenantCtx, runID, "") }},
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once: %v", err)
+	}
+
+	var (
+		stepStatus     domain.StepStatus
+		leaseExpiresAt *time.Time
+	)
+	if err := pool.QueryRow(ctx,
+		`SELECT status, lease_expires_at FROM steps WHERE run_id=$1 AND name=$2`,
+		runID, domain.StepLLM,
+	).Scan(&stepStatus, &leaseExpiresAt); err != nil {
+		t.Fatalf("query step: %v", err)
+	}
+	if stepStatus != domain.StepCanceled || leaseExpiresAt != nil {
+		t.Fatalf("expected step %s without a lease, got %s lease=%v", domain.StepCanceled, stepStatus, leaseExpiresAt)
+	}
+
+	var interrupted bool
+	if err := pool.QueryRow(ctx,
+		`SELECT (payload->>'interrupted')::boolean FROM events WHERE run_id=$1 AND type=$2`,
+		runID, domain.EventStepCanceled,
+	).Scan(&interrupted); err != nil {
+		t.Fatalf("read %s event: %v", domain.EventStepCanceled, err)
+	}
+	if !interrupted {
+		t.Fatalf("expected an interrupted %s event", domain.EventStepCanceled)
+	}
+
+	var retries int
+	if err := pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM events WHERE run_id=$1 AND type=$2`,
+		runID, domain.EventStepFailedRetry,
+	).Scan(&retries); err != nil {
+		t.Fatalf("count retry events: %v", err)
+	}
+	if retries != 0 {
+		t.Fatalf("expected the interrupted step not to be retried, got %d retries", retries)
+	}
+}
+
+func TestDedicatedWorkerStaysWithinTenant(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyA, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key A: %v", err)
+	}
+	apiKeyB, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key B: %v", err)
+	}
+
+	ctxA := auth.WithAPIKeyID(ctx, apiKeyA)
+	ctxB := auth.WithAPIKeyID(ctx, apiKeyB)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+
+	runA, err := runRepo.CreateRun(ctxA, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run for key A: %v", err)
+	}
+	runB, err := runRepo.CreateRun(ctxB, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run for key B: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:         pool,
+		Logger:       logger,
+		APIKeyID:     apiKeyA,
+		ReclaimAfter: 5 * time.Minute,
+		MaxAttempts:  3,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM:  staticExecutor{payload: json.RawMessage(`{"ok":"llm"}`)},
+		domain.StepTool: staticExecutor{payload: json.RawMessage(`{"ok":"tool"}`)},
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once #1: %v", err)
+	}
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once #2: %v", err)
+	}
+
+	var processedA int
+	if err := pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM steps
+		WHERE run_id=$1 AND status <> $2
+	`, runA, domain.StepPending).Scan(&processedA); err != nil {
+		t.Fatalf("query tenant A steps: %v", err)
+	}
+	if processedA == 0 {
+		t.Fatal("expected dedicated worker to process at least one step for tenant A")
+	}
+
+	var processedB int
+	if err := pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM steps
+		WHERE run_id=$1 AND status <> $2
+	`, runB, domain.StepPending).Scan(&processedB); err != nil {
+		t.Fatalf("query tenant B steps: %v", err)
+	}
+	if processedB != 0 {
+		t.Fatalf("expected tenant B steps to remain pending, got %d processed", processedB)
+	}
+
+	var claimedEvents int
+	if err := pool.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM events
+		WHERE run_id=$1 AND type='STEP_CLAIMED'
+	`, runA).Scan(&claimedEvents); err != nil {
+		t.Fatalf("query claimed events: %v", err)
+	}
+	if claimedEvents == 0 {
+		t.Fatal("expected at least one STEP_CLAIMED event for tenant A run")
+	}
+
+	var successEvents int
+	if err := pool.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM events
+		WHERE run_id=$1 AND type='STEP_SUCCEEDED'
+	`, runA).Scan(&successEvents); err != nil {
+		t.Fatalf("query success events: %v", err)
+	}
+	if successEvents == 0 {
+		t.Fatal("expected at least one STEP_SUCCEEDED event for tenant A run")
+	}
+
+	var waitingEvents int
+	if err := pool.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM events
+		WHERE run_id=$1 AND type='STEP_WAITING_APPROVAL'
+	`, runA).Scan(&waitingEvents); err != nil {
+		t.Fatalf("query waiting approval events: %v", err)
+	}
+	if waitingEvents != 1 {
+		t.Fatalf("expected exactly one STEP_WAITING_APPROVAL event for tenant A run, got %d", waitingEvents)
+	}
+}
+
+func TestWorkerTracksStepAndRunCosts(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	// Pre-approve so worker can complete the run after TOOL succeeds.
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps
+		SET status=$2
+		WHERE run_id=$1 AND name=$3
+	`, runID, domain.StepSuccess, domain.StepApproval); err != nil {
+		t.Fatalf("pre-approve run: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:         pool,
+		Logger:       logger,
+		APIKeyID:     apiKeyID,
+		ReclaimAfter: 5 * time.Minute,
+		MaxAttempts:  3,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM: staticExecutor{payload: json.RawMessage(`{"ok":"llm"}`), cost: domain.CostDetail{
+			Provider:         "openai",
+			Model:            "gpt-4o",
+			PromptTokens:     1000,
+			CompletionTokens: 250,
+			UnitPriceUSD:     0.001,
+			CostUSD:          1.25,
+		}},
+		domain.StepTool: staticExecutor{payload: json.RawMessage(`{"ok":"tool"}`), cost: domain.CostDetail{Tool: "search", CostUSD: 0.75}},
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process llm step: %v", err)
+	}
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process tool step: %v", err)
+	}
+
+	var llmCost float64
+	if err := pool.QueryRow(ctx, `
+		SELECT cost_usd::double precision
+		FROM steps
+		WHERE run_id=$1 AND name=$2
+	`, runID, domain.StepLLM).Scan(&llmCost); err != nil {
+		t.Fatalf("query llm step cost: %v", err)
+	}
+	if llmCost != 1.25 {
+		t.Fatalf("expected llm cost 1.25 got %f", llmCost)
+	}
+
+	var toolCost float64
+	if err := pool.QueryRow(ctx, `
+		SELECT cost_usd::double precision
+		FROM steps
+		WHERE run_id=$1 AND name=$2
+	`, runID, domain.StepTool).Scan(&toolCost); err != nil {
+		t.Fatalf("query tool step cost: %v", err)
+	}
+	if toolCost != 0.75 {
+		t.Fatalf("expected tool cost 0.75 got %f", toolCost)
+	}
+
+	var totalCost float64
+	if err := pool.QueryRow(ctx, `
+		SELECT total_cost_usd::double precision
+		FROM runs
+		WHERE id=$1
+	`, runID).Scan(&totalCost); err != nil {
+		t.Fatalf("query run total cost: %v", err)
+	}
+	if totalCost != 2.0 {
+		t.Fatalf("expected total run cost 2.0 got %f", totalCost)
+	}
+
+	breakdown, err := runRepo.GetRunCost(tenantCtx, runID)
+	if err != nil {
+		t.Fatalf("get run cost: %v", err)
+	}
+	if len(breakdown.Steps) != 3 || breakdown.Steps[0].CostDetail == nil || breakdown.Steps[0].CostDetail.Model != "gpt-4o" ||
+		breakdown.Steps[0].CostDetail.CompletionTokens != 250 || breakdown.Steps[2].CostDetail != nil {
+		t.Fatalf("expected itemized llm step and no detail for approval, got %+v", breakdown.Steps)
+	}
+	want := []domain.ModelCost{
+		{Provider: "openai", Model: "gpt-4o", Steps: 1, PromptTokens: 1000, CompletionTokens: 250, CostUSD: 1.25},
+		{Tool: "search", Steps: 1, CostUSD: 0.75},
+	}
+	if !slices.Equal(breakdown.ByModel, want) {
+		t.Fatalf("expected by_model %+v got %+v", want, breakdown.ByModel)
+	}
+
+	today := time.Now().UTC()
+	usage, err := repository.NewUsageRepository(pool, logger).ListDailyTokenUsage(ctx, apiKeyID, today.AddDate(0, 0, -1), today.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("list token usage: %v", err)
+	}
+	if len(usage) != 2 {
+		t.Fatalf("expected usage for the llm and tool steps, got %+v", usage)
+	}
+	for _, u := range usage {
+		u.Period, u.TotalLatencyMS = "", 0
+		switch u.Tool {
+		case "search":
+			if u != (domain.TokenUsage{Tool: "search", Calls: 1}) {
+				t.Fatalf("unexpected tool usage %+v", u)
+			}
+		default:
+			if u != (domain.TokenUsage{Provider: "openai", Model: "gpt-4o", Calls: 1, PromptTokens: 1000, CompletionTokens: 250}) {
+				t.Fatalf("unexpected llm usage %+v", u)
+			}
+		}
+	}
+}
+
+func TestWorkerRecordsUsageOfFailedAttempts(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if _, err := repository.NewRunRepository(pool, logger).CreateRun(tenantCtx, domain.CreateRunParams{}); err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	fake := clock.NewFake(time.Now().UTC())
+	w := New(Deps{
+		Pool:           pool,
+		Logger:         logger,
+		Clock:          fake,
+		APIKeyID:       apiKeyID,
+		MaxAttempts:    2,
+		RetryBaseDelay: time.Second,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM: failingExecutor{err: errors.New("provider error"), cost: domain.CostDetail{
+			Provider:     "openai",
+			Model:        "gpt-4o",
+			PromptTokens: 400,
+		}},
+	}
+
+	// The first attempt is retried, the second fails the run; both spent
+	// tokens.
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process first attempt: %v", err)
+	}
+	fake.Advance(time.Minute)
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process second attempt: %v", err)
+	}
+
+	today := time.Now().UTC()
+	usage, err := repository.NewUsageRepository(pool, logger).ListDailyTokenUsage(ctx, apiKeyID, today.AddDate(0, 0, -1), today.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("list token usage: %v", err)
+	}
+	if len(usage) != 1 || usage[0].Calls != 2 || usage[0].PromptTokens != 800 {
+		t.Fatalf("expected both failed attempts counted, got %+v", usage)
+	}
+}
+
+func TestWorkerEmitsRunSummaryOnCompletion(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps
+		SET status=$2
+		WHERE run_id=$1 AND name=$3
+	`, runID, domain.StepSuccess, domain.StepApproval); err != nil {
+		t.Fatalf("pre-approve run: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:           pool,
+		Logger:         logger,
+		APIKeyID:       apiKeyID,
+		MaxAttempts:    3,
+		RetryBaseDelay: time.Nanosecond,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM:  staticExecutor{payload: json.RawMessage(`{"usage":{"prompt_tokens":100,"completion_tokens":20}}`), cost: domain.CostDetail{CostUSD: 1.25}},
+		domain.StepTool: failingExecutor{err: errors.New("flaky tool")},
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process llm step: %v", err)
+	}
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process failing tool step: %v", err)
+	}
+	w.executors[domain.StepTool] = staticExecutor{payload: json.RawMessage(`{"ok":"tool"}`), cost: domain.CostDetail{CostUSD: 0.75}}
+	time.Sleep(10 * time.Millisecond)
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process retried tool step: %v", err)
+	}
+
+	var (
+		count   int
+		payload []byte
+	)
+	if err := pool.QueryRow(ctx, `
+		SELECT COUNT(*) OVER (), payload
+		FROM events
+		WHERE run_id=$1 AND type=$2
+	`, runID, domain.EventRunSummary).Scan(&count, &payload); err != nil {
+		t.Fatalf("query run summary event: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected exactly one RUN_SUMMARY event, got %d", count)
+	}
+
+	var summary domain.RunSummary
+	if err := json.Unmarshal(payload, &summary); err != nil {
+		t.Fatalf("decode run summary: %v", err)
+	}
+	if summary.Status != domain.RunSuccess {
+		t.Fatalf("expected SUCCEEDED summary, got %s", summary.Status)
+	}
+	if summary.TotalCostUSD != 2.0 {
+		t.Fatalf("expected total cost 2.0 got %f", summary.TotalCostUSD)
+	}
+	if summary.Retries != 1 || summary.Attempts != 3 {
+		t.Fatalf("expected 3 attempts with 1 retry, got %d/%d", summary.Attempts, summary.Retries)
+	}
+	if summary.PromptTokens != 100 || summary.CompletionTokens != 20 || summary.TotalTokens != 120 {
+		t.Fatalf("unexpected token usage: %+v", summary)
+	}
+	if len(summary.Steps) != 3 {
+		t.Fatalf("expected 3 steps in summary, got %d", len(summary.Steps))
+	}
+}
+
+func TestWorkerEncryptsStepPayloads(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	keyring, err := secrets.ParseKeyring("k1:" + base64.StdEncoding.EncodeToString(make([]byte, secrets.KeySize)))
+	if err != nil {
+		t.Fatalf("parse keyring: %v", err)
+	}
+
+	runID, err := repository.NewRunRepository(pool, logger).WithKeyring(keyring).CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps
+		SET status=$2
+		WHERE run_id=$1 AND name=$3
+	`, runID, domain.StepSuccess, domain.StepApproval); err != nil {
+		t.Fatalf("pre-approve run: %v", err)
+	}
+
+	w := New(Deps{Pool: pool, Logger: logger, APIKeyID: apiKeyID, Keyring: keyring})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM: staticExecutor{
+			payload: json.RawMessage(`{"answer":"private","usage":{"prompt_tokens":100,"completion_tokens":20}}`),
+			cost:    domain.CostDetail{PromptTokens: 100, CompletionTokens: 20},
+		},
+		domain.StepTool: staticExecutor{payload: json.RawMessage(`{"ok":"tool"}`)},
+	}
+	for range 2 {
+		if err := w.ProcessOnce(ctx); err != nil {
+			t.Fatalf("process step: %v", err)
+		}
+	}
+
+	var input, output json.RawMessage
+	if err := pool.QueryRow(ctx, `SELECT input, output FROM steps WHERE run_id=$1 AND name=$2`, runID, domain.StepLLM).Scan(&input, &output); err != nil {
+		t.Fatalf("read llm step: %v", err)
+	}
+	if strings.Contains(string(output), "private") || strings.Contains(string(input), "claimedAt") {
+		t.Fatalf("expected sealed input and output, got %s and %s", input, output)
+	}
+	opened, err := keyring.OpenJSON(secrets.FieldStepOutput, output)
+	if err != nil || !strings.Contains(string(opened), `"answer":"private"`) {
+		t.Fatalf("opened output = %s (%v)", opened, err)
+	}
+
+	var payload []byte
+	if err := pool.QueryRow(ctx, `SELECT payload FROM events WHERE run_id=$1 AND type=$2`, runID, domain.EventRunSummary).Scan(&payload); err != nil {
+		t.Fatalf("query run summary event: %v", err)
+	}
+	var summary domain.RunSummary
+	if err := json.Unmarshal(payload, &summary); err != nil {
+		t.Fatalf("decode run summary: %v", err)
+	}
+	if summary.Status != domain.RunSuccess || summary.PromptTokens != 100 || summary.CompletionTokens != 20 {
+		t.Fatalf("expected tokens read from cost detail, got %+v", summary)
+	}
+}
+
+func TestWorkerEncryptsFailureOutput(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	keyring, err := secrets.ParseKeyring("k1:" + base64.StdEncoding.EncodeToString(make([]byte, secrets.KeySize)))
+	if err != nil {
+		t.Fatalf("parse keyring: %v", err)
+	}
+
+	runID, err := repository.NewRunRepository(pool, logger).WithKeyring(keyring).CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	w := New(Deps{Pool: pool, Logger: logger, APIKeyID: apiKeyID, Keyring: keyring})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM: failingExecutor{err: execs.Permanent(errors.New("provider echoed private prompt"))},
+	}
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process step: %v", err)
+	}
+
+	var (
+		status domain.StepStatus
+		output json.RawMessage
+	)
+	if err := pool.QueryRow(ctx, `SELECT status, output FROM steps WHERE run_id=$1 AND name=$2`, runID, domain.StepLLM).Scan(&status, &output); err != nil {
+		t.Fatalf("read llm step: %v", err)
+	}
+	if status != domain.StepFailed || strings.Contains(string(output), "private") {
+		t.Fatalf("expected a failed step with sealed output, got %s and %s", status, output)
+	}
+	opened, err := keyring.OpenJSON(secrets.FieldStepOutput, output)
+	if err != nil || !strings.Contains(string(opened), "provider echoed private prompt") {
+		t.Fatalf("opened output = %s (%v)", opened, err)
+	}
+}
+func TestWorkerOnFailurePolicyLetsRunContinue(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps
+		SET on_failure = CASE name WHEN $2 THEN $3 WHEN $4 THEN $5 ELSE on_failure END
+		WHERE run_id=$1
+	`, runID, domain.StepLLM, domain.OnFailureSkip, domain.StepTool, domain.OnFailureContinue); err != nil {
+		t.Fatalf("set step failure policies: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:        pool,
+		Logger:      logger,
+		APIKeyID:    apiKeyID,
+		MaxAttempts: 1,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM:  failingExecutor{err: errors.New("enrichment down")},
+		domain.StepTool: failingExecutor{err: errors.New("tool down")},
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process llm step: %v", err)
+	}
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process tool step: %v", err)
+	}
+
+	stepStatus := func(name domain.StepName) domain.StepStatus {
+		t.Helper()
+		var status domain.StepStatus
+		if err := pool.QueryRow(ctx,
+			`SELECT status FROM steps WHERE run_id=$1 AND name=$2`,
+			runID, name,
+		).Scan(&status); err != nil {
+			t.Fatalf("read %s status: %v", name, err)
+		}
+		return status
+	}
+
+	if got := stepStatus(domain.StepLLM); got != domain.StepSkipped {
+		t.Fatalf("expected LLM step %s got %s", domain.StepSkipped, got)
+	}
+	if got := stepStatus(domain.StepTool); got != domain.StepFailed {
+		t.Fatalf("expected TOOL step %s got %s", domain.StepFailed, got)
+	}
+	if got := stepStatus(domain.StepApproval); got != domain.StepWaiting {
+		t.Fatalf("expected APPROVAL step %s got %s", domain.StepWaiting, got)
+	}
+
+	var runStatus domain.RunStatus
+	if err := pool.QueryRow(ctx, `SELECT status FROM runs WHERE id=$1`, runID).Scan(&runStatus); err != nil {
+		t.Fatalf("read run status: %v", err)
+	}
+	if runStatus != domain.RunWaiting {
+		t.Fatalf("expected run %s got %s", domain.RunWaiting, runStatus)
+	}
+
+	var skippedEvents int
+	if err := pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM events WHERE run_id=$1 AND type=$2`,
+		runID, domain.EventStepSkipped,
+	).Scan(&skippedEvents); err != nil {
+		t.Fatalf("count skipped events: %v", err)
+	}
+	if skippedEvents != 1 {
+		t.Fatalf("expected one STEP_SKIPPED event, got %d", skippedEvents)
+	}
+
+	if err := runRepo.ApproveRun(tenantCtx, runID); err != nil {
+		t.Fatalf("approve run: %v", err)
+	}
+	if err := pool.QueryRow(ctx, `SELECT status FROM runs WHERE id=$1`, runID).Scan(&runStatus); err != nil {
+		t.Fatalf("read run status after approve: %v", err)
+	}
+	if runStatus != domain.RunSuccess {
+		t.Fatalf("expected run %s after approve got %s", domain.RunSuccess, runStatus)
+	}
+}
+
+func TestWorkerSkipsStepsWhoseConditionIsFalse(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
+		Metadata: map[string]string{"tier": "free"},
+	})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps
+		SET condition = CASE name WHEN $2 THEN $3 WHEN $4 THEN $5 WHEN $6 THEN $7 END
+		WHERE run_id=$1
+	`,
+		runID,
+		domain.StepLLM, `run.metadata.tier == "free"`,
+		domain.StepTool, `steps.LLM.output.ok == "llm" && run.metadata.tier != "free"`,
+		domain.StepApproval, `steps.TOOL.status == "SUCCEEDED"`,
+	); err != nil {
+		t.Fatalf("set step conditions: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:     pool,
+		Logger:   logger,
+		APIKeyID: apiKeyID,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM:  staticExecutor{payload: json.RawMessage(`{"ok":"llm"}`)},
+		domain.StepTool: failingExecutor{err: errors.New("tool must not run")},
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process llm step: %v", err)
+	}
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process tool step: %v", err)
+	}
+
+	rows, err := pool.Query(ctx, `SELECT name, status, attempts FROM steps WHERE run_id=$1`, runID)
+	if err != nil {
+		t.Fatalf("read steps: %v", err)
+	}
+	defer rows.Close()
+	want := map[domain.StepName]domain.StepStatus{
+		domain.StepLLM:      domain.StepSuccess,
+		domain.StepTool:     domain.StepSkipped,
+		domain.StepApproval: domain.StepSkipped,
+	}
+	for rows.Next() {
+		var (
+			name     domain.StepName
+			status   domain.StepStatus
+			attempts int
+		)
+		if err := rows.Scan(&name, &status, &attempts); err != nil {
+			t.Fatalf("scan step: %v", err)
+		}
+		if status != want[name] {
+			t.Fatalf("expected %s step %s got %s", name, want[name], status)
+		}
+		if name != domain.StepLLM && attempts != 0 {
+			t.Fatalf("expected skipped %s step to have no attempts, got %d", name, attempts)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("iterate steps: %v", err)
+	}
+
+	var runStatus domain.RunStatus
+	if err := pool.QueryRow(ctx, `SELECT status FROM runs WHERE id=$1`, runID).Scan(&runStatus); err != nil {
+		t.Fatalf("read run status: %v", err)
+	}
+	if runStatus != domain.RunSuccess {
+		t.Fatalf("expected run %s got %s", domain.RunSuccess, runStatus)
+	}
+
+	var conditionSkips int
+	if err := pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM events WHERE run_id=$1 AND type=$2 AND payload->>'reason' = 'condition'`,
+		runID, domain.EventStepSkipped,
+	).Scan(&conditionSkips); err != nil {
+		t.Fatalf("count skipped events: %v", err)
+	}
+	if conditionSkips != 2 {
+		t.Fatalf("expected two condition STEP_SKIPPED events, got %d", conditionSkips)
+	}
+}
+
+func TestWorkerFansOutMapStepWithBoundedParallelism(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	templateID := uuid.New()
+	templateName := "map-template-" + uuid.NewString()
+	if _, err := pool.Exec(ctx, `INSERT INTO workflow_templates (id, name) VALUES ($1, $2)`, templateID, templateName); err != nil {
+		t.Fatalf("insert workflow template: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		INSERT INTO workflow_template_steps (id, template_id, position, name, map_items, map_step, map_parallelism)
+		VALUES
+			($1, $2, 1, $3, NULL, NULL, NULL),
+			($4, $2, 2, $5, 'steps.LLM.output.items', $6, 1)
+	`,
+		uuid.New(), templateID, domain.StepLLM,
+		uuid.New(), domain.StepMap, domain.StepTool,
+	); err != nil {
+		t.Fatalf("insert workflow template steps: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{TemplateName: templateName})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:     pool,
+		Logger:   logger,
+		APIKeyID: apiKeyID,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM:  staticExecutor{payload: json.RawMessage(`{"items":[{"id":1},{"id":2},{"id":3}]}`)},
+		domain.StepTool: itemEchoExecutor{},
+	}
+
+	// LLM, then the MAP expansion.
+	for range 2 {
+		if err := w.ProcessOnce(ctx); err != nil {
+			t.Fatalf("process step: %v", err)
+		}
+	}
+
+	var mapStepID uuid.UUID
+	if err := pool.QueryRow(ctx,
+		`SELECT id FROM steps WHERE run_id=$1 AND name=$2`,
+		runID, domain.StepMap,
+	).Scan(&mapStepID); err != nil {
+		t.Fatalf("read map step: %v", err)
+	}
+
+	var children int
+	if err := pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM steps WHERE parent_step_id=$1 AND status=$2 AND name=$3`,
+		mapStepID, domain.StepPending, domain.StepTool,
+	).Scan(&children); err != nil {
+		t.Fatalf("count map children: %v", err)
+	}
+	if children != 3 {
+		t.Fatalf("expected 3 pending TOOL children, got %d", children)
+	}
+
+	// With map_parallelism=1, a running child blocks its siblings.
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps SET status=$2, started_at=NOW()
+		WHERE parent_step_id=$1 AND map_index=0
+	`, mapStepID, domain.StepRunning); err != nil {
+		t.Fatalf("mark first child running: %v", err)
+	}
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process at parallelism limit: %v", err)
+	}
+	var running int
+	if err := pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM steps WHERE parent_step_id=$1 AND status <> $2`,
+		mapStepID, domain.StepPending,
+	).Scan(&running); err != nil {
+		t.Fatalf("count started children: %v", err)
+	}
+	if running != 1 {
+		t.Fatalf("expected only the running child to have started, got %d", running)
+	}
+	if _, err := pool.Exec(ctx,
+		`UPDATE steps SET status=$2, started_at=NULL WHERE parent_step_id=$1 AND map_index=0`,
+		mapStepID, domain.StepPending,
+	); err != nil {
+		t.Fatalf("reset first child: %v", err)
+	}
+
+	for range 3 {
+		if err := w.ProcessOnce(ctx); err != nil {
+			t.Fatalf("process map child: %v", err)
+		}
+	}
+
+	var (
+		mapStatus domain.StepStatus
+		output    []byte
+	)
+	if err := pool.QueryRow(ctx,
+		`SELECT status, output FROM steps WHERE id=$1`,
+		mapStepID,
+	).Scan(&mapStatus, &output); err != nil {
+		t.Fatalf("read map step: %v", err)
+	}
+	if mapStatus != domain.StepSuccess {
+		t.Fatalf("expected map step %s got %s", domain.StepSuccess, mapStatus)
+	}
+	var aggregated struct {
+		Items   int `json:"items"`
+		Results []struct {
+			Item struct {
+				ID int `json:"id"`
+			} `json:"item"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(output, &aggregated); err != nil {
+		t.Fatalf("decode map output %s: %v", output, err)
+	}
+	if aggregated.Items != 3 || len(aggregated.Results) != 3 {
+		t.Fatalf("expected 3 aggregated results, got %s", output)
+	}
+	for i, result := range aggregated.Results {
+		if result.Item.ID != i+1 {
+			t.Fatalf("expected results in item order, got %s", output)
+		}
+	}
+
+	var runStatus domain.RunStatus
+	if err := pool.QueryRow(ctx, `SELECT status FROM runs WHERE id=$1`, runID).Scan(&runStatus); err != nil {
+		t.Fatalf("read run status: %v", err)
+	}
+	if runStatus != domain.RunSuccess {
+		t.Fatalf("expected run %s got %s", domain.RunSuccess, runStatus)
+	}
+}
+
+func TestWorkerOpensNamedApprovalGatesInOrder(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	templateID := uuid.New()
+	templateName := "approvals-template-" + uuid.NewString()
+	if _, err := pool.Exec(ctx, `INSERT INTO workflow_templates (id, name) VALUES ($1, $2)`, templateID, templateName); err != nil {
+		t.Fatalf("insert workflow template: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		INSERT INTO workflow_template_steps (id, template_id, position, name, approval_name)
+		VALUES
+			($1, $2, 1, $3, NULL),
+			($4, $2, 2, $5, 'legal'),
+			($6, $2, 3, $5, 'finance'),
+			($7, $2, 4, $8, NULL)
+	`,
+		uuid.New(), templateID, domain.StepLLM,
+		uuid.New(), domain.StepApproval,
+		uuid.New(),
+		uuid.New(), domain.StepTool,
+	); err != nil {
+		t.Fatalf("insert workflow template steps: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{TemplateName: templateName})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:     pool,
+		Logger:   logger,
+		APIKeyID: apiKeyID,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM:  staticExecutor{payload: json.RawMessage(`{"ok":"llm"}`)},
+		domain.StepTool: staticExecutor{payload: json.RawMessage(`{"ok":"tool"}`)},
+	}
+
+	// LLM, the first gate, then nothing until it is approved.
+	for range 3 {
+		if err := w.ProcessOnce(ctx); err != nil {
+			t.Fatalf("process step: %v", err)
+		}
+	}
+
+	gates, err := runRepo.ListPendingApprovals(tenantCtx, runID)
+	if err != nil {
+		t.Fatalf("list pending approvals: %v", err)
+	}
+	if len(gates) != 2 {
+		t.Fatalf("expected 2 pending gates, got %d", len(gates))
+	}
+	legal, finance := gates[0], gates[1]
+	if legal.Name != "legal" || legal.Status != domain.StepWaiting || legal.WaitingSince == nil {
+		t.Fatalf("expected legal gate waiting, got %+v", legal)
+	}
+	if finance.Name != "finance" || finance.Status != domain.StepPending {
+		t.Fatalf("expected finance gate pending, got %+v", finance)
+	}
+
+	if err := runRepo.ApproveStep(tenantCtx, runID, finance.StepID); !errors.Is(err, domain.ErrRunNotWaitingApproval) {
+		t.Fatalf("expected ErrRunNotWaitingApproval approving a closed gate, got %v", err)
+	}
+	if err := runRepo.ApproveStep(tenantCtx, runID, uuid.New()); !errors.Is(err, domain.ErrApprovalStepNotFound) {
+		t.Fatalf("expected ErrApprovalStepNotFound, got %v", err)
+	}
+	if err := runRepo.ApproveStep(tenantCtx, runID, legal.StepID); err != nil {
+		t.Fatalf("approve legal gate: %v", err)
+	}
+
+	// The second gate opens right after the first.
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("open finance gate: %v", err)
+	}
+	if err := runRepo.ApproveStep(tenantCtx, runID, finance.StepID); err != nil {
+		t.Fatalf("approve finance gate: %v", err)
+	}
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process tool step: %v", err)
+	}
+
+	var (
+		runStatus  domain.RunStatus
+		toolStatus domain.StepStatus
+	)
+	if err := pool.QueryRow(ctx, `SELECT status FROM runs WHERE id=$1`, runID).Scan(&runStatus); err != nil {
+		t.Fatalf("read run status: %v", err)
+	}
+	if err := pool.QueryRow(ctx,
+		`SELECT status FROM steps WHERE run_id=$1 AND name=$2`,
+		runID, domain.StepTool,
+	).Scan(&toolStatus); err != nil {
+		t.Fatalf("read tool step: %v", err)
+	}
+	if toolStatus != domain.StepSuccess || runStatus != domain.RunSuccess {
+		t.Fatalf("expected tool %s and run %s, got %s and %s", domain.StepSuccess, domain.RunSuccess, toolStatus, runStatus)
+	}
+
+	var namedApprovals int
+	if err := pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM events WHERE run_id=$1 AND type=$2 AND payload->>'approval_name' IN ('legal', 'finance')`,
+		runID, domain.EventStepApproved,
+	).Scan(&namedApprovals); err != nil {
+		t.Fatalf("count approved events: %v", err)
+	}
+	if namedApprovals != 2 {
+		t.Fatalf("expected two named STEP_APPROVED events, got %d", namedApprovals)
+	}
+}
+
+func TestWorkerClaimsHigherPriorityRunFirst(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+
+	lowPriorityRun, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{Priority: 0})
+	if err != nil {
+		t.Fatalf("create low priority run: %v", err)
+	}
+	highPriorityRun, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{Priority: 10})
+	if err != nil {
+		t.Fatalf("create high priority run: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:         pool,
+		Logger:       logger,
+		APIKeyID:     apiKeyID,
+		ReclaimAfter: 5 * time.Minute,
+		MaxAttempts:  3,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM:  staticExecutor{payload: json.RawMessage(`{"ok":"llm"}`)},
+		domain.StepTool: staticExecutor{payload: json.RawMessage(`{"ok":"tool"}`)},
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once: %v", err)
+	}
+
+	var highStatus domain.StepStatus
+	if err := pool.QueryRow(ctx, `
+		SELECT status
+		FROM steps
+		WHERE run_id=$1 AND name=$2
+	`, highPriorityRun, domain.StepLLM).Scan(&highStatus); err != nil {
+		t.Fatalf("query high priority run step status: %v", err)
+	}
+	if highStatus == domain.StepPending {
+		t.Fatalf("expected high priority run to be processed first, step status=%s", highStatus)
+	}
+
+	var lowStatus domain.StepStatus
+	if err := pool.QueryRow(ctx, `
+		SELECT status
+		FROM steps
+		WHERE run_id=$1 AND name=$2
+	`, lowPriorityRun, domain.StepLLM).Scan(&lowStatus); err != nil {
+		t.Fatalf("query low priority run step status: %v", err)
+	}
+	if lowStatus != domain.StepPending {
+		t.Fatalf("expected low priority run step to remain pending, got %s", lowStatus)
+	}
+}
+
+func TestWorkerClaimsBatchWithinConcurrencyLimit(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `UPDATE api_keys SET max_concurrent_runs=3 WHERE id=$1`, apiKeyID); err != nil {
+		t.Fatalf("set max_concurrent_runs: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+	for i := range 4 {
+		if _, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{}); err != nil {
+			t.Fatalf("create run %d: %v", i, err)
+		}
 	}
 
 	w := New(Deps{
-		Pool:               pool,
-		Logger:             logger,
-		APIKeyID:           apiKeyID,
-		ReclaimAfter:       5 * time.Minute,
-		MaxAttempts:        3,
-		RetryBaseDelay:     1 * time.Second,
-		DefaultStepTimeout: 100 * time.Millisecond,
+		Pool:           pool,
+		Logger:         logger,
+		APIKeyID:       apiKeyID,
+		ReclaimAfter:   5 * time.Minute,
+		MaxAttempts:    3,
+		ClaimBatchSize: 8,
 	})
 	w.executors = map[domain.StepName]StepExecutor{
-		domain.StepLLM: timeoutExecutor{},
+		domain.StepLLM:  staticExecutor{payload: json.RawMessage(`{"ok":"llm"}`)},
+		domain.StepTool: staticExecutor{payload: json.RawMessage(`{"ok":"tool"}`)},
 	}
 
-	start := time.Now()
-	if err := w.ProcessOnce(ctx); err != nil {
+	claimed, err := w.processOnce(ctx)
+	if err != nil {
 		t.Fatalf("process once: %v", err)
 	}
-	if elapsed := time.Since(start); elapsed > 2*time.Second {
-		t.Fatalf("expected default timeout to stop step quickly, elapsed=%s", elapsed)
+	// Four runs have a runnable LLM step, but only three may run at once.
+	if claimed != 3 {
+		t.Fatalf("expected a batch of 3 claims, got %d", claimed)
 	}
 
-	var (
-		status    domain.StepStatus
-		attempts  int
-		nextRunAt time.Time
-	)
+	var succeeded, claims int
 	if err := pool.QueryRow(ctx, `
-		SELECT status, attempts, next_run_at
-		FROM steps
-		WHERE run_id=$1 AND name=$2
-	`, runID, domain.StepLLM).Scan(&status, &attempts, &nextRunAt); err != nil {
-		t.Fatalf("query step after timeout: %v", err)
+		SELECT COUNT(*) FROM steps WHERE name=$1 AND status=$2
+	`, domain.StepLLM, domain.StepSuccess).Scan(&succeeded); err != nil {
+		t.Fatalf("count succeeded steps: %v", err)
+	}
+	if succeeded != 3 {
+		t.Fatalf("expected the batch to run 3 steps, got %d", succeeded)
 	}
+	if err := pool.QueryRow(ctx, `
+		SELECT claims FROM tenant_claim_counters WHERE api_key_id=$1
+	`, apiKeyID).Scan(&claims); err != nil {
+		t.Fatalf("read claim counter: %v", err)
+	}
+	if claims != 3 {
+		t.Fatalf("expected claim counter 3, got %d", claims)
+	}
+}
 
-	if status != domain.StepPending {
-		t.Fatalf("expected timed-out step to be retried as %s, got %s", domain.StepPending, status)
+func TestWorkerStartsNoMoreRunsThanTemplateRunLimit(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
 	}
-	if attempts != 1 {
-		t.Fatalf("expected attempts=1 after timeout, got %d", attempts)
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
 	}
-	if !nextRunAt.After(time.Now().UTC()) {
-		t.Fatalf("expected next_run_at to be scheduled in future, got %s", nextRunAt)
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+	for i := range 4 {
+		if _, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{}); err != nil {
+			t.Fatalf("create run %d: %v", i, err)
+		}
+	}
+	// The runs were accepted while none had started; the cap now holds them
+	// back at claim time.
+	if _, err := pool.Exec(ctx, `UPDATE api_keys SET max_concurrent_runs_per_template='{"default": 2}' WHERE id=$1`, apiKeyID); err != nil {
+		t.Fatalf("set max_concurrent_runs_per_template: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:           pool,
+		Logger:         logger,
+		APIKeyID:       apiKeyID,
+		ReclaimAfter:   5 * time.Minute,
+		MaxAttempts:    3,
+		ClaimBatchSize: 8,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM:  staticExecutor{payload: json.RawMessage(`{"ok":"llm"}`)},
+		domain.StepTool: staticExecutor{payload: json.RawMessage(`{"ok":"tool"}`)},
+	}
+
+	claimed, err := w.processOnce(ctx)
+	if err != nil {
+		t.Fatalf("process once: %v", err)
+	}
+	if claimed != 2 {
+		t.Fatalf("expected 2 runs started, got %d claims", claimed)
+	}
+
+	// The started runs keep going; the others wait for a slot.
+	if _, err := w.processOnce(ctx); err != nil {
+		t.Fatalf("process once: %v", err)
+	}
+	var pending int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM runs WHERE status=$1`, domain.RunPending).Scan(&pending); err != nil {
+		t.Fatalf("count pending runs: %v", err)
+	}
+	if pending != 2 {
+		t.Fatalf("expected 2 runs still pending, got %d", pending)
+	}
+	var tools int
+	if err := pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM steps WHERE name=$1 AND status=$2
+	`, domain.StepTool, domain.StepSuccess).Scan(&tools); err != nil {
+		t.Fatalf("count tool steps: %v", err)
+	}
+	if tools != 2 {
+		t.Fatalf("expected the started runs to reach their TOOL steps, got %d", tools)
 	}
 }
 
-func TestDedicatedWorkerStaysWithinTenant(t *testing.T) {
+func TestWorkerRespectsGlobalStepLimitAcrossTenants(t *testing.T) {
 	ctx := context.Background()
 	pool := workerIntegrationPool(t, ctx)
 	defer pool.Close()
@@ -217,34 +2430,132 @@ func TestDedicatedWorkerStaysWithinTenant(t *testing.T) {
 		t.Skipf("skip integration test: database not reachable (%v)", err)
 	}
 
-	apiKeyA, err := workerCreateAPIKey(ctx, pool)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runRepo := repository.NewRunRepository(pool, logger)
+	limits := map[domain.StepName]int{domain.StepLLM: 2}
+
+	// Another tenant already has an LLM step running under a live lease.
+	otherKeyID, err := workerCreateAPIKey(ctx, pool)
 	if err != nil {
-		t.Fatalf("create api key A: %v", err)
+		t.Fatalf("create other api key: %v", err)
 	}
-	apiKeyB, err := workerCreateAPIKey(ctx, pool)
+	if _, err := runRepo.CreateRun(auth.WithAPIKeyID(ctx, otherKeyID), domain.CreateRunParams{}); err != nil {
+		t.Fatalf("create other tenant run: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps SET status=$1, lease_expires_at=NOW() + INTERVAL '1 hour' WHERE name=$2
+	`, domain.StepRunning, domain.StepLLM); err != nil {
+		t.Fatalf("mark other tenant step running: %v", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
 	if err != nil {
-		t.Fatalf("create api key B: %v", err)
+		t.Fatalf("create api key: %v", err)
+	}
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	for i := range 3 {
+		if _, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{}); err != nil {
+			t.Fatalf("create run %d: %v", i, err)
+		}
 	}
 
-	ctxA := auth.WithAPIKeyID(ctx, apiKeyA)
-	ctxB := auth.WithAPIKeyID(ctx, apiKeyB)
+	w := New(Deps{
+		Pool:             pool,
+		Logger:           logger,
+		APIKeyID:         apiKeyID,
+		ReclaimAfter:     5 * time.Minute,
+		MaxAttempts:      3,
+		ClaimBatchSize:   8,
+		GlobalStepLimits: limits,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM:  staticExecutor{payload: json.RawMessage(`{"ok":"llm"}`)},
+		domain.StepTool: staticExecutor{payload: json.RawMessage(`{"ok":"tool"}`)},
+	}
+	llmSucceeded := func() int {
+		t.Helper()
+		var n int
+		if err := pool.QueryRow(ctx, `
+			SELECT COUNT(*) FROM steps st JOIN runs r ON st.run_id = r.id
+			WHERE r.api_key_id=$1 AND st.name=$2 AND st.status=$3
+		`, apiKeyID, domain.StepLLM, domain.StepSuccess).Scan(&n); err != nil {
+			t.Fatalf("count succeeded LLM steps: %v", err)
+		}
+		return n
+	}
+
+	// One of the two cluster-wide LLM slots is taken, so only one of the
+	// tenant's three LLM steps may be claimed.
+	if _, err := w.processOnce(ctx); err != nil {
+		t.Fatalf("process once: %v", err)
+	}
+	if got := llmSucceeded(); got != 1 {
+		t.Fatalf("expected 1 LLM step under the global limit, got %d", got)
+	}
+
+	// Once the other tenant's step finishes, both slots are free.
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps SET status=$1, lease_expires_at=NULL
+		WHERE status=$2 AND run_id IN (SELECT id FROM runs WHERE api_key_id=$3)
+	`, domain.StepSuccess, domain.StepRunning, otherKeyID); err != nil {
+		t.Fatalf("finish other tenant step: %v", err)
+	}
+	if _, err := w.processOnce(ctx); err != nil {
+		t.Fatalf("process once: %v", err)
+	}
+	if got := llmSucceeded(); got != 3 {
+		t.Fatalf("expected the remaining 2 LLM steps claimed together, got %d in total", got)
+	}
+}
+
+func TestDedicatedWorkerRespectsConcurrentRunningStepLimit(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	if _, err := pool.Exec(ctx, `
+		UPDATE api_keys
+		SET max_concurrent_runs=1
+		WHERE id=$1
+	`, apiKeyID); err != nil {
+		t.Fatalf("set max_concurrent_runs: %v", err)
+	}
 
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	runRepo := repository.NewRunRepository(pool, logger)
 
-	runA, err := runRepo.CreateRun(ctxA, domain.CreateRunParams{})
+	runA, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
 	if err != nil {
-		t.Fatalf("create run for key A: %v", err)
+		t.Fatalf("create run A: %v", err)
 	}
-	runB, err := runRepo.CreateRun(ctxB, domain.CreateRunParams{})
+	runB, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
 	if err != nil {
-		t.Fatalf("create run for key B: %v", err)
+		t.Fatalf("create run B: %v", err)
+	}
+
+	// Simulate an already-running step for this tenant.
+	if _, err := pool.Exec(ctx, `
+		UPDATE steps
+		SET status=$2, started_at=NOW()
+		WHERE run_id=$1 AND name=$3
+	`, runA, domain.StepRunning, domain.StepLLM); err != nil {
+		t.Fatalf("mark step running: %v", err)
 	}
 
 	w := New(Deps{
 		Pool:         pool,
 		Logger:       logger,
-		APIKeyID:     apiKeyA,
+		APIKeyID:     apiKeyID,
 		ReclaimAfter: 5 * time.Minute,
 		MaxAttempts:  3,
 	})
@@ -254,72 +2565,94 @@ func TestDedicatedWorkerStaysWithinTenant(t *testing.T) {
 	}
 
 	if err := w.ProcessOnce(ctx); err != nil {
-		t.Fatalf("process once #1: %v", err)
-	}
-	if err := w.ProcessOnce(ctx); err != nil {
-		t.Fatalf("process once #2: %v", err)
-	}
-
-	var processedA int
-	if err := pool.QueryRow(ctx, `
-		SELECT COUNT(*) FROM steps
-		WHERE run_id=$1 AND status <> $2
-	`, runA, domain.StepPending).Scan(&processedA); err != nil {
-		t.Fatalf("query tenant A steps: %v", err)
-	}
-	if processedA == 0 {
-		t.Fatal("expected dedicated worker to process at least one step for tenant A")
+		t.Fatalf("process once: %v", err)
+	}
+
+	var runBProcessed int
+	if err := pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM steps
+		WHERE run_id=$1 AND status <> $2
+	`, runB, domain.StepPending).Scan(&runBProcessed); err != nil {
+		t.Fatalf("query run B step statuses: %v", err)
+	}
+	if runBProcessed != 0 {
+		t.Fatalf("expected run B steps to remain pending due concurrent limit, got %d processed", runBProcessed)
+	}
+}
+
+func TestDedicatedWorkerStopsClaimingOverMonthlyBudget(t *testing.T) {
+	ctx := context.Background()
+	pool := workerIntegrationPool(t, ctx)
+	defer pool.Close()
+
+	if err := workerTruncateAll(ctx, pool); err != nil {
+		t.Skipf("skip integration test: database not reachable (%v)", err)
+	}
+
+	apiKeyID, err := workerCreateAPIKey(ctx, pool)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runID, err := repository.NewRunRepository(pool, logger).CreateRun(tenantCtx, domain.CreateRunParams{})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	// The run already spent more than the cap set after it was created.
+	if _, err := pool.Exec(ctx, `UPDATE runs SET total_cost_usd=2 WHERE id=$1`, runID); err != nil {
+		t.Fatalf("record run cost: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `UPDATE api_keys SET monthly_budget_usd=1 WHERE id=$1`, apiKeyID); err != nil {
+		t.Fatalf("set monthly budget: %v", err)
+	}
+
+	w := New(Deps{
+		Pool:     pool,
+		Logger:   logger,
+		APIKeyID: apiKeyID,
+	})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM:  staticExecutor{payload: json.RawMessage(`{"ok":"llm"}`)},
+		domain.StepTool: staticExecutor{payload: json.RawMessage(`{"ok":"tool"}`)},
+	}
+
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once: %v", err)
 	}
 
-	var processedB int
+	var processed int
 	if err := pool.QueryRow(ctx, `
 		SELECT COUNT(*) FROM steps
 		WHERE run_id=$1 AND status <> $2
-	`, runB, domain.StepPending).Scan(&processedB); err != nil {
-		t.Fatalf("query tenant B steps: %v", err)
-	}
-	if processedB != 0 {
-		t.Fatalf("expected tenant B steps to remain pending, got %d processed", processedB)
-	}
-
-	var claimedEvents int
-	if err := pool.QueryRow(ctx, `
-		SELECT COUNT(*)
-		FROM events
-		WHERE run_id=$1 AND type='STEP_CLAIMED'
-	`, runA).Scan(&claimedEvents); err != nil {
-		t.Fatalf("query claimed events: %v", err)
+	`, runID, domain.StepPending).Scan(&processed); err != nil {
+		t.Fatalf("query step statuses: %v", err)
 	}
-	if claimedEvents == 0 {
-		t.Fatal("expected at least one STEP_CLAIMED event for tenant A run")
+	if processed != 0 {
+		t.Fatalf("expected steps to stay pending over budget, got %d processed", processed)
 	}
 
-	var successEvents int
-	if err := pool.QueryRow(ctx, `
-		SELECT COUNT(*)
-		FROM events
-		WHERE run_id=$1 AND type='STEP_SUCCEEDED'
-	`, runA).Scan(&successEvents); err != nil {
-		t.Fatalf("query success events: %v", err)
+	// Raising the cap lets the worker resume.
+	if _, err := pool.Exec(ctx, `UPDATE api_keys SET monthly_budget_usd=10 WHERE id=$1`, apiKeyID); err != nil {
+		t.Fatalf("raise monthly budget: %v", err)
 	}
-	if successEvents == 0 {
-		t.Fatal("expected at least one STEP_SUCCEEDED event for tenant A run")
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process once after raise: %v", err)
 	}
-
-	var waitingEvents int
 	if err := pool.QueryRow(ctx, `
-		SELECT COUNT(*)
-		FROM events
-		WHERE run_id=$1 AND type='STEP_WAITING_APPROVAL'
-	`, runA).Scan(&waitingEvents); err != nil {
-		t.Fatalf("query waiting approval events: %v", err)
+		SELECT COUNT(*) FROM steps
+		WHERE run_id=$1 AND status <> $2
+	`, runID, domain.StepPending).Scan(&processed); err != nil {
+		t.Fatalf("query step statuses: %v", err)
 	}
-	if waitingEvents != 1 {
-		t.Fatalf("expected exactly one STEP_WAITING_APPROVAL event for tenant A run, got %d", waitingEvents)
+	if processed != 1 {
+		t.Fatalf("expected one step processed after raising the cap, got %d", processed)
 	}
 }
 
-func TestWorkerTracksStepAndRunCosts(t *testing.T) {
+func TestWorkerWebhookOutboxRetriesWithBackoffThenDelivers(t *testing.T) {
 	ctx := context.Background()
 	pool := workerIntegrationPool(t, ctx)
 	defer pool.Close()
@@ -337,77 +2670,185 @@ func TestWorkerTracksStepAndRunCosts(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	runRepo := repository.NewRunRepository(pool, logger)
 
-	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
+		WebhookURL: "http://webhook.local/callback",
+	})
 	if err != nil {
 		t.Fatalf("create run: %v", err)
 	}
 
-	// Pre-approve so worker can complete the run after TOOL succeeds.
-	if _, err := pool.Exec(ctx, `
-		UPDATE steps
-		SET status=$2
-		WHERE run_id=$1 AND name=$3
-	`, runID, domain.StepSuccess, domain.StepApproval); err != nil {
-		t.Fatalf("pre-approve run: %v", err)
-	}
-
+	var (
+		sends         int32
+		sentHeaders   []http.Header
+		sentHeadersMu sync.Mutex
+	)
 	w := New(Deps{
-		Pool:         pool,
-		Logger:       logger,
-		APIKeyID:     apiKeyID,
-		ReclaimAfter: 5 * time.Minute,
-		MaxAttempts:  3,
+		Pool:                  pool,
+		Logger:                logger,
+		APIKeyID:              apiKeyID,
+		MaxAttempts:           1,
+		WebhookMaxAttempts:    3,
+		WebhookRetryBaseDelay: 10 * time.Second,
 	})
 	w.executors = map[domain.StepName]StepExecutor{
-		domain.StepLLM:  staticExecutor{payload: json.RawMessage(`{"ok":"llm"}`), costUSD: 1.25},
-		domain.StepTool: staticExecutor{payload: json.RawMessage(`{"ok":"tool"}`), costUSD: 0.75},
+		domain.StepLLM: failingExecutor{err: errors.New("boom")},
 	}
+	w.httpClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		sentHeadersMu.Lock()
+		sentHeaders = append(sentHeaders, r.Header.Clone())
+		sentHeadersMu.Unlock()
+		status := http.StatusOK
+		if atomic.AddInt32(&sends, 1) == 1 {
+			status = http.StatusBadGateway
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       io.NopCloser(strings.NewReader("")),
+			Header:     make(http.Header),
+		}, nil
+	})}
 
 	if err := w.ProcessOnce(ctx); err != nil {
-		t.Fatalf("process llm step: %v", err)
-	}
-	if err := w.ProcessOnce(ctx); err != nil {
-		t.Fatalf("process tool step: %v", err)
+		t.Fatalf("process once: %v", err)
 	}
 
-	var llmCost float64
+	var (
+		eventType string
+		status    domain.WebhookDeliveryStatus
+		attempts  int
+	)
 	if err := pool.QueryRow(ctx, `
-		SELECT cost_usd::double precision
-		FROM steps
-		WHERE run_id=$1 AND name=$2
-	`, runID, domain.StepLLM).Scan(&llmCost); err != nil {
-		t.Fatalf("query llm step cost: %v", err)
+		SELECT event_type, status, attempts
+		FROM webhook_deliveries
+		WHERE run_id=$1
+	`, runID).Scan(&eventType, &status, &attempts); err != nil {
+		t.Fatalf("expected terminal update to enqueue webhook delivery: %v", err)
 	}
-	if llmCost != 1.25 {
-		t.Fatalf("expected llm cost 1.25 got %f", llmCost)
+	if eventType != "RUN_FAILED" || status != domain.WebhookPending || attempts != 0 {
+		t.Fatalf("unexpected enqueued delivery: type=%s status=%s attempts=%d", eventType, status, attempts)
+	}
+	if got := atomic.LoadInt32(&sends); got != 0 {
+		t.Fatalf("expected no inline webhook send, got %d", got)
 	}
 
-	var toolCost float64
+	start := time.Now().UTC()
+	n, err := w.DispatchWebhooksOnce(ctx)
+	if err != nil {
+		t.Fatalf("dispatch #1: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected one delivery attempted got %d", n)
+	}
+
+	var (
+		lastStatusCode int
+		nextAttemptAt  time.Time
+	)
 	if err := pool.QueryRow(ctx, `
-		SELECT cost_usd::double precision
-		FROM steps
-		WHERE run_id=$1 AND name=$2
-	`, runID, domain.StepTool).Scan(&toolCost); err != nil {
-		t.Fatalf("query tool step cost: %v", err)
+		SELECT status, attempts, last_status_code, next_attempt_at
+		FROM webhook_deliveries
+		WHERE run_id=$1
+	`, runID).Scan(&status, &attempts, &lastStatusCode, &nextAttemptAt); err != nil {
+		t.Fatalf("read delivery after failure: %v", err)
 	}
-	if toolCost != 0.75 {
-		t.Fatalf("expected tool cost 0.75 got %f", toolCost)
+	if status != domain.WebhookPending || attempts != 1 || lastStatusCode != http.StatusBadGateway {
+		t.Fatalf("unexpected delivery after failure: status=%s attempts=%d code=%d", status, attempts, lastStatusCode)
+	}
+	if !nextAttemptAt.After(start.Add(5 * time.Second)) {
+		t.Fatalf("expected next_attempt_at to be delayed by backoff, got %s", nextAttemptAt)
 	}
 
-	var totalCost float64
+	// Not due yet: nothing to dispatch.
+	n, err = w.DispatchWebhooksOnce(ctx)
+	if err != nil {
+		t.Fatalf("dispatch before due: %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("expected no deliveries before next_attempt_at, got %d", n)
+	}
+
+	if _, err := pool.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET next_attempt_at=NOW() - INTERVAL '1 second'
+		WHERE run_id=$1
+	`, runID); err != nil {
+		t.Fatalf("force delivery due: %v", err)
+	}
+
+	if _, err := w.DispatchWebhooksOnce(ctx); err != nil {
+		t.Fatalf("dispatch #2: %v", err)
+	}
+
+	var deliveredAt *time.Time
 	if err := pool.QueryRow(ctx, `
-		SELECT total_cost_usd::double precision
-		FROM runs
-		WHERE id=$1
-	`, runID).Scan(&totalCost); err != nil {
-		t.Fatalf("query run total cost: %v", err)
+		SELECT status, attempts, delivered_at
+		FROM webhook_deliveries
+		WHERE run_id=$1
+	`, runID).Scan(&status, &attempts, &deliveredAt); err != nil {
+		t.Fatalf("read delivery after success: %v", err)
 	}
-	if totalCost != 2.0 {
-		t.Fatalf("expected total run cost 2.0 got %f", totalCost)
+	if status != domain.WebhookDelivered || attempts != 2 || deliveredAt == nil {
+		t.Fatalf("expected delivered after second attempt, got status=%s attempts=%d delivered_at=%v", status, attempts, deliveredAt)
+	}
+
+	sentHeadersMu.Lock()
+	if len(sentHeaders) != 2 {
+		t.Fatalf("expected two sends got %d", len(sentHeaders))
+	}
+	firstSend, secondSend := sentHeaders[0], sentHeaders[1]
+	sentHeadersMu.Unlock()
+	if firstSend.Get(webhookHeaderAttempt) != "1" || secondSend.Get(webhookHeaderAttempt) != "2" {
+		t.Fatalf("expected attempt headers 1 and 2, got %q and %q", firstSend.Get(webhookHeaderAttempt), secondSend.Get(webhookHeaderAttempt))
+	}
+	if firstAt := firstSend.Get(webhookHeaderFirstAttemptAt); firstAt == "" || secondSend.Get(webhookHeaderFirstAttemptAt) != firstAt {
+		t.Fatalf("expected retries to carry the first attempt time %q, got %q", firstAt, secondSend.Get(webhookHeaderFirstAttemptAt))
+	}
+
+	webhookRepo := repository.NewWebhookRepository(pool, logger)
+	deliveries, err := webhookRepo.ListWebhookDeliveries(tenantCtx, runID)
+	if err != nil {
+		t.Fatalf("list webhook deliveries: %v", err)
+	}
+	if len(deliveries) != 1 || len(deliveries[0].AttemptLog) != 2 {
+		t.Fatalf("expected one delivery with two logged attempts, got %+v", deliveries)
+	}
+	first, second := deliveries[0].AttemptLog[0], deliveries[0].AttemptLog[1]
+	if first.Attempt != 1 || first.StatusCode == nil || *first.StatusCode != http.StatusBadGateway || first.Error == nil {
+		t.Fatalf("unexpected first attempt %+v", first)
+	}
+	if second.Attempt != 2 || second.StatusCode == nil || *second.StatusCode != http.StatusOK || second.Error != nil {
+		t.Fatalf("unexpected second attempt %+v", second)
+	}
+
+	// Redelivery queues one more immediate attempt.
+	redelivered, err := webhookRepo.RedeliverWebhook(tenantCtx, deliveries[0].ID)
+	if err != nil {
+		t.Fatalf("redeliver webhook: %v", err)
+	}
+	if redelivered.Status != domain.WebhookPending || redelivered.MaxAttempts != 3 {
+		t.Fatalf("unexpected redelivered row %+v", redelivered)
+	}
+	if _, err := webhookRepo.RedeliverWebhook(tenantCtx, deliveries[0].ID); !errors.Is(err, domain.ErrWebhookDeliveryPending) {
+		t.Fatalf("expected ErrWebhookDeliveryPending while queued, got %v", err)
+	}
+	if _, err := w.DispatchWebhooksOnce(ctx); err != nil {
+		t.Fatalf("dispatch redelivery: %v", err)
+	}
+	deliveries, err = webhookRepo.ListWebhookDeliveries(tenantCtx, runID)
+	if err != nil {
+		t.Fatalf("list webhook deliveries after redelivery: %v", err)
+	}
+	if deliveries[0].Status != domain.WebhookDelivered || len(deliveries[0].AttemptLog) != 3 {
+		t.Fatalf("expected redelivery to be delivered and logged, got %+v", deliveries[0])
+	}
+
+	otherCtx := auth.WithAPIKeyID(ctx, uuid.New())
+	if _, err := webhookRepo.ListWebhookDeliveries(otherCtx, runID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expected ErrNoRows for another tenant, got %v", err)
 	}
 }
 
-func TestWorkerClaimsHigherPriorityRunFirst(t *testing.T) {
+func TestWorkerEnqueuesSubscribedEventWebhooks(t *testing.T) {
 	ctx := context.Background()
 	pool := workerIntegrationPool(t, ctx)
 	defer pool.Close()
@@ -425,57 +2866,101 @@ func TestWorkerClaimsHigherPriorityRunFirst(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	runRepo := repository.NewRunRepository(pool, logger)
 
-	lowPriorityRun, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{Priority: 0})
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
+		WebhookURL:    "http://webhook.local/callback",
+		WebhookEvents: []string{domain.EventStepWaitingApproval},
+	})
 	if err != nil {
-		t.Fatalf("create low priority run: %v", err)
+		t.Fatalf("create run: %v", err)
 	}
-	highPriorityRun, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{Priority: 10})
-	if err != nil {
-		t.Fatalf("create high priority run: %v", err)
+
+	if _, err := pool.Exec(ctx,
+		`UPDATE steps SET approval_timeout_seconds=600 WHERE run_id=$1 AND name=$2`,
+		runID, domain.StepApproval,
+	); err != nil {
+		t.Fatalf("set approval timeout: %v", err)
 	}
 
+	delivered := map[string][]byte{}
 	w := New(Deps{
 		Pool:         pool,
 		Logger:       logger,
 		APIKeyID:     apiKeyID,
-		ReclaimAfter: 5 * time.Minute,
-		MaxAttempts:  3,
+		ApprovalLink: "https://console.example/runs/{run_id}/gates/{step_id}",
 	})
 	w.executors = map[domain.StepName]StepExecutor{
 		domain.StepLLM:  staticExecutor{payload: json.RawMessage(`{"ok":"llm"}`)},
 		domain.StepTool: staticExecutor{payload: json.RawMessage(`{"ok":"tool"}`)},
 	}
+	w.httpClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("read webhook body: %v", err)
+		}
+		var payload struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("decode webhook body: %v", err)
+		}
+		delivered[payload.Type+payload.Status] = body
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("")),
+			Header:     make(http.Header),
+		}, nil
+	})}
 
 	if err := w.ProcessOnce(ctx); err != nil {
-		t.Fatalf("process once: %v", err)
+		t.Fatalf("process llm step: %v", err)
+	}
+	if err := w.ProcessOnce(ctx); err != nil {
+		t.Fatalf("process tool step: %v", err)
 	}
 
-	var highStatus domain.StepStatus
+	var eventTypes []string
 	if err := pool.QueryRow(ctx, `
-		SELECT status
-		FROM steps
-		WHERE run_id=$1 AND name=$2
-	`, highPriorityRun, domain.StepLLM).Scan(&highStatus); err != nil {
-		t.Fatalf("query high priority run step status: %v", err)
+		SELECT COALESCE(array_agg(event_type ORDER BY event_type), '{}')
+		FROM webhook_deliveries
+		WHERE run_id=$1
+	`, runID).Scan(&eventTypes); err != nil {
+		t.Fatalf("query deliveries: %v", err)
 	}
-	if highStatus == domain.StepPending {
-		t.Fatalf("expected high priority run to be processed first, step status=%s", highStatus)
+	wantTypes := []string{"RUN_" + string(domain.RunWaiting), domain.EventStepWaitingApproval}
+	if !slices.Equal(eventTypes, wantTypes) {
+		t.Fatalf("expected %v deliveries, got %v", wantTypes, eventTypes)
 	}
 
-	var lowStatus domain.StepStatus
-	if err := pool.QueryRow(ctx, `
-		SELECT status
-		FROM steps
-		WHERE run_id=$1 AND name=$2
-	`, lowPriorityRun, domain.StepLLM).Scan(&lowStatus); err != nil {
-		t.Fatalf("query low priority run step status: %v", err)
+	if _, err := w.DispatchWebhooksOnce(ctx); err != nil {
+		t.Fatalf("dispatch: %v", err)
 	}
-	if lowStatus != domain.StepPending {
-		t.Fatalf("expected low priority run step to remain pending, got %s", lowStatus)
+	if len(delivered) != 2 {
+		t.Fatalf("expected two webhooks delivered, got %d", len(delivered))
+	}
+
+	var event domain.EventWebhookPayload
+	if err := json.Unmarshal(delivered[domain.EventStepWaitingApproval], &event); err != nil {
+		t.Fatalf("decode event webhook: %v", err)
+	}
+	if event.RunID != runID || event.StepID == nil {
+		t.Fatalf("unexpected event webhook payload: %+v", event)
+	}
+
+	var approval domain.ApprovalWebhookPayload
+	if err := json.Unmarshal(delivered[string(domain.RunWaiting)], &approval); err != nil {
+		t.Fatalf("decode approval webhook: %v", err)
+	}
+	wantURL := "https://console.example/runs/" + runID.String() + "/gates/" + event.StepID.String()
+	if approval.RunID != runID || approval.StepID != *event.StepID || approval.ApprovalURL != wantURL {
+		t.Fatalf("unexpected approval webhook payload: %+v", approval)
+	}
+	if approval.ExpiresAt == nil || !approval.ExpiresAt.Equal(approval.WaitingSince.Add(10*time.Minute)) || approval.OnExpiry != domain.ApprovalTimeoutFail {
+		t.Fatalf("expected the gate to expire 10m after %s, got %+v", approval.WaitingSince, approval)
 	}
 }
 
-func TestDedicatedWorkerRespectsConcurrentRunningStepLimit(t *testing.T) {
+func TestWorkerWebhookOutboxMarksFailedAfterMaxAttempts(t *testing.T) {
 	ctx := context.Background()
 	pool := workerIntegrationPool(t, ctx)
 	defer pool.Close()
@@ -489,90 +2974,135 @@ func TestDedicatedWorkerRespectsConcurrentRunningStepLimit(t *testing.T) {
 		t.Fatalf("create api key: %v", err)
 	}
 
-	if _, err := pool.Exec(ctx, `
-		UPDATE api_keys
-		SET max_concurrent_runs=1
-		WHERE id=$1
-	`, apiKeyID); err != nil {
-		t.Fatalf("set max_concurrent_runs: %v", err)
-	}
-
 	tenantCtx := auth.WithAPIKeyID(ctx, apiKeyID)
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	runRepo := repository.NewRunRepository(pool, logger)
 
-	runA, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
-	if err != nil {
-		t.Fatalf("create run A: %v", err)
-	}
-	runB, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{})
+	runID, err := runRepo.CreateRun(tenantCtx, domain.CreateRunParams{
+		WebhookURL: "http://webhook.local/callback",
+	})
 	if err != nil {
-		t.Fatalf("create run B: %v", err)
-	}
-
-	// Simulate an already-running step for this tenant.
-	if _, err := pool.Exec(ctx, `
-		UPDATE steps
-		SET status=$2, started_at=NOW()
-		WHERE run_id=$1 AND name=$3
-	`, runA, domain.StepRunning, domain.StepLLM); err != nil {
-		t.Fatalf("mark step running: %v", err)
+		t.Fatalf("create run: %v", err)
 	}
 
 	w := New(Deps{
-		Pool:         pool,
-		Logger:       logger,
-		APIKeyID:     apiKeyID,
-		ReclaimAfter: 5 * time.Minute,
-		MaxAttempts:  3,
+		Pool:               pool,
+		Logger:             logger,
+		APIKeyID:           apiKeyID,
+		MaxAttempts:        1,
+		WebhookMaxAttempts: 1,
 	})
 	w.executors = map[domain.StepName]StepExecutor{
-		domain.StepLLM:  staticExecutor{payload: json.RawMessage(`{"ok":"llm"}`)},
-		domain.StepTool: staticExecutor{payload: json.RawMessage(`{"ok":"tool"}`)},
+		domain.StepLLM: failingExecutor{err: errors.New("boom")},
 	}
+	w.httpClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})}
 
 	if err := w.ProcessOnce(ctx); err != nil {
 		t.Fatalf("process once: %v", err)
 	}
+	if _, err := w.DispatchWebhooksOnce(ctx); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
 
-	var runBProcessed int
+	var (
+		status    domain.WebhookDeliveryStatus
+		attempts  int
+		lastError string
+	)
 	if err := pool.QueryRow(ctx, `
-		SELECT COUNT(*) FROM steps
-		WHERE run_id=$1 AND status <> $2
-	`, runB, domain.StepPending).Scan(&runBProcessed); err != nil {
-		t.Fatalf("query run B step statuses: %v", err)
+		SELECT status, attempts, last_error
+		FROM webhook_deliveries
+		WHERE run_id=$1
+	`, runID).Scan(&status, &attempts, &lastError); err != nil {
+		t.Fatalf("read delivery: %v", err)
 	}
-	if runBProcessed != 0 {
-		t.Fatalf("expected run B steps to remain pending due concurrent limit, got %d processed", runBProcessed)
+	if status != domain.WebhookFailed || attempts != 1 {
+		t.Fatalf("expected delivery FAILED after 1 attempt, got status=%s attempts=%d", status, attempts)
+	}
+	if !strings.Contains(lastError, "connection refused") {
+		t.Fatalf("expected last_error to record transport error, got %q", lastError)
 	}
 }
 
 type staticExecutor struct {
 	payload json.RawMessage
-	costUSD float64
+	cost    domain.CostDetail
+}
+
+func (s staticExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, domain.CostDetail, error) {
+	return s.payload, s.cost, nil
+}
+
+// itemEchoExecutor returns the MAP item it ran on.
+type itemEchoExecutor struct{}
+
+func (itemEchoExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, domain.CostDetail, error) {
+	item, ok := execs.MapItem(ctx)
+	if !ok {
+		return nil, domain.CostDetail{}, errors.New("not a map child")
+	}
+	out, err := json.Marshal(map[string]json.RawMessage{"item": item})
+	return out, domain.CostDetail{}, err
+}
+
+type loggingExecutor struct {
+	lines []string
 }
 
-func (s staticExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, float64, error) {
-	return s.payload, s.costUSD, nil
+func (e loggingExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, domain.CostDetail, error) {
+	for _, line := range e.lines {
+		execs.StepLogger(ctx).Log(domain.StepLogInfo, line)
+	}
+	return json.RawMessage(`{"ok":true}`), domain.CostDetail{}, nil
 }
 
 type failingExecutor struct {
-	err error
+	err  error
+	cost domain.CostDetail
+}
+
+func (f failingExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, domain.CostDetail, error) {
+	return nil, f.cost, f.err
+}
+
+// cancelAndBlockExecutor cancels the run while its step is executing, then
+// blocks until its context is done.
+type cancelAndBlockExecutor struct {
+	cancel func() error
+}
+
+func (e cancelAndBlockExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, domain.CostDetail, error) {
+	if err := e.cancel(); err != nil {
+		return nil, domain.CostDetail{}, err
+	}
+	<-ctx.Done()
+	return nil, domain.CostDetail{}, ctx.Err()
+}
+
+// cancelingExecutor cancels the run while its step is executing, then
+// succeeds.
+type cancelingExecutor struct {
+	cancel func() error
 }
 
-func (f failingExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, float64, error) {
-	return nil, 0, f.err
+func (e cancelingExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, domain.CostDetail, error) {
+	if err := e.cancel(); err != nil {
+		return nil, domain.CostDetail{}, err
+	}
+	return json.RawMessage(`{"ok":true}`), domain.CostDetail{}, nil
 }
 
 type timeoutExecutor struct{}
 
-func (e timeoutExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, float64, error) {
+func (e timeoutExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, domain.CostDetail, error) {
 	<-ctx.Done()
-	return nil, 0, ctx.Err()
+	return nil, domain.CostDetail{}, ctx.Err()
 }
 
 func workerTruncateAll(ctx context.Context, pool *pgxpool.Pool) error {
-	_, err := pool.Exec(ctx, `TRUNCATE TABLE events, steps, run_requests, runs, api_keys RESTART IDENTITY CASCADE`)
+	_, err := pool.Exec(ctx, `TRUNCATE TABLE outbox_messages, webhook_deliveries, events, steps, run_requests, runs, api_keys RESTART IDENTITY CASCADE`)
 	return err
 }
 
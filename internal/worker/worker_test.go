@@ -6,32 +6,45 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/egress"
+	"github.com/adiadia/agent-runtime/internal/redact"
+	"github.com/adiadia/agent-runtime/internal/secrets"
+	execs "github.com/adiadia/agent-runtime/internal/worker/executors"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type fakeExecutor struct {
-	output json.RawMessage
-	cost   float64
-	err    error
-	called bool
-	runID  uuid.UUID
+	output       json.RawMessage
+	cost         domain.CostDetail
+	err          error
+	called       bool
+	runID        uuid.UUID
+	executionKey string
 }
 
-func (f *fakeExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, float64, error) {
+func (f *fakeExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, domain.CostDetail, error) {
 	f.called = true
 	f.runID = runID
+	f.executionKey, _ = execs.ExecutionKey(ctx)
 	return f.output, f.cost, f.err
 }
 
 func TestNewDefaults(t *testing.T) {
-	w := New(Deps{})
+	w := New(Deps{Queue: &fakeQueue{}})
 
 	if w.logger == nil {
 		t.Fatal("expected default logger to be set")
@@ -48,9 +61,21 @@ func TestNewDefaults(t *testing.T) {
 	if w.defaultStepTimeout != 30*time.Second {
 		t.Fatalf("expected default defaultStepTimeout=30s, got %s", w.defaultStepTimeout)
 	}
+	if w.cancelCheckInterval != 2*time.Second {
+		t.Fatalf("expected default cancelCheckInterval=2s, got %s", w.cancelCheckInterval)
+	}
 	if w.apiKeyID != uuid.Nil {
 		t.Fatalf("expected default apiKeyID to be nil UUID, got %s", w.apiKeyID)
 	}
+	if w.workerID == uuid.Nil {
+		t.Fatal("expected a random workerID by default")
+	}
+	if w.maxStepOutputBytes != 1<<20 {
+		t.Fatalf("expected default maxStepOutputBytes=1MiB, got %d", w.maxStepOutputBytes)
+	}
+	if w.claimBatchSize != 1 {
+		t.Fatalf("expected default claimBatchSize=1, got %d", w.claimBatchSize)
+	}
 
 	if _, ok := w.executors[domain.StepLLM]; !ok {
 		t.Fatal("expected LLM executor to be registered")
@@ -60,17 +85,32 @@ func TestNewDefaults(t *testing.T) {
 	}
 }
 
+func TestNewPanicsWithoutQueue(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("expected New to panic when Pool is not a pgxpool and Queue is nil")
+		}
+	}()
+
+	New(Deps{Pool: &fakeDB{}})
+}
+
 func TestNewCustomValues(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	apiKeyID := uuid.New()
+	workerID := uuid.New()
 
 	w := New(Deps{
+		Queue:              &fakeQueue{},
 		Logger:             logger,
+		WorkerID:           workerID,
 		ReclaimAfter:       30 * time.Second,
 		MaxAttempts:        7,
 		RetryBaseDelay:     9 * time.Second,
 		DefaultStepTimeout: 11 * time.Second,
 		APIKeyID:           apiKeyID,
+		ClaimBatchSize:     16,
+		GlobalStepLimits:   map[domain.StepName]int{domain.StepLLM: 20},
 	})
 
 	if w.logger != logger {
@@ -91,12 +131,54 @@ func TestNewCustomValues(t *testing.T) {
 	if w.apiKeyID != apiKeyID {
 		t.Fatalf("expected apiKeyID=%s, got %s", apiKeyID, w.apiKeyID)
 	}
+	if w.workerID != workerID {
+		t.Fatalf("expected workerID=%s, got %s", workerID, w.workerID)
+	}
+	if w.claimBatchSize != 16 {
+		t.Fatalf("expected claimBatchSize=16, got %d", w.claimBatchSize)
+	}
+	if w.globalStepLimits[domain.StepLLM] != 20 {
+		t.Fatalf("expected global LLM limit 20, got %v", w.globalStepLimits)
+	}
+}
+
+func TestNewWithMockProviders(t *testing.T) {
+	w := New(Deps{Queue: &fakeQueue{}, Mock: &MockConfig{Seed: 7}})
+
+	for _, name := range []domain.StepName{domain.StepLLM, domain.StepTool} {
+		if _, ok := w.executors[name].(*execs.MockExecutor); !ok {
+			t.Fatalf("expected mock executor for %s, got %T", name, w.executors[name])
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "https://receiver.invalid/hook", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected mock webhook delivery without network, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status 204 got %d", resp.StatusCode)
+	}
+
+	failing := New(Deps{Queue: &fakeQueue{}, Mock: &MockConfig{FailureRate: 1}})
+	resp, err = failing.httpClient.Do(req)
+	if err != nil {
+		t.Fatalf("mock webhook delivery: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503 at failure rate 1, got %d", resp.StatusCode)
+	}
 }
 
 func TestExecuteStepSuccess(t *testing.T) {
 	runID := uuid.New()
 	want := json.RawMessage(`{"ok":true}`)
-	exec := &fakeExecutor{output: want}
+	exec := &fakeExecutor{output: want, cost: domain.CostDetail{Provider: "openai", Model: "gpt-4o", CostUSD: 0.5}}
 
 	w := &Worker{
 		executors: map[domain.StepName]StepExecutor{
@@ -104,9 +186,13 @@ func TestExecuteStepSuccess(t *testing.T) {
 		},
 	}
 
-	out, cost, err := w.executeStep(context.Background(), claimedStep{
-		RunID: runID,
-		Name:  domain.StepLLM,
+	stepID := uuid.New()
+	out, cost, err := w.executeStep(context.Background(), domain.ClaimedStep{
+		StepID:           stepID,
+		RunID:            runID,
+		Name:             domain.StepLLM,
+		Attempt:          3,
+		ExecutionAttempt: 2,
 	})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -118,11 +204,14 @@ func TestExecuteStepSuccess(t *testing.T) {
 	if exec.runID != runID {
 		t.Fatalf("expected run id %s got %s", runID, exec.runID)
 	}
+	if want := stepID.String() + ":2"; exec.executionKey != want {
+		t.Fatalf("expected execution key %s got %q", want, exec.executionKey)
+	}
 	if string(out) != string(want) {
 		t.Fatalf("expected output %s got %s", string(want), string(out))
 	}
-	if cost != 0 {
-		t.Fatalf("expected cost 0 got %f", cost)
+	if cost != exec.cost {
+		t.Fatalf("expected cost %+v got %+v", exec.cost, cost)
 	}
 }
 
@@ -136,7 +225,7 @@ func TestExecuteStepError(t *testing.T) {
 		},
 	}
 
-	_, _, err := w.executeStep(context.Background(), claimedStep{
+	_, _, err := w.executeStep(context.Background(), domain.ClaimedStep{
 		RunID: uuid.New(),
 		Name:  domain.StepTool,
 	})
@@ -147,9 +236,9 @@ func TestExecuteStepError(t *testing.T) {
 
 type blockingExecutor struct{}
 
-func (b *blockingExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, float64, error) {
+func (b *blockingExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, domain.CostDetail, error) {
 	<-ctx.Done()
-	return nil, 0, ctx.Err()
+	return nil, domain.CostDetail{}, ctx.Err()
 }
 
 func TestExecuteStepTimeout(t *testing.T) {
@@ -159,7 +248,7 @@ func TestExecuteStepTimeout(t *testing.T) {
 		},
 	}
 
-	_, _, err := w.executeStep(context.Background(), claimedStep{
+	_, _, err := w.executeStep(context.Background(), domain.ClaimedStep{
 		RunID:   uuid.New(),
 		Name:    domain.StepLLM,
 		Timeout: 20 * time.Millisecond,
@@ -174,7 +263,7 @@ func TestExecuteStepMissingExecutor(t *testing.T) {
 		executors: map[domain.StepName]StepExecutor{},
 	}
 
-	_, _, err := w.executeStep(context.Background(), claimedStep{
+	_, _, err := w.executeStep(context.Background(), domain.ClaimedStep{
 		RunID: uuid.New(),
 		Name:  domain.StepApproval,
 	})
@@ -185,3 +274,377 @@ func TestExecuteStepMissingExecutor(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestExecuteStepInvalidEgressHostsIsPermanent(t *testing.T) {
+	w := New(Deps{Queue: &fakeQueue{}})
+
+	_, _, err := w.executeStep(context.Background(), domain.ClaimedStep{
+		RunID:       uuid.New(),
+		Name:        domain.StepTool,
+		HTTP:        &domain.HTTPRequest{Method: "GET", URL: "https://api.example.com"},
+		EgressHosts: []string{"not a host"},
+	})
+	if !errors.Is(err, egress.ErrInvalidHostRule) || !execs.IsPermanent(err) {
+		t.Fatalf("expected a permanent invalid host rule error, got %v", err)
+	}
+}
+
+func TestStepLogSanitizesAndBoundsLines(t *testing.T) {
+	l := &stepLog{w: New(Deps{Queue: &fakeQueue{}}), full: make(chan struct{}, 1)}
+
+	l.Log("loud", "a\x00b")
+	l.Log(domain.StepLogError, strings.Repeat("x", domain.MaxStepLogLineBytes+10))
+	if got := l.pending[0]; got.level != domain.StepLogInfo || got.line != "ab" {
+		t.Fatalf("expected unknown level mapped to info and NUL stripped, got %+v", got)
+	}
+	if got := len(l.pending[1].line); got != domain.MaxStepLogLineBytes {
+		t.Fatalf("expected long line cut to %d bytes, got %d", domain.MaxStepLogLineBytes, got)
+	}
+
+	for range stepLogMaxPending {
+		l.Log(domain.StepLogDebug, "spam")
+	}
+	if len(l.pending) != stepLogMaxPending || l.dropped != 2 {
+		t.Fatalf("expected buffer capped at %d with 2 dropped, got %d pending and %d dropped", stepLogMaxPending, len(l.pending), l.dropped)
+	}
+	select {
+	case <-l.full:
+	default:
+		t.Fatal("expected a full batch to wake the writer")
+	}
+}
+
+func TestStepProgressSnapshotMasksAndBoundsPreview(t *testing.T) {
+	p := &stepProgress{step: domain.ClaimedStep{Name: domain.StepLLM, Attempt: 2}}
+	if _, ok := p.snapshot(); ok {
+		t.Fatal("expected no event before any output")
+	}
+
+	p.maskSecrets([]string{"sk-live"})
+	p.Stream(strings.Repeat("é", stepProgressPreviewBytes))
+	p.Stream("key sk-")
+	p.Stream("")
+	p.Stream("live\x00 done")
+
+	payload, ok := p.snapshot()
+	if !ok {
+		t.Fatal("expected an event after output arrived")
+	}
+	preview := payload["preview"].(string)
+	if !utf8.ValidString(preview) || len(preview) > stepProgressPreviewBytes {
+		t.Fatalf("expected a valid preview of at most %d bytes, got %d", stepProgressPreviewBytes, len(preview))
+	}
+	if !strings.HasSuffix(preview, "key "+secrets.Masked+" done") {
+		t.Fatalf("expected the split secret masked and NUL stripped, got %q", preview)
+	}
+	if payload["chunks"] != 3 || payload["output_bytes"] != 2*stepProgressPreviewBytes+17 || payload["attempt"] != 2 {
+		t.Fatalf("unexpected totals: %+v", payload)
+	}
+
+	if _, ok := p.snapshot(); ok {
+		t.Fatal("expected no event without new output")
+	}
+}
+
+func TestStepProgressReportsPercentAndMessage(t *testing.T) {
+	p := &stepProgress{step: domain.ClaimedStep{Name: domain.StepTool, Attempt: 1}}
+	p.maskSecrets([]string{"hunter2"})
+
+	p.EmitProgress(140, "uploading with hunter2")
+	payload, ok := p.snapshot()
+	if !ok {
+		t.Fatal("expected an event after a report")
+	}
+	if payload["percent"] != 100.0 || payload["message"] != "uploading with "+secrets.Masked {
+		t.Fatalf("expected percent clamped and message masked, got %+v", payload)
+	}
+	if _, streamed := payload["preview"]; streamed {
+		t.Fatalf("expected no output fields without streamed output, got %+v", payload)
+	}
+
+	p.EmitProgress(-5, strings.Repeat("x", stepProgressMessageBytes+1))
+	payload, _ = p.snapshot()
+	if _, ok := payload["percent"]; ok || len(payload["message"].(string)) != stepProgressMessageBytes {
+		t.Fatalf("expected unknown percent omitted and message bounded, got %+v", payload)
+	}
+}
+
+func TestExecuteStepRedactsPersonalData(t *testing.T) {
+	redactor, err := redact.ParseRules(`[{"pattern": "[a-z]+@example\\.com"}, {"path": "$.prompt"}]`)
+	if err != nil {
+		t.Fatalf("parse rules: %v", err)
+	}
+	w := New(Deps{Queue: &fakeQueue{}, Redactor: redactor})
+	w.executors = map[domain.StepName]StepExecutor{
+		domain.StepLLM:  &fakeExecutor{output: json.RawMessage(`{"prompt":"hi ann","reply":"mail ann@example.com"}`)},
+		domain.StepTool: &fakeExecutor{err: execs.Permanent(errors.New("rejected ann@example.com"))},
+	}
+
+	out, _, err := w.executeStep(context.Background(), domain.ClaimedStep{RunID: uuid.New(), Name: domain.StepLLM})
+	if err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if string(out) != `{"prompt":"[REDACTED]","reply":"mail [REDACTED]"}` {
+		t.Fatalf("expected redacted output, got %s", out)
+	}
+
+	_, _, err = w.executeStep(context.Background(), domain.ClaimedStep{RunID: uuid.New(), Name: domain.StepTool})
+	if err == nil || err.Error() != "rejected [REDACTED]" || !execs.IsPermanent(err) {
+		t.Fatalf("expected redacted permanent error, got %v", err)
+	}
+
+	l := &stepLog{w: w, full: make(chan struct{}, 1)}
+	l.Log(domain.StepLogInfo, "sending to ann@example.com")
+	if got := l.pending[0].line; got != "sending to [REDACTED]" {
+		t.Fatalf("expected redacted log line, got %q", got)
+	}
+}
+
+func TestWorkerPricingDeclaredByExecutors(t *testing.T) {
+	pricing := New(Deps{Queue: &fakeQueue{}}).Pricing()
+	if llm := pricing[domain.StepLLM]; llm.Unit != domain.PricingUnitToken || llm.UnitPriceUSD <= 0 || llm.MinUnits <= 0 {
+		t.Fatalf("expected LLM token pricing, got %+v", llm)
+	}
+	if tool, ok := pricing[domain.StepTool]; !ok || tool.Unit != domain.PricingUnitCall {
+		t.Fatalf("expected TOOL call pricing, got %+v", pricing)
+	}
+
+	w := New(Deps{Queue: &fakeQueue{}})
+	w.executors = map[domain.StepName]StepExecutor{domain.StepLLM: &fakeExecutor{}}
+	if got := w.Pricing(); len(got) != 0 {
+		t.Fatalf("expected executors without pricing left out, got %+v", got)
+	}
+}
+
+func TestBoundStepOutput(t *testing.T) {
+	small := json.RawMessage(`{"ok":true}`)
+	if got, truncated := boundStepOutput(small, 64); truncated || string(got) != string(small) {
+		t.Fatalf("expected output within the limit unchanged, got %s (truncated=%v)", got, truncated)
+	}
+
+	large := json.RawMessage(`{"text":"` + strings.Repeat("a", 500) + `"}`)
+	got, truncated := boundStepOutput(large, 200)
+	if !truncated || len(got) > 200 {
+		t.Fatalf("expected truncated output within 200 bytes, got %d bytes (truncated=%v)", len(got), truncated)
+	}
+	var stub struct {
+		Truncated     bool   `json:"truncated"`
+		OriginalBytes int    `json:"original_bytes"`
+		Preview       string `json:"preview"`
+	}
+	if err := json.Unmarshal(got, &stub); err != nil {
+		t.Fatalf("expected valid JSON, got %s: %v", got, err)
+	}
+	if !stub.Truncated || stub.OriginalBytes != len(large) || !strings.HasPrefix(string(large), stub.Preview) || stub.Preview == "" {
+		t.Fatalf("unexpected truncated output %+v", stub)
+	}
+
+	// Output that escapes badly loses its preview rather than the limit.
+	quotes := json.RawMessage(`"` + strings.Repeat(`\u0000`, 100) + `"`)
+	got, truncated = boundStepOutput(quotes, 60)
+	if !truncated || len(got) > 60 || strings.Contains(string(got), "preview") {
+		t.Fatalf("expected a preview-less stand-in within 60 bytes, got %s", got)
+	}
+}
+
+// fakeDB answers single-statement queries without a database.
+type fakeDB struct {
+	rows []fakeRow
+}
+
+func (f *fakeDB) Begin(context.Context) (pgx.Tx, error) {
+	return nil, errors.New("fakeDB: transactions not supported")
+}
+
+func (f *fakeDB) Exec(context.Context, string, ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+func (f *fakeDB) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	if f.rows == nil {
+		return nil, errors.New("fakeDB: queries not supported")
+	}
+	return &fakeRows{rows: f.rows, at: -1}, nil
+}
+
+func (f *fakeDB) QueryRow(context.Context, string, ...any) pgx.Row {
+	return fakeRow(nil)
+}
+
+// fakeRow scans its values into same-typed destinations.
+type fakeRow []any
+
+func (r fakeRow) Scan(dest ...any) error {
+	if len(r) == 0 {
+		return pgx.ErrNoRows
+	}
+	for i, d := range dest {
+		reflect.ValueOf(d).Elem().Set(reflect.ValueOf(r[i]))
+	}
+	return nil
+}
+
+// fakeRows iterates fakeRow values.
+type fakeRows struct {
+	rows []fakeRow
+	at   int
+}
+
+func (r *fakeRows) Close()                                       {}
+func (r *fakeRows) Err() error                                   { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *fakeRows) Next() bool                                   { r.at++; return r.at < len(r.rows) }
+func (r *fakeRows) Scan(dest ...any) error                       { return r.rows[r.at].Scan(dest...) }
+func (r *fakeRows) Values() ([]any, error)                       { return r.rows[r.at], nil }
+func (r *fakeRows) RawValues() [][]byte                          { return nil }
+func (r *fakeRows) Conn() *pgx.Conn                              { return nil }
+
+// fakeQueue hands out step once and records how it was settled.
+type fakeQueue struct {
+	step      domain.ClaimedStep
+	claimed   bool
+	req       domain.ClaimRequest
+	completed *domain.StepCompletion
+	failed    *domain.StepFailure
+	// held and canceled answer RenewLease and StepCanceled; lease records
+	// the last renewal.
+	held     bool
+	canceled bool
+	lease    time.Duration
+}
+
+func (q *fakeQueue) ClaimStep(_ context.Context, req domain.ClaimRequest) (domain.ClaimedStep, error) {
+	if q.claimed {
+		return domain.ClaimedStep{}, domain.ErrNoStepToClaim
+	}
+	q.claimed, q.req = true, req
+	return q.step, nil
+}
+
+func (q *fakeQueue) ClaimSteps(ctx context.Context, req domain.ClaimRequest, _ int) ([]domain.ClaimedStep, error) {
+	step, err := q.ClaimStep(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return []domain.ClaimedStep{step}, nil
+}
+
+func (q *fakeQueue) CompleteStep(_ context.Context, _ domain.ClaimedStep, result domain.StepCompletion) error {
+	q.completed = &result
+	return nil
+}
+
+func (q *fakeQueue) FailStep(_ context.Context, _ domain.ClaimedStep, failure domain.StepFailure) error {
+	q.failed = &failure
+	return nil
+}
+
+func (q *fakeQueue) CancelStep(context.Context, domain.ClaimedStep) error {
+	return errors.New("fakeQueue: unexpected cancel")
+}
+
+func (q *fakeQueue) RenewLease(_ context.Context, _ domain.ClaimedStep, lease time.Duration) (bool, error) {
+	q.lease = lease
+	return q.held, nil
+}
+
+func (q *fakeQueue) StepCanceled(context.Context, domain.ClaimedStep) (bool, error) {
+	return q.canceled, nil
+}
+
+func (q *fakeQueue) AppendStepEvent(context.Context, domain.ClaimedStep, string, any) error {
+	return nil
+}
+
+func TestWorkerSettlesThroughQueue(t *testing.T) {
+	apiKeyID := uuid.New()
+	queue := &fakeQueue{step: domain.ClaimedStep{
+		StepID:           uuid.New(),
+		RunID:            uuid.New(),
+		APIKeyID:         apiKeyID,
+		Name:             domain.StepLLM,
+		Status:           domain.StepPending,
+		Attempt:          1,
+		ExecutionAttempt: 1,
+	}}
+	executor := &fakeExecutor{output: json.RawMessage(`{"ok":true}`), cost: domain.CostDetail{CostUSD: 0.5}}
+	w := New(Deps{
+		Pool:         &fakeDB{},
+		Queue:        queue,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		APIKeyID:     apiKeyID,
+		ReclaimAfter: time.Minute,
+		MaxAttempts:  5,
+	})
+	w.executors[domain.StepLLM] = executor
+
+	if err := w.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if queue.req.APIKeyID != apiKeyID || queue.req.WorkerID != w.workerID || queue.req.Lease != time.Minute {
+		t.Fatalf("unexpected claim request %+v", queue.req)
+	}
+	if queue.completed == nil || string(queue.completed.Output) != `{"ok":true}` || queue.completed.Cost.CostUSD != 0.5 {
+		t.Fatalf("expected the step completed with its output and cost, got %+v", queue.completed)
+	}
+	if err := w.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("expected an empty queue to be no error, got %v", err)
+	}
+
+	queue.claimed, executor.err = false, errors.New("provider down")
+	if err := w.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if queue.failed == nil || queue.failed.Err != executor.err || queue.failed.Permanent || queue.failed.Retry.MaxAttempts != 5 {
+		t.Fatalf("expected a retryable failure under the worker's policy, got %+v", queue.failed)
+	}
+
+	queue.claimed, executor.err = false, execs.Permanent(errors.New("bad input"))
+	if err := w.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if !queue.failed.Permanent {
+		t.Fatalf("expected a permanent failure, got %+v", queue.failed)
+	}
+
+	queue.claimed, queue.step.ConfigErr = false, fmt.Errorf("%w: %q", egress.ErrInvalidHostRule, "not a host")
+	if err := w.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if !queue.failed.Permanent || !errors.Is(queue.failed.Err, egress.ErrInvalidHostRule) {
+		t.Fatalf("expected an invalid egress allow-list to fail permanently, got %+v", queue.failed)
+	}
+}
+
+func TestWorkerRenewsLeaseThroughQueue(t *testing.T) {
+	queue := &fakeQueue{held: true}
+	w := New(Deps{Queue: queue, ReclaimAfter: time.Minute})
+	step := domain.ClaimedStep{StepID: uuid.New(), RunID: uuid.New()}
+
+	if held, err := w.renewLease(context.Background(), step); err != nil || !held || queue.lease != time.Minute {
+		t.Fatalf("expected lease renewed for a minute, got %v, %v, %s", held, err, queue.lease)
+	}
+	queue.held = false
+	if held, err := w.renewLease(context.Background(), step); err != nil || held {
+		t.Fatalf("expected lease lost, got %v, %v", held, err)
+	}
+}
+
+func TestHostTransportsBlockPrivateUnlessAllowed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	hosts, _ := egress.ParseHostRules([]string{"127.0.0.1"})
+
+	client := &http.Client{Transport: newHostTransports(nil, false).forHosts(hosts)}
+	if _, err := client.Get(srv.URL); !errors.Is(err, egress.ErrBlocked) {
+		t.Fatalf("expected a listed loopback address blocked, got %v", err)
+	}
+
+	client = &http.Client{Transport: newHostTransports(nil, true).forHosts(hosts)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("expected loopback allowed when private targets are, got %v", err)
+	}
+	resp.Body.Close()
+}
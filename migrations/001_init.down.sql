@@ -0,0 +1,9 @@
+-- Drops the whole schema. Later down migrations have already removed
+-- everything added after this one.
+DROP TABLE IF EXISTS workflow_template_steps;
+DROP TABLE IF EXISTS workflow_templates;
+DROP TABLE IF EXISTS run_requests;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS steps;
+DROP TABLE IF EXISTS runs;
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,2 @@
+-- 001_init already creates these columns; keep them so the schema matches a
+-- fresh install at version 1.
@@ -0,0 +1 @@
+-- 001_init already creates steps.timeout_seconds; nothing to undo.
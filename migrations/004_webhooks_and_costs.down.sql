@@ -0,0 +1 @@
+-- 001_init already creates these columns; nothing to undo.
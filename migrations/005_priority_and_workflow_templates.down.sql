@@ -0,0 +1,2 @@
+-- 001_init already creates runs.priority and the template tables; nothing to
+-- undo.
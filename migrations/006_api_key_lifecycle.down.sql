@@ -0,0 +1,2 @@
+-- Plaintext tokens cannot be recovered from their hashes, and 001_init already
+-- has name and token_hash; nothing to undo.
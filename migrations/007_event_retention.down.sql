@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_events_created_at;
+
+ALTER TABLE api_keys
+    DROP CONSTRAINT IF EXISTS api_keys_event_retention_days_positive;
+
+ALTER TABLE api_keys
+    DROP COLUMN IF EXISTS event_retention_days;
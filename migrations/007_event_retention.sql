@@ -0,0 +1,11 @@
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS event_retention_days INT NULL;
+
+ALTER TABLE api_keys
+    DROP CONSTRAINT IF EXISTS api_keys_event_retention_days_positive;
+
+ALTER TABLE api_keys
+    ADD CONSTRAINT api_keys_event_retention_days_positive
+    CHECK (event_retention_days IS NULL OR event_retention_days > 0);
+
+CREATE INDEX IF NOT EXISTS idx_events_created_at ON events(created_at);
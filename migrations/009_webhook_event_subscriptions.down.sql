@@ -0,0 +1,8 @@
+ALTER TABLE webhook_deliveries
+    ALTER COLUMN max_attempts DROP DEFAULT;
+
+ALTER TABLE webhook_deliveries
+    DROP COLUMN IF EXISTS event_id;
+
+ALTER TABLE runs
+    DROP COLUMN IF EXISTS webhook_events;
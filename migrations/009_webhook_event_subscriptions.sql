@@ -0,0 +1,8 @@
+ALTER TABLE runs
+    ADD COLUMN IF NOT EXISTS webhook_events TEXT[] NOT NULL DEFAULT '{}';
+
+ALTER TABLE webhook_deliveries
+    ADD COLUMN IF NOT EXISTS event_id UUID NULL;
+
+ALTER TABLE webhook_deliveries
+    ALTER COLUMN max_attempts SET DEFAULT 8;
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS runs_daily_stats_update ON runs;
+DROP TRIGGER IF EXISTS runs_daily_stats_insert ON runs;
+DROP FUNCTION IF EXISTS run_daily_stats_on_update();
+DROP FUNCTION IF EXISTS run_daily_stats_on_insert();
+DROP TABLE IF EXISTS run_daily_stats;
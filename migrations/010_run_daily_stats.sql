@@ -0,0 +1,103 @@
+CREATE TABLE IF NOT EXISTS run_daily_stats (
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    runs_created BIGINT NOT NULL DEFAULT 0,
+    runs_succeeded BIGINT NOT NULL DEFAULT 0,
+    runs_failed BIGINT NOT NULL DEFAULT 0,
+    runs_canceled BIGINT NOT NULL DEFAULT 0,
+    total_cost_usd NUMERIC(16,6) NOT NULL DEFAULT 0,
+    total_duration_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (api_key_id, day)
+);
+
+-- Runs are counted on the day they are created; completions, cost, and
+-- duration are counted on the day the run reaches a terminal status.
+CREATE OR REPLACE FUNCTION run_daily_stats_on_insert() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO run_daily_stats (api_key_id, day, runs_created)
+    VALUES (NEW.api_key_id, NEW.created_at::date, 1)
+    ON CONFLICT (api_key_id, day) DO UPDATE
+    SET runs_created = run_daily_stats.runs_created + 1,
+        updated_at = NOW();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION run_daily_stats_on_update() RETURNS trigger AS $$
+DECLARE
+    old_terminal BOOLEAN := OLD.status IN ('SUCCEEDED', 'FAILED', 'CANCELED');
+    new_terminal BOOLEAN := NEW.status IN ('SUCCEEDED', 'FAILED', 'CANCELED');
+BEGIN
+    IF new_terminal AND NOT old_terminal THEN
+        INSERT INTO run_daily_stats (
+            api_key_id, day, runs_succeeded, runs_failed, runs_canceled,
+            total_cost_usd, total_duration_seconds
+        )
+        VALUES (
+            NEW.api_key_id,
+            NEW.updated_at::date,
+            CASE WHEN NEW.status = 'SUCCEEDED' THEN 1 ELSE 0 END,
+            CASE WHEN NEW.status = 'FAILED' THEN 1 ELSE 0 END,
+            CASE WHEN NEW.status = 'CANCELED' THEN 1 ELSE 0 END,
+            NEW.total_cost_usd,
+            GREATEST(EXTRACT(EPOCH FROM (NEW.updated_at - NEW.created_at)), 0)
+        )
+        ON CONFLICT (api_key_id, day) DO UPDATE
+        SET runs_succeeded = run_daily_stats.runs_succeeded + EXCLUDED.runs_succeeded,
+            runs_failed = run_daily_stats.runs_failed + EXCLUDED.runs_failed,
+            runs_canceled = run_daily_stats.runs_canceled + EXCLUDED.runs_canceled,
+            total_cost_usd = run_daily_stats.total_cost_usd + EXCLUDED.total_cost_usd,
+            total_duration_seconds = run_daily_stats.total_duration_seconds + EXCLUDED.total_duration_seconds,
+            updated_at = NOW();
+    ELSIF old_terminal AND new_terminal AND NEW.total_cost_usd <> OLD.total_cost_usd THEN
+        -- A step that was in flight when the run was canceled can still bill
+        -- cost after the run finished.
+        INSERT INTO run_daily_stats (api_key_id, day, total_cost_usd)
+        VALUES (NEW.api_key_id, NEW.updated_at::date, NEW.total_cost_usd - OLD.total_cost_usd)
+        ON CONFLICT (api_key_id, day) DO UPDATE
+        SET total_cost_usd = run_daily_stats.total_cost_usd + EXCLUDED.total_cost_usd,
+            updated_at = NOW();
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS runs_daily_stats_insert ON runs;
+CREATE TRIGGER runs_daily_stats_insert
+    AFTER INSERT ON runs
+    FOR EACH ROW EXECUTE FUNCTION run_daily_stats_on_insert();
+
+DROP TRIGGER IF EXISTS runs_daily_stats_update ON runs;
+CREATE TRIGGER runs_daily_stats_update
+    AFTER UPDATE OF status, total_cost_usd ON runs
+    FOR EACH ROW EXECUTE FUNCTION run_daily_stats_on_update();
+
+-- Backfill from runs that existed before the summary table.
+INSERT INTO run_daily_stats (api_key_id, day, runs_created)
+SELECT api_key_id, created_at::date, COUNT(*)
+FROM runs
+GROUP BY api_key_id, created_at::date
+ON CONFLICT (api_key_id, day) DO UPDATE
+SET runs_created = EXCLUDED.runs_created;
+
+INSERT INTO run_daily_stats (
+    api_key_id, day, runs_succeeded, runs_failed, runs_canceled,
+    total_cost_usd, total_duration_seconds
+)
+SELECT api_key_id,
+       updated_at::date,
+       COUNT(*) FILTER (WHERE status = 'SUCCEEDED'),
+       COUNT(*) FILTER (WHERE status = 'FAILED'),
+       COUNT(*) FILTER (WHERE status = 'CANCELED'),
+       COALESCE(SUM(total_cost_usd), 0),
+       COALESCE(SUM(GREATEST(EXTRACT(EPOCH FROM (updated_at - created_at)), 0)), 0)
+FROM runs
+WHERE status IN ('SUCCEEDED', 'FAILED', 'CANCELED')
+GROUP BY api_key_id, updated_at::date
+ON CONFLICT (api_key_id, day) DO UPDATE
+SET runs_succeeded = EXCLUDED.runs_succeeded,
+    runs_failed = EXCLUDED.runs_failed,
+    runs_canceled = EXCLUDED.runs_canceled,
+    total_cost_usd = EXCLUDED.total_cost_usd,
+    total_duration_seconds = EXCLUDED.total_duration_seconds;
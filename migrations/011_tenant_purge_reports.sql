@@ -0,0 +1,10 @@
+-- No foreign key to api_keys: reports must outlive the data they describe.
+CREATE TABLE IF NOT EXISTS tenant_purge_reports (
+    id UUID PRIMARY KEY,
+    api_key_id UUID NOT NULL,
+    report JSONB NOT NULL,
+    signature TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_tenant_purge_reports_api_key_id ON tenant_purge_reports(api_key_id);
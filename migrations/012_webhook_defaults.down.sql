@@ -0,0 +1,3 @@
+ALTER TABLE api_keys
+    DROP COLUMN IF EXISTS default_webhook_secret,
+    DROP COLUMN IF EXISTS default_webhook_url;
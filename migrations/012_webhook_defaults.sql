@@ -0,0 +1,5 @@
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS default_webhook_url TEXT NULL;
+
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS default_webhook_secret TEXT NULL;
@@ -0,0 +1,12 @@
+ALTER TABLE webhook_deliveries
+    ADD COLUMN IF NOT EXISTS first_attempted_at TIMESTAMP NULL;
+
+UPDATE webhook_deliveries d
+SET first_attempted_at = a.first_attempted_at
+FROM (
+    SELECT delivery_id, MIN(created_at) AS first_attempted_at
+    FROM webhook_attempts
+    GROUP BY delivery_id
+) a
+WHERE a.delivery_id = d.id
+  AND d.first_attempted_at IS NULL;
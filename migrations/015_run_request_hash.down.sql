@@ -0,0 +1,2 @@
+ALTER TABLE run_requests
+    DROP COLUMN IF EXISTS request_hash;
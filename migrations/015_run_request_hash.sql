@@ -0,0 +1,2 @@
+ALTER TABLE run_requests
+    ADD COLUMN IF NOT EXISTS request_hash TEXT NULL;
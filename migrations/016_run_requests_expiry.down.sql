@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_run_requests_created_at;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_run_requests_created_at ON run_requests(created_at);
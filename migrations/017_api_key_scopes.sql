@@ -0,0 +1,3 @@
+-- Existing keys keep full access; new keys may be created with fewer scopes.
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS scopes TEXT[] NOT NULL DEFAULT ARRAY['approvals:write', 'runs:read', 'runs:write']::TEXT[];
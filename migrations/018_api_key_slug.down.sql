@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_api_keys_slug;
+
+ALTER TABLE api_keys
+    DROP COLUMN IF EXISTS slug;
@@ -0,0 +1,7 @@
+-- Optional human-friendly tenant identifier, usable in place of the UUID in
+-- admin paths and logs. Slugs stay reserved after revocation so old log lines
+-- keep pointing at one tenant.
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS slug TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_slug ON api_keys (slug) WHERE slug IS NOT NULL;
@@ -0,0 +1,3 @@
+-- Keys without an expiry never expire.
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
@@ -0,0 +1,11 @@
+-- Versioned per-tenant webhook signing secrets. Runs without a secret of their
+-- own are signed with every unexpired version; rotating sets an expiry on the
+-- previous versions so receivers can switch secrets without missing a delivery.
+CREATE TABLE IF NOT EXISTS webhook_signing_keys (
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    secret TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP,
+    PRIMARY KEY (api_key_id, version)
+);
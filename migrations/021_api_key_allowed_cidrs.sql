@@ -0,0 +1,3 @@
+-- Optional per-key client IP allow-list; NULL allows any address.
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS allowed_cidrs TEXT[];
@@ -0,0 +1,15 @@
+-- Registry of running worker processes: which binary version each runs, the
+-- schema version it was built against, and the features it supports.
+CREATE TABLE IF NOT EXISTS workers (
+    id UUID PRIMARY KEY,
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    hostname TEXT NOT NULL,
+    version TEXT NOT NULL,
+    min_schema_version INT NOT NULL,
+    features TEXT[] NOT NULL DEFAULT '{}',
+    started_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_workers_api_key_last_seen
+    ON workers (api_key_id, last_seen_at DESC);
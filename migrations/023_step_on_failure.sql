@@ -0,0 +1,10 @@
+-- Per-step failure policy: fail_run (default) fails the run when the step
+-- exhausts its attempts, skip marks the step SKIPPED, and continue leaves it
+-- FAILED; with skip and continue the run carries on with the next step.
+ALTER TABLE workflow_template_steps
+    ADD COLUMN IF NOT EXISTS on_failure TEXT NOT NULL DEFAULT 'fail_run'
+    CHECK (on_failure IN ('fail_run', 'skip', 'continue'));
+
+ALTER TABLE steps
+    ADD COLUMN IF NOT EXISTS on_failure TEXT NOT NULL DEFAULT 'fail_run'
+    CHECK (on_failure IN ('fail_run', 'skip', 'continue'));
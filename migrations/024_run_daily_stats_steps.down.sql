@@ -0,0 +1,43 @@
+-- Restores the trigger function from 010_run_daily_stats.
+CREATE OR REPLACE FUNCTION run_daily_stats_on_update() RETURNS trigger AS $$
+DECLARE
+    old_terminal BOOLEAN := OLD.status IN ('SUCCEEDED', 'FAILED', 'CANCELED');
+    new_terminal BOOLEAN := NEW.status IN ('SUCCEEDED', 'FAILED', 'CANCELED');
+BEGIN
+    IF new_terminal AND NOT old_terminal THEN
+        INSERT INTO run_daily_stats (
+            api_key_id, day, runs_succeeded, runs_failed, runs_canceled,
+            total_cost_usd, total_duration_seconds
+        )
+        VALUES (
+            NEW.api_key_id,
+            NEW.updated_at::date,
+            CASE WHEN NEW.status = 'SUCCEEDED' THEN 1 ELSE 0 END,
+            CASE WHEN NEW.status = 'FAILED' THEN 1 ELSE 0 END,
+            CASE WHEN NEW.status = 'CANCELED' THEN 1 ELSE 0 END,
+            NEW.total_cost_usd,
+            GREATEST(EXTRACT(EPOCH FROM (NEW.updated_at - NEW.created_at)), 0)
+        )
+        ON CONFLICT (api_key_id, day) DO UPDATE
+        SET runs_succeeded = run_daily_stats.runs_succeeded + EXCLUDED.runs_succeeded,
+            runs_failed = run_daily_stats.runs_failed + EXCLUDED.runs_failed,
+            runs_canceled = run_daily_stats.runs_canceled + EXCLUDED.runs_canceled,
+            total_cost_usd = run_daily_stats.total_cost_usd + EXCLUDED.total_cost_usd,
+            total_duration_seconds = run_daily_stats.total_duration_seconds + EXCLUDED.total_duration_seconds,
+            updated_at = NOW();
+    ELSIF old_terminal AND new_terminal AND NEW.total_cost_usd <> OLD.total_cost_usd THEN
+        -- A step that was in flight when the run was canceled can still bill
+        -- cost after the run finished.
+        INSERT INTO run_daily_stats (api_key_id, day, total_cost_usd)
+        VALUES (NEW.api_key_id, NEW.updated_at::date, NEW.total_cost_usd - OLD.total_cost_usd)
+        ON CONFLICT (api_key_id, day) DO UPDATE
+        SET total_cost_usd = run_daily_stats.total_cost_usd + EXCLUDED.total_cost_usd,
+            updated_at = NOW();
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE run_daily_stats
+    DROP COLUMN IF EXISTS step_retries,
+    DROP COLUMN IF EXISTS steps_executed;
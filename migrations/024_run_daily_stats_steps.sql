@@ -0,0 +1,73 @@
+-- Step usage for GET /usage. Like cost and duration, executed steps and
+-- retries are counted on the day their run reaches a terminal status.
+ALTER TABLE run_daily_stats
+    ADD COLUMN IF NOT EXISTS steps_executed BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS step_retries BIGINT NOT NULL DEFAULT 0;
+
+CREATE OR REPLACE FUNCTION run_daily_stats_on_update() RETURNS trigger AS $$
+DECLARE
+    old_terminal BOOLEAN := OLD.status IN ('SUCCEEDED', 'FAILED', 'CANCELED');
+    new_terminal BOOLEAN := NEW.status IN ('SUCCEEDED', 'FAILED', 'CANCELED');
+    executed BIGINT;
+    retries BIGINT;
+BEGIN
+    IF new_terminal AND NOT old_terminal THEN
+        SELECT COUNT(*) FILTER (WHERE attempts > 0),
+               COALESCE(SUM(GREATEST(attempts - 1, 0)), 0)
+        INTO executed, retries
+        FROM steps
+        WHERE run_id = NEW.id;
+
+        INSERT INTO run_daily_stats (
+            api_key_id, day, runs_succeeded, runs_failed, runs_canceled,
+            total_cost_usd, total_duration_seconds, steps_executed, step_retries
+        )
+        VALUES (
+            NEW.api_key_id,
+            NEW.updated_at::date,
+            CASE WHEN NEW.status = 'SUCCEEDED' THEN 1 ELSE 0 END,
+            CASE WHEN NEW.status = 'FAILED' THEN 1 ELSE 0 END,
+            CASE WHEN NEW.status = 'CANCELED' THEN 1 ELSE 0 END,
+            NEW.total_cost_usd,
+            GREATEST(EXTRACT(EPOCH FROM (NEW.updated_at - NEW.created_at)), 0),
+            executed,
+            retries
+        )
+        ON CONFLICT (api_key_id, day) DO UPDATE
+        SET runs_succeeded = run_daily_stats.runs_succeeded + EXCLUDED.runs_succeeded,
+            runs_failed = run_daily_stats.runs_failed + EXCLUDED.runs_failed,
+            runs_canceled = run_daily_stats.runs_canceled + EXCLUDED.runs_canceled,
+            total_cost_usd = run_daily_stats.total_cost_usd + EXCLUDED.total_cost_usd,
+            total_duration_seconds = run_daily_stats.total_duration_seconds + EXCLUDED.total_duration_seconds,
+            steps_executed = run_daily_stats.steps_executed + EXCLUDED.steps_executed,
+            step_retries = run_daily_stats.step_retries + EXCLUDED.step_retries,
+            updated_at = NOW();
+    ELSIF old_terminal AND new_terminal AND NEW.total_cost_usd <> OLD.total_cost_usd THEN
+        -- A step that was in flight when the run was canceled can still bill
+        -- cost after the run finished.
+        INSERT INTO run_daily_stats (api_key_id, day, total_cost_usd)
+        VALUES (NEW.api_key_id, NEW.updated_at::date, NEW.total_cost_usd - OLD.total_cost_usd)
+        ON CONFLICT (api_key_id, day) DO UPDATE
+        SET total_cost_usd = run_daily_stats.total_cost_usd + EXCLUDED.total_cost_usd,
+            updated_at = NOW();
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Backfill from runs that finished before the columns existed.
+UPDATE run_daily_stats rds
+SET steps_executed = agg.steps_executed,
+    step_retries = agg.step_retries
+FROM (
+    SELECT r.api_key_id,
+           r.updated_at::date AS day,
+           COUNT(s.id) FILTER (WHERE s.attempts > 0) AS steps_executed,
+           COALESCE(SUM(GREATEST(s.attempts - 1, 0)), 0) AS step_retries
+    FROM runs r
+    JOIN steps s ON s.run_id = r.id
+    WHERE r.status IN ('SUCCEEDED', 'FAILED', 'CANCELED')
+    GROUP BY r.api_key_id, r.updated_at::date
+) agg
+WHERE rds.api_key_id = agg.api_key_id
+  AND rds.day = agg.day;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_steps_waiting_approval;
+
+ALTER TABLE steps
+    DROP COLUMN IF EXISTS escalation_level,
+    DROP COLUMN IF EXISTS waiting_since;
@@ -0,0 +1,19 @@
+-- Approval escalation: waiting_since records when an APPROVAL step started
+-- waiting, and escalation_level how many escalation thresholds it has passed.
+ALTER TABLE steps
+    ADD COLUMN IF NOT EXISTS waiting_since TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS escalation_level INT NOT NULL DEFAULT 0;
+
+-- Steps already waiting start their clock at their STEP_WAITING_APPROVAL event.
+UPDATE steps s
+SET waiting_since = COALESCE(
+    (SELECT MAX(e.created_at) FROM events e
+     WHERE e.step_id = s.id AND e.type = 'STEP_WAITING_APPROVAL'),
+    NOW() AT TIME ZONE 'UTC'
+)
+WHERE s.status = 'WAITING_APPROVAL'
+  AND s.waiting_since IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_steps_waiting_approval
+    ON steps (waiting_since)
+    WHERE status = 'WAITING_APPROVAL';
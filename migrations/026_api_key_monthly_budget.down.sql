@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_runs_api_key_created_at;
+
+ALTER TABLE api_keys
+    DROP CONSTRAINT IF EXISTS api_keys_monthly_budget_usd_positive;
+
+ALTER TABLE api_keys
+    DROP COLUMN IF EXISTS monthly_budget_usd;
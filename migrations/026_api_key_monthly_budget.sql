@@ -0,0 +1,13 @@
+-- Monthly spend cap per API key. NULL means no cap.
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS monthly_budget_usd NUMERIC(12,4) NULL;
+
+ALTER TABLE api_keys
+    DROP CONSTRAINT IF EXISTS api_keys_monthly_budget_usd_positive;
+
+ALTER TABLE api_keys
+    ADD CONSTRAINT api_keys_monthly_budget_usd_positive
+    CHECK (monthly_budget_usd IS NULL OR monthly_budget_usd > 0);
+
+-- Month-to-date spend sums runs.total_cost_usd by tenant and creation time.
+CREATE INDEX IF NOT EXISTS idx_runs_api_key_created_at ON runs(api_key_id, created_at);
@@ -0,0 +1,2 @@
+ALTER TABLE steps
+    DROP COLUMN IF EXISTS cost_detail;
@@ -0,0 +1,4 @@
+-- Itemized cost of a step execution (provider, model or tool, tokens, unit
+-- price) alongside the cost_usd total.
+ALTER TABLE steps
+    ADD COLUMN IF NOT EXISTS cost_detail JSONB NULL;
@@ -0,0 +1,23 @@
+-- Audit trail of admin and tenant mutations. Rows outlive the keys and runs
+-- they describe, so there are no foreign keys; seq orders and pages entries.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id UUID PRIMARY KEY,
+    seq BIGSERIAL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    actor_type TEXT NOT NULL,
+    actor_id UUID,
+    api_key_id UUID,
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    ip TEXT,
+    request_id TEXT,
+    before JSONB,
+    after JSONB
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_api_key_seq
+    ON audit_log (api_key_id, seq);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_action_seq
+    ON audit_log (action, seq);
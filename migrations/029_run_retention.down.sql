@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_runs_terminal_updated_at;
+
+-- Archived runs are gone from the hot tables; dropping the archive discards
+-- them for good.
+DROP TABLE IF EXISTS run_archive;
+
+ALTER TABLE api_keys
+    DROP CONSTRAINT IF EXISTS api_keys_run_retention_days_positive;
+
+ALTER TABLE api_keys
+    DROP COLUMN IF EXISTS run_retention_days;
@@ -0,0 +1,32 @@
+-- Optional per-key run retention; NULL inherits RUN_RETENTION_DAYS.
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS run_retention_days INT NULL;
+
+ALTER TABLE api_keys
+    DROP CONSTRAINT IF EXISTS api_keys_run_retention_days_positive;
+
+ALTER TABLE api_keys
+    ADD CONSTRAINT api_keys_run_retention_days_positive
+    CHECK (run_retention_days IS NULL OR run_retention_days > 0);
+
+-- Terminal runs past retention, moved out of the hot tables. Each row holds
+-- the run with its steps and events as JSON documents, so the archive does
+-- not have to follow later column changes.
+CREATE TABLE IF NOT EXISTS run_archive (
+    id UUID PRIMARY KEY,
+    api_key_id UUID NOT NULL,
+    status TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL,
+    archived_at TIMESTAMP NOT NULL,
+    run JSONB NOT NULL,
+    steps JSONB NOT NULL,
+    events JSONB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_run_archive_api_key_finished
+    ON run_archive (api_key_id, finished_at);
+
+CREATE INDEX IF NOT EXISTS idx_runs_terminal_updated_at
+    ON runs (updated_at)
+    WHERE status IN ('SUCCEEDED', 'FAILED', 'CANCELED');
@@ -0,0 +1,39 @@
+-- Turns events back into a plain table, copying every partition's rows.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1
+        FROM pg_partitioned_table pt
+        JOIN pg_class c ON c.oid = pt.partrelid
+        WHERE c.oid = 'public.events'::regclass
+    ) THEN
+        RETURN;
+    END IF;
+
+    CREATE TEMPORARY TABLE events_partitioned ON COMMIT DROP AS
+    SELECT seq, id, run_id, step_id, type, payload, created_at
+    FROM events;
+
+    ALTER SEQUENCE events_seq_seq OWNED BY NONE;
+    DROP TABLE events;
+
+    CREATE TABLE events (
+        seq BIGINT NOT NULL DEFAULT nextval('events_seq_seq') UNIQUE,
+        id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+        run_id UUID NOT NULL REFERENCES runs(id) ON DELETE CASCADE,
+        step_id UUID,
+        type TEXT NOT NULL,
+        payload JSONB,
+        created_at TIMESTAMP NOT NULL DEFAULT NOW()
+    );
+
+    INSERT INTO events (seq, id, run_id, step_id, type, payload, created_at)
+    SELECT seq, id, run_id, step_id, type, payload, created_at
+    FROM events_partitioned;
+
+    ALTER SEQUENCE events_seq_seq OWNED BY events.seq;
+END;
+$$;
+
+CREATE INDEX IF NOT EXISTS idx_events_run_id ON events(run_id);
+CREATE INDEX IF NOT EXISTS idx_events_created_at ON events(created_at);
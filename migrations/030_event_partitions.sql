@@ -0,0 +1,68 @@
+-- Range-partition events by month of created_at. Retention pruning empties old
+-- months, which the janitor then drops instead of leaving dead tuples to
+-- vacuum, and queries bounded by created_at only touch recent partitions.
+-- Partitioned tables need the partition key in every unique constraint, so
+-- the keys become (id, created_at) and (seq, created_at); both id and seq are
+-- still unique on their own by construction.
+--
+-- Existing rows are copied over. Rows from before the current month land in
+-- events_default and age out through row-level pruning; the janitor creates
+-- monthly partitions ahead of time from here on.
+DO $$
+DECLARE
+    month_start TIMESTAMP := date_trunc('month', NOW() AT TIME ZONE 'UTC');
+    partition_start TIMESTAMP;
+BEGIN
+    IF EXISTS (
+        SELECT 1
+        FROM pg_partitioned_table pt
+        JOIN pg_class c ON c.oid = pt.partrelid
+        WHERE c.oid = 'public.events'::regclass
+    ) THEN
+        RETURN;
+    END IF;
+
+    ALTER TABLE events RENAME TO events_unpartitioned;
+    ALTER TABLE events_unpartitioned DROP CONSTRAINT IF EXISTS events_pkey;
+    ALTER TABLE events_unpartitioned DROP CONSTRAINT IF EXISTS events_seq_key;
+    DROP INDEX IF EXISTS idx_events_run_id;
+    DROP INDEX IF EXISTS idx_events_created_at;
+    ALTER SEQUENCE events_seq_seq OWNED BY NONE;
+
+    CREATE TABLE events (
+        seq BIGINT NOT NULL DEFAULT nextval('events_seq_seq'),
+        id UUID NOT NULL DEFAULT uuid_generate_v4(),
+        run_id UUID NOT NULL REFERENCES runs(id) ON DELETE CASCADE,
+        step_id UUID,
+        type TEXT NOT NULL,
+        payload JSONB,
+        created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+        PRIMARY KEY (id, created_at),
+        UNIQUE (seq, created_at)
+    ) PARTITION BY RANGE (created_at);
+
+    CREATE TABLE events_default PARTITION OF events DEFAULT;
+
+    FOR i IN 0..1 LOOP
+        partition_start := month_start + make_interval(months => i);
+        EXECUTE format(
+            'CREATE TABLE %I PARTITION OF events FOR VALUES FROM (%L) TO (%L)',
+            'events_p' || to_char(partition_start, 'YYYYMM'),
+            partition_start,
+            partition_start + INTERVAL '1 month'
+        );
+    END LOOP;
+
+    INSERT INTO events (seq, id, run_id, step_id, type, payload, created_at)
+    SELECT seq, id, run_id, step_id, type, payload, created_at
+    FROM events_unpartitioned;
+
+    ALTER SEQUENCE events_seq_seq OWNED BY events.seq;
+    DROP TABLE events_unpartitioned;
+END;
+$$;
+
+-- Event reads go by run and cursor; run_id leads so per-run scans stay
+-- ordered by seq without a sort.
+CREATE INDEX IF NOT EXISTS idx_events_run_id_seq ON events (run_id, seq);
+CREATE INDEX IF NOT EXISTS idx_events_created_at ON events (created_at);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_runs_api_key_created;
+DROP INDEX IF EXISTS idx_runs_tags;
+DROP INDEX IF EXISTS idx_runs_metadata;
+
+ALTER TABLE runs
+    DROP COLUMN IF EXISTS tags,
+    DROP COLUMN IF EXISTS metadata;
@@ -0,0 +1,12 @@
+-- Caller-supplied run labels: metadata is a flat string-to-string object and
+-- tags a set of strings. GET /runs filters with containment (@>), which both
+-- GIN indexes serve.
+ALTER TABLE runs
+    ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}'::jsonb,
+    ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_runs_metadata ON runs USING GIN (metadata jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS idx_runs_tags ON runs USING GIN (tags);
+
+-- Unfiltered listings page a tenant's runs newest first.
+CREATE INDEX IF NOT EXISTS idx_runs_api_key_created ON runs (api_key_id, created_at DESC, id DESC);
@@ -0,0 +1,5 @@
+ALTER TABLE steps
+    DROP COLUMN IF EXISTS condition;
+
+ALTER TABLE workflow_template_steps
+    DROP COLUMN IF EXISTS condition;
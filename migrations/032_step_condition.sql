@@ -0,0 +1,8 @@
+-- Optional per-step condition (see domain.StepCondition). The worker evaluates
+-- it against earlier step outputs and run metadata when the step comes up and
+-- marks the step SKIPPED instead of running it when the condition is false.
+ALTER TABLE workflow_template_steps
+    ADD COLUMN IF NOT EXISTS condition TEXT;
+
+ALTER TABLE steps
+    ADD COLUMN IF NOT EXISTS condition TEXT;
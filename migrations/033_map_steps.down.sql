@@ -0,0 +1,18 @@
+-- MAP children cannot run without the columns that describe them.
+DELETE FROM steps WHERE parent_step_id IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_steps_parent_step_id;
+
+ALTER TABLE steps
+    DROP COLUMN IF EXISTS position,
+    DROP COLUMN IF EXISTS item,
+    DROP COLUMN IF EXISTS map_index,
+    DROP COLUMN IF EXISTS parent_step_id,
+    DROP COLUMN IF EXISTS map_parallelism,
+    DROP COLUMN IF EXISTS map_step,
+    DROP COLUMN IF EXISTS map_items;
+
+ALTER TABLE workflow_template_steps
+    DROP COLUMN IF EXISTS map_parallelism,
+    DROP COLUMN IF EXISTS map_step,
+    DROP COLUMN IF EXISTS map_items;
@@ -0,0 +1,34 @@
+-- MAP steps fan out over an array (map_items, a step path such as
+-- steps.LLM.output.items): the worker creates one map_step child per item,
+-- runs at most map_parallelism of them at once, and aggregates their outputs
+-- into the MAP step.
+ALTER TABLE workflow_template_steps
+    ADD COLUMN IF NOT EXISTS map_items TEXT,
+    ADD COLUMN IF NOT EXISTS map_step TEXT CHECK (map_step IN ('LLM', 'TOOL')),
+    ADD COLUMN IF NOT EXISTS map_parallelism INTEGER CHECK (map_parallelism > 0);
+
+ALTER TABLE steps
+    ADD COLUMN IF NOT EXISTS map_items TEXT,
+    ADD COLUMN IF NOT EXISTS map_step TEXT CHECK (map_step IN ('LLM', 'TOOL')),
+    ADD COLUMN IF NOT EXISTS map_parallelism INTEGER CHECK (map_parallelism > 0),
+    ADD COLUMN IF NOT EXISTS parent_step_id UUID REFERENCES steps(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS map_index INTEGER,
+    ADD COLUMN IF NOT EXISTS item JSONB,
+    ADD COLUMN IF NOT EXISTS position INTEGER;
+
+-- Steps were ordered by created_at, but a run's steps are inserted in one
+-- transaction and share it. position is the template position, shared by a
+-- MAP step's children, and is what "earlier step" means from here on.
+UPDATE steps s
+SET position = ordered.position
+FROM (
+    SELECT id, (row_number() OVER (PARTITION BY run_id ORDER BY created_at, ctid) - 1)::INTEGER AS position
+    FROM steps
+) ordered
+WHERE s.id = ordered.id
+  AND s.position IS NULL;
+
+ALTER TABLE steps ALTER COLUMN position SET DEFAULT 0;
+ALTER TABLE steps ALTER COLUMN position SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_steps_parent_step_id ON steps (parent_step_id) WHERE parent_step_id IS NOT NULL;
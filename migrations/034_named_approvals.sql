@@ -0,0 +1,8 @@
+-- A template may have several APPROVAL steps. approval_name labels each gate
+-- so run detail can say which ones are still pending; gates are approved one
+-- at a time by step id.
+ALTER TABLE workflow_template_steps
+    ADD COLUMN IF NOT EXISTS approval_name TEXT;
+
+ALTER TABLE steps
+    ADD COLUMN IF NOT EXISTS approval_name TEXT;
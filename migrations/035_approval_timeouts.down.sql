@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_steps_waiting_approval_timeout;
+
+ALTER TABLE steps
+    DROP COLUMN IF EXISTS approval_timeout_action,
+    DROP COLUMN IF EXISTS approval_timeout_seconds;
+
+ALTER TABLE workflow_template_steps
+    DROP COLUMN IF EXISTS approval_timeout_action,
+    DROP COLUMN IF EXISTS approval_timeout_seconds;
@@ -0,0 +1,14 @@
+-- An APPROVAL step may time out: once it has waited approval_timeout_seconds,
+-- the API's sweeper applies approval_timeout_action, failing the run (fail,
+-- the default) or approving the step (approve).
+ALTER TABLE workflow_template_steps
+    ADD COLUMN IF NOT EXISTS approval_timeout_seconds INTEGER CHECK (approval_timeout_seconds > 0),
+    ADD COLUMN IF NOT EXISTS approval_timeout_action TEXT CHECK (approval_timeout_action IN ('fail', 'approve'));
+
+ALTER TABLE steps
+    ADD COLUMN IF NOT EXISTS approval_timeout_seconds INTEGER CHECK (approval_timeout_seconds > 0),
+    ADD COLUMN IF NOT EXISTS approval_timeout_action TEXT CHECK (approval_timeout_action IN ('fail', 'approve'));
+
+CREATE INDEX IF NOT EXISTS idx_steps_waiting_approval_timeout
+    ON steps (waiting_since)
+    WHERE status = 'WAITING_APPROVAL' AND approval_timeout_seconds IS NOT NULL;
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_runs_failure_unnotified;
+DROP INDEX IF EXISTS idx_steps_approval_unnotified;
+
+ALTER TABLE runs
+    DROP COLUMN IF EXISTS failure_notified_at;
+
+ALTER TABLE steps
+    DROP COLUMN IF EXISTS approval_notified_at;
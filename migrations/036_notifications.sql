@@ -0,0 +1,22 @@
+-- The API's notifier tells humans about approvals that started waiting and
+-- runs that failed. It marks what it has handled so each is sent once:
+-- approval_notified_at on the APPROVAL step and failure_notified_at on the
+-- run. Failures from before the notifier existed count as handled.
+ALTER TABLE steps
+    ADD COLUMN IF NOT EXISTS approval_notified_at TIMESTAMPTZ;
+
+ALTER TABLE runs
+    ADD COLUMN IF NOT EXISTS failure_notified_at TIMESTAMPTZ;
+
+UPDATE runs
+SET failure_notified_at = updated_at
+WHERE status = 'FAILED'
+  AND failure_notified_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_steps_approval_unnotified
+    ON steps (waiting_since)
+    WHERE status = 'WAITING_APPROVAL' AND approval_notified_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_runs_failure_unnotified
+    ON runs (updated_at)
+    WHERE status = 'FAILED' AND failure_notified_at IS NULL;
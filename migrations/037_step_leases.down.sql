@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_steps_claimed_by_running;
+DROP INDEX IF EXISTS idx_steps_running_lease;
+
+ALTER TABLE steps
+    DROP COLUMN IF EXISTS lease_expires_at,
+    DROP COLUMN IF EXISTS claimed_by;
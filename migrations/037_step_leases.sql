@@ -0,0 +1,23 @@
+-- A claimed step is leased to one worker: claimed_by names the worker and
+-- lease_expires_at is when the lease lapses unless the worker renews it.
+-- Only RUNNING steps with an expired lease are reclaimed. Steps already
+-- RUNNING keep the old behaviour: their lease ends five minutes after they
+-- started.
+ALTER TABLE steps
+    ADD COLUMN IF NOT EXISTS claimed_by UUID,
+    ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP;
+
+UPDATE steps
+SET lease_expires_at = started_at + INTERVAL '5 minutes'
+WHERE status = 'RUNNING'
+  AND name <> 'MAP'
+  AND started_at IS NOT NULL
+  AND lease_expires_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_steps_running_lease
+    ON steps (lease_expires_at)
+    WHERE status = 'RUNNING';
+
+CREATE INDEX IF NOT EXISTS idx_steps_claimed_by_running
+    ON steps (claimed_by)
+    WHERE status = 'RUNNING';
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_workers_last_seen;
+
+ALTER TABLE workers
+    DROP COLUMN IF EXISTS in_flight_steps;
@@ -0,0 +1,7 @@
+-- Workers heartbeat their registry row on every poll, reporting how many steps
+-- they are executing.
+ALTER TABLE workers
+    ADD COLUMN IF NOT EXISTS in_flight_steps INT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_workers_last_seen
+    ON workers (last_seen_at DESC);
@@ -0,0 +1,11 @@
+ALTER TABLE steps
+    DROP COLUMN IF EXISTS retry_jitter,
+    DROP COLUMN IF EXISTS retry_backoff,
+    DROP COLUMN IF EXISTS retry_base_delay_ms,
+    DROP COLUMN IF EXISTS max_attempts;
+
+ALTER TABLE workflow_template_steps
+    DROP COLUMN IF EXISTS retry_jitter,
+    DROP COLUMN IF EXISTS retry_backoff,
+    DROP COLUMN IF EXISTS retry_base_delay_ms,
+    DROP COLUMN IF EXISTS max_attempts;
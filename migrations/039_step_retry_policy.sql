@@ -0,0 +1,15 @@
+-- Template steps may override the worker-wide retry policy: max_attempts,
+-- retry_base_delay_ms, retry_backoff (exponential, linear, or fixed), and
+-- retry_jitter. NULL keeps the worker's --max-attempts / --retry-base-delay,
+-- exponential backoff, and no jitter. Steps copy them from the template.
+ALTER TABLE workflow_template_steps
+    ADD COLUMN IF NOT EXISTS max_attempts INTEGER CHECK (max_attempts > 0),
+    ADD COLUMN IF NOT EXISTS retry_base_delay_ms INTEGER CHECK (retry_base_delay_ms > 0),
+    ADD COLUMN IF NOT EXISTS retry_backoff TEXT CHECK (retry_backoff IN ('exponential', 'linear', 'fixed')),
+    ADD COLUMN IF NOT EXISTS retry_jitter BOOLEAN;
+
+ALTER TABLE steps
+    ADD COLUMN IF NOT EXISTS max_attempts INTEGER CHECK (max_attempts > 0),
+    ADD COLUMN IF NOT EXISTS retry_base_delay_ms INTEGER CHECK (retry_base_delay_ms > 0),
+    ADD COLUMN IF NOT EXISTS retry_backoff TEXT CHECK (retry_backoff IN ('exponential', 'linear', 'fixed')),
+    ADD COLUMN IF NOT EXISTS retry_jitter BOOLEAN;
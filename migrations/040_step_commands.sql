@@ -0,0 +1,8 @@
+-- TOOL template steps (and MAP steps over TOOL) may carry a command, an argv
+-- run by the worker's sandbox executor instead of the default tool. Steps
+-- copy it from the template; MAP children copy it from their parent.
+ALTER TABLE workflow_template_steps
+    ADD COLUMN IF NOT EXISTS command TEXT[] CHECK (command IS NULL OR cardinality(command) > 0);
+
+ALTER TABLE steps
+    ADD COLUMN IF NOT EXISTS command TEXT[] CHECK (command IS NULL OR cardinality(command) > 0);
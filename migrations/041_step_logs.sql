@@ -0,0 +1,14 @@
+-- Log lines emitted by executors while a step runs, kept apart from events so
+-- chatty steps do not flood the run's event stream. seq orders lines within
+-- a step and is the pagination cursor.
+CREATE TABLE IF NOT EXISTS step_logs (
+    seq BIGSERIAL PRIMARY KEY,
+    run_id UUID NOT NULL REFERENCES runs(id) ON DELETE CASCADE,
+    step_id UUID NOT NULL REFERENCES steps(id) ON DELETE CASCADE,
+    attempt INTEGER NOT NULL,
+    level TEXT NOT NULL CHECK (level IN ('debug', 'info', 'warn', 'error')),
+    line TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_step_logs_step_seq ON step_logs (step_id, seq);
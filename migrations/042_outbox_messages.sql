@@ -0,0 +1,23 @@
+-- Lifecycle messages for an external broker, written in the transaction that
+-- created the run or inserted the event so nothing is published that did not
+-- commit and nothing that committed is lost. The relay deletes a row once it
+-- is published; seq orders messages, and a run's messages go out in seq
+-- order. There is no foreign key to runs so retention can delete a run
+-- before its last messages are relayed.
+CREATE TABLE IF NOT EXISTS outbox_messages (
+    seq BIGSERIAL PRIMARY KEY,
+    id UUID NOT NULL UNIQUE,
+    type TEXT NOT NULL,
+    api_key_id UUID NOT NULL,
+    run_id UUID NOT NULL,
+    step_id UUID,
+    event_id UUID,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_due ON outbox_messages (next_attempt_at, seq);
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_run_seq ON outbox_messages (run_id, seq);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS tenant_claim_counters;
+
+ALTER TABLE api_keys DROP COLUMN IF EXISTS scheduling_weight;
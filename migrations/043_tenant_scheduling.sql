@@ -0,0 +1,13 @@
+-- Scheduling weight of each tenant in shared workers, which give a tenant a
+-- share of their claims proportional to its weight.
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS scheduling_weight INTEGER NOT NULL DEFAULT 1
+        CHECK (scheduling_weight BETWEEN 1 AND 1000);
+
+-- Steps claimed per tenant by any worker, so the claim shares can be checked
+-- against the weights. One row per tenant, bumped in the claim transaction.
+CREATE TABLE IF NOT EXISTS tenant_claim_counters (
+    api_key_id UUID PRIMARY KEY REFERENCES api_keys(id) ON DELETE CASCADE,
+    claims BIGINT NOT NULL DEFAULT 0,
+    last_claimed_at TIMESTAMP
+);
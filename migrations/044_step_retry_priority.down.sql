@@ -0,0 +1,6 @@
+ALTER TABLE steps
+    DROP COLUMN IF EXISTS priority_boost,
+    DROP COLUMN IF EXISTS retry_priority;
+
+ALTER TABLE workflow_template_steps
+    DROP COLUMN IF EXISTS retry_priority;
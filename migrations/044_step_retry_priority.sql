@@ -0,0 +1,10 @@
+-- Template steps may move their retries up or down the claim order:
+-- retry_priority is added to the step's priority_boost each time it is
+-- rescheduled, and workers claim by runs.priority + steps.priority_boost.
+-- NULL keeps the worker's --retry-priority. Steps copy it from the template.
+ALTER TABLE workflow_template_steps
+    ADD COLUMN IF NOT EXISTS retry_priority INTEGER CHECK (retry_priority BETWEEN -100 AND 100);
+
+ALTER TABLE steps
+    ADD COLUMN IF NOT EXISTS retry_priority INTEGER CHECK (retry_priority BETWEEN -100 AND 100),
+    ADD COLUMN IF NOT EXISTS priority_boost INTEGER NOT NULL DEFAULT 0;
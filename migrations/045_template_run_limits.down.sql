@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_runs_api_key_template_status;
+
+ALTER TABLE runs DROP COLUMN IF EXISTS template_name;
+
+ALTER TABLE api_keys DROP COLUMN IF EXISTS max_concurrent_runs_per_template;
@@ -0,0 +1,13 @@
+-- Per-template caps on a tenant's active runs, as a JSON object from template
+-- name to the most RUNNING or WAITING runs of that template, e.g.
+-- {"deploy": 2}. Templates not listed are only bound by max_concurrent_runs.
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS max_concurrent_runs_per_template JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+-- The template each run was created from, so runs can be counted per
+-- template. Runs created before this migration have none.
+ALTER TABLE runs ADD COLUMN IF NOT EXISTS template_name TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_runs_api_key_template_status
+    ON runs (api_key_id, template_name, status)
+    WHERE template_name IS NOT NULL;
@@ -0,0 +1,3 @@
+-- Why a run was canceled, as given to POST /runs/{id}/cancel or
+-- POST /admin/runs/{id}/cancel, or the default reason of either.
+ALTER TABLE runs ADD COLUMN IF NOT EXISTS cancel_reason TEXT;
@@ -0,0 +1,20 @@
+-- Tenant-wide exports of run data, requested through POST /exports and built
+-- in the background by the API. The finished bundle is stored on the row as
+-- the job's artifact until the tenant is purged.
+CREATE TABLE IF NOT EXISTS export_jobs (
+    id UUID PRIMARY KEY,
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    format TEXT NOT NULL CHECK (format IN ('json', 'csv')),
+    status TEXT NOT NULL,
+    runs_exported INTEGER NOT NULL DEFAULT 0,
+    artifact BYTEA,
+    artifact_bytes BIGINT,
+    artifact_sha256 TEXT,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_export_jobs_status_created ON export_jobs (status, created_at);
+CREATE INDEX IF NOT EXISTS idx_export_jobs_api_key_created ON export_jobs (api_key_id, created_at);
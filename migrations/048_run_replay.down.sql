@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_runs_replayed_from;
+ALTER TABLE steps DROP COLUMN IF EXISTS input_override;
+ALTER TABLE runs DROP COLUMN IF EXISTS replayed_from_run_id;
@@ -0,0 +1,8 @@
+-- The run a replay repeats (POST /runs/{id}/replay), and the input override
+-- a replay gave each of its steps.
+ALTER TABLE runs ADD COLUMN IF NOT EXISTS replayed_from_run_id UUID REFERENCES runs(id) ON DELETE SET NULL;
+ALTER TABLE steps ADD COLUMN IF NOT EXISTS input_override JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_runs_replayed_from
+    ON runs (replayed_from_run_id)
+    WHERE replayed_from_run_id IS NOT NULL;
@@ -0,0 +1,12 @@
+ALTER TABLE runs DROP COLUMN IF EXISTS template_version;
+
+-- Keep only the latest version of each template, so names are unique again.
+DELETE FROM workflow_templates wt
+WHERE EXISTS (
+    SELECT 1 FROM workflow_templates newer
+    WHERE newer.name = wt.name AND newer.version > wt.version
+);
+
+DROP INDEX IF EXISTS idx_workflow_templates_name_version;
+ALTER TABLE workflow_templates DROP COLUMN IF EXISTS version;
+ALTER TABLE workflow_templates ADD CONSTRAINT workflow_templates_name_key UNIQUE (name);
@@ -0,0 +1,11 @@
+-- Workflow template versions: an edit adds a row with the same name and the
+-- next version instead of changing the template in place, and each run pins
+-- the version it was created from. Runs created before versions existed keep
+-- a NULL template_version.
+ALTER TABLE workflow_templates ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE workflow_templates DROP CONSTRAINT IF EXISTS workflow_templates_name_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_workflow_templates_name_version
+    ON workflow_templates (name, version);
+
+ALTER TABLE runs ADD COLUMN IF NOT EXISTS template_version INT;
@@ -0,0 +1,3 @@
+ALTER TABLE steps DROP COLUMN IF EXISTS secrets;
+ALTER TABLE workflow_template_steps DROP COLUMN IF EXISTS secrets;
+DROP TABLE IF EXISTS secrets;
@@ -0,0 +1,13 @@
+-- Named secrets template steps reference through workflow_template_steps.secrets.
+-- Values are sealed with AES-GCM under SECRETS_KEY before they are stored and
+-- are only opened by workers running a step that names them.
+CREATE TABLE IF NOT EXISTS secrets (
+    id UUID PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    ciphertext BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE workflow_template_steps ADD COLUMN IF NOT EXISTS secrets TEXT[];
+ALTER TABLE steps ADD COLUMN IF NOT EXISTS secrets TEXT[];
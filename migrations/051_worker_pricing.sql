@@ -0,0 +1,3 @@
+-- Pricing the executors of each registered worker declare, keyed by step
+-- type. The API reads it from active workers to estimate template costs.
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS pricing JSONB NOT NULL DEFAULT '{}'::jsonb;
@@ -0,0 +1,21 @@
+-- Token accounting written by workers for every step execution that reports a
+-- provider and model or a tool, kept apart from cost_usd so chargeback and
+-- model-mix reports survive price changes. Rows reference runs and steps by
+-- ID only, so they outlive run retention; a tenant purge deletes them.
+CREATE TABLE IF NOT EXISTS usage_records (
+    id BIGSERIAL PRIMARY KEY,
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    run_id UUID NOT NULL,
+    step_id UUID NOT NULL,
+    step_name TEXT NOT NULL,
+    attempt INTEGER NOT NULL,
+    provider TEXT NOT NULL DEFAULT '',
+    model TEXT NOT NULL DEFAULT '',
+    tool TEXT NOT NULL DEFAULT '',
+    prompt_tokens BIGINT NOT NULL DEFAULT 0,
+    completion_tokens BIGINT NOT NULL DEFAULT 0,
+    latency_ms BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_records_api_key_created ON usage_records (api_key_id, created_at);
@@ -0,0 +1 @@
+ALTER TABLE steps DROP COLUMN IF EXISTS execution_attempt;
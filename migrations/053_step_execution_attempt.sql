@@ -0,0 +1,5 @@
+-- Counts a step's executions the way its execution key does: a claim of a
+-- PENDING step starts a new execution, while reclaiming a RUNNING step whose
+-- lease expired continues the execution the dead worker had started, so the
+-- external calls it repeats carry the same key. attempts counts every claim.
+ALTER TABLE steps ADD COLUMN IF NOT EXISTS execution_attempt INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE steps DROP COLUMN IF EXISTS claim_token;
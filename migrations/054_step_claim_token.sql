@@ -0,0 +1,5 @@
+-- Identifies the claim a step's result belongs to. Every claim, reclaims
+-- included, sets a new token, and a worker settles the step only while the
+-- token is still the one it claimed with, so a worker that lost its lease
+-- to a reclaim cannot overwrite what the newer claim records.
+ALTER TABLE steps ADD COLUMN IF NOT EXISTS claim_token UUID;
@@ -0,0 +1,4 @@
+-- When a key last authenticated a request, so operators can find keys
+-- nothing uses anymore before revoking them. NULL means never, or not since
+-- this column was added.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP;
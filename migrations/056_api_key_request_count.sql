@@ -0,0 +1,4 @@
+-- Requests a key has authenticated. The API counts them in memory and adds
+-- them here together with last_used_at, at most once per
+-- API_KEY_USAGE_INTERVAL per key and API process.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS request_count BIGINT NOT NULL DEFAULT 0;
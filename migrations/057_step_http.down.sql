@@ -0,0 +1,5 @@
+ALTER TABLE steps
+    DROP COLUMN IF EXISTS http;
+
+ALTER TABLE workflow_template_steps
+    DROP COLUMN IF EXISTS http;
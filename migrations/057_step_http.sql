@@ -0,0 +1,8 @@
+-- TOOL template steps (and MAP steps over TOOL) may carry an HTTP request,
+-- sent by the worker's HTTP executor instead of the default tool. Steps copy
+-- it from the template; MAP children copy it from their parent.
+ALTER TABLE workflow_template_steps
+    ADD COLUMN IF NOT EXISTS http JSONB;
+
+ALTER TABLE steps
+    ADD COLUMN IF NOT EXISTS http JSONB;
@@ -0,0 +1,3 @@
+-- Hosts and ports the HTTP steps of a key's runs may call, as host[:port]
+-- rules. NULL leaves them limited only by the worker's egress policy.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS egress_allow_hosts TEXT[];
@@ -3,7 +3,9 @@
 package migrations
 
 import (
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"io/fs"
 	"sort"
 	"strings"
@@ -12,11 +14,24 @@ import (
 //go:embed *.sql
 var embeddedFiles embed.FS
 
+// downSuffix marks the file that reverts the migration of the same name.
+const downSuffix = ".down.sql"
+
 type File struct {
 	Name string
 	SQL  string
+	// Down reverts SQL; empty when the migration has no down file.
+	Down string
+}
+
+// Checksum identifies the content of the migration, so an applied file that
+// was edited afterwards can be detected.
+func (f File) Checksum() string {
+	sum := sha256.Sum256([]byte(f.SQL))
+	return hex.EncodeToString(sum[:])
 }
 
+// Ordered returns the up migrations by name, each with its down migration.
 func Ordered() ([]File, error) {
 	entries, err := fs.ReadDir(embeddedFiles, ".")
 	if err != nil {
@@ -24,6 +39,7 @@ func Ordered() ([]File, error) {
 	}
 
 	files := make([]File, 0, len(entries))
+	downs := make(map[string]string)
 	for _, entry := range entries {
 		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
 			continue
@@ -34,12 +50,20 @@ func Ordered() ([]File, error) {
 			return nil, err
 		}
 
+		if name, ok := strings.CutSuffix(entry.Name(), downSuffix); ok {
+			downs[name+".sql"] = string(body)
+			continue
+		}
 		files = append(files, File{
 			Name: entry.Name(),
 			SQL:  string(body),
 		})
 	}
 
+	for i := range files {
+		files[i].Down = downs[files[i].Name]
+	}
+
 	sort.Slice(files, func(i, j int) bool {
 		return files[i].Name < files[j].Name
 	})
@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package webhook signs and verifies Agent Runtime webhook requests. Receivers
+// import it to check the X-Signature header:
+//
+//	body, _ := io.ReadAll(r.Body)
+//	if err := webhook.Verify(body, r.Header.Get(webhook.SignatureHeader), secret, webhook.DefaultTolerance); err != nil {
+//		http.Error(w, "invalid signature", http.StatusUnauthorized)
+//		return
+//	}
+//
+// The header has the form "t=<unix seconds>,v1=<hex hmac>", where the HMAC-SHA256
+// is computed over "<t>.<body>" with the run's webhook secret. Binding the
+// timestamp into the signature lets receivers reject replayed requests.
+//
+// Tenants that rotate versioned signing secrets get one signature per active
+// secret, each labeled with the secret's key id:
+//
+//	t=<unix seconds>,kid=k2,v1=<hex hmac>,kid=k1,v1=<hex hmac>
+//
+// Receivers holding several secrets verify with VerifyWithKeys; Verify still
+// accepts the header when any one signature matches its secret.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SignatureHeader  = "X-Signature"
+	SignatureVersion = "v1"
+	KeyIDField       = "kid"
+	DefaultTolerance = 5 * time.Minute
+)
+
+// Key is one versioned signing secret.
+type Key struct {
+	ID     string
+	Secret string
+}
+
+// Signature is one v1 signature from a header with the key id it was labeled
+// with, or "" when it was not labeled.
+type Signature struct {
+	KeyID string
+	Value string
+}
+
+var (
+	ErrMissingSignature    = errors.New("webhook: missing signature header")
+	ErrInvalidHeader       = errors.New("webhook: invalid signature header")
+	ErrNoValidSignature    = errors.New("webhook: no matching v1 signature")
+	ErrTimestampOutOfRange = errors.New("webhook: timestamp outside tolerance")
+	ErrMissingSecret       = errors.New("webhook: missing secret")
+	ErrUnsupportedVersion  = errors.New("webhook: no supported signature version")
+)
+
+// Sign returns the X-Signature header value for payload sent at ts.
+func Sign(secret string, ts time.Time, payload []byte) string {
+	t := ts.Unix()
+	return "t=" + strconv.FormatInt(t, 10) + "," + SignatureVersion + "=" + ComputeSignature(secret, t, payload)
+}
+
+// SignWithKeys returns an X-Signature header value carrying one v1 signature
+// per key, each preceded by its key id, so receivers can keep verifying while
+// they move from one secret to the next.
+func SignWithKeys(keys []Key, ts time.Time, payload []byte) string {
+	t := ts.Unix()
+	var b strings.Builder
+	b.WriteString("t=" + strconv.FormatInt(t, 10))
+	for _, k := range keys {
+		b.WriteString("," + KeyIDField + "=" + k.ID)
+		b.WriteString("," + SignatureVersion + "=" + ComputeSignature(k.Secret, t, payload))
+	}
+	return b.String()
+}
+
+// ComputeSignature returns the hex HMAC-SHA256 of "<timestamp>.<payload>".
+func ComputeSignature(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ParseHeader splits a signature header into its timestamp and v1 signatures.
+// Unknown versions are ignored so new schemes can be added alongside v1.
+func ParseHeader(header string) (time.Time, []string, error) {
+	ts, signatures, err := ParseSignatures(header)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	values := make([]string, 0, len(signatures))
+	for _, sig := range signatures {
+		values = append(values, sig.Value)
+	}
+	return ts, values, nil
+}
+
+// ParseSignatures is ParseHeader keeping key ids: a "kid" labels the v1
+// signature that follows it.
+func ParseSignatures(header string) (time.Time, []Signature, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return time.Time{}, nil, ErrMissingSignature
+	}
+
+	var (
+		timestamp    int64
+		hasTimestamp bool
+		keyID        string
+		signatures   []Signature
+	)
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || value == "" {
+			return time.Time{}, nil, ErrInvalidHeader
+		}
+		switch key {
+		case "t":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return time.Time{}, nil, ErrInvalidHeader
+			}
+			timestamp = n
+			hasTimestamp = true
+		case KeyIDField:
+			keyID = value
+		case SignatureVersion:
+			signatures = append(signatures, Signature{KeyID: keyID, Value: value})
+			keyID = ""
+		}
+	}
+
+	if !hasTimestamp {
+		return time.Time{}, nil, ErrInvalidHeader
+	}
+	if len(signatures) == 0 {
+		return time.Time{}, nil, ErrUnsupportedVersion
+	}
+	return time.Unix(timestamp, 0).UTC(), signatures, nil
+}
+
+// Verify checks header against payload and secret and rejects timestamps more
+// than tolerance away from now. A tolerance <= 0 uses DefaultTolerance.
+func Verify(payload []byte, header, secret string, tolerance time.Duration) error {
+	return VerifyAt(payload, header, secret, tolerance, time.Now())
+}
+
+// VerifyAt is Verify with an explicit current time.
+func VerifyAt(payload []byte, header, secret string, tolerance time.Duration, now time.Time) error {
+	if secret == "" {
+		return ErrMissingSecret
+	}
+
+	ts, signatures, err := parseWithinTolerance(header, tolerance, now)
+	if err != nil {
+		return err
+	}
+
+	expected := []byte(ComputeSignature(secret, ts.Unix(), payload))
+	for _, sig := range signatures {
+		if hmac.Equal(expected, []byte(sig.Value)) {
+			return nil
+		}
+	}
+	return ErrNoValidSignature
+}
+
+// VerifyWithKeys checks header against secrets, keyed by key id. A signature
+// labeled with a key id is only checked against that secret; an unlabeled one
+// is checked against all of them.
+func VerifyWithKeys(payload []byte, header string, secrets map[string]string, tolerance time.Duration) error {
+	return VerifyWithKeysAt(payload, header, secrets, tolerance, time.Now())
+}
+
+// VerifyWithKeysAt is VerifyWithKeys with an explicit current time.
+func VerifyWithKeysAt(payload []byte, header string, secrets map[string]string, tolerance time.Duration, now time.Time) error {
+	if len(secrets) == 0 {
+		return ErrMissingSecret
+	}
+
+	ts, signatures, err := parseWithinTolerance(header, tolerance, now)
+	if err != nil {
+		return err
+	}
+
+	for _, sig := range signatures {
+		for keyID, secret := range secrets {
+			if secret == "" || (sig.KeyID != "" && sig.KeyID != keyID) {
+				continue
+			}
+			if hmac.Equal([]byte(ComputeSignature(secret, ts.Unix(), payload)), []byte(sig.Value)) {
+				return nil
+			}
+		}
+	}
+	return ErrNoValidSignature
+}
+
+func parseWithinTolerance(header string, tolerance time.Duration, now time.Time) (time.Time, []Signature, error) {
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+
+	ts, signatures, err := ParseSignatures(header)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	skew := now.Sub(ts)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > tolerance {
+		return time.Time{}, nil, ErrTimestampOutOfRange
+	}
+	return ts, signatures, nil
+}
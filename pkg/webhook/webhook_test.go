@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package webhook
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	payload := []byte(`{"run_id":"r1","status":"SUCCEEDED"}`)
+	sentAt := time.Unix(1767225600, 0)
+
+	header := Sign("whsec_test", sentAt, payload)
+	if !strings.HasPrefix(header, "t=1767225600,v1=") {
+		t.Fatalf("unexpected header format %q", header)
+	}
+
+	if err := VerifyAt(payload, header, "whsec_test", time.Minute, sentAt.Add(30*time.Second)); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+}
+
+func TestVerifyRejectsTamperingAndReplays(t *testing.T) {
+	payload := []byte(`{"run_id":"r1"}`)
+	sentAt := time.Unix(1767225600, 0)
+	header := Sign("whsec_test", sentAt, payload)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		header  string
+		secret  string
+		now     time.Time
+		want    error
+	}{
+		{name: "modified body", payload: []byte(`{"run_id":"r2"}`), header: header, secret: "whsec_test", now: sentAt, want: ErrNoValidSignature},
+		{name: "wrong secret", payload: payload, header: header, secret: "whsec_other", now: sentAt, want: ErrNoValidSignature},
+		{name: "replayed later", payload: payload, header: header, secret: "whsec_test", now: sentAt.Add(DefaultTolerance + time.Second), want: ErrTimestampOutOfRange},
+		{name: "timestamp swapped", payload: payload, header: strings.Replace(header, "t=1767225600", "t=1767225601", 1), secret: "whsec_test", now: sentAt, want: ErrNoValidSignature},
+		{name: "missing header", payload: payload, header: "", secret: "whsec_test", now: sentAt, want: ErrMissingSignature},
+		{name: "bare hex", payload: payload, header: "deadbeef", secret: "whsec_test", now: sentAt, want: ErrInvalidHeader},
+		{name: "no v1", payload: payload, header: "t=1767225600,v0=abc", secret: "whsec_test", now: sentAt, want: ErrUnsupportedVersion},
+		{name: "no secret", payload: payload, header: header, secret: "", now: sentAt, want: ErrMissingSecret},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifyAt(tc.payload, tc.header, tc.secret, 0, tc.now)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestVerifyAcceptsAnyMatchingV1Signature(t *testing.T) {
+	payload := []byte(`{}`)
+	sentAt := time.Unix(1767225600, 0)
+	header := "t=1767225600,v1=" + ComputeSignature("whsec_old", sentAt.Unix(), payload) +
+		",v1=" + ComputeSignature("whsec_new", sentAt.Unix(), payload)
+
+	for _, secret := range []string{"whsec_old", "whsec_new"} {
+		if err := VerifyAt(payload, header, secret, 0, sentAt); err != nil {
+			t.Fatalf("verify with %s: %v", secret, err)
+		}
+	}
+}
+
+func TestSignWithKeysLabelsEachSignature(t *testing.T) {
+	payload := []byte(`{"run_id":"r1"}`)
+	sentAt := time.Unix(1767225600, 0)
+	header := SignWithKeys([]Key{{ID: "k2", Secret: "whsec_new"}, {ID: "k1", Secret: "whsec_old"}}, sentAt, payload)
+
+	_, signatures, err := ParseSignatures(header)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(signatures) != 2 || signatures[0].KeyID != "k2" || signatures[1].KeyID != "k1" {
+		t.Fatalf("expected signatures labeled k2, k1; got %+v", signatures)
+	}
+
+	// Receivers mid-rotation may hold either secret.
+	for _, secrets := range []map[string]string{
+		{"k1": "whsec_old"},
+		{"k2": "whsec_new"},
+		{"k1": "whsec_old", "k2": "whsec_new"},
+	} {
+		if err := VerifyWithKeysAt(payload, header, secrets, 0, sentAt); err != nil {
+			t.Fatalf("verify with %v: %v", secrets, err)
+		}
+	}
+	if err := VerifyAt(payload, header, "whsec_old", 0, sentAt); err != nil {
+		t.Fatalf("single-secret verify: %v", err)
+	}
+}
+
+func TestVerifyWithKeysRejectsMismatchedKeyID(t *testing.T) {
+	payload := []byte(`{}`)
+	sentAt := time.Unix(1767225600, 0)
+	header := SignWithKeys([]Key{{ID: "k1", Secret: "whsec_old"}}, sentAt, payload)
+
+	// The right secret filed under the wrong key id does not verify.
+	err := VerifyWithKeysAt(payload, header, map[string]string{"k2": "whsec_old"}, 0, sentAt)
+	if !errors.Is(err, ErrNoValidSignature) {
+		t.Fatalf("expected ErrNoValidSignature got %v", err)
+	}
+	if err := VerifyWithKeysAt(payload, header, nil, 0, sentAt); !errors.Is(err, ErrMissingSecret) {
+		t.Fatalf("expected ErrMissingSecret got %v", err)
+	}
+
+	unlabeled := Sign("whsec_old", sentAt, payload)
+	if err := VerifyWithKeysAt(payload, unlabeled, map[string]string{"k9": "whsec_old"}, 0, sentAt); err != nil {
+		t.Fatalf("expected unlabeled signature to verify against any key, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package workertest
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	execs "github.com/adiadia/agent-runtime/internal/worker/executors"
+	"github.com/google/uuid"
+)
+
+// Executor runs one step. It has the worker's step executor method set: it
+// reports the step's output and what it cost, or an error that fails the
+// attempt.
+type Executor interface {
+	Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, CostDetail, error)
+}
+
+// ExecutorFunc adapts a function to Executor.
+type ExecutorFunc func(ctx context.Context, runID uuid.UUID) (json.RawMessage, CostDetail, error)
+
+func (f ExecutorFunc) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, CostDetail, error) {
+	return f(ctx, runID)
+}
+
+// Permanent marks err as a failure retrying cannot fix; the step fails on
+// the first such error instead of using its remaining attempts.
+func Permanent(err error) error {
+	return execs.Permanent(err)
+}
+
+// IsPermanent reports whether err is, or wraps, an error made by Permanent,
+// as the worker decides whether to retry a failed attempt.
+func IsPermanent(err error) bool {
+	return execs.IsPermanent(err)
+}
+
+// ExecutionKey returns the key of the step execution ctx belongs to, as the
+// worker passes it to executors: "<step_id>:<attempt>".
+func ExecutionKey(ctx context.Context) (string, bool) {
+	return execs.ExecutionKey(ctx)
+}
+
+// Result is one scripted outcome of a step attempt.
+type Result struct {
+	Output json.RawMessage
+	Cost   CostDetail
+	Err    error
+}
+
+// Succeed returns a successful outcome with output, a JSON document.
+func Succeed(output string) Result {
+	return Result{Output: json.RawMessage(output)}
+}
+
+// Fail returns an outcome failing the attempt with err, which is retried.
+func Fail(err error) Result {
+	return Result{Err: err}
+}
+
+// FailPermanently returns an outcome failing the step with err without
+// retrying it.
+func FailPermanently(err error) Result {
+	return Result{Err: Permanent(err)}
+}
+
+// ScriptedExecutor returns its results in order, one per call, then repeats
+// the last one. It is safe for concurrent use.
+type ScriptedExecutor struct {
+	mu      sync.Mutex
+	results []Result
+	calls   []uuid.UUID
+	inputs  []json.RawMessage
+}
+
+// Script returns an executor answering its calls with results in order.
+// Without results every call succeeds with {}.
+func Script(results ...Result) *ScriptedExecutor {
+	if len(results) == 0 {
+		results = []Result{Succeed(`{}`)}
+	}
+	return &ScriptedExecutor{results: results}
+}
+
+func (e *ScriptedExecutor) Execute(ctx context.Context, runID uuid.UUID) (json.RawMessage, CostDetail, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	input, _ := execs.StepInput(ctx)
+	e.calls = append(e.calls, runID)
+	e.inputs = append(e.inputs, input)
+
+	res := e.results[min(len(e.calls), len(e.results))-1]
+	if err := ctx.Err(); err != nil {
+		return nil, CostDetail{}, err
+	}
+	return res.Output, res.Cost, res.Err
+}
+
+// Calls returns how many times the executor ran.
+func (e *ScriptedExecutor) Calls() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.calls)
+}
+
+// RunIDs returns the run of every call, in order.
+func (e *ScriptedExecutor) RunIDs() []uuid.UUID {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]uuid.UUID(nil), e.calls...)
+}
+
+// Inputs returns the step input override of every call, nil where the step
+// had none.
+func (e *ScriptedExecutor) Inputs() []json.RawMessage {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]json.RawMessage(nil), e.inputs...)
+}
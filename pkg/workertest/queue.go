@@ -0,0 +1,483 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package workertest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/repository"
+	"github.com/google/uuid"
+)
+
+// StepSpec describes a step of a run added to a Queue.
+type StepSpec struct {
+	// Name is domain.StepLLM or domain.StepTool.
+	Name domain.StepName
+	// Input is merged into the step's input, as a replay's input override
+	// is; executors read it with executors.StepInput.
+	Input json.RawMessage
+	// Timeout bounds each attempt; 0 uses the worker's default.
+	Timeout time.Duration
+}
+
+// QueuedRun is a snapshot of a run held by a Queue.
+type QueuedRun struct {
+	ID       uuid.UUID
+	APIKeyID uuid.UUID
+	Status   domain.RunStatus
+	Steps    []QueuedStep
+	Events   []Event
+}
+
+// EventTypes returns the types of the run's events in order, for comparing
+// against an expected sequence.
+func (r QueuedRun) EventTypes() []string {
+	types := make([]string, len(r.Events))
+	for i, ev := range r.Events {
+		types[i] = ev.Type
+	}
+	return types
+}
+
+// QueuedStep is a snapshot of one step of a run held by a Queue.
+type QueuedStep struct {
+	ID       uuid.UUID
+	Name     domain.StepName
+	Status   domain.StepStatus
+	Attempts int
+	// Output is the output of a SUCCEEDED step; Err is the error of the
+	// step's last failed attempt.
+	Output json.RawMessage
+	Err    error
+	// Cost is the latest attempt's, with the tokens and cost of all of them.
+	Cost CostDetail
+	// NextRunAt is when a step waiting to be retried becomes claimable.
+	NextRunAt time.Time
+	// ClaimedAt holds the clock time of every claim, for checking the
+	// spacing of retries.
+	ClaimedAt []time.Time
+}
+
+// Event is an entry of a run's event log.
+type Event struct {
+	Type    string
+	StepID  uuid.UUID
+	Payload any
+	At      time.Time
+}
+
+// Queue is an in-memory repository.StepQueue, so the worker can claim,
+// execute, and settle steps without Postgres. A run's steps are claimed in
+// order, each once the one before it succeeded; a failed attempt is retried
+// after its retry policy's delay on the queue's clock until its attempts run
+// out, which fails the step and its run. Leases expire on the same clock and
+// are then reclaimed.
+//
+// Approval gates, MAP steps, conditions, on_failure policies, per-step retry
+// overrides, retry jitter, priorities, and tenant and global limits are
+// repository.StepQueueRepository's and are not modelled.
+type Queue struct {
+	mu    sync.Mutex
+	clock clock.Clock
+	runs  []*queuedRun
+}
+
+var _ repository.StepQueue = (*Queue)(nil)
+
+type queuedRun struct {
+	QueuedRun
+	steps []*queuedStep
+}
+
+type queuedStep struct {
+	QueuedStep
+	input            json.RawMessage
+	timeout          time.Duration
+	executionAttempt int
+	claimToken       uuid.UUID
+	leaseExpiresAt   time.Time
+}
+
+// NewQueue returns an empty queue reading the time from c; nil uses the
+// wall clock.
+func NewQueue(c clock.Clock) *Queue {
+	return &Queue{clock: clock.OrReal(c)}
+}
+
+// Clock returns the clock the queue schedules retries and leases on.
+func (q *Queue) Clock() clock.Clock {
+	return q.clock
+}
+
+func (q *Queue) now() time.Time {
+	return q.clock.Now().UTC()
+}
+
+// AddRun queues a PENDING run of apiKeyID with steps and returns its ID. It
+// panics on a step the queue does not model.
+func (q *Queue) AddRun(apiKeyID uuid.UUID, steps ...StepSpec) uuid.UUID {
+	r := &queuedRun{QueuedRun: QueuedRun{
+		ID:       uuid.New(),
+		APIKeyID: apiKeyID,
+		Status:   domain.RunPending,
+	}}
+	for _, spec := range steps {
+		if spec.Name != domain.StepLLM && spec.Name != domain.StepTool {
+			panic(fmt.Sprintf("workertest: Queue does not run %s steps", spec.Name))
+		}
+		r.steps = append(r.steps, &queuedStep{
+			QueuedStep: QueuedStep{ID: uuid.New(), Name: spec.Name, Status: domain.StepPending},
+			input:      spec.Input,
+			timeout:    spec.Timeout,
+		})
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.runs = append(q.runs, r)
+	return r.ID
+}
+
+// Run returns a snapshot of the run with id.
+func (q *Queue) Run(id uuid.UUID) (QueuedRun, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r := q.run(id)
+	if r == nil {
+		return QueuedRun{}, false
+	}
+	out := r.QueuedRun
+	out.Events = slices.Clone(r.Events)
+	out.Steps = make([]QueuedStep, len(r.steps))
+	for i, st := range r.steps {
+		out.Steps[i] = st.QueuedStep
+		out.Steps[i].ClaimedAt = slices.Clone(st.ClaimedAt)
+	}
+	return out, true
+}
+
+// CancelRun cancels the run with id and its unfinished steps, as the API
+// does; a worker executing one of them interrupts it at its next
+// cancellation check.
+func (q *Queue) CancelRun(id uuid.UUID) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r := q.run(id)
+	if r == nil {
+		return domain.ErrRunNotFound
+	}
+	if err := domain.CheckRunTransition(r.Status, domain.RunCanceled); err != nil {
+		return err
+	}
+	now := q.now()
+	r.Status = domain.RunCanceled
+	for _, st := range r.steps {
+		if !st.Status.IsTerminal() {
+			st.Status = domain.StepCanceled
+		}
+	}
+	r.addEvent(now, domain.EventRunCanceled, uuid.Nil, nil)
+	return nil
+}
+
+func (q *Queue) run(id uuid.UUID) *queuedRun {
+	for _, r := range q.runs {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// ClaimStep leases the first claimable step of the tenant's runs, in the
+// order they were added: a PENDING step whose retry delay has passed, or a
+// RUNNING one whose lease expired.
+func (q *Queue) ClaimStep(_ context.Context, req domain.ClaimRequest) (domain.ClaimedStep, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.claim(req, req.Blocked)
+}
+
+// ClaimSteps claims up to n steps, at most one of each probing step type.
+func (q *Queue) ClaimSteps(_ context.Context, req domain.ClaimRequest, n int) ([]domain.ClaimedStep, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	blocked := slices.Clone(req.Blocked)
+	var steps []domain.ClaimedStep
+	for len(steps) < n {
+		s, err := q.claim(req, blocked)
+		if err != nil {
+			break
+		}
+		steps = append(steps, s)
+		if slices.Contains(req.Probing, s.Name) {
+			blocked = append(blocked, s.Name)
+		}
+	}
+	if len(steps) == 0 {
+		return nil, domain.ErrNoStepToClaim
+	}
+	return steps, nil
+}
+
+func (q *Queue) claim(req domain.ClaimRequest, blocked []domain.StepName) (domain.ClaimedStep, error) {
+	now := q.now()
+	for _, r := range q.runs {
+		if r.APIKeyID != req.APIKeyID || r.Status.IsTerminal() {
+			continue
+		}
+		st := r.next()
+		if st == nil || slices.Contains(blocked, st.Name) {
+			continue
+		}
+		claimable := (st.Status == domain.StepPending && !now.Before(st.NextRunAt)) ||
+			(st.Status == domain.StepRunning && !now.Before(st.leaseExpiresAt))
+		if !claimable {
+			continue
+		}
+
+		prev := st.Status
+		if prev == domain.StepPending {
+			st.executionAttempt++
+		}
+		st.Status = domain.StepRunning
+		st.Attempts++
+		st.NextRunAt = time.Time{}
+		st.claimToken = uuid.New()
+		st.leaseExpiresAt = now.Add(req.Lease)
+		st.ClaimedAt = append(st.ClaimedAt, now)
+		if r.Status == domain.RunPending {
+			r.Status = domain.RunRunning
+		}
+		r.addEvent(now, domain.EventStepClaimed, st.ID, map[string]any{
+			"status":        domain.StepRunning,
+			"step":          st.Name,
+			"reclaimed":     prev == domain.StepRunning,
+			"previous":      prev,
+			"worker_id":     req.WorkerID,
+			"execution_key": domain.ExecutionKey(st.ID, st.executionAttempt),
+		})
+
+		timeout := st.timeout
+		if timeout <= 0 {
+			timeout = req.DefaultTimeout
+		}
+		return domain.ClaimedStep{
+			StepID:           st.ID,
+			RunID:            r.ID,
+			APIKeyID:         r.APIKeyID,
+			Name:             st.Name,
+			Status:           prev,
+			Timeout:          timeout,
+			InputOverride:    st.input,
+			Attempt:          st.Attempts,
+			ExecutionAttempt: st.executionAttempt,
+			ClaimToken:       st.claimToken,
+		}, nil
+	}
+	return domain.ClaimedStep{}, domain.ErrNoStepToClaim
+}
+
+// next returns the run's first step that has not succeeded, or nil.
+func (r *queuedRun) next() *queuedStep {
+	for _, st := range r.steps {
+		if st.Status != domain.StepSuccess {
+			return st
+		}
+	}
+	return nil
+}
+
+func (r *queuedRun) addEvent(at time.Time, typ string, stepID uuid.UUID, payload any) {
+	r.Events = append(r.Events, Event{Type: typ, StepID: stepID, Payload: payload, At: at})
+}
+
+// owned returns the step and run step belongs to while step's claim still
+// holds, and domain.ErrStaleClaim once a reclaim superseded it.
+func (q *Queue) owned(step domain.ClaimedStep) (*queuedRun, *queuedStep, error) {
+	r := q.run(step.RunID)
+	if r == nil {
+		return nil, nil, domain.ErrRunNotFound
+	}
+	for _, st := range r.steps {
+		if st.ID == step.StepID {
+			if st.claimToken != step.ClaimToken {
+				return nil, nil, domain.ErrStaleClaim
+			}
+			return r, st, nil
+		}
+	}
+	return nil, nil, domain.ErrStepNotFound
+}
+
+// CompleteStep records step's output and succeeds its run once it was the
+// run's last step.
+func (q *Queue) CompleteStep(_ context.Context, step domain.ClaimedStep, result domain.StepCompletion) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r, st, err := q.owned(step)
+	if err != nil {
+		return err
+	}
+	if err := domain.CheckStepTransition(st.Status, domain.StepSuccess); err != nil {
+		return err
+	}
+
+	now := q.now()
+	st.Status = domain.StepSuccess
+	st.Output = result.Output
+	st.Cost = addCost(st.Cost, result.Cost)
+	r.addEvent(now, domain.EventStepSucceeded, st.ID, map[string]any{
+		"status":  domain.StepSuccess,
+		"attempt": st.Attempts,
+	})
+	if r.next() == nil {
+		if err := domain.CheckRunTransition(r.Status, domain.RunSuccess); err != nil {
+			return err
+		}
+		r.Status = domain.RunSuccess
+	}
+	return nil
+}
+
+// FailStep schedules step's next attempt after failure.Retry's delay, or
+// fails the step and its run once it is out of attempts or the failure is
+// permanent.
+func (q *Queue) FailStep(_ context.Context, step domain.ClaimedStep, failure domain.StepFailure) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r, st, err := q.owned(step)
+	if err != nil {
+		return err
+	}
+
+	now := q.now()
+	st.Err = failure.Err
+	st.Cost = addCost(st.Cost, failure.Cost)
+	policy := failure.Retry
+	if st.Attempts < policy.MaxAttempts && !failure.Permanent {
+		if err := domain.CheckStepTransition(st.Status, domain.StepPending); err != nil {
+			return err
+		}
+		st.Status = domain.StepPending
+		st.NextRunAt = now.Add(policy.Delay(st.Attempts))
+		r.addEvent(now, domain.EventStepFailedRetry, st.ID, map[string]any{
+			"status":       domain.StepPending,
+			"error":        failure.Err.Error(),
+			"attempt":      st.Attempts,
+			"max_attempts": policy.MaxAttempts,
+			"next_run_at":  st.NextRunAt,
+		})
+		return nil
+	}
+
+	if err := domain.CheckStepTransition(st.Status, domain.StepFailed); err != nil {
+		return err
+	}
+	if err := domain.CheckRunTransition(r.Status, domain.RunFailed); err != nil {
+		return err
+	}
+	st.Status = domain.StepFailed
+	r.Status = domain.RunFailed
+	r.addEvent(now, domain.EventStepFailed, st.ID, map[string]any{
+		"status":       domain.StepFailed,
+		"error":        failure.Err.Error(),
+		"attempt":      st.Attempts,
+		"max_attempts": policy.MaxAttempts,
+		"permanent":    failure.Permanent,
+	})
+	return nil
+}
+
+// CancelStep settles a step whose executor was interrupted because its run
+// was canceled.
+func (q *Queue) CancelStep(_ context.Context, step domain.ClaimedStep) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r, st, err := q.owned(step)
+	if err != nil {
+		return err
+	}
+	if st.Status != domain.StepCanceled {
+		if err := domain.CheckStepTransition(st.Status, domain.StepCanceled); err != nil {
+			return err
+		}
+		st.Status = domain.StepCanceled
+	}
+	r.addEvent(q.now(), domain.EventStepCanceled, st.ID, map[string]any{
+		"status":      domain.StepCanceled,
+		"step":        st.Name,
+		"reason":      "run_canceled",
+		"interrupted": true,
+	})
+	return nil
+}
+
+// RenewLease extends the lease on step to lease from now. It reports false
+// once the step is no longer RUNNING under this claim.
+func (q *Queue) RenewLease(_ context.Context, step domain.ClaimedStep, lease time.Duration) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, st, err := q.owned(step)
+	if errors.Is(err, domain.ErrStaleClaim) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if st.Status != domain.StepRunning {
+		return false, nil
+	}
+	st.leaseExpiresAt = q.now().Add(lease)
+	return true, nil
+}
+
+// StepCanceled reports whether step's run was canceled or step itself is
+// CANCELED.
+func (q *Queue) StepCanceled(_ context.Context, step domain.ClaimedStep) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r := q.run(step.RunID)
+	if r == nil {
+		return false, domain.ErrRunNotFound
+	}
+	if r.Status == domain.RunCanceled {
+		return true, nil
+	}
+	for _, st := range r.steps {
+		if st.ID == step.StepID {
+			return st.Status == domain.StepCanceled, nil
+		}
+	}
+	return false, domain.ErrStepNotFound
+}
+
+// AppendStepEvent records an event of step, such as STEP_PROGRESS.
+func (q *Queue) AppendStepEvent(_ context.Context, step domain.ClaimedStep, eventType string, payload any) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r := q.run(step.RunID)
+	if r == nil {
+		return domain.ErrRunNotFound
+	}
+	r.addEvent(q.now(), eventType, step.StepID, payload)
+	return nil
+}
+
+// addCost adds b's tokens and cost to a, which otherwise describes the
+// latest attempt.
+func addCost(a, b CostDetail) CostDetail {
+	b.PromptTokens += a.PromptTokens
+	b.CompletionTokens += a.CompletionTokens
+	b.CostUSD += a.CostUSD
+	return b
+}
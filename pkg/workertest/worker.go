@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package workertest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+
+	"github.com/adiadia/agent-runtime/internal/worker"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// NewWorker returns the worker, built by worker.New from deps, claiming and
+// settling steps through queue on queue's clock, so retries wait out their
+// backoff only as that clock is advanced. deps.Executors stands in for its
+// providers; a nil deps.Logger discards its logs.
+//
+// No database backs the worker: lines executors log are dropped, and
+// webhook deliveries cannot run.
+func NewWorker(queue *Queue, deps worker.Deps) *worker.Worker {
+	deps.Pool = noDatabase{}
+	deps.Queue = queue
+	deps.Clock = queue.Clock()
+	if deps.Logger == nil {
+		deps.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return worker.New(deps)
+}
+
+// errNoDatabase answers whatever the worker asks of its database outside its
+// step queue.
+var errNoDatabase = errors.New("workertest: the worker has no database")
+
+// noDatabase is the worker.DB of a worker from NewWorker.
+type noDatabase struct{}
+
+var _ worker.DB = noDatabase{}
+
+func (noDatabase) Begin(context.Context) (pgx.Tx, error) {
+	return nil, errNoDatabase
+}
+
+func (noDatabase) Exec(context.Context, string, ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, errNoDatabase
+}
+
+func (noDatabase) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	return nil, errNoDatabase
+}
+
+func (noDatabase) QueryRow(context.Context, string, ...any) pgx.Row {
+	return noRow{}
+}
+
+// noRow is the row noDatabase returns.
+type noRow struct{}
+
+func (noRow) Scan(...any) error {
+	return errNoDatabase
+}
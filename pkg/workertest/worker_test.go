@@ -0,0 +1,221 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package workertest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/domain"
+	"github.com/adiadia/agent-runtime/internal/worker"
+	execs "github.com/adiadia/agent-runtime/internal/worker/executors"
+	"github.com/google/uuid"
+)
+
+var start = time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+func TestWorkerRetriesThroughBackoff(t *testing.T) {
+	clk := NewClock(start)
+	queue := NewQueue(clk)
+	apiKeyID := uuid.New()
+	rateLimited := errors.New("rate limited")
+	llm := Script(Fail(rateLimited), Fail(rateLimited), Succeed(`{"text":"ok"}`))
+	w := NewWorker(queue, worker.Deps{
+		APIKeyID:       apiKeyID,
+		MaxAttempts:    3,
+		RetryBaseDelay: 10 * time.Second,
+		Executors:      map[domain.StepName]worker.StepExecutor{domain.StepLLM: llm},
+	})
+	runID := queue.AddRun(apiKeyID, StepSpec{Name: domain.StepLLM})
+
+	process := func(wantCalls int) {
+		t.Helper()
+		if err := w.ProcessOnce(context.Background()); err != nil {
+			t.Fatalf("process: %v", err)
+		}
+		if llm.Calls() != wantCalls {
+			t.Fatalf("expected %d executions at %s, got %d", wantCalls, clk.Now().Sub(start), llm.Calls())
+		}
+	}
+
+	// Exponential backoff from 10s: 20s after the first failure, 40s after
+	// the second.
+	process(1)
+	clk.Advance(19 * time.Second)
+	process(1)
+	clk.Advance(time.Second)
+	process(2)
+	clk.Advance(39 * time.Second)
+	process(2)
+	clk.Advance(time.Second)
+	process(3)
+
+	run, ok := queue.Run(runID)
+	if !ok {
+		t.Fatal("run not found")
+	}
+	if run.Status != domain.RunSuccess {
+		t.Fatalf("expected run SUCCEEDED, got %s", run.Status)
+	}
+	step := run.Steps[0]
+	if step.Status != domain.StepSuccess || step.Attempts != 3 || string(step.Output) != `{"text":"ok"}` {
+		t.Fatalf("expected the step to succeed on its third attempt, got %+v", step)
+	}
+	wantClaims := []time.Time{start, start.Add(20 * time.Second), start.Add(60 * time.Second)}
+	if !slices.Equal(step.ClaimedAt, wantClaims) {
+		t.Fatalf("expected claims at %v, got %v", wantClaims, step.ClaimedAt)
+	}
+	wantEvents := []string{
+		domain.EventStepClaimed, domain.EventStepFailedRetry,
+		domain.EventStepClaimed, domain.EventStepFailedRetry,
+		domain.EventStepClaimed, domain.EventStepSucceeded,
+	}
+	if !slices.Equal(run.EventTypes(), wantEvents) {
+		t.Fatalf("expected events %v, got %v", wantEvents, run.EventTypes())
+	}
+}
+
+func TestWorkerFailsRunOnPermanentError(t *testing.T) {
+	queue := NewQueue(NewClock(start))
+	apiKeyID := uuid.New()
+	tool := Script(FailPermanently(errors.New("bad input")))
+	w := NewWorker(queue, worker.Deps{
+		APIKeyID:    apiKeyID,
+		MaxAttempts: 3,
+		Executors:   map[domain.StepName]worker.StepExecutor{domain.StepTool: tool},
+	})
+	runID := queue.AddRun(apiKeyID, StepSpec{Name: domain.StepTool}, StepSpec{Name: domain.StepTool})
+
+	for range 2 {
+		if err := w.ProcessOnce(context.Background()); err != nil {
+			t.Fatalf("process: %v", err)
+		}
+	}
+
+	run, _ := queue.Run(runID)
+	if run.Status != domain.RunFailed || run.Steps[0].Status != domain.StepFailed || run.Steps[0].Attempts != 1 {
+		t.Fatalf("expected the run failed after one attempt, got %s %+v", run.Status, run.Steps[0])
+	}
+	if run.Steps[1].Status != domain.StepPending || tool.Calls() != 1 {
+		t.Fatalf("expected the second step never to run, got %s after %d calls", run.Steps[1].Status, tool.Calls())
+	}
+}
+
+// TestWorkerHandsExecutorsTheStepContext keeps StepContext in step with the
+// context the worker hands executors.
+func TestWorkerHandsExecutorsTheStepContext(t *testing.T) {
+	type seen struct {
+		key   string
+		input json.RawMessage
+	}
+	read := func(ctx context.Context) seen {
+		key, _ := execs.ExecutionKey(ctx)
+		input, _ := execs.StepInput(ctx)
+		return seen{key: key, input: input}
+	}
+
+	queue := NewQueue(NewClock(start))
+	apiKeyID := uuid.New()
+	var got seen
+	w := NewWorker(queue, worker.Deps{
+		APIKeyID: apiKeyID,
+		Executors: map[domain.StepName]worker.StepExecutor{
+			domain.StepLLM: ExecutorFunc(func(ctx context.Context, runID uuid.UUID) (json.RawMessage, CostDetail, error) {
+				got = read(ctx)
+				return json.RawMessage(`{}`), CostDetail{}, nil
+			}),
+		},
+	})
+	input := json.RawMessage(`{"prompt":"hi"}`)
+	runID := queue.AddRun(apiKeyID, StepSpec{Name: domain.StepLLM, Input: input})
+
+	if err := w.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	run, _ := queue.Run(runID)
+	want := read(StepContext(context.Background(), Step{ID: run.Steps[0].ID, Attempt: 1, Input: input}))
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected executor context %+v got %+v", want, got)
+	}
+}
+
+func TestWorkerInterruptsCanceledRun(t *testing.T) {
+	queue := NewQueue(NewClock(start))
+	apiKeyID := uuid.New()
+	w := NewWorker(queue, worker.Deps{
+		APIKeyID:            apiKeyID,
+		CancelCheckInterval: time.Millisecond,
+		Executors: map[domain.StepName]worker.StepExecutor{
+			domain.StepLLM: ExecutorFunc(func(ctx context.Context, runID uuid.UUID) (json.RawMessage, CostDetail, error) {
+				if err := queue.CancelRun(runID); err != nil {
+					return nil, CostDetail{}, err
+				}
+				<-ctx.Done()
+				return nil, CostDetail{}, ctx.Err()
+			}),
+		},
+	})
+	runID := queue.AddRun(apiKeyID, StepSpec{Name: domain.StepLLM})
+
+	if err := w.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	run, _ := queue.Run(runID)
+	if run.Status != domain.RunCanceled || run.Steps[0].Status != domain.StepCanceled {
+		t.Fatalf("expected the run and step canceled, got %s and %s", run.Status, run.Steps[0].Status)
+	}
+	if types := run.EventTypes(); types[len(types)-1] != domain.EventStepCanceled {
+		t.Fatalf("expected the interruption recorded last, got %v", types)
+	}
+}
+
+func TestQueueReclaimsExpiredLease(t *testing.T) {
+	ctx := context.Background()
+	clk := NewClock(start)
+	queue := NewQueue(clk)
+	req := domain.ClaimRequest{APIKeyID: uuid.New(), WorkerID: uuid.New(), Lease: time.Minute}
+	runID := queue.AddRun(req.APIKeyID, StepSpec{Name: domain.StepLLM})
+
+	first, err := queue.ClaimStep(ctx, req)
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if _, err := queue.ClaimStep(ctx, req); !errors.Is(err, domain.ErrNoStepToClaim) {
+		t.Fatalf("expected a leased step not to be claimed again, got %v", err)
+	}
+
+	clk.Advance(30 * time.Second)
+	if held, err := queue.RenewLease(ctx, first, time.Minute); err != nil || !held {
+		t.Fatalf("expected lease renewed, got %v, %v", held, err)
+	}
+	clk.Advance(59 * time.Second)
+	if _, err := queue.ClaimStep(ctx, req); !errors.Is(err, domain.ErrNoStepToClaim) {
+		t.Fatalf("expected the renewed lease to hold, got %v", err)
+	}
+	clk.Advance(time.Second)
+	second, err := queue.ClaimStep(ctx, req)
+	if err != nil {
+		t.Fatalf("reclaim: %v", err)
+	}
+	if second.Status != domain.StepRunning || second.Attempt != 2 || second.ExecutionAttempt != first.ExecutionAttempt {
+		t.Fatalf("expected a reclaim of the same execution, got %+v", second)
+	}
+
+	if held, err := queue.RenewLease(ctx, first, time.Minute); err != nil || held {
+		t.Fatalf("expected the first claim's lease lost, got %v, %v", held, err)
+	}
+	if err := queue.CompleteStep(ctx, first, domain.StepCompletion{}); !errors.Is(err, domain.ErrStaleClaim) {
+		t.Fatalf("expected the first claim stale, got %v", err)
+	}
+	if err := queue.CompleteStep(ctx, second, domain.StepCompletion{Output: json.RawMessage(`{}`)}); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if run, _ := queue.Run(runID); run.Status != domain.RunSuccess {
+		t.Fatalf("expected run SUCCEEDED, got %s", run.Status)
+	}
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package workertest runs the worker without Postgres, providers, or real
+// sleeps: Queue is an in-memory step queue on a manually driven clock,
+// NewWorker builds the worker on it, and scripted executors stand in for
+// providers:
+//
+//	clk := workertest.NewClock(time.Now())
+//	queue := workertest.NewQueue(clk)
+//	w := workertest.NewWorker(queue, worker.Deps{
+//		APIKeyID: apiKeyID,
+//		Executors: map[domain.StepName]worker.StepExecutor{
+//			domain.StepLLM: workertest.Script(
+//				workertest.Fail(errors.New("rate limited")),
+//				workertest.Succeed(`{"text":"ok"}`),
+//			),
+//		},
+//	})
+//	runID := queue.AddRun(apiKeyID, workertest.StepSpec{Name: domain.StepLLM})
+//	_ = w.ProcessOnce(ctx) // fails; the retry waits out its backoff
+//	clk.Advance(time.Minute)
+//	_ = w.ProcessOnce(ctx) // succeeds the step and the run
+//
+// StepContext gives an executor under test the context the worker hands it.
+// Queue models claim order, leases, retries and their backoff, and
+// cancellation; approval gates, MAP steps, conditions, and failure policies
+// live in the repository's SQL and are covered by its integration tests
+// against Postgres.
+package workertest
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/adiadia/agent-runtime/internal/clock"
+	"github.com/adiadia/agent-runtime/internal/domain"
+	execs "github.com/adiadia/agent-runtime/internal/worker/executors"
+	"github.com/google/uuid"
+)
+
+type (
+	CostDetail = domain.CostDetail
+	// Clock is a manually driven clock; Advance and Set move it.
+	Clock = clock.Fake
+)
+
+// NewClock returns a clock reading now.
+func NewClock(now time.Time) *Clock {
+	return clock.NewFake(now)
+}
+
+// Step describes the step execution an executor is called for.
+type Step struct {
+	ID uuid.UUID
+	// Attempt is the execution attempt; with ID it makes the execution key.
+	Attempt int
+	// Input is the step input override, as a replay sets it; executors read
+	// it with executors.StepInput.
+	Input json.RawMessage
+	// Item is the MAP item of a MAP child step; executors read it with
+	// executors.MapItem.
+	Item json.RawMessage
+}
+
+// StepContext returns ctx carrying what the worker passes an executor for
+// step: its execution key and, when set, its input override and MAP item.
+func StepContext(ctx context.Context, step Step) context.Context {
+	ctx = execs.WithExecutionKey(ctx, domain.ExecutionKey(step.ID, step.Attempt))
+	if step.Item != nil {
+		ctx = execs.WithMapItem(ctx, step.Item)
+	}
+	if step.Input != nil {
+		ctx = execs.WithStepInput(ctx, step.Input)
+	}
+	return ctx
+}
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package workertest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	execs "github.com/adiadia/agent-runtime/internal/worker/executors"
+	"github.com/google/uuid"
+)
+
+func TestScriptAnswersInOrderThenRepeats(t *testing.T) {
+	rateLimited := errors.New("rate limited")
+	llm := Script(Fail(rateLimited), FailPermanently(errors.New("bad prompt")), Succeed(`{"text":"ok"}`))
+	runID := uuid.New()
+
+	var errs []error
+	for range 4 {
+		output, _, err := llm.Execute(context.Background(), runID)
+		if err == nil && string(output) != `{"text":"ok"}` {
+			t.Fatalf("unexpected output %s", output)
+		}
+		errs = append(errs, err)
+	}
+	if !errors.Is(errs[0], rateLimited) || IsPermanent(errs[0]) {
+		t.Fatalf("expected a retryable first failure, got %v", errs[0])
+	}
+	if !IsPermanent(errs[1]) {
+		t.Fatalf("expected a permanent second failure, got %v", errs[1])
+	}
+	if errs[2] != nil || errs[3] != nil {
+		t.Fatalf("expected the last result to repeat, got %v", errs[2:])
+	}
+	if llm.Calls() != 4 || !slices.Equal(llm.RunIDs(), []uuid.UUID{runID, runID, runID, runID}) {
+		t.Fatalf("expected 4 calls for %s, got %d: %v", runID, llm.Calls(), llm.RunIDs())
+	}
+
+	if output, _, err := Script().Execute(context.Background(), runID); err != nil || string(output) != `{}` {
+		t.Fatalf("expected an empty script to succeed with {}, got %s (%v)", output, err)
+	}
+}
+
+func TestScriptHonorsCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := Script().Execute(ctx, uuid.New()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestStepContextCarriesWhatTheWorkerPasses(t *testing.T) {
+	stepID := uuid.New()
+	ctx := StepContext(context.Background(), Step{
+		ID:      stepID,
+		Attempt: 2,
+		Input:   json.RawMessage(`{"prompt":"hi"}`),
+		Item:    json.RawMessage(`"a"`),
+	})
+
+	if key, ok := ExecutionKey(ctx); !ok || key != stepID.String()+":2" {
+		t.Fatalf("expected execution key %s:2, got %q", stepID, key)
+	}
+	if item, ok := execs.MapItem(ctx); !ok || string(item) != `"a"` {
+		t.Fatalf("expected map item, got %s", item)
+	}
+
+	llm := Script()
+	if _, _, err := llm.Execute(ctx, uuid.New()); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if _, _, err := llm.Execute(StepContext(context.Background(), Step{ID: stepID, Attempt: 3}), uuid.New()); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	inputs := llm.Inputs()
+	if string(inputs[0]) != `{"prompt":"hi"}` || inputs[1] != nil {
+		t.Fatalf("unexpected step inputs %q", inputs)
+	}
+}
+
+func TestClockMovesOnlyWhenAdvanced(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewClock(start)
+	if !c.Now().Equal(start) {
+		t.Fatalf("expected %s, got %s", start, c.Now())
+	}
+	c.Advance(4 * time.Second)
+	if want := start.Add(4 * time.Second); !c.Now().Equal(want) {
+		t.Fatalf("expected %s, got %s", want, c.Now())
+	}
+}